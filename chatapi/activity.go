@@ -0,0 +1,208 @@
+package chatapi
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// defaultHistogramBuckets is how far back the histogram goes when the request
+// doesn't give a start: 90 days, or 90 weeks
+const defaultHistogramBuckets = 90
+
+// histogramBucketLength is the length of one bucket of each granularity
+var histogramBucketLength = map[string]time.Duration{
+	store.HistogramDay:  24 * time.Hour,
+	store.HistogramWeek: 7 * 24 * time.Hour,
+}
+
+// ActivityHistogramResponse is a room's activity over a range of days or weeks
+type ActivityHistogramResponse struct {
+	Granularity string                  `json:"granularity"`
+	From        time.Time               `json:"from"` // Start of the first bucket
+	To          time.Time               `json:"to"`   // End of the range, exclusive
+	Buckets     []*store.ActivityBucket `json:"buckets"`
+}
+
+// parseHistogramTime reads a from, to or date parameter: a date (2024-03-15)
+// or an RFC 3339 time, in UTC
+// With endOfDay, a date means the end of that day, so a to date is included
+func parseHistogramTime(raw string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, raw); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
+
+// histogramBucketStart returns the start of the bucket t falls in: midnight
+// UTC, and for weeks the Monday before, like date_trunc('week')
+func histogramBucketStart(t time.Time, granularity string) time.Time {
+	t = t.UTC().Truncate(24 * time.Hour)
+	if granularity == store.HistogramWeek {
+		// Weekday counts from Sunday; ISO weeks start on Monday
+		t = t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+	}
+	return t
+}
+
+// activityHistogramHandler returns how many messages and senders a room had
+// per day or week, for a jump-to-date scrollbar
+// Buckets without messages are left out to keep the payload small; clients
+// fill the gaps. All times are UTC, and weeks start on Monday
+// GET /v1/rooms/{roomID}/activity-histogram?granularity=day|week&from=2024-01-01&to=2024-03-31
+// from and to take a date or an RFC 3339 time; a to date includes that day.
+// to defaults to today, from to 90 buckets before it
+// Requires authentication and room membership
+// Response: {"granularity": "day", "from": "2024-01-01T00:00:00Z", "to": "2024-04-01T00:00:00Z",
+// "buckets": [{"period_start": "2024-01-02T00:00:00Z", "message_count": 41, "active_user_count": 6}, ...]}
+// A range of more than 366 buckets: 400
+func (app *application) activityHistogramHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	query := r.URL.Query()
+	granularity := query.Get("granularity")
+	if granularity == "" {
+		granularity = store.HistogramDay
+	}
+	bucketLength, ok := histogramBucketLength[granularity]
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid_histogram_granularity")
+		return
+	}
+
+	to := histogramBucketStart(app.now(), store.HistogramDay).AddDate(0, 0, 1)
+	if raw := query.Get("to"); raw != "" {
+		if to, err = parseHistogramTime(raw, true); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_date_parameter", "to")
+			return
+		}
+	}
+	from := to.Add(-defaultHistogramBuckets * bucketLength)
+	if raw := query.Get("from"); raw != "" {
+		if from, err = parseHistogramTime(raw, false); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_date_parameter", "from")
+			return
+		}
+	}
+	// The first bucket starts where date_trunc would put it
+	from = histogramBucketStart(from, granularity)
+
+	if !to.After(from) {
+		writeError(w, r, http.StatusBadRequest, "invalid_histogram_range")
+		return
+	}
+	// Rounded up: a partial bucket at the end still counts
+	if buckets := (to.Sub(from) + bucketLength - 1) / bucketLength; buckets > store.MaxHistogramBuckets {
+		writeError(w, r, http.StatusBadRequest, "histogram_range_too_large", store.MaxHistogramBuckets)
+		return
+	}
+
+	isMember, err := app.store.RoomMembers.IsUserInRoom(r.Context(), roomID, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "membership_check_failed")
+		return
+	}
+	if !isMember {
+		writeError(w, r, http.StatusForbidden, "membership_required_messages")
+		return
+	}
+
+	buckets, err := app.store.Messages.Histogram(r.Context(), roomID, granularity, from, to)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "messages_lookup_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ActivityHistogramResponse{
+		Granularity: granularity,
+		From:        from,
+		To:          to,
+		Buckets:     buckets,
+	})
+}
+
+// getMessagesAtHandler opens a room at a date: the first message posted on or
+// after it, with the messages around it as for the context endpoint
+// GET /v1/rooms/{roomID}/messages/at?date=2024-03-15&before=25&after=25
+// date takes a date (midnight UTC) or an RFC 3339 time
+// Requires authentication and room membership
+// Response: {"anchor_id": 123, "messages": [...], "has_more_before": true, "has_more_after": true}
+// Nothing posted since the date: 404
+func (app *application) getMessagesAtHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	at, err := parseHistogramTime(r.URL.Query().Get("date"), false)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_date_parameter", "date")
+		return
+	}
+
+	before, after, ok := parseContextCounts(w, r)
+	if !ok {
+		return
+	}
+
+	isMember, err := app.store.RoomMembers.IsUserInRoom(r.Context(), roomID, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "membership_check_failed")
+		return
+	}
+	if !isMember {
+		writeError(w, r, http.StatusForbidden, "membership_required_messages")
+		return
+	}
+
+	anchorID, err := app.store.Messages.FirstMessageAt(r.Context(), roomID, at)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "no_messages_since_date")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "messages_lookup_failed")
+		return
+	}
+
+	window, err := app.store.Messages.GetMessagesAround(r.Context(), roomID, anchorID, before, after)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// Deleted between the two queries
+			writeError(w, r, http.StatusNotFound, "no_messages_since_date")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "messages_lookup_failed")
+		return
+	}
+
+	app.hub.RenderSystemMessages(r.Context(), window.Messages)
+	writeJSON(w, http.StatusOK, window)
+}
@@ -0,0 +1,168 @@
+package chatapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// newActivityStore has ada and grace in room 1, with linus outside it.
+// Room 1 has a message from ada just before midnight on February 29th 2024,
+// one from grace at midnight and one from ada at nine; the fake clock's
+// today is March 1st
+func newActivityStore(t *testing.T) *testStore {
+	t.Helper()
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	for i, m := range []struct {
+		userID int64
+		at     time.Time
+	}{
+		{1, time.Date(2024, 2, 29, 23, 59, 0, 0, time.UTC)},
+		{2, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{1, time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)},
+	} {
+		ts.messages.messages = append(ts.messages.messages, &store.Message{ID: int64(i + 1), RoomID: 1, UserID: m.userID, Content: "hi", CreatedAt: m.at})
+	}
+	return ts
+}
+
+// TestHistogramBucketStart truncates to midnight UTC, and for weeks back to
+// Monday, whatever zone the time is given in
+func TestHistogramBucketStart(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*3600)
+	for _, tc := range []struct {
+		at          time.Time
+		granularity string
+		want        time.Time
+	}{
+		{time.Date(2024, 3, 1, 23, 59, 0, 0, time.UTC), store.HistogramDay, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 3, 1, 8, 0, 0, 0, tokyo), store.HistogramDay, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC), store.HistogramWeek, time.Date(2024, 2, 26, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), store.HistogramWeek, time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)},
+	} {
+		if got := histogramBucketStart(tc.at, tc.granularity); !got.Equal(tc.want) || got.Location() != time.UTC {
+			t.Errorf("%s by %s got %s, want %s", tc.at, tc.granularity, got, tc.want)
+		}
+	}
+}
+
+// TestActivityHistogram reads room 1's histogram by day and by week, with
+// and without a range, and refuses bad parameters, ranges over 366 buckets
+// and non-members
+func TestActivityHistogram(t *testing.T) {
+	server := newTestServer(t, newActivityStore(t), withClock(newFakeClock()))
+	histogramURL := func(query string) string {
+		return fmt.Sprintf("%s/v1/rooms/1/activity-histogram?%s", server.URL, query)
+	}
+	day := func(month time.Month, d int) time.Time { return time.Date(2024, month, d, 0, 0, 0, 0, time.UTC) }
+
+	type bucket struct {
+		start          time.Time
+		count, senders int
+	}
+	for _, tc := range []struct {
+		query    string
+		from, to time.Time
+		want     []bucket
+	}{
+		// 90 days up to the end of today
+		{"", day(3, 2).AddDate(0, 0, -90), day(3, 2), []bucket{{day(2, 29), 1, 1}, {day(3, 1), 2, 2}}},
+		{"from=2024-03-01&to=2024-03-01", day(3, 1), day(3, 2), []bucket{{day(3, 1), 2, 2}}},
+		// Times in other zones count in UTC: 00:30 on the 1st in Paris is still the 29th
+		{"from=" + url.QueryEscape("2024-03-01T00:30:00+01:00") + "&to=2024-03-01", day(2, 29), day(3, 2), []bucket{{day(2, 29), 1, 1}, {day(3, 1), 2, 2}}},
+		// The first week starts on the Monday before from
+		{"granularity=week&from=2024-02-28&to=2024-03-10", day(2, 26), day(3, 11), []bucket{{day(2, 26), 3, 2}}},
+	} {
+		var resp ActivityHistogramResponse
+		if status := doJSON(t, http.MethodGet, histogramURL(tc.query), 2, nil, &resp); status != http.StatusOK {
+			t.Errorf("%q got %d, want 200", tc.query, status)
+			continue
+		}
+		if !resp.From.Equal(tc.from) || !resp.To.Equal(tc.to) {
+			t.Errorf("%q covered %s to %s, want %s to %s", tc.query, resp.From, resp.To, tc.from, tc.to)
+		}
+		if len(resp.Buckets) != len(tc.want) {
+			t.Errorf("%q got %d buckets, want %d", tc.query, len(resp.Buckets), len(tc.want))
+			continue
+		}
+		for i, want := range tc.want {
+			if got := resp.Buckets[i]; !got.PeriodStart.Equal(want.start) || got.MessageCount != want.count || got.ActiveUserCount != want.senders {
+				t.Errorf("%q bucket %d got %+v, want %+v", tc.query, i, got, want)
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		query  string
+		userID int64
+		status int
+		code   string
+	}{
+		{"granularity=month", 1, http.StatusBadRequest, "invalid_histogram_granularity"},
+		{"from=yesterday", 1, http.StatusBadRequest, "invalid_date_parameter"},
+		{"from=2024-03-02&to=2024-03-01", 1, http.StatusBadRequest, "invalid_histogram_range"},
+		// 2024 is a leap year: March 2nd 2023 to March 1st 2024 is 366 days
+		{"from=2023-03-02&to=2024-03-01", 1, http.StatusOK, ""},
+		{"from=2023-03-01&to=2024-03-01", 1, http.StatusBadRequest, "histogram_range_too_large"},
+		{"granularity=week&from=2017-01-01&to=2024-03-01", 1, http.StatusBadRequest, "histogram_range_too_large"},
+		{"", 3, http.StatusForbidden, "membership_required_messages"},
+	} {
+		var failure errorBody
+		if status := doJSON(t, http.MethodGet, histogramURL(tc.query), tc.userID, nil, &failure); status != tc.status || failure.Code != tc.code {
+			t.Errorf("%q as user %d got %d %q, want %d %q", tc.query, tc.userID, status, failure.Code, tc.status, tc.code)
+		}
+	}
+}
+
+// TestGetMessagesAt opens room 1 at a date or time: the window is centred on
+// the first message at or after it, and a date after the last message is a 404
+func TestGetMessagesAt(t *testing.T) {
+	server := newTestServer(t, newActivityStore(t), withClock(newFakeClock()))
+	atURL := func(query string) string {
+		return fmt.Sprintf("%s/v1/rooms/1/messages/at?%s", server.URL, query)
+	}
+
+	for _, tc := range []struct {
+		query  string
+		anchor int64
+	}{
+		{"date=2024-02-29", 1},
+		{"date=2024-03-01", 2},
+		{"date=" + url.QueryEscape("2024-03-01T01:00:00+01:00"), 2},
+		{"date=" + url.QueryEscape("2024-03-01T00:00:01Z"), 3},
+	} {
+		var window store.MessageWindow
+		if status := doJSON(t, http.MethodGet, atURL(tc.query), 1, nil, &window); status != http.StatusOK || window.AnchorID != tc.anchor {
+			t.Errorf("%q got %d anchored at %d, want 200 at %d", tc.query, status, window.AnchorID, tc.anchor)
+		}
+	}
+
+	var window store.MessageWindow
+	if status := doJSON(t, http.MethodGet, atURL("date=2024-03-01&before=0&after=1"), 1, nil, &window); status != http.StatusOK || len(window.Messages) != 2 || !window.HasMoreBefore || window.HasMoreAfter {
+		t.Errorf("a window of 0 before and 1 after got %d %+v", status, window)
+	}
+
+	for _, tc := range []struct {
+		query  string
+		userID int64
+		status int
+		code   string
+	}{
+		{"date=2024-03-02", 1, http.StatusNotFound, "no_messages_since_date"},
+		{"", 1, http.StatusBadRequest, "invalid_date_parameter"},
+		{"date=2024-03-01&before=lots", 1, http.StatusBadRequest, "invalid_context_count"},
+		{"date=2024-03-01", 3, http.StatusForbidden, "membership_required_messages"},
+	} {
+		var failure errorBody
+		if status := doJSON(t, http.MethodGet, atURL(tc.query), tc.userID, nil, &failure); status != tc.status || failure.Code != tc.code {
+			t.Errorf("%q as user %d got %d %q, want %d %q", tc.query, tc.userID, status, failure.Code, tc.status, tc.code)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package chatapi
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/websocket"
+)
+
+// opsTokenHeader carries the shared operations token for /v1/admin routes
+const opsTokenHeader = "X-Ops-Token"
+
+// requireOpsToken protects operational endpoints with the shared OPS_TOKEN
+// Deploy tooling calls these without a user account, so a JWT doesn't fit
+// With no token configured the endpoints are disabled entirely
+func (app *application) requireOpsToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expected := app.config.ops.token
+		if expected == "" {
+			writeError(w, r, http.StatusNotFound, "not_found")
+			return
+		}
+
+		// Constant-time comparison so the token can't be guessed byte by byte
+		given := r.Header.Get(opsTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(given), []byte(expected)) != 1 {
+			writeError(w, r, http.StatusUnauthorized, "invalid_ops_token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// drainHandler stops the instance from taking new WebSocket connections
+// Existing clients are told to reconnect elsewhere; with ?deadline= the ones
+// still connected when it elapses are closed with code 1012 (service restart)
+// Calling it again while draining only reports progress
+// POST /v1/admin/drain?deadline=120s
+// Requires the X-Ops-Token header
+// Response: {"shards": 4, "rooms": 12, "clients": 85, "draining": true}
+func (app *application) drainHandler(w http.ResponseWriter, r *http.Request) {
+	var deadline time.Duration
+	if raw := r.URL.Query().Get("deadline"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid_drain_deadline")
+			return
+		}
+		deadline = d
+	}
+
+	app.hub.Drain(app.config.ops.drainRetryAfter, deadline)
+
+	writeJSON(w, http.StatusAccepted, app.hub.Stats())
+}
+
+// hubSnapshotHandler returns the hub's current state for diagnostics
+// GET /v1/admin/hub/snapshot
+// Requires the X-Ops-Token header
+// Response: {"taken_at": "...", "clients": 85, "room_list": [{"room_id": 1, "connections": [...]}], ...}
+func (app *application) hubSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, app.hub.Snapshot())
+}
+
+// logPreviousSnapshot summarizes the snapshot left by the previous run
+// After a crash this is the quickest way to see how busy the server was
+func logPreviousSnapshot(path string) {
+	snapshot, err := websocket.ReadSnapshot(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to read previous hub snapshot: %v", err)
+		return
+	}
+
+	log.Printf("Previous hub snapshot (%s): %d clients across %d rooms, %d clients dropped for full buffers",
+		snapshot.TakenAt.Format(time.RFC3339), snapshot.Clients, snapshot.Rooms, snapshot.DroppedClients)
+}
+
+// ReadinessResponse is the hub's stats with the database pools' usage, the
+// WebSocket upgrade routes' load and the message cache's hits
+type ReadinessResponse struct {
+	websocket.HubStats
+	Database     *store.PoolStats         `json:"database,omitempty"` // Without WithPools, not reported
+	Upgrades     UpgradeStats             `json:"upgrades"`
+	MessageCache *store.MessageCacheStats `json:"message_cache,omitempty"` // Without CacheMessages, not reported
+}
+
+// readinessHandler tells the load balancer whether to send new traffic here
+// Unlike the liveness check (/v1/health), it fails while the hub is draining
+// A failing read replica doesn't fail it: reads fall back to the primary
+// GET /v1/health/ready
+// Response: {"shards": 4, "rooms": 12, "clients": 85, "draining": false,
+// "database": {"primary": {"open": 8, "in_use": 2, "idle": 6, ...}, "replica": {...}},
+// "upgrades": {"in_flight": 3, "max_in_flight": 256, "rejected_busy": 0, "rejected_rate_limited": 12},
+// "message_cache": {"rooms": 40, "messages": 3100, "max_messages": 20000, "hits": 9120, "misses": 310}}
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	stats := app.hub.Stats()
+
+	status := http.StatusOK
+	if stats.Draining {
+		status = http.StatusServiceUnavailable
+	}
+	response := ReadinessResponse{HubStats: stats, Upgrades: app.upgrades.stats()}
+	if app.pools != nil {
+		response.Database = app.pools.Stats()
+	}
+	if cache, ok := app.store.Messages.(*store.MessageCache); ok {
+		response.MessageCache = cache.Stats()
+	}
+	writeJSON(w, status, response)
+}
+
+// rejectIfDraining refuses a WebSocket upgrade while the hub is draining
+// Returns true if the request was rejected
+func (app *application) rejectIfDraining(w http.ResponseWriter, r *http.Request) bool {
+	if !app.hub.Draining() {
+		return false
+	}
+
+	retryAfter := int(app.config.ops.drainRetryAfter.Seconds())
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	writeError(w, r, http.StatusServiceUnavailable, "server_draining")
+	return true
+}
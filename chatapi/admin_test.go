@@ -0,0 +1,122 @@
+package chatapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	ws "github.com/drazan344/go-chat/internal/websocket"
+	"github.com/gorilla/websocket"
+)
+
+// TestDrainEndpoint drains an instance through the ops endpoint: a
+// connected client is told to reconnect, readiness fails while liveness
+// holds, and new connections, guests included, are refused with 503 and
+// Retry-After
+func TestDrainEndpoint(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "lobby", CreatedBy: 1, IsPublicReadonly: true})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	server, app := newTestServerApp(t, ts, func(app *application) {
+		app.config.ops = opsConfig{token: "ops-secret", drainRetryAfter: 7 * time.Second}
+	})
+
+	conn := dialRoom(t, server, 1, 1)
+	readFrame(t, conn, "join")
+
+	for _, tc := range []struct {
+		name   string
+		token  string
+		query  string
+		status int
+		code   string
+	}{
+		{"no token", "", "", http.StatusUnauthorized, "invalid_ops_token"},
+		{"wrong token", "ops-guess", "", http.StatusUnauthorized, "invalid_ops_token"},
+		{"bad deadline", "ops-secret", "?deadline=soon", http.StatusBadRequest, "invalid_drain_deadline"},
+		{"negative deadline", "ops-secret", "?deadline=-1s", http.StatusBadRequest, "invalid_drain_deadline"},
+	} {
+		var failure errorBody
+		status := doJSONWithHeaders(t, http.MethodPost, server.URL+"/v1/admin/drain"+tc.query, 0, map[string]string{opsTokenHeader: tc.token}, nil, &failure)
+		if status != tc.status || failure.Code != tc.code {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, status, failure.Code, tc.status, tc.code)
+		}
+	}
+	if app.hub.Draining() {
+		t.Fatal("a refused request started draining")
+	}
+
+	var ready ReadinessResponse
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/health/ready", 0, nil, &ready); status != http.StatusOK || ready.Clients != 1 {
+		t.Errorf("before draining readiness got %d with %d clients, want 200 with 1", status, ready.Clients)
+	}
+	if ready.Database == nil || ready.Database.Replica != nil {
+		t.Errorf("readiness reported the database as %+v, want the primary alone", ready.Database)
+	}
+	var stats ws.HubStats
+	status := doJSONWithHeaders(t, http.MethodPost, server.URL+"/v1/admin/drain", 0, map[string]string{opsTokenHeader: "ops-secret"}, nil, &stats)
+	if status != http.StatusAccepted || !stats.Draining {
+		t.Fatalf("draining got %d %+v, want 202 and draining", status, stats)
+	}
+
+	if notice := readFrame(t, conn, "server_draining"); notice.ReconnectAfter != 7 {
+		t.Errorf("the client was told to reconnect after %ds, want 7", notice.ReconnectAfter)
+	}
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/health/ready", 0, nil, &stats); status != http.StatusServiceUnavailable || stats.Clients != 1 {
+		t.Errorf("while draining readiness got %d with %d clients, want 503 with 1", status, stats.Clients)
+	}
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/health", 0, nil, nil); status != http.StatusOK {
+		t.Errorf("while draining liveness got %d, want 200", status)
+	}
+
+	for _, path := range []string{"/v1/rooms/1/ws", "/v1/rooms/1/ws/guest"} {
+		url := fmt.Sprintf("ws%s%s", strings.TrimPrefix(server.URL, "http"), path)
+		header := asUser(t, httptest.NewRequest(http.MethodGet, url, nil), 1).Header
+		_, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if err == nil {
+			t.Errorf("%s: connected while draining", path)
+			continue
+		}
+		if resp == nil || resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "7" {
+			t.Errorf("%s: the handshake got %v, want 503 with Retry-After: 7", path, resp)
+		}
+	}
+}
+
+// TestOpsRoutesDisabled checks the admin routes don't exist without OPS_TOKEN
+func TestOpsRoutesDisabled(t *testing.T) {
+	server := newTestServer(t, newTestStore(t))
+	status := doJSONWithHeaders(t, http.MethodPost, server.URL+"/v1/admin/drain", 0, map[string]string{opsTokenHeader: ""}, nil, nil)
+	if status != http.StatusNotFound {
+		t.Errorf("draining without OPS_TOKEN got %d, want 404", status)
+	}
+}
+
+// TestHubSnapshotEndpoint reads the hub's state through the ops endpoint:
+// it takes the ops token and lists who is connected where
+func TestHubSnapshotEndpoint(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "lobby", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	server := newTestServer(t, ts, withOps)
+	readFrame(t, dialRoom(t, server, 1, 1), "join")
+
+	url := server.URL + "/v1/admin/hub/snapshot"
+	if status := doJSONWithHeaders(t, http.MethodGet, url, 1, map[string]string{opsTokenHeader: "ops-guess"}, nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("a wrong token got %d, want 401", status)
+	}
+	var snapshot ws.Snapshot
+	if status := doJSONWithHeaders(t, http.MethodGet, url, 0, map[string]string{opsTokenHeader: "ops-secret"}, nil, &snapshot); status != http.StatusOK {
+		t.Fatalf("the snapshot got %d, want 200", status)
+	}
+	if snapshot.Clients != 1 || len(snapshot.RoomList) != 1 || snapshot.RoomList[0].RoomID != 1 ||
+		len(snapshot.RoomList[0].Connections) != 1 || snapshot.RoomList[0].Connections[0].UserID != 1 {
+		t.Errorf("the snapshot is %+v, want ada alone in room 1", snapshot)
+	}
+}
@@ -0,0 +1,520 @@
+package chatapi
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/auth"
+	"github.com/drazan344/go-chat/internal/blob"
+	"github.com/drazan344/go-chat/internal/cache"
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/drazan344/go-chat/internal/flags"
+	"github.com/drazan344/go-chat/internal/mail"
+	"github.com/drazan344/go-chat/internal/notify"
+	"github.com/drazan344/go-chat/internal/oauth"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/translation"
+	"github.com/drazan344/go-chat/internal/webhook"
+	"github.com/drazan344/go-chat/internal/websocket"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+type application struct {
+	// Define your application struct fields here
+	config config
+	store  store.Storage
+	pools  *store.Pools    // The database pools behind store, for their stats
+	hub    *websocket.Hub  // WebSocket hub for real-time messaging
+	guests *guestLimiter   // Caps anonymous guest connections per IP
+	blobs  *blob.FileStore // Content-addressed storage for attachment files
+
+	// The CONTENT_FILTER_WORDLIST filter, re-read by Reload; nil without a wordlist
+	wordlist *content.WordlistFilter
+
+	// now is the clock exports are dated and rate limited by; tests swap it
+	// to run at a chosen time
+	now func() time.Time
+
+	// Authenticates requests instead of JWTs and API tokens when set (see WithUserResolver)
+	resolver UserResolver
+
+	// Prefix the handler is mounted under, for the URLs it hands out (see WithBasePath)
+	basePath string
+
+	// Where and how often the hub's snapshot is saved; 0 turns it off
+	hubSnapshotPath     string
+	hubSnapshotInterval time.Duration
+
+	// Limits how many image thumbnails are made at once (see thumbnails.go)
+	thumbnailSlots chan struct{}
+
+	// Per-user limit on user search and username lookups, to slow down scraping
+	directoryLimiter *rateLimiter[int64]
+
+	// Per-API-token limit on messages sent over REST, keyed by token ID
+	tokenMessageLimiter *rateLimiter[int64]
+
+	// The same limit per bot API key, keyed by key ID
+	keyMessageLimiter *rateLimiter[int64]
+
+	// Caps WebSocket handshakes in flight and per IP (see upgrades.go)
+	upgrades *upgradeGuard
+
+	// Settings that can change without a restart; replaced as a whole on reload
+	runtime  atomic.Pointer[RuntimeConfig]
+	reloader *configReloader
+
+	// Rules for new passwords, shared by every path that sets one
+	passwords *auth.PasswordPolicy
+
+	// Encrypts users' TOTP secrets; twoFactorLimiter limits code attempts per user
+	secrets          *auth.SecretBox
+	twoFactorLimiter *rateLimiter[int64]
+
+	// Limits password reset emails per address
+	passwordResetLimiter *rateLimiter[string]
+
+	// Providers users can sign in with, by name (see oauth.go)
+	oauth map[string]*oauth.Provider
+
+	// Backend for message translation; nil when translation is off
+	translator translation.Translator
+
+	// Who wants which notifications; invalidated when preferences change
+	notifications notify.Policy
+
+	// Users' roles and rooms' permission matrices; invalidated when either changes
+	roomAccessCache *roomAccessCache
+
+	// Users' token versions; invalidated when a user signs out everywhere or is banned
+	tokenVersions *cache.TTL[int64, int64]
+
+	// Feature flags, shared with the hub; invalidated when a flag changes
+	flags *flags.CachedChecker
+
+	// Sends email (invites); nil when email is turned off
+	mailer mail.Mailer
+
+	// Sends rooms' events to their outgoing webhooks; nil when they're turned off
+	outgoing *webhook.Outgoing
+
+	// Parent of work that outlives the request starting it (exports, emails,
+	// thumbnails); cancelled once Start's context is done
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type config struct {
+	// Define your config struct fields here
+	// These are read once at startup; RuntimeConfig holds the settings that can be reloaded
+	addr        string
+	db          dbConfig
+	auth        authConfig
+	guest       guestConfig
+	upgrades    upgradesConfig
+	limits      limitsConfig
+	moderation  moderationConfig
+	export      exportConfig
+	webhook     webhookConfig
+	ops         opsConfig
+	rooms       roomsConfig
+	push        pushConfig
+	attachments attachmentsConfig
+	translate   translateConfig
+	mail        mailConfig
+	oauth       oauthConfig
+
+	// Reverse proxies whose X-Forwarded-For and X-Real-IP are believed (see realIP)
+	trustedProxies []netip.Prefix
+
+	// systemUsername is the name of the system user that server-originated
+	// messages are posted as (see postSystemMessage)
+	systemUsername string
+}
+
+type dbConfig struct {
+	addr         string
+	replicaAddr  string // Read replica for heavy reads; empty reads everything from addr
+	maxOpenConns int
+	maxIdleConns int
+	maxIdleTime  string
+	timeouts     store.Timeouts // How long store operations may take (DB_*_TIMEOUT)
+}
+
+type authConfig struct {
+	jwtSecret          string        // Secret key for signing JWT tokens
+	passwordMinLength  int           // Minimum characters in a new password
+	passwordClasses    []string      // Character classes every new password must use
+	passwordDictionary string        // File of refused passwords, one per line; empty for none
+	breachCheck        bool          // Reject passwords found in known breaches (HaveIBeenPwned)
+	breachTimeout      time.Duration // How long to wait for the breach API before allowing the password
+	sessionIdleTimeout time.Duration // Login sessions unused for this long are signed out
+	twoFactorKey       string        // Encrypts TOTP secrets at rest; falls back to jwtSecret
+	passwordResetTTL   time.Duration // How long a password reset link works
+}
+
+type guestConfig struct {
+	maxConnsPerIP int // Concurrent guest WebSocket connections allowed per IP
+}
+
+type upgradesConfig struct {
+	maxInFlight int // WebSocket handshakes handled at once; more get a 503
+}
+
+type exportConfig struct {
+	dir            string // Where async exports are written
+	asyncThreshold int    // Users with more messages than this get an async export
+}
+
+type attachmentsConfig struct {
+	dir      string // Where attachment files are stored
+	maxBytes int64  // Largest file a single upload may contain
+}
+
+type translateConfig struct {
+	provider string        // "dictionary" or "libretranslate"; empty disables translation
+	url      string        // Base URL of the LibreTranslate-compatible API
+	apiKey   string        // Optional API key for the translation API
+	timeout  time.Duration // How long to wait for the translation API before giving up
+}
+
+type pushConfig struct {
+	provider    string // Push provider name ("log"); empty disables push notifications
+	maxFailures int    // Permanent failures in a row before a device token is disabled
+}
+
+type mailConfig struct {
+	provider       string        // "log" or "smtp"; empty disables email (and so digests and email invites)
+	smtpAddr       string        // SMTP server host:port
+	smtpUsername   string        // SMTP login; empty sends without authentication
+	smtpPassword   string        // SMTP password
+	from           string        // Sender address of outgoing email
+	publicURL      string        // Base URL of the web app, for links in emails
+	digestInterval time.Duration // How often the digest scheduler looks for due digests
+}
+
+type oauthConfig struct {
+	// The app registered with each provider users can sign in with, by name
+	// (see oauthProviders); providers not listed are off
+	clients map[string]oauthClient
+}
+
+type roomsConfig struct {
+	restoreWindow   time.Duration // How long a deleted room can be restored before it's purged
+	eventRetention  time.Duration // How long room events are kept for clients to replay
+	defaultLanguage string        // Language of rooms without their own, a canonical BCP 47 tag
+
+	// Message retention (see retention.go): the default for rooms without
+	// their own (0 keeps messages forever), and the bounds of a room's own
+	// (a maxRetention of 0 means no maximum, and lets rooms keep messages forever)
+	messageRetention time.Duration
+	minRetention     time.Duration
+	maxRetention     time.Duration
+}
+
+type opsConfig struct {
+	token           string        // Shared token for /v1/admin routes; empty disables them
+	drainRetryAfter time.Duration // How long draining clients are told to wait before reconnecting
+}
+
+type webhookConfig struct {
+	url    string   // Where hub events are POSTed; empty disables the webhook
+	secret string   // Shared secret for the HMAC signature header
+	events []string // Event types to send; empty sends all of them
+
+	// Hosts rooms' outgoing webhooks may call; empty disables them
+	roomHosts []string
+}
+
+type moderationConfig struct {
+	wordlistPath string   // Wordlist file for the content filter; empty disables filtering
+	hookHosts    []string // Hosts moderation hooks may call; empty disables hooks
+}
+
+type limitsConfig struct {
+	maxRoomMembers  int // Global cap on members per room; rooms may set a lower limit
+	maxRoomsPerUser int // How many rooms one user may belong to
+
+	// Room creation quotas (see room_quota.go); 0 disables either
+	roomCreatesPerDay int            // Rooms one user may create per rolling 24 hours
+	maxOwnedRooms     int            // Rooms one user may have created and not deleted
+	roomQuotaExempt   map[int64]bool // Users the quotas don't apply to
+}
+
+func (app *application) mount() http.Handler {
+	r := chi.NewRouter()
+
+	r.Use(middleware.RequestID)
+	r.Use(app.realIP)
+	r.Use(middleware.Logger)
+	r.Use(middleware.Recoverer)
+
+	// Request timeouts and body limits are set per route group (see limits.go)
+	// The WebSocket routes have none, as their connections outlive the request
+
+	// Serve static files
+	// The path is taken from the route rather than the URL, so this works
+	// under a prefix too
+	fileServer := http.FileServer(http.Dir("./web/static"))
+	r.Get("/static/*", func(w http.ResponseWriter, r *http.Request) {
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/" + chi.URLParam(r, "*")
+		r2.URL.RawPath = ""
+		fileServer.ServeHTTP(w, r2)
+	})
+
+	// Serve index.html at root
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "./web/index.html")
+	})
+
+	// API routes
+	r.Route("/v1", func(r chi.Router) {
+		// Long-lived routes, kept out of the request timeout below
+		// A WebSocket connection outlives its upgrade request; a timeout here
+		// would cancel the request context the connection was set up with
+		// Upgrades are guarded before authentication, which already costs a query
+		r.With(app.guardUpgrade).Get("/rooms/{roomID}/ws/guest", app.guestWebsocketHandler)
+		r.With(app.guardUpgrade, app.AuthMiddleware).Get("/rooms/{roomID}/ws", app.websocketHandler)
+		r.Group(func(r chi.Router) {
+			r.Use(app.AuthMiddleware)
+
+			// A synchronous export streams for as long as it takes to write
+			// the user's history, past the server's WriteTimeout
+			r.With(app.withTimeout(exportTimeout), app.withoutWriteTimeout).
+				Get("/users/me/export", app.exportUserDataHandler)
+		})
+
+		// Everything else: defaultRequestTimeout, or less where a group says so
+		r.Group(func(r chi.Router) {
+			r.Use(app.withTimeout(defaultRequestTimeout))
+
+			// Health check endpoint
+			r.Get("/health", app.healthCheckHandler)
+
+			// Readiness fails while draining, so the load balancer stops routing here
+			// Liveness (/health) stays OK until the process actually exits
+			r.Get("/health/ready", app.readinessHandler)
+
+			// Operational routes for deploy tooling, protected by a shared token
+			r.Route("/admin", func(r chi.Router) {
+				r.Use(app.requireOpsToken)
+				r.Post("/drain", app.drainHandler)
+				r.Get("/hub/snapshot", app.hubSnapshotHandler)
+				r.Get("/storage/stats", app.storageStatsHandler)
+				r.Get("/reports", app.adminReportsHandler)
+				r.Patch("/reports/{reportID}", app.updateReportHandler)
+				r.Get("/messages", app.adminMessagesHandler)
+				r.Post("/messages/{messageID}/redact", app.redactMessageHandler)
+				r.Post("/rooms/{roomID}/default", app.setDefaultRoomHandler)
+				r.Delete("/rooms/{roomID}/default", app.unsetDefaultRoomHandler)
+				r.Post("/config/reload", app.reloadConfigHandler)
+				r.Get("/flags", app.listFeatureFlagsHandler)
+				r.Put("/flags", app.updateFeatureFlagHandler)
+				r.Put("/users/{userID}/role", app.setUserRoleHandler)
+
+				// Only in -tags faults builds run with STORE_FAULTS (see faults.go)
+				app.mountFaults(r)
+			})
+
+			// Public authentication routes (no auth required)
+			r.Route("/auth", func(r chi.Router) {
+				r.Use(app.withBodyLimit(authBodyLimit))
+				r.Group(func(r chi.Router) {
+					r.Use(app.withTimeout(authTimeout))
+					r.Post("/register", app.registerHandler)
+					r.Post("/login", app.loginHandler)
+					r.Post("/2fa/verify", app.verifyTwoFactorLoginHandler)
+					r.Post("/forgot-password", app.forgotPasswordHandler)
+					r.Post("/reset-password", app.resetPasswordHandler)
+					r.Get("/oauth/{provider}/login", app.oauthLoginHandler)
+				})
+
+				// Finishing a Google or GitHub sign-in waits on the provider
+				r.With(app.withTimeout(oauthTimeout)).Get("/oauth/{provider}/callback", app.oauthCallbackHandler)
+			})
+
+			// What clients may send and which features are on (no auth required)
+			r.Get("/capabilities", app.capabilitiesHandler)
+
+			// Public guest routes (no auth required)
+			// Only rooms flagged is_public_readonly respond; all others return 404
+			r.Get("/rooms/{roomID}/messages/public", app.getPublicRoomMessagesHandler)
+
+			// Protected routes (require authentication)
+			// The AuthMiddleware validates JWT and adds user ID to context
+			r.Group(func(r chi.Router) {
+				r.Use(app.AuthMiddleware)
+
+				// Current user endpoint
+				r.Get("/auth/me", app.getCurrentUserHandler)
+
+				// Revokes every token the user was issued, signing them out everywhere
+				r.Post("/auth/logout-all", app.logoutAllHandler)
+
+				// Device registration and cross-device read state
+				r.Post("/devices", app.createDeviceHandler)
+				r.Post("/devices/push-token", app.setPushTokenHandler)
+				r.Delete("/devices/push-token", app.deletePushTokenHandler)
+				r.Get("/users/me/sync", app.syncStateHandler)
+
+				// Every frame sent to the user, by user_seq, for filling gaps after a reconnect
+				r.Get("/users/me/events", app.listUserEventsHandler)
+
+				// User directory and profile
+				r.Patch("/users/me", app.updateProfileHandler)
+				r.Get("/users/search", app.searchUsersHandler)
+				r.Get("/users/by-username/{username}", app.getUserByUsernameHandler)
+				r.Get("/users/{userID}/mutual", app.getMutualHandler)
+				r.Post("/users/{userID}/report", app.reportUserHandler)
+
+				// Server-wide moderation, for admins (users.role)
+				r.Group(func(r chi.Router) {
+					r.Use(app.RequireRole(store.UserRoleAdmin))
+					r.Post("/users/{userID}/ban", app.banUserHandler)
+					r.Delete("/users/{userID}/ban", app.unbanUserHandler)
+				})
+
+				// Joined rooms with last message, unread/mention and online counts
+				r.Get("/users/me/rooms", app.listMyRoomsHandler)
+
+				// Room invites for registered users, also pushed and answered over WebSocket
+				r.Get("/users/me/invites", app.listRoomInvitesHandler)
+				r.Post("/users/me/invites/{inviteID}/accept", app.acceptRoomInviteHandler)
+				r.Post("/users/me/invites/{inviteID}/decline", app.declineRoomInviteHandler)
+
+				// Notification preferences, per channel and event type
+				r.Get("/users/me/notification-preferences", app.notificationPreferencesHandler)
+				r.Put("/users/me/notification-preferences", app.updateNotificationPreferencesHandler)
+
+				// Daily digest emails
+				r.Get("/users/me/digest", app.digestSettingsHandler)
+				r.Put("/users/me/digest", app.updateDigestSettingsHandler)
+
+				// Personal access tokens for scripts and integrations
+				r.Post("/users/me/tokens", app.createAPITokenHandler)
+				r.Get("/users/me/tokens", app.listAPITokensHandler)
+				r.Delete("/users/me/tokens/{tokenID}", app.revokeAPITokenHandler)
+
+				// Bot API keys, sent as "ApiKey <key>"
+				r.Post("/users/me/api-keys", app.createAPIKeyHandler)
+				r.Get("/users/me/api-keys", app.listAPIKeysHandler)
+				r.Delete("/users/me/api-keys/{keyID}", app.revokeAPIKeyHandler)
+
+				// Two-factor authentication (TOTP)
+				r.Post("/users/me/2fa/setup", app.setupTwoFactorHandler)
+				r.Post("/users/me/2fa/enable", app.enableTwoFactorHandler)
+				r.Post("/users/me/2fa/disable", app.disableTwoFactorHandler)
+
+				// Login sessions (devices signed in with a password)
+				r.Get("/users/me/sessions", app.listSessionsHandler)
+				r.Delete("/users/me/sessions/{sessionID}", app.revokeSessionHandler)
+
+				// Personal data export (GDPR data subject access requests)
+				// Synchronous exports are registered above, outside the request timeout
+				r.Get("/users/me/export/{jobID}", app.getExportJobHandler)
+
+				// File uploads, deduplicated by content
+				r.Post("/attachments", app.uploadAttachmentHandler)
+				r.Get("/attachments/{attachmentID}", app.getAttachmentHandler)
+				r.Get("/attachments/{attachmentID}/thumbnail", app.getAttachmentThumbnailHandler)
+				r.Delete("/attachments/{attachmentID}", app.deleteAttachmentHandler)
+
+				// Message permalinks, for members of the message's room
+				r.Get("/messages/{messageID}", app.getMessageHandler)
+
+				// Message translation, for members of the message's room
+				r.Get("/messages/{messageID}/translate", app.translateMessageHandler)
+
+				// Abuse reports on messages (users are reported under /users)
+				r.Post("/messages/{messageID}/report", app.reportMessageHandler)
+
+				// Room tags with their room counts
+				r.Get("/tags", app.listTagsHandler)
+
+				// Saved room settings, used with POST /rooms?template_id=N
+				r.Post("/room-templates", app.createRoomTemplateHandler)
+				r.Get("/room-templates", app.listRoomTemplatesHandler)
+
+				// Post routes
+				r.Route("/posts", func(r chi.Router) {
+					r.Get("/", app.listPostsHandler)
+					r.Post("/", app.createPostHandler)
+					r.Get("/{postID}", app.getPostHandler)
+					r.Patch("/{postID}", app.updatePostHandler)
+					r.Delete("/{postID}", app.deletePostHandler)
+				})
+
+				// Room routes
+				r.Route("/rooms", func(r chi.Router) {
+					r.Get("/", app.listRoomsHandler)
+					r.Post("/", app.createRoomHandler)
+					r.Get("/recommended", app.recommendedRoomsHandler)
+					r.Get("/{roomID}", app.getRoomHandler)
+					r.Patch("/{roomID}", app.updateRoomHandler)
+					r.Post("/{roomID}/delete-request", app.roomDeletionRequestHandler)
+					r.Delete("/{roomID}", app.deleteRoomHandler)
+					r.Post("/{roomID}/restore", app.restoreRoomHandler)
+					r.Post("/{roomID}/merge", app.mergeRoomHandler)
+					r.Post("/{roomID}/join", app.joinRoomHandler)
+					r.Post("/{roomID}/leave", app.leaveRoomHandler)
+					r.Post("/{roomID}/members/bulk", app.bulkAddMembersHandler)
+					r.Post("/{roomID}/members/bulk-remove", app.bulkRemoveMembersHandler)
+					r.Post("/{roomID}/members/invite", app.createRoomInviteHandler)
+					r.Put("/{roomID}/members/{userID}/role", app.setRoomMemberRoleHandler)
+					r.Post("/{roomID}/invites", app.createEmailInvitesHandler)
+					r.Get("/{roomID}/join-requests", app.listJoinRequestsHandler)
+					r.Post("/{roomID}/join-requests/{userID}/approve", app.approveJoinRequestHandler)
+					r.Post("/{roomID}/join-requests/{userID}/reject", app.rejectJoinRequestHandler)
+					r.Get("/{roomID}/membership-events", app.listMembershipEventsHandler)
+					r.Get("/{roomID}/events", app.listRoomEventsHandler)
+					r.Get("/{roomID}/reports", app.roomReportsHandler)
+					r.Get("/{roomID}/permissions", app.roomPermissionsHandler)
+					r.Put("/{roomID}/permissions", app.updateRoomPermissionsHandler)
+					r.Get("/{roomID}/moderation-hook", app.getModerationHookHandler)
+					r.Put("/{roomID}/moderation-hook", app.putModerationHookHandler)
+					r.Delete("/{roomID}/moderation-hook", app.deleteModerationHookHandler)
+					r.Get("/{roomID}/outgoing-webhooks", app.listOutgoingWebhooksHandler)
+					r.Post("/{roomID}/outgoing-webhooks", app.createOutgoingWebhookHandler)
+					r.Delete("/{roomID}/outgoing-webhooks/{webhookID}", app.deleteOutgoingWebhookHandler)
+					r.Get("/{roomID}/outgoing-webhooks/{webhookID}/deliveries", app.listOutgoingWebhookDeliveriesHandler)
+					r.Get("/{roomID}/messages", app.getRoomMessagesHandler)
+					r.With(app.withMessageBodyLimit, app.withTimeout(messageTimeout)).
+						Post("/{roomID}/messages", app.sendRoomMessageHandler)
+					r.Get("/{roomID}/messages/context", app.getMessageContextHandler)
+					r.Get("/{roomID}/messages/at", app.getMessagesAtHandler)
+					r.Get("/{roomID}/activity-histogram", app.activityHistogramHandler)
+					r.Get("/{roomID}/search", app.searchRoomHandler)
+					r.Get("/{roomID}/messages/{messageID}/receipts", app.getMessageReceiptsHandler)
+					r.Post("/{roomID}/read", app.markRoomReadHandler)
+					r.Get("/{roomID}/pins", app.listPinsHandler)
+					r.Put("/{roomID}/pins/order", app.reorderPinsHandler)
+					r.Post("/{roomID}/pins/{messageID}", app.pinMessageHandler)
+					r.Delete("/{roomID}/pins/{messageID}", app.unpinMessageHandler)
+				})
+			})
+		})
+	})
+
+	return r
+}
+
+func (app *application) run(mux http.Handler) error {
+
+	srv := &http.Server{
+		Addr:         app.config.addr,
+		Handler:      mux,
+		WriteTimeout: time.Second * 30,
+		ReadTimeout:  time.Second * 10,
+		IdleTimeout:  time.Minute,
+	}
+
+	log.Printf("Server has started at %s", app.config.addr)
+
+	return srv.ListenAndServe()
+}
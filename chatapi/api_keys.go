@@ -0,0 +1,129 @@
+package chatapi
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/drazan344/go-chat/internal/auth"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// maxAPIKeyNameLength matches the api_keys.name column
+const maxAPIKeyNameLength = 100
+
+// CreateAPIKeyRequest represents the JSON structure for creating a bot API key
+type CreateAPIKeyRequest struct {
+	Name string `json:"name"`
+}
+
+// CreateAPIKeyResponse is the new key's details plus the key itself
+// This is the only time the key is ever returned
+type CreateAPIKeyResponse struct {
+	*store.APIKey
+	Key string `json:"key"`
+}
+
+// createAPIKeyHandler creates a bot API key for the current user
+// Keys never expire and, unlike personal access tokens, survive password
+// resets; they're revoked one by one, and a ban locks them out until it's lifted
+// POST /v1/users/me/api-keys
+// Requires authentication with a login session, as for personal access tokens
+// Request body: {"name": "deploy bot"}
+// Response: {"id": 2, "name": "deploy bot", "prefix": "gcbot_9c0e7", "key": "gcbot_9c0e7...", ...}
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+	if !viaLoginSession(r.Context()) {
+		writeError(w, r, http.StatusForbidden, "api_key_session_required")
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		writeError(w, r, http.StatusBadRequest, "api_key_name_required")
+		return
+	}
+	if utf8.RuneCountInString(name) > maxAPIKeyNameLength {
+		writeError(w, r, http.StatusBadRequest, "api_key_name_too_long", maxAPIKeyNameLength)
+		return
+	}
+
+	key, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "api_key_create_failed")
+		return
+	}
+
+	apiKey := &store.APIKey{UserID: userID, Name: name, Prefix: prefix}
+	if err := app.store.APIKeys.Create(r.Context(), apiKey, hash); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "api_key_create_failed")
+		return
+	}
+
+	// Keys are credentials: keep them out of any caches along the way
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, CreateAPIKeyResponse{APIKey: apiKey, Key: key})
+}
+
+// listAPIKeysHandler lists the current user's bot API keys
+// The keys themselves are never returned, only their prefixes
+// GET /v1/users/me/api-keys
+// Requires authentication
+// Response: [{"id": 2, "name": "deploy bot", "prefix": "gcbot_9c0e7", "last_used_at": "...", ...}]
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	keys, err := app.store.APIKeys.List(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "api_key_list_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// revokeAPIKeyHandler revokes one of the current user's bot API keys
+// The key stops working immediately: requests are checked against the database
+// DELETE /v1/users/me/api-keys/{keyID}
+// Requires authentication
+// Response: 204 No Content
+func (app *application) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	keyID, err := extractIDFromURL(r, "keyID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "keyID")
+		return
+	}
+
+	if err := app.store.APIKeys.Revoke(r.Context(), keyID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "api_key_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "api_key_revoke_failed")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
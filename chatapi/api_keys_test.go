@@ -0,0 +1,111 @@
+package chatapi
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/auth"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// withAPIKey authenticates a request with a bot API key
+func withAPIKey(key string) map[string]string {
+	return map[string]string{"Authorization": "ApiKey " + key}
+}
+
+// TestAPIKeys has ada make a key for a bot: it works as "ApiKey <key>",
+// can send silent messages, and can't make more keys. Neither a JWT nor a
+// personal access token passes as a key, and a revoked key stops working
+func TestAPIKeys(t *testing.T) {
+	server := newTestServer(t, newRolesStore(t))
+	keys := server.URL + "/v1/users/me/api-keys"
+
+	var created CreateAPIKeyResponse
+	if status := doJSON(t, http.MethodPost, keys, 1, CreateAPIKeyRequest{Name: "deploy bot"}, &created); status != http.StatusCreated || !auth.IsAPIKey(created.Key) {
+		t.Fatalf("creating got %d with key %q, want 201 with a gcbot_ key", status, created.Key)
+	}
+	var listed []*store.APIKey
+	if status := doJSONWithHeaders(t, http.MethodGet, keys, 0, withAPIKey(created.Key), nil, &listed); status != http.StatusOK || len(listed) != 1 || listed[0].Prefix != created.Prefix {
+		t.Fatalf("listing with the key got %d with %d keys, want 200 with the new one", status, len(listed))
+	}
+	var sent store.Message
+	if status := doJSONWithHeaders(t, http.MethodPost, server.URL+"/v1/rooms/1/messages", 0, withAPIKey(created.Key), SendMessageRequest{Content: "deployed", Silent: true}, &sent); status != http.StatusCreated || sent.UserID != 1 {
+		t.Errorf("a silent message with the key got %d from user %d, want 201 from ada", status, sent.UserID)
+	}
+
+	var failure errorBody
+	if status := doJSONWithHeaders(t, http.MethodPost, keys, 0, withAPIKey(created.Key), CreateAPIKeyRequest{Name: "another"}, &failure); status != http.StatusForbidden || failure.Code != "api_key_session_required" {
+		t.Errorf("creating with a key got %d %q, want 403 api_key_session_required", status, failure.Code)
+	}
+	if status := doJSON(t, http.MethodPost, keys, 1, CreateAPIKeyRequest{Name: " "}, &failure); status != http.StatusBadRequest || failure.Code != "api_key_name_required" {
+		t.Errorf("a blank name got %d %q, want 400 api_key_name_required", status, failure.Code)
+	}
+
+	jwt, err := auth.GenerateToken(1, 0, 0, time.Now().Add(auth.TokenLifetime), testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var token CreateAPITokenResponse
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/users/me/tokens", 1, CreateAPITokenRequest{Name: "backup script"}, &token); status != http.StatusCreated {
+		t.Fatalf("creating a token got %d, want 201", status)
+	}
+	for name, credential := range map[string]string{"a JWT": jwt, "a personal access token": token.Token} {
+		if status := doJSONWithHeaders(t, http.MethodGet, keys, 0, withAPIKey(credential), nil, &failure); status != http.StatusUnauthorized || failure.Code != "invalid_token" {
+			t.Errorf("%s as a key got %d %q, want 401 invalid_token", name, status, failure.Code)
+		}
+	}
+
+	if status := doJSON(t, http.MethodDelete, fmt.Sprintf("%s/%d", keys, created.ID), 2, nil, &failure); status != http.StatusNotFound || failure.Code != "api_key_not_found" {
+		t.Errorf("grace revoking ada's key got %d %q, want 404 api_key_not_found", status, failure.Code)
+	}
+	if status := doJSON(t, http.MethodDelete, fmt.Sprintf("%s/%d", keys, created.ID), 1, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("revoking got %d, want 204", status)
+	}
+	if status := doJSONWithHeaders(t, http.MethodGet, keys, 0, withAPIKey(created.Key), nil, &failure); status != http.StatusUnauthorized || failure.Code != "invalid_token" {
+		t.Errorf("the revoked key got %d %q, want 401 invalid_token", status, failure.Code)
+	}
+}
+
+// TestAPIKeysOutliveSignOuts has ada sign out everywhere and then get
+// banned: signing out leaves the bot's key working, while the ban locks it
+// out until grace lifts it, unlike personal access tokens, which are gone
+func TestAPIKeysOutliveSignOuts(t *testing.T) {
+	server := newTestServer(t, newRolesStore(t))
+	me := server.URL + "/v1/auth/me"
+
+	var created CreateAPIKeyResponse
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/users/me/api-keys", 1, CreateAPIKeyRequest{Name: "deploy bot"}, &created); status != http.StatusCreated {
+		t.Fatalf("creating got %d, want 201", status)
+	}
+	var token CreateAPITokenResponse
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/users/me/tokens", 1, CreateAPITokenRequest{Name: "backup script"}, &token); status != http.StatusCreated {
+		t.Fatalf("creating a token got %d, want 201", status)
+	}
+
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/auth/logout-all", 1, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("signing out everywhere got %d, want 204", status)
+	}
+	if status := doJSONWithHeaders(t, http.MethodGet, me, 0, withAPIKey(created.Key), nil, nil); status != http.StatusOK {
+		t.Errorf("the key after signing out everywhere got %d, want 200", status)
+	}
+
+	ban := server.URL + "/v1/users/1/ban"
+	if status := doJSON(t, http.MethodPost, ban, 2, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("banning got %d, want 204", status)
+	}
+	var failure errorBody
+	if status := doJSONWithHeaders(t, http.MethodGet, me, 0, withAPIKey(created.Key), nil, &failure); status != http.StatusUnauthorized || failure.Code != "invalid_token" {
+		t.Errorf("the key while banned got %d %q, want 401 invalid_token", status, failure.Code)
+	}
+	if status := doJSON(t, http.MethodDelete, ban, 2, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("lifting the ban got %d, want 204", status)
+	}
+	if status := doJSONWithHeaders(t, http.MethodGet, me, 0, withAPIKey(created.Key), nil, nil); status != http.StatusOK {
+		t.Errorf("the key after the ban got %d, want 200", status)
+	}
+	if status := doJSONWithHeaders(t, http.MethodGet, me, 0, withToken(token.Token), nil, &failure); status != http.StatusUnauthorized || failure.Code != "invalid_token" {
+		t.Errorf("the token after the ban got %d %q, want 401 invalid_token", status, failure.Code)
+	}
+}
@@ -0,0 +1,146 @@
+package chatapi
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/drazan344/go-chat/internal/auth"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+const (
+	// maxAPITokenNameLength matches the api_tokens.name column
+	maxAPITokenNameLength = 100
+
+	// maxAPITokenLifetimeDays caps the optional expiry; omit it for a token that never expires
+	maxAPITokenLifetimeDays = 365
+)
+
+// CreateAPITokenRequest represents the JSON structure for creating a personal access token
+type CreateAPITokenRequest struct {
+	Name          string `json:"name"`
+	ExpiresInDays *int   `json:"expires_in_days"` // Omit for a token that never expires
+}
+
+// CreateAPITokenResponse is the new token's details plus the token itself
+// This is the only time the token is ever returned
+type CreateAPITokenResponse struct {
+	*store.APIToken
+	Token string `json:"token"`
+}
+
+// createAPITokenHandler creates a personal access token for the current user
+// POST /v1/users/me/tokens
+// Requires authentication with a login session: a token (or API key) can't mint more tokens,
+// so a leaked one can be contained by revoking it
+// Request body: {"name": "backup script", "expires_in_days": 90}
+// Response: {"id": 3, "name": "backup script", "prefix": "gochat_3fa1c", "token": "gochat_3fa1c...", ...}
+func (app *application) createAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+	if !viaLoginSession(r.Context()) {
+		writeError(w, r, http.StatusForbidden, "api_token_session_required")
+		return
+	}
+
+	var req CreateAPITokenRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		writeError(w, r, http.StatusBadRequest, "api_token_name_required")
+		return
+	}
+	if utf8.RuneCountInString(name) > maxAPITokenNameLength {
+		writeError(w, r, http.StatusBadRequest, "api_token_name_too_long", maxAPITokenNameLength)
+		return
+	}
+
+	apiToken := &store.APIToken{UserID: userID, Name: name}
+	if req.ExpiresInDays != nil {
+		days := *req.ExpiresInDays
+		if days < 1 || days > maxAPITokenLifetimeDays {
+			writeError(w, r, http.StatusBadRequest, "invalid_api_token_expiry", maxAPITokenLifetimeDays)
+			return
+		}
+		expiresAt := time.Now().AddDate(0, 0, days)
+		apiToken.ExpiresAt = &expiresAt
+	}
+
+	token, prefix, hash, err := auth.GenerateAPIToken()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "api_token_create_failed")
+		return
+	}
+	apiToken.Prefix = prefix
+
+	if err := app.store.APITokens.Create(r.Context(), apiToken, hash); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "api_token_create_failed")
+		return
+	}
+
+	// Tokens are credentials: keep them out of any caches along the way
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, CreateAPITokenResponse{APIToken: apiToken, Token: token})
+}
+
+// listAPITokensHandler lists the current user's personal access tokens
+// The tokens themselves are never returned, only their prefixes
+// GET /v1/users/me/tokens
+// Requires authentication
+// Response: [{"id": 3, "name": "backup script", "prefix": "gochat_3fa1c", "last_used_at": "...", "expires_at": null, ...}]
+func (app *application) listAPITokensHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	tokens, err := app.store.APITokens.List(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "api_token_list_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// revokeAPITokenHandler revokes one of the current user's personal access tokens
+// The token stops working immediately: requests are checked against the database
+// DELETE /v1/users/me/tokens/{tokenID}
+// Requires authentication
+// Response: 204 No Content
+func (app *application) revokeAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	tokenID, err := extractIDFromURL(r, "tokenID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "tokenID")
+		return
+	}
+
+	if err := app.store.APITokens.Revoke(r.Context(), tokenID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "api_token_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "api_token_revoke_failed")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,131 @@
+package chatapi
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/auth"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// withToken authenticates a request with a personal access token instead of a JWT
+func withToken(token string) map[string]string {
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+// TestAPITokens has ada create a token from a login session: the token is
+// in that response and nowhere else, works alongside the JWT, is logged by
+// ID when used, and stops working the moment it's revoked
+func TestAPITokens(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(io.Discard) })
+
+	ts := newTestStore(t)
+	server := newTestServer(t, ts)
+	tokens := server.URL + "/v1/users/me/tokens"
+
+	var created CreateAPITokenResponse
+	if status := doJSON(t, http.MethodPost, tokens, 1, CreateAPITokenRequest{Name: " backup script "}, &created); status != http.StatusCreated {
+		t.Fatalf("creating got %d, want 201", status)
+	}
+	if !strings.HasPrefix(created.Token, created.Prefix) || created.Prefix != created.Token[:12] || created.Name != "backup script" {
+		t.Errorf("got token %q with prefix %q named %q", created.Token, created.Prefix, created.Name)
+	}
+	if _, err := ts.apiTokens.GetByHash(context.Background(), auth.HashAPIToken(created.Token)); err != nil {
+		t.Errorf("the token isn't stored under its hash: %v", err)
+	}
+
+	// Listed with the token, with the JWT, and never showing it again
+	for name, headers := range map[string]map[string]string{"token": withToken(created.Token), "jwt": nil} {
+		userID := int64(1)
+		if headers != nil {
+			userID = 0
+		}
+		var listed []map[string]any
+		if status := doJSONWithHeaders(t, http.MethodGet, tokens, userID, headers, nil, &listed); status != http.StatusOK || len(listed) != 1 {
+			t.Fatalf("listing with the %s got %d with %d tokens, want 200 with 1", name, status, len(listed))
+		}
+		if _, ok := listed[0]["token"]; ok || listed[0]["prefix"] != created.Prefix {
+			t.Errorf("listing with the %s showed %v, want the prefix only", name, listed[0])
+		}
+	}
+	if !strings.Contains(logged.String(), "API token request: token=1 user=1 GET /v1/users/me/tokens") {
+		t.Errorf("the token's request wasn't logged: %q", logged.String())
+	}
+
+	// Another user can't see or revoke it
+	if status := doJSON(t, http.MethodDelete, tokens+"/1", 2, nil, nil); status != http.StatusNotFound {
+		t.Errorf("grace revoking ada's token got %d, want 404", status)
+	}
+	if status := doJSON(t, http.MethodDelete, tokens+"/1", 1, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("revoking got %d, want 204", status)
+	}
+	var failure errorBody
+	if status := doJSONWithHeaders(t, http.MethodGet, tokens, 0, withToken(created.Token), nil, &failure); status != http.StatusUnauthorized || failure.Code != "invalid_token" {
+		t.Errorf("the revoked token got %d %q, want 401 invalid_token", status, failure.Code)
+	}
+}
+
+// TestAPITokenLimits refuses expired tokens, tokens minting tokens and
+// bad names and expiries, and writes last_used_at at most once a minute
+func TestAPITokenLimits(t *testing.T) {
+	ts := newTestStore(t)
+	server := newTestServer(t, ts)
+	tokens := server.URL + "/v1/users/me/tokens"
+
+	token, prefix, hash, err := auth.GenerateAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recently := time.Now().Add(-time.Second)
+	ts.apiTokens.Create(context.Background(), &store.APIToken{UserID: 1, Name: "cron", Prefix: prefix, LastUsedAt: &recently}, hash)
+
+	for range 3 {
+		if status := doJSONWithHeaders(t, http.MethodGet, tokens, 0, withToken(token), nil, nil); status != http.StatusOK {
+			t.Fatalf("using the token got %d, want 200", status)
+		}
+	}
+	if ts.apiTokens.touches != 0 {
+		t.Errorf("a token used a second ago was touched %d times", ts.apiTokens.touches)
+	}
+
+	var failure errorBody
+	if status := doJSONWithHeaders(t, http.MethodPost, tokens, 0, withToken(token), CreateAPITokenRequest{Name: "more"}, &failure); status != http.StatusForbidden || failure.Code != "api_token_session_required" {
+		t.Errorf("minting with a token got %d %q, want 403 api_token_session_required", status, failure.Code)
+	}
+
+	expired, prefix, hash, err := auth.GenerateAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	yesterday := time.Now().AddDate(0, 0, -1)
+	ts.apiTokens.Create(context.Background(), &store.APIToken{UserID: 1, Name: "old", Prefix: prefix, ExpiresAt: &yesterday}, hash)
+	if status := doJSONWithHeaders(t, http.MethodGet, tokens, 0, withToken(expired), nil, &failure); status != http.StatusUnauthorized || failure.Code != "token_expired" {
+		t.Errorf("the expired token got %d %q, want 401 token_expired", status, failure.Code)
+	}
+	if status := doJSONWithHeaders(t, http.MethodGet, tokens, 0, withToken("gochat_notatoken"), nil, &failure); status != http.StatusUnauthorized || failure.Code != "invalid_token" {
+		t.Errorf("an unknown token got %d %q, want 401 invalid_token", status, failure.Code)
+	}
+
+	zero, tooLong := 0, maxAPITokenLifetimeDays+1
+	for _, tc := range []struct {
+		req  CreateAPITokenRequest
+		code string
+	}{
+		{CreateAPITokenRequest{Name: "  "}, "api_token_name_required"},
+		{CreateAPITokenRequest{Name: strings.Repeat("a", maxAPITokenNameLength+1)}, "api_token_name_too_long"},
+		{CreateAPITokenRequest{Name: "ci", ExpiresInDays: &zero}, "invalid_api_token_expiry"},
+		{CreateAPITokenRequest{Name: "ci", ExpiresInDays: &tooLong}, "invalid_api_token_expiry"},
+	} {
+		if status := doJSON(t, http.MethodPost, tokens, 1, tc.req, &failure); status != http.StatusBadRequest || failure.Code != tc.code {
+			t.Errorf("%+v got %d %q, want 400 %q", tc.req, status, failure.Code, tc.code)
+		}
+	}
+}
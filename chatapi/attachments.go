@@ -0,0 +1,205 @@
+package chatapi
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/drazan344/go-chat/internal/blob"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// maxAttachmentFilename caps stored file names (the column is VARCHAR(255))
+const maxAttachmentFilename = 255
+
+// uploadAttachmentHandler stores an uploaded file
+// The file is streamed to disk while it's hashed; if the same bytes were
+// uploaded before (by anyone), the existing blob is reused and the response
+// says "deduplicated": true, but is otherwise the same
+// POST /v1/attachments
+// Requires authentication
+// Request body: multipart/form-data with the file in a part named "file"
+// Response: {"id": 4, "attachment_id": 2, "filename": "cat.png", "hash": "...", "size": 5120, ...}
+// Images also get "width" and "height", and "thumbnail_pending": true until
+// their thumbnail is made in the background (see thumbnails.go)
+func (app *application) uploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	// The limit applies to the file itself; this just bounds the whole body,
+	// leaving room for the multipart headers
+	maxBytes := app.config.attachments.maxBytes
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64*1024)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "attachment_file_missing")
+		return
+	}
+
+	// Skip any other form fields until the file part
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "attachment_file_missing")
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		app.storeAttachment(w, r, userID, part.FileName(), part)
+		part.Close()
+		return
+	}
+}
+
+// storeAttachment stages, deduplicates and records one uploaded file
+func (app *application) storeAttachment(w http.ResponseWriter, r *http.Request, userID int64, filename string, body io.Reader) {
+	maxBytes := app.config.attachments.maxBytes
+	staged, err := app.blobs.Stage(body, maxBytes)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.Is(err, blob.ErrTooLarge) || errors.As(err, &tooLarge) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, "attachment_too_large", maxBytes)
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "attachment_upload_failed")
+		return
+	}
+	// Left over if the blob already existed or the upload failed
+	defer staged.Discard()
+
+	attachment := &store.Attachment{
+		UserID:      userID,
+		Filename:    cleanAttachmentFilename(filename),
+		Hash:        staged.Hash,
+		Size:        staged.Size,
+		ContentType: staged.ContentType,
+	}
+	readImageSize(staged, attachment)
+
+	place := func() error { return app.blobs.Commit(staged) }
+	if err := app.store.Attachments.Add(r.Context(), attachment, place); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "attachment_upload_failed")
+		return
+	}
+
+	// Only a new blob needs a thumbnail; a reused one has (or is getting) its own
+	if attachment.ThumbnailPending && !attachment.Deduplicated {
+		go app.makeThumbnail(attachment.AttachmentID, attachment.Hash)
+	}
+	app.setThumbnailURL(attachment)
+
+	writeJSON(w, http.StatusCreated, attachment)
+}
+
+// cleanAttachmentFilename reduces a client-supplied file name to something safe to store
+// Any directory part is dropped; the name is only ever shown, never used as a path
+func cleanAttachmentFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == "/" || !utf8.ValidString(name) {
+		return "file"
+	}
+	for len(name) > maxAttachmentFilename {
+		// Trim whole runes so the name stays valid UTF-8
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+// getAttachmentHandler downloads one of the user's uploads
+// GET /v1/attachments/{attachmentID}
+// Requires authentication; owner only
+// Response: the file, with its original name in Content-Disposition
+func (app *application) getAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	attachmentID, err := extractIDFromURL(r, "attachmentID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "attachmentID")
+		return
+	}
+
+	attachment, err := app.store.Attachments.GetByID(r.Context(), attachmentID, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "attachment_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "attachment_lookup_failed")
+		return
+	}
+
+	file, err := app.blobs.Open(attachment.Hash)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "attachment_lookup_failed")
+		return
+	}
+	defer file.Close()
+
+	// The type was sniffed on upload; nosniff stops browsers second-guessing it
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	http.ServeContent(w, r, "", attachment.CreatedAt, file)
+}
+
+// deleteAttachmentHandler deletes one of the user's uploads
+// The stored file is only removed once no other upload shares it
+// DELETE /v1/attachments/{attachmentID}
+// Requires authentication; owner only
+// Response: 204 No Content
+func (app *application) deleteAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	attachmentID, err := extractIDFromURL(r, "attachmentID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "attachmentID")
+		return
+	}
+
+	if err := app.store.Attachments.Remove(r.Context(), attachmentID, userID, app.blobs.Remove); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "attachment_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "attachment_delete_failed")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// storageStatsHandler reports how much space deduplication saves
+// GET /v1/admin/storage/stats
+// Requires the X-Ops-Token header
+// Response: {"blobs": 120, "uploads": 310, "logical_bytes": 91000000, "physical_bytes": 40000000, "saved_bytes": 51000000}
+func (app *application) storageStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := app.store.Attachments.Stats(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "storage_stats_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
@@ -0,0 +1,184 @@
+package chatapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/blob"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// withAttachments stores attachment files under dir, with uploads capped at
+// maxBytes, and turns the ops endpoints on
+func withAttachments(t *testing.T, dir string, maxBytes int64) testOption {
+	t.Helper()
+	blobs, err := blob.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func(app *application) {
+		app.blobs = blobs
+		app.config.attachments = attachmentsConfig{dir: dir, maxBytes: maxBytes}
+		withOps(app)
+	}
+}
+
+// upload posts data as userID's file named filename and decodes the response
+func upload(t *testing.T, server *httptest.Server, userID int64, filename, data string) (int, store.Attachment) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("note", "ignored")
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(part, data)
+	form.Close()
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/v1/attachments", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, err := http.DefaultClient.Do(asUser(t, req, userID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var attachment store.Attachment
+	json.NewDecoder(resp.Body).Decode(&attachment)
+	return resp.StatusCode, attachment
+}
+
+// blobFiles returns the stored blobs under dir, leaving out staged uploads
+func blobFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && path == filepath.Join(dir, "tmp") {
+			return filepath.SkipDir
+		}
+		if !entry.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// TestAttachmentDeduplication has ada and grace upload the same meme: both
+// get their own upload sharing one file, which survives ada deleting hers
+// and goes with grace's
+func TestAttachmentDeduplication(t *testing.T) {
+	ts := newTestStore(t)
+	dir := t.TempDir()
+	server := newTestServer(t, ts, withAttachments(t, dir, 1024))
+
+	status, first := upload(t, server, 1, "meme.png", "same bytes")
+	if status != http.StatusCreated || first.Deduplicated {
+		t.Fatalf("ada's upload got %d %+v, want 201, not deduplicated", status, first)
+	}
+	status, second := upload(t, server, 2, `C:\Users\grace\meme copy.png`, "same bytes")
+	if status != http.StatusCreated || !second.Deduplicated || second.Hash != first.Hash {
+		t.Fatalf("grace's upload got %d %+v, want 201 sharing ada's file", status, second)
+	}
+	if second.Filename != "meme copy.png" {
+		t.Errorf("grace's file is named %q, want the path dropped", second.Filename)
+	}
+	if files := blobFiles(t, dir); len(files) != 1 {
+		t.Errorf("%d files are stored, want 1", len(files))
+	}
+
+	var stats store.StorageStats
+	if status := doJSONWithHeaders(t, http.MethodGet, server.URL+"/v1/admin/storage/stats", 0, map[string]string{opsTokenHeader: "ops-secret"}, nil, &stats); status != http.StatusOK {
+		t.Fatalf("the stats got %d, want 200", status)
+	}
+	if stats.Blobs != 1 || stats.Uploads != 2 || stats.LogicalBytes != 20 || stats.SavedBytes != 10 {
+		t.Errorf("the stats are %+v, want 1 file behind 2 uploads, saving 10 bytes", stats)
+	}
+
+	// Each upload is its owner's alone
+	url := func(a store.Attachment) string { return fmt.Sprintf("%s/v1/attachments/%d", server.URL, a.ID) }
+	if status := doJSON(t, http.MethodDelete, url(first), 2, nil, nil); status != http.StatusNotFound {
+		t.Errorf("grace deleting ada's upload got %d, want 404", status)
+	}
+	if status := doJSON(t, http.MethodDelete, url(first), 1, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("ada's delete got %d, want 204", status)
+	}
+	if files := blobFiles(t, dir); len(files) != 1 {
+		t.Errorf("after ada's delete %d files are stored, want grace's kept", len(files))
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, url(second), nil)
+	resp, err := http.DefaultClient.Do(asUser(t, req, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(data) != "same bytes" || resp.Header.Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("grace's download got %d %q, want 200 with her file, not sniffed", resp.StatusCode, data)
+	}
+
+	if status := doJSON(t, http.MethodDelete, url(second), 2, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("grace's delete got %d, want 204", status)
+	}
+	if files := blobFiles(t, dir); len(files) != 0 {
+		t.Errorf("after the last delete %d files are stored, want none", len(files))
+	}
+}
+
+// TestAttachmentTooLarge uploads one byte over the limit: it's refused and
+// nothing is stored
+func TestAttachmentTooLarge(t *testing.T) {
+	ts := newTestStore(t)
+	dir := t.TempDir()
+	server := newTestServer(t, ts, withAttachments(t, dir, 8))
+
+	if status, _ := upload(t, server, 1, "big.txt", "123456789"); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("the upload got %d, want 413", status)
+	}
+	if status, _ := upload(t, server, 1, "fits.txt", "12345678"); status != http.StatusCreated {
+		t.Errorf("an upload at the limit got %d, want 201", status)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "tmp")); len(entries) != 0 {
+		t.Errorf("%d staged files are left behind", len(entries))
+	}
+}
+
+// TestCleanAttachmentFilename checks client file names lose any path and
+// stay within the column, on a rune boundary
+func TestCleanAttachmentFilename(t *testing.T) {
+	long := strings.Repeat("é", 200) // 400 bytes
+	for _, tc := range []struct {
+		name, want string
+	}{
+		{"cat.png", "cat.png"},
+		{"../../etc/passwd", "passwd"},
+		{`C:\tmp\cat.png`, "cat.png"},
+		{"  ", "file"},
+		{"/", "file"},
+		{"\xff.png", "file"},
+		{long, strings.Repeat("é", 127)},
+	} {
+		if got := cleanAttachmentFilename(tc.name); got != tc.want {
+			t.Errorf("cleanAttachmentFilename(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package chatapi
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/drazan344/go-chat/internal/auth"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// RegisterRequest represents the JSON structure for user registration
+type RegisterRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+
+	// InviteToken is the token from an email invite (optional)
+	// It proves the account owns Email, so the address's open invites are accepted
+	InviteToken string `json:"invite_token,omitempty"`
+}
+
+// LoginRequest represents the JSON structure for user login
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// AuthResponse represents the response after successful login/registration
+// It includes the JWT token and user information
+type AuthResponse struct {
+	Token string      `json:"token"`
+	User  *store.User `json:"user"`
+
+	// Rooms are the default and invited rooms a new account was joined to (registration only)
+	Rooms []*store.Room `json:"rooms,omitempty"`
+
+	// Invites are the outcomes of the email invites accepted at registration
+	Invites []*store.EmailInviteOutcome `json:"invites,omitempty"`
+}
+
+// registerHandler handles user registration
+// POST /v1/auth/register
+// Request body: {"username": "john", "email": "john@example.com", "password": "secret123"}
+// Optional: "invite_token" from an email invite
+// Response: {"token": "jwt...", "user": {...}, "rooms": [{"id": 1, "name": "general", ...}],
+// "invites": [{"room_id": 7, "room_name": "design", "invited_by": 2, "status": "joined"}]}
+// New accounts are joined to every default room, listed in "rooms". With an
+// invite token for the same address, every open invite of that address is
+// accepted: open and invite-only rooms are joined (and listed in "rooms"),
+// approval rooms get a join request ("requested"), and the inviters are told
+func (app *application) registerHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request body
+	var req RegisterRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	// Validate input
+	// Basic validation - in production, you might want more thorough validation
+	if req.Username == "" || req.Email == "" || req.Password == "" {
+		writeError(w, r, http.StatusBadRequest, "registration_fields_required")
+		return
+	}
+
+	// Validate email format (basic check)
+	if !strings.Contains(req.Email, "@") {
+		writeError(w, r, http.StatusBadRequest, "invalid_email_format")
+		return
+	}
+
+	// Validate password strength against the configured policy
+	// Every problem is reported at once, so the user can fix them in one go
+	if !app.checkPassword(w, r, req.Password, auth.PasswordUser{Username: req.Username, Email: req.Email}) {
+		return
+	}
+
+	// Hash the password before storing
+	// NEVER store plain text passwords!
+	hashedPassword, err := auth.HashPassword(req.Password)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "password_processing_failed")
+		return
+	}
+
+	// Create user in database
+	user := &store.User{
+		Username: req.Username,
+		Email:    req.Email,
+		Password: hashedPassword, // Store hashed password, not plain text
+	}
+
+	// Use context from request for database operations
+	// This allows for timeout and cancellation
+	// The user is joined to the default rooms in the same transaction, and with a
+	// valid invite token to the rooms their address was invited to. An expired or
+	// unknown token doesn't stop the registration; it just accepts nothing
+	var inviteHash string
+	if req.InviteToken != "" {
+		inviteHash = auth.HashAPIToken(req.InviteToken)
+	}
+	rooms, invites, err := app.store.Users.CreateWithDefaultRooms(r.Context(), user, inviteHash)
+	if err != nil {
+		// Check if error is due to unique constraint violation (duplicate email/username)
+		if store.IsUniqueViolation(err) {
+			writeError(w, r, http.StatusConflict, "email_or_username_taken")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "user_create_failed")
+		return
+	}
+
+	for _, room := range rooms {
+		app.roomMembersChanged(room.ID)
+	}
+	app.acceptedEmailInvites(r, user, invites)
+
+	// Start a login session and generate a JWT token bound to it
+	token, ok := app.startSession(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	// Clear password before sending response
+	// Even though it's hashed, we don't want to send it to the client
+	user.Password = ""
+
+	// Return success response with token and user info
+	// 201 Created is the appropriate status code for resource creation
+	writeJSON(w, http.StatusCreated, AuthResponse{
+		Token:   token,
+		User:    user,
+		Rooms:   rooms,
+		Invites: invites,
+	})
+}
+
+// loadPasswordDictionary reads the refused passwords from a file (PASSWORD_DICTIONARY)
+func loadPasswordDictionary(path string) (auth.PasswordDictionary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return auth.LoadPasswordDictionary(f)
+}
+
+// checkPassword runs a new password through the password policy
+// It writes a field-level validation error and returns false if the password is refused
+// Every path that sets a password must go through here
+func (app *application) checkPassword(w http.ResponseWriter, r *http.Request, password string, user auth.PasswordUser) bool {
+	violations := app.passwords.PolicyCheck(r.Context(), password, user)
+	if len(violations) == 0 {
+		return true
+	}
+
+	locale := resolveLocale(r)
+	reasons := make([]fieldError, len(violations))
+	for i, v := range violations {
+		reasons[i] = fieldError{Error: translate(locale, v.Reason, v.Args...), Code: v.Reason}
+	}
+	writeFieldErrors(w, r, http.StatusBadRequest, "password_policy_violation", map[string][]fieldError{"password": reasons})
+	return false
+}
+
+// loginHandler handles user authentication
+// POST /v1/auth/login
+// Request body: {"email": "john@example.com", "password": "secret123"}
+// Response: {"token": "jwt...", "user": {...}}
+// With 2FA on: {"two_factor_required": true, "two_factor_token": "jwt...", "expires_at": "..."},
+// to be sent with a code to POST /v1/auth/2fa/verify
+func (app *application) loginHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request body
+	var req LoginRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	// Validate input
+	if req.Email == "" || req.Password == "" {
+		writeError(w, r, http.StatusBadRequest, "credentials_required")
+		return
+	}
+
+	// Find user by email
+	user, err := app.store.Users.GetByEmail(r.Context(), req.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// Don't reveal whether email exists or not for security
+			// Use generic error message
+			writeError(w, r, http.StatusUnauthorized, "invalid_credentials")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return
+	}
+
+	// Compare provided password with hashed password in database
+	// This uses bcrypt's built-in comparison which handles the salt automatically
+	if err := auth.ComparePassword(user.Password, req.Password); err != nil {
+		writeError(w, r, http.StatusUnauthorized, "invalid_credentials")
+		return
+	}
+
+	// With 2FA on, the password only earns an intermediate token; the session
+	// starts once a code is verified (see verifyTwoFactorLoginHandler)
+	twoFactor, err := app.store.TwoFactor.Get(r.Context(), user.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "two_factor_failed")
+		return
+	}
+	if twoFactor.Enabled {
+		app.startTwoFactorLogin(w, r, user.ID)
+		return
+	}
+
+	// Start a login session and generate a JWT token bound to it
+	token, ok := app.startSession(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	// Clear password before sending response
+	user.Password = ""
+
+	// Return success response with token and user info
+	// 200 OK is appropriate for successful login
+	writeJSON(w, http.StatusOK, AuthResponse{
+		Token: token,
+		User:  user,
+	})
+}
+
+// getCurrentUserHandler returns the currently authenticated user's information
+// GET /v1/auth/me
+// Requires authentication (JWT token in Authorization header)
+// Response: {"id": 1, "username": "john", "email": "john@example.com", ...}
+func (app *application) getCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract user ID from context (set by AuthMiddleware)
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	// Retrieve user from database
+	user, err := app.store.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "user_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return
+	}
+
+	// Clear password before sending response
+	user.Password = ""
+
+	// Return user information
+	writeJSON(w, http.StatusOK, user)
+}
@@ -0,0 +1,69 @@
+package chatapi
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/auth"
+)
+
+// TestRegisterPasswordPolicy registers with a password breaking several
+// rules: each is listed under the password field, translated, and the
+// account isn't created (the users store would fail if it were asked)
+func TestRegisterPasswordPolicy(t *testing.T) {
+	server := newTestServer(t, newTestStore(t))
+
+	var failure struct {
+		errorBody
+		Fields map[string][]errorBody `json:"fields"`
+	}
+	body := map[string]string{"username": "grace", "email": "grace@example.com", "password": "grace"}
+	status := doJSONWithHeaders(t, http.MethodPost, server.URL+"/v1/auth/register", 0, map[string]string{"Accept-Language": "de"}, body, &failure)
+	if status != http.StatusBadRequest || failure.Code != "password_policy_violation" {
+		t.Fatalf("registering got %d %+v, want 400 password_policy_violation", status, failure.errorBody)
+	}
+
+	var codes []string
+	for _, reason := range failure.Fields["password"] {
+		codes = append(codes, reason.Code)
+		if reason.Error == "" || reason.Error == translate("en", reason.Code, auth.DefaultMinPasswordLength) {
+			t.Errorf("%s has message %q, want it in German", reason.Code, reason.Error)
+		}
+	}
+	want := []string{auth.ReasonTooShort, auth.ReasonTooWeak, auth.ReasonPersonal}
+	if !slices.Equal(codes, want) {
+		t.Errorf("the password broke %v, want %v", codes, want)
+	}
+}
+
+// TestRegisterPasswordClassesAndDictionary lists the configured rules'
+// violations along with the built-in ones
+func TestRegisterPasswordClassesAndDictionary(t *testing.T) {
+	server := newTestServer(t, newTestStore(t), func(app *application) {
+		app.passwords = &auth.PasswordPolicy{
+			RequiredClasses: []string{auth.ClassUpper, auth.ClassSymbol},
+			Dictionary:      auth.PasswordDictionary{"correcthorse42": {}},
+		}
+	})
+
+	var failure struct {
+		errorBody
+		Fields map[string][]errorBody `json:"fields"`
+	}
+	body := map[string]string{"username": "grace", "email": "grace@example.com", "password": "correcthorse42"}
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/auth/register", 0, body, &failure); status != http.StatusBadRequest {
+		t.Fatalf("registering got %d, want 400", status)
+	}
+	var codes []string
+	for _, reason := range failure.Fields["password"] {
+		codes = append(codes, reason.Code)
+		if reason.Error != translate("en", reason.Code) {
+			t.Errorf("%s has message %q", reason.Code, reason.Error)
+		}
+	}
+	want := []string{auth.ReasonNeedsUpper, auth.ReasonNeedsSymbol, auth.ReasonCommon}
+	if !slices.Equal(codes, want) {
+		t.Errorf("the password broke %v, want %v", codes, want)
+	}
+}
@@ -0,0 +1,128 @@
+package chatapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/gorilla/websocket"
+)
+
+// TestAutoJoin connects to rooms the user isn't in: with ?auto_join=true an
+// open room is joined and the connection upgraded, even when two connects
+// race; rooms that need approval or an invite, and connects without the
+// parameter, are refused before the upgrade
+func TestAutoJoin(t *testing.T) {
+	ts := newTestStore(t)
+	for id := int64(2); id <= 4; id++ {
+		ts.users.add(&store.User{ID: id, Username: "user" + strconv.FormatInt(id, 10)})
+	}
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 9, JoinPolicy: store.JoinPolicyOpen})
+	ts.rooms.add(&store.Room{ID: 2, Name: "staff", CreatedBy: 9, JoinPolicy: store.JoinPolicyApproval})
+	ts.rooms.add(&store.Room{ID: 3, Name: "secret", CreatedBy: 9, JoinPolicy: store.JoinPolicyInvite})
+	server := newTestServer(t, ts)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// userHeader authenticates a handshake as userID
+	userHeader := func(userID int64) http.Header {
+		return asUser(t, httptest.NewRequest(http.MethodGet, wsURL, nil), userID).Header
+	}
+	// dial opens a WebSocket to a room with header, closes it at once if it
+	// opened, and returns the handshake's response
+	dial := func(header http.Header, roomID int64, query string) (*http.Response, error) {
+		url := wsURL + "/v1/rooms/" + strconv.FormatInt(roomID, 10) + "/ws" + query
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if conn != nil {
+			conn.Close()
+		}
+		if resp == nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+	// connect dials and returns the handshake's status and, when it was
+	// refused, the error
+	connect := func(userID, roomID int64, query string) (int, errorBody) {
+		t.Helper()
+		resp, err := dial(userHeader(userID), roomID, query)
+		if err != nil {
+			t.Fatalf("connecting to room %d: %v", roomID, err)
+		}
+		defer resp.Body.Close()
+		var failure errorBody
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			if err := json.NewDecoder(resp.Body).Decode(&failure); err != nil {
+				t.Fatalf("decoding the %d response: %v", resp.StatusCode, err)
+			}
+		}
+		return resp.StatusCode, failure
+	}
+
+	for _, c := range []struct {
+		name   string
+		roomID int64
+		query  string
+		status int
+		code   string
+	}{
+		{"without auto_join", 1, "", http.StatusForbidden, "membership_required_connect"},
+		{"auto_join=false", 1, "?auto_join=false", http.StatusForbidden, "membership_required_connect"},
+		{"approval room", 2, "?auto_join=true", http.StatusForbidden, "auto_join_requires_approval"},
+		{"invite-only room", 3, "?auto_join=true", http.StatusForbidden, "auto_join_invite_only"},
+		{"missing room", 4, "?auto_join=true", http.StatusNotFound, "room_not_found"},
+	} {
+		status, failure := connect(2, c.roomID, c.query)
+		if status != c.status || failure.Code != c.code {
+			t.Errorf("%s: got %d %q, want %d %s", c.name, status, failure.Code, c.status, c.code)
+		}
+	}
+	for roomID := int64(1); roomID <= 3; roomID++ {
+		if member, _ := ts.roomMembers.IsUserInRoom(context.Background(), roomID, 2); member {
+			t.Errorf("a refused connect made user 2 a member of room %d", roomID)
+		}
+	}
+
+	if status, failure := connect(2, 1, "?auto_join=true"); status != http.StatusSwitchingProtocols {
+		t.Fatalf("auto-joining the open room got %d %q, want 101", status, failure.Code)
+	}
+	if member, _ := ts.roomMembers.IsUserInRoom(context.Background(), 1, 2); !member {
+		t.Error("auto-joining didn't make user 2 a member")
+	}
+	// Now a member, so it connects without the parameter too
+	if status, failure := connect(2, 1, ""); status != http.StatusSwitchingProtocols {
+		t.Errorf("a member connecting got %d %q, want 101", status, failure.Code)
+	}
+
+	// Two devices auto-joining at once both connect (that only one adds the
+	// member is the store's job; see internal/store/room_members_test.go)
+	header := userHeader(3)
+	var wg sync.WaitGroup
+	resps := make([]*http.Response, 2)
+	errs := make([]error, 2)
+	for i := range resps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resps[i], errs[i] = dial(header, 1, "?auto_join=true")
+		}()
+	}
+	wg.Wait()
+	for i, resp := range resps {
+		if errs[i] != nil {
+			t.Fatalf("concurrent connect %d: %v", i, errs[i])
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Errorf("concurrent connect %d got %d, want 101", i, resp.StatusCode)
+		}
+	}
+	if member, _ := ts.roomMembers.IsUserInRoom(context.Background(), 1, 3); !member {
+		t.Error("the concurrent auto-joins didn't make user 3 a member")
+	}
+}
@@ -0,0 +1,217 @@
+package chatapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/sysmsg"
+	"github.com/drazan344/go-chat/internal/websocket"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// maxBulkMembers caps how many users one bulk request may name
+const maxBulkMembers = 100
+
+// bulkNotFound is the outcome for entries that match no user
+const bulkNotFound = "not_found"
+
+// BulkMembersRequest names the users to add or remove, by username and/or ID
+type BulkMembersRequest struct {
+	Usernames []string `json:"usernames"`
+	UserIDs   []int64  `json:"user_ids"`
+}
+
+// BulkMemberResult is the outcome for one entry of a bulk request
+// Exactly one of Username and UserID is echoed from the request; the other is
+// filled in once the user is found
+type BulkMemberResult struct {
+	Username string `json:"username,omitempty"`
+	UserID   int64  `json:"user_id,omitempty"`
+	Status   string `json:"status"` // See store.Bulk* and bulkNotFound
+}
+
+// bulkAddMembersHandler adds several users to a room at once
+// Each entry gets its own result; one failing entry doesn't stop the others
+// POST /v1/rooms/{roomID}/members/bulk
+// Requires authentication and manage_members (by default the owner and admins)
+// Request body: {"usernames": ["jane", "bob"], "user_ids": [12]}
+// Response: {"results": [{"username": "jane", "user_id": 5, "status": "added"}, {"username": "bob", "status": "not_found"}, ...]}
+func (app *application) bulkAddMembersHandler(w http.ResponseWriter, r *http.Request) {
+	app.bulkMembers(w, r, true)
+}
+
+// bulkRemoveMembersHandler removes several users from a room at once
+// Removed users are disconnected from the room, and the room is told with
+// "member_removed" frames and system messages; admins can't be removed this way
+// POST /v1/rooms/{roomID}/members/bulk-remove
+// Requires authentication and manage_members
+// Request body: {"usernames": ["jane"], "user_ids": [12]}
+// Response: {"results": [{"username": "jane", "user_id": 5, "status": "removed"}, ...]}
+func (app *application) bulkRemoveMembersHandler(w http.ResponseWriter, r *http.Request) {
+	app.bulkMembers(w, r, false)
+}
+
+// bulkMembers is the shared implementation of bulk add and remove
+func (app *application) bulkMembers(w http.ResponseWriter, r *http.Request, add bool) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	var req BulkMembersRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	entries := len(req.Usernames) + len(req.UserIDs)
+	if entries == 0 {
+		writeError(w, r, http.StatusBadRequest, "bulk_members_empty")
+		return
+	}
+	if entries > maxBulkMembers {
+		writeError(w, r, http.StatusBadRequest, "bulk_members_too_many", maxBulkMembers)
+		return
+	}
+
+	room, err := app.store.Rooms.GetByID(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_verify_failed")
+		return
+	}
+	if !app.requireRoomPermission(w, r, room.ID, userID, store.CapManageMembers) {
+		return
+	}
+
+	// Resolve every name and ID in one query
+	users, err := app.store.Users.GetByUsernames(r.Context(), req.Usernames, req.UserIDs)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return
+	}
+	byName := make(map[string]*store.User, len(users))
+	byID := make(map[int64]*store.User, len(users))
+	for _, u := range users {
+		byName[u.Username] = u
+		byID[u.ID] = u
+	}
+
+	// One result per entry, in request order; found users are collected once each
+	results := make([]*BulkMemberResult, 0, entries)
+	userIDs := make([]int64, 0, len(users))
+	seen := make(map[int64]bool, len(users))
+	found := func(result *BulkMemberResult, u *store.User) {
+		if u == nil {
+			result.Status = bulkNotFound
+			return
+		}
+		result.Username, result.UserID = u.Username, u.ID
+		if !seen[u.ID] {
+			seen[u.ID] = true
+			userIDs = append(userIDs, u.ID)
+		}
+	}
+	for _, name := range req.Usernames {
+		result := &BulkMemberResult{Username: name}
+		found(result, byName[name])
+		results = append(results, result)
+	}
+	for _, id := range req.UserIDs {
+		result := &BulkMemberResult{UserID: id}
+		found(result, byID[id])
+		results = append(results, result)
+	}
+
+	var outcomes map[int64]string
+	if len(userIDs) > 0 {
+		if add {
+			outcomes, err = app.store.RoomMembers.AddMembers(r.Context(), roomID, userIDs, userID)
+		} else {
+			outcomes, err = app.store.RoomMembers.RemoveMembers(r.Context(), roomID, userIDs, userID)
+		}
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				writeError(w, r, http.StatusNotFound, "room_not_found")
+				return
+			}
+			writeError(w, r, http.StatusInternalServerError, "bulk_members_failed")
+			return
+		}
+	}
+	for _, result := range results {
+		if result.Status == "" {
+			result.Status = outcomes[result.UserID]
+		}
+	}
+
+	// Notify only after the transaction committed, in one batch per kind
+	// (one round through the hub's shards, not one per user)
+	changed := make([]int64, 0, len(outcomes))
+	for id, outcome := range outcomes {
+		if outcome == store.BulkAdded || outcome == store.BulkRemoved {
+			changed = append(changed, id)
+		}
+	}
+	if len(changed) > 0 {
+		app.roomMembersChanged(roomID)
+		if add {
+			app.hub.NotifyUsers(changed, &websocket.Message{
+				Message: wire.Message{
+					RoomID:  roomID,
+					Content: "you were added to " + room.Name,
+					Type:    "member_added",
+				},
+			})
+		} else {
+			app.announceRemoved(r.Context(), roomID, changed, results)
+		}
+	}
+
+	type response struct {
+		Results []*BulkMemberResult `json:"results"`
+	}
+	writeJSON(w, http.StatusOK, response{Results: results})
+}
+
+// announceRemoved tells a room who was removed from it, as one action (a
+// shared txn): the removed users get "removed_from_room" and are
+// disconnected, and everyone left gets a "member_removed" frame and a
+// system message for each
+func (app *application) announceRemoved(ctx context.Context, roomID int64, removed []int64, results []*BulkMemberResult) {
+	usernames := make(map[int64]string, len(results))
+	for _, result := range results {
+		usernames[result.UserID] = result.Username
+	}
+
+	txn := newTxn()
+	app.hub.RemoveUsers(roomID, removed, txn)
+	for _, id := range removed {
+		app.hub.Announce(&websocket.Message{
+			Message: wire.Message{
+				RoomID:   roomID,
+				UserID:   id,
+				Username: usernames[id],
+				Content:  usernames[id] + " was removed from the room",
+				Type:     "member_removed",
+				Txn:      txn,
+			},
+		})
+		if _, err := app.postSystemMessage(ctx, roomID, sysmsg.Removed(usernames[id]), txn); err != nil {
+			log.Printf("Failed to post the removal of user %d from room %d: %v", id, roomID, err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package chatapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+	ws "github.com/drazan344/go-chat/internal/websocket"
+	"github.com/gorilla/websocket"
+)
+
+// newBulkStore creates a testStore where ada (1) is admin of room 1, which
+// linus (3) is already in, and ken (4) is in as many rooms as allowed
+// grace (2) is only in room 9
+func newBulkStore(t *testing.T) *testStore {
+	t.Helper()
+	ts := newTestStore(t)
+	for id, name := range map[int64]string{1: "ada", 2: "grace", 3: "linus", 4: "ken"} {
+		ts.users.add(&store.User{ID: id, Username: name})
+	}
+	ts.rooms.add(&store.Room{ID: 1, Name: "lobby", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 3, store.RoomRoleMember)
+	ts.rooms.add(&store.Room{ID: 9, Name: "garden", CreatedBy: 2})
+	ts.roomMembers.add(9, 2, store.RoomRoleAdmin)
+	for roomID := int64(10); roomID < 10+int64(testLimits.MaxRoomsPerUser); roomID++ {
+		ts.roomMembers.add(roomID, 4, store.RoomRoleMember)
+	}
+	return ts
+}
+
+// bulkResults posts a bulk request as userID and returns the results, or
+// fails the test if the status isn't want
+func bulkResults(t *testing.T, url string, userID int64, body BulkMembersRequest, want int) []BulkMemberResult {
+	t.Helper()
+	var raw json.RawMessage
+	if status := doJSON(t, http.MethodPost, url, userID, body, &raw); status != want {
+		t.Fatalf("POST %s got %d %s, want %d", url, status, raw, want)
+	}
+	var response struct {
+		Results []BulkMemberResult `json:"results"`
+	}
+	json.Unmarshal(raw, &response)
+	return response.Results
+}
+
+// TestBulkAddMembers adds a mix of names and IDs: every entry gets its own
+// status in request order, and only the users actually added are notified
+func TestBulkAddMembers(t *testing.T) {
+	ts := newBulkStore(t)
+	server := newTestServer(t, ts)
+	grace := dialRoom(t, server, 9, 2)
+	readFrame(t, grace, "join")
+
+	url := server.URL + "/v1/rooms/1/members/bulk"
+	got := bulkResults(t, url, 1, BulkMembersRequest{
+		Usernames: []string{"grace", "nobody", "linus"},
+		UserIDs:   []int64{4, 99},
+	}, http.StatusOK)
+	want := []BulkMemberResult{
+		{Username: "grace", UserID: 2, Status: store.BulkAdded},
+		{Username: "nobody", Status: bulkNotFound},
+		{Username: "linus", UserID: 3, Status: store.BulkAlreadyMember},
+		{Username: "ken", UserID: 4, Status: store.BulkQuotaExceeded},
+		{UserID: 99, Status: bulkNotFound},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("the results are %+v, want %+v", got, want)
+	}
+
+	if frame := readFrame(t, grace, "member_added"); frame.RoomID != 1 {
+		t.Errorf("grace was told about room %d, want 1", frame.RoomID)
+	}
+	if in, _ := ts.roomMembers.IsUserInRoom(t.Context(), 1, 2); !in {
+		t.Error("grace isn't in the room")
+	}
+}
+
+// TestBulkMembersValidation checks who may send a bulk request and how
+// many entries it may have
+func TestBulkMembersValidation(t *testing.T) {
+	server := newTestServer(t, newBulkStore(t))
+	tooMany := make([]int64, maxBulkMembers+1)
+	for i := range tooMany {
+		tooMany[i] = int64(i + 1)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		path   string
+		userID int64
+		body   BulkMembersRequest
+		want   int
+		code   string
+	}{
+		{"not an admin", "/v1/rooms/1/members/bulk", 3, BulkMembersRequest{UserIDs: []int64{2}}, http.StatusForbidden, ""},
+		{"no entries", "/v1/rooms/1/members/bulk", 1, BulkMembersRequest{}, http.StatusBadRequest, "bulk_members_empty"},
+		{"too many", "/v1/rooms/1/members/bulk-remove", 1, BulkMembersRequest{UserIDs: tooMany}, http.StatusBadRequest, "bulk_members_too_many"},
+		{"no such room", "/v1/rooms/7/members/bulk", 1, BulkMembersRequest{UserIDs: []int64{2}}, http.StatusNotFound, "room_not_found"},
+	} {
+		var failure errorBody
+		status := doJSON(t, http.MethodPost, server.URL+tc.path, tc.userID, tc.body, &failure)
+		if status != tc.want || (tc.code != "" && failure.Code != tc.code) {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, status, failure.Code, tc.want, tc.code)
+		}
+	}
+}
+
+// TestBulkRemoveMembers removes a member, an admin and people who aren't
+// there: only the member goes, and their connection is closed
+func TestBulkRemoveMembers(t *testing.T) {
+	ts := newBulkStore(t)
+	server := newTestServer(t, ts)
+	linus := dialRoom(t, server, 1, 3)
+	readFrame(t, linus, "join")
+
+	got := bulkResults(t, server.URL+"/v1/rooms/1/members/bulk-remove", 1, BulkMembersRequest{
+		Usernames: []string{"linus", "ada", "grace"},
+		UserIDs:   []int64{99},
+	}, http.StatusOK)
+	want := []BulkMemberResult{
+		{Username: "linus", UserID: 3, Status: store.BulkRemoved},
+		{Username: "ada", UserID: 1, Status: store.BulkAdminProtected},
+		{Username: "grace", UserID: 2, Status: store.BulkNotMember},
+		{UserID: 99, Status: bulkNotFound},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("the results are %+v, want %+v", got, want)
+	}
+
+	readFrame(t, linus, "removed_from_room")
+	var closeErr *websocket.CloseError
+	if _, _, err := linus.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != ws.CloseRemovedFromRoom {
+		t.Errorf("after the removal linus's connection got %v, want close code %d", err, ws.CloseRemovedFromRoom)
+	}
+	if status := dialStatus(t, server, 1, 3); status != http.StatusForbidden {
+		t.Errorf("linus reconnecting got %d, want 403", status)
+	}
+}
@@ -0,0 +1,218 @@
+package chatapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/drazan344/go-chat/internal/flags"
+	"github.com/drazan344/go-chat/internal/oauth"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/websocket"
+)
+
+// capabilitiesMaxAge is how long clients and proxies may use the capabilities
+// document before revalidating it; a reload shows up within it
+const capabilitiesMaxAge = "60"
+
+// Capabilities is the server's effective limits and features, for clients
+// that would otherwise hardcode them
+// Every value is read from the setting the enforcing code reads, so it's
+// never out of date with what the server does
+type Capabilities struct {
+	Messages   MessageCapabilities   `json:"messages"`
+	Uploads    UploadCapabilities    `json:"uploads"`
+	WebSocket  WebSocketCapabilities `json:"websocket"`
+	Rooms      RoomCapabilities      `json:"rooms"`
+	RateLimits map[string]RateLimit  `json:"rate_limits"`
+
+	// Features says what this deployment has turned on; reactions, threads
+	// and polls are listed as off since the server has none
+	Features map[string]bool `json:"features"`
+
+	// FeatureFlags are the flags' server-wide defaults; users and rooms can
+	// be switched differently (see feature_flags.go)
+	FeatureFlags map[string]bool `json:"feature_flags"`
+}
+
+// MessageCapabilities are the limits on chat messages, over REST and the socket
+type MessageCapabilities struct {
+	MaxLength       int            `json:"max_length"`         // Text and markdown, in runes
+	MaxLengthByType map[string]int `json:"max_length_by_type"` // In runes
+	ContentTypes    []string       `json:"content_types"`
+	CodeLanguages   []string       `json:"code_languages"`
+	OversizePolicy  string         `json:"oversize_policy"` // "reject" or "truncate"
+	MaxBodyBytes    int64          `json:"max_body_bytes"`  // Of POST /v1/rooms/{id}/messages
+}
+
+// UploadCapabilities are the limits on attachments; any file type is accepted
+type UploadCapabilities struct {
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// WebSocketCapabilities describe the WebSocket protocol (see protocol.go)
+type WebSocketCapabilities struct {
+	ProtocolVersions []int    `json:"protocol_versions"`
+	Subprotocols     []string `json:"subprotocols"` // Sec-WebSocket-Protocol entries, one per version
+	Features         []string `json:"features"`     // As announced in the hello frame
+	MaxFrameBytes    int      `json:"max_frame_bytes"`
+}
+
+// RoomCapabilities are the server-wide room limits and defaults
+// Overrides lists the room fields that carry a room's own value in place of
+// these, so clients read those from the room object
+type RoomCapabilities struct {
+	MaxMembers              int      `json:"max_members"`
+	MaxRoomsPerUser         int      `json:"max_rooms_per_user"`
+	MaxOwnedRooms           int      `json:"max_owned_rooms"` // 0 for no limit
+	MaxTags                 int      `json:"max_tags"`
+	JoinPolicies            []string `json:"join_policies"`
+	PostPolicies            []string `json:"post_policies"`
+	DefaultLanguage         string   `json:"default_language"`
+	MessageRetentionSeconds int64    `json:"message_retention_seconds"` // 0 keeps messages forever
+	MinRetentionSeconds     int64    `json:"min_retention_seconds"`
+	MaxRetentionSeconds     int64    `json:"max_retention_seconds"` // 0 for no maximum
+	RestoreWindowSeconds    int64    `json:"restore_window_seconds"`
+	Overrides               []string `json:"overrides"`
+}
+
+// RateLimit is a limit of Limit actions per window; a Limit of 0 is no limit
+type RateLimit struct {
+	Limit         int   `json:"limit"`
+	WindowSeconds int64 `json:"window_seconds"`
+}
+
+// roomOverrides are the room fields that override a server-wide setting
+var roomOverrides = []string{
+	"max_members", "effective_retention_seconds", "duplicate_limit_enabled",
+	"content_filter_enabled", "post_policy", "quiet_hours", "language",
+}
+
+// capabilities assembles the capabilities document from the current settings
+func (app *application) capabilities(ctx context.Context) *Capabilities {
+	rc := app.runtime.Load()
+	lengths := app.hub.LengthPolicy()
+	tunables := app.hub.Tunables()
+	limits := app.config.limits
+
+	byType := make(map[string]int)
+	for _, contentType := range content.Types() {
+		byType[contentType] = lengths.Limit(contentType)
+	}
+	oversize, _ := content.ParseOversize(lengths.Oversize)
+
+	versions := websocket.ProtocolVersions()
+	subprotocols := make([]string, len(versions))
+	for i, v := range versions {
+		subprotocols[i] = websocket.Subprotocol(v)
+	}
+
+	featureFlags := make(map[string]bool, len(flags.Known))
+	for name := range flags.Known {
+		featureFlags[name] = app.flags.Enabled(ctx, name, 0, 0)
+	}
+
+	return &Capabilities{
+		Messages: MessageCapabilities{
+			MaxLength:       lengths.Limit(content.TypeText),
+			MaxLengthByType: byType,
+			ContentTypes:    content.Types(),
+			CodeLanguages:   content.Languages(),
+			OversizePolicy:  oversize,
+			MaxBodyBytes:    messageBodyLimit(lengths),
+		},
+		Uploads: UploadCapabilities{MaxBytes: app.config.attachments.maxBytes},
+		WebSocket: WebSocketCapabilities{
+			ProtocolVersions: versions,
+			Subprotocols:     subprotocols,
+			Features:         websocket.Capabilities(),
+			MaxFrameBytes:    app.hub.MaxFrameBytes(),
+		},
+		Rooms: RoomCapabilities{
+			MaxMembers:              limits.maxRoomMembers,
+			MaxRoomsPerUser:         limits.maxRoomsPerUser,
+			MaxOwnedRooms:           limits.maxOwnedRooms,
+			MaxTags:                 store.MaxRoomTags,
+			JoinPolicies:            []string{store.JoinPolicyOpen, store.JoinPolicyApproval, store.JoinPolicyInvite},
+			PostPolicies:            []string{store.PostPolicyEveryone, store.PostPolicyAdminsOnly},
+			DefaultLanguage:         app.config.rooms.defaultLanguage,
+			MessageRetentionSeconds: int64(app.config.rooms.messageRetention.Seconds()),
+			MinRetentionSeconds:     int64(app.config.rooms.minRetention.Seconds()),
+			MaxRetentionSeconds:     int64(app.config.rooms.maxRetention.Seconds()),
+			RestoreWindowSeconds:    int64(app.config.rooms.restoreWindow.Seconds()),
+			Overrides:               roomOverrides,
+		},
+		RateLimits: map[string]RateLimit{
+			"user_search":          {rc.UserSearchRateLimit, int64(rc.UserSearchRateWindow.Seconds())},
+			"api_token_messages":   {rc.APITokenMessageRateLimit, int64(rc.APITokenMessageRateWindow.Seconds())},
+			"websocket_handshakes": {rc.WSHandshakeRateLimit, int64(rc.WSHandshakeRateWindow.Seconds())},
+			"duplicate_messages":   {max(tunables.DuplicateLimit, 0), int64(tunables.DuplicateWindow.Seconds())},
+			"room_mentions":        {1, int64(websocket.RoomMentionInterval().Seconds())},
+			"room_creates":         {max(limits.roomCreatesPerDay, 0), int64(roomCreationWindow.Seconds())},
+			"two_factor_attempts":  {twoFactorAttempts, int64(twoFactorAttemptWindow.Seconds())},
+			"password_resets":      {passwordResetRequests, int64(passwordResetRequestWindow.Seconds())},
+		},
+		Features: map[string]bool{
+			"attachments":       true,
+			"two_factor":        true,
+			"translation":       app.translator != nil,
+			"push":              app.config.push.provider != "",
+			"email_invites":     app.mailer != nil,
+			"password_reset":    app.mailer != nil,
+			"oauth_google":      app.oauth[oauth.Google] != nil,
+			"oauth_github":      app.oauth[oauth.GitHub] != nil,
+			"content_filter":    app.wordlist != nil,
+			"moderation_hooks":  len(app.config.moderation.hookHosts) > 0,
+			"outgoing_webhooks": app.outgoing != nil,
+			"reactions":         false,
+			"threads":           false,
+			"polls":             false,
+		},
+		FeatureFlags: featureFlags,
+	}
+}
+
+// capabilitiesHandler returns the capabilities document
+// Clients read it at startup instead of hardcoding limits; it changes when
+// the configuration is reloaded, so the ETag tells them whether it did
+// GET /v1/capabilities
+// No authentication required; answers 304 to a matching If-None-Match
+// Response: {"messages": {"max_length": 4000, "content_types": ["code", "markdown", "text"], ...},
+// "uploads": {"max_bytes": 10485760}, "websocket": {"protocol_versions": [1, 2], ...},
+// "rooms": {...}, "rate_limits": {"user_search": {"limit": 30, "window_seconds": 60}, ...},
+// "features": {"translation": false, ...}, "feature_flags": {"persist_join_leave": false, ...}}
+func (app *application) capabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := json.Marshal(app.capabilities(r.Context()))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "capabilities_failed")
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age="+capabilitiesMaxAge)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(body, '\n'))
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, or is *
+// Weak tags match too: the document is the same either way
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
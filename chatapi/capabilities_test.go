@@ -0,0 +1,133 @@
+package chatapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// getCapabilities fetches the capabilities document, sending etag as
+// If-None-Match when it's set; the document is nil for a 304
+func getCapabilities(t *testing.T, serverURL, etag string) (int, *Capabilities, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, serverURL+"/v1/capabilities", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil, resp.Header.Get("ETag")
+	}
+	var caps Capabilities
+	if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, &caps, resp.Header.Get("ETag")
+}
+
+// TestCapabilities reads the document without signing in: it has the
+// built-in limits, and asking again with its ETag gets a 304
+func TestCapabilities(t *testing.T) {
+	server, app := newTestServerApp(t, newReloadStore(t), withReloader(filepath.Join(t.TempDir(), ".env")))
+	app.config.attachments.maxBytes = 5 << 20
+
+	status, caps, etag := getCapabilities(t, server.URL, "")
+	if status != http.StatusOK || etag == "" {
+		t.Fatalf("reading the capabilities got %d with ETag %q, want 200 with one", status, etag)
+	}
+	if caps.Messages.MaxLength != 4000 || caps.Messages.MaxLengthByType["code"] != 10000 || caps.Messages.OversizePolicy != "reject" {
+		t.Errorf("the message limits are %+v, want 4000 runes, 10000 for code, rejecting longer ones", caps.Messages)
+	}
+	if !slices.Equal(caps.Messages.ContentTypes, []string{"code", "markdown", "text"}) {
+		t.Errorf("the content types are %v", caps.Messages.ContentTypes)
+	}
+	if caps.Uploads.MaxBytes != 5<<20 {
+		t.Errorf("uploads are limited to %d bytes, want %d", caps.Uploads.MaxBytes, 5<<20)
+	}
+	if !slices.Equal(caps.WebSocket.ProtocolVersions, []int{1, 2}) || !slices.Equal(caps.WebSocket.Subprotocols, []string{"gochat.v1", "gochat.v2"}) || caps.WebSocket.MaxFrameBytes != 1<<20 {
+		t.Errorf("the WebSocket capabilities are %+v", caps.WebSocket)
+	}
+	if search := caps.RateLimits["user_search"]; search.Limit != 0 {
+		t.Errorf("the user search limit is %+v, want none, as the test app has it", search)
+	}
+	if caps.Features["threads"] || !caps.Features["attachments"] {
+		t.Errorf("the features are %v, want attachments on and threads off", caps.Features)
+	}
+	if _, ok := caps.FeatureFlags["persist_join_leave"]; !ok {
+		t.Errorf("the feature flags are %v, want every known flag", caps.FeatureFlags)
+	}
+
+	if status, _, _ := getCapabilities(t, server.URL, etag); status != http.StatusNotModified {
+		t.Errorf("revalidating got %d, want 304", status)
+	}
+	if status, _, _ := getCapabilities(t, server.URL, `"stale", W/`+etag); status != http.StatusNotModified {
+		t.Errorf("revalidating with a list of weak tags got %d, want 304", status)
+	}
+}
+
+// TestCapabilitiesFollowConfig lowers the message and frame limits with a
+// reload: the document advertises the new values under a new ETag, and the
+// REST and WebSocket send paths enforce exactly those
+func TestCapabilitiesFollowConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	server := newTestServer(t, newReloadStore(t), withReloader(path))
+	_, _, before := getCapabilities(t, server.URL, "")
+
+	dotenv := "MESSAGE_MAX_LENGTH=10\nMESSAGE_MAX_CODE_LENGTH=20\nWS_MAX_FRAME_BYTES=4096\n"
+	if status := reload(t, server.URL, path, dotenv, nil); status != http.StatusOK {
+		t.Fatalf("reloading got %d, want 200", status)
+	}
+	status, caps, after := getCapabilities(t, server.URL, before)
+	if status != http.StatusOK || after == before {
+		t.Fatalf("revalidating after the reload got %d with ETag %q, want 200 with a new one", status, after)
+	}
+	if caps.Messages.MaxLength != 10 || caps.Messages.MaxLengthByType["code"] != 20 || caps.WebSocket.MaxFrameBytes != 4096 {
+		t.Fatalf("the document has %+v and a %d byte frame limit, want 10, 20 for code and 4096", caps.Messages, caps.WebSocket.MaxFrameBytes)
+	}
+
+	messages := server.URL + "/v1/rooms/1/messages"
+	limit := caps.Messages.MaxLength
+	var failure errorBody
+	if status := doJSON(t, http.MethodPost, messages, 1, SendMessageRequest{Content: strings.Repeat("a", limit+1)}, &failure); status != http.StatusUnprocessableEntity || failure.Code != "message_too_long" {
+		t.Errorf("a message one over the advertised limit got %d %q, want 422 message_too_long", status, failure.Code)
+	}
+	if status := doJSON(t, http.MethodPost, messages, 1, SendMessageRequest{Content: strings.Repeat("a", limit)}, nil); status != http.StatusCreated {
+		t.Errorf("a message at the advertised limit got %d, want 201", status)
+	}
+	failure = errorBody{}
+	oversized := SendMessageRequest{Content: strings.Repeat("a", int(caps.Messages.MaxBodyBytes))}
+	if status := doJSON(t, http.MethodPost, messages, 1, oversized, &failure); status != http.StatusRequestEntityTooLarge || failure.Code != "request_body_too_large" {
+		t.Errorf("a body over the advertised size got %d %q, want 413 request_body_too_large", status, failure.Code)
+	}
+
+	conn := dialRoom(t, server, 1, 1)
+	readFrame(t, conn, "join")
+	if err := conn.WriteMessage(websocket.TextMessage, make([]byte, caps.WebSocket.MaxFrameBytes+1)); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseMessageTooBig || !strings.Contains(closeErr.Text, fmt.Sprint(caps.WebSocket.MaxFrameBytes)) {
+			t.Errorf("a frame over the advertised limit got %v, want close 1009 naming the limit", err)
+		}
+		break
+	}
+}
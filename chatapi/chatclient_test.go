@@ -0,0 +1,252 @@
+package chatapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/auth"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+	ws "github.com/drazan344/go-chat/internal/websocket"
+	"github.com/drazan344/go-chat/pkg/chatclient"
+	"github.com/drazan344/go-chat/pkg/wire"
+	"github.com/gorilla/websocket"
+)
+
+// cuttableNetwork dials the client's WebSockets over connections it can
+// cut, and refuses new ones while it's down
+type cuttableNetwork struct {
+	mu    sync.Mutex
+	conns []net.Conn
+	dials int
+	down  bool
+}
+
+func (n *cuttableNetwork) dialer() *websocket.Dialer {
+	return &websocket.Dialer{NetDialContext: n.dial, HandshakeTimeout: 5 * time.Second}
+}
+
+func (n *cuttableNetwork) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.dials++
+	if n.down {
+		return nil, errors.New("network down")
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+	if err == nil {
+		n.conns = append(n.conns, conn)
+	}
+	return conn, err
+}
+
+// cut drops every connection and refuses new ones until restore
+func (n *cuttableNetwork) cut() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.down = true
+	for _, conn := range n.conns {
+		conn.Close()
+	}
+	n.conns = nil
+}
+
+func (n *cuttableNetwork) restore() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.down = false
+}
+
+// chatClientTest is user 1 on a chatclient.Client, in room 1 of a test
+// server whose owner is user 2
+type chatClientTest struct {
+	app     *application
+	server  *httptest.Server
+	network *cuttableNetwork
+	client  *chatclient.Client
+
+	mu       sync.Mutex
+	states   []chatclient.StateChange
+	messages []*wire.Message // Chat messages received, system messages aside
+}
+
+func newChatClientTest(t *testing.T) *chatClientTest {
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	ts.rooms.add(&store.Room{ID: 1, Name: "lobby", CreatedBy: 2, JoinPolicy: store.JoinPolicyOpen})
+	ts.roomMembers.add(1, 2, store.RoomRoleOwner)
+
+	ct := &chatClientTest{network: &cuttableNetwork{}}
+	ct.server, ct.app = newTestServerApp(t, ts)
+
+	token, err := auth.GenerateToken(1, 0, 0, time.Now().Add(auth.TokenLifetime), testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct.client, err = chatclient.Dial(context.Background(), ct.server.URL, token,
+		chatclient.WithDialer(ct.network.dialer()),
+		chatclient.WithBackoff(10*time.Millisecond, 50*time.Millisecond),
+		chatclient.WithStateHandler(func(change chatclient.StateChange) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			ct.states = append(ct.states, change)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ct.client.Close() })
+	if user := ct.client.User(); user.ID != 1 || user.Username != "ada" {
+		t.Fatalf("the client is signed in as %+v, want ada", user)
+	}
+
+	ct.client.Subscribe(chatclient.FrameMessage, func(message *wire.Message) {
+		if message.System {
+			return
+		}
+		ct.mu.Lock()
+		defer ct.mu.Unlock()
+		ct.messages = append(ct.messages, message)
+	})
+	return ct
+}
+
+// send posts the messages "message from" to "message to" in room 1 as user 2
+func (ct *chatClientTest) send(t *testing.T, from, to int) {
+	for i := from; i <= to; i++ {
+		body := map[string]string{"content": fmt.Sprintf("message %d", i)}
+		if status := doJSON(t, http.MethodPost, ct.server.URL+"/v1/rooms/1/messages", 2, body, nil); status != http.StatusCreated {
+			t.Errorf("sending message %d got %d", i, status)
+		}
+	}
+}
+
+// received returns how many chat messages the client got
+func (ct *chatClientTest) received() int {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return len(ct.messages)
+}
+
+// reached reports whether the room's connection has been in state
+func (ct *chatClientTest) reached(state chatclient.State) bool {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	for _, change := range ct.states {
+		if change.State == state {
+			return true
+		}
+	}
+	return false
+}
+
+// TestChatClientReconnect joins a room with the client and cuts its
+// connection mid-conversation: messages sent while it's down are fetched
+// once it's back, before those sent as it reconnects, and the handlers get
+// every message exactly once, in order
+func TestChatClientReconnect(t *testing.T) {
+	ct := newChatClientTest(t)
+	if err := ct.client.JoinRoom(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if !testutil.WaitFor(2*time.Second, func() bool { return ct.app.hub.GetRoomClientCount(1) == 1 }) {
+		t.Fatal("the client never registered with the hub")
+	}
+
+	ct.send(t, 1, 5)
+	if !testutil.WaitFor(2*time.Second, func() bool { return ct.received() == 5 }) {
+		t.Fatalf("got %d messages before the cut, want 5", ct.received())
+	}
+
+	ct.network.cut()
+	if !testutil.WaitFor(2*time.Second, func() bool {
+		return ct.reached(chatclient.StateReconnecting) && ct.app.hub.GetRoomClientCount(1) == 0
+	}) {
+		t.Fatal("the cut connection was never noticed")
+	}
+	ct.send(t, 6, 10)
+
+	// The rest are sent while the client reconnects and fetches what it missed
+	ct.network.restore()
+	ct.send(t, 11, 15)
+	if !testutil.WaitFor(5*time.Second, func() bool { return ct.received() >= 15 }) {
+		t.Fatalf("got %d messages, want 15", ct.received())
+	}
+	ct.client.Close()
+
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	if len(ct.messages) != 15 {
+		t.Errorf("got %d messages, want 15", len(ct.messages))
+	}
+	for i, message := range ct.messages {
+		if want := fmt.Sprintf("message %d", i+1); message.Content != want || message.RoomID != 1 || message.Username != "grace" {
+			t.Errorf("message %d was %q in room %d from %q, want %q in room 1 from grace", i+1, message.Content, message.RoomID, message.Username, want)
+		}
+	}
+
+	var got []chatclient.State
+	for _, change := range ct.states {
+		got = append(got, change.State)
+	}
+	want := []chatclient.State{chatclient.StateConnecting, chatclient.StateConnected, chatclient.StateReconnecting, chatclient.StateConnected, chatclient.StateClosed}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("the connection went through %v, want %v", got, want)
+	}
+}
+
+// TestChatClientRemoved checks a close the server means for good isn't
+// reconnected: removed from the room, the client gets the removed_from_room
+// frame and the connection ends with StateClosed and the close code
+func TestChatClientRemoved(t *testing.T) {
+	// The client's close codes are the server's
+	for client, server := range map[int]int{
+		chatclient.CloseRemovedFromRoom: ws.CloseRemovedFromRoom,
+		chatclient.CloseRoomDeleted:     ws.CloseRoomDeleted,
+		chatclient.CloseRoomMerged:      ws.CloseRoomMerged,
+		chatclient.CloseSessionRevoked:  ws.CloseSessionRevoked,
+		chatclient.CloseLeftRoom:        ws.CloseLeftRoom,
+	} {
+		if client != server {
+			t.Errorf("the client has close code %d for the server's %d", client, server)
+		}
+	}
+
+	ct := newChatClientTest(t)
+	removed := make(chan *wire.Message, 1)
+	ct.client.Subscribe(chatclient.FrameRemoved, func(frame *wire.Message) { removed <- frame })
+	if err := ct.client.JoinRoom(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if !testutil.WaitFor(2*time.Second, func() bool { return ct.app.hub.GetRoomClientCount(1) == 1 }) {
+		t.Fatal("the client never registered with the hub")
+	}
+
+	ct.app.hub.RemoveUsers(1, []int64{1}, "")
+	select {
+	case <-removed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no removed_from_room frame")
+	}
+	if !testutil.WaitFor(2*time.Second, func() bool { return ct.reached(chatclient.StateClosed) }) {
+		t.Fatal("the connection never closed")
+	}
+
+	ct.mu.Lock()
+	last := ct.states[len(ct.states)-1]
+	ct.mu.Unlock()
+	if !websocket.IsCloseError(last.Err, chatclient.CloseRemovedFromRoom) || ct.reached(chatclient.StateReconnecting) {
+		t.Errorf("the connection closed with %v after %d dials, want close %d and no reconnect", last.Err, ct.network.dials, chatclient.CloseRemovedFromRoom)
+	}
+	if err := ct.client.SendMessage(context.Background(), 1, "still here?"); !errors.Is(err, chatclient.ErrNotConnected) {
+		t.Errorf("sending after the removal got %v, want ErrNotConnected", err)
+	}
+}
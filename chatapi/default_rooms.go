@@ -0,0 +1,45 @@
+package chatapi
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+)
+
+// setDefaultRoomHandler makes a room one that every new account joins
+// Existing users aren't added; they only join rooms they choose
+// POST /v1/admin/rooms/{roomID}/default
+// Requires the X-Ops-Token header
+// Response: 204 No Content
+func (app *application) setDefaultRoomHandler(w http.ResponseWriter, r *http.Request) {
+	app.updateDefaultRoom(w, r, true)
+}
+
+// unsetDefaultRoomHandler stops new accounts from joining a room
+// Users who already joined it stay members
+// DELETE /v1/admin/rooms/{roomID}/default
+// Requires the X-Ops-Token header
+// Response: 204 No Content
+func (app *application) unsetDefaultRoomHandler(w http.ResponseWriter, r *http.Request) {
+	app.updateDefaultRoom(w, r, false)
+}
+
+// updateDefaultRoom is the shared body of the default room handlers
+func (app *application) updateDefaultRoom(w http.ResponseWriter, r *http.Request, isDefault bool) {
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	if err := app.store.Rooms.SetDefault(r.Context(), roomID, isDefault); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_update_failed")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
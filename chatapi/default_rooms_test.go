@@ -0,0 +1,138 @@
+package chatapi
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// onboardingUsers creates accounts in memory and joins them to the default
+// rooms with space, as the store does (the transaction and the quiet join
+// are the store's job and are tested there)
+// With an invite token it also accepts the address's email invites: rooms
+// with the approval policy get a join request, the others a membership
+type onboardingUsers struct {
+	*fakeUsers
+	rooms    *fakeRooms
+	members  *fakeRoomMembers
+	invites  *fakeEmailInvites
+	requests *fakeJoinRequests
+}
+
+// newOnboardingUsers replaces ts's users with onboardingUsers
+func newOnboardingUsers(ts *testStore) *onboardingUsers {
+	users := &onboardingUsers{fakeUsers: ts.users, rooms: ts.rooms, members: ts.roomMembers, invites: ts.emailInvites, requests: ts.joinRequests}
+	ts.Users = users
+	return users
+}
+
+func (u *onboardingUsers) CreateWithDefaultRooms(ctx context.Context, user *store.User, inviteTokenHash string) ([]*store.Room, []*store.EmailInviteOutcome, error) {
+	user.ID = int64(len(u.users) + 1)
+	// A copy, since the handler clears the password of the one it has
+	copied := *user
+	u.add(&copied)
+	joined := make([]*store.Room, 0)
+	u.rooms.mu.Lock()
+	ids := slices.Sorted(maps.Keys(u.rooms.rooms))
+	u.rooms.mu.Unlock()
+	for _, id := range ids {
+		room, err := u.rooms.GetByID(ctx, id)
+		if err != nil || !room.IsDefault {
+			continue
+		}
+		if err := u.members.JoinWithOptions(ctx, id, user.ID, store.JoinOptions{Quiet: true}); errors.Is(err, store.ErrRoomFull) {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		joined = append(joined, room)
+	}
+	if inviteTokenHash == "" {
+		return joined, nil, nil
+	}
+
+	outcomes := make([]*store.EmailInviteOutcome, 0)
+	for _, invite := range u.invites.accept(user.Email, inviteTokenHash) {
+		room, err := u.rooms.GetByID(ctx, invite.RoomID)
+		if err != nil {
+			return nil, nil, err
+		}
+		outcome := &store.EmailInviteOutcome{RoomID: room.ID, RoomName: room.Name, InvitedBy: invite.InvitedBy, Status: store.EmailInviteJoined}
+		if room.JoinPolicy == store.JoinPolicyApproval {
+			if _, _, err := u.requests.Knock(ctx, room.ID, user.ID, 0); err != nil {
+				return nil, nil, err
+			}
+			outcome.Status = store.EmailInviteRequested
+		} else {
+			if err := u.members.JoinWithOptions(ctx, room.ID, user.ID, store.JoinOptions{}); err != nil {
+				return nil, nil, err
+			}
+			joined = append(joined, room)
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	return joined, outcomes, nil
+}
+
+// TestDefaultRooms flags rooms as defaults through the admin endpoints and
+// registers accounts: each response lists the default rooms with space the
+// account joined, and unflagging a room leaves its members in it
+func TestDefaultRooms(t *testing.T) {
+	ts := newTestStore(t)
+	newOnboardingUsers(ts)
+	ts.rooms.add(&store.Room{ID: 1, Name: "welcome", CreatedBy: 1, IsDefault: true})
+	ts.rooms.add(&store.Room{ID: 2, Name: "random", CreatedBy: 1})
+	ts.rooms.add(&store.Room{ID: 3, Name: "crowded", CreatedBy: 1, IsDefault: true})
+	for userID := int64(1); userID <= int64(testLimits.MaxRoomMembers); userID++ {
+		ts.roomMembers.add(3, 100+userID, store.RoomRoleMember)
+	}
+	server := newTestServer(t, ts, withOps)
+	ops := map[string]string{opsTokenHeader: "ops-secret"}
+
+	register := func(name string) []int64 {
+		t.Helper()
+		var resp AuthResponse
+		body := RegisterRequest{Username: name, Email: name + "@example.com", Password: "correct horse battery staple"}
+		if status := doJSON(t, http.MethodPost, server.URL+"/v1/auth/register", 0, body, &resp); status != http.StatusCreated {
+			t.Fatalf("registering %s got %d, want 201", name, status)
+		}
+		ids := make([]int64, 0, len(resp.Rooms))
+		for _, room := range resp.Rooms {
+			ids = append(ids, room.ID)
+		}
+		return ids
+	}
+
+	if rooms := register("erin"); !slices.Equal(rooms, []int64{1}) {
+		t.Errorf("erin joined %v, want the default room with space, 1", rooms)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		status  int
+	}{
+		{"without the ops token", http.MethodPost, "/v1/admin/rooms/2/default", nil, http.StatusUnauthorized},
+		{"an unknown room", http.MethodPost, "/v1/admin/rooms/99/default", ops, http.StatusNotFound},
+		{"flagging random", http.MethodPost, "/v1/admin/rooms/2/default", ops, http.StatusNoContent},
+		{"unflagging welcome", http.MethodDelete, "/v1/admin/rooms/1/default", ops, http.StatusNoContent},
+	} {
+		if status := doJSONWithHeaders(t, tc.method, server.URL+tc.path, 0, tc.headers, nil, nil); status != tc.status {
+			t.Errorf("%s got %d, want %d", tc.name, status, tc.status)
+		}
+	}
+
+	if rooms := register("frank"); !slices.Equal(rooms, []int64{2}) {
+		t.Errorf("frank joined %v, want the new default room, 2", rooms)
+	}
+	if in, _ := ts.roomMembers.IsUserInRoom(context.Background(), 1, 1); !in {
+		t.Error("unflagging welcome removed erin from it")
+	}
+}
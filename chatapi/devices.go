@@ -0,0 +1,215 @@
+package chatapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+const (
+	// deviceHeader carries the device ID returned by POST /v1/devices
+	deviceHeader = "X-Device-ID"
+
+	// staleDeviceAge is how long a device may go unused before it's pruned
+	staleDeviceAge = 60 * 24 * time.Hour
+
+	// devicePruneInterval is how often the background pruner runs
+	devicePruneInterval = 24 * time.Hour
+)
+
+// CreateDeviceRequest represents the JSON structure for registering a device
+type CreateDeviceRequest struct {
+	Name string `json:"name"`
+}
+
+// MarkReadRequest represents the JSON structure for moving a read marker
+type MarkReadRequest struct {
+	MessageID int64 `json:"message_id"`
+}
+
+// SyncStateResponse is the compact state document returned to a syncing device
+type SyncStateResponse struct {
+	DeviceID int64                  `json:"device_id"`
+	Rooms    []*store.RoomSyncState `json:"rooms"`
+}
+
+// deviceFromRequest reads and verifies the X-Device-ID header
+// Device registration is optional: a missing header returns device 0, which
+// stands for "unregistered client"
+// A header naming a device that doesn't belong to the user is rejected
+func (app *application) deviceFromRequest(w http.ResponseWriter, r *http.Request, userID int64) (int64, bool) {
+	header := strings.TrimSpace(r.Header.Get(deviceHeader))
+	if header == "" {
+		return 0, true
+	}
+
+	deviceID, err := strconv.ParseInt(header, 10, 64)
+	if err != nil || deviceID <= 0 {
+		writeError(w, r, http.StatusBadRequest, "invalid_device_id")
+		return 0, false
+	}
+
+	// Touch doubles as an ownership check and keeps the device from being pruned
+	if err := app.store.Devices.Touch(r.Context(), deviceID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "device_not_found")
+			return 0, false
+		}
+		writeError(w, r, http.StatusInternalServerError, "device_lookup_failed")
+		return 0, false
+	}
+
+	return deviceID, true
+}
+
+// createDeviceHandler registers a new client device for the current user
+// POST /v1/devices
+// Requires authentication
+// Request body: {"name": "Firefox on laptop"}
+// Response: {"id": 7, "name": "Firefox on laptop", ...}
+func (app *application) createDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	var req CreateDeviceRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	device := &store.Device{
+		UserID: userID,
+		Name:   strings.TrimSpace(req.Name),
+	}
+	if len(device.Name) > 100 {
+		writeError(w, r, http.StatusBadRequest, "device_name_too_long")
+		return
+	}
+
+	if err := app.store.Devices.Create(r.Context(), device); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "device_create_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, device)
+}
+
+// markRoomReadHandler records that the calling device has read up to a message
+// POST /v1/rooms/{roomID}/read
+// Requires authentication and room membership; honours the X-Device-ID header
+// Request body: {"message_id": 123}
+// Response: {"room_id": 1, "unread_count": 0}
+func (app *application) markRoomReadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	var req MarkReadRequest
+	if err := readJSON(r, &req); err != nil || req.MessageID <= 0 {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	isMember, err := app.store.RoomMembers.IsUserInRoom(r.Context(), roomID, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "membership_check_failed")
+		return
+	}
+	if !isMember {
+		writeError(w, r, http.StatusForbidden, "membership_required_messages")
+		return
+	}
+
+	deviceID, ok := app.deviceFromRequest(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if err := app.store.ReadMarkers.MarkRead(r.Context(), userID, roomID, deviceID, req.MessageID); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "read_marker_update_failed")
+		return
+	}
+
+	unread, err := app.store.ReadMarkers.GetUnreadCount(r.Context(), userID, roomID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "read_marker_update_failed")
+		return
+	}
+
+	type response struct {
+		RoomID      int64 `json:"room_id"`
+		UnreadCount int   `json:"unread_count"`
+	}
+	writeJSON(w, http.StatusOK, response{RoomID: roomID, UnreadCount: unread})
+}
+
+// syncStateHandler returns read positions for every room the user belongs to
+// GET /v1/users/me/sync
+// Requires authentication; honours the X-Device-ID header
+// A newly logged-in device uses this to jump to the right position in each room
+// Response: {"device_id": 7, "rooms": [{"room_id": 1, "latest_message_id": 90, ...}]}
+func (app *application) syncStateHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	deviceID, ok := app.deviceFromRequest(w, r, userID)
+	if !ok {
+		return
+	}
+
+	rooms, err := app.store.ReadMarkers.GetSyncState(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "sync_state_lookup_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SyncStateResponse{DeviceID: deviceID, Rooms: rooms})
+}
+
+// runDevicePruner periodically removes devices that haven't been used in 60 days
+// It runs until ctx is done and should be started in a goroutine
+func (app *application) runDevicePruner(ctx context.Context) {
+	ticker := time.NewTicker(devicePruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		callCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		removed, err := app.store.Devices.PruneInactive(callCtx, time.Now().Add(-staleDeviceAge))
+		cancel()
+
+		if err != nil {
+			log.Printf("Failed to prune stale devices: %v", err)
+			continue
+		}
+		if removed > 0 {
+			log.Printf("Pruned %d stale devices", removed)
+		}
+	}
+}
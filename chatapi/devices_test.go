@@ -0,0 +1,81 @@
+package chatapi
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestDeviceHeader sends X-Device-ID with a read marker: it's optional, but
+// a malformed ID is a bad request and another user's device isn't found
+func TestDeviceHeader(t *testing.T) {
+	ts := newTestStore(t)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.devices.add(7, 2)
+	ts.devices.add(8, 3)
+	server := newTestServer(t, ts)
+
+	for _, tc := range []struct {
+		header string
+		status int
+		code   string
+	}{
+		{"", http.StatusOK, ""},
+		{"7", http.StatusOK, ""},
+		{"seven", http.StatusBadRequest, "invalid_device_id"},
+		{"-7", http.StatusBadRequest, "invalid_device_id"},
+		{"8", http.StatusNotFound, "device_not_found"},
+		{"9", http.StatusNotFound, "device_not_found"},
+	} {
+		var failure errorBody
+		status := doJSONWithHeaders(t, http.MethodPost, server.URL+"/v1/rooms/1/read", 2, map[string]string{deviceHeader: tc.header}, map[string]any{"message_id": 1}, &failure)
+		if status != tc.status || failure.Code != tc.code {
+			t.Errorf("device %q got %d %q, want %d %q", tc.header, status, failure.Code, tc.status, tc.code)
+		}
+	}
+}
+
+// TestMarkRead reads a room on two devices: the unread count follows the
+// furthest of them, stale positions don't move it back, and sync reports
+// each device's position
+func TestMarkRead(t *testing.T) {
+	ts := newTestStore(t)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.devices.add(7, 2)
+	ts.messages.addMessages(1, 3, 10)
+	server := newTestServer(t, ts)
+
+	type marked struct {
+		RoomID      int64 `json:"room_id"`
+		UnreadCount int   `json:"unread_count"`
+	}
+	for _, tc := range []struct {
+		device    string
+		messageID int64
+		unread    int
+	}{
+		{"", 4, 6},
+		{"7", 8, 2},
+		{"", 6, 2},
+		{"7", 3, 2},
+	} {
+		var got marked
+		status := doJSONWithHeaders(t, http.MethodPost, server.URL+"/v1/rooms/1/read", 2, map[string]string{deviceHeader: tc.device}, map[string]any{"message_id": tc.messageID}, &got)
+		if status != http.StatusOK || got.UnreadCount != tc.unread {
+			t.Errorf("device %q reading to %d got %d with %d unread, want 200 with %d", tc.device, tc.messageID, status, got.UnreadCount, tc.unread)
+		}
+	}
+
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/read", 3, map[string]any{"message_id": 1}, nil); status != http.StatusForbidden {
+		t.Errorf("a non-member got %d, want 403", status)
+	}
+
+	var sync SyncStateResponse
+	if status := doJSONWithHeaders(t, http.MethodGet, server.URL+"/v1/users/me/sync", 2, map[string]string{deviceHeader: "7"}, nil, &sync); status != http.StatusOK {
+		t.Fatalf("sync got %d, want 200", status)
+	}
+	if sync.DeviceID != 7 || len(sync.Rooms) != 1 || len(sync.Rooms[0].Devices) != 2 {
+		t.Errorf("sync returned device %d with rooms %+v, want device 7 and both positions in room 1", sync.DeviceID, sync.Rooms)
+	}
+}
@@ -0,0 +1,118 @@
+package chatapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/digest"
+	"github.com/drazan344/go-chat/internal/mail"
+	"github.com/drazan344/go-chat/internal/notify"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// newMailer creates the configured email sender
+// Returns nil when email is turned off
+func newMailer(cfg mailConfig) (mail.Mailer, error) {
+	switch cfg.provider {
+	case "":
+		return nil, nil
+	case "log":
+		return mail.LogMailer{}, nil
+	case "smtp":
+		if cfg.smtpAddr == "" || cfg.from == "" {
+			return nil, errors.New("SMTP_ADDR and MAIL_FROM are required for smtp")
+		}
+		return &mail.SMTPMailer{
+			Addr:     cfg.smtpAddr,
+			Username: cfg.smtpUsername,
+			Password: cfg.smtpPassword,
+			From:     cfg.from,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown mail provider %q", cfg.provider)
+}
+
+// startDigests starts sending daily digest emails
+// Does nothing when email is turned off; users can still save their settings
+func startDigests(ctx context.Context, st store.Storage, policy notify.Policy, mailer mail.Mailer, interval time.Duration) {
+	if mailer == nil {
+		return
+	}
+
+	go digest.NewScheduler(st, mailer, policy, interval).Run(ctx)
+}
+
+// digestSettingsHandler returns the caller's daily digest settings
+// GET /v1/users/me/digest
+// Requires authentication
+// Response: {"enabled": true, "hour": 8, "timezone": "Europe/Berlin"}
+func (app *application) digestSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	settings, err := app.store.Digests.GetSettings(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "user_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "digest_settings_lookup_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, settings)
+}
+
+// updateDigestSettingsHandler turns daily digest emails on or off and picks when they arrive
+// The digest for a day is sent once the hour has come in the user's timezone
+// and covers unread messages since the previous digest
+// PUT /v1/users/me/digest
+// Requires authentication
+// Request body: {"enabled": true, "hour": 8, "timezone": "Europe/Berlin"}
+// Response: {"enabled": true, "hour": 8, "timezone": "Europe/Berlin"}
+func (app *application) updateDigestSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	var settings store.DigestSettings
+	if err := readJSON(r, &settings); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	if settings.Hour < 0 || settings.Hour > 23 {
+		writeError(w, r, http.StatusBadRequest, "invalid_digest_hour")
+		return
+	}
+	settings.Timezone = strings.TrimSpace(settings.Timezone)
+	if settings.Timezone == "" {
+		settings.Timezone = "UTC"
+	}
+	// "Local" would mean the server's timezone, which isn't what the user picked
+	if _, err := time.LoadLocation(settings.Timezone); err != nil || settings.Timezone == "Local" || len(settings.Timezone) > 64 {
+		writeError(w, r, http.StatusBadRequest, "invalid_timezone", settings.Timezone)
+		return
+	}
+
+	if err := app.store.Digests.UpdateSettings(r.Context(), userID, &settings); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "user_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "digest_settings_update_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, settings)
+}
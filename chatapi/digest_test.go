@@ -0,0 +1,86 @@
+package chatapi
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/mail"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestDigestSettings reads the defaults, saves new settings and reads them
+// back; a blank timezone is saved as UTC
+func TestDigestSettings(t *testing.T) {
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	server := newTestServer(t, ts)
+	url := server.URL + "/v1/users/me/digest"
+
+	var settings store.DigestSettings
+	if status := doJSON(t, http.MethodGet, url, 1, nil, &settings); status != http.StatusOK || settings != (store.DigestSettings{Hour: 8, Timezone: "UTC"}) {
+		t.Errorf("got %d %+v, want digests off at 08:00 UTC", status, settings)
+	}
+
+	for _, tc := range []struct {
+		body, want store.DigestSettings
+	}{
+		{store.DigestSettings{Enabled: true, Hour: 7, Timezone: "Europe/Berlin"}, store.DigestSettings{Enabled: true, Hour: 7, Timezone: "Europe/Berlin"}},
+		{store.DigestSettings{Enabled: true, Hour: 0, Timezone: " "}, store.DigestSettings{Enabled: true, Hour: 0, Timezone: "UTC"}},
+	} {
+		var saved, loaded store.DigestSettings
+		if status := doJSON(t, http.MethodPut, url, 1, tc.body, &saved); status != http.StatusOK || saved != tc.want {
+			t.Errorf("saving %+v got %d %+v, want %+v", tc.body, status, saved, tc.want)
+		}
+		if doJSON(t, http.MethodGet, url, 1, nil, &loaded); loaded != tc.want {
+			t.Errorf("after saving %+v got %+v, want %+v", tc.body, loaded, tc.want)
+		}
+	}
+
+	var failure errorBody
+	if status := doJSON(t, http.MethodGet, url, 2, nil, &failure); status != http.StatusNotFound || failure.Code != "user_not_found" {
+		t.Errorf("an unknown user got %d %q, want 404 user_not_found", status, failure.Code)
+	}
+}
+
+// TestDigestSettingsValidation refuses hours outside the day and timezones
+// the server doesn't know or that would mean its own
+func TestDigestSettingsValidation(t *testing.T) {
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	server := newTestServer(t, ts)
+
+	for _, tc := range []struct {
+		body map[string]any
+		code string
+	}{
+		{map[string]any{"enabled": true, "hour": 24, "timezone": "UTC"}, "invalid_digest_hour"},
+		{map[string]any{"enabled": true, "hour": -1, "timezone": "UTC"}, "invalid_digest_hour"},
+		{map[string]any{"enabled": true, "hour": 8, "timezone": "Mars/Olympus"}, "invalid_timezone"},
+		{map[string]any{"enabled": true, "hour": 8, "timezone": "Local"}, "invalid_timezone"},
+		{map[string]any{"enabled": "yes"}, "invalid_request_body"},
+	} {
+		var failure errorBody
+		if status := doJSON(t, http.MethodPut, server.URL+"/v1/users/me/digest", 1, tc.body, &failure); status != http.StatusBadRequest || failure.Code != tc.code {
+			t.Errorf("%v: got %d %q, want 400 %q", tc.body, status, failure.Code, tc.code)
+		}
+	}
+	if settings, _ := ts.digests.GetSettings(t.Context(), 1); settings.Enabled {
+		t.Error("a refused update turned digests on")
+	}
+}
+
+// TestNewMailer picks the sender from MAIL_PROVIDER
+func TestNewMailer(t *testing.T) {
+	if mailer, err := newMailer(mailConfig{}); mailer != nil || err != nil {
+		t.Errorf("no provider got %v, %v; want email off", mailer, err)
+	}
+	if mailer, err := newMailer(mailConfig{provider: "log"}); err != nil || mailer != (mail.LogMailer{}) {
+		t.Errorf("log got %v, %v", mailer, err)
+	}
+	if _, err := newMailer(mailConfig{provider: "smtp", smtpAddr: "localhost:25"}); err == nil {
+		t.Error("smtp without MAIL_FROM was accepted")
+	}
+	if _, err := newMailer(mailConfig{provider: "carrier-pigeon"}); err == nil {
+		t.Error("an unknown provider was accepted")
+	}
+}
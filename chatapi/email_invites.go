@@ -0,0 +1,266 @@
+package chatapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/auth"
+	"github.com/drazan344/go-chat/internal/mail"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/websocket"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+const (
+	// emailInviteTTL is how long an emailed invite can be used to sign up
+	emailInviteTTL = 14 * 24 * time.Hour
+
+	// maxInviteEmailLength matches the pending_email_invites.email column
+	maxInviteEmailLength = 255
+)
+
+// Outcomes of an email invite entry besides the store.Bulk* ones of registered users
+const (
+	inviteEmailed      = "invited"       // No account yet: an invite was emailed
+	inviteInvalidEmail = "invalid_email" // Not an email address
+	inviteMailDisabled = "mail_disabled" // No account yet, and this server doesn't send email
+)
+
+// EmailInvitesRequest lists the addresses to invite to a room
+type EmailInvitesRequest struct {
+	Emails []string `json:"emails"`
+}
+
+// EmailInviteResult is the outcome for one address of an invite request
+type EmailInviteResult struct {
+	Email  string `json:"email"`
+	UserID int64  `json:"user_id,omitempty"` // Set when the address belongs to a registered user
+	Status string `json:"status"`
+}
+
+// createEmailInvitesHandler invites people to a room by email address
+// An address that belongs to a registered user adds them like a bulk add
+// (status "added", "already_member", ...). Any other address gets an email
+// with a sign-up link; registering with it joins the new account to the room
+// ("invited"). Invites expire after 14 days; inviting the same address again
+// sends a fresh link and the old one stops working
+// POST /v1/rooms/{roomID}/invites
+// Requires authentication and manage_members (by default the owner and admins)
+// Request body: {"emails": ["jane@example.com", "new.colleague@example.com"]}
+// Response: {"results": [{"email": "jane@example.com", "user_id": 5, "status": "added"},
+// {"email": "new.colleague@example.com", "status": "invited"}]}
+func (app *application) createEmailInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	var req EmailInvitesRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	if len(req.Emails) == 0 {
+		writeError(w, r, http.StatusBadRequest, "email_invites_empty")
+		return
+	}
+	if len(req.Emails) > maxBulkMembers {
+		writeError(w, r, http.StatusBadRequest, "email_invites_too_many", maxBulkMembers)
+		return
+	}
+
+	room, err := app.store.Rooms.GetByID(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_verify_failed")
+		return
+	}
+	if !app.requireRoomPermission(w, r, room.ID, userID, store.CapManageMembers) {
+		return
+	}
+
+	// One result per entry, in request order; addresses are compared normalized
+	results := make([]*EmailInviteResult, len(req.Emails))
+	valid := make([]string, 0, len(req.Emails))
+	for i, email := range req.Emails {
+		email = store.NormalizeEmail(email)
+		results[i] = &EmailInviteResult{Email: email}
+		if !strings.Contains(email, "@") || len(email) > maxInviteEmailLength {
+			results[i].Status = inviteInvalidEmail
+			continue
+		}
+		valid = append(valid, email)
+	}
+
+	// Addresses of registered users fall back to adding them, in one query each way
+	users, err := app.store.Users.GetByEmails(r.Context(), valid)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return
+	}
+	byEmail := make(map[string]*store.User, len(users))
+	userIDs := make([]int64, 0, len(users))
+	for _, u := range users {
+		byEmail[store.NormalizeEmail(u.Email)] = u
+		userIDs = append(userIDs, u.ID)
+	}
+
+	var outcomes map[int64]string
+	if len(userIDs) > 0 {
+		outcomes, err = app.store.RoomMembers.AddMembers(r.Context(), roomID, userIDs, userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				writeError(w, r, http.StatusNotFound, "room_not_found")
+				return
+			}
+			writeError(w, r, http.StatusInternalServerError, "bulk_members_failed")
+			return
+		}
+	}
+
+	inviter, err := app.store.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return
+	}
+
+	// Everyone else gets an email; an address listed twice gets one
+	emails := make([]*mail.Message, 0)
+	invited := make(map[string]bool)
+	for _, result := range results {
+		if result.Status != "" {
+			continue
+		}
+		if u := byEmail[result.Email]; u != nil {
+			result.UserID = u.ID
+			result.Status = outcomes[u.ID]
+			continue
+		}
+		if app.mailer == nil {
+			result.Status = inviteMailDisabled
+			continue
+		}
+		if invited[result.Email] {
+			result.Status = inviteEmailed
+			continue
+		}
+
+		token, hash, err := auth.GenerateInviteToken()
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "email_invite_failed")
+			return
+		}
+		invite := &store.EmailInvite{RoomID: roomID, Email: result.Email, InvitedBy: &userID}
+		if err := app.store.EmailInvites.Create(r.Context(), invite, hash, emailInviteTTL); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "email_invite_failed")
+			return
+		}
+		invited[result.Email] = true
+		result.Status = inviteEmailed
+		emails = append(emails, app.inviteEmail(invite, token, room, inviter))
+	}
+
+	// Notify only after the memberships committed, as for bulk adds
+	added := make([]int64, 0, len(outcomes))
+	for id, outcome := range outcomes {
+		if outcome == store.BulkAdded {
+			added = append(added, id)
+		}
+	}
+	if len(added) > 0 {
+		app.roomMembersChanged(roomID)
+		app.hub.NotifyUsers(added, &websocket.Message{
+			Message: wire.Message{
+				RoomID:  roomID,
+				Content: "you were added to " + room.Name,
+				Type:    "member_added",
+			},
+		})
+	}
+
+	// The invites are saved; a slow mail server shouldn't hold up the response
+	if len(emails) > 0 {
+		go app.sendInviteEmails(emails)
+	}
+
+	type response struct {
+		Results []*EmailInviteResult `json:"results"`
+	}
+	writeJSON(w, http.StatusOK, response{Results: results})
+}
+
+// inviteEmail builds the email for an invite
+// The link opens the web app's sign-up form with the token filled in
+func (app *application) inviteEmail(invite *store.EmailInvite, token string, room *store.Room, inviter *store.User) *mail.Message {
+	link := strings.TrimRight(app.config.mail.publicURL, "/") + "/?invite=" + url.QueryEscape(token)
+	text := fmt.Sprintf("%s invited you to the room %q on go-chat.\n\n"+
+		"Sign up with this email address to join:\n%s\n\n"+
+		"The invite expires on %s.\n",
+		inviter.Username, room.Name, link, invite.ExpiresAt.Format("January 2, 2006"))
+
+	return &mail.Message{
+		To:      invite.Email,
+		Subject: fmt.Sprintf("%s invited you to %s", inviter.Username, room.Name),
+		Text:    text,
+	}
+}
+
+// sendInviteEmails sends invite emails one after another
+// Failures are only logged: the invite is saved and can be sent again by inviting again
+func (app *application) sendInviteEmails(emails []*mail.Message) {
+	for _, email := range emails {
+		ctx, cancel := context.WithTimeout(app.ctx, 30*time.Second)
+		if err := app.mailer.Send(ctx, email); err != nil {
+			log.Printf("Failed to send room invite to %s: %v", email.To, err)
+		}
+		cancel()
+	}
+}
+
+// acceptedEmailInvites tells everyone involved about the invites a new account accepted
+// The inviter hears that their invite was used; for approval rooms the admins
+// also get the usual join request notification
+func (app *application) acceptedEmailInvites(r *http.Request, user *store.User, invites []*store.EmailInviteOutcome) {
+	for _, invite := range invites {
+		switch invite.Status {
+		case store.EmailInviteJoined:
+			app.roomMembersChanged(invite.RoomID)
+		case store.EmailInviteRequested:
+			app.notifyJoinRequest(r, invite.RoomID, user.ID)
+		}
+
+		if invite.InvitedBy == nil {
+			continue
+		}
+		content := user.Username + " signed up and joined " + invite.RoomName
+		if invite.Status != store.EmailInviteJoined {
+			content = user.Username + " signed up from your invite to " + invite.RoomName
+		}
+		app.hub.SendToUser(*invite.InvitedBy, &websocket.Message{
+			Message: wire.Message{
+				RoomID:   invite.RoomID,
+				UserID:   user.ID,
+				Username: user.Username,
+				Content:  content,
+				Type:     "invite_accepted",
+			},
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package chatapi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/mail"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+)
+
+// recordingMailer keeps the emails it's asked to send
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []*mail.Message
+}
+
+func (m *recordingMailer) Send(_ context.Context, message *mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, message)
+	return nil
+}
+
+// messages returns the emails sent so far
+func (m *recordingMailer) messages() []*mail.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.sent)
+}
+
+// inviteToken returns the token in the sign-up link of an invite email
+func inviteToken(t *testing.T, message *mail.Message) string {
+	t.Helper()
+	_, link, ok := strings.Cut(message.Text, "https://chat.example.com/?")
+	if !ok {
+		t.Fatalf("the invite email has no sign-up link:\n%s", message.Text)
+	}
+	query, err := url.ParseQuery(strings.Fields(link)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return query.Get("invite")
+}
+
+// TestEmailInvites invites a batch mixing a registered user, a new address
+// listed twice in different case and something that isn't an address: the
+// user is added, the new address gets one email, and each entry has its
+// outcome in request order
+func TestEmailInvites(t *testing.T) {
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 2, Username: "grace", Email: "grace@example.com"})
+	ts.users.add(&store.User{ID: 3, Username: "linus", Email: "linus@example.com"})
+	ts.rooms.add(&store.Room{ID: 1, Name: "design", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	mailer := &recordingMailer{}
+	ts.users.add(&store.User{ID: 1, Username: "ada", Email: "ada@example.com"})
+	server := newTestServer(t, ts, withMailer(mailer))
+	invites := server.URL + "/v1/rooms/1/invites"
+
+	var failure errorBody
+	if status := doJSON(t, http.MethodPost, invites, 1, EmailInvitesRequest{}, &failure); status != http.StatusBadRequest || failure.Code != "email_invites_empty" {
+		t.Errorf("inviting nobody got %d %q, want 400 email_invites_empty", status, failure.Code)
+	}
+	body := EmailInvitesRequest{Emails: []string{"Grace@Example.com", "new.colleague@example.com", "not-an-address", " NEW.Colleague@example.com"}}
+	if status := doJSON(t, http.MethodPost, invites, 3, body, &failure); status != http.StatusForbidden || failure.Code != "room_permission_denied" {
+		t.Errorf("linus inviting got %d %q, want 403 room_permission_denied", status, failure.Code)
+	}
+
+	var resp struct {
+		Results []*EmailInviteResult `json:"results"`
+	}
+	if status := doJSON(t, http.MethodPost, invites, 1, body, &resp); status != http.StatusOK {
+		t.Fatalf("inviting got %d, want 200", status)
+	}
+	want := []EmailInviteResult{
+		{Email: "grace@example.com", UserID: 2, Status: store.BulkAdded},
+		{Email: "new.colleague@example.com", Status: inviteEmailed},
+		{Email: "not-an-address", Status: inviteInvalidEmail},
+		{Email: "new.colleague@example.com", Status: inviteEmailed},
+	}
+	if len(resp.Results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(resp.Results), len(want))
+	}
+	for i, result := range resp.Results {
+		if *result != want[i] {
+			t.Errorf("result %d is %+v, want %+v", i, *result, want[i])
+		}
+	}
+	if in, _ := ts.roomMembers.IsUserInRoom(context.Background(), 1, 2); !in {
+		t.Error("grace wasn't added to the room")
+	}
+
+	if !testutil.WaitFor(time.Second, func() bool { return len(mailer.messages()) > 0 }) {
+		t.Fatal("no invite email was sent")
+	}
+	time.Sleep(50 * time.Millisecond)
+	sent := mailer.messages()
+	if len(sent) != 1 || sent[0].To != "new.colleague@example.com" || !strings.Contains(sent[0].Subject, "ada invited you to design") {
+		t.Fatalf("sent %+v, want one invite to new.colleague@example.com", sent)
+	}
+	if token := inviteToken(t, sent[0]); !strings.HasPrefix(token, "gcinv_") {
+		t.Errorf("the link's token is %q, want an invite token", token)
+	}
+	if len(ts.emailInvites.invites) != 1 || ts.emailInvites.invites[0].hash == "" {
+		t.Errorf("saved %d invites, want one with its token hash", len(ts.emailInvites.invites))
+	}
+}
+
+// TestEmailInvitesMailDisabled still adds registered users when the server
+// doesn't send email, and saves no invite for the other addresses
+func TestEmailInvitesMailDisabled(t *testing.T) {
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 2, Username: "grace", Email: "grace@example.com"})
+	ts.rooms.add(&store.Room{ID: 1, Name: "design", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.users.add(&store.User{ID: 1, Username: "ada", Email: "ada@example.com"})
+	server := newTestServer(t, ts, withMailer(nil))
+
+	var resp struct {
+		Results []*EmailInviteResult `json:"results"`
+	}
+	body := EmailInvitesRequest{Emails: []string{"grace@example.com", "new.colleague@example.com"}}
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/invites", 1, body, &resp); status != http.StatusOK {
+		t.Fatalf("inviting got %d, want 200", status)
+	}
+	if len(resp.Results) != 2 || resp.Results[0].Status != store.BulkAdded || resp.Results[1].Status != inviteMailDisabled {
+		t.Errorf("got %+v, want grace added and the new address mail_disabled", resp.Results)
+	}
+	if len(ts.emailInvites.invites) != 0 {
+		t.Errorf("saved %d invites without email, want none", len(ts.emailInvites.invites))
+	}
+}
+
+// TestRegisterWithEmailInvite invites a new address to an open room and to
+// an approval room, then signs up from the emailed link: the account joins
+// the open room and asks to join the other: ada hears the invites were
+// used, and grace, who also runs the other room, gets the join request
+// Signing up with the token under another address accepts nothing
+func TestRegisterWithEmailInvite(t *testing.T) {
+	ts := newTestStore(t)
+	newOnboardingUsers(ts)
+	ts.rooms.add(&store.Room{ID: 1, Name: "design", CreatedBy: 1})
+	ts.rooms.add(&store.Room{ID: 2, Name: "board", CreatedBy: 1, JoinPolicy: store.JoinPolicyApproval})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(2, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(2, 2, store.RoomRoleAdmin)
+	ts.users.add(&store.User{ID: 2, Username: "grace", Email: "grace@example.com"})
+	mailer := &recordingMailer{}
+	ts.users.add(&store.User{ID: 1, Username: "ada", Email: "ada@example.com"})
+	server := newTestServer(t, ts, withMailer(mailer))
+
+	for _, roomID := range []string{"1", "2"} {
+		body := EmailInvitesRequest{Emails: []string{"new.colleague@example.com"}}
+		if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/"+roomID+"/invites", 1, body, nil); status != http.StatusOK {
+			t.Fatalf("inviting to room %s got %d, want 200", roomID, status)
+		}
+	}
+	if !testutil.WaitFor(time.Second, func() bool { return len(mailer.messages()) == 2 }) {
+		t.Fatalf("sent %d invite emails, want 2", len(mailer.messages()))
+	}
+	token := inviteToken(t, mailer.messages()[0])
+
+	register := func(name, email string) AuthResponse {
+		t.Helper()
+		var resp AuthResponse
+		body := RegisterRequest{Username: name, Email: email, Password: "correct horse battery staple", InviteToken: token}
+		if status := doJSON(t, http.MethodPost, server.URL+"/v1/auth/register", 0, body, &resp); status != http.StatusCreated {
+			t.Fatalf("registering %s got %d, want 201", name, status)
+		}
+		return resp
+	}
+
+	// The token proves ownership of its own address only
+	if resp := register("mallory", "mallory@example.com"); len(resp.Invites) != 0 || len(resp.Rooms) != 0 {
+		t.Errorf("mallory's sign-up accepted %+v and joined %+v, want nothing", resp.Invites, resp.Rooms)
+	}
+
+	inviter := dialRoom(t, server, 1, 1)
+	readFrame(t, inviter, "join")
+	admin := dialRoom(t, server, 2, 2)
+	readFrame(t, admin, "join")
+
+	resp := register("erin", "New.Colleague@example.com")
+	if len(resp.Rooms) != 1 || resp.Rooms[0].ID != 1 {
+		t.Errorf("erin joined %+v, want design", resp.Rooms)
+	}
+	want := map[int64]string{1: store.EmailInviteJoined, 2: store.EmailInviteRequested}
+	if len(resp.Invites) != 2 {
+		t.Fatalf("got %d invite outcomes, want 2", len(resp.Invites))
+	}
+	for _, invite := range resp.Invites {
+		if invite.Status != want[invite.RoomID] || invite.InvitedBy == nil || *invite.InvitedBy != 1 {
+			t.Errorf("the invite to room %d is %+v, want %q by ada", invite.RoomID, invite, want[invite.RoomID])
+		}
+	}
+	if request := ts.joinRequests.requests[[2]int64{2, resp.User.ID}]; request == nil || request.Status != store.JoinRequestPending {
+		t.Errorf("erin's join request to board is %+v, want pending", request)
+	}
+
+	if frame := readFrame(t, admin, "join_request"); frame.UserID != resp.User.ID {
+		t.Errorf("grace got a join request from user %d, want erin", frame.UserID)
+	}
+	if frame := readFrame(t, inviter, "invite_accepted"); frame.UserID != resp.User.ID || !strings.Contains(frame.Content, "erin signed up") {
+		t.Errorf("ada got invite_accepted %+v, want erin's", frame)
+	}
+}
@@ -0,0 +1,265 @@
+package chatapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/auth"
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+	"github.com/go-chi/chi/v5"
+)
+
+// newEmbeddedChat creates a Server on ts the way an embedding application
+// does, configured from envFile (which needn't exist) and the environment
+// Tokens are signed with testSecret; only the hub runs
+func newEmbeddedChat(t *testing.T, ts *testStore, envFile string, opts ...Option) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("JWT_SECRET", testSecret)
+	t.Setenv("ATTACHMENT_DIR", filepath.Join(dir, "attachments"))
+	t.Setenv("EXPORT_DIR", filepath.Join(dir, "exports"))
+	cfg, err := LoadConfig(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hub := NewHub(ts.Storage, 1)
+	chat, err := New(cfg, ts.Storage, hub, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go hub.Run()
+	return chat
+}
+
+// TestMountedUnderPrefix mounts the chat under /chat of another router, as
+// examples/embed does: its routes answer under the prefix only, the
+// application's own routes still work, and URLs the chat hands out include
+// the prefix
+func TestMountedUnderPrefix(t *testing.T) {
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	ts.exports.addMessages(2, 1, 3)
+	t.Setenv("EXPORT_ASYNC_THRESHOLD", "1") // So the export runs as a job
+	chat := newEmbeddedChat(t, ts, filepath.Join(t.TempDir(), "missing.env"), WithBasePath("/chat"))
+
+	router := chi.NewRouter()
+	router.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "home")
+	})
+	router.Mount("/chat", chat.Handler())
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/", http.StatusOK},
+		{"/chat/v1/health", http.StatusOK},
+		{"/chat/v1/auth/me", http.StatusOK},
+		{"/v1/health", http.StatusNotFound},
+		{"/v1/auth/me", http.StatusNotFound},
+	} {
+		if status := doJSON(t, http.MethodGet, server.URL+tc.path, 2, nil, nil); status != tc.want {
+			t.Errorf("GET %s got %d, want %d", tc.path, status, tc.want)
+		}
+	}
+
+	// The export job's URL must lead back through the prefix
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/chat/v1/users/me/export", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(asUser(t, req, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusAccepted || location != "/chat/v1/users/me/export/1" {
+		t.Fatalf("the export answered %d with Location %q, want 202 and /chat/v1/users/me/export/1", resp.StatusCode, location)
+	}
+	var export exportDocument
+	done := testutil.WaitFor(5*time.Second, func() bool {
+		return doJSON(t, http.MethodGet, server.URL+location, 2, nil, &export) == http.StatusOK && export.FormatVersion != 0
+	})
+	if !done {
+		t.Fatalf("the export at %s never became ready", location)
+	}
+	if len(export.Messages) != 3 {
+		t.Errorf("the export has %d messages, want 3", len(export.Messages))
+	}
+}
+
+// TestUserResolverReplacesTokens authenticates with an application's own
+// resolver reading a session cookie: whoever it names is the user, whatever
+// it refuses gets 401 unauthenticated, and go-chat's own JWTs are no longer
+// accepted
+func TestUserResolverReplacesTokens(t *testing.T) {
+	const cookie = "app_session"
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	resolver := UserResolverFunc(func(r *http.Request) (int64, error) {
+		session, err := r.Cookie(cookie)
+		if err != nil {
+			return 0, errors.New("not signed in")
+		}
+		switch session.Value {
+		case "ada":
+			return 1, nil
+		case "grace":
+			return 2, nil
+		case "nobody":
+			return 0, nil // Signed in, but without a chat account
+		}
+		return 0, errors.New("unknown session")
+	})
+	chat := newEmbeddedChat(t, ts, filepath.Join(t.TempDir(), "missing.env"), WithUserResolver(resolver))
+	server := httptest.NewServer(chat.Handler())
+	t.Cleanup(server.Close)
+
+	me := func(session, bearer string) (int, *store.User, errorBody) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/v1/auth/me", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if session != "" {
+			req.AddCookie(&http.Cookie{Name: cookie, Value: session})
+		}
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var user store.User
+		var failure errorBody
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(&user)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&failure)
+		}
+		if err != nil {
+			t.Fatalf("decoding the response: %v", err)
+		}
+		return resp.StatusCode, &user, failure
+	}
+
+	for _, tc := range []struct {
+		session string
+		userID  int64
+	}{
+		{"ada", 1},
+		{"grace", 2},
+	} {
+		if status, user, _ := me(tc.session, ""); status != http.StatusOK || user.ID != tc.userID {
+			t.Errorf("session %s got %d as user %d, want 200 as user %d", tc.session, status, user.ID, tc.userID)
+		}
+	}
+
+	token, err := auth.GenerateToken(1, 0, 0, time.Now().Add(time.Hour), testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name    string
+		session string
+		bearer  string
+	}{
+		{"no session", "", ""},
+		{"unknown session", "mallory", ""},
+		{"no chat account", "nobody", ""},
+		{"go-chat JWT only", "", token},
+	} {
+		if status, _, failure := me(tc.session, tc.bearer); status != http.StatusUnauthorized || failure.Code != "unauthenticated" {
+			t.Errorf("%s got %d %q, want 401 unauthenticated", tc.name, status, failure.Code)
+		}
+	}
+}
+
+// TestReload has an embedded Server re-read its .env file and wordlist when
+// asked, as the binary does on SIGHUP: a valid change is applied, and an
+// invalid one is returned with the current values kept
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "chat.env")
+	wordlist := filepath.Join(dir, "wordlist.txt")
+	write := func(path, text string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(text), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(envFile, "") // Loading it mustn't leave settings in the environment for other tests
+	write(wordlist, "darn\n")
+	t.Setenv("CONTENT_FILTER_WORDLIST", wordlist)
+	chat := newEmbeddedChat(t, newTestStore(t), envFile)
+	masked := func(word string) bool {
+		verdict, _, _ := chat.app.wordlist.Check(context.Background(), word)
+		return verdict == content.VerdictMask
+	}
+
+	write(envFile, "USER_SEARCH_RATE_LIMIT=20\n")
+	write(wordlist, "heck\n")
+	if err := chat.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if limit := chat.app.runtime.Load().UserSearchRateLimit; limit != 20 {
+		t.Errorf("the search limit is %d after reloading, want 20", limit)
+	}
+	if masked("darn") || !masked("heck") {
+		t.Error("the wordlist wasn't reloaded")
+	}
+
+	write(envFile, "USER_SEARCH_RATE_LIMIT=lots\n")
+	os.Remove(wordlist)
+	err := chat.Reload()
+	var problems configError
+	if !errors.As(err, &problems) || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("reloading bad settings and a missing wordlist got %v, want both errors", err)
+	}
+	if limit := chat.app.runtime.Load().UserSearchRateLimit; limit != 20 || !masked("heck") {
+		t.Errorf("after a failed reload the search limit is %d, want 20 and the wordlist kept", limit)
+	}
+}
+
+// TestBackgroundJobsStop runs each periodic job with a context that's
+// already done: each returns instead of waiting for its next tick
+func TestBackgroundJobsStop(t *testing.T) {
+	app := newTestApp(newTestStore(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for name, run := range map[string]func(context.Context){
+		"device pruner":     app.runDevicePruner,
+		"session purger":    app.runSessionPurger,
+		"room purger":       app.runRoomPurger,
+		"room event purger": app.runRoomEventPurger,
+	} {
+		done := make(chan struct{})
+		go func() {
+			run(ctx)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Errorf("the %s kept running after its context was done", name)
+		}
+	}
+}
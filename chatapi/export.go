@@ -0,0 +1,268 @@
+package chatapi
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+const (
+	// exportFormatVersion is bumped whenever the export document changes shape
+	// Fields are only ever added within a version, never renamed or removed
+	exportFormatVersion = 1
+
+	// exportInterval is how often a user may request an export
+	exportInterval = 24 * time.Hour
+)
+
+// exportHeader is everything in an export except the message list
+// The message list can be huge, so it's streamed after this part
+//
+// Document layout (format_version 1):
+//
+//	{
+//	  "format_version": 1,
+//	  "generated_at":   "2024-01-01T00:00:00Z",
+//	  "warnings":       ["messages in random are deleted after 24h0m0s; older ones are not included"],
+//	  "profile":        {"id", "username", "email", "created_at", "updated_at"},
+//	  "memberships":    [{"room_id", "room_name", "role", "joined_at", "retention_seconds"}],
+//	  "join_requests":  [{"room_id", "user_id", "status", "created_at", "decided_at", "decided_by"}],
+//	  "devices":        [{"id", "user_id", "name", "created_at", "last_active_at"}],
+//	  "posts":          [{"id", "title", "content", "user_id", "tags", "created_at", "updated_at"}],
+//	  "messages":       [{"id", "room_id", "room_name", "content", "content_type", "created_at"}]
+//	}
+type exportHeader struct {
+	FormatVersion int                         `json:"format_version"`
+	GeneratedAt   time.Time                   `json:"generated_at"`
+	Warnings      []string                    `json:"warnings"` // What the export is missing, e.g. messages past a room's retention
+	Profile       *store.User                 `json:"profile"`
+	Memberships   []*store.ExportedMembership `json:"memberships"`
+	JoinRequests  []*store.JoinRequest        `json:"join_requests"`
+	Devices       []*store.Device             `json:"devices"`
+	Posts         []*store.Post               `json:"posts"`
+}
+
+// writeExport writes a user's complete data export as JSON to w
+// Messages are streamed one at a time, so memory use doesn't depend on how
+// many messages the user has written
+func (app *application) writeExport(ctx context.Context, w io.Writer, userID int64) error {
+	header := exportHeader{FormatVersion: exportFormatVersion, GeneratedAt: app.now().UTC()}
+
+	var err error
+	if header.Profile, err = app.store.Users.GetByID(ctx, userID); err != nil {
+		return err
+	}
+	if header.Memberships, err = app.store.Exports.GetUserMemberships(ctx, userID); err != nil {
+		return err
+	}
+	header.Warnings = retentionWarnings(header.Memberships)
+	if header.JoinRequests, err = app.store.Exports.GetUserJoinRequests(ctx, userID); err != nil {
+		return err
+	}
+	if header.Devices, err = app.store.Exports.GetUserDevices(ctx, userID); err != nil {
+		return err
+	}
+	if header.Posts, err = app.store.Exports.GetUserPosts(ctx, userID); err != nil {
+		return err
+	}
+
+	// Write the header object without its closing brace, then append the
+	// messages array and close the object ourselves
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(headerJSON[:len(headerJSON)-1]); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, `,"messages":[`); err != nil {
+		return err
+	}
+
+	first := true
+	err = app.store.Exports.StreamUserMessages(ctx, userID, func(m *store.ExportedMessage) error {
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+
+		messageJSON, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(messageJSON)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+// exportUserDataHandler exports everything stored about the current user
+// GET /v1/users/me/export
+// Requires authentication; limited to one export per day
+// Small exports are streamed straight back as a JSON download (200)
+// Users with more messages than the configured threshold get an export job
+// instead (202) and download the file from /v1/users/me/export/{jobID}
+func (app *application) exportUserDataHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	messageCount, err := app.store.Exports.CountUserMessages(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "export_failed")
+		return
+	}
+
+	job, err := app.store.Exports.CreateJob(r.Context(), userID, app.now().Add(-exportInterval))
+	if err != nil {
+		if errors.Is(err, store.ErrExportRateLimited) {
+			writeError(w, r, http.StatusTooManyRequests, "export_rate_limited")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "export_failed")
+		return
+	}
+
+	if messageCount > app.config.export.asyncThreshold {
+		go app.runExportJob(job)
+
+		w.Header().Set("Location", app.basePath+fmt.Sprintf("/v1/users/me/export/%d", job.ID))
+		writeJSON(w, http.StatusAccepted, job)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", exportDisposition(userID))
+	w.WriteHeader(http.StatusOK)
+
+	// Once streaming has started the status code can't change anymore; a
+	// failure leaves truncated (invalid) JSON, which clients can detect
+	if err := app.writeExport(r.Context(), w, userID); err != nil {
+		log.Printf("Export %d for user %d failed: %v", job.ID, userID, err)
+		app.store.Exports.FailJob(app.ctx, job.ID, err.Error())
+		return
+	}
+	app.store.Exports.CompleteJob(app.ctx, job.ID, "")
+}
+
+// runExportJob generates a large export in the background and records the result
+// This should be called in a goroutine: go app.runExportJob(job)
+func (app *application) runExportJob(job *store.ExportJob) {
+	ctx := app.ctx
+
+	path, err := app.writeExportFile(ctx, job)
+	if err != nil {
+		log.Printf("Export %d for user %d failed: %v", job.ID, job.UserID, err)
+		if err := app.store.Exports.FailJob(ctx, job.ID, "export generation failed"); err != nil {
+			log.Printf("Failed to mark export %d as failed: %v", job.ID, err)
+		}
+		return
+	}
+
+	if err := app.store.Exports.CompleteJob(ctx, job.ID, path); err != nil {
+		log.Printf("Failed to mark export %d as done: %v", job.ID, err)
+	}
+}
+
+// writeExportFile writes an export to the export directory and returns its path
+// A partially written file is removed on failure
+func (app *application) writeExportFile(ctx context.Context, job *store.ExportJob) (string, error) {
+	if err := os.MkdirAll(app.config.export.dir, 0o700); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(app.config.export.dir, fmt.Sprintf("export-%d.json", job.ID))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+
+	buffered := bufio.NewWriter(file)
+	err = app.writeExport(ctx, buffered, job.UserID)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	return path, nil
+}
+
+// getExportJobHandler reports the status of an export job or downloads it
+// GET /v1/users/me/export/{jobID}
+// Requires authentication; users can only see their own exports
+// Returns the job ({"id": 3, "status": "running", ...}) until an async export
+// is ready, then the export file itself
+func (app *application) getExportJobHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	jobID, err := extractIDFromURL(r, "jobID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "jobID")
+		return
+	}
+
+	job, err := app.store.Exports.GetJob(r.Context(), jobID, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "export_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "export_lookup_failed")
+		return
+	}
+
+	// Running, failed, and immediate (already downloaded) exports report their status
+	if job.Status != store.ExportDone || job.FilePath == "" {
+		writeJSON(w, http.StatusOK, job)
+		return
+	}
+
+	file, err := os.Open(job.FilePath)
+	if err != nil {
+		writeError(w, r, http.StatusGone, "export_file_missing")
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", exportDisposition(userID))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, file); err != nil {
+		log.Printf("Failed to send export %d: %v", job.ID, err)
+	}
+}
+
+// exportDisposition makes browsers save the export as a file
+func exportDisposition(userID int64) string {
+	return fmt.Sprintf(`attachment; filename="go-chat-export-%d.json"`, userID)
+}
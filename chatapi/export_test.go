@@ -0,0 +1,186 @@
+package chatapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+)
+
+// fakeClock is a clock that only moves when the test moves it
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// exportDocument is the part of an export the tests look at
+type exportDocument struct {
+	FormatVersion int       `json:"format_version"`
+	GeneratedAt   time.Time `json:"generated_at"`
+	Profile       struct {
+		ID int64 `json:"id"`
+	} `json:"profile"`
+	Messages []json.RawMessage `json:"messages"`
+}
+
+// withExports writes async exports, of users with more than asyncThreshold
+// messages, to a directory removed when the test ends
+func withExports(t *testing.T, asyncThreshold int) testOption {
+	dir := t.TempDir()
+	return func(app *application) {
+		app.config.export = exportConfig{dir: dir, asyncThreshold: asyncThreshold}
+	}
+}
+
+// TestSmallExport exports a user below the async threshold: the export comes
+// straight back, dated by the clock, and the next one is refused until a day
+// has passed
+func TestSmallExport(t *testing.T) {
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	ts.exports.addMessages(2, 1, 3)
+	clock := newFakeClock()
+	ts.exports.now = clock.Now
+	server := newTestServer(t, ts, withClock(clock), withExports(t, 10))
+	url := server.URL + "/v1/users/me/export"
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(asUser(t, req, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("the export answered %d, want 200", resp.StatusCode)
+	}
+	if got, want := resp.Header.Get("Content-Disposition"), exportDisposition(2); got != want {
+		t.Errorf("Content-Disposition is %q, want %q", got, want)
+	}
+	var export exportDocument
+	if err := json.NewDecoder(resp.Body).Decode(&export); err != nil {
+		t.Fatalf("decoding the export: %v", err)
+	}
+	if export.FormatVersion != exportFormatVersion || export.Profile.ID != 2 || len(export.Messages) != 3 {
+		t.Errorf("got format %d for user %d with %d messages, want format %d for user 2 with 3",
+			export.FormatVersion, export.Profile.ID, len(export.Messages), exportFormatVersion)
+	}
+	if !export.GeneratedAt.Equal(clock.Now()) {
+		t.Errorf("the export is dated %s, want %s", export.GeneratedAt, clock.Now())
+	}
+	if !testutil.WaitFor(time.Second, func() bool { return jobStatus(ts, 1) == store.ExportDone }) {
+		t.Errorf("the export's job is %s, want done", jobStatus(ts, 1))
+	}
+
+	assertExportLimit(t, url, clock, http.StatusOK)
+}
+
+// TestLargeExport exports a user above the async threshold: a job is started
+// and its URL serves the file once it's written, dated by the clock; the next
+// export is refused until a day has passed
+func TestLargeExport(t *testing.T) {
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	ts.exports.addMessages(2, 1, 5)
+	clock := newFakeClock()
+	ts.exports.now = clock.Now
+	server := newTestServer(t, ts, withClock(clock), withExports(t, 2))
+	url := server.URL + "/v1/users/me/export"
+	started := clock.Now()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(asUser(t, req, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var job store.ExportJob
+	err = json.NewDecoder(resp.Body).Decode(&job)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decoding the job: %v", err)
+	}
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusAccepted || location != "/v1/users/me/export/1" {
+		t.Fatalf("the export answered %d with Location %q, want 202 and /v1/users/me/export/1", resp.StatusCode, location)
+	}
+	if job.ID != 1 || job.Status != store.ExportRunning || !job.CreatedAt.Equal(started) {
+		t.Errorf("the job is %+v, want job 1 running since %s", job, started)
+	}
+
+	var export exportDocument
+	done := testutil.WaitFor(5*time.Second, func() bool {
+		return doJSON(t, http.MethodGet, server.URL+location, 2, nil, &export) == http.StatusOK && export.FormatVersion != 0
+	})
+	if !done {
+		t.Fatalf("the export at %s never became ready", location)
+	}
+	if export.Profile.ID != 2 || len(export.Messages) != 5 {
+		t.Errorf("got user %d with %d messages, want user 2 with 5", export.Profile.ID, len(export.Messages))
+	}
+	if !export.GeneratedAt.Equal(started) {
+		t.Errorf("the export is dated %s, want %s", export.GeneratedAt, started)
+	}
+
+	// Someone else can't see it
+	var failure errorBody
+	if status := doJSON(t, http.MethodGet, server.URL+location, 3, nil, &failure); status != http.StatusNotFound || failure.Code != "export_not_found" {
+		t.Errorf("another user got %d %q for the export, want 404 export_not_found", status, failure.Code)
+	}
+
+	assertExportLimit(t, url, clock, http.StatusAccepted)
+	// Let the second job finish before its directory is removed
+	if !testutil.WaitFor(5*time.Second, func() bool { return jobStatus(ts, 2) == store.ExportDone }) {
+		t.Errorf("the second export's job is %s, want done", jobStatus(ts, 2))
+	}
+}
+
+// jobStatus returns the status of user 2's export job jobID, empty if there's none
+func jobStatus(ts *testStore, jobID int64) string {
+	job, err := ts.exports.GetJob(context.Background(), jobID, 2)
+	if err != nil {
+		return ""
+	}
+	return job.Status
+}
+
+// assertExportLimit checks user 2, who just exported, is refused another
+// export until exportInterval has passed on clock, and then gets one (want)
+func assertExportLimit(t *testing.T, url string, clock *fakeClock, want int) {
+	t.Helper()
+	clock.Advance(exportInterval - time.Second)
+	var failure errorBody
+	if status := doJSON(t, http.MethodGet, url, 2, nil, &failure); status != http.StatusTooManyRequests || failure.Code != "export_rate_limited" {
+		t.Errorf("an export a second short of a day later got %d %q, want 429 export_rate_limited", status, failure.Code)
+	}
+
+	clock.Advance(time.Second)
+	if status := doJSON(t, http.MethodGet, url, 2, nil, nil); status != want {
+		t.Errorf("an export a day later got %d, want %d", status, want)
+	}
+}
@@ -0,0 +1,2614 @@
+package chatapi
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/schedule"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/lib/pq"
+)
+
+// A fake embeds the store it replaces and overrides what its tests use; every
+// other method goes to the embedded store, which fails like a database that's
+// down (see newTestStore)
+
+// fakeUsers keeps users in memory
+type fakeUsers struct {
+	*store.UserStore
+	mu     sync.Mutex
+	users  map[int64]*store.User
+	roles  map[int64]string // Server roles other than member
+	banned map[int64]bool
+
+	// Token versions other than 0
+	tokenVersions map[int64]int64
+
+	// Banning and revoking tokens sign the user out of these
+	sessions  *fakeSessions
+	apiTokens *fakeAPITokens
+}
+
+// add saves a user under their ID
+func (f *fakeUsers) add(user *store.User) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users[user.ID] = user
+}
+
+// EnsureSystemUser does nothing; tests that need the system user add it
+func (f *fakeUsers) EnsureSystemUser(context.Context, string) error {
+	return nil
+}
+
+func (f *fakeUsers) GetRole(_ context.Context, id int64) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.users[id]; !ok {
+		return "", sql.ErrNoRows
+	}
+	if role, ok := f.roles[id]; ok {
+		return role, nil
+	}
+	return store.UserRoleMember, nil
+}
+
+func (f *fakeUsers) SetRole(_ context.Context, id int64, role string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.users[id]; !ok {
+		return sql.ErrNoRows
+	}
+	f.roles[id] = role
+	return nil
+}
+
+func (f *fakeUsers) IsBanned(_ context.Context, id int64) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.users[id]; !ok {
+		return false, sql.ErrNoRows
+	}
+	return f.banned[id], nil
+}
+
+// Ban bumps the user's token version and deletes their sessions and tokens,
+// as the transaction does
+func (f *fakeUsers) Ban(_ context.Context, id int64) ([]int64, error) {
+	f.mu.Lock()
+	if _, ok := f.users[id]; !ok || f.roles[id] == store.UserRoleAdmin {
+		f.mu.Unlock()
+		return nil, sql.ErrNoRows
+	}
+	f.banned[id] = true
+	f.tokenVersions[id]++
+	f.mu.Unlock()
+
+	f.deleteAPITokens(id)
+	return f.deleteSessions(id), nil
+}
+
+// deleteAPITokens deletes a user's personal access tokens
+func (f *fakeUsers) deleteAPITokens(id int64) {
+	f.apiTokens.mu.Lock()
+	defer f.apiTokens.mu.Unlock()
+	for hash, token := range f.apiTokens.tokens {
+		if token.UserID == id {
+			delete(f.apiTokens.tokens, hash)
+		}
+	}
+}
+
+// deleteSessions deletes a user's sessions and returns their IDs, in order
+func (f *fakeUsers) deleteSessions(id int64) []int64 {
+	f.sessions.mu.Lock()
+	defer f.sessions.mu.Unlock()
+	ids := make([]int64, 0)
+	for sessionID, session := range f.sessions.sessions {
+		if session.UserID == id {
+			ids = append(ids, sessionID)
+			delete(f.sessions.sessions, sessionID)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func (f *fakeUsers) GetTokenVersion(_ context.Context, id int64) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.users[id]; !ok {
+		return 0, sql.ErrNoRows
+	}
+	return f.tokenVersions[id], nil
+}
+
+// RevokeTokens bumps the user's token version and deletes their sessions
+func (f *fakeUsers) RevokeTokens(_ context.Context, id int64) (int64, []int64, error) {
+	f.mu.Lock()
+	if _, ok := f.users[id]; !ok {
+		f.mu.Unlock()
+		return 0, nil, sql.ErrNoRows
+	}
+	f.tokenVersions[id]++
+	version := f.tokenVersions[id]
+	f.mu.Unlock()
+
+	return version, f.deleteSessions(id), nil
+}
+
+func (f *fakeUsers) Unban(_ context.Context, id int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.users[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(f.banned, id)
+	return nil
+}
+
+func (f *fakeUsers) GetByID(_ context.Context, id int64) (*store.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user, ok := f.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (f *fakeUsers) GetByUsernames(_ context.Context, usernames []string, ids []int64) ([]*store.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	users := make([]*store.User, 0)
+	for _, user := range f.users {
+		if slices.Contains(usernames, user.Username) || slices.Contains(ids, user.ID) {
+			copied := *user
+			users = append(users, &copied)
+		}
+	}
+	return users, nil
+}
+
+func (f *fakeUsers) GetByEmail(_ context.Context, email string) (*store.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, user := range f.users {
+		if user.Email == email {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (f *fakeUsers) GetByEmails(_ context.Context, emails []string) ([]*store.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	users := make([]*store.User, 0)
+	for _, user := range f.users {
+		if slices.Contains(emails, store.NormalizeEmail(user.Email)) {
+			copied := *user
+			users = append(users, &copied)
+		}
+	}
+	return users, nil
+}
+
+func (f *fakeUsers) GetByUsername(_ context.Context, username string) (*store.PublicUser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, user := range f.users {
+		if user.Username == username {
+			return &store.PublicUser{ID: user.ID, Username: user.Username, DisplayName: user.DisplayName}, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// Search returns discoverable users whose username contains q, by ID
+// Ranking is the store's job and is tested there
+func (f *fakeUsers) Search(_ context.Context, q string, limit int) ([]*store.PublicUser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	users := make([]*store.PublicUser, 0)
+	for _, user := range f.users {
+		if user.Discoverable && strings.Contains(user.Username, q) {
+			users = append(users, &store.PublicUser{ID: user.ID, Username: user.Username, DisplayName: user.DisplayName})
+		}
+	}
+	slices.SortFunc(users, func(a, b *store.PublicUser) int { return cmp.Compare(a.ID, b.ID) })
+	return users[:min(limit, len(users))], nil
+}
+
+func (f *fakeUsers) Delete(_ context.Context, id int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.users, id)
+	return nil
+}
+
+func (f *fakeUsers) UpdateProfile(_ context.Context, userID int64, displayName *string, discoverable *bool, expectedVersion int64) (*store.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user, ok := f.users[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if expectedVersion != 0 && user.Version != expectedVersion {
+		return nil, store.ErrVersionConflict
+	}
+	user.Version++
+	if displayName != nil {
+		user.DisplayName = *displayName
+	}
+	if discoverable != nil {
+		user.Discoverable = *discoverable
+	}
+	copied := *user
+	return &copied, nil
+}
+
+// fakeRooms keeps rooms in memory
+// Soft-deleted rooms stay in rooms, with their deletion time in deleted
+// Created rooms are stamped with now, which tests may replace with a fake clock
+type fakeRooms struct {
+	*store.RoomStore
+	mu      sync.Mutex
+	rooms   map[int64]*store.Room
+	deleted map[int64]time.Time
+	now     func() time.Time
+}
+
+// isDeleted reports whether the room was soft-deleted
+func (f *fakeRooms) isDeleted(id int64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.deleted[id]
+	return ok
+}
+
+// add saves a room under its ID
+func (f *fakeRooms) add(room *store.Room) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rooms[room.ID] = room
+}
+
+func (f *fakeRooms) GetByID(_ context.Context, id int64) (*store.Room, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	room, ok := f.rooms[id]
+	if _, deleted := f.deleted[id]; !ok || deleted {
+		return nil, sql.ErrNoRows
+	}
+	copied := *room
+	return &copied, nil
+}
+
+// Create gives the room the next free ID
+func (f *fakeRooms) Create(_ context.Context, room *store.Room) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, existing := range f.rooms {
+		room.ID = max(room.ID, existing.ID)
+	}
+	room.ID++
+	room.CreatedAt, room.UpdatedAt = f.now(), f.now()
+	copied := *room
+	f.rooms[room.ID] = &copied
+	return nil
+}
+
+// CountCreatedSince counts the user's rooms created after since, deleted or
+// not, as the store's query does
+func (f *fakeRooms) CountCreatedSince(_ context.Context, userID int64, since time.Time) (int, time.Time, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var (
+		count  int
+		oldest time.Time
+	)
+	for _, room := range f.rooms {
+		if room.CreatedBy != userID || !room.CreatedAt.After(since) {
+			continue
+		}
+		if count == 0 || room.CreatedAt.Before(oldest) {
+			oldest = room.CreatedAt
+		}
+		count++
+	}
+	return count, oldest, nil
+}
+
+func (f *fakeRooms) CountOwnedActive(_ context.Context, userID int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var count int
+	for id, room := range f.rooms {
+		if _, deleted := f.deleted[id]; room.CreatedBy == userID && !deleted {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (f *fakeRooms) Delete(_ context.Context, id int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.rooms, id)
+	return nil
+}
+
+func (f *fakeRooms) SoftDelete(_ context.Context, id int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, deleted := f.deleted[id]; f.rooms[id] == nil || deleted {
+		return sql.ErrNoRows
+	}
+	f.deleted[id] = time.Now()
+	return nil
+}
+
+func (f *fakeRooms) GetDeletedByID(_ context.Context, id int64, window time.Duration) (*store.Room, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	deletedAt, deleted := f.deleted[id]
+	if !deleted || time.Since(deletedAt) >= window {
+		return nil, sql.ErrNoRows
+	}
+	copied := *f.rooms[id]
+	return &copied, nil
+}
+
+func (f *fakeRooms) Restore(_ context.Context, id int64, window time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	deletedAt, deleted := f.deleted[id]
+	if !deleted || time.Since(deletedAt) >= window {
+		return sql.ErrNoRows
+	}
+	delete(f.deleted, id)
+	f.rooms[id].Version++
+	return nil
+}
+
+func (f *fakeRooms) SetDefault(_ context.Context, id int64, isDefault bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	room, ok := f.rooms[id]
+	if _, deleted := f.deleted[id]; !ok || deleted {
+		return sql.ErrNoRows
+	}
+	room.IsDefault = isDefault
+	return nil
+}
+
+func (f *fakeRooms) Update(_ context.Context, room *store.Room, expectedVersion int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	current, ok := f.rooms[room.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if expectedVersion != 0 && current.Version != expectedVersion {
+		return store.ErrVersionConflict
+	}
+	room.UpdatedAt = time.Now()
+	room.Version = current.Version + 1
+	copied := *room
+	f.rooms[room.ID] = &copied
+	return nil
+}
+
+func (f *fakeRooms) IsContentFilterEnabled(_ context.Context, id int64) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	room, ok := f.rooms[id]
+	if !ok {
+		return false, sql.ErrNoRows
+	}
+	return room.ContentFilterEnabled, nil
+}
+
+func (f *fakeRooms) IsDuplicateLimitEnabled(_ context.Context, id int64) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	room, ok := f.rooms[id]
+	if !ok {
+		return false, sql.ErrNoRows
+	}
+	return room.DuplicateLimitEnabled, nil
+}
+
+func (f *fakeRooms) GetQuietHours(_ context.Context, id int64) (*schedule.Window, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	room, ok := f.rooms[id]
+	if !ok {
+		return nil, 0, sql.ErrNoRows
+	}
+	return room.QuietHours, room.CreatedBy, nil
+}
+
+func (f *fakeRooms) GetPostPolicy(_ context.Context, id int64) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	room, ok := f.rooms[id]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	if room.PostPolicy == "" {
+		return store.PostPolicyEveryone, nil
+	}
+	return room.PostPolicy, nil
+}
+
+// List returns the rooms that aren't deleted, newest first, keeping those
+// with opts.Tag if it's set
+// Sorting by activity is the store's job and is tested there
+func (f *fakeRooms) List(_ context.Context, opts store.RoomListOptions) ([]*store.Room, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rooms := make([]*store.Room, 0)
+	for id, room := range f.rooms {
+		if _, deleted := f.deleted[id]; deleted || (opts.Tag != "" && !slices.Contains(room.Tags, opts.Tag)) {
+			continue
+		}
+		copied := *room
+		rooms = append(rooms, &copied)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].ID > rooms[j].ID })
+	return rooms, nil
+}
+
+// ListTags counts the tags of rooms that aren't deleted or invite-only,
+// most used first
+func (f *fakeRooms) ListTags(context.Context) ([]*store.TagCount, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	counts := make(map[string]int)
+	for id, room := range f.rooms {
+		if _, deleted := f.deleted[id]; deleted || room.JoinPolicy == store.JoinPolicyInvite {
+			continue
+		}
+		for _, tag := range room.Tags {
+			counts[tag]++
+		}
+	}
+	tags := make([]*store.TagCount, 0, len(counts))
+	for tag, rooms := range counts {
+		tags = append(tags, &store.TagCount{Tag: tag, Rooms: rooms})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Rooms != tags[j].Rooms {
+			return tags[i].Rooms > tags[j].Rooms
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	return tags, nil
+}
+
+// Recommend suggests every room that isn't invite-only, newest first
+// The ranking itself is the store's job and is tested there
+func (f *fakeRooms) Recommend(_ context.Context, _ int64, limit int) ([]*store.RoomRecommendation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	recommendations := make([]*store.RoomRecommendation, 0)
+	for _, room := range f.rooms {
+		if room.JoinPolicy != store.JoinPolicyInvite {
+			copied := *room
+			recommendations = append(recommendations, &store.RoomRecommendation{Room: &copied})
+		}
+	}
+	sort.Slice(recommendations, func(i, j int) bool { return recommendations[i].ID > recommendations[j].ID })
+	return recommendations[:min(limit, len(recommendations))], nil
+}
+
+// fakeMessages keeps messages in memory, oldest first
+type fakeMessages struct {
+	*store.MessageStore
+	mu       sync.Mutex
+	messages []*store.Message
+}
+
+// addMessages saves n messages in roomID from userID
+func (f *fakeMessages) addMessages(roomID, userID int64, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := 0; i < n; i++ {
+		f.messages = append(f.messages, &store.Message{
+			ID:        int64(len(f.messages) + 1),
+			RoomID:    roomID,
+			UserID:    userID,
+			Content:   "message",
+			CreatedAt: time.Now(),
+		})
+	}
+}
+
+func (f *fakeMessages) Create(_ context.Context, message *store.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	message.ID = int64(len(f.messages) + 1)
+	message.CreatedAt = time.Now()
+	message.System = message.UserID == store.SystemUserID
+	copied := *message
+	f.messages = append(f.messages, &copied)
+	return nil
+}
+
+func (f *fakeMessages) CountRecentIdentical(_ context.Context, roomID, userID int64, contentHash string, window time.Duration) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	count := 0
+	for _, m := range f.messages {
+		if m.RoomID == roomID && m.UserID == userID && m.ContentHash == contentHash && time.Since(m.CreatedAt) < window {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (f *fakeMessages) CountInRoom(_ context.Context, roomID int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	count := 0
+	for _, m := range f.messages {
+		if m.RoomID == roomID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GetRoomMessages returns the room's newest limit messages, oldest first
+func (f *fakeMessages) GetRoomMessages(_ context.Context, roomID int64, limit int) ([]*store.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var messages []*store.Message
+	for _, m := range f.messages {
+		if m.RoomID == roomID {
+			copied := *m
+			messages = append(messages, &copied)
+		}
+	}
+	return messages[max(len(messages)-limit, 0):], nil
+}
+
+// GetMessagesBefore returns up to limit of the room's messages older than
+// beforeID, oldest first
+func (f *fakeMessages) GetMessagesBefore(_ context.Context, roomID, beforeID int64, limit int) ([]*store.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var messages []*store.Message
+	for _, m := range f.messages {
+		if m.RoomID == roomID && m.ID < beforeID {
+			copied := *m
+			messages = append(messages, &copied)
+		}
+	}
+	return messages[max(len(messages)-limit, 0):], nil
+}
+
+func (f *fakeMessages) GetByID(_ context.Context, id int64) (*store.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, m := range f.messages {
+		if m.ID == id {
+			copied := *m
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// GetMessagesAround returns the window around aroundID without clamping the
+// counts; the store clamps them and is tested for it
+func (f *fakeMessages) GetMessagesAround(_ context.Context, roomID, aroundID int64, before, after int) (*store.MessageWindow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var messages []*store.Message
+	anchor := -1
+	for _, m := range f.messages {
+		if m.RoomID == roomID {
+			if m.ID == aroundID {
+				anchor = len(messages)
+			}
+			copied := *m
+			messages = append(messages, &copied)
+		}
+	}
+	if anchor < 0 {
+		return nil, sql.ErrNoRows
+	}
+	start, end := max(anchor-before, 0), min(anchor+1+after, len(messages))
+	return &store.MessageWindow{
+		AnchorID:      aroundID,
+		Messages:      messages[start:end],
+		HasMoreBefore: start > 0,
+		HasMoreAfter:  end < len(messages),
+	}, nil
+}
+
+// Histogram counts the room's messages per UTC day, or per week starting
+// on Monday
+func (f *fakeMessages) Histogram(_ context.Context, roomID int64, granularity string, from, to time.Time) ([]*store.ActivityBucket, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var buckets []*store.ActivityBucket
+	senders := make(map[time.Time]map[int64]bool)
+	for _, m := range f.messages {
+		if m.RoomID != roomID || m.CreatedAt.Before(from) || !m.CreatedAt.Before(to) {
+			continue
+		}
+		at := m.CreatedAt.UTC()
+		start := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+		for granularity == store.HistogramWeek && start.Weekday() != time.Monday {
+			start = start.AddDate(0, 0, -1)
+		}
+		if len(buckets) == 0 || !buckets[len(buckets)-1].PeriodStart.Equal(start) {
+			buckets = append(buckets, &store.ActivityBucket{PeriodStart: start})
+			senders[start] = make(map[int64]bool)
+		}
+		bucket := buckets[len(buckets)-1]
+		bucket.MessageCount++
+		if m.UserID > 0 && !senders[start][m.UserID] {
+			senders[start][m.UserID] = true
+			bucket.ActiveUserCount++
+		}
+	}
+	return buckets, nil
+}
+
+func (f *fakeMessages) FirstMessageAt(_ context.Context, roomID int64, t time.Time) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, m := range f.messages {
+		if m.RoomID == roomID && !m.CreatedAt.Before(t) {
+			return m.ID, nil
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+// edit changes a message's content in place, as an edit would
+func (f *fakeMessages) edit(id int64, content string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, m := range f.messages {
+		if m.ID == id {
+			m.Content = content
+		}
+	}
+}
+
+// fakeTranslations keeps cached translations in memory, by message and
+// target language
+type fakeTranslations struct {
+	*store.MessageTranslationStore
+	mu     sync.Mutex
+	cached map[int64]map[string]*store.MessageTranslation
+}
+
+// Get discards a translation of other content, like the store
+func (f *fakeTranslations) Get(_ context.Context, messageID int64, targetLang, sourceHash string) (*store.MessageTranslation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cached, ok := f.cached[messageID][targetLang]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if cached.SourceHash != sourceHash {
+		delete(f.cached, messageID)
+		return nil, sql.ErrNoRows
+	}
+	copied := *cached
+	return &copied, nil
+}
+
+func (f *fakeTranslations) Save(_ context.Context, t *store.MessageTranslation) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cached[t.MessageID] == nil {
+		f.cached[t.MessageID] = make(map[string]*store.MessageTranslation)
+	}
+	t.CreatedAt = time.Now()
+	copied := *t
+	f.cached[t.MessageID][t.TargetLang] = &copied
+	return nil
+}
+
+func (f *fakeTranslations) Invalidate(_ context.Context, messageID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.cached, messageID)
+	return nil
+}
+
+// fakeSessions keeps login sessions in memory and counts the writes to
+// last_active_at
+type fakeSessions struct {
+	*store.SessionStore
+	mu       sync.Mutex
+	nextID   int64
+	sessions map[int64]*store.Session
+	touches  int
+}
+
+func (f *fakeSessions) Create(_ context.Context, session *store.Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	session.ID = f.nextID
+	session.CreatedAt = time.Now()
+	session.LastActiveAt = session.CreatedAt
+	copied := *session
+	f.sessions[session.ID] = &copied
+	return nil
+}
+
+func (f *fakeSessions) GetByID(_ context.Context, id int64) (*store.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	session, ok := f.sessions[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *session
+	return &copied, nil
+}
+
+func (f *fakeSessions) ListActive(_ context.Context, userID int64, idleCutoff time.Time) ([]*store.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sessions := make([]*store.Session, 0)
+	for _, session := range f.sessions {
+		if session.UserID == userID && session.LastActiveAt.After(idleCutoff) && session.ExpiresAt.After(time.Now()) {
+			copied := *session
+			sessions = append(sessions, &copied)
+		}
+	}
+	slices.SortFunc(sessions, func(a, b *store.Session) int { return cmp.Compare(b.ID, a.ID) })
+	return sessions, nil
+}
+
+func (f *fakeSessions) Touch(_ context.Context, id int64, ip string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if session, ok := f.sessions[id]; ok {
+		session.LastActiveAt = time.Now()
+		session.IP = ip
+		f.touches++
+	}
+	return nil
+}
+
+func (f *fakeSessions) Revoke(_ context.Context, id, userID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if session, ok := f.sessions[id]; !ok || session.UserID != userID {
+		return sql.ErrNoRows
+	}
+	delete(f.sessions, id)
+	return nil
+}
+
+// fakeAPITokens keeps personal access tokens in memory, by hash, and
+// counts the writes to last_used_at
+type fakeAPITokens struct {
+	*store.APITokenStore
+	mu      sync.Mutex
+	nextID  int64
+	tokens  map[string]*store.APIToken
+	touches int
+}
+
+func (f *fakeAPITokens) Create(_ context.Context, token *store.APIToken, hash string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	token.ID = f.nextID
+	token.CreatedAt = time.Now()
+	copied := *token
+	f.tokens[hash] = &copied
+	return nil
+}
+
+func (f *fakeAPITokens) List(_ context.Context, userID int64) ([]*store.APIToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tokens := make([]*store.APIToken, 0)
+	for _, token := range f.tokens {
+		if token.UserID == userID {
+			copied := *token
+			tokens = append(tokens, &copied)
+		}
+	}
+	slices.SortFunc(tokens, func(a, b *store.APIToken) int { return cmp.Compare(b.ID, a.ID) })
+	return tokens, nil
+}
+
+func (f *fakeAPITokens) GetByHash(_ context.Context, hash string) (*store.APIToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	token, ok := f.tokens[hash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *token
+	return &copied, nil
+}
+
+func (f *fakeAPITokens) Touch(_ context.Context, tokenID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, token := range f.tokens {
+		if token.ID == tokenID {
+			now := time.Now()
+			token.LastUsedAt = &now
+			f.touches++
+		}
+	}
+	return nil
+}
+
+func (f *fakeAPITokens) Revoke(_ context.Context, tokenID, userID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for hash, token := range f.tokens {
+		if token.ID == tokenID && token.UserID == userID {
+			delete(f.tokens, hash)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// fakeAPIKeys keeps bot API keys in memory, by hash; like the query, the
+// lookup skips keys of banned users
+type fakeAPIKeys struct {
+	*store.APIKeyStore
+	mu     sync.Mutex
+	nextID int64
+	keys   map[string]*store.APIKey
+	users  *fakeUsers
+}
+
+func (f *fakeAPIKeys) Create(_ context.Context, key *store.APIKey, hash string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	key.ID = f.nextID
+	key.CreatedAt = time.Now()
+	copied := *key
+	f.keys[hash] = &copied
+	return nil
+}
+
+func (f *fakeAPIKeys) List(_ context.Context, userID int64) ([]*store.APIKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]*store.APIKey, 0)
+	for _, key := range f.keys {
+		if key.UserID == userID {
+			copied := *key
+			keys = append(keys, &copied)
+		}
+	}
+	slices.SortFunc(keys, func(a, b *store.APIKey) int { return cmp.Compare(b.ID, a.ID) })
+	return keys, nil
+}
+
+func (f *fakeAPIKeys) GetByHash(ctx context.Context, hash string) (*store.APIKey, error) {
+	f.mu.Lock()
+	key, ok := f.keys[hash]
+	var copied store.APIKey
+	if ok {
+		copied = *key
+	}
+	f.mu.Unlock()
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if banned, err := f.users.IsBanned(ctx, copied.UserID); err != nil || banned {
+		return nil, sql.ErrNoRows
+	}
+	return &copied, nil
+}
+
+func (f *fakeAPIKeys) Touch(_ context.Context, keyID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, key := range f.keys {
+		if key.ID == keyID {
+			now := time.Now()
+			key.LastUsedAt = &now
+		}
+	}
+	return nil
+}
+
+func (f *fakeAPIKeys) Revoke(_ context.Context, keyID, userID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for hash, key := range f.keys {
+		if key.ID == keyID && key.UserID == userID {
+			delete(f.keys, hash)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// fakeRoomMembers keeps each room's members and their roles in memory
+type fakeRoomMembers struct {
+	*store.RoomMemberStore
+	mu     sync.Mutex
+	roles  map[int64]map[int64]string // By room, then user
+	limits store.Limits
+	rooms  *fakeRooms // Memberships of deleted rooms don't count
+	users  *fakeUsers // For resolving mentions
+}
+
+// add makes userID a member of roomID with role
+func (f *fakeRoomMembers) add(roomID, userID int64, role string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.roles[roomID] == nil {
+		f.roles[roomID] = make(map[int64]string)
+	}
+	f.roles[roomID][userID] = role
+}
+
+func (f *fakeRoomMembers) Join(ctx context.Context, roomID, userID, actorID int64) error {
+	return f.JoinWithOptions(ctx, roomID, userID, store.JoinOptions{ActorID: actorID})
+}
+
+// JoinWithOptions fails like the primary key does for existing members, and
+// like addMember does at the global limits (rooms' own limits aren't faked)
+func (f *fakeRoomMembers) JoinWithOptions(_ context.Context, roomID, userID int64, opts store.JoinOptions) error {
+	role := opts.Role
+	if role == "" {
+		role = store.RoomRoleMember
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.roles[roomID][userID]; ok {
+		return &pq.Error{Code: "23505", Message: `duplicate key value violates unique constraint "room_members_pkey"`}
+	}
+	if f.limits.MaxRoomsPerUser > 0 && f.userRoomCount(userID) >= f.limits.MaxRoomsPerUser {
+		return store.ErrTooManyRooms
+	}
+	if f.limits.MaxRoomMembers > 0 && len(f.roles[roomID]) >= f.limits.MaxRoomMembers {
+		return store.ErrRoomFull
+	}
+	if f.roles[roomID] == nil {
+		f.roles[roomID] = make(map[int64]string)
+	}
+	f.roles[roomID][userID] = role
+	return nil
+}
+
+// JoinIfAbsent joins as JoinWithOptions would, reporting existing members
+// as not added instead of failing
+func (f *fakeRoomMembers) JoinIfAbsent(ctx context.Context, roomID, userID int64) (bool, error) {
+	err := f.JoinWithOptions(ctx, roomID, userID, store.JoinOptions{ActorID: userID})
+	if store.IsUniqueViolation(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// AddMembers joins each user as JoinWithOptions would, reporting why any
+// couldn't be added
+func (f *fakeRoomMembers) AddMembers(ctx context.Context, roomID int64, userIDs []int64, actorID int64) (map[int64]string, error) {
+	results := make(map[int64]string, len(userIDs))
+	for _, id := range userIDs {
+		switch err := f.JoinWithOptions(ctx, roomID, id, store.JoinOptions{ActorID: actorID}); {
+		case err == nil:
+			results[id] = store.BulkAdded
+		case errors.Is(err, store.ErrTooManyRooms):
+			results[id] = store.BulkQuotaExceeded
+		case errors.Is(err, store.ErrRoomFull):
+			results[id] = store.BulkRoomFull
+		default:
+			results[id] = store.BulkAlreadyMember
+		}
+	}
+	return results, nil
+}
+
+// RemoveMembers removes every user but the room's admins
+func (f *fakeRoomMembers) RemoveMembers(_ context.Context, roomID int64, userIDs []int64, _ int64) (map[int64]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	results := make(map[int64]string, len(userIDs))
+	for _, id := range userIDs {
+		role, ok := f.roles[roomID][id]
+		switch {
+		case !ok:
+			results[id] = store.BulkNotMember
+		case role == store.RoomRoleAdmin:
+			results[id] = store.BulkAdminProtected
+		default:
+			delete(f.roles[roomID], id)
+			results[id] = store.BulkRemoved
+		}
+	}
+	return results, nil
+}
+
+// Leave removes userID from roomID; leaving a room one isn't in is fine
+func (f *fakeRoomMembers) Leave(_ context.Context, roomID, userID, _ int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.roles[roomID], userID)
+	return nil
+}
+
+func (f *fakeRoomMembers) GetUserRoomCount(_ context.Context, userID int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.userRoomCount(userID), nil
+}
+
+// userRoomCount counts the rooms userID is in; f.mu must be held
+func (f *fakeRoomMembers) userRoomCount(userID int64) int {
+	var count int
+	for _, members := range f.roles {
+		if _, ok := members[userID]; ok {
+			count++
+		}
+	}
+	return count
+}
+
+func (f *fakeRoomMembers) IsUserInRoom(_ context.Context, roomID, userID int64) (bool, error) {
+	if f.rooms.isDeleted(roomID) {
+		return false, nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.roles[roomID][userID]
+	return ok, nil
+}
+
+// FindMembersByUsername resolves mentioned names to members of the room
+func (f *fakeRoomMembers) FindMembersByUsername(ctx context.Context, roomID int64, usernames []string) ([]int64, error) {
+	ids := make([]int64, 0)
+	for _, name := range usernames {
+		user, err := f.users.GetByUsername(ctx, name)
+		if err != nil {
+			continue
+		}
+		if member, _ := f.IsUserInRoom(ctx, roomID, user.ID); member {
+			ids = append(ids, user.ID)
+		}
+	}
+	return ids, nil
+}
+
+// GetMutual lists the rooms that aren't deleted with both users in them,
+// by ID; message counts and join times are the store's job and are tested there
+func (f *fakeRoomMembers) GetMutual(ctx context.Context, userID, otherID int64) (*store.MutualContext, error) {
+	mutual := &store.MutualContext{UserID: otherID, Rooms: make([]*store.MutualRoom, 0)}
+	for _, roomID := range f.roomIDs() {
+		room, err := f.rooms.GetByID(ctx, roomID)
+		if err != nil {
+			continue
+		}
+		f.mu.Lock()
+		_, a := f.roles[roomID][userID]
+		_, b := f.roles[roomID][otherID]
+		count := len(f.roles[roomID])
+		f.mu.Unlock()
+		if a && b {
+			mutual.Rooms = append(mutual.Rooms, &store.MutualRoom{ID: roomID, Name: room.Name, MemberCount: count})
+		}
+	}
+	return mutual, nil
+}
+
+// roomIDs returns the IDs of every room with members, in order
+func (f *fakeRoomMembers) roomIDs() []int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := slices.Collect(maps.Keys(f.roles))
+	slices.Sort(ids)
+	return ids
+}
+
+func (f *fakeRoomMembers) IsRoomAdmin(_ context.Context, roomID, userID int64) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.roles[roomID][userID] == store.RoomRoleAdmin, nil
+}
+
+func (f *fakeRoomMembers) SetRole(_ context.Context, roomID, userID int64, role string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.roles[roomID][userID]; !ok {
+		return sql.ErrNoRows
+	}
+	f.roles[roomID][userID] = role
+	return nil
+}
+
+func (f *fakeRoomMembers) GetRoomAdmins(_ context.Context, roomID int64) ([]int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	admins := make([]int64, 0)
+	for userID, role := range f.roles[roomID] {
+		if role == store.RoomRoleAdmin {
+			admins = append(admins, userID)
+		}
+	}
+	return admins, nil
+}
+
+// fakeMembershipEvents answers every query with the same events and keeps
+// the last query it was asked
+// Paging itself is the store's job and is tested there
+type fakeMembershipEvents struct {
+	*store.MembershipEventStore
+	mu     sync.Mutex
+	events []*store.MembershipEvent
+	last   store.MembershipEventQuery
+}
+
+func (f *fakeMembershipEvents) List(_ context.Context, _ int64, q store.MembershipEventQuery) ([]*store.MembershipEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.last = q
+	return f.events, nil
+}
+
+// fakePins keeps each room's pinned message IDs in order, with the room's
+// pins version, in memory
+// Messages aren't checked: any ID can be pinned
+type fakePins struct {
+	*store.PinStore
+	mu       sync.Mutex
+	pins     map[int64][]int64 // By room
+	versions map[int64]int64   // By room
+	events   *fakeRoomEvents   // Every change is logged here
+}
+
+func (f *fakePins) Pin(_ context.Context, roomID, messageID, userID int64) (store.PinChange, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if slices.Contains(f.pins[roomID], messageID) {
+		return store.PinChange{}, store.ErrAlreadyPinned
+	}
+	f.pins[roomID] = append(f.pins[roomID], messageID)
+	return f.changed(roomID, store.RoomEventPinAdded, map[string]any{"message_id": messageID, "pinned_by": userID}), nil
+}
+
+func (f *fakePins) Unpin(_ context.Context, roomID, messageID int64) (store.PinChange, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := slices.Index(f.pins[roomID], messageID)
+	if i < 0 {
+		return store.PinChange{}, sql.ErrNoRows
+	}
+	f.pins[roomID] = slices.Delete(f.pins[roomID], i, i+1)
+	return f.changed(roomID, store.RoomEventPinRemoved, map[string]any{"message_id": messageID}), nil
+}
+
+func (f *fakePins) Count(_ context.Context, roomID int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.pins[roomID]), nil
+}
+
+func (f *fakePins) List(_ context.Context, roomID int64) ([]*store.PinnedMessage, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pins := make([]*store.PinnedMessage, len(f.pins[roomID]))
+	for i, id := range f.pins[roomID] {
+		pins[i] = &store.PinnedMessage{MessageID: id, RoomID: roomID, Position: i + 1}
+	}
+	return pins, f.versions[roomID], nil
+}
+
+// Reorder checks the version and the set like the store does
+func (f *fakePins) Reorder(_ context.Context, roomID int64, messageIDs []int64, version int64) (store.PinChange, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if version != f.versions[roomID] {
+		return store.PinChange{}, store.ErrPinsVersionConflict
+	}
+	current, requested := slices.Clone(f.pins[roomID]), slices.Clone(messageIDs)
+	slices.Sort(current)
+	slices.Sort(requested)
+	if !slices.Equal(current, requested) {
+		return store.PinChange{}, store.ErrPinSetMismatch
+	}
+	f.pins[roomID] = slices.Clone(messageIDs)
+	return f.changed(roomID, store.RoomEventPinOrderChanged, map[string]any{"pinned_ids": messageIDs}), nil
+}
+
+// changed bumps a room's pins version and logs the change, like bumpPinsVersion
+func (f *fakePins) changed(roomID int64, eventType string, payload map[string]any) store.PinChange {
+	f.versions[roomID]++
+	payload["pins_version"] = f.versions[roomID]
+	return store.PinChange{Version: f.versions[roomID], EventSeq: f.events.append(roomID, eventType, payload)}
+}
+
+// fakeRoomEvents keeps the room event log in memory
+// Sequence numbers are shared by all rooms, as in the database
+type fakeRoomEvents struct {
+	*store.RoomEventStore
+	mu     sync.Mutex
+	seq    int64
+	events []*store.RoomEvent
+	purged map[int64]int64 // Highest purged seq, by room
+}
+
+func (f *fakeRoomEvents) append(roomID int64, eventType string, payload any) int64 {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		panic(err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seq++
+	f.events = append(f.events, &store.RoomEvent{Seq: f.seq, RoomID: roomID, Type: eventType, Payload: data, CreatedAt: time.Now()})
+	return f.seq
+}
+
+func (f *fakeRoomEvents) ListAfter(_ context.Context, roomID, afterSeq int64, limit int) ([]*store.RoomEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if afterSeq < f.purged[roomID] {
+		return nil, store.ErrRoomEventsPurged
+	}
+	events := make([]*store.RoomEvent, 0)
+	for _, event := range f.events {
+		if event.RoomID == roomID && event.Seq > afterSeq && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (f *fakeRoomEvents) PurgeOlderThan(_ context.Context, cutoff time.Time) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var removed int64
+	f.events = slices.DeleteFunc(f.events, func(event *store.RoomEvent) bool {
+		if !event.CreatedAt.Before(cutoff) {
+			return false
+		}
+		f.purged[event.RoomID] = max(f.purged[event.RoomID], event.Seq)
+		removed++
+		return true
+	})
+	return removed, nil
+}
+
+// fakePushTokens keeps each device's push token in memory
+type fakePushTokens struct {
+	*store.PushTokenStore
+	mu     sync.Mutex
+	tokens map[int64]*store.PushToken // By device
+}
+
+// Upsert replaces the device's token, re-enabling it
+func (f *fakePushTokens) Upsert(_ context.Context, token *store.PushToken) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	token.ID = token.DeviceID
+	token.ConsecutiveFailures, token.DisabledAt = 0, nil
+	token.CreatedAt, token.UpdatedAt = time.Now(), time.Now()
+	copied := *token
+	f.tokens[token.DeviceID] = &copied
+	return nil
+}
+
+func (f *fakePushTokens) Delete(_ context.Context, deviceID, userID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if token, ok := f.tokens[deviceID]; !ok || token.UserID != userID {
+		return sql.ErrNoRows
+	}
+	delete(f.tokens, deviceID)
+	return nil
+}
+
+// fakeAttachments keeps uploads and the blobs they share in memory
+type fakeAttachments struct {
+	*store.AttachmentStore
+	mu      sync.Mutex
+	uploads map[int64]*store.Attachment // By upload; blob fields as uploaded
+	blobs   map[string]*fakeBlob        // By hash
+	nextID  int64
+	blobIDs int64
+}
+
+// fakeBlob is one stored file: its ID, how many uploads share it and its
+// thumbnail state
+type fakeBlob struct {
+	id                    int64
+	refs                  int
+	pending, hasThumbnail bool
+	width, height         int
+}
+
+// withBlob returns a copy of an upload with its blob's current state
+// f.mu must be held
+func (f *fakeAttachments) withBlob(upload *store.Attachment) *store.Attachment {
+	copied := *upload
+	b := f.blobs[upload.Hash]
+	copied.Width, copied.Height = b.width, b.height
+	copied.ThumbnailPending, copied.HasThumbnail = b.pending, b.hasThumbnail
+	return &copied
+}
+
+// pending reports whether the blob behind an upload is waiting for its thumbnail
+func (f *fakeAttachments) pending(id int64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.blobs[f.uploads[id].Hash].pending
+}
+
+func (f *fakeAttachments) Add(_ context.Context, a *store.Attachment, place func() error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.blobs[a.Hash]
+	a.Deduplicated = ok
+	if err := place(); err != nil {
+		return err
+	}
+	if !ok {
+		f.blobIDs++
+		b = &fakeBlob{id: f.blobIDs, pending: a.ThumbnailPending, width: a.Width, height: a.Height}
+		f.blobs[a.Hash] = b
+	}
+	b.refs++
+	f.nextID++
+	a.ID, a.AttachmentID, a.CreatedAt = f.nextID, b.id, time.Now()
+	copied := *a
+	f.uploads[a.ID] = &copied
+	*a = *f.withBlob(&copied)
+	return nil
+}
+
+func (f *fakeAttachments) GetByID(_ context.Context, id, userID int64) (*store.Attachment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a, ok := f.uploads[id]
+	if !ok || a.UserID != userID {
+		return nil, sql.ErrNoRows
+	}
+	return f.withBlob(a), nil
+}
+
+func (f *fakeAttachments) FinishThumbnail(_ context.Context, attachmentID int64, made bool) ([]*store.Attachment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	uploads := make([]*store.Attachment, 0)
+	for _, b := range f.blobs {
+		if b.id != attachmentID || !b.pending {
+			continue
+		}
+		b.pending, b.hasThumbnail = false, made
+		for _, upload := range f.uploads {
+			if upload.AttachmentID == attachmentID {
+				uploads = append(uploads, f.withBlob(upload))
+			}
+		}
+	}
+	return uploads, nil
+}
+
+func (f *fakeAttachments) PendingThumbnails(context.Context) (map[int64]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pending := make(map[int64]string)
+	for hash, b := range f.blobs {
+		if b.pending {
+			pending[b.id] = hash
+		}
+	}
+	return pending, nil
+}
+
+func (f *fakeAttachments) Remove(_ context.Context, id, userID int64, cleanup func(string) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a, ok := f.uploads[id]
+	if !ok || a.UserID != userID {
+		return sql.ErrNoRows
+	}
+	b := f.blobs[a.Hash]
+	if b.refs == 1 {
+		if err := cleanup(a.Hash); err != nil {
+			return err
+		}
+		delete(f.blobs, a.Hash)
+	}
+	b.refs--
+	delete(f.uploads, id)
+	return nil
+}
+
+func (f *fakeAttachments) Stats(context.Context) (*store.StorageStats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stats := &store.StorageStats{}
+	sizes := make(map[string]int64)
+	for _, a := range f.uploads {
+		stats.Uploads++
+		stats.LogicalBytes += a.Size
+		sizes[a.Hash] = a.Size
+	}
+	for _, size := range sizes {
+		stats.Blobs++
+		stats.PhysicalBytes += size
+	}
+	stats.SavedBytes = stats.LogicalBytes - stats.PhysicalBytes
+	return stats, nil
+}
+
+// fakeDevices keeps the owner of each device in memory
+type fakeDevices struct {
+	*store.DeviceStore
+	mu     sync.Mutex
+	owners map[int64]int64 // By device
+}
+
+// add registers deviceID to userID
+func (f *fakeDevices) add(deviceID, userID int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.owners[deviceID] = userID
+}
+
+func (f *fakeDevices) Touch(_ context.Context, deviceID, userID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if owner, ok := f.owners[deviceID]; !ok || owner != userID {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// fakeReadMarkers keeps read positions in memory and counts unread messages
+// in messages
+type fakeReadMarkers struct {
+	*store.ReadMarkerStore
+	messages *fakeMessages
+	mu       sync.Mutex
+	markers  map[[3]int64]int64 // By user, room and device
+}
+
+func (f *fakeReadMarkers) MarkRead(_ context.Context, userID, roomID, deviceID, messageID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := [3]int64{userID, roomID, deviceID}
+	f.markers[key] = max(f.markers[key], messageID)
+	return nil
+}
+
+func (f *fakeReadMarkers) GetUnreadCount(_ context.Context, userID, roomID int64) (int, error) {
+	f.mu.Lock()
+	var read int64
+	for key, position := range f.markers {
+		if key[0] == userID && key[1] == roomID {
+			read = max(read, position)
+		}
+	}
+	f.mu.Unlock()
+
+	f.messages.mu.Lock()
+	defer f.messages.mu.Unlock()
+	unread := 0
+	for _, m := range f.messages.messages {
+		if m.RoomID == roomID && m.ID > read {
+			unread++
+		}
+	}
+	return unread, nil
+}
+
+// GetSyncState returns one state per room the user has a marker in, with
+// only the device positions filled in
+func (f *fakeReadMarkers) GetSyncState(_ context.Context, userID int64) ([]*store.RoomSyncState, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	byRoom := make(map[int64]*store.RoomSyncState)
+	states := make([]*store.RoomSyncState, 0)
+	for key, position := range f.markers {
+		if key[0] != userID {
+			continue
+		}
+		state, ok := byRoom[key[1]]
+		if !ok {
+			state = &store.RoomSyncState{RoomID: key[1]}
+			byRoom[key[1]] = state
+			states = append(states, state)
+		}
+		state.Devices = append(state.Devices, store.DeviceReadPosition{DeviceID: key[2], LastReadMessageID: position})
+	}
+	return states, nil
+}
+
+// fakeJoinRequests keeps join requests in memory; approving one adds the
+// membership to members
+type fakeJoinRequests struct {
+	*store.JoinRequestStore
+	members  *fakeRoomMembers
+	mu       sync.Mutex
+	requests map[[2]int64]*store.JoinRequest // By room and user
+}
+
+func (f *fakeJoinRequests) Knock(_ context.Context, roomID, userID int64, cooldown time.Duration) (*store.JoinRequest, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := [2]int64{roomID, userID}
+	if request, ok := f.requests[key]; ok {
+		if request.Status == store.JoinRequestPending {
+			copied := *request
+			return &copied, false, nil
+		}
+		if request.Status == store.JoinRequestRejected && time.Since(*request.DecidedAt) < cooldown {
+			return nil, false, store.ErrJoinRequestCooldown
+		}
+	}
+	request := &store.JoinRequest{RoomID: roomID, UserID: userID, Status: store.JoinRequestPending, CreatedAt: time.Now()}
+	f.requests[key] = request
+	copied := *request
+	return &copied, true, nil
+}
+
+func (f *fakeJoinRequests) ListPending(_ context.Context, roomID int64) ([]*store.JoinRequest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	requests := make([]*store.JoinRequest, 0)
+	for key, request := range f.requests {
+		if key[0] == roomID && request.Status == store.JoinRequestPending {
+			copied := *request
+			requests = append(requests, &copied)
+		}
+	}
+	return requests, nil
+}
+
+func (f *fakeJoinRequests) Approve(_ context.Context, roomID, userID, adminID int64) error {
+	if err := f.decide(roomID, userID, adminID, store.JoinRequestApproved); err != nil {
+		return err
+	}
+	f.members.add(roomID, userID, store.RoomRoleMember)
+	return nil
+}
+
+func (f *fakeJoinRequests) Reject(_ context.Context, roomID, userID, adminID int64) error {
+	return f.decide(roomID, userID, adminID, store.JoinRequestRejected)
+}
+
+// decide moves a pending request to status, or returns sql.ErrNoRows
+func (f *fakeJoinRequests) decide(roomID, userID, adminID int64, status string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	request, ok := f.requests[[2]int64{roomID, userID}]
+	if !ok || request.Status != store.JoinRequestPending {
+		return sql.ErrNoRows
+	}
+	now := time.Now()
+	request.Status, request.DecidedAt, request.DecidedBy = status, &now, &adminID
+	return nil
+}
+
+// fakePosts keeps posts in memory
+type fakePosts struct {
+	*store.PostStore
+	mu    sync.Mutex
+	posts map[int64]*store.Post
+}
+
+// add saves a post under its ID
+func (f *fakePosts) add(post *store.Post) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.posts[post.ID] = post
+}
+
+func (f *fakePosts) Create(_ context.Context, post *store.Post) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	post.ID = int64(len(f.posts) + 1)
+	post.CreatedAt = time.Now()
+	post.UpdatedAt = post.CreatedAt
+	copied := *post
+	f.posts[post.ID] = &copied
+	return nil
+}
+
+func (f *fakePosts) GetByID(_ context.Context, id int64) (*store.Post, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	post, ok := f.posts[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *post
+	return &copied, nil
+}
+
+// List pages through the posts newest first, taking higher IDs as newer
+func (f *fakePosts) List(_ context.Context, limit, offset int) ([]*store.Post, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	posts := make([]*store.Post, 0)
+	for id := int64(len(f.posts)); id > 0; id-- {
+		if post, ok := f.posts[id]; ok {
+			copied := *post
+			posts = append(posts, &copied)
+		}
+	}
+	offset = min(offset, len(posts))
+	return posts[offset:min(offset+limit, len(posts))], nil
+}
+
+func (f *fakePosts) Update(_ context.Context, post *store.Post) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.posts[post.ID]; !ok {
+		return sql.ErrNoRows
+	}
+	post.UpdatedAt = time.Now()
+	copied := *post
+	f.posts[post.ID] = &copied
+	return nil
+}
+
+func (f *fakePosts) Delete(_ context.Context, id int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.posts, id)
+	return nil
+}
+
+// fakeReceipts keeps per-message receipts and who has caught up on which room
+type fakeReceipts struct {
+	*store.ReceiptStore
+	mu       sync.Mutex
+	receipts map[[2]int64]*store.MessageReceipts // By room and message
+	caughtUp map[[2]int64]bool                   // By room and user
+}
+
+func (f *fakeReceipts) MarkDelivered(context.Context, []store.DeliveryMark) error {
+	return nil
+}
+
+func (f *fakeReceipts) MarkDeliveredLatest(_ context.Context, roomID, userID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.caughtUp[[2]int64{roomID, userID}] = true
+	return nil
+}
+
+// hasCaughtUp reports whether userID's delivery pointer was moved to the
+// newest message in roomID
+func (f *fakeReceipts) hasCaughtUp(roomID, userID int64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.caughtUp[[2]int64{roomID, userID}]
+}
+
+func (f *fakeReceipts) GetReceipts(_ context.Context, roomID, messageID int64) (*store.MessageReceipts, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	receipts, ok := f.receipts[[2]int64{roomID, messageID}]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return receipts, nil
+}
+
+// fakeExports keeps export jobs in memory and exports each user's messages
+// and nothing else
+type fakeExports struct {
+	*store.ExportStore
+	mu       sync.Mutex
+	now      func() time.Time                   // Dates jobs; the app's clock, if a test swaps it
+	jobs     []*store.ExportJob                 // ID i+1 at index i
+	messages map[int64][]*store.ExportedMessage // By user
+}
+
+// addMessages gives userID n messages in roomID to export
+func (f *fakeExports) addMessages(userID, roomID int64, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := 0; i < n; i++ {
+		id := int64(len(f.messages[userID]) + 1)
+		f.messages[userID] = append(f.messages[userID], &store.ExportedMessage{
+			ID:          id,
+			RoomID:      roomID,
+			RoomName:    "general",
+			Content:     "message",
+			ContentType: "text",
+			CreatedAt:   time.Now().UTC(),
+		})
+	}
+}
+
+func (f *fakeExports) CreateJob(_ context.Context, userID int64, since time.Time) (*store.ExportJob, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, job := range f.jobs {
+		if job.UserID == userID && job.CreatedAt.After(since) {
+			return nil, store.ErrExportRateLimited
+		}
+	}
+	job := &store.ExportJob{ID: int64(len(f.jobs) + 1), UserID: userID, Status: store.ExportRunning, CreatedAt: f.now()}
+	f.jobs = append(f.jobs, job)
+	copied := *job
+	return &copied, nil
+}
+
+func (f *fakeExports) CompleteJob(_ context.Context, jobID int64, filePath string) error {
+	return f.finish(jobID, store.ExportDone, filePath, "")
+}
+
+func (f *fakeExports) FailJob(_ context.Context, jobID int64, reason string) error {
+	return f.finish(jobID, store.ExportFailed, "", reason)
+}
+
+// finish records how a job ended
+func (f *fakeExports) finish(jobID int64, status, filePath, reason string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if jobID < 1 || jobID > int64(len(f.jobs)) {
+		return nil
+	}
+	now := f.now()
+	job := f.jobs[jobID-1]
+	job.Status, job.FilePath, job.Error, job.CompletedAt = status, filePath, reason, &now
+	return nil
+}
+
+func (f *fakeExports) GetJob(_ context.Context, jobID, userID int64) (*store.ExportJob, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if jobID < 1 || jobID > int64(len(f.jobs)) || f.jobs[jobID-1].UserID != userID {
+		return nil, sql.ErrNoRows
+	}
+	copied := *f.jobs[jobID-1]
+	return &copied, nil
+}
+
+func (f *fakeExports) CountUserMessages(_ context.Context, userID int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.messages[userID]), nil
+}
+
+func (f *fakeExports) GetUserMemberships(context.Context, int64) ([]*store.ExportedMembership, error) {
+	return []*store.ExportedMembership{}, nil
+}
+
+func (f *fakeExports) GetUserJoinRequests(context.Context, int64) ([]*store.JoinRequest, error) {
+	return []*store.JoinRequest{}, nil
+}
+
+func (f *fakeExports) GetUserDevices(context.Context, int64) ([]*store.Device, error) {
+	return []*store.Device{}, nil
+}
+
+func (f *fakeExports) GetUserPosts(context.Context, int64) ([]*store.Post, error) {
+	return []*store.Post{}, nil
+}
+
+func (f *fakeExports) StreamUserMessages(_ context.Context, userID int64, fn func(*store.ExportedMessage) error) error {
+	f.mu.Lock()
+	messages := f.messages[userID]
+	f.mu.Unlock()
+	for _, m := range messages {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fakeDigests keeps digest settings in memory; users without saved
+// settings get the column defaults, and unknown users are sql.ErrNoRows
+type fakeDigests struct {
+	*store.DigestStore
+	users    *fakeUsers
+	mu       sync.Mutex
+	settings map[int64]store.DigestSettings
+}
+
+func (f *fakeDigests) GetSettings(ctx context.Context, userID int64) (*store.DigestSettings, error) {
+	if _, err := f.users.GetByID(ctx, userID); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	settings, ok := f.settings[userID]
+	if !ok {
+		settings = store.DigestSettings{Hour: 8, Timezone: "UTC"}
+	}
+	return &settings, nil
+}
+
+func (f *fakeDigests) UpdateSettings(ctx context.Context, userID int64, settings *store.DigestSettings) error {
+	if _, err := f.users.GetByID(ctx, userID); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.settings[userID] = *settings
+	return nil
+}
+
+// fakeNotificationPreferences keeps the cells users changed in memory
+type fakeNotificationPreferences struct {
+	*store.NotificationPreferenceStore
+	mu      sync.Mutex
+	changed map[int64]store.NotificationPreferences
+}
+
+func (f *fakeNotificationPreferences) Get(ctx context.Context, userID int64) (store.NotificationPreferences, error) {
+	prefs, err := f.GetForUsers(ctx, []int64{userID})
+	return prefs[userID], err
+}
+
+func (f *fakeNotificationPreferences) GetForUsers(_ context.Context, userIDs []int64) (map[int64]store.NotificationPreferences, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result := make(map[int64]store.NotificationPreferences, len(userIDs))
+	for _, userID := range userIDs {
+		prefs := store.DefaultNotificationPreferences()
+		for channel, cells := range f.changed[userID] {
+			maps.Copy(prefs[channel], cells)
+		}
+		result[userID] = prefs
+	}
+	return result, nil
+}
+
+func (f *fakeNotificationPreferences) Update(ctx context.Context, userID int64, changes store.NotificationPreferences) (store.NotificationPreferences, error) {
+	f.mu.Lock()
+	if f.changed[userID] == nil {
+		f.changed[userID] = make(store.NotificationPreferences)
+	}
+	for channel, cells := range changes {
+		if f.changed[userID][channel] == nil {
+			f.changed[userID][channel] = make(map[string]bool)
+		}
+		maps.Copy(f.changed[userID][channel], cells)
+	}
+	f.mu.Unlock()
+	return f.Get(ctx, userID)
+}
+
+// fakeReports keeps reports in memory and, like the partial unique indexes,
+// refuses a second open report from the same reporter about the same target
+type fakeReports struct {
+	*store.ReportStore
+	mu      sync.Mutex
+	reports []*store.Report
+	history map[int64][]*store.ReportEvent
+}
+
+// isOpen reports whether a report in status still blocks another one
+func (f *fakeReports) isOpen(status string) bool {
+	return status == store.ReportStatusOpen || status == store.ReportStatusReviewing
+}
+
+// duplicates reports whether another open report from the same reporter
+// names the same message or user as report
+func (f *fakeReports) duplicates(report *store.Report) bool {
+	for _, other := range f.reports {
+		if other.ID == report.ID || other.ReporterID != report.ReporterID || other.TargetType != report.TargetType || !f.isOpen(other.Status) {
+			continue
+		}
+		if report.TargetType == store.ReportTargetMessage && *other.MessageID == *report.MessageID ||
+			report.TargetType == store.ReportTargetUser && *other.TargetUserID == *report.TargetUserID {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeReports) Create(_ context.Context, report *store.Report) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	report.Status = store.ReportStatusOpen
+	if f.duplicates(report) {
+		return store.ErrDuplicateReport
+	}
+	report.ID = int64(len(f.reports) + 1)
+	report.CreatedAt, report.UpdatedAt = time.Now(), time.Now()
+	copied := *report
+	f.reports = append(f.reports, &copied)
+	return nil
+}
+
+// list returns the reports matching keep, newest first
+func (f *fakeReports) list(keep func(*store.Report) bool, limit, offset int) []*store.Report {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reports := make([]*store.Report, 0)
+	for i := len(f.reports) - 1; i >= 0; i-- {
+		if keep(f.reports[i]) {
+			copied := *f.reports[i]
+			reports = append(reports, &copied)
+		}
+	}
+	reports = reports[min(offset, len(reports)):]
+	return reports[:min(limit, len(reports))]
+}
+
+func (f *fakeReports) ListForRoom(_ context.Context, roomID int64, limit, offset int) ([]*store.Report, error) {
+	return f.list(func(r *store.Report) bool { return r.RoomID != nil && *r.RoomID == roomID }, limit, offset), nil
+}
+
+func (f *fakeReports) List(_ context.Context, status string, limit, offset int) ([]*store.Report, error) {
+	return f.list(func(r *store.Report) bool { return status == "" || r.Status == status }, limit, offset), nil
+}
+
+// UpdateStatus allows the changes the store's reportTransitions does: open
+// reports to any other status, closed ones back to open
+func (f *fakeReports) UpdateStatus(_ context.Context, id int64, status, actor, note string) (*store.Report, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id < 1 || id > int64(len(f.reports)) {
+		return nil, sql.ErrNoRows
+	}
+	report := f.reports[id-1]
+	if status == report.Status || !f.isOpen(report.Status) && status != store.ReportStatusOpen {
+		return nil, store.ErrInvalidReportTransition
+	}
+	from := report.Status
+	report.Status = status
+	if f.duplicates(report) {
+		report.Status = from
+		return nil, store.ErrDuplicateReport
+	}
+	report.UpdatedAt = time.Now()
+	f.history[id] = append(f.history[id], &store.ReportEvent{FromStatus: from, ToStatus: status, Actor: actor, Note: note, CreatedAt: time.Now()})
+	copied := *report
+	copied.History = append([]*store.ReportEvent(nil), f.history[id]...)
+	return &copied, nil
+}
+
+// fakeRedactions redacts the fake messages in place, keeping the originals,
+// and logs each redaction in the fake room event log
+type fakeRedactions struct {
+	*store.RedactionStore
+	mu         sync.Mutex
+	messages   *fakeMessages
+	rooms      *fakeRooms
+	events     *fakeRoomEvents
+	redactions map[int64]*store.Redaction // By message ID
+}
+
+func (f *fakeRedactions) Redact(_ context.Context, messageID int64, actor, reason string) (*store.Redaction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages.mu.Lock()
+	var message *store.Message
+	for _, m := range f.messages.messages {
+		if m.ID == messageID {
+			message = m
+		}
+	}
+	if message == nil {
+		f.messages.mu.Unlock()
+		return nil, sql.ErrNoRows
+	}
+	if message.Redacted {
+		f.messages.mu.Unlock()
+		return nil, store.ErrAlreadyRedacted
+	}
+	redaction := &store.Redaction{
+		ID:                  int64(len(f.redactions) + 1),
+		MessageID:           messageID,
+		RoomID:              message.RoomID,
+		OriginalContent:     message.Content,
+		OriginalContentType: message.ContentType,
+		OriginalLanguage:    message.Language,
+		Actor:               actor,
+		Reason:              reason,
+		CreatedAt:           time.Now(),
+	}
+	message.Content, message.ContentType, message.Language, message.Redacted = store.RedactedContent, "text", "", true
+	f.messages.mu.Unlock()
+
+	redaction.EventSeq = f.events.append(redaction.RoomID, store.RoomEventMessageRedacted, map[string]any{"message_id": messageID})
+	f.redactions[messageID] = redaction
+	copied := *redaction
+	return &copied, nil
+}
+
+// ListMessages filters the fake messages as the store's query does, newest first
+func (f *fakeRedactions) ListMessages(_ context.Context, q store.AdminMessageQuery) ([]*store.AdminMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages.mu.Lock()
+	defer f.messages.mu.Unlock()
+	found := make([]*store.AdminMessage, 0)
+	for i := len(f.messages.messages) - 1; i >= 0 && len(found) < q.Limit; i-- {
+		m := f.messages.messages[i]
+		switch {
+		case q.UserID != 0 && m.UserID != q.UserID,
+			q.RoomID != 0 && m.RoomID != q.RoomID,
+			q.Q != "" && !strings.Contains(strings.ToLower(m.Content), strings.ToLower(q.Q)),
+			!q.Since.IsZero() && m.CreatedAt.Before(q.Since),
+			!q.Until.IsZero() && !m.CreatedAt.Before(q.Until),
+			q.Before != 0 && m.ID >= q.Before:
+			continue
+		}
+		copied := *m
+		listed := &store.AdminMessage{Message: &copied}
+		if room, err := f.rooms.GetByID(context.Background(), m.RoomID); err == nil {
+			listed.RoomName = room.Name
+		}
+		if redaction, ok := f.redactions[m.ID]; ok {
+			kept := *redaction
+			listed.Redaction = &kept
+		}
+		found = append(found, listed)
+	}
+	return found, nil
+}
+
+// fakeRoomTemplates keeps room templates in memory; names are unique per owner
+type fakeRoomTemplates struct {
+	*store.RoomTemplateStore
+	mu        sync.Mutex
+	templates []*store.RoomTemplate
+}
+
+func (f *fakeRoomTemplates) Create(_ context.Context, template *store.RoomTemplate) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, existing := range f.templates {
+		if existing.OwnerID == template.OwnerID && existing.Name == template.Name {
+			return &pq.Error{Code: "23505", Message: `duplicate key value violates unique constraint "room_templates_owner_id_name_key"`}
+		}
+	}
+	template.ID = int64(len(f.templates) + 1)
+	template.CreatedAt = time.Now()
+	copied := *template
+	f.templates = append(f.templates, &copied)
+	return nil
+}
+
+func (f *fakeRoomTemplates) GetByID(_ context.Context, id, ownerID int64) (*store.RoomTemplate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, template := range f.templates {
+		if template.ID == id && template.OwnerID == ownerID {
+			copied := *template
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (f *fakeRoomTemplates) ListForOwner(_ context.Context, ownerID int64) ([]*store.RoomTemplate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	templates := make([]*store.RoomTemplate, 0)
+	for _, template := range f.templates {
+		if template.OwnerID == ownerID {
+			copied := *template
+			templates = append(templates, &copied)
+		}
+	}
+	slices.SortFunc(templates, func(a, b *store.RoomTemplate) int { return strings.Compare(a.Name, b.Name) })
+	return templates, nil
+}
+
+// fakeRoomPermissions keeps the cells each room changed in memory, and
+// takes owners from the fake rooms and roles from the fake memberships
+// Like the store, it answers for deleted rooms too, so they can be restored
+type fakeRoomPermissions struct {
+	*store.RoomPermissionStore
+	rooms     *fakeRooms
+	members   *fakeRoomMembers
+	mu        sync.Mutex
+	overrides map[int64]store.RoomPermissions // By room
+}
+
+func (f *fakeRoomPermissions) Get(_ context.Context, roomID int64) (store.RoomPermissions, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	perms := store.DefaultRoomPermissions()
+	for role, cells := range f.overrides[roomID] {
+		for capability, allowed := range cells {
+			perms[role][capability] = allowed
+		}
+	}
+	return perms, nil
+}
+
+func (f *fakeRoomPermissions) GetAccess(ctx context.Context, roomID, userID int64) (*store.RoomAccess, error) {
+	f.rooms.mu.Lock()
+	room, ok := f.rooms.rooms[roomID]
+	f.rooms.mu.Unlock()
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	f.members.mu.Lock()
+	role, member := f.members.roles[roomID][userID]
+	f.members.mu.Unlock()
+	if room.CreatedBy == userID {
+		role = store.RoomRoleOwner
+	}
+
+	perms, err := f.Get(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	return &store.RoomAccess{Role: role, Member: member, Deleted: f.rooms.isDeleted(roomID), Permissions: perms}, nil
+}
+
+func (f *fakeRoomPermissions) Update(ctx context.Context, roomID int64, changes store.RoomPermissions) (store.RoomPermissions, error) {
+	f.mu.Lock()
+	if f.overrides[roomID] == nil {
+		f.overrides[roomID] = make(store.RoomPermissions)
+	}
+	for role, cells := range changes {
+		if f.overrides[roomID][role] == nil {
+			f.overrides[roomID][role] = make(map[string]bool)
+		}
+		for capability, allowed := range cells {
+			f.overrides[roomID][role][capability] = allowed
+		}
+	}
+	f.mu.Unlock()
+	return f.Get(ctx, roomID)
+}
+
+// fakeEmailInvites keeps email invites and their token hashes in memory
+type fakeEmailInvites struct {
+	*store.EmailInviteStore
+	mu      sync.Mutex
+	invites []*fakeEmailInvite
+}
+
+// fakeEmailInvite is an invite with what the table keeps beside it
+type fakeEmailInvite struct {
+	store.EmailInvite
+	hash     string
+	accepted bool
+}
+
+func (f *fakeEmailInvites) Create(_ context.Context, invite *store.EmailInvite, tokenHash string, ttl time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	invite.Email = store.NormalizeEmail(invite.Email)
+	invite.CreatedAt = time.Now()
+	invite.ExpiresAt = invite.CreatedAt.Add(ttl)
+	for _, open := range f.invites {
+		if open.RoomID == invite.RoomID && open.Email == invite.Email && !open.accepted {
+			invite.ID = open.ID
+			open.EmailInvite, open.hash = *invite, tokenHash
+			return nil
+		}
+	}
+	invite.ID = int64(len(f.invites) + 1)
+	f.invites = append(f.invites, &fakeEmailInvite{EmailInvite: *invite, hash: tokenHash})
+	return nil
+}
+
+// accept uses up the live invites of email if tokenHash belongs to one of
+// them, as the registration does, and returns them
+func (f *fakeEmailInvites) accept(email, tokenHash string) []store.EmailInvite {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	live := func(invite *fakeEmailInvite) bool {
+		return invite.Email == store.NormalizeEmail(email) && !invite.accepted && invite.ExpiresAt.After(time.Now())
+	}
+	if !slices.ContainsFunc(f.invites, func(invite *fakeEmailInvite) bool { return live(invite) && invite.hash == tokenHash }) {
+		return nil
+	}
+	accepted := make([]store.EmailInvite, 0)
+	for _, invite := range f.invites {
+		if live(invite) {
+			invite.accepted = true
+			accepted = append(accepted, invite.EmailInvite)
+		}
+	}
+	return accepted
+}
+
+// fakePasswordResets keeps reset tokens in memory, by hash; using one sets
+// the password in users and signs the user out as the transaction does
+type fakePasswordResets struct {
+	*store.PasswordResetStore
+	users  *fakeUsers
+	mu     sync.Mutex
+	tokens map[string]*fakePasswordReset
+}
+
+// fakePasswordReset is a token's row
+type fakePasswordReset struct {
+	userID    int64
+	expiresAt time.Time
+	used      bool
+}
+
+// Create replaces the user's unused token, as the upsert does
+func (f *fakePasswordResets) Create(_ context.Context, userID int64, tokenHash string, ttl time.Duration) (time.Time, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for hash, token := range f.tokens {
+		if token.userID == userID && !token.used {
+			delete(f.tokens, hash)
+		}
+	}
+	expiresAt := time.Now().Add(ttl)
+	f.tokens[tokenHash] = &fakePasswordReset{userID: userID, expiresAt: expiresAt}
+	return expiresAt, nil
+}
+
+// live returns the token if it can still be used; f.mu must be held
+func (f *fakePasswordResets) live(tokenHash string) *fakePasswordReset {
+	token, ok := f.tokens[tokenHash]
+	if !ok || token.used || !token.expiresAt.After(time.Now()) {
+		return nil
+	}
+	return token
+}
+
+func (f *fakePasswordResets) GetUserID(_ context.Context, tokenHash string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	token := f.live(tokenHash)
+	if token == nil {
+		return 0, sql.ErrNoRows
+	}
+	return token.userID, nil
+}
+
+func (f *fakePasswordResets) Consume(_ context.Context, tokenHash, passwordHash string) ([]int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	token := f.live(tokenHash)
+	if token == nil {
+		return nil, sql.ErrNoRows
+	}
+	token.used = true
+
+	f.users.mu.Lock()
+	f.users.users[token.userID].Password = passwordHash
+	f.users.tokenVersions[token.userID]++
+	f.users.mu.Unlock()
+
+	f.users.deleteAPITokens(token.userID)
+	return f.users.deleteSessions(token.userID), nil
+}
+
+// fakeOAuthIdentities keeps linked provider accounts in memory, by
+// provider and subject
+type fakeOAuthIdentities struct {
+	*store.OAuthIdentityStore
+	mu         sync.Mutex
+	identities map[[2]string]*store.OAuthIdentity
+}
+
+func (f *fakeOAuthIdentities) GetUserID(_ context.Context, provider, subject string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	identity, ok := f.identities[[2]string{provider, subject}]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return identity.UserID, nil
+}
+
+func (f *fakeOAuthIdentities) Link(_ context.Context, identity *store.OAuthIdentity) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := [2]string{identity.Provider, identity.Subject}
+	if _, ok := f.identities[key]; ok {
+		return &pq.Error{Code: "23505", Message: `duplicate key value violates unique constraint "oauth_identities_pkey"`}
+	}
+	identity.CreatedAt = time.Now()
+	copied := *identity
+	f.identities[key] = &copied
+	return nil
+}
+
+// fakeModerationHooks keeps rooms' moderation hooks in memory
+type fakeModerationHooks struct {
+	*store.ModerationHookStore
+	mu    sync.Mutex
+	hooks map[int64]*store.ModerationHook
+}
+
+func (f *fakeModerationHooks) Get(_ context.Context, roomID int64) (*store.ModerationHook, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	hook, ok := f.hooks[roomID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *hook
+	return &copied, nil
+}
+
+// Save turns a disabled hook back on, as the upsert does
+func (f *fakeModerationHooks) Save(_ context.Context, hook *store.ModerationHook) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	hook.DisabledReason, hook.DisabledAt, hook.UpdatedAt = "", nil, now
+	if saved, ok := f.hooks[hook.RoomID]; ok {
+		hook.CreatedAt = saved.CreatedAt
+	} else {
+		hook.CreatedAt = now
+	}
+	copied := *hook
+	f.hooks[hook.RoomID] = &copied
+	return nil
+}
+
+func (f *fakeModerationHooks) Disable(_ context.Context, roomID int64, reason string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if hook, ok := f.hooks[roomID]; ok {
+		now := time.Now()
+		hook.Enabled, hook.DisabledReason, hook.DisabledAt = false, reason, &now
+	}
+	return nil
+}
+
+func (f *fakeModerationHooks) Delete(_ context.Context, roomID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.hooks[roomID]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(f.hooks, roomID)
+	return nil
+}
+
+// fakeTwoFactor keeps users' 2FA state and recovery codes in memory
+// Users it hasn't seen have 2FA off
+type fakeTwoFactor struct {
+	*store.TwoFactorStore
+	mu     sync.Mutex
+	states map[int64]*store.TwoFactor
+	codes  map[int64]map[string]bool // Recovery code hashes per user, true once used
+}
+
+// state returns userID's state, creating it; the caller holds f.mu
+func (f *fakeTwoFactor) state(userID int64) *store.TwoFactor {
+	tf, ok := f.states[userID]
+	if !ok {
+		tf = &store.TwoFactor{UserID: userID}
+		f.states[userID] = tf
+	}
+	return tf
+}
+
+func (f *fakeTwoFactor) Get(_ context.Context, userID int64) (*store.TwoFactor, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	copied := *f.state(userID)
+	copied.RecoveryCodesLeft = 0
+	for _, used := range f.codes[userID] {
+		if !used {
+			copied.RecoveryCodesLeft++
+		}
+	}
+	return &copied, nil
+}
+
+func (f *fakeTwoFactor) Setup(_ context.Context, userID int64, secret []byte, codeHashes []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tf := f.state(userID)
+	if tf.Enabled {
+		return store.ErrTwoFactorEnabled
+	}
+	tf.Secret, tf.LastStep = secret, 0
+	f.codes[userID] = make(map[string]bool)
+	for _, hash := range codeHashes {
+		f.codes[userID][hash] = false
+	}
+	return nil
+}
+
+func (f *fakeTwoFactor) Enable(_ context.Context, userID, step int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tf := f.state(userID)
+	if tf.Secret == nil || tf.Enabled || tf.LastStep >= step {
+		return sql.ErrNoRows
+	}
+	now := time.Now()
+	tf.Enabled, tf.EnabledAt, tf.LastStep = true, &now, step
+	return nil
+}
+
+func (f *fakeTwoFactor) UseStep(_ context.Context, userID, step int64) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tf := f.state(userID)
+	if tf.LastStep >= step {
+		return false, nil
+	}
+	tf.LastStep = step
+	return true, nil
+}
+
+func (f *fakeTwoFactor) UseRecoveryCode(_ context.Context, userID int64, codeHash string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	used, ok := f.codes[userID][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	f.codes[userID][codeHash] = true
+	return true, nil
+}
+
+func (f *fakeTwoFactor) Disable(_ context.Context, userID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tf := f.state(userID)
+	tf.Secret, tf.Enabled, tf.EnabledAt = nil, false, nil
+	delete(f.codes, userID)
+	return nil
+}
+
+// fakeFeatureFlags keeps feature flags in memory, refusing overrides for
+// users and rooms the fake stores don't have
+type fakeFeatureFlags struct {
+	*store.FeatureFlagStore
+	users *fakeUsers
+	rooms *fakeRooms
+	mu    sync.Mutex
+	flags map[string]*store.FeatureFlag
+}
+
+func (f *fakeFeatureFlags) List(context.Context) ([]*store.FeatureFlag, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list := make([]*store.FeatureFlag, 0, len(f.flags))
+	for _, flag := range f.flags {
+		copied := *flag
+		list = append(list, &copied)
+	}
+	slices.SortFunc(list, func(a, b *store.FeatureFlag) int { return strings.Compare(a.Name, b.Name) })
+	return list, nil
+}
+
+func (f *fakeFeatureFlags) Save(ctx context.Context, flag *store.FeatureFlag) error {
+	for id := range flag.UserOverrides {
+		if _, err := f.users.GetByID(ctx, id); err != nil {
+			return store.ErrOverrideTargetNotFound
+		}
+	}
+	for id := range flag.RoomOverrides {
+		if _, err := f.rooms.GetByID(ctx, id); err != nil {
+			return store.ErrOverrideTargetNotFound
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	flag.UpdatedAt = time.Now()
+	copied := *flag
+	f.flags[flag.Name] = &copied
+	return nil
+}
+
+// fakeRoomInvites keeps room invites in memory; accepting joins through the
+// fake room members, so the global limits apply
+type fakeRoomInvites struct {
+	*store.RoomInviteStore
+	mu      sync.Mutex
+	rooms   *fakeRooms
+	users   *fakeUsers
+	members *fakeRoomMembers
+	invites []*store.RoomInvite
+}
+
+// Create refreshes an open invite of the same user to the same room, as the upsert does
+func (f *fakeRoomInvites) Create(_ context.Context, invite *store.RoomInvite, ttl time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	invite.Status, invite.CreatedAt = store.RoomInvitePending, time.Now()
+	invite.ExpiresAt = invite.CreatedAt.Add(ttl)
+	for _, open := range f.invites {
+		if open.RoomID == invite.RoomID && open.InviteeID == invite.InviteeID && open.Status == store.RoomInvitePending {
+			invite.ID = open.ID
+			*open = *invite
+			return nil
+		}
+	}
+	invite.ID = int64(len(f.invites) + 1)
+	saved := *invite
+	f.invites = append(f.invites, &saved)
+	return nil
+}
+
+func (f *fakeRoomInvites) ListPending(ctx context.Context, userID int64) ([]*store.RoomInvite, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	invites := make([]*store.RoomInvite, 0)
+	for i := len(f.invites) - 1; i >= 0; i-- {
+		invite := f.invites[i]
+		if invite.InviteeID == userID && invite.Status == store.RoomInvitePending && invite.ExpiresAt.After(time.Now()) && !f.rooms.isDeleted(invite.RoomID) {
+			invites = append(invites, f.withNames(ctx, invite))
+		}
+	}
+	return invites, nil
+}
+
+func (f *fakeRoomInvites) Respond(ctx context.Context, inviteID, userID int64, accept bool) (*store.RoomInvite, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if inviteID < 1 || inviteID > int64(len(f.invites)) {
+		return nil, false, store.ErrInviteNotFound
+	}
+	invite := f.invites[inviteID-1]
+	if invite.InviteeID != userID || f.rooms.isDeleted(invite.RoomID) {
+		return nil, false, store.ErrInviteNotFound
+	}
+	status := store.RoomInviteDeclined
+	if accept {
+		status = store.RoomInviteAccepted
+	}
+	if invite.Status != store.RoomInvitePending {
+		if invite.Status == status {
+			return f.withNames(ctx, invite), false, nil
+		}
+		return nil, false, store.ErrInviteAnswered
+	}
+	if !invite.ExpiresAt.After(time.Now()) {
+		return nil, false, store.ErrInviteExpired
+	}
+	if accept {
+		err := f.members.JoinWithOptions(ctx, invite.RoomID, userID, store.JoinOptions{ActorID: userID})
+		var pqErr *pq.Error
+		if err != nil && !errors.As(err, &pqErr) {
+			return nil, false, err
+		}
+	}
+	now := time.Now()
+	invite.Status, invite.RespondedAt = status, &now
+	return f.withNames(ctx, invite), true, nil
+}
+
+// expire makes an invite past its expiry
+func (f *fakeRoomInvites) expire(inviteID int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.invites[inviteID-1].ExpiresAt = time.Now().Add(-time.Minute)
+}
+
+// withNames returns a copy of invite with its room's and inviter's names, as the joins fill them in
+func (f *fakeRoomInvites) withNames(ctx context.Context, invite *store.RoomInvite) *store.RoomInvite {
+	copied := *invite
+	if room, err := f.rooms.GetByID(ctx, invite.RoomID); err == nil {
+		copied.RoomName = room.Name
+	}
+	if invite.InvitedBy != nil {
+		if inviter, err := f.users.GetByID(ctx, *invite.InvitedBy); err == nil {
+			copied.InviterName = inviter.Username
+		}
+	}
+	return &copied
+}
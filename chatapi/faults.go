@@ -0,0 +1,168 @@
+//go:build faults
+
+package chatapi
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// Store fault injection, in builds made with -tags faults
+//
+// With STORE_FAULTS=true, InjectFaults puts a store.Faults in front of the
+// Storage and /v1/admin/faults programs it, so a dev server can be made to
+// see failing or slow queries on purpose. Builds without the tag have
+// neither (see faults_off.go)
+
+// FaultRequest sets the fault on one store method
+type FaultRequest struct {
+	Method   string `json:"method"`              // As Field.Method, e.g. "Messages.Create"
+	Error    string `json:"error,omitempty"`     // A name from store.FaultErrors; empty only adds latency
+	Latency  string `json:"latency,omitempty"`   // A duration, e.g. "250ms"
+	EveryNth int    `json:"every_nth,omitempty"` // Only every Nth call; 0 for every call
+}
+
+// FaultResponse is a fault that is set, and what it has done since
+type FaultResponse struct {
+	Method   string `json:"method"`
+	Error    string `json:"error,omitempty"`
+	Latency  string `json:"latency,omitempty"`
+	EveryNth int    `json:"every_nth,omitempty"`
+	Calls    int64  `json:"calls"`
+	Hits     int64  `json:"hits"`
+}
+
+// FaultsResponse lists the faults that are set and the error names a fault can use
+type FaultsResponse struct {
+	Faults []*FaultResponse `json:"faults"`
+	Errors []string         `json:"errors"`
+}
+
+// InjectFaults puts a fault injector in front of st when STORE_FAULTS is on,
+// and returns st as it is otherwise
+// Call it before CacheMessages, so the message cache and the admin routes
+// that report on it still see their own store
+func InjectFaults(st Storage, cfg *Config) Storage {
+	if !cfg.storeFaults {
+		return st
+	}
+	log.Println("Warning: STORE_FAULTS is on; /v1/admin/faults can make store calls fail")
+	return store.WithFaults(st, store.NewFaults())
+}
+
+// mountFaults adds /v1/admin/faults when the Storage has a fault injector
+func (app *application) mountFaults(r chi.Router) {
+	if store.FaultsOf(app.store) == nil {
+		return
+	}
+	r.Get("/faults", app.listFaultsHandler)
+	r.Put("/faults", app.setFaultHandler)
+	r.Delete("/faults", app.clearFaultsHandler)
+}
+
+// listFaultsHandler lists the faults that are set
+// GET /v1/admin/faults
+// Requires the X-Ops-Token header
+// Response: {"faults": [{"method": "Messages.Create", "error": "timeout", "every_nth": 3, "calls": 7, "hits": 2}],
+// "errors": ["bad_conn", "canceled", ...]}
+func (app *application) listFaultsHandler(w http.ResponseWriter, r *http.Request) {
+	faults := store.FaultsOf(app.store)
+	response := FaultsResponse{Faults: []*FaultResponse{}, Errors: make([]string, 0, len(store.FaultErrors))}
+	for method, status := range faults.List() {
+		response.Faults = append(response.Faults, newFaultResponse(method, status))
+	}
+	sort.Slice(response.Faults, func(i, j int) bool { return response.Faults[i].Method < response.Faults[j].Method })
+	for name := range store.FaultErrors {
+		response.Errors = append(response.Errors, name)
+	}
+	sort.Strings(response.Errors)
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// setFaultHandler sets the fault on a store method, replacing the one it had
+// PUT /v1/admin/faults
+// Requires the X-Ops-Token header
+// Request body: {"method": "Messages.GetRoomMessages", "error": "timeout", "latency": "2s", "every_nth": 2}
+// Response: the fault as listed by GET /v1/admin/faults
+// Unknown methods or error names, and negative latencies or every_nth: 400
+func (app *application) setFaultHandler(w http.ResponseWriter, r *http.Request) {
+	faults := store.FaultsOf(app.store)
+	var req FaultRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	var fault store.Fault
+	if req.Error != "" {
+		err, ok := store.FaultErrors[req.Error]
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, "unknown_fault_error", req.Error)
+			return
+		}
+		fault.Err = err
+	}
+	if req.Latency != "" {
+		d, err := time.ParseDuration(req.Latency)
+		if err != nil || d < 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid_fault")
+			return
+		}
+		fault.Latency = d
+	}
+	if req.EveryNth < 0 {
+		writeError(w, r, http.StatusBadRequest, "invalid_fault")
+		return
+	}
+	fault.EveryNth = req.EveryNth
+
+	// Set only fails for a method Storage doesn't have
+	if err := faults.Set(req.Method, fault); err != nil {
+		writeError(w, r, http.StatusBadRequest, "unknown_fault_method", req.Method)
+		return
+	}
+
+	log.Printf("Store fault set on %s: error=%q latency=%s every_nth=%d", req.Method, req.Error, fault.Latency, fault.EveryNth)
+	writeJSON(w, http.StatusOK, newFaultResponse(req.Method, store.FaultStatus{Fault: fault}))
+}
+
+// clearFaultsHandler removes the fault on one method, or with no method all of them
+// DELETE /v1/admin/faults?method=Messages.Create
+// Requires the X-Ops-Token header
+// Response: 204
+func (app *application) clearFaultsHandler(w http.ResponseWriter, r *http.Request) {
+	faults := store.FaultsOf(app.store)
+	if method := r.URL.Query().Get("method"); method != "" {
+		faults.Clear(method)
+		log.Printf("Store fault on %s cleared", method)
+	} else {
+		faults.Reset()
+		log.Println("Store faults cleared")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newFaultResponse describes a fault, naming its error as store.FaultErrors does
+// Errors set in code under no name are shown by their message
+func newFaultResponse(method string, status store.FaultStatus) *FaultResponse {
+	response := &FaultResponse{Method: method, EveryNth: status.EveryNth, Calls: status.Calls, Hits: status.Hits}
+	if status.Latency > 0 {
+		response.Latency = status.Latency.String()
+	}
+	if status.Err != nil {
+		response.Error = status.Err.Error()
+		for name, err := range store.FaultErrors {
+			if err == status.Err {
+				response.Error = name
+				break
+			}
+		}
+	}
+	return response
+}
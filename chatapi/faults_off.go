@@ -0,0 +1,21 @@
+//go:build !faults
+
+package chatapi
+
+import (
+	"log"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// InjectFaults returns st as it is: only builds made with -tags faults have
+// a fault injector (see faults.go)
+func InjectFaults(st Storage, cfg *Config) Storage {
+	if cfg.storeFaults {
+		log.Println("Warning: STORE_FAULTS is ignored; this build has no fault injection (build with -tags faults)")
+	}
+	return st
+}
+
+// mountFaults adds nothing without a fault injector
+func (app *application) mountFaults(chi.Router) {}
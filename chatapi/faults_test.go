@@ -0,0 +1,209 @@
+//go:build faults
+
+package chatapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+)
+
+// faultServer serves general (1), where ada and grace are members, and
+// random (2), which nobody has joined, on a Storage behind a fault injector
+// /v1/admin/faults takes the ops token "ops-secret"
+func faultServer(t *testing.T) (*httptest.Server, *testStore, *store.Faults) {
+	t.Helper()
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.rooms.add(&store.Room{ID: 2, Name: "random", CreatedBy: 1})
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+
+	faults := store.NewFaults()
+	ts.Storage = store.WithFaults(ts.Storage, faults)
+	server := newTestServer(t, ts, withOps)
+	return server, ts, faults
+}
+
+// TestSendWhileSaveFails has ada send messages while Messages.Create fails:
+// ada gets an error frame for each, grace gets none of them, and the hub is
+// left with no goroutines it didn't have before. Once saving works again the
+// next message is the first one grace sees
+func TestSendWhileSaveFails(t *testing.T) {
+	server, _, faults := faultServer(t)
+	ada := dialRoom(t, server, 1, 1)
+	readFrame(t, ada, "join")
+	grace := dialRoom(t, server, 1, 2)
+	readFrame(t, grace, "join")
+	readFrame(t, ada, "join")
+	baseline := runtime.NumGoroutine()
+
+	if err := faults.Set("Messages.Create", store.Fault{Err: store.FaultErrors["conn_done"]}); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if err := ada.WriteJSON(map[string]string{"content": "lost"}); err != nil {
+			t.Fatal(err)
+		}
+		if frame := readFrame(t, ada, "error"); frame.Code != "message_save_failed" || frame.RoomID != 1 {
+			t.Errorf("ada got %q for room %d, want message_save_failed for room 1", frame.Code, frame.RoomID)
+		}
+	}
+	if hits := faults.List()["Messages.Create"].Hits; hits != 3 {
+		t.Errorf("Messages.Create failed %d times, want 3", hits)
+	}
+
+	faults.Reset()
+	if err := ada.WriteJSON(map[string]string{"content": "saved"}); err != nil {
+		t.Fatal(err)
+	}
+	if frame := readFrame(t, grace, "message"); frame.Content != "saved" || frame.ID == 0 {
+		t.Errorf("grace's first message is %q with ID %d, want the saved one", frame.Content, frame.ID)
+	}
+	if !testutil.WaitFor(2*time.Second, func() bool { return runtime.NumGoroutine() <= baseline }) {
+		t.Errorf("%d goroutines are running after the failed sends, want at most %d", runtime.NumGoroutine(), baseline)
+	}
+}
+
+// TestRoomMentionWhileSaveFails has ada, a room admin, use @room while
+// Messages.Create fails, over WebSocket and then REST: neither failed
+// message uses up their @room, so the first one saved still goes out, and
+// only the one after it is refused
+func TestRoomMentionWhileSaveFails(t *testing.T) {
+	server, _, faults := faultServer(t)
+	ada := dialRoom(t, server, 1, 1)
+	readFrame(t, ada, "join")
+	send := server.URL + "/v1/rooms/1/messages"
+
+	if err := faults.Set("Messages.Create", store.Fault{Err: store.FaultErrors["conn_done"]}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ada.WriteJSON(map[string]string{"content": "@room standup"}); err != nil {
+		t.Fatal(err)
+	}
+	if frame := readFrame(t, ada, "error"); frame.Code != "message_save_failed" {
+		t.Errorf("an @room that wasn't saved got %q over WebSocket, want message_save_failed", frame.Code)
+	}
+	var failure errorBody
+	if status := doJSON(t, http.MethodPost, send, 1, SendMessageRequest{Content: "@room standup"}, &failure); status != http.StatusInternalServerError || failure.Code != "message_save_failed" {
+		t.Errorf("an @room that wasn't saved got %d %q over REST, want 500 message_save_failed", status, failure.Code)
+	}
+
+	faults.Reset()
+	if status := doJSON(t, http.MethodPost, send, 1, SendMessageRequest{Content: "@room standup"}, nil); status != http.StatusCreated {
+		t.Errorf("the first @room saved got %d, want 201", status)
+	}
+	if status := doJSON(t, http.MethodPost, send, 1, SendMessageRequest{Content: "@room again"}, &failure); status != http.StatusTooManyRequests || failure.Code != "room_mention_rate_limited" {
+		t.Errorf("a second @room got %d %q, want 429 room_mention_rate_limited", status, failure.Code)
+	}
+}
+
+// TestJoinDuringDuplicateKeyRace has grace's join lose a race to another
+// join of theirs: the membership check passes, the insert then hits the
+// unique key, and the handler answers 409 already_member as it does when
+// the check catches it
+func TestJoinDuringDuplicateKeyRace(t *testing.T) {
+	server, ts, faults := faultServer(t)
+	if err := faults.Set("RoomMembers.Join", store.Fault{Err: store.FaultErrors["unique_violation"]}); err != nil {
+		t.Fatal(err)
+	}
+
+	var failure errorBody
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/2/join", 2, nil, &failure); status != http.StatusConflict || failure.Code != "already_member" || failure.Error == "" {
+		t.Errorf("a join losing the race got %d %+v, want 409 already_member with a message", status, failure)
+	}
+	if member, _ := ts.roomMembers.IsUserInRoom(context.Background(), 2, 2); member {
+		t.Error("the failed join was recorded")
+	}
+
+	faults.Clear("RoomMembers.Join")
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/2/join", 2, nil, nil); status != http.StatusOK {
+		t.Errorf("joining with the fault cleared got %d, want 200", status)
+	}
+}
+
+// TestHistoryDuringQueryTimeout reads general's history while its query
+// times out: the reader gets a 500 in the standard error shape, no database
+// connection is left in use, and the next read succeeds
+func TestHistoryDuringQueryTimeout(t *testing.T) {
+	server, ts, faults := faultServer(t)
+	messages := server.URL + "/v1/rooms/1/messages"
+	if err := faults.Set("Messages.GetRoomMessages", store.Fault{Err: store.FaultErrors["timeout"], Latency: 10 * time.Millisecond, EveryNth: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := doJSON(t, http.MethodGet, messages, 1, nil, nil); status != http.StatusOK {
+		t.Errorf("the first read got %d, want 200", status)
+	}
+	var failure errorBody
+	if status := doJSON(t, http.MethodGet, messages, 1, nil, &failure); status != http.StatusInternalServerError || failure.Code != "messages_lookup_failed" || failure.Error == "" {
+		t.Errorf("a read whose query timed out got %d %+v, want 500 messages_lookup_failed with a message", status, failure)
+	}
+	if inUse := ts.pools.Primary().Stats().InUse; inUse != 0 {
+		t.Errorf("%d database connections are in use after the timeout", inUse)
+	}
+	if status := doJSON(t, http.MethodGet, messages, 1, nil, nil); status != http.StatusOK {
+		t.Errorf("the read after the timeout got %d, want 200", status)
+	}
+}
+
+// TestFaultsEndpoint programs the injector over /v1/admin/faults: a fault
+// is set, listed with its counts and cleared, and bad ones are refused
+func TestFaultsEndpoint(t *testing.T) {
+	server, _, faults := faultServer(t)
+	endpoint := server.URL + "/v1/admin/faults"
+	ops := map[string]string{opsTokenHeader: "ops-secret"}
+
+	if status := doJSONWithHeaders(t, http.MethodGet, endpoint, 0, nil, nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("listing without the ops token got %d, want 401", status)
+	}
+	for _, tc := range []struct {
+		name string
+		req  FaultRequest
+		code string
+	}{
+		{"an unknown method", FaultRequest{Method: "Messages.Explode"}, "unknown_fault_method"},
+		{"an unknown error", FaultRequest{Method: "Messages.Create", Error: "meltdown"}, "unknown_fault_error"},
+		{"a negative latency", FaultRequest{Method: "Messages.Create", Latency: "-1s"}, "invalid_fault"},
+		{"a negative every_nth", FaultRequest{Method: "Messages.Create", EveryNth: -2}, "invalid_fault"},
+	} {
+		var failure errorBody
+		if status := doJSONWithHeaders(t, http.MethodPut, endpoint, 0, ops, tc.req, &failure); status != http.StatusBadRequest || failure.Code != tc.code {
+			t.Errorf("%s got %d %q, want 400 %s", tc.name, status, failure.Code, tc.code)
+		}
+	}
+
+	var set FaultResponse
+	req := FaultRequest{Method: "Messages.GetRoomMessages", Error: "timeout", Latency: "5ms", EveryNth: 2}
+	if status := doJSONWithHeaders(t, http.MethodPut, endpoint, 0, ops, req, &set); status != http.StatusOK || set.Error != "timeout" || set.Latency != "5ms" || set.EveryNth != 2 {
+		t.Fatalf("setting a fault got %d %+v", status, set)
+	}
+	doJSON(t, http.MethodGet, server.URL+"/v1/rooms/1/messages", 1, nil, nil)
+	doJSON(t, http.MethodGet, server.URL+"/v1/rooms/1/messages", 1, nil, nil)
+
+	var list FaultsResponse
+	if status := doJSONWithHeaders(t, http.MethodGet, endpoint, 0, ops, nil, &list); status != http.StatusOK {
+		t.Fatalf("listing got %d, want 200", status)
+	}
+	if len(list.Faults) != 1 || list.Faults[0].Method != req.Method || list.Faults[0].Calls != 2 || list.Faults[0].Hits != 1 {
+		t.Errorf("the faults are %+v, want the one set, with 2 calls and 1 hit", list.Faults)
+	}
+	if len(list.Errors) != len(store.FaultErrors) {
+		t.Errorf("the error names are %v", list.Errors)
+	}
+
+	if status := doJSONWithHeaders(t, http.MethodDelete, endpoint+"?method="+req.Method, 0, ops, nil, nil); status != http.StatusNoContent {
+		t.Errorf("clearing got %d, want 204", status)
+	}
+	if len(faults.List()) != 0 {
+		t.Errorf("faults are still set after clearing: %v", faults.List())
+	}
+}
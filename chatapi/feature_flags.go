@@ -0,0 +1,141 @@
+package chatapi
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/flags"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// FeatureFlagResponse is a flag as the admin API shows it
+type FeatureFlagResponse struct {
+	Name          string         `json:"name"`
+	Description   string         `json:"description"`
+	Enabled       bool           `json:"enabled"`
+	UserOverrides map[int64]bool `json:"user_overrides"`
+	RoomOverrides map[int64]bool `json:"room_overrides"`
+	UpdatedAt     *time.Time     `json:"updated_at,omitempty"` // Absent for flags never saved
+}
+
+// FeatureFlagsResponse lists every flag the code knows about
+type FeatureFlagsResponse struct {
+	Flags []*FeatureFlagResponse `json:"flags"`
+}
+
+// FeatureFlagRequest replaces a flag's default and all of its overrides
+type FeatureFlagRequest struct {
+	Name          string         `json:"name"`
+	Enabled       bool           `json:"enabled"`
+	UserOverrides map[int64]bool `json:"user_overrides"`
+	RoomOverrides map[int64]bool `json:"room_overrides"`
+}
+
+func newFeatureFlagResponse(flag *store.FeatureFlag) *FeatureFlagResponse {
+	response := &FeatureFlagResponse{
+		Name:          flag.Name,
+		Description:   flags.Known[flag.Name],
+		Enabled:       flag.Enabled,
+		UserOverrides: flag.UserOverrides,
+		RoomOverrides: flag.RoomOverrides,
+	}
+	if response.UserOverrides == nil {
+		response.UserOverrides = map[int64]bool{}
+	}
+	if response.RoomOverrides == nil {
+		response.RoomOverrides = map[int64]bool{}
+	}
+	if !flag.UpdatedAt.IsZero() {
+		updatedAt := flag.UpdatedAt
+		response.UpdatedAt = &updatedAt
+	}
+	return response
+}
+
+// listFeatureFlagsHandler shows every known flag with its default and overrides
+// Flags that were never saved are listed as off with no overrides, which is
+// how the code treats them. Read from the database, not the flags cache
+// GET /v1/admin/flags
+// Requires the X-Ops-Token header
+// Response: {"flags": [{"name": "persist_join_leave", "description": "...", "enabled": false,
+// "user_overrides": {"12": true}, "room_overrides": {"5": true}, "updated_at": "..."}]}
+func (app *application) listFeatureFlagsHandler(w http.ResponseWriter, r *http.Request) {
+	stored, err := app.store.FeatureFlags.List(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "feature_flags_lookup_failed")
+		return
+	}
+	byName := make(map[string]*store.FeatureFlag, len(stored))
+	for _, flag := range stored {
+		byName[flag.Name] = flag
+	}
+
+	response := FeatureFlagsResponse{Flags: make([]*FeatureFlagResponse, 0, len(flags.Known))}
+	for name := range flags.Known {
+		flag := byName[name]
+		if flag == nil {
+			flag = &store.FeatureFlag{Name: name}
+		}
+		response.Flags = append(response.Flags, newFeatureFlagResponse(flag))
+	}
+	sort.Slice(response.Flags, func(i, j int) bool { return response.Flags[i].Name < response.Flags[j].Name })
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// updateFeatureFlagHandler sets a flag's default and replaces all of its overrides
+// Takes effect on this instance at once and on others within flags.DefaultCacheTTL
+// A user's override wins over a room's, and either over the default
+// PUT /v1/admin/flags
+// Requires the X-Ops-Token header
+// Request body: {"name": "persist_join_leave", "enabled": false, "user_overrides": {"12": true}, "room_overrides": {"5": true}}
+// Response: the flag as listed by GET /v1/admin/flags
+// Unknown flag names: 400; overrides naming an unknown user or room: 400
+func (app *application) updateFeatureFlagHandler(w http.ResponseWriter, r *http.Request) {
+	var req FeatureFlagRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	// A flag no code asks about would do nothing, which is most likely a typo
+	if _, ok := flags.Known[req.Name]; !ok {
+		writeError(w, r, http.StatusBadRequest, "unknown_feature_flag", req.Name)
+		return
+	}
+	for id := range req.UserOverrides {
+		if id <= 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid_feature_flag_override")
+			return
+		}
+	}
+	for id := range req.RoomOverrides {
+		if id <= 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid_feature_flag_override")
+			return
+		}
+	}
+
+	flag := &store.FeatureFlag{
+		Name:          req.Name,
+		Enabled:       req.Enabled,
+		UserOverrides: req.UserOverrides,
+		RoomOverrides: req.RoomOverrides,
+	}
+	if err := app.store.FeatureFlags.Save(r.Context(), flag); err != nil {
+		if errors.Is(err, store.ErrOverrideTargetNotFound) {
+			writeError(w, r, http.StatusBadRequest, "feature_flag_target_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "feature_flag_update_failed")
+		return
+	}
+	app.flags.Invalidate()
+
+	log.Printf("Feature flag %s set: enabled=%t, %d user and %d room overrides",
+		flag.Name, flag.Enabled, len(flag.UserOverrides), len(flag.RoomOverrides))
+	writeJSON(w, http.StatusOK, newFeatureFlagResponse(flag))
+}
@@ -0,0 +1,69 @@
+package chatapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/flags"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestFeatureFlagHandlers lists and sets flags with the ops token: a flag
+// never saved is listed as off, unknown names and overrides for missing or
+// invalid IDs are refused, and a saved change reaches the app's checker at
+// once rather than when its cache expires
+func TestFeatureFlagHandlers(t *testing.T) {
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	ts.rooms.add(&store.Room{ID: 5, Name: "general", CreatedBy: 1})
+	server, app := newTestServerApp(t, ts, withOps)
+	ops := map[string]string{opsTokenHeader: "ops-secret"}
+	url := server.URL + "/v1/admin/flags"
+
+	if status := doJSONWithHeaders(t, http.MethodGet, url, 0, nil, nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("listing without the ops token got %d, want 401", status)
+	}
+	var listed FeatureFlagsResponse
+	if status := doJSONWithHeaders(t, http.MethodGet, url, 0, ops, nil, &listed); status != http.StatusOK {
+		t.Fatalf("listing got %d, want 200", status)
+	}
+	if len(listed.Flags) != len(flags.Known) {
+		t.Fatalf("listed %d flags, want the %d known", len(listed.Flags), len(flags.Known))
+	}
+	if flag := listed.Flags[0]; flag.Name != flags.PersistJoinLeave || flag.Enabled || flag.UpdatedAt != nil || flag.Description == "" {
+		t.Errorf("got %+v, want persist_join_leave described, off and never saved", flag)
+	}
+	ctx := context.Background()
+	if app.flags.Enabled(ctx, flags.PersistJoinLeave, 1, 5) {
+		t.Fatal("the flag is on before it was saved")
+	}
+
+	for _, tc := range []struct {
+		name string
+		req  FeatureFlagRequest
+		code string
+	}{
+		{"an unknown flag", FeatureFlagRequest{Name: "persist_join_leav"}, "unknown_feature_flag"},
+		{"a zero user ID", FeatureFlagRequest{Name: flags.PersistJoinLeave, UserOverrides: map[int64]bool{0: true}}, "invalid_feature_flag_override"},
+		{"a negative room ID", FeatureFlagRequest{Name: flags.PersistJoinLeave, RoomOverrides: map[int64]bool{-5: true}}, "invalid_feature_flag_override"},
+		{"a missing user", FeatureFlagRequest{Name: flags.PersistJoinLeave, UserOverrides: map[int64]bool{99: true}}, "feature_flag_target_not_found"},
+	} {
+		var failure errorBody
+		if status := doJSONWithHeaders(t, http.MethodPut, url, 0, ops, tc.req, &failure); status != http.StatusBadRequest || failure.Code != tc.code {
+			t.Errorf("%s got %d %q, want 400 %s", tc.name, status, failure.Code, tc.code)
+		}
+	}
+
+	var saved FeatureFlagResponse
+	req := FeatureFlagRequest{Name: flags.PersistJoinLeave, RoomOverrides: map[int64]bool{5: true}}
+	if status := doJSONWithHeaders(t, http.MethodPut, url, 0, ops, req, &saved); status != http.StatusOK {
+		t.Fatalf("saving got %d, want 200", status)
+	}
+	if !saved.RoomOverrides[5] || saved.UpdatedAt == nil {
+		t.Errorf("saved %+v, want room 5 on", saved)
+	}
+	if !app.flags.Enabled(ctx, flags.PersistJoinLeave, 1, 5) || app.flags.Enabled(ctx, flags.PersistJoinLeave, 1, 6) {
+		t.Error("the saved override didn't reach the checker at once")
+	}
+}
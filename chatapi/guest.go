@@ -0,0 +1,175 @@
+package chatapi
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/drazan344/go-chat/internal/store"
+	ws "github.com/drazan344/go-chat/internal/websocket"
+)
+
+// publicHistoryLimit is how many recent messages guests can read
+// Guests get a smaller window than members since the endpoint is unauthenticated
+const publicHistoryLimit = 50
+
+// guestLimiter caps the number of concurrent guest connections per IP address
+// Guest access skips authentication, so without a cap one machine could
+// open thousands of sockets against a public room
+type guestLimiter struct {
+	mu     sync.Mutex
+	counts map[string]int
+	max    int
+}
+
+// newGuestLimiter creates a limiter allowing max concurrent connections per IP
+func newGuestLimiter(max int) *guestLimiter {
+	return &guestLimiter{
+		counts: make(map[string]int),
+		max:    max,
+	}
+}
+
+// acquire reserves a connection slot for ip
+// Returns false if the IP already has the maximum number of guest connections
+func (l *guestLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.counts[ip] >= l.max {
+		return false
+	}
+	l.counts[ip]++
+	return true
+}
+
+// release frees a slot previously reserved with acquire
+func (l *guestLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.counts[ip]--
+	if l.counts[ip] <= 0 {
+		// Delete empty entries so the map doesn't grow with every IP ever seen
+		delete(l.counts, ip)
+	}
+}
+
+// newGuestName generates a display name like "guest-3fa1" for an anonymous viewer
+func newGuestName() string {
+	b := make([]byte, 2)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand failing is extremely unlikely; a fixed name is still usable
+		return "guest"
+	}
+	return "guest-" + hex.EncodeToString(b)
+}
+
+// getPublicRoom loads a room and checks it allows guest access
+// Rooms that aren't public are reported as not found so guests can't probe
+// which private rooms exist
+func (app *application) getPublicRoom(w http.ResponseWriter, r *http.Request) (*store.Room, bool) {
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return nil, false
+	}
+
+	room, err := app.store.Rooms.GetByID(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+			return nil, false
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_lookup_failed")
+		return nil, false
+	}
+
+	if !room.IsPublicReadonly {
+		writeError(w, r, http.StatusNotFound, "room_not_found")
+		return nil, false
+	}
+
+	return room, true
+}
+
+// guestWebsocketHandler lets anonymous viewers watch a public room's live feed
+// GET /v1/rooms/{roomID}/ws/guest
+// No authentication required; the room must have is_public_readonly set
+// Guests receive broadcasts but any frame they send is rejected
+func (app *application) guestWebsocketHandler(w http.ResponseWriter, r *http.Request) {
+	if app.rejectIfDraining(w, r) {
+		return
+	}
+	if app.rejectIfOriginNotAllowed(w, r) {
+		return
+	}
+
+	room, ok := app.getPublicRoom(w, r)
+	if !ok {
+		return
+	}
+
+	// Reserve a slot for this IP before upgrading
+	// clientIP is the socket peer unless a trusted proxy forwarded the request,
+	// so a guest can't dodge the cap by making up X-Forwarded-For
+	ip := clientIP(r)
+	if !app.guests.acquire(ip) {
+		writeError(w, r, http.StatusTooManyRequests, "too_many_guest_connections")
+		return
+	}
+
+	client, err := ws.ServeWS(app.hub, w, r, ws.ClientOptions{
+		Username:  newGuestName(),
+		RoomID:    room.ID,
+		Guest:     true,
+		Events:    eventsFromQuery(r),
+		PingStats: pingStatsFromQuery(r),
+	})
+	if err != nil {
+		app.guests.release(ip)
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+
+	// Free the slot once the guest disconnects
+	go func() {
+		<-client.Done()
+		app.guests.release(ip)
+	}()
+
+	log.Printf("Guest WebSocket connection established: ip=%s room=%d", ip, room.ID)
+}
+
+// getPublicRoomMessagesHandler returns recent history of a public room
+// GET /v1/rooms/{roomID}/messages/public?fields=...&compact=true
+// No authentication required; limited to the last 50 messages
+// fields and compact work as for GET /v1/rooms/{roomID}/messages
+func (app *application) getPublicRoomMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	listOpts, ok := parseMessageListOptions(w, r)
+	if !ok {
+		return
+	}
+
+	room, ok := app.getPublicRoom(w, r)
+	if !ok {
+		return
+	}
+
+	messages, err := app.store.Messages.GetRoomMessages(r.Context(), room.ID, publicHistoryLimit)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "messages_lookup_failed")
+		return
+	}
+
+	if messages == nil {
+		messages = []*store.Message{}
+	}
+	app.hub.RenderSystemMessages(r.Context(), messages)
+
+	writeMessageList(w, http.StatusOK, messages, listOpts)
+}
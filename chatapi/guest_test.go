@@ -0,0 +1,124 @@
+package chatapi
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+	"github.com/gorilla/websocket"
+)
+
+// TestPublicHistory reads a room's history without an account: public rooms
+// give their last 50 messages, and every other room is not found
+func TestPublicHistory(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "lobby", CreatedBy: 2, IsPublicReadonly: true})
+	ts.rooms.add(&store.Room{ID: 2, Name: "staff", CreatedBy: 2})
+	ts.messages.addMessages(1, 2, 60)
+	server := newTestServer(t, ts)
+
+	var messages []*store.Message
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/rooms/1/messages/public", 0, nil, &messages); status != http.StatusOK {
+		t.Fatalf("the public room's history got %d, want 200", status)
+	}
+	if len(messages) != publicHistoryLimit || messages[len(messages)-1].ID != 60 {
+		t.Errorf("got %d messages, want the newest %d", len(messages), publicHistoryLimit)
+	}
+
+	for _, path := range []string{
+		"/v1/rooms/2/messages/public",
+		"/v1/rooms/3/messages/public",
+		"/v1/rooms/2/ws/guest",
+	} {
+		var failure errorBody
+		if status := doJSON(t, http.MethodGet, server.URL+path, 0, nil, &failure); status != http.StatusNotFound || failure.Code != "room_not_found" {
+			t.Errorf("%s got %d %q, want 404 room_not_found", path, status, failure.Code)
+		}
+	}
+}
+
+// TestGuestConnections watches a public room as guests: anything they send
+// is answered with an error frame, and each IP gets only so many connections
+// at once, whatever address they claim in forwarded headers
+func TestGuestConnections(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "lobby", CreatedBy: 2, IsPublicReadonly: true})
+	server, app := newTestServerApp(t, ts)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/rooms/1/ws/guest"
+	connected := func() int {
+		app.guests.mu.Lock()
+		defer app.guests.mu.Unlock()
+		return app.guests.counts["127.0.0.1"]
+	}
+
+	// dial connects a guest, returning the handshake's status
+	dial := func(header http.Header) (*websocket.Conn, int) {
+		t.Helper()
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if err != nil {
+			if resp == nil {
+				t.Fatal(err)
+			}
+			return nil, resp.StatusCode
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn, resp.StatusCode
+	}
+
+	first, _ := dial(nil)
+	if _, status := dial(nil); status != http.StatusSwitchingProtocols {
+		t.Fatalf("the second guest got %d, want 101", status)
+	}
+	if _, status := dial(nil); status != http.StatusTooManyRequests {
+		t.Errorf("a guest over the cap got %d, want 429", status)
+	}
+	// Without TRUSTED_PROXIES, forwarded addresses are the client's own word
+	spoofed := http.Header{"X-Forwarded-For": {"198.51.100.1"}, "X-Real-Ip": {"198.51.100.2"}}
+	if _, status := dial(spoofed); status != http.StatusTooManyRequests {
+		t.Errorf("a guest over the cap claiming another address got %d, want 429", status)
+	}
+
+	if err := first.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	first.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var frame struct {
+		Type    string `json:"type"`
+		Content string `json:"content"`
+	}
+	if err := first.ReadJSON(&frame); err != nil || frame.Type != "error" {
+		t.Errorf("a guest's message was answered with %+v (%v), want an error frame", frame, err)
+	}
+
+	// Hanging up frees the slot
+	first.Close()
+	if !testutil.WaitFor(time.Second, func() bool { return connected() == 1 }) {
+		t.Fatal("the slot of the guest who left was never freed")
+	}
+	if _, status := dial(nil); status != http.StatusSwitchingProtocols {
+		t.Errorf("a guest after one left got %d, want 101", status)
+	}
+}
+
+// TestUpdateRoom changes a room's settings: only its creator may, and only
+// the fields sent change
+func TestUpdateRoom(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "lobby", Description: "Say hi", CreatedBy: 2})
+	server := newTestServer(t, ts)
+	public := map[string]any{"is_public_readonly": true}
+
+	if status := doJSON(t, http.MethodPatch, server.URL+"/v1/rooms/1", 3, public, nil); status != http.StatusForbidden {
+		t.Errorf("another user's update got %d, want 403", status)
+	}
+	var room store.Room
+	if status := doJSON(t, http.MethodPatch, server.URL+"/v1/rooms/1", 2, public, &room); status != http.StatusOK {
+		t.Fatalf("the creator's update got %d, want 200", status)
+	}
+	if !room.IsPublicReadonly || room.Description != "Say hi" {
+		t.Errorf("the room became %+v, want it public with its description kept", room)
+	}
+}
@@ -1,8 +1,8 @@
-package main
+package chatapi
 
 import "net/http"
 
 func (app *application) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
-}
\ No newline at end of file
+}
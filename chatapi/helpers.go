@@ -0,0 +1,422 @@
+package chatapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// writeJSON writes a JSON response to the client
+// This helper standardizes JSON responses across all handlers
+// The status parameter sets the HTTP status code (200, 201, 400, etc.)
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	// Set Content-Type header to indicate JSON response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	// Encode data as JSON and write to response
+	// If encoding fails, there's not much we can do since headers are already sent
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		// Log the error but can't send error to client anymore
+		fmt.Printf("Error encoding JSON: %v\n", err)
+	}
+}
+
+// readJSON reads and unmarshals JSON from the request body
+// The dst parameter should be a pointer to the struct you want to unmarshal into
+// Example: var req LoginRequest; readJSON(r, &req)
+// Report errors with writeBodyError, which tells an oversized body apart
+func readJSON(r *http.Request, dst interface{}) error {
+	// Limit request body size to prevent DOS attacks
+	// The limit is the route's (see withBodyLimit), 1MB by default
+	r.Body = http.MaxBytesReader(nil, r.Body, bodyLimit(r))
+
+	// Create JSON decoder
+	decoder := json.NewDecoder(r.Body)
+
+	// DisallowUnknownFields makes the decoder return an error if the JSON contains
+	// fields that don't match the destination struct
+	// This helps catch typos and prevents clients from sending unexpected data
+	decoder.DisallowUnknownFields()
+
+	// Decode JSON into the destination
+	if err := decoder.Decode(dst); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	return nil
+}
+
+// writeError writes a standardized error response
+// This ensures all error responses have the same format
+// code is a machine-readable key from the message catalog (see i18n.go); the
+// human-readable message is translated using the request's Accept-Language
+// Optional args fill in placeholders in the catalog message
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, args ...interface{}) {
+	// Create a simple error response structure
+	// Clients should branch on Code, never on the localized Error text
+	type errorResponse struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+
+	locale := resolveLocale(r)
+	message := translate(locale, code, args...)
+	w.Header().Set("Content-Language", locale)
+	writeJSON(w, status, errorResponse{Error: message, Code: code})
+}
+
+// fieldError is one problem with one request field
+type fieldError struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// writeFieldErrors writes an error response with per-field details
+// The top-level code and message summarize; fields maps each field name to
+// everything wrong with it, each entry translated like writeError's message
+// Response: {"error": "...", "code": "...", "fields": {"password": [{"error": "...", "code": "..."}]}}
+func writeFieldErrors(w http.ResponseWriter, r *http.Request, status int, code string, fields map[string][]fieldError) {
+	type errorResponse struct {
+		Error  string                  `json:"error"`
+		Code   string                  `json:"code"`
+		Fields map[string][]fieldError `json:"fields"`
+	}
+
+	locale := resolveLocale(r)
+	w.Header().Set("Content-Language", locale)
+	writeJSON(w, status, errorResponse{Error: translate(locale, code), Code: code, Fields: fields})
+}
+
+// extractIDFromURL extracts an integer ID from URL parameters
+// This is commonly used for routes like /rooms/{roomID} where roomID needs to be parsed
+// The param parameter is the URL parameter name (e.g., "roomID")
+func extractIDFromURL(r *http.Request, param string) (int64, error) {
+	// Chi stores URL parameters in the request context
+	idStr := chi.URLParam(r, param)
+	if idStr == "" {
+		return 0, fmt.Errorf("missing %s parameter", param)
+	}
+
+	// Parse string to int64
+	// ParseInt parameters: string, base (10 for decimal), bitSize (64 for int64)
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s parameter: must be an integer", param)
+	}
+
+	return id, nil
+}
+
+// Sparse fieldsets for message lists
+//
+// History endpoints take ?fields=id,content,user_id,created_at to send only
+// some of each message's fields, and ?compact=true to move usernames into a
+// "users" table keyed by user ID instead of repeating them on every message:
+//
+//	{"messages": [{"id": 7, "user_id": 3, ...}], "users": {"3": "alice"}}
+//
+// Fields are encoded straight from store.Message by the functions in
+// messageFieldRegistry; the projection for each distinct fieldset is built
+// once and cached. A field that was asked for is always sent, even where
+// the full format leaves it out when empty; compact without fields keeps the
+// full format's fields and leaves the same ones out
+
+// messageField is one entry of the field registry: a JSON name, a function
+// appending that field's value to a buffer and, for fields the full format
+// leaves out when empty (omitempty), a function telling whether it is
+type messageField struct {
+	name   string
+	encode func(buf []byte, m *store.Message) []byte
+	empty  func(m *store.Message) bool
+}
+
+// messageFieldRegistry lists every selectable message field, in output order
+var messageFieldRegistry = []messageField{
+	{name: "id", encode: func(buf []byte, m *store.Message) []byte { return strconv.AppendInt(buf, m.ID, 10) }},
+	{name: "room_id", encode: func(buf []byte, m *store.Message) []byte { return strconv.AppendInt(buf, m.RoomID, 10) }},
+	{name: "user_id", encode: func(buf []byte, m *store.Message) []byte { return strconv.AppendInt(buf, m.UserID, 10) }},
+	{name: "content", encode: func(buf []byte, m *store.Message) []byte { return appendJSONString(buf, m.Content) }},
+	{name: "username", encode: func(buf []byte, m *store.Message) []byte { return appendJSONString(buf, m.Username) }},
+	{name: "created_at", encode: func(buf []byte, m *store.Message) []byte {
+		buf = append(buf, '"')
+		buf = m.CreatedAt.AppendFormat(buf, time.RFC3339Nano)
+		return append(buf, '"')
+	}},
+	{name: "content_type", encode: func(buf []byte, m *store.Message) []byte { return appendJSONString(buf, m.ContentType) }},
+	{
+		name:   "language",
+		encode: func(buf []byte, m *store.Message) []byte { return appendJSONString(buf, m.Language) },
+		empty:  func(m *store.Message) bool { return m.Language == "" },
+	},
+	{
+		name:   "filtered",
+		encode: func(buf []byte, m *store.Message) []byte { return strconv.AppendBool(buf, m.Filtered) },
+		empty:  func(m *store.Message) bool { return !m.Filtered },
+	},
+	{
+		name:   "truncated",
+		encode: func(buf []byte, m *store.Message) []byte { return strconv.AppendBool(buf, m.Truncated) },
+		empty:  func(m *store.Message) bool { return !m.Truncated },
+	},
+	{
+		name:   "override",
+		encode: func(buf []byte, m *store.Message) []byte { return strconv.AppendBool(buf, m.Override) },
+		empty:  func(m *store.Message) bool { return !m.Override },
+	},
+	{
+		name:   "moderated",
+		encode: func(buf []byte, m *store.Message) []byte { return strconv.AppendBool(buf, m.Moderated) },
+		empty:  func(m *store.Message) bool { return !m.Moderated },
+	},
+	{
+		name:   "system",
+		encode: func(buf []byte, m *store.Message) []byte { return strconv.AppendBool(buf, m.System) },
+		empty:  func(m *store.Message) bool { return !m.System },
+	},
+	{
+		name:   "system_event",
+		encode: func(buf []byte, m *store.Message) []byte { return appendJSONValue(buf, m.SystemEvent) },
+		empty:  func(m *store.Message) bool { return m.SystemEvent == nil },
+	},
+	{
+		name:   "legacy_system",
+		encode: func(buf []byte, m *store.Message) []byte { return strconv.AppendBool(buf, m.LegacySystem) },
+		empty:  func(m *store.Message) bool { return !m.LegacySystem },
+	},
+	{
+		name:   "override_username",
+		encode: func(buf []byte, m *store.Message) []byte { return appendJSONString(buf, m.OverrideUsername) },
+		empty:  func(m *store.Message) bool { return m.OverrideUsername == "" },
+	},
+	{
+		name:   "override_avatar_url",
+		encode: func(buf []byte, m *store.Message) []byte { return appendJSONString(buf, m.OverrideAvatarURL) },
+		empty:  func(m *store.Message) bool { return m.OverrideAvatarURL == "" },
+	},
+	{
+		name:   "redacted",
+		encode: func(buf []byte, m *store.Message) []byte { return strconv.AppendBool(buf, m.Redacted) },
+		empty:  func(m *store.Message) bool { return !m.Redacted },
+	},
+	{
+		name:   "room_mention",
+		encode: func(buf []byte, m *store.Message) []byte { return appendJSONString(buf, m.RoomMention) },
+		empty:  func(m *store.Message) bool { return m.RoomMention == "" },
+	},
+}
+
+// messageProjection encodes messages with a fixed set of fields
+// With omitEmpty, fields that have an empty function are left out when empty
+type messageProjection struct {
+	fields    []messageField
+	omitEmpty bool
+}
+
+// messageProjections caches one projection per distinct fieldset, keyed by
+// the canonical (registry-ordered) field list and the omitEmpty flag
+// There are at most 2^(len(messageFieldRegistry)+1) keys, so it never needs pruning
+var messageProjections sync.Map
+
+// messageListOptions are the parsed ?fields= and ?compact= of a history request
+type messageListOptions struct {
+	projection *messageProjection // nil for the full format
+	compact    bool
+}
+
+// parseMessageListOptions reads ?fields= and ?compact= from a history request
+// Unknown field names get a 400 that lists the valid ones
+// On failure it writes the error response and returns false
+func parseMessageListOptions(w http.ResponseWriter, r *http.Request) (messageListOptions, bool) {
+	compact, _ := strconv.ParseBool(r.URL.Query().Get("compact"))
+	opts := messageListOptions{compact: compact}
+
+	raw := r.URL.Query().Get("fields")
+	if raw == "" && !compact {
+		return opts, true
+	}
+
+	wanted := make(map[string]bool)
+	unknown := make([]string, 0)
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if messageFieldIndex(name) < 0 {
+			unknown = append(unknown, name)
+			continue
+		}
+		wanted[name] = true
+	}
+	if len(unknown) > 0 {
+		valid := make([]string, len(messageFieldRegistry))
+		for i, field := range messageFieldRegistry {
+			valid[i] = field.name
+		}
+		writeError(w, r, http.StatusBadRequest, "invalid_message_fields", strings.Join(unknown, ", "), strings.Join(valid, ", "))
+		return opts, false
+	}
+
+	// No fields named: the full format's fields, left out when empty as it does
+	omitEmpty := len(wanted) == 0
+	if omitEmpty {
+		if !compact {
+			return opts, true
+		}
+		for _, field := range messageFieldRegistry {
+			wanted[field.name] = true
+		}
+	}
+
+	// Compact messages point into the users table by user ID instead of naming the user
+	if compact {
+		wanted["user_id"] = true
+		delete(wanted, "username")
+	}
+
+	key := make([]string, 0, len(wanted))
+	for _, field := range messageFieldRegistry {
+		if wanted[field.name] {
+			key = append(key, field.name)
+		}
+	}
+	opts.projection = projectionFor(key, omitEmpty)
+	return opts, true
+}
+
+// messageFieldIndex returns a field's position in messageFieldRegistry, or -1
+func messageFieldIndex(name string) int {
+	for i, field := range messageFieldRegistry {
+		if field.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// projectionFor returns the cached projection for a canonical field list
+func projectionFor(names []string, omitEmpty bool) *messageProjection {
+	key := strings.Join(names, ",") + "|" + strconv.FormatBool(omitEmpty)
+	if cached, ok := messageProjections.Load(key); ok {
+		return cached.(*messageProjection)
+	}
+
+	projection := &messageProjection{fields: make([]messageField, len(names)), omitEmpty: omitEmpty}
+	for i, name := range names {
+		projection.fields[i] = messageFieldRegistry[messageFieldIndex(name)]
+	}
+	cached, _ := messageProjections.LoadOrStore(key, projection)
+	return cached.(*messageProjection)
+}
+
+// appendMessage appends one message as a JSON object with the projection's fields
+func (p *messageProjection) appendMessage(buf []byte, m *store.Message) []byte {
+	buf = append(buf, '{')
+	first := true
+	for _, field := range p.fields {
+		if p.omitEmpty && field.empty != nil && field.empty(m) {
+			continue
+		}
+		if !first {
+			buf = append(buf, ',')
+		}
+		first = false
+		buf = append(buf, '"')
+		buf = append(buf, field.name...)
+		buf = append(buf, '"', ':')
+		buf = field.encode(buf, m)
+	}
+	return append(buf, '}')
+}
+
+// appendJSONString appends s as a JSON string, escaped like encoding/json does
+func appendJSONString(buf []byte, s string) []byte {
+	encoded, err := json.Marshal(s)
+	if err != nil {
+		// Strings always marshal; invalid UTF-8 is replaced, not refused
+		return append(buf, `""`...)
+	}
+	return append(buf, encoded...)
+}
+
+// appendJSONValue appends v encoded by encoding/json, or null if it can't be
+func appendJSONValue(buf []byte, v interface{}) []byte {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return append(buf, "null"...)
+	}
+	return append(buf, encoded...)
+}
+
+// writeMessageList writes a history response in the format the request asked
+// for (see parseMessageListOptions): a plain array of messages, an array of
+// projected messages, or with compact the {"messages", "users"} object
+func writeMessageList(w http.ResponseWriter, status int, messages []*store.Message, opts messageListOptions) {
+	if opts.projection == nil {
+		writeJSON(w, status, messages)
+		return
+	}
+
+	buf := make([]byte, 0, 128*len(messages)+64)
+	if opts.compact {
+		buf = append(buf, `{"messages":`...)
+	}
+	buf = append(buf, '[')
+	for i, m := range messages {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = opts.projection.appendMessage(buf, m)
+	}
+	buf = append(buf, ']')
+
+	if opts.compact {
+		buf = append(buf, `,"users":{`...)
+		seen := make(map[int64]bool)
+		for _, m := range messages {
+			if seen[m.UserID] {
+				continue
+			}
+			if len(seen) > 0 {
+				buf = append(buf, ',')
+			}
+			seen[m.UserID] = true
+			buf = append(buf, '"')
+			buf = strconv.AppendInt(buf, m.UserID, 10)
+			buf = append(buf, '"', ':')
+			buf = appendJSONString(buf, m.Username)
+		}
+		buf = append(buf, '}', '}')
+	}
+	buf = append(buf, '\n')
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(buf)
+}
+
+// HTTP Status Codes Reference (for educational purposes):
+//
+// 2xx Success:
+//   200 OK - Request succeeded
+//   201 Created - Resource created successfully
+//   204 No Content - Success but no content to return
+//
+// 4xx Client Errors:
+//   400 Bad Request - Invalid request (validation failed)
+//   401 Unauthorized - Authentication required or failed
+//   403 Forbidden - Authenticated but not authorized
+//   404 Not Found - Resource doesn't exist
+//   409 Conflict - Request conflicts with current state (e.g., duplicate email)
+//
+// 5xx Server Errors:
+//   500 Internal Server Error - Unexpected server error
+//   503 Service Unavailable - Server temporarily unavailable
@@ -0,0 +1,355 @@
+package chatapi
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/auth"
+	"github.com/drazan344/go-chat/internal/flags"
+	"github.com/drazan344/go-chat/internal/mail"
+	"github.com/drazan344/go-chat/internal/notify"
+	"github.com/drazan344/go-chat/internal/store"
+	ws "github.com/drazan344/go-chat/internal/websocket"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/gorilla/websocket"
+)
+
+// TestMain silences the server's log output, request log included
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	middleware.DefaultLogger = middleware.RequestLogger(&middleware.DefaultLogFormatter{Logger: log.New(io.Discard, "", 0)})
+	os.Exit(m.Run())
+}
+
+// testSecret signs the tokens test requests are made with
+const testSecret = "test-secret"
+
+// testLimits are the membership limits test apps and their stores enforce
+var testLimits = store.Limits{MaxRoomMembers: 5, MaxRoomsPerUser: 3}
+
+// testStore is a Storage on a database nothing listens on, with posts,
+// users, rooms, messages, memberships and their history, pins, devices,
+// push tokens, attachments, read markers, join requests, receipts, exports,
+// translations, personal access tokens, login sessions, the room event
+// log, digest settings, notification preferences, abuse reports,
+// redactions, room templates, room permissions, email invites, moderation
+// hooks, 2FA settings and feature flags faked in memory (see fakes_test.go)
+// Every other store method fails at once, as if the database were down
+type testStore struct {
+	store.Storage
+	pools        *store.Pools // The primary only, for the readiness stats
+	posts        *fakePosts
+	users        *fakeUsers
+	rooms        *fakeRooms
+	messages     *fakeMessages
+	roomMembers  *fakeRoomMembers
+	memberships  *fakeMembershipEvents
+	pins         *fakePins
+	devices      *fakeDevices
+	pushTokens   *fakePushTokens
+	attachments  *fakeAttachments
+	readMarkers  *fakeReadMarkers
+	joinRequests *fakeJoinRequests
+	receipts     *fakeReceipts
+	exports      *fakeExports
+	translations *fakeTranslations
+	apiTokens    *fakeAPITokens
+	apiKeys      *fakeAPIKeys
+	sessions     *fakeSessions
+	roomEvents   *fakeRoomEvents
+	digests      *fakeDigests
+	preferences  *fakeNotificationPreferences
+	reports      *fakeReports
+	redactions   *fakeRedactions
+	templates    *fakeRoomTemplates
+	perms        *fakeRoomPermissions
+	emailInvites *fakeEmailInvites
+	resets       *fakePasswordResets
+	oauth        *fakeOAuthIdentities
+	roomInvites  *fakeRoomInvites
+	modHooks     *fakeModerationHooks
+	twoFactor    *fakeTwoFactor
+	featureFlags *fakeFeatureFlags
+}
+
+// newTestStore creates a testStore
+func newTestStore(t *testing.T) *testStore {
+	t.Helper()
+	db, err := sql.Open("postgres", "host=127.0.0.1 port=1 sslmode=disable connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	pools := store.NewPools(db, nil)
+	ts := &testStore{Storage: store.WithoutTimeouts(store.NewPostgresStorage(pools, testLimits)), pools: pools}
+	ts.posts = &fakePosts{PostStore: ts.Posts.(*store.PostStore), posts: make(map[int64]*store.Post)}
+	ts.users = &fakeUsers{UserStore: ts.Users.(*store.UserStore), users: make(map[int64]*store.User), roles: make(map[int64]string), banned: make(map[int64]bool), tokenVersions: make(map[int64]int64)}
+	ts.rooms = &fakeRooms{RoomStore: ts.Rooms.(*store.RoomStore), rooms: make(map[int64]*store.Room), deleted: make(map[int64]time.Time), now: time.Now}
+	ts.messages = &fakeMessages{MessageStore: ts.Messages.(*store.MessageStore)}
+	ts.roomMembers = &fakeRoomMembers{RoomMemberStore: ts.RoomMembers.(*store.RoomMemberStore), roles: make(map[int64]map[int64]string), limits: testLimits, rooms: ts.rooms, users: ts.users}
+	ts.memberships = &fakeMembershipEvents{MembershipEventStore: ts.MembershipEvents.(*store.MembershipEventStore), events: []*store.MembershipEvent{}}
+	ts.roomEvents = &fakeRoomEvents{RoomEventStore: ts.RoomEvents.(*store.RoomEventStore), purged: make(map[int64]int64)}
+	ts.RoomEvents = ts.roomEvents
+	ts.pins = &fakePins{PinStore: ts.Pins.(*store.PinStore), pins: make(map[int64][]int64), versions: make(map[int64]int64), events: ts.roomEvents}
+	ts.devices = &fakeDevices{DeviceStore: ts.Devices.(*store.DeviceStore), owners: make(map[int64]int64)}
+	ts.pushTokens = &fakePushTokens{PushTokenStore: ts.PushTokens.(*store.PushTokenStore), tokens: make(map[int64]*store.PushToken)}
+	ts.attachments = &fakeAttachments{AttachmentStore: ts.Attachments.(*store.AttachmentStore), uploads: make(map[int64]*store.Attachment), blobs: make(map[string]*fakeBlob)}
+	ts.readMarkers = &fakeReadMarkers{ReadMarkerStore: ts.ReadMarkers.(*store.ReadMarkerStore), messages: ts.messages, markers: make(map[[3]int64]int64)}
+	ts.joinRequests = &fakeJoinRequests{JoinRequestStore: ts.JoinRequests.(*store.JoinRequestStore), members: ts.roomMembers, requests: make(map[[2]int64]*store.JoinRequest)}
+	ts.Posts, ts.Users, ts.Rooms, ts.Messages, ts.RoomMembers = ts.posts, ts.users, ts.rooms, ts.messages, ts.roomMembers
+	ts.receipts = &fakeReceipts{ReceiptStore: ts.Receipts.(*store.ReceiptStore), receipts: make(map[[2]int64]*store.MessageReceipts), caughtUp: make(map[[2]int64]bool)}
+	ts.exports = &fakeExports{ExportStore: ts.Exports.(*store.ExportStore), now: time.Now, messages: make(map[int64][]*store.ExportedMessage)}
+	ts.translations = &fakeTranslations{MessageTranslationStore: ts.Translations.(*store.MessageTranslationStore), cached: make(map[int64]map[string]*store.MessageTranslation)}
+	ts.Translations = ts.translations
+	ts.apiTokens = &fakeAPITokens{APITokenStore: ts.APITokens.(*store.APITokenStore), tokens: make(map[string]*store.APIToken)}
+	ts.APITokens = ts.apiTokens
+	ts.apiKeys = &fakeAPIKeys{APIKeyStore: ts.APIKeys.(*store.APIKeyStore), keys: make(map[string]*store.APIKey), users: ts.users}
+	ts.APIKeys = ts.apiKeys
+	ts.sessions = &fakeSessions{SessionStore: ts.Sessions.(*store.SessionStore), sessions: make(map[int64]*store.Session)}
+	ts.Sessions = ts.sessions
+	ts.users.sessions, ts.users.apiTokens = ts.sessions, ts.apiTokens
+	ts.digests = &fakeDigests{DigestStore: ts.Digests.(*store.DigestStore), users: ts.users, settings: make(map[int64]store.DigestSettings)}
+	ts.Digests = ts.digests
+	ts.preferences = &fakeNotificationPreferences{NotificationPreferenceStore: ts.NotificationPreferences.(*store.NotificationPreferenceStore), changed: make(map[int64]store.NotificationPreferences)}
+	ts.NotificationPreferences = ts.preferences
+	ts.reports = &fakeReports{ReportStore: ts.Reports.(*store.ReportStore), history: make(map[int64][]*store.ReportEvent)}
+	ts.Reports = ts.reports
+	ts.redactions = &fakeRedactions{RedactionStore: ts.Redactions.(*store.RedactionStore), messages: ts.messages, rooms: ts.rooms, events: ts.roomEvents, redactions: make(map[int64]*store.Redaction)}
+	ts.Redactions = ts.redactions
+	ts.templates = &fakeRoomTemplates{RoomTemplateStore: ts.RoomTemplates.(*store.RoomTemplateStore)}
+	ts.RoomTemplates = ts.templates
+	ts.perms = &fakeRoomPermissions{
+		RoomPermissionStore: ts.RoomPermissions.(*store.RoomPermissionStore),
+		rooms:               ts.rooms,
+		members:             ts.roomMembers,
+		overrides:           make(map[int64]store.RoomPermissions),
+	}
+	ts.RoomPermissions = ts.perms
+	ts.emailInvites = &fakeEmailInvites{EmailInviteStore: ts.EmailInvites.(*store.EmailInviteStore)}
+	ts.EmailInvites = ts.emailInvites
+	ts.resets = &fakePasswordResets{PasswordResetStore: ts.PasswordResets.(*store.PasswordResetStore), users: ts.users, tokens: make(map[string]*fakePasswordReset)}
+	ts.PasswordResets = ts.resets
+	ts.oauth = &fakeOAuthIdentities{OAuthIdentityStore: ts.OAuthIdentities.(*store.OAuthIdentityStore), identities: make(map[[2]string]*store.OAuthIdentity)}
+	ts.OAuthIdentities = ts.oauth
+	ts.roomInvites = &fakeRoomInvites{RoomInviteStore: ts.RoomInvites.(*store.RoomInviteStore), rooms: ts.rooms, users: ts.users, members: ts.roomMembers}
+	ts.RoomInvites = ts.roomInvites
+	ts.modHooks = &fakeModerationHooks{ModerationHookStore: ts.ModerationHooks.(*store.ModerationHookStore), hooks: make(map[int64]*store.ModerationHook)}
+	ts.ModerationHooks = ts.modHooks
+	ts.twoFactor = &fakeTwoFactor{TwoFactorStore: ts.TwoFactor.(*store.TwoFactorStore), states: make(map[int64]*store.TwoFactor), codes: make(map[int64]map[string]bool)}
+	ts.TwoFactor = ts.twoFactor
+	ts.featureFlags = &fakeFeatureFlags{FeatureFlagStore: ts.FeatureFlags.(*store.FeatureFlagStore), users: ts.users, rooms: ts.rooms, flags: make(map[string]*store.FeatureFlag)}
+	ts.FeatureFlags = ts.featureFlags
+	ts.MembershipEvents, ts.Pins, ts.Devices, ts.PushTokens, ts.Attachments, ts.ReadMarkers, ts.JoinRequests, ts.Receipts, ts.Exports = ts.memberships, ts.pins, ts.devices, ts.pushTokens, ts.attachments, ts.readMarkers, ts.joinRequests, ts.receipts, ts.exports
+	return ts
+}
+
+// newTestApp creates an application on ts with a running hub
+// Guests are capped at 2 connections per IP, and memberships at testLimits
+// Directory lookups aren't rate limited, 2FA codes and reset emails are as in production,
+// and the other runtime settings have their defaults
+func newTestApp(ts *testStore) *application {
+	hub := ws.NewHub(ts.Storage, 1)
+	notifications := notify.NewCachedPolicy(ts.Storage, notify.DefaultCacheTTL)
+	hub.SetNotificationPolicy(notifications)
+	featureFlags := flags.NewCachedChecker(ts.Storage, flags.DefaultCacheTTL)
+	hub.SetFeatureFlags(featureFlags)
+	ctx, cancel := context.WithCancel(context.Background())
+	app := &application{
+		ctx:    ctx,
+		cancel: cancel,
+		config: config{
+			auth:  authConfig{jwtSecret: testSecret, sessionIdleTimeout: time.Hour},
+			guest: guestConfig{maxConnsPerIP: 2},
+			limits: limitsConfig{
+				maxRoomMembers:  testLimits.MaxRoomMembers,
+				maxRoomsPerUser: testLimits.MaxRoomsPerUser,
+			},
+			rooms:          roomsConfig{restoreWindow: 7 * 24 * time.Hour},
+			systemUsername: "system",
+		},
+		store:          ts.Storage,
+		pools:          ts.pools,
+		hub:            hub,
+		guests:         newGuestLimiter(2),
+		thumbnailSlots: make(chan struct{}, thumbnailWorkers),
+		now:            time.Now,
+
+		directoryLimiter:    newRateLimiter[int64](0, 0),
+		tokenMessageLimiter: newRateLimiter[int64](0, 0),
+		keyMessageLimiter:   newRateLimiter[int64](0, 0),
+		twoFactorLimiter:    newRateLimiter[int64](twoFactorAttempts, twoFactorAttemptWindow),
+		upgrades:            newUpgradeGuard(256),
+		passwords:           &auth.PasswordPolicy{},
+		notifications:       notifications,
+		flags:               featureFlags,
+		roomAccessCache:     newRoomAccessCache(),
+		tokenVersions:       newTokenVersionCache(),
+
+		passwordResetLimiter: newRateLimiter[string](passwordResetRequests, passwordResetRequestWindow),
+	}
+	app.secrets, _ = auth.NewSecretBox(testSecret)
+	// The defaults, without the search rate limit
+	runtime, _ := loadRuntimeConfig(func(string) (string, bool) { return "", false })
+	runtime.UserSearchRateLimit = 0
+	app.runtime.Store(runtime)
+	hub.SetInviteResponder(app)
+	go hub.Run()
+	return app
+}
+
+// testOption changes a test application before it's served: the settings
+// and dependencies a test needs beyond newTestApp's
+type testOption func(app *application)
+
+// newTestServer serves a new application on ts over a loopback listener
+func newTestServer(t *testing.T, ts *testStore, options ...testOption) *httptest.Server {
+	t.Helper()
+	server, _ := newTestServerApp(t, ts, options...)
+	return server
+}
+
+// newTestServerApp is newTestServer for tests that reach into the
+// application while it serves
+func newTestServerApp(t *testing.T, ts *testStore, options ...testOption) (*httptest.Server, *application) {
+	t.Helper()
+	app := newTestApp(ts)
+	for _, option := range options {
+		option(app)
+	}
+	server := httptest.NewServer(app.mount())
+	t.Cleanup(server.Close)
+	return server, app
+}
+
+// withOps turns the ops endpoints on, with the token "ops-secret"
+func withOps(app *application) {
+	app.config.ops = opsConfig{token: "ops-secret"}
+}
+
+// withClock runs the application's clock on clock
+func withClock(clock *fakeClock) testOption {
+	return func(app *application) {
+		app.now = clock.Now
+	}
+}
+
+// withMailer sends email through mailer (nil for none), with links to
+// https://chat.example.com/
+func withMailer(mailer mail.Mailer) testOption {
+	return func(app *application) {
+		app.mailer = mailer
+		app.config.mail.publicURL = "https://chat.example.com/"
+	}
+}
+
+// asUser makes r as userID, with a token signed by testSecret that isn't
+// bound to a login session, like those issued before sessions existed
+// User 0 makes it unauthenticated
+func asUser(t *testing.T, r *http.Request, userID int64) *http.Request {
+	t.Helper()
+	if userID == 0 {
+		return r
+	}
+	token, err := auth.GenerateToken(userID, 0, 0, time.Now().Add(auth.TokenLifetime), testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+// errorBody is the shape of every error response (see writeError)
+type errorBody struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// doJSON sends a request with body as JSON (none if nil) as userID and
+// decodes the response into out, if it isn't nil
+// Returns the response's status
+func doJSON(t *testing.T, method, url string, userID int64, body, out any) int {
+	t.Helper()
+	return doJSONWithHeaders(t, method, url, userID, nil, body, out)
+}
+
+// doJSONWithHeaders is doJSON with extra request headers
+func doJSONWithHeaders(t *testing.T, method, url string, userID int64, headers map[string]string, body, out any) int {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(asUser(t, req, userID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil && !errors.Is(err, io.EOF) {
+			t.Fatalf("%s %s: decoding the %d response: %v", method, url, resp.StatusCode, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// dialRoom connects userID to roomID's WebSocket on server
+// The connection is closed when the test ends
+func dialRoom(t *testing.T, server *httptest.Server, roomID, userID int64) *websocket.Conn {
+	t.Helper()
+	url := fmt.Sprintf("ws%s/v1/rooms/%d/ws", strings.TrimPrefix(server.URL, "http"), roomID)
+	header := asUser(t, httptest.NewRequest(http.MethodGet, url, nil), userID).Header
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		t.Fatalf("user %d dialing room %d got %d: %v", userID, roomID, status, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readFrame reads conn's frames until one of frameType arrives and returns it
+// The test fails if none comes within a few seconds
+func readFrame(t *testing.T, conn *websocket.Conn, frameType string) *ws.Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		var frame ws.Message
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatalf("waiting for a %s frame: %v", frameType, err)
+		}
+		if frame.Type == frameType {
+			return &frame
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package chatapi
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLocale is used when the client doesn't ask for a language we support
+const defaultLocale = "en"
+
+// genericErrorKey is the catalog entry used when an error code has no translation
+const genericErrorKey = "generic_error"
+
+// localeFiles embeds the message catalogs into the binary
+// Each file is named after its language tag (en.json, de.json, ...) and maps
+// machine-readable error codes to human-readable messages
+//
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalog holds every locale's messages, keyed by language tag then error code
+// It's loaded once at startup and only read afterwards, so no locking is needed
+var catalog = mustLoadCatalog()
+
+// mustLoadCatalog parses the embedded locale files
+// A broken catalog is a programming error, so we fail fast at startup
+func mustLoadCatalog() map[string]map[string]string {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		log.Fatalf("failed to read locale catalog: %v", err)
+	}
+
+	c := make(map[string]map[string]string)
+	for _, entry := range entries {
+		data, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			log.Fatalf("failed to read locale %s: %v", entry.Name(), err)
+		}
+
+		messages := make(map[string]string)
+		if err := json.Unmarshal(data, &messages); err != nil {
+			log.Fatalf("failed to parse locale %s: %v", entry.Name(), err)
+		}
+
+		locale := strings.TrimSuffix(entry.Name(), ".json")
+		c[locale] = messages
+	}
+
+	if _, ok := c[defaultLocale]; !ok {
+		log.Fatalf("locale catalog is missing the default locale %q", defaultLocale)
+	}
+	return c
+}
+
+// languagePreference is one entry of an Accept-Language header
+type languagePreference struct {
+	tag string
+	q   float64
+}
+
+// parseAcceptLanguage parses an Accept-Language header into tags ordered by preference
+// Example: "de-DE,de;q=0.9,en;q=0.8" -> ["de-de", "de", "en"]
+// Entries with q=0 (explicitly not acceptable) or malformed q-values are skipped
+func parseAcceptLanguage(header string) []string {
+	prefs := make([]languagePreference, 0)
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		tag := part
+		q := 1.0
+		// Parameters follow the tag, separated by semicolons: "de;q=0.9"
+		if i := strings.Index(part, ";"); i >= 0 {
+			tag = strings.TrimSpace(part[:i])
+			param := strings.TrimSpace(part[i+1:])
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				continue
+			}
+			q = parsed
+		}
+
+		if tag == "" || q == 0 {
+			continue
+		}
+		prefs = append(prefs, languagePreference{tag: strings.ToLower(tag), q: q})
+	}
+
+	// SliceStable keeps header order for equal q-values, as RFC 9110 intends
+	sort.SliceStable(prefs, func(i, j int) bool {
+		return prefs[i].q > prefs[j].q
+	})
+
+	tags := make([]string, len(prefs))
+	for i, p := range prefs {
+		tags[i] = p.tag
+	}
+	return tags
+}
+
+// resolveLocale picks the best supported locale for a request
+// Each preferred tag is tried as-is ("de-de") and then by its base language ("de")
+func resolveLocale(r *http.Request) string {
+	for _, tag := range parseAcceptLanguage(r.Header.Get("Accept-Language")) {
+		if _, ok := catalog[tag]; ok {
+			return tag
+		}
+		if base, _, found := strings.Cut(tag, "-"); found {
+			if _, ok := catalog[base]; ok {
+				return base
+			}
+		}
+		if tag == "*" {
+			return defaultLocale
+		}
+	}
+	return defaultLocale
+}
+
+// translate returns the message for code in locale, formatted with args
+// Missing translations fall back to English, and unknown codes fall back to a
+// generic message, so a typo in a handler never panics or leaks an empty error
+func translate(locale, code string, args ...interface{}) string {
+	message, ok := catalog[locale][code]
+	if !ok {
+		message, ok = catalog[defaultLocale][code]
+	}
+	if !ok {
+		log.Printf("Missing error message for code %q", code)
+		message, ok = catalog[locale][genericErrorKey]
+		if !ok {
+			message = catalog[defaultLocale][genericErrorKey]
+		}
+		return message
+	}
+
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
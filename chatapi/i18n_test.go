@@ -0,0 +1,99 @@
+package chatapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// TestParseAcceptLanguage orders a header's languages by q-value, keeping
+// header order between equals and skipping q=0 and malformed entries
+func TestParseAcceptLanguage(t *testing.T) {
+	for _, c := range []struct {
+		header string
+		want   []string
+	}{
+		{"", []string{}},
+		{"de-DE,de;q=0.9,en;q=0.8", []string{"de-de", "de", "en"}},
+		{"en;q=0.5, de", []string{"de", "en"}},
+		{"fr;q=0.8,de;q=0.8", []string{"fr", "de"}},
+		{"de;q=0,en", []string{"en"}},
+		{"de;q=abc,en;level=1,fr;q=2,tr", []string{"tr"}},
+		{" , ,en", []string{"en"}},
+	} {
+		if got := parseAcceptLanguage(c.header); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q parsed to %q, want %q", c.header, got, c.want)
+		}
+	}
+}
+
+// TestResolveLocale picks the first supported language, trying a regional
+// tag's base language, and falls back to English
+func TestResolveLocale(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                        "en",
+		"de-DE,de;q=0.9":          "de",
+		"de-AT":                   "de",
+		"fr-FR,fr;q=0.9,de;q=0.5": "de",
+		"fr,*;q=0.1":              "en",
+		"tr":                      "en",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Language", header)
+		if got := resolveLocale(r); got != want {
+			t.Errorf("%q resolved to %q, want %q", header, got, want)
+		}
+	}
+}
+
+// TestTranslateFallbacks translates codes the catalog lacks: a message
+// missing from a locale comes from English, and an unknown code gets the
+// generic message in the requested locale
+func TestTranslateFallbacks(t *testing.T) {
+	catalog["en"]["only_in_english"] = "only in English"
+	t.Cleanup(func() { delete(catalog["en"], "only_in_english") })
+
+	if got := translate("de", "only_in_english"); got != "only in English" {
+		t.Errorf("a message missing in German read %q, want the English one", got)
+	}
+	if got := translate("de", "no_such_code"); got != catalog["de"][genericErrorKey] {
+		t.Errorf("an unknown code read %q, want the German generic message", got)
+	}
+	if got := translate("de", "invalid_id_parameter", "roomID"); got != "ungültiger Parameter roomID: muss eine ganze Zahl sein" {
+		t.Errorf("the placeholder was filled to %q", got)
+	}
+}
+
+// TestLocalizedErrors checks that every catalog has the same codes and that
+// an error response carries its code, the message in the asked-for
+// language and Content-Language
+func TestLocalizedErrors(t *testing.T) {
+	for locale, messages := range catalog {
+		for code := range catalog[defaultLocale] {
+			if _, ok := messages[code]; !ok {
+				t.Errorf("%s has no message for %s", locale, code)
+			}
+		}
+	}
+
+	server := newTestServer(t, newTestStore(t))
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/v1/rooms", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Language", "de-CH, en;q=0.5")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var failure errorBody
+	if err := json.NewDecoder(resp.Body).Decode(&failure); err != nil {
+		t.Fatal(err)
+	}
+	if failure.Code != "missing_authorization_header" || failure.Error != catalog["de"][failure.Code] || resp.Header.Get("Content-Language") != "de" {
+		t.Errorf("got %q %q in %q, want missing_authorization_header in German", failure.Code, failure.Error, resp.Header.Get("Content-Language"))
+	}
+}
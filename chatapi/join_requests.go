@@ -0,0 +1,182 @@
+package chatapi
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/websocket"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// joinRequestCooldown is how long a rejected user must wait before knocking again
+const joinRequestCooldown = time.Hour
+
+// knockRoomHandler handles a join attempt on a room with the "approval" policy
+// Called from joinRoomHandler once the room has been loaded
+// Response (202): {"room_id": 1, "user_id": 5, "status": "pending", ...}
+func (app *application) knockRoomHandler(w http.ResponseWriter, r *http.Request, room *store.Room, userID int64) {
+	isMember, err := app.store.RoomMembers.IsUserInRoom(r.Context(), room.ID, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "membership_check_failed")
+		return
+	}
+	if isMember {
+		writeError(w, r, http.StatusConflict, "already_member")
+		return
+	}
+
+	request, created, err := app.store.JoinRequests.Knock(r.Context(), room.ID, userID, joinRequestCooldown)
+	if err != nil {
+		if errors.Is(err, store.ErrJoinRequestCooldown) {
+			writeError(w, r, http.StatusTooManyRequests, "join_request_cooldown")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "join_request_failed")
+		return
+	}
+
+	// Only a new request notifies the admins; knocking again while pending is a no-op
+	if created {
+		app.notifyJoinRequest(r, room.ID, userID)
+	}
+
+	writeJSON(w, http.StatusAccepted, request)
+}
+
+// notifyJoinRequest tells the room's online admins that someone is waiting
+// Failures are only logged: the request is stored and shows up in the list endpoint anyway
+func (app *application) notifyJoinRequest(r *http.Request, roomID, userID int64) {
+	user, err := app.store.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to load user %d for join request notification: %v", userID, err)
+		return
+	}
+
+	admins, err := app.store.RoomMembers.GetRoomAdmins(r.Context(), roomID)
+	if err != nil {
+		log.Printf("Failed to load admins of room %d: %v", roomID, err)
+		return
+	}
+
+	app.hub.SendToUsers(roomID, admins, &websocket.Message{
+		Message: wire.Message{
+			RoomID:   roomID,
+			UserID:   userID,
+			Username: user.Username,
+			Content:  user.Username + " asked to join the room",
+			Type:     "join_request",
+		},
+	})
+}
+
+// listJoinRequestsHandler returns a room's pending join requests
+// GET /v1/rooms/{roomID}/join-requests
+// Requires authentication and manage_members (by default the owner and admins)
+// Response: [{"room_id": 1, "user_id": 5, "username": "jane", "status": "pending", ...}]
+func (app *application) listJoinRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	if !app.requireRoomPermission(w, r, roomID, userID, store.CapManageMembers) {
+		return
+	}
+
+	requests, err := app.store.JoinRequests.ListPending(r.Context(), roomID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "join_requests_lookup_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, requests)
+}
+
+// approveJoinRequestHandler accepts a pending join request
+// POST /v1/rooms/{roomID}/join-requests/{userID}/approve
+// Requires authentication and manage_members (by default the owner and admins)
+// The requester becomes a member and is notified on their open connections
+// Response: {"message": "join request approved"}
+func (app *application) approveJoinRequestHandler(w http.ResponseWriter, r *http.Request) {
+	app.decideJoinRequest(w, r, store.JoinRequestApproved)
+}
+
+// rejectJoinRequestHandler declines a pending join request
+// POST /v1/rooms/{roomID}/join-requests/{userID}/reject
+// Requires authentication and manage_members (by default the owner and admins)
+// The requester can't knock again until the cooldown has passed
+// Response: {"message": "join request rejected"}
+func (app *application) rejectJoinRequestHandler(w http.ResponseWriter, r *http.Request) {
+	app.decideJoinRequest(w, r, store.JoinRequestRejected)
+}
+
+// decideJoinRequest is the shared implementation of approve and reject
+func (app *application) decideJoinRequest(w http.ResponseWriter, r *http.Request, decision string) {
+	adminID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	requesterID, err := extractIDFromURL(r, "userID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "userID")
+		return
+	}
+
+	if !app.requireRoomPermission(w, r, roomID, adminID, store.CapManageMembers) {
+		return
+	}
+
+	if decision == store.JoinRequestApproved {
+		err = app.store.JoinRequests.Approve(r.Context(), roomID, requesterID, adminID)
+	} else {
+		err = app.store.JoinRequests.Reject(r.Context(), roomID, requesterID, adminID)
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "join_request_not_found")
+			return
+		}
+		if app.writeLimitError(w, r, err) {
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "join_request_decision_failed")
+		return
+	}
+
+	if decision == store.JoinRequestApproved {
+		app.roomMembersChanged(roomID)
+	}
+
+	// Let the requester know wherever they're connected
+	frame := &websocket.Message{Message: wire.Message{RoomID: roomID, UserID: requesterID, Type: "join_" + decision}}
+	if decision == store.JoinRequestApproved {
+		frame.Content = "your request to join the room was approved"
+	} else {
+		frame.Content = "your request to join the room was rejected"
+	}
+	app.hub.SendToUser(requesterID, frame)
+
+	type response struct {
+		Message string `json:"message"`
+	}
+	writeJSON(w, http.StatusOK, response{Message: "join request " + decision})
+}
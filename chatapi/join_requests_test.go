@@ -0,0 +1,122 @@
+package chatapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestJoinPolicies joins rooms under each join policy: open rooms let
+// anyone in, approval rooms take a request once, invite rooms refuse, and
+// existing members are told so whatever the policy
+func TestJoinPolicies(t *testing.T) {
+	ts := newTestStore(t)
+	for _, room := range []*store.Room{
+		{ID: 1, Name: "open", CreatedBy: 1, JoinPolicy: store.JoinPolicyOpen},
+		{ID: 2, Name: "approval", CreatedBy: 1, JoinPolicy: store.JoinPolicyApproval},
+		{ID: 3, Name: "invite", CreatedBy: 1, JoinPolicy: store.JoinPolicyInvite},
+	} {
+		ts.rooms.add(room)
+		ts.roomMembers.add(room.ID, 1, store.RoomRoleAdmin)
+	}
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	server := newTestServer(t, ts)
+
+	for _, tc := range []struct {
+		roomID, userID int64
+		status         int
+		code           string
+	}{
+		{1, 2, http.StatusOK, ""},
+		{1, 2, http.StatusConflict, "already_member"},
+		{2, 2, http.StatusAccepted, ""},
+		{2, 2, http.StatusAccepted, ""},
+		{2, 1, http.StatusConflict, "already_member"},
+		{3, 2, http.StatusForbidden, "room_invite_only"},
+		{3, 1, http.StatusConflict, "already_member"},
+		{4, 2, http.StatusNotFound, "room_not_found"},
+	} {
+		var failure errorBody
+		status := doJSON(t, http.MethodPost, fmt.Sprintf("%s/v1/rooms/%d/join", server.URL, tc.roomID), tc.userID, nil, &failure)
+		if status != tc.status || failure.Code != tc.code {
+			t.Errorf("user %d joining room %d got %d %q, want %d %q", tc.userID, tc.roomID, status, failure.Code, tc.status, tc.code)
+		}
+	}
+
+	if member, _ := ts.roomMembers.IsUserInRoom(context.Background(), 2, 2); member {
+		t.Error("knocking on the approval room made grace a member")
+	}
+	if pending, _ := ts.joinRequests.ListPending(context.Background(), 2); len(pending) != 1 {
+		t.Errorf("knocking twice left %d pending requests, want 1", len(pending))
+	}
+}
+
+// TestDecideJoinRequests has room admins decide requests: the room's online
+// admins hear of each new request, only admins may decide, an approval
+// makes a member and reaches the requester wherever they're connected, and
+// a rejection keeps the user from knocking again at once
+func TestDecideJoinRequests(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "staff", CreatedBy: 1, JoinPolicy: store.JoinPolicyApproval})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 4, store.RoomRoleMember)
+	ts.rooms.add(&store.Room{ID: 2, Name: "lobby", CreatedBy: 1})
+	ts.roomMembers.add(2, 2, store.RoomRoleMember)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	ts.users.add(&store.User{ID: 3, Username: "linus"})
+	ts.users.add(&store.User{ID: 4, Username: "ken"})
+	server := newTestServer(t, ts)
+	admin := dialRoom(t, server, 1, 1)
+	readFrame(t, admin, "join") // Its own
+	requester := dialRoom(t, server, 2, 2)
+	readFrame(t, requester, "join")
+
+	for _, userID := range []int64{2, 3} {
+		if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/join", userID, nil, nil); status != http.StatusAccepted {
+			t.Fatalf("user %d knocking got %d, want 202", userID, status)
+		}
+	}
+	if frame := readFrame(t, admin, "join_request"); frame.UserID != 2 || frame.Username != "grace" {
+		t.Errorf("the admin was told of %+v, want grace's request", frame)
+	}
+
+	var pending []*store.JoinRequest
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/rooms/1/join-requests", 1, nil, &pending); status != http.StatusOK || len(pending) != 2 {
+		t.Errorf("listing requests got %d with %d requests, want 200 with 2", status, len(pending))
+	}
+	for _, path := range []string{"/v1/rooms/1/join-requests", "/v1/rooms/1/join-requests/2/approve"} {
+		method := http.MethodPost
+		if path == "/v1/rooms/1/join-requests" {
+			method = http.MethodGet
+		}
+		var failure errorBody
+		if status := doJSON(t, method, server.URL+path, 4, nil, &failure); status != http.StatusForbidden || failure.Code != "room_permission_denied" {
+			t.Errorf("a member's %s %s got %d %q, want 403 room_permission_denied", method, path, status, failure.Code)
+		}
+	}
+
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/join-requests/2/approve", 1, nil, nil); status != http.StatusOK {
+		t.Errorf("approving got %d, want 200", status)
+	}
+	if member, _ := ts.roomMembers.IsUserInRoom(context.Background(), 1, 2); !member {
+		t.Error("grace isn't a member after being approved")
+	}
+	if frame := readFrame(t, requester, "join_approved"); frame.RoomID != 1 {
+		t.Errorf("grace was told of an approval for room %d, want 1", frame.RoomID)
+	}
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/join-requests/3/reject", 1, nil, nil); status != http.StatusOK {
+		t.Errorf("rejecting got %d, want 200", status)
+	}
+
+	var failure errorBody
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/join-requests/3/approve", 1, nil, &failure); status != http.StatusNotFound || failure.Code != "join_request_not_found" {
+		t.Errorf("approving a decided request got %d %q, want 404 join_request_not_found", status, failure.Code)
+	}
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/join", 3, nil, &failure); status != http.StatusTooManyRequests || failure.Code != "join_request_cooldown" {
+		t.Errorf("knocking after a rejection got %d %q, want 429 join_request_cooldown", status, failure.Code)
+	}
+}
@@ -0,0 +1,110 @@
+package chatapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Per-route request limits
+//
+// Every route gets defaultBodyLimit and defaultRequestTimeout unless its group
+// in mount() says otherwise. Routes that take small bodies and should answer
+// fast (auth, message sends) get tighter limits, so a slow or oversized
+// request gives up early instead of holding a connection for a minute
+const (
+	defaultBodyLimit      = 1 << 20 // 1MB, plenty for any JSON payload
+	defaultRequestTimeout = 60 * time.Second
+
+	authBodyLimit = 16 << 10 // Credentials and a 2FA code
+	authTimeout   = 5 * time.Second
+
+	// A message's body limit follows the configured lengths (see messageBodyLimit)
+	messageTimeout = 10 * time.Second
+
+	// messageEnvelopeBytes is room in a message body for the JSON around the content
+	messageEnvelopeBytes = 24 << 10
+
+	// exportTimeout bounds a synchronous export; large ones run as jobs anyway
+	exportTimeout = 5 * time.Minute
+)
+
+// bodyLimitKey holds the body size limit set by withBodyLimit
+const bodyLimitKey contextKey = "bodyLimit"
+
+// withBodyLimit caps request bodies at n bytes for the routes it wraps
+// A Content-Length over the limit is refused with 413 before the handler
+// runs; a body that turns out longer while being read fails the read, which
+// readJSON callers report with writeBodyError
+func (app *application) withBodyLimit(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				writeError(w, r, http.StatusRequestEntityTooLarge, "request_body_too_large", n)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			ctx := context.WithValue(r.Context(), bodyLimitKey, n)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// messageBodyLimit is the body size limit of a message send: the longest
+// content the policy allows, at up to 4 bytes a rune, plus the JSON around it
+// (about 63KB with the default 10,000 rune code budget)
+func messageBodyLimit(policy content.LengthPolicy) int64 {
+	return int64(policy.Longest())*utf8.UTFMax + messageEnvelopeBytes
+}
+
+// withMessageBodyLimit is withBodyLimit at messageBodyLimit, worked out per
+// request so a reloaded length limit applies at once
+func (app *application) withMessageBodyLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.withBodyLimit(messageBodyLimit(app.hub.LengthPolicy()))(next).ServeHTTP(w, r)
+	})
+}
+
+// bodyLimit returns the body size limit for a request
+func bodyLimit(r *http.Request) int64 {
+	if n, ok := r.Context().Value(bodyLimitKey).(int64); ok {
+		return n
+	}
+	return defaultBodyLimit
+}
+
+// withTimeout gives the routes it wraps a context deadline of d
+// Handlers still running at the deadline see ctx.Done() and their queries
+// are cancelled; the client gets a 504 if nothing was written yet
+// Deadlines only get shorter when nested, so routes that need longer than
+// defaultRequestTimeout have to be registered outside the group that sets it
+func (app *application) withTimeout(d time.Duration) func(http.Handler) http.Handler {
+	return middleware.Timeout(d)
+}
+
+// withoutWriteTimeout lifts the server's WriteTimeout for a streamed response
+// that can take longer to send; pair it with withTimeout so the handler still
+// has an upper bound
+func (app *application) withoutWriteTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Not every ResponseWriter supports deadlines; then the server's applies
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeBodyError reports a readJSON failure: 413 if the body was over the
+// route's limit, 400 for anything else
+func writeBodyError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, r, http.StatusRequestEntityTooLarge, "request_body_too_large", tooLarge.Limit)
+		return
+	}
+	writeError(w, r, http.StatusBadRequest, "invalid_request_body")
+}
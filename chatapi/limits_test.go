@@ -0,0 +1,170 @@
+package chatapi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// walkRoutes calls fn with every route under routes and the middleware in
+// front of it. Unlike chi.Walk it keeps the middleware of a group a
+// subrouter is mounted in, which chi chains onto the mount itself
+func walkRoutes(routes chi.Routes, prefix string, parent []func(http.Handler) http.Handler, fn func(method, route string, middlewares []func(http.Handler) http.Handler)) {
+	for _, route := range routes.Routes() {
+		middlewares := append(slices.Clone(parent), routes.Middlewares()...)
+		if route.SubRoutes != nil {
+			for _, handler := range route.Handlers {
+				if chain, ok := handler.(*chi.ChainHandler); ok {
+					middlewares = append(middlewares, chain.Middlewares...)
+					break
+				}
+			}
+			walkRoutes(route.SubRoutes, prefix+strings.TrimSuffix(route.Pattern, "/*"), middlewares, fn)
+			continue
+		}
+		for method, handler := range route.Handlers {
+			if method == "*" {
+				continue
+			}
+			if chain, ok := handler.(*chi.ChainHandler); ok {
+				fn(method, prefix+route.Pattern, append(slices.Clone(middlewares), chain.Middlewares...))
+			} else {
+				fn(method, prefix+route.Pattern, middlewares)
+			}
+		}
+	}
+}
+
+// TestRouteDeadlines runs a probe behind each route's middleware, as chi
+// registered it, and checks the deadline its request context gets: none for
+// the WebSocket routes, whose connections outlive the upgrade request, the
+// tighter ones where a group sets them, and the default everywhere else.
+// The routes named in want are there so the test fails if they move
+func TestRouteDeadlines(t *testing.T) {
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	routes := newTestApp(ts).mount().(chi.Routes)
+
+	want := map[string]time.Duration{
+		"GET /v1/rooms/{roomID}/ws":              0,
+		"GET /v1/rooms/{roomID}/ws/guest":        0,
+		"POST /v1/auth/register":                 authTimeout,
+		"POST /v1/auth/login":                    authTimeout,
+		"POST /v1/auth/2fa/verify":               authTimeout,
+		"POST /v1/auth/forgot-password":          authTimeout,
+		"POST /v1/auth/reset-password":           authTimeout,
+		"GET /v1/auth/oauth/{provider}/login":    authTimeout,
+		"GET /v1/auth/oauth/{provider}/callback": oauthTimeout,
+		"POST /v1/rooms/{roomID}/messages":       messageTimeout,
+		"GET /v1/users/me/export":                exportTimeout,
+		"GET /v1/rooms/{roomID}/messages":        defaultRequestTimeout,
+	}
+	seen := 0
+	walkRoutes(routes, "", nil, func(method, route string, middlewares []func(http.Handler) http.Handler) {
+		if !strings.HasPrefix(route, "/v1/") {
+			return
+		}
+		var remaining time.Duration
+		var hasDeadline bool
+		var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var deadline time.Time
+			deadline, hasDeadline = r.Context().Deadline()
+			remaining = time.Until(deadline)
+			w.WriteHeader(http.StatusNoContent)
+		})
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			handler = middlewares[i](handler)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, asUser(t, httptest.NewRequest(method, "/probe", nil), 1))
+		if rec.Code != http.StatusNoContent {
+			// Refused by a middleware before the probe (ops token, guest checks)
+			return
+		}
+
+		key := method + " " + route
+		timeout, listed := want[key]
+		if listed {
+			seen++
+		} else {
+			timeout = defaultRequestTimeout
+		}
+		if timeout == 0 {
+			if hasDeadline {
+				t.Errorf("%s has a deadline in %s, want none", key, remaining)
+			}
+			return
+		}
+		if !hasDeadline || remaining > timeout || remaining < timeout-time.Second {
+			t.Errorf("%s has a deadline in %s (set %v), want %s", key, remaining, hasDeadline, timeout)
+		}
+	})
+	if seen != len(want) {
+		t.Errorf("probed %d of the %d routes checked by name", seen, len(want))
+	}
+}
+
+// TestBodyLimits sends bodies over each route's limit, with and without a
+// Content-Length: both get a 413 in the standard error shape naming the
+// limit, while the longest message, in 4-byte runes, still fits
+func TestBodyLimits(t *testing.T) {
+	ts := newTestStore(t)
+	addLoginUser(t, ts)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	server := newTestServer(t, ts)
+
+	messageLimit := messageBodyLimit(content.LengthPolicy{})
+
+	// padded is a JSON object of about n bytes
+	padded := func(n int) []byte {
+		return []byte(`{"content": "` + strings.Repeat("a", n) + `"}`)
+	}
+	for _, tc := range []struct {
+		name    string
+		path    string
+		body    []byte
+		chunked bool
+		limit   string
+	}{
+		{"login", "/v1/auth/login", padded(authBodyLimit), false, "16384"},
+		{"login, chunked", "/v1/auth/login", padded(authBodyLimit), true, "16384"},
+		{"message", "/v1/rooms/1/messages", padded(int(messageLimit)), false, fmt.Sprint(messageLimit)},
+		{"message, chunked", "/v1/rooms/1/messages", padded(int(messageLimit)), true, fmt.Sprint(messageLimit)},
+		{"room, the default limit", "/v1/rooms", padded(defaultBodyLimit), true, "1048576"},
+	} {
+		var body io.Reader = bytes.NewReader(tc.body)
+		if tc.chunked {
+			// Hides the length, so the client sends it chunked
+			body = io.MultiReader(body)
+		}
+		req, err := http.NewRequest(http.MethodPost, server.URL+tc.path, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(asUser(t, req, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusRequestEntityTooLarge || !strings.Contains(string(raw), `"code":"request_body_too_large"`) || !strings.Contains(string(raw), tc.limit) {
+			t.Errorf("%s got %d %s, want 413 request_body_too_large with the limit", tc.name, resp.StatusCode, raw)
+		}
+	}
+
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/messages", 1, SendMessageRequest{Content: strings.Repeat("😀", 4000)}, nil); status != http.StatusCreated {
+		t.Errorf("a message of 4,000 emoji got %d, want 201", status)
+	}
+}
@@ -0,0 +1,73 @@
+package chatapi
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+const (
+	// defaultMembershipEventsLimit is the page size when ?limit is not given
+	defaultMembershipEventsLimit = 50
+
+	// maxMembershipEventsLimit caps how many events one request can ask for
+	maxMembershipEventsLimit = 200
+)
+
+// listMembershipEventsHandler returns a page of a room's membership history, newest first
+// For the next page, pass the id of the last event as ?before
+// GET /v1/rooms/{roomID}/membership-events?user_id=5&limit=50&before=120
+// Requires authentication and manage_members (by default the owner and admins)
+// Response: [{"id": 119, "user_id": 5, "username": "jane", "event": "left", "actor_id": 5, ...}]
+func (app *application) listMembershipEventsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	q := store.MembershipEventQuery{Limit: defaultMembershipEventsLimit}
+	if raw := r.URL.Query().Get("user_id"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id < 1 {
+			writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "user_id")
+			return
+		}
+		q.UserID = id
+	}
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxMembershipEventsLimit {
+			writeError(w, r, http.StatusBadRequest, "invalid_pagination")
+			return
+		}
+		q.Limit = n
+	}
+	if raw := r.URL.Query().Get("before"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id < 1 {
+			writeError(w, r, http.StatusBadRequest, "invalid_pagination")
+			return
+		}
+		q.Before = id
+	}
+
+	if !app.requireRoomPermission(w, r, roomID, userID, store.CapManageMembers) {
+		return
+	}
+
+	events, err := app.store.MembershipEvents.List(r.Context(), roomID, q)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "membership_events_lookup_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, events)
+}
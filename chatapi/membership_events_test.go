@@ -0,0 +1,48 @@
+package chatapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestListMembershipEvents reads a room's membership history: only those
+// who may manage members (admins, by default) may, and the filters and cursor reach the store as given, with
+// bad values refused
+func TestListMembershipEvents(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 4})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	server := newTestServer(t, ts)
+
+	for _, tc := range []struct {
+		userID int64
+		query  string
+		status int
+		code   string
+		want   store.MembershipEventQuery
+	}{
+		{1, "", http.StatusOK, "", store.MembershipEventQuery{Limit: defaultMembershipEventsLimit}},
+		{1, "?user_id=2&limit=10&before=120", http.StatusOK, "", store.MembershipEventQuery{UserID: 2, Limit: 10, Before: 120}},
+		{2, "", http.StatusForbidden, "room_permission_denied", store.MembershipEventQuery{}},
+		{1, "?user_id=x", http.StatusBadRequest, "invalid_id_parameter", store.MembershipEventQuery{}},
+		{1, "?limit=0", http.StatusBadRequest, "invalid_pagination", store.MembershipEventQuery{}},
+		{1, "?limit=201", http.StatusBadRequest, "invalid_pagination", store.MembershipEventQuery{}},
+		{1, "?before=-1", http.StatusBadRequest, "invalid_pagination", store.MembershipEventQuery{}},
+	} {
+		ts.memberships.last = store.MembershipEventQuery{}
+		var response json.RawMessage
+		status := doJSON(t, http.MethodGet, server.URL+"/v1/rooms/1/membership-events"+tc.query, tc.userID, nil, &response)
+		var failure errorBody
+		json.Unmarshal(response, &failure)
+		if status != tc.status || failure.Code != tc.code {
+			t.Errorf("user %d %q: got %d %q, want %d %q", tc.userID, tc.query, status, failure.Code, tc.status, tc.code)
+		}
+		if ts.memberships.last != tc.want {
+			t.Errorf("user %d %q: the store was asked %+v, want %+v", tc.userID, tc.query, ts.memberships.last, tc.want)
+		}
+	}
+}
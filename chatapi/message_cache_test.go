@@ -0,0 +1,78 @@
+package chatapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestMessageCacheHistory serves room 1's history through the message cache:
+// messages ada sends over a connection are appended to it and the history
+// matches what's saved without a second read, a redaction drops the cached
+// copy, and the readiness check reports the hits
+func TestMessageCacheHistory(t *testing.T) {
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.messages.addMessages(1, 2, 3)
+	ts.Storage = CacheMessages(ts.Storage, MessageCacheConfig{MaxMessages: 100})
+	cache := ts.Messages.(*store.MessageCache)
+
+	server := newTestServer(t, ts, withOps)
+
+	history := func() []*store.Message {
+		t.Helper()
+		var messages []*store.Message
+		if status := doJSON(t, http.MethodGet, server.URL+"/v1/rooms/1/messages", 2, nil, &messages); status != http.StatusOK {
+			t.Fatalf("the history got %d, want 200", status)
+		}
+		return messages
+	}
+	history() // Loads the room's window
+
+	ada := dialRoom(t, server, 1, 1)
+	readFrame(t, ada, "join")
+	for _, content := range []string{"hello", "anyone here?"} {
+		if err := ada.WriteJSON(map[string]string{"content": content}); err != nil {
+			t.Fatal(err)
+		}
+		readFrame(t, ada, "message")
+	}
+
+	misses := cache.Stats().Misses
+	cached := history()
+	saved, _ := ts.messages.GetRoomMessages(context.Background(), 1, 100)
+	if len(cached) != 5 || len(saved) != 5 {
+		t.Fatalf("the history holds %d messages and the database %d, want 5", len(cached), len(saved))
+	}
+	got, _ := json.Marshal(cached)
+	want, _ := json.Marshal(saved)
+	if !bytes.Equal(got, want) {
+		t.Errorf("the history is\n%s\nwant it as saved:\n%s", got, want)
+	}
+	if stats := cache.Stats(); stats.Misses != misses || stats.Hits == 0 {
+		t.Errorf("reading the history again read the database (%+v)", stats)
+	}
+
+	ops := map[string]string{opsTokenHeader: "ops-secret"}
+	url := server.URL + "/v1/admin/messages/4/redact"
+	if status := doJSONWithHeaders(t, http.MethodPost, url, 0, ops, RedactMessageRequest{Actor: "ops"}, nil); status != http.StatusOK {
+		t.Fatalf("redacting got %d, want 200", status)
+	}
+	if messages := history(); messages[3].Content != store.RedactedContent {
+		t.Errorf("after the redaction the history shows %q, want the marker", messages[3].Content)
+	}
+
+	var ready ReadinessResponse
+	doJSON(t, http.MethodGet, server.URL+"/v1/health/ready", 0, nil, &ready)
+	if ready.MessageCache == nil || ready.MessageCache.Hits == 0 || ready.MessageCache.MaxMessages != 100 {
+		t.Errorf("the readiness check reports %+v, want the cache's hits", ready.MessageCache)
+	}
+}
@@ -0,0 +1,173 @@
+package chatapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// update rewrites the golden files instead of comparing against them:
+// go test ./chatapi -run Golden -update
+var update = flag.Bool("update", false, "rewrite the testdata/*.golden files")
+
+// historyPage is n messages from authors taking turns, every field set on
+// the first and the omitempty ones left empty on the rest
+func historyPage(n, authors int) []*store.Message {
+	names := []string{"ada", "grace", "linus", "ken"}
+	start := time.Date(2026, 3, 14, 15, 9, 26, 0, time.UTC)
+	messages := make([]*store.Message, n)
+	for i := range messages {
+		author := i % authors
+		messages[i] = &store.Message{
+			ID:          int64(i + 1),
+			RoomID:      7,
+			UserID:      int64(author + 1),
+			Username:    names[author%len(names)],
+			Content:     fmt.Sprintf("message %d, \"quoted\" <b>", i+1),
+			CreatedAt:   start.Add(time.Duration(i) * time.Second),
+			ContentType: "text",
+		}
+	}
+	first := messages[0]
+	first.ContentType, first.Language = "code", "go"
+	first.Filtered, first.Truncated, first.Override, first.System, first.Redacted = true, true, true, true, true
+	first.RoomMention = "here"
+	return messages
+}
+
+// messageList encodes messages as a history request with query would get them
+func messageList(t testing.TB, query string, messages []*store.Message) []byte {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/v1/rooms/7/messages?"+query, nil)
+	w := httptest.NewRecorder()
+	opts, ok := parseMessageListOptions(w, r)
+	if !ok {
+		t.Fatalf("%s was refused: %s", query, w.Body)
+	}
+	writeMessageList(w, http.StatusOK, messages, opts)
+	return w.Body.Bytes()
+}
+
+// checkGolden compares body, indented, with testdata/name
+func checkGolden(t *testing.T, name string, body []byte) {
+	t.Helper()
+	var got bytes.Buffer
+	if err := json.Indent(&got, body, "", "  "); err != nil {
+		t.Fatalf("%s isn't JSON: %v", name, err)
+	}
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("%s changed; if that's intended, run with -update\ngot:\n%s\nwant:\n%s", name, got.Bytes(), want)
+	}
+}
+
+// TestMessageListGolden pins the compact format, with and without a fieldset
+func TestMessageListGolden(t *testing.T) {
+	page := historyPage(3, 2)
+	checkGolden(t, "messages_compact.golden", messageList(t, "compact=true", page))
+	checkGolden(t, "messages_compact_fields.golden", messageList(t, "compact=true&fields=id,content,username,filtered", page))
+	checkGolden(t, "messages_fields.golden", messageList(t, "fields=created_at,id,language", page))
+}
+
+// TestMessageListFullFormat checks the registry against the struct: every
+// field, left out when empty, encodes exactly as encoding/json does, so the
+// compact format differs from the plain one only by the usernames
+func TestMessageListFullFormat(t *testing.T) {
+	page := historyPage(3, 2)
+	var compact struct {
+		Messages []map[string]any  `json:"messages"`
+		Users    map[string]string `json:"users"`
+	}
+	if err := json.Unmarshal(messageList(t, "compact=true", page), &compact); err != nil {
+		t.Fatal(err)
+	}
+	var plain []map[string]any
+	if err := json.Unmarshal(messageList(t, "", page), &plain); err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range plain {
+		username := m["username"]
+		delete(m, "username")
+		got, _ := json.Marshal(compact.Messages[i])
+		want, _ := json.Marshal(m)
+		if !bytes.Equal(got, want) {
+			t.Errorf("message %d is %s compacted, want %s", i, got, want)
+		}
+		if user := compact.Users[fmt.Sprint(m["user_id"])]; user != username {
+			t.Errorf("message %d's author is %q in the users table, want %q", i, user, username)
+		}
+	}
+	if len(compact.Users) != 2 {
+		t.Errorf("the users table is %v, want two authors", compact.Users)
+	}
+}
+
+// TestMessageFields asks a public room's history for some fields over HTTP,
+// then for ones that don't exist
+func TestMessageFields(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "lobby", CreatedBy: 1, IsPublicReadonly: true})
+	ts.messages.addMessages(1, 1, 2)
+	server := newTestServer(t, ts)
+	url := server.URL + "/v1/rooms/1/messages/public"
+
+	var got []map[string]any
+	if status := doJSON(t, http.MethodGet, url+"?fields=id,+content", 0, nil, &got); status != http.StatusOK {
+		t.Fatalf("asking for fields got %d, want 200", status)
+	}
+	for _, m := range got {
+		if len(m) != 2 || m["id"] == nil || m["content"] == nil {
+			t.Errorf("got %v, want only id and content", m)
+		}
+	}
+
+	var failure errorBody
+	if status := doJSON(t, http.MethodGet, url+"?fields=id,password,avatar", 0, nil, &failure); status != http.StatusBadRequest || failure.Code != "invalid_message_fields" {
+		t.Fatalf("unknown fields got %d %q, want 400 invalid_message_fields", status, failure.Code)
+	}
+	if want := "unknown message fields: password, avatar (valid fields: id, room_id, user_id"; !bytes.HasPrefix([]byte(failure.Error), []byte(want)) {
+		t.Errorf("the error is %q, want it to name the unknown and the valid fields", failure.Error)
+	}
+}
+
+// BenchmarkMessageList encodes a 100-message page from four authors and
+// reports each format's size next to the full one's
+func BenchmarkMessageList(b *testing.B) {
+	page := historyPage(100, 4)
+	full := len(messageList(b, "", page))
+	for _, tc := range []struct{ name, query string }{
+		{"full", ""},
+		{"compact", "compact=true"},
+		{"fields", "fields=id,content,user_id,created_at"},
+		{"compact_fields", "compact=true&fields=id,content,user_id,created_at"},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			size := len(messageList(b, tc.query, page))
+			for b.Loop() {
+				messageList(b, tc.query, page)
+			}
+			// After the loop, which resets reported metrics
+			b.ReportMetric(float64(size), "bytes/page")
+			b.ReportMetric(100*float64(full-size)/float64(full), "%saved")
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package chatapi
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/drazan344/go-chat/internal/store"
+	ws "github.com/drazan344/go-chat/internal/websocket"
+)
+
+// SendMessageRequest represents the JSON structure for sending a message over REST
+// The fields mirror a WebSocket chat frame
+type SendMessageRequest struct {
+	Content     string `json:"content"`
+	ContentType string `json:"content_type"` // Optional, defaults to "text"
+	Language    string `json:"language"`     // Only for code messages
+
+	// Silent delivers the message without notifying anyone; API tokens and keys only
+	Silent bool `json:"silent"`
+
+	// For bridges relaying another chat: the original speaker's name and
+	// avatar, shown instead of the bot's own; API tokens and keys only
+	OverrideUsername  string `json:"override_username"`
+	OverrideAvatarURL string `json:"override_avatar_url"`
+}
+
+// Limits on the identity a bridge relays a message under
+const (
+	maxOverrideUsernameLength  = 64 // Runes
+	maxOverrideAvatarURLLength = 2048
+)
+
+// sendRoomMessageHandler posts a message without a WebSocket connection
+// It's for scripts, curl and server-side integrations that post occasionally
+// The message goes through the same validation and content filter as the
+// WebSocket path, is saved, and is then pushed to connected clients live
+// POST /v1/rooms/{roomID}/messages
+// Requires authentication and room membership
+// Request body: {"content": "Hello!", "content_type": "text"}; API token
+// callers may add "silent": true to deliver it without notifying anyone, and
+// "override_username" and "override_avatar_url" to relay it for someone else
+// Messages sent with an API token are rate limited per token
+// @here and @room notify the room with mention_everyone, @room once per 10
+// minutes (429 room_mention_rate_limited); the response's "room_mention"
+// says whether they did
+// Response: {"id": 42, "room_id": 1, "content": "Hello!", "created_at": "...", ...}
+func (app *application) sendRoomMessageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	var req SendMessageRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	// Silent messages are for bots and integrations, which use API tokens or keys
+	isBot := !viaLoginSession(r.Context())
+	if req.Silent && !isBot {
+		writeError(w, r, http.StatusForbidden, "silent_not_allowed")
+		return
+	}
+	// So is relaying for someone else
+	if (req.OverrideUsername != "" || req.OverrideAvatarURL != "") && !isBot {
+		writeError(w, r, http.StatusForbidden, "override_not_allowed")
+		return
+	}
+	// One limit per token or key, whoever a bridge says the messages are from
+	if tokenID, isToken := APITokenIDFromContext(r.Context()); isToken && !app.allowBotMessage(w, r, app.tokenMessageLimiter, tokenID) {
+		return
+	}
+	if keyID, isKey := APIKeyIDFromContext(r.Context()); isKey && !app.allowBotMessage(w, r, app.keyMessageLimiter, keyID) {
+		return
+	}
+	if !app.checkMessageOverride(w, r, &req) {
+		return
+	}
+
+	// Non-members have no capabilities, so this also keeps them out
+	access, ok := app.authorizeRoom(w, r, roomID, userID, store.CapPostMessage)
+	if !ok {
+		return
+	}
+
+	// Announcement rooms only take messages from the owner and admins, as in the hub
+	if !store.PostPolicyAllows(app.hub.RoomPostPolicy(r.Context(), roomID), access.Role) {
+		writeError(w, r, http.StatusForbidden, "room_announcement_only")
+		return
+	}
+
+	// During quiet hours only the creator and admins may post (423 Locked for everyone else)
+	quiet := app.hub.CheckQuietHours(r.Context(), roomID, userID)
+	if quiet == ws.QuietRejected {
+		writeError(w, r, http.StatusLocked, "room_quiet_hours")
+		return
+	}
+
+	// Same rules as the WebSocket path (see content.Validate)
+	// Validation codes double as catalog keys
+	formatted, err := content.Validate(content.Formatted{
+		Type:     req.ContentType,
+		Language: req.Language,
+		Body:     req.Content,
+	}, app.hub.LengthPolicy())
+	if err != nil {
+		var validationErr *content.Error
+		if errors.As(err, &validationErr) {
+			writeError(w, r, http.StatusUnprocessableEntity, validationErr.Code)
+			return
+		}
+		writeError(w, r, http.StatusBadRequest, "invalid_request_body")
+		return
+	}
+
+	message := &store.Message{
+		RoomID:      roomID,
+		UserID:      userID,
+		Content:     formatted.Body,
+		ContentType: formatted.Type,
+		Language:    formatted.Language,
+		Truncated:   formatted.Truncated,
+		Override:    quiet == ws.QuietOverride,
+		ContentHash: content.Hash(formatted.Body),
+
+		OverrideUsername:  req.OverrideUsername,
+		OverrideAvatarURL: req.OverrideAvatarURL,
+	}
+
+	// Rooms can refuse the same message sent over and over
+	if app.hub.IsDuplicate(r.Context(), roomID, userID, message.ContentHash) {
+		writeError(w, r, http.StatusTooManyRequests, "duplicate_message")
+		return
+	}
+
+	user, err := app.store.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "user_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return
+	}
+	message.Username = user.Username
+
+	// The room's moderation bot, if it has one, sees the message first, as it does in the hub
+	if !app.applyModerationHook(w, r, message) {
+		return
+	}
+
+	// The content filter runs before saving, as it does in the hub
+	verdict, rewritten := app.hub.Moderate(r.Context(), roomID, message.Content)
+	switch verdict {
+	case content.VerdictReject:
+		writeError(w, r, http.StatusUnprocessableEntity, "content_rejected")
+		return
+	case content.VerdictMask:
+		message.Content = rewritten
+		message.Filtered = true
+	}
+
+	// @here and @room notify the room only with mention_everyone; without it
+	// they stay plain text. @room shares the hub's allowance
+	if !app.applyRoomMention(w, r, access, message) {
+		return
+	}
+
+	if err := app.store.Messages.Create(r.Context(), message); err != nil {
+		// The sender can try again, so the @room they were allowed isn't spent
+		if message.RoomMention == content.MentionRoom {
+			app.hub.RefundRoomMention(roomID, userID)
+		}
+		writeError(w, r, http.StatusInternalServerError, "message_save_failed")
+		return
+	}
+
+	// Already saved, so the hub only delivers it
+	frame := ws.NewChatMessage(message)
+	frame.Silent = req.Silent
+	app.hub.InjectMessage(frame)
+
+	writeJSON(w, http.StatusCreated, message)
+}
+
+// applyRoomMention sets a message's RoomMention if it uses @here or @room
+// and the sender's role allows it
+// It writes a 429 with Retry-After and returns false once the sender's @room
+// allowance in the room is used up, as the hub refuses it; a message that
+// then fails to save is refunded with Hub.RefundRoomMention
+func (app *application) applyRoomMention(w http.ResponseWriter, r *http.Request, access *store.RoomAccess, message *store.Message) bool {
+	scope := content.RoomMention(message.Content)
+	if scope == "" || !access.Can(store.CapMentionEveryone) {
+		return true
+	}
+	if scope == content.MentionRoom {
+		if ok, retryAfter := app.hub.AllowRoomMention(message.RoomID, message.UserID); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			writeError(w, r, http.StatusTooManyRequests, "room_mention_rate_limited")
+			return false
+		}
+	}
+	message.RoomMention = scope
+	return true
+}
+
+// allowBotMessage applies the per-token (or per-key) limit on messages sent
+// with an API token or key
+// It writes a 429 with Retry-After and returns false once it's over the limit
+func (app *application) allowBotMessage(w http.ResponseWriter, r *http.Request, limiter *rateLimiter[int64], id int64) bool {
+	ok, retryAfter := limiter.allow(id)
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeError(w, r, http.StatusTooManyRequests, "message_rate_limited")
+		return false
+	}
+	return true
+}
+
+// checkMessageOverride validates and normalizes the identity a bridge relays
+// a message under; both fields are optional
+// The name may not be a local user's (or the system user's): it's only text,
+// and a relayed "alice" that clients show like the real alice would invite
+// replies mentioning, and notifying, the wrong person
+// On failure it writes the error response and returns false
+func (app *application) checkMessageOverride(w http.ResponseWriter, r *http.Request, req *SendMessageRequest) bool {
+	req.OverrideUsername = strings.TrimSpace(req.OverrideUsername)
+	req.OverrideAvatarURL = strings.TrimSpace(req.OverrideAvatarURL)
+
+	fields := make(map[string][]fieldError)
+	if name := req.OverrideUsername; name != "" {
+		if utf8.RuneCountInString(name) > maxOverrideUsernameLength {
+			fields["override_username"] = append(fields["override_username"], fieldError{Error: "must be at most 64 characters", Code: "too_long"})
+		}
+		if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+			fields["override_username"] = append(fields["override_username"], fieldError{Error: "must not contain control characters", Code: "invalid_characters"})
+		}
+	}
+	if raw := req.OverrideAvatarURL; raw != "" {
+		u, err := url.Parse(raw)
+		switch {
+		case len(raw) > maxOverrideAvatarURLLength:
+			fields["override_avatar_url"] = append(fields["override_avatar_url"], fieldError{Error: "must be at most 2048 characters", Code: "too_long"})
+		case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+			fields["override_avatar_url"] = append(fields["override_avatar_url"], fieldError{Error: "must be an http or https URL", Code: "invalid_url"})
+		}
+	}
+
+	// Only worth a lookup once the name is otherwise fine
+	if name := req.OverrideUsername; name != "" && len(fields["override_username"]) == 0 {
+		taken := name == app.config.systemUsername
+		if !taken {
+			_, err := app.store.Users.GetByUsername(r.Context(), name)
+			switch {
+			case err == nil:
+				taken = true
+			case !errors.Is(err, sql.ErrNoRows):
+				writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+				return false
+			}
+		}
+		if taken {
+			fields["override_username"] = append(fields["override_username"], fieldError{Error: "is a local user's name", Code: "username_taken"})
+		}
+	}
+
+	if len(fields) > 0 {
+		writeFieldErrors(w, r, http.StatusBadRequest, "invalid_message_override", fields)
+		return false
+	}
+	return true
+}
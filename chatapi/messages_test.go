@@ -0,0 +1,333 @@
+package chatapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/drazan344/go-chat/internal/store"
+	ws "github.com/drazan344/go-chat/internal/websocket"
+)
+
+// TestSendMessageOverREST posts a message without a WebSocket: a member
+// connected to the room gets it live, with the ID it was saved under, and
+// the room's history has it exactly once
+func TestSendMessageOverREST(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	server := newTestServer(t, ts)
+	conn := dialRoom(t, server, 1, 1)
+	readFrame(t, conn, "join")
+
+	var sent store.Message
+	body := SendMessageRequest{Content: "hello from curl"}
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/messages", 2, body, &sent); status != http.StatusCreated {
+		t.Fatalf("sending got %d, want 201", status)
+	}
+	if sent.ID == 0 || sent.CreatedAt.IsZero() || sent.Username != "grace" || sent.ContentType != content.TypeText {
+		t.Errorf("the response is %+v, want the saved message", sent)
+	}
+
+	live := readFrame(t, conn, "message")
+	if live.ID != sent.ID || live.Content != "hello from curl" || live.UserID != 2 || live.Username != "grace" {
+		t.Errorf("the connected client got %+v, want grace's message %d", live, sent.ID)
+	}
+
+	var history []*store.Message
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/rooms/1/messages", 1, nil, &history); status != http.StatusOK {
+		t.Fatalf("the history got %d, want 200", status)
+	}
+	if len(history) != 1 || history[0].ID != sent.ID {
+		t.Errorf("the history holds %d messages, want grace's once", len(history))
+	}
+}
+
+// rejectWord is a content filter rejecting any message that contains it
+type rejectWord string
+
+func (w rejectWord) Check(_ context.Context, message string) (content.Verdict, string, error) {
+	if strings.Contains(message, string(w)) {
+		return content.VerdictReject, "", nil
+	}
+	return content.VerdictAllow, message, nil
+}
+
+// TestSendMessageChecks checks a REST message gets the WebSocket path's
+// rules: members only, validated content, and the room's content filter
+// Nothing refused is saved
+func TestSendMessageChecks(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "filtered", CreatedBy: 1, ContentFilterEnabled: true})
+	ts.rooms.add(&store.Room{ID: 2, Name: "unfiltered", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(2, 1, store.RoomRoleAdmin)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	server := newTestServer(t, ts, func(app *application) {
+		app.hub = ws.NewHub(ts.Storage, 1)
+		app.hub.SetContentFilter(rejectWord("darn"))
+		go app.hub.Run()
+	})
+
+	for _, tc := range []struct {
+		name   string
+		userID int64
+		roomID string
+		body   SendMessageRequest
+		status int
+		code   string
+	}{
+		{"not a member", 3, "1", SendMessageRequest{Content: "hi"}, http.StatusForbidden, "room_permission_denied"},
+		{"empty", 1, "1", SendMessageRequest{Content: "  "}, http.StatusUnprocessableEntity, "empty_message"},
+		{"unknown type", 1, "1", SendMessageRequest{Content: "hi", ContentType: "html"}, http.StatusUnprocessableEntity, "unknown_content_type"},
+		{"filtered", 1, "1", SendMessageRequest{Content: "darn it"}, http.StatusUnprocessableEntity, "content_rejected"},
+		{"filter off for the room", 1, "2", SendMessageRequest{Content: "darn it"}, http.StatusCreated, ""},
+	} {
+		var failure errorBody
+		status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/"+tc.roomID+"/messages", tc.userID, tc.body, &failure)
+		if status != tc.status || failure.Code != tc.code {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, status, failure.Code, tc.status, tc.code)
+		}
+	}
+
+	ts.messages.mu.Lock()
+	saved := len(ts.messages.messages)
+	ts.messages.mu.Unlock()
+	if saved != 1 {
+		t.Errorf("%d messages were saved, want only the one in the unfiltered room", saved)
+	}
+}
+
+// TestSendMessageLengthPolicy holds REST messages to the hub's length
+// policy: with truncation on, a long message is saved cut down and marked
+func TestSendMessageLengthPolicy(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	server := newTestServer(t, ts, func(app *application) {
+		app.hub = ws.NewHub(ts.Storage, 1)
+		tunables := ws.DefaultTunables()
+		tunables.Lengths = content.LengthPolicy{MaxLength: 5, Oversize: content.OversizeTruncate}
+		app.hub.SetTunables(tunables)
+		go app.hub.Run()
+	})
+
+	var sent store.Message
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/messages", 1, SendMessageRequest{Content: "hello world"}, &sent); status != http.StatusCreated {
+		t.Fatalf("sending got %d, want 201", status)
+	}
+	if sent.Content != "hello" || !sent.Truncated {
+		t.Errorf("the message was saved as %q (truncated %v), want \"hello\" truncated", sent.Content, sent.Truncated)
+	}
+}
+
+// TestSendMessageDuplicateLimit repeats a REST message: a room takes every
+// copy until its creator turns the limit on, after which the sender gets
+// 429 and nothing is saved, while other members can still send it
+func TestSendMessageDuplicateLimit(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	server := newTestServer(t, ts)
+	send := func(userID int64, message string) (int, string) {
+		var failure errorBody
+		status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/messages", userID, SendMessageRequest{Content: message}, &failure)
+		return status, failure.Code
+	}
+
+	for i := 0; i < 4; i++ {
+		if status, code := send(2, "buy now"); status != http.StatusCreated {
+			t.Fatalf("copy %d with the limit off got %d %q, want 201", i+1, status, code)
+		}
+	}
+
+	on := true
+	if status := doJSON(t, http.MethodPatch, server.URL+"/v1/rooms/1", 1, RoomSettings{DuplicateLimit: &on}, nil); status != http.StatusOK {
+		t.Fatalf("turning the limit on got %d, want 200", status)
+	}
+	if status, code := send(2, " Buy Now "); status != http.StatusTooManyRequests || code != "duplicate_message" {
+		t.Errorf("another copy got %d %q, want 429 duplicate_message", status, code)
+	}
+	if status, _ := send(1, "buy now"); status != http.StatusCreated {
+		t.Errorf("another member's copy got %d, want 201", status)
+	}
+	if status, _ := send(2, "something else"); status != http.StatusCreated {
+		t.Errorf("a different message got %d, want 201", status)
+	}
+
+	ts.messages.mu.Lock()
+	saved := len(ts.messages.messages)
+	ts.messages.mu.Unlock()
+	if saved != 6 {
+		t.Errorf("%d messages were saved, want 6", saved)
+	}
+}
+
+// TestSilentMessages posts a silent message over REST: signed in with a JWT
+// grace is refused before anything is saved, and with an API token the
+// message is saved and reaches a connected member marked silent
+func TestSilentMessages(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	server := newTestServer(t, ts)
+	url := server.URL + "/v1/rooms/1/messages"
+	conn := dialRoom(t, server, 1, 1)
+	readFrame(t, conn, "join")
+
+	var failure errorBody
+	body := SendMessageRequest{Content: "hush", Silent: true}
+	if status := doJSON(t, http.MethodPost, url, 2, body, &failure); status != http.StatusForbidden || failure.Code != "silent_not_allowed" {
+		t.Errorf("a silent message without a token got %d %q, want 403 silent_not_allowed", status, failure.Code)
+	}
+	if len(ts.messages.messages) != 0 {
+		t.Errorf("the refused message was saved")
+	}
+
+	var created CreateAPITokenResponse
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/users/me/tokens", 2, CreateAPITokenRequest{Name: "bridge"}, &created); status != http.StatusCreated {
+		t.Fatalf("creating a token got %d, want 201", status)
+	}
+	var sent store.Message
+	if status := doJSONWithHeaders(t, http.MethodPost, url, 0, withToken(created.Token), body, &sent); status != http.StatusCreated {
+		t.Fatalf("a silent message with a token got %d, want 201", status)
+	}
+	if live := readFrame(t, conn, "message"); live.ID != sent.ID || !live.Silent || live.Notify {
+		t.Errorf("the connected member got %+v, want message %d silent and without notify", live.Message, sent.ID)
+	}
+}
+
+// TestMessageOverride relays messages for a bridge: only API token callers
+// may set an override, names of local users and malformed values are
+// refused, and an accepted override reaches the WebSocket frame, history
+// and the fields registry with the bot still the author
+func TestMessageOverride(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	server := newTestServer(t, ts)
+	url := server.URL + "/v1/rooms/1/messages"
+	conn := dialRoom(t, server, 1, 1)
+	readFrame(t, conn, "join")
+
+	relayed := SendMessageRequest{Content: "hi from IRC", OverrideUsername: " alice (IRC) ", OverrideAvatarURL: "https://irc.example.com/alice.png"}
+	var failure errorBody
+	if status := doJSON(t, http.MethodPost, url, 2, relayed, &failure); status != http.StatusForbidden || failure.Code != "override_not_allowed" {
+		t.Errorf("an override without a token got %d %q, want 403 override_not_allowed", status, failure.Code)
+	}
+
+	var created CreateAPITokenResponse
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/users/me/tokens", 2, CreateAPITokenRequest{Name: "bridge"}, &created); status != http.StatusCreated {
+		t.Fatalf("creating a token got %d, want 201", status)
+	}
+	bridge := withToken(created.Token)
+
+	for _, tc := range []struct {
+		name  string
+		req   SendMessageRequest
+		field string
+		code  string
+	}{
+		{"a long name", SendMessageRequest{OverrideUsername: strings.Repeat("é", maxOverrideUsernameLength+1)}, "override_username", "too_long"},
+		{"a control character", SendMessageRequest{OverrideUsername: "alice\nbob"}, "override_username", "invalid_characters"},
+		{"a local user", SendMessageRequest{OverrideUsername: "ada"}, "override_username", "username_taken"},
+		{"the system user", SendMessageRequest{OverrideUsername: "system"}, "override_username", "username_taken"},
+		{"an ftp avatar", SendMessageRequest{OverrideAvatarURL: "ftp://irc.example.com/alice.png"}, "override_avatar_url", "invalid_url"},
+		{"a relative avatar", SendMessageRequest{OverrideAvatarURL: "/alice.png"}, "override_avatar_url", "invalid_url"},
+	} {
+		tc.req.Content = "hello"
+		var invalid struct {
+			Code   string                  `json:"code"`
+			Fields map[string][]fieldError `json:"fields"`
+		}
+		status := doJSONWithHeaders(t, http.MethodPost, url, 0, bridge, tc.req, &invalid)
+		if errs := invalid.Fields[tc.field]; status != http.StatusBadRequest || invalid.Code != "invalid_message_override" || len(errs) != 1 || errs[0].Code != tc.code {
+			t.Errorf("%s got %d %q %+v, want 400 with %s %s", tc.name, status, invalid.Code, invalid.Fields, tc.field, tc.code)
+		}
+	}
+	if len(ts.messages.messages) != 0 {
+		t.Fatalf("%d refused messages were saved", len(ts.messages.messages))
+	}
+
+	var sent store.Message
+	if status := doJSONWithHeaders(t, http.MethodPost, url, 0, bridge, relayed, &sent); status != http.StatusCreated {
+		t.Fatalf("relaying got %d, want 201", status)
+	}
+	if sent.OverrideUsername != "alice (IRC)" || sent.UserID != 2 || sent.Username != "grace" {
+		t.Errorf("saved %q from %d %q, want alice (IRC), trimmed, from grace", sent.OverrideUsername, sent.UserID, sent.Username)
+	}
+	live := readFrame(t, conn, "message")
+	if live.OverrideUsername != "alice (IRC)" || live.OverrideAvatarURL != relayed.OverrideAvatarURL || live.UserID != 2 {
+		t.Errorf("the connected member got %+v, want the override with grace as the author", live.Message)
+	}
+
+	var history []store.Message
+	if status := doJSON(t, http.MethodGet, url, 1, nil, &history); status != http.StatusOK || len(history) != 1 || history[0].OverrideUsername != "alice (IRC)" || history[0].OverrideAvatarURL != relayed.OverrideAvatarURL {
+		t.Errorf("history got %d %+v, want the relayed message with its override", status, history)
+	}
+	var projected []map[string]any
+	if status := doJSON(t, http.MethodGet, url+"?fields=id,override_username,override_avatar_url", 1, nil, &projected); status != http.StatusOK || len(projected) != 1 || projected[0]["override_username"] != "alice (IRC)" || projected[0]["override_avatar_url"] != relayed.OverrideAvatarURL {
+		t.Errorf("history with fields got %d %v", status, projected)
+	}
+}
+
+// TestTokenMessageRateLimit sends messages with one API token under
+// different relayed names: they share the token's limit, the one over it
+// gets a 429 with Retry-After, and messages sent with a JWT aren't counted
+func TestTokenMessageRateLimit(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	server := newTestServer(t, ts, func(app *application) {
+		app.tokenMessageLimiter = newRateLimiter[int64](2, time.Minute)
+	})
+	url := server.URL + "/v1/rooms/1/messages"
+
+	var created CreateAPITokenResponse
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/users/me/tokens", 2, CreateAPITokenRequest{Name: "bridge"}, &created); status != http.StatusCreated {
+		t.Fatalf("creating a token got %d, want 201", status)
+	}
+	for _, name := range []string{"alice (IRC)", "bob (IRC)"} {
+		if status := doJSONWithHeaders(t, http.MethodPost, url, 0, withToken(created.Token), SendMessageRequest{Content: "hi", OverrideUsername: name}, nil); status != http.StatusCreated {
+			t.Fatalf("relaying for %s got %d, want 201", name, status)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(`{"content": "hi", "override_username": "carol (IRC)"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+created.Token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failure errorBody
+	json.NewDecoder(resp.Body).Decode(&failure)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || failure.Code != "message_rate_limited" || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("the third message got %d %q with Retry-After %q, want 429 message_rate_limited", resp.StatusCode, failure.Code, resp.Header.Get("Retry-After"))
+	}
+
+	if status := doJSON(t, http.MethodPost, url, 2, SendMessageRequest{Content: "hi"}, nil); status != http.StatusCreated {
+		t.Errorf("a message sent with a JWT got %d, want 201", status)
+	}
+}
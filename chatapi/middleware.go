@@ -0,0 +1,227 @@
+package chatapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/auth"
+)
+
+// contextKey is a custom type for context keys to avoid collisions
+// Using a custom type prevents conflicts with other packages using context
+type contextKey string
+
+const (
+	userIDKey     contextKey = "userID"
+	apiTokenIDKey contextKey = "apiTokenID" // Set only when a personal access token was used
+	apiKeyIDKey   contextKey = "apiKeyID"   // Set only when a bot API key was used
+	sessionIDKey  contextKey = "sessionID"  // Set only for JWTs bound to a login session
+)
+
+// apiTokenTouchInterval is how often a token's last_used_at is updated
+// A script making many requests a second doesn't need a write for each one
+const apiTokenTouchInterval = time.Minute
+
+// UserResolver authenticates requests for an application embedding the chat
+// (see WithUserResolver), in place of JWTs and API tokens
+type UserResolver interface {
+	// ResolveUser returns the ID of the go-chat user making the request
+	// An error answers the request with 401 Unauthorized
+	ResolveUser(r *http.Request) (int64, error)
+}
+
+// UserResolverFunc adapts a function to a UserResolver
+type UserResolverFunc func(r *http.Request) (int64, error)
+
+// ResolveUser calls f(r)
+func (f UserResolverFunc) ResolveUser(r *http.Request) (int64, error) {
+	return f(r)
+}
+
+// AuthMiddleware validates JWT tokens and adds user ID to request context
+// This middleware protects routes that require authentication
+// It expects the token in the Authorization header: "Bearer <token>"
+// The token is either a JWT from login or a personal access token ("gochat_...")
+// Bots send their API key as "ApiKey <key>" ("gcbot_...") instead
+// With a UserResolver, the resolver decides instead
+func (app *application) AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.resolver != nil {
+			userID, err := app.resolver.ResolveUser(r)
+			if err != nil || userID <= 0 {
+				writeError(w, r, http.StatusUnauthorized, "unauthenticated")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userIDKey, userID)))
+			return
+		}
+
+		// Extract the Authorization header
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			writeError(w, r, http.StatusUnauthorized, "missing_authorization_header")
+			return
+		}
+
+		// Authorization header format: "Bearer <token>" or "ApiKey <token>"
+		// Split to extract the token part
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || (parts[0] != "Bearer" && parts[0] != "ApiKey") {
+			writeError(w, r, http.StatusUnauthorized, "invalid_authorization_header")
+			return
+		}
+
+		token := parts[1]
+
+		// Bot API keys are their own credential; nothing else goes with ApiKey
+		if parts[0] == "ApiKey" {
+			if !auth.IsAPIKey(token) {
+				writeError(w, r, http.StatusUnauthorized, "invalid_token")
+				return
+			}
+			app.authenticateAPIKey(w, r, next, token)
+			return
+		}
+
+		// Personal access tokens are looked up in the database instead of verified
+		if auth.IsAPIToken(token) {
+			app.authenticateAPIToken(w, r, next, token)
+			return
+		}
+
+		// Validate the token and extract its claims
+		claims, err := auth.ParseToken(token, app.config.auth.jwtSecret)
+		if err != nil {
+			if errors.Is(err, auth.ErrExpiredToken) {
+				writeError(w, r, http.StatusUnauthorized, "token_expired")
+				return
+			}
+			writeError(w, r, http.StatusUnauthorized, "invalid_token")
+			return
+		}
+
+		// Add user ID to request context
+		// Context is Go's way of passing request-scoped values through the call chain
+		// The context flows through all handlers and can be accessed anywhere in the request lifecycle
+		ctx := context.WithValue(r.Context(), userIDKey, claims.UserID)
+
+		// Tokens issued before sessions existed carry no session and stay
+		// valid until they expire
+		if claims.SessionID != 0 {
+			if !app.checkSession(w, r, claims.SessionID) {
+				return
+			}
+			ctx = context.WithValue(ctx, sessionIDKey, claims.SessionID)
+		}
+
+		// A session deleted along with the revocation has already answered
+		// session_revoked; this catches the tokens without one
+		if !app.checkTokenVersion(w, r, claims.UserID, claims.TokenVersion) {
+			return
+		}
+
+		// Call the next handler with the updated context
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticateAPIToken finishes AuthMiddleware for a personal access token
+// Revoked tokens are deleted, so they fail the lookup on the very next request
+// Each request made with a token is logged with the token's ID so it can be
+// told apart from a browser session
+func (app *application) authenticateAPIToken(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	apiToken, err := app.store.APITokens.GetByHash(r.Context(), auth.HashAPIToken(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusUnauthorized, "invalid_token")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "api_token_lookup_failed")
+		return
+	}
+
+	now := time.Now()
+	if apiToken.Expired(now) {
+		writeError(w, r, http.StatusUnauthorized, "token_expired")
+		return
+	}
+
+	if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) >= apiTokenTouchInterval {
+		if err := app.store.APITokens.Touch(r.Context(), apiToken.ID); err != nil {
+			// Not worth failing the request over
+			log.Printf("Failed to record use of API token %d: %v", apiToken.ID, err)
+		}
+	}
+
+	log.Printf("API token request: token=%d user=%d %s %s", apiToken.ID, apiToken.UserID, r.Method, r.URL.Path)
+
+	ctx := context.WithValue(r.Context(), userIDKey, apiToken.UserID)
+	ctx = context.WithValue(ctx, apiTokenIDKey, apiToken.ID)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// APITokenIDFromContext returns the ID of the personal access token that
+// authenticated the request, or false for a JWT session
+func APITokenIDFromContext(ctx context.Context) (int64, bool) {
+	tokenID, ok := ctx.Value(apiTokenIDKey).(int64)
+	return tokenID, ok
+}
+
+// authenticateAPIKey finishes AuthMiddleware for a bot API key, as
+// authenticateAPIToken does for personal access tokens
+// Keys don't expire; banned users' keys fail the lookup until the ban is lifted
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	apiKey, err := app.store.APIKeys.GetByHash(r.Context(), auth.HashAPIToken(key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusUnauthorized, "invalid_token")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "api_key_lookup_failed")
+		return
+	}
+
+	if apiKey.LastUsedAt == nil || time.Since(*apiKey.LastUsedAt) >= apiTokenTouchInterval {
+		if err := app.store.APIKeys.Touch(r.Context(), apiKey.ID); err != nil {
+			// Not worth failing the request over
+			log.Printf("Failed to record use of API key %d: %v", apiKey.ID, err)
+		}
+	}
+
+	log.Printf("API key request: key=%d user=%d %s %s", apiKey.ID, apiKey.UserID, r.Method, r.URL.Path)
+
+	ctx := context.WithValue(r.Context(), userIDKey, apiKey.UserID)
+	ctx = context.WithValue(ctx, apiKeyIDKey, apiKey.ID)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// APIKeyIDFromContext returns the ID of the bot API key that authenticated
+// the request, or false for anything else
+func APIKeyIDFromContext(ctx context.Context) (int64, bool) {
+	keyID, ok := ctx.Value(apiKeyIDKey).(int64)
+	return keyID, ok
+}
+
+// viaLoginSession reports whether the request was authenticated by a login
+// (a JWT) rather than a personal access token or an API key
+func viaLoginSession(ctx context.Context) bool {
+	_, viaToken := APITokenIDFromContext(ctx)
+	_, viaKey := APIKeyIDFromContext(ctx)
+	return !viaToken && !viaKey
+}
+
+// GetUserIDFromContext extracts the user ID from the request context
+// This is used in handlers to get the authenticated user's ID
+// Returns an error if the user ID is not found in context (should never happen if middleware is used)
+func GetUserIDFromContext(ctx context.Context) (int64, error) {
+	userID, ok := ctx.Value(userIDKey).(int64)
+	if !ok {
+		return 0, errors.New("user ID not found in context")
+	}
+	return userID, nil
+}
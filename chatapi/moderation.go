@@ -0,0 +1,38 @@
+package chatapi
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/drazan344/go-chat/internal/content"
+)
+
+// newContentFilter builds the content filter from the configured wordlist path
+// No path means no filtering; a path that can't be loaded is a startup error,
+// since silently running without moderation would be worse
+func newContentFilter(path string) (content.Filter, error) {
+	if path == "" {
+		return content.NoopFilter{}, nil
+	}
+
+	filter, err := content.NewWordlistFilter(path)
+	if err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// reloadWordlist re-reads the content filter wordlist, if one is configured,
+// so moderators can update it without a restart
+// A wordlist that can't be loaded keeps the previous list and is returned
+func (app *application) reloadWordlist() error {
+	if app.wordlist == nil {
+		return nil
+	}
+	path := app.config.moderation.wordlistPath
+	if err := app.wordlist.Reload(); err != nil {
+		return fmt.Errorf("failed to reload content filter wordlist %s (keeping previous list): %w", path, err)
+	}
+	log.Printf("Content filter wordlist %s reloaded", path)
+	return nil
+}
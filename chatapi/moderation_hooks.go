@@ -0,0 +1,259 @@
+package chatapi
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	ws "github.com/drazan344/go-chat/internal/websocket"
+)
+
+const (
+	// Bounds of a moderation hook's timeout; every message of the room waits this long at worst
+	defaultModerationHookTimeout = 300 * time.Millisecond
+	minModerationHookTimeout     = 50 * time.Millisecond
+	maxModerationHookTimeout     = 2 * time.Second
+
+	// minModerationHookSecret is the shortest secret a hook can be saved with
+	minModerationHookSecret = 16
+)
+
+// ModerationHookRequest configures a room's moderation bot
+// TimeoutMs defaults to 300, FailOpen and Enabled to true
+type ModerationHookRequest struct {
+	URL       string `json:"url"`
+	Secret    string `json:"secret"`
+	TimeoutMs *int   `json:"timeout_ms"`
+	FailOpen  *bool  `json:"fail_open"`
+	Enabled   *bool  `json:"enabled"`
+}
+
+// ModerationHookResponse is a room's moderation hook without its secret
+type ModerationHookResponse struct {
+	*store.ModerationHook
+	TimeoutMs int64 `json:"timeout_ms"`
+}
+
+func newModerationHookResponse(hook *store.ModerationHook) ModerationHookResponse {
+	return ModerationHookResponse{ModerationHook: hook, TimeoutMs: hook.Timeout.Milliseconds()}
+}
+
+// requireModerationHookOwner checks that moderation hooks are on and the user owns the room
+// Hooks make the server POST every message to a URL of the owner's choosing,
+// so they're for the room's owner only, not a capability admins can be given
+// It writes the error response itself and returns false if the check fails
+func (app *application) requireModerationHookOwner(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	if len(app.config.moderation.hookHosts) == 0 {
+		writeError(w, r, http.StatusNotFound, "moderation_hooks_disabled")
+		return 0, false
+	}
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return 0, false
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return 0, false
+	}
+
+	access, ok := app.authorizeRoom(w, r, roomID, userID, "")
+	if !ok {
+		return 0, false
+	}
+	if access.Role != store.RoomRoleOwner {
+		writeError(w, r, http.StatusForbidden, "moderation_hook_owner_only")
+		return 0, false
+	}
+	return roomID, true
+}
+
+// parseHostList splits a comma-separated host allowlist (MODERATION_HOOK_HOSTS,
+// OUTGOING_WEBHOOK_HOSTS)
+func parseHostList(list string) []string {
+	var hosts []string
+	for _, host := range strings.Split(list, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// moderationHookHostAllowed reports whether a hook URL's host is in MODERATION_HOOK_HOSTS
+func (app *application) moderationHookHostAllowed(u *url.URL) bool {
+	return hostAllowed(app.config.moderation.hookHosts, u)
+}
+
+// hostAllowed reports whether a URL's host is in an allowlist
+// Entries match the host with or without its port; "*" allows any host
+func hostAllowed(hosts []string, u *url.URL) bool {
+	for _, allowed := range hosts {
+		if allowed == "*" || strings.EqualFold(allowed, u.Host) || strings.EqualFold(allowed, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// getModerationHookHandler returns a room's moderation hook, secret left out
+// GET /v1/rooms/{roomID}/moderation-hook
+// Requires authentication; the room's owner only
+// Response: {"room_id": 1, "url": "https://bot.example.com/moderate", "timeout_ms": 300,
+// "fail_open": true, "enabled": false, "disabled_reason": "turned off after 5 failures in a row; ...", ...}
+func (app *application) getModerationHookHandler(w http.ResponseWriter, r *http.Request) {
+	roomID, ok := app.requireModerationHookOwner(w, r)
+	if !ok {
+		return
+	}
+
+	hook, err := app.store.ModerationHooks.Get(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "moderation_hook_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "moderation_hook_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newModerationHookResponse(hook))
+}
+
+// putModerationHookHandler sets up or replaces a room's moderation bot
+// Every chat message of the room is then POSTed to the URL before anyone sees
+// it, signed with X-GoChat-Signature: sha256=<HMAC-SHA256(secret, body)>, and
+// the bot answers {"action": "allow"}, {"action": "reject", "reason": "..."} or
+// {"action": "rewrite", "content": "..."}. A bot that doesn't answer within
+// timeout_ms lets the message through with fail_open, and rejects it otherwise;
+// after 5 failures in a row the hook is turned off and the owner is told
+// Saving again turns a hook that was turned off back on
+// PUT /v1/rooms/{roomID}/moderation-hook
+// Requires authentication; the room's owner only. The URL's host must be
+// listed in MODERATION_HOOK_HOSTS
+// Request body: {"url": "https://bot.example.com/moderate", "secret": "...", "timeout_ms": 300, "fail_open": false}
+// Response: the hook as for GET
+func (app *application) putModerationHookHandler(w http.ResponseWriter, r *http.Request) {
+	roomID, ok := app.requireModerationHookOwner(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	var req ModerationHookRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	hook := &store.ModerationHook{
+		RoomID:    roomID,
+		URL:       strings.TrimSpace(req.URL),
+		Secret:    req.Secret,
+		Timeout:   defaultModerationHookTimeout,
+		FailOpen:  true,
+		Enabled:   true,
+		CreatedBy: &userID,
+	}
+	if req.TimeoutMs != nil {
+		hook.Timeout = time.Duration(*req.TimeoutMs) * time.Millisecond
+	}
+	if req.FailOpen != nil {
+		hook.FailOpen = *req.FailOpen
+	}
+	if req.Enabled != nil {
+		hook.Enabled = *req.Enabled
+	}
+
+	fields := make(map[string][]fieldError)
+	u, err := url.Parse(hook.URL)
+	switch {
+	case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+		fields["url"] = append(fields["url"], fieldError{Error: "must be an http or https URL", Code: "invalid_url"})
+	case !app.moderationHookHostAllowed(u):
+		fields["url"] = append(fields["url"], fieldError{Error: "host is not in MODERATION_HOOK_HOSTS", Code: "host_not_allowed"})
+	}
+	if len(hook.Secret) < minModerationHookSecret {
+		fields["secret"] = append(fields["secret"], fieldError{Error: "must be at least 16 characters", Code: "too_short"})
+	}
+	if hook.Timeout < minModerationHookTimeout || hook.Timeout > maxModerationHookTimeout {
+		fields["timeout_ms"] = append(fields["timeout_ms"], fieldError{Error: "must be between 50 and 2000", Code: "out_of_range"})
+	}
+	if len(fields) > 0 {
+		writeFieldErrors(w, r, http.StatusBadRequest, "invalid_moderation_hook", fields)
+		return
+	}
+
+	if err := app.store.ModerationHooks.Save(r.Context(), hook); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "moderation_hook_failed")
+		return
+	}
+	app.hub.InvalidateModerationHook(roomID)
+
+	writeJSON(w, http.StatusOK, newModerationHookResponse(hook))
+}
+
+// deleteModerationHookHandler removes a room's moderation bot
+// Messages already waiting for it are let through
+// DELETE /v1/rooms/{roomID}/moderation-hook
+// Requires authentication; the room's owner only
+// Response: 204 No Content
+func (app *application) deleteModerationHookHandler(w http.ResponseWriter, r *http.Request) {
+	roomID, ok := app.requireModerationHookOwner(w, r)
+	if !ok {
+		return
+	}
+
+	if err := app.store.ModerationHooks.Delete(r.Context(), roomID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "moderation_hook_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "moderation_hook_failed")
+		return
+	}
+	app.hub.InvalidateModerationHook(roomID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyModerationHook runs a message sent over REST past its room's moderation bot
+// A rewrite replaces the content in place. It writes the error response itself
+// and returns false if the message must not be sent: 422 when the bot rejects
+// it, 503 when the bot is unavailable and the room fails closed, or its queue is full
+func (app *application) applyModerationHook(w http.ResponseWriter, r *http.Request, message *store.Message) bool {
+	outcome, err := app.hub.ModerateWithHook(r.Context(), message)
+	if err != nil {
+		if errors.Is(err, ws.ErrModerationBusy) {
+			writeError(w, r, http.StatusServiceUnavailable, "moderation_busy")
+			return false
+		}
+		writeError(w, r, http.StatusServiceUnavailable, "moderation_unavailable")
+		return false
+	}
+
+	switch outcome.Action {
+	case ws.ModerationReject:
+		if outcome.Failed {
+			writeError(w, r, http.StatusServiceUnavailable, "moderation_unavailable")
+			return false
+		}
+		if outcome.Reason != "" {
+			writeError(w, r, http.StatusUnprocessableEntity, "moderation_rejected_reason", outcome.Reason)
+			return false
+		}
+		writeError(w, r, http.StatusUnprocessableEntity, "moderation_rejected")
+		return false
+	case ws.ModerationRewrite:
+		message.Content = outcome.Content
+		message.Moderated = true
+	}
+	return true
+}
@@ -0,0 +1,152 @@
+package chatapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+	ws "github.com/drazan344/go-chat/internal/websocket"
+)
+
+// newModerationHookStore has ada owning room 1, with grace as an admin
+func newModerationHookStore(t *testing.T) *testStore {
+	t.Helper()
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 2, store.RoomRoleAdmin)
+	return ts
+}
+
+// TestModerationHookHandlers sets up, reads and removes room 1's hook: only
+// the owner may, only when MODERATION_HOOK_HOSTS allows some host, and the
+// secret is never sent back
+func TestModerationHookHandlers(t *testing.T) {
+	ts := newModerationHookStore(t)
+	off := newTestServer(t, ts)
+	server := newTestServer(t, ts, func(app *application) {
+		app.config.moderation.hookHosts = []string{"bot.example.com"}
+	})
+	hookURL := server.URL + "/v1/rooms/1/moderation-hook"
+
+	var failure errorBody
+	if status := doJSON(t, http.MethodGet, off.URL+"/v1/rooms/1/moderation-hook", 1, nil, &failure); status != http.StatusNotFound || failure.Code != "moderation_hooks_disabled" {
+		t.Errorf("without allowed hosts got %d %q, want 404 moderation_hooks_disabled", status, failure.Code)
+	}
+	valid := ModerationHookRequest{URL: "https://bot.example.com/moderate", Secret: "sixteen-or-more-chars"}
+	if status := doJSON(t, http.MethodPut, hookURL, 2, valid, &failure); status != http.StatusForbidden || failure.Code != "moderation_hook_owner_only" {
+		t.Errorf("an admin saving got %d %q, want 403 moderation_hook_owner_only", status, failure.Code)
+	}
+
+	tooFast := 10
+	for _, tc := range []struct {
+		body ModerationHookRequest
+		want map[string]string
+	}{
+		{ModerationHookRequest{URL: "ftp://bot.example.com", Secret: "short", TimeoutMs: &tooFast},
+			map[string]string{"url": "invalid_url", "secret": "too_short", "timeout_ms": "out_of_range"}},
+		{ModerationHookRequest{URL: "https://evil.example.com/moderate", Secret: valid.Secret},
+			map[string]string{"url": "host_not_allowed"}},
+	} {
+		var invalid struct {
+			Code   string                  `json:"code"`
+			Fields map[string][]fieldError `json:"fields"`
+		}
+		if status := doJSON(t, http.MethodPut, hookURL, 1, tc.body, &invalid); status != http.StatusBadRequest || invalid.Code != "invalid_moderation_hook" {
+			t.Errorf("saving %+v got %d %q, want 400 invalid_moderation_hook", tc.body, status, invalid.Code)
+		}
+		for field, code := range tc.want {
+			if errs := invalid.Fields[field]; len(errs) != 1 || errs[0].Code != code {
+				t.Errorf("saving %+v: %s has %+v, want %s", tc.body, field, errs, code)
+			}
+		}
+		if len(invalid.Fields) != len(tc.want) {
+			t.Errorf("saving %+v: got problems with %d fields, want %d", tc.body, len(invalid.Fields), len(tc.want))
+		}
+	}
+
+	var saved map[string]any
+	if status := doJSON(t, http.MethodPut, hookURL, 1, valid, &saved); status != http.StatusOK {
+		t.Fatalf("saving got %d, want 200", status)
+	}
+	if saved["timeout_ms"] != float64(300) || saved["fail_open"] != true || saved["enabled"] != true {
+		t.Errorf("saved %v, want the defaults", saved)
+	}
+	var got map[string]any
+	if status := doJSON(t, http.MethodGet, hookURL, 1, nil, &got); status != http.StatusOK || got["url"] != valid.URL {
+		t.Errorf("reading got %d %v", status, got)
+	}
+	for _, body := range []map[string]any{saved, got} {
+		if _, ok := body["secret"]; ok {
+			t.Errorf("the secret was sent back: %v", body)
+		}
+	}
+
+	if status := doJSON(t, http.MethodDelete, hookURL, 1, nil, nil); status != http.StatusNoContent {
+		t.Errorf("deleting got %d, want 204", status)
+	}
+	if status := doJSON(t, http.MethodGet, hookURL, 1, nil, &failure); status != http.StatusNotFound || failure.Code != "moderation_hook_not_found" {
+		t.Errorf("reading after deleting got %d %q, want 404 moderation_hook_not_found", status, failure.Code)
+	}
+}
+
+// moderationBot answers moderation requests by content
+type moderationBot map[string]*ws.ModerationDecision
+
+func (bot moderationBot) Moderate(_ context.Context, _ *store.ModerationHook, req *ws.ModerationRequest) (*ws.ModerationDecision, error) {
+	if decision, ok := bot[req.Content]; ok {
+		return decision, nil
+	}
+	return nil, errors.New("the bot is confused")
+}
+
+// TestSendMessageModerationHook sends REST messages to a room whose bot
+// fails closed: a rejection is a 422 with the bot's reason, a failure a 503,
+// and only the allowed message and the rewrite are saved, the rewrite
+// flagged as moderated
+func TestSendMessageModerationHook(t *testing.T) {
+	ts := newModerationHookStore(t)
+	ts.modHooks.hooks[1] = &store.ModerationHook{RoomID: 1, URL: "https://bot.example.com/", Timeout: defaultModerationHookTimeout, Enabled: true}
+	server := newTestServer(t, ts, func(app *application) {
+		app.hub = ws.NewHub(ts.Storage, 1)
+		app.hub.SetModerationCaller(moderationBot{
+			"hello":   {Action: ws.ModerationAllow},
+			"buy now": {Action: ws.ModerationReject, Reason: "no ads"},
+			"darn":    {Action: ws.ModerationRewrite, Content: "d**n"},
+		})
+		go app.hub.Run()
+	})
+
+	for _, tc := range []struct {
+		content string
+		status  int
+		code    string
+	}{
+		{"hello", http.StatusCreated, ""},
+		{"buy now", http.StatusUnprocessableEntity, "moderation_rejected_reason"},
+		{"oops", http.StatusServiceUnavailable, "moderation_unavailable"},
+		{"darn", http.StatusCreated, ""},
+	} {
+		var body json.RawMessage
+		status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/messages", 2, SendMessageRequest{Content: tc.content}, &body)
+		var failure errorBody
+		json.Unmarshal(body, &failure)
+		if status != tc.status || failure.Code != tc.code {
+			t.Errorf("sending %q got %d %q, want %d %q", tc.content, status, failure.Code, tc.status, tc.code)
+		}
+	}
+
+	ts.messages.mu.Lock()
+	defer ts.messages.mu.Unlock()
+	if len(ts.messages.messages) != 2 {
+		t.Fatalf("saved %d messages, want 2", len(ts.messages.messages))
+	}
+	if first, rewrite := ts.messages.messages[0], ts.messages.messages[1]; first.Moderated || rewrite.Content != "d**n" || !rewrite.Moderated {
+		t.Errorf("saved %q and %q (moderated %v), want hello and the moderated rewrite", first.Content, rewrite.Content, rewrite.Moderated)
+	}
+}
@@ -0,0 +1,53 @@
+package chatapi
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestNewContentFilter builds the filter from the configuration: no path
+// filters nothing, and a wordlist that can't be loaded stops startup
+func TestNewContentFilter(t *testing.T) {
+	if filter, err := newContentFilter(""); err != nil || filter != (content.NoopFilter{}) {
+		t.Errorf("no wordlist gave %v, %v; want the no-op filter", filter, err)
+	}
+	if _, err := newContentFilter(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("a missing wordlist was accepted")
+	}
+
+	path := filepath.Join(t.TempDir(), "wordlist.txt")
+	if err := os.WriteFile(path, []byte("darn\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	filter, err := newContentFilter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verdict, _, _ := filter.Check(context.Background(), "darn"); verdict != content.VerdictMask {
+		t.Errorf("the wordlist filter gave %d for a listed word, want a mask", verdict)
+	}
+}
+
+// TestToggleContentFilter has the room's creator turn filtering off and on
+func TestToggleContentFilter(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1, ContentFilterEnabled: true})
+	server := newTestServer(t, ts)
+
+	for _, enabled := range []bool{false, true} {
+		var room store.Room
+		body := RoomSettings{ContentFilter: &enabled}
+		if status := doJSON(t, http.MethodPatch, server.URL+"/v1/rooms/1", 1, body, &room); status != http.StatusOK {
+			t.Fatalf("setting it to %t got %d, want 200", enabled, status)
+		}
+		if room.ContentFilterEnabled != enabled {
+			t.Errorf("setting it to %t returned %t", enabled, room.ContentFilterEnabled)
+		}
+	}
+}
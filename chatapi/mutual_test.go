@@ -0,0 +1,52 @@
+package chatapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestMutualRooms has ada and grace share two rooms, one of them deleted,
+// and a third only ada is in: only the live shared room is listed
+func TestMutualRooms(t *testing.T) {
+	ts := newDirectoryStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "lobby", CreatedBy: 1})
+	ts.rooms.add(&store.Room{ID: 2, Name: "archive", CreatedBy: 1})
+	ts.rooms.add(&store.Room{ID: 3, Name: "private", CreatedBy: 1})
+	for _, roomID := range []int64{1, 2, 3} {
+		ts.roomMembers.add(roomID, 1, store.RoomRoleAdmin)
+	}
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.roomMembers.add(1, 3, store.RoomRoleMember)
+	ts.roomMembers.add(2, 2, store.RoomRoleMember)
+	if err := ts.rooms.SoftDelete(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(t, ts)
+
+	var mutual store.MutualContext
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/users/2/mutual", 1, nil, &mutual); status != http.StatusOK {
+		t.Fatalf("asking about grace got %d, want 200", status)
+	}
+	if mutual.UserID != 2 || len(mutual.Rooms) != 1 || mutual.Rooms[0].ID != 1 || mutual.Rooms[0].MemberCount != 3 {
+		t.Errorf("got user %d with rooms %+v, want lobby only with 3 members", mutual.UserID, mutual.Rooms)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		path   string
+		status int
+		code   string
+	}{
+		{"yourself", "/v1/users/1/mutual", http.StatusBadRequest, "mutual_self"},
+		{"unknown user", "/v1/users/99/mutual", http.StatusNotFound, "user_not_found"},
+		{"bad ID", "/v1/users/grace/mutual", http.StatusBadRequest, "invalid_id_parameter"},
+	} {
+		var failure errorBody
+		if status := doJSON(t, http.MethodGet, server.URL+tc.path, 1, nil, &failure); status != tc.status || failure.Code != tc.code {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, status, failure.Code, tc.status, tc.code)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package chatapi
+
+import (
+	"net/http"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// NotificationPreferencesRequest and NotificationPreferencesResponse carry the
+// preference matrix: channel -> event type -> enabled
+// Channels: websocket_flag, email, push
+// Event types: mention, dm, room_invite, announcement, digest
+type NotificationPreferencesRequest struct {
+	Preferences store.NotificationPreferences `json:"preferences"`
+}
+
+type NotificationPreferencesResponse struct {
+	Preferences store.NotificationPreferences `json:"preferences"`
+}
+
+// notificationPreferencesHandler returns the caller's full notification matrix
+// Cells the user never changed show their defaults
+// GET /v1/users/me/notification-preferences
+// Requires authentication
+// Response: {"preferences": {"push": {"mention": true, "dm": true, ...}, "email": {...}, "websocket_flag": {...}}}
+func (app *application) notificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	prefs, err := app.store.NotificationPreferences.Get(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "notification_preferences_lookup_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, NotificationPreferencesResponse{Preferences: prefs})
+}
+
+// updateNotificationPreferencesHandler changes some cells of the caller's notification matrix
+// Cells left out of the request keep their current value
+// The change applies to every notification path on this instance at once
+// (other instances within a minute, see notify.DefaultCacheTTL)
+// PUT /v1/users/me/notification-preferences
+// Requires authentication
+// Request body: {"preferences": {"push": {"mention": false}, "email": {"digest": false}}}
+// Response: the full matrix, as for GET
+func (app *application) updateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	var req NotificationPreferencesRequest
+	if err := readJSON(r, &req); err != nil || len(req.Preferences) == 0 {
+		writeBodyError(w, r, err)
+		return
+	}
+	for channel, cells := range req.Preferences {
+		for event := range cells {
+			if !store.ValidNotificationCell(channel, event) {
+				writeError(w, r, http.StatusBadRequest, "invalid_notification_preference", channel+"."+event)
+				return
+			}
+		}
+	}
+
+	prefs, err := app.store.NotificationPreferences.Update(r.Context(), userID, req.Preferences)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "notification_preferences_update_failed")
+		return
+	}
+	app.notifications.Invalidate(userID)
+
+	writeJSON(w, http.StatusOK, NotificationPreferencesResponse{Preferences: prefs})
+}
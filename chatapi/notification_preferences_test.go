@@ -0,0 +1,125 @@
+package chatapi
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/gorilla/websocket"
+)
+
+// TestNotificationPreferences reads the full matrix of defaults, changes one
+// cell and finds only that cell changed
+func TestNotificationPreferences(t *testing.T) {
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	server := newTestServer(t, ts)
+	url := server.URL + "/v1/users/me/notification-preferences"
+
+	var defaults NotificationPreferencesResponse
+	if status := doJSON(t, http.MethodGet, url, 1, nil, &defaults); status != http.StatusOK {
+		t.Fatalf("reading got %d, want 200", status)
+	}
+	for _, channel := range store.NotificationChannels {
+		for _, event := range store.NotificationEvents {
+			if _, ok := defaults.Preferences[channel][event]; !ok {
+				t.Errorf("the matrix has no %s.%s cell", channel, event)
+			}
+		}
+	}
+
+	change := NotificationPreferencesRequest{Preferences: store.NotificationPreferences{
+		store.NotifyChannelPush: {store.NotifyEventMention: false},
+	}}
+	var updated, loaded NotificationPreferencesResponse
+	if status := doJSON(t, http.MethodPut, url, 1, change, &updated); status != http.StatusOK {
+		t.Fatalf("updating got %d, want 200", status)
+	}
+	doJSON(t, http.MethodGet, url, 1, nil, &loaded)
+	for _, channel := range store.NotificationChannels {
+		for _, event := range store.NotificationEvents {
+			want := defaults.Preferences[channel][event]
+			if channel == store.NotifyChannelPush && event == store.NotifyEventMention {
+				want = false
+			}
+			if updated.Preferences[channel][event] != want || loaded.Preferences[channel][event] != want {
+				t.Errorf("%s.%s is %v after updating and %v after reading, want %v", channel, event,
+					updated.Preferences[channel][event], loaded.Preferences[channel][event], want)
+			}
+		}
+	}
+}
+
+// TestNotificationPreferencesValidation refuses unknown channels and event
+// types, and requests that change nothing
+func TestNotificationPreferencesValidation(t *testing.T) {
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	server := newTestServer(t, ts)
+
+	for _, tc := range []struct {
+		body any
+		code string
+	}{
+		{map[string]any{"preferences": map[string]any{"sms": map[string]bool{"mention": true}}}, "invalid_notification_preference"},
+		{map[string]any{"preferences": map[string]any{"push": map[string]bool{"birthday": true}}}, "invalid_notification_preference"},
+		{map[string]any{"preferences": map[string]any{}}, "invalid_request_body"},
+		{map[string]any{"preferences": map[string]any{"push": map[string]string{"mention": "off"}}}, "invalid_request_body"},
+	} {
+		var failure errorBody
+		if status := doJSON(t, http.MethodPut, server.URL+"/v1/users/me/notification-preferences", 1, tc.body, &failure); status != http.StatusBadRequest || failure.Code != tc.code {
+			t.Errorf("%v: got %d %q, want 400 %q", tc.body, status, failure.Code, tc.code)
+		}
+	}
+}
+
+// TestMentionAlerts has ada mention grace and linus after linus turned off
+// the WebSocket flag for mentions: both get the message, but only grace's
+// copy asks for an alert, and ken, who wasn't mentioned, gets no alert
+// Turning the cell back on takes effect at once
+func TestMentionAlerts(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	for id, name := range map[int64]string{1: "ada", 2: "grace", 3: "linus", 4: "ken"} {
+		ts.users.add(&store.User{ID: id, Username: name})
+		ts.roomMembers.add(1, id, store.RoomRoleMember)
+	}
+	server := newTestServer(t, ts)
+	preferences := server.URL + "/v1/users/me/notification-preferences"
+	set := func(on bool) {
+		t.Helper()
+		change := NotificationPreferencesRequest{Preferences: store.NotificationPreferences{
+			store.NotifyChannelWebSocket: {store.NotifyEventMention: on},
+		}}
+		if status := doJSON(t, http.MethodPut, preferences, 3, change, nil); status != http.StatusOK {
+			t.Fatalf("linus updating got %d, want 200", status)
+		}
+	}
+	set(false)
+
+	conns := make(map[string]*websocket.Conn)
+	for id, name := range map[int64]string{2: "grace", 3: "linus", 4: "ken"} {
+		conns[name] = dialRoom(t, server, 1, id)
+		readFrame(t, conns[name], "join")
+	}
+	alerts := func() map[string]bool {
+		t.Helper()
+		body := SendMessageRequest{Content: "@grace @linus standup?"}
+		if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/messages", 1, body, nil); status != http.StatusCreated {
+			t.Fatalf("sending got %d, want 201", status)
+		}
+		received := make(map[string]bool)
+		for name, conn := range conns {
+			received[name] = readFrame(t, conn, "message").Notify
+		}
+		return received
+	}
+
+	if got := alerts(); !got["grace"] || got["linus"] || got["ken"] {
+		t.Errorf("alerts went out as %v, want grace only", got)
+	}
+	set(true)
+	if got := alerts(); !got["grace"] || !got["linus"] || got["ken"] {
+		t.Errorf("after linus turned alerts back on they went out as %v, want grace and linus", got)
+	}
+}
@@ -0,0 +1,271 @@
+package chatapi
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/auth"
+	"github.com/drazan344/go-chat/internal/oauth"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// oauthTimeout bounds the callback and each request to a provider
+	// A callback makes up to three round trips to the provider
+	oauthTimeout = 15 * time.Second
+
+	// oauthStateCookie holds the state of a login in progress, so the
+	// callback only completes a login this browser started
+	oauthStateCookie = "oauth_state"
+
+	// maxOAuthUsernameLength caps the username made for a new account
+	maxOAuthUsernameLength = 32
+)
+
+// oauthProviders lists the providers users can sign in with, in the order
+// they're configured (OAUTH_<NAME>_CLIENT_ID and _SECRET)
+var oauthProviders = []string{oauth.Google, oauth.GitHub}
+
+// oauthClient is an app registered with a provider
+type oauthClient struct {
+	id     string
+	secret string
+}
+
+// newOAuthProviders creates the configured providers, by name
+// The provider sends the browser back to PUBLIC_URL, under the base path
+func newOAuthProviders(cfg config, basePath string) (map[string]*oauth.Provider, error) {
+	providers := make(map[string]*oauth.Provider, len(cfg.oauth.clients))
+	for name, client := range cfg.oauth.clients {
+		provider, err := oauth.New(name, oauth.Config{
+			ClientID:     client.id,
+			ClientSecret: client.secret,
+			RedirectURL:  strings.TrimRight(cfg.mail.publicURL, "/") + basePath + "/v1/auth/oauth/" + name + "/callback",
+		}, oauthTimeout)
+		if err != nil {
+			return nil, err
+		}
+		providers[name] = provider
+	}
+	return providers, nil
+}
+
+// oauthProvider returns the provider named in the URL
+// It writes a 404 and returns nil if it isn't configured
+func (app *application) oauthProvider(w http.ResponseWriter, r *http.Request) *oauth.Provider {
+	provider := app.oauth[chi.URLParam(r, "provider")]
+	if provider == nil {
+		writeError(w, r, http.StatusNotFound, "oauth_provider_not_found")
+	}
+	return provider
+}
+
+// oauthLoginHandler starts signing in with a provider
+// GET /v1/auth/oauth/{provider}/login (provider: google or github)
+// Redirects the browser to the provider, which sends it back to the callback
+func (app *application) oauthLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider := app.oauthProvider(w, r)
+	if provider == nil {
+		return
+	}
+
+	state, err := auth.GenerateOAuthState(provider.Name(), app.config.auth.jwtSecret)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "token_generation_failed")
+		return
+	}
+
+	// Lax, since the provider sends the browser back with a top-level GET
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     app.basePath + "/v1/auth/oauth/" + provider.Name(),
+		MaxAge:   int(auth.OAuthStateLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(app.config.mail.publicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, provider.AuthCodeURL(state), http.StatusFound)
+}
+
+// oauthCallbackHandler finishes signing in with a provider
+// GET /v1/auth/oauth/{provider}/callback?code=...&state=...
+// Response: {"token": "jwt...", "user": {...}} as login returns it (200), or
+// as registration does, with the default rooms joined (201), for a new account
+// With 2FA on, the two-step login challenge instead, as for a password login
+// The provider account signs in the user it's linked to. An account not linked
+// yet is linked to the user with its verified email, or else gets a new user
+// named after it. New users have no password; they can set one with
+// POST /v1/auth/forgot-password
+func (app *application) oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider := app.oauthProvider(w, r)
+	if provider == nil {
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		writeError(w, r, http.StatusBadRequest, "oauth_denied")
+		return
+	}
+
+	// The state must be the one this browser was given, and still valid
+	state := query.Get("state")
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil || state == "" || cookie.Value != state || auth.ParseOAuthState(state, provider.Name(), app.config.auth.jwtSecret) != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_oauth_state")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: cookie.Path, MaxAge: -1})
+
+	identity, err := provider.Exchange(r.Context(), query.Get("code"))
+	if err != nil {
+		if errors.Is(err, oauth.ErrInvalidCode) {
+			writeError(w, r, http.StatusBadRequest, "invalid_oauth_code")
+			return
+		}
+		log.Printf("OAuth login with %s failed: %v", provider.Name(), err)
+		writeError(w, r, http.StatusBadGateway, "oauth_provider_unavailable")
+		return
+	}
+
+	user, rooms, ok := app.oauthUser(w, r, identity)
+	if !ok {
+		return
+	}
+
+	// Signing in with a provider skips the password, not the second factor
+	twoFactor, err := app.store.TwoFactor.Get(r.Context(), user.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "two_factor_failed")
+		return
+	}
+	if twoFactor.Enabled {
+		app.startTwoFactorLogin(w, r, user.ID)
+		return
+	}
+
+	token, ok := app.startSession(w, r, user.ID)
+	if !ok {
+		return
+	}
+	user.Password = ""
+
+	status := http.StatusOK
+	if rooms != nil {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, AuthResponse{Token: token, User: user, Rooms: rooms})
+}
+
+// oauthUser finds or creates the user a provider account signs in
+// rooms is non-nil only for a new user: the default rooms it joined
+// It writes the error response itself and returns false if there's no user
+func (app *application) oauthUser(w http.ResponseWriter, r *http.Request, identity *oauth.Identity) (*store.User, []*store.Room, bool) {
+	userID, err := app.store.OAuthIdentities.GetUserID(r.Context(), identity.Provider, identity.Subject)
+	if err == nil {
+		user, err := app.store.Users.GetByID(r.Context(), userID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+			return nil, nil, false
+		}
+		return user, nil, true
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusInternalServerError, "oauth_login_failed")
+		return nil, nil, false
+	}
+
+	// Linking by address is only safe if the provider checked it
+	if identity.Email == "" || !identity.EmailVerified {
+		writeError(w, r, http.StatusForbidden, "oauth_email_unverified")
+		return nil, nil, false
+	}
+
+	var rooms []*store.Room
+	users, err := app.store.Users.GetByEmails(r.Context(), []string{store.NormalizeEmail(identity.Email)})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return nil, nil, false
+	}
+	var user *store.User
+	if len(users) > 0 {
+		user = users[0]
+	} else {
+		user, rooms, err = app.createOAuthUser(r.Context(), identity)
+		if err != nil {
+			if store.IsUniqueViolation(err) {
+				writeError(w, r, http.StatusConflict, "email_or_username_taken")
+				return nil, nil, false
+			}
+			writeError(w, r, http.StatusInternalServerError, "user_create_failed")
+			return nil, nil, false
+		}
+		for _, room := range rooms {
+			app.roomMembersChanged(room.ID)
+		}
+	}
+
+	// Should this fail after creating the user, the next attempt links by address
+	link := &store.OAuthIdentity{Provider: identity.Provider, Subject: identity.Subject, UserID: user.ID, Email: identity.Email}
+	if err := app.store.OAuthIdentities.Link(r.Context(), link); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "oauth_login_failed")
+		return nil, nil, false
+	}
+	log.Printf("Linked %s account %s to user %d", identity.Provider, identity.Subject, user.ID)
+	return user, rooms, true
+}
+
+// createOAuthUser creates a user for a provider account, joined to the
+// default rooms as at registration, and returns the rooms it joined
+// It's named after the account; if the name is taken a few numbered
+// variants are tried
+func (app *application) createOAuthUser(ctx context.Context, identity *oauth.Identity) (*store.User, []*store.Room, error) {
+	base := oauthUsername(identity)
+	var err error
+	for attempt := range 5 {
+		user := &store.User{Username: base, Email: identity.Email}
+		if attempt > 0 {
+			n, _ := rand.Int(rand.Reader, big.NewInt(10000))
+			user.Username = fmt.Sprintf("%s_%04d", base, n)
+		}
+		var rooms []*store.Room
+		rooms, _, err = app.store.Users.CreateWithDefaultRooms(ctx, user, "")
+		if err == nil {
+			return user, rooms, nil
+		}
+		if !store.IsUniqueViolation(err) {
+			return nil, nil, err
+		}
+	}
+	return nil, nil, err
+}
+
+// oauthUsername makes a username from the one a provider suggests: letters,
+// digits, '.', '_' and '-' only, at most maxOAuthUsernameLength characters
+// Falls back to the provider's name
+func oauthUsername(identity *oauth.Identity) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		}
+		return -1
+	}, identity.Username)
+	if len(name) > maxOAuthUsernameLength {
+		name = name[:maxOAuthUsernameLength]
+	}
+	if name == "" {
+		name = identity.Provider
+	}
+	return name
+}
@@ -0,0 +1,177 @@
+package chatapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/oauth"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// gitHubAccount is an account the fake GitHub signs in, by code
+type gitHubAccount struct {
+	user   string // The /user answer
+	emails string // The /user/emails answer
+}
+
+// newFakeGitHub serves GitHub's token, user and emails endpoints for the
+// accounts; a code's access token is the code itself
+func newFakeGitHub(t *testing.T, accounts map[string]gitHubAccount) *oauth.Endpoints {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		code := r.FormValue("code")
+		if _, ok := accounts[code]; !ok {
+			w.Write([]byte(`{"error": "bad_verification_code"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token": "` + code + `", "token_type": "bearer"}`))
+	})
+	account := func(r *http.Request) gitHubAccount {
+		return accounts[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	}
+	mux.HandleFunc("GET /user", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(account(r).user)) })
+	mux.HandleFunc("GET /user/emails", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(account(r).emails)) })
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return &oauth.Endpoints{
+		AuthURL:   server.URL + "/authorize",
+		TokenURL:  server.URL + "/token",
+		UserURL:   server.URL + "/user",
+		EmailsURL: server.URL + "/user/emails",
+	}
+}
+
+// withFakeGitHub signs in with the fake GitHub, which knows accounts
+func withFakeGitHub(t *testing.T, accounts map[string]gitHubAccount) testOption {
+	t.Helper()
+	provider, err := oauth.New(oauth.GitHub, oauth.Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "https://chat.example.com/v1/auth/oauth/github/callback",
+		Endpoints:    newFakeGitHub(t, accounts),
+	}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func(app *application) {
+		app.config.mail.publicURL = "https://chat.example.com"
+		app.oauth = map[string]*oauth.Provider{oauth.GitHub: provider}
+	}
+}
+
+// newOAuthStore has ada able to log in and new users joining the default rooms
+func newOAuthStore(t *testing.T) *testStore {
+	t.Helper()
+	ts := newTestStore(t)
+	addLoginUser(t, ts)
+	newOnboardingUsers(ts)
+	return ts
+}
+
+// startOAuthLogin starts signing in with GitHub and returns the state the
+// browser was sent off with and the cookie it was given
+func startOAuthLogin(t *testing.T, serverURL string) (string, string) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(serverURL + "/v1/auth/oauth/github/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("starting the login got %d, want 302", resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := location.Query()
+	if location.Path != "/authorize" || query.Get("redirect_uri") != "https://chat.example.com/v1/auth/oauth/github/callback" {
+		t.Errorf("redirected to %s", location)
+	}
+	cookies := resp.Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oauthStateCookie || cookies[0].Value != query.Get("state") || !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Fatalf("got cookies %v for state %q", cookies, query.Get("state"))
+	}
+	return query.Get("state"), cookies[0].Value
+}
+
+// oauthCallback comes back from GitHub with code, as the browser would
+func oauthCallback(t *testing.T, serverURL, code string, out any) int {
+	t.Helper()
+	state, cookie := startOAuthLogin(t, serverURL)
+	callback := serverURL + "/v1/auth/oauth/github/callback?" + url.Values{"code": {code}, "state": {state}}.Encode()
+	return doJSONWithHeaders(t, http.MethodGet, callback, 0, map[string]string{"Cookie": oauthStateCookie + "=" + cookie}, nil, out)
+}
+
+// TestOAuthLogin signs in with GitHub: a new account gets a new user (201)
+// and signs it in again later (200); an account with ada's verified address
+// is linked to ada; one without a verified address is refused. A refused
+// code is a 400, as is a callback without the state cookie
+func TestOAuthLogin(t *testing.T) {
+	ts := newOAuthStore(t)
+	server := newTestServer(t, ts, withFakeGitHub(t, map[string]gitHubAccount{
+		"grace": {`{"id": 7, "login": "grace hopper!"}`, `[{"email": "grace@example.com", "primary": true, "verified": true}]`},
+		"ada":   {`{"id": 8, "login": "ada-gh"}`, `[{"email": "ADA@example.com", "primary": true, "verified": true}]`},
+		"eve":   {`{"id": 9, "login": "eve"}`, `[{"email": "ada@example.com", "primary": true, "verified": false}]`},
+	}))
+
+	var created AuthResponse
+	if status := oauthCallback(t, server.URL, "grace", &created); status != http.StatusCreated || created.Token == "" {
+		t.Fatalf("a new account got %d, want 201 with a token", status)
+	}
+	if created.User.Username != "gracehopper" || created.User.Email != "grace@example.com" {
+		t.Errorf("the new user is %+v, want gracehopper", created.User)
+	}
+	var again AuthResponse
+	if status := oauthCallback(t, server.URL, "grace", &again); status != http.StatusOK || again.User.ID != created.User.ID {
+		t.Errorf("signing in again got %d as user %d, want 200 as user %d", status, again.User.ID, created.User.ID)
+	}
+	if status := doJSONWithHeaders(t, http.MethodGet, server.URL+"/v1/auth/me", 0, withToken(again.Token), nil, nil); status != http.StatusOK {
+		t.Errorf("the token got %d from /v1/auth/me, want 200", status)
+	}
+
+	var linked AuthResponse
+	if status := oauthCallback(t, server.URL, "ada", &linked); status != http.StatusOK || linked.User.ID != 1 {
+		t.Errorf("ada's address got %d as user %d, want 200 as ada", status, linked.User.ID)
+	}
+	if userID, err := ts.oauth.GetUserID(t.Context(), oauth.GitHub, "8"); err != nil || userID != 1 {
+		t.Errorf("the account is linked to %d (%v), want ada", userID, err)
+	}
+
+	var failure errorBody
+	if status := oauthCallback(t, server.URL, "eve", &failure); status != http.StatusForbidden || failure.Code != "oauth_email_unverified" {
+		t.Errorf("an unverified address got %d %q, want 403 oauth_email_unverified", status, failure.Code)
+	}
+	if status := oauthCallback(t, server.URL, "stale", &failure); status != http.StatusBadRequest || failure.Code != "invalid_oauth_code" {
+		t.Errorf("a refused code got %d %q, want 400 invalid_oauth_code", status, failure.Code)
+	}
+
+	state, _ := startOAuthLogin(t, server.URL)
+	callback := server.URL + "/v1/auth/oauth/github/callback?" + url.Values{"code": {"grace"}, "state": {state}}.Encode()
+	if status := doJSON(t, http.MethodGet, callback, 0, nil, &failure); status != http.StatusBadRequest || failure.Code != "invalid_oauth_state" {
+		t.Errorf("a callback without the cookie got %d %q, want 400 invalid_oauth_state", status, failure.Code)
+	}
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/auth/oauth/google/login", 0, nil, &failure); status != http.StatusNotFound || failure.Code != "oauth_provider_not_found" {
+		t.Errorf("an unconfigured provider got %d %q, want 404 oauth_provider_not_found", status, failure.Code)
+	}
+}
+
+// TestOAuthLoginTwoFactor still asks ada for a code when signing in with GitHub
+func TestOAuthLoginTwoFactor(t *testing.T) {
+	ts := newOAuthStore(t)
+	server := newTestServer(t, ts, withFakeGitHub(t, map[string]gitHubAccount{
+		"ada": {`{"id": 8, "login": "ada-gh"}`, `[{"email": "ada@example.com", "primary": true, "verified": true}]`},
+	}))
+	ts.twoFactor.states[1] = &store.TwoFactor{UserID: 1, Secret: []byte("secret"), Enabled: true}
+
+	var challenge TwoFactorChallengeResponse
+	if status := oauthCallback(t, server.URL, "ada", &challenge); status != http.StatusOK || !challenge.TwoFactorRequired || challenge.TwoFactorToken == "" {
+		t.Errorf("signing in got %d %+v, want a 2FA challenge", status, challenge)
+	}
+}
@@ -0,0 +1,296 @@
+package chatapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/webhook"
+	ws "github.com/drazan344/go-chat/internal/websocket"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+const (
+	// maxOutgoingWebhooksPerRoom caps the webhooks one room can have
+	maxOutgoingWebhooksPerRoom = 5
+
+	// minOutgoingWebhookSecret is the shortest secret a webhook can be saved with
+	minOutgoingWebhookSecret = 16
+
+	// Page size of the delivery log when ?limit is not given, and its cap
+	// (the log keeps no more than 100 entries per webhook anyway)
+	defaultWebhookDeliveriesLimit = 50
+	maxWebhookDeliveriesLimit     = 100
+)
+
+// OutgoingWebhookRequest adds a webhook to a room
+type OutgoingWebhookRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+// startOutgoingWebhooks sets up the dispatcher of rooms' outgoing webhooks,
+// when OUTGOING_WEBHOOK_HOSTS allows any
+// Returns nil when they're turned off; the dispatcher runs from startBackground
+func startOutgoingWebhooks(hub *ws.Hub, st store.Storage, cfg webhookConfig) *webhook.Outgoing {
+	if len(cfg.roomHosts) == 0 {
+		return nil
+	}
+
+	outgoing := webhook.NewOutgoing(st)
+	outgoing.Register(hub.Hooks())
+	outgoing.OnDisabled(func(hook *store.OutgoingWebhook) {
+		outgoingWebhookDisabled(hub, st, hook)
+	})
+	return outgoing
+}
+
+// outgoingWebhookDisabled tells whoever added a webhook that it was turned off
+// Webhooks whose creator is gone are reported to the room's owner
+func outgoingWebhookDisabled(hub *ws.Hub, st store.Storage, hook *store.OutgoingWebhook) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	room, err := st.Rooms.GetByID(ctx, hook.RoomID)
+	if err != nil {
+		log.Printf("Failed to look up room %d to report its disabled outgoing webhook: %v", hook.RoomID, err)
+		return
+	}
+	userID := room.CreatedBy
+	if hook.CreatedBy != nil {
+		userID = *hook.CreatedBy
+	}
+	hub.SendToUser(userID, &ws.Message{
+		Message: wire.Message{
+			RoomID:  hook.RoomID,
+			Content: "the outgoing webhook to " + hook.URL + " of " + room.Name + " was " + hook.DisabledReason,
+			Type:    "outgoing_webhook_disabled",
+		},
+	})
+}
+
+// requireOutgoingWebhookOwner checks that outgoing webhooks are on and the user owns the room
+// Like moderation hooks, webhooks make the server POST the room's messages to
+// a URL of the owner's choosing, so they're for the room's owner only
+// It writes the error response itself and returns false if the check fails
+func (app *application) requireOutgoingWebhookOwner(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	if app.outgoing == nil {
+		writeError(w, r, http.StatusNotFound, "outgoing_webhooks_disabled")
+		return 0, false
+	}
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return 0, false
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return 0, false
+	}
+
+	access, ok := app.authorizeRoom(w, r, roomID, userID, "")
+	if !ok {
+		return 0, false
+	}
+	if access.Role != store.RoomRoleOwner {
+		writeError(w, r, http.StatusForbidden, "outgoing_webhook_owner_only")
+		return 0, false
+	}
+	return roomID, true
+}
+
+// outgoingWebhookChanged reports a change that may interest a room's webhooks
+// (members, pins, the room's description); does nothing when they're turned off
+func (app *application) outgoingWebhookChanged(roomID int64) {
+	if app.outgoing != nil {
+		app.outgoing.RoomChanged(roomID)
+	}
+}
+
+// listOutgoingWebhooksHandler returns a room's webhooks, secrets left out
+// GET /v1/rooms/{roomID}/outgoing-webhooks
+// Requires authentication; the room's owner only
+// Response: [{"id": 1, "room_id": 1, "url": "https://ci.example.com/hook", "events": ["message_pinned"],
+// "enabled": true, "consecutive_failures": 0, ...}]
+func (app *application) listOutgoingWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	roomID, ok := app.requireOutgoingWebhookOwner(w, r)
+	if !ok {
+		return
+	}
+
+	hooks, err := app.store.OutgoingWebhooks.ListForRoom(r.Context(), roomID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "outgoing_webhook_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, hooks)
+}
+
+// createOutgoingWebhookHandler adds a webhook to a room
+// The room's events listed in events are then POSTed to the URL as JSON,
+// signed with X-GoChat-Signature: sha256=<HMAC-SHA256(secret, body)>, with the
+// event type in X-GoChat-Event. Events: message_created, message_pinned,
+// member_joined, member_left and topic_changed. A delivery is retried 3 times
+// with exponential backoff; after 50 failed deliveries in a row the webhook
+// is turned off and its creator told
+// POST /v1/rooms/{roomID}/outgoing-webhooks
+// Requires authentication; the room's owner only, up to 5 webhooks per room.
+// The URL's host must be listed in OUTGOING_WEBHOOK_HOSTS
+// Request body: {"url": "https://ci.example.com/hook", "secret": "...", "events": ["message_pinned", "member_joined"]}
+// Response: 201 Created with the webhook as for GET
+func (app *application) createOutgoingWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	roomID, ok := app.requireOutgoingWebhookOwner(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	var req OutgoingWebhookRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	hook := &store.OutgoingWebhook{
+		RoomID:    roomID,
+		URL:       strings.TrimSpace(req.URL),
+		Secret:    req.Secret,
+		Events:    make([]string, 0, len(req.Events)),
+		CreatedBy: &userID,
+	}
+
+	fields := make(map[string][]fieldError)
+	u, err := url.Parse(hook.URL)
+	switch {
+	case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+		fields["url"] = append(fields["url"], fieldError{Error: "must be an http or https URL", Code: "invalid_url"})
+	case !hostAllowed(app.config.webhook.roomHosts, u):
+		fields["url"] = append(fields["url"], fieldError{Error: "host is not in OUTGOING_WEBHOOK_HOSTS", Code: "host_not_allowed"})
+	}
+	if len(hook.Secret) < minOutgoingWebhookSecret {
+		fields["secret"] = append(fields["secret"], fieldError{Error: "must be at least 16 characters", Code: "too_short"})
+	}
+	if len(req.Events) == 0 {
+		fields["events"] = append(fields["events"], fieldError{Error: "must list at least one event", Code: "required"})
+	}
+	seen := make(map[string]bool)
+	for _, event := range req.Events {
+		if !webhook.ValidOutgoingEvent(event) {
+			fields["events"] = append(fields["events"], fieldError{Error: "unknown event " + event, Code: "unknown_event"})
+			continue
+		}
+		if !seen[event] {
+			seen[event] = true
+			hook.Events = append(hook.Events, event)
+		}
+	}
+	if len(fields) > 0 {
+		writeFieldErrors(w, r, http.StatusBadRequest, "invalid_outgoing_webhook", fields)
+		return
+	}
+
+	existing, err := app.store.OutgoingWebhooks.ListForRoom(r.Context(), roomID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "outgoing_webhook_failed")
+		return
+	}
+	if len(existing) >= maxOutgoingWebhooksPerRoom {
+		writeError(w, r, http.StatusConflict, "outgoing_webhook_limit", maxOutgoingWebhooksPerRoom)
+		return
+	}
+
+	if err := app.store.OutgoingWebhooks.Create(r.Context(), hook); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "outgoing_webhook_failed")
+		return
+	}
+	app.outgoing.Invalidate()
+
+	writeJSON(w, http.StatusCreated, hook)
+}
+
+// deleteOutgoingWebhookHandler removes one of a room's webhooks and its delivery log
+// Deliveries already under way still go out
+// DELETE /v1/rooms/{roomID}/outgoing-webhooks/{webhookID}
+// Requires authentication; the room's owner only
+// Response: 204 No Content
+func (app *application) deleteOutgoingWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	roomID, ok := app.requireOutgoingWebhookOwner(w, r)
+	if !ok {
+		return
+	}
+	webhookID, err := extractIDFromURL(r, "webhookID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "webhookID")
+		return
+	}
+
+	if err := app.store.OutgoingWebhooks.Delete(r.Context(), roomID, webhookID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "outgoing_webhook_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "outgoing_webhook_failed")
+		return
+	}
+	app.outgoing.Invalidate()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listOutgoingWebhookDeliveriesHandler returns a webhook's most recent deliveries, newest first
+// The last 100 are kept; response_body is the first 1KB of the webhook's answer
+// GET /v1/rooms/{roomID}/outgoing-webhooks/{webhookID}/deliveries?limit=50
+// Requires authentication; the room's owner only
+// Response: [{"id": 7, "webhook_id": 1, "event": "member_joined", "status": "failed", "attempts": 4,
+// "response_code": 502, "latency_ms": 130, "response_body": "...", "error": "...", "created_at": "..."}]
+func (app *application) listOutgoingWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	roomID, ok := app.requireOutgoingWebhookOwner(w, r)
+	if !ok {
+		return
+	}
+	webhookID, err := extractIDFromURL(r, "webhookID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "webhookID")
+		return
+	}
+
+	limit := defaultWebhookDeliveriesLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxWebhookDeliveriesLimit {
+			writeError(w, r, http.StatusBadRequest, "invalid_pagination")
+			return
+		}
+		limit = n
+	}
+
+	// Looked up through the room, so one room's owner can't read another's log
+	if _, err := app.store.OutgoingWebhooks.Get(r.Context(), roomID, webhookID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "outgoing_webhook_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "outgoing_webhook_failed")
+		return
+	}
+
+	deliveries, err := app.store.OutgoingWebhooks.ListDeliveries(r.Context(), webhookID, limit)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "outgoing_webhook_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, deliveries)
+}
@@ -0,0 +1,221 @@
+package chatapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/auth"
+	"github.com/drazan344/go-chat/internal/mail"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+const (
+	// Reset emails allowed per address per window, so the endpoint can't be
+	// used to flood someone's inbox
+	passwordResetRequests      = 3
+	passwordResetRequestWindow = time.Hour
+
+	// passwordResetPurgeInterval is how often used and expired reset tokens are deleted
+	passwordResetPurgeInterval = time.Hour
+)
+
+// ForgotPasswordRequest asks for a password reset email
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest sets a new password with the token from a reset email
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// forgotPasswordHandler emails a link to reset the password of the account
+// with the given address
+// POST /v1/auth/forgot-password
+// Request body: {"email": "john@example.com"}
+// Response: 202 {"status": "accepted"}, whether or not the address has an
+// account, so the endpoint can't be used to find out which do
+// The link (PUBLIC_URL/?reset=...) works once, for PASSWORD_RESET_TTL; asking
+// again replaces it. Needs MAIL_PROVIDER; without it the response is 503
+func (app *application) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	email := store.NormalizeEmail(req.Email)
+	if !strings.Contains(email, "@") {
+		writeError(w, r, http.StatusBadRequest, "invalid_email_format")
+		return
+	}
+	if app.mailer == nil {
+		writeError(w, r, http.StatusServiceUnavailable, "password_reset_unavailable")
+		return
+	}
+
+	// Limited per address, known or not, so the limit gives nothing away either
+	ok, retryAfter := app.passwordResetLimiter.allow(email)
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeError(w, r, http.StatusTooManyRequests, "password_reset_rate_limited")
+		return
+	}
+
+	users, err := app.store.Users.GetByEmails(r.Context(), []string{email})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "password_reset_failed")
+		return
+	}
+	// The token is made, stored and sent in the background, so the request
+	// does the same work, one lookup, for known and unknown addresses
+	if len(users) > 0 {
+		go app.sendPasswordReset(users[0])
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "accepted"})
+}
+
+// resetPasswordHandler sets a new password with the token from a reset email
+// POST /v1/auth/reset-password
+// Request body: {"token": "gcrst_...", "password": "new secret"}
+// Response: 204 No Content
+// The token is used up, and the account is signed out everywhere: its
+// sessions, older tokens and personal access tokens are revoked, and the
+// user logs in again with the new password. The password goes through the
+// same policy as at registration
+func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	if req.Token == "" || req.Password == "" {
+		writeError(w, r, http.StatusBadRequest, "password_reset_fields_required")
+		return
+	}
+
+	hash := auth.HashAPIToken(req.Token)
+	userID, err := app.store.PasswordResets.GetUserID(r.Context(), hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusBadRequest, "invalid_reset_token")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "password_reset_failed")
+		return
+	}
+	user, err := app.store.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusBadRequest, "invalid_reset_token")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return
+	}
+
+	if !app.checkPassword(w, r, req.Password, auth.PasswordUser{Username: user.Username, Email: user.Email}) {
+		return
+	}
+	hashedPassword, err := auth.HashPassword(req.Password)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "password_processing_failed")
+		return
+	}
+
+	// The token is checked again as it's used up, in case another request got there first
+	sessionIDs, err := app.store.PasswordResets.Consume(r.Context(), hash, hashedPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusBadRequest, "invalid_reset_token")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "password_reset_failed")
+		return
+	}
+	app.tokenVersions.Invalidate(userID)
+	app.hub.CloseUser(userID)
+	log.Printf("User %d reset their password; %d sessions signed out", userID, len(sessionIDs))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// passwordResetEmail builds the email for a reset token
+// The link opens the web app's reset form with the token filled in
+func (app *application) passwordResetEmail(user *store.User, token string, expiresAt time.Time) *mail.Message {
+	link := strings.TrimRight(app.config.mail.publicURL, "/") + "/?reset=" + url.QueryEscape(token)
+	text := fmt.Sprintf("Someone asked to reset the password of your go-chat account %s.\n\n"+
+		"Choose a new password here:\n%s\n\n"+
+		"The link works once and expires at %s UTC. If you didn't ask for it, ignore this email; "+
+		"your password stays the same.\n",
+		user.Username, link, expiresAt.UTC().Format("January 2, 2006 15:04"))
+
+	return &mail.Message{
+		To:      user.Email,
+		Subject: "Reset your go-chat password",
+		Text:    text,
+	}
+}
+
+// sendPasswordReset stores a new reset token for a user and emails it,
+// only logging a failure: the user can ask again
+// It should be called in a goroutine
+func (app *application) sendPasswordReset(user *store.User) {
+	ctx, cancel := context.WithTimeout(app.ctx, 30*time.Second)
+	defer cancel()
+
+	token, hash, err := auth.GeneratePasswordResetToken()
+	if err != nil {
+		log.Printf("Failed to make a password reset token for user %d: %v", user.ID, err)
+		return
+	}
+	expiresAt, err := app.store.PasswordResets.Create(ctx, user.ID, hash, app.config.auth.passwordResetTTL)
+	if err != nil {
+		log.Printf("Failed to save a password reset token for user %d: %v", user.ID, err)
+		return
+	}
+
+	email := app.passwordResetEmail(user, token, expiresAt)
+	if err := app.mailer.Send(ctx, email); err != nil {
+		log.Printf("Failed to send password reset email to %s: %v", email.To, err)
+	}
+}
+
+// runPasswordResetPurger periodically deletes reset tokens that were used or
+// expired more than a day ago
+// It runs until ctx is done and should be started in a goroutine
+func (app *application) runPasswordResetPurger(ctx context.Context) {
+	ticker := time.NewTicker(passwordResetPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		callCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		removed, err := app.store.PasswordResets.PurgeExpired(callCtx, time.Now().Add(-24*time.Hour))
+		cancel()
+
+		if err != nil {
+			log.Printf("Failed to purge password reset tokens: %v", err)
+			continue
+		}
+		if removed > 0 {
+			log.Printf("Purged %d password reset tokens", removed)
+		}
+	}
+}
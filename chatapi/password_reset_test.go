@@ -0,0 +1,143 @@
+package chatapi
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/mail"
+	"github.com/drazan344/go-chat/internal/testutil"
+)
+
+// withPasswordReset mails reset links through mailer (nil for none), valid
+// for an hour
+func withPasswordReset(mailer mail.Mailer) testOption {
+	return func(app *application) {
+		withMailer(mailer)(app)
+		app.config.auth.passwordResetTTL = time.Hour
+	}
+}
+
+// forgotPassword asks for a reset email for an address and returns the status
+func forgotPassword(t *testing.T, serverURL, email string) int {
+	t.Helper()
+	return doJSON(t, http.MethodPost, serverURL+"/v1/auth/forgot-password", 0, ForgotPasswordRequest{Email: email}, nil)
+}
+
+// resetToken waits for the nth reset email and returns the token in its link
+func resetToken(t *testing.T, mailer *recordingMailer, n int) string {
+	t.Helper()
+	if !testutil.WaitFor(time.Second, func() bool { return len(mailer.messages()) >= n }) {
+		t.Fatalf("got %d reset emails, want %d", len(mailer.messages()), n)
+	}
+	message := mailer.messages()[n-1]
+	_, link, ok := strings.Cut(message.Text, "https://chat.example.com/?")
+	if !ok {
+		t.Fatalf("the reset email has no link:\n%s", message.Text)
+	}
+	query, err := url.ParseQuery(strings.Fields(link)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return query.Get("reset")
+}
+
+// TestPasswordReset has ada, logged in, ask for a reset in different case:
+// the link works once, after a refused weak password, and signs out the old
+// session, a token without a session and their personal access token. An
+// unknown address gets the same answer and no email
+func TestPasswordReset(t *testing.T) {
+	mailer := &recordingMailer{}
+	ts := newTestStore(t)
+	addLoginUser(t, ts)
+	server := newTestServer(t, ts, withPasswordReset(mailer))
+	reset := server.URL + "/v1/auth/reset-password"
+	browser := login(t, server.URL, firefoxOnWindows)
+	var apiToken CreateAPITokenResponse
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/users/me/tokens", 1, CreateAPITokenRequest{Name: "backup script"}, &apiToken); status != http.StatusCreated {
+		t.Fatalf("creating a token got %d, want 201", status)
+	}
+	var apiKey CreateAPIKeyResponse
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/users/me/api-keys", 1, CreateAPIKeyRequest{Name: "deploy bot"}, &apiKey); status != http.StatusCreated {
+		t.Fatalf("creating a bot key got %d, want 201", status)
+	}
+
+	if status := forgotPassword(t, server.URL, " Ada@Example.com "); status != http.StatusAccepted {
+		t.Fatalf("asking for a reset got %d, want 202", status)
+	}
+	if status := forgotPassword(t, server.URL, "nobody@example.com"); status != http.StatusAccepted {
+		t.Errorf("asking for an unknown address got %d, want 202 as well", status)
+	}
+	token := resetToken(t, mailer, 1)
+	if sent := mailer.messages(); len(sent) != 1 || sent[0].To != "ada@example.com" {
+		t.Fatalf("sent %d emails, want one to ada", len(sent))
+	}
+
+	var failure errorBody
+	if status := doJSON(t, http.MethodPost, reset, 0, ResetPasswordRequest{Token: token, Password: "ada"}, &failure); status != http.StatusBadRequest || failure.Code != "password_policy_violation" {
+		t.Errorf("a weak password got %d %q, want 400 password_policy_violation", status, failure.Code)
+	}
+	if status := doJSON(t, http.MethodPost, reset, 0, ResetPasswordRequest{Token: token, Password: "a brand new passphrase"}, nil); status != http.StatusNoContent {
+		t.Fatalf("resetting got %d, want 204", status)
+	}
+	if status := doJSON(t, http.MethodPost, reset, 0, ResetPasswordRequest{Token: token, Password: "another new passphrase"}, &failure); status != http.StatusBadRequest || failure.Code != "invalid_reset_token" {
+		t.Errorf("using the token again got %d %q, want 400 invalid_reset_token", status, failure.Code)
+	}
+
+	if status := doJSONWithHeaders(t, http.MethodGet, server.URL+"/v1/auth/me", 0, browser, nil, &failure); status != http.StatusUnauthorized || failure.Code != "session_revoked" {
+		t.Errorf("the session from before the reset got %d %q, want 401 session_revoked", status, failure.Code)
+	}
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/auth/me", 1, nil, &failure); status != http.StatusUnauthorized || failure.Code != "token_revoked" {
+		t.Errorf("a token without a session got %d %q, want 401 token_revoked", status, failure.Code)
+	}
+	if status := doJSONWithHeaders(t, http.MethodGet, server.URL+"/v1/auth/me", 0, withToken(apiToken.Token), nil, &failure); status != http.StatusUnauthorized || failure.Code != "invalid_token" {
+		t.Errorf("the personal access token got %d %q, want 401 invalid_token", status, failure.Code)
+	}
+	if status := doJSONWithHeaders(t, http.MethodGet, server.URL+"/v1/auth/me", 0, withAPIKey(apiKey.Key), nil, nil); status != http.StatusOK {
+		t.Errorf("the bot key got %d, want 200: resets leave bots be", status)
+	}
+	login := server.URL + "/v1/auth/login"
+	if status := doJSON(t, http.MethodPost, login, 0, LoginRequest{Email: "ada@example.com", Password: "correct horse battery staple"}, nil); status != http.StatusUnauthorized {
+		t.Errorf("the old password got %d, want 401", status)
+	}
+	if status := doJSON(t, http.MethodPost, login, 0, LoginRequest{Email: "ada@example.com", Password: "a brand new passphrase"}, nil); status != http.StatusOK {
+		t.Errorf("the new password got %d, want 200", status)
+	}
+}
+
+// TestPasswordResetRequests asks for ada's reset again, which retires the
+// first link, until the per-address limit refuses more; without email set
+// up, nothing is sent
+func TestPasswordResetRequests(t *testing.T) {
+	mailer := &recordingMailer{}
+	ts := newTestStore(t)
+	addLoginUser(t, ts)
+	server := newTestServer(t, ts, withPasswordReset(mailer))
+	reset := server.URL + "/v1/auth/reset-password"
+
+	forgotPassword(t, server.URL, "ada@example.com")
+	first := resetToken(t, mailer, 1)
+	forgotPassword(t, server.URL, "ada@example.com")
+	second := resetToken(t, mailer, 2)
+
+	var failure errorBody
+	if status := doJSON(t, http.MethodPost, reset, 0, ResetPasswordRequest{Token: first, Password: "a brand new passphrase"}, &failure); status != http.StatusBadRequest || failure.Code != "invalid_reset_token" {
+		t.Errorf("the replaced token got %d %q, want 400 invalid_reset_token", status, failure.Code)
+	}
+	if status := doJSON(t, http.MethodPost, reset, 0, ResetPasswordRequest{Token: second, Password: "a brand new passphrase"}, nil); status != http.StatusNoContent {
+		t.Errorf("the newest token got %d, want 204", status)
+	}
+
+	forgotPassword(t, server.URL, "ada@example.com")
+	if status := forgotPassword(t, server.URL, "ADA@example.com"); status != http.StatusTooManyRequests {
+		t.Errorf("a fourth request for the address got %d, want 429", status)
+	}
+
+	disabled := newTestServer(t, ts, withPasswordReset(nil))
+	var unavailable errorBody
+	if status := doJSON(t, http.MethodPost, disabled.URL+"/v1/auth/forgot-password", 0, ForgotPasswordRequest{Email: "ada@example.com"}, &unavailable); status != http.StatusServiceUnavailable || unavailable.Code != "password_reset_unavailable" {
+		t.Errorf("without email got %d %q, want 503 password_reset_unavailable", status, unavailable.Code)
+	}
+}
@@ -0,0 +1,153 @@
+package chatapi
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// defaultContextMessages is how many messages the context endpoint returns on
+// each side of the anchor when the request doesn't say
+const defaultContextMessages = 25
+
+// MessagePermalinkResponse is a linked message with the room it was posted in
+type MessagePermalinkResponse struct {
+	Message *store.Message `json:"message"`
+	Room    *store.Room    `json:"room"`
+}
+
+// getMessageHandler resolves a link to a single message
+// Clients open the room from the response, then load the messages around it
+// with the context endpoint below
+// GET /v1/messages/{messageID}
+// Requires authentication and membership of the message's room; anyone else
+// gets the same 404 as for a missing message, so links don't reveal which rooms exist
+// Response: {"message": {"id": 123, "room_id": 4, ...}, "room": {"id": 4, "name": "general", ...}}
+func (app *application) getMessageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	messageID, err := extractIDFromURL(r, "messageID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "messageID")
+		return
+	}
+
+	message, err := app.store.Messages.GetByID(r.Context(), messageID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "message_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "message_lookup_failed")
+		return
+	}
+
+	isMember, err := app.store.RoomMembers.IsUserInRoom(r.Context(), message.RoomID, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "membership_check_failed")
+		return
+	}
+	if !isMember {
+		writeError(w, r, http.StatusNotFound, "message_not_found")
+		return
+	}
+
+	// A deleted room's messages stay until it's purged, but can't be opened
+	room, err := app.store.Rooms.GetByID(r.Context(), message.RoomID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "message_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_lookup_failed")
+		return
+	}
+
+	app.hub.RenderSystemMessages(r.Context(), []*store.Message{message})
+	writeJSON(w, http.StatusOK, MessagePermalinkResponse{Message: message, Room: room})
+}
+
+// parseContextCounts reads the before and after query parameters of the
+// context endpoints, defaulting to defaultContextMessages
+// Out of range counts are clamped by the store; only non-numbers are refused
+// It writes the error response itself and returns false if one is invalid
+func parseContextCounts(w http.ResponseWriter, r *http.Request) (before, after int, ok bool) {
+	counts := []int{defaultContextMessages, defaultContextMessages}
+	for i, name := range []string{"before", "after"} {
+		raw := r.URL.Query().Get(name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_context_count", name)
+			return 0, 0, false
+		}
+		counts[i] = n
+	}
+	return counts[0], counts[1], true
+}
+
+// getMessageContextHandler returns the messages around one message, for
+// opening a room scrolled to a linked message
+// before and after default to 25 and are clamped to 0..100; has_more_before
+// and has_more_after tell the client whether to offer loading further
+// GET /v1/rooms/{roomID}/messages/context?around_id=123&before=25&after=25
+// Requires authentication and room membership
+// Response: {"anchor_id": 123, "messages": [{"id": 98, ...}, ..., {"id": 123, ...}, ...],
+// "has_more_before": true, "has_more_after": false}
+// around_id not in the room: 404
+func (app *application) getMessageContextHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	aroundID, err := strconv.ParseInt(r.URL.Query().Get("around_id"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "around_id")
+		return
+	}
+
+	before, after, ok := parseContextCounts(w, r)
+	if !ok {
+		return
+	}
+
+	isMember, err := app.store.RoomMembers.IsUserInRoom(r.Context(), roomID, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "membership_check_failed")
+		return
+	}
+	if !isMember {
+		writeError(w, r, http.StatusForbidden, "membership_required_messages")
+		return
+	}
+
+	window, err := app.store.Messages.GetMessagesAround(r.Context(), roomID, aroundID, before, after)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "message_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "messages_lookup_failed")
+		return
+	}
+
+	app.hub.RenderSystemMessages(r.Context(), window.Messages)
+	writeJSON(w, http.StatusOK, window)
+}
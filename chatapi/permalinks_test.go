@@ -0,0 +1,117 @@
+package chatapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// newPermalinkStore has ada and grace in room 1, linus alone in room 2, and
+// ten of grace's messages in room 1 followed by two of linus's in room 2
+func newPermalinkStore(t *testing.T) *testStore {
+	t.Helper()
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.rooms.add(&store.Room{ID: 2, Name: "ops", CreatedBy: 3})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.roomMembers.add(2, 3, store.RoomRoleAdmin)
+	ts.messages.addMessages(1, 2, 10)
+	ts.messages.addMessages(2, 3, 2)
+	return ts
+}
+
+// TestGetMessage resolves a link for a member of the message's room, and
+// answers everyone else, and links into deleted rooms, as if the message
+// didn't exist
+func TestGetMessage(t *testing.T) {
+	ts := newPermalinkStore(t)
+	server := newTestServer(t, ts)
+	link := server.URL + "/v1/messages/4"
+
+	var resp MessagePermalinkResponse
+	if status := doJSON(t, http.MethodGet, link, 1, nil, &resp); status != http.StatusOK {
+		t.Fatalf("ada got %d, want 200", status)
+	}
+	if resp.Message.ID != 4 || resp.Room.ID != 1 || resp.Room.Name != "general" {
+		t.Errorf("got message %+v in room %+v, want 4 in general", resp.Message, resp.Room)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		url    string
+		userID int64
+	}{
+		{"a non-member", link, 3},
+		{"a missing message", server.URL + "/v1/messages/99", 1},
+	} {
+		var failure errorBody
+		if status := doJSON(t, http.MethodGet, tc.url, tc.userID, nil, &failure); status != http.StatusNotFound || failure.Code != "message_not_found" {
+			t.Errorf("%s got %d %q, want 404 message_not_found", tc.name, status, failure.Code)
+		}
+	}
+
+	if err := ts.rooms.SoftDelete(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	var failure errorBody
+	if status := doJSON(t, http.MethodGet, link, 1, nil, &failure); status != http.StatusNotFound || failure.Code != "message_not_found" {
+		t.Errorf("a deleted room's message got %d %q, want 404 message_not_found", status, failure.Code)
+	}
+}
+
+// TestGetMessageContext loads windows around messages in the middle and at
+// both ends of room 1's history, and refuses bad parameters, non-members and
+// anchors from another room
+func TestGetMessageContext(t *testing.T) {
+	server := newTestServer(t, newPermalinkStore(t))
+	contextURL := func(roomID int64, query string) string {
+		return fmt.Sprintf("%s/v1/rooms/%d/messages/context?%s", server.URL, roomID, query)
+	}
+
+	for _, tc := range []struct {
+		query  string
+		want   []int64
+		before bool
+		after  bool
+	}{
+		{"around_id=5&before=2&after=2", []int64{3, 4, 5, 6, 7}, true, true},
+		{"around_id=2&before=3&after=1", []int64{1, 2, 3}, false, true},
+		{"around_id=9&after=25", []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, false, false},
+		{"around_id=10&before=1&after=0", []int64{9, 10}, true, false},
+	} {
+		var window store.MessageWindow
+		if status := doJSON(t, http.MethodGet, contextURL(1, tc.query), 1, nil, &window); status != http.StatusOK {
+			t.Errorf("%s got %d, want 200", tc.query, status)
+			continue
+		}
+		var ids []int64
+		for _, m := range window.Messages {
+			ids = append(ids, m.ID)
+		}
+		if !slices.Equal(ids, tc.want) || window.HasMoreBefore != tc.before || window.HasMoreAfter != tc.after {
+			t.Errorf("%s got %v (more before %v, after %v), want %v (%v, %v)", tc.query, ids, window.HasMoreBefore, window.HasMoreAfter, tc.want, tc.before, tc.after)
+		}
+	}
+
+	for _, tc := range []struct {
+		url    string
+		userID int64
+		status int
+		code   string
+	}{
+		{contextURL(1, "before=2"), 1, http.StatusBadRequest, "invalid_id_parameter"},
+		{contextURL(1, "around_id=5&after=lots"), 1, http.StatusBadRequest, "invalid_context_count"},
+		{contextURL(1, "around_id=5"), 3, http.StatusForbidden, "membership_required_messages"},
+		{contextURL(1, "around_id=11"), 1, http.StatusNotFound, "message_not_found"},
+	} {
+		var failure errorBody
+		if status := doJSON(t, http.MethodGet, tc.url, tc.userID, nil, &failure); status != tc.status || failure.Code != tc.code {
+			t.Errorf("%s as user %d got %d %q, want %d %s", tc.url, tc.userID, status, failure.Code, tc.status, tc.code)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package chatapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/cache"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+const (
+	// roomAccessTTL is how long a user's role and a room's matrix are cached
+	// Changes made through this instance invalidate the room at once; other
+	// instances pick them up within the TTL
+	roomAccessTTL = 10 * time.Second
+
+	// maxRoomAccessEntries bounds the cache; expired entries are swept when it's reached
+	maxRoomAccessEntries = 10000
+)
+
+// RoomPermissionsRequest and RoomPermissionsResponse carry a room's permission
+// matrix: role -> capability -> allowed
+// Roles: owner, admin, member
+// Capabilities: post_message, pin_message, manage_members, manage_settings,
+// delete_room, view_reports, merge_room, mention_everyone
+type RoomPermissionsRequest struct {
+	Permissions store.RoomPermissions `json:"permissions"`
+}
+
+type RoomPermissionsResponse struct {
+	Role        string                `json:"role"` // The caller's role in the room
+	Permissions store.RoomPermissions `json:"permissions"`
+}
+
+// roomAccessKey is one user in one room
+type roomAccessKey struct {
+	roomID, userID int64
+}
+
+// roomAccessCache caches store.RoomAccess per room and user, so permission
+// checks cost one query per user and room every roomAccessTTL at most
+type roomAccessCache struct {
+	*cache.TTL[roomAccessKey, *store.RoomAccess]
+}
+
+// newRoomAccessCache creates an empty cache
+func newRoomAccessCache() *roomAccessCache {
+	return &roomAccessCache{cache.New[roomAccessKey, *store.RoomAccess](roomAccessTTL, maxRoomAccessEntries)}
+}
+
+// invalidateRoom expires everything cached about a room
+// Call it whenever the room's matrix, members or roles change
+func (c *roomAccessCache) invalidateRoom(roomID int64) {
+	c.InvalidateFunc(func(key roomAccessKey) bool { return key.roomID == roomID })
+}
+
+// roomAccess returns what a user may do in a room, from the cache where possible
+// Returns sql.ErrNoRows if the room doesn't exist
+func (app *application) roomAccess(ctx context.Context, roomID, userID int64) (*store.RoomAccess, error) {
+	now := time.Now()
+	key := roomAccessKey{roomID: roomID, userID: userID}
+	access, fresh, generation := app.roomAccessCache.Get(key, now)
+	if fresh {
+		return access, nil
+	}
+
+	access, err := app.store.RoomPermissions.GetAccess(ctx, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	app.roomAccessCache.Put(key, access, generation, now)
+	return access, nil
+}
+
+// requireRoomPermission checks that the current user's role in the room allows a capability
+// Every handler that only some users of a room may use goes through here, so
+// who can do what is decided in one place (see store.RoomPermissions)
+// It writes the error response itself and returns false if the check fails
+func (app *application) requireRoomPermission(w http.ResponseWriter, r *http.Request, roomID, userID int64, capability string) bool {
+	_, ok := app.authorizeRoom(w, r, roomID, userID, capability)
+	return ok
+}
+
+// authorizeRoom is requireRoomPermission for handlers that also need the caller's access
+// An empty capability only checks that the room exists
+func (app *application) authorizeRoom(w http.ResponseWriter, r *http.Request, roomID, userID int64, capability string) (*store.RoomAccess, bool) {
+	access, err := app.roomAccess(r.Context(), roomID, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+			return nil, false
+		}
+		writeError(w, r, http.StatusInternalServerError, "membership_check_failed")
+		return nil, false
+	}
+	if capability != "" && !access.Can(capability) {
+		writeError(w, r, http.StatusForbidden, "room_permission_denied", capability)
+		return nil, false
+	}
+	return access, true
+}
+
+// roomPermissionsHandler returns a room's effective permission matrix
+// Cells the room never changed show their defaults
+// GET /v1/rooms/{roomID}/permissions
+// Requires authentication; room members and the owner only
+// Response: {"role": "admin", "permissions": {"owner": {"post_message": true, ...}, "admin": {...}, "member": {...}}}
+func (app *application) roomPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	access, ok := app.authorizeRoom(w, r, roomID, userID, "")
+	if !ok {
+		return
+	}
+	if access.Role == "" {
+		writeError(w, r, http.StatusForbidden, "membership_required_permissions")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, RoomPermissionsResponse{Role: access.Role, Permissions: access.Permissions})
+}
+
+// updateRoomPermissionsHandler changes some cells of a room's permission matrix
+// Cells left out of the request keep their current value. The owner can't
+// lose manage_settings, so nobody can lock the room's settings for good
+// The change applies on this instance at once, on others within roomAccessTTL
+// PUT /v1/rooms/{roomID}/permissions
+// Requires authentication and manage_settings
+// Request body: {"permissions": {"admin": {"manage_settings": true}, "member": {"pin_message": true}}}
+// Response: the full matrix, as for GET
+func (app *application) updateRoomPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	var req RoomPermissionsRequest
+	if err := readJSON(r, &req); err != nil || len(req.Permissions) == 0 {
+		writeBodyError(w, r, err)
+		return
+	}
+	for role, cells := range req.Permissions {
+		for capability, allowed := range cells {
+			if !store.ValidRoomPermission(role, capability) {
+				writeError(w, r, http.StatusBadRequest, "invalid_room_permission", role+"."+capability)
+				return
+			}
+			if role == store.RoomRoleOwner && capability == store.CapManageSettings && !allowed {
+				writeError(w, r, http.StatusBadRequest, "room_owner_keeps_settings")
+				return
+			}
+		}
+	}
+
+	access, ok := app.authorizeRoom(w, r, roomID, userID, store.CapManageSettings)
+	if !ok {
+		return
+	}
+
+	perms, err := app.store.RoomPermissions.Update(r.Context(), roomID, req.Permissions)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "room_permissions_update_failed")
+		return
+	}
+	app.roomAccessCache.invalidateRoom(roomID)
+
+	writeJSON(w, http.StatusOK, RoomPermissionsResponse{Role: access.Role, Permissions: perms})
+}
@@ -0,0 +1,106 @@
+package chatapi
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestRoomAccessCache checks invalidating a room expires its entries and
+// leaves other rooms' alone
+func TestRoomAccessCache(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	cache := newRoomAccessCache()
+	access := &store.RoomAccess{Role: store.RoomRoleMember}
+
+	cache.Put(roomAccessKey{roomID: 1, userID: 2}, access, 0, now)
+	cache.Put(roomAccessKey{roomID: 1, userID: 3}, access, 0, now)
+	cache.Put(roomAccessKey{roomID: 4, userID: 2}, access, 0, now)
+	cache.invalidateRoom(1)
+	for _, key := range []roomAccessKey{{roomID: 1, userID: 2}, {roomID: 1, userID: 3}} {
+		if _, fresh, _ := cache.Get(key, now); fresh {
+			t.Errorf("an invalidated room's entry for user %d was served", key.userID)
+		}
+	}
+	if got, fresh, _ := cache.Get(roomAccessKey{roomID: 4, userID: 2}, now); !fresh || got != access {
+		t.Error("invalidating one room dropped another's entry")
+	}
+}
+
+// TestRoomPermissionsEndpoints reads and changes a room's matrix as its
+// owner, an admin, a member and an outsider
+func TestRoomPermissionsEndpoints(t *testing.T) {
+	const owner, admin, member, outsider = 2, 3, 4, 5
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: owner})
+	ts.roomMembers.add(1, admin, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, member, store.RoomRoleMember)
+	server := newTestServer(t, ts)
+	url := server.URL + "/v1/rooms/1/permissions"
+
+	var got RoomPermissionsResponse
+	if status := doJSON(t, http.MethodGet, url, member, nil, &got); status != http.StatusOK {
+		t.Fatalf("a member reading the matrix got %d, want 200", status)
+	}
+	if got.Role != store.RoomRoleMember || got.Permissions.Allows(store.RoomRoleMember, store.CapPinMessage) {
+		t.Errorf("a member got role %q and %v, want member and the defaults", got.Role, got.Permissions)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		userID int64
+		body   any
+		status int
+		code   string
+	}{
+		{"an outsider reading", http.MethodGet, url, outsider, nil, http.StatusForbidden, "membership_required_permissions"},
+		{"reading a missing room", http.MethodGet, server.URL + "/v1/rooms/9/permissions", owner, nil, http.StatusNotFound, "room_not_found"},
+		{"a member changing", http.MethodPut, url, member,
+			RoomPermissionsRequest{store.RoomPermissions{"member": {"pin_message": true}}},
+			http.StatusForbidden, "room_permission_denied"},
+		{"an admin changing", http.MethodPut, url, admin,
+			RoomPermissionsRequest{store.RoomPermissions{"member": {"pin_message": true}}},
+			http.StatusForbidden, "room_permission_denied"},
+		{"an unknown capability", http.MethodPut, url, owner,
+			RoomPermissionsRequest{store.RoomPermissions{"member": {"ban_users": true}}},
+			http.StatusBadRequest, "invalid_room_permission"},
+		{"an unknown role", http.MethodPut, url, owner,
+			RoomPermissionsRequest{store.RoomPermissions{"guest": {"post_message": true}}},
+			http.StatusBadRequest, "invalid_room_permission"},
+		{"the owner losing settings", http.MethodPut, url, owner,
+			RoomPermissionsRequest{store.RoomPermissions{"owner": {"manage_settings": false}}},
+			http.StatusBadRequest, "room_owner_keeps_settings"},
+		{"no cells", http.MethodPut, url, owner, RoomPermissionsRequest{}, http.StatusBadRequest, ""},
+	} {
+		var failure errorBody
+		status := doJSON(t, tc.method, tc.path, tc.userID, tc.body, &failure)
+		if status != tc.status || (tc.code != "" && failure.Code != tc.code) {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, status, failure.Code, tc.status, tc.code)
+		}
+	}
+
+	// Let admins change settings; the admin's cached access must not hide it
+	change := RoomPermissionsRequest{store.RoomPermissions{"admin": {"manage_settings": true}}}
+	if status := doJSON(t, http.MethodPut, url, owner, change, &got); status != http.StatusOK {
+		t.Fatalf("the owner changing the matrix got %d, want 200", status)
+	}
+	if got.Role != store.RoomRoleOwner || !got.Permissions.Allows(store.RoomRoleAdmin, store.CapManageSettings) {
+		t.Errorf("the owner got role %q and %v after the change", got.Role, got.Permissions)
+	}
+	change = RoomPermissionsRequest{store.RoomPermissions{"member": {"pin_message": true}}}
+	if status := doJSON(t, http.MethodPut, url, admin, change, &got); status != http.StatusOK {
+		t.Fatalf("an admin allowed to change settings got %d, want 200", status)
+	}
+
+	// Earlier changes stay; later ones are added
+	if status := doJSON(t, http.MethodGet, url, member, nil, &got); status != http.StatusOK {
+		t.Fatalf("a member reading the matrix got %d, want 200", status)
+	}
+	if !got.Permissions.Allows(store.RoomRoleMember, store.CapPinMessage) || !got.Permissions.Allows(store.RoomRoleAdmin, store.CapManageSettings) {
+		t.Errorf("the matrix is %v, want both changes", got.Permissions)
+	}
+}
@@ -0,0 +1,234 @@
+package chatapi
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/websocket"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// PinOrderRequest is the new order of a room's pinned bar
+// Version is the pins_version the client last saw (from GET .../pins or a pin frame)
+type PinOrderRequest struct {
+	MessageIDs []int64 `json:"message_ids"`
+	Version    int64   `json:"version"`
+}
+
+// pinsResponse is a room's pins with the version needed to reorder them
+type pinsResponse struct {
+	Version int64                  `json:"pins_version"`
+	Pins    []*store.PinnedMessage `json:"pins"`
+}
+
+// pinsVersionResponse is the answer to a pin change: the version after it
+type pinsVersionResponse struct {
+	Version int64 `json:"pins_version"`
+}
+
+// listPinsHandler returns a room's pinned messages in display order
+// GET /v1/rooms/{roomID}/pins
+// Requires authentication and room membership
+// Response: {"pins_version": 4, "pins": [{"message_id": 12, "position": 1, "content": "...", ...}]}
+func (app *application) listPinsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	isMember, err := app.store.RoomMembers.IsUserInRoom(r.Context(), roomID, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "membership_check_failed")
+		return
+	}
+	if !isMember {
+		writeError(w, r, http.StatusForbidden, "membership_required_pins")
+		return
+	}
+
+	pins, version, err := app.store.Pins.List(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "pins_lookup_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, pinsResponse{Version: version, Pins: pins})
+}
+
+// pinMessageHandler adds a message to the end of the room's pinned bar
+// POST /v1/rooms/{roomID}/pins/{messageID}
+// Requires authentication and pin_message (by default the owner and admins)
+// Response: {"pins_version": 5}
+func (app *application) pinMessageHandler(w http.ResponseWriter, r *http.Request) {
+	room, userID, ok := app.pinManager(w, r)
+	if !ok {
+		return
+	}
+
+	messageID, err := extractIDFromURL(r, "messageID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "messageID")
+		return
+	}
+
+	change, err := app.store.Pins.Pin(r.Context(), room.ID, messageID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			writeError(w, r, http.StatusNotFound, "message_not_found")
+		case errors.Is(err, store.ErrAlreadyPinned):
+			writeError(w, r, http.StatusConflict, "message_already_pinned")
+		case errors.Is(err, store.ErrTooManyPins):
+			writeError(w, r, http.StatusConflict, "too_many_pins", store.MaxPinsPerRoom)
+		default:
+			writeError(w, r, http.StatusInternalServerError, "pin_update_failed")
+		}
+		return
+	}
+
+	app.hub.Announce(&websocket.Message{
+		Message: wire.Message{
+			RoomID:      room.ID,
+			UserID:      userID,
+			Type:        "pin_added",
+			MessageID:   messageID,
+			PinsVersion: change.Version,
+			EventSeq:    change.EventSeq,
+		},
+	})
+	app.outgoingWebhookChanged(room.ID)
+	writeJSON(w, http.StatusOK, pinsVersionResponse{Version: change.Version})
+}
+
+// unpinMessageHandler removes a message from the room's pinned bar
+// DELETE /v1/rooms/{roomID}/pins/{messageID}
+// Requires authentication and pin_message (by default the owner and admins)
+// Response: {"pins_version": 6}
+func (app *application) unpinMessageHandler(w http.ResponseWriter, r *http.Request) {
+	room, userID, ok := app.pinManager(w, r)
+	if !ok {
+		return
+	}
+
+	messageID, err := extractIDFromURL(r, "messageID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "messageID")
+		return
+	}
+
+	change, err := app.store.Pins.Unpin(r.Context(), room.ID, messageID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "pin_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "pin_update_failed")
+		return
+	}
+
+	app.hub.Announce(&websocket.Message{
+		Message: wire.Message{
+			RoomID:      room.ID,
+			UserID:      userID,
+			Type:        "pin_removed",
+			MessageID:   messageID,
+			PinsVersion: change.Version,
+			EventSeq:    change.EventSeq,
+		},
+	})
+	writeJSON(w, http.StatusOK, pinsVersionResponse{Version: change.Version})
+}
+
+// reorderPinsHandler sets the order of the room's pinned bar
+// The list must contain exactly the pinned messages, and version must be the
+// current pins_version; otherwise 409 and the client should reload the pins
+// PUT /v1/rooms/{roomID}/pins/order
+// Requires authentication and pin_message (by default the owner and admins)
+// Request body: {"message_ids": [31, 12, 20], "version": 6}
+// Response: {"pins_version": 7}
+func (app *application) reorderPinsHandler(w http.ResponseWriter, r *http.Request) {
+	room, userID, ok := app.pinManager(w, r)
+	if !ok {
+		return
+	}
+
+	var req PinOrderRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	if req.MessageIDs == nil {
+		req.MessageIDs = []int64{}
+	}
+
+	change, err := app.store.Pins.Reorder(r.Context(), room.ID, req.MessageIDs, req.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+		case errors.Is(err, store.ErrPinsVersionConflict):
+			writeError(w, r, http.StatusConflict, "pins_version_conflict")
+		case errors.Is(err, store.ErrPinSetMismatch):
+			writeError(w, r, http.StatusConflict, "pins_set_mismatch")
+		default:
+			writeError(w, r, http.StatusInternalServerError, "pin_update_failed")
+		}
+		return
+	}
+
+	app.hub.Announce(&websocket.Message{
+		Message: wire.Message{
+			RoomID:      room.ID,
+			UserID:      userID,
+			Type:        "pin_order_changed",
+			PinnedIDs:   req.MessageIDs,
+			PinsVersion: change.Version,
+			EventSeq:    change.EventSeq,
+		},
+	})
+	writeJSON(w, http.StatusOK, pinsVersionResponse{Version: change.Version})
+}
+
+// pinManager loads the room from the URL and checks the user may manage its pins
+// It writes the error response itself and returns false if a check fails
+func (app *application) pinManager(w http.ResponseWriter, r *http.Request) (*store.Room, int64, bool) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return nil, 0, false
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return nil, 0, false
+	}
+
+	room, err := app.store.Rooms.GetByID(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+			return nil, 0, false
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_verify_failed")
+		return nil, 0, false
+	}
+
+	if !app.requireRoomPermission(w, r, room.ID, userID, store.CapPinMessage) {
+		return nil, 0, false
+	}
+	return room, userID, true
+}
@@ -0,0 +1,75 @@
+package chatapi
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestReorderPins has ada, the room's admin, pin three messages and
+// reorder them: grace sees the new order live and the list comes back in it
+// A reorder from a stale list, as when a pin is removed mid-flight, gets 409
+func TestReorderPins(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "lobby", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	server := newTestServer(t, ts)
+	grace := dialRoom(t, server, 1, 2)
+	readFrame(t, grace, "join")
+
+	base := server.URL + "/v1/rooms/1/pins"
+	for _, id := range []string{"10", "11", "12"} {
+		if status := doJSON(t, http.MethodPost, base+"/"+id, 1, nil, nil); status != http.StatusOK {
+			t.Fatalf("pinning %s got %d, want 200", id, status)
+		}
+		readFrame(t, grace, "pin_added")
+	}
+	if status := doJSON(t, http.MethodPost, base+"/10", 2, nil, nil); status != http.StatusForbidden {
+		t.Errorf("grace pinning got %d, want 403", status)
+	}
+
+	var loaded pinsResponse
+	doJSON(t, http.MethodGet, base, 2, nil, &loaded)
+
+	var changed pinsVersionResponse
+	order := PinOrderRequest{MessageIDs: []int64{12, 10, 11}, Version: loaded.Version}
+	if status := doJSON(t, http.MethodPut, base+"/order", 1, order, &changed); status != http.StatusOK {
+		t.Fatalf("reordering got %d, want 200", status)
+	}
+	frame := readFrame(t, grace, "pin_order_changed")
+	if !slices.Equal(frame.PinnedIDs, order.MessageIDs) || frame.PinsVersion != changed.Version {
+		t.Errorf("grace was sent %v at version %d, want %v at %d", frame.PinnedIDs, frame.PinsVersion, order.MessageIDs, changed.Version)
+	}
+
+	var listed pinsResponse
+	doJSON(t, http.MethodGet, base, 2, nil, &listed)
+	var ids []int64
+	for _, pin := range listed.Pins {
+		ids = append(ids, pin.MessageID)
+	}
+	if !slices.Equal(ids, order.MessageIDs) || listed.Version != changed.Version {
+		t.Errorf("the pins are listed as %v at version %d, want %v at %d", ids, listed.Version, order.MessageIDs, changed.Version)
+	}
+
+	// 12 is unpinned while an old copy of the list is being reordered
+	if status := doJSON(t, http.MethodDelete, base+"/12", 1, nil, nil); status != http.StatusOK {
+		t.Fatalf("unpinning got %d, want 200", status)
+	}
+	for _, tc := range []struct {
+		name  string
+		order PinOrderRequest
+		code  string
+	}{
+		{"stale version", PinOrderRequest{MessageIDs: []int64{10, 11, 12}, Version: changed.Version}, "pins_version_conflict"},
+		{"unpinned message listed", PinOrderRequest{MessageIDs: []int64{10, 11, 12}, Version: changed.Version + 1}, "pins_set_mismatch"},
+	} {
+		var failure errorBody
+		if status := doJSON(t, http.MethodPut, base+"/order", 1, tc.order, &failure); status != http.StatusConflict || failure.Code != tc.code {
+			t.Errorf("%s: got %d %q, want 409 %q", tc.name, status, failure.Code, tc.code)
+		}
+	}
+}
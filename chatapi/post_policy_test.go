@@ -0,0 +1,60 @@
+package chatapi
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestAnnouncementRoom has the owner make room 1 admins_only while grace and
+// linus are connected: both are told, grace's messages are refused over
+// WebSocket and REST while they can still page the history, and ada, an
+// admin, still posts
+func TestAnnouncementRoom(t *testing.T) {
+	server, ts, conns := roomMentionServer(t)
+
+	policy := "bogus"
+	if status := doJSON(t, http.MethodPatch, server.URL+"/v1/rooms/1", 9, RoomSettings{PostPolicy: &policy}, nil); status != http.StatusBadRequest {
+		t.Errorf("an unknown post policy got %d, want 400", status)
+	}
+	policy = store.PostPolicyAdminsOnly
+	if status := doJSON(t, http.MethodPatch, server.URL+"/v1/rooms/1", 2, RoomSettings{PostPolicy: &policy}, nil); status != http.StatusForbidden {
+		t.Errorf("a member changing the post policy got %d, want 403", status)
+	}
+	var room store.Room
+	if status := doJSON(t, http.MethodPatch, server.URL+"/v1/rooms/1", 9, RoomSettings{PostPolicy: &policy}, &room); status != http.StatusOK || room.PostPolicy != policy {
+		t.Fatalf("making the room admins_only got %d with post_policy %q", status, room.PostPolicy)
+	}
+	for name, conn := range conns {
+		if frame := readFrame(t, conn, "room_updated"); frame.PostPolicy != policy {
+			t.Errorf("%s was told the post policy is %q, want %q", name, frame.PostPolicy, policy)
+		}
+	}
+
+	if err := conns["grace"].WriteJSON(map[string]string{"content": "can I still talk?"}); err != nil {
+		t.Fatal(err)
+	}
+	if frame := readFrame(t, conns["grace"], "error"); frame.Code != "room_announcement_only" {
+		t.Errorf("a member's message got %q, want room_announcement_only", frame.Code)
+	}
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/messages", 2, SendMessageRequest{Content: "over REST then"}, nil); status != http.StatusForbidden {
+		t.Errorf("a member's message over REST got %d, want 403", status)
+	}
+	if err := conns["grace"].WriteJSON(map[string]any{"type": "history_request", "room_id": 1, "req_id": "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if frame := readFrame(t, conns["grace"], "history_response"); frame.ReqID != "1" {
+		t.Errorf("the history response has req_id %q, want 1", frame.ReqID)
+	}
+
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/messages", 1, SendMessageRequest{Content: "release at 5"}, nil); status != http.StatusCreated {
+		t.Errorf("an admin's message got %d, want 201", status)
+	}
+	if frame := readFrame(t, conns["linus"], "message"); frame.Content != "release at 5" {
+		t.Errorf("linus got %q, want the admin's message", frame.Content)
+	}
+	if messages, _ := ts.messages.GetRoomMessages(t.Context(), 1, 100); len(messages) != 1 {
+		t.Errorf("%d messages were saved, want only the admin's", len(messages))
+	}
+}
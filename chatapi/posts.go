@@ -0,0 +1,236 @@
+package chatapi
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+const (
+	// defaultPostsLimit is the page size used when ?limit is not given
+	defaultPostsLimit = 20
+
+	// maxPostsLimit caps the page size so one request can't pull the whole table
+	maxPostsLimit = 100
+
+	// maxPostTags is the most tags a single post may carry
+	maxPostTags = 10
+)
+
+// PostRequest represents the JSON structure for creating or updating a post
+// On update, fields left out of the body keep their current value
+type PostRequest struct {
+	Title   *string  `json:"title"`
+	Content *string  `json:"content"`
+	Tags    []string `json:"tags"`
+}
+
+// validatePost checks a post before it's written to the database
+// It returns a catalog error code, or "" if the post is valid
+func validatePost(post *store.Post) string {
+	post.Title = strings.TrimSpace(post.Title)
+	if post.Title == "" || strings.TrimSpace(post.Content) == "" {
+		return "post_fields_required"
+	}
+	if len(post.Title) > 255 {
+		return "post_title_too_long"
+	}
+	if len(post.Tags) > maxPostTags {
+		return "post_too_many_tags"
+	}
+	return ""
+}
+
+// getPostOr404 loads the post named in the URL, writing the error response if it can't
+func (app *application) getPostOr404(w http.ResponseWriter, r *http.Request) (*store.Post, bool) {
+	postID, err := extractIDFromURL(r, "postID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "postID")
+		return nil, false
+	}
+
+	post, err := app.store.Posts.GetByID(r.Context(), postID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "post_not_found")
+			return nil, false
+		}
+		writeError(w, r, http.StatusInternalServerError, "post_lookup_failed")
+		return nil, false
+	}
+	return post, true
+}
+
+// createPostHandler creates a new post owned by the current user
+// POST /v1/posts
+// Requires authentication
+// Request body: {"title": "Release notes", "content": "...", "tags": ["news"]}
+// Response: {"id": 1, "title": "Release notes", ...}
+func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	var req PostRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	post := &store.Post{UserID: userID, Tags: req.Tags}
+	if req.Title != nil {
+		post.Title = *req.Title
+	}
+	if req.Content != nil {
+		post.Content = *req.Content
+	}
+	if code := validatePost(post); code != "" {
+		writeError(w, r, http.StatusBadRequest, code)
+		return
+	}
+
+	if err := app.store.Posts.Create(r.Context(), post); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "post_create_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, post)
+}
+
+// listPostsHandler returns a page of posts, newest first
+// GET /v1/posts?limit=20&offset=0
+// Requires authentication
+// Response: [{"id": 2, "title": "...", ...}, {"id": 1, ...}]
+func (app *application) listPostsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := defaultPostsLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxPostsLimit {
+			writeError(w, r, http.StatusBadRequest, "invalid_pagination")
+			return
+		}
+		limit = n
+	}
+
+	offset := 0
+	if raw := r.URL.Query().Get("offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid_pagination")
+			return
+		}
+		offset = n
+	}
+
+	posts, err := app.store.Posts.List(r.Context(), limit, offset)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "posts_lookup_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, posts)
+}
+
+// getPostHandler returns a single post
+// GET /v1/posts/{postID}
+// Requires authentication
+// Response: {"id": 1, "title": "...", ...}
+func (app *application) getPostHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := app.getPostOr404(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, post)
+}
+
+// updatePostHandler edits a post
+// PATCH /v1/posts/{postID}
+// Requires authentication; only the author may edit a post
+// Request body: {"title": "...", "content": "...", "tags": [...]} (all optional)
+// Response: {"id": 1, "title": "...", ...}
+func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	var req PostRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	post, ok := app.getPostOr404(w, r)
+	if !ok {
+		return
+	}
+
+	if post.UserID != userID {
+		writeError(w, r, http.StatusForbidden, "post_author_only")
+		return
+	}
+
+	// Apply only the fields that were provided
+	if req.Title != nil {
+		post.Title = *req.Title
+	}
+	if req.Content != nil {
+		post.Content = *req.Content
+	}
+	if req.Tags != nil {
+		post.Tags = req.Tags
+	}
+	if code := validatePost(post); code != "" {
+		writeError(w, r, http.StatusBadRequest, code)
+		return
+	}
+
+	if err := app.store.Posts.Update(r.Context(), post); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// Deleted between the lookup and the update
+			writeError(w, r, http.StatusNotFound, "post_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "post_update_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, post)
+}
+
+// deletePostHandler removes a post
+// DELETE /v1/posts/{postID}
+// Requires authentication; only the author may delete a post
+// Response: 204 No Content
+func (app *application) deletePostHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	post, ok := app.getPostOr404(w, r)
+	if !ok {
+		return
+	}
+
+	if post.UserID != userID {
+		writeError(w, r, http.StatusForbidden, "post_author_only")
+		return
+	}
+
+	if err := app.store.Posts.Delete(r.Context(), post.ID); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "post_delete_failed")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,103 @@
+package chatapi
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestPostsCRUD writes, reads, edits and deletes a post: only its author
+// may change it, edits keep the fields left out, and every route needs a
+// signed-in user
+func TestPostsCRUD(t *testing.T) {
+	ts := newTestStore(t)
+	server := newTestServer(t, ts)
+
+	var post store.Post
+	body := map[string]any{"title": "  Release notes ", "content": "v2 is out", "tags": []string{"news"}}
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/posts", 1, body, &post); status != http.StatusCreated {
+		t.Fatalf("creating got %d, want 201", status)
+	}
+	if post.Title != "Release notes" || post.UserID != 1 {
+		t.Errorf("created %+v, want ada's trimmed title", post)
+	}
+	url := fmt.Sprintf("%s/v1/posts/%d", server.URL, post.ID)
+
+	var failure errorBody
+	for _, tc := range []struct {
+		method string
+		userID int64
+		body   any
+		status int
+		code   string
+	}{
+		{http.MethodGet, 0, nil, http.StatusUnauthorized, "missing_authorization_header"},
+		{http.MethodPatch, 2, map[string]any{"title": "mine"}, http.StatusForbidden, "post_author_only"},
+		{http.MethodDelete, 2, nil, http.StatusForbidden, "post_author_only"},
+		{http.MethodPatch, 1, map[string]any{"title": " "}, http.StatusBadRequest, "post_fields_required"},
+		{http.MethodPatch, 1, map[string]any{"tags": make([]string, maxPostTags+1)}, http.StatusBadRequest, "post_too_many_tags"},
+	} {
+		failure = errorBody{}
+		if status := doJSON(t, tc.method, url, tc.userID, tc.body, &failure); status != tc.status || failure.Code != tc.code {
+			t.Errorf("%s by user %d got %d %q, want %d %q", tc.method, tc.userID, status, failure.Code, tc.status, tc.code)
+		}
+	}
+
+	var edited store.Post
+	if status := doJSON(t, http.MethodPatch, url, 1, map[string]any{"content": "v2.1 is out"}, &edited); status != http.StatusOK {
+		t.Fatalf("editing got %d, want 200", status)
+	}
+	if edited.Title != "Release notes" || edited.Content != "v2.1 is out" || len(edited.Tags) != 1 {
+		t.Errorf("edited into %+v, want new content and the rest kept", edited)
+	}
+
+	if status := doJSON(t, http.MethodDelete, url, 1, nil, nil); status != http.StatusNoContent {
+		t.Errorf("deleting got %d, want 204", status)
+	}
+	if status := doJSON(t, http.MethodGet, url, 2, nil, &failure); status != http.StatusNotFound || failure.Code != "post_not_found" {
+		t.Errorf("reading a deleted post got %d %q, want 404 post_not_found", status, failure.Code)
+	}
+}
+
+// TestListPosts pages through posts newest first, rejecting pages that are
+// too large or negative
+func TestListPosts(t *testing.T) {
+	ts := newTestStore(t)
+	for id := int64(1); id <= 25; id++ {
+		ts.posts.add(&store.Post{ID: id, Title: fmt.Sprintf("post %d", id), Content: "x", UserID: 1})
+	}
+	server := newTestServer(t, ts)
+
+	for _, tc := range []struct {
+		query   string
+		status  int
+		count   int
+		firstID int64
+	}{
+		{"", http.StatusOK, defaultPostsLimit, 25},
+		{"?limit=10&offset=20", http.StatusOK, 5, 5},
+		{"?offset=30", http.StatusOK, 0, 0},
+		{"?limit=0", http.StatusBadRequest, 0, 0},
+		{fmt.Sprintf("?limit=%d", maxPostsLimit+1), http.StatusBadRequest, 0, 0},
+		{"?offset=-1", http.StatusBadRequest, 0, 0},
+		{"?limit=ten", http.StatusBadRequest, 0, 0},
+	} {
+		var posts []*store.Post
+		var failure errorBody
+		var out any = &posts
+		if tc.status != http.StatusOK {
+			out = &failure
+		}
+		status := doJSON(t, http.MethodGet, server.URL+"/v1/posts"+tc.query, 1, nil, out)
+		switch {
+		case status != tc.status:
+			t.Errorf("%q got %d, want %d", tc.query, status, tc.status)
+		case status != http.StatusOK && failure.Code != "invalid_pagination":
+			t.Errorf("%q was refused with %q, want invalid_pagination", tc.query, failure.Code)
+		case status == http.StatusOK && (len(posts) != tc.count || (tc.count > 0 && posts[0].ID != tc.firstID)):
+			t.Errorf("%q got %d posts, want %d starting at post %d", tc.query, len(posts), tc.count, tc.firstID)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package chatapi
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/drazan344/go-chat/internal/notify"
+	"github.com/drazan344/go-chat/internal/push"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/websocket"
+)
+
+// PushTokenRequest represents the JSON structure for registering a push token
+type PushTokenRequest struct {
+	Platform string `json:"platform"` // "apns", "fcm" or "webpush"
+	Token    string `json:"token"`
+}
+
+// startPushNotifier subscribes push notifications to the hub's events
+// Does nothing when no provider is configured
+func startPushNotifier(hub *websocket.Hub, st store.Storage, policy notify.Policy, cfg pushConfig) error {
+	var provider push.Provider
+	switch cfg.provider {
+	case "":
+		return nil
+	case "log":
+		provider = push.LogProvider{}
+	default:
+		return fmt.Errorf("unknown push provider %q", cfg.provider)
+	}
+
+	push.NewNotifier(provider, st, hub, policy, cfg.maxFailures).Register(hub.Hooks())
+	return nil
+}
+
+// setPushTokenHandler registers the push token of the calling device
+// Registering again replaces the token and re-enables a disabled one
+// POST /v1/devices/push-token
+// Requires authentication and the X-Device-ID header
+// Request body: {"platform": "fcm", "token": "..."}
+// Response: {"id": 3, "device_id": 7, "platform": "fcm", ...}
+func (app *application) setPushTokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	deviceID, ok := app.deviceFromRequest(w, r, userID)
+	if !ok {
+		return
+	}
+	if deviceID == 0 {
+		writeError(w, r, http.StatusBadRequest, "device_required")
+		return
+	}
+
+	var req PushTokenRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	token := &store.PushToken{
+		DeviceID: deviceID,
+		UserID:   userID,
+		Platform: req.Platform,
+		Token:    strings.TrimSpace(req.Token),
+	}
+	if !store.ValidPushPlatform(token.Platform) {
+		writeError(w, r, http.StatusBadRequest, "invalid_push_platform")
+		return
+	}
+	// Real tokens are a few hundred bytes at most (web push subscriptions are the longest)
+	if token.Token == "" || len(token.Token) > 4096 {
+		writeError(w, r, http.StatusBadRequest, "invalid_push_token")
+		return
+	}
+
+	if err := app.store.PushTokens.Upsert(r.Context(), token); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "push_token_save_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, token)
+}
+
+// deletePushTokenHandler stops push notifications to the calling device
+// DELETE /v1/devices/push-token
+// Requires authentication and the X-Device-ID header
+// Response: 204 No Content
+func (app *application) deletePushTokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	deviceID, ok := app.deviceFromRequest(w, r, userID)
+	if !ok {
+		return
+	}
+	if deviceID == 0 {
+		writeError(w, r, http.StatusBadRequest, "device_required")
+		return
+	}
+
+	if err := app.store.PushTokens.Delete(r.Context(), deviceID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "push_token_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "push_token_delete_failed")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
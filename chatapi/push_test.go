@@ -0,0 +1,67 @@
+package chatapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestPushTokenHandlers sets and removes a device's push token: it needs a
+// device of the caller's own, a known platform and a token, and the token
+// is never echoed back
+func TestPushTokenHandlers(t *testing.T) {
+	ts := newTestStore(t)
+	ts.devices.add(7, 2)
+	ts.devices.add(8, 3)
+	server := newTestServer(t, ts)
+	url := server.URL + "/v1/devices/push-token"
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		device string
+		body   PushTokenRequest
+		status int
+		code   string
+	}{
+		{"no device", http.MethodPost, "", PushTokenRequest{Platform: "fcm", Token: "abc"}, http.StatusBadRequest, "device_required"},
+		{"another user's device", http.MethodPost, "8", PushTokenRequest{Platform: "fcm", Token: "abc"}, http.StatusNotFound, "device_not_found"},
+		{"unknown platform", http.MethodPost, "7", PushTokenRequest{Platform: "pager", Token: "abc"}, http.StatusBadRequest, "invalid_push_platform"},
+		{"blank token", http.MethodPost, "7", PushTokenRequest{Platform: "fcm", Token: "  "}, http.StatusBadRequest, "invalid_push_token"},
+		{"huge token", http.MethodPost, "7", PushTokenRequest{Platform: "fcm", Token: strings.Repeat("x", 4097)}, http.StatusBadRequest, "invalid_push_token"},
+		{"setting", http.MethodPost, "7", PushTokenRequest{Platform: "fcm", Token: "abc"}, http.StatusOK, ""},
+		{"replacing", http.MethodPost, "7", PushTokenRequest{Platform: "apns", Token: "def"}, http.StatusOK, ""},
+		{"deleting", http.MethodDelete, "7", PushTokenRequest{}, http.StatusNoContent, ""},
+		{"deleting again", http.MethodDelete, "7", PushTokenRequest{}, http.StatusNotFound, "push_token_not_found"},
+	} {
+		var response json.RawMessage
+		status := doJSONWithHeaders(t, tc.method, url, 2, map[string]string{deviceHeader: tc.device}, tc.body, &response)
+		var failure errorBody
+		json.Unmarshal(response, &failure)
+		if status != tc.status || failure.Code != tc.code {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, status, failure.Code, tc.status, tc.code)
+		}
+		if status == http.StatusOK && strings.Contains(string(response), tc.body.Token) {
+			t.Errorf("%s: the response echoes the token: %s", tc.name, response)
+		}
+		if tc.name == "replacing" {
+			if token := ts.pushTokens.tokens[7]; token.Platform != "apns" || token.Token != "def" {
+				t.Errorf("the device's token is %+v after replacing", token)
+			}
+		}
+	}
+}
+
+// TestStartPushNotifier checks PUSH_PROVIDER: unset turns push off, log
+// selects the stub, and anything else stops startup
+func TestStartPushNotifier(t *testing.T) {
+	ts := newTestStore(t)
+	app := newTestApp(ts)
+	for provider, wantErr := range map[string]bool{"": false, "log": false, "apns": true} {
+		err := startPushNotifier(app.hub, ts.Storage, app.notifications, pushConfig{provider: provider, maxFailures: 5})
+		if (err != nil) != wantErr {
+			t.Errorf("provider %q got %v, want error %v", provider, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package chatapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestQuietHours has ada, the room's creator, put the room into quiet
+// hours around the clock: grace, a member, is refused with 423 while ada's
+// message goes through marked as overriding them. Clearing the quiet hours
+// with null lets grace post again straight away
+func TestQuietHours(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	server := newTestServer(t, ts)
+	room := server.URL + "/v1/rooms/1"
+
+	var updated store.Room
+	allDay := RoomSettings{QuietHours: json.RawMessage(`{"start": "00:00", "end": "00:00", "timezone": "Europe/Berlin"}`)}
+	if status := doJSON(t, http.MethodPatch, room, 1, allDay, &updated); status != http.StatusOK {
+		t.Fatalf("setting quiet hours got %d, want 200", status)
+	}
+	// Whether they're in force now is worked out by the store, and tested there
+	if updated.QuietHours == nil || updated.QuietHours.Timezone != "Europe/Berlin" {
+		t.Errorf("the room came back with quiet hours %+v", updated.QuietHours)
+	}
+
+	body := SendMessageRequest{Content: "anyone?"}
+	var failure errorBody
+	if status := doJSON(t, http.MethodPost, room+"/messages", 2, body, &failure); status != http.StatusLocked || failure.Code != "room_quiet_hours" {
+		t.Errorf("grace posting got %d %q, want 423 room_quiet_hours", status, failure.Code)
+	}
+	var sent store.Message
+	if status := doJSON(t, http.MethodPost, room+"/messages", 1, body, &sent); status != http.StatusCreated || !sent.Override {
+		t.Errorf("ada posting got %d with override %v, want 201 overriding", status, sent.Override)
+	}
+
+	clear := RoomSettings{QuietHours: json.RawMessage(`null`)}
+	if status := doJSON(t, http.MethodPatch, room, 1, clear, nil); status != http.StatusOK {
+		t.Fatalf("clearing quiet hours got %d, want 200", status)
+	}
+	var later store.Message
+	if status := doJSON(t, http.MethodPost, room+"/messages", 2, body, &later); status != http.StatusCreated || later.Override {
+		t.Errorf("grace posting afterwards got %d with override %v, want 201", status, later.Override)
+	}
+	if got, _ := ts.rooms.GetByID(context.Background(), 1); got.QuietHours != nil {
+		t.Errorf("the room still has quiet hours %+v", got.QuietHours)
+	}
+}
+
+// TestQuietHoursValidation refuses malformed windows without touching the room
+func TestQuietHoursValidation(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	server := newTestServer(t, ts)
+
+	for _, raw := range []string{
+		`{"start": "9am", "end": "17:00"}`,
+		`{"start": "09:00", "end": "17:00", "timezone": "Mars/Olympus"}`,
+		`{"start": "09:00", "end": "17:00", "days": ["funday"]}`,
+		`"evenings"`,
+	} {
+		var failure errorBody
+		body := RoomSettings{QuietHours: json.RawMessage(raw)}
+		if status := doJSON(t, http.MethodPatch, server.URL+"/v1/rooms/1", 1, body, &failure); status != http.StatusBadRequest || failure.Code != "invalid_quiet_hours" {
+			t.Errorf("%s got %d %q, want 400 invalid_quiet_hours", raw, status, failure.Code)
+		}
+	}
+	if got, _ := ts.rooms.GetByID(context.Background(), 1); got.QuietHours != nil {
+		t.Errorf("the room has quiet hours %+v after only bad updates", got.QuietHours)
+	}
+}
@@ -0,0 +1,89 @@
+package chatapi
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter allows each key (a user, a token, an IP) a fixed number of requests per window
+// Windows are fixed rather than sliding: simple, and good enough to make
+// scraping slow without getting in the way of a person typing
+type rateLimiter[K comparable] struct {
+	mu      sync.Mutex
+	windows map[K]*rateWindow
+	limit   int           // Guarded by mu, changed by configure
+	window  time.Duration // Guarded by mu, changed by configure
+
+	lastSweep time.Time // When expired windows were last dropped
+}
+
+// rateLimiterSweepSize is how many keys a limiter holds before it starts
+// dropping expired windows
+const rateLimiterSweepSize = 10000
+
+// rateWindow counts one key's requests in its current window
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// newRateLimiter creates a limiter allowing limit requests per key per window
+// A limit of zero or less disables it
+func newRateLimiter[K comparable](limit int, window time.Duration) *rateLimiter[K] {
+	return &rateLimiter[K]{
+		windows: make(map[K]*rateWindow),
+		limit:   limit,
+		window:  window,
+	}
+}
+
+// configure changes the limit and window, e.g. on a configuration reload
+// Counts so far are kept; a new window length applies from each key's next window
+func (l *rateLimiter[K]) configure(limit int, window time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = limit
+	l.window = window
+}
+
+// allow records a request for key and reports whether it's within the limit
+// When it isn't, retryAfter is how long until the key's window resets
+func (l *rateLimiter[K]) allow(key K) (ok bool, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limit <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	w := l.windows[key]
+	if w == nil || now.Sub(w.start) >= l.window {
+		// Drop expired windows now and then so the map doesn't keep every key ever seen
+		// At most once per window: a sweep takes every window that has ended,
+		// so sweeping again sooner would walk the whole map to find few or none
+		if len(l.windows) > rateLimiterSweepSize && now.Sub(l.lastSweep) >= l.window {
+			l.sweep(now)
+		}
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+
+	if w.count >= l.limit {
+		return false, w.start.Add(l.window).Sub(now)
+	}
+	w.count++
+	return true, 0
+}
+
+// sweep removes windows that have ended
+// Must be called with mu held
+func (l *rateLimiter[K]) sweep(now time.Time) {
+	l.lastSweep = now
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= l.window {
+			delete(l.windows, key)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package chatapi
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRateLimiter allows each key its own limit per window, and everything
+// once the window has passed or when the limit is zero
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter[int64](2, 50*time.Millisecond)
+	for i := range 2 {
+		if ok, _ := limiter.allow(1); !ok {
+			t.Fatalf("request %d was refused", i+1)
+		}
+	}
+	ok, retryAfter := limiter.allow(1)
+	if ok || retryAfter <= 0 || retryAfter > 50*time.Millisecond {
+		t.Errorf("the third request got %v, retry after %v", ok, retryAfter)
+	}
+	if ok, _ := limiter.allow(2); !ok {
+		t.Error("another key was refused")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if ok, _ := limiter.allow(1); !ok {
+		t.Error("a request after the window was refused")
+	}
+
+	disabled := newRateLimiter[int64](0, time.Minute)
+	for range 100 {
+		if ok, _ := disabled.allow(1); !ok {
+			t.Fatal("a disabled limiter refused a request")
+		}
+	}
+}
+
+// TestRateLimiterSweep drops expired windows once the limiter holds many
+// keys, but walks them at most once per window however many new keys arrive
+func TestRateLimiterSweep(t *testing.T) {
+	limiter := newRateLimiter[int](1, time.Minute)
+	for key := range rateLimiterSweepSize + 1 {
+		limiter.allow(key)
+	}
+	if !limiter.lastSweep.IsZero() {
+		t.Fatal("swept before any window had ended")
+	}
+
+	// End every window without waiting for them
+	for _, w := range limiter.windows {
+		w.start = w.start.Add(-time.Minute)
+	}
+	limiter.allow(-1)
+	swept := limiter.lastSweep
+	if swept.IsZero() || len(limiter.windows) != 1 {
+		t.Fatalf("after the windows ended, %d keys were left", len(limiter.windows))
+	}
+
+	for key := range 2 * rateLimiterSweepSize {
+		limiter.allow(rateLimiterSweepSize + key)
+	}
+	if limiter.lastSweep != swept {
+		t.Error("swept again within the same window")
+	}
+	if ok, _ := limiter.allow(-1); ok {
+		t.Error("a key over its limit was allowed after a sweep")
+	}
+}
@@ -0,0 +1,103 @@
+package chatapi
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parseTrustedProxies parses TRUSTED_PROXIES: comma-separated IP addresses
+// and CIDR ranges of the reverse proxies in front of the server
+func parseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, raw := range strings.Split(list, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		if strings.Contains(raw, "/") {
+			prefix, err := netip.ParsePrefix(raw)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR range %q", raw)
+			}
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address %q", raw)
+		}
+		proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return proxies, nil
+}
+
+// trustedProxy reports whether an address belongs to one of TRUSTED_PROXIES
+func (app *application) trustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range app.config.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// realIP replaces RemoteAddr with the client's address as reported by a
+// trusted proxy in X-Forwarded-For or X-Real-IP
+// Anyone can send those headers, so they're only read when the connection
+// itself comes from TRUSTED_PROXIES; otherwise the socket peer is the client.
+// The per-IP limits (guest connections, WebSocket handshakes) count whatever
+// this leaves in RemoteAddr
+func (app *application) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if peer, err := netip.ParseAddrPort(r.RemoteAddr); err == nil && app.trustedProxy(peer.Addr()) {
+			if client, ok := app.forwardedFor(r); ok {
+				r.RemoteAddr = client.String()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedFor returns the client address trusted proxies reported for r
+// X-Forwarded-For is read from the right, skipping our own proxies: the
+// entries to the left of the first untrusted one were written by the client
+// and could be anything
+func (app *application) forwardedFor(r *http.Request) (netip.Addr, bool) {
+	if header := r.Header.Values("X-Forwarded-For"); len(header) > 0 {
+		hops := strings.Split(strings.Join(header, ","), ",")
+		var client netip.Addr
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			client = addr.Unmap()
+			if !app.trustedProxy(client) {
+				break
+			}
+		}
+		return client, client.IsValid()
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// clientIP returns the caller's IP address without the port
+// RemoteAddr is the socket peer, or the client a trusted proxy reported (see
+// realIP)
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// realIP sets RemoteAddr to a bare IP, which SplitHostPort rejects
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,54 @@
+package chatapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestRealIP believes forwarded addresses only from TRUSTED_PROXIES, and
+// reads X-Forwarded-For from the right so a client can't prepend its own
+func TestRealIP(t *testing.T) {
+	proxies, err := parseTrustedProxies(" 10.0.0.0/8, 192.168.1.7 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &application{config: config{trustedProxies: proxies}}
+	var got string
+	handler := app.realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = clientIP(r)
+	}))
+
+	for _, tc := range []struct {
+		name    string
+		peer    string
+		headers map[string]string
+		want    string
+	}{
+		{"a client straight to the server", "203.0.113.5:4000", nil, "203.0.113.5"},
+		{"a client claiming an address", "203.0.113.5:4000", map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.2"}, "203.0.113.5"},
+		{"a trusted proxy", "10.1.2.3:4000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"two trusted proxies", "192.168.1.7:4000", map[string]string{"X-Forwarded-For": "198.51.100.1, 10.9.9.9"}, "198.51.100.1"},
+		{"a client prepending an address", "10.1.2.3:4000", map[string]string{"X-Forwarded-For": "198.51.100.99, 198.51.100.1"}, "198.51.100.1"},
+		{"X-Real-IP from a trusted proxy", "10.1.2.3:4000", map[string]string{"X-Real-IP": "198.51.100.1"}, "198.51.100.1"},
+		{"a trusted proxy without headers", "10.1.2.3:4000", nil, "10.1.2.3"},
+		{"a garbled header", "10.1.2.3:4000", map[string]string{"X-Forwarded-For": "not an address"}, "10.1.2.3"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.peer
+		for name, value := range tc.headers {
+			r.Header.Set(name, value)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		if got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+
+	if _, err := parseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("an invalid range was accepted")
+	}
+	if _, err := parseTrustedProxies("proxy.internal"); err == nil {
+		t.Error("a host name was accepted")
+	}
+}
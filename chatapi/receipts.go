@@ -0,0 +1,70 @@
+package chatapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// getMessageReceiptsHandler returns delivery and read receipts for one message
+// GET /v1/rooms/{roomID}/messages/{messageID}/receipts
+// Requires authentication and room membership
+// Response: {"message_id": 42, "delivered_count": 3, "read_count": 1, "delivered_to": [2, 5, 7], "read_by": [5]}
+func (app *application) getMessageReceiptsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	messageID, err := extractIDFromURL(r, "messageID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "messageID")
+		return
+	}
+
+	isMember, err := app.store.RoomMembers.IsUserInRoom(r.Context(), roomID, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "membership_check_failed")
+		return
+	}
+	if !isMember {
+		writeError(w, r, http.StatusForbidden, "membership_required_messages")
+		return
+	}
+
+	receipts, err := app.store.Receipts.GetReceipts(r.Context(), roomID, messageID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "message_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "receipts_lookup_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, receipts)
+}
+
+// markDeliveredLatest advances a user's delivery pointer to the newest message in a room
+// Called when a user connects or loads history, which is how messages sent
+// while they were offline get delivered
+// Failures are only logged: receipts are informational and shouldn't fail the request
+func (app *application) markDeliveredLatest(roomID, userID int64) {
+	// Not tied to the request context, which ends when a WebSocket upgrade hijacks it
+	ctx, cancel := context.WithTimeout(app.ctx, 5*time.Second)
+	defer cancel()
+
+	if err := app.store.Receipts.MarkDeliveredLatest(ctx, roomID, userID); err != nil {
+		log.Printf("Failed to mark messages delivered: user=%d room=%d: %v", userID, roomID, err)
+	}
+}
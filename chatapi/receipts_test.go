@@ -0,0 +1,72 @@
+package chatapi
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+)
+
+// TestMessageReceipts reads a message's receipts: only room members may,
+// and a message that isn't in the room is 404
+func TestMessageReceipts(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.receipts.receipts[[2]int64{1, 9}] = &store.MessageReceipts{
+		MessageID: 9, DeliveredCount: 1, DeliveredTo: []int64{2}, ReadBy: []int64{},
+	}
+	server := newTestServer(t, ts)
+
+	for _, tc := range []struct {
+		path   string
+		userID int64
+		status int
+		code   string
+	}{
+		{"/v1/rooms/1/messages/9/receipts", 3, http.StatusForbidden, "membership_required_messages"},
+		{"/v1/rooms/1/messages/8/receipts", 1, http.StatusNotFound, "message_not_found"},
+		{"/v1/rooms/1/messages/x/receipts", 1, http.StatusBadRequest, "invalid_id_parameter"},
+	} {
+		var failure errorBody
+		if status := doJSON(t, http.MethodGet, server.URL+tc.path, tc.userID, nil, &failure); status != tc.status || failure.Code != tc.code {
+			t.Errorf("user %d reading %s got %d %q, want %d %q", tc.userID, tc.path, status, failure.Code, tc.status, tc.code)
+		}
+	}
+
+	var receipts store.MessageReceipts
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/rooms/1/messages/9/receipts", 1, nil, &receipts); status != http.StatusOK {
+		t.Fatalf("got %d, want 200", status)
+	}
+	if receipts.DeliveredCount != 1 || !slices.Equal(receipts.DeliveredTo, []int64{2}) {
+		t.Errorf("got %+v, want it delivered to grace", receipts)
+	}
+}
+
+// TestOfflineDeliveryCatchesUp has users come back: loading history or
+// connecting moves their delivery pointer to the room's newest message
+func TestOfflineDeliveryCatchesUp(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	ts.messages.addMessages(1, 1, 3)
+	server := newTestServer(t, ts)
+
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/rooms/1/messages", 1, nil, nil); status != http.StatusOK {
+		t.Fatalf("loading history got %d, want 200", status)
+	}
+	if !ts.receipts.hasCaughtUp(1, 1) {
+		t.Error("loading history didn't mark the room delivered")
+	}
+
+	dialRoom(t, server, 1, 2)
+	if !testutil.WaitFor(5*time.Second, func() bool { return ts.receipts.hasCaughtUp(1, 2) }) {
+		t.Error("connecting didn't mark the room delivered")
+	}
+}
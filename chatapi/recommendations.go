@@ -0,0 +1,46 @@
+package chatapi
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const (
+	// defaultRecommendationsLimit is how many rooms are suggested when ?limit is not given
+	defaultRecommendationsLimit = 10
+
+	// maxRecommendationsLimit caps how many suggestions one request can ask for
+	maxRecommendationsLimit = 50
+)
+
+// recommendedRoomsHandler suggests rooms the user hasn't joined yet
+// Rooms are ranked by recent activity, size and how many of the user's
+// co-members already belong to them (see RoomStore.Recommend)
+// GET /v1/rooms/recommended?limit=10
+// Requires authentication
+// Response: [{"id": 3, "name": "golang", ..., "recent_messages": 120, "known_members": 3, "score": 8.1}]
+func (app *application) recommendedRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	limit := defaultRecommendationsLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxRecommendationsLimit {
+			writeError(w, r, http.StatusBadRequest, "invalid_pagination")
+			return
+		}
+		limit = n
+	}
+
+	recommendations, err := app.store.Rooms.Recommend(r.Context(), userID, limit)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "rooms_lookup_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, recommendations)
+}
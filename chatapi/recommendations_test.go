@@ -0,0 +1,44 @@
+package chatapi
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestRecommendedRoomsLimit checks ?limit: 10 suggestions by default, up
+// to 50 on request, and anything else is refused
+func TestRecommendedRoomsLimit(t *testing.T) {
+	ts := newTestStore(t)
+	for id := int64(1); id <= 12; id++ {
+		ts.rooms.add(&store.Room{ID: id, Name: fmt.Sprintf("room-%d", id), CreatedBy: 1, JoinPolicy: store.JoinPolicyOpen})
+	}
+	server := newTestServer(t, ts)
+
+	for _, tc := range []struct {
+		query  string
+		userID int64
+		status int
+		want   int
+	}{
+		{"", 2, http.StatusOK, 10},
+		{"?limit=3", 2, http.StatusOK, 3},
+		{"?limit=50", 2, http.StatusOK, 12},
+		{"?limit=0", 2, http.StatusBadRequest, 0},
+		{"?limit=51", 2, http.StatusBadRequest, 0},
+		{"?limit=ten", 2, http.StatusBadRequest, 0},
+		{"", 0, http.StatusUnauthorized, 0},
+	} {
+		var recommendations []store.RoomRecommendation
+		var out any = &recommendations
+		if tc.status != http.StatusOK {
+			out = nil
+		}
+		status := doJSON(t, http.MethodGet, server.URL+"/v1/rooms/recommended"+tc.query, tc.userID, nil, out)
+		if status != tc.status || len(recommendations) != tc.want {
+			t.Errorf("%q as user %d: got %d with %d rooms, want %d with %d", tc.query, tc.userID, status, len(recommendations), tc.status, tc.want)
+		}
+	}
+}
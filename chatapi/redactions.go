@@ -0,0 +1,178 @@
+package chatapi
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/websocket"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+const (
+	// defaultAdminMessagesLimit is the page size when ?limit is not given
+	defaultAdminMessagesLimit = 50
+
+	// maxAdminMessagesLimit caps how many messages one request can ask for
+	maxAdminMessagesLimit = 200
+
+	// maxRedactionReasonLength caps the reason recorded with a redaction
+	maxRedactionReasonLength = 1000
+)
+
+// AdminMessagesResponse is one page of the admin message view
+// NextCursor is empty on the last page
+type AdminMessagesResponse struct {
+	Messages   []*store.AdminMessage `json:"messages"`
+	NextCursor string                `json:"next_cursor,omitempty"`
+}
+
+// RedactMessageRequest is the body of a redaction: who is redacting and why
+type RedactMessageRequest struct {
+	Actor  string `json:"actor"`
+	Reason string `json:"reason"`
+}
+
+// adminMessagesHandler lists messages across every room for moderation,
+// newest first, without joining the rooms
+// Every filter is optional; q matches the current content, ignoring case
+// since and until take a date (2024-03-15) or an RFC 3339 time; until is exclusive
+// For the next page, pass next_cursor as ?cursor. Cursors are message IDs,
+// so pages stay stable while messages are sent or redacted
+// GET /v1/admin/messages?user_id=5&room_id=1&q=spam&since=2024-03-01&until=2024-03-15&limit=50&cursor=120
+// Requires the X-Ops-Token header
+// Response: {"messages": [{"id": 119, "room_id": 1, "room_name": "general", "content": "[removed by moderator]",
+// "redacted": true, "redaction": {"original_content": "...", "actor": "alice", "reason": "spam", ...}, ...}],
+// "next_cursor": "119"}
+func (app *application) adminMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := store.AdminMessageQuery{Q: query.Get("q"), Limit: defaultAdminMessagesLimit}
+
+	for _, param := range []struct {
+		name string
+		id   *int64
+	}{{"user_id", &q.UserID}, {"room_id", &q.RoomID}} {
+		raw := query.Get(param.name)
+		if raw == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id < 1 {
+			writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", param.name)
+			return
+		}
+		*param.id = id
+	}
+	if raw := query.Get("since"); raw != "" {
+		t, err := parseHistogramTime(raw, false)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_date_parameter", "since")
+			return
+		}
+		q.Since = t
+	}
+	if raw := query.Get("until"); raw != "" {
+		t, err := parseHistogramTime(raw, true)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_date_parameter", "until")
+			return
+		}
+		q.Until = t
+	}
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxAdminMessagesLimit {
+			writeError(w, r, http.StatusBadRequest, "invalid_pagination")
+			return
+		}
+		q.Limit = n
+	}
+	if raw := query.Get("cursor"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id < 1 {
+			writeError(w, r, http.StatusBadRequest, "invalid_pagination")
+			return
+		}
+		q.Before = id
+	}
+
+	messages, err := app.store.Redactions.ListMessages(r.Context(), q)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "admin_messages_lookup_failed")
+		return
+	}
+
+	response := AdminMessagesResponse{Messages: messages}
+	if len(messages) == q.Limit {
+		response.NextCursor = strconv.FormatInt(messages[len(messages)-1].ID, 10)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// redactMessageHandler replaces a message's content with the redaction marker
+// The original is kept with the actor and reason for appeals, where only
+// this API can see it. Clients connected to the room get a
+// "message_redacted" frame with the message ID and the marker to show instead
+// POST /v1/admin/messages/{messageID}/redact
+// Requires the X-Ops-Token header
+// Request body: {"actor": "alice", "reason": "harassment, report 9"}
+// Response: {"id": 3, "message_id": 119, "room_id": 1, "original_content": "...", "actor": "alice", ...}
+func (app *application) redactMessageHandler(w http.ResponseWriter, r *http.Request) {
+	messageID, err := extractIDFromURL(r, "messageID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "messageID")
+		return
+	}
+
+	var req RedactMessageRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	req.Actor = strings.TrimSpace(req.Actor)
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Actor == "" {
+		writeError(w, r, http.StatusBadRequest, "redaction_actor_required")
+		return
+	}
+	if utf8.RuneCountInString(req.Actor) > maxReportActorLength {
+		writeError(w, r, http.StatusBadRequest, "report_actor_too_long", maxReportActorLength)
+		return
+	}
+	if utf8.RuneCountInString(req.Reason) > maxRedactionReasonLength {
+		writeError(w, r, http.StatusBadRequest, "redaction_reason_too_long", maxRedactionReasonLength)
+		return
+	}
+
+	redaction, err := app.store.Redactions.Redact(r.Context(), messageID, req.Actor, req.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			writeError(w, r, http.StatusNotFound, "message_not_found")
+		case errors.Is(err, store.ErrAlreadyRedacted):
+			writeError(w, r, http.StatusConflict, "message_already_redacted")
+		default:
+			writeError(w, r, http.StatusInternalServerError, "message_redaction_failed")
+		}
+		return
+	}
+	log.Printf("Message %d in room %d redacted by %q", messageID, redaction.RoomID, redaction.Actor)
+	app.roomMessagesChanged(redaction.RoomID)
+
+	app.hub.Announce(&websocket.Message{
+		Message: wire.Message{
+			RoomID:    redaction.RoomID,
+			Type:      "message_redacted",
+			Content:   store.RedactedContent,
+			MessageID: messageID,
+			Redacted:  true,
+			EventSeq:  redaction.EventSeq,
+		},
+	})
+	writeJSON(w, http.StatusOK, redaction)
+}
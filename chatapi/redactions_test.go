@@ -0,0 +1,138 @@
+package chatapi
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestRedactMessage has an admin redact grace's reported message: linus,
+// connected to the room, is told to show the marker instead, the history
+// shows only the marker, and the admin view keeps the original with who
+// redacted it and why. The room's event log records the redaction without
+// the original, and members can't reach the admin endpoints
+func TestRedactMessage(t *testing.T) {
+	ts := newReportsStore(t)
+	server := newTestServer(t, ts, withOps)
+	ops := map[string]string{opsTokenHeader: "ops-secret"}
+	linus := dialRoom(t, server, 1, 3)
+	readFrame(t, linus, "join")
+
+	url := server.URL + "/v1/admin/messages/1/redact"
+	request := RedactMessageRequest{Actor: "ops-ada", Reason: "spam, report 1"}
+	var failure errorBody
+	if status := doJSON(t, http.MethodPost, url, 1, request, &failure); status != http.StatusUnauthorized {
+		t.Errorf("the room's creator redacting got %d, want 401", status)
+	}
+	if status := doJSONWithHeaders(t, http.MethodPost, url, 0, ops, RedactMessageRequest{Reason: "spam"}, &failure); status != http.StatusBadRequest || failure.Code != "redaction_actor_required" {
+		t.Errorf("redacting without an actor got %d %q, want 400 redaction_actor_required", status, failure.Code)
+	}
+
+	var redaction store.Redaction
+	if status := doJSONWithHeaders(t, http.MethodPost, url, 0, ops, request, &redaction); status != http.StatusOK {
+		t.Fatalf("redacting got %d, want 200", status)
+	}
+	if redaction.MessageID != 1 || redaction.OriginalContent != "cheap watches at example.invalid" || redaction.Actor != "ops-ada" || redaction.Reason != "spam, report 1" {
+		t.Errorf("the redaction is %+v, want the original kept with the actor and reason", redaction)
+	}
+
+	frame := readFrame(t, linus, "message_redacted")
+	if frame.MessageID != 1 || frame.Content != store.RedactedContent || !frame.Redacted || frame.EventSeq == 0 {
+		t.Errorf("linus was sent %+v, want message 1 replaced by the marker with its event seq", frame)
+	}
+	events, _ := ts.roomEvents.ListAfter(t.Context(), 1, 0, 10)
+	if len(events) != 1 || events[0].Type != store.RoomEventMessageRedacted || events[0].Seq != frame.EventSeq ||
+		strings.Contains(string(events[0].Payload), "watches") {
+		t.Errorf("the room's event log is %+v, want the redaction as event %d without the original", events, frame.EventSeq)
+	}
+
+	var history []*store.Message
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/rooms/1/messages", 3, nil, &history); status != http.StatusOK {
+		t.Fatalf("the history got %d, want 200", status)
+	}
+	if len(history) != 1 || history[0].Content != store.RedactedContent || !history[0].Redacted {
+		t.Errorf("the history holds %+v, want the marker only", history)
+	}
+
+	var page AdminMessagesResponse
+	if status := doJSONWithHeaders(t, http.MethodGet, server.URL+"/v1/admin/messages?room_id=1", 0, ops, nil, &page); status != http.StatusOK || len(page.Messages) != 1 {
+		t.Fatalf("the admin view got %d with %d messages, want 200 with 1", status, len(page.Messages))
+	}
+	if kept := page.Messages[0].Redaction; kept == nil || kept.OriginalContent != redaction.OriginalContent || kept.Actor != "ops-ada" {
+		t.Errorf("the admin view shows %+v, want the original kept for appeals", kept)
+	}
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/admin/messages", 1, nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("a member listing every message got %d, want 401", status)
+	}
+
+	for _, tc := range []struct {
+		name string
+		url  string
+		want int
+		code string
+	}{
+		{"twice", url, http.StatusConflict, "message_already_redacted"},
+		{"unknown message", server.URL + "/v1/admin/messages/99/redact", http.StatusNotFound, "message_not_found"},
+	} {
+		if status := doJSONWithHeaders(t, http.MethodPost, tc.url, 0, ops, request, &failure); status != tc.want || failure.Code != tc.code {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, status, failure.Code, tc.want, tc.code)
+		}
+	}
+}
+
+// TestAdminMessagesPaging pages through grace's messages three at a time,
+// redacting one already listed and one on a later page between pages: each
+// message is listed once, newest first, and the later one shows up redacted
+func TestAdminMessagesPaging(t *testing.T) {
+	ts := newReportsStore(t)
+	server := newTestServer(t, ts, withOps)
+	ops := map[string]string{opsTokenHeader: "ops-secret"}
+	ts.messages.addMessages(1, 2, 6) // Messages 2 to 7
+	ts.messages.addMessages(1, 3, 2) // linus's, filtered out
+
+	var listed []int64
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		var response AdminMessagesResponse
+		url := fmt.Sprintf("%s/v1/admin/messages?user_id=2&limit=3&cursor=%s", server.URL, cursor)
+		if status := doJSONWithHeaders(t, http.MethodGet, url, 0, ops, nil, &response); status != http.StatusOK {
+			t.Fatalf("page %d got %d, want 200", page, status)
+		}
+		for _, m := range response.Messages {
+			listed = append(listed, m.ID)
+			if m.ID == 4 && (!m.Redacted || m.Redaction == nil || m.Redaction.OriginalContent != "message") {
+				t.Errorf("message 4 is listed as %+v, want it redacted with its original", m.Message)
+			}
+		}
+		if response.NextCursor == "" {
+			break
+		}
+		cursor = response.NextCursor
+
+		if page == 0 {
+			for _, id := range []int{6, 4} {
+				url := fmt.Sprintf("%s/v1/admin/messages/%d/redact", server.URL, id)
+				if status := doJSONWithHeaders(t, http.MethodPost, url, 0, ops, RedactMessageRequest{Actor: "ops"}, nil); status != http.StatusOK {
+					t.Fatalf("redacting %d got %d, want 200", id, status)
+				}
+			}
+		}
+	}
+	if want := []int64{7, 6, 5, 4, 3, 2, 1}; !slices.Equal(listed, want) {
+		t.Errorf("listed %v, want %v", listed, want)
+	}
+
+	var failure errorBody
+	for _, query := range []string{"cursor=abc", "limit=500", "user_id=0"} {
+		if status := doJSONWithHeaders(t, http.MethodGet, server.URL+"/v1/admin/messages?"+query, 0, ops, nil, &failure); status != http.StatusBadRequest {
+			t.Errorf("%s got %d %q, want 400", query, status, failure.Code)
+		}
+	}
+	if status := doJSONWithHeaders(t, http.MethodGet, server.URL+"/v1/admin/messages?since=yesterday", 0, ops, nil, &failure); status != http.StatusBadRequest || failure.Code != "invalid_date_parameter" {
+		t.Errorf("an unreadable since got %d %q, want 400 invalid_date_parameter", status, failure.Code)
+	}
+}
@@ -0,0 +1,354 @@
+package chatapi
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+const (
+	// maxReportDetailsLength caps the free text of a report, in characters
+	maxReportDetailsLength = 1000
+
+	// maxReportNoteLength caps the note on a status change, in characters
+	maxReportNoteLength = 1000
+
+	// maxReportActorLength matches the report_events.actor column
+	maxReportActorLength = 100
+
+	// defaultReportsLimit and maxReportsLimit bound report listing pages
+	defaultReportsLimit = 20
+	maxReportsLimit     = 100
+)
+
+// CreateReportRequest represents the JSON structure for reporting a message or user
+type CreateReportRequest struct {
+	Reason  string `json:"reason"`  // "spam", "harassment" or "other"
+	Details string `json:"details"` // Optional free text
+
+	// RoomID is the room a user report was filed from (optional, user reports only)
+	RoomID *int64 `json:"room_id"`
+}
+
+// UpdateReportRequest represents the JSON structure for changing a report's status
+type UpdateReportRequest struct {
+	Status string `json:"status"`
+	Actor  string `json:"actor"` // Who is making the change, recorded in the audit trail
+	Note   string `json:"note"`
+}
+
+// readCreateReport parses and validates a report request body
+// It writes the error response and returns false if the body is invalid
+func readCreateReport(w http.ResponseWriter, r *http.Request) (*CreateReportRequest, bool) {
+	var req CreateReportRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return nil, false
+	}
+	if !store.ValidReportReason(req.Reason) {
+		writeError(w, r, http.StatusBadRequest, "invalid_report_reason")
+		return nil, false
+	}
+	req.Details = strings.TrimSpace(req.Details)
+	if utf8.RuneCountInString(req.Details) > maxReportDetailsLength {
+		writeError(w, r, http.StatusBadRequest, "report_details_too_long", maxReportDetailsLength)
+		return nil, false
+	}
+	return &req, true
+}
+
+// saveReport files a report, mapping a duplicate to 409
+// Responds 201 with the report
+func (app *application) saveReport(w http.ResponseWriter, r *http.Request, report *store.Report) {
+	if err := app.store.Reports.Create(r.Context(), report); err != nil {
+		if errors.Is(err, store.ErrDuplicateReport) {
+			writeError(w, r, http.StatusConflict, "report_already_open")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "report_create_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, report)
+}
+
+// reportMessageHandler reports a message for abuse
+// The message's content is copied into the report, so editing or deleting
+// the message later doesn't destroy the evidence
+// POST /v1/messages/{messageID}/report
+// Requires authentication and membership of the message's room
+// Request body: {"reason": "spam", "details": "posted the same link 20 times"}
+// Response: the report (201); 409 if the caller already has an open report about the message
+func (app *application) reportMessageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	messageID, err := extractIDFromURL(r, "messageID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "messageID")
+		return
+	}
+
+	req, ok := readCreateReport(w, r)
+	if !ok {
+		return
+	}
+
+	message, err := app.store.Messages.GetByID(r.Context(), messageID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "message_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "message_lookup_failed")
+		return
+	}
+
+	// Non-members get the same 404 as a missing message, so IDs can't be probed
+	isMember, err := app.store.RoomMembers.IsUserInRoom(r.Context(), message.RoomID, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "membership_check_failed")
+		return
+	}
+	if !isMember {
+		writeError(w, r, http.StatusNotFound, "message_not_found")
+		return
+	}
+
+	if message.UserID == userID {
+		writeError(w, r, http.StatusBadRequest, "report_self")
+		return
+	}
+
+	// A deleted author leaves the report with a message but no user
+	var targetUserID *int64
+	if !message.AuthorDeleted {
+		targetUserID = &message.UserID
+	}
+	app.saveReport(w, r, &store.Report{
+		ReporterID:     userID,
+		TargetType:     store.ReportTargetMessage,
+		TargetUserID:   targetUserID,
+		MessageID:      &message.ID,
+		RoomID:         &message.RoomID,
+		MessageContent: &message.Content,
+		Reason:         req.Reason,
+		Details:        req.Details,
+	})
+}
+
+// reportUserHandler reports a user for abuse
+// A room_id ties the report to a room the caller is in, so whoever has
+// view_reports there sees it too
+// POST /v1/users/{userID}/report
+// Requires authentication
+// Request body: {"reason": "harassment", "details": "...", "room_id": 4}
+// Response: the report (201); 409 if the caller already has an open report about the user
+func (app *application) reportUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	targetID, err := extractIDFromURL(r, "userID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "userID")
+		return
+	}
+
+	req, ok := readCreateReport(w, r)
+	if !ok {
+		return
+	}
+
+	if targetID == userID {
+		writeError(w, r, http.StatusBadRequest, "report_self")
+		return
+	}
+
+	if _, err := app.store.Users.GetByID(r.Context(), targetID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "user_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return
+	}
+
+	// Only a room the reporter is in can be given as context
+	if req.RoomID != nil {
+		isMember, err := app.store.RoomMembers.IsUserInRoom(r.Context(), *req.RoomID, userID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "membership_check_failed")
+			return
+		}
+		if !isMember {
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+			return
+		}
+	}
+
+	app.saveReport(w, r, &store.Report{
+		ReporterID:   userID,
+		TargetType:   store.ReportTargetUser,
+		TargetUserID: &targetID,
+		RoomID:       req.RoomID,
+		Reason:       req.Reason,
+		Details:      req.Details,
+	})
+}
+
+// readReportsPage parses ?limit and ?offset for report listings
+// It writes the error response and returns false if either is invalid
+func readReportsPage(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	limit := defaultReportsLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxReportsLimit {
+			writeError(w, r, http.StatusBadRequest, "invalid_pagination")
+			return 0, 0, false
+		}
+		limit = n
+	}
+
+	offset := 0
+	if raw := r.URL.Query().Get("offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid_pagination")
+			return 0, 0, false
+		}
+		offset = n
+	}
+	return limit, offset, true
+}
+
+// roomReportsHandler lists the reports concerning a room, newest first
+// GET /v1/rooms/{roomID}/reports?limit=20&offset=0
+// Requires authentication and view_reports (by default only the owner)
+// Response: [{"id": 9, "target_type": "message", "reason": "spam", "status": "open", "message_content": "...", ...}]
+func (app *application) roomReportsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	limit, offset, ok := readReportsPage(w, r)
+	if !ok {
+		return
+	}
+
+	// Deleted rooms' reports are only visible to operators
+	room, err := app.store.Rooms.GetByID(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_lookup_failed")
+		return
+	}
+	if !app.requireRoomPermission(w, r, room.ID, userID, store.CapViewReports) {
+		return
+	}
+
+	reports, err := app.store.Reports.ListForRoom(r.Context(), roomID, limit, offset)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "reports_lookup_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, reports)
+}
+
+// adminReportsHandler lists reports across the platform, newest first
+// GET /v1/admin/reports?status=open&limit=20&offset=0
+// Requires the X-Ops-Token header
+// Response: [{"id": 9, "target_type": "user", "status": "open", ...}]
+func (app *application) adminReportsHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && !store.ValidReportStatus(status) {
+		writeError(w, r, http.StatusBadRequest, "invalid_report_status")
+		return
+	}
+
+	limit, offset, ok := readReportsPage(w, r)
+	if !ok {
+		return
+	}
+
+	reports, err := app.store.Reports.List(r.Context(), status, limit, offset)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "reports_lookup_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, reports)
+}
+
+// updateReportHandler changes a report's status and records who did it and why
+// Open reports can move to reviewing, resolved or dismissed; closed ones can be reopened
+// PATCH /v1/admin/reports/{reportID}
+// Requires the X-Ops-Token header
+// Request body: {"status": "resolved", "actor": "alice", "note": "user warned"}
+// Response: the report with its "history" of status changes
+func (app *application) updateReportHandler(w http.ResponseWriter, r *http.Request) {
+	reportID, err := extractIDFromURL(r, "reportID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "reportID")
+		return
+	}
+
+	var req UpdateReportRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	if !store.ValidReportStatus(req.Status) {
+		writeError(w, r, http.StatusBadRequest, "invalid_report_status")
+		return
+	}
+	req.Actor = strings.TrimSpace(req.Actor)
+	req.Note = strings.TrimSpace(req.Note)
+	if utf8.RuneCountInString(req.Actor) > maxReportActorLength {
+		writeError(w, r, http.StatusBadRequest, "report_actor_too_long", maxReportActorLength)
+		return
+	}
+	if utf8.RuneCountInString(req.Note) > maxReportNoteLength {
+		writeError(w, r, http.StatusBadRequest, "report_note_too_long", maxReportNoteLength)
+		return
+	}
+
+	report, err := app.store.Reports.UpdateStatus(r.Context(), reportID, req.Status, req.Actor, req.Note)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			writeError(w, r, http.StatusNotFound, "report_not_found")
+		case errors.Is(err, store.ErrInvalidReportTransition):
+			writeError(w, r, http.StatusConflict, "invalid_report_transition")
+		case errors.Is(err, store.ErrDuplicateReport):
+			writeError(w, r, http.StatusConflict, "report_already_open")
+		default:
+			writeError(w, r, http.StatusInternalServerError, "report_update_failed")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,173 @@
+package chatapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// newReportsStore has a room created by ada with grace and linus in it, and
+// ken outside it; grace's message 1 is the one reported
+func newReportsStore(t *testing.T) *testStore {
+	t.Helper()
+	ts := newTestStore(t)
+	for id, name := range map[int64]string{1: "ada", 2: "grace", 3: "linus", 4: "ken"} {
+		ts.users.add(&store.User{ID: id, Username: name})
+	}
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.roomMembers.add(1, 3, store.RoomRoleMember)
+	if err := ts.messages.Create(context.Background(), &store.Message{RoomID: 1, UserID: 2, Content: "cheap watches at example.invalid"}); err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+// TestReportMessage reports grace's message: the report keeps the content
+// as it was, a second open report from the same member is refused, and
+// authors, outsiders and unknown reasons are turned away
+func TestReportMessage(t *testing.T) {
+	ts := newReportsStore(t)
+	server := newTestServer(t, ts, withOps)
+	url := server.URL + "/v1/messages/1/report"
+	spam := CreateReportRequest{Reason: store.ReportReasonSpam, Details: "  posted it everywhere  "}
+
+	var report store.Report
+	if status := doJSON(t, http.MethodPost, url, 3, spam, &report); status != http.StatusCreated {
+		t.Fatalf("linus reporting got %d, want 201", status)
+	}
+	if report.Status != store.ReportStatusOpen || *report.TargetUserID != 2 || *report.RoomID != 1 || report.Details != "posted it everywhere" {
+		t.Errorf("got report %+v, want an open report about grace in room 1", report)
+	}
+
+	// Editing the message afterwards leaves the evidence alone
+	ts.messages.edit(1, "sorry, wrong room")
+	var listed []*store.Report
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/rooms/1/reports", 1, nil, &listed); status != http.StatusOK || len(listed) != 1 {
+		t.Fatalf("listing got %d with %d reports, want 200 with 1", status, len(listed))
+	}
+	if content := listed[0].MessageContent; content == nil || *content != "cheap watches at example.invalid" {
+		t.Errorf("the report holds %v, want the content as reported", content)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		userID int64
+		body   CreateReportRequest
+		status int
+		code   string
+	}{
+		{"again while open", 3, spam, http.StatusConflict, "report_already_open"},
+		{"the author", 2, spam, http.StatusBadRequest, "report_self"},
+		{"not a member", 4, spam, http.StatusNotFound, "message_not_found"},
+		{"unknown reason", 1, CreateReportRequest{Reason: "rude"}, http.StatusBadRequest, "invalid_report_reason"},
+	} {
+		var failure errorBody
+		if status := doJSON(t, http.MethodPost, url, tc.userID, tc.body, &failure); status != tc.status || failure.Code != tc.code {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, status, failure.Code, tc.status, tc.code)
+		}
+	}
+}
+
+// TestReportUser reports grace from room 1; the room given must be one the
+// reporter is in, and nobody can report themselves or a missing user
+func TestReportUser(t *testing.T) {
+	server := newTestServer(t, newReportsStore(t), withOps)
+	room := int64(1)
+	harassment := CreateReportRequest{Reason: store.ReportReasonHarassment, RoomID: &room}
+
+	var report store.Report
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/users/2/report", 3, harassment, &report); status != http.StatusCreated {
+		t.Fatalf("linus reporting grace got %d, want 201", status)
+	}
+	if report.TargetType != store.ReportTargetUser || report.MessageID != nil || report.MessageContent != nil {
+		t.Errorf("got report %+v, want a user report without a message", report)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		userID int64
+		target int64
+		status int
+		code   string
+	}{
+		{"again while open", 3, 2, http.StatusConflict, "report_already_open"},
+		{"themselves", 3, 3, http.StatusBadRequest, "report_self"},
+		{"unknown user", 3, 99, http.StatusNotFound, "user_not_found"},
+		{"from a room they aren't in", 4, 2, http.StatusNotFound, "room_not_found"},
+	} {
+		var failure errorBody
+		url := fmt.Sprintf("%s/v1/users/%d/report", server.URL, tc.target)
+		if status := doJSON(t, http.MethodPost, url, tc.userID, harassment, &failure); status != tc.status || failure.Code != tc.code {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, status, failure.Code, tc.status, tc.code)
+		}
+	}
+}
+
+// TestReportPermissions lists reports as the room's creator, as another
+// member and through the admin endpoints, which alone see reports filed
+// without a room and can change a report's status
+func TestReportPermissions(t *testing.T) {
+	server := newTestServer(t, newReportsStore(t), withOps)
+	ops := map[string]string{opsTokenHeader: "ops-secret"}
+	doJSON(t, http.MethodPost, server.URL+"/v1/messages/1/report", 3, CreateReportRequest{Reason: store.ReportReasonSpam}, nil)
+	doJSON(t, http.MethodPost, server.URL+"/v1/users/2/report", 4, CreateReportRequest{Reason: store.ReportReasonOther}, nil)
+
+	var failure errorBody
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/rooms/1/reports", 2, nil, &failure); status != http.StatusForbidden || failure.Code != "room_permission_denied" {
+		t.Errorf("a member who isn't the creator got %d %q, want 403 room_permission_denied", status, failure.Code)
+	}
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/admin/reports", 1, nil, &failure); status != http.StatusUnauthorized {
+		t.Errorf("the room's creator listing every report got %d, want 401", status)
+	}
+
+	var listed []*store.Report
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/rooms/1/reports", 1, nil, &listed); status != http.StatusOK || len(listed) != 1 {
+		t.Errorf("the creator got %d with %d reports, want 200 with the room's 1", status, len(listed))
+	}
+	if status := doJSONWithHeaders(t, http.MethodGet, server.URL+"/v1/admin/reports?status=open", 0, ops, nil, &listed); status != http.StatusOK || len(listed) != 2 {
+		t.Fatalf("admins got %d with %d reports, want 200 with 2", status, len(listed))
+	}
+	if status := doJSONWithHeaders(t, http.MethodGet, server.URL+"/v1/admin/reports?limit=1&offset=1", 0, ops, nil, &listed); status != http.StatusOK || len(listed) != 1 || listed[0].ID != 1 {
+		t.Errorf("the second page got %d with %+v, want report 1 alone", status, listed)
+	}
+
+	// Resolve, fail to skip straight to dismissed, then reopen
+	url := server.URL + "/v1/admin/reports/1"
+	var report store.Report
+	resolve := UpdateReportRequest{Status: store.ReportStatusResolved, Actor: "ops-ada", Note: "warned"}
+	if status := doJSONWithHeaders(t, http.MethodPatch, url, 0, ops, resolve, &report); status != http.StatusOK || report.Status != store.ReportStatusResolved {
+		t.Fatalf("resolving got %d %q, want 200 resolved", status, report.Status)
+	}
+	if len(report.History) != 1 || report.History[0].FromStatus != store.ReportStatusOpen || report.History[0].Actor != "ops-ada" {
+		t.Errorf("the history is %+v, want one change from open by ops-ada", report.History)
+	}
+	for _, tc := range []struct {
+		name   string
+		url    string
+		status string
+		want   int
+		code   string
+	}{
+		{"closed to closed", url, store.ReportStatusDismissed, http.StatusConflict, "invalid_report_transition"},
+		{"unknown status", url, "deleted", http.StatusBadRequest, "invalid_report_status"},
+		{"unknown report", server.URL + "/v1/admin/reports/99", store.ReportStatusOpen, http.StatusNotFound, "report_not_found"},
+	} {
+		if status := doJSONWithHeaders(t, http.MethodPatch, tc.url, 0, ops, UpdateReportRequest{Status: tc.status}, &failure); status != tc.want || failure.Code != tc.code {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, status, failure.Code, tc.want, tc.code)
+		}
+	}
+
+	// With the first report closed linus may file another, which blocks reopening it
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/messages/1/report", 3, CreateReportRequest{Reason: store.ReportReasonSpam}, nil); status != http.StatusCreated {
+		t.Fatalf("reporting again after resolution got %d, want 201", status)
+	}
+	reopen := UpdateReportRequest{Status: store.ReportStatusOpen}
+	if status := doJSONWithHeaders(t, http.MethodPatch, url, 0, ops, reopen, &failure); status != http.StatusConflict || failure.Code != "report_already_open" {
+		t.Errorf("reopening beside a new report got %d %q, want 409 report_already_open", status, failure.Code)
+	}
+}
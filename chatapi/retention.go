@@ -0,0 +1,125 @@
+package chatapi
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// Message retention
+//
+// Messages are kept forever unless MESSAGE_RETENTION sets a default, and a
+// room's creator can choose otherwise with retention_seconds on the room:
+// a compliance room keeps everything (0), an ephemeral one drops messages
+// after a day. A room's own retention must lie within ROOM_RETENTION_MIN and
+// ROOM_RETENTION_MAX; it's only checked when set, so a room keeps its setting
+// if the bounds change later
+//
+// Nothing is deleted in the request: the purge job below finds what expired
+// every messagePurgeInterval, so a shortened retention applies from the next run
+
+const (
+	// messagePurgeInterval is how often expired messages are purged
+	messagePurgeInterval = 15 * time.Minute
+
+	// messagePurgeBatchSize is how many of a room's messages one statement deletes
+	messagePurgeBatchSize = 1000
+)
+
+// parseRoomRetention decodes the retention_seconds field of a room update
+// JSON null returns nil, which resets the room to the server default
+// It writes the error response itself and returns false if the value isn't allowed
+func (app *application) parseRoomRetention(w http.ResponseWriter, r *http.Request, raw json.RawMessage) (*int64, bool) {
+	if string(raw) == "null" {
+		return nil, true
+	}
+
+	minSeconds := int64(app.config.rooms.minRetention.Seconds())
+	maxSeconds := int64(app.config.rooms.maxRetention.Seconds())
+
+	var seconds int64
+	err := json.Unmarshal(raw, &seconds)
+	switch {
+	case maxSeconds > 0 && (err != nil || seconds < minSeconds || seconds > maxSeconds):
+		// With a maximum, keeping messages forever isn't allowed either
+		writeError(w, r, http.StatusBadRequest, "invalid_room_retention_range", minSeconds, maxSeconds)
+		return nil, false
+	case err != nil || seconds < 0 || (seconds > 0 && seconds < minSeconds):
+		writeError(w, r, http.StatusBadRequest, "invalid_room_retention", minSeconds)
+		return nil, false
+	}
+	return &seconds, true
+}
+
+// runMessagePurger deletes messages past their room's retention
+// It runs until ctx is done and should be started in a goroutine
+func (app *application) runMessagePurger(ctx context.Context) {
+	ticker := time.NewTicker(messagePurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if purged := app.purgeExpiredMessages(ctx); purged > 0 {
+			log.Printf("Purged %d messages past their room's retention", purged)
+		}
+	}
+}
+
+// purgeExpiredMessages deletes every room's expired messages, returning how
+// many were removed
+// Rooms take turns one batch at a time, so a huge backlog in one room doesn't
+// hold up the others; it stops early when ctx is done
+func (app *application) purgeExpiredMessages(ctx context.Context) int64 {
+	listCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	rooms, err := app.store.Rooms.ListRetained(listCtx)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to list rooms with message retention: %v", err)
+		return 0
+	}
+
+	var total int64
+	for len(rooms) > 0 && ctx.Err() == nil {
+		pending := rooms[:0]
+		for _, room := range rooms {
+			callCtx, cancel := context.WithTimeout(ctx, time.Minute)
+			purged, err := app.store.Messages.PurgeRoomExpired(callCtx, room.RoomID, room.Retention, messagePurgeBatchSize)
+			cancel()
+
+			if err != nil {
+				log.Printf("Failed to purge expired messages of room %d: %v", room.RoomID, err)
+				continue
+			}
+			total += purged
+
+			// A short batch means nothing is left in the room for now
+			if purged == messagePurgeBatchSize {
+				pending = append(pending, room)
+			}
+		}
+		rooms = pending
+	}
+	return total
+}
+
+// retentionWarnings describes, for an export, the rooms whose older messages
+// were deleted and so are missing from it
+func retentionWarnings(memberships []*store.ExportedMembership) []string {
+	warnings := make([]string, 0)
+	for _, m := range memberships {
+		if m.RetentionSeconds > 0 {
+			retention := time.Duration(m.RetentionSeconds) * time.Second
+			warnings = append(warnings, "messages in "+m.RoomName+" are deleted after "+retention.String()+"; older ones are not included")
+		}
+	}
+	return warnings
+}
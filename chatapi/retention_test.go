@@ -0,0 +1,192 @@
+package chatapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestRoomRetention sets retention_seconds through PATCH /v1/rooms/{id},
+// with and without ROOM_RETENTION_MAX: values below the minimum are refused,
+// 0 keeps messages forever unless there's a maximum, and null goes back to
+// the server default
+func TestRoomRetention(t *testing.T) {
+	for _, c := range []struct {
+		max   string // ROOM_RETENTION_MAX
+		value string // retention_seconds as sent
+		want  string // The saved setting, "null" for none, or the error code
+	}{
+		{"0s", "86400", "86400"},
+		{"0s", "3600", "3600"},
+		{"0s", "0", "0"},
+		{"0s", "null", "null"},
+		{"0s", "3599", "invalid_room_retention"},
+		{"0s", "-1", "invalid_room_retention"},
+		{"0s", `"1d"`, "invalid_room_retention"},
+		{"720h", "86400", "86400"},
+		{"720h", "2592000", "2592000"},
+		{"720h", "2592001", "invalid_room_retention_range"},
+		{"720h", "0", "invalid_room_retention_range"},
+		{"720h", "60", "invalid_room_retention_range"},
+		{"720h", "null", "null"},
+	} {
+		t.Run(c.max+" "+c.value, func(t *testing.T) {
+			ts := newTestStore(t)
+			week := int64(7 * 24 * 3600)
+			ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 2, JoinPolicy: store.JoinPolicyOpen, RetentionSeconds: &week})
+			ts.roomMembers.add(1, 2, store.RoomRoleOwner)
+			t.Setenv("ROOM_RETENTION_MIN", "1h")
+			t.Setenv("ROOM_RETENTION_MAX", c.max)
+			chat := newEmbeddedChat(t, ts, filepath.Join(t.TempDir(), "missing.env"))
+			server := httptest.NewServer(chat.Handler())
+			defer server.Close()
+
+			body := json.RawMessage(`{"retention_seconds": ` + c.value + `}`)
+			var failure errorBody
+			status := doJSON(t, http.MethodPatch, server.URL+"/v1/rooms/1", 2, body, &failure)
+			saved, _ := ts.rooms.GetByID(context.Background(), 1)
+			got := "null"
+			if saved.RetentionSeconds != nil {
+				got = strconv.FormatInt(*saved.RetentionSeconds, 10)
+			}
+
+			if strings.HasPrefix(c.want, "invalid") {
+				if status != http.StatusBadRequest || failure.Code != c.want {
+					t.Errorf("got %d %q, want 400 %s", status, failure.Code, c.want)
+				}
+				if got != "604800" {
+					t.Errorf("a refused value left the setting %s, want 604800", got)
+				}
+				return
+			}
+			if status != http.StatusOK || got != c.want {
+				t.Errorf("got %d with the setting %s, want 200 and %s", status, got, c.want)
+			}
+		})
+	}
+}
+
+// retainedRooms lists rooms with a retention for the purge job
+type retainedRooms struct {
+	*fakeRooms
+	retained []store.RoomRetention
+}
+
+func (r *retainedRooms) ListRetained(context.Context) ([]store.RoomRetention, error) {
+	return r.retained, nil
+}
+
+// expiringMessages has a number of expired messages per room, deleted a
+// batch at a time, and records the order rooms were purged in
+// The purge job needs nothing else from Messages; the other methods are the
+// real store's on no database and would panic
+type expiringMessages struct {
+	*store.MessageStore
+	mu      sync.Mutex
+	expired map[int64]int64
+	calls   []int64
+}
+
+var errPurgeFailed = errors.New("purge failed")
+
+func (m *expiringMessages) PurgeRoomExpired(_ context.Context, roomID int64, retention time.Duration, batchSize int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, roomID)
+	left, ok := m.expired[roomID]
+	if !ok {
+		return 0, errPurgeFailed
+	}
+	purged := min(left, int64(batchSize))
+	m.expired[roomID] = left - purged
+	return purged, nil
+}
+
+// TestPurgeExpiredMessages runs the purge job over rooms with different
+// backlogs: rooms take turns a batch at a time until each is done, and a
+// room that fails is skipped without holding up the rest
+func TestPurgeExpiredMessages(t *testing.T) {
+	ts := newTestStore(t)
+	ts.Rooms = &retainedRooms{fakeRooms: ts.rooms, retained: []store.RoomRetention{
+		{RoomID: 1, Retention: time.Hour},
+		{RoomID: 2, Retention: 24 * time.Hour},
+		{RoomID: 3, Retention: time.Hour},
+		{RoomID: 4, Retention: time.Hour},
+	}}
+	messages := &expiringMessages{expired: map[int64]int64{
+		1: 2*messagePurgeBatchSize + 5,
+		2: 10,
+		4: messagePurgeBatchSize,
+	}}
+	ts.Messages = messages
+	chat := newEmbeddedChat(t, ts, filepath.Join(t.TempDir(), "missing.env"))
+
+	if purged := chat.app.purgeExpiredMessages(context.Background()); purged != 3*messagePurgeBatchSize+15 {
+		t.Errorf("%d messages were purged, want %d", purged, 3*messagePurgeBatchSize+15)
+	}
+	// Room 4's first batch was full, so it's asked once more and finds nothing
+	if want := []int64{1, 2, 3, 4, 1, 4, 1}; !slices.Equal(messages.calls, want) {
+		t.Errorf("rooms were purged in the order %v, want %v", messages.calls, want)
+	}
+	for roomID, left := range messages.expired {
+		if left != 0 {
+			t.Errorf("room %d has %d expired messages left", roomID, left)
+		}
+	}
+}
+
+// TestRetentionWarnings warns about each exported room whose messages expire
+func TestRetentionWarnings(t *testing.T) {
+	warnings := retentionWarnings([]*store.ExportedMembership{
+		{RoomName: "general"},
+		{RoomName: "ephemeral", RetentionSeconds: 86400},
+	})
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "ephemeral") || !strings.Contains(warnings[0], "24h0m0s") {
+		t.Errorf("the warnings are %q, want one about ephemeral's 24h", warnings)
+	}
+	if warnings := retentionWarnings(nil); warnings == nil {
+		t.Error("no memberships gave nil warnings, want an empty list")
+	}
+}
+
+// TestRetentionConfig checks the retention settings are passed to the store
+// and refused when they contradict each other
+func TestRetentionConfig(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), "missing.env")
+	t.Setenv("JWT_SECRET", testSecret)
+	t.Setenv("MESSAGE_RETENTION", "2160h")
+	cfg, err := LoadConfig(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits := cfg.StoreLimits(); limits.MessageRetention != 2160*time.Hour {
+		t.Errorf("the store's default retention is %s, want 2160h", limits.MessageRetention)
+	}
+
+	for _, c := range []struct {
+		name, value string
+	}{
+		{"MESSAGE_RETENTION", "-1h"},
+		{"ROOM_RETENTION_MIN", "0s"},
+		{"ROOM_RETENTION_MAX", "-1h"},
+		{"ROOM_RETENTION_MAX", "30m"}, // Below the 1h minimum
+	} {
+		t.Run(c.name+"="+c.value, func(t *testing.T) {
+			t.Setenv(c.name, c.value)
+			if _, err := LoadConfig(envFile); err == nil {
+				t.Errorf("loading %s=%s succeeded, want an error", c.name, c.value)
+			}
+		})
+	}
+}
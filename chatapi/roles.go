@@ -0,0 +1,232 @@
+package chatapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// RoleRequest sets a user's role
+// Server roles: member, moderator, admin; room roles: member, admin
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+// RoleResponse is a user's role after a change
+type RoleResponse struct {
+	UserID int64  `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+// hasUserRole reports whether a user has at least a server role
+// A user that doesn't exist has none
+func (app *application) hasUserRole(ctx context.Context, userID int64, role string) (bool, error) {
+	have, err := app.store.Users.GetRole(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return store.UserRoleAtLeast(have, role), nil
+}
+
+// RequireRole protects routes for users with at least a server role
+// (store.UserRoles, e.g. an admin passes RequireRole(store.UserRoleModerator))
+// It goes after AuthMiddleware; anyone else gets a 403 naming the role
+func (app *application) RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, err := GetUserIDFromContext(r.Context())
+			if err != nil {
+				writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+				return
+			}
+			ok, err := app.hasUserRole(r.Context(), userID, role)
+			if err != nil {
+				writeError(w, r, http.StatusInternalServerError, "role_lookup_failed")
+				return
+			}
+			if !ok {
+				writeError(w, r, http.StatusForbidden, "role_required", role)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireRoomPermissionOrRole is requireRoomPermission, passed as well by
+// users with at least a server role, whatever their role in the room
+func (app *application) requireRoomPermissionOrRole(w http.ResponseWriter, r *http.Request, roomID, userID int64, capability, role string) bool {
+	ok, err := app.hasUserRole(r.Context(), userID, role)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "role_lookup_failed")
+		return false
+	}
+	return ok || app.requireRoomPermission(w, r, roomID, userID, capability)
+}
+
+// setUserRoleHandler changes a user's server role
+// This is how the first admin is made, so it's an operational route
+// PUT /v1/admin/users/{userID}/role
+// Requires the X-Ops-Token header
+// Request body: {"role": "moderator"}
+// Response: {"user_id": 7, "role": "moderator"}
+func (app *application) setUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := extractIDFromURL(r, "userID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "userID")
+		return
+	}
+
+	var req RoleRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	if !slices.Contains(store.UserRoles, req.Role) {
+		writeError(w, r, http.StatusBadRequest, "invalid_user_role", req.Role)
+		return
+	}
+
+	if err := app.store.Users.SetRole(r.Context(), userID, req.Role); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "user_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "role_update_failed")
+		return
+	}
+	log.Printf("User %d is now a server %s", userID, req.Role)
+
+	writeJSON(w, http.StatusOK, RoleResponse{UserID: userID, Role: req.Role})
+}
+
+// banUserHandler bans a user: they're signed out everywhere, their personal
+// access tokens are deleted and they can't sign in again until unbanned
+// Admins can't be banned; demote them first
+// POST /v1/users/{userID}/ban
+// Requires authentication and the admin role
+// Response: 204 No Content
+func (app *application) banUserHandler(w http.ResponseWriter, r *http.Request) {
+	targetID, err := extractIDFromURL(r, "userID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "userID")
+		return
+	}
+
+	role, err := app.store.Users.GetRole(r.Context(), targetID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "user_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "role_lookup_failed")
+		return
+	}
+	if role == store.UserRoleAdmin {
+		writeError(w, r, http.StatusForbidden, "cannot_ban_admin")
+		return
+	}
+
+	sessionIDs, err := app.store.Users.Ban(r.Context(), targetID)
+	if err != nil {
+		// Deleted or made an admin in the meantime
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusConflict, "user_ban_conflict")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "user_ban_failed")
+		return
+	}
+	app.tokenVersions.Invalidate(targetID)
+	app.hub.CloseUser(targetID)
+
+	adminID, _ := GetUserIDFromContext(r.Context())
+	log.Printf("User %d banned user %d, closing %d sessions", adminID, targetID, len(sessionIDs))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unbanUserHandler lifts a ban; the user can sign in again
+// DELETE /v1/users/{userID}/ban
+// Requires authentication and the admin role
+// Response: 204 No Content
+func (app *application) unbanUserHandler(w http.ResponseWriter, r *http.Request) {
+	targetID, err := extractIDFromURL(r, "userID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "userID")
+		return
+	}
+
+	if err := app.store.Users.Unban(r.Context(), targetID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "user_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "user_ban_failed")
+		return
+	}
+
+	adminID, _ := GetUserIDFromContext(r.Context())
+	log.Printf("User %d unbanned user %d", adminID, targetID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setRoomMemberRoleHandler makes a member a room admin, or a member again
+// The creator stays the owner whatever their membership role
+// PUT /v1/rooms/{roomID}/members/{userID}/role
+// Requires authentication and manage_settings
+// Request body: {"role": "admin"}
+// Response: {"user_id": 7, "role": "admin"}
+func (app *application) setRoomMemberRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+	memberID, err := extractIDFromURL(r, "userID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "userID")
+		return
+	}
+
+	var req RoleRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	if req.Role != store.RoomRoleMember && req.Role != store.RoomRoleAdmin {
+		writeError(w, r, http.StatusBadRequest, "invalid_room_role", req.Role)
+		return
+	}
+
+	if !app.requireRoomPermission(w, r, roomID, userID, store.CapManageSettings) {
+		return
+	}
+
+	if err := app.store.RoomMembers.SetRole(r.Context(), roomID, memberID, req.Role); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_member_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "role_update_failed")
+		return
+	}
+	app.roomAccessCache.invalidateRoom(roomID)
+
+	writeJSON(w, http.StatusOK, RoleResponse{UserID: memberID, Role: req.Role})
+}
@@ -0,0 +1,132 @@
+package chatapi
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// newRolesStore has ada, who can log in, grace, a server admin, and linus,
+// a moderator; ada owns general
+func newRolesStore(t *testing.T) *testStore {
+	t.Helper()
+	ts := newTestStore(t)
+	addLoginUser(t, ts)
+	ts.users.add(&store.User{ID: 2, Username: "grace", Email: "grace@example.com"})
+	ts.users.add(&store.User{ID: 3, Username: "linus", Email: "linus@example.com"})
+	ts.users.roles[2] = store.UserRoleAdmin
+	ts.users.roles[3] = store.UserRoleModerator
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 3, store.RoomRoleMember)
+	return ts
+}
+
+// TestBanUser has grace ban ada, which signs ada out and keeps them from
+// logging in until grace lifts it. Only admins may ban, and admins can't be
+func TestBanUser(t *testing.T) {
+	server := newTestServer(t, newRolesStore(t))
+	browser := login(t, server.URL, firefoxOnWindows)
+	ban := func(userID int64) string { return fmt.Sprintf("%s/v1/users/%d/ban", server.URL, userID) }
+	credentials := LoginRequest{Email: "ada@example.com", Password: "correct horse battery staple"}
+
+	var failure errorBody
+	for _, userID := range []int64{1, 3} {
+		if status := doJSON(t, http.MethodPost, ban(1), userID, nil, &failure); status != http.StatusForbidden || failure.Code != "role_required" {
+			t.Errorf("user %d banning got %d %q, want 403 role_required", userID, status, failure.Code)
+		}
+	}
+	if status := doJSON(t, http.MethodPost, ban(2), 2, nil, &failure); status != http.StatusForbidden || failure.Code != "cannot_ban_admin" {
+		t.Errorf("banning an admin got %d %q, want 403 cannot_ban_admin", status, failure.Code)
+	}
+	if status := doJSON(t, http.MethodPost, ban(99), 2, nil, &failure); status != http.StatusNotFound || failure.Code != "user_not_found" {
+		t.Errorf("banning an unknown user got %d %q, want 404 user_not_found", status, failure.Code)
+	}
+
+	if status := doJSON(t, http.MethodPost, ban(1), 2, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("banning ada got %d, want 204", status)
+	}
+	if status := doJSONWithHeaders(t, http.MethodGet, server.URL+"/v1/auth/me", 0, browser, nil, &failure); status != http.StatusUnauthorized || failure.Code != "session_revoked" {
+		t.Errorf("ada's session got %d %q after the ban, want 401 session_revoked", status, failure.Code)
+	}
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/auth/login", 0, credentials, &failure); status != http.StatusForbidden || failure.Code != "user_banned" {
+		t.Errorf("logging in while banned got %d %q, want 403 user_banned", status, failure.Code)
+	}
+
+	if status := doJSON(t, http.MethodDelete, ban(1), 2, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("lifting the ban got %d, want 204", status)
+	}
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/auth/login", 0, credentials, nil); status != http.StatusOK {
+		t.Errorf("logging in after the ban got %d, want 200", status)
+	}
+}
+
+// TestAdminDeletesRoom has grace, an admin who isn't in general, delete and
+// restore it through the same confirmation as its creator; linus, a
+// moderator and member, still can't
+func TestAdminDeletesRoom(t *testing.T) {
+	server := newTestServer(t, newRolesStore(t))
+	room := server.URL + "/v1/rooms/1"
+
+	var failure errorBody
+	if status := doJSON(t, http.MethodPost, room+"/delete-request", 3, nil, &failure); status != http.StatusForbidden || failure.Code != "room_delete_creator_only" {
+		t.Errorf("a moderator requesting the deletion got %d %q, want 403 room_delete_creator_only", status, failure.Code)
+	}
+
+	var summary RoomDeletionRequestResponse
+	if status := doJSON(t, http.MethodPost, room+"/delete-request", 2, nil, &summary); status != http.StatusOK {
+		t.Fatalf("an admin requesting the deletion got %d, want 200", status)
+	}
+	confirm := DeleteRoomRequest{ConfirmationToken: summary.ConfirmationToken, RoomName: "general"}
+	if status := doJSON(t, http.MethodDelete, room, 2, confirm, nil); status != http.StatusNoContent {
+		t.Fatalf("an admin deleting the room got %d, want 204", status)
+	}
+	if status := doJSON(t, http.MethodPost, room+"/restore", 3, nil, &failure); status != http.StatusForbidden || failure.Code != "room_permission_denied" {
+		t.Errorf("a moderator restoring got %d %q, want 403 room_permission_denied", status, failure.Code)
+	}
+	if status := doJSON(t, http.MethodPost, room+"/restore", 2, nil, nil); status != http.StatusOK {
+		t.Errorf("an admin restoring got %d, want 200", status)
+	}
+}
+
+// TestSetRoles changes server roles with the ops token, which gives ada the
+// admin routes, and room roles as the room's owner
+func TestSetRoles(t *testing.T) {
+	ts := newRolesStore(t)
+	server := newTestServer(t, ts, func(app *application) {
+		app.config.ops.token = "ops-secret"
+	})
+	ops := map[string]string{opsTokenHeader: "ops-secret"}
+
+	var failure errorBody
+	if status := doJSONWithHeaders(t, http.MethodPut, server.URL+"/v1/admin/users/1/role", 0, ops, RoleRequest{Role: "root"}, &failure); status != http.StatusBadRequest || failure.Code != "invalid_user_role" {
+		t.Errorf("an unknown role got %d %q, want 400 invalid_user_role", status, failure.Code)
+	}
+	var changed RoleResponse
+	if status := doJSONWithHeaders(t, http.MethodPut, server.URL+"/v1/admin/users/1/role", 0, ops, RoleRequest{Role: store.UserRoleAdmin}, &changed); status != http.StatusOK || changed != (RoleResponse{UserID: 1, Role: store.UserRoleAdmin}) {
+		t.Errorf("making ada an admin got %d %+v", status, changed)
+	}
+	member := server.URL + "/v1/rooms/1/members/3/role"
+	if status := doJSON(t, http.MethodPut, member, 3, RoleRequest{Role: store.RoomRoleAdmin}, &failure); status != http.StatusForbidden || failure.Code != "room_permission_denied" {
+		t.Errorf("a member promoting themselves got %d %q, want 403 room_permission_denied", status, failure.Code)
+	}
+	if status := doJSON(t, http.MethodPut, member, 1, RoleRequest{Role: store.RoomRoleOwner}, &failure); status != http.StatusBadRequest || failure.Code != "invalid_room_role" {
+		t.Errorf("making a member the owner got %d %q, want 400 invalid_room_role", status, failure.Code)
+	}
+	if status := doJSON(t, http.MethodPut, member, 1, RoleRequest{Role: store.RoomRoleAdmin}, nil); status != http.StatusOK {
+		t.Errorf("the owner promoting a member got %d, want 200", status)
+	}
+	if status := doJSON(t, http.MethodPut, server.URL+"/v1/rooms/1/members/2/role", 1, RoleRequest{Role: store.RoomRoleAdmin}, &failure); status != http.StatusNotFound || failure.Code != "room_member_not_found" {
+		t.Errorf("promoting a non-member got %d %q, want 404 room_member_not_found", status, failure.Code)
+	}
+	if admins, _ := ts.roomMembers.GetRoomAdmins(t.Context(), 1); len(admins) != 2 {
+		t.Errorf("general has admins %v, want ada and linus", admins)
+	}
+
+	// Made an admin above, ada may ban now
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/users/3/ban", 1, nil, nil); status != http.StatusNoContent {
+		t.Errorf("ada banning as an admin got %d, want 204", status)
+	}
+}
@@ -0,0 +1,277 @@
+package chatapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/auth"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+const (
+	// roomPurgeInterval is how often expired deleted rooms are purged
+	roomPurgeInterval = time.Hour
+
+	// roomPurgeBatchSize is how many rooms are hard-deleted per statement
+	// Each room takes all its messages with it, so batches stay small
+	roomPurgeBatchSize = 100
+)
+
+// RoomDeletionImpact is what deleting a room takes with it
+// Attachments aren't part of rooms or messages, so uploads are kept and aren't counted
+type RoomDeletionImpact struct {
+	Members  int `json:"members"`
+	Messages int `json:"messages"`
+	Pins     int `json:"pins"`
+}
+
+// RoomDeletionRequestResponse is a room's impact summary and the token that confirms its deletion
+type RoomDeletionRequestResponse struct {
+	RoomID            int64              `json:"room_id"`
+	RoomName          string             `json:"room_name"`
+	Impact            RoomDeletionImpact `json:"impact"`
+	ConfirmationToken string             `json:"confirmation_token"`
+	ExpiresAt         time.Time          `json:"expires_at"`
+}
+
+// DeleteRoomRequest confirms a room's deletion: the token from the deletion
+// request and the room's name, typed back
+type DeleteRoomRequest struct {
+	ConfirmationToken string `json:"confirmation_token"`
+	RoomName          string `json:"room_name"`
+}
+
+// roomDeletionRequestHandler is the first step of deleting a room
+// It changes nothing: it returns what the deletion would take with it and a
+// token, valid for 10 minutes, that DELETE /v1/rooms/{roomID} needs along
+// with the room's name. The token is bound to the room as it is now and to
+// the requester, so it's void once the room changes or is restored
+// POST /v1/rooms/{roomID}/delete-request
+// Requires authentication; only the room's creator or a server admin
+// Response: {"room_id": 1, "room_name": "general", "impact": {"members": 12, "messages": 5400, "pins": 3},
+// "confirmation_token": "...", "expires_at": "..."}
+func (app *application) roomDeletionRequestHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	room, err := app.store.Rooms.GetByID(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_lookup_failed")
+		return
+	}
+	if room.CreatedBy != userID {
+		admin, err := app.hasUserRole(r.Context(), userID, store.UserRoleAdmin)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "role_lookup_failed")
+			return
+		}
+		if !admin {
+			writeError(w, r, http.StatusForbidden, "room_delete_creator_only")
+			return
+		}
+	}
+
+	impact := RoomDeletionImpact{Members: room.MemberCount}
+	if impact.Messages, err = app.store.Messages.CountInRoom(r.Context(), roomID); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "room_impact_failed")
+		return
+	}
+	if impact.Pins, err = app.store.Pins.Count(r.Context(), roomID); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "room_impact_failed")
+		return
+	}
+
+	token, expiresAt, err := auth.GenerateRoomDeletionToken(userID, roomID, room.Version, app.config.auth.jwtSecret)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "room_delete_request_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, RoomDeletionRequestResponse{
+		RoomID:            room.ID,
+		RoomName:          room.Name,
+		Impact:            impact,
+		ConfirmationToken: token,
+		ExpiresAt:         expiresAt,
+	})
+}
+
+// deleteRoomHandler deletes a room, confirmed with a deletion request's token
+// The room is hidden right away and its connected clients are disconnected,
+// but nothing is removed until the restore window (ROOM_RESTORE_WINDOW) passes
+// DELETE /v1/rooms/{roomID}
+// Requires authentication and delete_room, or the admin server role; only
+// the creator or an admin can get a token
+// Request body: {"confirmation_token": "...", "room_name": "general"}
+// A token for another room or user, or from before the room last changed, and
+// a name that isn't the room's are 400s; an expired token is a 410
+// Response: 204 No Content
+func (app *application) deleteRoomHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	room, err := app.store.Rooms.GetByID(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_lookup_failed")
+		return
+	}
+
+	if !app.requireRoomPermissionOrRole(w, r, room.ID, userID, store.CapDeleteRoom, store.UserRoleAdmin) {
+		return
+	}
+
+	// No body at all is the old one-step call, which needs a confirmation now
+	var req DeleteRoomRequest
+	if err := readJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+		writeBodyError(w, r, err)
+		return
+	}
+	if req.ConfirmationToken == "" {
+		writeError(w, r, http.StatusBadRequest, "room_delete_confirmation_required")
+		return
+	}
+	claims, err := auth.ParseRoomDeletionToken(req.ConfirmationToken, app.config.auth.jwtSecret)
+	if errors.Is(err, auth.ErrExpiredToken) {
+		writeError(w, r, http.StatusGone, "room_delete_token_expired")
+		return
+	}
+	if err != nil || claims.RoomID != room.ID || claims.UserID != userID || claims.RoomVersion != room.Version {
+		writeError(w, r, http.StatusBadRequest, "invalid_room_delete_token")
+		return
+	}
+	if strings.TrimSpace(req.RoomName) != room.Name {
+		writeError(w, r, http.StatusBadRequest, "room_name_mismatch")
+		return
+	}
+
+	if err := app.store.Rooms.SoftDelete(r.Context(), roomID); err != nil {
+		// Someone else deleted it in the meantime
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_delete_failed")
+		return
+	}
+
+	// New connections are refused by the membership check; close the existing ones
+	app.roomAccessCache.invalidateRoom(roomID)
+	app.hub.CloseRoom(roomID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restoreRoomHandler brings back a deleted room within the restore window
+// Messages and memberships were kept, so the room returns exactly as it was
+// POST /v1/rooms/{roomID}/restore
+// Requires authentication and delete_room, or the admin server role
+// Response: {"id": 1, "name": "general", ...}
+func (app *application) restoreRoomHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	window := app.config.rooms.restoreWindow
+	room, err := app.store.Rooms.GetDeletedByID(r.Context(), roomID, window)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_not_restorable")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_lookup_failed")
+		return
+	}
+
+	if !app.requireRoomPermissionOrRole(w, r, room.ID, userID, store.CapDeleteRoom, store.UserRoleAdmin) {
+		return
+	}
+
+	if err := app.store.Rooms.Restore(r.Context(), roomID, window); err != nil {
+		// Restored by someone else, or the window closed in between
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_not_restorable")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_restore_failed")
+		return
+	}
+	app.roomAccessCache.invalidateRoom(roomID)
+
+	writeJSON(w, http.StatusOK, room)
+}
+
+// runRoomPurger permanently removes deleted rooms once their restore window has passed
+// It runs until ctx is done and should be started in a goroutine
+func (app *application) runRoomPurger(ctx context.Context) {
+	ticker := time.NewTicker(roomPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var total int64
+		for {
+			callCtx, cancel := context.WithTimeout(ctx, time.Minute)
+			purged, err := app.store.Rooms.PurgeExpired(callCtx, app.config.rooms.restoreWindow, roomPurgeBatchSize)
+			cancel()
+
+			if err != nil {
+				log.Printf("Failed to purge deleted rooms: %v", err)
+				break
+			}
+			total += purged
+
+			// A short batch means nothing is left for now
+			if purged < roomPurgeBatchSize {
+				break
+			}
+		}
+		if total > 0 {
+			log.Printf("Purged %d deleted rooms", total)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package chatapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/auth"
+	"github.com/drazan344/go-chat/internal/store"
+	ws "github.com/drazan344/go-chat/internal/websocket"
+	"github.com/gorilla/websocket"
+)
+
+// TestDeleteAndRestoreRoom deletes a room with a member connected: they're
+// told and disconnected with CloseRoomDeleted, the room is gone from the
+// API and can't be connected to, and restoring it brings it back with its
+// members. The confirmation used to delete it can't delete it again, either
+// while it's deleted or once it's restored
+// Only the creator may delete it; the creator and room admins may restore it
+func TestDeleteAndRestoreRoom(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.roomMembers.add(1, 3, store.RoomRoleAdmin)
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	server := newTestServer(t, ts)
+	conn := dialRoom(t, server, 1, 2)
+	readFrame(t, conn, "join")
+	confirmed := requestRoomDeletion(t, server, 1, 1)
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		userID int64
+		body   any
+		status int
+		code   string
+	}{
+		{"a member deletes", http.MethodDelete, "", 2, confirmed, http.StatusForbidden, "room_permission_denied"},
+		{"an admin deletes unconfirmed", http.MethodDelete, "", 3, nil, http.StatusBadRequest, "room_delete_confirmation_required"},
+		{"the creator deletes", http.MethodDelete, "", 1, confirmed, http.StatusNoContent, ""},
+		{"deleting again", http.MethodDelete, "", 1, confirmed, http.StatusNotFound, "room_not_found"},
+		{"reading it", http.MethodGet, "", 2, nil, http.StatusNotFound, "room_not_found"},
+		{"a member restores", http.MethodPost, "/restore", 2, nil, http.StatusForbidden, "room_permission_denied"},
+		{"the creator restores", http.MethodPost, "/restore", 1, nil, http.StatusOK, ""},
+		{"restoring again", http.MethodPost, "/restore", 1, nil, http.StatusNotFound, "room_not_restorable"},
+		{"reading it back", http.MethodGet, "", 2, nil, http.StatusOK, ""},
+		{"replaying the confirmation", http.MethodDelete, "", 1, confirmed, http.StatusBadRequest, "invalid_room_delete_token"},
+	} {
+		var failure errorBody
+		status := doJSON(t, tc.method, server.URL+"/v1/rooms/1"+tc.path, tc.userID, tc.body, &failure)
+		if status != tc.status || failure.Code != tc.code {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, status, failure.Code, tc.status, tc.code)
+		}
+
+		if tc.name == "the creator deletes" {
+			readFrame(t, conn, "room_deleted")
+			var closeErr *websocket.CloseError
+			if _, _, err := conn.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != ws.CloseRoomDeleted {
+				t.Errorf("after the deletion the connection got %v, want close code %d", err, ws.CloseRoomDeleted)
+			}
+			if status := dialStatus(t, server, 1, 2); status != http.StatusForbidden {
+				t.Errorf("connecting to the deleted room got %d, want 403", status)
+			}
+		}
+	}
+
+	// Memberships were never touched
+	if status := dialStatus(t, server, 1, 2); status != http.StatusSwitchingProtocols {
+		t.Errorf("connecting to the restored room got %d, want 101", status)
+	}
+}
+
+// TestRestoreWindow checks a room deleted longer ago than
+// ROOM_RESTORE_WINDOW can't be restored
+func TestRestoreWindow(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	server := newTestServer(t, ts, func(app *application) {
+		app.config.rooms.restoreWindow = time.Hour
+	})
+
+	confirmed := requestRoomDeletion(t, server, 1, 1)
+	if status := doJSON(t, http.MethodDelete, server.URL+"/v1/rooms/1", 1, confirmed, nil); status != http.StatusNoContent {
+		t.Fatalf("deleting got %d, want 204", status)
+	}
+	ts.rooms.mu.Lock()
+	ts.rooms.deleted[1] = time.Now().Add(-time.Hour - time.Second)
+	ts.rooms.mu.Unlock()
+
+	var failure errorBody
+	status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/restore", 1, nil, &failure)
+	if status != http.StatusNotFound || failure.Code != "room_not_restorable" {
+		t.Errorf("restoring after the window got %d %q, want 404 room_not_restorable", status, failure.Code)
+	}
+}
+
+// TestRoomDeletionRequest checks the impact summary the creator is shown and
+// that the deletion only goes through with that room's confirmation token,
+// for that user, and the room's name typed back
+func TestRoomDeletionRequest(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1, MemberCount: 3})
+	ts.rooms.add(&store.Room{ID: 2, Name: "random", CreatedBy: 1, MemberCount: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleOwner)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.roomMembers.add(1, 3, store.RoomRoleAdmin)
+	ts.roomMembers.add(2, 1, store.RoomRoleOwner)
+	ts.messages.addMessages(1, 1, 5)
+	ts.messages.addMessages(2, 1, 2)
+	if _, err := ts.pins.Pin(context.Background(), 1, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(t, ts)
+	requestURL := server.URL + "/v1/rooms/1/delete-request"
+
+	for userID, code := range map[int64]string{2: "room_delete_creator_only", 3: "room_delete_creator_only"} {
+		var failure errorBody
+		if status := doJSON(t, http.MethodPost, requestURL, userID, nil, &failure); status != http.StatusForbidden || failure.Code != code {
+			t.Errorf("user %d requesting the deletion got %d %q, want 403 %s", userID, status, failure.Code, code)
+		}
+	}
+
+	var summary RoomDeletionRequestResponse
+	if status := doJSON(t, http.MethodPost, requestURL, 1, nil, &summary); status != http.StatusOK {
+		t.Fatalf("requesting the deletion got %d, want 200", status)
+	}
+	if want := (RoomDeletionImpact{Members: 3, Messages: 5, Pins: 1}); summary.Impact != want || summary.RoomName != "general" {
+		t.Errorf("the summary is %+v for %q, want %+v for general", summary.Impact, summary.RoomName, want)
+	}
+	if d := time.Until(summary.ExpiresAt); d <= 0 || d > auth.RoomDeletionTokenLifetime {
+		t.Errorf("the confirmation expires in %s, want within %s", d, auth.RoomDeletionTokenLifetime)
+	}
+
+	other := requestRoomDeletion(t, server, 2, 1)
+	for _, tc := range []struct {
+		name   string
+		userID int64
+		body   DeleteRoomRequest
+		code   string
+	}{
+		{"another room's token", 1, DeleteRoomRequest{ConfirmationToken: other.ConfirmationToken, RoomName: "general"}, "invalid_room_delete_token"},
+		{"the creator's token used by an admin", 3, DeleteRoomRequest{ConfirmationToken: summary.ConfirmationToken, RoomName: "general"}, "invalid_room_delete_token"},
+		{"a garbled token", 1, DeleteRoomRequest{ConfirmationToken: "nope", RoomName: "general"}, "invalid_room_delete_token"},
+		{"a mistyped name", 1, DeleteRoomRequest{ConfirmationToken: summary.ConfirmationToken, RoomName: "General"}, "room_name_mismatch"},
+		{"no name", 1, DeleteRoomRequest{ConfirmationToken: summary.ConfirmationToken}, "room_name_mismatch"},
+	} {
+		var failure errorBody
+		status := doJSON(t, http.MethodDelete, server.URL+"/v1/rooms/1", tc.userID, tc.body, &failure)
+		if status != http.StatusBadRequest || failure.Code != tc.code {
+			t.Errorf("%s got %d %q, want 400 %s", tc.name, status, failure.Code, tc.code)
+		}
+	}
+
+	// The token isn't an access token
+	req := httptest.NewRequest(http.MethodGet, server.URL+"/v1/rooms/1", nil)
+	req.RequestURI = ""
+	req.Header.Set("Authorization", "Bearer "+summary.ConfirmationToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("authenticating with the confirmation token got %d, want 401", resp.StatusCode)
+	}
+
+	// Changing the room after the summary was shown voids it
+	settings := map[string]any{"description": "still in use"}
+	if status := doJSON(t, http.MethodPatch, server.URL+"/v1/rooms/1", 1, settings, nil); status != http.StatusOK {
+		t.Fatalf("updating the room got %d, want 200", status)
+	}
+	var failure errorBody
+	body := DeleteRoomRequest{ConfirmationToken: summary.ConfirmationToken, RoomName: "general"}
+	if status := doJSON(t, http.MethodDelete, server.URL+"/v1/rooms/1", 1, body, &failure); status != http.StatusBadRequest || failure.Code != "invalid_room_delete_token" {
+		t.Errorf("deleting with the summary from before the update got %d %q, want 400 invalid_room_delete_token", status, failure.Code)
+	}
+
+	body = DeleteRoomRequest{ConfirmationToken: requestRoomDeletion(t, server, 1, 1).ConfirmationToken, RoomName: " general "}
+	if status := doJSON(t, http.MethodDelete, server.URL+"/v1/rooms/1", 1, body, nil); status != http.StatusNoContent {
+		t.Errorf("deleting with a fresh confirmation got %d, want 204", status)
+	}
+}
+
+// requestRoomDeletion asks for userID's confirmation to delete roomID and
+// returns the body that confirms it
+func requestRoomDeletion(t *testing.T, server *httptest.Server, roomID, userID int64) DeleteRoomRequest {
+	t.Helper()
+	var summary RoomDeletionRequestResponse
+	url := fmt.Sprintf("%s/v1/rooms/%d/delete-request", server.URL, roomID)
+	if status := doJSON(t, http.MethodPost, url, userID, nil, &summary); status != http.StatusOK {
+		t.Fatalf("requesting the deletion of room %d got %d, want 200", roomID, status)
+	}
+	return DeleteRoomRequest{ConfirmationToken: summary.ConfirmationToken, RoomName: summary.RoomName}
+}
+
+// dialStatus tries to connect userID to roomID's WebSocket and returns the
+// HTTP status of the refusal, or 101 if the connection was accepted
+func dialStatus(t *testing.T, server *httptest.Server, roomID, userID int64) int {
+	t.Helper()
+	url := fmt.Sprintf("ws%s/v1/rooms/%d/ws", strings.TrimPrefix(server.URL, "http"), roomID)
+	header := asUser(t, httptest.NewRequest(http.MethodGet, url, nil), userID).Header
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err == nil {
+		conn.Close()
+	}
+	if resp == nil {
+		t.Fatalf("dialing room %d: %v", roomID, err)
+	}
+	return resp.StatusCode
+}
@@ -0,0 +1,129 @@
+package chatapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+const (
+	// maxRoomEventsLimit is the largest page of room events one request may ask for
+	maxRoomEventsLimit = 500
+
+	// roomEventPurgeInterval is how often events past the retention period are removed
+	roomEventPurgeInterval = time.Hour
+)
+
+// RoomEventsResponse is a page of a room's event log
+type RoomEventsResponse struct {
+	Events []*store.RoomEvent `json:"events"`
+
+	// LatestSeq is the sequence number to ask for next: the last event returned,
+	// or after_seq if there was nothing new
+	LatestSeq int64 `json:"latest_seq"`
+
+	// HasMore is true if the page was full and more events may follow
+	HasMore bool `json:"has_more"`
+}
+
+// listRoomEventsHandler replays the room changes a client missed while offline
+// Clients remember the highest event_seq they've seen on WebSocket frames (or
+// latest_seq from this endpoint) and ask for everything after it
+// If events after after_seq were already purged (ROOM_EVENT_RETENTION), the
+// response is 410 and the client must reload the room from scratch
+// GET /v1/rooms/{roomID}/events?after_seq=120&limit=500
+// Requires authentication and room membership
+// Response: {"events": [{"seq": 121, "type": "pin_added", "payload": {...}, ...}], "latest_seq": 121, "has_more": false}
+func (app *application) listRoomEventsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	var afterSeq int64
+	if raw := r.URL.Query().Get("after_seq"); raw != "" {
+		afterSeq, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil || afterSeq < 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid_pagination")
+			return
+		}
+	}
+	limit := maxRoomEventsLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxRoomEventsLimit {
+			writeError(w, r, http.StatusBadRequest, "invalid_pagination")
+			return
+		}
+		limit = n
+	}
+
+	isMember, err := app.store.RoomMembers.IsUserInRoom(r.Context(), roomID, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "membership_check_failed")
+		return
+	}
+	if !isMember {
+		writeError(w, r, http.StatusForbidden, "membership_required_events")
+		return
+	}
+
+	events, err := app.store.RoomEvents.ListAfter(r.Context(), roomID, afterSeq, limit)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrRoomEventsPurged):
+			writeError(w, r, http.StatusGone, "room_events_purged")
+		case errors.Is(err, sql.ErrNoRows):
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+		default:
+			writeError(w, r, http.StatusInternalServerError, "room_events_lookup_failed")
+		}
+		return
+	}
+
+	response := RoomEventsResponse{Events: events, LatestSeq: afterSeq, HasMore: len(events) == limit}
+	if len(events) > 0 {
+		response.LatestSeq = events[len(events)-1].Seq
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// runRoomEventPurger removes room events older than the retention period
+// It runs until ctx is done and should be started in a goroutine
+func (app *application) runRoomEventPurger(ctx context.Context) {
+	ticker := time.NewTicker(roomEventPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		callCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		purged, err := app.store.RoomEvents.PurgeOlderThan(callCtx, time.Now().Add(-app.config.rooms.eventRetention))
+		cancel()
+
+		if err != nil {
+			log.Printf("Failed to purge room events: %v", err)
+			continue
+		}
+		if purged > 0 {
+			log.Printf("Purged %d room events past retention", purged)
+		}
+	}
+}
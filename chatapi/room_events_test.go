@@ -0,0 +1,139 @@
+package chatapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestReplayRoomEvents has grace see ada's first pin live, then go offline
+// while ada pins another, unpins the first and reorders: asking for events
+// after the seq of the last frame grace saw replays the three in order
+func TestReplayRoomEvents(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "lobby", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	server := newTestServer(t, ts)
+	pins := server.URL + "/v1/rooms/1/pins"
+	events := server.URL + "/v1/rooms/1/events"
+
+	grace := dialRoom(t, server, 1, 2)
+	readFrame(t, grace, "join")
+	doJSON(t, http.MethodPost, pins+"/10", 1, nil, nil)
+	seen := readFrame(t, grace, "pin_added").EventSeq
+	if seen == 0 {
+		t.Fatal("the pin_added frame has no event_seq")
+	}
+	grace.Close()
+
+	var version pinsVersionResponse
+	doJSON(t, http.MethodPost, pins+"/11", 1, nil, nil)
+	doJSON(t, http.MethodDelete, pins+"/10", 1, nil, &version)
+	doJSON(t, http.MethodPut, pins+"/order", 1, PinOrderRequest{MessageIDs: []int64{11}, Version: version.Version}, nil)
+
+	var replay RoomEventsResponse
+	if status := doJSON(t, http.MethodGet, fmt.Sprintf("%s?after_seq=%d", events, seen), 2, nil, &replay); status != http.StatusOK {
+		t.Fatalf("replaying got %d, want 200", status)
+	}
+	var types []string
+	for _, event := range replay.Events {
+		types = append(types, event.Type)
+	}
+	want := []string{store.RoomEventPinAdded, store.RoomEventPinRemoved, store.RoomEventPinOrderChanged}
+	if !slices.Equal(types, want) || replay.HasMore {
+		t.Errorf("replayed %v with more %v, want %v and no more", types, replay.HasMore, want)
+	}
+	if replay.LatestSeq != replay.Events[len(replay.Events)-1].Seq {
+		t.Errorf("latest_seq is %d, want the last event's %d", replay.LatestSeq, replay.Events[len(replay.Events)-1].Seq)
+	}
+
+	// Page by page, each page picking up where the last left off
+	after := seen
+	for i := range want {
+		var page RoomEventsResponse
+		doJSON(t, http.MethodGet, fmt.Sprintf("%s?after_seq=%d&limit=1", events, after), 2, nil, &page)
+		if len(page.Events) != 1 || page.Events[0].Type != want[i] || !page.HasMore {
+			t.Fatalf("page %d has %d events, want %s and more to come", i, len(page.Events), want[i])
+		}
+		after = page.LatestSeq
+	}
+
+	// Up to date: nothing new, and latest_seq stays put
+	var current RoomEventsResponse
+	doJSON(t, http.MethodGet, fmt.Sprintf("%s?after_seq=%d", events, replay.LatestSeq), 2, nil, &current)
+	if len(current.Events) != 0 || current.LatestSeq != replay.LatestSeq {
+		t.Errorf("an up to date client got %d events and latest_seq %d", len(current.Events), current.LatestSeq)
+	}
+}
+
+// TestRoomEventsPurged purges the log past retention: a client behind the
+// purge gets 410 and must resync, while one that saw the last purged
+// event can still replay what came after
+func TestRoomEventsPurged(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "lobby", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	server := newTestServer(t, ts)
+	events := server.URL + "/v1/rooms/1/events"
+
+	var version pinsVersionResponse
+	doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/pins/10", 1, nil, &version)
+	doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/pins/11", 1, nil, nil)
+	purged, err := ts.roomEvents.PurgeOlderThan(context.Background(), time.Now().Add(time.Second))
+	if err != nil || purged != 2 {
+		t.Fatalf("purged %d events (%v), want 2", purged, err)
+	}
+	doJSON(t, http.MethodDelete, server.URL+"/v1/rooms/1/pins/10", 1, nil, nil)
+
+	for _, tc := range []struct {
+		after  int64
+		status int
+		code   string
+		events int
+	}{
+		{0, http.StatusGone, "room_events_purged", 0},
+		{1, http.StatusGone, "room_events_purged", 0},
+		{2, http.StatusOK, "", 1},
+	} {
+		var replay struct {
+			RoomEventsResponse
+			errorBody
+		}
+		status := doJSON(t, http.MethodGet, fmt.Sprintf("%s?after_seq=%d", events, tc.after), 1, nil, &replay)
+		if status != tc.status || replay.Code != tc.code || len(replay.Events) != tc.events {
+			t.Errorf("after %d: got %d %q with %d events, want %d %q with %d", tc.after, status, replay.Code, len(replay.Events), tc.status, tc.code, tc.events)
+		}
+	}
+}
+
+// TestRoomEventsRefused checks members only, and the paging parameters
+func TestRoomEventsRefused(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "lobby", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	server := newTestServer(t, ts)
+
+	for _, tc := range []struct {
+		query  string
+		userID int64
+		status int
+		code   string
+	}{
+		{"", 2, http.StatusForbidden, "membership_required_events"},
+		{"?after_seq=-1", 1, http.StatusBadRequest, "invalid_pagination"},
+		{"?limit=501", 1, http.StatusBadRequest, "invalid_pagination"},
+		{"?limit=0", 1, http.StatusBadRequest, "invalid_pagination"},
+	} {
+		var failure errorBody
+		if status := doJSON(t, http.MethodGet, server.URL+"/v1/rooms/1/events"+tc.query, tc.userID, nil, &failure); status != tc.status || failure.Code != tc.code {
+			t.Errorf("%q as user %d: got %d %q, want %d %q", tc.query, tc.userID, status, failure.Code, tc.status, tc.code)
+		}
+	}
+}
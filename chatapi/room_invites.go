@@ -0,0 +1,246 @@
+package chatapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/websocket"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// roomInviteTTL is how long a registered user has to answer a room invite
+const roomInviteTTL = 14 * 24 * time.Hour
+
+// RoomInviteRequest names the registered user to invite to a room
+type RoomInviteRequest struct {
+	Username string `json:"username"`
+}
+
+// createRoomInviteHandler invites a registered user to a room
+// Unlike a bulk add they join only once they accept. The invite is pushed to
+// every connection they have open as an "invite" frame and is in their inbox
+// (GET /v1/users/me/invites) until answered; inviting them again refreshes
+// the open invite. Invites expire after 14 days
+// POST /v1/rooms/{roomID}/members/invite
+// Requires authentication and manage_members (by default the owner and admins)
+// Request body: {"username": "grace"}
+// Response (201): {"id": 7, "room_id": 1, "room_name": "general", "invitee_id": 5, "status": "pending", ...}
+func (app *application) createRoomInviteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	var req RoomInviteRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" {
+		writeError(w, r, http.StatusBadRequest, "invite_username_required")
+		return
+	}
+
+	if !app.requireRoomPermission(w, r, roomID, userID, store.CapManageMembers) {
+		return
+	}
+
+	room, err := app.store.Rooms.GetByID(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_lookup_failed")
+		return
+	}
+
+	invitee, err := app.store.Users.GetByUsername(r.Context(), req.Username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "user_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return
+	}
+
+	isMember, err := app.store.RoomMembers.IsUserInRoom(r.Context(), roomID, invitee.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "membership_check_failed")
+		return
+	}
+	if isMember {
+		writeError(w, r, http.StatusConflict, "already_member")
+		return
+	}
+
+	inviter, err := app.store.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return
+	}
+
+	invite := &store.RoomInvite{RoomID: roomID, InviteeID: invitee.ID, InvitedBy: &userID}
+	if err := app.store.RoomInvites.Create(r.Context(), invite, roomInviteTTL); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "room_invite_failed")
+		return
+	}
+	invite.RoomName, invite.InviterName = room.Name, inviter.Username
+
+	app.pushRoomInvite(r.Context(), invite, room)
+	writeJSON(w, http.StatusCreated, invite)
+}
+
+// pushRoomInvite sends the invitee an "invite" frame on each connection they
+// have open; nothing is sent when they're offline, the inbox has it
+// The frame is flagged to alert them unless they turned room_invite
+// notifications off
+func (app *application) pushRoomInvite(ctx context.Context, invite *store.RoomInvite, room *store.Room) {
+	if !app.hub.IsUserOnline(invite.InviteeID) {
+		return
+	}
+	frame := &websocket.Message{Message: wire.Message{
+		RoomID:   invite.RoomID,
+		Type:     "invite",
+		InviteID: invite.ID,
+		Room:     wireRoom(room),
+		From:     invite.InviterName,
+		Content:  fmt.Sprintf("%s invited you to %s", invite.InviterName, room.Name),
+		Notify:   app.notifications.Allowed(ctx, invite.InviteeID, store.NotifyChannelWebSocket, store.NotifyEventRoomInvite),
+	}}
+	app.hub.SendToUser(invite.InviteeID, frame)
+}
+
+// wireRoom is the summary of a room invite frames carry
+func wireRoom(room *store.Room) *wire.Room {
+	return &wire.Room{ID: room.ID, Name: room.Name, Description: room.Description, MemberCount: room.MemberCount}
+}
+
+// listRoomInvitesHandler returns the current user's open invites, newest first
+// Clients that were offline when invited read them here
+// GET /v1/users/me/invites
+// Requires authentication
+// Response: [{"id": 7, "room_id": 1, "room_name": "general", "inviter_name": "ada", ...}]
+func (app *application) listRoomInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	invites, err := app.store.RoomInvites.ListPending(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "room_invites_lookup_failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, invites)
+}
+
+// acceptRoomInviteHandler accepts one of the current user's invites and joins the room
+// POST /v1/users/me/invites/{inviteID}/accept
+// Requires authentication
+// Accepting again is harmless; the membership limits apply as to a join
+// Response: the answered invite, {"id": 7, "status": "accepted", ...}
+func (app *application) acceptRoomInviteHandler(w http.ResponseWriter, r *http.Request) {
+	app.answerRoomInvite(w, r, true)
+}
+
+// declineRoomInviteHandler declines one of the current user's invites
+// POST /v1/users/me/invites/{inviteID}/decline
+// Requires authentication
+// Declining again is harmless
+// Response: the answered invite, {"id": 7, "status": "declined", ...}
+func (app *application) declineRoomInviteHandler(w http.ResponseWriter, r *http.Request) {
+	app.answerRoomInvite(w, r, false)
+}
+
+// answerRoomInvite is the shared implementation of accept and decline
+func (app *application) answerRoomInvite(w http.ResponseWriter, r *http.Request, accept bool) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	inviteID, err := extractIDFromURL(r, "inviteID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "inviteID")
+		return
+	}
+
+	invite, err := app.respondToInvite(r.Context(), userID, inviteID, accept)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrInviteNotFound):
+			writeError(w, r, http.StatusNotFound, "invite_not_found")
+		case errors.Is(err, store.ErrInviteExpired):
+			writeError(w, r, http.StatusGone, "invite_expired")
+		case errors.Is(err, store.ErrInviteAnswered):
+			writeError(w, r, http.StatusConflict, "invite_already_answered")
+		case app.writeLimitError(w, r, err):
+		default:
+			writeError(w, r, http.StatusInternalServerError, "invite_response_failed")
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, invite)
+}
+
+// RespondToInvite answers an invite for an "invite_response" frame
+// It makes the application the hub's websocket.InviteResponder
+func (app *application) RespondToInvite(ctx context.Context, userID, inviteID int64, accept bool) error {
+	_, err := app.respondToInvite(ctx, userID, inviteID, accept)
+	return err
+}
+
+// respondToInvite answers an invite, over REST or the socket, and confirms
+// it with an "invite_accepted" or "invite_declined" frame
+// The invitee and the inviter are both told when this call answered it; an
+// answer repeated (a retry, a second tab) is confirmed to the invitee alone
+// The room's caches are dropped before anyone is told, so the new member
+// can open its WebSocket as soon as the confirmation arrives
+func (app *application) respondToInvite(ctx context.Context, userID, inviteID int64, accept bool) (*store.RoomInvite, error) {
+	invite, changed, err := app.store.RoomInvites.Respond(ctx, inviteID, userID, accept)
+	if err != nil {
+		return nil, err
+	}
+	if changed && accept {
+		app.roomMembersChanged(invite.RoomID)
+	}
+
+	recipients := []int64{invite.InviteeID}
+	if changed && invite.InvitedBy != nil && *invite.InvitedBy != invite.InviteeID {
+		recipients = append(recipients, *invite.InvitedBy)
+	}
+	frame := &websocket.Message{Message: wire.Message{
+		RoomID:   invite.RoomID,
+		UserID:   invite.InviteeID,
+		Type:     "invite_" + invite.Status,
+		InviteID: invite.ID,
+		From:     invite.InviterName,
+	}}
+	if room, err := app.store.Rooms.GetByID(ctx, invite.RoomID); err == nil {
+		frame.Room = wireRoom(room)
+	}
+	if invitee, err := app.store.Users.GetByID(ctx, invite.InviteeID); err == nil {
+		frame.Username = invitee.Username
+		frame.Content = fmt.Sprintf("%s %s the invite to %s", invitee.Username, invite.Status, invite.RoomName)
+	}
+	app.hub.NotifyUsers(recipients, frame)
+	return invite, nil
+}
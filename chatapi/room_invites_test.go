@@ -0,0 +1,183 @@
+package chatapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/gorilla/websocket"
+)
+
+// roomInviteServer serves general (1), where ada is an admin and linus a
+// member, and random (2), where grace and linus are members
+func roomInviteServer(t *testing.T) (*httptest.Server, *testStore) {
+	t.Helper()
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 9})
+	ts.rooms.add(&store.Room{ID: 2, Name: "random", CreatedBy: 9})
+	for id, name := range map[int64]string{1: "ada", 2: "grace", 3: "linus", 9: "owner"} {
+		ts.users.add(&store.User{ID: id, Username: name})
+	}
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 3, store.RoomRoleMember)
+	ts.roomMembers.add(2, 2, store.RoomRoleMember)
+	ts.roomMembers.add(2, 3, store.RoomRoleMember)
+	return newTestServer(t, ts), ts
+}
+
+// TestRoomInviteLive has ada, connected to general, invite grace, connected
+// to random: grace gets the invite on that connection and accepts it there,
+// both are told, and grace can open general's WebSocket at once. Answering
+// again is confirmed again, and answers to invites that are someone else's
+// or expired come back as error frames
+func TestRoomInviteLive(t *testing.T) {
+	server, ts := roomInviteServer(t)
+	ada := dialRoom(t, server, 1, 1)
+	readFrame(t, ada, "join")
+	grace := dialRoom(t, server, 2, 2)
+	readFrame(t, grace, "join")
+	linus := dialRoom(t, server, 2, 3)
+	readFrame(t, linus, "join")
+
+	inviteURL := server.URL + "/v1/rooms/1/members/invite"
+	if status := doJSON(t, http.MethodPost, inviteURL, 3, RoomInviteRequest{Username: "grace"}, nil); status != http.StatusForbidden {
+		t.Errorf("a member inviting got %d, want 403", status)
+	}
+	if status := doJSON(t, http.MethodPost, inviteURL, 1, RoomInviteRequest{Username: "nobody"}, nil); status != http.StatusNotFound {
+		t.Errorf("inviting an unknown user got %d, want 404", status)
+	}
+	if status := doJSON(t, http.MethodPost, inviteURL, 1, RoomInviteRequest{Username: "linus"}, nil); status != http.StatusConflict {
+		t.Errorf("inviting a member got %d, want 409", status)
+	}
+	// Before the invite, so a cached refusal would keep grace out after it
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/rooms/1/messages", 2, nil, nil); status != http.StatusForbidden {
+		t.Fatalf("grace reading general before joining got %d, want 403", status)
+	}
+
+	var invite store.RoomInvite
+	if status := doJSON(t, http.MethodPost, inviteURL, 1, RoomInviteRequest{Username: "grace"}, &invite); status != http.StatusCreated {
+		t.Fatalf("inviting grace got %d, want 201", status)
+	}
+	frame := readFrame(t, grace, "invite")
+	if frame.InviteID != invite.ID || frame.From != "ada" || frame.Room == nil || frame.Room.Name != "general" {
+		t.Errorf("grace got invite %d from %q for %+v, want invite %d from ada for general", frame.InviteID, frame.From, frame.Room, invite.ID)
+	}
+	if !frame.Notify {
+		t.Error("the invite wasn't flagged to alert grace")
+	}
+
+	accept := map[string]any{"type": "invite_response", "invite_id": invite.ID, "accept": true}
+	if err := grace.WriteJSON(accept); err != nil {
+		t.Fatal(err)
+	}
+	for name, conn := range map[string]*websocket.Conn{"grace": grace, "ada": ada} {
+		frame := readFrame(t, conn, "invite_accepted")
+		if frame.InviteID != invite.ID || frame.UserID != 2 || frame.Username != "grace" || frame.Room == nil || frame.Room.ID != 1 {
+			t.Errorf("%s got invite_accepted for invite %d by %d (%q) with room %+v", name, frame.InviteID, frame.UserID, frame.Username, frame.Room)
+		}
+	}
+	conn := dialRoom(t, server, 1, 2)
+	readFrame(t, conn, "join")
+
+	// A retry is confirmed again; answering the other way is refused
+	if err := grace.WriteJSON(accept); err != nil {
+		t.Fatal(err)
+	}
+	if frame := readFrame(t, grace, "invite_accepted"); frame.InviteID != invite.ID {
+		t.Errorf("the repeated answer was confirmed for invite %d, want %d", frame.InviteID, invite.ID)
+	}
+	if err := grace.WriteJSON(map[string]any{"type": "invite_response", "invite_id": invite.ID, "accept": false}); err != nil {
+		t.Fatal(err)
+	}
+	if frame := readFrame(t, grace, "error"); frame.Code != "invite_already_answered" || frame.InviteID != invite.ID {
+		t.Errorf("declining an accepted invite got %q for invite %d, want invite_already_answered", frame.Code, frame.InviteID)
+	}
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/rooms/1/messages", 2, nil, nil); status != http.StatusOK {
+		t.Errorf("grace reading general after answering again got %d, want 200", status)
+	}
+
+	// Someone else's invite is as good as none
+	if err := linus.WriteJSON(accept); err != nil {
+		t.Fatal(err)
+	}
+	if frame := readFrame(t, linus, "error"); frame.Code != "invite_not_found" || frame.InviteID != invite.ID {
+		t.Errorf("answering grace's invite got %q for invite %d, want invite_not_found", frame.Code, frame.InviteID)
+	}
+
+	ts.rooms.add(&store.Room{ID: 3, Name: "ops", CreatedBy: 1})
+	ts.roomMembers.add(3, 1, store.RoomRoleAdmin)
+	var expired store.RoomInvite
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/3/members/invite", 1, RoomInviteRequest{Username: "linus"}, &expired); status != http.StatusCreated {
+		t.Fatalf("inviting linus got %d, want 201", status)
+	}
+	readFrame(t, linus, "invite")
+	ts.roomInvites.expire(expired.ID)
+	if err := linus.WriteJSON(map[string]any{"type": "invite_response", "invite_id": expired.ID, "accept": true}); err != nil {
+		t.Fatal(err)
+	}
+	if frame := readFrame(t, linus, "error"); frame.Code != "invite_expired" {
+		t.Errorf("answering an expired invite got %q, want invite_expired", frame.Code)
+	}
+	if err := linus.WriteJSON(map[string]any{"type": "invite_response", "invite_id": expired.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if frame := readFrame(t, linus, "error"); frame.Code != "invalid_invite_response" {
+		t.Errorf("an answer without accept got %q, want invalid_invite_response", frame.Code)
+	}
+}
+
+// TestRoomInviteInbox invites grace while they're offline: the invite waits
+// in their inbox, inviting again refreshes it, and they decline it over REST,
+// which ada hears about live
+func TestRoomInviteInbox(t *testing.T) {
+	server, _ := roomInviteServer(t)
+	ada := dialRoom(t, server, 1, 1)
+	readFrame(t, ada, "join")
+
+	inviteURL := server.URL + "/v1/rooms/1/members/invite"
+	var first, again store.RoomInvite
+	doJSON(t, http.MethodPost, inviteURL, 1, RoomInviteRequest{Username: "grace"}, &first)
+	if status := doJSON(t, http.MethodPost, inviteURL, 1, RoomInviteRequest{Username: "grace"}, &again); status != http.StatusCreated || again.ID != first.ID {
+		t.Errorf("inviting grace again got %d with invite %d, want 201 with invite %d", status, again.ID, first.ID)
+	}
+
+	var inbox []*store.RoomInvite
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/users/me/invites", 2, nil, &inbox); status != http.StatusOK {
+		t.Fatalf("reading the inbox got %d, want 200", status)
+	}
+	if len(inbox) != 1 || inbox[0].ID != first.ID || inbox[0].RoomName != "general" || inbox[0].InviterName != "ada" {
+		t.Fatalf("grace's inbox is %+v, want ada's invite to general", inbox)
+	}
+
+	answerURL := func(inviteID int64, answer string) string {
+		return fmt.Sprintf("%s/v1/users/me/invites/%d/%s", server.URL, inviteID, answer)
+	}
+	if status := doJSON(t, http.MethodPost, answerURL(first.ID, "decline"), 3, nil, nil); status != http.StatusNotFound {
+		t.Errorf("linus declining grace's invite got %d, want 404", status)
+	}
+	var declined store.RoomInvite
+	if status := doJSON(t, http.MethodPost, answerURL(first.ID, "decline"), 2, nil, &declined); status != http.StatusOK || declined.Status != store.RoomInviteDeclined {
+		t.Errorf("declining got %d with status %q, want 200 declined", status, declined.Status)
+	}
+	if frame := readFrame(t, ada, "invite_declined"); frame.InviteID != first.ID || frame.Username != "grace" {
+		t.Errorf("ada was told invite %d was declined by %q, want invite %d by grace", frame.InviteID, frame.Username, first.ID)
+	}
+	if status := doJSON(t, http.MethodPost, answerURL(first.ID, "decline"), 2, nil, nil); status != http.StatusOK {
+		t.Errorf("declining again got %d, want 200", status)
+	}
+	if status := doJSON(t, http.MethodPost, answerURL(first.ID, "accept"), 2, nil, nil); status != http.StatusConflict {
+		t.Errorf("accepting a declined invite got %d, want 409", status)
+	}
+	if status := doJSON(t, http.MethodPost, answerURL(first.ID+10, "accept"), 2, nil, nil); status != http.StatusNotFound {
+		t.Errorf("accepting an unknown invite got %d, want 404", status)
+	}
+	doJSON(t, http.MethodGet, server.URL+"/v1/users/me/invites", 2, nil, &inbox)
+	if len(inbox) != 0 {
+		t.Errorf("grace's inbox has %d invites after declining, want none", len(inbox))
+	}
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/rooms/1/messages", 2, nil, nil); status != http.StatusForbidden {
+		t.Errorf("grace reading general after declining got %d, want 403", status)
+	}
+}
@@ -0,0 +1,112 @@
+package chatapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/sysmsg"
+)
+
+// TestRoomLanguage sets a room's language through PATCH /v1/rooms/{id}: the
+// tag is saved in its usual case and the hub renders the room's system
+// messages in it at once; a malformed tag is refused, and "" goes back to
+// DEFAULT_ROOM_LANGUAGE
+func TestRoomLanguage(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 2, JoinPolicy: store.JoinPolicyOpen, Version: 1})
+	ts.roomMembers.add(1, 2, store.RoomRoleOwner)
+	ts.roomMembers.add(1, 3, store.RoomRoleMember)
+	t.Setenv("DEFAULT_ROOM_LANGUAGE", "fr")
+	chat := newEmbeddedChat(t, ts, filepath.Join(t.TempDir(), "missing.env"))
+	server := httptest.NewServer(chat.Handler())
+	defer server.Close()
+	url := server.URL + "/v1/rooms/1"
+	hub := chat.app.hub
+
+	// setLanguage answers with the room or an error; the two don't share fields
+	setLanguage := func(userID int64, language string) (int, *store.Room, errorBody) {
+		t.Helper()
+		var resp struct {
+			store.Room
+			errorBody
+		}
+		status := doJSON(t, http.MethodPatch, url, userID, map[string]string{"language": language}, &resp)
+		return status, &resp.Room, resp.errorBody
+	}
+
+	status, room, _ := setLanguage(2, "de-at")
+	if status != http.StatusOK || room.Language != "de-AT" {
+		t.Fatalf("setting de-at got %d with language %q, want 200 and de-AT", status, room.Language)
+	}
+	if got := hub.RoomLanguage(context.Background(), 1); got != "de-AT" {
+		t.Errorf("the hub renders the room in %q, want de-AT", got)
+	}
+	if got, want := sysmsg.Render(sysmsg.Join("ada"), hub.RoomLanguage(context.Background(), 1)), "ada ist dem Raum beigetreten"; got != want {
+		t.Errorf("a join renders as %q, want %q", got, want)
+	}
+
+	for _, c := range []struct {
+		name     string
+		userID   int64
+		language string
+		status   int
+		code     string
+	}{
+		{"malformed tag", 2, "german", http.StatusBadRequest, "invalid_room_language"},
+		{"underscore", 2, "de_AT", http.StatusBadRequest, "invalid_room_language"},
+		{"not the owner", 3, "en", http.StatusForbidden, "room_permission_denied"},
+	} {
+		status, _, failure := setLanguage(c.userID, c.language)
+		if status != c.status || failure.Code != c.code {
+			t.Errorf("%s: got %d %q, want %d %s", c.name, status, failure.Code, c.status, c.code)
+		}
+	}
+	if saved, _ := ts.rooms.GetByID(context.Background(), 1); saved.Language != "de-AT" {
+		t.Errorf("a refused change left the language %q, want de-AT", saved.Language)
+	}
+
+	status, room, _ = setLanguage(2, "")
+	if status != http.StatusOK || room.Language != "" {
+		t.Fatalf("resetting the language got %d with language %q, want 200 and none", status, room.Language)
+	}
+	if got := hub.RoomLanguage(context.Background(), 1); got != "fr" {
+		t.Errorf("after a reset the hub renders the room in %q, want the default fr", got)
+	}
+}
+
+// TestSystemMessagePreview cuts a rendered system message to the length of
+// the previews the store makes for other messages
+func TestSystemMessagePreview(t *testing.T) {
+	if got, want := systemMessagePreview(sysmsg.Leave("ada"), "de"), "ada hat den Raum verlassen"; got != want {
+		t.Errorf("the preview is %q, want %q", got, want)
+	}
+	long := systemMessagePreview(sysmsg.Merged(strings.Repeat("ü", 100)), "en")
+	if n := len([]rune(long)); n != lastMessagePreviewLength || long != strings.Repeat("ü", lastMessagePreviewLength) {
+		t.Errorf("a long preview has %d characters, want the first %d", n, lastMessagePreviewLength)
+	}
+}
+
+// TestDefaultRoomLanguageConfig checks DEFAULT_ROOM_LANGUAGE is canonicalized
+// and refused when it isn't a language tag
+func TestDefaultRoomLanguageConfig(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), "missing.env")
+	t.Setenv("JWT_SECRET", testSecret)
+	t.Setenv("DEFAULT_ROOM_LANGUAGE", "pt-br")
+	cfg, err := LoadConfig(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.config.rooms.defaultLanguage != "pt-BR" {
+		t.Errorf("the default language is %q, want pt-BR", cfg.config.rooms.defaultLanguage)
+	}
+
+	t.Setenv("DEFAULT_ROOM_LANGUAGE", "portuguese")
+	if _, err := LoadConfig(envFile); err == nil || !strings.Contains(err.Error(), "DEFAULT_ROOM_LANGUAGE") {
+		t.Errorf("loading a malformed default language returned %v, want an error naming DEFAULT_ROOM_LANGUAGE", err)
+	}
+}
@@ -0,0 +1,113 @@
+package chatapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/gorilla/websocket"
+)
+
+// roomMentionServer serves room 1 with ada as its admin and grace and linus
+// as members; grace and linus are connected
+func roomMentionServer(t *testing.T) (*httptest.Server, *testStore, map[string]*websocket.Conn) {
+	t.Helper()
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 9})
+	ts.users.add(&store.User{ID: 9, Username: "owner"})
+	for id, name := range map[int64]string{1: "ada", 2: "grace", 3: "linus"} {
+		ts.users.add(&store.User{ID: id, Username: name})
+		ts.roomMembers.add(1, id, store.RoomRoleMember)
+	}
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	server := newTestServer(t, ts)
+
+	conns := make(map[string]*websocket.Conn)
+	for id, name := range map[int64]string{2: "grace", 3: "linus"} {
+		conns[name] = dialRoom(t, server, 1, id)
+		readFrame(t, conns[name], "join")
+	}
+	return server, ts, conns
+}
+
+// TestRoomMentionDenied has grace, a member, write @here and @room: the
+// message is sent as plain text, nobody is alerted, and grace is told why
+// over their connection; over REST the reply has no room_mention
+func TestRoomMentionDenied(t *testing.T) {
+	server, _, conns := roomMentionServer(t)
+
+	if err := conns["grace"].WriteJSON(map[string]string{"content": "@room lunch?"}); err != nil {
+		t.Fatal(err)
+	}
+	notice := readFrame(t, conns["grace"], "notice")
+	if notice.Code != "mention_everyone_denied" {
+		t.Errorf("the notice has code %q, want mention_everyone_denied", notice.Code)
+	}
+	if frame := readFrame(t, conns["linus"], "message"); frame.Content != "@room lunch?" || frame.RoomMention != "" || frame.Notify {
+		t.Errorf("linus got %+v, want the plain text without an alert", frame.Message)
+	}
+
+	var sent store.Message
+	body := SendMessageRequest{Content: "@here anyone?"}
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/messages", 2, body, &sent); status != http.StatusCreated || sent.RoomMention != "" {
+		t.Errorf("sending over REST got %d with room_mention %q, want 201 without one", status, sent.RoomMention)
+	}
+	if frame := readFrame(t, conns["linus"], "message"); frame.Notify {
+		t.Error("linus was alerted by a member's @here")
+	}
+}
+
+// TestRoomMentionTargets has ada, an admin, use @here and @room: each alerts
+// everyone connected and is marked in the history; only @room is limited,
+// to one per 10 minutes, over both transports
+// Reaching members who aren't connected is push's and the unread counts'
+// (see push.TestRoomMentionPush)
+func TestRoomMentionTargets(t *testing.T) {
+	server, ts, conns := roomMentionServer(t)
+	ada := dialRoom(t, server, 1, 1)
+	readFrame(t, ada, "join")
+
+	for _, tc := range []struct {
+		content, scope string
+	}{{"@here standup", "here"}, {"@here again", "here"}, {"@room release at 5", "room"}} {
+		if err := ada.WriteJSON(map[string]string{"content": tc.content}); err != nil {
+			t.Fatal(err)
+		}
+		for name, conn := range conns {
+			if frame := readFrame(t, conn, "message"); !frame.Notify || frame.RoomMention != tc.scope {
+				t.Errorf("%q: %s got notify %v and room_mention %q, want an alert and %q", tc.content, name, frame.Notify, frame.RoomMention, tc.scope)
+			}
+		}
+		if frame := readFrame(t, ada, "message"); frame.Notify {
+			t.Errorf("%q: the sender was alerted", tc.content)
+		}
+	}
+
+	var history []*store.Message
+	doJSON(t, http.MethodGet, server.URL+"/v1/rooms/1/messages", 2, nil, &history)
+	if len(history) != 3 || history[0].RoomMention != "here" || history[2].RoomMention != "room" {
+		t.Errorf("the history is %+v, want the messages marked here, here and room", history)
+	}
+
+	if err := ada.WriteJSON(map[string]string{"content": "@room one more thing"}); err != nil {
+		t.Fatal(err)
+	}
+	if frame := readFrame(t, ada, "error"); frame.Code != "room_mention_rate_limited" {
+		t.Errorf("a second @room got %q, want room_mention_rate_limited", frame.Code)
+	}
+	req := asUser(t, httptest.NewRequest(http.MethodPost, server.URL+"/v1/rooms/1/messages", strings.NewReader(`{"content": "@room really"}`)), 1)
+	req.RequestURI = ""
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("a second @room over REST got %d with Retry-After %q, want 429 with one", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if messages, _ := ts.messages.GetRoomMessages(t.Context(), 1, 100); len(messages) != 3 {
+		t.Errorf("%d messages were saved, want the limited ones refused", len(messages))
+	}
+}
@@ -0,0 +1,160 @@
+package chatapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+	ws "github.com/drazan344/go-chat/internal/websocket"
+	"github.com/gorilla/websocket"
+)
+
+// mergingRooms merges rooms in memory: the source's members join the
+// target, keeping the higher role, and the source is deleted
+// Moving messages, pins and read markers is the store's job and is tested there
+type mergingRooms struct {
+	*fakeRooms
+	members *fakeRoomMembers
+}
+
+func (r mergingRooms) Merge(ctx context.Context, sourceID, targetID, _ int64) (*store.RoomMergeResult, error) {
+	if sourceID == targetID {
+		return nil, store.ErrMergeSameRoom
+	}
+	if _, err := r.GetByID(ctx, sourceID); err != nil {
+		return nil, err
+	}
+	if _, err := r.GetByID(ctx, targetID); err != nil {
+		if r.isDeleted(targetID) {
+			return nil, store.ErrMergeTargetDeleted
+		}
+		return nil, err
+	}
+
+	result := &store.RoomMergeResult{SourceRoomID: sourceID, TargetRoomID: targetID}
+	r.members.mu.Lock()
+	for userID, role := range r.members.roles[sourceID] {
+		result.Members = append(result.Members, userID)
+		current, ok := r.members.roles[targetID][userID]
+		if !ok {
+			result.NewMembers = append(result.NewMembers, userID)
+		}
+		if !ok || role == store.RoomRoleAdmin {
+			r.members.roles[targetID][userID] = cmpRole(current, role)
+		}
+	}
+	delete(r.members.roles, sourceID)
+	r.members.mu.Unlock()
+	result.MembersMoved, result.MembersAdded = len(result.Members), len(result.NewMembers)
+	return result, r.SoftDelete(ctx, sourceID)
+}
+
+// cmpRole returns the higher of two roles
+func cmpRole(a, b string) string {
+	if a == store.RoomRoleAdmin || b == store.RoomRoleAdmin {
+		return store.RoomRoleAdmin
+	}
+	return store.RoomRoleMember
+}
+
+// TestMergeRoom has ada merge room 1 into room 2, both created by ada
+// linus is in both and keeps the admin role from room 1; grace joins room
+// 2 and is told so in room 3, where they're connected. Everyone connected
+// to room 1 gets room_merged naming room 2 and is closed with CloseRoomMerged,
+// and ada, connected to room 2, sees the system user's notice of the merge
+func TestMergeRoom(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "gophers", CreatedBy: 1})
+	ts.rooms.add(&store.Room{ID: 2, Name: "golang", CreatedBy: 1})
+	ts.rooms.add(&store.Room{ID: 3, Name: "random", CreatedBy: 4})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.roomMembers.add(1, 3, store.RoomRoleAdmin)
+	ts.roomMembers.add(2, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(2, 3, store.RoomRoleMember)
+	ts.roomMembers.add(3, 2, store.RoomRoleMember)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	ts.users.add(&store.User{ID: 3, Username: "linus"})
+	ts.Rooms = mergingRooms{ts.rooms, ts.roomMembers}
+	server := newTestServer(t, ts)
+
+	linus := dialRoom(t, server, 1, 3)
+	readFrame(t, linus, "join")
+	grace := dialRoom(t, server, 3, 2)
+	readFrame(t, grace, "join")
+	ada := dialRoom(t, server, 2, 1)
+	readFrame(t, ada, "join")
+
+	var result store.RoomMergeResult
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/merge", 1, MergeRoomRequest{TargetRoomID: 2}, &result); status != http.StatusOK {
+		t.Fatalf("merging got %d, want 200", status)
+	}
+	if result.MembersMoved != 3 || result.MembersAdded != 1 {
+		t.Errorf("moved %d members and added %d, want 3 and 1", result.MembersMoved, result.MembersAdded)
+	}
+
+	merged := readFrame(t, linus, "room_merged")
+	if merged.TargetRoomID != 2 {
+		t.Errorf("room_merged named room %d, want 2", merged.TargetRoomID)
+	}
+	var closeErr *websocket.CloseError
+	if _, _, err := linus.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != ws.CloseRoomMerged || closeErr.Text != "merged into room 2" {
+		t.Errorf("after the merge the connection got %v, want close code %d", err, ws.CloseRoomMerged)
+	}
+	if added := readFrame(t, grace, "member_added"); added.RoomID != 2 {
+		t.Errorf("grace was told about room %d, want 2", added.RoomID)
+	}
+	notice := readFrame(t, ada, "message")
+	if notice.UserID != store.SystemUserID || !notice.System || notice.Username != "system" || notice.Content != "gophers was merged into this room" || notice.ID == 0 {
+		t.Errorf("room 2 got %+v, want the system user's saved merge notice", notice.Message)
+	}
+
+	ctx := context.Background()
+	for userID, want := range map[int64]string{1: store.RoomRoleAdmin, 2: store.RoomRoleMember, 3: store.RoomRoleAdmin} {
+		if admin, _ := ts.roomMembers.IsRoomAdmin(ctx, 2, userID); admin != (want == store.RoomRoleAdmin) {
+			t.Errorf("user %d is admin of room 2: %v, want %s", userID, admin, want)
+		}
+	}
+	if _, err := ts.rooms.GetByID(ctx, 1); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("room 1 is still there after the merge: %v", err)
+	}
+}
+
+// TestMergeRoomRefused checks who may merge what
+func TestMergeRoomRefused(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "gophers", CreatedBy: 1})
+	ts.rooms.add(&store.Room{ID: 2, Name: "golang", CreatedBy: 1})
+	ts.rooms.add(&store.Room{ID: 3, Name: "random", CreatedBy: 2})
+	ts.rooms.add(&store.Room{ID: 4, Name: "old", CreatedBy: 1})
+	ts.rooms.SoftDelete(context.Background(), 4)
+	ts.Rooms = mergingRooms{ts.rooms, ts.roomMembers}
+	server := newTestServer(t, ts)
+
+	for _, tc := range []struct {
+		name   string
+		source string
+		target int64
+		userID int64
+		status int
+		code   string
+	}{
+		{"into itself", "1", 1, 1, http.StatusBadRequest, "room_merge_same_room"},
+		{"no target", "1", 0, 1, http.StatusBadRequest, "invalid_request_body"},
+		{"someone else's source", "3", 1, 1, http.StatusForbidden, "room_permission_denied"},
+		{"someone else's target", "1", 3, 1, http.StatusForbidden, "room_permission_denied"},
+		{"by a stranger", "1", 2, 2, http.StatusForbidden, "room_permission_denied"},
+		{"into a deleted room", "1", 4, 1, http.StatusConflict, "room_merge_target_deleted"},
+		{"into a missing room", "1", 99, 1, http.StatusNotFound, "room_not_found"},
+	} {
+		var failure errorBody
+		status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/"+tc.source+"/merge", tc.userID, MergeRoomRequest{TargetRoomID: tc.target}, &failure)
+		if status != tc.status || failure.Code != tc.code {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, status, failure.Code, tc.status, tc.code)
+		}
+	}
+}
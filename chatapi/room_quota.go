@@ -0,0 +1,86 @@
+package chatapi
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/flags"
+)
+
+// roomCreationWindow is the rolling window of ROOM_CREATES_PER_DAY
+const roomCreationWindow = 24 * time.Hour
+
+// parseUserIDList splits a comma-separated list of user IDs
+// Anything that isn't a positive integer is an error, so a typo doesn't
+// silently leave someone out
+func parseUserIDList(list string) (map[int64]bool, error) {
+	ids := make(map[int64]bool)
+	for _, raw := range strings.Split(list, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id < 1 {
+			return nil, fmt.Errorf("invalid user ID %q", raw)
+		}
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// roomQuotaExempt reports whether the room creation quotas don't apply to a user:
+// listed in ROOM_QUOTA_EXEMPT_USERS, or given the room_quota_exempt flag
+func (app *application) roomQuotaExempt(r *http.Request, userID int64) bool {
+	if app.config.limits.roomQuotaExempt[userID] {
+		return true
+	}
+	return app.flags.Enabled(r.Context(), flags.RoomQuotaExempt, userID, 0)
+}
+
+// checkRoomCreationQuota keeps one account from creating rooms without end,
+// e.g. to squat names: at most ROOM_CREATES_PER_DAY rooms per rolling 24
+// hours (429 with Retry-After) and MAX_OWNED_ROOMS rooms not deleted (409)
+// Deleting a room frees room under the total at once, but the creation still
+// counts against the rolling window
+// On failure it writes the error response and returns false
+func (app *application) checkRoomCreationQuota(w http.ResponseWriter, r *http.Request, userID int64) bool {
+	limits := app.config.limits
+	if (limits.roomCreatesPerDay <= 0 && limits.maxOwnedRooms <= 0) || app.roomQuotaExempt(r, userID) {
+		return true
+	}
+
+	if limits.roomCreatesPerDay > 0 {
+		now := app.now()
+		created, oldest, err := app.store.Rooms.CountCreatedSince(r.Context(), userID, now.Add(-roomCreationWindow))
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "room_quota_check_failed")
+			return false
+		}
+		if created >= limits.roomCreatesPerDay {
+			// When the oldest creation leaves the window; if the limit was
+			// lowered it may take longer than that
+			expires := oldest.Add(roomCreationWindow).Sub(now)
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(expires.Seconds())))))
+			writeError(w, r, http.StatusTooManyRequests, "room_creation_rate_limited", limits.roomCreatesPerDay)
+			return false
+		}
+	}
+
+	if limits.maxOwnedRooms > 0 {
+		owned, err := app.store.Rooms.CountOwnedActive(r.Context(), userID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "room_quota_check_failed")
+			return false
+		}
+		if owned >= limits.maxOwnedRooms {
+			writeError(w, r, http.StatusConflict, "owned_room_limit_reached", limits.maxOwnedRooms)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,170 @@
+package chatapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/flags"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+func TestParseUserIDList(t *testing.T) {
+	for _, tc := range []struct {
+		list    string
+		want    []int64
+		invalid bool
+	}{
+		{"", nil, false},
+		{"7", []int64{7}, false},
+		{" 3, 9 ,,12 ", []int64{3, 9, 12}, false},
+		{"3,abc", nil, true},
+		{"0", nil, true},
+		{"-4", nil, true},
+	} {
+		ids, err := parseUserIDList(tc.list)
+		if tc.invalid {
+			if err == nil {
+				t.Errorf("parseUserIDList(%q) accepted %v", tc.list, ids)
+			}
+			continue
+		}
+		if got := slices.Sorted(maps.Keys(ids)); err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("parseUserIDList(%q) = %v, %v; want %v", tc.list, got, err, tc.want)
+		}
+	}
+}
+
+// withRoomQuota allows perDay room creations per rolling 24 hours and owned
+// rooms in all
+func withRoomQuota(perDay, owned int) testOption {
+	return func(app *application) {
+		app.config.limits.roomCreatesPerDay = perDay
+		app.config.limits.maxOwnedRooms = owned
+	}
+}
+
+// createRoom creates a room named name as userID
+// Returns the status, the error code if it failed, and Retry-After
+func createRoom(t *testing.T, serverURL string, userID int64, name string) (int, string, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, serverURL+"/v1/rooms", strings.NewReader(`{"name": "`+name+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(asUser(t, req, userID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var failure errorBody
+	if resp.StatusCode >= 400 {
+		if err := json.NewDecoder(resp.Body).Decode(&failure); err != nil {
+			t.Fatalf("creating %s: decoding the %d response: %v", name, resp.StatusCode, err)
+		}
+	}
+	return resp.StatusCode, failure.Code, resp.Header.Get("Retry-After")
+}
+
+// TestRoomCreationWindow gives three users one room each, created a second
+// less than, exactly, and a second more than 24 hours ago, with one
+// creation allowed a day: a room created exactly 24 hours ago no longer
+// counts, and the one still in the window is retried a second later
+func TestRoomCreationWindow(t *testing.T) {
+	clock := newFakeClock()
+	ts := newTestStore(t)
+	for userID, age := range map[int64]time.Duration{
+		1: roomCreationWindow - time.Second,
+		2: roomCreationWindow,
+		3: roomCreationWindow + time.Second,
+	} {
+		ts.rooms.add(&store.Room{ID: 100 + userID, Name: fmt.Sprintf("old-%d", userID), CreatedBy: userID, CreatedAt: clock.Now().Add(-age)})
+	}
+	ts.rooms.now = clock.Now
+	server := newTestServer(t, ts, withClock(clock), withRoomQuota(1, 0))
+
+	for _, tc := range []struct {
+		name   string
+		userID int64
+		status int
+		retry  string
+	}{
+		{"a second inside the window", 1, http.StatusTooManyRequests, "1"},
+		{"exactly 24 hours ago", 2, http.StatusCreated, ""},
+		{"a second outside the window", 3, http.StatusCreated, ""},
+	} {
+		status, code, retry := createRoom(t, server.URL, tc.userID, fmt.Sprintf("new-%d", tc.userID))
+		if status != tc.status || retry != tc.retry {
+			t.Errorf("with a room from %s got %d %q, Retry-After %q; want %d, Retry-After %q", tc.name, status, code, retry, tc.status, tc.retry)
+		}
+	}
+
+	clock.Advance(time.Second)
+	if status, code, _ := createRoom(t, server.URL, 1, "new-1"); status != http.StatusCreated {
+		t.Errorf("a second later got %d %q, want 201", status, code)
+	}
+}
+
+// TestRoomCreationQuota creates rooms against both quotas: the daily limit
+// answers 429 with Retry-After until the oldest creation leaves the window,
+// deleted rooms stop counting toward the total but not the window, and
+// exempt users, by ROOM_QUOTA_EXEMPT_USERS or by the room_quota_exempt flag,
+// aren't limited
+func TestRoomCreationQuota(t *testing.T) {
+	const (
+		ada   = 1 // Has created nothing yet
+		grace = 2 // Owns three rooms from last week, one of them deleted
+		linus = 3 // Listed in ROOM_QUOTA_EXEMPT_USERS
+		ken   = 4 // Has the room_quota_exempt flag
+	)
+	clock := newFakeClock()
+	ts := newTestStore(t)
+	for i := int64(0); i < 3; i++ {
+		ts.rooms.add(&store.Room{ID: 200 + i, Name: fmt.Sprintf("last-week-%d", i), CreatedBy: grace, CreatedAt: clock.Now().Add(-7 * 24 * time.Hour)})
+	}
+	ts.rooms.SoftDelete(t.Context(), 200)
+	ts.users.add(&store.User{ID: ken, Username: "ken"})
+	ts.featureFlags.Save(t.Context(), &store.FeatureFlag{Name: flags.RoomQuotaExempt, UserOverrides: map[int64]bool{ken: true}})
+	ts.rooms.now = clock.Now
+	server, app := newTestServerApp(t, ts, withClock(clock), withRoomQuota(2, 3))
+	app.config.limits.roomQuotaExempt = map[int64]bool{linus: true}
+
+	for i, tc := range []struct {
+		name    string
+		userID  int64
+		advance time.Duration // Before creating
+		status  int
+		code    string
+		retry   time.Duration
+	}{
+		{"a first room", ada, 0, http.StatusCreated, "", 0},
+		{"a second room an hour later", ada, time.Hour, http.StatusCreated, "", 0},
+		{"a third room in a day", ada, time.Hour, http.StatusTooManyRequests, "room_creation_rate_limited", 22 * time.Hour},
+		{"a third room once the first left the window", ada, 22 * time.Hour, http.StatusCreated, "", 0},
+		{"a third owned room", grace, 0, http.StatusCreated, "", 0},
+		{"a fourth owned room", grace, 0, http.StatusConflict, "owned_room_limit_reached", 0},
+		{"an exempt user's first", linus, 0, http.StatusCreated, "", 0},
+		{"an exempt user's second", linus, 0, http.StatusCreated, "", 0},
+		{"an exempt user's third", linus, 0, http.StatusCreated, "", 0},
+		{"a flagged user's first", ken, 0, http.StatusCreated, "", 0},
+		{"a flagged user's second", ken, 0, http.StatusCreated, "", 0},
+		{"a flagged user's third", ken, 0, http.StatusCreated, "", 0},
+	} {
+		clock.Advance(tc.advance)
+		status, code, retry := createRoom(t, server.URL, tc.userID, fmt.Sprintf("room-%d", i))
+		if status != tc.status || code != tc.code {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, status, code, tc.status, tc.code)
+			continue
+		}
+		if want := strconv.Itoa(int(tc.retry.Seconds())); tc.retry != 0 && retry != want {
+			t.Errorf("%s: Retry-After is %q, want %s", tc.name, retry, want)
+		}
+	}
+}
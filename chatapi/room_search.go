@@ -0,0 +1,172 @@
+package chatapi
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// defaultRoomSearchLimit is how many results each type gets when ?limit_per_type is not given
+	defaultRoomSearchLimit = 5
+
+	// maxRoomSearchLimit caps ?limit_per_type; "see all results" is the
+	// type's own endpoint's job
+	maxRoomSearchLimit = 20
+)
+
+// roomSearchTypes are what a room search can cover, in the order they're searched
+var roomSearchTypes = []string{"messages", "members", "pins", "files"}
+
+// roomSearchSection is one type's results
+// When the type's search failed, Error is set and Items is empty; the other
+// types are unaffected
+type roomSearchSection struct {
+	Items   any         `json:"items"`
+	HasMore bool        `json:"has_more"`
+	Error   *fieldError `json:"error,omitempty"`
+}
+
+// roomSearchResponse is a room search's results, by type
+// Only the types asked for are present
+type roomSearchResponse struct {
+	Query   string                        `json:"query"`
+	Results map[string]*roomSearchSection `json:"results"`
+}
+
+// searchRoomHandler searches a room's messages, members, pinned messages and
+// files at once, for an in-room search box
+// Each type is searched concurrently and returns up to limit_per_type
+// results, with has_more set if there are others. A type whose search fails
+// gets an "error" and no items, and the rest are still returned. Files are the
+// user's own uploads, since uploads aren't tied to rooms
+// GET /v1/rooms/{roomID}/search?q=deploy&types=messages,members,pins,files&limit_per_type=5
+// types defaults to all four
+// Requires authentication and room membership
+// Response: {"query": "deploy", "results": {"messages": {"items": [...], "has_more": true},
+// "members": {"items": [], "has_more": false, "error": {"error": "...", "code": "room_search_failed"}}, ...}}
+func (app *application) searchRoomHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if utf8.RuneCountInString(q) < minUserSearchLength {
+		writeError(w, r, http.StatusBadRequest, "search_query_too_short", minUserSearchLength)
+		return
+	}
+
+	types := roomSearchTypes
+	if raw := r.URL.Query().Get("types"); raw != "" {
+		types = nil
+		for _, kind := range strings.Split(raw, ",") {
+			kind = strings.TrimSpace(kind)
+			if !slices.Contains(roomSearchTypes, kind) {
+				writeError(w, r, http.StatusBadRequest, "invalid_room_search_type", kind)
+				return
+			}
+			if !slices.Contains(types, kind) {
+				types = append(types, kind)
+			}
+		}
+	}
+
+	limit := defaultRoomSearchLimit
+	if raw := r.URL.Query().Get("limit_per_type"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxRoomSearchLimit {
+			writeError(w, r, http.StatusBadRequest, "invalid_pagination")
+			return
+		}
+		limit = n
+	}
+
+	isMember, err := app.store.RoomMembers.IsUserInRoom(r.Context(), roomID, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "membership_check_failed")
+		return
+	}
+	if !isMember {
+		writeError(w, r, http.StatusForbidden, "membership_required_search")
+		return
+	}
+
+	// Failures are kept per section, so no search ever cancels the others
+	ctx := r.Context()
+	sections := make([]*roomSearchSection, len(types))
+	var g errgroup.Group
+	for i, kind := range types {
+		g.Go(func() error {
+			items, hasMore, err := app.searchRoomType(ctx, kind, roomID, userID, q, limit)
+			if err != nil {
+				log.Printf("Room search for %s in room %d failed: %v", kind, roomID, err)
+				sections[i] = &roomSearchSection{Items: []any{}, Error: &fieldError{
+					Error: translate(resolveLocale(r), "room_search_failed", kind),
+					Code:  "room_search_failed",
+				}}
+				return nil
+			}
+			sections[i] = &roomSearchSection{Items: items, HasMore: hasMore}
+			return nil
+		})
+	}
+	g.Wait()
+
+	// Out of time: the timeout middleware answers instead
+	if ctx.Err() != nil {
+		return
+	}
+
+	response := roomSearchResponse{Query: q, Results: make(map[string]*roomSearchSection, len(types))}
+	for i, kind := range types {
+		response.Results[kind] = sections[i]
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// searchRoomType runs one type of a room search
+// One result more than limit is asked for, to learn whether there are more
+func (app *application) searchRoomType(ctx context.Context, kind string, roomID, userID int64, q string, limit int) (any, bool, error) {
+	switch kind {
+	case "messages":
+		messages, err := app.store.Messages.Search(ctx, roomID, q, limit+1)
+		return searchPage(messages, err, limit)
+	case "members":
+		members, err := app.store.RoomMembers.SearchMembers(ctx, roomID, q, limit+1)
+		return searchPage(members, err, limit)
+	case "pins":
+		pins, err := app.store.Pins.Search(ctx, roomID, q, limit+1)
+		return searchPage(pins, err, limit)
+	default:
+		files, err := app.store.Attachments.Search(ctx, userID, q, limit+1)
+		for _, file := range files {
+			app.setThumbnailURL(file)
+		}
+		return searchPage(files, err, limit)
+	}
+}
+
+// searchPage cuts a search's results to limit and reports whether any were cut
+func searchPage[T any](found []T, err error, limit int) (any, bool, error) {
+	if err != nil {
+		return nil, false, err
+	}
+	if len(found) > limit {
+		return found[:limit], true, nil
+	}
+	return found, false, nil
+}
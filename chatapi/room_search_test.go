@@ -0,0 +1,265 @@
+package chatapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// searchBarrier holds every search of a room search until all of them have
+// started, so the request only succeeds if they run at the same time
+type searchBarrier struct {
+	started sync.WaitGroup
+}
+
+func newSearchBarrier(n int) *searchBarrier {
+	b := &searchBarrier{}
+	b.started.Add(n)
+	return b
+}
+
+// wait marks a search as started and waits for the others
+func (b *searchBarrier) wait() error {
+	b.started.Done()
+	all := make(chan struct{})
+	go func() {
+		b.started.Wait()
+		close(all)
+	}()
+	select {
+	case <-all:
+		return nil
+	case <-time.After(2 * time.Second):
+		return errors.New("the other searches never started")
+	}
+}
+
+// searchStub answers one type of a room search from found, as a store
+// would: at most limit results, or err
+type searchStub[T any] struct {
+	barrier *searchBarrier
+	found   []T
+	err     error
+	calls   atomic.Int32
+	limit   atomic.Int32 // The last limit asked for
+}
+
+func (s *searchStub[T]) search(limit int) ([]T, error) {
+	s.calls.Add(1)
+	s.limit.Store(int32(limit))
+	if err := s.barrier.wait(); err != nil {
+		return nil, err
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.found[:min(limit, len(s.found))], nil
+}
+
+type stubMessageSearch struct {
+	*fakeMessages
+	*searchStub[*store.Message]
+}
+
+func (s stubMessageSearch) Search(_ context.Context, _ int64, _ string, limit int) ([]*store.Message, error) {
+	return s.search(limit)
+}
+
+type stubMemberSearch struct {
+	*fakeRoomMembers
+	*searchStub[*store.PublicUser]
+}
+
+func (s stubMemberSearch) SearchMembers(_ context.Context, _ int64, _ string, limit int) ([]*store.PublicUser, error) {
+	return s.search(limit)
+}
+
+type stubPinSearch struct {
+	*fakePins
+	*searchStub[*store.PinnedMessage]
+}
+
+func (s stubPinSearch) Search(_ context.Context, _ int64, _ string, limit int) ([]*store.PinnedMessage, error) {
+	return s.search(limit)
+}
+
+type stubFileSearch struct {
+	*fakeAttachments
+	*searchStub[*store.Attachment]
+}
+
+func (s stubFileSearch) Search(_ context.Context, _ int64, _ string, limit int) ([]*store.Attachment, error) {
+	return s.search(limit)
+}
+
+// roomSearchStubs are the four searches behind a test server's room search
+type roomSearchStubs struct {
+	messages *searchStub[*store.Message]
+	members  *searchStub[*store.PublicUser]
+	pins     *searchStub[*store.PinnedMessage]
+	files    *searchStub[*store.Attachment]
+}
+
+// calls returns how often each type was searched, and with what limit
+func (s roomSearchStubs) calls() map[string][2]int {
+	return map[string][2]int{
+		"messages": {int(s.messages.calls.Load()), int(s.messages.limit.Load())},
+		"members":  {int(s.members.calls.Load()), int(s.members.limit.Load())},
+		"pins":     {int(s.pins.calls.Load()), int(s.pins.limit.Load())},
+		"files":    {int(s.files.calls.Load()), int(s.files.limit.Load())},
+	}
+}
+
+// newRoomSearchServer starts a server whose room searches find 5 messages,
+// 2 members, 4 pins and 3 files in room 1, which user 1 is a member of
+// Each search waits for expected searches to have started
+func newRoomSearchServer(t *testing.T, expected int) (string, roomSearchStubs) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "lobby", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleMember)
+
+	barrier := newSearchBarrier(expected)
+	stubs := roomSearchStubs{
+		messages: &searchStub[*store.Message]{barrier: barrier},
+		members:  &searchStub[*store.PublicUser]{barrier: barrier},
+		pins:     &searchStub[*store.PinnedMessage]{barrier: barrier},
+		files:    &searchStub[*store.Attachment]{barrier: barrier},
+	}
+	for i := int64(1); i <= 5; i++ {
+		stubs.messages.found = append(stubs.messages.found, &store.Message{ID: i, RoomID: 1, Content: fmt.Sprintf("deploy %d", i)})
+	}
+	for i := int64(1); i <= 2; i++ {
+		stubs.members.found = append(stubs.members.found, &store.PublicUser{ID: i, Username: fmt.Sprintf("deployer%d", i)})
+	}
+	for i := int64(1); i <= 4; i++ {
+		stubs.pins.found = append(stubs.pins.found, &store.PinnedMessage{MessageID: i, RoomID: 1, Position: int(i)})
+	}
+	for i := int64(1); i <= 3; i++ {
+		stubs.files.found = append(stubs.files.found, &store.Attachment{ID: i, UserID: 1, Filename: fmt.Sprintf("deploy-%d.log", i)})
+	}
+
+	ts.Messages = stubMessageSearch{ts.messages, stubs.messages}
+	ts.RoomMembers = stubMemberSearch{ts.roomMembers, stubs.members}
+	ts.Pins = stubPinSearch{ts.pins, stubs.pins}
+	ts.Attachments = stubFileSearch{ts.attachments, stubs.files}
+	return newTestServer(t, ts).URL + "/v1/rooms/1/search", stubs
+}
+
+// roomSearchResult is a room search response as a client reads it
+type roomSearchResult struct {
+	Query   string `json:"query"`
+	Results map[string]struct {
+		Items   []json.RawMessage `json:"items"`
+		HasMore bool              `json:"has_more"`
+		Error   *errorBody        `json:"error"`
+	} `json:"results"`
+}
+
+// TestRoomSearch searches all four types with limit_per_type=3: every type is
+// searched at once for one more result than the limit, and each section holds
+// at most 3 results with has_more set when there were others
+// When one type's search fails, that section carries an error and no items,
+// and the other three are unchanged
+func TestRoomSearch(t *testing.T) {
+	want := map[string]struct {
+		items   int
+		hasMore bool
+	}{
+		"messages": {3, true},
+		"members":  {2, false},
+		"pins":     {3, true},
+		"files":    {3, false},
+	}
+
+	for _, failing := range []string{"", "members", "files"} {
+		url, stubs := newRoomSearchServer(t, 4)
+		switch failing {
+		case "members":
+			stubs.members.err = errors.New("database is down")
+		case "files":
+			stubs.files.err = errors.New("database is down")
+		}
+
+		var got roomSearchResult
+		if status := doJSON(t, http.MethodGet, url+"?q=deploy&limit_per_type=3", 1, nil, &got); status != http.StatusOK {
+			t.Fatalf("with %q failing: search got %d, want 200", failing, status)
+		}
+		if got.Query != "deploy" || len(got.Results) != len(want) {
+			t.Errorf("with %q failing: got query %q with %d sections, want \"deploy\" with %d", failing, got.Query, len(got.Results), len(want))
+		}
+		for kind, calls := range stubs.calls() {
+			if calls != [2]int{1, 4} {
+				t.Errorf("with %q failing: %s was searched %d times with limit %d, want once with 4", failing, kind, calls[0], calls[1])
+			}
+		}
+
+		for kind, w := range want {
+			section := got.Results[kind]
+			if kind == failing {
+				if section.Error == nil || section.Error.Code != "room_search_failed" || section.Items == nil || len(section.Items) != 0 || section.HasMore {
+					t.Errorf("the failed %s section was %+v, want an error and no items", kind, section)
+				}
+				continue
+			}
+			if section.Error != nil || len(section.Items) != w.items || section.HasMore != w.hasMore {
+				t.Errorf("with %q failing: %s had %d items, has_more %v and error %v; want %d, %v and none",
+					failing, kind, len(section.Items), section.HasMore, section.Error, w.items, w.hasMore)
+			}
+		}
+	}
+}
+
+// TestRoomSearchTypes searches only the types asked for, once each
+func TestRoomSearchTypes(t *testing.T) {
+	url, stubs := newRoomSearchServer(t, 2)
+
+	var got roomSearchResult
+	if status := doJSON(t, http.MethodGet, url+"?q=deploy&types=pins,%20members,pins", 1, nil, &got); status != http.StatusOK {
+		t.Fatalf("search got %d, want 200", status)
+	}
+	if _, ok := got.Results["pins"]; !ok || len(got.Results) != 2 {
+		t.Errorf("got sections %v, want pins and members", got.Results)
+	}
+	for kind, calls := range stubs.calls() {
+		searched := kind == "pins" || kind == "members"
+		if searched && calls != [2]int{1, defaultRoomSearchLimit + 1} || !searched && calls[0] != 0 {
+			t.Errorf("%s was searched %d times with limit %d", kind, calls[0], calls[1])
+		}
+	}
+}
+
+// TestRoomSearchRejected refuses bad queries and non-members before
+// searching anything
+func TestRoomSearchRejected(t *testing.T) {
+	url, stubs := newRoomSearchServer(t, 4)
+	for _, tc := range []struct {
+		query  string
+		userID int64
+		status int
+		code   string
+	}{
+		{"?q=deploy&types=messages,threads", 1, http.StatusBadRequest, "invalid_room_search_type"},
+		{"?q=deploy&types=", 2, http.StatusForbidden, "membership_required_search"},
+		{"?q=d", 1, http.StatusBadRequest, "search_query_too_short"},
+		{"?q=deploy&limit_per_type=0", 1, http.StatusBadRequest, "invalid_pagination"},
+		{"?q=deploy&limit_per_type=21", 1, http.StatusBadRequest, "invalid_pagination"},
+	} {
+		var failure errorBody
+		if status := doJSON(t, http.MethodGet, url+tc.query, tc.userID, nil, &failure); status != tc.status || failure.Code != tc.code {
+			t.Errorf("%s as user %d: got %d %q, want %d %q", tc.query, tc.userID, status, failure.Code, tc.status, tc.code)
+		}
+	}
+	for kind, calls := range stubs.calls() {
+		if calls[0] != 0 {
+			t.Errorf("%s was searched %d times", kind, calls[0])
+		}
+	}
+}
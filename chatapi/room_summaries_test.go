@@ -0,0 +1,109 @@
+package chatapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+)
+
+// TestMyRoomsQueryCount lists the sidebar for a user in one room and in
+// thirty: either way it's one database query, with the real RoomStore on a
+// mock that fails on any statement it doesn't expect
+func TestMyRoomsQueryCount(t *testing.T) {
+	for _, rooms := range []int{1, 30} {
+		t.Run(fmt.Sprintf("%d rooms", rooms), func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			ts := newTestStore(t)
+			ts.Rooms = store.NewPostgresStorage(store.NewPools(db, nil), testLimits).Rooms
+			server := newTestServer(t, ts)
+
+			now := time.Now()
+			rows := sqlmock.NewRows([]string{"id", "name", "description", "created_by", "created_at", "updated_at", "version",
+				"is_public_readonly", "last_message_at", "join_policy", "max_members", "member_count",
+				"content_filter_enabled", "duplicate_limit_enabled", "quiet_hours", "tags", "is_default", "language", "retention_seconds", "post_policy",
+				"lm_id", "preview", "lm_user_id", "username", "lm_created_at", "lm_system_event", "unread", "mentions"})
+			for id := 1; id <= rooms; id++ {
+				rows.AddRow(id, fmt.Sprintf("room-%d", id), "", 2, now, now, 1, false, now, "open", nil, 2, true, false, nil, "{}", false, "", nil, "everyone",
+					id*100, "hello", 2, "grace", now, nil, 1, 0)
+			}
+			mock.ExpectQuery(`FROM rooms r`).WillReturnRows(rows)
+
+			var summaries []*store.RoomSummary
+			if status := doJSON(t, http.MethodGet, server.URL+"/v1/users/me/rooms", 1, nil, &summaries); status != http.StatusOK || len(summaries) != rooms {
+				t.Fatalf("listing got %d with %d rooms, want 200 with %d", status, len(summaries), rooms)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// summaryRooms lists a user's rooms from the fakes with no messages; the
+// counts are the store's job and are tested there
+type summaryRooms struct {
+	*fakeRooms
+	members *fakeRoomMembers
+}
+
+func (r summaryRooms) GetUserRoomSummaries(ctx context.Context, userID int64, _ store.RoomListOptions) ([]*store.RoomSummary, error) {
+	summaries := make([]*store.RoomSummary, 0)
+	for _, roomID := range []int64{1, 2} {
+		if in, _ := r.members.IsUserInRoom(ctx, roomID, userID); !in {
+			continue
+		}
+		room, err := r.GetByID(ctx, roomID)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, &store.RoomSummary{Room: room})
+	}
+	return summaries, nil
+}
+
+// TestMyRoomsOnline fills in each room's online count from the hub: grace
+// with two tabs open counts once, and a room nobody is in shows 0
+func TestMyRoomsOnline(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.rooms.add(&store.Room{ID: 2, Name: "random", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(2, 1, store.RoomRoleAdmin)
+	ts.roomMembers.add(1, 2, store.RoomRoleMember)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	ts.Rooms = summaryRooms{ts.rooms, ts.roomMembers}
+	server, app := newTestServerApp(t, ts)
+
+	dialRoom(t, server, 1, 2)
+	dialRoom(t, server, 1, 2)
+	dialRoom(t, server, 1, 1)
+	if !testutil.WaitFor(5*time.Second, func() bool { return app.hub.GetRoomClientCount(1) == 3 }) {
+		t.Fatalf("room 1 has %d connections, want 3", app.hub.GetRoomClientCount(1))
+	}
+
+	var summaries []*store.RoomSummary
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/users/me/rooms", 1, nil, &summaries); status != http.StatusOK || len(summaries) != 2 {
+		t.Fatalf("listing got %d with %d rooms, want 200 with 2", status, len(summaries))
+	}
+	for i, want := range []int{2, 0} {
+		if summaries[i].OnlineCount != want {
+			t.Errorf("room %d has %d online, want %d", summaries[i].ID, summaries[i].OnlineCount, want)
+		}
+	}
+
+	var failure errorBody
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/users/me/rooms?sort=members", 1, nil, &failure); status != http.StatusBadRequest || failure.Code != "invalid_room_sort" {
+		t.Errorf("an unknown sort got %d %q, want 400 invalid_room_sort", status, failure.Code)
+	}
+}
@@ -0,0 +1,308 @@
+package chatapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/websocket"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// maxRoomTemplateNameLength matches the room_templates.name column
+const maxRoomTemplateNameLength = 100
+
+// RoomTemplateSettings is what a room template holds: the settings of the
+// rooms created from it and the users to add to them
+type RoomTemplateSettings struct {
+	RoomSettings
+
+	// DefaultMembers are usernames added to every room created from the template
+	DefaultMembers []string `json:"default_members"`
+}
+
+// templateSettingFields are the JSON fields of RoomTemplateSettings, lowercase
+// Anything else in a template is ignored and reported back to the client
+var templateSettingFields = jsonFieldNames(reflect.TypeOf(RoomTemplateSettings{}))
+
+// CreateRoomTemplateRequest represents the JSON structure for saving a room template
+type CreateRoomTemplateRequest struct {
+	Name     string          `json:"name"`
+	Settings json.RawMessage `json:"settings"`
+}
+
+// RoomTemplateResponse is a saved template with the fields it will ignore
+type RoomTemplateResponse struct {
+	Template      *store.RoomTemplate `json:"template"`
+	IgnoredFields []string            `json:"ignored_fields"`
+}
+
+// RoomTemplatesResponse lists the caller's templates
+type RoomTemplatesResponse struct {
+	Templates []*store.RoomTemplate `json:"templates"`
+}
+
+// TemplateRoomResponse is a room created from a template, with the outcome
+// of adding each of the template's default members
+type TemplateRoomResponse struct {
+	Room          *store.Room         `json:"room"`
+	Members       []*BulkMemberResult `json:"members"`
+	IgnoredFields []string            `json:"ignored_fields"`
+}
+
+// createRoomTemplateHandler saves a named set of room settings
+// The settings are checked like a room update, so a template that saves can
+// be used; fields that aren't room settings are kept but ignored
+// POST /v1/room-templates
+// Requires authentication
+// Request body: {"name": "standup", "settings": {"join_policy": "invite", "tags": ["team"],
+// "duplicate_limit_enabled": true, "default_members": ["jane", "bob"]}}
+// Response: {"template": {"id": 3, "name": "standup", "settings": {...}, ...}, "ignored_fields": []}
+func (app *application) createRoomTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	var req CreateRoomTemplateRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeError(w, r, http.StatusBadRequest, "room_template_name_required")
+		return
+	}
+	if utf8.RuneCountInString(req.Name) > maxRoomTemplateNameLength {
+		writeError(w, r, http.StatusBadRequest, "room_template_name_too_long", maxRoomTemplateNameLength)
+		return
+	}
+
+	settings, ignored, err := decodeTemplateSettings(req.Settings)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_template_settings", err.Error())
+		return
+	}
+	// Bad settings are refused now rather than when someone uses the template
+	if !app.applyRoomSettings(w, r, store.NewRoom("", userID), &settings.RoomSettings) {
+		return
+	}
+	if len(settings.DefaultMembers) > maxBulkMembers {
+		writeError(w, r, http.StatusBadRequest, "bulk_members_too_many", maxBulkMembers)
+		return
+	}
+	for i, name := range settings.DefaultMembers {
+		if strings.TrimSpace(name) == "" {
+			writeError(w, r, http.StatusBadRequest, "invalid_template_settings", "default_members["+strconv.Itoa(i)+"] is empty")
+			return
+		}
+	}
+
+	template := &store.RoomTemplate{OwnerID: userID, Name: req.Name, Settings: req.Settings}
+	if err := app.store.RoomTemplates.Create(r.Context(), template); err != nil {
+		if store.IsUniqueViolation(err) {
+			writeError(w, r, http.StatusConflict, "room_template_name_taken")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_template_create_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, RoomTemplateResponse{Template: template, IgnoredFields: ignored})
+}
+
+// listRoomTemplatesHandler lists the caller's room templates by name
+// GET /v1/room-templates
+// Requires authentication
+// Response: {"templates": [{"id": 3, "name": "standup", "settings": {...}, ...}]}
+func (app *application) listRoomTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	templates, err := app.store.RoomTemplates.ListForOwner(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "room_templates_lookup_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, RoomTemplatesResponse{Templates: templates})
+}
+
+// createRoomFromTemplate finishes createRoomHandler for POST /v1/rooms?template_id=N
+// The room gets the template's settings, then whatever the request body sets
+// (description, join policy, tags), and the template's default members are
+// added in the same transaction that creates it. Each member gets a result
+// like a bulk add; one that no longer exists is "not_found" and doesn't stop
+// the others. Fields of the template this server doesn't know are listed in
+// "ignored_fields" instead of failing the creation
+// Response: 201 {"room": {...}, "members": [{"username": "jane", "user_id": 5, "status": "added"}], "ignored_fields": []}
+func (app *application) createRoomFromTemplate(w http.ResponseWriter, r *http.Request, userID int64, rawID string, req *CreateRoomRequest, tags []string) {
+	templateID, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil || templateID < 1 {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "template_id")
+		return
+	}
+
+	template, err := app.store.RoomTemplates.GetByID(r.Context(), templateID, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_template_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_template_lookup_failed")
+		return
+	}
+
+	settings, ignored, err := decodeTemplateSettings(template.Settings)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_template_settings", err.Error())
+		return
+	}
+
+	// Checked again: limits such as the global member cap may have changed since it was saved
+	room := store.NewRoom(req.Name, userID)
+	if !app.applyRoomSettings(w, r, room, &settings.RoomSettings) {
+		return
+	}
+	if req.Description != "" {
+		room.Description = req.Description
+	}
+	if req.JoinPolicy != "" {
+		room.JoinPolicy = req.JoinPolicy
+	}
+	if len(tags) > 0 {
+		room.Tags = tags
+	}
+
+	// Resolve the default members in one query, one result per distinct name
+	names := make([]string, 0, len(settings.DefaultMembers))
+	seenNames := make(map[string]bool, len(settings.DefaultMembers))
+	for _, name := range settings.DefaultMembers {
+		name = strings.TrimSpace(name)
+		if !seenNames[name] {
+			seenNames[name] = true
+			names = append(names, name)
+		}
+	}
+	users, err := app.store.Users.GetByUsernames(r.Context(), names, nil)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return
+	}
+	byName := make(map[string]*store.User, len(users))
+	for _, u := range users {
+		byName[u.Username] = u
+	}
+
+	results := make([]*BulkMemberResult, 0, len(names))
+	memberIDs := make([]int64, 0, len(users))
+	for _, name := range names {
+		result := &BulkMemberResult{Username: name}
+		if u := byName[name]; u != nil {
+			result.UserID = u.ID
+			memberIDs = append(memberIDs, u.ID)
+		} else {
+			result.Status = bulkNotFound
+		}
+		results = append(results, result)
+	}
+
+	outcomes, err := app.store.Rooms.CreateWithMembers(r.Context(), room, memberIDs)
+	if err != nil {
+		if store.IsUniqueViolation(err) {
+			writeError(w, r, http.StatusConflict, "room_name_taken")
+			return
+		}
+		if errors.Is(err, store.ErrTooManyRooms) {
+			writeError(w, r, http.StatusConflict, "room_quota_exceeded", app.config.limits.maxRoomsPerUser)
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_create_failed")
+		return
+	}
+
+	added := make([]int64, 0, len(outcomes))
+	for _, result := range results {
+		if result.Status == "" {
+			result.Status = outcomes[result.UserID]
+		}
+		if result.Status == store.BulkAdded {
+			added = append(added, result.UserID)
+		}
+	}
+	if len(added) > 0 {
+		app.hub.NotifyUsers(added, &websocket.Message{
+			Message: wire.Message{
+				RoomID:  room.ID,
+				Content: "you were added to " + room.Name,
+				Type:    "member_added",
+			},
+		})
+	}
+
+	writeJSON(w, http.StatusCreated, TemplateRoomResponse{Room: room, Members: results, IgnoredFields: ignored})
+}
+
+// decodeTemplateSettings decodes a template's settings object
+// Fields that aren't template settings are returned, sorted, rather than failing;
+// a value of the wrong type is an error
+func decodeTemplateSettings(raw json.RawMessage) (*RoomTemplateSettings, []string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil || fields == nil {
+		return nil, nil, errors.New("settings must be a JSON object")
+	}
+
+	settings := &RoomTemplateSettings{}
+	if err := json.Unmarshal(raw, settings); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, nil, fmt.Errorf("%s has the wrong type", typeErr.Field)
+		}
+		return nil, nil, errors.New("settings must be a JSON object")
+	}
+
+	// encoding/json matches field names without regard to case, so this does too
+	ignored := make([]string, 0)
+	for name := range fields {
+		if !templateSettingFields[strings.ToLower(name)] {
+			ignored = append(ignored, name)
+		}
+	}
+	sort.Strings(ignored)
+	return settings, ignored, nil
+}
+
+// jsonFieldNames returns the lowercased JSON names of a struct's fields,
+// including those of embedded structs
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			for name := range jsonFieldNames(field.Type) {
+				names[name] = true
+			}
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" {
+			name = field.Name
+		}
+		names[strings.ToLower(name)] = true
+	}
+	return names
+}
@@ -0,0 +1,120 @@
+package chatapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// templateRooms creates rooms with their members in the fakes; the single
+// transaction is the store's job and is tested there
+type templateRooms struct {
+	*fakeRooms
+	members *fakeRoomMembers
+}
+
+func (r templateRooms) CreateWithMembers(ctx context.Context, room *store.Room, memberIDs []int64) (map[int64]string, error) {
+	if err := r.Create(ctx, room); err != nil {
+		return nil, err
+	}
+	r.members.add(room.ID, room.CreatedBy, store.RoomRoleAdmin)
+	return r.members.AddMembers(ctx, room.ID, memberIDs, room.CreatedBy)
+}
+
+// TestRoomTemplateRejectedAtSave saves templates with settings a room update
+// would refuse: each is turned away with the update's error, so no template
+// that saves can fail when it's used
+func TestRoomTemplateRejectedAtSave(t *testing.T) {
+	server := newTestServer(t, newTestStore(t))
+	url := server.URL + "/v1/room-templates"
+
+	for _, tc := range []struct {
+		name     string
+		settings string
+		code     string
+	}{
+		{"unknown join policy", `{"join_policy": "whoever"}`, "invalid_join_policy"},
+		{"over the member cap", `{"max_members": 500}`, "invalid_max_members"},
+		{"bad quiet hours", `{"quiet_hours": {"start": "25:00", "end": "08:00"}}`, "invalid_quiet_hours"},
+		{"a tag too many", `{"tags": ["a1", "b2", "c3", "d4", "e5", "f6"]}`, "invalid_room_tags"},
+		{"wrong type", `{"content_filter_enabled": "yes"}`, "invalid_template_settings"},
+		{"not an object", `["standup"]`, "invalid_template_settings"},
+		{"empty member", `{"default_members": ["grace", " "]}`, "invalid_template_settings"},
+	} {
+		var failure errorBody
+		body := CreateRoomTemplateRequest{Name: tc.name, Settings: json.RawMessage(tc.settings)}
+		if status := doJSON(t, http.MethodPost, url, 1, body, &failure); status != http.StatusBadRequest || failure.Code != tc.code {
+			t.Errorf("%s: got %d %q, want 400 %q", tc.name, status, failure.Code, tc.code)
+		}
+	}
+
+	var listed RoomTemplatesResponse
+	if status := doJSON(t, http.MethodGet, url, 1, nil, &listed); status != http.StatusOK || len(listed.Templates) != 0 {
+		t.Errorf("after the refusals got %d with %d templates, want none saved", status, len(listed.Templates))
+	}
+}
+
+// TestRoomFromTemplate saves ada's standup template naming grace and linus,
+// then deletes linus' account: a room made from it gets the template's
+// settings, grace is added, linus is reported not found without failing the
+// room, and a field this server doesn't know is listed as ignored
+func TestRoomFromTemplate(t *testing.T) {
+	ts := newTestStore(t)
+	for id, name := range map[int64]string{1: "ada", 2: "grace", 3: "linus"} {
+		ts.users.add(&store.User{ID: id, Username: name})
+	}
+	ts.Rooms = templateRooms{fakeRooms: ts.rooms, members: ts.roomMembers}
+	server := newTestServer(t, ts)
+
+	settings := `{"join_policy": "invite", "tags": ["Team"], "duplicate_limit_enabled": true,
+		"default_members": ["grace", "linus", "grace"], "slow_mode": 30}`
+	var saved RoomTemplateResponse
+	body := CreateRoomTemplateRequest{Name: "standup", Settings: json.RawMessage(settings)}
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/room-templates", 1, body, &saved); status != http.StatusCreated {
+		t.Fatalf("saving got %d, want 201", status)
+	}
+	if !slices.Equal(saved.IgnoredFields, []string{"slow_mode"}) {
+		t.Errorf("saving ignored %v, want slow_mode", saved.IgnoredFields)
+	}
+	var failure errorBody
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/room-templates", 1, body, &failure); status != http.StatusConflict || failure.Code != "room_template_name_taken" {
+		t.Errorf("saving the name again got %d %q, want 409 room_template_name_taken", status, failure.Code)
+	}
+
+	ts.users.mu.Lock()
+	delete(ts.users.users, 3)
+	ts.users.mu.Unlock()
+
+	url := fmt.Sprintf("%s/v1/rooms?template_id=%d", server.URL, saved.Template.ID)
+	var created TemplateRoomResponse
+	if status := doJSON(t, http.MethodPost, url, 1, CreateRoomRequest{Name: "standup-monday"}, &created); status != http.StatusCreated {
+		t.Fatalf("creating from the template got %d, want 201", status)
+	}
+	room := created.Room
+	if room.JoinPolicy != store.JoinPolicyInvite || !room.DuplicateLimitEnabled || !slices.Equal(room.Tags, []string{"team"}) {
+		t.Errorf("the room is %+v, want the template's settings", room)
+	}
+	want := []*BulkMemberResult{
+		{Username: "grace", UserID: 2, Status: store.BulkAdded},
+		{Username: "linus", Status: bulkNotFound},
+	}
+	if !slices.EqualFunc(created.Members, want, func(a, b *BulkMemberResult) bool { return *a == *b }) {
+		t.Errorf("the members are %+v, want grace added and linus not found", created.Members)
+	}
+	if !slices.Equal(created.IgnoredFields, []string{"slow_mode"}) {
+		t.Errorf("creating ignored %v, want slow_mode", created.IgnoredFields)
+	}
+	if in, _ := ts.roomMembers.IsRoomAdmin(context.Background(), room.ID, 1); !in {
+		t.Error("ada isn't the new room's admin")
+	}
+
+	// Templates are private to their owner
+	if status := doJSON(t, http.MethodPost, url, 2, CreateRoomRequest{Name: "borrowed"}, &failure); status != http.StatusNotFound || failure.Code != "room_template_not_found" {
+		t.Errorf("grace using ada's template got %d %q, want 404 room_template_not_found", status, failure.Code)
+	}
+}
@@ -0,0 +1,691 @@
+package chatapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/drazan344/go-chat/internal/schedule"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/sysmsg"
+)
+
+// CreateRoomRequest represents the JSON structure for creating a room
+type CreateRoomRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	JoinPolicy  string   `json:"join_policy"` // Optional, defaults to "open"
+	Tags        []string `json:"tags"`        // Optional, at most store.MaxRoomTags
+}
+
+// RoomSettings are the editable settings of a room, as sent to update a room
+// or saved in a room template
+// Fields are pointers so we can tell "not provided" apart from a zero value
+// Only the fields present are changed
+type RoomSettings struct {
+	Description      *string `json:"description"`
+	IsPublicReadonly *bool   `json:"is_public_readonly"`
+	JoinPolicy       *string `json:"join_policy"`
+	MaxMembers       *int    `json:"max_members"` // 0 resets to the global limit
+	ContentFilter    *bool   `json:"content_filter_enabled"`
+	DuplicateLimit   *bool   `json:"duplicate_limit_enabled"`
+
+	// Tags replaces all of the room's tags; an empty list removes them
+	Tags *[]string `json:"tags"`
+
+	// QuietHours sets the room's quiet hours; null removes them
+	// Kept raw so a null can be told apart from the field being left out
+	QuietHours json.RawMessage `json:"quiet_hours"`
+
+	// Language is a BCP 47 tag for the room's system messages; "" resets it
+	// to the server default
+	Language *string `json:"language"`
+
+	// RetentionSeconds is how long the room keeps messages: 0 keeps them
+	// forever, null resets it to the server default (see retention.go)
+	// Kept raw so a null can be told apart from the field being left out
+	RetentionSeconds json.RawMessage `json:"retention_seconds"`
+
+	// PostPolicy is who may send messages: "everyone", or "admins_only" for an
+	// announcement room where only the owner and admins post
+	PostPolicy *string `json:"post_policy"`
+}
+
+// UpdateRoomRequest represents the JSON structure for updating a room's settings
+type UpdateRoomRequest struct {
+	RoomSettings
+
+	// Version is the room version the change is based on (or send If-Match)
+	Version *int64 `json:"version"`
+}
+
+// createRoomHandler creates a new chat room
+// POST /v1/rooms
+// POST /v1/rooms?template_id=3 creates it from one of the caller's room
+// templates instead (see createRoomFromTemplate)
+// Requires authentication
+// Request body: {"name": "general", "description": "General chat room", "join_policy": "open", "tags": ["chat"]}
+// Response: {"id": 1, "name": "general", ...}
+func (app *application) createRoomHandler(w http.ResponseWriter, r *http.Request) {
+	// Get authenticated user ID from context
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	// Parse request body
+	var req CreateRoomRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	// Validate input
+	if req.Name == "" {
+		writeError(w, r, http.StatusBadRequest, "room_name_required")
+		return
+	}
+
+	// Room names should be lowercase and URL-friendly (like Slack channels)
+	// Convert to lowercase and trim spaces
+	req.Name = strings.ToLower(strings.TrimSpace(req.Name))
+
+	if req.JoinPolicy != "" && !store.ValidJoinPolicy(req.JoinPolicy) {
+		writeError(w, r, http.StatusBadRequest, "invalid_join_policy")
+		return
+	}
+
+	tags, ok := validateRoomTags(w, r, req.Tags)
+	if !ok {
+		return
+	}
+
+	// Creating a room makes the creator a member, so it counts against their room quota
+	// Checking up front avoids creating a room that its creator can't join
+	if app.config.limits.maxRoomsPerUser > 0 {
+		count, err := app.store.RoomMembers.GetUserRoomCount(r.Context(), userID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "membership_check_failed")
+			return
+		}
+		if count >= app.config.limits.maxRoomsPerUser {
+			writeError(w, r, http.StatusConflict, "room_quota_exceeded", app.config.limits.maxRoomsPerUser)
+			return
+		}
+	}
+
+	if !app.checkRoomCreationQuota(w, r, userID) {
+		return
+	}
+
+	if raw := r.URL.Query().Get("template_id"); raw != "" {
+		app.createRoomFromTemplate(w, r, userID, raw, &req, tags)
+		return
+	}
+
+	// Create room in database
+	room := &store.Room{
+		Name:        req.Name,
+		Description: req.Description,
+		CreatedBy:   userID,
+		JoinPolicy:  req.JoinPolicy,
+		Tags:        tags,
+	}
+
+	if err := app.store.Rooms.Create(r.Context(), room); err != nil {
+		// Check for duplicate room name
+		if store.IsUniqueViolation(err) {
+			writeError(w, r, http.StatusConflict, "room_name_taken")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_create_failed")
+		return
+	}
+
+	// Automatically join the creator to the room as its first admin
+	// This makes sense as the creator would want to be in their own room
+	if err := app.store.RoomMembers.JoinWithOptions(r.Context(), room.ID, userID, store.JoinOptions{Role: store.RoomRoleAdmin, ActorID: userID}); err != nil {
+		// A concurrent join used up the creator's last slot after the check above
+		// Remove the room rather than leave it without its creator
+		if errors.Is(err, store.ErrTooManyRooms) {
+			app.store.Rooms.Delete(r.Context(), room.ID)
+			writeError(w, r, http.StatusConflict, "room_quota_exceeded", app.config.limits.maxRoomsPerUser)
+			return
+		}
+		// Room was created but join failed - log this but don't fail the request
+		// The user can manually join later
+		writeError(w, r, http.StatusInternalServerError, "room_created_join_failed")
+		return
+	}
+
+	// Return the created room with 201 Created status
+	room.MemberCount = 1
+	writeJSON(w, http.StatusCreated, room)
+}
+
+// listRoomsHandler returns all available chat rooms
+// GET /v1/rooms?sort=created|activity&tag=gaming
+// Requires authentication
+// Response: [{"id": 1, "name": "general", ...}, {"id": 2, "name": "random", ...}]
+func (app *application) listRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	// Validate the sort order; default is newest rooms first
+	opts := store.RoomListOptions{Sort: r.URL.Query().Get("sort")}
+	if opts.Sort != "" && !store.ValidRoomSort(opts.Sort) {
+		writeError(w, r, http.StatusBadRequest, "invalid_room_sort")
+		return
+	}
+
+	// Optionally only rooms with one tag
+	if raw := r.URL.Query().Get("tag"); raw != "" {
+		tag, ok := store.NormalizeTag(raw)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, "invalid_room_tag", raw)
+			return
+		}
+		opts.Tag = tag
+	}
+
+	// Get all rooms from database
+	// In a production app with many rooms, you'd want pagination here
+	rooms, err := app.store.Rooms.List(r.Context(), opts)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "rooms_lookup_failed")
+		return
+	}
+
+	// Return empty array instead of null if no rooms exist
+	// This is better for client-side code
+	if rooms == nil {
+		rooms = []*store.Room{}
+	}
+
+	writeJSON(w, http.StatusOK, rooms)
+}
+
+// listMyRoomsHandler returns the rooms the current user has joined, with
+// everything a sidebar shows: the last message, unread and mention counts and
+// how many members are online
+// The rooms come from one query and the online counts from one hub call,
+// however many rooms the user is in
+// GET /v1/users/me/rooms?sort=created|activity&tag=gaming
+// Requires authentication
+// Response: [{"id": 1, "name": "general", ..., "last_message": {"preview": "hi", "username": "john", ...},
+// "unread_count": 3, "mention_count": 1, "online_count": 4}]
+func (app *application) listMyRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	opts := store.RoomListOptions{Sort: r.URL.Query().Get("sort")}
+	if opts.Sort != "" && !store.ValidRoomSort(opts.Sort) {
+		writeError(w, r, http.StatusBadRequest, "invalid_room_sort")
+		return
+	}
+	if raw := r.URL.Query().Get("tag"); raw != "" {
+		tag, ok := store.NormalizeTag(raw)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, "invalid_room_tag", raw)
+			return
+		}
+		opts.Tag = tag
+	}
+
+	summaries, err := app.store.Rooms.GetUserRoomSummaries(r.Context(), userID, opts)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "rooms_lookup_failed")
+		return
+	}
+
+	roomIDs := make([]int64, len(summaries))
+	for i, summary := range summaries {
+		roomIDs[i] = summary.ID
+	}
+	online := app.hub.GetRoomCounts(roomIDs)
+	for _, summary := range summaries {
+		summary.OnlineCount = online[summary.ID]
+		if last := summary.LastMessage; last != nil && last.SystemEvent != nil {
+			last.Preview = systemMessagePreview(last.SystemEvent, app.roomLanguage(summary.Room))
+		}
+	}
+
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+// getRoomHandler returns details about a specific room
+// GET /v1/rooms/{roomID}
+// Requires authentication
+// Response: {"id": 1, "name": "general", ...}
+func (app *application) getRoomHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract room ID from URL
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	// Get room from database
+	room, err := app.store.Rooms.GetByID(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_lookup_failed")
+		return
+	}
+
+	setETag(w, room.Version)
+	writeJSON(w, http.StatusOK, room)
+}
+
+// updateRoomHandler changes a room's settings
+// PATCH /v1/rooms/{roomID}
+// Requires authentication and manage_settings (by default only the owner)
+// Send the version you read as If-Match: "<version>" (or "version" in the body);
+// if the room changed since, the response is 412 with the current room to merge into
+// Request body (all fields optional): {"description": "...", "is_public_readonly": true,
+// "join_policy": "approval", "max_members": 50, "content_filter_enabled": false,
+// "duplicate_limit_enabled": true, "tags": ["gaming", "lfg"], "language": "de", "retention_seconds": 86400,
+// "post_policy": "admins_only",
+// "quiet_hours": {"start": "18:00", "end": "08:00", "timezone": "Europe/Berlin", "days": ["mon", "tue"]}}
+// Response: {"id": 1, "name": "general", ...}
+func (app *application) updateRoomHandler(w http.ResponseWriter, r *http.Request) {
+	// Get authenticated user ID
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	// Extract room ID from URL
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	// Parse request body
+	var req UpdateRoomRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	room, err := app.store.Rooms.GetByID(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_lookup_failed")
+		return
+	}
+
+	// By default only the owner can change room settings
+	if !app.requireRoomPermission(w, r, room.ID, userID, store.CapManageSettings) {
+		return
+	}
+
+	version, ok := expectedVersion(w, r, req.Version)
+	if !ok {
+		return
+	}
+	if version != 0 && version != room.Version {
+		writeVersionConflict(w, r, room, room.Version)
+		return
+	}
+
+	postPolicy := room.PostPolicy
+	if !app.applyRoomSettings(w, r, room, &req.RoomSettings) {
+		return
+	}
+
+	if err := app.store.Rooms.Update(r.Context(), room, version); err != nil {
+		if errors.Is(err, store.ErrVersionConflict) {
+			// Someone else saved between our read and write
+			app.writeRoomConflict(w, r, room.ID)
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_update_failed")
+		return
+	}
+
+	// The hub caches quiet hours per room; make it pick up the new ones
+	if req.QuietHours != nil {
+		app.hub.InvalidateQuietHours(room.ID)
+	}
+	// Likewise the language, which live join and leave frames are rendered in
+	if req.Language != nil {
+		app.hub.SetRoomLanguage(room.ID, room.Language)
+	}
+	// and the post policy, which connected clients are told about so they
+	// can enable or disable their input box
+	if room.PostPolicy != postPolicy {
+		app.hub.SetRoomPostPolicy(room.ID, room.PostPolicy)
+	}
+	// A new description is sent to the room's webhooks as topic_changed
+	if req.Description != nil {
+		app.outgoingWebhookChanged(room.ID)
+	}
+
+	setETag(w, room.Version)
+	writeJSON(w, http.StatusOK, room)
+}
+
+// applyRoomSettings validates the settings that were provided and applies them to room
+// Room updates and room templates share it, so a template can't hold a
+// setting an update would refuse
+// On failure it writes a 400 and returns false
+func (app *application) applyRoomSettings(w http.ResponseWriter, r *http.Request, room *store.Room, settings *RoomSettings) bool {
+	if settings.Description != nil {
+		room.Description = *settings.Description
+	}
+	if settings.IsPublicReadonly != nil {
+		room.IsPublicReadonly = *settings.IsPublicReadonly
+	}
+	if settings.JoinPolicy != nil {
+		if !store.ValidJoinPolicy(*settings.JoinPolicy) {
+			writeError(w, r, http.StatusBadRequest, "invalid_join_policy")
+			return false
+		}
+		room.JoinPolicy = *settings.JoinPolicy
+	}
+	if settings.ContentFilter != nil {
+		room.ContentFilterEnabled = *settings.ContentFilter
+	}
+	if settings.DuplicateLimit != nil {
+		room.DuplicateLimitEnabled = *settings.DuplicateLimit
+	}
+	if settings.Tags != nil {
+		tags, ok := validateRoomTags(w, r, *settings.Tags)
+		if !ok {
+			return false
+		}
+		room.Tags = tags
+	}
+	if settings.MaxMembers != nil {
+		// Rooms can lower the global member cap, never raise it
+		limit := *settings.MaxMembers
+		globalMax := app.config.limits.maxRoomMembers
+		if limit < 0 || (globalMax > 0 && limit > globalMax) {
+			writeError(w, r, http.StatusBadRequest, "invalid_max_members", globalMax)
+			return false
+		}
+		if limit == 0 {
+			room.MaxMembersOverride = nil
+		} else {
+			room.MaxMembersOverride = &limit
+		}
+	}
+
+	if settings.QuietHours != nil {
+		window, err := parseQuietHours(settings.QuietHours)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_quiet_hours", err.Error())
+			return false
+		}
+		room.QuietHours = window
+	}
+	if settings.Language != nil {
+		room.Language = ""
+		if *settings.Language != "" {
+			tag, ok := sysmsg.CanonicalTag(*settings.Language)
+			if !ok {
+				writeError(w, r, http.StatusBadRequest, "invalid_room_language")
+				return false
+			}
+			room.Language = tag
+		}
+	}
+	if settings.RetentionSeconds != nil {
+		retention, ok := app.parseRoomRetention(w, r, settings.RetentionSeconds)
+		if !ok {
+			return false
+		}
+		room.RetentionSeconds = retention
+	}
+	if settings.PostPolicy != nil {
+		if !store.ValidPostPolicy(*settings.PostPolicy) {
+			writeError(w, r, http.StatusBadRequest, "invalid_post_policy")
+			return false
+		}
+		room.PostPolicy = *settings.PostPolicy
+	}
+	return true
+}
+
+// writeRoomConflict answers a lost update race with the room as it is now
+func (app *application) writeRoomConflict(w http.ResponseWriter, r *http.Request, roomID int64) {
+	current, err := app.store.Rooms.GetByID(r.Context(), roomID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "room_lookup_failed")
+		return
+	}
+	writeVersionConflict(w, r, current, current.Version)
+}
+
+// parseQuietHours decodes the quiet_hours field of a room update
+// JSON null returns a nil window, which turns quiet hours off
+func parseQuietHours(raw json.RawMessage) (*schedule.Window, error) {
+	if string(raw) == "null" {
+		return nil, nil
+	}
+
+	window := &schedule.Window{}
+	if err := json.Unmarshal(raw, window); err != nil {
+		return nil, errors.New("expected start, end, timezone and days")
+	}
+	if err := window.Validate(); err != nil {
+		return nil, err
+	}
+	return window, nil
+}
+
+// joinRoomHandler adds the current user to a room
+// POST /v1/rooms/{roomID}/join
+// Requires authentication
+// What happens depends on the room's join policy:
+//   - open: the user becomes a member right away (200)
+//   - approval: a join request is created for the room admins to review (202)
+//   - invite: the request is refused (403)
+//
+// Response: {"message": "joined room successfully"}
+func (app *application) joinRoomHandler(w http.ResponseWriter, r *http.Request) {
+	// Get authenticated user ID
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	// Extract room ID from URL
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	// Verify room exists
+	room, err := app.store.Rooms.GetByID(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_verify_failed")
+		return
+	}
+
+	switch room.JoinPolicy {
+	case store.JoinPolicyApproval:
+		app.knockRoomHandler(w, r, room, userID)
+		return
+	case store.JoinPolicyInvite:
+		// Members of an invite-only room get the same answer as in any other room
+		isMember, err := app.store.RoomMembers.IsUserInRoom(r.Context(), roomID, userID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "membership_check_failed")
+			return
+		}
+		if isMember {
+			writeError(w, r, http.StatusConflict, "already_member")
+			return
+		}
+		writeError(w, r, http.StatusForbidden, "room_invite_only")
+		return
+	}
+
+	// Join the room
+	if err := app.store.RoomMembers.Join(r.Context(), roomID, userID, userID); err != nil {
+		// Check if already a member (duplicate key error)
+		if store.IsUniqueViolation(err) {
+			writeError(w, r, http.StatusConflict, "already_member")
+			return
+		}
+		if app.writeLimitError(w, r, err) {
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_join_failed")
+		return
+	}
+	app.roomMembersChanged(roomID)
+
+	// Return success message
+	type response struct {
+		Message string `json:"message"`
+	}
+	writeJSON(w, http.StatusOK, response{Message: "joined room successfully"})
+}
+
+// writeLimitError writes the response for a membership limit error
+// It returns false if err isn't a limit error, so the caller can handle it
+func (app *application) writeLimitError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case errors.Is(err, store.ErrRoomFull):
+		writeError(w, r, http.StatusConflict, "room_full")
+		return true
+	case errors.Is(err, store.ErrTooManyRooms):
+		writeError(w, r, http.StatusConflict, "room_quota_exceeded", app.config.limits.maxRoomsPerUser)
+		return true
+	}
+	return false
+}
+
+// roomMembersChanged is called after a room gains or loses members
+// Cached roles are dropped and connected clients get the new member count
+func (app *application) roomMembersChanged(roomID int64) {
+	app.roomAccessCache.invalidateRoom(roomID)
+	app.hub.MembersChanged(roomID)
+	app.outgoingWebhookChanged(roomID)
+}
+
+// roomMessagesChanged is called after rooms' saved messages change other
+// than by a new message (redacted, moved by a merge)
+// Their cached recent messages are dropped, when a MessageCache is in use
+func (app *application) roomMessagesChanged(roomIDs ...int64) {
+	if cache, ok := app.store.Messages.(*store.MessageCache); ok {
+		cache.Invalidate(roomIDs...)
+	}
+}
+
+// leaveRoomHandler removes the current user from a room
+// POST /v1/rooms/{roomID}/leave
+// Requires authentication
+// Response: {"message": "left room successfully"}
+func (app *application) leaveRoomHandler(w http.ResponseWriter, r *http.Request) {
+	// Get authenticated user ID
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	// Extract room ID from URL
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	// Leave the room
+	// This is idempotent - if user is not a member, it silently succeeds
+	if err := app.store.RoomMembers.Leave(r.Context(), roomID, userID, userID); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "room_leave_failed")
+		return
+	}
+	app.roomMembersChanged(roomID)
+
+	// The user's open connections to the room, in other tabs too, are closed
+	app.hub.LeftRoom(roomID, userID)
+
+	// Return success message
+	type response struct {
+		Message string `json:"message"`
+	}
+	writeJSON(w, http.StatusOK, response{Message: "left room successfully"})
+}
+
+// getRoomMessagesHandler retrieves message history for a room
+// GET /v1/rooms/{roomID}/messages?fields=id,content,user_id,created_at&compact=true
+// fields and compact are optional (see parseMessageListOptions)
+// Requires authentication and room membership
+// Response: [{"id": 1, "content": "Hello!", "username": "john", ...}, ...]
+// With compact: {"messages": [{"id": 1, "user_id": 3, ...}], "users": {"3": "john"}}
+func (app *application) getRoomMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	// Get authenticated user ID
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	// Extract room ID from URL
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	listOpts, ok := parseMessageListOptions(w, r)
+	if !ok {
+		return
+	}
+
+	// Check if user is a member of the room
+	// Users can only see messages in rooms they've joined
+	isMember, err := app.store.RoomMembers.IsUserInRoom(r.Context(), roomID, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "membership_check_failed")
+		return
+	}
+	if !isMember {
+		writeError(w, r, http.StatusForbidden, "membership_required_messages")
+		return
+	}
+
+	// Get recent messages (last 100)
+	// In a production app, you'd want pagination or infinite scroll
+	messages, err := app.store.Messages.GetRoomMessages(r.Context(), roomID, 100)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "messages_lookup_failed")
+		return
+	}
+
+	// Return empty array instead of null if no messages
+	if messages == nil {
+		messages = []*store.Message{}
+	}
+
+	// System messages are rendered in the room's language as it is now
+	app.hub.RenderSystemMessages(r.Context(), messages)
+
+	// Loading history delivers everything the user missed while offline
+	app.markDeliveredLatest(roomID, userID)
+
+	writeMessageList(w, http.StatusOK, messages, listOpts)
+}
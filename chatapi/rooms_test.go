@@ -0,0 +1,152 @@
+package chatapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+	ws "github.com/drazan344/go-chat/internal/websocket"
+	"github.com/gorilla/websocket"
+)
+
+// TestMembershipLimits joins and creates rooms at the limits in testLimits:
+// a full room and a user at their room quota are refused with 409, and a
+// user at their quota can't create a room either, since its creator joins it
+func TestMembershipLimits(t *testing.T) {
+	ts := newTestStore(t)
+	for id := int64(1); id <= 5; id++ {
+		ts.rooms.add(&store.Room{ID: id, Name: fmt.Sprintf("room-%d", id), CreatedBy: 1})
+	}
+	// Room 1 is full; linus is already in three rooms
+	for userID := int64(10); userID < 10+int64(testLimits.MaxRoomMembers); userID++ {
+		ts.roomMembers.add(1, userID, store.RoomRoleMember)
+	}
+	for _, roomID := range []int64{2, 3, 4} {
+		ts.roomMembers.add(roomID, 3, store.RoomRoleMember)
+	}
+	server := newTestServer(t, ts)
+
+	for _, tc := range []struct {
+		name     string
+		userID   int64
+		path     string
+		body     any
+		status   int
+		code     string
+		contains string
+	}{
+		{"full room", 2, "/v1/rooms/1/join", nil, http.StatusConflict, "room_full", ""},
+		{"room with space", 2, "/v1/rooms/5/join", nil, http.StatusOK, "", ""},
+		{"user at their quota", 3, "/v1/rooms/5/join", nil, http.StatusConflict, "room_quota_exceeded", "at most 3 rooms"},
+		{"creating at the quota", 3, "/v1/rooms", CreateRoomRequest{Name: "linus-room"}, http.StatusConflict, "room_quota_exceeded", "at most 3 rooms"},
+	} {
+		var failure errorBody
+		status := doJSON(t, http.MethodPost, server.URL+tc.path, tc.userID, tc.body, &failure)
+		if status != tc.status || failure.Code != tc.code || !strings.Contains(failure.Error, tc.contains) {
+			t.Errorf("%s: got %d %q %q, want %d %q", tc.name, status, failure.Code, failure.Error, tc.status, tc.code)
+		}
+	}
+
+	ts.rooms.mu.Lock()
+	rooms := len(ts.rooms.rooms)
+	ts.rooms.mu.Unlock()
+	if rooms != 5 {
+		t.Errorf("there are %d rooms, want 5: none for the user at their quota", rooms)
+	}
+
+	var created store.Room
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms", 2, CreateRoomRequest{Name: "grace-room"}, &created); status != http.StatusCreated {
+		t.Fatalf("creating a room got %d, want 201", status)
+	}
+	if created.MemberCount != 1 {
+		t.Errorf("the new room has %d members, want 1", created.MemberCount)
+	}
+	if admin, _ := ts.roomMembers.IsRoomAdmin(context.Background(), created.ID, 2); !admin {
+		t.Error("the creator isn't the new room's admin")
+	}
+}
+
+// TestUpdateMaxMembers sets a room's own member limit: it may lower the
+// global cap but not raise it, 0 goes back to the global cap, and only the
+// creator may change it
+func TestUpdateMaxMembers(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	server := newTestServer(t, ts)
+	three := 3
+
+	for _, tc := range []struct {
+		userID int64
+		limit  int
+		status int
+		code   string
+		want   *int // The room's override afterwards
+	}{
+		{1, 3, http.StatusOK, "", &three},
+		{1, testLimits.MaxRoomMembers + 1, http.StatusBadRequest, "invalid_max_members", &three},
+		{1, -1, http.StatusBadRequest, "invalid_max_members", &three},
+		{2, 2, http.StatusForbidden, "room_permission_denied", &three},
+		{1, 0, http.StatusOK, "", nil},
+	} {
+		var failure errorBody
+		body := RoomSettings{MaxMembers: &tc.limit}
+		status := doJSON(t, http.MethodPatch, server.URL+"/v1/rooms/1", tc.userID, body, &failure)
+		if status != tc.status || failure.Code != tc.code {
+			t.Errorf("user %d setting %d got %d %q, want %d %q", tc.userID, tc.limit, status, failure.Code, tc.status, tc.code)
+		}
+
+		room, err := ts.rooms.GetByID(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := room.MaxMembersOverride; (got == nil) != (tc.want == nil) || (got != nil && *got != *tc.want) {
+			t.Errorf("user %d setting %d left the override at %v, want %v", tc.userID, tc.limit, got, tc.want)
+		}
+	}
+}
+
+// TestLeaveClosesConnections leaves a room with two tabs open on it: both
+// connections get a left_room frame and are closed for good at once, while
+// the other member's stays open
+func TestLeaveClosesConnections(t *testing.T) {
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	ts.rooms.add(&store.Room{ID: 1, Name: "lobby", CreatedBy: 2})
+	ts.roomMembers.add(1, 1, store.RoomRoleMember)
+	ts.roomMembers.add(1, 2, store.RoomRoleOwner)
+	server, app := newTestServerApp(t, ts)
+
+	grace := dialRoom(t, server, 1, 2)
+	tabs := []*websocket.Conn{dialRoom(t, server, 1, 1), dialRoom(t, server, 1, 1)}
+	if !testutil.WaitFor(2*time.Second, func() bool { return app.hub.GetRoomClientCount(1) == 3 }) {
+		t.Fatal("the connections never registered with the hub")
+	}
+
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/leave", 1, nil, nil); status != http.StatusOK {
+		t.Fatalf("leaving got %d, want 200", status)
+	}
+	for i, tab := range tabs {
+		readFrame(t, tab, "left_room")
+		var closeErr *websocket.CloseError
+		if _, _, err := tab.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != ws.CloseLeftRoom {
+			t.Errorf("after the leave tab %d got %v, want close code %d", i+1, err, ws.CloseLeftRoom)
+		}
+	}
+
+	if got := app.hub.GetRoomClientCount(1); got != 1 {
+		t.Errorf("%d connections are left in the room, want grace's", got)
+	}
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/messages", 2, map[string]string{"content": "still here"}, nil); status != http.StatusCreated {
+		t.Fatalf("grace sending got %d", status)
+	}
+	if frame := readFrame(t, grace, "message"); frame.Content != "still here" {
+		t.Errorf("grace got %q, want her own message", frame.Content)
+	}
+}
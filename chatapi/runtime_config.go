@@ -0,0 +1,380 @@
+package chatapi
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/drazan344/go-chat/internal/websocket"
+	"github.com/joho/godotenv"
+)
+
+// RuntimeConfig holds the settings that can change without a restart
+// Everything in config is read once at boot (listen address, database, ...);
+// these are read again by Server.Reload or POST /v1/admin/config/reload, and a
+// valid new snapshot replaces the old one as a whole. Handlers and the hub
+// load the snapshot once per operation, so open WebSocket connections keep
+// going and simply see the new values from their next message on
+//
+// Each field's env tag names its variable and default its value when unset;
+// reload:"hot" marks it as reloadable, which every field here is
+type RuntimeConfig struct {
+	// Per-user limit on user search and username lookups; 0 disables it
+	UserSearchRateLimit  int           `env:"USER_SEARCH_RATE_LIMIT" default:"30" reload:"hot"`
+	UserSearchRateWindow time.Duration `env:"USER_SEARCH_RATE_WINDOW" default:"1m" reload:"hot"`
+
+	// Per-token limit on messages sent over REST with an API token (bots and
+	// bridges), however many speakers a bridge relays for; 0 disables it
+	APITokenMessageRateLimit  int           `env:"API_TOKEN_MESSAGE_RATE_LIMIT" default:"120" reload:"hot"`
+	APITokenMessageRateWindow time.Duration `env:"API_TOKEN_MESSAGE_RATE_WINDOW" default:"1m" reload:"hot"`
+
+	// Per-IP limit on WebSocket handshakes, checked before any database work
+	// so reconnect storms and floods of bad tokens stay cheap; 0 disables it
+	WSHandshakeRateLimit  int           `env:"WS_HANDSHAKE_RATE_LIMIT" default:"60" reload:"hot"`
+	WSHandshakeRateWindow time.Duration `env:"WS_HANDSHAKE_RATE_WINDOW" default:"10s" reload:"hot"`
+
+	// Chat message length limit (text and markdown, in runes) and what happens
+	// to longer messages: "reject" or "truncate"
+	MessageMaxLength      int    `env:"MESSAGE_MAX_LENGTH" default:"4000" reload:"hot"`
+	MessageOversizePolicy string `env:"MESSAGE_OVERSIZE_POLICY" default:"reject" reload:"hot"`
+
+	// Length limit of code messages, in runes; never below MESSAGE_MAX_LENGTH
+	MessageMaxCodeLength int `env:"MESSAGE_MAX_CODE_LENGTH" default:"10000" reload:"hot"`
+
+	// Largest WebSocket frame a client may send, in bytes; longer ones close
+	// the connection with 1009
+	WSMaxFrameBytes int `env:"WS_MAX_FRAME_BYTES" default:"1048576" reload:"hot"`
+
+	// Identical messages allowed per window in rooms with duplicate_limit_enabled; 0 disables it
+	DuplicateMessageLimit  int           `env:"DUPLICATE_MESSAGE_LIMIT" default:"3" reload:"hot"`
+	DuplicateMessageWindow time.Duration `env:"DUPLICATE_MESSAGE_WINDOW" default:"60s" reload:"hot"`
+
+	// Round-trip time above which a connection counts as slow and is logged; 0 disables it
+	RTTSlowThreshold time.Duration `env:"RTT_SLOW_THRESHOLD" default:"500ms" reload:"hot"`
+
+	// Bytes per second a WebSocket connection may be sent before presence,
+	// receipts and room stats are held back for it; 0 disables throttling
+	ClientBandwidthBudget int `env:"CLIENT_BANDWIDTH_BUDGET" default:"65536" reload:"hot"`
+
+	// Bytes queued across all WebSocket send buffers over which budgets are
+	// cut to a quarter (soft) and the most backed up connections are
+	// disconnected (hard); 0 disables either
+	HubMemorySoftLimit int `env:"HUB_MEMORY_SOFT_LIMIT" default:"268435456" reload:"hot"`
+	HubMemoryHardLimit int `env:"HUB_MEMORY_HARD_LIMIT" default:"536870912" reload:"hot"`
+
+	// How long a WebSocket connection's writes may make no progress, with
+	// frames waiting, before it's disconnected as stale; 0 disables it
+	HubStaleWriteThreshold time.Duration `env:"HUB_STALE_WRITE_THRESHOLD" default:"45s" reload:"hot"`
+
+	// Origins browsers may open WebSocket connections from (comma-separated,
+	// e.g. https://chat.example.com); empty allows any
+	AllowedOrigins []string `env:"ALLOWED_ORIGINS" default:"" reload:"hot"`
+}
+
+// minFrameBytes is the lowest WS_MAX_FRAME_BYTES: below it even a short chat
+// message in its JSON frame wouldn't fit
+const minFrameBytes = 4096
+
+// configProblem is one invalid value found while loading a RuntimeConfig
+type configProblem struct {
+	Key     string
+	Message string
+}
+
+// configError lists every invalid value of a RuntimeConfig
+type configError []configProblem
+
+func (e configError) Error() string {
+	messages := make([]string, len(e))
+	for i, p := range e {
+		messages[i] = p.Key + ": " + p.Message
+	}
+	return "invalid configuration: " + strings.Join(messages, "; ")
+}
+
+// configChange is one setting a reload changed
+type configChange struct {
+	Key string `json:"key"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// loadRuntimeConfig reads the reloadable settings through lookup
+// Every invalid value is reported at once, as a configError
+func loadRuntimeConfig(lookup func(string) (string, bool)) (*RuntimeConfig, error) {
+	rc := &RuntimeConfig{}
+	var problems configError
+
+	v := reflect.ValueOf(rc).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("env")
+		raw, ok := lookup(key)
+		if !ok {
+			raw = field.Tag.Get("default")
+		}
+		raw = strings.TrimSpace(raw)
+
+		switch target := v.Field(i).Addr().Interface().(type) {
+		case *int:
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				problems = append(problems, configProblem{key, fmt.Sprintf("%q is not a whole number", raw)})
+				continue
+			}
+			*target = n
+		case *time.Duration:
+			d, err := time.ParseDuration(raw)
+			if err != nil {
+				problems = append(problems, configProblem{key, fmt.Sprintf("%q is not a duration (e.g. 30s, 5m)", raw)})
+				continue
+			}
+			*target = d
+		case *string:
+			*target = raw
+		case *[]string:
+			list := make([]string, 0)
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			*target = list
+		}
+	}
+
+	problems = append(problems, rc.validate()...)
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return rc, nil
+}
+
+// validate checks the values that parsed for ones that make no sense
+func (rc *RuntimeConfig) validate() configError {
+	var problems configError
+	negative := func(key string, bad bool) {
+		if bad {
+			problems = append(problems, configProblem{key, "must not be negative"})
+		}
+	}
+	negative("USER_SEARCH_RATE_LIMIT", rc.UserSearchRateLimit < 0)
+	negative("API_TOKEN_MESSAGE_RATE_LIMIT", rc.APITokenMessageRateLimit < 0)
+	negative("WS_HANDSHAKE_RATE_LIMIT", rc.WSHandshakeRateLimit < 0)
+	negative("MESSAGE_MAX_LENGTH", rc.MessageMaxLength < 0)
+	negative("MESSAGE_MAX_CODE_LENGTH", rc.MessageMaxCodeLength < 0)
+	negative("DUPLICATE_MESSAGE_LIMIT", rc.DuplicateMessageLimit < 0)
+	negative("RTT_SLOW_THRESHOLD", rc.RTTSlowThreshold < 0)
+	negative("CLIENT_BANDWIDTH_BUDGET", rc.ClientBandwidthBudget < 0)
+	negative("HUB_MEMORY_SOFT_LIMIT", rc.HubMemorySoftLimit < 0)
+	negative("HUB_MEMORY_HARD_LIMIT", rc.HubMemoryHardLimit < 0)
+	negative("HUB_STALE_WRITE_THRESHOLD", rc.HubStaleWriteThreshold < 0)
+
+	if rc.UserSearchRateWindow <= 0 {
+		problems = append(problems, configProblem{"USER_SEARCH_RATE_WINDOW", "must be positive"})
+	}
+	if rc.APITokenMessageRateWindow <= 0 {
+		problems = append(problems, configProblem{"API_TOKEN_MESSAGE_RATE_WINDOW", "must be positive"})
+	}
+	if rc.WSHandshakeRateWindow <= 0 {
+		problems = append(problems, configProblem{"WS_HANDSHAKE_RATE_WINDOW", "must be positive"})
+	}
+	if rc.WSMaxFrameBytes < minFrameBytes {
+		problems = append(problems, configProblem{"WS_MAX_FRAME_BYTES", fmt.Sprintf("must be at least %d", minFrameBytes)})
+	}
+	if rc.DuplicateMessageLimit > 0 && rc.DuplicateMessageWindow <= 0 {
+		problems = append(problems, configProblem{"DUPLICATE_MESSAGE_WINDOW", "must be positive while DUPLICATE_MESSAGE_LIMIT is set"})
+	}
+	if rc.HubMemorySoftLimit > 0 && rc.HubMemoryHardLimit > 0 && rc.HubMemorySoftLimit >= rc.HubMemoryHardLimit {
+		problems = append(problems, configProblem{"HUB_MEMORY_SOFT_LIMIT", "must be below HUB_MEMORY_HARD_LIMIT"})
+	}
+	if _, err := content.ParseOversize(rc.MessageOversizePolicy); err != nil {
+		problems = append(problems, configProblem{"MESSAGE_OVERSIZE_POLICY", err.Error()})
+	}
+	for _, origin := range rc.AllowedOrigins {
+		if !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			problems = append(problems, configProblem{"ALLOWED_ORIGINS", fmt.Sprintf("%q must start with http:// or https://", origin)})
+		}
+	}
+	return problems
+}
+
+// tunables returns the hub's share of the settings
+func (rc *RuntimeConfig) tunables() websocket.Tunables {
+	oversize, _ := content.ParseOversize(rc.MessageOversizePolicy)
+	return websocket.Tunables{
+		Lengths: content.LengthPolicy{
+			MaxLength:     rc.MessageMaxLength,
+			MaxCodeLength: rc.MessageMaxCodeLength,
+			Oversize:      oversize,
+		},
+		MaxFrameBytes:       rc.WSMaxFrameBytes,
+		DuplicateLimit:      rc.DuplicateMessageLimit,
+		DuplicateWindow:     rc.DuplicateMessageWindow,
+		SlowRTT:             rc.RTTSlowThreshold,
+		BandwidthBudget:     rc.ClientBandwidthBudget,
+		MemorySoftLimit:     rc.HubMemorySoftLimit,
+		MemoryHardLimit:     rc.HubMemoryHardLimit,
+		StaleWriteThreshold: rc.HubStaleWriteThreshold,
+	}
+}
+
+// diffRuntimeConfig lists the settings that differ between two snapshots, by variable name
+func diffRuntimeConfig(old, new *RuntimeConfig) []configChange {
+	changes := make([]configChange, 0)
+	oldValue, newValue := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	t := oldValue.Type()
+	for i := 0; i < t.NumField(); i++ {
+		a, b := oldValue.Field(i).Interface(), newValue.Field(i).Interface()
+		if !reflect.DeepEqual(a, b) {
+			changes = append(changes, configChange{Key: t.Field(i).Tag.Get("env"), Old: formatConfigValue(a), New: formatConfigValue(b)})
+		}
+	}
+	return changes
+}
+
+// formatConfigValue shows a setting the way it's written in .env
+func formatConfigValue(value any) string {
+	if list, ok := value.([]string); ok {
+		return strings.Join(list, ",")
+	}
+	return fmt.Sprint(value)
+}
+
+// configReloader re-reads the reloadable settings from .env and the environment
+type configReloader struct {
+	// mu makes reloads from Server.Reload and the endpoint run one at a time
+	mu sync.Mutex
+
+	// path is the .env file; a missing file counts as empty
+	path string
+
+	// processEnv are the variables set before .env was loaded at boot
+	// Like godotenv.Load, they win over the file; every other variable is
+	// taken from the file as it is now, since the process environment
+	// still holds the values the file had at boot
+	processEnv map[string]bool
+}
+
+// newConfigReloader records the process environment; call it before godotenv.Load
+func newConfigReloader(path string) *configReloader {
+	processEnv := make(map[string]bool)
+	for _, entry := range os.Environ() {
+		key, _, _ := strings.Cut(entry, "=")
+		processEnv[key] = true
+	}
+	return &configReloader{path: path, processEnv: processEnv}
+}
+
+// lookup returns a function finding each variable's current value
+func (c *configReloader) lookup() (func(string) (string, bool), error) {
+	file, err := godotenv.Read(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		file, err = map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", c.path, err)
+	}
+
+	return func(key string) (string, bool) {
+		if value, ok := file[key]; ok && !c.processEnv[key] {
+			return value, true
+		}
+		return os.LookupEnv(key)
+	}, nil
+}
+
+// applyRuntimeConfig makes a snapshot current everywhere it's used
+func (app *application) applyRuntimeConfig(rc *RuntimeConfig) {
+	app.runtime.Store(rc)
+	app.directoryLimiter.configure(rc.UserSearchRateLimit, rc.UserSearchRateWindow)
+	app.tokenMessageLimiter.configure(rc.APITokenMessageRateLimit, rc.APITokenMessageRateWindow)
+	app.keyMessageLimiter.configure(rc.APITokenMessageRateLimit, rc.APITokenMessageRateWindow)
+	app.upgrades.limiter.configure(rc.WSHandshakeRateLimit, rc.WSHandshakeRateWindow)
+	app.hub.SetTunables(rc.tunables())
+}
+
+// reloadConfig reads the reloadable settings again and applies them if they're valid
+// An invalid value keeps the current snapshot and returns a configError
+// The changes are logged and returned
+func (app *application) reloadConfig() ([]configChange, error) {
+	app.reloader.mu.Lock()
+	defer app.reloader.mu.Unlock()
+
+	lookup, err := app.reloader.lookup()
+	if err != nil {
+		return nil, err
+	}
+	rc, err := loadRuntimeConfig(lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := diffRuntimeConfig(app.runtime.Load(), rc)
+	app.applyRuntimeConfig(rc)
+
+	if len(changes) == 0 {
+		log.Println("Configuration reloaded: nothing changed")
+	}
+	for _, change := range changes {
+		log.Printf("Configuration reloaded: %s changed from %q to %q", change.Key, change.Old, change.New)
+	}
+	return changes, nil
+}
+
+// reloadConfigHandler reloads the reloadable settings from .env and the environment
+// Invalid values leave the running configuration as it was and are listed per variable
+// POST /v1/admin/config/reload
+// Requires the X-Ops-Token header
+// Response: {"changed": [{"key": "MESSAGE_MAX_LENGTH", "old": "4000", "new": "2000"}]}
+// Response (400): {"error": "...", "code": "config_invalid", "fields": {"MESSAGE_MAX_LENGTH": [{"error": "...", "code": "config_invalid_value"}]}}
+func (app *application) reloadConfigHandler(w http.ResponseWriter, r *http.Request) {
+	changes, err := app.reloadConfig()
+	if err != nil {
+		var problems configError
+		if errors.As(err, &problems) {
+			fields := make(map[string][]fieldError)
+			for _, p := range problems {
+				fields[p.Key] = append(fields[p.Key], fieldError{Error: p.Message, Code: "config_invalid_value"})
+			}
+			writeFieldErrors(w, r, http.StatusBadRequest, "config_invalid", fields)
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "config_reload_failed")
+		return
+	}
+
+	type response struct {
+		Changed []configChange `json:"changed"`
+	}
+	writeJSON(w, http.StatusOK, response{Changed: changes})
+}
+
+// rejectIfOriginNotAllowed turns away WebSocket upgrades from origins outside ALLOWED_ORIGINS
+// Requests without an Origin header (bots, native apps) are always let through;
+// browsers always send one. Returns true if the request was rejected
+func (app *application) rejectIfOriginNotAllowed(w http.ResponseWriter, r *http.Request) bool {
+	allowed := app.runtime.Load().AllowedOrigins
+	origin := r.Header.Get("Origin")
+	if len(allowed) == 0 || origin == "" {
+		return false
+	}
+	for _, candidate := range allowed {
+		if strings.EqualFold(strings.TrimRight(candidate, "/"), origin) {
+			return false
+		}
+	}
+
+	writeError(w, r, http.StatusForbidden, "origin_not_allowed")
+	return true
+}
@@ -0,0 +1,213 @@
+package chatapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/gorilla/websocket"
+)
+
+// TestLoadRuntimeConfig reads the defaults when nothing is set, and reports
+// every invalid value at once, by variable
+func TestLoadRuntimeConfig(t *testing.T) {
+	rc, err := loadRuntimeConfig(func(string) (string, bool) { return "", false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.MessageMaxLength != 4000 || rc.UserSearchRateWindow != time.Minute || rc.RTTSlowThreshold != 500*time.Millisecond || len(rc.AllowedOrigins) != 0 {
+		t.Errorf("the defaults are %+v", rc)
+	}
+
+	env := map[string]string{
+		"MESSAGE_MAX_LENGTH":       "lots",
+		"USER_SEARCH_RATE_WINDOW":  "0s",
+		"DUPLICATE_MESSAGE_LIMIT":  "-1",
+		"RTT_SLOW_THRESHOLD":       "soon",
+		"MESSAGE_OVERSIZE_POLICY":  "shout",
+		"ALLOWED_ORIGINS":          "https://chat.example.com, chat.example.org",
+		"DUPLICATE_MESSAGE_WINDOW": "90s",
+	}
+	_, err = loadRuntimeConfig(func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	})
+	problems, ok := err.(configError)
+	if !ok {
+		t.Fatalf("got %v, want a configError", err)
+	}
+	var keys []string
+	for _, p := range problems {
+		keys = append(keys, p.Key)
+	}
+	slices.Sort(keys)
+	want := []string{"ALLOWED_ORIGINS", "DUPLICATE_MESSAGE_LIMIT", "MESSAGE_MAX_LENGTH", "MESSAGE_OVERSIZE_POLICY", "RTT_SLOW_THRESHOLD", "USER_SEARCH_RATE_WINDOW"}
+	if !slices.Equal(keys, want) {
+		t.Errorf("the problems are with %v, want %v", keys, want)
+	}
+}
+
+// newReloadStore has ada in room 1
+func newReloadStore(t *testing.T) *testStore {
+	t.Helper()
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.users.add(&store.User{ID: 1, Username: "ada", Email: "ada@example.com", Discoverable: true})
+	return ts
+}
+
+// withReloader reads the reloadable settings from the .env file at path,
+// and turns the ops endpoints on
+// processEnv names variables set in the environment before the file was read
+func withReloader(path string, processEnv ...string) testOption {
+	return func(app *application) {
+		withOps(app)
+		app.reloader = &configReloader{path: path, processEnv: make(map[string]bool)}
+		for _, key := range processEnv {
+			app.reloader.processEnv[key] = true
+		}
+	}
+}
+
+// reload writes the .env file at path and asks the server to reload it
+func reload(t *testing.T, serverURL, path, dotenv string, out any) int {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(dotenv), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	headers := map[string]string{opsTokenHeader: "ops-secret"}
+	return doJSONWithHeaders(t, http.MethodPost, serverURL+"/v1/admin/config/reload", 0, headers, nil, out)
+}
+
+// TestReloadConfig lowers the message length limit and turns on the search
+// rate limit through .env while ada is connected: the open connection is
+// held to the new limit from its next message without being dropped, and
+// the next search is limited
+func TestReloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	server := newTestServer(t, newReloadStore(t), withReloader(path))
+	conn := dialRoom(t, server, 1, 1)
+	readFrame(t, conn, "join")
+
+	// An empty file: every setting back to its default
+	if status := reload(t, server.URL, path, "", nil); status != http.StatusOK {
+		t.Fatalf("reloading an empty file got %d, want 200", status)
+	}
+	if err := conn.WriteJSON(map[string]string{"content": "hello world"}); err != nil {
+		t.Fatal(err)
+	}
+	readFrame(t, conn, "message")
+
+	var resp struct {
+		Changed []configChange `json:"changed"`
+	}
+	dotenv := "MESSAGE_MAX_LENGTH=5\nUSER_SEARCH_RATE_LIMIT=1\n"
+	if status := reload(t, server.URL, path, dotenv, &resp); status != http.StatusOK {
+		t.Fatalf("reloading got %d, want 200", status)
+	}
+	want := []configChange{{"USER_SEARCH_RATE_LIMIT", "30", "1"}, {"MESSAGE_MAX_LENGTH", "4000", "5"}}
+	if !slices.Equal(resp.Changed, want) {
+		t.Errorf("the changes are %+v, want %+v", resp.Changed, want)
+	}
+
+	if err := conn.WriteJSON(map[string]string{"content": "hello world"}); err != nil {
+		t.Fatal(err)
+	}
+	if frame := readFrame(t, conn, "error"); frame.Code != "message_too_long" {
+		t.Errorf("the long message got error %q, want message_too_long", frame.Code)
+	}
+	if err := conn.WriteJSON(map[string]string{"content": "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	readFrame(t, conn, "message")
+
+	search := server.URL + "/v1/users/search?q=ad"
+	if status := doJSON(t, http.MethodGet, search, 1, nil, nil); status != http.StatusOK {
+		t.Errorf("the first search got %d, want 200", status)
+	}
+	if status := doJSON(t, http.MethodGet, search, 1, nil, nil); status != http.StatusTooManyRequests {
+		t.Errorf("the second search got %d, want 429", status)
+	}
+}
+
+// TestReloadConfigInvalid keeps the running settings when any reloaded value
+// is invalid, and lists the problems per variable
+func TestReloadConfigInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	server, app := newTestServerApp(t, newReloadStore(t), withReloader(path))
+
+	var failure struct {
+		Code   string                  `json:"code"`
+		Fields map[string][]fieldError `json:"fields"`
+	}
+	dotenv := "MESSAGE_MAX_LENGTH=5\nDUPLICATE_MESSAGE_WINDOW=forever\n"
+	if status := reload(t, server.URL, path, dotenv, &failure); status != http.StatusBadRequest || failure.Code != "config_invalid" {
+		t.Fatalf("reloading got %d %q, want 400 config_invalid", status, failure.Code)
+	}
+	if len(failure.Fields) != 1 || failure.Fields["DUPLICATE_MESSAGE_WINDOW"][0].Code != "config_invalid_value" {
+		t.Errorf("the problems are %+v, want DUPLICATE_MESSAGE_WINDOW alone", failure.Fields)
+	}
+	if max := app.runtime.Load().MessageMaxLength; max != 4000 {
+		t.Errorf("the message length limit is %d after a failed reload, want 4000", max)
+	}
+	if max := app.hub.LengthPolicy().MaxLength; max != 0 {
+		t.Errorf("the hub's length limit is %d after a failed reload, want the default", max)
+	}
+}
+
+// TestReloadConfigPrecedence keeps a variable set in the process
+// environment before boot over the one in .env, as at boot
+func TestReloadConfigPrecedence(t *testing.T) {
+	t.Setenv("MESSAGE_MAX_LENGTH", "100")
+	path := filepath.Join(t.TempDir(), ".env")
+	server, app := newTestServerApp(t, newReloadStore(t), withReloader(path, "MESSAGE_MAX_LENGTH"))
+
+	if status := reload(t, server.URL, path, "MESSAGE_MAX_LENGTH=5\nDUPLICATE_MESSAGE_LIMIT=9\n", nil); status != http.StatusOK {
+		t.Fatalf("reloading got %d, want 200", status)
+	}
+	if rc := app.runtime.Load(); rc.MessageMaxLength != 100 || rc.DuplicateMessageLimit != 9 {
+		t.Errorf("got length %d and duplicate limit %d, want the environment's 100 and the file's 9", rc.MessageMaxLength, rc.DuplicateMessageLimit)
+	}
+	if tunables := app.hub.Tunables(); tunables.Lengths.MaxLength != 100 || tunables.DuplicateLimit != 9 {
+		t.Errorf("the hub got %+v, want the reloaded values", tunables)
+	}
+}
+
+// TestAllowedOrigins turns away WebSocket upgrades from origins outside a
+// reloaded ALLOWED_ORIGINS, and still lets clients without an Origin in
+func TestAllowedOrigins(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	server := newTestServer(t, newReloadStore(t), withReloader(path))
+	if status := reload(t, server.URL, path, "ALLOWED_ORIGINS=https://chat.example.com/\n", nil); status != http.StatusOK {
+		t.Fatalf("reloading got %d, want 200", status)
+	}
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/rooms/1/ws"
+	for _, tc := range []struct {
+		origin string
+		status int
+	}{
+		{"https://evil.example.com", http.StatusForbidden},
+		{"https://chat.example.com", http.StatusSwitchingProtocols},
+		{"", http.StatusSwitchingProtocols},
+	} {
+		header := asUser(t, httptest.NewRequest(http.MethodGet, url, nil), 1).Header
+		if tc.origin != "" {
+			header.Set("Origin", tc.origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if err == nil {
+			conn.Close()
+		}
+		if resp == nil || resp.StatusCode != tc.status {
+			t.Errorf("origin %q got %v, want %d", tc.origin, resp, tc.status)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package chatapi
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/drazan344/go-chat/db/migrations"
+	"github.com/drazan344/go-chat/internal/migrate"
+)
+
+// CheckSchema makes sure the database has every migration this build expects
+// An API started against a database that's behind fails much later with
+// errors like "column does not exist", so it's refused up front, naming the
+// missing versions. With autoMigrate the pending migrations are applied first,
+// using the same code as cmd/migrate. Migrations the build doesn't know
+// (a newer build ran, then was rolled back) only log a warning
+func CheckSchema(database *sql.DB, autoMigrate bool) error {
+	set, err := migrate.Read(migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	if autoMigrate {
+		if err := migrate.EnsureTable(database); err != nil {
+			return fmt.Errorf("failed to create migrations table: %w", err)
+		}
+		if err := migrate.CheckDirty(database); err != nil {
+			return err
+		}
+		if err := migrate.Up(database, set); err != nil {
+			return fmt.Errorf("auto-migration failed: %w", err)
+		}
+	}
+
+	status, err := migrate.Verify(database, set)
+	if err != nil {
+		return fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	if err := status.Err(); err != nil {
+		return err
+	}
+	if len(status.Extra) > 0 {
+		log.Printf("Warning: database has migrations this build doesn't know: %s", status.ExtraString())
+	}
+
+	log.Printf("Database schema is current (%d migrations)", len(set))
+	return nil
+}
@@ -0,0 +1,313 @@
+package chatapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/pkg/chatclient"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// Self-test
+//
+// SelfTest checks a build against its environment the way a user would:
+// it serves Handler on a loopback listener, registers a throwaway account,
+// logs in, creates and joins a room, sends a message over the room's
+// WebSocket and finds it in the history. Everything goes through the real
+// Storage, so a pass also means the database is reachable and migrated
+// The account and room are named selftestPrefix plus a random suffix and
+// are deleted afterwards, whatever happened; nothing else is changed, so
+// it's safe to run against production. The one trace left is the quiet
+// join of the default rooms at registration in their event logs, which
+// their retention purges like any other
+
+// selftestPrefix starts the names of the accounts and rooms the self-test
+// creates, so what an interrupted run left behind is easy to find
+const selftestPrefix = "selftest-"
+
+// selftestStepTimeout bounds each step, and the cleanup
+const selftestStepTimeout = 15 * time.Second
+
+// selftestStep is one check of the self-test
+type selftestStep struct {
+	name string
+	run  func(context.Context) error
+}
+
+// selftest is one run of the self-test and what its steps have made so far
+type selftest struct {
+	app     *application
+	baseURL string
+
+	name     string // The account's username and the room's name
+	password string
+	token    string
+	userID   int64
+	roomID   int64
+	client   *chatclient.Client
+	received chan *wire.Message
+	sent     *wire.Message // The message as it came back over the WebSocket
+}
+
+// SelfTest runs the self-test, writing a PASS, FAIL or SKIP line per step to
+// out, and returns an error if any step failed
+// The hub is started if the Server wasn't (the background jobs aren't:
+// a self-test shouldn't purge or send anything)
+func (s *Server) SelfTest(ctx context.Context, out io.Writer) error {
+	s.start.Do(func() { s.app.startHub(ctx) })
+
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	secret := make([]byte, 16)
+	rand.Read(secret)
+	test := &selftest{
+		app:     s.app,
+		baseURL: server.URL,
+		name:    selftestPrefix + hex.EncodeToString(suffix),
+		// Long and mixed, so any password policy takes it
+		password: "St-" + hex.EncodeToString(secret) + "-9x!",
+		received: make(chan *wire.Message, 16),
+	}
+	fmt.Fprintf(out, "self-test as %s against %s\n", test.name, server.URL)
+
+	steps := []selftestStep{
+		{"register", test.register},
+		{"login", test.login},
+		{"create room", test.createRoom},
+		{"join room", test.joinRoom},
+		{"open websocket", test.openWebSocket},
+		{"send message", test.sendMessage},
+		{"fetch history", test.fetchHistory},
+	}
+	failed := 0
+	for _, step := range steps {
+		if failed > 0 {
+			fmt.Fprintf(out, "SKIP %s\n", step.name)
+			continue
+		}
+		if !runSelftestStep(ctx, out, step) {
+			failed++
+		}
+	}
+	// Cleaned up even if ctx is done, so an interrupted run leaves nothing behind
+	if !runSelftestStep(context.WithoutCancel(ctx), out, selftestStep{"clean up", test.cleanUp}) {
+		failed++
+	}
+
+	if failed > 0 {
+		fmt.Fprintln(out, "self-test FAILED")
+		return fmt.Errorf("self-test failed: %d of %d steps", failed, len(steps)+1)
+	}
+	fmt.Fprintln(out, "self-test passed")
+	return nil
+}
+
+// runSelftestStep runs a step under selftestStepTimeout, reports it and
+// returns whether it passed
+func runSelftestStep(ctx context.Context, out io.Writer, step selftestStep) bool {
+	ctx, cancel := context.WithTimeout(ctx, selftestStepTimeout)
+	defer cancel()
+	start := time.Now()
+	err := step.run(ctx)
+	took := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		fmt.Fprintf(out, "FAIL %s (%s): %v\n", step.name, took, err)
+		return false
+	}
+	fmt.Fprintf(out, "PASS %s (%s)\n", step.name, took)
+	return true
+}
+
+func (test *selftest) register(ctx context.Context) error {
+	var resp AuthResponse
+	req := RegisterRequest{Username: test.name, Email: test.email(), Password: test.password}
+	if err := test.call(ctx, http.MethodPost, "/v1/auth/register", req, &resp, http.StatusCreated); err != nil {
+		return err
+	}
+	if resp.User == nil || resp.User.ID == 0 {
+		return errors.New("the response has no user")
+	}
+	test.userID = resp.User.ID
+	return nil
+}
+
+func (test *selftest) login(ctx context.Context) error {
+	var resp AuthResponse
+	req := LoginRequest{Email: test.email(), Password: test.password}
+	if err := test.call(ctx, http.MethodPost, "/v1/auth/login", req, &resp, http.StatusOK); err != nil {
+		return err
+	}
+	if resp.Token == "" {
+		return errors.New("the response has no token")
+	}
+	test.token = resp.Token
+	return nil
+}
+
+func (test *selftest) createRoom(ctx context.Context) error {
+	var room store.Room
+	req := CreateRoomRequest{Name: test.name, Description: "go-chat self-test, deleted when it ends"}
+	if err := test.call(ctx, http.MethodPost, "/v1/rooms", req, &room, http.StatusCreated); err != nil {
+		return err
+	}
+	test.roomID = room.ID
+	return nil
+}
+
+// joinRoom joins the room; as its creator the account is a member already,
+// which the server must say rather than fail
+func (test *selftest) joinRoom(ctx context.Context) error {
+	err := test.call(ctx, http.MethodPost, fmt.Sprintf("/v1/rooms/%d/join", test.roomID), nil, nil, http.StatusOK)
+	var apiErr *chatclient.APIError
+	if errors.As(err, &apiErr) && apiErr.Code == "already_member" {
+		return nil
+	}
+	return err
+}
+
+func (test *selftest) openWebSocket(ctx context.Context) error {
+	client, err := chatclient.Dial(ctx, test.baseURL, test.token)
+	if err != nil {
+		return err
+	}
+	test.client = client
+	client.Subscribe(chatclient.FrameMessage, func(m *wire.Message) {
+		select {
+		case test.received <- m:
+		default:
+		}
+	})
+	client.Subscribe(chatclient.FrameError, func(m *wire.Message) {
+		select {
+		case test.received <- m:
+		default:
+		}
+	})
+	return client.OpenRoom(ctx, test.roomID)
+}
+
+// sendMessage sends a message and waits for the room to send it back
+func (test *selftest) sendMessage(ctx context.Context) error {
+	content := "self-test message from " + test.name
+	if err := test.client.SendMessage(ctx, test.roomID, content); err != nil {
+		return err
+	}
+	for {
+		select {
+		case m := <-test.received:
+			if m.Type == chatclient.FrameError {
+				return fmt.Errorf("the message was refused: %s", m.Code)
+			}
+			if m.RoomID == test.roomID && m.UserID == test.userID && m.Content == content {
+				if m.ID == 0 {
+					return errors.New("the message came back without an ID, so it wasn't saved")
+				}
+				test.sent = m
+				return nil
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("the message didn't come back: %w", ctx.Err())
+		}
+	}
+}
+
+// fetchHistory checks the message sent is in the room's history as it came back
+func (test *selftest) fetchHistory(ctx context.Context) error {
+	var history []*store.Message
+	if err := test.call(ctx, http.MethodGet, fmt.Sprintf("/v1/rooms/%d/messages", test.roomID), nil, &history, http.StatusOK); err != nil {
+		return err
+	}
+	for _, m := range history {
+		if m.ID == test.sent.ID {
+			if m.Content != test.sent.Content || m.UserID != test.userID {
+				return fmt.Errorf("message %d was saved as %q by user %d", m.ID, m.Content, m.UserID)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("message %d isn't in the history", test.sent.ID)
+}
+
+// cleanUp deletes what the steps created, straight from the store
+// Deleting the room takes its messages with it, and deleting the account its
+// sessions and memberships
+func (test *selftest) cleanUp(ctx context.Context) error {
+	if test.client != nil {
+		test.client.Close()
+	}
+	var errs []error
+	if test.roomID != 0 {
+		if err := test.app.store.Rooms.Delete(ctx, test.roomID); err != nil {
+			errs = append(errs, fmt.Errorf("deleting room %d: %w", test.roomID, err))
+		}
+	}
+	if test.userID != 0 {
+		if err := test.app.store.Users.Delete(ctx, test.userID); err != nil {
+			errs = append(errs, fmt.Errorf("deleting user %d: %w", test.userID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (test *selftest) email() string {
+	return test.name + "@selftest.invalid"
+}
+
+// call makes a request to the server as the account, once it's logged in
+// A status other than want is returned as a *chatclient.APIError
+func (test *selftest) call(ctx context.Context, method, path string, body, out any, want int) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, test.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if test.token != "" {
+		req.Header.Set("Authorization", "Bearer "+test.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		apiErr := &chatclient.APIError{Status: resp.StatusCode}
+		var e struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(data, &e) == nil {
+			apiErr.Code, apiErr.Message = e.Code, e.Error
+		} else {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
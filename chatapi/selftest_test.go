@@ -0,0 +1,55 @@
+package chatapi
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/auth"
+)
+
+// TestSelfTest runs the self-test on the in-memory store: every step passes
+// and what it created is gone afterwards. With registration refused, the
+// steps after it are skipped, the cleanup still runs, and it fails
+func TestSelfTest(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		minLength int
+		want      []string
+	}{
+		{"passing", 0, []string{
+			"PASS register", "PASS login", "PASS create room", "PASS join room", "PASS open websocket",
+			"PASS send message", "PASS fetch history", "PASS clean up", "self-test passed",
+		}},
+		{"registration refused", 200, []string{
+			"FAIL register", "SKIP login", "SKIP create room", "SKIP join room", "SKIP open websocket",
+			"SKIP send message", "SKIP fetch history", "PASS clean up", "self-test FAILED",
+		}},
+	} {
+		ts := newTestStore(t)
+		newOnboardingUsers(ts)
+		app := newTestApp(ts)
+		app.passwords = &auth.PasswordPolicy{MinLength: tc.minLength}
+		srv := &Server{app: app}
+		srv.start.Do(func() {}) // newTestApp runs the hub
+
+		var out bytes.Buffer
+		err := srv.SelfTest(t.Context(), &out)
+		if passed := tc.name == "passing"; (err == nil) != passed {
+			t.Errorf("%s: SelfTest returned %v", tc.name, err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != len(tc.want)+1 || !strings.HasPrefix(lines[0], "self-test as "+selftestPrefix) {
+			t.Fatalf("%s: the output is\n%s", tc.name, out.String())
+		}
+		for i, want := range tc.want {
+			if !strings.HasPrefix(lines[i+1], want) {
+				t.Errorf("%s: line %d is %q, want %q", tc.name, i+2, lines[i+1], want)
+			}
+		}
+		if len(ts.users.users) != 0 || len(ts.rooms.rooms) != 0 {
+			t.Errorf("%s: %d users and %d rooms were left behind", tc.name, len(ts.users.users), len(ts.rooms.rooms))
+		}
+	}
+}
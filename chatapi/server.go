@@ -0,0 +1,347 @@
+package chatapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/auth"
+	"github.com/drazan344/go-chat/internal/blob"
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/drazan344/go-chat/internal/flags"
+	"github.com/drazan344/go-chat/internal/notify"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/webhook"
+	"github.com/drazan344/go-chat/internal/websocket"
+)
+
+// Embedding
+//
+// The binary in cmd/api is only wiring; an application can embed the chat
+// the same way: LoadConfig, build the Storage and Hub, create a Server with
+// New, Start it, and serve Handler (on its own or mounted under a prefix of
+// another router, see WithBasePath). WithUserResolver replaces the built-in
+// JWT and API token authentication with the application's own. The package
+// installs no signal handlers: the binary calls Reload on SIGHUP, and an
+// embedding application calls it whenever it likes. See examples/embed for a
+// complete program
+//
+// The database schema comes from the migrations in db/migrations; CheckSchema
+// checks (or applies) them at boot, as the binary does
+
+// Types an embedding application needs from the internal packages, which
+// it can't import itself: the storage and hub it builds, and the records its
+// user resolver looks up through Storage
+type (
+	Storage    = store.Storage
+	Pools      = store.Pools
+	Limits     = store.Limits
+	Hub        = websocket.Hub
+	User       = store.User
+	PublicUser = store.PublicUser
+	Room       = store.Room
+	Message    = store.Message
+)
+
+// NewPools wraps the primary database (and an optional read replica, or nil) for NewPostgresStorage
+func NewPools(primary, replica *sql.DB) *Pools {
+	return store.NewPools(primary, replica)
+}
+
+// NewPostgresStorage creates the Storage backed by PostgreSQL
+func NewPostgresStorage(pools *Pools, limits Limits) Storage {
+	return store.NewPostgresStorage(pools, limits)
+}
+
+// CacheMessages puts a cache of rooms' recent messages in front of
+// st.Messages, as cfg sets it up (see store.MessageCache); with
+// cfg.MaxMessages 0 it returns st as it is
+// Call it before NewHub, so the messages the hub saves are cached too
+func CacheMessages(st Storage, cfg MessageCacheConfig) Storage {
+	if cfg.MaxMessages > 0 {
+		st.Messages = store.NewMessageCache(st.Messages, cfg.MaxMessages, cfg.TTL)
+	}
+	return st
+}
+
+// NewHub creates the WebSocket hub with shards shards (0 picks one per CPU)
+// The Server configures and runs it
+func NewHub(st Storage, shards int) *Hub {
+	return websocket.NewHub(st, shards)
+}
+
+// Server is the chat's HTTP API and WebSocket endpoints
+type Server struct {
+	app   *application
+	start sync.Once
+}
+
+// Option changes how New sets up a Server
+type Option func(*serverOptions)
+
+type serverOptions struct {
+	pools    *Pools
+	resolver UserResolver
+	basePath string
+}
+
+// WithPools reports the database pools' stats in GET /v1/health/ready
+func WithPools(pools *Pools) Option {
+	return func(o *serverOptions) { o.pools = pools }
+}
+
+// WithUserResolver authenticates requests with resolver instead of JWTs and API tokens
+func WithUserResolver(resolver UserResolver) Option {
+	return func(o *serverOptions) { o.resolver = resolver }
+}
+
+// WithBasePath tells the Server it's mounted under a path prefix (e.g. "/chat"),
+// for the URLs it hands out; the caller strips the prefix before Handler sees
+// the request (chi's Mount and http.StripPrefix both do)
+func WithBasePath(prefix string) Option {
+	return func(o *serverOptions) { o.basePath = prefix }
+}
+
+// New creates a Server on a Storage and a Hub
+// It configures the hub (content filter, tunables, webhooks, push, ...) and
+// makes sure the system user exists; nothing runs until Start
+func New(cfg *Config, st Storage, hub *Hub, opts ...Option) (*Server, error) {
+	var o serverOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	// Server-originated messages are posted as the system user; create it if it's missing
+	// A real account already using SYSTEM_USERNAME stops startup, since the two would be confused
+	systemCtx, cancelSystem := context.WithTimeout(context.Background(), 10*time.Second)
+	err := st.Users.EnsureSystemUser(systemCtx, cfg.config.systemUsername)
+	cancelSystem()
+	if err != nil {
+		return nil, fmt.Errorf("failed to provision the system user (is SYSTEM_USERNAME taken?): %w", err)
+	}
+
+	// Moderate chat messages with the configured wordlist (if any)
+	filter, err := newContentFilter(cfg.config.moderation.wordlistPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load content filter: %w", err)
+	}
+	hub.SetContentFilter(filter)
+	wordlist, _ := filter.(*content.WordlistFilter)
+
+	// Rooms' moderation bots, when MODERATION_HOOK_HOSTS allows any
+	if len(cfg.config.moderation.hookHosts) > 0 {
+		hub.SetModerationCaller(webhook.NewModerator())
+	}
+
+	hub.SetPresenceGrace(cfg.presenceGrace)
+	hub.SetMembershipSweepInterval(cfg.membershipSweep)
+	hub.SetDefaultLanguage(cfg.config.rooms.defaultLanguage)
+	hub.SetTunables(cfg.runtime.tunables())
+
+	// Counters show up under "audit" in /v1/health/ready
+	hub.SetSequenceAudit(cfg.sequenceAudit)
+
+	// Numbers every frame per user and keeps the recent ones (see /v1/users/me/events)
+	hub.SetUserEvents(cfg.userEvents)
+
+	// One place decides who wants which notifications, shared by every sender
+	notifications := notify.NewCachedPolicy(st, notify.DefaultCacheTTL)
+	hub.SetNotificationPolicy(notifications)
+
+	// Features rolled out gradually, per user or room; consulted by handlers and the hub
+	featureFlags := flags.NewCachedChecker(st, flags.DefaultCacheTTL)
+	hub.SetFeatureFlags(featureFlags)
+
+	// Forward hub events to an external service (push notifications, analytics)
+	// Hooks run on their own workers, so a slow webhook never delays chat
+	registerWebhook(hub, cfg.config.webhook)
+
+	// Rooms' own webhooks (message_created, member_joined, ...), when OUTGOING_WEBHOOK_HOSTS allows any
+	outgoing := startOutgoingWebhooks(hub, st, cfg.config.webhook)
+
+	// Notify offline users on their devices when they're mentioned
+	if err := startPushNotifier(hub, st, notifications, cfg.config.push); err != nil {
+		return nil, fmt.Errorf("failed to start push notifications: %w", err)
+	}
+
+	// Attachment files are stored by content hash, so identical uploads share one file
+	blobs, err := blob.NewFileStore(cfg.config.attachments.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open attachment storage: %w", err)
+	}
+
+	passwords := &auth.PasswordPolicy{MinLength: cfg.config.auth.passwordMinLength, RequiredClasses: cfg.config.auth.passwordClasses}
+	if cfg.config.auth.passwordDictionary != "" {
+		if passwords.Dictionary, err = loadPasswordDictionary(cfg.config.auth.passwordDictionary); err != nil {
+			return nil, fmt.Errorf("failed to load PASSWORD_DICTIONARY: %w", err)
+		}
+	}
+	if cfg.config.auth.breachCheck {
+		// If the API doesn't answer in time the password is allowed (fail open)
+		passwords.BreachClient = &http.Client{Timeout: cfg.config.auth.breachTimeout}
+	}
+
+	// TOTP secrets are encrypted with their own key when one is set; changing
+	// the key (or JWT_SECRET without one) makes existing 2FA setups unusable
+	twoFactorKey := cfg.config.auth.twoFactorKey
+	if twoFactorKey == "" {
+		twoFactorKey = cfg.config.auth.jwtSecret
+	}
+	secrets, err := auth.NewSecretBox(twoFactorKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up 2FA secret encryption: %w", err)
+	}
+
+	translator, err := newTranslator(cfg.config.translate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up translation: %w", err)
+	}
+
+	// Email (digests, room invites) is off unless MAIL_PROVIDER is set
+	mailer, err := newMailer(cfg.config.mail)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up email: %w", err)
+	}
+
+	// Signing in with Google or GitHub is off unless OAUTH_*_CLIENT_ID is set
+	providers, err := newOAuthProviders(cfg.config, o.basePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up OAuth: %w", err)
+	}
+
+	rc := cfg.runtime
+	ctx, cancel := context.WithCancel(context.Background())
+	app := &application{
+		ctx:    ctx,
+		cancel: cancel,
+
+		config:   cfg.config,
+		store:    st,
+		pools:    o.pools,
+		hub:      hub,
+		guests:   newGuestLimiter(cfg.config.guest.maxConnsPerIP),
+		blobs:    blobs,
+		wordlist: wordlist,
+		now:      time.Now,
+		resolver: o.resolver,
+		basePath: o.basePath,
+
+		hubSnapshotPath:     cfg.snapshotPath,
+		hubSnapshotInterval: cfg.snapshotInterval,
+
+		thumbnailSlots: make(chan struct{}, thumbnailWorkers),
+
+		directoryLimiter:    newRateLimiter[int64](rc.UserSearchRateLimit, rc.UserSearchRateWindow),
+		tokenMessageLimiter: newRateLimiter[int64](rc.APITokenMessageRateLimit, rc.APITokenMessageRateWindow),
+		keyMessageLimiter:   newRateLimiter[int64](rc.APITokenMessageRateLimit, rc.APITokenMessageRateWindow),
+		twoFactorLimiter:    newRateLimiter[int64](twoFactorAttempts, twoFactorAttemptWindow),
+		upgrades:            newUpgradeGuard(cfg.config.upgrades.maxInFlight),
+		reloader:            cfg.reloader,
+
+		passwordResetLimiter: newRateLimiter[string](passwordResetRequests, passwordResetRequestWindow),
+		oauth:                providers,
+
+		passwords:     passwords,
+		secrets:       secrets,
+		translator:    translator,
+		notifications: notifications,
+		flags:         featureFlags,
+
+		roomAccessCache: newRoomAccessCache(),
+		tokenVersions:   newTokenVersionCache(),
+		mailer:          mailer,
+		outgoing:        outgoing,
+	}
+	app.applyRuntimeConfig(rc)
+
+	// Invitees can answer invites over the socket as well as over REST
+	hub.SetInviteResponder(app)
+
+	return &Server{app: app}, nil
+}
+
+// Start runs the hub and the background jobs; calling it again does nothing
+// The jobs stop when ctx is done, and the hub's and requests' leftover work
+// is cancelled; the hub itself runs until the process exits
+func (s *Server) Start(ctx context.Context) {
+	s.start.Do(func() { s.app.startBackground(ctx) })
+}
+
+// Reload re-reads the settings in RuntimeConfig from .env and the
+// environment, and the content filter wordlist, as the binary does on SIGHUP
+// Whatever fails to load keeps its current value, and the errors are returned
+func (s *Server) Reload() error {
+	_, err := s.app.reloadConfig()
+	if err != nil {
+		err = fmt.Errorf("failed to reload configuration (keeping the current one): %w", err)
+	}
+	return errors.Join(err, s.app.reloadWordlist())
+}
+
+// Handler returns the HTTP handler serving the API, the WebSocket endpoints
+// and the web client
+func (s *Server) Handler() http.Handler {
+	return s.app.mount()
+}
+
+// ListenAndServe serves Handler on the configured address (ADDR)
+// Starts the Server first if it wasn't started, for the life of the process
+func (s *Server) ListenAndServe() error {
+	s.Start(context.Background())
+	return s.app.run(s.Handler())
+}
+
+// startBackground starts the hub and every background job; the jobs stop when ctx is done
+func (app *application) startBackground(ctx context.Context) {
+	app.startHub(ctx)
+
+	// Periodically save who is connected where, for diagnosing crashes
+	// The snapshot left by the previous run is summarized before it's replaced
+	logPreviousSnapshot(app.hubSnapshotPath)
+	if app.hubSnapshotInterval > 0 {
+		go app.hub.WriteSnapshots(app.hubSnapshotPath, app.hubSnapshotInterval)
+	}
+
+	// Remove devices nobody has used in a long time
+	go app.runDevicePruner(ctx)
+
+	// Remove login sessions that were idle too long or have expired
+	go app.runSessionPurger(ctx)
+
+	// Remove password reset tokens that were used or have expired
+	go app.runPasswordResetPurger(ctx)
+
+	// Permanently remove rooms deleted longer ago than the restore window
+	go app.runRoomPurger(ctx)
+
+	// Drop room events older than the retention period
+	go app.runRoomEventPurger(ctx)
+
+	// Delete messages past their room's retention
+	go app.runMessagePurger(ctx)
+
+	// Send rooms' events to their outgoing webhooks
+	if app.outgoing != nil {
+		app.outgoing.Start(ctx)
+	}
+
+	// Finish image thumbnails that were still being made at the last shutdown
+	go app.resumeThumbnails()
+
+	// Email opted-in users a daily summary of what they missed
+	startDigests(ctx, app.store, app.notifications, app.mailer, app.config.mail.digestInterval)
+}
+
+// startHub runs the hub; its and requests' leftover work is cancelled when ctx is done
+// SelfTest needs it without the background jobs
+func (app *application) startHub(ctx context.Context) {
+	context.AfterFunc(ctx, app.cancel)
+	app.hub.SetContext(ctx)
+	go app.hub.Run() // Start hub in background goroutine
+	log.Println("WebSocket hub initialized and running")
+}
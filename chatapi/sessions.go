@@ -0,0 +1,202 @@
+package chatapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/auth"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+const (
+	// maxSessionUserAgentLength bounds the stored User-Agent header
+	maxSessionUserAgentLength = 512
+
+	// sessionTouchInterval is how often a session's last_active_at is updated
+	// Like API tokens, a busy client doesn't need a write for every request
+	sessionTouchInterval = time.Minute
+
+	// sessionPurgeInterval is how often idle and expired sessions are deleted
+	sessionPurgeInterval = time.Hour
+)
+
+// SessionsResponse lists the caller's active sessions
+type SessionsResponse struct {
+	Sessions []*store.Session `json:"sessions"`
+}
+
+// startSession records a new login session for the request's client and
+// returns a JWT bound to it
+// It writes the error response and returns false on failure, a 403 for a
+// banned user
+func (app *application) startSession(w http.ResponseWriter, r *http.Request, userID int64) (string, bool) {
+	// Every way of signing in ends here, so this stops them all
+	banned, err := app.store.Users.IsBanned(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return "", false
+	}
+	if banned {
+		writeError(w, r, http.StatusForbidden, "user_banned")
+		return "", false
+	}
+
+	// Read before the session is created, so a concurrent logout-all revokes it
+	tokenVersion, err := app.store.Users.GetTokenVersion(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return "", false
+	}
+
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxSessionUserAgentLength {
+		userAgent = userAgent[:maxSessionUserAgentLength]
+	}
+
+	session := &store.Session{
+		UserID:      userID,
+		IP:          clientIP(r),
+		UserAgent:   userAgent,
+		DeviceLabel: auth.DeviceLabel(userAgent),
+		ExpiresAt:   time.Now().Add(auth.TokenLifetime),
+	}
+	if err := app.store.Sessions.Create(r.Context(), session); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "session_create_failed")
+		return "", false
+	}
+
+	token, err := auth.GenerateToken(userID, session.ID, tokenVersion, session.ExpiresAt, app.config.auth.jwtSecret)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "token_generation_failed")
+		return "", false
+	}
+	return token, true
+}
+
+// checkSession finishes AuthMiddleware for a JWT bound to a login session
+// Revoked sessions are deleted, so their tokens fail on the very next request
+// Returns false after writing the error response
+func (app *application) checkSession(w http.ResponseWriter, r *http.Request, sessionID int64) bool {
+	session, err := app.store.Sessions.GetByID(r.Context(), sessionID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusUnauthorized, "session_revoked")
+			return false
+		}
+		writeError(w, r, http.StatusInternalServerError, "session_lookup_failed")
+		return false
+	}
+
+	now := time.Now()
+	if !session.Active(now, app.config.auth.sessionIdleTimeout) {
+		writeError(w, r, http.StatusUnauthorized, "session_expired")
+		return false
+	}
+
+	if now.Sub(session.LastActiveAt) >= sessionTouchInterval {
+		if err := app.store.Sessions.Touch(r.Context(), session.ID, clientIP(r)); err != nil {
+			// Not worth failing the request over
+			log.Printf("Failed to record use of session %d: %v", session.ID, err)
+		}
+	}
+	return true
+}
+
+// SessionIDFromContext returns the login session that authenticated the
+// request, or false for API tokens and tokens issued before sessions existed
+func SessionIDFromContext(ctx context.Context) (int64, bool) {
+	sessionID, ok := ctx.Value(sessionIDKey).(int64)
+	return sessionID, ok
+}
+
+// listSessionsHandler lists the current user's active login sessions
+// The session making the request is marked "current"
+// GET /v1/users/me/sessions
+// Requires authentication
+// Response: {"sessions": [{"id": 7, "ip": "203.0.113.9", "device_label": "Firefox on Windows", "current": true, ...}]}
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	idleCutoff := time.Now().Add(-app.config.auth.sessionIdleTimeout)
+	sessions, err := app.store.Sessions.ListActive(r.Context(), userID, idleCutoff)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "sessions_lookup_failed")
+		return
+	}
+
+	currentID, _ := SessionIDFromContext(r.Context())
+	for _, session := range sessions {
+		session.Current = session.ID == currentID
+	}
+
+	writeJSON(w, http.StatusOK, SessionsResponse{Sessions: sessions})
+}
+
+// revokeSessionHandler signs one of the current user's sessions out
+// Its token stops working on the next request and its WebSocket connections
+// are closed with code 4401. Revoking the current session logs the caller out
+// DELETE /v1/users/me/sessions/{sessionID}
+// Requires authentication
+// Response: 204 No Content
+func (app *application) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	sessionID, err := extractIDFromURL(r, "sessionID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "sessionID")
+		return
+	}
+
+	if err := app.store.Sessions.Revoke(r.Context(), sessionID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "session_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "session_revoke_failed")
+		return
+	}
+
+	app.hub.CloseSession(sessionID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runSessionPurger periodically deletes sessions that have been idle longer
+// than the idle timeout or whose token has expired
+// It runs until ctx is done and should be started in a goroutine
+func (app *application) runSessionPurger(ctx context.Context) {
+	ticker := time.NewTicker(sessionPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		callCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		removed, err := app.store.Sessions.PurgeInactive(callCtx, time.Now().Add(-app.config.auth.sessionIdleTimeout))
+		cancel()
+
+		if err != nil {
+			log.Printf("Failed to purge inactive sessions: %v", err)
+			continue
+		}
+		if removed > 0 {
+			log.Printf("Purged %d inactive sessions", removed)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package chatapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/auth"
+	"github.com/drazan344/go-chat/internal/store"
+	ws "github.com/drazan344/go-chat/internal/websocket"
+	"github.com/gorilla/websocket"
+)
+
+// firefoxOnWindows is a browser's User-Agent header
+const firefoxOnWindows = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:128.0) Gecko/20100101 Firefox/128.0"
+
+// addLoginUser adds ada with a password test logins can use
+func addLoginUser(t *testing.T, ts *testStore) {
+	t.Helper()
+	hash, err := auth.HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.users.add(&store.User{ID: 1, Username: "ada", Email: "ada@example.com", Password: hash})
+}
+
+// login logs ada in from a client with the given User-Agent and returns
+// the headers that authenticate as the new session
+func login(t *testing.T, serverURL, userAgent string) map[string]string {
+	t.Helper()
+	var resp AuthResponse
+	body := LoginRequest{Email: "ada@example.com", Password: "correct horse battery staple"}
+	if status := doJSONWithHeaders(t, http.MethodPost, serverURL+"/v1/auth/login", 0, map[string]string{"User-Agent": userAgent}, body, &resp); status != http.StatusOK {
+		t.Fatalf("logging in got %d, want 200", status)
+	}
+	return withToken(resp.Token)
+}
+
+// TestSessions logs ada in from a browser and from curl: each login is a
+// session with its own device label, and the list marks the one asking
+// Revoking the curl session from the browser makes its token fail and
+// closes its WebSocket with a session_revoked frame and code 4401; revoking
+// the browser's own session logs the browser out
+func TestSessions(t *testing.T) {
+	ts := newTestStore(t)
+	addLoginUser(t, ts)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	server := newTestServer(t, ts)
+	sessions := server.URL + "/v1/users/me/sessions"
+
+	browser := login(t, server.URL, firefoxOnWindows)
+	script := login(t, server.URL, "curl/8.5.0")
+
+	var listed SessionsResponse
+	if status := doJSONWithHeaders(t, http.MethodGet, sessions, 0, browser, nil, &listed); status != http.StatusOK || len(listed.Sessions) != 2 {
+		t.Fatalf("listing got %d with %d sessions, want 200 with 2", status, len(listed.Sessions))
+	}
+	// Most recently active first
+	for i, tc := range []struct {
+		label   string
+		current bool
+	}{{"curl", false}, {"Firefox on Windows", true}} {
+		if session := listed.Sessions[i]; session.DeviceLabel != tc.label || session.Current != tc.current || session.IP == "" {
+			t.Errorf("got session %+v, want %q with current %v", session, tc.label, tc.current)
+		}
+	}
+	scriptID := listed.Sessions[0].ID
+	browserID := listed.Sessions[1].ID
+
+	// The curl session's connection, authenticated with its token
+	url := fmt.Sprintf("ws%s/v1/rooms/1/ws", strings.TrimPrefix(server.URL, "http"))
+	header := http.Header{}
+	header.Set("Authorization", script["Authorization"])
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	readFrame(t, conn, "join")
+
+	// Someone else's session is as good as missing
+	var failure errorBody
+	if status := doJSON(t, http.MethodDelete, fmt.Sprintf("%s/%d", sessions, scriptID), 2, nil, &failure); status != http.StatusNotFound || failure.Code != "session_not_found" {
+		t.Errorf("grace revoking ada's session got %d %q, want 404 session_not_found", status, failure.Code)
+	}
+
+	if status := doJSONWithHeaders(t, http.MethodDelete, fmt.Sprintf("%s/%d", sessions, scriptID), 0, browser, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("revoking the curl session got %d, want 204", status)
+	}
+	readFrame(t, conn, "session_revoked")
+	var closeErr *websocket.CloseError
+	if _, _, err := conn.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != ws.CloseSessionRevoked {
+		t.Errorf("after revoking the connection got %v, want close code %d", err, ws.CloseSessionRevoked)
+	}
+	if status := doJSONWithHeaders(t, http.MethodGet, sessions, 0, script, nil, &failure); status != http.StatusUnauthorized || failure.Code != "session_revoked" {
+		t.Errorf("the revoked token got %d %q, want 401 session_revoked", status, failure.Code)
+	}
+
+	// Signing out the browser's own session logs the browser out
+	if status := doJSONWithHeaders(t, http.MethodDelete, fmt.Sprintf("%s/%d", sessions, browserID), 0, browser, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("revoking the current session got %d, want 204", status)
+	}
+	if status := doJSONWithHeaders(t, http.MethodGet, server.URL+"/v1/auth/me", 0, browser, nil, &failure); status != http.StatusUnauthorized || failure.Code != "session_revoked" {
+		t.Errorf("after signing itself out the browser got %d %q, want 401 session_revoked", status, failure.Code)
+	}
+}
+
+// TestSessionIdle expires a session unused for longer than the idle
+// timeout, records use of an active one at most once a minute, and still
+// accepts tokens issued before sessions existed
+func TestSessionIdle(t *testing.T) {
+	ts := newTestStore(t)
+	addLoginUser(t, ts)
+	server := newTestServer(t, ts)
+	me := server.URL + "/v1/auth/me"
+
+	headers := login(t, server.URL, firefoxOnWindows)
+	ctx := context.Background()
+	setLastActive := func(ago time.Duration) {
+		ts.sessions.mu.Lock()
+		defer ts.sessions.mu.Unlock()
+		ts.sessions.sessions[1].LastActiveAt = time.Now().Add(-ago)
+	}
+
+	// Used seconds ago: no write
+	if status := doJSONWithHeaders(t, http.MethodGet, me, 0, headers, nil, nil); status != http.StatusOK {
+		t.Fatalf("using the session got %d, want 200", status)
+	}
+	if ts.sessions.touches != 0 {
+		t.Errorf("a session used a moment ago was touched %d times", ts.sessions.touches)
+	}
+
+	// Used two minutes ago: recorded
+	setLastActive(2 * time.Minute)
+	if status := doJSONWithHeaders(t, http.MethodGet, me, 0, headers, nil, nil); status != http.StatusOK {
+		t.Fatalf("using the session got %d, want 200", status)
+	}
+	if ts.sessions.touches != 1 {
+		t.Errorf("a session used two minutes ago was touched %d times, want 1", ts.sessions.touches)
+	}
+
+	// Idle past the timeout
+	setLastActive(2 * time.Hour)
+	var failure errorBody
+	if status := doJSONWithHeaders(t, http.MethodGet, me, 0, headers, nil, &failure); status != http.StatusUnauthorized || failure.Code != "session_expired" {
+		t.Errorf("the idle session got %d %q, want 401 session_expired", status, failure.Code)
+	}
+	if listed, _ := ts.sessions.ListActive(ctx, 1, time.Now().Add(-time.Hour)); len(listed) != 0 {
+		t.Errorf("the idle session is still listed: %+v", listed[0])
+	}
+
+	if status := doJSON(t, http.MethodGet, me, 1, nil, nil); status != http.StatusOK {
+		t.Errorf("a token without a session got %d, want 200", status)
+	}
+}
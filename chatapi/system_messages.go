@@ -0,0 +1,59 @@
+package chatapi
+
+import (
+	"context"
+
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/sysmsg"
+	"github.com/drazan344/go-chat/internal/websocket"
+)
+
+// lastMessagePreviewLength matches the previews the store cuts from content
+const lastMessagePreviewLength = 80
+
+// postSystemMessage saves a message from the system user (store.SystemUserID)
+// and delivers it to the room's connected clients
+// Every message the server itself posts goes through here, so history
+// attributes them all to the same user and clients can style them by the
+// "system" flag. The text is the server's own, so quiet hours, the duplicate
+// limit and the content filter don't apply
+// The message is saved as its event and rendered in the room's language for
+// the broadcast (and the returned message), as it is whenever it's read
+// txn, if set, ties the broadcast to the other frames of the action that
+// posted it (wire.Message.Txn); it isn't saved
+func (app *application) postSystemMessage(ctx context.Context, roomID int64, event store.SystemEvent, txn string) (*store.Message, error) {
+	message := &store.Message{
+		RoomID:      roomID,
+		UserID:      store.SystemUserID,
+		Username:    app.config.systemUsername,
+		ContentType: content.TypeText,
+		SystemEvent: event,
+	}
+	if err := app.store.Messages.Create(ctx, message); err != nil {
+		return nil, err
+	}
+	message.Content = sysmsg.Render(event, app.hub.RoomLanguage(ctx, roomID))
+
+	frame := websocket.NewChatMessage(message)
+	frame.Txn = txn
+	app.hub.InjectMessage(frame)
+	return message, nil
+}
+
+// roomLanguage returns the language a loaded room's system messages are shown in
+func (app *application) roomLanguage(room *store.Room) string {
+	if room.Language != "" {
+		return room.Language
+	}
+	return app.config.rooms.defaultLanguage
+}
+
+// systemMessagePreview renders a system message for a room list's last message preview
+func systemMessagePreview(event store.SystemEvent, language string) string {
+	text := []rune(sysmsg.Render(event, language))
+	if len(text) > lastMessagePreviewLength {
+		text = text[:lastMessagePreviewLength]
+	}
+	return string(text)
+}
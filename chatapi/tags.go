@@ -0,0 +1,59 @@
+package chatapi
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// validateRoomTags normalizes the tags from a create or update request
+// Duplicates are dropped and the result is sorted, matching how rooms return them
+// Problems are reported per field: "tags" for too many, "tags[i]" for a malformed tag
+// On failure it writes a 400 and returns false
+func validateRoomTags(w http.ResponseWriter, r *http.Request, raw []string) ([]string, bool) {
+	locale := resolveLocale(r)
+	fields := make(map[string][]fieldError)
+
+	seen := make(map[string]bool, len(raw))
+	tags := make([]string, 0, len(raw))
+	for i, t := range raw {
+		tag, ok := store.NormalizeTag(t)
+		if !ok {
+			field := "tags[" + strconv.Itoa(i) + "]"
+			fields[field] = append(fields[field], fieldError{Error: translate(locale, "invalid_room_tag", t), Code: "invalid_room_tag"})
+			continue
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > store.MaxRoomTags {
+		fields["tags"] = append(fields["tags"], fieldError{Error: translate(locale, "too_many_room_tags", store.MaxRoomTags), Code: "too_many_room_tags"})
+	}
+
+	if len(fields) > 0 {
+		writeFieldErrors(w, r, http.StatusBadRequest, "invalid_room_tags", fields)
+		return nil, false
+	}
+
+	sort.Strings(tags)
+	return tags, true
+}
+
+// listTagsHandler returns every room tag with how many rooms use it
+// Deleted and invite-only rooms aren't counted
+// GET /v1/tags
+// Requires authentication
+// Response: [{"tag": "gaming", "rooms": 12}, {"tag": "go", "rooms": 3}]
+func (app *application) listTagsHandler(w http.ResponseWriter, r *http.Request) {
+	tags, err := app.store.Rooms.ListTags(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "tags_lookup_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tags)
+}
@@ -0,0 +1,104 @@
+package chatapi
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestRoomTags creates and retags rooms: tags come back normalized, sorted
+// and without duplicates, and rooms can be listed by tag
+func TestRoomTags(t *testing.T) {
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	server := newTestServer(t, ts)
+
+	var golang store.Room
+	body := CreateRoomRequest{Name: "golang", Tags: []string{" Go ", "chat", "go"}}
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms", 1, body, &golang); status != http.StatusCreated {
+		t.Fatalf("creating a room got %d, want 201", status)
+	}
+	if !slices.Equal(golang.Tags, []string{"chat", "go"}) {
+		t.Errorf("the room was created with tags %v, want [chat go]", golang.Tags)
+	}
+	doJSON(t, http.MethodPost, server.URL+"/v1/rooms", 1, CreateRoomRequest{Name: "games", Tags: []string{"gaming"}}, nil)
+
+	var updated store.Room
+	tags := []string{"gaming", "go"}
+	if status := doJSON(t, http.MethodPatch, fmt.Sprintf("%s/v1/rooms/%d", server.URL, golang.ID), 1, RoomSettings{Tags: &tags}, &updated); status != http.StatusOK {
+		t.Fatalf("retagging got %d, want 200", status)
+	}
+	if !slices.Equal(updated.Tags, tags) {
+		t.Errorf("the room was retagged %v, want %v", updated.Tags, tags)
+	}
+
+	var listed []store.Room
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/rooms?tag=GO", 1, nil, &listed); status != http.StatusOK {
+		t.Fatalf("listing by tag got %d, want 200", status)
+	}
+	if len(listed) != 1 || listed[0].ID != golang.ID {
+		t.Errorf("listing by go found %v, want only golang", listed)
+	}
+	var failure errorBody
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/rooms?tag=a", 1, nil, &failure); status != http.StatusBadRequest || failure.Code != "invalid_room_tag" {
+		t.Errorf("listing by a malformed tag got %d %q, want 400 invalid_room_tag", status, failure.Code)
+	}
+}
+
+// TestRoomTagsValidation reports each bad tag under its own field, and too
+// many tags under "tags"
+func TestRoomTagsValidation(t *testing.T) {
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	server := newTestServer(t, ts)
+
+	for _, tc := range []struct {
+		name  string
+		tags  []string
+		field string
+		code  string
+	}{
+		{"too short", []string{"go", "x"}, "tags[1]", "invalid_room_tag"},
+		{"not alphanumeric", []string{"c++"}, "tags[0]", "invalid_room_tag"},
+		{"too long", []string{"a123456789b123456789c123456789d"}, "tags[0]", "invalid_room_tag"},
+		{"too many", []string{"aa", "bb", "cc", "dd", "ee", "ff"}, "tags", "too_many_room_tags"},
+	} {
+		var failure struct {
+			Code   string                 `json:"code"`
+			Fields map[string][]errorBody `json:"fields"`
+		}
+		status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms", 1, CreateRoomRequest{Name: "room", Tags: tc.tags}, &failure)
+		if status != http.StatusBadRequest || failure.Code != "invalid_room_tags" {
+			t.Errorf("%s: got %d %q, want 400 invalid_room_tags", tc.name, status, failure.Code)
+			continue
+		}
+		if got := failure.Fields[tc.field]; len(got) != 1 || got[0].Code != tc.code {
+			t.Errorf("%s: %s has %v, want %s", tc.name, tc.field, failure.Fields, tc.code)
+		}
+	}
+	// Six spellings of five tags are fine
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms", 1, CreateRoomRequest{Name: "room", Tags: []string{"aa", "AA", "bb", "cc", "dd", "ee"}}, nil); status != http.StatusCreated {
+		t.Errorf("five distinct tags got %d, want 201", status)
+	}
+}
+
+// TestListTags counts only rooms anyone can find
+func TestListTags(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "golang", JoinPolicy: store.JoinPolicyOpen, Tags: []string{"chat", "go"}})
+	ts.rooms.add(&store.Room{ID: 2, Name: "gophers", JoinPolicy: store.JoinPolicyApproval, Tags: []string{"go"}})
+	ts.rooms.add(&store.Room{ID: 3, Name: "secret", JoinPolicy: store.JoinPolicyInvite, Tags: []string{"go"}})
+	server := newTestServer(t, ts)
+
+	var tags []store.TagCount
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/tags", 1, nil, &tags); status != http.StatusOK {
+		t.Fatalf("listing tags got %d, want 200", status)
+	}
+	want := []store.TagCount{{Tag: "go", Rooms: 2}, {Tag: "chat", Rooms: 1}}
+	if !slices.Equal(tags, want) {
+		t.Errorf("got %v, want %v", tags, want)
+	}
+}
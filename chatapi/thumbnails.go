@@ -0,0 +1,172 @@
+package chatapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/blob"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/websocket"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+const (
+	// thumbnailMaxEdge is the longest side of a thumbnail, in pixels
+	thumbnailMaxEdge = 320
+
+	// thumbnailWorkers is how many thumbnails are made at once
+	// Each one decodes a whole image into memory
+	thumbnailWorkers = 2
+
+	// thumbnailStoreTimeout bounds recording a finished thumbnail
+	thumbnailStoreTimeout = 10 * time.Second
+)
+
+// readImageSize fills in an image upload's dimensions from the staged file's
+// header and marks its thumbnail pending
+// Anything that can't be read as an image is left as a plain file
+func readImageSize(staged *blob.Staged, attachment *store.Attachment) {
+	if !blob.IsImage(staged.ContentType) {
+		return
+	}
+	file, err := staged.Open()
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	width, height, err := blob.ImageSize(file)
+	if err != nil || width <= 0 || height <= 0 {
+		return
+	}
+	attachment.Width = width
+	attachment.Height = height
+	attachment.ThumbnailPending = true
+}
+
+// setThumbnailURL points an attachment at its thumbnail, if it has one
+func (app *application) setThumbnailURL(attachment *store.Attachment) {
+	if attachment.HasThumbnail {
+		attachment.ThumbnailURL = app.basePath + fmt.Sprintf("/v1/attachments/%d/thumbnail", attachment.ID)
+	}
+}
+
+// makeThumbnail makes and stores the thumbnail of a blob, then tells every
+// uploader of it with an "attachment_thumbnail" frame carrying their upload
+// (with "thumbnail_url", or without one if the image couldn't be decoded)
+// This should be called in a goroutine: go app.makeThumbnail(id, hash)
+func (app *application) makeThumbnail(attachmentID int64, hash string) {
+	app.thumbnailSlots <- struct{}{}
+	made := app.writeThumbnail(hash)
+	<-app.thumbnailSlots
+
+	ctx, cancel := context.WithTimeout(app.ctx, thumbnailStoreTimeout)
+	defer cancel()
+
+	// A blob deleted meanwhile has no uploads left to tell; its thumbnail file
+	// stays behind until the same bytes are uploaded again and replace it
+	uploads, err := app.store.Attachments.FinishThumbnail(ctx, attachmentID, made)
+	if err != nil {
+		log.Printf("Failed to record thumbnail of attachment %d: %v", attachmentID, err)
+		return
+	}
+	for _, upload := range uploads {
+		app.setThumbnailURL(upload)
+		app.hub.SendToUser(upload.UserID, &websocket.Message{
+			Message: wire.Message{
+				UserID:     upload.UserID,
+				Type:       "attachment_thumbnail",
+				Attachment: upload.Wire(),
+			},
+		})
+	}
+}
+
+// writeThumbnail makes the thumbnail of a blob and stores it next to the blob
+// Returns false if the image can't be decoded; that's not worth more than a log line
+func (app *application) writeThumbnail(hash string) bool {
+	file, err := app.blobs.Open(hash)
+	if err != nil {
+		log.Printf("Failed to open blob %s for its thumbnail: %v", hash, err)
+		return false
+	}
+	defer file.Close()
+
+	thumbnail, err := blob.Thumbnail(file, thumbnailMaxEdge)
+	if err != nil {
+		log.Printf("No thumbnail for blob %s: %v", hash, err)
+		return false
+	}
+	if err := app.blobs.PutThumbnail(hash, thumbnail); err != nil {
+		log.Printf("Failed to store thumbnail of blob %s: %v", hash, err)
+		return false
+	}
+	return true
+}
+
+// resumeThumbnails makes the thumbnails a restart left pending
+// This should be called in a goroutine: go app.resumeThumbnails()
+func (app *application) resumeThumbnails() {
+	ctx, cancel := context.WithTimeout(app.ctx, thumbnailStoreTimeout)
+	pending, err := app.store.Attachments.PendingThumbnails(ctx)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to list pending thumbnails: %v", err)
+		return
+	}
+	if len(pending) > 0 {
+		log.Printf("Making %d thumbnails left pending", len(pending))
+	}
+	for attachmentID, hash := range pending {
+		app.makeThumbnail(attachmentID, hash)
+	}
+}
+
+// getAttachmentThumbnailHandler downloads the thumbnail of one of the user's image uploads
+// GET /v1/attachments/{attachmentID}/thumbnail
+// Requires authentication; owner only, like the file itself
+// Response: a JPEG of at most 320 pixels on its long edge
+// 404 while the thumbnail is still being made, and for files without one
+func (app *application) getAttachmentThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	attachmentID, err := extractIDFromURL(r, "attachmentID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "attachmentID")
+		return
+	}
+
+	attachment, err := app.store.Attachments.GetByID(r.Context(), attachmentID, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "attachment_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "attachment_lookup_failed")
+		return
+	}
+	if !attachment.HasThumbnail {
+		writeError(w, r, http.StatusNotFound, "thumbnail_not_found")
+		return
+	}
+
+	file, err := app.blobs.OpenThumbnail(attachment.Hash)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "attachment_lookup_failed")
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", attachment.CreatedAt, file)
+}
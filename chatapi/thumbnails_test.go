@@ -0,0 +1,104 @@
+package chatapi
+
+import (
+	"bytes"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+)
+
+// TestImageUploadThumbnail uploads images and checks the response carries
+// their dimensions, the thumbnail is made in the background, announced to
+// the uploader with an attachment_thumbnail frame and served to them only,
+// a second upload of the same bytes gets it at once, and images that can't
+// be decoded get none
+func TestImageUploadThumbnail(t *testing.T) {
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 2})
+	ts.roomMembers.add(1, 2, store.RoomRoleAdmin)
+	server := newTestServer(t, ts, withAttachments(t, t.TempDir(), 1<<20))
+	conn := dialRoom(t, server, 1, 2)
+
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewGray(image.Rect(0, 0, 1000, 500))); err != nil {
+		t.Fatal(err)
+	}
+	status, first := upload(t, server, 2, "photo.png", img.String())
+	if status != http.StatusCreated || first.Width != 1000 || first.Height != 500 || !first.ThumbnailPending || first.ThumbnailURL != "" {
+		t.Errorf("the upload got %d, %dx%d, pending %t, thumbnail %q; want 201, 1000x500, pending, no thumbnail yet",
+			status, first.Width, first.Height, first.ThumbnailPending, first.ThumbnailURL)
+	}
+	frame := readFrame(t, conn, "attachment_thumbnail")
+	if a := frame.Attachment; a == nil || a.ID != first.ID || a.ThumbnailPending || a.ThumbnailURL != "/v1/attachments/1/thumbnail" {
+		t.Fatalf("the frame carried %+v, want upload 1 with its thumbnail", a)
+	}
+
+	thumbnailURL := server.URL + "/v1/attachments/1/thumbnail"
+	status, contentType, body := download(t, thumbnailURL, 2)
+	if status != http.StatusOK || contentType != "image/jpeg" {
+		t.Fatalf("the thumbnail answered %d %s, want 200 image/jpeg", status, contentType)
+	}
+	thumbnail, format, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil || format != "jpeg" || thumbnail.Width != 320 || thumbnail.Height != 160 {
+		t.Errorf("the thumbnail is a %dx%d %s (%v), want a 320x160 jpeg", thumbnail.Width, thumbnail.Height, format, err)
+	}
+	if status, _, _ := download(t, thumbnailURL, 3); status != http.StatusNotFound {
+		t.Errorf("another user got %d for the thumbnail, want 404", status)
+	}
+
+	// The same bytes again: the blob and its thumbnail are shared
+	_, second := upload(t, server, 3, "copy.png", img.String())
+	if !second.Deduplicated || second.ThumbnailPending || second.ThumbnailURL != "/v1/attachments/2/thumbnail" {
+		t.Errorf("the second upload is deduplicated %t, pending %t, thumbnail %q; want a shared, finished thumbnail",
+			second.Deduplicated, second.ThumbnailPending, second.ThumbnailURL)
+	}
+
+	// A valid header with nothing after it: dimensions, but no thumbnail
+	_, broken := upload(t, server, 2, "broken.png", img.String()[:64])
+	if broken.Width != 1000 || !broken.ThumbnailPending {
+		t.Errorf("the broken image is %dx%d, pending %t; want 1000x500, pending", broken.Width, broken.Height, broken.ThumbnailPending)
+	}
+	if frame := readFrame(t, conn, "attachment_thumbnail"); frame.Attachment == nil || frame.Attachment.ID != broken.ID || frame.Attachment.ThumbnailURL != "" {
+		t.Errorf("the frame carried %+v, want the broken upload without a thumbnail", frame.Attachment)
+	}
+	if !testutil.WaitFor(5*time.Second, func() bool { return !ts.attachments.pending(broken.ID) }) {
+		t.Fatal("the broken image's thumbnail never finished")
+	}
+	var failure errorBody
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/attachments/3/thumbnail", 2, nil, &failure); status != http.StatusNotFound || failure.Code != "thumbnail_not_found" {
+		t.Errorf("the broken image's thumbnail got %d %q, want 404 thumbnail_not_found", status, failure.Code)
+	}
+
+	// Not an image at all
+	_, text := upload(t, server, 2, "notes.txt", "just some notes")
+	if text.Width != 0 || text.ThumbnailPending {
+		t.Errorf("a text file got %dx%d, pending %t", text.Width, text.Height, text.ThumbnailPending)
+	}
+}
+
+// download GETs url as userID and returns the status, content type and body
+func download(t *testing.T, url string, userID int64) (int, string, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(asUser(t, req, userID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, resp.Header.Get("Content-Type"), body
+}
@@ -0,0 +1,40 @@
+package chatapi
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestLogoutAll signs ada out everywhere from one browser: both of their
+// sessions and a token issued before token versions existed are revoked,
+// and logging in again works
+func TestLogoutAll(t *testing.T) {
+	ts := newTestStore(t)
+	addLoginUser(t, ts)
+	server := newTestServer(t, ts)
+	browser := login(t, server.URL, firefoxOnWindows)
+	phone := login(t, server.URL, "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Safari/604.1")
+	me := server.URL + "/v1/auth/me"
+
+	if status := doJSON(t, http.MethodGet, me, 1, nil, nil); status != http.StatusOK {
+		t.Fatalf("the legacy token got %d before signing out, want 200", status)
+	}
+	if status := doJSONWithHeaders(t, http.MethodPost, server.URL+"/v1/auth/logout-all", 0, browser, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("signing out everywhere got %d, want 204", status)
+	}
+
+	var failure errorBody
+	for name, headers := range map[string]map[string]string{"browser": browser, "phone": phone} {
+		if status := doJSONWithHeaders(t, http.MethodGet, me, 0, headers, nil, &failure); status != http.StatusUnauthorized || failure.Code != "session_revoked" {
+			t.Errorf("the %s got %d %q, want 401 session_revoked", name, status, failure.Code)
+		}
+	}
+	if status := doJSON(t, http.MethodGet, me, 1, nil, &failure); status != http.StatusUnauthorized || failure.Code != "token_revoked" {
+		t.Errorf("the legacy token got %d %q, want 401 token_revoked", status, failure.Code)
+	}
+
+	again := login(t, server.URL, firefoxOnWindows)
+	if status := doJSONWithHeaders(t, http.MethodGet, me, 0, again, nil, nil); status != http.StatusOK {
+		t.Errorf("a new login got %d, want 200", status)
+	}
+}
@@ -0,0 +1,185 @@
+package chatapi
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/translation"
+)
+
+// translationRetryAfter is the Retry-After sent when the translation backend is down, in seconds
+const translationRetryAfter = 30
+
+// TranslationResponse is the result of translating one message
+type TranslationResponse struct {
+	MessageID  int64  `json:"message_id"`
+	TargetLang string `json:"target_lang"`
+	SourceLang string `json:"source_lang"`
+	Text       string `json:"text"`
+	Cached     bool   `json:"cached"` // Served from the cache without asking the backend
+}
+
+// newTranslator creates the configured translation backend
+// Returns nil when translation is turned off
+func newTranslator(cfg translateConfig) (translation.Translator, error) {
+	switch cfg.provider {
+	case "":
+		return nil, nil
+	case "dictionary":
+		return translation.NewDemoDictionary(), nil
+	case "libretranslate":
+		if cfg.url == "" {
+			return nil, errors.New("TRANSLATE_URL is required for libretranslate")
+		}
+		return translation.NewLibreTranslate(cfg.url, cfg.apiKey, cfg.timeout), nil
+	}
+	return nil, fmt.Errorf("unknown translation provider %q", cfg.provider)
+}
+
+// translateMessageHandler translates a message into another language
+// Translations are cached per message and language; a cached translation of
+// content that has since changed is discarded and made again
+// GET /v1/messages/{messageID}/translate?target=de
+// Requires authentication and membership of the message's room
+// Response: {"message_id": 42, "target_lang": "de", "source_lang": "en", "text": "...", "cached": false}
+// Unsupported target: 400 with {"supported": ["de", "es", ...]}
+// Backend down or too slow: 503 with Retry-After
+func (app *application) translateMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if app.translator == nil {
+		writeError(w, r, http.StatusNotFound, "translation_disabled")
+		return
+	}
+
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	messageID, err := extractIDFromURL(r, "messageID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "messageID")
+		return
+	}
+
+	target := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("target")))
+	if target == "" {
+		writeError(w, r, http.StatusBadRequest, "translation_target_required")
+		return
+	}
+
+	message, err := app.store.Messages.GetByID(r.Context(), messageID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "message_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "message_lookup_failed")
+		return
+	}
+
+	// Non-members get the same 404 as a missing message, so IDs can't be probed
+	isMember, err := app.store.RoomMembers.IsUserInRoom(r.Context(), message.RoomID, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "membership_check_failed")
+		return
+	}
+	if !isMember {
+		writeError(w, r, http.StatusNotFound, "message_not_found")
+		return
+	}
+
+	// A system message's text is the room's language rendering of its event
+	app.hub.RenderSystemMessages(r.Context(), []*store.Message{message})
+
+	sourceHash := store.TranslationSourceHash(message.Content)
+	cached, err := app.store.Translations.Get(r.Context(), messageID, target, sourceHash)
+	if err == nil {
+		writeJSON(w, http.StatusOK, TranslationResponse{
+			MessageID:  messageID,
+			TargetLang: target,
+			SourceLang: cached.SourceLang,
+			Text:       cached.Text,
+			Cached:     true,
+		})
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusInternalServerError, "translation_lookup_failed")
+		return
+	}
+
+	// Check the language before calling the backend, so clients get the list of options
+	supported, err := app.translator.Languages(r.Context())
+	if err != nil {
+		app.translationUnavailable(w, r, err)
+		return
+	}
+	if !slices.Contains(supported, target) {
+		writeUnsupportedLanguage(w, r, supported)
+		return
+	}
+
+	text, sourceLang, err := app.translator.Translate(r.Context(), message.Content, target)
+	if err != nil {
+		if errors.Is(err, translation.ErrUnsupportedLanguage) {
+			writeUnsupportedLanguage(w, r, supported)
+			return
+		}
+		app.translationUnavailable(w, r, err)
+		return
+	}
+
+	cache := &store.MessageTranslation{
+		MessageID:  messageID,
+		TargetLang: target,
+		SourceLang: sourceLang,
+		SourceHash: sourceHash,
+		Text:       text,
+	}
+	if err := app.store.Translations.Save(r.Context(), cache); err != nil {
+		// The translation is still good; it just isn't cached for next time
+		log.Printf("Failed to cache translation of message %d: %v", messageID, err)
+	}
+
+	writeJSON(w, http.StatusOK, TranslationResponse{
+		MessageID:  messageID,
+		TargetLang: target,
+		SourceLang: sourceLang,
+		Text:       text,
+	})
+}
+
+// translationUnavailable reports a backend failure
+// Outages and timeouts are worth retrying (503 with Retry-After); anything else is a 502
+func (app *application) translationUnavailable(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("Translation failed: %v", err)
+	if errors.Is(err, translation.ErrUnavailable) {
+		w.Header().Set("Retry-After", strconv.Itoa(translationRetryAfter))
+		writeError(w, r, http.StatusServiceUnavailable, "translation_unavailable")
+		return
+	}
+	writeError(w, r, http.StatusBadGateway, "translation_failed")
+}
+
+// writeUnsupportedLanguage writes a 400 listing the languages the backend supports
+// Response: {"error": "...", "code": "unsupported_translation_language", "supported": ["de", "es"]}
+func writeUnsupportedLanguage(w http.ResponseWriter, r *http.Request, supported []string) {
+	type errorResponse struct {
+		Error     string   `json:"error"`
+		Code      string   `json:"code"`
+		Supported []string `json:"supported"`
+	}
+
+	const code = "unsupported_translation_language"
+	locale := resolveLocale(r)
+	w.Header().Set("Content-Language", locale)
+	writeJSON(w, http.StatusBadRequest, errorResponse{Error: translate(locale, code), Code: code, Supported: supported})
+}
@@ -0,0 +1,143 @@
+package chatapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/translation"
+)
+
+// stubTranslator is the demo dictionary, counting calls to Translate and
+// failing them with err if it's set
+type stubTranslator struct {
+	*translation.Dictionary
+	mu    sync.Mutex
+	calls int
+	err   error
+}
+
+func (s *stubTranslator) Translate(ctx context.Context, text, targetLang string) (string, string, error) {
+	s.mu.Lock()
+	s.calls++
+	err := s.err
+	s.mu.Unlock()
+	if err != nil {
+		return "", "", err
+	}
+	return s.Dictionary.Translate(ctx, text, targetLang)
+}
+
+// addTranslateMessage puts ada (1) in room 1, with ada's "good morning" as
+// message 1
+func addTranslateMessage(ts *testStore) {
+	ts.rooms.add(&store.Room{ID: 1, Name: "lobby", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.messages.Create(context.Background(), &store.Message{RoomID: 1, UserID: 1, Content: "good morning"})
+}
+
+// withTranslator translates messages with translator
+func withTranslator(translator translation.Translator) testOption {
+	return func(app *application) {
+		app.translator = translator
+	}
+}
+
+// TestTranslateMessage translates a message twice, the second time from
+// the cache, then again once the message has been edited
+func TestTranslateMessage(t *testing.T) {
+	ts := newTestStore(t)
+	translator := &stubTranslator{Dictionary: translation.NewDemoDictionary()}
+	addTranslateMessage(ts)
+	server := newTestServer(t, ts, withTranslator(translator))
+	url := server.URL + "/v1/messages/1/translate?target=DE"
+
+	for _, tc := range []struct {
+		name   string
+		edit   string
+		want   TranslationResponse
+		called int
+	}{
+		{"first time", "", TranslationResponse{MessageID: 1, TargetLang: "de", SourceLang: "en", Text: "gut Morgen"}, 1},
+		{"cached", "", TranslationResponse{MessageID: 1, TargetLang: "de", SourceLang: "en", Text: "gut Morgen", Cached: true}, 1},
+		{"after an edit", "good night", TranslationResponse{MessageID: 1, TargetLang: "de", SourceLang: "en", Text: "gut Nacht"}, 2},
+	} {
+		if tc.edit != "" {
+			ts.messages.edit(1, tc.edit)
+		}
+		var got TranslationResponse
+		if status := doJSON(t, http.MethodGet, url, 1, nil, &got); status != http.StatusOK {
+			t.Fatalf("%s: got %d, want 200", tc.name, status)
+		}
+		if got != tc.want || translator.calls != tc.called {
+			t.Errorf("%s: got %+v after %d calls, want %+v after %d", tc.name, got, translator.calls, tc.want, tc.called)
+		}
+	}
+}
+
+// TestTranslateMessageErrors checks who may translate and how backend
+// failures are reported
+func TestTranslateMessageErrors(t *testing.T) {
+	ts := newTestStore(t)
+	translator := &stubTranslator{Dictionary: translation.NewDemoDictionary()}
+	addTranslateMessage(ts)
+	server := newTestServer(t, ts, withTranslator(translator))
+
+	for _, tc := range []struct {
+		name   string
+		path   string
+		userID int64
+		err    error
+		want   int
+		code   string
+	}{
+		{"no target", "/v1/messages/1/translate", 1, nil, http.StatusBadRequest, "translation_target_required"},
+		{"missing message", "/v1/messages/9/translate?target=de", 1, nil, http.StatusNotFound, "message_not_found"},
+		{"not a member", "/v1/messages/1/translate?target=de", 2, nil, http.StatusNotFound, "message_not_found"},
+		{"backend down", "/v1/messages/1/translate?target=de", 1, fmt.Errorf("timeout: %w", translation.ErrUnavailable), http.StatusServiceUnavailable, "translation_unavailable"},
+		{"backend error", "/v1/messages/1/translate?target=de", 1, errors.New("bad response"), http.StatusBadGateway, "translation_failed"},
+	} {
+		translator.mu.Lock()
+		translator.err = tc.err
+		translator.mu.Unlock()
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL+tc.path, nil)
+		resp, err := http.DefaultClient.Do(asUser(t, req, tc.userID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var failure errorBody
+		json.NewDecoder(resp.Body).Decode(&failure)
+		resp.Body.Close()
+		if resp.StatusCode != tc.want || failure.Code != tc.code {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, resp.StatusCode, failure.Code, tc.want, tc.code)
+		}
+		if retry := resp.Header.Get("Retry-After"); (tc.want == http.StatusServiceUnavailable) != (retry == "30") {
+			t.Errorf("%s: Retry-After is %q", tc.name, retry)
+		}
+	}
+
+	var unsupported struct {
+		Code      string   `json:"code"`
+		Supported []string `json:"supported"`
+	}
+	status := doJSON(t, http.MethodGet, server.URL+"/v1/messages/1/translate?target=fr", 1, nil, &unsupported)
+	if status != http.StatusBadRequest || unsupported.Code != "unsupported_translation_language" || !slices.Equal(unsupported.Supported, []string{"de", "es"}) {
+		t.Errorf("an unsupported target got %d %+v, want 400 listing de and es", status, unsupported)
+	}
+}
+
+// TestTranslationDisabled answers 404 when no backend is configured
+func TestTranslationDisabled(t *testing.T) {
+	server := newTestServer(t, newTestStore(t))
+	var failure errorBody
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/messages/1/translate?target=de", 1, nil, &failure); status != http.StatusNotFound || failure.Code != "translation_disabled" {
+		t.Errorf("got %d %q, want 404 translation_disabled", status, failure.Code)
+	}
+}
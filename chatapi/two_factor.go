@@ -0,0 +1,358 @@
+package chatapi
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/auth"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+const (
+	// Code attempts allowed per user per window, across login, enable and disable
+	// A 6 digit code with ±1 step has 3 chances in a million per guess, so
+	// this keeps guessing hopeless without locking out someone who mistypes
+	twoFactorAttempts      = 5
+	twoFactorAttemptWindow = time.Minute
+
+	// twoFactorIssuer names the account in authenticator apps
+	twoFactorIssuer = "go-chat"
+)
+
+// TwoFactorCodeRequest carries a code from the user's authenticator app, or
+// one of their recovery codes where those are accepted
+type TwoFactorCodeRequest struct {
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// TwoFactorVerifyRequest finishes a two-step login
+type TwoFactorVerifyRequest struct {
+	TwoFactorToken string `json:"two_factor_token"`
+	TwoFactorCodeRequest
+}
+
+// TwoFactorChallengeResponse is the answer to a correct password when the
+// account has 2FA on: no access yet, just a token to send with the code
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool      `json:"two_factor_required"`
+	TwoFactorToken    string    `json:"two_factor_token"`
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+// TwoFactorSetupResponse is a new TOTP secret and its recovery codes
+// Shown once; the secret is only stored encrypted and the codes only hashed
+type TwoFactorSetupResponse struct {
+	Secret        string   `json:"secret"`      // Base32, for typing into an app
+	OTPAuthURI    string   `json:"otpauth_uri"` // For a QR code
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// requireSessionForTwoFactor refuses 2FA changes made with a personal access
+// token or an API key
+// Like creating tokens, changing how the account signs in needs a real login
+// It writes the error response itself and returns false if the check fails
+func (app *application) requireSessionForTwoFactor(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return 0, false
+	}
+	if !viaLoginSession(r.Context()) {
+		writeError(w, r, http.StatusForbidden, "api_token_session_required")
+		return 0, false
+	}
+	return userID, true
+}
+
+// checkTwoFactorCode checks a TOTP code, or a recovery code if allowed, for a user
+// Attempts are rate limited per user. A TOTP code is accepted once: its time
+// step is recorded, and the same or an earlier step is refused afterwards.
+// A recovery code is spent when accepted
+// It writes the error response itself (failStatus for a wrong code) and
+// returns false if the code isn't accepted
+func (app *application) checkTwoFactorCode(w http.ResponseWriter, r *http.Request, tf *store.TwoFactor, req TwoFactorCodeRequest, allowRecovery bool, failStatus int) bool {
+	if req.Code == "" && (req.RecoveryCode == "" || !allowRecovery) {
+		writeError(w, r, http.StatusBadRequest, "two_factor_code_required")
+		return false
+	}
+
+	ok, retryAfter := app.twoFactorLimiter.allow(tf.UserID)
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeError(w, r, http.StatusTooManyRequests, "two_factor_rate_limited")
+		return false
+	}
+
+	if req.Code == "" {
+		used, err := app.store.TwoFactor.UseRecoveryCode(r.Context(), tf.UserID, auth.HashRecoveryCode(req.RecoveryCode))
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "two_factor_failed")
+			return false
+		}
+		if !used {
+			writeError(w, r, failStatus, "invalid_two_factor_code")
+			return false
+		}
+		log.Printf("User %d used a 2FA recovery code", tf.UserID)
+		return true
+	}
+
+	secret, err := app.secrets.Open(tf.Secret)
+	if err != nil {
+		log.Printf("Failed to decrypt TOTP secret of user %d: %v", tf.UserID, err)
+		writeError(w, r, http.StatusInternalServerError, "two_factor_failed")
+		return false
+	}
+
+	step, ok := auth.VerifyTOTP(secret, req.Code, app.now())
+	if !ok {
+		writeError(w, r, failStatus, "invalid_two_factor_code")
+		return false
+	}
+
+	// Enabling records the step itself, in the same statement that turns 2FA on
+	if !tf.Enabled {
+		tf.LastStep = step
+		return true
+	}
+
+	fresh, err := app.store.TwoFactor.UseStep(r.Context(), tf.UserID, step)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "two_factor_failed")
+		return false
+	}
+	if !fresh {
+		writeError(w, r, failStatus, "invalid_two_factor_code")
+		return false
+	}
+	return true
+}
+
+// setupTwoFactorHandler starts setting up 2FA with a new secret and recovery codes
+// Nothing changes for logins until a first code is confirmed with the enable
+// endpoint; calling this again before then starts over with a new secret
+// POST /v1/users/me/2fa/setup
+// Requires authentication with a login session (not a personal access token)
+// Response: {"secret": "JBSWY3DP...", "otpauth_uri": "otpauth://totp/go-chat:john%40example.com?...",
+// "recovery_codes": ["k3j5m2qa-7hd9x4pn", ...]}
+// 2FA already on: 409
+func (app *application) setupTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.requireSessionForTwoFactor(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := app.store.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "user_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "two_factor_failed")
+		return
+	}
+	sealed, err := app.secrets.Seal(secret)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "two_factor_failed")
+		return
+	}
+	codes, hashes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "two_factor_failed")
+		return
+	}
+
+	if err := app.store.TwoFactor.Setup(r.Context(), userID, sealed, hashes); err != nil {
+		if errors.Is(err, store.ErrTwoFactorEnabled) {
+			writeError(w, r, http.StatusConflict, "two_factor_already_enabled")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "two_factor_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, TwoFactorSetupResponse{
+		Secret:        auth.EncodeTOTPSecret(secret),
+		OTPAuthURI:    auth.TOTPURI(secret, twoFactorIssuer, user.Email),
+		RecoveryCodes: codes,
+	})
+}
+
+// enableTwoFactorHandler turns 2FA on once the user shows their app produces codes
+// POST /v1/users/me/2fa/enable
+// Requires authentication with a login session
+// Request body: {"code": "123456"}
+// Response: 204 No Content; from now on logins ask for a code
+// Not set up or already on: 409; wrong code: 400
+func (app *application) enableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.requireSessionForTwoFactor(w, r)
+	if !ok {
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	tf, err := app.store.TwoFactor.Get(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "two_factor_failed")
+		return
+	}
+	if tf.Enabled {
+		writeError(w, r, http.StatusConflict, "two_factor_already_enabled")
+		return
+	}
+	if tf.Secret == nil {
+		writeError(w, r, http.StatusConflict, "two_factor_not_set_up")
+		return
+	}
+
+	if !app.checkTwoFactorCode(w, r, tf, req, false, http.StatusBadRequest) {
+		return
+	}
+
+	if err := app.store.TwoFactor.Enable(r.Context(), userID, tf.LastStep); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// Enabled by a concurrent request, or set up again since
+			writeError(w, r, http.StatusConflict, "two_factor_already_enabled")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "two_factor_failed")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// disableTwoFactorHandler turns 2FA off and forgets the secret and recovery codes
+// POST /v1/users/me/2fa/disable
+// Requires authentication with a login session, and a current code or a recovery code
+// Request body: {"code": "123456"} or {"recovery_code": "k3j5m2qa-7hd9x4pn"}
+// Response: 204 No Content
+// Not on: 409; wrong code: 400
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.requireSessionForTwoFactor(w, r)
+	if !ok {
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	tf, err := app.store.TwoFactor.Get(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "two_factor_failed")
+		return
+	}
+	if !tf.Enabled {
+		writeError(w, r, http.StatusConflict, "two_factor_not_enabled")
+		return
+	}
+
+	if !app.checkTwoFactorCode(w, r, tf, req, true, http.StatusBadRequest) {
+		return
+	}
+
+	if err := app.store.TwoFactor.Disable(r.Context(), userID); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "two_factor_failed")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// startTwoFactorLogin answers a correct password for an account with 2FA on
+// with an intermediate token instead of a session
+func (app *application) startTwoFactorLogin(w http.ResponseWriter, r *http.Request, userID int64) {
+	token, expiresAt, err := auth.GenerateTwoFactorToken(userID, app.config.auth.jwtSecret)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "token_generation_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, TwoFactorChallengeResponse{
+		TwoFactorRequired: true,
+		TwoFactorToken:    token,
+		ExpiresAt:         expiresAt,
+	})
+}
+
+// verifyTwoFactorLoginHandler finishes a two-step login
+// The intermediate token from the login response and a code (or a recovery
+// code) are exchanged for the real token, as login would return it
+// POST /v1/auth/2fa/verify
+// Request body: {"two_factor_token": "jwt...", "code": "123456"} or
+// {"two_factor_token": "jwt...", "recovery_code": "k3j5m2qa-7hd9x4pn"}
+// Response: {"token": "jwt...", "user": {...}}
+// Bad or expired intermediate token, or wrong code: 401; too many attempts: 429
+func (app *application) verifyTwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorVerifyRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	userID, err := auth.ParseTwoFactorToken(req.TwoFactorToken, app.config.auth.jwtSecret)
+	if err != nil {
+		if errors.Is(err, auth.ErrExpiredToken) {
+			writeError(w, r, http.StatusUnauthorized, "token_expired")
+			return
+		}
+		writeError(w, r, http.StatusUnauthorized, "invalid_token")
+		return
+	}
+
+	tf, err := app.store.TwoFactor.Get(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusUnauthorized, "invalid_token")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "two_factor_failed")
+		return
+	}
+	// Turned off since the password was checked: log in again without a code
+	if !tf.Enabled {
+		writeError(w, r, http.StatusUnauthorized, "invalid_token")
+		return
+	}
+
+	if !app.checkTwoFactorCode(w, r, tf, req.TwoFactorCodeRequest, true, http.StatusUnauthorized) {
+		return
+	}
+
+	user, err := app.store.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return
+	}
+
+	token, ok := app.startSession(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	user.Password = ""
+	writeJSON(w, http.StatusOK, AuthResponse{
+		Token: token,
+		User:  user,
+	})
+}
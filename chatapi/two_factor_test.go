@@ -0,0 +1,134 @@
+package chatapi
+
+import (
+	"encoding/base32"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/auth"
+)
+
+// setUpTwoFactor starts a 2FA setup for the session in headers and returns
+// the secret, decoded, and the recovery codes
+func setUpTwoFactor(t *testing.T, serverURL string, headers map[string]string) ([]byte, []string) {
+	t.Helper()
+	var setup TwoFactorSetupResponse
+	if status := doJSONWithHeaders(t, http.MethodPost, serverURL+"/v1/users/me/2fa/setup", 0, headers, nil, &setup); status != http.StatusOK {
+		t.Fatalf("setting up 2FA got %d, want 200", status)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(setup.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(setup.OTPAuthURI, "otpauth://totp/go-chat:ada@example.com?") || len(setup.RecoveryCodes) != auth.RecoveryCodeCount {
+		t.Errorf("got URI %q and %d recovery codes", setup.OTPAuthURI, len(setup.RecoveryCodes))
+	}
+	return secret, setup.RecoveryCodes
+}
+
+// startTwoFactorLogin logs ada in with the password and returns the
+// intermediate token
+func startTwoFactorLogin(t *testing.T, serverURL string) string {
+	t.Helper()
+	var challenge TwoFactorChallengeResponse
+	body := LoginRequest{Email: "ada@example.com", Password: "correct horse battery staple"}
+	if status := doJSON(t, http.MethodPost, serverURL+"/v1/auth/login", 0, body, &challenge); status != http.StatusOK || !challenge.TwoFactorRequired || challenge.TwoFactorToken == "" {
+		t.Fatalf("logging in got %d %+v, want a 2FA challenge", status, challenge)
+	}
+	return challenge.TwoFactorToken
+}
+
+// TestTwoFactorLogin turns 2FA on for ada and logs in with it: the password
+// alone only earns an intermediate token that opens nothing, a code from the
+// next time step is accepted, a code can't be used twice, and a recovery
+// code works once. Turning 2FA off takes a code and restores one-step logins
+func TestTwoFactorLogin(t *testing.T) {
+	clock := newFakeClock()
+	ts := newTestStore(t)
+	addLoginUser(t, ts)
+	server, app := newTestServerApp(t, ts, withClock(clock))
+	app.twoFactorLimiter = newRateLimiter[int64](0, 0)
+	session := login(t, server.URL, "curl/8.5.0")
+	secret, recoveryCodes := setUpTwoFactor(t, server.URL, session)
+	codeAt := func(at time.Time) string { return auth.TOTPCode(secret, auth.TOTPStep(at)) }
+	enable := server.URL + "/v1/users/me/2fa/enable"
+	verify := server.URL + "/v1/auth/2fa/verify"
+
+	var failure errorBody
+	if status := doJSONWithHeaders(t, http.MethodPost, enable, 0, session, TwoFactorCodeRequest{Code: "000000"}, &failure); status != http.StatusBadRequest || failure.Code != "invalid_two_factor_code" {
+		t.Errorf("enabling with a wrong code got %d %q, want 400 invalid_two_factor_code", status, failure.Code)
+	}
+	enableCode := codeAt(clock.Now())
+	if status := doJSONWithHeaders(t, http.MethodPost, enable, 0, session, TwoFactorCodeRequest{Code: enableCode}, nil); status != http.StatusNoContent {
+		t.Fatalf("enabling got %d, want 204", status)
+	}
+
+	pending := startTwoFactorLogin(t, server.URL)
+	if status := doJSONWithHeaders(t, http.MethodGet, server.URL+"/v1/users/me/sessions", 0, withToken(pending), nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("the intermediate token got %d on a protected route, want 401", status)
+	}
+
+	for _, tc := range []struct {
+		name string
+		req  TwoFactorCodeRequest
+		want int
+	}{
+		{"the code that enabled 2FA", TwoFactorCodeRequest{Code: enableCode}, http.StatusUnauthorized},
+		{"a code from the next step", TwoFactorCodeRequest{Code: codeAt(clock.Now().Add(30 * time.Second))}, http.StatusOK},
+		{"the same code again", TwoFactorCodeRequest{Code: codeAt(clock.Now().Add(30 * time.Second))}, http.StatusUnauthorized},
+		{"a recovery code", TwoFactorCodeRequest{RecoveryCode: strings.ToUpper(recoveryCodes[0])}, http.StatusOK},
+		{"the same recovery code again", TwoFactorCodeRequest{RecoveryCode: recoveryCodes[0]}, http.StatusUnauthorized},
+	} {
+		var resp AuthResponse
+		body := TwoFactorVerifyRequest{TwoFactorToken: startTwoFactorLogin(t, server.URL), TwoFactorCodeRequest: tc.req}
+		status := doJSON(t, http.MethodPost, verify, 0, body, &resp)
+		if status != tc.want {
+			t.Errorf("%s got %d, want %d", tc.name, status, tc.want)
+			continue
+		}
+		if status == http.StatusOK {
+			if got := doJSONWithHeaders(t, http.MethodGet, server.URL+"/v1/users/me/sessions", 0, withToken(resp.Token), nil, nil); got != http.StatusOK {
+				t.Errorf("%s: the token got %d, want 200", tc.name, got)
+			}
+		}
+	}
+	accessToken := strings.TrimPrefix(session["Authorization"], "Bearer ")
+	body := TwoFactorVerifyRequest{TwoFactorToken: accessToken, TwoFactorCodeRequest: TwoFactorCodeRequest{RecoveryCode: recoveryCodes[1]}}
+	if status := doJSON(t, http.MethodPost, verify, 0, body, nil); status != http.StatusUnauthorized {
+		t.Errorf("verifying with an access token got %d, want 401", status)
+	}
+
+	disable := server.URL + "/v1/users/me/2fa/disable"
+	if status := doJSONWithHeaders(t, http.MethodPost, disable, 0, session, TwoFactorCodeRequest{RecoveryCode: "not-a-code"}, nil); status != http.StatusBadRequest {
+		t.Errorf("disabling with a wrong recovery code got %d, want 400", status)
+	}
+	if status := doJSONWithHeaders(t, http.MethodPost, disable, 0, session, TwoFactorCodeRequest{RecoveryCode: recoveryCodes[1]}, nil); status != http.StatusNoContent {
+		t.Fatalf("disabling got %d, want 204", status)
+	}
+	login(t, server.URL, "curl/8.5.0")
+}
+
+// TestTwoFactorRateLimit refuses a user's sixth code in a minute, even a
+// right one
+func TestTwoFactorRateLimit(t *testing.T) {
+	clock := newFakeClock()
+	ts := newTestStore(t)
+	addLoginUser(t, ts)
+	server := newTestServer(t, ts, withClock(clock))
+	session := login(t, server.URL, "curl/8.5.0")
+	secret, _ := setUpTwoFactor(t, server.URL, session)
+	enable := server.URL + "/v1/users/me/2fa/enable"
+
+	for i := 0; i < twoFactorAttempts; i++ {
+		if status := doJSONWithHeaders(t, http.MethodPost, enable, 0, session, TwoFactorCodeRequest{Code: "000000"}, nil); status != http.StatusBadRequest {
+			t.Fatalf("attempt %d got %d, want 400", i+1, status)
+		}
+	}
+	var failure errorBody
+	code := auth.TOTPCode(secret, auth.TOTPStep(clock.Now()))
+	if status := doJSONWithHeaders(t, http.MethodPost, enable, 0, session, TwoFactorCodeRequest{Code: code}, &failure); status != http.StatusTooManyRequests || failure.Code != "two_factor_rate_limited" {
+		t.Errorf("a right code over the limit got %d %q, want 429 two_factor_rate_limited", status, failure.Code)
+	}
+}
@@ -0,0 +1,83 @@
+package chatapi
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// upgradeBusyRetryAfter is the Retry-After sent when too many upgrades are in flight, in seconds
+// Handshakes take milliseconds, so slots free up quickly
+const upgradeBusyRetryAfter = 1
+
+// upgradeGuard protects the WebSocket upgrade routes from handshake floods
+// Every upgrade costs database queries (session, room access, user) before
+// the connection exists, so a burst of attempts, valid or not, turns straight
+// into database load. The guard runs before authentication: each IP may start
+// a limited number of handshakes per window, and only so many may be in
+// flight at once
+type upgradeGuard struct {
+	slots   chan struct{}        // One per upgrade in flight
+	limiter *rateLimiter[string] // Handshakes per IP
+
+	rejectedBusy        atomic.Int64
+	rejectedRateLimited atomic.Int64
+}
+
+// UpgradeStats is how the WebSocket upgrade routes are holding up
+type UpgradeStats struct {
+	InFlight            int   `json:"in_flight"`             // Handshakes being handled right now
+	MaxInFlight         int   `json:"max_in_flight"`         // WS_MAX_CONCURRENT_UPGRADES
+	RejectedBusy        int64 `json:"rejected_busy"`         // Turned away with 503 while all slots were taken
+	RejectedRateLimited int64 `json:"rejected_rate_limited"` // Turned away with 429 by the per-IP limit
+}
+
+// newUpgradeGuard creates a guard allowing maxInFlight upgrades at once
+// The per-IP limit starts disabled; applyRuntimeConfig sets it
+func newUpgradeGuard(maxInFlight int) *upgradeGuard {
+	return &upgradeGuard{
+		slots:   make(chan struct{}, maxInFlight),
+		limiter: newRateLimiter[string](0, 0),
+	}
+}
+
+// stats returns the guard's current counts
+func (g *upgradeGuard) stats() UpgradeStats {
+	return UpgradeStats{
+		InFlight:            len(g.slots),
+		MaxInFlight:         cap(g.slots),
+		RejectedBusy:        g.rejectedBusy.Load(),
+		RejectedRateLimited: g.rejectedRateLimited.Load(),
+	}
+}
+
+// guardUpgrade applies the upgrade guard to a WebSocket route
+// An IP over WS_HANDSHAKE_RATE_LIMIT gets a 429 and a full server a 503,
+// both with Retry-After and without touching the database
+// The slot is held until the handler returns, which for a WebSocket is once
+// the connection is up: the connection itself runs on the hub
+func (app *application) guardUpgrade(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g := app.upgrades
+
+		if ok, retryAfter := g.limiter.allow(clientIP(r)); !ok {
+			g.rejectedRateLimited.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(retryAfter.Seconds())))))
+			writeError(w, r, http.StatusTooManyRequests, "handshake_rate_limited")
+			return
+		}
+
+		select {
+		case g.slots <- struct{}{}:
+		default:
+			g.rejectedBusy.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(upgradeBusyRetryAfter))
+			writeError(w, r, http.StatusServiceUnavailable, "upgrades_busy")
+			return
+		}
+		defer func() { <-g.slots }()
+
+		next.ServeHTTP(w, r)
+	})
+}
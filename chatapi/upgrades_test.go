@@ -0,0 +1,179 @@
+package chatapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// handshakeQueries counts the store calls a WebSocket handshake can make
+type handshakeQueries struct {
+	membership atomic.Int64
+	access     atomic.Int64
+	users      atomic.Int64
+}
+
+func (q *handshakeQueries) total() int64 {
+	return q.membership.Load() + q.access.Load() + q.users.Load()
+}
+
+type countingRoomMembers struct {
+	*fakeRoomMembers
+	queries *handshakeQueries
+}
+
+func (c countingRoomMembers) IsUserInRoom(ctx context.Context, roomID, userID int64) (bool, error) {
+	c.queries.membership.Add(1)
+	return c.fakeRoomMembers.IsUserInRoom(ctx, roomID, userID)
+}
+
+type countingRoomPermissions struct {
+	*fakeRoomPermissions
+	queries *handshakeQueries
+}
+
+func (c countingRoomPermissions) GetAccess(ctx context.Context, roomID, userID int64) (*store.RoomAccess, error) {
+	c.queries.access.Add(1)
+	return c.fakeRoomPermissions.GetAccess(ctx, roomID, userID)
+}
+
+type countingUsers struct {
+	*fakeUsers
+	queries *handshakeQueries
+}
+
+func (c countingUsers) GetByID(ctx context.Context, id int64) (*store.User, error) {
+	c.queries.users.Add(1)
+	return c.fakeUsers.GetByID(ctx, id)
+}
+
+// newHandshakeApp returns an application whose handshake queries are
+// counted, with room 1 holding user 1
+func newHandshakeApp(t *testing.T) (*application, *handshakeQueries) {
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	ts.rooms.add(&store.Room{ID: 1, Name: "lobby", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleOwner)
+
+	queries := &handshakeQueries{}
+	ts.RoomMembers = countingRoomMembers{ts.roomMembers, queries}
+	ts.RoomPermissions = countingRoomPermissions{ts.perms, queries}
+	ts.Users = countingUsers{ts.users, queries}
+	return newTestApp(ts), queries
+}
+
+// handshake makes a WebSocket upgrade request to room 1 from ip, as userID
+// A negative userID sends a token that doesn't verify
+// The request isn't a real upgrade, so one that gets through every check
+// fails in the upgrader after doing all the work a real one would
+func handshake(t *testing.T, handler http.Handler, ip string, userID int64) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/v1/rooms/1/ws", nil)
+	r.RemoteAddr = ip + ":40000"
+	if userID < 0 {
+		r.Header.Set("Authorization", "Bearer not-a-jwt")
+	} else {
+		r = asUser(t, r, userID)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// TestHandshakeQueries makes 1000 handshake attempts at a time and counts
+// the database queries behind them. Before the upgrade guard and the cached
+// membership check, every attempt by a member cost a membership query and a
+// user lookup (2000 for 1000 attempts)
+// Garbage tokens from one IP cost nothing at all, a reconnect storm from one
+// IP is cut off by the handshake limit, and one from many IPs asks for the
+// room access once
+func TestHandshakeQueries(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		userID   int64
+		ips      int
+		queries  int64
+		rejected int // With 429
+	}{
+		{"invalid tokens from one IP", -1, 1, 0, 940},
+		{"a member from one IP", 1, 1, 1 + 60, 940},
+		{"a member from 1000 IPs", 1, 1000, 1 + 1000, 0},
+	} {
+		app, queries := newHandshakeApp(t)
+		app.applyRuntimeConfig(app.runtime.Load())
+		handler := app.mount()
+
+		rejected := 0
+		for i := range 1000 {
+			ip := fmt.Sprintf("10.0.%d.%d", i%tc.ips/256, i%tc.ips%256)
+			if handshake(t, handler, ip, tc.userID).Code == http.StatusTooManyRequests {
+				rejected++
+			}
+		}
+
+		if got := queries.total(); got != tc.queries || rejected != tc.rejected {
+			t.Errorf("%s: 1000 attempts made %d queries with %d rejected, want %d and %d", tc.name, got, rejected, tc.queries, tc.rejected)
+		}
+		if n := queries.membership.Load(); n != 0 {
+			t.Errorf("%s: membership was queried %d times outside the room access", tc.name, n)
+		}
+		if stats := app.upgrades.stats(); stats.RejectedRateLimited != int64(tc.rejected) || stats.InFlight != 0 {
+			t.Errorf("%s: got stats %+v", tc.name, stats)
+		}
+	}
+}
+
+// TestHandshakeLimits checks a handshake over the per-IP limit gets a 429,
+// however it fills in X-Forwarded-For, and one while every slot is taken a
+// 503, both with Retry-After, and that both are counted in the readiness
+// stats
+func TestHandshakeLimits(t *testing.T) {
+	app, queries := newHandshakeApp(t)
+	app.upgrades.limiter.configure(1, time.Minute)
+	handler := app.mount()
+
+	handshake(t, handler, "10.0.0.1", 1)
+	w := handshake(t, handler, "10.0.0.1", 1)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("the second handshake from one IP got %d with Retry-After %q, want 429 with 60", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// The limit counts the connection's address, not whatever it claims to be
+	spoofed := httptest.NewRequest(http.MethodGet, "/v1/rooms/1/ws", nil)
+	spoofed.RemoteAddr = "10.0.0.1:40000"
+	spoofed.Header.Set("X-Forwarded-For", "10.0.0.99")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, asUser(t, spoofed, 1))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("a handshake with a made-up X-Forwarded-For got %d, want 429", w.Code)
+	}
+
+	for range cap(app.upgrades.slots) {
+		app.upgrades.slots <- struct{}{}
+	}
+	w = handshake(t, handler, "10.0.0.2", 1)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("a handshake with every slot taken got %d with Retry-After %q, want 503 with 1", w.Code, w.Header().Get("Retry-After"))
+	}
+	if got := queries.total(); got != 2 {
+		t.Errorf("rejected handshakes made %d queries, want only the first handshake's 2", got)
+	}
+
+	var ready ReadinessResponse
+	r := httptest.NewRequest(http.MethodGet, "/v1/health/ready", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if err := json.NewDecoder(rec.Body).Decode(&ready); err != nil {
+		t.Fatal(err)
+	}
+	want := UpgradeStats{InFlight: 256, MaxInFlight: 256, RejectedBusy: 1, RejectedRateLimited: 2}
+	if ready.Upgrades != want {
+		t.Errorf("readiness reported upgrades %+v, want %+v", ready.Upgrades, want)
+	}
+}
@@ -0,0 +1,89 @@
+package chatapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/drazan344/go-chat/internal/websocket"
+)
+
+// maxUserEventsLimit is the largest page of a user's frames one request may ask for
+const maxUserEventsLimit = 500
+
+// UserEventsResponse is a page of the frames sent to the current user
+type UserEventsResponse struct {
+	// Events are the frames as they were sent, each with its user_seq
+	Events []json.RawMessage `json:"events"`
+
+	// LatestUserSeq is the user_seq to ask for next: the last frame returned,
+	// or after_user_seq if there was nothing new
+	LatestUserSeq int64 `json:"latest_user_seq"`
+
+	// HasMore is true if the page was full and more frames follow
+	HasMore bool `json:"has_more"`
+}
+
+// listUserEventsHandler returns the frames the current user was sent after
+// a user_seq, across all their rooms and connections
+// Clients keep the highest user_seq they've seen; a jump on a frame, or a
+// reconnect, means frames were missed, and this fills the gap. Frames are
+// kept for USER_EVENT_RETENTION, up to USER_EVENT_BUFFER per user in memory
+// (more with USER_EVENT_SPILL); past that the response is 410 and the client
+// must reload its state
+// after_user_seq 0 (or none) returns the oldest frames still kept
+// GET /v1/users/me/events?after_user_seq=1718000000000123&limit=500
+// Requires authentication
+// Response: {"events": [{"user_seq": 1718000000000124, "room_id": 3, "type": "message", ...}],
+// "latest_user_seq": 1718000000000124, "has_more": false}
+func (app *application) listUserEventsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	var after int64
+	if raw := r.URL.Query().Get("after_user_seq"); raw != "" {
+		after, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil || after < 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid_pagination")
+			return
+		}
+	}
+	limit := maxUserEventsLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxUserEventsLimit {
+			writeError(w, r, http.StatusBadRequest, "invalid_pagination")
+			return
+		}
+		limit = n
+	}
+
+	page, err := app.hub.UserEvents(r.Context(), userID, after, limit)
+	if err != nil {
+		if errors.Is(err, websocket.ErrUserEventsGone) {
+			writeError(w, r, http.StatusGone, "user_events_gone")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "user_events_lookup_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, UserEventsResponse{
+		Events:        page.Events,
+		LatestUserSeq: page.Latest,
+		HasMore:       page.HasMore,
+	})
+}
+
+// newTxn returns an ID for the frames one action produces (wire.Message.Txn)
+func newTxn() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,224 @@
+package chatapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+	ws "github.com/drazan344/go-chat/internal/websocket"
+	"github.com/gorilla/websocket"
+)
+
+// newUserEventsStore has general (1) and random (2), where ada (1) and
+// grace (2) are both members
+func newUserEventsStore(t *testing.T) *testStore {
+	t.Helper()
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	ts.users.add(&store.User{ID: 2, Username: "grace"})
+	for _, roomID := range []int64{1, 2} {
+		ts.rooms.add(&store.Room{ID: roomID, Name: fmt.Sprint("room", roomID), CreatedBy: 1})
+		ts.roomMembers.add(roomID, 1, store.RoomRoleAdmin)
+		ts.roomMembers.add(roomID, 2, store.RoomRoleMember)
+	}
+	return ts
+}
+
+// userEvents fetches the frames userID was sent after a user_seq, decoded
+func userEvents(t *testing.T, serverURL string, userID, after int64) (int, UserEventsResponse, []*ws.Message) {
+	t.Helper()
+	var page UserEventsResponse
+	status := doJSON(t, http.MethodGet, serverURL+"/v1/users/me/events?after_user_seq="+strconv.FormatInt(after, 10), userID, nil, &page)
+	frames := make([]*ws.Message, len(page.Events))
+	for i, raw := range page.Events {
+		frames[i] = &ws.Message{}
+		if err := json.Unmarshal(raw, frames[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return status, page, frames
+}
+
+// readSequenced reads conn's frames until one is a chat message with the
+// given content, failing the test if the user_seqs it carries ever go down
+// Returns the frames that had one, by user_seq
+func readSequenced(t *testing.T, conn *websocket.Conn, content string) map[int64]*ws.Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	seen := make(map[int64]*ws.Message)
+	var last int64
+	for {
+		var frame ws.Message
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatalf("waiting for %q: %v", content, err)
+		}
+		if frame.UserSeq != 0 {
+			if frame.UserSeq <= last {
+				t.Errorf("a %s frame has user_seq %d after %d", frame.Type, frame.UserSeq, last)
+			}
+			last = frame.UserSeq
+			seen[frame.UserSeq] = &frame
+		}
+		if frame.Type == "message" && frame.Content == content {
+			return seen
+		}
+	}
+}
+
+// TestUserSeqAcrossConnections has ada connected twice to general and once
+// to random while grace talks in both: each connection sees ada's user_seq
+// only go up, both general connections give each frame the same number, and
+// together the connections cover an unbroken run of numbers the events
+// endpoint returns as well
+func TestUserSeqAcrossConnections(t *testing.T) {
+	server := newTestServer(t, newUserEventsStore(t))
+	general := dialRoom(t, server, 1, 1)
+	readFrame(t, general, "join")
+	second := dialRoom(t, server, 1, 1)
+	random := dialRoom(t, server, 2, 1)
+
+	for i := range 6 {
+		roomID := int64(1 + i%2)
+		url := fmt.Sprintf("%s/v1/rooms/%d/messages", server.URL, roomID)
+		if status := doJSON(t, http.MethodPost, url, 2, SendMessageRequest{Content: fmt.Sprint("hello ", i)}, nil); status != http.StatusCreated {
+			t.Fatalf("sending message %d got %d", i, status)
+		}
+	}
+
+	seen := readSequenced(t, general, "hello 4")
+	fromSecond := readSequenced(t, second, "hello 4")
+	for seq, frame := range fromSecond {
+		if first, ok := seen[seq]; ok && (first.Type != frame.Type || first.ID != frame.ID) {
+			t.Errorf("user_seq %d is a %s frame (%d) on one connection and a %s frame (%d) on the other", seq, first.Type, first.ID, frame.Type, frame.ID)
+		}
+		seen[seq] = frame
+	}
+	for seq, frame := range readSequenced(t, random, "hello 5") {
+		if _, ok := seen[seq]; ok && frame.RoomID == 2 {
+			t.Errorf("user_seq %d was given to frames in both rooms", seq)
+		}
+		seen[seq] = frame
+	}
+
+	status, page, frames := userEvents(t, server.URL, 1, 0)
+	if status != http.StatusOK || len(frames) == 0 {
+		t.Fatalf("listing ada's events got %d with %d frames", status, len(frames))
+	}
+	listed := make(map[int64]bool, len(frames))
+	for i, frame := range frames {
+		if i > 0 && frame.UserSeq != frames[i-1].UserSeq+1 {
+			t.Errorf("the events jump from user_seq %d to %d", frames[i-1].UserSeq, frame.UserSeq)
+		}
+		listed[frame.UserSeq] = true
+	}
+	for seq, frame := range seen {
+		if !listed[seq] {
+			t.Errorf("the %s frame with user_seq %d isn't listed", frame.Type, seq)
+		}
+	}
+	if page.LatestUserSeq != frames[len(frames)-1].UserSeq {
+		t.Errorf("latest_user_seq is %d, want the last frame's, %d", page.LatestUserSeq, frames[len(frames)-1].UserSeq)
+	}
+	if status, page, _ := userEvents(t, server.URL, 2, 0); status != http.StatusOK || len(page.Events) != 0 {
+		t.Errorf("grace, who never connected, got %d with %d events, want none", status, len(page.Events))
+	}
+}
+
+// TestKickSharesTxn removes linus from the lobby: his "removed_from_room"
+// frame, and the "member_removed" frame and system message ada gets, all
+// carry the same txn
+func TestKickSharesTxn(t *testing.T) {
+	server := newTestServer(t, newBulkStore(t))
+	ada := dialRoom(t, server, 1, 1)
+	readFrame(t, ada, "join")
+	linus := dialRoom(t, server, 1, 3)
+	readFrame(t, linus, "join")
+	readFrame(t, ada, "join")
+
+	bulkResults(t, server.URL+"/v1/rooms/1/members/bulk-remove", 1, BulkMembersRequest{Usernames: []string{"linus"}}, http.StatusOK)
+
+	removed := readFrame(t, linus, "removed_from_room")
+	if removed.Txn == "" {
+		t.Fatal("the removed_from_room frame has no txn")
+	}
+	if frame := readFrame(t, ada, "member_removed"); frame.Txn != removed.Txn || frame.UserID != 3 || frame.Username != "linus" {
+		t.Errorf("ada's member_removed frame is for %q (%d) in txn %q, want linus (3) in %q", frame.Username, frame.UserID, frame.Txn, removed.Txn)
+	}
+	notice := readFrame(t, ada, "message")
+	if !notice.System || notice.Content != "linus was removed from the room" || notice.Txn != removed.Txn {
+		t.Errorf("ada's system message is %q (system %t) in txn %q, want the removal in %q", notice.Content, notice.System, notice.Txn, removed.Txn)
+	}
+	if notice.UserSeq == 0 {
+		t.Error("the system message has no user_seq")
+	}
+}
+
+// TestUserEventsGapRecovery drops ada's connection without reading what
+// grace sent in the meantime: after reconnecting, the jump in user_seq on the
+// next message is filled from the events endpoint, in order. A position from
+// before ada's stream began is refused with 410
+func TestUserEventsGapRecovery(t *testing.T) {
+	server := newTestServer(t, newUserEventsStore(t))
+	ada := dialRoom(t, server, 1, 1)
+	before := readFrame(t, ada, "join").UserSeq
+	if before == 0 {
+		t.Fatal("ada's join has no user_seq")
+	}
+
+	for i := range 3 {
+		if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/messages", 2, SendMessageRequest{Content: fmt.Sprint("missed ", i)}, nil); status != http.StatusCreated {
+			t.Fatalf("sending message %d got %d", i, status)
+		}
+	}
+	// The frames were queued for ada, who never reads them
+	sent := testutil.WaitFor(2*time.Second, func() bool {
+		_, page, _ := userEvents(t, server.URL, 1, before)
+		return len(page.Events) >= 3
+	})
+	if !sent {
+		t.Fatal("the messages never reached ada's stream")
+	}
+	ada.Close()
+
+	// Within the presence grace period the reconnect has no join, so the
+	// next message is the first frame ada sees
+	ada = dialRoom(t, server, 1, 1)
+	if status := doJSON(t, http.MethodPost, server.URL+"/v1/rooms/1/messages", 2, SendMessageRequest{Content: "back"}, nil); status != http.StatusCreated {
+		t.Fatalf("sending the last message got %d", status)
+	}
+	after := readFrame(t, ada, "message").UserSeq
+	if after <= before+1 {
+		t.Fatalf("ada reconnected at user_seq %d after %d, want a gap", after, before)
+	}
+
+	status, page, frames := userEvents(t, server.URL, 1, before)
+	if status != http.StatusOK {
+		t.Fatalf("filling the gap got %d", status)
+	}
+	var missed []string
+	for i, frame := range frames {
+		if frame.UserSeq != before+int64(i)+1 {
+			t.Errorf("frame %d has user_seq %d, want %d", i, frame.UserSeq, before+int64(i)+1)
+		}
+		if frame.Type == "message" {
+			missed = append(missed, frame.Content)
+		}
+	}
+	if fmt.Sprint(missed) != "[missed 0 missed 1 missed 2 back]" {
+		t.Errorf("the gap held %v, want the three missed messages in order, then the last", missed)
+	}
+	if page.LatestUserSeq != after || page.HasMore {
+		t.Errorf("latest_user_seq is %d (has_more %t), want the last message's %d", page.LatestUserSeq, page.HasMore, after)
+	}
+
+	var failure errorBody
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/users/me/events?after_user_seq=1", 1, nil, &failure); status != http.StatusGone || failure.Code != "user_events_gone" {
+		t.Errorf("a position from before the stream got %d %q, want 410 user_events_gone", status, failure.Code)
+	}
+}
@@ -0,0 +1,216 @@
+package chatapi
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// minUserSearchLength is the shortest query search accepts
+	// Single letters match most of the directory, which is what scrapers want
+	minUserSearchLength = 2
+
+	// defaultUserSearchLimit is how many users search returns when ?limit is not given
+	defaultUserSearchLimit = 20
+
+	// maxUserSearchLimit caps how many users one search can return
+	maxUserSearchLimit = 50
+
+	// maxDisplayNameLength matches the users.display_name column
+	maxDisplayNameLength = 100
+)
+
+// UpdateProfileRequest represents the JSON structure for updating your own profile
+// Fields left out keep their current value
+type UpdateProfileRequest struct {
+	DisplayName  *string `json:"display_name"`
+	Discoverable *bool   `json:"discoverable"`
+
+	// Version is the profile version the change is based on (or send If-Match)
+	Version *int64 `json:"version"`
+}
+
+// searchUsersHandler finds users by username or display name
+// Users who turned off "discoverable" are never returned
+// GET /v1/users/search?q=ali&limit=20
+// Requires authentication; rate limited per user
+// Response: [{"id": 5, "username": "alice", "display_name": "Alice"}, ...]
+func (app *application) searchUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.allowDirectoryLookup(w, r) {
+		return
+	}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if utf8.RuneCountInString(q) < minUserSearchLength {
+		writeError(w, r, http.StatusBadRequest, "search_query_too_short", minUserSearchLength)
+		return
+	}
+
+	limit := defaultUserSearchLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxUserSearchLimit {
+			writeError(w, r, http.StatusBadRequest, "invalid_pagination")
+			return
+		}
+		limit = n
+	}
+
+	users, err := app.store.Users.Search(r.Context(), q, limit)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, users)
+}
+
+// getUserByUsernameHandler returns a user's public profile by exact username
+// Works for non-discoverable users too, so they stay reachable by people who know their name
+// GET /v1/users/by-username/{username}
+// Requires authentication; rate limited per user (shared with search)
+// Response: {"id": 5, "username": "alice", "display_name": "Alice"}
+func (app *application) getUserByUsernameHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.allowDirectoryLookup(w, r) {
+		return
+	}
+
+	user, err := app.store.Users.GetByUsername(r.Context(), chi.URLParam(r, "username"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "user_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, user)
+}
+
+// getMutualHandler shows how the current user and another user are connected:
+// the rooms they share, how much they've talked there lately, and since when
+// Only rooms both are members of are listed, so nothing private leaks
+// GET /v1/users/{userID}/mutual
+// Requires authentication; rate limited per user (shared with search)
+// Response: {"user_id": 7, "rooms": [{"id": 1, "name": "general", "member_count": 12, "shared_since": "..."}], "messages_last_30_days": 42, "first_shared_at": "..."}
+func (app *application) getMutualHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	otherID, err := extractIDFromURL(r, "userID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "userID")
+		return
+	}
+	if otherID == userID {
+		writeError(w, r, http.StatusBadRequest, "mutual_self")
+		return
+	}
+
+	if !app.allowDirectoryLookup(w, r) {
+		return
+	}
+
+	if _, err := app.store.Users.GetByID(r.Context(), otherID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "user_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return
+	}
+
+	mutual, err := app.store.RoomMembers.GetMutual(r.Context(), userID, otherID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "mutual_lookup_failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, mutual)
+}
+
+// updateProfileHandler changes the current user's display name and directory visibility
+// PATCH /v1/users/me
+// Requires authentication
+// Send the version you read as If-Match: "<version>" (or "version" in the body);
+// if the profile changed since, the response is 412 with the current profile
+// Request body: {"display_name": "Alice", "discoverable": false}
+// Response: {"id": 5, "username": "alice", "display_name": "Alice", "discoverable": false, ...}
+func (app *application) updateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := readJSON(r, &req); err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	if req.DisplayName != nil {
+		name := strings.TrimSpace(*req.DisplayName)
+		if utf8.RuneCountInString(name) > maxDisplayNameLength {
+			writeError(w, r, http.StatusBadRequest, "display_name_too_long", maxDisplayNameLength)
+			return
+		}
+		req.DisplayName = &name
+	}
+
+	version, ok := expectedVersion(w, r, req.Version)
+	if !ok {
+		return
+	}
+
+	user, err := app.store.Users.UpdateProfile(r.Context(), userID, req.DisplayName, req.Discoverable, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrVersionConflict):
+			current, err := app.store.Users.GetByID(r.Context(), userID)
+			if err != nil {
+				writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+				return
+			}
+			writeVersionConflict(w, r, current, current.Version)
+		case errors.Is(err, sql.ErrNoRows):
+			writeError(w, r, http.StatusNotFound, "user_not_found")
+		default:
+			writeError(w, r, http.StatusInternalServerError, "profile_update_failed")
+		}
+		return
+	}
+
+	setETag(w, user.Version)
+	writeJSON(w, http.StatusOK, user)
+}
+
+// allowDirectoryLookup applies the per-user rate limit for search and username lookups
+// It writes a 429 with Retry-After and returns false once the user is over the limit
+func (app *application) allowDirectoryLookup(w http.ResponseWriter, r *http.Request) bool {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return false
+	}
+
+	ok, retryAfter := app.directoryLimiter.allow(userID)
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeError(w, r, http.StatusTooManyRequests, "directory_rate_limited")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package chatapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// newDirectoryStore creates a testStore with ada, grace and linus; linus
+// isn't discoverable
+func newDirectoryStore(t *testing.T) *testStore {
+	t.Helper()
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 1, Username: "ada", Email: "ada@example.com", Discoverable: true})
+	ts.users.add(&store.User{ID: 2, Username: "grace", Email: "grace@example.com", DisplayName: "Grace Hopper", Discoverable: true})
+	ts.users.add(&store.User{ID: 3, Username: "linus", Email: "linus@example.com"})
+	return ts
+}
+
+// TestSearchUsers checks the query rules and that results carry public
+// fields only
+func TestSearchUsers(t *testing.T) {
+	server := newTestServer(t, newDirectoryStore(t))
+
+	for _, tc := range []struct {
+		query string
+		want  int
+		code  string
+	}{
+		{"q=a", http.StatusBadRequest, "search_query_too_short"},
+		{"q=%20a%20", http.StatusBadRequest, "search_query_too_short"},
+		{"q=ad&limit=51", http.StatusBadRequest, "invalid_pagination"},
+		{"q=ad&limit=0", http.StatusBadRequest, "invalid_pagination"},
+		{"q=in", http.StatusOK, ""},
+	} {
+		var raw json.RawMessage
+		status := doJSON(t, http.MethodGet, server.URL+"/v1/users/search?"+tc.query, 1, nil, &raw)
+		var failure errorBody
+		json.Unmarshal(raw, &failure)
+		if status != tc.want || failure.Code != tc.code {
+			t.Errorf("?%s got %d %q, want %d %q", tc.query, status, failure.Code, tc.want, tc.code)
+		}
+	}
+
+	var hidden []map[string]any
+	if doJSON(t, http.MethodGet, server.URL+"/v1/users/search?q=nus", 1, nil, &hidden); len(hidden) != 0 {
+		t.Errorf("searching for linus found %v, but they aren't discoverable", hidden)
+	}
+
+	var found []map[string]any
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/users/search?q=ra", 1, nil, &found); status != http.StatusOK {
+		t.Fatalf("searching got %d, want 200", status)
+	}
+	if len(found) != 1 || found[0]["username"] != "grace" || found[0]["display_name"] != "Grace Hopper" {
+		t.Fatalf("searching found %v, want grace", found)
+	}
+	if _, ok := found[0]["email"]; ok {
+		t.Errorf("search returned grace's email: %v", found[0])
+	}
+}
+
+// TestDiscoverable has grace hide from search: she's gone from results but
+// can still be looked up by exact username
+func TestDiscoverable(t *testing.T) {
+	server := newTestServer(t, newDirectoryStore(t))
+
+	var me store.User
+	if status := doJSON(t, http.MethodPatch, server.URL+"/v1/users/me", 2, map[string]bool{"discoverable": false}, &me); status != http.StatusOK {
+		t.Fatalf("updating the profile got %d, want 200", status)
+	}
+	if me.Discoverable || me.DisplayName != "Grace Hopper" {
+		t.Errorf("the profile is %+v, want hidden with the display name kept", me)
+	}
+
+	var found []store.PublicUser
+	doJSON(t, http.MethodGet, server.URL+"/v1/users/search?q=gr", 1, nil, &found)
+	if len(found) != 0 {
+		t.Errorf("searching found %v after grace hid", found)
+	}
+
+	var user store.PublicUser
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/users/by-username/grace", 1, nil, &user); status != http.StatusOK || user.ID != 2 {
+		t.Errorf("looking grace up got %d %+v, want 200 with grace", status, user)
+	}
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/users/by-username/nobody", 1, nil, nil); status != http.StatusNotFound {
+		t.Errorf("looking up a missing user got %d, want 404", status)
+	}
+
+	long := make([]byte, maxDisplayNameLength+1)
+	for i := range long {
+		long[i] = 'a'
+	}
+	var failure errorBody
+	if status := doJSON(t, http.MethodPatch, server.URL+"/v1/users/me", 2, map[string]string{"display_name": string(long)}, &failure); status != http.StatusBadRequest || failure.Code != "display_name_too_long" {
+		t.Errorf("a long display name got %d %q, want 400 display_name_too_long", status, failure.Code)
+	}
+}
+
+// TestDirectoryRateLimit shares one limit between search and lookups, per
+// user: the request over it gets 429 with Retry-After, and other users
+// aren't affected
+func TestDirectoryRateLimit(t *testing.T) {
+	server := newTestServer(t, newDirectoryStore(t), func(app *application) {
+		app.directoryLimiter = newRateLimiter[int64](2, time.Minute)
+	})
+
+	doJSON(t, http.MethodGet, server.URL+"/v1/users/search?q=ad", 1, nil, nil)
+	doJSON(t, http.MethodGet, server.URL+"/v1/users/by-username/ada", 1, nil, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/v1/users/search?q=ad", nil)
+	resp, err := http.DefaultClient.Do(asUser(t, req, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "60" {
+		t.Errorf("the third lookup got %d with Retry-After %q, want 429 and 60", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if status := doJSON(t, http.MethodGet, server.URL+"/v1/users/search?q=ad", 2, nil, nil); status != http.StatusOK {
+		t.Errorf("grace's lookup got %d, want 200", status)
+	}
+}
@@ -0,0 +1,58 @@
+package chatapi
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// expectedVersion returns the version an update was based on, for optimistic locking
+// It comes from an If-Match header ("5" or W/"5") or else the body's version field
+// Zero means the client sent neither: the update goes ahead as last write wins,
+// which is deprecated and logged
+// On a malformed If-Match it writes a 400 and returns false
+func expectedVersion(w http.ResponseWriter, r *http.Request, bodyVersion *int64) (int64, bool) {
+	if header := r.Header.Get("If-Match"); header != "" {
+		tag := strings.Trim(strings.TrimPrefix(strings.TrimSpace(header), "W/"), `"`)
+		version, err := strconv.ParseInt(tag, 10, 64)
+		if err != nil || version < 1 {
+			writeError(w, r, http.StatusBadRequest, "invalid_if_match")
+			return 0, false
+		}
+		return version, true
+	}
+
+	if bodyVersion != nil {
+		if *bodyVersion < 1 {
+			writeError(w, r, http.StatusBadRequest, "invalid_version")
+			return 0, false
+		}
+		return *bodyVersion, true
+	}
+
+	log.Printf("Deprecated: %s %s without If-Match or version; concurrent edits may overwrite each other", r.Method, r.URL.Path)
+	return 0, true
+}
+
+// setETag sends a resource's version as its ETag, ready to be echoed in If-Match
+func setETag(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", `"`+strconv.FormatInt(version, 10)+`"`)
+}
+
+// writeVersionConflict writes a 412 carrying the resource as it is now
+// The client merges its change into current and retries with current's version
+// Response: {"error": "...", "code": "version_conflict", "current": {...}}
+func writeVersionConflict(w http.ResponseWriter, r *http.Request, current interface{}, version int64) {
+	type conflictResponse struct {
+		Error   string      `json:"error"`
+		Code    string      `json:"code"`
+		Current interface{} `json:"current"`
+	}
+
+	const code = "version_conflict"
+	locale := resolveLocale(r)
+	w.Header().Set("Content-Language", locale)
+	setETag(w, version)
+	writeJSON(w, http.StatusPreconditionFailed, conflictResponse{Error: translate(locale, code), Code: code, Current: current})
+}
@@ -0,0 +1,162 @@
+package chatapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// conflictBody is a 412 response, with the resource as it is now
+type conflictBody[T any] struct {
+	Code    string `json:"code"`
+	Current T      `json:"current"`
+}
+
+// TestRoomVersionConflict edits a room from two tabs that both read
+// version 1: the first save wins and the second gets 412 with the room as
+// the first left it. Merging and retrying with the new version goes through
+func TestRoomVersionConflict(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1, Version: 1})
+	server := newTestServer(t, ts)
+	url := server.URL + "/v1/rooms/1"
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(asUser(t, req, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if etag := resp.Header.Get("ETag"); etag != `"1"` {
+		t.Errorf("the room's ETag is %s, want \"1\"", etag)
+	}
+
+	first, second := "first tab", "second tab"
+	var saved store.Room
+	if status := doJSONWithHeaders(t, http.MethodPatch, url, 1, map[string]string{"If-Match": `"1"`}, RoomSettings{Description: &first}, &saved); status != http.StatusOK {
+		t.Fatalf("the first save got %d, want 200", status)
+	}
+	if saved.Version != 2 {
+		t.Errorf("the first save left version %d, want 2", saved.Version)
+	}
+
+	var conflict conflictBody[store.Room]
+	status := doJSONWithHeaders(t, http.MethodPatch, url, 1, map[string]string{"If-Match": `"1"`}, RoomSettings{Description: &second}, &conflict)
+	if status != http.StatusPreconditionFailed || conflict.Code != "version_conflict" {
+		t.Fatalf("the second save got %d %q, want 412 version_conflict", status, conflict.Code)
+	}
+	if conflict.Current.Description != first || conflict.Current.Version != 2 {
+		t.Errorf("the conflict carried %q at version %d, want the first save", conflict.Current.Description, conflict.Current.Version)
+	}
+
+	// The retry sends the version in the body instead of If-Match
+	merged := first + " + " + second
+	retry := UpdateRoomRequest{RoomSettings: RoomSettings{Description: &merged}, Version: &conflict.Current.Version}
+	if status := doJSON(t, http.MethodPatch, url, 1, retry, &saved); status != http.StatusOK || saved.Version != 3 {
+		t.Errorf("the retry got %d at version %d, want 200 at 3", status, saved.Version)
+	}
+	if room, _ := ts.rooms.GetByID(context.Background(), 1); room.Description != merged {
+		t.Errorf("the room's description is %q, want %q", room.Description, merged)
+	}
+
+	// Without a version the save still goes through, as last write wins
+	if status := doJSON(t, http.MethodPatch, url, 1, RoomSettings{Description: &second}, &saved); status != http.StatusOK || saved.Version != 4 {
+		t.Errorf("the unversioned save got %d at version %d, want 200 at 4", status, saved.Version)
+	}
+}
+
+// racingRooms is a room store where someone else saves the room between
+// the handler reading it and writing it back
+type racingRooms struct {
+	*fakeRooms
+}
+
+func (r racingRooms) Update(ctx context.Context, room *store.Room, expectedVersion int64) error {
+	other, err := r.fakeRooms.GetByID(ctx, room.ID)
+	if err != nil {
+		return err
+	}
+	other.Description = "saved in between"
+	if err := r.fakeRooms.Update(ctx, other, 0); err != nil {
+		return err
+	}
+	return r.fakeRooms.Update(ctx, room, expectedVersion)
+}
+
+// TestRoomVersionRace loses the race after the handler's own version
+// check passed: the store refuses the write and the 412 carries the room
+// as the other save left it
+func TestRoomVersionRace(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1, Version: 1})
+	ts.Rooms = racingRooms{ts.rooms}
+	server := newTestServer(t, ts)
+
+	description := "mine"
+	var conflict conflictBody[store.Room]
+	status := doJSONWithHeaders(t, http.MethodPatch, server.URL+"/v1/rooms/1", 1, map[string]string{"If-Match": `W/"1"`}, RoomSettings{Description: &description}, &conflict)
+	if status != http.StatusPreconditionFailed || conflict.Current.Description != "saved in between" || conflict.Current.Version != 2 {
+		t.Errorf("got %d with %+v, want 412 with the other save at version 2", status, conflict.Current)
+	}
+}
+
+// TestProfileVersionConflict runs the same race on a user's own profile
+func TestProfileVersionConflict(t *testing.T) {
+	ts := newTestStore(t)
+	ts.users.add(&store.User{ID: 1, Username: "ada", Version: 1})
+	server := newTestServer(t, ts)
+	url := server.URL + "/v1/users/me"
+
+	name := "Ada"
+	var saved store.User
+	if status := doJSONWithHeaders(t, http.MethodPatch, url, 1, map[string]string{"If-Match": `"1"`}, UpdateProfileRequest{DisplayName: &name}, &saved); status != http.StatusOK || saved.Version != 2 {
+		t.Fatalf("the first save got %d at version %d, want 200 at 2", status, saved.Version)
+	}
+
+	hidden := false
+	var conflict conflictBody[store.User]
+	status := doJSONWithHeaders(t, http.MethodPatch, url, 1, map[string]string{"If-Match": `"1"`}, UpdateProfileRequest{Discoverable: &hidden}, &conflict)
+	if status != http.StatusPreconditionFailed || conflict.Current.DisplayName != name || conflict.Current.Version != 2 {
+		t.Errorf("the stale save got %d with %+v, want 412 with the first save", status, conflict.Current)
+	}
+	if user, _ := ts.users.GetByID(context.Background(), 1); user.Discoverable {
+		t.Error("the stale save was applied")
+	}
+}
+
+// TestVersionValidation refuses versions that can't be right before
+// touching the room
+func TestVersionValidation(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1, Version: 1})
+	server := newTestServer(t, ts)
+	zero := int64(0)
+
+	for _, tc := range []struct {
+		name    string
+		ifMatch string
+		body    UpdateRoomRequest
+		code    string
+	}{
+		{"zero", `"0"`, UpdateRoomRequest{}, "invalid_if_match"},
+		{"not a number", `"abc"`, UpdateRoomRequest{}, "invalid_if_match"},
+		{"zero in the body", "", UpdateRoomRequest{Version: &zero}, "invalid_version"},
+	} {
+		headers := map[string]string{}
+		if tc.ifMatch != "" {
+			headers["If-Match"] = tc.ifMatch
+		}
+		var failure errorBody
+		if status := doJSONWithHeaders(t, http.MethodPatch, server.URL+"/v1/rooms/1", 1, headers, tc.body, &failure); status != http.StatusBadRequest || failure.Code != tc.code {
+			t.Errorf("%s: got %d %q, want 400 %q", tc.name, status, failure.Code, tc.code)
+		}
+	}
+	if room, _ := ts.rooms.GetByID(context.Background(), 1); room.Version != 1 {
+		t.Errorf("the room is at version %d after only bad updates", room.Version)
+	}
+}
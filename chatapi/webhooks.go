@@ -0,0 +1,48 @@
+package chatapi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/drazan344/go-chat/internal/webhook"
+	"github.com/drazan344/go-chat/internal/websocket"
+)
+
+// hookEvents lists the event names accepted in WEBHOOK_EVENTS
+var hookEvents = map[string]bool{
+	websocket.EventMessagePersisted: true,
+	websocket.EventClientJoined:     true,
+	websocket.EventClientLeft:       true,
+	websocket.EventRoomEmptied:      true,
+}
+
+// parseWebhookEvents splits a comma-separated event allowlist
+// Unknown names are an error so a typo doesn't silently disable an event
+func parseWebhookEvents(list string) ([]string, error) {
+	var events []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !hookEvents[name] {
+			return nil, fmt.Errorf("unknown webhook event %q", name)
+		}
+		events = append(events, name)
+	}
+	return events, nil
+}
+
+// registerWebhook subscribes the outgoing webhook to the hub's events
+// Does nothing when no webhook URL is configured
+func registerWebhook(hub *websocket.Hub, cfg webhookConfig) {
+	if cfg.url == "" {
+		return
+	}
+
+	webhook.New(webhook.Config{
+		URL:    cfg.url,
+		Secret: cfg.secret,
+		Events: cfg.events,
+	}).Register(hub.Hooks())
+}
@@ -0,0 +1,31 @@
+package chatapi
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestParseWebhookEvents checks the WEBHOOK_EVENTS allowlist: blanks and
+// spaces are ignored, and a misspelt event stops startup
+func TestParseWebhookEvents(t *testing.T) {
+	for _, tc := range []struct {
+		list    string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{" , ", nil, false},
+		{"message.persisted", []string{"message.persisted"}, false},
+		{"client.joined, room.emptied,", []string{"client.joined", "room.emptied"}, false},
+		{"client.joined,client.leave", nil, true},
+	} {
+		got, err := parseWebhookEvents(tc.list)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseWebhookEvents(%q) error = %v, want error %v", tc.list, err, tc.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("parseWebhookEvents(%q) = %q, want %q", tc.list, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package chatapi
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/drazan344/go-chat/internal/store"
+	ws "github.com/drazan344/go-chat/internal/websocket"
+)
+
+// eventsFromQuery reads the comma-separated ?events= filter from a WebSocket URL
+// A missing parameter returns nil, meaning the client receives every event
+func eventsFromQuery(r *http.Request) []string {
+	raw := r.URL.Query().Get("events")
+	if raw == "" {
+		return nil
+	}
+	return strings.Split(raw, ",")
+}
+
+// pingStatsFromQuery reports whether a WebSocket URL asked for ?ping_stats=1
+// Anything that doesn't parse as true leaves the frames off
+func pingStatsFromQuery(r *http.Request) bool {
+	enabled, _ := strconv.ParseBool(r.URL.Query().Get("ping_stats"))
+	return enabled
+}
+
+// suppressEchoFromQuery reports whether a WebSocket URL asked for ?suppress_echo=true
+func suppressEchoFromQuery(r *http.Request) bool {
+	enabled, _ := strconv.ParseBool(r.URL.Query().Get("suppress_echo"))
+	return enabled
+}
+
+// autoJoinFromQuery reports whether a WebSocket URL asked for ?auto_join=true
+func autoJoinFromQuery(r *http.Request) bool {
+	enabled, _ := strconv.ParseBool(r.URL.Query().Get("auto_join"))
+	return enabled
+}
+
+// autoJoinRoom joins the user to an open room they're connecting to
+// Rooms that need approval or an invite are refused with a 403 saying which;
+// the user must knock or be invited first. The membership check and insert
+// are one statement, so when two devices auto-join at once only one of them
+// adds the member and fires the usual join side effects
+// Writes the error response itself and returns false if the user can't join
+func (app *application) autoJoinRoom(w http.ResponseWriter, r *http.Request, roomID, userID int64) bool {
+	room, err := app.store.Rooms.GetByID(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+			return false
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_verify_failed")
+		return false
+	}
+
+	switch room.JoinPolicy {
+	case store.JoinPolicyApproval:
+		writeError(w, r, http.StatusForbidden, "auto_join_requires_approval")
+		return false
+	case store.JoinPolicyInvite:
+		writeError(w, r, http.StatusForbidden, "auto_join_invite_only")
+		return false
+	}
+
+	joined, err := app.store.RoomMembers.JoinIfAbsent(r.Context(), roomID, userID)
+	if err != nil {
+		// The room may have been deleted since it was loaded
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "room_not_found")
+			return false
+		}
+		if app.writeLimitError(w, r, err) {
+			return false
+		}
+		writeError(w, r, http.StatusInternalServerError, "room_join_failed")
+		return false
+	}
+	// Another connect may have joined the user in the meantime; it fired the
+	// side effects already
+	if joined {
+		app.roomMembersChanged(roomID)
+	}
+	return true
+}
+
+// websocketHandler handles WebSocket upgrade and connection
+// GET /v1/rooms/{roomID}/ws?events=message,join&proto=2&ping_stats=1&suppress_echo=true&auto_join=true
+// Requires authentication (JWT token); handshakes are limited per IP and in
+// flight before the token is even checked (see upgrades.go)
+// The user must be a member of the room to connect, unless auto_join is set:
+// then a user outside an open room joins it before the upgrade (see autoJoinRoom)
+// The optional events parameter limits which room events the client receives
+// The optional proto parameter (or a "gochat.v2" subprotocol) selects the frame format
+// The optional ping_stats parameter sends the client its round-trip time after every ping
+// The optional suppress_echo parameter stops the client's own messages coming back to it
+// (see internal/websocket/options.go); API token connections may send silent messages
+func (app *application) websocketHandler(w http.ResponseWriter, r *http.Request) {
+	// A draining instance sends clients elsewhere instead of taking new connections
+	if app.rejectIfDraining(w, r) {
+		return
+	}
+
+	// Only pages served from ALLOWED_ORIGINS may open connections, when it's set
+	if app.rejectIfOriginNotAllowed(w, r) {
+		return
+	}
+
+	// Get authenticated user ID from context
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "user_not_authenticated")
+		return
+	}
+
+	// Extract room ID from URL
+	roomID, err := extractIDFromURL(r, "roomID")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id_parameter", "roomID")
+		return
+	}
+
+	// Verify user is a member of the room
+	// Users can only connect to rooms they've joined, and not once the room is
+	// deleted. Membership comes with the room access, which is cached: a
+	// reconnect storm asks the same question over and over, and joins, leaves,
+	// kicks and deletions invalidate it
+	access, ok := app.authorizeRoom(w, r, roomID, userID, "")
+	if !ok {
+		return
+	}
+	if (!access.Member || access.Deleted) && autoJoinFromQuery(r) {
+		if !app.autoJoinRoom(w, r, roomID, userID) {
+			return
+		}
+		// Joining invalidated the cached access; load the user's new role
+		if access, ok = app.authorizeRoom(w, r, roomID, userID, ""); !ok {
+			return
+		}
+	}
+	if !access.Member || access.Deleted {
+		writeError(w, r, http.StatusForbidden, "membership_required_connect")
+		return
+	}
+
+	// Get user information to include username in messages
+	user, err := app.store.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "user_not_found")
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "user_lookup_failed")
+		return
+	}
+
+	// Upgrade HTTP connection to WebSocket and start the client on the hub
+	// Legacy clients that don't ask for a frame format get v1
+	sessionID, _ := SessionIDFromContext(r.Context())
+	isBot := !viaLoginSession(r.Context())
+	_, err = ws.ServeWS(app.hub, w, r, ws.ClientOptions{
+		UserID:       userID,
+		Username:     user.Username,
+		RoomID:       roomID,
+		SessionID:    sessionID,
+		Events:       eventsFromQuery(r),
+		PingStats:    pingStatsFromQuery(r),
+		SuppressEcho: suppressEchoFromQuery(r),
+		AllowSilent:  isBot,
+		DenyPosting:  !access.Can(store.CapPostMessage), // Members who can't post may still read
+
+		MentionEveryone: access.Can(store.CapMentionEveryone),
+		Role:            access.Role,
+	})
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+
+	// Messages sent while the user was offline count as delivered once they connect
+	app.markDeliveredLatest(roomID, userID)
+
+	log.Printf("WebSocket connection established: user=%s room=%d", user.Username, roomID)
+}
@@ -0,0 +1,56 @@
+package chatapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/gorilla/websocket"
+)
+
+// TestSubprotocolNegotiation connects with the subprotocols a client offers:
+// the server echoes the version it picked, and the connection opens with a
+// hello for it; a client offering none gets no subprotocol and no hello
+func TestSubprotocolNegotiation(t *testing.T) {
+	ts := newTestStore(t)
+	ts.rooms.add(&store.Room{ID: 1, Name: "general", CreatedBy: 1})
+	ts.roomMembers.add(1, 1, store.RoomRoleAdmin)
+	ts.users.add(&store.User{ID: 1, Username: "ada"})
+	server := newTestServer(t, ts)
+	url := fmt.Sprintf("ws%s/v1/rooms/1/ws", strings.TrimPrefix(server.URL, "http"))
+
+	for _, tc := range []struct {
+		offered []string
+		echoed  string
+		first   string // Type of the first frame
+	}{
+		{nil, "", "join"},
+		{[]string{"gochat.v1", "gochat.v2"}, "gochat.v2", "hello"},
+		{[]string{"gochat.v1"}, "gochat.v1", "hello"},
+	} {
+		header := asUser(t, httptest.NewRequest(http.MethodGet, url, nil), 1).Header
+		dialer := websocket.Dialer{Subprotocols: tc.offered}
+		conn, _, err := dialer.Dial(url, header)
+		if err != nil {
+			t.Fatalf("offering %v: %v", tc.offered, err)
+		}
+
+		var first struct {
+			Type string `json:"type"`
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		err = conn.ReadJSON(&first)
+		conn.Close()
+		if err != nil {
+			t.Fatalf("offering %v: %v", tc.offered, err)
+		}
+		if conn.Subprotocol() != tc.echoed || first.Type != tc.first {
+			t.Errorf("offering %v got %q and a %s frame first, want %q and %s",
+				tc.offered, conn.Subprotocol(), first.Type, tc.echoed, tc.first)
+		}
+	}
+}
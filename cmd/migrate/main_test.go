@@ -0,0 +1,54 @@
+package main
+
+import (
+	"database/sql"
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/drazan344/go-chat/internal/migrate"
+)
+
+// TestMain silences the progress log
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newMockDB returns a sqlmock database that matches queries literally and
+// checks every expectation was met
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return db, mock
+}
+
+// TestForceValidation checks force refuses arguments it can't act on
+// None of them may touch the database: the mock expects nothing
+func TestForceValidation(t *testing.T) {
+	db, _ := newMockDB(t)
+
+	migrations := []migrate.Migration{{Version: 1, Prefix: "000001", Name: "create_users"}}
+	for _, args := range [][]string{
+		nil,
+		{"one"},
+		{"7"},
+		{"1", "applied", "now"},
+		{"1", "done"},
+	} {
+		if err := force(db, migrations, args); err == nil {
+			t.Errorf("force %q was accepted", args)
+		}
+	}
+}
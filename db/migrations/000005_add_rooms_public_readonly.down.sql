@@ -0,0 +1,2 @@
+-- Rollback guest read-only flag on rooms
+ALTER TABLE rooms DROP COLUMN IF EXISTS is_public_readonly;
@@ -0,0 +1,4 @@
+-- Allow rooms to expose a read-only live feed to anonymous guests
+-- Guests can watch public rooms over WebSocket and read recent history,
+-- but can never send messages
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS is_public_readonly BOOLEAN NOT NULL DEFAULT FALSE;
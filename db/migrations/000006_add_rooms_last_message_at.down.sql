@@ -0,0 +1,3 @@
+-- Rollback room last activity tracking
+DROP INDEX IF EXISTS idx_rooms_last_message_at;
+ALTER TABLE rooms DROP COLUMN IF EXISTS last_message_at;
@@ -0,0 +1,17 @@
+-- Track when each room last received a message
+-- Sidebars sort rooms by recent activity; keeping this denormalized on the room
+-- avoids a MAX(created_at) subquery over messages for every room in the list
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS last_message_at TIMESTAMP;
+
+-- Backfill from existing messages
+UPDATE rooms r
+SET last_message_at = m.last_created
+FROM (
+    SELECT room_id, MAX(created_at) AS last_created
+    FROM messages
+    GROUP BY room_id
+) m
+WHERE r.id = m.room_id;
+
+-- Index for ?sort=activity (rooms without messages sort last)
+CREATE INDEX IF NOT EXISTS idx_rooms_last_message_at ON rooms(last_message_at DESC NULLS LAST);
@@ -0,0 +1,4 @@
+-- Rollback devices and read markers
+DROP INDEX IF EXISTS idx_messages_room_id_id;
+DROP TABLE IF EXISTS read_markers CASCADE;
+DROP TABLE IF EXISTS devices CASCADE;
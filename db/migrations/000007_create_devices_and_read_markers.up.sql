@@ -0,0 +1,34 @@
+-- Create devices table for per-device client registration
+-- A user logged in on web and mobile has one row per client so each can
+-- keep its own read position during transition periods
+CREATE TABLE IF NOT EXISTS devices (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_active_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Index on user_id to list a user's devices
+CREATE INDEX idx_devices_user_id ON devices(user_id);
+
+-- Index on last_active_at so the stale-device pruner doesn't scan the table
+CREATE INDEX idx_devices_last_active_at ON devices(last_active_at);
+
+-- Create read_markers table tracking the last message each device has read
+-- device_id 0 is used by clients that haven't registered a device, so it is
+-- deliberately not a foreign key; markers of pruned devices are removed by the pruner
+CREATE TABLE IF NOT EXISTS read_markers (
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    device_id BIGINT NOT NULL DEFAULT 0,
+    last_read_message_id BIGINT NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, room_id, device_id)
+);
+
+-- Index on device_id for pruning markers of removed devices
+CREATE INDEX idx_read_markers_device_id ON read_markers(device_id);
+
+-- Unread counts filter messages by room and id
+CREATE INDEX IF NOT EXISTS idx_messages_room_id_id ON messages(room_id, id);
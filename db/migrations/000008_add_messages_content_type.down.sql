@@ -0,0 +1,3 @@
+-- Rollback message formatting metadata
+ALTER TABLE messages DROP COLUMN IF EXISTS language;
+ALTER TABLE messages DROP COLUMN IF EXISTS content_type;
@@ -0,0 +1,6 @@
+-- Add formatting metadata to messages
+-- content_type tells clients how to render the content ("text", "markdown", "code")
+-- language is only set for code messages (e.g. "go", "python")
+-- Existing rows become plain text via the column default
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS content_type VARCHAR(20) NOT NULL DEFAULT 'text';
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS language VARCHAR(32);
@@ -0,0 +1,6 @@
+-- Drop join requests and the columns added for the approval workflow
+DROP TABLE IF EXISTS join_requests;
+
+ALTER TABLE room_members DROP COLUMN IF EXISTS role;
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS join_policy;
@@ -0,0 +1,36 @@
+-- Add join_policy to rooms so creators can choose how people get in
+-- "open" rooms can be joined by anyone, "approval" rooms require an admin to
+-- accept a join request, and "invite" rooms can't be joined directly at all
+ALTER TABLE rooms
+    ADD COLUMN IF NOT EXISTS join_policy VARCHAR(16) NOT NULL DEFAULT 'open'
+    CHECK (join_policy IN ('open', 'approval', 'invite'));
+
+-- Add a role to room memberships so rooms can have admins besides the creator
+ALTER TABLE room_members
+    ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'member'
+    CHECK (role IN ('member', 'admin'));
+
+-- Existing creators become admins of their rooms
+UPDATE room_members rm
+SET role = 'admin'
+FROM rooms r
+WHERE r.id = rm.room_id AND r.created_by = rm.user_id;
+
+-- Create join_requests table for "approval" rooms
+-- One row per (room, user): a new knock reuses the row, so duplicate pending
+-- requests are impossible and the last decision is kept for the cooldown check
+CREATE TABLE IF NOT EXISTS join_requests (
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'approved', 'rejected')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    decided_at TIMESTAMP,
+    -- The admin who approved or rejected; kept even if that admin is deleted
+    decided_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    PRIMARY KEY (room_id, user_id)
+);
+
+-- Index for listing a room's pending requests, oldest first
+CREATE INDEX idx_join_requests_pending ON join_requests(room_id, created_at)
+    WHERE status = 'pending';
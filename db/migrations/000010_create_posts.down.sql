@@ -0,0 +1,2 @@
+-- Drop posts table
+DROP TABLE IF EXISTS posts;
@@ -0,0 +1,19 @@
+-- Create posts table for long-form posts outside of chat rooms
+-- Some deployments created this table by hand before it had a migration,
+-- so IF NOT EXISTS keeps this safe to apply on top of them
+CREATE TABLE IF NOT EXISTS posts (
+    id BIGSERIAL PRIMARY KEY,
+    title VARCHAR(255) NOT NULL,
+    content TEXT NOT NULL,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- Free-form labels stored as a Postgres array
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Index on user_id to find all posts by an author
+CREATE INDEX IF NOT EXISTS idx_posts_user_id ON posts(user_id);
+
+-- Index supporting the newest-first listing used for pagination
+CREATE INDEX IF NOT EXISTS idx_posts_created_id ON posts(created_at DESC, id DESC);
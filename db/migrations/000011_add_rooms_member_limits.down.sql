@@ -0,0 +1,6 @@
+-- Remove the member count trigger and the per-room member limit
+DROP TRIGGER IF EXISTS room_members_count ON room_members;
+DROP FUNCTION IF EXISTS room_members_count();
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS member_count;
+ALTER TABLE rooms DROP COLUMN IF EXISTS max_members;
@@ -0,0 +1,30 @@
+-- Add an optional per-room member limit
+-- NULL means the room uses the global MAX_ROOM_MEMBERS limit; a value can only
+-- lower the limit, never raise it above the global cap (enforced by the API)
+ALTER TABLE rooms
+    ADD COLUMN IF NOT EXISTS max_members INTEGER CHECK (max_members > 0);
+
+-- Keep each room's member count on the room row, so reading a room (which now
+-- reports its count next to its limit) doesn't count its members every time
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS member_count INTEGER NOT NULL DEFAULT 0;
+
+UPDATE rooms r SET
+    member_count = (SELECT COUNT(*) FROM room_members rm WHERE rm.room_id = r.id);
+
+-- Memberships change in many places, including cascades from deleted users,
+-- so a trigger keeps the count instead of the store
+CREATE OR REPLACE FUNCTION room_members_count() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('DELETE', 'UPDATE') THEN
+        UPDATE rooms SET member_count = member_count - 1 WHERE id = OLD.room_id;
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE rooms SET member_count = member_count + 1 WHERE id = NEW.room_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER room_members_count
+    AFTER INSERT OR DELETE OR UPDATE OF room_id ON room_members
+    FOR EACH ROW EXECUTE FUNCTION room_members_count();
@@ -0,0 +1,2 @@
+-- Remove delivery tracking
+ALTER TABLE room_members DROP COLUMN IF EXISTS delivered_up_to;
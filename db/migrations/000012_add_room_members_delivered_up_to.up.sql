@@ -0,0 +1,6 @@
+-- Track message delivery per member for "delivered" ticks
+-- Rather than a row per (message, user), each membership keeps a pointer to the
+-- newest message that reached one of the user's connections; everything at or
+-- below it counts as delivered
+ALTER TABLE room_members
+    ADD COLUMN IF NOT EXISTS delivered_up_to BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+-- Remove content filter columns
+ALTER TABLE messages DROP COLUMN IF EXISTS filtered;
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS content_filter_enabled;
@@ -0,0 +1,7 @@
+-- Let room creators switch the content filter off for their room
+ALTER TABLE rooms
+    ADD COLUMN IF NOT EXISTS content_filter_enabled BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- Mark messages whose content was masked by the content filter
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS filtered BOOLEAN NOT NULL DEFAULT FALSE;
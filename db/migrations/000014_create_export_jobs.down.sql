@@ -0,0 +1,4 @@
+-- Drop export jobs and the index used to stream a user's messages
+DROP INDEX IF EXISTS idx_messages_user_id_id;
+
+DROP TABLE IF EXISTS export_jobs;
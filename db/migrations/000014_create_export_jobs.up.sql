@@ -0,0 +1,21 @@
+-- Create export_jobs table tracking personal data exports
+-- Every export gets a row, including small ones served immediately, so the
+-- one-export-per-day limit covers both paths
+CREATE TABLE IF NOT EXISTS export_jobs (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- running: being generated, done: ready (async exports have a file), failed: see error
+    status VARCHAR(16) NOT NULL DEFAULT 'running'
+        CHECK (status IN ('running', 'done', 'failed')),
+    -- Location of the generated file for async exports, NULL for immediate ones
+    file_path TEXT,
+    error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP
+);
+
+-- Index for the rate limit check (latest export of a user)
+CREATE INDEX idx_export_jobs_user_created ON export_jobs(user_id, created_at DESC);
+
+-- Exports stream a user's messages in id order
+CREATE INDEX IF NOT EXISTS idx_messages_user_id_id ON messages(user_id, id);
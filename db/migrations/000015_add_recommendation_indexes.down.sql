@@ -0,0 +1,3 @@
+-- Drop the room recommendation indexes
+DROP INDEX IF EXISTS idx_messages_created_room;
+DROP INDEX IF EXISTS idx_room_members_user_room;
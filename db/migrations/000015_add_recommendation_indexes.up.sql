@@ -0,0 +1,8 @@
+-- Indexes for room recommendations (RoomStore.Recommend)
+
+-- Finding a user's co-members walks room_members from user to room and back;
+-- with both columns in the index these become index-only scans
+CREATE INDEX IF NOT EXISTS idx_room_members_user_room ON room_members(user_id, room_id);
+
+-- Counting each room's messages from the last 7 days only reads recent entries
+CREATE INDEX IF NOT EXISTS idx_messages_created_room ON messages(created_at, room_id);
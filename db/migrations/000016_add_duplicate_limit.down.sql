@@ -0,0 +1,4 @@
+-- Remove the duplicate message limit
+DROP INDEX IF EXISTS idx_messages_duplicates;
+ALTER TABLE messages DROP COLUMN IF EXISTS content_hash;
+ALTER TABLE rooms DROP COLUMN IF EXISTS duplicate_limit_enabled;
@@ -0,0 +1,9 @@
+-- Optional per-room limit on repeated identical messages (spam collapse)
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS duplicate_limit_enabled BOOLEAN NOT NULL DEFAULT false;
+
+-- SHA-256 of the normalized content (see content.Hash), hex encoded
+-- NULL for messages stored before this column existed
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS content_hash CHAR(64);
+
+-- Counting a user's recent identical messages in a room
+CREATE INDEX IF NOT EXISTS idx_messages_duplicates ON messages(room_id, user_id, content_hash, created_at);
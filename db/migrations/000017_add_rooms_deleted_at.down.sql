@@ -0,0 +1,4 @@
+-- Remove soft deletion for rooms
+-- Rooms that are still soft-deleted become visible again
+DROP INDEX IF EXISTS idx_rooms_deleted_at;
+ALTER TABLE rooms DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,7 @@
+-- Soft deletion for rooms
+-- A deleted room is hidden everywhere but keeps its messages and memberships
+-- until the restore window passes and the purge job removes it for good
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+-- The purge job looks for rooms deleted longer ago than the restore window
+CREATE INDEX IF NOT EXISTS idx_rooms_deleted_at ON rooms(deleted_at) WHERE deleted_at IS NOT NULL;
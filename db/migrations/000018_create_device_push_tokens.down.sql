@@ -0,0 +1,2 @@
+-- Drop device_push_tokens table
+DROP TABLE IF EXISTS device_push_tokens;
@@ -0,0 +1,18 @@
+-- Create device_push_tokens table for mobile/browser push notifications
+-- Each device has at most one token; registering again replaces it
+CREATE TABLE IF NOT EXISTS device_push_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    device_id BIGINT NOT NULL UNIQUE REFERENCES devices(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- Which push service the token belongs to
+    platform VARCHAR(16) NOT NULL CHECK (platform IN ('apns', 'fcm', 'webpush')),
+    token TEXT NOT NULL,
+    -- Permanent delivery failures in a row; the token is disabled at the limit
+    consecutive_failures INT NOT NULL DEFAULT 0,
+    disabled_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Index for finding the active tokens of the users being notified
+CREATE INDEX idx_device_push_tokens_user_active ON device_push_tokens(user_id) WHERE disabled_at IS NULL;
@@ -0,0 +1,2 @@
+-- Drop room_membership_events table
+DROP TABLE IF EXISTS room_membership_events;
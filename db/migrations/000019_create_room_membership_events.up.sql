@@ -0,0 +1,17 @@
+-- Create room_membership_events table: an append-only history of membership changes
+-- Rows are written in the same transaction as the change itself
+CREATE TABLE IF NOT EXISTS room_membership_events (
+    id BIGSERIAL PRIMARY KEY,
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    event VARCHAR(16) NOT NULL CHECK (event IN ('joined', 'left', 'kicked', 'banned', 'invited')),
+    -- Who performed the change; the user themselves for a plain join/leave
+    -- NULL for system actions, or once the actor's account is deleted
+    actor_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Indexes for paging through a room's history, optionally for one user
+-- Pages are keyed on id, which stays unique when events share a timestamp
+CREATE INDEX idx_room_membership_events_room ON room_membership_events(room_id, id DESC);
+CREATE INDEX idx_room_membership_events_room_user ON room_membership_events(room_id, user_id, id DESC);
@@ -0,0 +1,3 @@
+-- Drop attachment tables (references first, they point at attachments)
+DROP TABLE IF EXISTS attachment_refs;
+DROP TABLE IF EXISTS attachments;
@@ -0,0 +1,28 @@
+-- Create attachments table: one row per distinct uploaded file
+-- Files are stored by content hash, so identical uploads share a row and a blob
+CREATE TABLE IF NOT EXISTS attachments (
+    id BIGSERIAL PRIMARY KEY,
+    -- Hex SHA-256 of the file; the unique index is what stops two concurrent
+    -- uploads of the same new file from both creating a row
+    hash CHAR(64) NOT NULL,
+    size BIGINT NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    -- Number of attachment_refs pointing here; the blob is removed at zero
+    ref_count INT NOT NULL DEFAULT 1 CHECK (ref_count >= 0),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_attachments_hash ON attachments(hash);
+
+-- Create attachment_refs table: one row per upload, owned by the uploader
+-- Each keeps its own file name even when the bytes are shared
+CREATE TABLE IF NOT EXISTS attachment_refs (
+    id BIGSERIAL PRIMARY KEY,
+    attachment_id BIGINT NOT NULL REFERENCES attachments(id),
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    filename VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Index for finding the references to a blob
+CREATE INDEX idx_attachment_refs_attachment ON attachment_refs(attachment_id);
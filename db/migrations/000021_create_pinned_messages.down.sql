@@ -0,0 +1,3 @@
+-- Drop pinned messages and the rooms' pin version counter
+ALTER TABLE rooms DROP COLUMN IF EXISTS pins_version;
+DROP TABLE IF EXISTS pinned_messages;
@@ -0,0 +1,17 @@
+-- Create pinned_messages table: messages shown in a room's pinned bar
+-- position orders the bar; gaps are fine (unpinning leaves one) and a reorder renumbers from 1
+CREATE TABLE IF NOT EXISTS pinned_messages (
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    position INT NOT NULL,
+    pinned_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    pinned_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (room_id, message_id)
+);
+
+-- Index for listing a room's pins in order
+CREATE INDEX idx_pinned_messages_room_position ON pinned_messages(room_id, position);
+
+-- Bumped on every pin, unpin and reorder, so a reorder based on a stale
+-- view of the pins is refused instead of silently overwriting a newer one
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS pins_version BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+-- Drop the user directory columns and indexes
+-- The pg_trgm extension is left installed; other database objects may use it
+DROP INDEX IF EXISTS idx_users_display_name_trgm;
+DROP INDEX IF EXISTS idx_users_username_trgm;
+ALTER TABLE users DROP COLUMN IF EXISTS discoverable;
+ALTER TABLE users DROP COLUMN IF EXISTS display_name;
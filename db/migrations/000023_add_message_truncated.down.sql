@@ -0,0 +1,2 @@
+-- Drop the truncated flag from messages
+ALTER TABLE messages DROP COLUMN IF EXISTS truncated;
@@ -0,0 +1,3 @@
+-- Mark messages that were cut down to the length limit (MESSAGE_OVERSIZE_POLICY=truncate)
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS truncated BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,3 @@
+-- Drop the room_tags table (its index goes with it) and the copy on rooms
+ALTER TABLE rooms DROP COLUMN IF EXISTS tags;
+DROP TABLE IF EXISTS room_tags;
@@ -0,0 +1,14 @@
+-- Tags group rooms by topic for browsing (e.g. "gaming", "go-lang")
+-- Tags are stored lowercase; a room has at most 5, enforced by the application
+CREATE TABLE IF NOT EXISTS room_tags (
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    tag VARCHAR(30) NOT NULL CHECK (tag = LOWER(tag)),
+    PRIMARY KEY (room_id, tag)
+);
+
+-- Listing rooms by tag and counting rooms per tag both start from the tag
+CREATE INDEX IF NOT EXISTS idx_room_tags_tag ON room_tags(tag);
+
+-- A sorted copy of each room's tags on the room row, so reading a room
+-- doesn't aggregate room_tags every time; the store writes both together
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
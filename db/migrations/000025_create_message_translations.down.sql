@@ -0,0 +1,2 @@
+-- Drop the message_translations table
+DROP TABLE IF EXISTS message_translations;
@@ -0,0 +1,12 @@
+-- Create message_translations table: cached machine translations of chat messages
+-- source_hash is the SHA-256 of the content that was translated, so a cached
+-- translation no longer matching the message's content is treated as stale
+CREATE TABLE IF NOT EXISTS message_translations (
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    target_lang VARCHAR(10) NOT NULL,
+    source_lang VARCHAR(10) NOT NULL DEFAULT '',
+    source_hash CHAR(64) NOT NULL,
+    text TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (message_id, target_lang)
+);
@@ -0,0 +1,3 @@
+-- Drop quiet hours from rooms and the override flag from messages
+ALTER TABLE messages DROP COLUMN IF EXISTS quiet_override;
+ALTER TABLE rooms DROP COLUMN IF EXISTS quiet_hours;
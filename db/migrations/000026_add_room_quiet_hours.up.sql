@@ -0,0 +1,8 @@
+-- Quiet hours: a weekly window during which only room admins can post
+-- Stored as JSON ({"start": "18:00", "end": "08:00", "timezone": "Europe/Berlin", "days": ["mon"]}); NULL turns it off
+ALTER TABLE rooms
+    ADD COLUMN IF NOT EXISTS quiet_hours JSONB;
+
+-- Mark messages an admin posted during quiet hours
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS quiet_override BOOLEAN NOT NULL DEFAULT FALSE;
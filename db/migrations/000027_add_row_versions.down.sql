@@ -0,0 +1,3 @@
+-- Drop the optimistic locking version counters
+ALTER TABLE users DROP COLUMN IF EXISTS version;
+ALTER TABLE rooms DROP COLUMN IF EXISTS version;
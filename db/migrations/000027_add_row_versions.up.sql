@@ -0,0 +1,7 @@
+-- Version counters for optimistic locking: every update bumps them, and an
+-- update made against an older version is refused instead of overwriting
+ALTER TABLE rooms
+    ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
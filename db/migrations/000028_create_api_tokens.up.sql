@@ -0,0 +1,16 @@
+-- Create api_tokens table for personal access tokens
+-- Only the SHA-256 of a token is stored; the token itself is shown once at creation
+-- prefix keeps the first few characters so users can tell their tokens apart
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(20) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP,
+    expires_at TIMESTAMP
+);
+
+-- Index on user_id to list a user's tokens
+CREATE INDEX idx_api_tokens_user_id ON api_tokens(user_id);
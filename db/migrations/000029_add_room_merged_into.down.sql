@@ -0,0 +1,2 @@
+-- Drop the merged_into column
+ALTER TABLE rooms DROP COLUMN IF EXISTS merged_into;
@@ -0,0 +1,4 @@
+-- Rooms merged into another room are soft-deleted and point at the room that took them over
+-- A merged room can't be restored: its messages and members now belong to the target
+ALTER TABLE rooms
+    ADD COLUMN IF NOT EXISTS merged_into BIGINT REFERENCES rooms(id) ON DELETE SET NULL;
@@ -0,0 +1,3 @@
+-- Drop the room event log
+ALTER TABLE rooms DROP COLUMN IF EXISTS events_purged_seq;
+DROP TABLE IF EXISTS room_events CASCADE;
@@ -0,0 +1,21 @@
+-- Create room_events table: a log of the changes clients cache locally
+-- (membership, pins, room settings) so a reconnecting client can replay what it missed
+-- seq comes from one shared sequence; within a room it only ever grows
+CREATE TABLE IF NOT EXISTS room_events (
+    seq BIGSERIAL PRIMARY KEY,
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    event_type VARCHAR(32) NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Replaying a room's events from a sequence number
+CREATE INDEX idx_room_events_room_seq ON room_events(room_id, seq);
+
+-- Finding events past the retention period
+CREATE INDEX idx_room_events_created_at ON room_events(created_at);
+
+-- Highest sequence number purged from each room's log
+-- Clients asking for events before it must do a full resync
+ALTER TABLE rooms
+    ADD COLUMN IF NOT EXISTS events_purged_seq BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,7 @@
+-- Drop digest runs and settings
+DROP TABLE IF EXISTS digest_runs CASCADE;
+DROP INDEX IF EXISTS idx_users_digest_timezone;
+ALTER TABLE users
+    DROP COLUMN IF EXISTS digest_timezone,
+    DROP COLUMN IF EXISTS digest_hour,
+    DROP COLUMN IF EXISTS digest_enabled;
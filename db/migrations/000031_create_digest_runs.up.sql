@@ -0,0 +1,22 @@
+-- Daily digest email settings; digests are off until a user opts in
+-- digest_hour is the local hour (0-23) in digest_timezone after which the day's digest goes out
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS digest_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS digest_hour SMALLINT NOT NULL DEFAULT 8 CHECK (digest_hour BETWEEN 0 AND 23),
+    ADD COLUMN IF NOT EXISTS digest_timezone VARCHAR(64) NOT NULL DEFAULT 'UTC';
+
+-- Finding the opted-in users of each timezone
+CREATE INDEX IF NOT EXISTS idx_users_digest_timezone ON users(digest_timezone) WHERE digest_enabled;
+
+-- Create digest_runs table: one row per user per local day, inserted before the
+-- digest is built. The primary key is the claim: when several instances run the
+-- scheduler, only the one whose insert succeeds sends that day's digest
+CREATE TABLE IF NOT EXISTS digest_runs (
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    digest_date DATE NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'claimed' CHECK (status IN ('claimed', 'sent', 'skipped', 'failed')),
+    claimed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP,
+    error TEXT,
+    PRIMARY KEY (user_id, digest_date)
+);
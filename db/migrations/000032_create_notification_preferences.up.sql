@@ -0,0 +1,12 @@
+-- Create notification_preferences table: one row per (user, channel, event type)
+-- a user has changed. Cells without a row use the defaults in
+-- internal/store/notification_preferences.go, so new users need no rows
+-- and defaults can change without a data migration
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    channel VARCHAR(32) NOT NULL CHECK (channel IN ('websocket_flag', 'email', 'push')),
+    event_type VARCHAR(32) NOT NULL CHECK (event_type IN ('mention', 'dm', 'room_invite', 'announcement', 'digest')),
+    enabled BOOLEAN NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, channel, event_type)
+);
@@ -0,0 +1,2 @@
+-- Drop sessions
+DROP TABLE IF EXISTS sessions CASCADE;
@@ -0,0 +1,20 @@
+-- Create sessions table: one row per login, so users can see where they're
+-- signed in and sign out other devices. Login JWTs carry the session ID;
+-- deleting the row revokes the token and disconnects its WebSockets
+-- last_active_at is updated at most once a minute per session
+CREATE TABLE IF NOT EXISTS sessions (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    device_label VARCHAR(100) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_active_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- Listing a user's sessions, most recently active first
+CREATE INDEX IF NOT EXISTS idx_sessions_user_active ON sessions(user_id, last_active_at DESC);
+
+-- Purging idle sessions
+CREATE INDEX IF NOT EXISTS idx_sessions_last_active ON sessions(last_active_at);
@@ -0,0 +1,3 @@
+-- Drop report_events and reports
+DROP TABLE IF EXISTS report_events CASCADE;
+DROP TABLE IF EXISTS reports CASCADE;
@@ -0,0 +1,44 @@
+-- Create reports table for abuse reports on messages and users
+-- target_user_id is the reported user, or the author of a reported message
+-- message_content is the message as it was when reported, so later edits
+-- or deletions don't destroy the evidence
+CREATE TABLE IF NOT EXISTS reports (
+    id BIGSERIAL PRIMARY KEY,
+    reporter_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    target_type VARCHAR(10) NOT NULL CHECK (target_type IN ('message', 'user')),
+    target_user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    message_id BIGINT REFERENCES messages(id) ON DELETE SET NULL,
+    room_id BIGINT REFERENCES rooms(id) ON DELETE SET NULL,
+    message_content TEXT,
+    reason VARCHAR(20) NOT NULL CHECK (reason IN ('spam', 'harassment', 'other')),
+    details TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'reviewing', 'resolved', 'dismissed')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- A reporter can have only one open (or in review) report per target
+-- Closed reports don't count, so the same target can be reported again later
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reports_open_message
+    ON reports(reporter_id, message_id)
+    WHERE target_type = 'message' AND status IN ('open', 'reviewing');
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reports_open_user
+    ON reports(reporter_id, target_user_id)
+    WHERE target_type = 'user' AND status IN ('open', 'reviewing');
+
+-- Room creators list their room's reports, admins list by status; both newest first
+CREATE INDEX IF NOT EXISTS idx_reports_room ON reports(room_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_reports_status ON reports(status, id DESC);
+
+-- Create report_events table: the audit trail of status changes
+CREATE TABLE IF NOT EXISTS report_events (
+    id BIGSERIAL PRIMARY KEY,
+    report_id BIGINT NOT NULL REFERENCES reports(id) ON DELETE CASCADE,
+    from_status VARCHAR(20) NOT NULL,
+    to_status VARCHAR(20) NOT NULL,
+    actor VARCHAR(100) NOT NULL DEFAULT '',
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_report_events_report ON report_events(report_id, id);
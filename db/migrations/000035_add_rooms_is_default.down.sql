@@ -0,0 +1,3 @@
+-- Drop is_default from rooms
+DROP INDEX IF EXISTS idx_rooms_default;
+ALTER TABLE rooms DROP COLUMN IF EXISTS is_default;
@@ -0,0 +1,6 @@
+-- Add is_default to rooms: new accounts join every default room on registration
+-- Only platform admins can set it (POST /v1/admin/rooms/{id}/default)
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS is_default BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Registration looks up the few default rooms
+CREATE INDEX IF NOT EXISTS idx_rooms_default ON rooms(id) WHERE is_default;
@@ -0,0 +1,2 @@
+-- Drop room_templates
+DROP TABLE IF EXISTS room_templates CASCADE;
@@ -0,0 +1,11 @@
+-- Create room_templates table: named room settings a user can create rooms from
+-- settings is the JSON object the template was saved with, validated on save;
+-- fields this server doesn't know are kept and ignored when the template is used
+CREATE TABLE IF NOT EXISTS room_templates (
+    id BIGSERIAL PRIMARY KEY,
+    owner_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    settings JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (owner_id, name)
+);
@@ -0,0 +1,2 @@
+-- Drop room_role_permissions
+DROP TABLE IF EXISTS room_role_permissions CASCADE;
@@ -0,0 +1,11 @@
+-- Create room_role_permissions table: per-room overrides of what each role may do
+-- Only cells a room changed are stored; the rest come from the defaults in
+-- internal/store/room_permissions.go. role 'owner' is the room's creator
+CREATE TABLE IF NOT EXISTS room_role_permissions (
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'admin', 'member')),
+    capability VARCHAR(50) NOT NULL,
+    allowed BOOLEAN NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (room_id, role, capability)
+);
@@ -0,0 +1,2 @@
+-- Drop pending_email_invites
+DROP TABLE IF EXISTS pending_email_invites CASCADE;
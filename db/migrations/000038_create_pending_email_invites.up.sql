@@ -0,0 +1,20 @@
+-- Create pending_email_invites table: room invites sent to addresses with no account yet
+-- email is stored lowercased. Only the SHA-256 of the token mailed out is kept;
+-- registering with the token joins the account to every live invite of its address
+CREATE TABLE IF NOT EXISTS pending_email_invites (
+    id BIGSERIAL PRIMARY KEY,
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    invited_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    accepted_at TIMESTAMP,
+    accepted_by BIGINT REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- One open invite per room and address; inviting again refreshes it
+CREATE UNIQUE INDEX idx_pending_email_invites_open ON pending_email_invites(room_id, email) WHERE accepted_at IS NULL;
+
+-- Registration looks up an address's open invites
+CREATE INDEX idx_pending_email_invites_email ON pending_email_invites(email) WHERE accepted_at IS NULL;
@@ -0,0 +1,3 @@
+-- Drop moderated from messages and the room_moderation_hooks table
+ALTER TABLE messages DROP COLUMN IF EXISTS moderated;
+DROP TABLE IF EXISTS room_moderation_hooks CASCADE;
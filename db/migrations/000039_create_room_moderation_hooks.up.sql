@@ -0,0 +1,20 @@
+-- Create room_moderation_hooks table: a room's moderation bot, asked about every
+-- chat message before it's saved or broadcast. The secret signs the requests
+-- (HMAC-SHA256), so it's kept as is rather than hashed
+-- A hook that keeps failing is turned off: enabled is cleared and disabled_reason says why
+CREATE TABLE IF NOT EXISTS room_moderation_hooks (
+    room_id BIGINT PRIMARY KEY REFERENCES rooms(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    timeout_ms INTEGER NOT NULL DEFAULT 300 CHECK (timeout_ms BETWEEN 50 AND 2000),
+    fail_open BOOLEAN NOT NULL DEFAULT TRUE,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    disabled_reason TEXT,
+    disabled_at TIMESTAMP,
+    created_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Add moderated to messages: the room's moderation bot rewrote the content
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS moderated BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,8 @@
+-- Drop 2FA recovery codes and settings
+DROP TABLE IF EXISTS two_factor_recovery_codes;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS totp_last_step,
+    DROP COLUMN IF EXISTS two_factor_enabled_at,
+    DROP COLUMN IF EXISTS two_factor_enabled,
+    DROP COLUMN IF EXISTS totp_secret;
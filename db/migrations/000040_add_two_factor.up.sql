@@ -0,0 +1,19 @@
+-- Two-factor authentication (TOTP); off until a user sets it up and confirms a first code
+-- totp_secret is AES-GCM encrypted by the API, as it's needed in the clear to check codes
+-- totp_last_step is the time step of the last code accepted, so a code can't be used twice
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS totp_secret BYTEA,
+    ADD COLUMN IF NOT EXISTS two_factor_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS two_factor_enabled_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- Create two_factor_recovery_codes table: single-use codes for when the
+-- authenticator is lost. Only the SHA-256 of each code is stored; used_at is
+-- set when one is spent. Setting up 2FA again replaces the whole set
+CREATE TABLE IF NOT EXISTS two_factor_recovery_codes (
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, code_hash)
+);
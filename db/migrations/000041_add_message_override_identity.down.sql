@@ -0,0 +1,3 @@
+-- Drop override_username and override_avatar_url from messages
+ALTER TABLE messages DROP COLUMN IF EXISTS override_avatar_url;
+ALTER TABLE messages DROP COLUMN IF EXISTS override_username;
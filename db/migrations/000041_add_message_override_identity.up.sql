@@ -0,0 +1,5 @@
+-- Add override_username and override_avatar_url to messages: the name and
+-- avatar a bridge bot relayed a message under (puppet attribution)
+-- user_id stays the bot's, for permissions and auditing; NULL on everything else
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS override_username TEXT;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS override_avatar_url TEXT;
@@ -0,0 +1,21 @@
+-- Create feature_flags table: features rolled out gradually, without a deploy
+-- enabled is the default for users and rooms without an override; flags the
+-- code asks about that have no row here are off
+CREATE TABLE IF NOT EXISTS feature_flags (
+    name TEXT PRIMARY KEY,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Create feature_flag_overrides table: a flag turned on or off for one user
+-- or one room, whatever its default; each row names exactly one of the two
+CREATE TABLE IF NOT EXISTS feature_flag_overrides (
+    flag_name TEXT NOT NULL REFERENCES feature_flags(name) ON DELETE CASCADE,
+    user_id BIGINT REFERENCES users(id) ON DELETE CASCADE,
+    room_id BIGINT REFERENCES rooms(id) ON DELETE CASCADE,
+    enabled BOOLEAN NOT NULL,
+    CHECK ((user_id IS NULL) <> (room_id IS NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_feature_flag_overrides_user ON feature_flag_overrides(flag_name, user_id) WHERE user_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_feature_flag_overrides_room ON feature_flag_overrides(flag_name, room_id) WHERE room_id IS NOT NULL;
@@ -0,0 +1,6 @@
+-- Drop image dimensions and thumbnail state from attachments
+DROP INDEX IF EXISTS idx_attachments_thumbnail_pending;
+ALTER TABLE attachments DROP COLUMN IF EXISTS has_thumbnail;
+ALTER TABLE attachments DROP COLUMN IF EXISTS thumbnail_pending;
+ALTER TABLE attachments DROP COLUMN IF EXISTS height;
+ALTER TABLE attachments DROP COLUMN IF EXISTS width;
@@ -0,0 +1,12 @@
+-- Add image dimensions and thumbnail state to attachments
+-- width and height are read from the image header on upload; NULL for files
+-- that aren't images (or whose header couldn't be read)
+-- The thumbnail is stored next to the blob; thumbnail_pending is set while it's
+-- being made, has_thumbnail once it exists
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS width INT;
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS height INT;
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS thumbnail_pending BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS has_thumbnail BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Index for resuming thumbnails left pending by a restart
+CREATE INDEX IF NOT EXISTS idx_attachments_thumbnail_pending ON attachments(id) WHERE thumbnail_pending;
@@ -0,0 +1,11 @@
+-- Drop room languages and structured system messages
+-- Structured system messages get their English text back in content first
+UPDATE messages SET content = CASE system_event->>'event'
+		WHEN 'join' THEN (system_event->>'username') || ' joined the room'
+		WHEN 'leave' THEN (system_event->>'username') || ' left the room'
+		WHEN 'merged' THEN (system_event->>'source') || ' was merged into this room'
+		ELSE content
+	END
+WHERE system_event IS NOT NULL;
+ALTER TABLE messages DROP COLUMN IF EXISTS system_event;
+ALTER TABLE rooms DROP COLUMN IF EXISTS language;
@@ -0,0 +1,11 @@
+-- Add a language to rooms and store system messages as structured events
+-- language is a BCP 47 tag for the text the server renders in the room
+-- (system messages); NULL uses the server's DEFAULT_ROOM_LANGUAGE
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS language TEXT;
+
+-- System messages are saved as an event with parameters, e.g.
+-- {"event": "join", "username": "alice"}, and rendered in the room's language
+-- when they're read, so their content is empty. Rows saved before this keep
+-- their rendered text in content and have no event; they're shown as they are
+-- and marked legacy_system by the API
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS system_event JSONB;
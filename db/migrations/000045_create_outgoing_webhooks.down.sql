@@ -0,0 +1,3 @@
+-- Drop outgoing webhooks and their delivery logs
+DROP TABLE IF EXISTS outgoing_webhook_deliveries;
+DROP TABLE IF EXISTS outgoing_webhooks;
@@ -0,0 +1,41 @@
+-- Create outgoing_webhooks table: URLs a room's events are POSTed to for external
+-- automation, set up by the room's owner. The secret signs the requests
+-- (HMAC-SHA256), so it's kept as is rather than hashed. events is the allowlist
+-- of event types sent (message_created, member_joined, ...)
+-- event_seq is how far through the room's event log the webhook has been sent;
+-- an instance claims events by moving it forward, so each event is sent once
+-- A webhook that keeps failing is turned off: enabled is cleared and disabled_reason says why
+CREATE TABLE IF NOT EXISTS outgoing_webhooks (
+    id BIGSERIAL PRIMARY KEY,
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT[] NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    disabled_reason TEXT,
+    disabled_at TIMESTAMP,
+    event_seq BIGINT NOT NULL DEFAULT 0,
+    created_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_outgoing_webhooks_room ON outgoing_webhooks(room_id);
+
+-- Create outgoing_webhook_deliveries table: the outcome of every event sent to
+-- a webhook, after its retries; only the most recent are kept per webhook
+CREATE TABLE IF NOT EXISTS outgoing_webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    webhook_id BIGINT NOT NULL REFERENCES outgoing_webhooks(id) ON DELETE CASCADE,
+    event TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('delivered', 'failed')),
+    attempts INTEGER NOT NULL,
+    response_code INTEGER,
+    latency_ms INTEGER NOT NULL,
+    response_body TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_outgoing_webhook_deliveries_webhook ON outgoing_webhook_deliveries(webhook_id, id DESC);
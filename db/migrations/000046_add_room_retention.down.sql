@@ -0,0 +1,2 @@
+-- Drop per-room message retention
+ALTER TABLE rooms DROP COLUMN IF EXISTS retention_seconds;
@@ -0,0 +1,5 @@
+-- Add per-room message retention
+-- retention_seconds is how long the room's messages are kept: NULL uses the
+-- server's MESSAGE_RETENTION, 0 keeps them forever. Messages past it are
+-- deleted by the purge job, room by room (idx_messages_room_created)
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS retention_seconds BIGINT CHECK (retention_seconds >= 0);
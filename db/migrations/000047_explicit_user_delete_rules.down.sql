@@ -0,0 +1,20 @@
+-- Go back to the original rules: deleting a user deletes their messages and rooms
+-- Messages and rooms whose user was already deleted can't point at anyone
+-- again, so they're deleted first
+DELETE FROM messages WHERE user_id IS NULL;
+DELETE FROM rooms WHERE created_by IS NULL;
+
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_user_id_fkey;
+ALTER TABLE messages ALTER COLUMN user_id SET NOT NULL;
+ALTER TABLE messages ADD CONSTRAINT messages_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE messages DROP COLUMN IF EXISTS author_username;
+
+ALTER TABLE rooms DROP CONSTRAINT IF EXISTS rooms_created_by_fkey;
+ALTER TABLE rooms ALTER COLUMN created_by SET NOT NULL;
+ALTER TABLE rooms ADD CONSTRAINT rooms_created_by_fkey
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE room_members DROP CONSTRAINT IF EXISTS room_members_user_id_fkey;
+ALTER TABLE room_members ADD CONSTRAINT room_members_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
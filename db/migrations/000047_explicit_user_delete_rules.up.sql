@@ -0,0 +1,47 @@
+-- Make what happens when a user is deleted explicit and the same everywhere
+-- These foreign keys were created and changed over several migrations, so
+-- databases that got here along different paths disagreed: deleting a user
+-- either failed or took their messages and rooms with them. Each one is
+-- dropped under whatever name it has and added back with its documented rule:
+--   messages.user_id      SET NULL  the history stays, under author_username
+--   rooms.created_by      SET NULL  the room outlives its creator
+--   room_members.user_id  CASCADE   the memberships go with the user
+-- (There's no reactions table; the other tables referencing users already say
+-- what they do and are left alone)
+
+-- The author's username as it was when the message was sent, so history still
+-- shows who wrote it once the account is gone
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS author_username VARCHAR(255) NOT NULL DEFAULT '';
+
+UPDATE messages m
+SET author_username = u.username
+FROM users u
+WHERE u.id = m.user_id AND m.author_username = '';
+
+DO $$
+DECLARE
+    fk RECORD;
+BEGIN
+    FOR fk IN
+        SELECT c.conrelid::regclass AS tbl, c.conname
+        FROM pg_constraint c
+        INNER JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = ANY (c.conkey)
+        WHERE c.contype = 'f'
+          AND c.confrelid = 'users'::regclass
+          AND (c.conrelid::regclass::text, a.attname::text) IN
+              (('messages', 'user_id'), ('rooms', 'created_by'), ('room_members', 'user_id'))
+    LOOP
+        EXECUTE format('ALTER TABLE %s DROP CONSTRAINT %I', fk.tbl, fk.conname);
+    END LOOP;
+END $$;
+
+ALTER TABLE messages ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE messages ADD CONSTRAINT messages_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+
+ALTER TABLE rooms ALTER COLUMN created_by DROP NOT NULL;
+ALTER TABLE rooms ADD CONSTRAINT rooms_created_by_fkey
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL;
+
+ALTER TABLE room_members ADD CONSTRAINT room_members_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
@@ -0,0 +1,9 @@
+-- Drop message_redactions, putting the original content back first
+UPDATE messages m
+SET content = r.original_content, content_type = r.original_content_type, language = r.original_language
+FROM message_redactions r
+WHERE r.message_id = m.id;
+
+DROP INDEX IF EXISTS idx_messages_content_trgm;
+DROP TABLE IF EXISTS message_redactions CASCADE;
+ALTER TABLE messages DROP COLUMN IF EXISTS redacted_at;
@@ -0,0 +1,20 @@
+-- Create message_redactions table: messages platform admins removed
+-- The message's content is replaced by the redaction marker and redacted_at is
+-- set; the original is kept here, for admins only, in case the author appeals.
+-- It goes with the message when the message is deleted (room deletion, retention)
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS redacted_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS message_redactions (
+    id BIGSERIAL PRIMARY KEY,
+    message_id BIGINT NOT NULL UNIQUE REFERENCES messages(id) ON DELETE CASCADE,
+    original_content TEXT NOT NULL,
+    original_content_type VARCHAR(20) NOT NULL,
+    original_language VARCHAR(32),
+    actor VARCHAR(100) NOT NULL DEFAULT '',
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- The admin message view filters on content across every room; a trigram
+-- index lets its ILIKE '%text%' use an index instead of scanning every message
+CREATE INDEX IF NOT EXISTS idx_messages_content_trgm ON messages USING GIN (content gin_trgm_ops);
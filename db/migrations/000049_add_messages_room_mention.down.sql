@@ -0,0 +1,2 @@
+-- Drop room_mention from messages
+ALTER TABLE messages DROP COLUMN IF EXISTS room_mention;
@@ -0,0 +1,5 @@
+-- Add room_mention to messages: 'here' or 'room' when the message used @here
+-- or @room and its sender had the mention_everyone permission. @room counts as
+-- a mention of every member (unread mention counts, digests); NULL otherwise
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS room_mention VARCHAR(10)
+    CHECK (room_mention IN ('here', 'room'));
@@ -0,0 +1,2 @@
+-- Drop post_policy from rooms
+ALTER TABLE rooms DROP COLUMN IF EXISTS post_policy;
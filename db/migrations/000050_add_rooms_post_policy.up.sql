@@ -0,0 +1,6 @@
+-- Add post_policy to rooms: in "admins_only" rooms (announcement channels)
+-- only the owner and admins may send messages and everyone else reads;
+-- "everyone" leaves posting to the permission matrix alone
+ALTER TABLE rooms
+    ADD COLUMN IF NOT EXISTS post_policy VARCHAR(16) NOT NULL DEFAULT 'everyone'
+    CHECK (post_policy IN ('everyone', 'admins_only'));
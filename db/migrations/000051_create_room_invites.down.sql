@@ -0,0 +1,2 @@
+-- Drop room_invites
+DROP TABLE IF EXISTS room_invites CASCADE;
@@ -0,0 +1,18 @@
+-- Create room_invites table: invites of registered users to a room, which
+-- they accept or decline (from the REST inbox or over their WebSocket)
+CREATE TABLE IF NOT EXISTS room_invites (
+    id BIGSERIAL PRIMARY KEY,
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    invitee_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    invited_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'declined')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    responded_at TIMESTAMP
+);
+
+-- One open invite per room and user; inviting again refreshes it
+CREATE UNIQUE INDEX idx_room_invites_open ON room_invites(room_id, invitee_id) WHERE status = 'pending';
+
+-- A user's inbox of open invites
+CREATE INDEX idx_room_invites_invitee ON room_invites(invitee_id, id) WHERE status = 'pending';
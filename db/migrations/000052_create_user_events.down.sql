@@ -0,0 +1,2 @@
+-- Drop user_events
+DROP TABLE IF EXISTS user_events;
@@ -0,0 +1,15 @@
+-- Create user_events table: frames delivered to a user that were pushed out
+-- of the hub's in-memory per-user buffer (USER_EVENT_SPILL), kept for
+-- GET /v1/users/me/events until USER_EVENT_RETENTION has passed
+-- frame is the frame as it was sent, user_seq included; JSON rather than
+-- JSONB so it's replayed byte for byte
+CREATE TABLE IF NOT EXISTS user_events (
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_seq BIGINT NOT NULL,
+    frame JSON NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, user_seq)
+);
+
+-- Purging by age
+CREATE INDEX idx_user_events_created_at ON user_events(created_at);
@@ -0,0 +1,17 @@
+-- Create password_reset_tokens table: tokens mailed by POST /v1/auth/forgot-password
+-- Only the SHA-256 of the token is kept. A token works once, until expires_at
+-- (PASSWORD_RESET_TTL); asking again replaces the user's unused token
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+-- One unused token per user; asking again refreshes it
+CREATE UNIQUE INDEX idx_password_reset_tokens_open ON password_reset_tokens(user_id) WHERE used_at IS NULL;
+
+-- Purging expired tokens
+CREATE INDEX idx_password_reset_tokens_expires_at ON password_reset_tokens(expires_at);
@@ -0,0 +1,2 @@
+-- Drop oauth_identities
+DROP TABLE IF EXISTS oauth_identities;
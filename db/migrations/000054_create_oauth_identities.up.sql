@@ -0,0 +1,14 @@
+-- Create oauth_identities table: provider accounts (Google, GitHub) users
+-- sign in with. subject is the provider's stable ID for the account; email
+-- is the address it had when it was linked
+CREATE TABLE IF NOT EXISTS oauth_identities (
+    provider VARCHAR(32) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (provider, subject)
+);
+
+-- Listing and deleting a user's identities
+CREATE INDEX idx_oauth_identities_user_id ON oauth_identities(user_id);
@@ -0,0 +1,4 @@
+-- Drop server-wide roles and bans
+ALTER TABLE users
+    DROP COLUMN IF EXISTS banned_at,
+    DROP COLUMN IF EXISTS role;
@@ -0,0 +1,8 @@
+-- Server-wide roles: admins may delete any room and ban users; moderator sits
+-- between them and members (internal/store/user_roles.go). Rooms keep their own
+-- roles in room_members.role
+-- banned_at is set while a user is banned; a banned user can't sign in
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'member'
+    CHECK (role IN ('member', 'moderator', 'admin')),
+    ADD COLUMN IF NOT EXISTS banned_at TIMESTAMP;
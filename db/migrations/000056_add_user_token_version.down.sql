@@ -0,0 +1,3 @@
+-- Drop users' token versions
+ALTER TABLE users
+    DROP COLUMN IF EXISTS token_version;
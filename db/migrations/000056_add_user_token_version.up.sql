@@ -0,0 +1,4 @@
+-- Every access token carries the user's token_version from when it was
+-- issued; signing out everywhere (or a ban) bumps it, revoking older tokens
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS token_version BIGINT NOT NULL DEFAULT 0;
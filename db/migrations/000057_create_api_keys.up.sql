@@ -0,0 +1,16 @@
+-- Create api_keys table for bot API keys ("Authorization: ApiKey gcbot_...")
+-- Kept apart from api_tokens, so revoking a person's tokens (a password
+-- reset, a ban) doesn't take their bots down. Keys never expire; revoking
+-- one deletes its row. Only the SHA-256 of a key is stored
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(20) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP
+);
+
+-- Index on user_id to list a user's keys
+CREATE INDEX idx_api_keys_user_id ON api_keys(user_id);
@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL migration files, so the binaries that
+// run or check them always agree on the expected set
+package migrations
+
+import "embed"
+
+// FS holds every XXXXXX_name.up.sql and .down.sql file in this directory
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,107 @@
+// Command chatclient is a terminal chat client built on pkg/chatclient
+//
+// It joins a room, prints its messages and sends each line typed on stdin.
+// The connection survives network trouble: it reconnects by itself and
+// prints what was said while it was away. Ctrl-D or Ctrl-C quits
+//
+//	go run ./examples/chatclient -url http://localhost:8080 -room 1
+//
+// The token comes from -token or GOCHAT_TOKEN: a JWT from /v1/auth/login or
+// an API token
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/drazan344/go-chat/pkg/chatclient"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+func main() {
+	baseURL := flag.String("url", "http://localhost:8080", "the server's base URL")
+	token := flag.String("token", os.Getenv("GOCHAT_TOKEN"), "the token to authenticate with (default $GOCHAT_TOKEN)")
+	roomID := flag.Int64("room", 0, "the room to join")
+	flag.Parse()
+	if *token == "" || *roomID <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client, err := chatclient.Dial(ctx, *baseURL, *token,
+		chatclient.WithStateHandler(func(change chatclient.StateChange) {
+			if change.Err != nil {
+				log.Printf("room %d: %s (%v)", change.RoomID, change.State, change.Err)
+				return
+			}
+			log.Printf("room %d: %s", change.RoomID, change.State)
+		}),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	me := client.User()
+
+	client.Subscribe(chatclient.FrameMessage, func(m *wire.Message) {
+		at := time.Now()
+		if m.CreatedAt != nil {
+			at = *m.CreatedAt
+		}
+		fmt.Printf("%s <%s> %s\n", at.Local().Format("15:04"), m.Username, m.Content)
+	})
+	client.Subscribe(chatclient.FrameJoin, func(m *wire.Message) {
+		fmt.Printf("* %s joined\n", m.Username)
+	})
+	client.Subscribe(chatclient.FrameLeave, func(m *wire.Message) {
+		fmt.Printf("* %s left\n", m.Username)
+	})
+	client.Subscribe(chatclient.FrameError, func(m *wire.Message) {
+		fmt.Printf("! %s (%s)\n", m.Content, m.Code)
+	})
+
+	if err := client.JoinRoom(ctx, *roomID); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("joined room %d as %s", *roomID, me.Username)
+
+	// Lines are read on their own goroutine so Ctrl-C isn't stuck behind stdin
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				lines = nil
+				stop()
+				continue
+			}
+			if line == "" {
+				continue
+			}
+			if err := client.SendMessage(ctx, *roomID, line); err != nil {
+				fmt.Printf("! not sent: %v\n", err)
+			}
+		case <-ctx.Done():
+			shutdown, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			client.Shutdown(shutdown)
+			cancel()
+			return
+		}
+	}
+}
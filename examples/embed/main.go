@@ -0,0 +1,92 @@
+// Command embed shows go-chat embedded in another application
+//
+// The application has its own chi router and its own sign-in; here a
+// (pretend) session cookie names the user. The chat is mounted under /chat,
+// so its API is at /chat/v1/... and WebSockets connect to
+// /chat/v1/rooms/{roomID}/ws. Requests are authenticated by the application's
+// user resolver instead of go-chat's JWTs
+//
+// It reads the same environment (and .env) as the go-chat binary, and expects
+// the database to be migrated (make migrate-up)
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/drazan344/go-chat/chatapi"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	_ "github.com/lib/pq" // PostgreSQL driver
+)
+
+// sessionCookie stands in for the application's own session handling; it
+// holds the go-chat username of the signed-in user
+const sessionCookie = "app_session"
+
+func main() {
+	cfg, err := chatapi.LoadConfig(".env")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dbCfg := cfg.Database()
+	database, err := sql.Open("postgres", dbCfg.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer database.Close()
+	if err := chatapi.CheckSchema(database, false); err != nil {
+		log.Fatal(err)
+	}
+
+	pools := chatapi.NewPools(database, nil)
+	store := chatapi.NewPostgresStorage(pools, cfg.StoreLimits())
+	hub := chatapi.NewHub(store, cfg.HubShards())
+
+	// Map the application's signed-in user to their go-chat account
+	resolver := chatapi.UserResolverFunc(func(r *http.Request) (int64, error) {
+		cookie, err := r.Cookie(sessionCookie)
+		if err != nil {
+			return 0, errors.New("not signed in")
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+		user, err := store.Users.GetByUsername(ctx, cookie.Value)
+		if err != nil {
+			return 0, err
+		}
+		return user.ID, nil
+	})
+
+	chat, err := chatapi.New(cfg, store, hub,
+		chatapi.WithPools(pools),
+		chatapi.WithUserResolver(resolver),
+		chatapi.WithBasePath("/chat"),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	chat.Start(context.Background())
+
+	// The application's own router and routes
+	r := chi.NewRouter()
+	r.Use(middleware.Recoverer)
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("the application's home page; chat is under /chat\n"))
+	})
+	r.Get("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: r.URL.Query().Get("user"), Path: "/", HttpOnly: true})
+		w.Write([]byte("signed in\n"))
+	})
+
+	// chi strips the prefix before the chat handler sees the request
+	r.Mount("/chat", chat.Handler())
+
+	log.Printf("Example application listening on %s", cfg.Addr())
+	log.Fatal(http.ListenAndServe(cfg.Addr(), r))
+}
@@ -2,12 +2,13 @@ module github.com/drazan344/go-chat
 
 go 1.24.5
 
-require github.com/lib/pq v1.10.9
-
 require (
-	github.com/go-chi/chi/v5 v5.2.2 // indirect
-	github.com/golang-jwt/jwt/v5 v5.3.0 // indirect
-	github.com/gorilla/websocket v1.5.3 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	golang.org/x/crypto v0.46.0 // indirect
+	github.com/DATA-DOG/go-sqlmock v1.5.2
+	github.com/go-chi/chi/v5 v5.2.2
+	github.com/golang-jwt/jwt/v5 v5.3.0
+	github.com/gorilla/websocket v1.5.3
+	github.com/joho/godotenv v1.5.1
+	github.com/lib/pq v1.10.9
+	golang.org/x/crypto v0.46.0
+	golang.org/x/sync v0.19.0
 )
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// APITokenPrefix starts every personal access token
+// The prefix lets the auth middleware tell API tokens from JWTs without
+// parsing, and makes leaked tokens easy to spot in code and logs
+const APITokenPrefix = "gochat_"
+
+// apiTokenBytes is how much randomness a token carries (256 bits)
+const apiTokenBytes = 32
+
+// apiTokenDisplayChars is how many characters of the random part are kept
+// for display after the prefix, e.g. "gochat_3fa1c"
+const apiTokenDisplayChars = 5
+
+// APIKeyPrefix starts every bot API key, sent as "ApiKey <key>"
+// Keys are a separate credential from personal access tokens, so they
+// get their own prefix
+const APIKeyPrefix = "gcbot_"
+
+// GenerateAPIToken creates a new random personal access token
+// It returns the token to show the user (once), the display prefix and
+// the hash to store; the token itself must never be stored
+func GenerateAPIToken() (token, prefix, hash string, err error) {
+	return generateSecret(APITokenPrefix)
+}
+
+// GenerateAPIKey creates a new random bot API key, as GenerateAPIToken does
+// The key is hashed with HashAPIToken too
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	return generateSecret(APIKeyPrefix)
+}
+
+// generateSecret makes a random token with the given prefix, its display
+// prefix (the prefix plus 5 characters) and its hash
+func generateSecret(kind string) (token, prefix, hash string, err error) {
+	b := make([]byte, apiTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	token = kind + hex.EncodeToString(b)
+	return token, token[:len(kind)+apiTokenDisplayChars], HashAPIToken(token), nil
+}
+
+// HashAPIToken returns the hex SHA-256 of a token, the form it's stored and looked up in
+// Unlike passwords, tokens are long and random, so a fast hash is enough:
+// there's nothing to brute-force, and every request needs a lookup
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsAPIToken reports whether a bearer value is a personal access token rather than a JWT
+func IsAPIToken(bearer string) bool {
+	return strings.HasPrefix(bearer, APITokenPrefix)
+}
+
+// IsAPIKey reports whether a value is a bot API key
+func IsAPIKey(value string) bool {
+	return strings.HasPrefix(value, APIKeyPrefix)
+}
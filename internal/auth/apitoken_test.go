@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestGenerateAPIToken checks a token's shape and that only its hash and
+// prefix are needed to find and show it again
+func TestGenerateAPIToken(t *testing.T) {
+	token, prefix, hash, err := GenerateAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsAPIToken(token) || len(token) != len(APITokenPrefix)+64 {
+		t.Errorf("the token %q isn't gochat_ and 64 hex characters", token)
+	}
+	if !strings.HasPrefix(token, prefix) || len(prefix) != len(APITokenPrefix)+5 {
+		t.Errorf("the prefix %q doesn't start %q", prefix, token)
+	}
+	if hash != HashAPIToken(token) || len(hash) != 64 || strings.Contains(hash, token[len(APITokenPrefix):]) {
+		t.Errorf("the hash %q isn't the token's SHA-256", hash)
+	}
+
+	other, _, _, err := GenerateAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == token {
+		t.Error("two tokens came out the same")
+	}
+}
+
+// TestGenerateAPIKey checks a key has its own prefix, so it can't pass for a
+// personal access token
+func TestGenerateAPIKey(t *testing.T) {
+	key, prefix, hash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsAPIKey(key) || IsAPIToken(key) || len(key) != len(APIKeyPrefix)+64 {
+		t.Errorf("the key %q isn't gcbot_ and 64 hex characters", key)
+	}
+	if prefix != key[:len(APIKeyPrefix)+5] || hash != HashAPIToken(key) {
+		t.Errorf("got prefix %q and hash %q for %q", prefix, hash, key)
+	}
+}
+
+// TestIsAPIToken tells tokens from JWTs by their prefix alone
+func TestIsAPIToken(t *testing.T) {
+	for _, tc := range []struct {
+		bearer string
+		want   bool
+	}{
+		{"gochat_3fa1c0", true},
+		{"eyJhbGciOiJIUzI1NiJ9.e30.sig", false},
+		{"GOCHAT_3fa1c0", false},
+		{"", false},
+	} {
+		if got := IsAPIToken(tc.bearer); got != tc.want {
+			t.Errorf("IsAPIToken(%q) = %v, want %v", tc.bearer, got, tc.want)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"time"
@@ -40,10 +42,43 @@ func ComparePassword(hashedPassword, password string) error {
 	return nil
 }
 
+// TokenLifetime is how long a JWT from GenerateToken is valid
+const TokenLifetime = 24 * time.Hour
+
 // Claims represents the JWT token claims
 // Claims are the payload of the JWT containing user information
 type Claims struct {
 	UserID int64 `json:"user_id"`
+
+	// SessionID ties the token to a login session the user can revoke
+	// Tokens issued before sessions existed have none
+	SessionID int64 `json:"sid,omitempty"`
+
+	// TokenVersion is the user's token version when it was issued; signing out
+	// everywhere bumps the version, which revokes every older token
+	TokenVersion int64 `json:"tv,omitempty"`
+
+	// Purpose is empty for tokens that grant access; other tokens signed with
+	// the same secret, like TwoFactorClaims, set it so they're never accepted as one
+	Purpose string `json:"purpose,omitempty"`
+
+	jwt.RegisteredClaims
+}
+
+// TwoFactorPurpose marks the intermediate token of a two-step login
+const TwoFactorPurpose = "2fa"
+
+// TwoFactorTokenLifetime is how long the user has to enter their code after the password
+const TwoFactorTokenLifetime = 5 * time.Minute
+
+// TwoFactorClaims are the claims of the intermediate token a password login
+// returns when the account has 2FA on
+// It proves the password was right and nothing else: ParseToken refuses it,
+// so it can't be used on protected routes, only exchanged for a real token
+// along with a code
+type TwoFactorClaims struct {
+	UserID  int64  `json:"user_id"`
+	Purpose string `json:"purpose"` // Always TwoFactorPurpose
 	jwt.RegisteredClaims
 }
 
@@ -53,14 +88,18 @@ type Claims struct {
 //   - Header: token type and signing algorithm
 //   - Payload: claims (user data)
 //   - Signature: cryptographic signature to verify authenticity
-func GenerateToken(userID int64, secret string) (string, error) {
-	// Set token expiration to 24 hours from now
-	// In production, you might want shorter expiration (1-2 hours) with refresh tokens
-	expirationTime := time.Now().Add(24 * time.Hour)
+//
+// The token expires at expiresAt; sessions pass their own expiry so both end together
+// tokenVersion is the user's current token version (see Claims.TokenVersion)
+func GenerateToken(userID, sessionID, tokenVersion int64, expiresAt time.Time, secret string) (string, error) {
+	// In production, you might want a shorter expiration (1-2 hours) with refresh tokens
+	expirationTime := expiresAt
 
 	// Create the claims
 	claims := &Claims{
-		UserID: userID,
+		UserID:       userID,
+		SessionID:    sessionID,
+		TokenVersion: tokenVersion,
 		RegisteredClaims: jwt.RegisteredClaims{
 			// ExpiresAt: when the token expires
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
@@ -86,8 +125,17 @@ func GenerateToken(userID int64, secret string) (string, error) {
 }
 
 // ValidateToken validates a JWT token and returns the user ID
-// This is used by middleware to authenticate requests
 func ValidateToken(tokenString, secret string) (int64, error) {
+	claims, err := ParseToken(tokenString, secret)
+	if err != nil {
+		return 0, err
+	}
+	return claims.UserID, nil
+}
+
+// ParseToken validates a JWT token and returns its claims
+// This is used by middleware to authenticate requests
+func ParseToken(tokenString, secret string) (*Claims, error) {
 	// Parse the token with claims
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		// Verify that the signing method is HMAC
@@ -100,20 +148,197 @@ func ValidateToken(tokenString, secret string) (int64, error) {
 	})
 
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse token: %w", err)
+		return nil, fmt.Errorf("failed to parse token: %w", err)
 	}
 
 	// Extract and validate claims
+	// Tokens with a purpose (like a 2FA login's intermediate token) don't grant access
 	claims, ok := token.Claims.(*Claims)
-	if !ok || !token.Valid {
-		return 0, ErrInvalidToken
+	if !ok || !token.Valid || claims.Purpose != "" {
+		return nil, ErrInvalidToken
 	}
 
 	// Check if token has expired
 	// Note: jwt.ParseWithClaims already validates expiration, but we double-check
 	if claims.ExpiresAt != nil && claims.ExpiresAt.Before(time.Now()) {
-		return 0, ErrExpiredToken
+		return nil, ErrExpiredToken
+	}
+
+	return claims, nil
+}
+
+// GenerateTwoFactorToken creates the intermediate token of a two-step login
+// It expires after TwoFactorTokenLifetime
+func GenerateTwoFactorToken(userID int64, secret string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(TwoFactorTokenLifetime)
+	claims := &TwoFactorClaims{
+		UserID:  userID,
+		Purpose: TwoFactorPurpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    "go-chat",
+		},
+	}
+
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign token: %w", err)
+	}
+	return tokenString, expiresAt, nil
+}
+
+// ParseTwoFactorToken validates an intermediate token and returns its user ID
+// Access tokens are refused, so one can't stand in for a password check
+func ParseTwoFactorToken(tokenString, secret string) (int64, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &TwoFactorClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(secret), nil
+	})
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return 0, ErrExpiredToken
+		}
+		return 0, fmt.Errorf("failed to parse token: %w", err)
 	}
 
+	claims, ok := token.Claims.(*TwoFactorClaims)
+	if !ok || !token.Valid || claims.Purpose != TwoFactorPurpose {
+		return 0, ErrInvalidToken
+	}
 	return claims.UserID, nil
 }
+
+// RoomDeletionPurpose marks the token confirming a room's deletion
+const RoomDeletionPurpose = "delete_room"
+
+// RoomDeletionTokenLifetime is how long the owner has to confirm a room's deletion
+const RoomDeletionTokenLifetime = 10 * time.Minute
+
+// RoomDeletionClaims are the claims of the token a deletion request returns
+// It's bound to one room, as it was when the deletion was requested (its
+// version), and to the user who requested it. ParseToken refuses it like
+// any token with a purpose
+type RoomDeletionClaims struct {
+	UserID      int64  `json:"user_id"`
+	RoomID      int64  `json:"room_id"`
+	RoomVersion int64  `json:"room_version"`
+	Purpose     string `json:"purpose"` // Always RoomDeletionPurpose
+	jwt.RegisteredClaims
+}
+
+// GenerateRoomDeletionToken creates the token confirming a room's deletion
+// It expires after RoomDeletionTokenLifetime
+func GenerateRoomDeletionToken(userID, roomID, roomVersion int64, secret string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(RoomDeletionTokenLifetime)
+	claims := &RoomDeletionClaims{
+		UserID:      userID,
+		RoomID:      roomID,
+		RoomVersion: roomVersion,
+		Purpose:     RoomDeletionPurpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    "go-chat",
+		},
+	}
+
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign token: %w", err)
+	}
+	return tokenString, expiresAt, nil
+}
+
+// ParseRoomDeletionToken validates a deletion confirmation token and returns its claims
+// The caller checks they match the room and the user; an expired token is ErrExpiredToken
+func ParseRoomDeletionToken(tokenString, secret string) (*RoomDeletionClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &RoomDeletionClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(secret), nil
+	})
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrExpiredToken
+		}
+		return nil, fmt.Errorf("failed to parse token: %w", err)
+	}
+
+	claims, ok := token.Claims.(*RoomDeletionClaims)
+	if !ok || !token.Valid || claims.Purpose != RoomDeletionPurpose {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// OAuthStatePurpose marks the state of an OAuth login
+const OAuthStatePurpose = "oauth_state"
+
+// OAuthStateLifetime is how long the user has to sign in at the provider
+const OAuthStateLifetime = 10 * time.Minute
+
+// OAuthStateClaims are the claims of the state an OAuth login sends to the
+// provider and gets back on the callback
+// It's bound to the provider, and its ID makes every state different, so the
+// callback can check it's the one this browser was given (see oauth.go in chatapi)
+type OAuthStateClaims struct {
+	Provider string `json:"provider"`
+	Purpose  string `json:"purpose"` // Always OAuthStatePurpose
+	jwt.RegisteredClaims
+}
+
+// GenerateOAuthState creates the state of an OAuth login with a provider
+// It expires after OAuthStateLifetime
+func GenerateOAuthState(provider, secret string) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate state: %w", err)
+	}
+
+	now := time.Now()
+	claims := &OAuthStateClaims{
+		Provider: provider,
+		Purpose:  OAuthStatePurpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(nonce),
+			ExpiresAt: jwt.NewNumericDate(now.Add(OAuthStateLifetime)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    "go-chat",
+		},
+	}
+
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign state: %w", err)
+	}
+	return tokenString, nil
+}
+
+// ParseOAuthState validates the state of an OAuth login with a provider
+// A state for another provider, or any other token, is ErrInvalidToken
+func ParseOAuthState(tokenString, provider, secret string) error {
+	token, err := jwt.ParseWithClaims(tokenString, &OAuthStateClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(secret), nil
+	})
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return ErrExpiredToken
+		}
+		return fmt.Errorf("failed to parse state: %w", err)
+	}
+
+	claims, ok := token.Claims.(*OAuthStateClaims)
+	if !ok || !token.Valid || claims.Purpose != OAuthStatePurpose || claims.Provider != provider {
+		return ErrInvalidToken
+	}
+	return nil
+}
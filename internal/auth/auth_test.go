@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TestRoomDeletionToken checks a deletion confirmation carries its room,
+// version and user, isn't accepted as an access token or the other way
+// round, and is told apart once expired
+func TestRoomDeletionToken(t *testing.T) {
+	const secret = "jwt-secret"
+	confirmation, expiresAt, err := GenerateRoomDeletionToken(7, 3, 12, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(expiresAt); d <= 0 || d > RoomDeletionTokenLifetime {
+		t.Errorf("the token expires in %s, want within %s", d, RoomDeletionTokenLifetime)
+	}
+	claims, err := ParseRoomDeletionToken(confirmation, secret)
+	if err != nil || claims.UserID != 7 || claims.RoomID != 3 || claims.RoomVersion != 12 {
+		t.Errorf("parsing the confirmation got %+v, %v", claims, err)
+	}
+	if _, err := ParseToken(confirmation, secret); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ParseToken accepted the confirmation: %v", err)
+	}
+
+	access, err := GenerateToken(7, 1, 0, time.Now().Add(time.Hour), secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseRoomDeletionToken(access, secret); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ParseRoomDeletionToken accepted an access token: %v", err)
+	}
+	pending, _, err := GenerateTwoFactorToken(7, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseRoomDeletionToken(pending, secret); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ParseRoomDeletionToken accepted a two-factor token: %v", err)
+	}
+
+	expired := &RoomDeletionClaims{
+		UserID: 7, RoomID: 3, RoomVersion: 12, Purpose: RoomDeletionPurpose,
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute))},
+	}
+	stale, err := jwt.NewWithClaims(jwt.SigningMethodHS256, expired).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseRoomDeletionToken(stale, secret); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("parsing an expired confirmation got %v, want ErrExpiredToken", err)
+	}
+	if _, err := ParseRoomDeletionToken(confirmation, "other-secret"); err == nil {
+		t.Error("a confirmation signed with another secret was accepted")
+	}
+}
+
+// TestOAuthState checks a state is accepted for its provider only, is
+// different every time, and isn't accepted as an access token
+func TestOAuthState(t *testing.T) {
+	const secret = "jwt-secret"
+	state, err := GenerateOAuthState("github", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ParseOAuthState(state, "github", secret); err != nil {
+		t.Errorf("parsing the state got %v", err)
+	}
+	if err := ParseOAuthState(state, "google", secret); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("another provider's state got %v, want ErrInvalidToken", err)
+	}
+	if again, _ := GenerateOAuthState("github", secret); again == state {
+		t.Error("two logins got the same state")
+	}
+	if _, err := ParseToken(state, secret); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ParseToken accepted the state: %v", err)
+	}
+
+	access, err := GenerateToken(7, 1, 0, time.Now().Add(time.Hour), secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ParseOAuthState(access, "github", secret); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ParseOAuthState accepted an access token: %v", err)
+	}
+}
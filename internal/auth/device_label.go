@@ -0,0 +1,63 @@
+package auth
+
+import "strings"
+
+// DeviceLabel turns a User-Agent header into a short label for a sessions
+// screen, like "Firefox on Windows" or "curl"
+// It only knows the common browsers and systems; anything else is "Unknown device"
+func DeviceLabel(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	// Scripts and command-line tools name themselves first
+	for _, tool := range []struct{ token, label string }{
+		{"curl/", "curl"},
+		{"wget/", "Wget"},
+		{"go-http-client", "Go HTTP client"},
+		{"python-requests", "Python requests"},
+		{"postmanruntime", "Postman"},
+	} {
+		if strings.HasPrefix(ua, tool.token) {
+			return tool.label
+		}
+	}
+
+	// Order matters: Edge and Opera also claim to be Chrome, and Chrome claims to be Safari
+	browser := ""
+	switch {
+	case strings.Contains(ua, "edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "opr/"):
+		browser = "Opera"
+	case strings.Contains(ua, "firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "chrome/") || strings.Contains(ua, "crios/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "safari/"):
+		browser = "Safari"
+	}
+
+	// iOS and Android before macOS and Linux, which their user agents also mention
+	system := ""
+	switch {
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad"):
+		system = "iOS"
+	case strings.Contains(ua, "android"):
+		system = "Android"
+	case strings.Contains(ua, "windows"):
+		system = "Windows"
+	case strings.Contains(ua, "mac os x") || strings.Contains(ua, "macintosh"):
+		system = "macOS"
+	case strings.Contains(ua, "linux"):
+		system = "Linux"
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system + " device"
+	}
+	return "Unknown device"
+}
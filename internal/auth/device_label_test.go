@@ -0,0 +1,30 @@
+package auth
+
+import "testing"
+
+// TestDeviceLabel labels real User-Agent headers, including browsers that
+// claim to be other browsers
+func TestDeviceLabel(t *testing.T) {
+	for _, tc := range []struct {
+		userAgent string
+		want      string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:128.0) Gecko/20100101 Firefox/128.0", "Firefox on Windows"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36 Edg/126.0.0.0", "Edge on Windows"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36 OPR/111.0.0.0", "Opera on macOS"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_5) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Safari/605.1.15", "Safari on macOS"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/126.0.6478.54 Mobile/15E148 Safari/604.1", "Chrome on iOS"},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:127.0) Gecko/20100101 Firefox/127.0", "Firefox on Linux"},
+		{"curl/8.5.0", "curl"},
+		{"Go-http-client/2.0", "Go HTTP client"},
+		{"python-requests/2.32.3", "Python requests"},
+		{"Dalvik/2.1.0 (Linux; U; Android 14; Pixel 8)", "Android device"},
+		{"MyChatBot/1.0", "Unknown device"},
+		{"", "Unknown device"},
+	} {
+		if got := DeviceLabel(tc.userAgent); got != tc.want {
+			t.Errorf("DeviceLabel(%q) = %q, want %q", tc.userAgent, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// InviteTokenPrefix starts every email invite token, so they can't be
+// mistaken for personal access tokens
+const InviteTokenPrefix = "gcinv_"
+
+// GenerateInviteToken creates a new random token for an email invite
+// It returns the token to put in the email and the hash to store; like API
+// tokens, only the hash is kept (see HashAPIToken)
+func GenerateInviteToken() (token, hash string, err error) {
+	b := make([]byte, apiTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	token = InviteTokenPrefix + hex.EncodeToString(b)
+	return token, HashAPIToken(token), nil
+}
+
+// PasswordResetTokenPrefix starts every password reset token
+const PasswordResetTokenPrefix = "gcrst_"
+
+// GeneratePasswordResetToken creates a new random token for a password reset email
+// It returns the token to put in the email and the hash to store, as for invites
+func GeneratePasswordResetToken() (token, hash string, err error) {
+	b := make([]byte, apiTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	token = PasswordResetTokenPrefix + hex.EncodeToString(b)
+	return token, HashAPIToken(token), nil
+}
@@ -0,0 +1,323 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Password policy violation codes
+// Each doubles as the error catalog key the API reports it under
+const (
+	ReasonTooShort = "password_too_short"         // Args: the minimum length
+	ReasonTooWeak  = "password_too_weak"          // Too predictable, see estimateEntropy
+	ReasonPersonal = "password_contains_personal" // Contains the username or email
+	ReasonBreached = "password_breached"          // Found in a known data breach
+	ReasonCommon   = "password_common"            // In the configured dictionary
+
+	ReasonNeedsLower  = "password_needs_lower"  // No lowercase letter
+	ReasonNeedsUpper  = "password_needs_upper"  // No uppercase letter
+	ReasonNeedsDigit  = "password_needs_digit"  // No digit
+	ReasonNeedsSymbol = "password_needs_symbol" // No symbol
+)
+
+// Character classes a policy can require
+const (
+	ClassLower  = "lower"
+	ClassUpper  = "upper"
+	ClassDigit  = "digit"
+	ClassSymbol = "symbol" // Anything that isn't a letter or digit, spaces included
+)
+
+// PasswordClasses lists every character class
+var PasswordClasses = []string{ClassLower, ClassUpper, ClassDigit, ClassSymbol}
+
+// classReasons maps each class to the violation for missing it, in the
+// order they're reported
+var classReasons = []struct{ class, reason string }{
+	{ClassLower, ReasonNeedsLower},
+	{ClassUpper, ReasonNeedsUpper},
+	{ClassDigit, ReasonNeedsDigit},
+	{ClassSymbol, ReasonNeedsSymbol},
+}
+
+const (
+	// DefaultMinPasswordLength is the minimum length when none is configured
+	DefaultMinPasswordLength = 10
+
+	// minEntropyBits is the weakest estimated strength accepted
+	// Ten characters of lowercase letters alone just clear it; "aaaaaaaaaa" doesn't
+	minEntropyBits = 40
+
+	// minPersonalLength is how long a username or email part must be before
+	// we refuse passwords containing it; shorter ones match too much by accident
+	minPersonalLength = 3
+
+	// DefaultBreachAPI is the HaveIBeenPwned range API
+	DefaultBreachAPI = "https://api.pwnedpasswords.com/range/"
+)
+
+// PasswordUser is what the policy knows about the account a password is for
+type PasswordUser struct {
+	Username string
+	Email    string
+}
+
+// PasswordViolation is one rule a password broke
+type PasswordViolation struct {
+	Reason string        // One of the Reason* codes
+	Args   []interface{} // Values for the reason's message, if any
+}
+
+// PasswordPolicy decides whether a password is acceptable
+// The zero value checks the default minimum length, strength and personal
+// details, and requires no character classes
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters (not bytes)
+	MinLength int
+
+	// RequiredClasses lists the character classes (Class*) every password
+	// must use, e.g. from ParsePasswordClasses
+	RequiredClasses []string
+
+	// Dictionary refuses the passwords it holds, such as a list of common or
+	// breached ones; checked locally, unlike BreachClient
+	Dictionary PasswordDictionary
+
+	// BreachClient enables the breached-password check when set
+	// Its Timeout bounds how long registration waits for the API
+	BreachClient *http.Client
+
+	// BreachAPI is the range API's base URL; the 5 character hash prefix is appended
+	BreachAPI string
+}
+
+// PolicyCheck returns every rule the password breaks, or nil if it's acceptable
+// All local rules are always checked, so the user sees every problem at once
+// The breach check runs last and only for passwords that pass everything else
+func (p *PasswordPolicy) PolicyCheck(ctx context.Context, password string, user PasswordUser) []PasswordViolation {
+	var violations []PasswordViolation
+
+	minLength := p.MinLength
+	if minLength <= 0 {
+		minLength = DefaultMinPasswordLength
+	}
+	if utf8.RuneCountInString(password) < minLength {
+		violations = append(violations, PasswordViolation{Reason: ReasonTooShort, Args: []interface{}{minLength}})
+	}
+
+	if len(p.RequiredClasses) > 0 {
+		used := characterClasses(password)
+		for _, c := range classReasons {
+			if !used[c.class] && slices.Contains(p.RequiredClasses, c.class) {
+				violations = append(violations, PasswordViolation{Reason: c.reason})
+			}
+		}
+	}
+
+	if estimateEntropy(password) < minEntropyBits {
+		violations = append(violations, PasswordViolation{Reason: ReasonTooWeak})
+	}
+
+	if containsPersonal(password, user) {
+		violations = append(violations, PasswordViolation{Reason: ReasonPersonal})
+	}
+
+	if p.Dictionary.Contains(password) {
+		violations = append(violations, PasswordViolation{Reason: ReasonCommon})
+	}
+
+	if len(violations) == 0 && p.BreachClient != nil {
+		if p.isBreached(ctx, password) {
+			violations = append(violations, PasswordViolation{Reason: ReasonBreached})
+		}
+	}
+
+	return violations
+}
+
+// estimateEntropy gives a rough strength in bits from length and character classes
+// Bits per character come from the size of the classes used (lowercase,
+// uppercase, digits, symbols); repeated characters only count up to twice the
+// number of distinct ones, so "abababababab" scores like "abab"
+// This is a heuristic, not zxcvbn: it doesn't know dictionary words, which is
+// what the breach check is for
+func estimateEntropy(password string) float64 {
+	distinct := make(map[rune]bool)
+	for _, r := range password {
+		distinct[r] = true
+	}
+
+	used := characterClasses(password)
+	pool := 0
+	if used[ClassLower] {
+		pool += 26
+	}
+	if used[ClassUpper] {
+		pool += 26
+	}
+	if used[ClassDigit] {
+		pool += 10
+	}
+	if used[ClassSymbol] {
+		pool += 33
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	length := utf8.RuneCountInString(password)
+	if limit := 2 * len(distinct); length > limit {
+		length = limit
+	}
+	return float64(length) * math.Log2(float64(pool))
+}
+
+// characterClasses returns the classes (Class*) the password uses
+func characterClasses(password string) map[string]bool {
+	used := make(map[string]bool, len(PasswordClasses))
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			used[ClassLower] = true
+		case unicode.IsUpper(r):
+			used[ClassUpper] = true
+		case unicode.IsDigit(r):
+			used[ClassDigit] = true
+		default:
+			used[ClassSymbol] = true
+		}
+	}
+	return used
+}
+
+// ParsePasswordClasses parses a comma separated list of character classes,
+// e.g. "upper,digit"; an empty string requires none
+func ParsePasswordClasses(s string) ([]string, error) {
+	var classes []string
+	for _, field := range strings.Split(s, ",") {
+		class := strings.ToLower(strings.TrimSpace(field))
+		if class == "" {
+			continue
+		}
+		if !slices.Contains(PasswordClasses, class) {
+			return nil, fmt.Errorf("unknown character class %q (want lower, upper, digit or symbol)", class)
+		}
+		if !slices.Contains(classes, class) {
+			classes = append(classes, class)
+		}
+	}
+	return classes, nil
+}
+
+// PasswordDictionary is a set of refused passwords, compared ignoring case
+type PasswordDictionary map[string]struct{}
+
+// LoadPasswordDictionary reads one password per line
+// Blank lines and lines starting with '#' are skipped
+func LoadPasswordDictionary(r io.Reader) (PasswordDictionary, error) {
+	dictionary := make(PasswordDictionary)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		dictionary[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return dictionary, nil
+}
+
+// Contains reports whether the password is in the dictionary, ignoring case
+// A nil dictionary holds nothing
+func (d PasswordDictionary) Contains(password string) bool {
+	_, ok := d[strings.ToLower(password)]
+	return ok
+}
+
+// containsPersonal reports whether the password contains the username, the
+// email address or its local part, ignoring case
+func containsPersonal(password string, user PasswordUser) bool {
+	lowered := strings.ToLower(password)
+
+	candidates := []string{user.Username, user.Email}
+	if local, _, found := strings.Cut(user.Email, "@"); found {
+		candidates = append(candidates, local)
+	}
+
+	for _, c := range candidates {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if utf8.RuneCountInString(c) >= minPersonalLength && strings.Contains(lowered, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBreached asks the range API whether the password appears in known breaches
+// Only the first 5 hex characters of the SHA-1 leave the server (k-anonymity);
+// the API answers with every suffix sharing that prefix and the match happens here
+// Any failure counts as "not breached": an unreachable API must not block sign-ups
+func (p *PasswordPolicy) isBreached(ctx context.Context, password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	base := p.BreachAPI
+	if base == "" {
+		base = DefaultBreachAPI
+	}
+
+	// Don't hold the request up longer than the client allows even if it has no timeout
+	if p.BreachClient.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.BreachClient.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+prefix, nil)
+	if err != nil {
+		log.Printf("Breached password check skipped: %v", err)
+		return false
+	}
+	// Padding hides the real number of matches from anyone watching response sizes
+	req.Header.Set("Add-Padding", "true")
+
+	resp, err := p.BreachClient.Do(req)
+	if err != nil {
+		log.Printf("Breached password check skipped: %v", err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Breached password check skipped: API returned %s", resp.Status)
+		return false
+	}
+
+	// Each line is "SUFFIX:COUNT"; padding lines have a count of 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		lineSuffix, count, found := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if found && strings.EqualFold(lineSuffix, suffix) && count != "0" {
+			return true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Breached password check skipped: %v", err)
+	}
+	return false
+}
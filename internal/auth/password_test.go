@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain silences the fail-open log lines
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// reasons returns the codes of the violations, in order
+func reasons(violations []PasswordViolation) []string {
+	var codes []string
+	for _, v := range violations {
+		codes = append(codes, v.Reason)
+	}
+	return codes
+}
+
+// TestPolicyRules checks each local rule on its own and that every broken
+// rule is reported together
+func TestPolicyRules(t *testing.T) {
+	ada := PasswordUser{Username: "ada", Email: "lovelace@example.com"}
+	policy := &PasswordPolicy{}
+
+	for _, tc := range []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{"strong", "correct-Horse-42", nil},
+		{"counts characters, not bytes", "ĉeĥoslovakĵo", nil},
+		{"too short", "Xk9#pQ2&z", []string{ReasonTooShort}},
+		{"one repeated character", "aaaaaaaaaaaa", []string{ReasonTooWeak}},
+		{"short repeated pattern", "abababababab", []string{ReasonTooWeak}},
+		{"contains the username", "my-ADA-Secret-9", []string{ReasonPersonal}},
+		{"contains the email's local part", "Lovelace#2024x", []string{ReasonPersonal}},
+		{"everything wrong", "ada", []string{ReasonTooShort, ReasonTooWeak, ReasonPersonal}},
+	} {
+		got := reasons(policy.PolicyCheck(context.Background(), tc.password, ada))
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: %q broke %v, want %v", tc.name, tc.password, got, tc.want)
+		}
+	}
+}
+
+// TestPolicyMinLength checks the configured minimum replaces the default
+// and is passed on for the message
+func TestPolicyMinLength(t *testing.T) {
+	policy := &PasswordPolicy{MinLength: 20}
+	violations := policy.PolicyCheck(context.Background(), "correct-Horse-42", PasswordUser{})
+	if len(violations) != 1 || violations[0].Reason != ReasonTooShort || violations[0].Args[0] != 20 {
+		t.Errorf("got %+v, want too short with a minimum of 20", violations)
+	}
+}
+
+// TestPolicyClasses requires character classes: each one missing is its own
+// violation, reported along with the other rules
+func TestPolicyClasses(t *testing.T) {
+	policy := &PasswordPolicy{RequiredClasses: []string{ClassUpper, ClassDigit, ClassSymbol}}
+	for _, tc := range []struct {
+		password string
+		want     []string
+	}{
+		{"correct-Horse-42", nil},
+		{"correct horse 42", []string{ReasonNeedsUpper}},
+		{"correcthorsebattery", []string{ReasonNeedsUpper, ReasonNeedsDigit, ReasonNeedsSymbol}},
+		{"aB1", []string{ReasonTooShort, ReasonNeedsSymbol, ReasonTooWeak}},
+	} {
+		got := reasons(policy.PolicyCheck(context.Background(), tc.password, PasswordUser{}))
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%q broke %v, want %v", tc.password, got, tc.want)
+		}
+	}
+}
+
+// TestParsePasswordClasses accepts a list in any case with repeats and
+// blanks, and refuses unknown classes
+func TestParsePasswordClasses(t *testing.T) {
+	classes, err := ParsePasswordClasses(" Upper, digit,,upper ")
+	if err != nil || !slices.Equal(classes, []string{ClassUpper, ClassDigit}) {
+		t.Errorf("got %v (%v), want upper and digit", classes, err)
+	}
+	if classes, err := ParsePasswordClasses(""); err != nil || classes != nil {
+		t.Errorf("an empty list got %v (%v), want none", classes, err)
+	}
+	if _, err := ParsePasswordClasses("upper,emoji"); err == nil {
+		t.Error("an unknown class was accepted")
+	}
+}
+
+// TestPasswordDictionary refuses listed passwords whatever their case; the
+// check runs with the other rules
+func TestPasswordDictionary(t *testing.T) {
+	dictionary, err := LoadPasswordDictionary(strings.NewReader("# Common passwords\n\nCorrect-Horse-42\n  Tr0ub4dor&3  \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dictionary) != 2 {
+		t.Errorf("loaded %d passwords, want 2", len(dictionary))
+	}
+	policy := &PasswordPolicy{Dictionary: dictionary}
+	for _, tc := range []struct {
+		password string
+		want     []string
+	}{
+		{"correct-horse-42", []string{ReasonCommon}},
+		{"tr0ub4dor&3", []string{ReasonCommon}},
+		{"correct-Horse-43", nil},
+	} {
+		got := reasons(policy.PolicyCheck(context.Background(), tc.password, PasswordUser{}))
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%q broke %v, want %v", tc.password, got, tc.want)
+		}
+	}
+}
+
+// TestContainsPersonal checks short names are ignored, since they'd match
+// too many passwords by accident
+func TestContainsPersonal(t *testing.T) {
+	for _, tc := range []struct {
+		user PasswordUser
+		want bool
+	}{
+		{PasswordUser{Username: "al", Email: "al@example.com"}, false},
+		{PasswordUser{Username: " Grace ", Email: "g@example.com"}, true},
+		{PasswordUser{Username: "linus", Email: "penguin@example.com"}, true},
+		{PasswordUser{Username: "ken", Email: "thompson@example.com"}, false},
+	} {
+		if got := containsPersonal("graces-penguin", tc.user); got != tc.want {
+			t.Errorf("containsPersonal with %+v = %v, want %v", tc.user, got, tc.want)
+		}
+	}
+}
+
+// breachServer answers range requests as the breach API does, listing
+// suffixes (with their counts) for any prefix, and records every request
+// it gets as its path and headers
+func breachServer(t *testing.T, status int, lines ...string) (*httptest.Server, *[]string) {
+	t.Helper()
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, fmt.Sprint(r.URL.String(), " ", r.Header))
+		w.WriteHeader(status)
+		io.WriteString(w, strings.Join(lines, "\r\n"))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// TestBreachCheck looks a password up in a stubbed range API: it's refused
+// only if its suffix is listed with a count, and neither the request nor
+// its headers carry more than the 5 character prefix
+func TestBreachCheck(t *testing.T) {
+	const password = "correct-Horse-42"
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	for _, tc := range []struct {
+		name  string
+		lines []string
+		want  bool
+	}{
+		{"listed", []string{"0018A45C4D1DEF81644B54AB7F969B88D65:1", suffix + ":3"}, true},
+		{"listed in lowercase", []string{strings.ToLower(suffix) + ":12"}, true},
+		{"padding only", []string{suffix + ":0"}, false},
+		{"not listed", []string{"0018A45C4D1DEF81644B54AB7F969B88D65:1"}, false},
+	} {
+		server, requests := breachServer(t, http.StatusOK, tc.lines...)
+		policy := &PasswordPolicy{BreachClient: server.Client(), BreachAPI: server.URL + "/range/"}
+		got := slices.Contains(reasons(policy.PolicyCheck(context.Background(), password, PasswordUser{})), ReasonBreached)
+		if got != tc.want {
+			t.Errorf("%s: breached = %v, want %v", tc.name, got, tc.want)
+		}
+
+		if len(*requests) != 1 {
+			t.Fatalf("%s: the API got %d requests, want 1", tc.name, len(*requests))
+		}
+		request := (*requests)[0]
+		if !strings.HasPrefix(request, "/range/"+prefix+" ") {
+			t.Errorf("%s: the API was asked for %s, want /range/%s", tc.name, request, prefix)
+		}
+		if strings.Contains(strings.ToUpper(request), suffix) {
+			t.Errorf("%s: the request %s carries the rest of the hash", tc.name, request)
+		}
+		if !strings.Contains(request, "Add-Padding:[true]") {
+			t.Errorf("%s: the request %s doesn't ask for padding", tc.name, request)
+		}
+	}
+}
+
+// TestBreachCheckSkippedForWeakPasswords checks the API isn't asked about
+// passwords the local rules already refuse
+func TestBreachCheckSkippedForWeakPasswords(t *testing.T) {
+	server, requests := breachServer(t, http.StatusOK)
+	policy := &PasswordPolicy{BreachClient: server.Client(), BreachAPI: server.URL + "/"}
+	policy.PolicyCheck(context.Background(), "short", PasswordUser{})
+	if len(*requests) != 0 {
+		t.Errorf("the API got %d requests, want none", len(*requests))
+	}
+}
+
+// TestBreachCheckFailsOpen has the API fail in each way it can: the
+// password is accepted every time, and a hung API is given up on within
+// the client's timeout
+func TestBreachCheckFailsOpen(t *testing.T) {
+	errored, _ := breachServer(t, http.StatusServiceUnavailable)
+	unreachable, _ := breachServer(t, http.StatusOK)
+	unreachable.Close()
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(hung.Close)
+
+	for _, tc := range []struct {
+		name string
+		url  string
+	}{
+		{"server error", errored.URL},
+		{"unreachable", unreachable.URL},
+		{"hung", hung.URL},
+		{"bad URL", "://nowhere"},
+	} {
+		policy := &PasswordPolicy{BreachClient: &http.Client{Timeout: 100 * time.Millisecond}, BreachAPI: tc.url + "/"}
+		start := time.Now()
+		if violations := policy.PolicyCheck(context.Background(), "correct-Horse-42", PasswordUser{}); len(violations) != 0 {
+			t.Errorf("%s: the password broke %v, want it accepted", tc.name, reasons(violations))
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s: the check took %v", tc.name, elapsed)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238); these are what authenticator apps assume when
+// the otpauth:// URI doesn't say otherwise
+const (
+	totpPeriod     = 30 * time.Second
+	totpDigits     = 6
+	totpSecretSize = 20 // 160 bits, the size RFC 4226 recommends for HMAC-SHA1
+)
+
+// TOTPSkew is how many time steps either side of the current one a code is
+// accepted for, to allow for clocks that are slightly off
+const TOTPSkew = 1
+
+// RecoveryCodeCount is how many recovery codes a 2FA setup hands out
+const RecoveryCodeCount = 10
+
+// recoveryCodeBytes is how much randomness a recovery code carries (80 bits)
+const recoveryCodeBytes = 10
+
+// ErrInvalidSecret is returned when an encrypted TOTP secret can't be opened
+var ErrInvalidSecret = errors.New("invalid encrypted secret")
+
+// totpEncoding is the unpadded base32 authenticator apps expect
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret creates a new random TOTP secret
+func GenerateTOTPSecret() ([]byte, error) {
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %w", err)
+	}
+	return secret, nil
+}
+
+// EncodeTOTPSecret returns a secret in the base32 form users type into an app
+func EncodeTOTPSecret(secret []byte) string {
+	return totpEncoding.EncodeToString(secret)
+}
+
+// TOTPURI returns the otpauth:// URI for a secret, usually shown as a QR code
+// issuer names the service in the app, account the user within it
+func TOTPURI(secret []byte, issuer, account string) string {
+	params := url.Values{}
+	params.Set("secret", EncodeTOTPSecret(secret))
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(int(totpPeriod.Seconds())))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// TOTPStep returns the time step t falls in
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod.Seconds())
+}
+
+// TOTPCode returns the code for a secret at a time step (RFC 4226 HOTP with
+// the step as the counter)
+func TOTPCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation: the low nibble of the last byte picks 4 bytes
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// VerifyTOTP checks a code against the steps around now (see TOTPSkew)
+// It returns the step the code belongs to, so callers can refuse a code whose
+// step was already used; a code is only as good as it is single-use
+// Spaces in the code are ignored, as apps often show "123 456"
+func VerifyTOTP(secret []byte, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := TOTPStep(now)
+	for step := current - TOTPSkew; step <= current+TOTPSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(TOTPCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateRecoveryCodes creates a set of single-use recovery codes
+// It returns the codes to show the user (once) and the hashes to store
+func GenerateRecoveryCodes() (codes, hashes []string, err error) {
+	codes = make([]string, RecoveryCodeCount)
+	hashes = make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		// 16 base32 characters, shown as two groups of 8
+		raw := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes[i] = raw[:8] + "-" + raw[8:]
+		hashes[i] = HashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode returns the hex SHA-256 of a recovery code, the form it's
+// stored and looked up in
+// Case, spaces and dashes don't matter, so codes can be typed as the user likes
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return fmt.Sprintf("%x", sum)
+}
+
+// SecretBox encrypts TOTP secrets at rest with AES-256-GCM
+// Unlike passwords, the server needs the secret itself to check codes, so it
+// can't be hashed; a database dump alone doesn't give it away
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox creates a box whose key is derived from key (any length)
+func NewSecretBox(key string) (*SecretBox, error) {
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBox{aead: aead}, nil
+}
+
+// Seal encrypts a secret; the random nonce is stored in front of the ciphertext
+func (b *SecretBox) Seal(secret []byte) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return b.aead.Seal(nonce, nonce, secret, nil), nil
+}
+
+// Open decrypts a secret sealed with the same key
+// Returns ErrInvalidSecret if it was tampered with or the key has changed
+func (b *SecretBox) Open(sealed []byte) ([]byte, error) {
+	size := b.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, ErrInvalidSecret
+	}
+	secret, err := b.aead.Open(nil, sealed[:size], sealed[size:], nil)
+	if err != nil {
+		return nil, ErrInvalidSecret
+	}
+	return secret, nil
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors
+var rfcSecret = []byte("12345678901234567890")
+
+// TestTOTPCode checks codes against the RFC 6238 vectors, which have 8
+// digits; ours are their last 6
+func TestTOTPCode(t *testing.T) {
+	for _, tc := range []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	} {
+		if got := TOTPCode(rfcSecret, TOTPStep(time.Unix(tc.unix, 0))); got != tc.want {
+			t.Errorf("at %d got %s, want %s", tc.unix, got, tc.want)
+		}
+	}
+}
+
+// TestVerifyTOTP accepts codes from one step either side of now and returns
+// the step they belong to; two steps off, or a malformed code, is refused
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := TOTPStep(now)
+
+	for _, tc := range []struct {
+		name string
+		step int64
+		ok   bool
+	}{
+		{"current", current, true},
+		{"a step behind", current - 1, true},
+		{"a step ahead", current + 1, true},
+		{"two steps behind", current - 2, false},
+		{"two steps ahead", current + 2, false},
+	} {
+		step, ok := VerifyTOTP(rfcSecret, TOTPCode(rfcSecret, tc.step), now)
+		if ok != tc.ok || (ok && step != tc.step) {
+			t.Errorf("%s: got step %d, %v; want %d, %v", tc.name, step, ok, tc.step, tc.ok)
+		}
+	}
+
+	code := TOTPCode(rfcSecret, current)
+	if _, ok := VerifyTOTP(rfcSecret, code[:3]+" "+code[3:], now); !ok {
+		t.Error("a code typed with a space was refused")
+	}
+	for _, bad := range []string{"", code[:5], code + "0", "abcdef"} {
+		if _, ok := VerifyTOTP(rfcSecret, bad, now); ok {
+			t.Errorf("%q was accepted", bad)
+		}
+	}
+}
+
+// TestRecoveryCodes hands out distinct codes whose hashes match however
+// they're typed back
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount || len(hashes) != RecoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), RecoveryCodeCount)
+	}
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		if seen[code] || len(code) != 17 || code[8] != '-' {
+			t.Errorf("code %q is repeated or not two groups of 8", code)
+		}
+		seen[code] = true
+		typed := " " + strings.ToUpper(strings.ReplaceAll(code, "-", "")) + " "
+		if HashRecoveryCode(typed) != hashes[i] {
+			t.Errorf("%q typed as %q hashes differently", code, typed)
+		}
+	}
+}
+
+// TestSecretBox opens what it sealed, and refuses tampered ciphertext and
+// other keys
+func TestSecretBox(t *testing.T) {
+	box, err := NewSecretBox("the key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := box.Seal(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened, err := box.Open(sealed); err != nil || string(opened) != string(rfcSecret) {
+		t.Fatalf("opened %q, %v", opened, err)
+	}
+
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+	other, err := NewSecretBox("another key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, open := range map[string]func() ([]byte, error){
+		"tampered":  func() ([]byte, error) { return box.Open(tampered) },
+		"truncated": func() ([]byte, error) { return box.Open(sealed[:4]) },
+		"other key": func() ([]byte, error) { return other.Open(sealed) },
+	} {
+		if _, err := open(); !errors.Is(err, ErrInvalidSecret) {
+			t.Errorf("%s got %v, want ErrInvalidSecret", name, err)
+		}
+	}
+}
+
+// TestTwoFactorToken keeps the intermediate token and access tokens apart:
+// neither parser accepts the other's
+func TestTwoFactorToken(t *testing.T) {
+	const secret = "jwt-secret"
+	pending, expiresAt, err := GenerateTwoFactorToken(7, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(expiresAt); d <= 0 || d > TwoFactorTokenLifetime {
+		t.Errorf("the token expires in %s, want within %s", d, TwoFactorTokenLifetime)
+	}
+	if userID, err := ParseTwoFactorToken(pending, secret); err != nil || userID != 7 {
+		t.Errorf("parsing the intermediate token got %d, %v", userID, err)
+	}
+	if _, err := ParseToken(pending, secret); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ParseToken accepted the intermediate token: %v", err)
+	}
+
+	access, err := GenerateToken(7, 1, 0, time.Now().Add(time.Hour), secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseTwoFactorToken(access, secret); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ParseTwoFactorToken accepted an access token: %v", err)
+	}
+	if _, err := ParseTwoFactorToken(pending, "other-secret"); err == nil {
+		t.Error("an intermediate token signed with another secret was accepted")
+	}
+}
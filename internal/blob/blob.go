@@ -0,0 +1,149 @@
+// Package blob stores uploaded files on local disk, addressed by their content
+// A file's name is the SHA-256 of its bytes, so identical uploads share one file
+// Images can also have a thumbnail stored next to them (see image.go)
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// ErrTooLarge is returned by Stage when the data exceeds the size limit
+var ErrTooLarge = errors.New("blob too large")
+
+// sniffLen is how many leading bytes are kept for content type detection
+const sniffLen = 512
+
+// FileStore keeps blobs under a directory as <dir>/<ab>/<cd>/<hash>
+// The two levels of subdirectories keep any one directory from growing huge
+type FileStore struct {
+	dir string
+}
+
+// Staged is data written to a temporary file but not yet stored under its hash
+// Call Discard once done; it's a no-op after Commit moved the file into place
+type Staged struct {
+	Hash        string // Hex-encoded SHA-256 of the data
+	Size        int64
+	ContentType string // Sniffed from the leading bytes, never taken from the client
+
+	tmpPath string
+}
+
+// NewFileStore creates a store rooted at dir, creating it if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns where the blob with the given hash lives
+func (s *FileStore) path(hash string) string {
+	return filepath.Join(s.dir, hash[0:2], hash[2:4], hash)
+}
+
+// Stage streams r into a temporary file, hashing it on the way
+// Nothing is held in memory beyond the bytes used to sniff the content type
+// Returns ErrTooLarge if r has more than maxSize bytes
+func (s *FileStore) Stage(r io.Reader, maxSize int64) (*Staged, error) {
+	// Temp files live inside the store so Commit's rename never crosses filesystems
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, "tmp"), "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	staged := &Staged{tmpPath: tmp.Name()}
+
+	hasher := sha256.New()
+	sniff := &sniffBuffer{}
+	// Read one byte past the limit to tell "exactly maxSize" from "too large"
+	size, err := io.Copy(io.MultiWriter(tmp, hasher, sniff), io.LimitReader(r, maxSize+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size > maxSize {
+		err = ErrTooLarge
+	}
+	if err != nil {
+		staged.Discard()
+		return nil, err
+	}
+
+	staged.Hash = hex.EncodeToString(hasher.Sum(nil))
+	staged.Size = size
+	staged.ContentType = http.DetectContentType(sniff.data)
+	return staged, nil
+}
+
+// Commit stores staged data under its hash
+// If the blob already exists the staged copy is simply dropped: same hash, same bytes
+func (s *FileStore) Commit(staged *Staged) error {
+	path := s.path(staged.Hash)
+	if _, err := os.Stat(path); err == nil {
+		staged.Discard()
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	// Rename is atomic, so readers never see a partially written blob
+	if err := os.Rename(staged.tmpPath, path); err != nil {
+		return err
+	}
+	staged.tmpPath = ""
+	return nil
+}
+
+// Open opens the blob with the given hash for reading
+func (s *FileStore) Open(hash string) (*os.File, error) {
+	return os.Open(s.path(hash))
+}
+
+// Remove deletes the blob with the given hash, and its thumbnail if it has one
+// A blob that is already gone is not an error
+func (s *FileStore) Remove(hash string) error {
+	for _, path := range []string{s.thumbnailPath(hash), s.path(hash)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Open opens the staged data for reading, e.g. to inspect it before Commit
+// Only valid until Commit or Discard
+func (staged *Staged) Open() (*os.File, error) {
+	if staged.tmpPath == "" {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(staged.tmpPath)
+}
+
+// Discard removes the temporary file if it hasn't been committed
+func (staged *Staged) Discard() {
+	if staged.tmpPath != "" {
+		os.Remove(staged.tmpPath)
+		staged.tmpPath = ""
+	}
+}
+
+// sniffBuffer keeps the first sniffLen bytes written to it and ignores the rest
+type sniffBuffer struct {
+	data []byte
+}
+
+func (b *sniffBuffer) Write(p []byte) (int, error) {
+	if room := sniffLen - len(b.data); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		b.data = append(b.data, p[:room]...)
+	}
+	return len(p), nil
+}
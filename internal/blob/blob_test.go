@@ -0,0 +1,115 @@
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tmpFiles returns how many staged files are left in the store's temp directory
+func tmpFiles(t *testing.T, s *FileStore) int {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(s.dir, "tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+// TestStage streams data into the store: the hash and size are of every
+// byte read, and the type is sniffed from the content, not the name
+func TestStage(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := "\x89PNG\r\n\x1a\n" + strings.Repeat("x", 2*sniffLen)
+	staged, err := s.Stage(strings.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer staged.Discard()
+
+	sum := sha256.Sum256([]byte(data))
+	if staged.Hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("hash is %s, want %x", staged.Hash, sum)
+	}
+	if staged.Size != int64(len(data)) {
+		t.Errorf("size is %d, want %d", staged.Size, len(data))
+	}
+	if staged.ContentType != "image/png" {
+		t.Errorf("content type is %q, want image/png", staged.ContentType)
+	}
+}
+
+// TestStageTooLarge checks the limit is inclusive and nothing is left
+// behind when it's exceeded
+func TestStageTooLarge(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staged, err := s.Stage(strings.NewReader("12345"), 5)
+	if err != nil {
+		t.Fatalf("exactly the limit got %v", err)
+	}
+	staged.Discard()
+
+	if _, err := s.Stage(strings.NewReader("123456"), 5); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("one byte over the limit got %v, want ErrTooLarge", err)
+	}
+	if n := tmpFiles(t, s); n != 0 {
+		t.Errorf("%d staged files are left behind", n)
+	}
+}
+
+// TestCommitDeduplicates stores the same bytes twice: there's one blob,
+// the second staged copy is dropped, and removing it is final
+func TestCommitDeduplicates(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hash string
+	for i := 0; i < 2; i++ {
+		staged, err := s.Stage(strings.NewReader("same meme"), 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Commit(staged); err != nil {
+			t.Fatal(err)
+		}
+		staged.Discard() // A no-op once committed
+		hash = staged.Hash
+	}
+	if n := tmpFiles(t, s); n != 0 {
+		t.Errorf("%d staged files are left behind", n)
+	}
+
+	file, err := s.Open(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil || string(data) != "same meme" {
+		t.Errorf("the blob holds %q (%v), want %q", data, err, "same meme")
+	}
+
+	for i := 0; i < 2; i++ { // Removing a missing blob is not an error
+		if err := s.Remove(hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Open(hash); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("opening a removed blob got %v, want os.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,154 @@
+package blob
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	// Decoders for the image types thumbnails are made of
+	_ "image/gif"
+	_ "image/png"
+)
+
+// ErrImageTooLarge is returned for images with more pixels than maxImagePixels
+var ErrImageTooLarge = errors.New("image too large to thumbnail")
+
+// maxImagePixels bounds the images that are decoded in full
+// A small file can declare a huge canvas; decoding it would take gigabytes
+const maxImagePixels = 50_000_000
+
+// thumbnailQuality is the JPEG quality of thumbnails
+const thumbnailQuality = 80
+
+// thumbnailSuffix is added to a blob's path for its thumbnail
+// A thumbnail belongs to its blob, not to its own bytes, so it isn't stored
+// under its own hash: an upload that happens to match a thumbnail can't share
+// its file, and Remove deletes both together
+const thumbnailSuffix = ".thumb.jpg"
+
+// IsImage reports whether a sniffed content type is an image thumbnails can be made of
+func IsImage(contentType string) bool {
+	switch strings.TrimSpace(strings.Split(contentType, ";")[0]) {
+	case "image/png", "image/jpeg", "image/gif":
+		return true
+	}
+	return false
+}
+
+// ImageSize reads an image's dimensions from its header without decoding it
+func ImageSize(r io.Reader) (width, height int, err error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	return config.Width, config.Height, nil
+}
+
+// Thumbnail decodes an image and scales it down to at most maxEdge pixels on
+// its long edge, encoded as JPEG
+// Smaller images keep their size; animated GIFs use their first frame, and
+// transparent areas are flattened onto white
+func Thumbnail(r io.Reader, maxEdge int) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, ErrImageTooLarge
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleDown(src, maxEdge), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleDown resizes src to fit within maxEdge by averaging the source pixels
+// each destination pixel covers (a box filter), which is enough for thumbnails
+func scaleDown(src image.Image, maxEdge int) *image.RGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dstW, dstH := srcW, srcH
+	if srcW > maxEdge || srcH > maxEdge {
+		if srcW >= srcH {
+			dstW, dstH = maxEdge, max(1, srcH*maxEdge/srcW)
+		} else {
+			dstW, dstH = max(1, srcW*maxEdge/srcH), maxEdge
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0, y1 := y*srcH/dstH, max((y+1)*srcH/dstH, y*srcH/dstH+1)
+		for x := 0; x < dstW; x++ {
+			x0, x1 := x*srcW/dstW, max((x+1)*srcW/dstW, x*srcW/dstW+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			// Colors are premultiplied, so adding the missing alpha as white
+			// flattens transparency onto a white background
+			white := 0xffff*n - a
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r + white) / n >> 8),
+				G: uint8((g + white) / n >> 8),
+				B: uint8((b + white) / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
+
+// thumbnailPath returns where the thumbnail of the blob with the given hash lives
+func (s *FileStore) thumbnailPath(hash string) string {
+	return s.path(hash) + thumbnailSuffix
+}
+
+// PutThumbnail stores the thumbnail of the blob with the given hash, replacing any earlier one
+func (s *FileStore) PutThumbnail(hash string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, "tmp"), "thumb-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(s.thumbnailPath(hash)), 0o700)
+	}
+	if err == nil {
+		// Rename is atomic, so readers never see a partially written thumbnail
+		err = os.Rename(tmp.Name(), s.thumbnailPath(hash))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// OpenThumbnail opens the thumbnail of the blob with the given hash for reading
+func (s *FileStore) OpenThumbnail(hash string) (*os.File, error) {
+	return os.Open(s.thumbnailPath(hash))
+}
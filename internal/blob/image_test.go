@@ -0,0 +1,205 @@
+package blob
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// encodePNG encodes a width x height image filled with c
+func encodePNG(t *testing.T, width, height int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// decodeThumbnail decodes a thumbnail, failing the test unless it's a JPEG
+func decodeThumbnail(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil || format != "jpeg" {
+		t.Fatalf("the thumbnail decodes as %q: %v", format, err)
+	}
+	return img
+}
+
+// near reports whether a JPEG pixel is within a few levels of want
+func near(got color.Color, want color.RGBA) bool {
+	r, g, b, _ := got.RGBA()
+	within := func(v uint32, w uint8) bool { return int(v>>8)-int(w) < 8 && int(w)-int(v>>8) < 8 }
+	return within(r, want.R) && within(g, want.G) && within(b, want.B)
+}
+
+func TestIsImage(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"image/png":                true,
+		"image/jpeg":               true,
+		"image/gif":                true,
+		"image/gif; charset=utf-8": true,
+		"image/webp":               false,
+		"image/svg+xml":            false,
+		"application/pdf":          false,
+		"":                         false,
+	} {
+		if got := IsImage(contentType); got != want {
+			t.Errorf("IsImage(%q) = %t, want %t", contentType, got, want)
+		}
+	}
+}
+
+func TestImageSize(t *testing.T) {
+	width, height, err := ImageSize(bytes.NewReader(encodePNG(t, 640, 480, color.White)))
+	if err != nil || width != 640 || height != 480 {
+		t.Errorf("ImageSize = %dx%d, %v; want 640x480", width, height, err)
+	}
+	if _, _, err := ImageSize(strings.NewReader("not an image")); err == nil {
+		t.Error("ImageSize read a size from text")
+	}
+}
+
+// TestThumbnailSize checks thumbnails fit within the long edge, keep the
+// aspect ratio, and never scale small images up
+func TestThumbnailSize(t *testing.T) {
+	for _, c := range []struct {
+		width, height int
+		wantW, wantH  int
+	}{
+		{1000, 500, 320, 160},
+		{300, 1200, 80, 320},
+		{320, 320, 320, 320},
+		{100, 40, 100, 40},
+		{2000, 1, 320, 1},
+	} {
+		thumbnail, err := Thumbnail(bytes.NewReader(encodePNG(t, c.width, c.height, color.RGBA{R: 200, A: 255})), 320)
+		if err != nil {
+			t.Errorf("%dx%d: %v", c.width, c.height, err)
+			continue
+		}
+		bounds := decodeThumbnail(t, thumbnail).Bounds()
+		if bounds.Dx() != c.wantW || bounds.Dy() != c.wantH {
+			t.Errorf("%dx%d became %dx%d, want %dx%d", c.width, c.height, bounds.Dx(), bounds.Dy(), c.wantW, c.wantH)
+		}
+	}
+}
+
+// TestThumbnailColors checks transparency is flattened onto white and an
+// animated GIF's thumbnail is its first frame
+func TestThumbnailColors(t *testing.T) {
+	thumbnail, err := Thumbnail(bytes.NewReader(encodePNG(t, 64, 64, color.Transparent)), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decodeThumbnail(t, thumbnail).At(16, 16); !near(got, color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("a transparent image's thumbnail is %v, want white", got)
+	}
+
+	frame := func(c color.Color) *image.Paletted {
+		img := image.NewPaletted(image.Rect(0, 0, 64, 64), palette.Plan9)
+		for y := 0; y < 64; y++ {
+			for x := 0; x < 64; x++ {
+				img.Set(x, y, c)
+			}
+		}
+		return img
+	}
+	var animated bytes.Buffer
+	err = gif.EncodeAll(&animated, &gif.GIF{
+		Image: []*image.Paletted{frame(color.RGBA{0, 0, 255, 255}), frame(color.RGBA{255, 0, 0, 255})},
+		Delay: []int{10, 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumbnail, err = Thumbnail(&animated, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decodeThumbnail(t, thumbnail).At(16, 16); !near(got, color.RGBA{0, 0, 255, 255}) {
+		t.Errorf("an animated GIF's thumbnail is %v, want its blue first frame", got)
+	}
+}
+
+// TestThumbnailRefuses checks corrupt images fail and images declaring more
+// than maxImagePixels aren't decoded
+func TestThumbnailRefuses(t *testing.T) {
+	valid := encodePNG(t, 8, 8, color.White)
+	if _, err := Thumbnail(bytes.NewReader(valid[:len(valid)/2]), 320); err == nil {
+		t.Error("a truncated PNG got a thumbnail")
+	}
+
+	// The same PNG claiming 10000x10000 pixels in its header (IHDR, after the
+	// 8-byte signature and the chunk's length and type)
+	huge := bytes.Clone(valid)
+	binary.BigEndian.PutUint32(huge[16:], 10000)
+	binary.BigEndian.PutUint32(huge[20:], 10000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+	if _, err := Thumbnail(bytes.NewReader(huge), 320); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("a 100-megapixel image got %v, want ErrImageTooLarge", err)
+	}
+}
+
+// TestThumbnailFiles stores a blob and its thumbnail: the thumbnail reads
+// back, and Remove deletes both, twice without an error
+func TestThumbnailFiles(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged, err := s.Stage(bytes.NewReader(encodePNG(t, 400, 200, color.White)), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if staged.ContentType != "image/png" {
+		t.Errorf("the upload was sniffed as %q, want image/png", staged.ContentType)
+	}
+	if err := s.Commit(staged); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4)), nil)
+	if err := s.PutThumbnail(staged.Hash, buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	file, err := s.OpenThumbnail(staged.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := io.ReadAll(file)
+	file.Close()
+	if err != nil || !bytes.Equal(stored, buf.Bytes()) {
+		t.Errorf("the stored thumbnail differs (%v)", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := s.Remove(staged.Hash); err != nil {
+			t.Fatalf("Remove %d: %v", i+1, err)
+		}
+	}
+	if _, err := s.Open(staged.Hash); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the blob is still there after Remove: %v", err)
+	}
+	if _, err := s.OpenThumbnail(staged.Hash); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the thumbnail is still there after Remove: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+// Package cache keeps values loaded from the store in memory for a while
+//
+// The read-through caches in front of the store (room access, token
+// versions, notification preferences, feature flags) all need the same
+// things: entries that expire, a bound on their number, and invalidation
+// that a load racing it can't undo. TTL does that once for all of them
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// entry is one cached value
+type entry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// TTL caches values by key for a fixed time, up to a bounded number of keys
+// Values are loaded by the caller, outside the lock: Get hands out the
+// generation with a miss, and Put drops a value loaded at an older one, since
+// a load that raced an invalidation may have read what it invalidated
+// Safe to use from any goroutine
+type TTL[K comparable, V any] struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[K]entry[V]
+
+	// generation counts invalidations, so a load that raced one isn't cached
+	generation uint64
+
+	// nextSweep is when the earliest entry left by the last sweep expires; a
+	// full cache isn't swept again before then, as there'd be nothing to drop
+	nextSweep time.Time
+}
+
+// New creates a cache keeping each value for ttl, and at most maxEntries of them
+func New[K comparable, V any](ttl time.Duration, maxEntries int) *TTL[K, V] {
+	return &TTL[K, V]{ttl: ttl, maxEntries: maxEntries, entries: make(map[K]entry[V])}
+}
+
+// Get returns a key's value, whether it's still fresh, and the generation a
+// load of the key should be put back with
+// An expired or invalidated value is returned too, for callers that would
+// rather fall back on it than on nothing; it's the zero value once swept
+func (c *TTL[K, V]) Get(key K, now time.Time) (V, bool, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	return e.value, ok && now.Before(e.expires), c.generation
+}
+
+// Put caches a value loaded at generation, unless something was invalidated since
+// A full cache sweeps out expired entries first, and drops the value if that
+// frees nothing
+func (c *TTL[K, V]) Put(key K, value V, generation uint64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		if now.Before(c.nextSweep) {
+			return
+		}
+		c.sweep(now)
+		if len(c.entries) >= c.maxEntries {
+			return
+		}
+	}
+	c.entries[key] = entry[V]{value: value, expires: now.Add(c.ttl)}
+}
+
+// Invalidate expires a key's value
+// Call it whenever what was loaded for the key changes
+func (c *TTL[K, V]) Invalidate(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.expires = time.Time{}
+		c.entries[key] = e
+	}
+	c.generation++
+	c.nextSweep = time.Time{}
+}
+
+// InvalidateFunc expires the values of every key match returns true for
+// It walks the whole cache, so it's for changes that touch many keys at once
+func (c *TTL[K, V]) InvalidateFunc(match func(K) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if match(key) {
+			e.expires = time.Time{}
+			c.entries[key] = e
+		}
+	}
+	c.generation++
+	c.nextSweep = time.Time{}
+}
+
+// Len returns how many values are cached, expired ones included until swept
+func (c *TTL[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// sweep removes expired entries; c.mu must be held
+func (c *TTL[K, V]) sweep(now time.Time) {
+	c.nextSweep = time.Time{}
+	for key, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, key)
+		} else if c.nextSweep.IsZero() || e.expires.Before(c.nextSweep) {
+			c.nextSweep = e.expires
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+// TestTTL checks values are fresh until the TTL, stale after it, and that a
+// load which raced an invalidation isn't cached
+func TestTTL(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := New[int64, string](time.Minute, 10)
+
+	if _, fresh, _ := c.Get(1, now); fresh {
+		t.Fatal("an empty cache served a value")
+	}
+	_, _, generation := c.Get(1, now)
+	c.Put(1, "a", generation, now)
+	if value, fresh, _ := c.Get(1, now.Add(time.Minute-time.Nanosecond)); !fresh || value != "a" {
+		t.Errorf("got %q (fresh %v) before the TTL, want a fresh a", value, fresh)
+	}
+	if value, fresh, _ := c.Get(1, now.Add(time.Minute)); fresh || value != "a" {
+		t.Errorf("got %q (fresh %v) after the TTL, want a stale a", value, fresh)
+	}
+
+	c.Put(2, "b", generation, now)
+	c.Invalidate(1)
+	if value, fresh, _ := c.Get(1, now); fresh || value != "a" {
+		t.Errorf("got %q (fresh %v) after invalidating, want a stale a", value, fresh)
+	}
+	if _, fresh, _ := c.Get(2, now); !fresh {
+		t.Error("invalidating one key expired another")
+	}
+
+	// This load started before the invalidation above
+	c.Put(1, "old", generation, now)
+	if value, _, _ := c.Get(1, now); value != "a" {
+		t.Errorf("a load that raced an invalidation was cached: got %q", value)
+	}
+	_, _, generation = c.Get(1, now)
+	c.Put(1, "new", generation, now)
+	if value, fresh, _ := c.Get(1, now); !fresh || value != "new" {
+		t.Errorf("got %q (fresh %v) after reloading, want a fresh new", value, fresh)
+	}
+}
+
+// TestTTLInvalidateFunc expires the matching keys only
+func TestTTLInvalidateFunc(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := New[int64, string](time.Minute, 10)
+	for key := int64(1); key <= 4; key++ {
+		c.Put(key, "v", 0, now)
+	}
+
+	c.InvalidateFunc(func(key int64) bool { return key%2 == 0 })
+	for key := int64(1); key <= 4; key++ {
+		if _, fresh, _ := c.Get(key, now); fresh != (key%2 == 1) {
+			t.Errorf("key %d is fresh %v after invalidating the even keys", key, fresh)
+		}
+	}
+}
+
+// TestTTLBound fills the cache: nothing is added while every entry is live,
+// expired entries are swept to make room, and a full cache isn't swept
+// again before its earliest entry expires
+func TestTTLBound(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := New[int, int](time.Minute, 3)
+	c.Put(1, 1, 0, now)
+	c.Put(2, 2, 0, now.Add(time.Second))
+	c.Put(3, 3, 0, now.Add(2*time.Second))
+
+	c.Put(4, 4, 0, now)
+	if _, fresh, _ := c.Get(4, now); fresh || c.Len() != 3 {
+		t.Errorf("a full cache took another entry (%d entries)", c.Len())
+	}
+	c.Put(1, 10, 0, now)
+	if value, _, _ := c.Get(1, now); value != 10 {
+		t.Errorf("a full cache didn't update a cached key: got %d", value)
+	}
+
+	// The sweep at now found key 1 expiring first, so nothing is swept before
+	// then, not even key 3 expired behind the cache's back
+	c.mu.Lock()
+	c.entries[3] = entry[int]{value: 3}
+	c.mu.Unlock()
+	c.Put(4, 4, 0, now.Add(30*time.Second))
+	if c.Len() != 3 {
+		t.Errorf("a full cache was swept before its earliest entry expired (%d entries)", c.Len())
+	}
+
+	later := now.Add(time.Minute)
+	c.Put(4, 4, 0, later)
+	if value, fresh, _ := c.Get(4, later); !fresh || value != 4 || c.Len() != 2 {
+		t.Errorf("expired entries weren't swept to make room: got %d (fresh %v, %d entries)", value, fresh, c.Len())
+	}
+}
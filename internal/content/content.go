@@ -0,0 +1,213 @@
+// Package content validates and sanitizes chat message content
+// Both the WebSocket and REST send paths run messages through Validate so
+// the same rules apply no matter how a message arrives
+package content
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Supported content types
+const (
+	TypeText     = "text"     // Plain text, rendered as-is
+	TypeMarkdown = "markdown" // Markdown, sanitized to remove raw HTML
+	TypeCode     = "code"     // A code snippet, optionally with a language for highlighting
+)
+
+// Default maximum content length per type, counted in runes (characters), not bytes
+// Each can be given a different limit with LengthPolicy
+// Code gets a larger budget since snippets are naturally longer than chat lines
+var maxLength = map[string]int{
+	TypeText:     4000,
+	TypeMarkdown: 4000,
+	TypeCode:     10000,
+}
+
+// Ways to handle a message longer than the length limit (see LengthPolicy)
+const (
+	OversizeReject   = "reject"   // Refuse the message with a message_too_long error
+	OversizeTruncate = "truncate" // Cut it down to the limit and mark it truncated
+)
+
+// LengthPolicy is a deployment's limit on how long a chat message may be
+// The zero value keeps the built-in limits and rejects longer messages
+type LengthPolicy struct {
+	// MaxLength is the limit for text and markdown, in runes; zero keeps the default
+	MaxLength int
+
+	// MaxCodeLength is the limit for code, in runes; zero keeps the default
+	// Code is never held to less than MaxLength
+	MaxCodeLength int
+
+	// Oversize is OversizeReject or OversizeTruncate; empty means reject
+	Oversize string
+}
+
+// ParseOversize checks an oversize mode from configuration
+// Empty means reject
+func ParseOversize(mode string) (string, error) {
+	switch mode {
+	case "", OversizeReject:
+		return OversizeReject, nil
+	case OversizeTruncate:
+		return OversizeTruncate, nil
+	}
+	return "", fmt.Errorf("unknown oversize mode %q (want %q or %q)", mode, OversizeReject, OversizeTruncate)
+}
+
+// Limit returns the maximum length of a content type under this policy, in
+// runes; 0 for a type Validate doesn't accept
+func (p LengthPolicy) Limit(contentType string) int {
+	limit, ok := maxLength[contentType]
+	if !ok {
+		return 0
+	}
+	if contentType == TypeCode {
+		if p.MaxCodeLength > 0 {
+			limit = p.MaxCodeLength
+		}
+		return max(limit, p.Limit(TypeText))
+	}
+	if p.MaxLength > 0 {
+		return p.MaxLength
+	}
+	return limit
+}
+
+// Longest returns the highest limit of any content type under this policy
+func (p LengthPolicy) Longest() int {
+	longest := 0
+	for contentType := range maxLength {
+		longest = max(longest, p.Limit(contentType))
+	}
+	return longest
+}
+
+// Types returns the content types Validate accepts, sorted
+func Types() []string {
+	types := make([]string, 0, len(maxLength))
+	for contentType := range maxLength {
+		types = append(types, contentType)
+	}
+	slices.Sort(types)
+	return types
+}
+
+// allowedLanguages lists the languages a code message may declare
+// Keeping an allowlist stops clients from stuffing arbitrary strings into the column
+var allowedLanguages = map[string]bool{
+	"bash": true, "c": true, "cpp": true, "csharp": true, "css": true,
+	"go": true, "html": true, "java": true, "javascript": true, "json": true,
+	"kotlin": true, "markdown": true, "php": true, "plaintext": true,
+	"python": true, "ruby": true, "rust": true, "shell": true, "sql": true,
+	"swift": true, "typescript": true, "yaml": true,
+}
+
+// Languages returns the languages a code message may declare, sorted
+func Languages() []string {
+	languages := make([]string, 0, len(allowedLanguages))
+	for language := range allowedLanguages {
+		languages = append(languages, language)
+	}
+	slices.Sort(languages)
+	return languages
+}
+
+// Error is a validation failure with a machine-readable code
+// The code is sent to clients in error frames and API responses
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Formatted is a message body together with its formatting metadata
+type Formatted struct {
+	Type     string // One of the Type constants
+	Language string // Only set for code messages
+	Body     string
+
+	// Truncated is set by Validate when the body was cut down to the length limit
+	Truncated bool
+}
+
+// Validate checks a message against the rules for its content type
+// It returns the normalized message to store (sanitized for markdown), or an
+// *Error describing why the message was rejected
+// Messages over the policy's length limit are rejected, or cut down and marked
+// Truncated when the policy says to truncate
+// An empty content type means plain text, for clients that predate content types
+func Validate(f Formatted, policy LengthPolicy) (Formatted, error) {
+	contentType := f.Type
+	if contentType == "" {
+		contentType = TypeText
+	}
+	language := strings.ToLower(strings.TrimSpace(f.Language))
+	body := f.Body
+
+	if _, ok := maxLength[contentType]; !ok {
+		return Formatted{}, &Error{
+			Code:    "unknown_content_type",
+			Message: fmt.Sprintf("unknown content type %q", contentType),
+		}
+	}
+
+	limit := policy.Limit(contentType)
+	truncated := false
+	if utf8.RuneCountInString(body) > limit {
+		if policy.Oversize != OversizeTruncate {
+			return Formatted{}, &Error{
+				Code:    "message_too_long",
+				Message: fmt.Sprintf("%s messages are limited to %d characters", contentType, limit),
+			}
+		}
+		body = truncateRunes(body, limit)
+		truncated = true
+	}
+
+	// Checked after truncating, in case all that's left is whitespace
+	if strings.TrimSpace(body) == "" {
+		return Formatted{}, &Error{Code: "empty_message", Message: "message content is empty"}
+	}
+
+	// Only code messages may carry a language
+	if language != "" {
+		if contentType != TypeCode {
+			return Formatted{}, &Error{
+				Code:    "language_not_allowed",
+				Message: "language is only allowed on code messages",
+			}
+		}
+		if !allowedLanguages[language] {
+			return Formatted{}, &Error{
+				Code:    "unsupported_language",
+				Message: fmt.Sprintf("unsupported code language %q", language),
+			}
+		}
+	}
+
+	if contentType == TypeMarkdown {
+		body = SanitizeMarkdown(body)
+	}
+
+	return Formatted{Type: contentType, Language: language, Body: body, Truncated: truncated}, nil
+}
+
+// truncateRunes cuts s down to its first n runes
+// Cutting by rune rather than byte never splits a multi-byte character
+func truncateRunes(s string, n int) string {
+	count := 0
+	for i := range s {
+		if count == n {
+			return s[:i]
+		}
+		count++
+	}
+	return s
+}
@@ -0,0 +1,89 @@
+package content
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestValidate checks the rules for each content type and the codes
+// refusals are reported with
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   Formatted
+		want Formatted
+		code string
+	}{
+		{"untyped is text", Formatted{Body: "hi"}, Formatted{Type: TypeText, Body: "hi"}, ""},
+		{"text is kept as sent", Formatted{Type: TypeText, Body: "<b>hi</b>"}, Formatted{Type: TypeText, Body: "<b>hi</b>"}, ""},
+		{"markdown is sanitized", Formatted{Type: TypeMarkdown, Body: "<b>hi</b>"}, Formatted{Type: TypeMarkdown, Body: "hi"}, ""},
+		{"language is normalized", Formatted{Type: TypeCode, Language: " Go ", Body: "x"}, Formatted{Type: TypeCode, Language: "go", Body: "x"}, ""},
+		{"unknown type", Formatted{Type: "html", Body: "x"}, Formatted{}, "unknown_content_type"},
+		{"empty", Formatted{Body: " \n"}, Formatted{}, "empty_message"},
+		{"language on text", Formatted{Type: TypeText, Language: "go", Body: "x"}, Formatted{}, "language_not_allowed"},
+		{"unlisted language", Formatted{Type: TypeCode, Language: "brainfuck", Body: "x"}, Formatted{}, "unsupported_language"},
+		{"text at the limit", Formatted{Body: strings.Repeat("é", 4000)}, Formatted{Type: TypeText, Body: strings.Repeat("é", 4000)}, ""},
+		{"text over the limit", Formatted{Body: strings.Repeat("é", 4001)}, Formatted{}, "message_too_long"},
+		{"code over the text limit", Formatted{Type: TypeCode, Body: strings.Repeat("x", 10000)}, Formatted{Type: TypeCode, Body: strings.Repeat("x", 10000)}, ""},
+		{"code over the limit", Formatted{Type: TypeCode, Body: strings.Repeat("x", 10001)}, Formatted{}, "message_too_long"},
+	} {
+		got, err := Validate(tc.in, LengthPolicy{})
+		var refusal *Error
+		switch {
+		case tc.code == "" && err != nil:
+			t.Errorf("%s: refused with %v", tc.name, err)
+		case tc.code != "" && (!errors.As(err, &refusal) || refusal.Code != tc.code):
+			t.Errorf("%s: got %v, want a %s refusal", tc.name, err, tc.code)
+		case got != tc.want:
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestLengthPolicy checks a configured limit in both oversize modes
+// Truncation counts runes, so multi-byte characters are never split
+func TestLengthPolicy(t *testing.T) {
+	reject := LengthPolicy{MaxLength: 5}
+	truncate := LengthPolicy{MaxLength: 5, Oversize: OversizeTruncate}
+	for _, tc := range []struct {
+		name   string
+		policy LengthPolicy
+		in     Formatted
+		want   Formatted
+		code   string
+	}{
+		{"at the limit", reject, Formatted{Body: "héllo"}, Formatted{Type: TypeText, Body: "héllo"}, ""},
+		{"rejected", reject, Formatted{Body: "héllo!"}, Formatted{}, "message_too_long"},
+		{"truncated", truncate, Formatted{Body: "héllo wörld"}, Formatted{Type: TypeText, Body: "héllo", Truncated: true}, ""},
+		{"not truncated at the limit", truncate, Formatted{Body: "héllo"}, Formatted{Type: TypeText, Body: "héllo"}, ""},
+		{"truncated to whitespace", truncate, Formatted{Body: "      x"}, Formatted{}, "empty_message"},
+		{"markdown truncated before sanitizing", truncate, Formatted{Type: TypeMarkdown, Body: "**hi** <b>"}, Formatted{Type: TypeMarkdown, Body: "**hi*", Truncated: true}, ""},
+		{"code keeps its budget", reject, Formatted{Type: TypeCode, Body: strings.Repeat("x", 10000)}, Formatted{Type: TypeCode, Body: strings.Repeat("x", 10000)}, ""},
+		{"code never below the limit", LengthPolicy{MaxLength: 20000}, Formatted{Type: TypeCode, Body: strings.Repeat("x", 15000)}, Formatted{Type: TypeCode, Body: strings.Repeat("x", 15000)}, ""},
+		{"code with its own limit", LengthPolicy{MaxLength: 50, MaxCodeLength: 100}, Formatted{Type: TypeCode, Body: strings.Repeat("x", 101)}, Formatted{}, "message_too_long"},
+	} {
+		got, err := Validate(tc.in, tc.policy)
+		var refusal *Error
+		switch {
+		case tc.code == "" && err != nil:
+			t.Errorf("%s: refused with %v", tc.name, err)
+		case tc.code != "" && (!errors.As(err, &refusal) || refusal.Code != tc.code):
+			t.Errorf("%s: got %v, want a %s refusal", tc.name, err, tc.code)
+		case got != tc.want:
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestParseOversize accepts the two modes, empty meaning reject
+func TestParseOversize(t *testing.T) {
+	for in, want := range map[string]string{"": OversizeReject, "reject": OversizeReject, "truncate": OversizeTruncate} {
+		if got, err := ParseOversize(in); err != nil || got != want {
+			t.Errorf("ParseOversize(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseOversize("drop"); err == nil {
+		t.Error("ParseOversize accepted an unknown mode")
+	}
+}
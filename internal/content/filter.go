@@ -0,0 +1,27 @@
+package content
+
+import "context"
+
+// Verdict is a content filter's decision about a message
+type Verdict int
+
+const (
+	VerdictAllow  Verdict = iota // Deliver the message unchanged
+	VerdictMask                  // Deliver the rewritten message, flagged as filtered
+	VerdictReject                // Drop the message and tell the sender
+)
+
+// Filter moderates message content before it's persisted and broadcast
+// Implementations must be safe for concurrent use: every hub shard calls Check
+// Check returns the verdict and, for VerdictMask, the rewritten content
+type Filter interface {
+	Check(ctx context.Context, content string) (Verdict, string, error)
+}
+
+// NoopFilter allows every message; it's the default when no wordlist is configured
+type NoopFilter struct{}
+
+// Check always allows the message unchanged
+func (NoopFilter) Check(_ context.Context, content string) (Verdict, string, error) {
+	return VerdictAllow, content, nil
+}
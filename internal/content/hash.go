@@ -0,0 +1,21 @@
+package content
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Normalize reduces a message to the form used for duplicate detection
+// Surrounding whitespace and letter case don't make a message different,
+// so "Buy now!" and "  BUY NOW!  " count as the same message
+func Normalize(body string) string {
+	return strings.ToLower(strings.TrimSpace(body))
+}
+
+// Hash returns the hex SHA-256 of the normalized message body
+// Stored with every message so identical messages can be counted with an index
+func Hash(body string) string {
+	sum := sha256.Sum256([]byte(Normalize(body)))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,46 @@
+package content
+
+import "testing"
+
+// TestHash checks which bodies count as the same message: case and
+// surrounding whitespace don't matter, in any script, and anything else does
+func TestHash(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		same bool
+	}{
+		{"Buy now!", "  BUY NOW!  ", true},
+		{"ÉCOLE", "école", true},
+		{"ПРИВЕТ", "привет", true},
+		{"\u00a0hi\u3000", "hi", true}, // No-break and ideographic spaces are trimmed too
+		{"\tспам\n", "СПАМ", true},
+		{"你好", "你好", true},
+		{"🎉🎉", " 🎉🎉", true},
+		{"buy now", "buy  now", false}, // Inner whitespace is kept
+		{"buy now", "buy now.", false},
+		{"你好", "您好", false},
+		{"🎉", "🎊", false},
+		{"", " ", true},
+	} {
+		if same := Hash(tc.a) == Hash(tc.b); same != tc.same {
+			t.Errorf("Hash(%q) == Hash(%q) is %v, want %v", tc.a, tc.b, same, tc.same)
+		}
+	}
+}
+
+// TestNormalize checks the normalized form itself, since Hash only hashes it
+func TestNormalize(t *testing.T) {
+	for in, want := range map[string]string{
+		"  Hello World  ": "hello world",
+		"ÉCOLE":           "école",
+		"\u3000你好\u3000":  "你好",
+		"A\nB":            "a\nb",
+	} {
+		if got := Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := Hash("x"); len(got) != 64 {
+		t.Errorf("Hash gives %q, want 64 hex digits", got)
+	}
+}
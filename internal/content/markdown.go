@@ -0,0 +1,404 @@
+package content
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// maxQuoteDepth is the deepest blockquote nesting kept in markdown messages
+// Deeper nesting is flattened; it's never useful in chat and can slow renderers down
+const maxQuoteDepth = 5
+
+var (
+	// HTML constructs removed from prose, anchored so they only match at the
+	// current scan position
+	// script and style elements are removed together with their contents,
+	// since the text inside them is never meant to be displayed
+	htmlPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`^(?is)<script\b[^>]*>.*?</script\s*>`),
+		regexp.MustCompile(`^(?is)<style\b[^>]*>.*?</style\s*>`),
+		regexp.MustCompile(`^(?s)<!--.*?-->`),
+		regexp.MustCompile(`^</?[a-zA-Z][^<>]*>`),
+	}
+
+	// autolink matches markdown autolinks like <https://example.com>,
+	// which look like tags but are legitimate markdown
+	autolink = regexp.MustCompile(`^<(?i:https?://|mailto:)[^\s<>]+>`)
+
+	// unsafeSchemes are URL schemes that can run script when a link is followed
+	unsafeSchemes = []string{"javascript:", "vbscript:", "data:"}
+
+	// backslashEscape matches a backslash-escaped ASCII punctuation character
+	backslashEscape = regexp.MustCompile("\\\\([!-/:-@\\[-`{-~])")
+)
+
+// SanitizeMarkdown removes raw HTML and unsafe constructs from markdown
+// Code blocks and inline code spans are left untouched: their contents are
+// displayed literally by markdown renderers, so "<script>" inside code is
+// harmless text the author intended to show
+func SanitizeMarkdown(markdown string) string {
+	lines := strings.Split(markdown, "\n")
+	out := make([]string, 0, len(lines))
+
+	// Prose lines are collected and sanitized as a block so that HTML elements
+	// spanning several lines are removed as a whole
+	prose := make([]string, 0)
+	flushProse := func() {
+		if len(prose) == 0 {
+			return
+		}
+		out = append(out, sanitizeProse(strings.Join(prose, "\n")))
+		prose = prose[:0]
+	}
+
+	var fence string   // The opening fence while inside a fenced block, "" otherwise
+	paragraph := false // Whether the previous line was paragraph text
+	for _, line := range lines {
+		if fence != "" {
+			out = append(out, line)
+			if isClosingFence(line, fence) {
+				fence = ""
+			}
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			prose = append(prose, line)
+			paragraph = false
+			continue
+		}
+
+		// An indented line is code unless it continues a paragraph
+		if indentation(line) >= 4 && !paragraph {
+			flushProse()
+			out = append(out, line)
+			continue
+		}
+
+		if opening := openingFence(line); opening != "" {
+			flushProse()
+			out = append(out, line)
+			fence = opening
+			paragraph = false
+			continue
+		}
+
+		prose = append(prose, clampQuoteDepth(line))
+		paragraph = true
+	}
+	flushProse()
+
+	return strings.Join(out, "\n")
+}
+
+// indentation returns the column of the first non-blank character in line,
+// with tabs stopping at multiples of 4 as in CommonMark
+func indentation(line string) int {
+	column := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			column++
+		case '\t':
+			column += 4 - column%4
+		default:
+			return column
+		}
+	}
+	return column
+}
+
+// openingFence returns the fence marker (e.g. "```" or "~~~~") if line opens a
+// fenced code block, or "" otherwise
+// Per CommonMark, a backtick fence's info string may not contain backticks, so
+// "```go `x`" is inline code rather than a fence
+func openingFence(line string) string {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		// Four spaces of indentation make an indented code block, not a fence
+		return ""
+	}
+
+	for _, char := range []byte{'`', '~'} {
+		n := 0
+		for n < len(trimmed) && trimmed[n] == char {
+			n++
+		}
+		if n < 3 {
+			continue
+		}
+		if char == '`' && strings.Contains(trimmed[n:], "`") {
+			return ""
+		}
+		return trimmed[:n]
+	}
+	return ""
+}
+
+// isClosingFence reports whether line closes a block opened with fence
+// The closing fence must use the same character, be at least as long, and have
+// nothing but whitespace after it, so lines inside the block that merely
+// contain backticks don't end it early
+func isClosingFence(line, fence string) bool {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return false
+	}
+
+	n := 0
+	for n < len(trimmed) && trimmed[n] == fence[0] {
+		n++
+	}
+	return n >= len(fence) && strings.TrimSpace(trimmed[n:]) == ""
+}
+
+// clampQuoteDepth flattens blockquote markers beyond maxQuoteDepth
+// Example with a limit of 2: "> > > deep" -> "> > deep"
+func clampQuoteDepth(line string) string {
+	depth := 0
+	i := 0
+	for ; i < len(line); i++ {
+		if line[i] == '>' {
+			depth++
+		} else if line[i] != ' ' && line[i] != '\t' {
+			break
+		}
+	}
+	if depth <= maxQuoteDepth {
+		return line
+	}
+	return strings.Repeat("> ", maxQuoteDepth) + line[i:]
+}
+
+// sanitizeProse strips HTML and unsafe link destinations from text outside
+// code blocks
+// Text is scanned left to right because raw HTML and code spans have equal
+// precedence in markdown: whichever starts first wins
+// That means "`<b>`" keeps its tag (it's code) while "<img src=`x` onerror=...>"
+// is removed entirely (it's a tag that happens to contain backticks)
+// Link destinations are found more eagerly than a renderer would find them,
+// since neutralizing a destination that wasn't one only changes some text
+func sanitizeProse(text string) string {
+	var b strings.Builder
+	lineStart := true
+	for len(text) > 0 {
+		if lineStart {
+			lineStart = false
+			text = neutralizeReference(text)
+		}
+
+		i := strings.IndexAny(text, "<`]\n")
+		if i < 0 {
+			b.WriteString(text)
+			break
+		}
+		b.WriteString(text[:i])
+		text = text[i:]
+
+		switch text[0] {
+		case '\n':
+			b.WriteByte('\n')
+			text = text[1:]
+			lineStart = true
+			continue
+
+		case ']':
+			// "](" starts the destination of an inline link or image
+			b.WriteByte(']')
+			text = text[1:]
+			if strings.HasPrefix(text, "(") {
+				b.WriteByte('(')
+				text = neutralizeDestination(text[1:])
+			}
+			continue
+
+		case '`':
+			// A code span opens with a run of N backticks and closes with a run of exactly N
+			run := 1
+			for run < len(text) && text[run] == '`' {
+				run++
+			}
+			closing := findBacktickRun(text[run:], run)
+			if closing < 0 {
+				// No matching run: the backticks are literal text
+				b.WriteString(text[:run])
+				text = text[run:]
+				continue
+			}
+			end := run + closing + run
+			b.WriteString(text[:end])
+			text = text[end:]
+			continue
+		}
+
+		if n := htmlLength(text); n > 0 {
+			text = text[n:]
+			continue
+		}
+		b.WriteByte('<')
+		text = text[1:]
+	}
+
+	return b.String()
+}
+
+// neutralizeReference replaces the destination of a link reference
+// definition ("[label]: destination") at the start of text with "#" if it's
+// unsafe
+// Blockquote markers and any indentation before the label are skipped
+func neutralizeReference(text string) string {
+	i := 0
+	for i < len(text) && (text[i] == '>' || text[i] == ' ' || text[i] == '\t') {
+		i++
+	}
+	if i == len(text) || text[i] != '[' {
+		return text
+	}
+
+	// The label ends at the first unescaped "]" and may not contain "["
+	i++
+	for ; i < len(text) && text[i] != ']'; i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '[':
+			return text
+		}
+	}
+	if i+1 >= len(text) || text[i+1] != ':' {
+		return text
+	}
+
+	return text[:i+2] + neutralizeDestination(text[i+2:])
+}
+
+// neutralizeDestination replaces the link destination at the start of s,
+// after optional whitespace, with "#" if it's unsafe
+func neutralizeDestination(s string) string {
+	start := skipLinkWhitespace(s)
+	end := start + destinationLength(s[start:])
+	if end == start || !isUnsafeDestination(s[start:end]) {
+		return s
+	}
+	return s[:start] + "#" + s[end:]
+}
+
+// skipLinkWhitespace returns the length of the spaces and tabs, with at most
+// one line break, at the start of s
+func skipLinkWhitespace(s string) int {
+	newline := false
+	i := 0
+	for ; i < len(s); i++ {
+		if s[i] == '\n' {
+			if newline {
+				break
+			}
+			newline = true
+		} else if s[i] != ' ' && s[i] != '\t' {
+			break
+		}
+	}
+	return i
+}
+
+// destinationLength returns the length of the link destination at the start
+// of s, or 0 if there's none
+// A destination is either enclosed in "<>" or runs up to whitespace, with
+// parentheses balanced; backslashes escape either form's delimiters
+func destinationLength(s string) int {
+	if strings.HasPrefix(s, "<") {
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '>':
+				return i + 1
+			case '<', '\n':
+				return 0
+			}
+		}
+		return 0
+	}
+
+	depth := 0
+	i := 0
+	for ; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c == 0x7f {
+			break
+		}
+		if c == '\\' && i+1 < len(s) {
+			i++
+			continue
+		}
+		if c == '(' {
+			depth++
+		} else if c == ')' {
+			if depth == 0 {
+				break
+			}
+			depth--
+		}
+	}
+	return i
+}
+
+// isUnsafeDestination reports whether a link destination uses a URL scheme
+// that can run script
+// It's decoded the way a renderer and then a browser would decode it:
+// backslash escapes and HTML entities first, then with tabs and line breaks
+// removed and leading control characters and spaces trimmed
+func isUnsafeDestination(destination string) bool {
+	destination = strings.TrimSuffix(strings.TrimPrefix(destination, "<"), ">")
+	destination = backslashEscape.ReplaceAllString(destination, "$1")
+	destination = html.UnescapeString(destination)
+	destination = strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, destination)
+	destination = strings.TrimLeftFunc(destination, func(r rune) bool { return r <= ' ' })
+	destination = strings.ToLower(destination)
+
+	for _, scheme := range unsafeSchemes {
+		if strings.HasPrefix(destination, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// htmlLength returns the length of the HTML construct at the start of text,
+// or 0 if text doesn't start with one (or starts with an autolink, which is kept)
+func htmlLength(text string) int {
+	if autolink.MatchString(text) {
+		return 0
+	}
+	for _, pattern := range htmlPatterns {
+		if loc := pattern.FindStringIndex(text); loc != nil {
+			return loc[1]
+		}
+	}
+	return 0
+}
+
+// findBacktickRun returns the index of the first run of exactly n backticks in s, or -1
+func findBacktickRun(s string, n int) int {
+	for i := 0; i < len(s); {
+		if s[i] != '`' {
+			i++
+			continue
+		}
+		j := i
+		for j < len(s) && s[j] == '`' {
+			j++
+		}
+		if j-i == n {
+			return i
+		}
+		i = j
+	}
+	return -1
+}
@@ -0,0 +1,70 @@
+package content
+
+import "testing"
+
+// TestSanitizeMarkdown checks the sanitizer against HTML hidden in links and
+// code, link destinations in every form markdown allows, and code that must
+// come through unchanged
+func TestSanitizeMarkdown(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		want string
+	}{
+		// Raw HTML
+		{"tag", "hi <b>there</b>", "hi there"},
+		{"script with contents", "a<script>alert(1)</script>b", "ab"},
+		{"multi-line element", "a<script>\nalert(1)\n</script>b", "ab"},
+		{"comment", "a<!-- hidden -->b", "ab"},
+		{"tag with backticks", "<img src=`x` onerror=alert(1)>", ""},
+		{"autolink", "see <https://example.com>", "see <https://example.com>"},
+		{"script autolink", "<javascript:alert(1)>", ""},
+		{"lone angle bracket", "1 < 2", "1 < 2"},
+		{"script inside link text", "[<script>alert(1)</script>](https://example.com)", "[](https://example.com)"},
+
+		// Inline link destinations
+		{"safe link", "[a](https://example.com)", "[a](https://example.com)"},
+		{"javascript link", "[a](javascript:alert(1))", "[a](#)"},
+		{"image", "![a](data:image/svg+xml,x)", "![a](#)"},
+		{"vbscript", "[a](vbscript:msgbox)", "[a](#)"},
+		{"upper case", "[a](JaVaScRiPt:alert(1))", "[a](#)"},
+		{"nested parentheses", "[a](javascript:alert((1)))", "[a](#)"},
+		{"pointy brackets", "[a](<javascript:alert(1)>)", "[a](#)"},
+		{"leading whitespace", "[a](  \njavascript:alert(1))", "[a](  \n#)"},
+		{"title kept", `[a](javascript:x "title")`, `[a](# "title")`},
+		{"decimal entity", "[a](&#106;avascript:x)", "[a](#)"},
+		{"hex entity", "[a](&#x6A;avascript:x)", "[a](#)"},
+		{"named entity", "[a](javascript&colon;x)", "[a](#)"},
+		{"entity tab", "[a](java&#9;script:x)", "[a](#)"},
+		{"backslash escape", `[a](javascript\:x)`, "[a](#)"},
+		{"relative path", "[a](javascript.html)", "[a](javascript.html)"},
+		{"scheme in path", "[a](/?next=javascript:x)", "[a](/?next=javascript:x)"},
+
+		// Reference definitions
+		{"reference", "[a]\n\n[a]: javascript:alert(1)", "[a]\n\n[a]: #"},
+		{"reference with title", `[a]: javascript:x "t"`, `[a]: # "t"`},
+		{"reference on next line", "[a]:\n  javascript:x", "[a]:\n  #"},
+		{"reference in quote", "> [a]: <javascript:x>", "> [a]: #"},
+		{"safe reference", "[a]: https://example.com", "[a]: https://example.com"},
+
+		// Code
+		{"code span", "`<b>` and `[a](javascript:x)`", "`<b>` and `[a](javascript:x)`"},
+		{"double backtick span", "``a ` <b> ``", "``a ` <b> ``"},
+		{"unclosed backtick", "`<b>", "`"},
+		{"fenced block", "```\n<script>x</script>\n[a](javascript:x)\n```", "```\n<script>x</script>\n[a](javascript:x)\n```"},
+		{"backticks inside fence", "````\n```\n<b>\n````\n<b>", "````\n```\n<b>\n````\n"},
+		{"tilde fence", "~~~\n<b>\n~~~", "~~~\n<b>\n~~~"},
+		{"backtick info string", "```go `x`\n<b>", "```go `x`\n"},
+		{"indented block", "    <b>\n    [a]: javascript:x", "    <b>\n    [a]: javascript:x"},
+		{"indented after blank line", "text\n\n    <b>", "text\n\n    <b>"},
+		{"indented continuation", "text\n    <b>x", "text\n    x"},
+
+		// Blockquotes
+		{"deep quote", "> > > > > > > deep", "> > > > > deep"},
+		{"shallow quote", "> > fine", "> > fine"},
+	} {
+		if got := SanitizeMarkdown(tc.in); got != tc.want {
+			t.Errorf("%s: SanitizeMarkdown(%q) = %q, want %q", tc.name, tc.in, got, tc.want)
+		}
+	}
+}
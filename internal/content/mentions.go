@@ -0,0 +1,49 @@
+package content
+
+import "regexp"
+
+// mentionPattern matches @username
+// Names may contain letters, digits and underscores, with single dots or dashes
+// inside, so the period in "thanks @jane." isn't taken as part of the name
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([\p{L}\p{N}_]+(?:[.-][\p{L}\p{N}_]+)*)`)
+
+// Mentions returns the distinct usernames mentioned in a message, in order of appearance
+// Names aren't checked against any user list; callers resolve them
+func Mentions(body string) []string {
+	matches := mentionPattern.FindAllStringSubmatch(body, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	seen := make(map[string]bool, len(matches))
+	names := make([]string, 0, len(matches))
+	for _, m := range matches {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// Room-wide mentions: @here notifies the members connected to the room, @room
+// every member. They're written like usernames, so Mentions lists them too
+const (
+	MentionHere = "here"
+	MentionRoom = "room"
+)
+
+// RoomMention returns MentionRoom or MentionHere if the message mentions the
+// whole room, MentionRoom winning when it has both, else ""
+func RoomMention(body string) string {
+	scope := ""
+	for _, name := range Mentions(body) {
+		switch name {
+		case MentionRoom:
+			return MentionRoom
+		case MentionHere:
+			scope = MentionHere
+		}
+	}
+	return scope
+}
@@ -0,0 +1,48 @@
+package content
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestMentions checks which @names are taken from a message: punctuation
+// around a name isn't part of it, email addresses aren't mentions, and
+// each name is listed once in order of appearance
+func TestMentions(t *testing.T) {
+	for _, tc := range []struct {
+		body string
+		want []string
+	}{
+		{"no mentions here", nil},
+		{"@ada hi", []string{"ada"}},
+		{"thanks @grace.", []string{"grace"}},
+		{"cc @grace.hopper, @linus-t and @ken_t!", []string{"grace.hopper", "linus-t", "ken_t"}},
+		{"@ada @grace @ada", []string{"ada", "grace"}},
+		{"mail ada@example.com", nil},
+		{"@@ada", nil},
+		{"(@ada)", []string{"ada"}},
+		{"hej @åsa och @José", []string{"åsa", "José"}},
+		{"just an @ sign", nil},
+	} {
+		if got := Mentions(tc.body); !slices.Equal(got, tc.want) {
+			t.Errorf("Mentions(%q) = %q, want %q", tc.body, got, tc.want)
+		}
+	}
+}
+
+// TestRoomMention checks @room wins over @here and neither is found inside
+// a longer name or an email address
+func TestRoomMention(t *testing.T) {
+	for body, want := range map[string]string{
+		"@here standup in 5":      MentionHere,
+		"@room the build is red":  MentionRoom,
+		"@here and @room":         MentionRoom,
+		"@hereford @roomba":       "",
+		"mail room@example.com":   "",
+		"no mention, just a room": "",
+	} {
+		if got := RoomMention(body); got != want {
+			t.Errorf("RoomMention(%q) = %q, want %q", body, got, want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package content
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// WordlistFilter rejects or masks words listed in a file
+//
+// The file has one entry per line, optionally followed by an action:
+//
+//	# Lines starting with # are comments
+//	darn            -> masked (the default action)
+//	heck* mask      -> masks "heck", "heckin", ...
+//	*spam* reject   -> rejects any message containing a word with "spam" in it
+//
+// Matching is case-insensitive and on whole words; "*" matches any run of
+// letters or digits. Entries are compiled when the file is loaded: exact
+// words go into a map and wildcard entries into one anchored regexp per
+// action, so checking a message is one pass over its words
+type WordlistFilter struct {
+	path    string
+	matcher atomic.Pointer[wordlistMatcher] // Swapped as a whole on Reload
+}
+
+// wordRegexp finds the words of a message: runs of Unicode letters and digits
+var wordRegexp = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// wordlistMatcher is the compiled form of a wordlist
+type wordlistMatcher struct {
+	reject wordSet
+	mask   wordSet
+}
+
+// wordSet matches words against the entries for one action
+type wordSet struct {
+	exact    map[string]bool // Lowercased entries without wildcards
+	wildcard *regexp.Regexp  // Anchored alternation of wildcard entries, nil if none
+}
+
+// matches reports whether a single word is in the set
+func (ws wordSet) matches(word string) bool {
+	word = strings.ToLower(word)
+	if ws.exact[word] {
+		return true
+	}
+	return ws.wildcard != nil && ws.wildcard.MatchString(word)
+}
+
+// NewWordlistFilter loads and compiles the wordlist at path
+func NewWordlistFilter(path string) (*WordlistFilter, error) {
+	f := &WordlistFilter{path: path}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload re-reads the wordlist file
+// On error the previous list stays in effect, so a typo in the file can't
+// switch moderation off
+func (f *WordlistFilter) Reload() error {
+	matcher, err := loadWordlist(f.path)
+	if err != nil {
+		return err
+	}
+	f.matcher.Store(matcher)
+	return nil
+}
+
+// Check rejects the message if it contains a "reject" word, otherwise masks
+// every "mask" word with asterisks
+func (f *WordlistFilter) Check(_ context.Context, content string) (Verdict, string, error) {
+	m := f.matcher.Load()
+	words := wordRegexp.FindAllStringIndex(content, -1)
+
+	for _, loc := range words {
+		if m.reject.matches(content[loc[0]:loc[1]]) {
+			return VerdictReject, "", nil
+		}
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, loc := range words {
+		word := content[loc[0]:loc[1]]
+		if !m.mask.matches(word) {
+			continue
+		}
+		b.WriteString(content[last:loc[0]])
+		b.WriteString(strings.Repeat("*", utf8.RuneCountInString(word)))
+		last = loc[1]
+	}
+	if last == 0 {
+		return VerdictAllow, content, nil
+	}
+	b.WriteString(content[last:])
+
+	return VerdictMask, b.String(), nil
+}
+
+// loadWordlist parses a wordlist file and compiles its entries
+func loadWordlist(path string) (*wordlistMatcher, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var reject, mask []string // Raw entries per action
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"word [mask|reject]\"", path, lineNo)
+		}
+
+		entry := strings.ToLower(fields[0])
+		action := "mask"
+		if len(fields) == 2 {
+			action = strings.ToLower(fields[1])
+		}
+
+		switch action {
+		case "mask":
+			mask = append(mask, entry)
+		case "reject":
+			reject = append(reject, entry)
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown action %q", path, lineNo, action)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &wordlistMatcher{reject: compileWords(reject), mask: compileWords(mask)}, nil
+}
+
+// compileWords builds the word set for one action's entries
+// Everything in an entry except "*" is matched literally
+func compileWords(entries []string) wordSet {
+	ws := wordSet{exact: make(map[string]bool)}
+	var patterns []string
+	for _, entry := range entries {
+		if !strings.Contains(entry, "*") {
+			ws.exact[entry] = true
+			continue
+		}
+		parts := strings.Split(entry, "*")
+		for i, part := range parts {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+		patterns = append(patterns, strings.Join(parts, `[\p{L}\p{N}]*`))
+	}
+	if len(patterns) > 0 {
+		ws.wildcard = regexp.MustCompile(`^(?:` + strings.Join(patterns, "|") + `)$`)
+	}
+	return ws
+}
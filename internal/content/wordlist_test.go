@@ -0,0 +1,135 @@
+package content
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeWordlist writes a wordlist file in a temporary directory and returns its path
+func writeWordlist(t testing.TB, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "wordlist.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestWordlistFilter checks messages against a list with exact and wildcard
+// entries of both actions: matching is on whole words and ignores case, a
+// reject word wins over any masking, and masks keep the word's length in
+// characters
+func TestWordlistFilter(t *testing.T) {
+	filter, err := NewWordlistFilter(writeWordlist(t,
+		"# Moderation list",
+		"darn",
+		"heck* mask",
+		"*spam* REJECT",
+		"",
+		"grüß",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		in      string
+		verdict Verdict
+		want    string
+	}{
+		{"hello there", VerdictAllow, "hello there"},
+		{"well darn it", VerdictMask, "well **** it"},
+		{"DARN, Darn!", VerdictMask, "****, ****!"},
+		{"darning needles", VerdictAllow, "darning needles"},
+		{"heck, heckin heckler", VerdictMask, "****, ****** *******"},
+		{"check", VerdictAllow, "check"},
+		{"grüß dich", VerdictMask, "**** dich"},
+		{"buy spam now", VerdictReject, ""},
+		{"darn antispamming", VerdictReject, ""},
+		{"", VerdictAllow, ""},
+	} {
+		verdict, got, err := filter.Check(context.Background(), tc.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if verdict != tc.verdict || got != tc.want {
+			t.Errorf("%q: got %d %q, want %d %q", tc.in, verdict, got, tc.verdict, tc.want)
+		}
+	}
+}
+
+// TestWordlistErrors loads lists that can't be used: they fail with the line
+// at fault, and a reload that fails keeps the list that was in effect
+func TestWordlistErrors(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want string
+	}{
+		{"darn mask please", ":1: expected"},
+		{"darn hide", `:1: unknown action "hide"`},
+	} {
+		if _, err := NewWordlistFilter(writeWordlist(t, tc.line)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: got %v, want an error containing %q", tc.line, err, tc.want)
+		}
+	}
+	if _, err := NewWordlistFilter(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("a missing wordlist loaded")
+	}
+
+	path := writeWordlist(t, "darn")
+	filter, err := NewWordlistFilter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("darn hide"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := filter.Reload(); err == nil {
+		t.Fatal("reloading a broken list succeeded")
+	}
+	if verdict, _, _ := filter.Check(context.Background(), "darn"); verdict != VerdictMask {
+		t.Error("a failed reload switched the previous list off")
+	}
+
+	if err := os.WriteFile(path, []byte("heck"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := filter.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if verdict, _, _ := filter.Check(context.Background(), "darn heck"); verdict != VerdictMask {
+		t.Error("the reloaded list isn't in effect")
+	}
+	if _, got, _ := filter.Check(context.Background(), "darn heck"); got != "darn ****" {
+		t.Errorf("got %q after reloading, want only heck masked", got)
+	}
+}
+
+// BenchmarkWordlistFilter checks a typical chat message against a list of a
+// thousand entries, a tenth of them wildcards
+func BenchmarkWordlistFilter(b *testing.B) {
+	lines := make([]string, 0, 1000)
+	for i := range 1000 {
+		switch {
+		case i%10 == 0:
+			lines = append(lines, fmt.Sprintf("bad%d* reject", i))
+		default:
+			lines = append(lines, fmt.Sprintf("word%d", i))
+		}
+	}
+	filter, err := NewWordlistFilter(writeWordlist(b, lines...))
+	if err != nil {
+		b.Fatal(err)
+	}
+	message := "Hey everyone, the deploy went out at noon and word42 looks fine so far, let me know if anything breaks"
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		filter.Check(context.Background(), message)
+	}
+}
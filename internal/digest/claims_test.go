@@ -0,0 +1,89 @@
+//go:build integration
+
+package digest
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/notify"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testdb"
+	"github.com/lib/pq"
+)
+
+// TestClaimsConcurrently runs two schedulers on the scratch database at once
+// over 40 opted-in users, each with one unread message: each user is
+// emailed once and has one digest_runs row for the day
+// The users pick a timezone no other test uses, so only they are due
+func TestClaimsConcurrently(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	st := store.NewPostgresStorage(store.NewPools(db, nil), store.Limits{})
+	suffix := time.Now().UnixNano()
+	const zone = "Pacific/Chatham"
+
+	seed := func(name string, enabled bool) int64 {
+		t.Helper()
+		var id int64
+		query := `
+			INSERT INTO users (username, email, password, digest_enabled, digest_hour, digest_timezone)
+			VALUES ($1, $1 || '@example.invalid', '!', $2, 0, $3) RETURNING id
+		`
+		if err := db.QueryRowContext(ctx, query, fmt.Sprintf("digest-%s-%d", name, suffix), enabled, zone).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Exec(`DELETE FROM users WHERE id = $1`, id) })
+		return id
+	}
+	poster := seed("poster", false)
+	var readers []int64
+	for i := range 40 {
+		readers = append(readers, seed(fmt.Sprint(i), true))
+	}
+
+	room := &store.Room{Name: fmt.Sprintf("digest-%d", suffix), CreatedBy: poster}
+	if err := st.Rooms.Create(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec(`DELETE FROM rooms WHERE id = $1`, room.ID) })
+	if _, err := db.ExecContext(ctx, `INSERT INTO room_members (room_id, user_id, role) SELECT $1, unnest($2::bigint[]), 'member'`, room.ID, pq.Array(readers)); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Messages.Create(ctx, &store.Message{RoomID: room.ID, UserID: poster, Content: "anyone around?"}); err != nil {
+		t.Fatal(err)
+	}
+
+	mailer := &fakeMailer{}
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scheduler := NewScheduler(st, mailer, notify.NewCachedPolicy(st, time.Minute), time.Minute)
+			scheduler.backoff = 0
+			if _, err := scheduler.RunOnce(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got := mailer.recipients()
+	slices.Sort(got)
+	if len(got) != len(readers) || len(slices.Compact(got)) != len(readers) {
+		t.Errorf("sent %d emails to %d users, want one each to %d", len(mailer.sent), len(slices.Compact(got)), len(readers))
+	}
+	var runs int
+	query := `SELECT COUNT(*) FROM digest_runs WHERE user_id = ANY($1) AND status = 'sent'`
+	if err := db.QueryRowContext(ctx, query, pq.Array(readers)).Scan(&runs); err != nil {
+		t.Fatal(err)
+	}
+	if runs != len(readers) {
+		t.Errorf("there are %d sent runs, want %d", runs, len(readers))
+	}
+}
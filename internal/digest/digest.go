@@ -0,0 +1,241 @@
+// Package digest emails users a daily summary of what they missed
+//
+// Users opt in and pick a local hour and timezone. A Scheduler wakes up every
+// few minutes, works out which users' hour has come, claims their digest for
+// the day in the database and sends it. Claims make each digest go out at
+// most once, even with the scheduler running on several instances
+package digest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/mail"
+	"github.com/drazan344/go-chat/internal/notify"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+const (
+	// DefaultInterval is how often the scheduler looks for due digests
+	DefaultInterval = 5 * time.Minute
+
+	// claimBatchSize is how many digests are claimed and loaded at once
+	claimBatchSize = 100
+
+	// firstDigestWindow is how far back a user's first digest looks
+	firstDigestWindow = 24 * time.Hour
+
+	// maxSendAttempts is how often a digest is tried before it's marked failed
+	maxSendAttempts = 3
+
+	// initialSendBackoff is the wait before the first retry; it doubles after each attempt
+	initialSendBackoff = time.Second
+
+	// topRooms is how many of the busiest rooms a digest highlights
+	topRooms = 3
+)
+
+// Scheduler sends daily digests when they're due
+type Scheduler struct {
+	store    store.Storage
+	mailer   mail.Mailer
+	policy   notify.Policy
+	interval time.Duration
+	backoff  time.Duration // The wait before the first retry of a failed send
+
+	// now is the clock; swap it with SetClock to run the scheduler at a chosen time
+	now func() time.Time
+}
+
+// NewScheduler creates a scheduler that checks for due digests every interval
+// Users whose policy turns off digest emails are skipped
+func NewScheduler(st store.Storage, mailer mail.Mailer, policy notify.Policy, interval time.Duration) *Scheduler {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Scheduler{store: st, mailer: mailer, policy: policy, interval: interval, backoff: initialSendBackoff, now: time.Now}
+}
+
+// SetClock replaces the scheduler's clock
+func (s *Scheduler) SetClock(now func() time.Time) {
+	s.now = now
+}
+
+// Run checks for due digests every interval until ctx is cancelled
+// It should be started in a goroutine
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if sent, err := s.RunOnce(ctx); err != nil {
+			log.Printf("Digest run failed: %v", err)
+		} else if sent > 0 {
+			log.Printf("Sent %d digests", sent)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce sends every digest that is due now
+// Users are grouped by timezone, since that decides both their local date
+// (which digest is due) and their local hour (whether it's due yet)
+// Cancelling ctx stops further claims; digests already claimed are still sent,
+// as a claimed digest is never picked up again
+// Returns the number of digests sent
+func (s *Scheduler) RunOnce(ctx context.Context) (int, error) {
+	now := s.now()
+
+	zones, err := s.store.Digests.Timezones(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, zone := range zones {
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			// Settings are validated on save, so this is a zone the server lost
+			log.Printf("Skipping digests for unknown timezone %q: %v", zone, err)
+			continue
+		}
+		local := now.In(loc)
+
+		for ctx.Err() == nil {
+			claimed, err := s.store.Digests.ClaimDue(ctx, zone, local, local.Hour(), claimBatchSize)
+			if err != nil {
+				return sent, err
+			}
+			if len(claimed) == 0 {
+				break
+			}
+			sent += s.deliver(context.WithoutCancel(ctx), claimed, local, now)
+		}
+		if ctx.Err() != nil {
+			return sent, ctx.Err()
+		}
+	}
+	return sent, nil
+}
+
+// deliver builds and sends the claimed digests for day
+// Returns the number sent
+func (s *Scheduler) deliver(ctx context.Context, userIDs []int64, day, now time.Time) int {
+	loadCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	recipients, err := s.store.Digests.LoadRecipients(loadCtx, userIDs, now.Add(-firstDigestWindow))
+	cancel()
+	if err != nil {
+		log.Printf("Failed to load %d digests: %v", len(userIDs), err)
+		for _, userID := range userIDs {
+			s.finish(ctx, userID, day, store.DigestFailed, err.Error())
+		}
+		return 0
+	}
+
+	// Users who turned off digest emails in their notification preferences
+	// are skipped; one lookup covers the whole batch
+	wanted := make(map[int64]bool, len(recipients))
+	for _, userID := range s.policy.Filter(ctx, userIDs, store.NotifyChannelEmail, store.NotifyEventDigest) {
+		wanted[userID] = true
+	}
+
+	sent := 0
+	for _, recipient := range recipients {
+		if !wanted[recipient.UserID] {
+			s.finish(ctx, recipient.UserID, day, store.DigestSkipped, "")
+			continue
+		}
+
+		digest := Build(recipient)
+		if digest == nil {
+			s.finish(ctx, recipient.UserID, day, store.DigestSkipped, "")
+			continue
+		}
+
+		message, err := Render(recipient, digest, day.Location())
+		if err == nil {
+			err = s.send(ctx, message)
+		}
+		if err != nil {
+			log.Printf("Digest for user %d failed: %v", recipient.UserID, err)
+			s.finish(ctx, recipient.UserID, day, store.DigestFailed, err.Error())
+			continue
+		}
+		s.finish(ctx, recipient.UserID, day, store.DigestSent, "")
+		sent++
+	}
+	return sent
+}
+
+// send delivers one digest, retrying with exponential backoff
+func (s *Scheduler) send(ctx context.Context, message *mail.Message) error {
+	backoff := s.backoff
+	var err error
+	for attempt := 1; attempt <= maxSendAttempts; attempt++ {
+		sendCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err = s.mailer.Send(sendCtx, message)
+		cancel()
+
+		if err == nil {
+			return nil
+		}
+		if attempt < maxSendAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return fmt.Errorf("after %d attempts: %w", maxSendAttempts, err)
+}
+
+// finish records the outcome of a digest, logging if that fails
+func (s *Scheduler) finish(ctx context.Context, userID int64, day time.Time, status, errText string) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if err := s.store.Digests.Finish(ctx, userID, day, status, errText); err != nil {
+		log.Printf("Failed to record digest %s for user %d: %v", status, userID, err)
+	}
+}
+
+// Digest is what one user's digest reports
+type Digest struct {
+	Rooms    []*store.DigestRoom // Rooms with unread messages, busiest first
+	TopRooms []*store.DigestRoom // The busiest few of Rooms
+	Unread   int                 // Unread messages across all rooms
+	Mentions int                 // Unread messages mentioning the user
+}
+
+// Build summarizes a recipient's unread activity
+// Returns nil if there's nothing new, in which case no email is sent
+func Build(recipient *store.DigestRecipient) *Digest {
+	if len(recipient.Rooms) == 0 {
+		return nil
+	}
+
+	rooms := make([]*store.DigestRoom, len(recipient.Rooms))
+	copy(rooms, recipient.Rooms)
+	sort.Slice(rooms, func(i, j int) bool {
+		if rooms[i].Unread != rooms[j].Unread {
+			return rooms[i].Unread > rooms[j].Unread
+		}
+		return rooms[i].Name < rooms[j].Name
+	})
+
+	digest := &Digest{Rooms: rooms, TopRooms: rooms}
+	if len(rooms) > topRooms {
+		digest.TopRooms = rooms[:topRooms]
+	}
+	for _, room := range rooms {
+		digest.Unread += room.Unread
+		digest.Mentions += room.Mentions
+	}
+	return digest
+}
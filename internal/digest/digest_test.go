@@ -0,0 +1,401 @@
+package digest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/mail"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// fakeDigests keeps digest settings and runs in memory
+// Claims are made under the mutex, which stands in for digest_runs' primary
+// key: two schedulers claiming at once never both get a user's day
+type fakeDigests struct {
+	mu       sync.Mutex
+	settings map[int64]*store.DigestSettings
+	rooms    map[int64][]*store.DigestRoom
+	runs     map[string]string // "user/date" to status; "" while claimed
+	claims   int
+}
+
+func newFakeDigests() *fakeDigests {
+	return &fakeDigests{
+		settings: make(map[int64]*store.DigestSettings),
+		rooms:    make(map[int64][]*store.DigestRoom),
+		runs:     make(map[string]string),
+	}
+}
+
+// optIn turns digests on for a user with some unread rooms
+func (f *fakeDigests) optIn(userID int64, hour int, zone string, rooms ...*store.DigestRoom) {
+	f.settings[userID] = &store.DigestSettings{Enabled: true, Hour: hour, Timezone: zone}
+	f.rooms[userID] = rooms
+}
+
+func runKey(userID int64, day time.Time) string {
+	return fmt.Sprintf("%d/%s", userID, day.Format("2006-01-02"))
+}
+
+// status returns how a user's digest for a date ended, or "" if it wasn't claimed
+func (f *fakeDigests) status(userID int64, date string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status, ok := f.runs[fmt.Sprintf("%d/%s", userID, date)]
+	return status, ok
+}
+
+func (f *fakeDigests) GetSettings(_ context.Context, userID int64) (*store.DigestSettings, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	settings, ok := f.settings[userID]
+	if !ok {
+		return nil, errors.New("no such user")
+	}
+	copied := *settings
+	return &copied, nil
+}
+
+func (f *fakeDigests) UpdateSettings(_ context.Context, userID int64, settings *store.DigestSettings) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	copied := *settings
+	f.settings[userID] = &copied
+	return nil
+}
+
+func (f *fakeDigests) Timezones(context.Context) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var zones []string
+	for _, settings := range f.settings {
+		if settings.Enabled && !slices.Contains(zones, settings.Timezone) {
+			zones = append(zones, settings.Timezone)
+		}
+	}
+	slices.Sort(zones)
+	return zones, nil
+}
+
+func (f *fakeDigests) ClaimDue(_ context.Context, zone string, day time.Time, hour, limit int) ([]int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.claims++
+	ids := slices.Sorted(maps.Keys(f.settings))
+
+	claimed := make([]int64, 0)
+	for _, id := range ids {
+		settings := f.settings[id]
+		if !settings.Enabled || settings.Timezone != zone || settings.Hour > hour {
+			continue
+		}
+		if _, taken := f.runs[runKey(id, day)]; taken {
+			continue
+		}
+		if len(claimed) == limit {
+			break
+		}
+		f.runs[runKey(id, day)] = ""
+		claimed = append(claimed, id)
+	}
+	return claimed, nil
+}
+
+func (f *fakeDigests) LoadRecipients(_ context.Context, userIDs []int64, since time.Time) ([]*store.DigestRecipient, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	recipients := make([]*store.DigestRecipient, 0, len(userIDs))
+	for _, id := range userIDs {
+		recipients = append(recipients, &store.DigestRecipient{
+			UserID:   id,
+			Username: fmt.Sprintf("user%d", id),
+			Email:    fmt.Sprintf("user%d@example.invalid", id),
+			Since:    since,
+			Rooms:    f.rooms[id],
+		})
+	}
+	return recipients, nil
+}
+
+func (f *fakeDigests) Finish(_ context.Context, userID int64, day time.Time, status, _ string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.runs[runKey(userID, day)] = status
+	return nil
+}
+
+// fakeMailer records what it sends and fails the first failures attempts
+type fakeMailer struct {
+	mu       sync.Mutex
+	sent     []*mail.Message
+	attempts int
+	failures int
+}
+
+func (m *fakeMailer) Send(_ context.Context, message *mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attempts++
+	if m.attempts <= m.failures {
+		return errors.New("mail server unavailable")
+	}
+	m.sent = append(m.sent, message)
+	return nil
+}
+
+// recipients returns who was emailed, in order
+func (m *fakeMailer) recipients() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var to []string
+	for _, message := range m.sent {
+		to = append(to, message.To)
+	}
+	return to
+}
+
+// digestsOff is a notify.Policy where the listed users turned off digest
+// emails and everything else is allowed
+type digestsOff map[int64]bool
+
+func (d digestsOff) Allowed(_ context.Context, userID int64, channel, event string) bool {
+	return !(d[userID] && channel == store.NotifyChannelEmail && event == store.NotifyEventDigest)
+}
+
+func (d digestsOff) Filter(ctx context.Context, userIDs []int64, channel, event string) []int64 {
+	var allowed []int64
+	for _, userID := range userIDs {
+		if d.Allowed(ctx, userID, channel, event) {
+			allowed = append(allowed, userID)
+		}
+	}
+	return allowed
+}
+
+func (digestsOff) Invalidate(int64) {}
+
+// newTestScheduler creates a scheduler on digests whose clock reads at and
+// whose retries don't wait; nobody has turned off digest emails
+func newTestScheduler(digests *fakeDigests, mailer mail.Mailer, at time.Time) *Scheduler {
+	scheduler := NewScheduler(store.Storage{Digests: digests}, mailer, digestsOff{}, time.Minute)
+	scheduler.SetClock(func() time.Time { return at })
+	scheduler.backoff = 0
+	return scheduler
+}
+
+func room(id int64, name string, unread, mentions int) *store.DigestRoom {
+	return &store.DigestRoom{RoomID: id, Name: name, Unread: unread, Mentions: mentions}
+}
+
+// TestBuild orders rooms by unread count, then name, keeps the busiest three
+// on top and adds up the totals
+func TestBuild(t *testing.T) {
+	if Build(&store.DigestRecipient{}) != nil {
+		t.Error("a recipient with nothing unread got a digest")
+	}
+
+	recipient := &store.DigestRecipient{Rooms: []*store.DigestRoom{
+		room(1, "quiet", 1, 0),
+		room(2, "lobby", 12, 2),
+		room(3, "books", 5, 0),
+		room(4, "art", 5, 1),
+	}}
+	digest := Build(recipient)
+
+	var order []string
+	for _, r := range digest.Rooms {
+		order = append(order, r.Name)
+	}
+	if want := []string{"lobby", "art", "books", "quiet"}; !slices.Equal(order, want) {
+		t.Errorf("the rooms are in order %v, want %v", order, want)
+	}
+	if len(digest.TopRooms) != 3 || digest.TopRooms[2].Name != "books" {
+		t.Errorf("the top rooms are %v, want the first three", digest.TopRooms)
+	}
+	if digest.Unread != 23 || digest.Mentions != 3 {
+		t.Errorf("got %d unread and %d mentions, want 23 and 3", digest.Unread, digest.Mentions)
+	}
+	if recipient.Rooms[0].Name != "quiet" {
+		t.Error("Build reordered the recipient's rooms")
+	}
+}
+
+// TestRender puts the totals in the subject and escapes room names in HTML
+func TestRender(t *testing.T) {
+	recipient := &store.DigestRecipient{
+		Username: "ada",
+		Email:    "ada@example.invalid",
+		Since:    time.Date(2026, 3, 5, 7, 0, 0, 0, time.UTC),
+		Rooms:    []*store.DigestRoom{room(1, "<b>lobby</b>", 1, 1)},
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	message, err := Render(recipient, Build(recipient), berlin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.To != "ada@example.invalid" || message.Subject != "Your daily digest: 1 mentions, 1 unread" {
+		t.Errorf("got a message to %q with subject %q", message.To, message.Subject)
+	}
+	if !strings.Contains(message.Text, "Since Thu Mar 5 08:00 CET you have 1 unread message in 1 room, including 1 mention of you.") {
+		t.Errorf("the text body doesn't sum up the digest in the recipient's timezone:\n%s", message.Text)
+	}
+	if strings.Contains(message.HTML, "<b>lobby</b>") || !strings.Contains(message.HTML, "&lt;b&gt;lobby&lt;/b&gt;") {
+		t.Errorf("the room name isn't escaped in the HTML body:\n%s", message.HTML)
+	}
+}
+
+// TestRunOnceBuckets runs the scheduler at 23:30 UTC on Thursday 2026-03-05
+// for users who want their digest at 08:00 or 18:00: it's 08:30 on Friday
+// in Tokyo, 18:30 on Thursday in New York and 00:30 on Friday in Berlin, so
+// only the Tokyo and New York users are due, each for their own local date
+// A user with nothing unread is claimed but not emailed, and running again
+// sends nothing new
+func TestRunOnceBuckets(t *testing.T) {
+	digests := newFakeDigests()
+	digests.optIn(1, 8, "Asia/Tokyo", room(1, "lobby", 3, 0))
+	digests.optIn(2, 18, "America/New_York", room(1, "lobby", 2, 1))
+	digests.optIn(3, 8, "Europe/Berlin", room(1, "lobby", 4, 0))
+	digests.optIn(4, 8, "Asia/Tokyo")
+	digests.optIn(5, 9, "Asia/Tokyo", room(1, "lobby", 1, 0))
+	mailer := &fakeMailer{}
+	scheduler := newTestScheduler(digests, mailer, time.Date(2026, 3, 5, 23, 30, 0, 0, time.UTC))
+
+	sent, err := scheduler.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mailer.recipients(); sent != 2 || !slices.Equal(got, []string{"user2@example.invalid", "user1@example.invalid"}) {
+		t.Errorf("sent %d digests to %v, want users 2 and 1", sent, got)
+	}
+	for _, tc := range []struct {
+		user   int64
+		date   string
+		status string
+		ok     bool
+	}{
+		{1, "2026-03-06", store.DigestSent, true},
+		{2, "2026-03-05", store.DigestSent, true},
+		{3, "2026-03-06", "", false},
+		{4, "2026-03-06", store.DigestSkipped, true},
+		{5, "2026-03-06", "", false},
+	} {
+		if status, ok := digests.status(tc.user, tc.date); status != tc.status || ok != tc.ok {
+			t.Errorf("user %d on %s: got %q (claimed %v), want %q (claimed %v)", tc.user, tc.date, status, ok, tc.status, tc.ok)
+		}
+	}
+
+	if sent, err := scheduler.RunOnce(context.Background()); err != nil || sent != 0 {
+		t.Errorf("running again sent %d, %v; want nothing", sent, err)
+	}
+}
+
+// TestDigestPreference skips users who turned off digest emails in their
+// notification preferences, without retrying them later that day
+func TestDigestPreference(t *testing.T) {
+	digests := newFakeDigests()
+	digests.optIn(1, 0, "UTC", room(1, "lobby", 1, 0))
+	digests.optIn(2, 0, "UTC", room(1, "lobby", 1, 0))
+	mailer := &fakeMailer{}
+	scheduler := newTestScheduler(digests, mailer, time.Date(2026, 3, 6, 12, 0, 0, 0, time.UTC))
+	scheduler.policy = digestsOff{2: true}
+
+	if sent, err := scheduler.RunOnce(context.Background()); err != nil || sent != 1 {
+		t.Errorf("sent %d, %v; want 1", sent, err)
+	}
+	if got := mailer.recipients(); !slices.Equal(got, []string{"user1@example.invalid"}) {
+		t.Errorf("emailed %v, want user 1 only", got)
+	}
+	if status, _ := digests.status(2, "2026-03-06"); status != store.DigestSkipped {
+		t.Errorf("user 2's digest is %q, want skipped", status)
+	}
+}
+
+// TestRunOnceConcurrently runs two schedulers over the same users at once
+// and checks each user gets exactly one email
+func TestRunOnceConcurrently(t *testing.T) {
+	digests := newFakeDigests()
+	for id := int64(1); id <= 250; id++ {
+		zone := "Europe/Berlin"
+		if id%2 == 0 {
+			zone = "UTC"
+		}
+		digests.optIn(id, 0, zone, room(1, "lobby", 1, 0))
+	}
+	mailer := &fakeMailer{}
+	at := time.Date(2026, 3, 6, 12, 0, 0, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	totals := make([]int, 2)
+	for i := range totals {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sent, err := newTestScheduler(digests, mailer, at).RunOnce(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+			totals[i] = sent
+		}()
+	}
+	wg.Wait()
+
+	got := mailer.recipients()
+	slices.Sort(got)
+	if len(got) != 250 || len(slices.Compact(got)) != 250 || totals[0]+totals[1] != 250 {
+		t.Errorf("the schedulers sent %v, %d emails to %d users; want one each to 250", totals, len(mailer.sent), len(slices.Compact(got)))
+	}
+}
+
+// TestSendRetries retries a failing send up to three times, then records
+// the digest as failed
+func TestSendRetries(t *testing.T) {
+	at := time.Date(2026, 3, 6, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		failures int
+		status   string
+	}{
+		{0, store.DigestSent},
+		{2, store.DigestSent},
+		{3, store.DigestFailed},
+	} {
+		digests := newFakeDigests()
+		digests.optIn(1, 0, "UTC", room(1, "lobby", 1, 0))
+		mailer := &fakeMailer{failures: tc.failures}
+		newTestScheduler(digests, mailer, at).RunOnce(context.Background())
+
+		if status, _ := digests.status(1, "2026-03-06"); status != tc.status {
+			t.Errorf("%d failures: the digest is %q, want %q", tc.failures, status, tc.status)
+		}
+		if want := min(tc.failures+1, maxSendAttempts); mailer.attempts != want {
+			t.Errorf("%d failures: tried %d times, want %d", tc.failures, mailer.attempts, want)
+		}
+	}
+}
+
+// TestRunOnceCancelled stops claiming once the context is cancelled
+func TestRunOnceCancelled(t *testing.T) {
+	digests := newFakeDigests()
+	digests.optIn(1, 0, "UTC", room(1, "lobby", 1, 0))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	sent, err := newTestScheduler(digests, &fakeMailer{}, time.Now()).RunOnce(ctx)
+	if !errors.Is(err, context.Canceled) || sent != 0 || digests.claims != 0 {
+		t.Errorf("got %d sent, %v after %d claims; want nothing claimed and context.Canceled", sent, err, digests.claims)
+	}
+}
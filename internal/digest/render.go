@@ -0,0 +1,95 @@
+package digest
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/mail"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// templateData is what the digest templates are rendered with
+type templateData struct {
+	Username string
+	Since    string
+	Digest   *Digest
+}
+
+// Both bodies say the same thing; plural picks "message" or "messages"
+var templateFuncs = map[string]interface{}{
+	"plural": func(n int, one, many string) string {
+		if n == 1 {
+			return one
+		}
+		return many
+	},
+}
+
+var textTemplate = texttemplate.Must(texttemplate.New("digest").Funcs(templateFuncs).Parse(
+	`Hi {{.Username}},
+
+Since {{.Since}} you have {{.Digest.Unread}} unread {{plural .Digest.Unread "message" "messages"}} in {{len .Digest.Rooms}} {{plural (len .Digest.Rooms) "room" "rooms"}}
+{{- if .Digest.Mentions}}, including {{.Digest.Mentions}} {{plural .Digest.Mentions "mention" "mentions"}} of you{{end}}.
+
+Busiest rooms:
+{{range .Digest.TopRooms}}  - {{.Name}}: {{.Unread}} {{plural .Unread "message" "messages"}}{{if .Mentions}} ({{.Mentions}} {{plural .Mentions "mention" "mentions"}}){{end}}
+{{end}}
+Rooms with unread messages:
+{{range .Digest.Rooms}}  - {{.Name}} ({{.Unread}})
+{{end}}
+You're getting this because daily digests are on in your settings.
+`))
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("digest").Funcs(templateFuncs).Parse(
+	`<!DOCTYPE html>
+<html>
+<body>
+<p>Hi {{.Username}},</p>
+<p>Since {{.Since}} you have <strong>{{.Digest.Unread}}</strong> unread {{plural .Digest.Unread "message" "messages"}} in {{len .Digest.Rooms}} {{plural (len .Digest.Rooms) "room" "rooms"}}
+{{- if .Digest.Mentions}}, including <strong>{{.Digest.Mentions}}</strong> {{plural .Digest.Mentions "mention" "mentions"}} of you{{end}}.</p>
+<h3>Busiest rooms</h3>
+<ul>
+{{range .Digest.TopRooms}}<li><strong>{{.Name}}</strong>: {{.Unread}} {{plural .Unread "message" "messages"}}{{if .Mentions}} ({{.Mentions}} {{plural .Mentions "mention" "mentions"}}){{end}}</li>
+{{end}}</ul>
+<h3>Rooms with unread messages</h3>
+<ul>
+{{range .Digest.Rooms}}<li>{{.Name}} ({{.Unread}})</li>
+{{end}}</ul>
+<p>You're getting this because daily digests are on in your settings.</p>
+</body>
+</html>
+`))
+
+// Render turns a digest into an email for the recipient
+// Times are shown in loc, the recipient's timezone
+// Room names are user input; the HTML template escapes them
+func Render(recipient *store.DigestRecipient, digest *Digest, loc *time.Location) (*mail.Message, error) {
+	data := templateData{
+		Username: recipient.Username,
+		Since:    recipient.Since.In(loc).Format("Mon Jan 2 15:04 MST"),
+		Digest:   digest,
+	}
+
+	var text, html bytes.Buffer
+	if err := textTemplate.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if err := htmlTemplate.Execute(&html, data); err != nil {
+		return nil, err
+	}
+
+	subject := fmt.Sprintf("Your daily digest: %d unread in %d rooms", digest.Unread, len(digest.Rooms))
+	if digest.Mentions > 0 {
+		subject = fmt.Sprintf("Your daily digest: %d mentions, %d unread", digest.Mentions, digest.Unread)
+	}
+
+	return &mail.Message{
+		To:      recipient.Email,
+		Subject: subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
@@ -0,0 +1,114 @@
+// Package flags decides whether a feature is on for a user in a room
+//
+// Risky features are rolled out gradually: a flag has a default, and can be
+// turned on or off for single users and rooms, all stored in the database
+// and changed through the admin API without a deploy. Every code path with
+// such a feature asks a Checker, in handlers and in the hub alike
+package flags
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/cache"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// DefaultCacheTTL is how long the flags are cached
+// Updates made through this instance invalidate the cache at once; other
+// instances pick them up within the TTL
+const DefaultCacheTTL = 30 * time.Second
+
+// Flags the code consults
+// A flag only does something once code asks about it, so the admin API
+// refuses names that aren't listed in Known
+const (
+	// PersistJoinLeave saves the hub's join and leave announcements as system
+	// messages, so they show in the room's history and not just live
+	PersistJoinLeave = "persist_join_leave"
+
+	// RoomQuotaExempt lifts the room creation quotas; meant to be set per user
+	RoomQuotaExempt = "room_quota_exempt"
+)
+
+// Known lists every flag with what it does, for the admin API
+var Known = map[string]string{
+	PersistJoinLeave: "Save join and leave announcements as system messages in the room's history",
+	RoomQuotaExempt:  "Let users create rooms without the daily and total room creation quotas",
+}
+
+// Checker answers whether a feature is on
+type Checker interface {
+	// Enabled reports whether flag is on for a user in a room
+	// Either ID may be zero when there's no user or room to go by
+	Enabled(ctx context.Context, flag string, userID, roomID int64) bool
+}
+
+// Resolve applies a flag's overrides: the user's wins, then the room's, and
+// without either the flag's default applies. A nil flag (one that was never
+// saved) is off
+// A user override is the more deliberate choice: it's how a feature is tried
+// by (or kept from) one person in every room, whatever the rooms say
+func Resolve(flag *store.FeatureFlag, userID, roomID int64) bool {
+	if flag == nil {
+		return false
+	}
+	if enabled, ok := flag.UserOverrides[userID]; ok && userID != 0 {
+		return enabled
+	}
+	if enabled, ok := flag.RoomOverrides[roomID]; ok && roomID != 0 {
+		return enabled
+	}
+	return flag.Enabled
+}
+
+// CachedChecker is the Checker backed by the feature flag store
+// All flags are loaded together and cached for the TTL; there are few of
+// them, and a hot path then never waits on the database more than once a TTL
+// If the flags can't be loaded, the last ones loaded stay in use (or all are
+// off, before the first load), and loading is retried on the next call
+type CachedChecker struct {
+	store store.Storage
+	cache *cache.TTL[struct{}, map[string]*store.FeatureFlag] // One entry: every flag by name
+}
+
+// NewCachedChecker creates a checker that caches the flags for ttl
+func NewCachedChecker(st store.Storage, ttl time.Duration) *CachedChecker {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &CachedChecker{store: st, cache: cache.New[struct{}, map[string]*store.FeatureFlag](ttl, 1)}
+}
+
+// Enabled reports whether flag is on for a user in a room (see Resolve)
+func (c *CachedChecker) Enabled(ctx context.Context, flag string, userID, roomID int64) bool {
+	return Resolve(c.load(ctx)[flag], userID, roomID)
+}
+
+// Invalidate drops the cached flags after they change
+func (c *CachedChecker) Invalidate() {
+	c.cache.Invalidate(struct{}{})
+}
+
+// load returns the flags by name, from the cache while it's fresh
+func (c *CachedChecker) load(ctx context.Context) map[string]*store.FeatureFlag {
+	now := time.Now()
+	stale, fresh, generation := c.cache.Get(struct{}{}, now)
+	if fresh {
+		return stale
+	}
+
+	// Loaded outside the lock; two callers may load at once, which is harmless
+	list, err := c.store.FeatureFlags.List(ctx)
+	if err != nil {
+		log.Printf("Failed to load feature flags, using the last ones loaded: %v", err)
+		return stale
+	}
+	flags := make(map[string]*store.FeatureFlag, len(list))
+	for _, flag := range list {
+		flags[flag.Name] = flag
+	}
+	c.cache.Put(struct{}{}, flags, generation, now)
+	return flags
+}
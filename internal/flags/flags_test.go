@@ -0,0 +1,118 @@
+package flags
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// fakeFlags keeps saved flags in memory and counts loads
+type fakeFlags struct {
+	mu    sync.Mutex
+	flags map[string]*store.FeatureFlag
+	loads int
+	err   error
+}
+
+func (f *fakeFlags) List(context.Context) ([]*store.FeatureFlag, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.loads++
+	if f.err != nil {
+		return nil, f.err
+	}
+	list := make([]*store.FeatureFlag, 0, len(f.flags))
+	for _, flag := range f.flags {
+		copied := *flag
+		list = append(list, &copied)
+	}
+	return list, nil
+}
+
+func (f *fakeFlags) Save(_ context.Context, flag *store.FeatureFlag) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	copied := *flag
+	f.flags[flag.Name] = &copied
+	return nil
+}
+
+// TestResolve puts a user's override over a room's, and a room's over the
+// default; a flag never saved is off, and a zero ID matches no override
+func TestResolve(t *testing.T) {
+	flag := &store.FeatureFlag{
+		Name:          PersistJoinLeave,
+		Enabled:       false,
+		UserOverrides: map[int64]bool{1: true, 2: false},
+		RoomOverrides: map[int64]bool{5: true, 0: true},
+	}
+	for _, tc := range []struct {
+		name           string
+		flag           *store.FeatureFlag
+		userID, roomID int64
+		want           bool
+	}{
+		{"the default", flag, 3, 4, false},
+		{"a user override", flag, 1, 4, true},
+		{"a room override", flag, 3, 5, true},
+		{"a user override over a room's", flag, 2, 5, false},
+		{"no user or room", flag, 0, 0, false},
+		{"never saved", nil, 1, 5, false},
+	} {
+		if got := Resolve(tc.flag, tc.userID, tc.roomID); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestCachedChecker serves flags from the cache within the TTL, reloads
+// them as soon as they're invalidated, and keeps the last ones loaded while
+// the store is failing
+func TestCachedChecker(t *testing.T) {
+	stored := &fakeFlags{flags: map[string]*store.FeatureFlag{
+		PersistJoinLeave: {Name: PersistJoinLeave, RoomOverrides: map[int64]bool{5: true}},
+	}}
+	checker := NewCachedChecker(store.Storage{FeatureFlags: stored}, time.Minute)
+	ctx := context.Background()
+
+	if !checker.Enabled(ctx, PersistJoinLeave, 1, 5) || checker.Enabled(ctx, PersistJoinLeave, 1, 4) {
+		t.Error("the room override wasn't applied")
+	}
+	if checker.Enabled(ctx, "no_such_flag", 1, 5) {
+		t.Error("an unknown flag is on")
+	}
+	if stored.loads != 1 {
+		t.Errorf("the flags were loaded %d times, want once", stored.loads)
+	}
+
+	stored.Save(ctx, &store.FeatureFlag{Name: PersistJoinLeave, Enabled: true})
+	if checker.Enabled(ctx, PersistJoinLeave, 1, 4) || stored.loads != 1 {
+		t.Error("a change showed up before the cache was invalidated")
+	}
+	checker.Invalidate()
+	if !checker.Enabled(ctx, PersistJoinLeave, 1, 4) || stored.loads != 2 {
+		t.Errorf("after invalidating got the old default with %d loads, want the new one", stored.loads)
+	}
+
+	stored.err = errors.New("database is down")
+	checker.Invalidate()
+	if !checker.Enabled(ctx, PersistJoinLeave, 1, 4) {
+		t.Error("a failed load dropped the flags loaded before it")
+	}
+	stored.err = nil
+	if checker.Enabled(ctx, PersistJoinLeave, 1, 4); stored.loads != 4 {
+		t.Errorf("the flags were loaded %d times, want a retry after the failure", stored.loads)
+	}
+}
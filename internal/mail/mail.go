@@ -0,0 +1,158 @@
+// Package mail sends email
+//
+// Senders (SMTP, an email API, ...) implement Mailer; features that email
+// users (digests, ...) build a Message and hand it to whichever Mailer is
+// configured
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Message is one email with a plain text and an HTML body
+// Either body may be empty, but not both
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers email
+type Mailer interface {
+	Send(ctx context.Context, message *Message) error
+}
+
+// LogMailer writes emails to the log instead of sending them
+// It's handy in development, where there's usually no mail server
+type LogMailer struct{}
+
+// Send logs the email's recipient, subject and text body
+func (LogMailer) Send(_ context.Context, message *Message) error {
+	log.Printf("Mail to %s: %s\n%s", message.To, message.Subject, message.Text)
+	return nil
+}
+
+// SMTPMailer sends email through an SMTP server
+// STARTTLS is used when the server offers it; credentials are only sent over TLS
+type SMTPMailer struct {
+	Addr     string // host:port
+	Username string // Empty to send without authentication
+	Password string
+	From     string
+}
+
+// Send delivers the email
+// net/smtp doesn't take a context, so the deadline is applied to the connection instead
+func (m *SMTPMailer) Send(ctx context.Context, message *Message) error {
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP address %q: %w", m.Addr, err)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}); err != nil {
+			return err
+		}
+	}
+	if m.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(m.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(message.To); err != nil {
+		return err
+	}
+	body, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := body.Write(compose(m.From, message, time.Now())); err != nil {
+		body.Close()
+		return err
+	}
+	if err := body.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// compose builds the raw email: headers and a multipart/alternative body
+// with the text part first, so clients that can show HTML prefer it
+func compose(from string, message *Message, now time.Time) []byte {
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+
+	header("From", from)
+	header("To", message.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", message.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+
+	boundary := newBoundary()
+	header("Content-Type", `multipart/alternative; boundary="`+boundary+`"`)
+	buf.WriteString("\r\n")
+
+	part := func(contentType, content string) {
+		if content == "" {
+			return
+		}
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		header("Content-Type", contentType+"; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		qp := quotedprintable.NewWriter(&buf)
+		qp.Write([]byte(strings.ReplaceAll(content, "\n", "\r\n")))
+		qp.Close()
+		buf.WriteString("\r\n")
+	}
+	part("text/plain", message.Text)
+	part("text/html", message.HTML)
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+
+	return buf.Bytes()
+}
+
+// newBoundary returns a random MIME boundary
+func newBoundary() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		// Only needs to not appear in the body; a fixed one almost never does
+		return "go-chat-boundary"
+	}
+	return "go-chat-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,67 @@
+package mail
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCompose parses a composed email back: the subject survives encoding
+// and the text part comes before the HTML one, each decoded from
+// quoted-printable
+func TestCompose(t *testing.T) {
+	message := &Message{
+		To:      "ada@example.invalid",
+		Subject: "Grüße: 3 unread",
+		Text:    "Hi ada,\nsee you in the lobby",
+		HTML:    "<p>Hi ada,</p>",
+	}
+	raw := compose("digest@example.invalid", message, time.Date(2026, 3, 6, 8, 0, 0, 0, time.UTC))
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != message.Subject {
+		t.Errorf("the subject decodes to %q, %v", subject, err)
+	}
+	if parsed.Header.Get("To") != message.To || parsed.Header.Get("Date") != "Fri, 06 Mar 2026 08:00:00 +0000" {
+		t.Errorf("got headers %v", parsed.Header)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("the content type is %q, %v", mediaType, err)
+	}
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+	for _, want := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", "Hi ada,\r\nsee you in the lobby"},
+		{"text/html; charset=utf-8", "<p>Hi ada,</p>"},
+	} {
+		part, err := parts.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// NextPart undoes the quoted-printable encoding and drops the header
+		body, _ := io.ReadAll(part)
+		if part.Header.Get("Content-Type") != want.contentType || string(body) != want.body {
+			t.Errorf("got a %q part %q, want %q %q", part.Header.Get("Content-Type"), body, want.contentType, want.body)
+		}
+	}
+	if _, err := parts.NextPart(); err != io.EOF {
+		t.Errorf("there's a third part: %v", err)
+	}
+}
+
+// TestComposeTextOnly leaves out an empty HTML body
+func TestComposeTextOnly(t *testing.T) {
+	raw := string(compose("digest@example.invalid", &Message{To: "ada@example.invalid", Subject: "hi", Text: "hello"}, time.Now()))
+	if strings.Contains(raw, "text/html") || !strings.Contains(raw, "text/plain") {
+		t.Errorf("got:\n%s", raw)
+	}
+}
@@ -0,0 +1,332 @@
+// Package migrate applies the SQL migrations in db/migrations and checks
+// which of them a database has
+// cmd/migrate runs them by hand; the API checks the schema against them on
+// startup and can apply them itself (AUTO_MIGRATE)
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// noTransactionMarker on the first line of a migration runs it outside a transaction
+// Needed for statements Postgres refuses inside one, like CREATE INDEX CONCURRENTLY
+const noTransactionMarker = "-- migrate:no-transaction"
+
+// Migration represents a single database migration file
+type Migration struct {
+	Version  int64  // Numeric version, used for ordering
+	Prefix   string // Version as written in the file name (e.g. "000001"), recorded in schema_migrations
+	Name     string
+	UpSQL    string
+	DownSQL  string
+	FilePath string
+}
+
+// String returns the migration's file name without the direction suffix
+func (m Migration) String() string {
+	return m.Prefix + "_" + m.Name
+}
+
+// inTransaction reports whether the migration may run inside a transaction
+func inTransaction(sql string) bool {
+	return !strings.HasPrefix(strings.TrimSpace(sql), noTransactionMarker)
+}
+
+// EnsureTable creates the schema_migrations table if it doesn't exist
+// This table keeps track of which migrations have been applied to the database
+func EnsureTable(db *sql.DB) error {
+	// dirty is true while a migration is running; a row left dirty means the run crashed
+	// ADD COLUMN IF NOT EXISTS upgrades tables created before the column existed
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version VARCHAR(255) PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+		);
+		ALTER TABLE schema_migrations ADD COLUMN IF NOT EXISTS dirty BOOLEAN NOT NULL DEFAULT false
+	`
+	_, err := db.Exec(query)
+	return err
+}
+
+// CheckDirty returns an error if a previous run left a migration half applied
+func CheckDirty(db *sql.DB) error {
+	version, err := dirtyVersion(db)
+	if err != nil || version == "" {
+		return err
+	}
+
+	return fmt.Errorf("database is dirty: migration %s did not finish. "+
+		"Fix the schema by hand, then run 'force %s applied' if the migration's changes are in place "+
+		"or 'force %s pending' if they were undone", version, version, version)
+}
+
+// dirtyVersion returns the version of a migration left half applied, or "" if there's none
+func dirtyVersion(db *sql.DB) (string, error) {
+	var version string
+	err := db.QueryRow("SELECT version FROM schema_migrations WHERE dirty LIMIT 1").Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return version, err
+}
+
+// Read reads all migration files from the root of fsys
+// Migration files should follow the naming convention: XXXXXX_name.up.sql and XXXXXX_name.down.sql
+//
+// The whole set is validated before anything runs: every version must be numeric
+// and unique, and every up file needs a down file. All problems are reported at once
+func Read(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.up.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0)
+	seen := make(map[int64]string) // version -> file that claimed it
+	var problems []string
+
+	for _, upFile := range files {
+		// Extract version and name from filename
+		// Example: 000001_create_users.up.sql -> version: 1 (prefix 000001), name: create_users
+		baseName := strings.TrimSuffix(path.Base(upFile), ".up.sql")
+		parts := strings.SplitN(baseName, "_", 2)
+		if len(parts) != 2 {
+			problems = append(problems, fmt.Sprintf("%s: expected VERSION_name.up.sql", upFile))
+			continue
+		}
+		prefix := parts[0]
+		name := parts[1]
+
+		// Versions are compared as integers: as strings "10" would sort before "2"
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil || version < 0 {
+			problems = append(problems, fmt.Sprintf("%s: version %q is not a number", upFile, prefix))
+			continue
+		}
+
+		if other, ok := seen[version]; ok {
+			problems = append(problems, fmt.Sprintf("%s: version %d is also used by %s", upFile, version, other))
+			continue
+		}
+		seen[version] = upFile
+
+		// Read up migration SQL
+		upSQL, err := fs.ReadFile(fsys, upFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", upFile, err)
+		}
+
+		// Read down migration SQL
+		downFile := fmt.Sprintf("%s_%s.down.sql", prefix, name)
+		downSQL, err := fs.ReadFile(fsys, downFile)
+		if errors.Is(err, fs.ErrNotExist) {
+			problems = append(problems, fmt.Sprintf("%s: missing down migration %s", upFile, downFile))
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", downFile, err)
+		}
+
+		migrations = append(migrations, Migration{
+			Version:  version,
+			Prefix:   prefix,
+			Name:     name,
+			UpSQL:    string(upSQL),
+			DownSQL:  string(downSQL),
+			FilePath: upFile,
+		})
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, fmt.Errorf("invalid migrations:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	// Sort migrations by version to ensure they're applied in order
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Up applies all pending migrations
+func Up(db *sql.DB, migrations []Migration) error {
+	// Get already applied migrations
+	applied, err := Applied(db)
+	if err != nil {
+		return err
+	}
+
+	// Apply each migration that hasn't been applied yet
+	for _, migration := range migrations {
+		if applied[migration.Version] {
+			log.Printf("Skipping migration %s (already applied)", migration)
+			continue
+		}
+
+		log.Printf("Applying migration %s...", migration)
+
+		// Record the migration as dirty before touching the schema
+		// This is committed on its own, so it survives a crash halfway through. It
+		// also stops a second runner: the version is the primary key, so its insert fails
+		if _, err := db.Exec("INSERT INTO schema_migrations (version, dirty) VALUES ($1, true)", migration.Prefix); err != nil {
+			return fmt.Errorf("failed to record migration %s: %w", migration, err)
+		}
+
+		if err := execMigration(db, migration.UpSQL); err != nil {
+			if inTransaction(migration.UpSQL) {
+				// The transaction was rolled back, so the schema is untouched
+				db.Exec("DELETE FROM schema_migrations WHERE version = $1", migration.Prefix)
+			}
+			return fmt.Errorf("failed to execute migration %s: %w", migration, err)
+		}
+
+		// Clear the dirty flag now that the migration is committed
+		if _, err := db.Exec("UPDATE schema_migrations SET dirty = false WHERE version = $1", migration.Prefix); err != nil {
+			return fmt.Errorf("failed to mark migration %s clean: %w", migration, err)
+		}
+
+		log.Printf("Migration %s applied successfully", migration)
+	}
+
+	return nil
+}
+
+// Down rolls back the most recent migration
+func Down(db *sql.DB, migrations []Migration) error {
+	// Get already applied migrations
+	applied, err := Applied(db)
+	if err != nil {
+		return err
+	}
+
+	// Find the most recent applied migration
+	var lastMigration *Migration
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if applied[migrations[i].Version] {
+			lastMigration = &migrations[i]
+			break
+		}
+	}
+
+	if lastMigration == nil {
+		log.Println("No migrations to roll back")
+		return nil
+	}
+
+	log.Printf("Rolling back migration %s...", lastMigration)
+
+	// Mark the migration dirty while its down SQL runs, same as for up
+	if _, err := db.Exec("UPDATE schema_migrations SET dirty = true WHERE version = $1", lastMigration.Prefix); err != nil {
+		return fmt.Errorf("failed to mark migration %s dirty: %w", lastMigration, err)
+	}
+
+	if err := execMigration(db, lastMigration.DownSQL); err != nil {
+		if inTransaction(lastMigration.DownSQL) {
+			// Nothing changed, so the migration is still cleanly applied
+			db.Exec("UPDATE schema_migrations SET dirty = false WHERE version = $1", lastMigration.Prefix)
+		}
+		return fmt.Errorf("failed to execute down migration %s: %w", lastMigration, err)
+	}
+
+	// Remove the migration record
+	if _, err := db.Exec("DELETE FROM schema_migrations WHERE version = $1", lastMigration.Prefix); err != nil {
+		return fmt.Errorf("failed to remove migration record %s: %w", lastMigration, err)
+	}
+
+	log.Printf("Migration %s rolled back successfully", lastMigration)
+	return nil
+}
+
+// execMigration runs migration SQL, inside a transaction unless the file opts out
+// With a transaction a failure rolls everything back; without one a failure
+// can leave the schema half changed, which is what the dirty flag is for
+func execMigration(db *sql.DB, migrationSQL string) error {
+	if !inTransaction(migrationSQL) {
+		_, err := db.Exec(migrationSQL)
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(migrationSQL); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Force records a migration's state without running it
+// Used to recover from a dirty database once the schema has been fixed by hand:
+//   - "applied" keeps the migration as applied and clears the dirty flag
+//   - "pending" removes the record, so the next Up runs the migration again
+func Force(db *sql.DB, migrations []Migration, version int64, state string) error {
+	var migration *Migration
+	for i := range migrations {
+		if migrations[i].Version == version {
+			migration = &migrations[i]
+			break
+		}
+	}
+	if migration == nil {
+		return fmt.Errorf("no migration with version %d", version)
+	}
+	if state != "applied" && state != "pending" {
+		return fmt.Errorf("unknown state %q, use 'applied' or 'pending'", state)
+	}
+
+	// Rows may have been recorded with a different prefix (e.g. "2" vs "000002"),
+	// so match on the numeric value
+	if _, err := db.Exec("DELETE FROM schema_migrations WHERE version ~ '^[0-9]+$' AND version::BIGINT = $1", version); err != nil {
+		return err
+	}
+
+	// For "pending" the record is already gone
+	if state == "applied" {
+		if _, err := db.Exec("INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)", migration.Prefix); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Migration %s forced to %s", migration, state)
+	return nil
+}
+
+// Applied returns the versions that have been applied
+// Versions are parsed as integers so "000002" and "2" refer to the same migration
+func Applied(db *sql.DB) (map[int64]bool, error) {
+	rows, err := db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]bool)
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+
+		number, err := strconv.ParseInt(version, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("schema_migrations has non-numeric version %q", version)
+		}
+		applied[number] = true
+	}
+
+	return applied, rows.Err()
+}
@@ -0,0 +1,195 @@
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestMain silences the progress log
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// migrationFS returns a file system with an up and a down file for each name
+// A name ending in "!" gets no down file
+func migrationFS(names ...string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for _, name := range names {
+		name, noDown := strings.CutSuffix(name, "!")
+		fsys[name+".up.sql"] = &fstest.MapFile{Data: []byte("SELECT 1")}
+		if !noDown {
+			fsys[name+".down.sql"] = &fstest.MapFile{Data: []byte("SELECT 1")}
+		}
+	}
+	return fsys
+}
+
+// TestReadOrder checks migrations are ordered by their numeric
+// version: sorted as strings, 10 comes before 2 and 9
+func TestReadOrder(t *testing.T) {
+	fsys := migrationFS("10_add_tags", "2_create_rooms", "9_add_index", "1_create_users", "000011_add_limits")
+
+	migrations, err := Read(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range migrations {
+		got = append(got, m.String())
+	}
+	want := []string{"1_create_users", "2_create_rooms", "9_add_index", "10_add_tags", "000011_add_limits"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("order is %v, want %v", got, want)
+	}
+}
+
+// TestReadValidation checks a bad set is refused before anything
+// runs, with every problem named
+func TestReadValidation(t *testing.T) {
+	fsys := migrationFS("1_create_users", "v2_create_rooms", "3_add_index", "003_add_other_index", "4_add_tags!", "5nounderscore")
+
+	_, err := Read(fsys)
+	if err == nil {
+		t.Fatal("an invalid set was accepted")
+	}
+	for _, want := range []string{
+		`v2_create_rooms.up.sql: version "v2" is not a number`,
+		"version 3 is also used by",
+		"4_add_tags.up.sql: missing down migration",
+		"5nounderscore.up.sql: expected VERSION_name.up.sql",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("the error doesn't mention %q:\n%v", want, err)
+		}
+	}
+}
+
+// newMockDB returns a sqlmock database that matches queries literally and
+// checks every expectation was met
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return db, mock
+}
+
+// TestDirtyStateRecovery crashes a migration that runs outside a
+// transaction: its row stays dirty, which blocks further runs until force
+// repairs it
+func TestDirtyStateRecovery(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	migrations := []Migration{
+		{Version: 1, Prefix: "000001", Name: "create_users", UpSQL: "CREATE TABLE users ()"},
+		{Version: 2, Prefix: "000002", Name: "index_users", UpSQL: noTransactionMarker + "\nCREATE INDEX CONCURRENTLY users_idx ON users (id)"},
+	}
+
+	// 1 is applied; 2 is marked dirty and fails outside a transaction, so
+	// nothing can tell how far it got and the row stays dirty
+	mock.ExpectQuery("SELECT version FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("000001"))
+	mock.ExpectExec("INSERT INTO schema_migrations (version, dirty) VALUES ($1, true)").
+		WithArgs("000002").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(migrations[1].UpSQL).WillReturnError(errors.New("connection reset"))
+	if err := Up(db, migrations); err == nil {
+		t.Fatal("the failed migration was reported as applied")
+	}
+
+	// The next run sees the dirty row and stops
+	mock.ExpectQuery("SELECT version FROM schema_migrations WHERE dirty LIMIT 1").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("000002"))
+	err := CheckDirty(db)
+	if err == nil || !strings.Contains(err.Error(), "force 000002") {
+		t.Fatalf("CheckDirty = %v, want an error pointing at force 000002", err)
+	}
+
+	// Forcing it pending removes the row, so up runs it again
+	mock.ExpectExec("DELETE FROM schema_migrations WHERE version ~ '^[0-9]+$' AND version::BIGINT = $1").
+		WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := Force(db, migrations, 2, "pending"); err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery("SELECT version FROM schema_migrations WHERE dirty LIMIT 1").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	if err := CheckDirty(db); err != nil {
+		t.Errorf("after force the database is still dirty: %v", err)
+	}
+	mock.ExpectQuery("SELECT version FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("000001"))
+	mock.ExpectExec("INSERT INTO schema_migrations (version, dirty) VALUES ($1, true)").
+		WithArgs("000002").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(migrations[1].UpSQL).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE schema_migrations SET dirty = false WHERE version = $1").
+		WithArgs("000002").WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := Up(db, migrations); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFailedTransactionalMigration fails a migration inside its
+// transaction: it's rolled back and its dirty row removed, so the database
+// isn't left dirty
+func TestFailedTransactionalMigration(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	migrations := []Migration{{Version: 1, Prefix: "1", Name: "create_users", UpSQL: "CREATE TABLE users ()"}}
+	mock.ExpectQuery("SELECT version FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	mock.ExpectExec("INSERT INTO schema_migrations (version, dirty) VALUES ($1, true)").
+		WithArgs("1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TABLE users ()").WillReturnError(errors.New("syntax error"))
+	mock.ExpectRollback()
+	mock.ExpectExec("DELETE FROM schema_migrations WHERE version = $1").
+		WithArgs("1").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := Up(db, migrations); err == nil {
+		t.Fatal("the failed migration was reported as applied")
+	}
+}
+
+// TestForceValidation checks Force refuses a version it has no migration
+// for and an unknown state without touching the database
+func TestForceValidation(t *testing.T) {
+	db, _ := newMockDB(t)
+
+	migrations := []Migration{{Version: 1, Prefix: "000001", Name: "create_users"}}
+	if err := Force(db, migrations, 7, "applied"); err == nil {
+		t.Error("forcing an unknown version was accepted")
+	}
+	if err := Force(db, migrations, 1, "done"); err == nil {
+		t.Error("forcing an unknown state was accepted")
+	}
+}
+
+// TestForceApplied records a migration under the prefix of its file name,
+// replacing a row recorded with another prefix for the same version
+func TestForceApplied(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	migrations := []Migration{{Version: 2, Prefix: "000002", Name: "create_rooms"}}
+	mock.ExpectExec("DELETE FROM schema_migrations WHERE version ~ '^[0-9]+$' AND version::BIGINT = $1").
+		WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)").
+		WithArgs("000002").WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := Force(db, migrations, 2, "applied"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,94 @@
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Status is how a database's schema_migrations compares to a set of migrations
+type Status struct {
+	Missing []Migration // In the set but not applied, i.e. the database is behind
+	Extra   []int64     // Applied but not in the set, e.g. from a newer build or another branch
+	Dirty   string      // Version of a migration left half applied, if any
+}
+
+// Current reports whether the database has every migration applied cleanly
+// Extra versions don't count: a rollback to an older build leaves them behind
+// and the schema it expects is still there
+func (s *Status) Current() bool {
+	return len(s.Missing) == 0 && s.Dirty == ""
+}
+
+// Err explains why the database isn't current, naming the versions involved
+// Returns nil if it is
+func (s *Status) Err() error {
+	if s.Current() {
+		return nil
+	}
+
+	var problems []string
+	if s.Dirty != "" {
+		problems = append(problems, fmt.Sprintf("migration %s did not finish (dirty); repair it with 'migrate force'", s.Dirty))
+	}
+	if len(s.Missing) > 0 {
+		names := make([]string, len(s.Missing))
+		for i, m := range s.Missing {
+			names[i] = m.String()
+		}
+		problems = append(problems, fmt.Sprintf("%d migration(s) not applied: %s; run 'migrate up' or set AUTO_MIGRATE=true",
+			len(s.Missing), strings.Join(names, ", ")))
+	}
+	if len(s.Extra) > 0 {
+		problems = append(problems, "applied migrations this build doesn't know: "+s.ExtraString())
+	}
+	return fmt.Errorf("database schema is not current:\n  %s", strings.Join(problems, "\n  "))
+}
+
+// ExtraString lists the extra versions, comma separated
+func (s *Status) ExtraString() string {
+	versions := make([]string, len(s.Extra))
+	for i, v := range s.Extra {
+		versions[i] = strconv.FormatInt(v, 10)
+	}
+	return strings.Join(versions, ", ")
+}
+
+// Verify compares the database's schema_migrations with a set of migrations
+// It only reads; a database without schema_migrations has nothing applied
+func Verify(db *sql.DB, migrations []Migration) (*Status, error) {
+	var exists bool
+	if err := db.QueryRow("SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, err
+	}
+
+	status := &Status{}
+	applied := make(map[int64]bool)
+	if exists {
+		var err error
+		if applied, err = Applied(db); err != nil {
+			return nil, err
+		}
+		if status.Dirty, err = dirtyVersion(db); err != nil {
+			return nil, err
+		}
+	}
+
+	known := make(map[int64]bool, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = true
+		if !applied[m.Version] {
+			status.Missing = append(status.Missing, m)
+		}
+	}
+	for version := range applied {
+		if !known[version] {
+			status.Extra = append(status.Extra, version)
+		}
+	}
+	sort.Slice(status.Extra, func(i, j int) bool { return status.Extra[i] < status.Extra[j] })
+
+	return status, nil
+}
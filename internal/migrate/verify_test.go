@@ -0,0 +1,128 @@
+package migrate
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/drazan344/go-chat/db/migrations"
+)
+
+// TestEmbeddedMigrations reads the set the binaries ship with: it must be
+// valid and numbered without gaps, since the API refuses to start if any of
+// them is missing from the database
+func TestEmbeddedMigrations(t *testing.T) {
+	set, err := Read(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set) == 0 {
+		t.Fatal("no migrations are embedded")
+	}
+	for i, m := range set {
+		if m.Version != int64(i+1) {
+			t.Fatalf("migration %d is %s, want version %d", i, m, i+1)
+		}
+	}
+}
+
+// expectTable expects the check for the schema_migrations table
+func expectTable(mock sqlmock.Sqlmock, exists bool) {
+	mock.ExpectQuery("SELECT to_regclass('schema_migrations') IS NOT NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(exists))
+}
+
+// TestVerify compares a database with the set: one migration is missing, one
+// applied version isn't in the set, and the prefix a version was recorded
+// with doesn't matter
+func TestVerify(t *testing.T) {
+	db, mock := newMockDB(t)
+	set := []Migration{
+		{Version: 1, Prefix: "000001", Name: "create_users"},
+		{Version: 2, Prefix: "000002", Name: "create_rooms"},
+		{Version: 3, Prefix: "000003", Name: "add_tags"},
+	}
+
+	expectTable(mock, true)
+	mock.ExpectQuery("SELECT version FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("000001").AddRow("2").AddRow("000009"))
+	mock.ExpectQuery("SELECT version FROM schema_migrations WHERE dirty LIMIT 1").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}))
+
+	status, err := Verify(db, set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Missing) != 1 || status.Missing[0].Version != 3 || !slices.Equal(status.Extra, []int64{9}) || status.Dirty != "" {
+		t.Fatalf("got %+v, want 3 missing and 9 extra", status)
+	}
+	err = status.Err()
+	if err == nil {
+		t.Fatal("a database missing a migration is current")
+	}
+	for _, want := range []string{"1 migration(s) not applied: 000003_add_tags", "doesn't know: 9"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("the error doesn't mention %q:\n%v", want, err)
+		}
+	}
+}
+
+// TestVerifyExtraOnly accepts a database ahead of the build: a rollback to
+// an older build leaves the newer migrations applied
+func TestVerifyExtraOnly(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	expectTable(mock, true)
+	mock.ExpectQuery("SELECT version FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("1").AddRow("2"))
+	mock.ExpectQuery("SELECT version FROM schema_migrations WHERE dirty LIMIT 1").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}))
+
+	status, err := Verify(db, []Migration{{Version: 1, Prefix: "1", Name: "create_users"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Current() || status.Err() != nil || status.ExtraString() != "2" {
+		t.Errorf("got %+v, want current with 2 extra", status)
+	}
+}
+
+// TestVerifyDirty refuses a database with a migration left half applied,
+// even if every version is recorded
+func TestVerifyDirty(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	expectTable(mock, true)
+	mock.ExpectQuery("SELECT version FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("000001"))
+	mock.ExpectQuery("SELECT version FROM schema_migrations WHERE dirty LIMIT 1").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("000001"))
+
+	status, err := Verify(db, []Migration{{Version: 1, Prefix: "000001", Name: "create_users"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Current() {
+		t.Fatal("a dirty database is current")
+	}
+	if err := status.Err(); !strings.Contains(err.Error(), "migration 000001 did not finish") {
+		t.Errorf("the error doesn't name the dirty migration:\n%v", err)
+	}
+}
+
+// TestVerifyNoTable treats a database that was never migrated as having
+// nothing applied, without creating schema_migrations
+func TestVerifyNoTable(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectTable(mock, false)
+
+	set := []Migration{{Version: 1, Prefix: "000001", Name: "create_users"}, {Version: 2, Prefix: "000002", Name: "create_rooms"}}
+	status, err := Verify(db, set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Missing) != 2 || len(status.Extra) != 0 {
+		t.Errorf("got %+v, want both migrations missing", status)
+	}
+}
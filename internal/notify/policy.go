@@ -0,0 +1,112 @@
+// Package notify decides whether a notification may be sent
+//
+// Every code path that notifies users (WebSocket mention alerts, push
+// notifications, digest emails, ...) asks a Policy before sending, so user
+// preferences are honoured the same way everywhere
+package notify
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/cache"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+const (
+	// DefaultCacheTTL is how long preferences are cached
+	// Updates made through this instance invalidate the cache at once; other
+	// instances pick them up within the TTL
+	DefaultCacheTTL = time.Minute
+
+	// maxCacheEntries bounds the cache; expired entries are swept when it's reached
+	maxCacheEntries = 10000
+)
+
+// Policy answers whether a user wants notifications of an event type on a channel
+// Channels and event types are the store.NotifyChannel and store.NotifyEvent constants
+type Policy interface {
+	// Allowed reports whether one user may be notified
+	Allowed(ctx context.Context, userID int64, channel, event string) bool
+
+	// Filter returns the users that may be notified, in their original order
+	Filter(ctx context.Context, userIDs []int64, channel, event string) []int64
+
+	// Invalidate drops a user's cached preferences after they change
+	Invalidate(userID int64)
+}
+
+// CachedPolicy is the Policy backed by the notification preference store
+// If preferences can't be loaded, the defaults are used rather than dropping
+// every notification
+type CachedPolicy struct {
+	store store.Storage
+	cache *cache.TTL[int64, store.NotificationPreferences] // user ID -> preferences
+}
+
+// NewCachedPolicy creates a policy that caches each user's preferences for ttl
+func NewCachedPolicy(st store.Storage, ttl time.Duration) *CachedPolicy {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &CachedPolicy{store: st, cache: cache.New[int64, store.NotificationPreferences](ttl, maxCacheEntries)}
+}
+
+// Allowed reports whether one user may be notified
+func (p *CachedPolicy) Allowed(ctx context.Context, userID int64, channel, event string) bool {
+	return len(p.Filter(ctx, []int64{userID}, channel, event)) == 1
+}
+
+// Filter returns the users that may be notified, loading uncached users in one query
+func (p *CachedPolicy) Filter(ctx context.Context, userIDs []int64, channel, event string) []int64 {
+	prefs := p.load(ctx, userIDs)
+
+	allowed := make([]int64, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if prefs[userID].Allows(channel, event) {
+			allowed = append(allowed, userID)
+		}
+	}
+	return allowed
+}
+
+// Invalidate drops a user's cached preferences
+func (p *CachedPolicy) Invalidate(userID int64) {
+	p.cache.Invalidate(userID)
+}
+
+// load returns the preferences of the given users, from the cache where possible
+// Users whose preferences couldn't be loaded are missing from the result, and
+// a nil matrix allows exactly what the defaults allow
+func (p *CachedPolicy) load(ctx context.Context, userIDs []int64) map[int64]store.NotificationPreferences {
+	now := time.Now()
+	result := make(map[int64]store.NotificationPreferences, len(userIDs))
+	missing := make([]int64, 0)
+	generations := make(map[int64]uint64)
+	for _, userID := range userIDs {
+		if prefs, fresh, generation := p.cache.Get(userID, now); fresh {
+			result[userID] = prefs
+		} else {
+			missing = append(missing, userID)
+			generations[userID] = generation
+		}
+	}
+
+	if len(missing) == 0 {
+		return result
+	}
+
+	// Loaded outside the lock; two callers may load the same user, which is harmless
+	loaded, err := p.store.NotificationPreferences.GetForUsers(ctx, missing)
+	if err != nil {
+		log.Printf("Failed to load notification preferences, using defaults: %v", err)
+		return result
+	}
+
+	for userID, prefs := range loaded {
+		result[userID] = prefs
+		p.cache.Put(userID, prefs, generations[userID], now)
+	}
+	return result
+}
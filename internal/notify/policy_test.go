@@ -0,0 +1,132 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// fakePreferences keeps the cells users changed in memory and counts loads
+type fakePreferences struct {
+	mu      sync.Mutex
+	changed map[int64]store.NotificationPreferences
+	loads   int
+	err     error
+}
+
+func (f *fakePreferences) Get(ctx context.Context, userID int64) (store.NotificationPreferences, error) {
+	prefs, err := f.GetForUsers(ctx, []int64{userID})
+	return prefs[userID], err
+}
+
+func (f *fakePreferences) GetForUsers(_ context.Context, userIDs []int64) (map[int64]store.NotificationPreferences, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.loads++
+	if f.err != nil {
+		return nil, f.err
+	}
+	result := make(map[int64]store.NotificationPreferences, len(userIDs))
+	for _, userID := range userIDs {
+		prefs := store.DefaultNotificationPreferences()
+		for channel, cells := range f.changed[userID] {
+			for event, on := range cells {
+				prefs[channel][event] = on
+			}
+		}
+		result[userID] = prefs
+	}
+	return result, nil
+}
+
+func (f *fakePreferences) Update(ctx context.Context, userID int64, changes store.NotificationPreferences) (store.NotificationPreferences, error) {
+	f.mu.Lock()
+	f.changed[userID] = changes
+	f.mu.Unlock()
+	return f.Get(ctx, userID)
+}
+
+func newTestPolicy(ttl time.Duration) (*CachedPolicy, *fakePreferences) {
+	prefs := &fakePreferences{changed: make(map[int64]store.NotificationPreferences)}
+	return NewCachedPolicy(store.Storage{NotificationPreferences: prefs}, ttl), prefs
+}
+
+// TestFilter keeps the users who want a notification, in order, and loads
+// everyone it hasn't cached in one go
+func TestFilter(t *testing.T) {
+	policy, prefs := newTestPolicy(time.Minute)
+	ctx := context.Background()
+	prefs.Update(ctx, 2, store.NotificationPreferences{store.NotifyChannelPush: {store.NotifyEventMention: false}})
+	prefs.loads = 0
+
+	got := policy.Filter(ctx, []int64{3, 2, 1}, store.NotifyChannelPush, store.NotifyEventMention)
+	if !slices.Equal(got, []int64{3, 1}) {
+		t.Errorf("push mentions go to %v, want 3 and 1", got)
+	}
+	if !policy.Allowed(ctx, 2, store.NotifyChannelWebSocket, store.NotifyEventMention) {
+		t.Error("turning off push mentions turned off the WebSocket flag too")
+	}
+	if policy.Allowed(ctx, 1, store.NotifyChannelEmail, store.NotifyEventMention) {
+		t.Error("mention emails are on, but they're off by default")
+	}
+	if prefs.loads != 1 {
+		t.Errorf("the store was read %d times, want once", prefs.loads)
+	}
+}
+
+// TestInvalidate reloads a user's preferences after they change, and
+// expired entries after the TTL
+func TestInvalidate(t *testing.T) {
+	policy, prefs := newTestPolicy(time.Minute)
+	ctx := context.Background()
+	mention := func() bool { return policy.Allowed(ctx, 1, store.NotifyChannelPush, store.NotifyEventMention) }
+
+	if !mention() {
+		t.Fatal("push mentions are off by default")
+	}
+	prefs.Update(ctx, 1, store.NotificationPreferences{store.NotifyChannelPush: {store.NotifyEventMention: false}})
+	if !mention() {
+		t.Error("the cached preferences weren't used")
+	}
+	policy.Invalidate(1)
+	if mention() {
+		t.Error("the change wasn't picked up after Invalidate")
+	}
+
+	short, prefs := newTestPolicy(time.Millisecond)
+	short.Allowed(ctx, 1, store.NotifyChannelPush, store.NotifyEventMention)
+	time.Sleep(5 * time.Millisecond)
+	short.Allowed(ctx, 1, store.NotifyChannelPush, store.NotifyEventMention)
+	if prefs.loads != 2 {
+		t.Errorf("the store was read %d times, want again after the TTL", prefs.loads)
+	}
+}
+
+// TestLoadFailure falls back to the defaults without caching them
+func TestLoadFailure(t *testing.T) {
+	policy, prefs := newTestPolicy(time.Minute)
+	ctx := context.Background()
+	prefs.err = errors.New("database is down")
+
+	if !policy.Allowed(ctx, 1, store.NotifyChannelPush, store.NotifyEventMention) || policy.Allowed(ctx, 1, store.NotifyChannelEmail, store.NotifyEventMention) {
+		t.Error("a failed load didn't fall back to the defaults")
+	}
+	prefs.err = nil
+	prefs.Update(ctx, 1, store.NotificationPreferences{store.NotifyChannelPush: {store.NotifyEventMention: false}})
+	if policy.Allowed(ctx, 1, store.NotifyChannelPush, store.NotifyEventMention) {
+		t.Error("the defaults from the failed load were cached")
+	}
+}
@@ -0,0 +1,267 @@
+// Package oauth signs users in with an account at an external provider
+// (Google, GitHub) through the OAuth 2.0 authorization code flow
+//
+// A Provider builds the URL the browser is sent to, then exchanges the code
+// the provider sends back for the account's Identity. Linking it to a local
+// user is the API's job
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Provider names
+const (
+	Google = "google"
+	GitHub = "github"
+)
+
+// ErrInvalidCode is returned when the provider refuses the code: it's
+// expired, was already used or belongs to another client
+var ErrInvalidCode = errors.New("authorization code refused")
+
+// ErrUnavailable marks failures of the provider itself (timeouts, 5xx,
+// connection refused, answers that can't be read)
+var ErrUnavailable = errors.New("oauth provider unavailable")
+
+// Identity is the provider account a code was exchanged for
+type Identity struct {
+	Provider string
+	Subject  string // The provider's stable ID for the account
+	Email    string // Empty if the provider has none to share
+
+	// EmailVerified is true if the provider checked the account owns Email
+	EmailVerified bool
+
+	// Username is the name to suggest for a new local account
+	Username string
+}
+
+// Endpoints are where a provider's authorization flow runs
+type Endpoints struct {
+	AuthURL   string // The page the browser is sent to
+	TokenURL  string // Exchanges the code for an access token
+	UserURL   string // Returns the account's profile
+	EmailsURL string // Returns the account's addresses (GitHub only)
+}
+
+// defaultEndpoints are the providers' public endpoints
+var defaultEndpoints = map[string]Endpoints{
+	Google: {
+		AuthURL:  "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL: "https://oauth2.googleapis.com/token",
+		UserURL:  "https://openidconnect.googleapis.com/v1/userinfo",
+	},
+	GitHub: {
+		AuthURL:   "https://github.com/login/oauth/authorize",
+		TokenURL:  "https://github.com/login/oauth/access_token",
+		UserURL:   "https://api.github.com/user",
+		EmailsURL: "https://api.github.com/user/emails",
+	},
+}
+
+// scopes are what each provider is asked for: the account and its email
+var scopes = map[string]string{
+	Google: "openid email profile",
+	GitHub: "read:user user:email",
+}
+
+// Config is an app registered with a provider
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string // The callback the provider sends the browser back to
+
+	// Endpoints replace the provider's public ones when set, e.g. for tests
+	Endpoints *Endpoints
+}
+
+// Provider runs the authorization code flow against one provider
+type Provider struct {
+	name      string
+	config    Config
+	endpoints Endpoints
+	client    *http.Client
+}
+
+// New creates a Provider for google or github
+// Every request to the provider gives up after timeout
+func New(name string, cfg Config, timeout time.Duration) (*Provider, error) {
+	endpoints, ok := defaultEndpoints[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown OAuth provider %q", name)
+	}
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return nil, fmt.Errorf("OAuth provider %s needs a client ID and secret", name)
+	}
+	if cfg.Endpoints != nil {
+		endpoints = *cfg.Endpoints
+	}
+	return &Provider{
+		name:      name,
+		config:    cfg,
+		endpoints: endpoints,
+		client:    &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Name returns the provider's name, e.g. "github"
+func (p *Provider) Name() string {
+	return p.name
+}
+
+// AuthCodeURL returns the provider's page asking the user to sign in and
+// allow access; state comes back unchanged on the callback
+func (p *Provider) AuthCodeURL(state string) string {
+	query := url.Values{
+		"client_id":     {p.config.ClientID},
+		"redirect_uri":  {p.config.RedirectURL},
+		"response_type": {"code"},
+		"scope":         {scopes[p.name]},
+		"state":         {state},
+	}
+	if p.name == Google {
+		// Always ask which account, so signing out of go-chat means something
+		query.Set("prompt", "select_account")
+	}
+	return p.endpoints.AuthURL + "?" + query.Encode()
+}
+
+// Exchange trades the code from the callback for the account it was issued for
+func (p *Provider) Exchange(ctx context.Context, code string) (*Identity, error) {
+	accessToken, err := p.accessToken(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if p.name == GitHub {
+		return p.gitHubIdentity(ctx, accessToken)
+	}
+	return p.googleIdentity(ctx, accessToken)
+}
+
+// accessToken exchanges a code at the token endpoint
+func (p *Provider) accessToken(ctx context.Context, code string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"client_secret": {p.config.ClientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoints.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%s token: %w: %v", p.name, ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	// GitHub answers a refused code with 200 and an error field; Google with a 400
+	var result struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return "", fmt.Errorf("%s token: %w: status %d", p.name, ErrUnavailable, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("%s token: %w: %v", p.name, ErrUnavailable, err)
+	}
+	if resp.StatusCode != http.StatusOK || result.Error != "" || result.AccessToken == "" {
+		return "", fmt.Errorf("%s token: %w: status %d %s", p.name, ErrInvalidCode, resp.StatusCode, result.Error)
+	}
+	return result.AccessToken, nil
+}
+
+// googleIdentity reads the account from Google's OpenID Connect userinfo
+func (p *Provider) googleIdentity(ctx context.Context, accessToken string) (*Identity, error) {
+	var user struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := p.get(ctx, p.endpoints.UserURL, accessToken, &user); err != nil {
+		return nil, err
+	}
+	if user.Sub == "" {
+		return nil, fmt.Errorf("google userinfo: %w: no subject", ErrUnavailable)
+	}
+
+	username, _, _ := strings.Cut(user.Email, "@")
+	return &Identity{
+		Provider:      Google,
+		Subject:       user.Sub,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Username:      username,
+	}, nil
+}
+
+// gitHubIdentity reads the account from GitHub's user API
+// The profile's email is whatever the user made public, so the verified
+// primary address is looked up separately
+func (p *Provider) gitHubIdentity(ctx context.Context, accessToken string) (*Identity, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+	}
+	if err := p.get(ctx, p.endpoints.UserURL, accessToken, &user); err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, fmt.Errorf("github user: %w: no ID", ErrUnavailable)
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.get(ctx, p.endpoints.EmailsURL, accessToken, &emails); err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{Provider: GitHub, Subject: fmt.Sprint(user.ID), Username: user.Login}
+	for _, email := range emails {
+		if email.Primary {
+			identity.Email, identity.EmailVerified = email.Email, email.Verified
+		}
+	}
+	return identity, nil
+}
+
+// get sends an authenticated GET and decodes the JSON response into out
+func (p *Provider) get(ctx context.Context, endpoint, accessToken string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %v", p.name, req.URL.Path, ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %w: status %d", p.name, req.URL.Path, ErrUnavailable, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: %w: %v", p.name, req.URL.Path, ErrUnavailable, err)
+	}
+	return nil
+}
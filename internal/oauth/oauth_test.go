@@ -0,0 +1,127 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// fakeProvider serves the token, user and emails endpoints, accepting only
+// the code "good" and the access token it hands out for it
+func fakeProvider(t *testing.T, user, emails string) (*httptest.Server, *Endpoints) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("client_secret") != "secret" || r.Form.Get("redirect_uri") != "https://chat.example.com/callback" {
+			t.Errorf("the token request had %v", r.Form)
+		}
+		if r.Form.Get("code") != "good" {
+			// As GitHub does: 200 with an error field
+			w.Write([]byte(`{"error": "bad_verification_code"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token": "access", "token_type": "bearer"}`))
+	})
+	authenticated := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer access" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(body))
+		}
+	}
+	mux.HandleFunc("GET /user", authenticated(user))
+	mux.HandleFunc("GET /emails", authenticated(emails))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, &Endpoints{
+		AuthURL:   server.URL + "/authorize",
+		TokenURL:  server.URL + "/token",
+		UserURL:   server.URL + "/user",
+		EmailsURL: server.URL + "/emails",
+	}
+}
+
+// newTestProvider creates a provider against a fake server
+func newTestProvider(t *testing.T, name string, endpoints *Endpoints) *Provider {
+	t.Helper()
+	provider, err := New(name, Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "https://chat.example.com/callback",
+		Endpoints:    endpoints,
+	}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+// TestGitHubExchange trades a code for the account, whose address is the
+// verified primary one rather than the profile's public email
+func TestGitHubExchange(t *testing.T) {
+	_, endpoints := fakeProvider(t, `{"id": 583231, "login": "octocat", "email": "public@example.com"}`,
+		`[{"email": "old@example.com", "primary": false, "verified": true},
+		  {"email": "octo@example.com", "primary": true, "verified": true}]`)
+	provider := newTestProvider(t, GitHub, endpoints)
+
+	identity, err := provider.Exchange(context.Background(), "good")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{Provider: GitHub, Subject: "583231", Email: "octo@example.com", EmailVerified: true, Username: "octocat"}
+	if *identity != want {
+		t.Errorf("got %+v, want %+v", identity, want)
+	}
+
+	if _, err := provider.Exchange(context.Background(), "stale"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("a refused code got %v, want ErrInvalidCode", err)
+	}
+}
+
+// TestGoogleExchange reads the account from userinfo and suggests the
+// address's local part as the username
+func TestGoogleExchange(t *testing.T) {
+	_, endpoints := fakeProvider(t, `{"sub": "1098", "email": "ada@example.com", "email_verified": true}`, `[]`)
+	provider := newTestProvider(t, Google, endpoints)
+
+	link, err := url.Parse(provider.AuthCodeURL("state-token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := link.Query()
+	if link.Path != "/authorize" || query.Get("state") != "state-token" || query.Get("client_id") != "client" || query.Get("scope") != "openid email profile" {
+		t.Errorf("the authorization URL is %s", link)
+	}
+
+	identity, err := provider.Exchange(context.Background(), "good")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{Provider: Google, Subject: "1098", Email: "ada@example.com", EmailVerified: true, Username: "ada"}
+	if *identity != want {
+		t.Errorf("got %+v, want %+v", identity, want)
+	}
+}
+
+// TestProviderUnavailable reports an outage as ErrUnavailable, not as a bad code
+func TestProviderUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	provider := newTestProvider(t, Google, &Endpoints{TokenURL: server.URL})
+
+	if _, err := provider.Exchange(context.Background(), "good"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("got %v, want ErrUnavailable", err)
+	}
+	if _, err := New("myspace", Config{ClientID: "a", ClientSecret: "b"}, time.Second); err == nil {
+		t.Error("an unknown provider was accepted")
+	}
+}
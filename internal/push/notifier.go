@@ -0,0 +1,218 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/drazan344/go-chat/internal/notify"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/websocket"
+)
+
+const (
+	// notifierWorkers is how many pushes are sent concurrently
+	notifierWorkers = 4
+
+	// notifierQueueSize bounds the pushes waiting for a worker
+	notifierQueueSize = 1024
+
+	// maxSendAttempts is how often a push is tried on transient errors
+	maxSendAttempts = 3
+
+	// initialSendBackoff is the wait before the first retry; it doubles after each attempt
+	initialSendBackoff = time.Second
+
+	// maxBodyLength caps the message preview in a notification, in characters
+	maxBodyLength = 120
+)
+
+// delivery is one payload for one device
+type delivery struct {
+	token   *store.PushToken
+	payload Payload
+}
+
+// Presence tells the notifier which users are connected; *websocket.Hub implements it
+type Presence interface {
+	IsUserOnline(userID int64) bool
+}
+
+// Notifier pushes notifications to users who aren't connected
+// It listens to hub events and, for every mention of an offline room member,
+// sends a push to each of their registered devices
+type Notifier struct {
+	provider Provider
+	store    store.Storage
+	presence Presence
+	policy   notify.Policy
+
+	// maxFailures is how many permanent failures in a row disable a token
+	maxFailures int
+
+	// backoff is the wait before the first retry of a transient failure
+	backoff time.Duration
+
+	queue chan delivery
+}
+
+// NewNotifier creates a notifier and starts its worker pool
+// policy decides which users want mention pushes
+func NewNotifier(provider Provider, st store.Storage, presence Presence, policy notify.Policy, maxFailures int) *Notifier {
+	n := &Notifier{
+		provider:    provider,
+		store:       st,
+		presence:    presence,
+		policy:      policy,
+		maxFailures: maxFailures,
+		backoff:     initialSendBackoff,
+		queue:       make(chan delivery, notifierQueueSize),
+	}
+	for i := 0; i < notifierWorkers; i++ {
+		go n.worker()
+	}
+	return n
+}
+
+// Register subscribes the notifier to the hub's events
+func (n *Notifier) Register(hooks *websocket.HookRegistry) {
+	hooks.OnMessagePersisted(n.messagePersisted)
+}
+
+// messagePersisted notifies offline members mentioned in a saved message
+// An @room mentions every member (see Message.RoomMention); @here only
+// notifies those connected, so it pushes nobody
+// Silent messages (from bots that asked for no notifications) are skipped
+// Runs on a hook worker, so the database lookups never hold up the hub
+func (n *Notifier) messagePersisted(event websocket.HookEvent) {
+	message := event.Message
+	if message == nil || message.Silent {
+		return
+	}
+
+	mentions := content.Mentions(message.Content)
+	if len(mentions) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Only room members can be mentioned; other names are just text
+	var members []int64
+	var err error
+	if message.RoomMention == content.MentionRoom {
+		members, err = n.store.RoomMembers.GetRoomMembers(ctx, message.RoomID)
+	} else {
+		members, err = n.store.RoomMembers.FindMembersByUsername(ctx, message.RoomID, mentions)
+	}
+	if err != nil {
+		log.Printf("Failed to resolve mentions in message %d: %v", message.ID, err)
+		return
+	}
+
+	// Users with an open connection see the mention live
+	recipients := make([]int64, 0, len(members))
+	for _, userID := range members {
+		if userID != message.UserID && !n.presence.IsUserOnline(userID) {
+			recipients = append(recipients, userID)
+		}
+	}
+
+	// Users who turned off push for mentions are left out
+	recipients = n.policy.Filter(ctx, recipients, store.NotifyChannelPush, store.NotifyEventMention)
+	if len(recipients) == 0 {
+		return
+	}
+
+	tokens, err := n.store.PushTokens.ListActiveForUsers(ctx, recipients)
+	if err != nil {
+		log.Printf("Failed to load push tokens: %v", err)
+		return
+	}
+
+	title := fmt.Sprintf("%s mentioned you", message.Username)
+	if message.RoomMention == content.MentionRoom {
+		title = fmt.Sprintf("%s mentioned @room", message.Username)
+	}
+	payload := Payload{
+		Kind:      KindMention,
+		Title:     title,
+		Body:      preview(message.Content),
+		RoomID:    message.RoomID,
+		MessageID: message.ID,
+	}
+	for _, token := range tokens {
+		n.enqueue(delivery{token: token, payload: payload})
+	}
+}
+
+// enqueue hands a delivery to the workers, dropping it if the queue is full
+// A missed push is better than a backlog that delivers stale notifications
+func (n *Notifier) enqueue(d delivery) {
+	select {
+	case n.queue <- d:
+	default:
+		log.Printf("Push queue full, dropped notification for user %d", d.token.UserID)
+	}
+}
+
+// worker sends queued deliveries until the process exits
+func (n *Notifier) worker() {
+	for d := range n.queue {
+		n.send(d)
+	}
+}
+
+// send delivers one push, retrying transient errors with exponential backoff
+// Permanent errors aren't retried; they count against the token, which is
+// disabled after maxFailures in a row
+func (n *Notifier) send(d delivery) {
+	backoff := n.backoff
+	var err error
+	for attempt := 1; attempt <= maxSendAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = n.provider.Send(ctx, d.token.Token, d.payload)
+		cancel()
+
+		if err == nil || errors.Is(err, ErrPermanent) {
+			break
+		}
+		if attempt < maxSendAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	switch {
+	case err == nil:
+		if err := n.store.PushTokens.RecordSuccess(ctx, d.token.ID); err != nil {
+			log.Printf("Failed to reset push token %d failures: %v", d.token.ID, err)
+		}
+	case errors.Is(err, ErrPermanent):
+		disabled, recordErr := n.store.PushTokens.RecordFailure(ctx, d.token.ID, n.maxFailures)
+		if recordErr != nil {
+			log.Printf("Failed to record push token %d failure: %v", d.token.ID, recordErr)
+		} else if disabled {
+			log.Printf("Push token %d disabled after %d failures: %v", d.token.ID, n.maxFailures, err)
+		}
+	default:
+		log.Printf("Push to token %d failed after %d attempts: %v", d.token.ID, maxSendAttempts, err)
+	}
+}
+
+// preview shortens message content for a notification body
+func preview(body string) string {
+	if utf8.RuneCountInString(body) <= maxBodyLength {
+		return body
+	}
+	runes := []rune(body)
+	return string(runes[:maxBodyLength-1]) + "…"
+}
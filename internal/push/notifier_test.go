@@ -0,0 +1,342 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+	"github.com/drazan344/go-chat/internal/websocket"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// TestMain silences the notifier's log
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// sent is one push the fake provider received
+type sent struct {
+	token   string
+	payload Payload
+}
+
+// fakeProvider records pushes, failing each token with its queued errors first
+type fakeProvider struct {
+	mu       sync.Mutex
+	sent     []sent
+	attempts map[string]int
+	errs     map[string][]error
+}
+
+func (p *fakeProvider) Send(_ context.Context, token string, payload Payload) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.attempts[token]++
+	if errs := p.errs[token]; len(errs) > 0 {
+		p.errs[token] = errs[1:]
+		return errs[0]
+	}
+	p.sent = append(p.sent, sent{token, payload})
+	return nil
+}
+
+// tokens returns the tokens that got a push, sorted
+func (p *fakeProvider) tokens() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var tokens []string
+	for _, s := range p.sent {
+		tokens = append(tokens, s.token)
+	}
+	slices.Sort(tokens)
+	return tokens
+}
+
+// fakeMembers resolves usernames of one room's members
+type fakeMembers struct {
+	*store.RoomMemberStore
+	ids map[string]int64
+}
+
+func (f fakeMembers) GetRoomMembers(context.Context, int64) ([]int64, error) {
+	ids := make([]int64, 0, len(f.ids))
+	for _, id := range f.ids {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (f fakeMembers) FindMembersByUsername(_ context.Context, _ int64, usernames []string) ([]int64, error) {
+	var ids []int64
+	for _, name := range usernames {
+		if id, ok := f.ids[name]; ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// fakeTokens keeps push tokens and their failure streaks in memory
+type fakeTokens struct {
+	*store.PushTokenStore
+	mu     sync.Mutex
+	tokens []*store.PushToken
+}
+
+func (f *fakeTokens) ListActiveForUsers(_ context.Context, userIDs []int64) ([]*store.PushToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var active []*store.PushToken
+	for _, token := range f.tokens {
+		if slices.Contains(userIDs, token.UserID) && token.DisabledAt == nil {
+			copied := *token
+			active = append(active, &copied)
+		}
+	}
+	return active, nil
+}
+
+func (f *fakeTokens) RecordFailure(_ context.Context, id int64, maxFailures int) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	token := f.tokens[id-1]
+	token.ConsecutiveFailures++
+	if token.ConsecutiveFailures >= maxFailures && token.DisabledAt == nil {
+		now := time.Now()
+		token.DisabledAt = &now
+	}
+	return token.DisabledAt != nil, nil
+}
+
+func (f *fakeTokens) RecordSuccess(_ context.Context, id int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens[id-1].ConsecutiveFailures = 0
+	return nil
+}
+
+// failures returns a token's failure streak and whether it's disabled
+func (f *fakeTokens) failures(id int64) (int, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.tokens[id-1].ConsecutiveFailures, f.tokens[id-1].DisabledAt != nil
+}
+
+// onlineUsers is a Presence listing who is connected
+type onlineUsers map[int64]bool
+
+func (o onlineUsers) IsUserOnline(userID int64) bool { return o[userID] }
+
+// mutedPolicy is a notify.Policy where the listed users turned off push
+// mentions and everything else is allowed
+type mutedPolicy map[int64]bool
+
+func (m mutedPolicy) Allowed(_ context.Context, userID int64, channel, event string) bool {
+	return !(m[userID] && channel == store.NotifyChannelPush && event == store.NotifyEventMention)
+}
+
+func (m mutedPolicy) Filter(ctx context.Context, userIDs []int64, channel, event string) []int64 {
+	var allowed []int64
+	for _, userID := range userIDs {
+		if m.Allowed(ctx, userID, channel, event) {
+			allowed = append(allowed, userID)
+		}
+	}
+	return allowed
+}
+
+func (mutedPolicy) Invalidate(int64) {}
+
+// Users of the tests; ken is not a member of the room
+const (
+	ada int64 = iota + 1
+	grace
+	linus
+	ken
+)
+
+// newTestNotifier returns a notifier over a fake provider, with members
+// ada, grace and linus, and one token per user named after them (linus has
+// two devices); nobody has turned off push mentions
+func newTestNotifier(online onlineUsers, maxFailures int) (*Notifier, *fakeProvider, *fakeTokens) {
+	provider := &fakeProvider{attempts: make(map[string]int), errs: make(map[string][]error)}
+	tokens := &fakeTokens{}
+	for _, owner := range []struct {
+		userID int64
+		token  string
+	}{{ada, "ada-phone"}, {grace, "grace-phone"}, {linus, "linus-phone"}, {linus, "linus-tablet"}, {ken, "ken-phone"}} {
+		tokens.tokens = append(tokens.tokens, &store.PushToken{ID: int64(len(tokens.tokens) + 1), UserID: owner.userID, Token: owner.token})
+	}
+	st := store.Storage{
+		RoomMembers: fakeMembers{ids: map[string]int64{"ada": ada, "grace": grace, "linus": linus}},
+		PushTokens:  tokens,
+	}
+	n := NewNotifier(provider, st, online, mutedPolicy{}, maxFailures)
+	n.backoff = time.Millisecond
+	return n, provider, tokens
+}
+
+// mention has ada send content to room 1 and returns the tokens pushed to
+func mention(t *testing.T, online onlineUsers, content string, want int) []string {
+	t.Helper()
+	return push(t, online, wire.Message{ID: 9, RoomID: 1, UserID: ada, Username: "ada", Content: content}, want)
+}
+
+// push has the notifier handle a saved message, as mention does
+func push(t *testing.T, online onlineUsers, message wire.Message, want int) []string {
+	t.Helper()
+	n, provider, _ := newTestNotifier(online, 5)
+	n.messagePersisted(websocket.HookEvent{
+		Type:    websocket.EventMessagePersisted,
+		Message: &websocket.Message{Message: message},
+	})
+	testutil.WaitFor(time.Second, func() bool { return len(provider.tokens()) >= want })
+	time.Sleep(20 * time.Millisecond) // Long enough for any push that shouldn't happen
+	return provider.tokens()
+}
+
+// TestWhoGetsPushed checks exactly who gets a push for a mention: offline
+// room members on every device, and nobody who is online, isn't a member,
+// wasn't mentioned or sent the message
+func TestWhoGetsPushed(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		online  onlineUsers
+		content string
+		want    []string
+	}{
+		{"offline member", onlineUsers{}, "hi @grace", []string{"grace-phone"}},
+		{"online member", onlineUsers{grace: true}, "hi @grace", nil},
+		{"every device", onlineUsers{}, "@linus look", []string{"linus-phone", "linus-tablet"}},
+		{"mixed", onlineUsers{grace: true}, "@grace @linus @ken", []string{"linus-phone", "linus-tablet"}},
+		{"not a member", onlineUsers{}, "@ken hi", nil},
+		{"the sender", onlineUsers{}, "note to self @ada", nil},
+		{"mentioned twice", onlineUsers{}, "@grace @grace", []string{"grace-phone"}},
+		{"no mention", onlineUsers{}, "grace@example.com says hi", nil},
+		{"someone else online", onlineUsers{linus: true}, "@grace", []string{"grace-phone"}},
+	} {
+		got := mention(t, tc.online, tc.content, len(tc.want))
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: pushed to %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestRoomMentionPush checks @room pushes every offline member but the
+// sender, whether or not they're named, while @here pushes nobody: it's for
+// those connected. Without the permission the hub leaves RoomMention empty
+// and the text is just text
+func TestRoomMentionPush(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		online  onlineUsers
+		content string
+		scope   string
+		want    []string
+	}{
+		{"@room", onlineUsers{}, "@room release at 5", "room", []string{"grace-phone", "linus-phone", "linus-tablet"}},
+		{"@room with some online", onlineUsers{linus: true}, "@room release at 5", "room", []string{"grace-phone"}},
+		{"@here", onlineUsers{}, "@here release at 5", "here", nil},
+		{"@here naming someone", onlineUsers{}, "@here and @grace", "here", []string{"grace-phone"}},
+		{"@room without permission", onlineUsers{}, "@room release at 5", "", nil},
+	} {
+		message := wire.Message{ID: 9, RoomID: 1, UserID: ada, Username: "ada", Content: tc.content, RoomMention: tc.scope}
+		if got := push(t, tc.online, message, len(tc.want)); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: pushed to %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestPushPreference leaves out users who turned off push mentions, and
+// only them
+func TestPushPreference(t *testing.T) {
+	n, provider, _ := newTestNotifier(onlineUsers{}, 5)
+	n.policy = mutedPolicy{grace: true}
+	n.messagePersisted(websocket.HookEvent{
+		Type:    websocket.EventMessagePersisted,
+		Message: &websocket.Message{Message: wire.Message{ID: 9, RoomID: 1, UserID: ada, Username: "ada", Content: "@grace @linus"}},
+	})
+	testutil.WaitFor(time.Second, func() bool { return len(provider.tokens()) >= 2 })
+	time.Sleep(20 * time.Millisecond)
+	if got, want := provider.tokens(), []string{"linus-phone", "linus-tablet"}; !slices.Equal(got, want) {
+		t.Errorf("pushed to %q, want %q", got, want)
+	}
+}
+
+// TestPayload checks what a mention push carries, with a long message cut
+// to maxBodyLength characters
+func TestPayload(t *testing.T) {
+	n, provider, _ := newTestNotifier(onlineUsers{}, 5)
+	body := "@grace " + strings.Repeat("é", 200)
+	n.messagePersisted(websocket.HookEvent{
+		Type:    websocket.EventMessagePersisted,
+		Message: &websocket.Message{Message: wire.Message{ID: 9, RoomID: 4, UserID: ada, Username: "ada", Content: body}},
+	})
+	if !testutil.WaitFor(time.Second, func() bool { return len(provider.tokens()) == 1 }) {
+		t.Fatal("no push was sent")
+	}
+
+	payload := provider.sent[0].payload
+	if payload.Kind != KindMention || payload.Title != "ada mentioned you" || payload.RoomID != 4 || payload.MessageID != 9 {
+		t.Errorf("the payload is %+v", payload)
+	}
+	if utf8.RuneCountInString(payload.Body) != maxBodyLength || !strings.HasSuffix(payload.Body, "…") {
+		t.Errorf("the body is %d characters, want %d ending in an ellipsis", utf8.RuneCountInString(payload.Body), maxBodyLength)
+	}
+}
+
+// TestSendRetries checks transient errors are retried up to
+// maxSendAttempts, while a permanent one counts against the token at once
+// and enough of them in a row disable it
+func TestSendRetries(t *testing.T) {
+	transient := errors.New("connection reset")
+	permanent := fmt.Errorf("unregistered token: %w", ErrPermanent)
+
+	n, provider, tokens := newTestNotifier(onlineUsers{}, 2)
+	provider.errs["grace-phone"] = []error{transient, transient}
+	n.send(delivery{token: tokens.tokens[grace-1], payload: Payload{}})
+	if provider.attempts["grace-phone"] != 3 || len(provider.tokens()) != 1 {
+		t.Errorf("after two transient errors: %d attempts and %d pushes, want 3 and 1", provider.attempts["grace-phone"], len(provider.tokens()))
+	}
+
+	provider.errs["ada-phone"] = []error{transient, transient, transient}
+	n.send(delivery{token: tokens.tokens[ada-1], payload: Payload{}})
+	if failures, _ := tokens.failures(ada); provider.attempts["ada-phone"] != maxSendAttempts || failures != 0 {
+		t.Errorf("after only transient errors: %d attempts and %d failures, want %d and 0", provider.attempts["ada-phone"], failures, maxSendAttempts)
+	}
+
+	linusPhone := tokens.tokens[2]
+	provider.errs["linus-phone"] = []error{permanent, permanent}
+	n.send(delivery{token: linusPhone, payload: Payload{}})
+	if failures, disabled := tokens.failures(linusPhone.ID); provider.attempts["linus-phone"] != 1 || failures != 1 || disabled {
+		t.Errorf("after one permanent error: %d attempts, %d failures, disabled %v; want 1, 1, false", provider.attempts["linus-phone"], failures, disabled)
+	}
+	n.send(delivery{token: linusPhone, payload: Payload{}})
+	if _, disabled := tokens.failures(linusPhone.ID); !disabled {
+		t.Error("the token wasn't disabled after two permanent errors in a row")
+	}
+
+	// A disabled token isn't pushed to again
+	before := len(provider.tokens())
+	n.messagePersisted(websocket.HookEvent{
+		Type:    websocket.EventMessagePersisted,
+		Message: &websocket.Message{Message: wire.Message{ID: 9, RoomID: 1, UserID: ada, Username: "ada", Content: "@linus"}},
+	})
+	testutil.WaitFor(time.Second, func() bool { return len(provider.tokens()) > before })
+	time.Sleep(20 * time.Millisecond)
+	if got := provider.tokens(); len(got) != before+1 || !slices.Contains(got, "linus-tablet") || slices.Contains(got, "linus-phone") {
+		t.Errorf("after disabling linus's phone the pushes went to %q, want the tablet added", got)
+	}
+}
@@ -0,0 +1,55 @@
+// Package push sends notifications to users' devices while they're offline
+//
+// Providers (APNs, FCM, ...) implement Provider; the Notifier decides who gets
+// notified and delivers through a provider on its own worker pool
+package push
+
+import (
+	"context"
+	"errors"
+	"log"
+)
+
+// Kinds of notification
+const (
+	KindMention = "mention" // The user was @mentioned in a room
+)
+
+// ErrPermanent marks failures that retrying won't fix, like an unregistered token
+// Providers wrap it (fmt.Errorf("...: %w", push.ErrPermanent)) so the notifier can
+// count the failure against the token instead of retrying
+var ErrPermanent = errors.New("permanent push failure")
+
+// Payload is a provider-agnostic notification
+// Providers translate it into their own format (an APNs alert, an FCM message, ...)
+type Payload struct {
+	Kind      string `json:"kind"`
+	Title     string `json:"title"`
+	Body      string `json:"body"`
+	RoomID    int64  `json:"room_id"`
+	MessageID int64  `json:"message_id"`
+}
+
+// Provider delivers a payload to one device token
+type Provider interface {
+	Send(ctx context.Context, deviceToken string, payload Payload) error
+}
+
+// LogProvider writes notifications to the log instead of sending them
+// It's the default until a real provider is configured, and handy in development
+type LogProvider struct{}
+
+// Send logs the notification
+func (LogProvider) Send(_ context.Context, deviceToken string, payload Payload) error {
+	log.Printf("Push (%s) to token ...%s: %s: %s", payload.Kind, tokenSuffix(deviceToken), payload.Title, payload.Body)
+	return nil
+}
+
+// tokenSuffix returns the end of a token, enough to tell tokens apart in logs
+// without writing the whole credential out
+func tokenSuffix(token string) string {
+	if len(token) <= 6 {
+		return token
+	}
+	return token[len(token)-6:]
+}
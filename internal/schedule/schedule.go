@@ -0,0 +1,113 @@
+// Package schedule evaluates recurring weekly time windows, like a room's
+// quiet hours, in the window's own timezone
+//
+// Windows are defined in wall-clock time, so "18:00" stays 18:00 across
+// daylight saving changes. Anything that needs "is it that time now?" logic
+// (quiet hours, do-not-disturb) should go through Window rather than
+// redoing the midnight and timezone handling
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	// Embed the timezone database: the runtime image (alpine) doesn't ship one
+	_ "time/tzdata"
+)
+
+// dayNames maps the accepted day names to time.Weekday
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Window is a daily time span on selected days of the week
+//
+// When End is before Start the window runs past midnight into the next day:
+// {"start": "18:00", "end": "08:00", "days": ["fri"]} is Friday 18:00 until
+// Saturday 08:00. Days are always the days a window starts on
+// When Start equals End the window covers the whole of each listed day
+type Window struct {
+	Start    string   `json:"start"`          // "HH:MM", 24-hour local time
+	End      string   `json:"end"`            // "HH:MM", 24-hour local time
+	Timezone string   `json:"timezone"`       // IANA name, e.g. "Europe/Berlin"; empty means UTC
+	Days     []string `json:"days,omitempty"` // "mon" to "sun"; empty means every day
+
+	// Filled in by Validate
+	start, end int // Minutes after midnight
+	loc        *time.Location
+	days       [7]bool
+}
+
+// Validate checks the window and prepares it for Active
+// Windows decoded from JSON must be validated before use
+// Day names are normalized to lowercase
+func (w *Window) Validate() error {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return fmt.Errorf("end: %w", err)
+	}
+
+	loc, err := time.LoadLocation(w.Timezone)
+	if err != nil {
+		return fmt.Errorf("unknown timezone %q", w.Timezone)
+	}
+
+	var days [7]bool
+	if len(w.Days) == 0 {
+		days = [7]bool{true, true, true, true, true, true, true}
+	}
+	for i, name := range w.Days {
+		name = strings.ToLower(strings.TrimSpace(name))
+		day, ok := dayNames[name]
+		if !ok {
+			return fmt.Errorf("unknown day %q (use mon, tue, wed, thu, fri, sat, sun)", w.Days[i])
+		}
+		w.Days[i] = name
+		days[day] = true
+	}
+
+	w.start, w.end, w.loc, w.days = start, end, loc, days
+	return nil
+}
+
+// Active reports whether t falls inside the window
+// A nil window is never active
+func (w *Window) Active(t time.Time) bool {
+	if w == nil || w.loc == nil {
+		return false
+	}
+
+	local := t.In(w.loc)
+	minute := local.Hour()*60 + local.Minute()
+	today := local.Weekday()
+	yesterday := (today + 6) % 7
+
+	switch {
+	case w.start == w.end:
+		// The whole day
+		return w.days[today]
+	case w.start < w.end:
+		// Same-day window, e.g. 09:00-17:00
+		return w.days[today] && minute >= w.start && minute < w.end
+	default:
+		// Overnight window, e.g. 18:00-08:00: either tonight's started,
+		// or last night's hasn't ended yet
+		return (w.days[today] && minute >= w.start) || (w.days[yesterday] && minute < w.end)
+	}
+}
+
+// parseClock parses "HH:MM" into minutes after midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, errors.New("time must be HH:MM")
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
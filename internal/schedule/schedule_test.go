@@ -0,0 +1,121 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// at parses a time in the given zone, in "2006-01-02 15:04" layout
+func at(t *testing.T, zone, value string) time.Time {
+	t.Helper()
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := time.ParseInLocation("2006-01-02 15:04", value, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+// TestActive checks windows at and around their edges
+// 2026-03-06 is a Friday; Europe/Berlin moves to summer time at 02:00 on
+// Sunday 2026-03-29 and back at 03:00 on Sunday 2026-10-25
+func TestActive(t *testing.T) {
+	office := Window{Start: "09:00", End: "17:00", Timezone: "Europe/Berlin", Days: []string{"mon", "tue", "wed", "thu", "fri"}}
+	weekendNights := Window{Start: "18:00", End: "08:00", Timezone: "Europe/Berlin", Days: []string{"Fri", "SAT"}}
+	allDay := Window{Start: "00:00", End: "00:00", Timezone: "America/New_York", Days: []string{"sun"}}
+	nightly := Window{Start: "22:00", End: "06:00", Timezone: "Europe/Berlin"}
+	fallBack := Window{Start: "02:00", End: "03:00", Timezone: "Europe/Berlin", Days: []string{"sun"}}
+
+	for _, tc := range []struct {
+		name   string
+		window Window
+		at     time.Time
+		want   bool
+	}{
+		// Same-day window: the start is in, the end is out
+		{"office opens", office, at(t, "Europe/Berlin", "2026-03-06 09:00"), true},
+		{"office before opening", office, at(t, "Europe/Berlin", "2026-03-06 08:59"), false},
+		{"office closes", office, at(t, "Europe/Berlin", "2026-03-06 17:00"), false},
+		{"office on saturday", office, at(t, "Europe/Berlin", "2026-03-07 12:00"), false},
+		{"office from another zone", office, at(t, "UTC", "2026-03-06 08:30"), true}, // 09:30 in Berlin
+
+		// Past midnight: Friday's window runs into Saturday morning, and
+		// Saturday's into Sunday, but Thursday has none
+		{"friday evening", weekendNights, at(t, "Europe/Berlin", "2026-03-06 18:00"), true},
+		{"friday before the start", weekendNights, at(t, "Europe/Berlin", "2026-03-06 17:59"), false},
+		{"saturday small hours", weekendNights, at(t, "Europe/Berlin", "2026-03-07 07:59"), true},
+		{"saturday morning", weekendNights, at(t, "Europe/Berlin", "2026-03-07 08:00"), false},
+		{"sunday small hours", weekendNights, at(t, "Europe/Berlin", "2026-03-08 03:00"), true},
+		{"sunday evening", weekendNights, at(t, "Europe/Berlin", "2026-03-08 19:00"), false},
+		{"friday small hours", weekendNights, at(t, "Europe/Berlin", "2026-03-06 03:00"), false},
+
+		// Whole day: midnight to midnight in the window's zone
+		{"sunday starts", allDay, at(t, "America/New_York", "2026-03-08 00:00"), true},
+		{"sunday ends", allDay, at(t, "America/New_York", "2026-03-08 23:59"), true},
+		{"monday starts", allDay, at(t, "America/New_York", "2026-03-09 00:00"), false},
+		{"sunday in new york, monday in utc", allDay, at(t, "UTC", "2026-03-09 02:00"), true},
+
+		// Wall-clock times hold across daylight saving changes: 22:00 is
+		// 21:00 UTC in winter and 20:00 UTC in summer
+		{"winter evening", nightly, at(t, "UTC", "2026-03-28 21:00"), true},
+		{"summer evening", nightly, at(t, "UTC", "2026-03-29 20:00"), true},
+		{"summer, an hour early", nightly, at(t, "UTC", "2026-03-29 19:59"), false},
+		{"the short night ends", nightly, at(t, "UTC", "2026-03-29 03:59"), true}, // 05:59 CEST
+		{"after the short night", nightly, at(t, "UTC", "2026-03-29 04:00"), false},
+
+		// 02:00-03:00 happens twice the night clocks go back, and is quiet both times
+		{"first 02:30", fallBack, at(t, "UTC", "2026-10-25 00:30"), true},
+		{"second 02:30", fallBack, at(t, "UTC", "2026-10-25 01:30"), true},
+		{"03:00 after falling back", fallBack, at(t, "UTC", "2026-10-25 02:00"), false},
+	} {
+		window := tc.window
+		window.Days = append([]string(nil), tc.window.Days...)
+		if err := window.Validate(); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := window.Active(tc.at); got != tc.want {
+			t.Errorf("%s (%s): active is %v, want %v", tc.name, tc.at.In(window.loc).Format("Mon 15:04 MST"), got, tc.want)
+		}
+	}
+}
+
+// TestActiveNil never finds a missing or unvalidated window active
+func TestActiveNil(t *testing.T) {
+	var missing *Window
+	if missing.Active(time.Now()) {
+		t.Error("a nil window is active")
+	}
+	unvalidated := &Window{Start: "00:00", End: "00:00"}
+	if unvalidated.Active(time.Now()) {
+		t.Error("an unvalidated window is active")
+	}
+}
+
+// TestValidate refuses malformed windows and normalizes day names
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		window Window
+		err    string
+	}{
+		{Window{Start: "9am", End: "17:00"}, "start"},
+		{Window{Start: "09:00", End: "24:00"}, "end"},
+		{Window{Start: "09:00", End: "17:00", Timezone: "Mars/Olympus"}, "unknown timezone"},
+		{Window{Start: "09:00", End: "17:00", Days: []string{"mon", "funday"}}, "unknown day"},
+	} {
+		if err := tc.window.Validate(); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%+v: got %v, want an error about %s", tc.window, err, tc.err)
+		}
+	}
+
+	window := Window{Start: "09:00", End: "17:00", Days: []string{" Mon ", "FRI"}}
+	if err := window.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if window.Days[0] != "mon" || window.Days[1] != "fri" {
+		t.Errorf("the days are %q, want mon and fri", window.Days)
+	}
+}
@@ -0,0 +1,120 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestHistogramBoundaries seeds messages on the scratch database either side
+// of a month end and on the day Europe moves its clocks forward: day buckets
+// split at UTC midnight and nowhere else, week buckets start on Monday, the
+// system user isn't counted as a sender, and FirstMessageAt finds the first
+// message at or after a time
+func TestHistogramBoundaries(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	rooms := &RoomStore{db, Limits{MaxRoomMembers: 100, MaxRoomsPerUser: 100}, NewPools(db, nil)}
+	messages := &MessageStore{db, NewPools(db, nil)}
+	suffix := time.Now().UnixNano()
+
+	var ada, grace int64
+	for _, user := range []struct {
+		name string
+		id   *int64
+	}{{"ada", &ada}, {"grace", &grace}} {
+		query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+		if err := db.QueryRowContext(ctx, query, fmt.Sprintf("histogram-%s-%d", user.name, suffix)).Scan(user.id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The system user is normally created at startup
+	systemUser := `INSERT INTO users (id, username, email, password) VALUES ($1, 'system', $2, '!') ON CONFLICT (id) DO NOTHING`
+	if _, err := db.ExecContext(ctx, systemUser, SystemUserID, systemUserEmail); err != nil {
+		t.Fatal(err)
+	}
+	room := &Room{Name: fmt.Sprintf("histogram-%d", suffix), CreatedBy: ada}
+	if err := rooms.Create(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM rooms WHERE id = $1`, room.ID)
+		db.Exec(`DELETE FROM users WHERE id = ANY(ARRAY[$1, $2]::bigint[])`, ada, grace)
+	})
+
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	for _, m := range []struct {
+		userID int64
+		at     time.Time
+	}{
+		{ada, at(2, 29, 23, 59)},
+		{grace, at(3, 1, 0, 0)},
+		{SystemUserID, at(3, 1, 0, 5)},
+		// Clocks in Europe go forward at 01:00 UTC on the 31st
+		{ada, at(3, 31, 0, 30)},
+		{grace, at(3, 31, 23, 30)},
+		{ada, at(4, 1, 0, 0)},
+	} {
+		query := `INSERT INTO messages (room_id, user_id, content, created_at) VALUES ($1, $2, 'hi', $3)`
+		if _, err := db.ExecContext(ctx, query, room.ID, m.userID, m.at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type bucket struct {
+		start          time.Time
+		count, senders int
+	}
+	for _, tc := range []struct {
+		granularity string
+		want        []bucket
+	}{
+		{HistogramDay, []bucket{
+			{at(2, 29, 0, 0), 1, 1}, {at(3, 1, 0, 0), 2, 1}, {at(3, 31, 0, 0), 2, 2}, {at(4, 1, 0, 0), 1, 1},
+		}},
+		{HistogramWeek, []bucket{
+			// February 26th and March 25th are Mondays
+			{at(2, 26, 0, 0), 3, 2}, {at(3, 25, 0, 0), 2, 2}, {at(4, 1, 0, 0), 1, 1},
+		}},
+	} {
+		buckets, err := messages.Histogram(ctx, room.ID, tc.granularity, at(2, 1, 0, 0), at(5, 1, 0, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(buckets) != len(tc.want) {
+			t.Fatalf("%s: got %d buckets, want %d", tc.granularity, len(buckets), len(tc.want))
+		}
+		for i, want := range tc.want {
+			got := buckets[i]
+			if !got.PeriodStart.Equal(want.start) || got.PeriodStart.Location() != time.UTC || got.MessageCount != want.count || got.ActiveUserCount != want.senders {
+				t.Errorf("%s bucket %d got %+v, want %+v", tc.granularity, i, got, want)
+			}
+		}
+	}
+
+	// The range's end is exclusive
+	buckets, err := messages.Histogram(ctx, room.ID, HistogramDay, at(3, 31, 0, 0), at(4, 1, 0, 0))
+	if err != nil || len(buckets) != 1 || buckets[0].MessageCount != 2 {
+		t.Errorf("the last day of March got %+v, %v", buckets, err)
+	}
+
+	var first int64
+	if err := db.QueryRowContext(ctx, `SELECT id FROM messages WHERE room_id = $1 AND created_at = $2`, room.ID, at(3, 31, 23, 30)).Scan(&first); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := messages.FirstMessageAt(ctx, room.ID, at(3, 31, 1, 0)); err != nil || id != first {
+		t.Errorf("the first message after 01:00 on the 31st got %d, %v, want %d", id, err, first)
+	}
+	if _, err := messages.FirstMessageAt(ctx, room.ID, at(4, 2, 0, 0)); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("after the last message got %v, want sql.ErrNoRows", err)
+	}
+}
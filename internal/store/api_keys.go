@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// APIKey is a bot's API key: a credential that never expires, managed apart
+// from personal access tokens, so signing a person out leaves their bots be
+// Only its SHA-256 is stored, so the key itself can't be shown again
+type APIKey struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // First characters of the key, to tell keys apart
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"` // nil until the key is first used
+}
+
+// APIKeyStore handles database operations for bot API keys
+type APIKeyStore struct {
+	db *sql.DB
+}
+
+// Create saves a new key under the given hash
+func (s *APIKeyStore) Create(ctx context.Context, key *APIKey, hash string) error {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_at
+	`
+
+	return s.db.QueryRowContext(ctx, query, key.UserID, key.Name, key.Prefix, hash).Scan(
+		&key.ID,
+		&key.CreatedAt,
+	)
+}
+
+// List returns a user's keys, newest first
+func (s *APIKeyStore) List(ctx context.Context, userID int64) ([]*APIKey, error) {
+	query := `
+		SELECT id, user_id, name, prefix, created_at, last_used_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]*APIKey, 0)
+	for rows.Next() {
+		key := &APIKey{}
+		if err := rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.CreatedAt, &key.LastUsedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// GetByHash finds the key with the given hash
+// A ban keeps a user's keys but locks their bots out until it's lifted
+// Returns sql.ErrNoRows for unknown (or revoked) keys and keys of banned users
+func (s *APIKeyStore) GetByHash(ctx context.Context, hash string) (*APIKey, error) {
+	query := `
+		SELECT k.id, k.user_id, k.name, k.prefix, k.created_at, k.last_used_at
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = $1 AND u.banned_at IS NULL
+	`
+
+	key := &APIKey{}
+	err := s.db.QueryRowContext(ctx, query, hash).Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix,
+		&key.CreatedAt, &key.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Touch records that a key was used
+func (s *APIKeyStore) Touch(ctx context.Context, keyID int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = NOW() WHERE id = $1`, keyID)
+	return err
+}
+
+// Revoke deletes one of a user's keys; it stops working on the next request
+// The user ID is part of the WHERE clause so users can't revoke each other's keys
+// Returns sql.ErrNoRows if the key doesn't exist or belongs to someone else
+func (s *APIKeyStore) Revoke(ctx context.Context, keyID, userID int64) error {
+	return expectOneRow(s.db.ExecContext(ctx, `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`, keyID, userID))
+}
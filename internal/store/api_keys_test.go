@@ -0,0 +1,41 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestAPIKeyLookup finds a key by its hash, skipping banned users' keys
+func TestAPIKeyLookup(t *testing.T) {
+	db, mock := newMockDB(t)
+	keys := &APIKeyStore{db}
+	now := time.Now()
+
+	mock.ExpectQuery(`FROM api_keys k\s+JOIN users u ON u.id = k.user_id\s+WHERE k.key_hash = \$1 AND u.banned_at IS NULL`).WithArgs("abc123").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name", "prefix", "created_at", "last_used_at"}).
+			AddRow(3, 1, "deploy bot", "gcbot_3fa1c", now, nil))
+	key, err := keys.GetByHash(context.Background(), "abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.ID != 3 || key.UserID != 1 || key.LastUsedAt != nil {
+		t.Errorf("got %+v, want key 3 of user 1, never used", key)
+	}
+}
+
+// TestRevokeAPIKey deletes a key only for its owner
+func TestRevokeAPIKey(t *testing.T) {
+	db, mock := newMockDB(t)
+	keys := &APIKeyStore{db}
+
+	mock.ExpectExec(`DELETE FROM api_keys WHERE id = \$1 AND user_id = \$2`).WithArgs(int64(3), int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := keys.Revoke(context.Background(), 3, 2); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("revoking someone else's key got %v, want sql.ErrNoRows", err)
+	}
+}
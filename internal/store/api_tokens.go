@@ -0,0 +1,105 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// APIToken is a personal access token for scripts and integrations
+// Only its SHA-256 is stored, so the token itself can't be shown again
+type APIToken struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // First characters of the token, to tell tokens apart
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"` // nil until the token is first used
+	ExpiresAt  *time.Time `json:"expires_at"`   // nil means the token never expires
+}
+
+// Expired reports whether the token is past its expiry
+func (t *APIToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// APITokenStore handles database operations for personal access tokens
+type APITokenStore struct {
+	db *sql.DB
+}
+
+// Create saves a new token under the given hash
+func (s *APITokenStore) Create(ctx context.Context, token *APIToken, hash string) error {
+	query := `
+		INSERT INTO api_tokens (user_id, name, prefix, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at
+	`
+
+	return s.db.QueryRowContext(ctx, query, token.UserID, token.Name, token.Prefix, hash, token.ExpiresAt).Scan(
+		&token.ID,
+		&token.CreatedAt,
+	)
+}
+
+// List returns a user's tokens, newest first
+func (s *APITokenStore) List(ctx context.Context, userID int64) ([]*APIToken, error) {
+	query := `
+		SELECT id, user_id, name, prefix, created_at, last_used_at, expires_at
+		FROM api_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]*APIToken, 0)
+	for rows.Next() {
+		token := &APIToken{}
+		if err := rows.Scan(&token.ID, &token.UserID, &token.Name, &token.Prefix,
+			&token.CreatedAt, &token.LastUsedAt, &token.ExpiresAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// GetByHash finds the token with the given hash
+// Expiry isn't checked here; the caller decides what an expired token means
+// Returns sql.ErrNoRows for unknown (or revoked) tokens
+func (s *APITokenStore) GetByHash(ctx context.Context, hash string) (*APIToken, error) {
+	query := `
+		SELECT id, user_id, name, prefix, created_at, last_used_at, expires_at
+		FROM api_tokens
+		WHERE token_hash = $1
+	`
+
+	token := &APIToken{}
+	err := s.db.QueryRowContext(ctx, query, hash).Scan(&token.ID, &token.UserID, &token.Name, &token.Prefix,
+		&token.CreatedAt, &token.LastUsedAt, &token.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Touch records that a token was used
+func (s *APITokenStore) Touch(ctx context.Context, tokenID int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE api_tokens SET last_used_at = NOW() WHERE id = $1`, tokenID)
+	return err
+}
+
+// Revoke deletes one of a user's tokens; it stops working on the next request
+// The user ID is part of the WHERE clause so users can't revoke each other's tokens
+// Returns sql.ErrNoRows if the token doesn't exist or belongs to someone else
+func (s *APITokenStore) Revoke(ctx context.Context, tokenID, userID int64) error {
+	return expectOneRow(s.db.ExecContext(ctx, `DELETE FROM api_tokens WHERE id = $1 AND user_id = $2`, tokenID, userID))
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestAPITokenLookup finds a token by its hash, and only by its hash
+func TestAPITokenLookup(t *testing.T) {
+	db, mock := newMockDB(t)
+	tokens := &APITokenStore{db}
+	now := time.Now()
+
+	mock.ExpectQuery(`FROM api_tokens\s+WHERE token_hash = \$1`).WithArgs("abc123").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name", "prefix", "created_at", "last_used_at", "expires_at"}).
+			AddRow(3, 1, "cron", "gochat_3fa1c", now, nil, now))
+	token, err := tokens.GetByHash(context.Background(), "abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.ID != 3 || token.LastUsedAt != nil || !token.Expired(now) || token.Expired(now.Add(-time.Second)) {
+		t.Errorf("got %+v, want token 3 expiring now", token)
+	}
+}
+
+// TestRevokeAPIToken deletes a token only for its owner
+func TestRevokeAPIToken(t *testing.T) {
+	db, mock := newMockDB(t)
+	tokens := &APITokenStore{db}
+
+	mock.ExpectExec(`DELETE FROM api_tokens WHERE id = \$1 AND user_id = \$2`).WithArgs(int64(3), int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := tokens.Revoke(context.Background(), 3, 2); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("revoking someone else's token got %v, want sql.ErrNoRows", err)
+	}
+}
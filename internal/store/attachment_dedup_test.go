@@ -0,0 +1,100 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestConcurrentAttachmentUploads has several users upload the same new
+// file at once on the scratch database: the unique hash index lets only one
+// of them insert the blob, the rest retry onto it, and the reference count
+// falls back to zero, removing the blob, only with the last deletion
+func TestConcurrentAttachmentUploads(t *testing.T) {
+	const uploads = 8
+
+	db := testdb.Open(t)
+	ctx := context.Background()
+	attachments := &AttachmentStore{db, NewPools(db, nil)}
+	suffix := time.Now().UnixNano()
+	sum := sha256.Sum256([]byte(fmt.Sprint("dedup-", suffix)))
+	hash := hex.EncodeToString(sum[:])
+
+	var userID int64
+	query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+	if err := db.QueryRowContext(ctx, query, fmt.Sprintf("dedup-ada-%d", suffix)).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM users WHERE id = $1`, userID)
+		db.Exec(`DELETE FROM attachments WHERE hash = $1`, hash)
+	})
+
+	// Every upload waits at the start line so their transactions overlap
+	start := make(chan struct{})
+	added := make([]*Attachment, uploads)
+	errs := make([]error, uploads)
+	var wg sync.WaitGroup
+	for i := range uploads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			a := &Attachment{UserID: userID, Filename: fmt.Sprintf("meme-%d.png", i), Hash: hash, Size: 42, ContentType: "image/png"}
+			errs[i] = attachments.Add(ctx, a, func() error { return nil })
+			added[i] = a
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	fresh := 0
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("upload %d failed: %v", i, err)
+		}
+		if !added[i].Deduplicated {
+			fresh++
+		}
+		if added[i].AttachmentID != added[0].AttachmentID {
+			t.Errorf("upload %d got blob %d, want %d", i, added[i].AttachmentID, added[0].AttachmentID)
+		}
+	}
+	if fresh != 1 {
+		t.Errorf("%d uploads inserted the blob, want 1", fresh)
+	}
+
+	stats := func() (rows, refCount int) {
+		t.Helper()
+		err := db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(ref_count), 0) FROM attachments WHERE hash = $1`, hash).Scan(&rows, &refCount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows, refCount
+	}
+	if rows, refCount := stats(); rows != 1 || refCount != uploads {
+		t.Fatalf("there are %d blob rows with %d references, want 1 with %d", rows, refCount, uploads)
+	}
+
+	var cleaned []string
+	cleanup := func(h string) error { cleaned = append(cleaned, h); return nil }
+	for i, a := range added {
+		if err := attachments.Remove(ctx, a.ID, userID, cleanup); err != nil {
+			t.Fatal(err)
+		}
+		if last := i == uploads-1; (len(cleaned) > 0) != last {
+			t.Fatalf("after %d of %d deletions the blob was cleaned up %d times", i+1, uploads, len(cleaned))
+		}
+	}
+	if rows, _ := stats(); rows != 0 || cleaned[0] != hash {
+		t.Errorf("%d blob rows are left and %v was cleaned up, want none left and %s", rows, cleaned, hash)
+	}
+}
@@ -0,0 +1,349 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// maxAttachmentAttempts is how often Add retries after losing an insert race
+const maxAttachmentAttempts = 3
+
+// Attachment is one upload as its owner sees it
+// The bytes behind it are shared with every other upload of the same file
+// Frames carry it as wire.Attachment (see Wire); new fields clients see go on both
+type Attachment struct {
+	ID           int64     `json:"id"`            // The upload (attachment_refs row)
+	AttachmentID int64     `json:"attachment_id"` // The shared blob (attachments row)
+	UserID       int64     `json:"user_id"`
+	Filename     string    `json:"filename"`
+	Hash         string    `json:"hash"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	// Deduplicated is true if the bytes were already stored by an earlier upload
+	Deduplicated bool `json:"deduplicated"`
+
+	// Images only: dimensions read from the file's header, and the thumbnail
+	// state. ThumbnailPending is set while the thumbnail is being made;
+	// ThumbnailURL is filled in by the API once HasThumbnail is true
+	Width            int    `json:"width,omitempty"`
+	Height           int    `json:"height,omitempty"`
+	ThumbnailPending bool   `json:"thumbnail_pending,omitempty"`
+	HasThumbnail     bool   `json:"-"`
+	ThumbnailURL     string `json:"thumbnail_url,omitempty"`
+}
+
+// Wire returns the attachment as frames carry it
+func (a *Attachment) Wire() *wire.Attachment {
+	return &wire.Attachment{
+		ID:               a.ID,
+		AttachmentID:     a.AttachmentID,
+		UserID:           a.UserID,
+		Filename:         a.Filename,
+		Hash:             a.Hash,
+		Size:             a.Size,
+		ContentType:      a.ContentType,
+		CreatedAt:        a.CreatedAt,
+		Deduplicated:     a.Deduplicated,
+		Width:            a.Width,
+		Height:           a.Height,
+		ThumbnailPending: a.ThumbnailPending,
+		ThumbnailURL:     a.ThumbnailURL,
+	}
+}
+
+// attachmentColumns are the columns scanAttachment reads, from attachment_refs r joined with attachments a
+const attachmentColumns = `r.id, a.id, r.user_id, r.filename, a.hash, a.size, a.content_type, r.created_at,
+	COALESCE(a.width, 0), COALESCE(a.height, 0), a.thumbnail_pending, a.has_thumbnail`
+
+// scanAttachment reads a row selected with attachmentColumns
+func scanAttachment(row interface{ Scan(...interface{}) error }) (*Attachment, error) {
+	a := &Attachment{}
+	err := row.Scan(
+		&a.ID,
+		&a.AttachmentID,
+		&a.UserID,
+		&a.Filename,
+		&a.Hash,
+		&a.Size,
+		&a.ContentType,
+		&a.CreatedAt,
+		&a.Width,
+		&a.Height,
+		&a.ThumbnailPending,
+		&a.HasThumbnail,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// StorageStats compares the bytes users uploaded with the bytes actually stored
+type StorageStats struct {
+	Blobs         int64 `json:"blobs"`          // Distinct files stored
+	Uploads       int64 `json:"uploads"`        // Uploads referencing them
+	LogicalBytes  int64 `json:"logical_bytes"`  // Sum of every upload's size
+	PhysicalBytes int64 `json:"physical_bytes"` // Sum of every distinct file's size
+	SavedBytes    int64 `json:"saved_bytes"`    // LogicalBytes - PhysicalBytes
+}
+
+// AttachmentStore handles uploads and the reference counts of the blobs behind them
+type AttachmentStore struct {
+	db    *sql.DB
+	reads *Pools // Stats may run on the read replica
+}
+
+// Add records an upload, reusing the blob row if the same bytes were stored before
+// Hash, Size and ContentType must be set, and Width, Height and
+// ThumbnailPending for a new image; ID, AttachmentID, CreatedAt and
+// Deduplicated are filled in. A reused blob keeps its own image fields
+//
+// place is called inside the transaction, after the blob row has been locked
+// (existing blob) or inserted (new blob), to make sure the file is on disk
+// Remove runs its cleanup under the same row lock, so a blob can't be deleted
+// between place and the reference being committed
+//
+// Two concurrent uploads of the same new file race on the unique hash index;
+// the loser's transaction fails and is retried, and then finds the winner's row
+func (s *AttachmentStore) Add(ctx context.Context, a *Attachment, place func() error) error {
+	var err error
+	for attempt := 0; attempt < maxAttachmentAttempts; attempt++ {
+		err = s.add(ctx, a, place)
+		if !IsUniqueViolation(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// add is one attempt of Add
+func (s *AttachmentStore) add(ctx context.Context, a *Attachment, place func() error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The UPDATE takes the row lock that Remove also waits for
+	// An existing blob keeps its original content type, sniffed from the same bytes
+	referenceQuery := `
+		UPDATE attachments
+		SET ref_count = ref_count + 1
+		WHERE hash = $1
+		RETURNING id, content_type, COALESCE(width, 0), COALESCE(height, 0), thumbnail_pending, has_thumbnail
+	`
+	err = tx.QueryRowContext(ctx, referenceQuery, a.Hash).Scan(
+		&a.AttachmentID, &a.ContentType, &a.Width, &a.Height, &a.ThumbnailPending, &a.HasThumbnail,
+	)
+	switch {
+	case err == nil:
+		a.Deduplicated = true
+	case errors.Is(err, sql.ErrNoRows):
+		insertQuery := `
+			INSERT INTO attachments (hash, size, content_type, width, height, thumbnail_pending)
+			VALUES ($1, $2, $3, NULLIF($4, 0), NULLIF($5, 0), $6)
+			RETURNING id
+		`
+		err := tx.QueryRowContext(ctx, insertQuery, a.Hash, a.Size, a.ContentType, a.Width, a.Height, a.ThumbnailPending).Scan(&a.AttachmentID)
+		if err != nil {
+			return err
+		}
+		a.Deduplicated = false
+	default:
+		return err
+	}
+
+	// Also run for existing blobs: if a cleanup ever failed halfway, this puts the file back
+	if err := place(); err != nil {
+		return err
+	}
+
+	refQuery := `
+		INSERT INTO attachment_refs (attachment_id, user_id, filename)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`
+	if err := tx.QueryRowContext(ctx, refQuery, a.AttachmentID, a.UserID, a.Filename).Scan(&a.ID, &a.CreatedAt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetByID retrieves an upload owned by the given user
+// Returns sql.ErrNoRows if it doesn't exist or belongs to someone else
+func (s *AttachmentStore) GetByID(ctx context.Context, id, userID int64) (*Attachment, error) {
+	query := `
+		SELECT ` + attachmentColumns + `
+		FROM attachment_refs r
+		INNER JOIN attachments a ON a.id = r.attachment_id
+		WHERE r.id = $1 AND r.user_id = $2
+	`
+
+	return scanAttachment(s.db.QueryRowContext(ctx, query, id, userID))
+}
+
+// FinishThumbnail records that a blob's thumbnail was made (made is true) or
+// can't be made, and returns every upload of the blob so their owners can be told
+// Returns no uploads if the blob is gone or wasn't waiting for a thumbnail
+func (s *AttachmentStore) FinishThumbnail(ctx context.Context, attachmentID int64, made bool) ([]*Attachment, error) {
+	query := `
+		WITH finished AS (
+			UPDATE attachments
+			SET thumbnail_pending = FALSE, has_thumbnail = $2
+			WHERE id = $1 AND thumbnail_pending
+			RETURNING id, hash, size, content_type, width, height, thumbnail_pending, has_thumbnail
+		)
+		SELECT ` + attachmentColumns + `
+		FROM attachment_refs r
+		INNER JOIN finished a ON a.id = r.attachment_id
+		ORDER BY r.id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, attachmentID, made)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make([]*Attachment, 0)
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+// PendingThumbnails returns the hashes of the blobs still waiting for a
+// thumbnail, keyed by their attachments row ID
+// Thumbnails are made in the background, so a restart can leave some behind
+func (s *AttachmentStore) PendingThumbnails(ctx context.Context) (map[int64]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, hash FROM attachments WHERE thumbnail_pending`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pending := make(map[int64]string)
+	for rows.Next() {
+		var (
+			id   int64
+			hash string
+		)
+		if err := rows.Scan(&id, &hash); err != nil {
+			return nil, err
+		}
+		pending[id] = hash
+	}
+	return pending, rows.Err()
+}
+
+// Remove deletes an upload owned by the given user and releases its blob
+// When the last reference goes, the blob row is deleted and cleanup is called
+// with its hash, still inside the transaction, to delete the file
+// Returns sql.ErrNoRows if the upload doesn't exist or belongs to someone else
+func (s *AttachmentStore) Remove(ctx context.Context, id, userID int64, cleanup func(hash string) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var attachmentID int64
+	deleteQuery := `
+		DELETE FROM attachment_refs
+		WHERE id = $1 AND user_id = $2
+		RETURNING attachment_id
+	`
+	if err := tx.QueryRowContext(ctx, deleteQuery, id, userID).Scan(&attachmentID); err != nil {
+		return err
+	}
+
+	var hash string
+	var refCount int
+	releaseQuery := `
+		UPDATE attachments
+		SET ref_count = ref_count - 1
+		WHERE id = $1
+		RETURNING hash, ref_count
+	`
+	if err := tx.QueryRowContext(ctx, releaseQuery, attachmentID).Scan(&hash, &refCount); err != nil {
+		return err
+	}
+
+	if refCount == 0 {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM attachments WHERE id = $1`, attachmentID); err != nil {
+			return err
+		}
+		// The row lock is still held, so no upload can reference this blob meanwhile
+		if err := cleanup(hash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Stats reports logical vs physical storage across all attachments
+func (s *AttachmentStore) Stats(ctx context.Context) (*StorageStats, error) {
+	query := `
+		SELECT COUNT(*), COALESCE(SUM(ref_count), 0),
+		       COALESCE(SUM(size * ref_count), 0), COALESCE(SUM(size), 0)
+		FROM attachments
+	`
+
+	stats := &StorageStats{}
+	err := s.reads.queryRow(ctx, "AttachmentStore.Stats", query, nil, &stats.Blobs, &stats.Uploads, &stats.LogicalBytes, &stats.PhysicalBytes)
+	if err != nil {
+		return nil, err
+	}
+	stats.SavedBytes = stats.LogicalBytes - stats.PhysicalBytes
+	return stats, nil
+}
+
+// Search finds a user's own uploads whose file name contains q, ignoring
+// case, newest first
+// Uploads aren't tied to a room, and only their owner may open them, so a
+// room search can only offer the searching user's files
+func (s *AttachmentStore) Search(ctx context.Context, userID int64, q string, limit int) ([]*Attachment, error) {
+	// q is matched literally, so LIKE's wildcards in it must be escaped
+	q = searchTerm(q)
+	if q == "" {
+		return make([]*Attachment, 0), nil
+	}
+	limit, _ = clampPage(limit, 0, 20, maxRoomSearchLimit)
+
+	query := `
+		SELECT ` + attachmentColumns + `
+		FROM attachment_refs r
+		INNER JOIN attachments a ON a.id = r.attachment_id
+		WHERE r.user_id = $1 AND r.filename ILIKE '%' || $2 || '%'
+		ORDER BY r.id DESC
+		LIMIT $3
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID, escapeLike(q), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make([]*Attachment, 0)
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, rows.Err()
+}
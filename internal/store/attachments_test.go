@@ -0,0 +1,221 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+const testHash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+// referenceColumns are what taking a reference on an existing blob returns
+var referenceColumns = []string{"id", "content_type", "width", "height", "thumbnail_pending", "has_thumbnail"}
+
+// TestAddAttachment uploads a new image and then the same bytes again: the
+// first inserts the blob with its dimensions, the second takes a reference
+// on it and keeps the original content type and thumbnail state
+func TestAddAttachment(t *testing.T) {
+	db, mock := newMockDB(t)
+	attachments := &AttachmentStore{db, NewPools(db, nil)}
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE attachments\s+SET ref_count = ref_count \+ 1\s+WHERE hash = \$1`).
+		WithArgs(testHash).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`INSERT INTO attachments \(hash, size, content_type, width, height, thumbnail_pending\)`).
+		WithArgs(testHash, int64(4), "image/png", 640, 480, true).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectQuery(`INSERT INTO attachment_refs`).
+		WithArgs(int64(2), int64(1), "a.png").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(10, now))
+	mock.ExpectCommit()
+
+	placed := 0
+	place := func() error { placed++; return nil }
+	first := &Attachment{UserID: 1, Filename: "a.png", Hash: testHash, Size: 4, ContentType: "image/png", Width: 640, Height: 480, ThumbnailPending: true}
+	if err := attachments.Add(context.Background(), first, place); err != nil {
+		t.Fatal(err)
+	}
+	if first.ID != 10 || first.AttachmentID != 2 || first.Deduplicated {
+		t.Errorf("the first upload is %+v, want upload 10 of new blob 2", first)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE attachments\s+SET ref_count = ref_count \+ 1`).
+		WithArgs(testHash).
+		WillReturnRows(sqlmock.NewRows(referenceColumns).AddRow(2, "image/png", 640, 480, false, true))
+	mock.ExpectQuery(`INSERT INTO attachment_refs`).
+		WithArgs(int64(2), int64(3), "copy.png").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(11, now))
+	mock.ExpectCommit()
+
+	second := &Attachment{UserID: 3, Filename: "copy.png", Hash: testHash, Size: 4, ContentType: "application/octet-stream"}
+	if err := attachments.Add(context.Background(), second, place); err != nil {
+		t.Fatal(err)
+	}
+	if second.ID != 11 || second.AttachmentID != 2 || !second.Deduplicated || second.ContentType != "image/png" ||
+		second.Width != 640 || second.ThumbnailPending || !second.HasThumbnail {
+		t.Errorf("the second upload is %+v, want upload 11 sharing blob 2 and its thumbnail as image/png", second)
+	}
+	if placed != 2 {
+		t.Errorf("the file was placed %d times, want once per upload", placed)
+	}
+}
+
+// TestAddAttachmentRace loses the insert race to a concurrent upload of the
+// same new file: the unique violation rolls the attempt back, and the retry
+// references the winner's blob
+func TestAddAttachmentRace(t *testing.T) {
+	db, mock := newMockDB(t)
+	attachments := &AttachmentStore{db, NewPools(db, nil)}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE attachments`).WithArgs(testHash).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`INSERT INTO attachments`).WillReturnError(&pq.Error{Code: "23505"})
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE attachments`).WithArgs(testHash).
+		WillReturnRows(sqlmock.NewRows(referenceColumns).AddRow(5, "image/png", 0, 0, false, false))
+	mock.ExpectQuery(`INSERT INTO attachment_refs`).WithArgs(int64(5), int64(1), "cat.png").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(12, time.Now()))
+	mock.ExpectCommit()
+
+	a := &Attachment{UserID: 1, Filename: "cat.png", Hash: testHash, Size: 9, ContentType: "image/png"}
+	if err := attachments.Add(context.Background(), a, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if a.AttachmentID != 5 || !a.Deduplicated {
+		t.Errorf("the retried upload is %+v, want it to share the winner's blob 5", a)
+	}
+}
+
+// TestAddAttachmentGivesUp keeps losing the race: Add stops after
+// maxAttachmentAttempts and returns the violation
+func TestAddAttachmentGivesUp(t *testing.T) {
+	db, mock := newMockDB(t)
+	attachments := &AttachmentStore{db, NewPools(db, nil)}
+
+	for i := 0; i < maxAttachmentAttempts; i++ {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`UPDATE attachments`).WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery(`INSERT INTO attachments`).WillReturnError(&pq.Error{Code: "23505"})
+		mock.ExpectRollback()
+	}
+
+	a := &Attachment{UserID: 1, Filename: "cat.png", Hash: testHash}
+	if err := attachments.Add(context.Background(), a, func() error { return nil }); !IsUniqueViolation(err) {
+		t.Errorf("Add returned %v, want the unique violation", err)
+	}
+}
+
+// TestRemoveAttachment releases two references to the same blob: only the
+// last one deletes the row and the file, and a failed file delete rolls
+// the whole removal back
+func TestRemoveAttachment(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		refCount   int
+		cleanupErr error
+		wantClean  bool
+	}{
+		{"shared", 1, nil, false},
+		{"last", 0, nil, true},
+		{"cleanup fails", 0, errors.New("disk gone"), true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			attachments := &AttachmentStore{db, NewPools(db, nil)}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`DELETE FROM attachment_refs\s+WHERE id = \$1 AND user_id = \$2`).
+				WithArgs(int64(10), int64(1)).
+				WillReturnRows(sqlmock.NewRows([]string{"attachment_id"}).AddRow(2))
+			mock.ExpectQuery(`UPDATE attachments\s+SET ref_count = ref_count - 1`).
+				WithArgs(int64(2)).
+				WillReturnRows(sqlmock.NewRows([]string{"hash", "ref_count"}).AddRow(testHash, tc.refCount))
+			if tc.refCount == 0 {
+				mock.ExpectExec(`DELETE FROM attachments WHERE id = \$1`).
+					WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			if tc.cleanupErr == nil {
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			var cleaned []string
+			cleanup := func(hash string) error {
+				cleaned = append(cleaned, hash)
+				return tc.cleanupErr
+			}
+			err := attachments.Remove(context.Background(), 10, 1, cleanup)
+			if !errors.Is(err, tc.cleanupErr) {
+				t.Errorf("Remove returned %v, want %v", err, tc.cleanupErr)
+			}
+			if (len(cleaned) == 1 && cleaned[0] == testHash) != tc.wantClean {
+				t.Errorf("cleanup ran for %v, want it run: %v", cleaned, tc.wantClean)
+			}
+		})
+	}
+}
+
+// TestRemoveAttachmentChecksOwner removes another user's upload: it's
+// reported as missing and the blob is untouched
+func TestRemoveAttachmentChecksOwner(t *testing.T) {
+	db, mock := newMockDB(t)
+	attachments := &AttachmentStore{db, NewPools(db, nil)}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`DELETE FROM attachment_refs`).WithArgs(int64(10), int64(3)).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	cleanup := func(string) error { t.Error("cleanup ran for someone else's upload"); return nil }
+	if err := attachments.Remove(context.Background(), 10, 3, cleanup); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Remove returned %v, want sql.ErrNoRows", err)
+	}
+}
+
+// TestStorageStats reads the totals and works out the bytes saved
+func TestStorageStats(t *testing.T) {
+	db, mock := newMockDB(t)
+	attachments := &AttachmentStore{db, NewPools(db, nil)}
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\), COALESCE\(SUM\(ref_count\), 0\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "uploads", "logical", "physical"}).AddRow(2, 5, 900, 300))
+	stats, err := attachments.Stats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := StorageStats{Blobs: 2, Uploads: 5, LogicalBytes: 900, PhysicalBytes: 300, SavedBytes: 600}
+	if *stats != want {
+		t.Errorf("stats are %+v, want %+v", *stats, want)
+	}
+}
+
+// TestFinishThumbnail records a made thumbnail and returns every upload of
+// the blob with its new state, for their owners to be told
+func TestFinishThumbnail(t *testing.T) {
+	db, mock := newMockDB(t)
+	attachments := &AttachmentStore{db, NewPools(db, nil)}
+	now := time.Now()
+
+	columns := []string{"id", "attachment_id", "user_id", "filename", "hash", "size", "content_type", "created_at",
+		"width", "height", "thumbnail_pending", "has_thumbnail"}
+	mock.ExpectQuery(`UPDATE attachments\s+SET thumbnail_pending = FALSE, has_thumbnail = \$2\s+WHERE id = \$1 AND thumbnail_pending`).
+		WithArgs(int64(2), true).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(10, 2, 1, "a.png", testHash, 4, "image/png", now, 640, 480, false, true).
+			AddRow(11, 2, 3, "copy.png", testHash, 4, "image/png", now, 640, 480, false, true))
+	uploads, err := attachments.FinishThumbnail(context.Background(), 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 2 || uploads[1].UserID != 3 || !uploads[1].HasThumbnail || uploads[1].ThumbnailPending {
+		t.Errorf("got %d uploads, want both uploads of blob 2 with the thumbnail made", len(uploads))
+	}
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// Per-user outcomes of AddMembers and RemoveMembers
+const (
+	BulkAdded          = "added"
+	BulkAlreadyMember  = "already_member"
+	BulkRoomFull       = "room_full"
+	BulkQuotaExceeded  = "room_quota_exceeded"
+	BulkRemoved        = "removed"
+	BulkNotMember      = "not_member"
+	BulkAdminProtected = "admin" // Admins can't be removed in bulk
+)
+
+// AddMembers adds several users to a room in one transaction
+// Limits are enforced as in Join: users already in MaxRoomsPerUser rooms are
+// skipped, and once the room is full the remaining users are skipped, in the
+// order given. All memberships are inserted by a single statement
+// Returns each user's outcome (see the Bulk* constants)
+// Returns sql.ErrNoRows if the room doesn't exist or is deleted
+func (s *RoomMemberStore) AddMembers(ctx context.Context, roomID int64, userIDs []int64, actorID int64) (map[int64]string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results, err := addMembers(ctx, tx, s.limits, roomID, userIDs, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// addMembers is AddMembers inside the caller's transaction
+func addMembers(ctx context.Context, tx *sql.Tx, limits Limits, roomID int64, userIDs []int64, actorID int64) (map[int64]string, error) {
+	// Same lock order as addMember (users, then room), so bulk adds and single
+	// joins queue up behind each other instead of deadlocking
+	// Sorting makes two bulk adds lock overlapping users in the same order too
+	lockUsers := `SELECT id FROM users WHERE id = ANY($1) ORDER BY id FOR UPDATE`
+	if _, err := tx.ExecContext(ctx, lockUsers, pq.Array(userIDs)); err != nil {
+		return nil, err
+	}
+
+	var override *int
+	lockRoom := `SELECT max_members FROM rooms WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, lockRoom, roomID).Scan(&override); err != nil {
+		return nil, err
+	}
+
+	results := make(map[int64]string, len(userIDs))
+
+	existing, err := queryIDs(ctx, tx, `SELECT user_id FROM room_members WHERE room_id = $1 AND user_id = ANY($2)`, roomID, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range existing {
+		results[id] = BulkAlreadyMember
+	}
+
+	if limits.MaxRoomsPerUser > 0 {
+		quotaQuery := `
+			SELECT rm.user_id FROM room_members rm
+			INNER JOIN rooms r ON r.id = rm.room_id
+			WHERE rm.user_id = ANY($1) AND r.deleted_at IS NULL
+			GROUP BY rm.user_id
+			HAVING COUNT(*) >= $2
+		`
+		full, err := queryIDs(ctx, tx, quotaQuery, pq.Array(userIDs), limits.MaxRoomsPerUser)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range full {
+			if results[id] == "" {
+				results[id] = BulkQuotaExceeded
+			}
+		}
+	}
+
+	// Whoever is left gets a seat while there are seats
+	capacity := -1
+	if limit := limits.roomMemberLimit(override); limit > 0 {
+		var members int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM room_members WHERE room_id = $1`, roomID).Scan(&members); err != nil {
+			return nil, err
+		}
+		capacity = limit - members
+	}
+	candidates := make([]int64, 0, len(userIDs))
+	for _, id := range userIDs {
+		if results[id] != "" {
+			continue
+		}
+		if capacity >= 0 && len(candidates) >= capacity {
+			results[id] = BulkRoomFull
+			continue
+		}
+		candidates = append(candidates, id)
+		results[id] = BulkAlreadyMember // Overwritten below for every row actually inserted
+	}
+
+	if len(candidates) > 0 {
+		insertQuery := `
+			INSERT INTO room_members (room_id, user_id, role)
+			SELECT $1, unnest($2::bigint[]), 'member'
+			ON CONFLICT DO NOTHING
+			RETURNING user_id
+		`
+		added, err := queryIDs(ctx, tx, insertQuery, roomID, pq.Array(candidates))
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range added {
+			results[id] = BulkAdded
+		}
+
+		if err := recordMembershipEvents(ctx, tx, roomID, added, MembershipJoined, actorID); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// RemoveMembers removes several users from a room in one transaction
+// Admins are left alone, so a bulk removal can't leave a room without anyone to manage it
+// Each removal is recorded as "kicked" by actorID
+// Returns each user's outcome (see the Bulk* constants)
+func (s *RoomMemberStore) RemoveMembers(ctx context.Context, roomID int64, userIDs []int64, actorID int64) (map[int64]string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	deleteQuery := `
+		DELETE FROM room_members
+		WHERE room_id = $1 AND user_id = ANY($2) AND role <> 'admin'
+		RETURNING user_id
+	`
+	removed, err := queryIDs(ctx, tx, deleteQuery, roomID, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	// Anyone still a member now must be an admin
+	admins, err := queryIDs(ctx, tx, `SELECT user_id FROM room_members WHERE room_id = $1 AND user_id = ANY($2)`, roomID, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordMembershipEvents(ctx, tx, roomID, removed, MembershipKicked, actorID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	results := make(map[int64]string, len(userIDs))
+	for _, id := range userIDs {
+		results[id] = BulkNotMember
+	}
+	for _, id := range removed {
+		results[id] = BulkRemoved
+	}
+	for _, id := range admins {
+		results[id] = BulkAdminProtected
+	}
+	return results, nil
+}
+
+// queryIDs runs a query returning a single column of IDs
+func queryIDs(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
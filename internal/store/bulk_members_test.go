@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// TestAddMembers adds five users to a room with two seats left: one is
+// already in, one is in too many rooms, the last doesn't fit, and one of
+// the two inserted loses a race to a concurrent join
+// Every membership goes in with one INSERT, and the mock fails the test on
+// any statement it doesn't expect, so a per-user insert would be caught
+func TestAddMembers(t *testing.T) {
+	db, mock := newMockDB(t)
+	members := &RoomMemberStore{db, Limits{MaxRoomMembers: 3, MaxRoomsPerUser: 2}}
+	userIDs := []int64{2, 3, 4, 5, 6}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT id FROM users WHERE id = ANY\(\$1\) ORDER BY id FOR UPDATE`).
+		WithArgs(pq.Array(userIDs)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT max_members FROM rooms WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+		WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"max_members"}).AddRow(nil))
+	mock.ExpectQuery(`SELECT user_id FROM room_members WHERE room_id = \$1 AND user_id = ANY\(\$2\)`).
+		WithArgs(int64(1), pq.Array(userIDs)).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(3))
+	mock.ExpectQuery(`HAVING COUNT\(\*\) >= \$2`).
+		WithArgs(pq.Array(userIDs), 2).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(4))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM room_members WHERE room_id = \$1`).
+		WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO room_members \(room_id, user_id, role\)\s+SELECT \$1, unnest\(\$2::bigint\[\]\), 'member'\s+ON CONFLICT DO NOTHING`).
+		WithArgs(int64(1), pq.Array([]int64{2, 5})).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(2))
+	expectMembershipEvents(mock, 1, []int64{2}, MembershipJoined, 1)
+	mock.ExpectCommit()
+
+	got, err := members.AddMembers(context.Background(), 1, userIDs, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64]string{2: BulkAdded, 3: BulkAlreadyMember, 4: BulkQuotaExceeded, 5: BulkAlreadyMember, 6: BulkRoomFull}
+	if !maps.Equal(got, want) {
+		t.Errorf("the outcomes are %v, want %v", got, want)
+	}
+}
+
+// TestAddMembersNothingToInsert skips the insert and the history when
+// nobody is left to add
+func TestAddMembersNothingToInsert(t *testing.T) {
+	db, mock := newMockDB(t)
+	members := &RoomMemberStore{db, Limits{}}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`FOR UPDATE`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT max_members FROM rooms`).WillReturnRows(sqlmock.NewRows([]string{"max_members"}).AddRow(nil))
+	mock.ExpectQuery(`SELECT user_id FROM room_members`).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(3))
+	mock.ExpectCommit()
+
+	got, err := members.AddMembers(context.Background(), 1, []int64{3}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[3] != BulkAlreadyMember {
+		t.Errorf("the outcomes are %v, want user 3 already a member", got)
+	}
+}
+
+// TestRemoveMembers removes three users, one of them an admin and one not
+// in the room: only the removal is recorded in the history
+func TestRemoveMembers(t *testing.T) {
+	db, mock := newMockDB(t)
+	members := &RoomMemberStore{db, Limits{}}
+	userIDs := []int64{1, 3, 7}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`DELETE FROM room_members\s+WHERE room_id = \$1 AND user_id = ANY\(\$2\) AND role <> 'admin'`).
+		WithArgs(int64(1), pq.Array(userIDs)).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(3))
+	mock.ExpectQuery(`SELECT user_id FROM room_members WHERE room_id = \$1 AND user_id = ANY\(\$2\)`).
+		WithArgs(int64(1), pq.Array(userIDs)).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	expectMembershipEvents(mock, 1, []int64{3}, MembershipKicked, 1)
+	mock.ExpectCommit()
+
+	got, err := members.RemoveMembers(context.Background(), 1, userIDs, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64]string{1: BulkAdminProtected, 3: BulkRemoved, 7: BulkNotMember}
+	if !maps.Equal(got, want) {
+		t.Errorf("the outcomes are %v, want %v", got, want)
+	}
+}
+
+// TestGetByUsernames resolves names and IDs with a single query that leaves
+// out the system user
+func TestGetByUsernames(t *testing.T) {
+	db, mock := newMockDB(t)
+	users := &UserStore{db, Limits{}, NewPools(db, nil)}
+	now := time.Now()
+
+	mock.ExpectQuery(`FROM users\s+WHERE \(username = ANY\(\$1\) OR id = ANY\(\$2\)\) AND id > 0`).
+		WithArgs(pq.Array([]string{"ada", "nobody"}), pq.Array([]int64{2})).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "display_name", "email", "discoverable", "version", "created_at", "updated_at"}).
+			AddRow(1, "ada", "Ada", "ada@example.com", true, 1, now, now).
+			AddRow(2, "grace", "", "grace@example.com", false, 1, now, now))
+
+	got, err := users.GetByUsernames(context.Background(), []string{"ada", "nobody"}, []int64{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Username != "ada" || got[1].ID != 2 || got[0].Password != "" {
+		t.Errorf("got %+v, want ada and grace without passwords", got)
+	}
+}
@@ -0,0 +1,78 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestDefaultRoomJoinIsQuiet registers an account on the scratch database
+// with a default room: the join is logged as a system action whose room
+// event payload says it's quiet, and a plain join's payload doesn't
+func TestDefaultRoomJoinIsQuiet(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	limits := Limits{MaxRoomMembers: 100, MaxRoomsPerUser: 100}
+	rooms := &RoomStore{db, limits, NewPools(db, nil)}
+	users := &UserStore{db, limits, NewPools(db, nil)}
+	suffix := time.Now().UnixNano()
+
+	var owner int64
+	query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+	if err := db.QueryRowContext(ctx, query, fmt.Sprintf("owner-%d", suffix)).Scan(&owner); err != nil {
+		t.Fatal(err)
+	}
+	room := &Room{Name: fmt.Sprintf("welcome-%d", suffix), CreatedBy: owner}
+	if err := rooms.Create(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+	user := &User{Username: fmt.Sprintf("newcomer-%d", suffix), Email: fmt.Sprintf("newcomer-%d@example.invalid", suffix), Password: "!"}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM rooms WHERE id = $1`, room.ID)
+		db.Exec(`DELETE FROM users WHERE id = ANY(ARRAY[$1, $2]::bigint[])`, owner, user.ID)
+	})
+	if err := rooms.SetDefault(ctx, room.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	// Only this test's room may be a default, or the account joins others too
+	t.Cleanup(func() { db.Exec(`UPDATE rooms SET is_default = false WHERE id = $1`, room.ID) })
+
+	joined, _, err := users.CreateWithDefaultRooms(ctx, user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, r := range joined {
+		found = found || r.ID == room.ID && r.IsDefault
+	}
+	if !found {
+		t.Fatalf("the new account joined %+v, want the default room among them", joined)
+	}
+	if err := (&RoomMemberStore{db, limits}).Join(ctx, room.ID, owner, owner); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		userID int64
+		quiet  bool
+	}{{user.ID, true}, {owner, false}} {
+		var quiet bool
+		var actor *int64
+		query := `SELECT COALESCE((payload->>'quiet')::boolean, false), (payload->>'actor_id')::bigint FROM room_events
+			WHERE room_id = $1 AND event_type = 'member_joined' AND (payload->>'user_id')::bigint = $2`
+		if err := db.QueryRowContext(ctx, query, room.ID, tc.userID).Scan(&quiet, &actor); err != nil {
+			t.Fatal(err)
+		}
+		if quiet != tc.quiet {
+			t.Errorf("user %d's join is quiet %v, want %v", tc.userID, quiet, tc.quiet)
+		}
+		if tc.quiet && actor != nil {
+			t.Errorf("the default room join has actor %d, want the system", *actor)
+		}
+	}
+}
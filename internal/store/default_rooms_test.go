@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// expectDefaultJoin expects addMember's checks for user 5 joining roomID,
+// which already has members
+func expectDefaultJoin(mock sqlmock.Sqlmock, roomID int64, members int) {
+	mock.ExpectExec(`SELECT id FROM users WHERE id = \$1 FOR UPDATE`).WithArgs(int64(5)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT max_members FROM rooms`).WithArgs(roomID).WillReturnRows(sqlmock.NewRows([]string{"max_members"}).AddRow(nil))
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM room_members`).WithArgs(roomID, int64(5)).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM room_members rm`).WithArgs(int64(5)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM room_members WHERE room_id = \$1`).WithArgs(roomID).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(members))
+}
+
+// expectCreateUser expects the new account's insert and the default rooms lookup
+func expectCreateUser(mock sqlmock.Sqlmock, defaultIDs ...int64) {
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO users`).WithArgs("erin", "erin@example.com", "hash").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "display_name", "discoverable", "version", "created_at", "updated_at"}).
+			AddRow(5, "", true, 1, now, now))
+	rows := sqlmock.NewRows([]string{"id"})
+	for _, id := range defaultIDs {
+		rows.AddRow(id)
+	}
+	mock.ExpectQuery(`SELECT id FROM rooms WHERE is_default AND deleted_at IS NULL ORDER BY id`).WillReturnRows(rows)
+}
+
+// TestCreateWithDefaultRooms registers erin with two default rooms, the
+// first of them full: the account joins the other as a quiet system join,
+// all in the transaction that creates it
+func TestCreateWithDefaultRooms(t *testing.T) {
+	db, mock := newMockDB(t)
+	users := &UserStore{db, Limits{MaxRoomMembers: 3, MaxRoomsPerUser: 10}, NewPools(db, nil)}
+	now := time.Now()
+
+	expectCreateUser(mock, 1, 2)
+	expectDefaultJoin(mock, 1, 3)
+	expectDefaultJoin(mock, 2, 1)
+	mock.ExpectExec(`INSERT INTO room_members`).WithArgs(int64(2), int64(5), RoomRoleMember).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO room_membership_events`).WithArgs(int64(2), int64(5), MembershipJoined, int64(0)).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectMembershipLogged(mock, 2, []int64{5}, MembershipJoined, 0, true)
+	mock.ExpectQuery(`FROM rooms r\s+WHERE r.id = ANY\(\$1\)`).WithArgs(pq.Array([]int64{2})).
+		WillReturnRows(sqlmock.NewRows(roomRowColumns).AddRow(2, "welcome", "", 1, now, now, 1, false, nil, "open", nil, 2, true, false, nil, "{}", true, "", nil, "everyone"))
+	mock.ExpectCommit()
+
+	user := &User{Username: "erin", Email: "erin@example.com", Password: "hash"}
+	rooms, invites, err := users.CreateWithDefaultRooms(context.Background(), user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != 5 || len(rooms) != 1 || rooms[0].ID != 2 || rooms[0].MaxMembers != 3 {
+		t.Errorf("got user %d with rooms %+v, want user 5 in room 2 alone", user.ID, rooms)
+	}
+	if invites != nil {
+		t.Errorf("registering without an invite token accepted %+v", invites)
+	}
+}
+
+// TestCreateWithDefaultRoomsRollsBack fails a default room join: the
+// account's insert is rolled back with it, so no account exists half onboarded
+func TestCreateWithDefaultRoomsRollsBack(t *testing.T) {
+	db, mock := newMockDB(t)
+	users := &UserStore{db, Limits{}, NewPools(db, nil)}
+	broken := errors.New("connection reset")
+
+	expectCreateUser(mock, 1)
+	mock.ExpectExec(`SELECT id FROM users WHERE id = \$1 FOR UPDATE`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT max_members FROM rooms`).WillReturnRows(sqlmock.NewRows([]string{"max_members"}).AddRow(nil))
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM room_members`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(`INSERT INTO room_members`).WillReturnError(broken)
+	mock.ExpectRollback()
+
+	user := &User{Username: "erin", Email: "erin@example.com", Password: "hash"}
+	if _, _, err := users.CreateWithDefaultRooms(context.Background(), user, ""); !errors.Is(err, broken) {
+		t.Errorf("got %v, want the join's error", err)
+	}
+}
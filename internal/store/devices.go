@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Device represents one client installation (browser, phone app) of a user
+// Devices let each client keep its own read position in every room
+type Device struct {
+	ID           int64     `json:"id"`
+	UserID       int64     `json:"user_id"`
+	Name         string    `json:"name"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastActiveAt time.Time `json:"last_active_at"`
+}
+
+// DeviceStore handles database operations for devices
+type DeviceStore struct {
+	db *sql.DB
+}
+
+// Create registers a new device for a user
+func (s *DeviceStore) Create(ctx context.Context, device *Device) error {
+	query := `
+		INSERT INTO devices (user_id, name)
+		VALUES ($1, $2) RETURNING id, created_at, last_active_at
+	`
+
+	return s.db.QueryRowContext(ctx, query, device.UserID, device.Name).Scan(
+		&device.ID,
+		&device.CreatedAt,
+		&device.LastActiveAt,
+	)
+}
+
+// Touch records activity on a device
+// The user ID is part of the WHERE clause so a client can't use another user's device
+// Returns sql.ErrNoRows if the device doesn't exist or belongs to someone else
+func (s *DeviceStore) Touch(ctx context.Context, deviceID, userID int64) error {
+	query := `
+		UPDATE devices
+		SET last_active_at = NOW()
+		WHERE id = $1 AND user_id = $2
+	`
+
+	result, err := s.db.ExecContext(ctx, query, deviceID, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// PruneInactive deletes devices with no activity since the cutoff
+// Their read markers are removed in the same transaction since read_markers.device_id
+// isn't a foreign key (device 0 stands for "unregistered client")
+// Returns the number of devices removed
+func (s *DeviceStore) PruneInactive(ctx context.Context, cutoff time.Time) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	markersQuery := `
+		DELETE FROM read_markers
+		WHERE device_id IN (SELECT id FROM devices WHERE last_active_at < $1)
+	`
+	if _, err := tx.ExecContext(ctx, markersQuery, cutoff); err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM devices WHERE last_active_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, tx.Commit()
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestTouchChecksOwner touches a device: another user's device is reported
+// as missing rather than touched
+func TestTouchChecksOwner(t *testing.T) {
+	db, mock := newMockDB(t)
+	devices := &DeviceStore{db}
+
+	mock.ExpectExec(`UPDATE devices\s+SET last_active_at = NOW\(\)\s+WHERE id = \$1 AND user_id = \$2`).
+		WithArgs(int64(7), int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE devices`).WithArgs(int64(7), int64(2)).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := devices.Touch(context.Background(), 7, 1); err != nil {
+		t.Errorf("the owner's touch failed: %v", err)
+	}
+	if err := devices.Touch(context.Background(), 7, 2); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("another user's touch returned %v, want sql.ErrNoRows", err)
+	}
+}
+
+// TestPruneInactive prunes stale devices: their read markers go in the same
+// transaction, and a failure removes neither
+func TestPruneInactive(t *testing.T) {
+	db, mock := newMockDB(t)
+	devices := &DeviceStore{db}
+	cutoff := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM read_markers\s+WHERE device_id IN`).WithArgs(cutoff).
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectExec(`DELETE FROM devices WHERE last_active_at < \$1`).WithArgs(cutoff).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	removed, err := devices.PruneInactive(context.Background(), cutoff)
+	if err != nil || removed != 2 {
+		t.Errorf("pruning removed %d devices (%v), want 2", removed, err)
+	}
+
+	locked := errors.New("devices is locked")
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM read_markers`).WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectExec(`DELETE FROM devices`).WillReturnError(locked)
+	mock.ExpectRollback()
+
+	if _, err := devices.PruneInactive(context.Background(), cutoff); !errors.Is(err, locked) {
+		t.Errorf("a failed prune returned %v, want %v", err, locked)
+	}
+}
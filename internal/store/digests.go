@@ -0,0 +1,222 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Outcomes of a digest run
+const (
+	DigestSent    = "sent"
+	DigestSkipped = "skipped" // Nothing new to report, so no email
+	DigestFailed  = "failed"
+)
+
+// digestDateFormat is how a digest day is passed to the DATE column
+const digestDateFormat = "2006-01-02"
+
+// DigestSettings are a user's daily digest email preferences
+type DigestSettings struct {
+	Enabled  bool   `json:"enabled"`
+	Hour     int    `json:"hour"`     // Local hour (0-23) after which the day's digest is sent
+	Timezone string `json:"timezone"` // IANA name, e.g. "Europe/Berlin"
+}
+
+// DigestRoom is a room with unread activity for a digest
+type DigestRoom struct {
+	RoomID   int64
+	Name     string
+	Unread   int // Unread messages from others since the last digest
+	Mentions int // How many of them mention the user
+}
+
+// DigestRecipient is a user to send a digest to, with their unread activity
+type DigestRecipient struct {
+	UserID   int64
+	Username string
+	Email    string
+	Since    time.Time // The last digest, or the fallback given to LoadRecipients
+	Rooms    []*DigestRoom
+}
+
+// DigestStore handles digest settings and the runs that make sure each
+// digest is sent once
+type DigestStore struct {
+	db *sql.DB
+}
+
+// GetSettings returns a user's digest settings
+func (s *DigestStore) GetSettings(ctx context.Context, userID int64) (*DigestSettings, error) {
+	query := `SELECT digest_enabled, digest_hour, digest_timezone FROM users WHERE id = $1`
+
+	settings := &DigestSettings{}
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&settings.Enabled, &settings.Hour, &settings.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// UpdateSettings saves a user's digest settings
+// The timezone must already be validated; the database doesn't check it
+func (s *DigestStore) UpdateSettings(ctx context.Context, userID int64, settings *DigestSettings) error {
+	query := `
+		UPDATE users
+		SET digest_enabled = $1, digest_hour = $2, digest_timezone = $3, updated_at = NOW()
+		WHERE id = $4
+	`
+	return expectOneRow(s.db.ExecContext(ctx, query, settings.Enabled, settings.Hour, settings.Timezone, userID))
+}
+
+// Timezones returns every timezone at least one opted-in user has chosen
+// The scheduler works through them one bucket at a time, since "today" and
+// "the digest hour" depend on the timezone
+func (s *DigestStore) Timezones(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT digest_timezone FROM users WHERE digest_enabled`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	zones := make([]string, 0)
+	for rows.Next() {
+		var zone string
+		if err := rows.Scan(&zone); err != nil {
+			return nil, err
+		}
+		zones = append(zones, zone)
+	}
+	return zones, rows.Err()
+}
+
+// ClaimDue claims up to limit users in a timezone whose digest for day is due
+// (their hour has come, given it's hour o'clock there) and not yet claimed
+// Claiming inserts the digest_runs row; when schedulers on several instances
+// claim at once, the primary key lets exactly one of them have each user
+// day is the local date in the timezone; only its year, month and day are used
+// Returns the claimed user IDs
+func (s *DigestStore) ClaimDue(ctx context.Context, timezone string, day time.Time, hour, limit int) ([]int64, error) {
+	query := `
+		INSERT INTO digest_runs (user_id, digest_date)
+		SELECT u.id, $2::date
+		FROM users u
+		WHERE u.digest_enabled AND u.digest_timezone = $1 AND u.digest_hour <= $3
+		  AND NOT EXISTS (SELECT 1 FROM digest_runs d WHERE d.user_id = u.id AND d.digest_date = $2::date)
+		ORDER BY u.id
+		LIMIT $4
+		ON CONFLICT DO NOTHING
+		RETURNING user_id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, timezone, day.Format(digestDateFormat), hour, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	claimed := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, id)
+	}
+	return claimed, rows.Err()
+}
+
+// LoadRecipients loads claimed users with their unread activity, in two
+// queries however many users and rooms there are
+// Activity counts messages from others since the user's last digest (or since
+// fallbackSince for a first digest) that are past the user's read position
+// A mention is a message containing "@username" (see mentionMatch) or an
+// allowed @room
+func (s *DigestStore) LoadRecipients(ctx context.Context, userIDs []int64, fallbackSince time.Time) ([]*DigestRecipient, error) {
+	// Shared by both queries so they agree on each user's start time
+	recipientsCTE := `
+		WITH recipients AS (
+			SELECT u.id AS user_id, u.username, u.email,
+			       COALESCE((
+			           SELECT MAX(d.finished_at) FROM digest_runs d
+			           WHERE d.user_id = u.id AND d.status IN ('sent', 'skipped')
+			       ), $2) AS since
+			FROM users u
+			WHERE u.id = ANY($1)
+		)
+	`
+
+	rows, err := s.db.QueryContext(ctx, recipientsCTE+`SELECT user_id, username, email, since FROM recipients`,
+		pq.Array(userIDs), fallbackSince)
+	if err != nil {
+		return nil, err
+	}
+	recipients := make([]*DigestRecipient, 0, len(userIDs))
+	byID := make(map[int64]*DigestRecipient, len(userIDs))
+	for rows.Next() {
+		recipient := &DigestRecipient{Rooms: make([]*DigestRoom, 0)}
+		if err := rows.Scan(&recipient.UserID, &recipient.Username, &recipient.Email, &recipient.Since); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+		byID[recipient.UserID] = recipient
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	activityQuery := recipientsCTE + `
+		SELECT s.user_id, r.id, r.name, COUNT(*),
+		       COUNT(*) FILTER (WHERE ` + mentionMatch("m.content", "s.username") + ` OR m.room_mention = 'room')
+		FROM recipients s
+		INNER JOIN room_members rm ON rm.user_id = s.user_id
+		INNER JOIN rooms r ON r.id = rm.room_id AND r.deleted_at IS NULL
+		INNER JOIN messages m ON m.room_id = rm.room_id
+		WHERE m.user_id IS DISTINCT FROM s.user_id
+		  AND m.created_at > s.since
+		  AND m.id > COALESCE((
+		      SELECT MAX(rk.last_read_message_id) FROM read_markers rk
+		      WHERE rk.user_id = s.user_id AND rk.room_id = rm.room_id
+		  ), 0)
+		GROUP BY s.user_id, r.id, r.name
+	`
+	rows, err = s.db.QueryContext(ctx, activityQuery, pq.Array(userIDs), fallbackSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID int64
+		room := &DigestRoom{}
+		if err := rows.Scan(&userID, &room.RoomID, &room.Name, &room.Unread, &room.Mentions); err != nil {
+			return nil, err
+		}
+		if recipient, ok := byID[userID]; ok {
+			recipient.Rooms = append(recipient.Rooms, room)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return recipients, nil
+}
+
+// Finish records how a claimed digest run ended
+// errText is stored for failed runs and ignored otherwise
+func (s *DigestStore) Finish(ctx context.Context, userID int64, day time.Time, status, errText string) error {
+	query := `
+		UPDATE digest_runs
+		SET status = $3, finished_at = NOW(), error = NULLIF($4, '')
+		WHERE user_id = $1 AND digest_date = $2::date
+	`
+	if status != DigestFailed {
+		errText = ""
+	}
+	return expectOneRow(s.db.ExecContext(ctx, query, userID, day.Format(digestDateFormat), status, errText))
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// TestClaimDue claims by inserting digest_runs rows for the local date,
+// leaving users someone else already claimed to ON CONFLICT
+func TestClaimDue(t *testing.T) {
+	db, mock := newMockDB(t)
+	digests := &DigestStore{db}
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	day := time.Date(2026, 3, 5, 23, 30, 0, 0, time.UTC).In(tokyo)
+
+	mock.ExpectQuery(`INSERT INTO digest_runs \(user_id, digest_date\)\s+SELECT u.id, \$2::date.+ON CONFLICT DO NOTHING\s+RETURNING user_id`).
+		WithArgs("Asia/Tokyo", "2026-03-06", 8, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(4).AddRow(9))
+
+	claimed, err := digests.ClaimDue(context.Background(), "Asia/Tokyo", day, 8, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 2 || claimed[0] != 4 || claimed[1] != 9 {
+		t.Errorf("claimed %v, want 4 and 9", claimed)
+	}
+}
+
+// TestLoadRecipients loads any number of users and rooms in two queries,
+// and gives each user their own rooms
+func TestLoadRecipients(t *testing.T) {
+	db, mock := newMockDB(t)
+	digests := &DigestStore{db}
+	userIDs := []int64{1, 2, 3}
+	since := time.Date(2026, 3, 5, 8, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`WITH recipients AS .+SELECT user_id, username, email, since FROM recipients`).
+		WithArgs(pq.Array(userIDs), since).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "username", "email", "since"}).
+			AddRow(1, "ada", "ada@example.com", since).
+			AddRow(2, "grace", "grace@example.com", since).
+			AddRow(3, "linus", "linus@example.com", since))
+	mock.ExpectQuery(`WITH recipients AS .+GROUP BY s.user_id, r.id, r.name`).
+		WithArgs(pq.Array(userIDs), since).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "id", "name", "count", "mentions"}).
+			AddRow(1, 10, "lobby", 4, 1).
+			AddRow(2, 10, "lobby", 3, 0).
+			AddRow(1, 11, "books", 2, 0))
+
+	recipients, err := digests.LoadRecipients(context.Background(), userIDs, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recipients) != 3 || len(recipients[0].Rooms) != 2 || len(recipients[1].Rooms) != 1 || len(recipients[2].Rooms) != 0 {
+		t.Fatalf("got %+v", recipients)
+	}
+	if lobby := recipients[0].Rooms[0]; lobby.Name != "lobby" || lobby.Unread != 4 || lobby.Mentions != 1 {
+		t.Errorf("ada's lobby is %+v", lobby)
+	}
+}
+
+// TestFinish keeps the error text of failed runs only
+func TestFinish(t *testing.T) {
+	db, mock := newMockDB(t)
+	digests := &DigestStore{db}
+	day := time.Date(2026, 3, 6, 8, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		status, errText, stored string
+	}{
+		{DigestFailed, "mail server unavailable", "mail server unavailable"},
+		{DigestSent, "leftover", ""},
+	} {
+		mock.ExpectExec(`UPDATE digest_runs\s+SET status = \$3, finished_at = NOW\(\), error = NULLIF\(\$4, ''\)`).
+			WithArgs(int64(1), "2026-03-06", tc.status, tc.stored).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		if err := digests.Finish(context.Background(), 1, day, tc.status, tc.errText); err != nil {
+			t.Errorf("%s: %v", tc.status, err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Outcomes of an email invite once its address registers
+// A full room or a user at the rooms limit gets BulkRoomFull / BulkQuotaExceeded
+const (
+	EmailInviteJoined    = "joined"    // The new account is a member of the room
+	EmailInviteRequested = "requested" // The room needs approval; a join request is waiting
+)
+
+// EmailInvite is an invite to a room sent to an address with no account yet
+type EmailInvite struct {
+	ID        int64     `json:"id"`
+	RoomID    int64     `json:"room_id"`
+	Email     string    `json:"email"`
+	InvitedBy *int64    `json:"invited_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// EmailInviteOutcome is what became of one email invite at registration
+type EmailInviteOutcome struct {
+	RoomID    int64  `json:"room_id"`
+	RoomName  string `json:"room_name"`
+	InvitedBy *int64 `json:"invited_by"`
+	Status    string `json:"status"` // EmailInviteJoined, EmailInviteRequested, BulkRoomFull or BulkQuotaExceeded
+}
+
+// EmailInviteStore handles database operations for email invites
+// Invites are accepted by UserStore.CreateWithDefaultRooms, inside the registration
+type EmailInviteStore struct {
+	db *sql.DB
+}
+
+// NormalizeEmail is the form emails are stored and matched in: trimmed and lowercased
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Create saves an invite of a room to an address, valid for ttl
+// Inviting the same address to the same room again replaces the open invite's
+// token, inviter and expiry, so only the newest email works. The same address
+// can hold invites to any number of rooms
+func (s *EmailInviteStore) Create(ctx context.Context, invite *EmailInvite, tokenHash string, ttl time.Duration) error {
+	query := `
+		INSERT INTO pending_email_invites (room_id, email, token_hash, invited_by, expires_at)
+		VALUES ($1, $2, $3, NULLIF($4, 0), NOW() + ($5 * INTERVAL '1 second'))
+		ON CONFLICT (room_id, email) WHERE accepted_at IS NULL DO UPDATE
+		SET token_hash = EXCLUDED.token_hash, invited_by = EXCLUDED.invited_by,
+			created_at = NOW(), expires_at = EXCLUDED.expires_at
+		RETURNING id, invited_by, created_at, expires_at
+	`
+	var invitedBy int64
+	if invite.InvitedBy != nil {
+		invitedBy = *invite.InvitedBy
+	}
+	invite.Email = NormalizeEmail(invite.Email)
+	return s.db.QueryRowContext(ctx, query, invite.RoomID, invite.Email, tokenHash, invitedBy, ttl.Seconds()).
+		Scan(&invite.ID, &invite.InvitedBy, &invite.CreatedAt, &invite.ExpiresAt)
+}
+
+// acceptEmailInvites turns the open invites of a new account's address into
+// memberships, inside the registration's transaction
+// tokenHash must belong to a live invite for the same address: it proves the
+// account owns the address, since emails aren't otherwise verified. Without
+// that nothing is accepted and no outcomes are returned
+// Rooms with the approval join policy get a pending join request instead of a
+// membership. Every invite of the address is used up, whatever its outcome
+func acceptEmailInvites(ctx context.Context, tx *sql.Tx, limits Limits, user *User, tokenHash string) ([]*EmailInviteOutcome, error) {
+	email := NormalizeEmail(user.Email)
+
+	var valid bool
+	tokenQuery := `
+		SELECT EXISTS(
+			SELECT 1 FROM pending_email_invites
+			WHERE token_hash = $1 AND email = $2 AND accepted_at IS NULL AND expires_at > NOW()
+		)
+	`
+	if err := tx.QueryRowContext(ctx, tokenQuery, tokenHash, email).Scan(&valid); err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, nil
+	}
+
+	invitesQuery := `
+		SELECT i.id, i.room_id, r.name, r.join_policy, i.invited_by
+		FROM pending_email_invites i
+		INNER JOIN rooms r ON r.id = i.room_id
+		WHERE i.email = $1 AND i.accepted_at IS NULL AND i.expires_at > NOW() AND r.deleted_at IS NULL
+		ORDER BY i.id
+		FOR UPDATE OF i
+	`
+	rows, err := tx.QueryContext(ctx, invitesQuery, email)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	var policies []string
+	outcomes := make([]*EmailInviteOutcome, 0)
+	for rows.Next() {
+		var id int64
+		var policy string
+		outcome := &EmailInviteOutcome{}
+		if err := rows.Scan(&id, &outcome.RoomID, &outcome.RoomName, &policy, &outcome.InvitedBy); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		policies = append(policies, policy)
+		outcomes = append(outcomes, outcome)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, outcome := range outcomes {
+		// A default room the account already joined counts as joined
+		var isMember bool
+		memberQuery := `SELECT EXISTS(SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)`
+		if err := tx.QueryRowContext(ctx, memberQuery, outcome.RoomID, user.ID).Scan(&isMember); err != nil {
+			return nil, err
+		}
+		if isMember {
+			outcome.Status = EmailInviteJoined
+			continue
+		}
+
+		if policies[i] == JoinPolicyApproval {
+			requestQuery := `INSERT INTO join_requests (room_id, user_id) VALUES ($1, $2) ON CONFLICT (room_id, user_id) DO NOTHING`
+			if _, err := tx.ExecContext(ctx, requestQuery, outcome.RoomID, user.ID); err != nil {
+				return nil, err
+			}
+			outcome.Status = EmailInviteRequested
+			continue
+		}
+
+		var actorID int64
+		if outcome.InvitedBy != nil {
+			actorID = *outcome.InvitedBy
+		}
+		err := addMember(ctx, tx, limits, outcome.RoomID, user.ID, JoinOptions{ActorID: actorID})
+		switch {
+		case errors.Is(err, ErrRoomFull):
+			outcome.Status = BulkRoomFull
+		case errors.Is(err, ErrTooManyRooms):
+			outcome.Status = BulkQuotaExceeded
+		case err != nil:
+			return nil, err
+		default:
+			outcome.Status = EmailInviteJoined
+		}
+	}
+
+	acceptQuery := `UPDATE pending_email_invites SET accepted_at = NOW(), accepted_by = $2 WHERE id = ANY($1)`
+	if _, err := tx.ExecContext(ctx, acceptQuery, pq.Array(ids), user.ID); err != nil {
+		return nil, err
+	}
+
+	return outcomes, nil
+}
@@ -0,0 +1,111 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestAcceptEmailInvites invites an address to an open room, an approval
+// room and a third room whose invite has expired, on the scratch database
+// The expired token accepts nothing; registering with a live one joins the
+// open room, asks to join the approval room, skips the expired invite and
+// uses the others up, so the token can't be used twice
+func TestAcceptEmailInvites(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	limits := Limits{MaxRoomMembers: 100, MaxRoomsPerUser: 100}
+	rooms := &RoomStore{db, limits, NewPools(db, nil)}
+	users := &UserStore{db, limits, NewPools(db, nil)}
+	invites := &EmailInviteStore{db}
+	suffix := time.Now().UnixNano()
+	email := fmt.Sprintf("invitee-%d@example.invalid", suffix)
+
+	var owner int64
+	query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+	if err := db.QueryRowContext(ctx, query, fmt.Sprintf("owner-%d", suffix)).Scan(&owner); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM rooms WHERE created_by = $1`, owner)
+		db.Exec(`DELETE FROM users WHERE id = $1 OR email = $2`, owner, email)
+	})
+
+	hashes := make(map[int64]string)
+	created := make([]*Room, 0, 3)
+	for i, policy := range []string{JoinPolicyOpen, JoinPolicyApproval, JoinPolicyOpen} {
+		room := &Room{Name: fmt.Sprintf("room-%d-%d", i, suffix), CreatedBy: owner, JoinPolicy: policy}
+		if err := rooms.Create(ctx, room); err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, room)
+		hashes[room.ID] = fmt.Sprintf("%064x", suffix+room.ID)
+		// Addresses are stored and matched normalized
+		invite := &EmailInvite{RoomID: room.ID, Email: " " + strings.ToUpper(email), InvitedBy: &owner}
+		if err := invites.Create(ctx, invite, hashes[room.ID], 14*24*time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if invite.Email != email {
+			t.Fatalf("the invite was saved for %q, want %q", invite.Email, email)
+		}
+	}
+	open, approval, expired := created[0], created[1], created[2]
+	if _, err := db.ExecContext(ctx, `UPDATE pending_email_invites SET expires_at = NOW() - INTERVAL '1 day' WHERE room_id = $1`, expired.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// accept runs acceptEmailInvites for email in a transaction it rolls back
+	accept := func(tokenHash string) []*EmailInviteOutcome {
+		t.Helper()
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		outcomes, err := acceptEmailInvites(ctx, tx, limits, &User{ID: owner, Email: email}, tokenHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return outcomes
+	}
+	if outcomes := accept(hashes[expired.ID]); outcomes != nil {
+		t.Errorf("the expired token accepted %+v", outcomes)
+	}
+
+	user := &User{Username: fmt.Sprintf("invitee-%d", suffix), Email: email, Password: "!"}
+	joined, outcomes, err := users.CreateWithDefaultRooms(ctx, user, hashes[open.ID])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64]string{open.ID: EmailInviteJoined, approval.ID: EmailInviteRequested}
+	if len(outcomes) != len(want) {
+		t.Fatalf("got %d outcomes, want %d: %+v", len(outcomes), len(want), outcomes)
+	}
+	for _, outcome := range outcomes {
+		if outcome.Status != want[outcome.RoomID] || *outcome.InvitedBy != owner {
+			t.Errorf("the invite to room %d is %+v, want %q", outcome.RoomID, outcome, want[outcome.RoomID])
+		}
+	}
+	var inOpen bool
+	for _, room := range joined {
+		inOpen = inOpen || room.ID == open.ID
+	}
+	if !inOpen {
+		t.Errorf("the account joined %+v, want the open room among them", joined)
+	}
+
+	var requested bool
+	requestQuery := `SELECT EXISTS(SELECT 1 FROM join_requests WHERE room_id = $1 AND user_id = $2 AND status = 'pending')`
+	if err := db.QueryRowContext(ctx, requestQuery, approval.ID, user.ID).Scan(&requested); err != nil || !requested {
+		t.Errorf("no pending join request for the approval room (%v)", err)
+	}
+	if outcomes := accept(hashes[approval.ID]); outcomes != nil {
+		t.Errorf("a used invite's token accepted %+v", outcomes)
+	}
+}
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// TestGetByEmails looks addresses up normalized, in one query that leaves
+// out the system user
+func TestGetByEmails(t *testing.T) {
+	db, mock := newMockDB(t)
+	users := &UserStore{db, Limits{}, NewPools(db, nil)}
+	now := time.Now()
+
+	mock.ExpectQuery(`FROM users\s+WHERE LOWER\(email\) = ANY\(\$1\) AND id > 0`).
+		WithArgs(pq.Array([]string{"ada@example.com", "nobody@example.com"})).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "display_name", "email", "discoverable", "version", "created_at", "updated_at"}).
+			AddRow(1, "ada", "Ada", "Ada@Example.com", true, 1, now, now))
+
+	got, err := users.GetByEmails(context.Background(), []string{" Ada@Example.com", "nobody@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != 1 || got[0].Password != "" {
+		t.Errorf("got %+v, want ada without a password", got)
+	}
+}
+
+// TestCreateWithUnknownInviteToken registers erin with a token that isn't
+// a live invite of erin's address: the account is created and nothing else
+// is looked at or accepted
+func TestCreateWithUnknownInviteToken(t *testing.T) {
+	db, mock := newMockDB(t)
+	users := &UserStore{db, Limits{}, NewPools(db, nil)}
+
+	expectCreateUser(mock)
+	mock.ExpectQuery(`SELECT EXISTS\(\s+SELECT 1 FROM pending_email_invites\s+WHERE token_hash = \$1 AND email = \$2 AND accepted_at IS NULL AND expires_at > NOW\(\)`).
+		WithArgs("hash-of-someone-elses-token", "erin@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`FROM rooms r\s+WHERE r.id = ANY\(\$1\)`).WithArgs(pq.Array([]int64{})).
+		WillReturnRows(sqlmock.NewRows(roomRowColumns))
+	mock.ExpectCommit()
+
+	user := &User{Username: "erin", Email: "erin@example.com", Password: "hash"}
+	rooms, invites, err := users.CreateWithDefaultRooms(context.Background(), user, "hash-of-someone-elses-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 0 || invites != nil {
+		t.Errorf("got rooms %+v and invites %+v, want neither", rooms, invites)
+	}
+}
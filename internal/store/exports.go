@@ -0,0 +1,326 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Export job statuses
+const (
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+)
+
+// exportBatchSize is how many messages StreamUserMessages reads per query
+const exportBatchSize = 1000
+
+// ErrExportRateLimited is returned by CreateJob when the user exported too recently
+var ErrExportRateLimited = errors.New("export requested too recently")
+
+// ExportJob tracks one personal data export
+type ExportJob struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"user_id"`
+	Status      string     `json:"status"`
+	FilePath    string     `json:"-"` // Server-side location of an async export
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// ExportedMembership is a room membership as it appears in an export
+type ExportedMembership struct {
+	RoomID   int64     `json:"room_id"`
+	RoomName string    `json:"room_name"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+
+	// RetentionSeconds is how long the room keeps messages, when they expire:
+	// older messages were deleted and aren't in the export
+	RetentionSeconds int64 `json:"retention_seconds,omitempty"`
+}
+
+// ExportedMessage is a message as it appears in an export
+type ExportedMessage struct {
+	ID          int64     `json:"id"`
+	RoomID      int64     `json:"room_id"`
+	RoomName    string    `json:"room_name"`
+	Content     string    `json:"content"`
+	ContentType string    `json:"content_type"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// Who a bridge bot relayed the message for (see Message.OverrideUsername)
+	OverrideUsername  string `json:"override_username,omitempty"`
+	OverrideAvatarURL string `json:"override_avatar_url,omitempty"`
+
+	// Redacted is true once a platform admin removed the message; Content is
+	// then RedactedContent, never the original
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// ExportStore handles database operations for personal data exports
+type ExportStore struct {
+	db     *sql.DB
+	limits Limits // For rooms' retention
+	reads  *Pools // Export reads may run on the read replica
+}
+
+// CreateJob records a new export for a user
+// The user row is locked while checking for a recent export, so two
+// simultaneous requests can't both pass the limit
+// Returns ErrExportRateLimited if the user's last export started after since
+func (s *ExportStore) CreateJob(ctx context.Context, userID int64, since time.Time) (*ExportJob, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return nil, err
+	}
+
+	var recent bool
+	recentQuery := `SELECT EXISTS(SELECT 1 FROM export_jobs WHERE user_id = $1 AND created_at > $2)`
+	if err := tx.QueryRowContext(ctx, recentQuery, userID, since).Scan(&recent); err != nil {
+		return nil, err
+	}
+	if recent {
+		return nil, ErrExportRateLimited
+	}
+
+	job := &ExportJob{UserID: userID, Status: ExportRunning}
+	insertQuery := `
+		INSERT INTO export_jobs (user_id)
+		VALUES ($1) RETURNING id, created_at
+	`
+	if err := tx.QueryRowContext(ctx, insertQuery, userID).Scan(&job.ID, &job.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// CompleteJob marks an export as done
+// filePath is where an async export was written, or "" for an immediate export
+func (s *ExportStore) CompleteJob(ctx context.Context, jobID int64, filePath string) error {
+	query := `
+		UPDATE export_jobs
+		SET status = 'done', file_path = NULLIF($2, ''), completed_at = NOW()
+		WHERE id = $1
+	`
+	_, err := s.db.ExecContext(ctx, query, jobID, filePath)
+	return err
+}
+
+// FailJob marks an export as failed with a reason
+func (s *ExportStore) FailJob(ctx context.Context, jobID int64, reason string) error {
+	query := `
+		UPDATE export_jobs
+		SET status = 'failed', error = $2, completed_at = NOW()
+		WHERE id = $1
+	`
+	_, err := s.db.ExecContext(ctx, query, jobID, reason)
+	return err
+}
+
+// GetJob retrieves a user's export job
+// Jobs of other users are reported as sql.ErrNoRows, same as missing ones
+func (s *ExportStore) GetJob(ctx context.Context, jobID, userID int64) (*ExportJob, error) {
+	query := `
+		SELECT id, user_id, status, COALESCE(file_path, ''), COALESCE(error, ''), created_at, completed_at
+		FROM export_jobs
+		WHERE id = $1 AND user_id = $2
+	`
+
+	job := &ExportJob{}
+	err := s.db.QueryRowContext(ctx, query, jobID, userID).Scan(
+		&job.ID,
+		&job.UserID,
+		&job.Status,
+		&job.FilePath,
+		&job.Error,
+		&job.CreatedAt,
+		&job.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// CountUserMessages returns how many messages a user has written
+// Used to decide whether an export is small enough to serve immediately
+func (s *ExportStore) CountUserMessages(ctx context.Context, userID int64) (int, error) {
+	var count int
+	err := s.reads.queryRow(ctx, "ExportStore.CountUserMessages", `SELECT COUNT(*) FROM messages WHERE user_id = $1`, []interface{}{userID}, &count)
+	return count, err
+}
+
+// GetUserMemberships returns every room a user belongs to, with join dates
+// and the rooms' retention
+// Deleted rooms awaiting their purge are included, since their data is still held
+func (s *ExportStore) GetUserMemberships(ctx context.Context, userID int64) ([]*ExportedMembership, error) {
+	query := `
+		SELECT rm.room_id, r.name, rm.role, rm.joined_at, r.retention_seconds
+		FROM room_members rm
+		INNER JOIN rooms r ON r.id = rm.room_id
+		WHERE rm.user_id = $1
+		ORDER BY rm.joined_at ASC
+	`
+
+	rows, err := s.reads.query(ctx, "ExportStore.GetUserMemberships", query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := make([]*ExportedMembership, 0)
+	for rows.Next() {
+		m := &ExportedMembership{}
+		var retention *int64
+		if err := rows.Scan(&m.RoomID, &m.RoomName, &m.Role, &m.JoinedAt, &retention); err != nil {
+			return nil, err
+		}
+		m.RetentionSeconds = int64(s.limits.retention(retention).Seconds())
+		memberships = append(memberships, m)
+	}
+	return memberships, rows.Err()
+}
+
+// GetUserJoinRequests returns every join request a user has made
+func (s *ExportStore) GetUserJoinRequests(ctx context.Context, userID int64) ([]*JoinRequest, error) {
+	query := `
+		SELECT room_id, user_id, status, created_at, decided_at, decided_by
+		FROM join_requests
+		WHERE user_id = $1
+		ORDER BY created_at ASC
+	`
+
+	rows, err := s.reads.query(ctx, "ExportStore.GetUserJoinRequests", query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := make([]*JoinRequest, 0)
+	for rows.Next() {
+		r := &JoinRequest{}
+		err := rows.Scan(&r.RoomID, &r.UserID, &r.Status, &r.CreatedAt, &r.DecidedAt, &r.DecidedBy)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, r)
+	}
+	return requests, rows.Err()
+}
+
+// GetUserDevices returns every device a user has registered
+func (s *ExportStore) GetUserDevices(ctx context.Context, userID int64) ([]*Device, error) {
+	query := `
+		SELECT id, user_id, name, created_at, last_active_at
+		FROM devices
+		WHERE user_id = $1
+		ORDER BY id ASC
+	`
+
+	rows, err := s.reads.query(ctx, "ExportStore.GetUserDevices", query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := make([]*Device, 0)
+	for rows.Next() {
+		d := &Device{}
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Name, &d.CreatedAt, &d.LastActiveAt); err != nil {
+			return nil, err
+		}
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
+// GetUserPosts returns every post a user has written
+func (s *ExportStore) GetUserPosts(ctx context.Context, userID int64) ([]*Post, error) {
+	query := `
+		SELECT id, title, content, user_id, tags, created_at, updated_at
+		FROM posts
+		WHERE user_id = $1
+		ORDER BY id ASC
+	`
+
+	rows, err := s.reads.query(ctx, "ExportStore.GetUserPosts", query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := make([]*Post, 0)
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+// StreamUserMessages calls fn for every message a user has written, oldest first
+// Messages are read in batches using the last seen ID as a cursor (keyset
+// pagination), so memory use stays flat no matter how many messages there are
+// and no single query holds a transaction open for the whole export
+// Returning an error from fn stops the stream
+func (s *ExportStore) StreamUserMessages(ctx context.Context, userID int64, fn func(*ExportedMessage) error) error {
+	query := `
+		SELECT m.id, m.room_id, r.name, m.content, m.content_type, m.created_at,
+			COALESCE(m.override_username, ''), COALESCE(m.override_avatar_url, ''), m.redacted_at IS NOT NULL
+		FROM messages m
+		INNER JOIN rooms r ON r.id = m.room_id
+		WHERE m.user_id = $1 AND m.id > $2
+		ORDER BY m.id ASC
+		LIMIT $3
+	`
+
+	var cursor int64
+	for {
+		rows, err := s.reads.query(ctx, "ExportStore.StreamUserMessages", query, userID, cursor, exportBatchSize)
+		if err != nil {
+			return err
+		}
+
+		n := 0
+		for rows.Next() {
+			m := &ExportedMessage{}
+			if err := rows.Scan(&m.ID, &m.RoomID, &m.RoomName, &m.Content, &m.ContentType, &m.CreatedAt,
+				&m.OverrideUsername, &m.OverrideAvatarURL, &m.Redacted); err != nil {
+				rows.Close()
+				return err
+			}
+			if err := fn(m); err != nil {
+				rows.Close()
+				return err
+			}
+			cursor = m.ID
+			n++
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+
+		// A short batch means we've reached the end
+		if n < exportBatchSize {
+			return nil
+		}
+	}
+}
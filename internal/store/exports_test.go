@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestCreateJobRateLimit starts export jobs: the user's row is locked so two
+// requests can't both pass the check, a job since the cutoff refuses a new
+// one, and otherwise the job is created running
+func TestCreateJobRateLimit(t *testing.T) {
+	db, mock := newMockDB(t)
+	exports := &ExportStore{db, Limits{}, NewPools(db, nil)}
+	since := time.Now().Add(-24 * time.Hour)
+
+	for _, recent := range []bool{true, false} {
+		mock.ExpectBegin()
+		mock.ExpectExec(`SELECT id FROM users WHERE id = \$1 FOR UPDATE`).WithArgs(int64(2)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM export_jobs WHERE user_id = \$1 AND created_at > \$2\)`).
+			WithArgs(int64(2), since).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(recent))
+		if recent {
+			mock.ExpectRollback()
+		} else {
+			mock.ExpectQuery(`INSERT INTO export_jobs`).WithArgs(int64(2)).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, time.Now()))
+			mock.ExpectCommit()
+		}
+
+		job, err := exports.CreateJob(context.Background(), 2, since)
+		switch {
+		case recent && !errors.Is(err, ErrExportRateLimited):
+			t.Errorf("with a recent export got %v, want ErrExportRateLimited", err)
+		case !recent && (err != nil || job.ID != 7 || job.Status != ExportRunning):
+			t.Errorf("got %+v, %v; want job 7 running", job, err)
+		}
+	}
+}
+
+// TestStreamUserMessagesOverride exports a message a bridge relayed with who
+// it was relayed for, and leaves both fields out of a plain message
+func TestStreamUserMessagesOverride(t *testing.T) {
+	db, mock := newMockDB(t)
+	exports := &ExportStore{db, Limits{}, NewPools(db, nil)}
+	at := time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`COALESCE\(m.override_username, ''\), COALESCE\(m.override_avatar_url, ''\)`).
+		WithArgs(int64(2), int64(0), exportBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "room_id", "name", "content", "content_type", "created_at", "override_username", "override_avatar_url", "redacted"}).
+			AddRow(7, 1, "general", "hi from IRC", "text", at, "alice (IRC)", "https://irc.example.com/alice.png", false).
+			AddRow(8, 1, "general", "hello", "text", at, "", "", false))
+
+	var encoded []string
+	err := exports.StreamUserMessages(context.Background(), 2, func(m *ExportedMessage) error {
+		raw, err := json.Marshal(m)
+		encoded = append(encoded, string(raw))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encoded) != 2 || !strings.Contains(encoded[0], `"override_username":"alice (IRC)","override_avatar_url":"https://irc.example.com/alice.png"`) || strings.Contains(encoded[1], "override") {
+		t.Errorf("exported %v, want the override on the relayed message only", encoded)
+	}
+}
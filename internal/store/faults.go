@@ -0,0 +1,172 @@
+//go:build faults
+
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Fault injection
+//
+// This file only builds with -tags faults, so production binaries have no
+// trace of it. WithFaults puts a Faults in front of every store; a fault set
+// on a method then makes its calls fail, slow down, or both, for all calls or
+// every Nth one. Tests program it directly, and a dev server can expose it
+// as an admin endpoint (see chatapi/faults.go)
+
+// ErrUnknownFaultMethod is returned for a fault on a method Storage doesn't have
+var ErrUnknownFaultMethod = errors.New("no such store method")
+
+// FaultErrors are the errors a fault can be given by name, as the admin
+// endpoint does; each is one the handlers already have to cope with
+var FaultErrors = map[string]error{
+	"timeout":          context.DeadlineExceeded,
+	"canceled":         context.Canceled,
+	"no_rows":          sql.ErrNoRows,
+	"conn_done":        sql.ErrConnDone,
+	"bad_conn":         driver.ErrBadConn,
+	"unique_violation": &pq.Error{Code: "23505", Message: "duplicate key value violates unique constraint (injected)"},
+}
+
+// Fault is what happens to the calls of the method it's set on
+type Fault struct {
+	Err      error         // Returned instead of calling the store; nil lets the call through
+	Latency  time.Duration // Waited before the call or Err; the call's context can cut it short
+	EveryNth int           // Only every Nth call is affected; 0 and 1 affect every call
+}
+
+// FaultStatus is a fault with the calls it has seen since it was set, and
+// how many of those it affected
+type FaultStatus struct {
+	Fault
+	Calls int64
+	Hits  int64
+}
+
+// Faults holds the faults set on store methods, keyed as Field.Method
+// ("Messages.Create"); it's safe for concurrent use
+type Faults struct {
+	armed atomic.Bool // Whether any fault is set; calls skip the lock when not
+
+	mu     sync.Mutex
+	faults map[string]*FaultStatus
+}
+
+// NewFaults returns a Faults with no faults set
+func NewFaults() *Faults {
+	return &Faults{faults: make(map[string]*FaultStatus)}
+}
+
+// Set sets the fault on method, replacing any it had and restarting its counts
+func (f *Faults) Set(method string, fault Fault) error {
+	if !faultMethods[method] {
+		return ErrUnknownFaultMethod
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults[method] = &FaultStatus{Fault: fault}
+	f.armed.Store(true)
+	return nil
+}
+
+// Clear removes the fault on method, if it has one
+func (f *Faults) Clear(method string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.faults, method)
+	f.armed.Store(len(f.faults) > 0)
+}
+
+// Reset removes every fault
+func (f *Faults) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	clear(f.faults)
+	f.armed.Store(false)
+}
+
+// List returns the faults that are set, by method
+func (f *Faults) List() map[string]FaultStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list := make(map[string]FaultStatus, len(f.faults))
+	for method, status := range f.faults {
+		list[method] = *status
+	}
+	return list
+}
+
+// FaultMethods returns the methods a fault can be set on, sorted
+func FaultMethods() []string {
+	methods := make([]string, 0, len(faultMethods))
+	for method := range faultMethods {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// inject applies the fault on method to a call, if it has one and the call
+// is one it affects
+// Returns the error the call fails with instead of reaching the store; a
+// nil Faults, or one with nothing set, returns at once
+func (f *Faults) inject(ctx context.Context, method string) error {
+	if f == nil || !f.armed.Load() {
+		return nil
+	}
+
+	f.mu.Lock()
+	status := f.faults[method]
+	if status == nil {
+		f.mu.Unlock()
+		return nil
+	}
+	status.Calls++
+	hit := status.EveryNth <= 1 || status.Calls%int64(status.EveryNth) == 0
+	if hit {
+		status.Hits++
+	}
+	fault := status.Fault
+	f.mu.Unlock()
+
+	if !hit {
+		return nil
+	}
+	if fault.Latency > 0 {
+		timer := time.NewTimer(fault.Latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return fault.Err
+}
+
+// WithFaults wraps every store of st so its calls go through faults first
+// A nil faults returns st as is
+func WithFaults(st Storage, faults *Faults) Storage {
+	if faults == nil {
+		return st
+	}
+	return withFaults(st, faults)
+}
+
+// FaultsOf returns the Faults a Storage made by WithFaults goes through, or
+// nil for any other Storage
+func FaultsOf(st Storage) *Faults {
+	if faulty, ok := st.Posts.(faultyPosts); ok {
+		return faulty.faults
+	}
+	return nil
+}
@@ -0,0 +1,1691 @@
+// Code generated by gen_timeouts.go; DO NOT EDIT.
+
+//go:build faults
+
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/schedule"
+)
+
+// faultyStorage is the Storage a withFaults wrapper passes calls on to
+type faultyStorage struct {
+	faults *Faults
+	next   Storage
+}
+
+type faultyPosts struct{ *faultyStorage }
+
+func (s faultyPosts) Create(ctx context.Context, a1 *Post) (err error) {
+	if err = s.faults.inject(ctx, "Posts.Create"); err != nil {
+		return
+	}
+	return s.next.Posts.Create(ctx, a1)
+}
+
+func (s faultyPosts) GetByID(ctx context.Context, a1 int64) (r0 *Post, err error) {
+	if err = s.faults.inject(ctx, "Posts.GetByID"); err != nil {
+		return
+	}
+	return s.next.Posts.GetByID(ctx, a1)
+}
+
+func (s faultyPosts) List(ctx context.Context, a1 int, a2 int) (r0 []*Post, err error) {
+	if err = s.faults.inject(ctx, "Posts.List"); err != nil {
+		return
+	}
+	return s.next.Posts.List(ctx, a1, a2)
+}
+
+func (s faultyPosts) Update(ctx context.Context, a1 *Post) (err error) {
+	if err = s.faults.inject(ctx, "Posts.Update"); err != nil {
+		return
+	}
+	return s.next.Posts.Update(ctx, a1)
+}
+
+func (s faultyPosts) Delete(ctx context.Context, a1 int64) (err error) {
+	if err = s.faults.inject(ctx, "Posts.Delete"); err != nil {
+		return
+	}
+	return s.next.Posts.Delete(ctx, a1)
+}
+
+type faultyUsers struct{ *faultyStorage }
+
+func (s faultyUsers) Create(ctx context.Context, a1 *User) (err error) {
+	if err = s.faults.inject(ctx, "Users.Create"); err != nil {
+		return
+	}
+	return s.next.Users.Create(ctx, a1)
+}
+
+func (s faultyUsers) CreateWithDefaultRooms(ctx context.Context, a1 *User, a2 string) (r0 []*Room, r1 []*EmailInviteOutcome, err error) {
+	if err = s.faults.inject(ctx, "Users.CreateWithDefaultRooms"); err != nil {
+		return
+	}
+	return s.next.Users.CreateWithDefaultRooms(ctx, a1, a2)
+}
+
+func (s faultyUsers) EnsureSystemUser(ctx context.Context, a1 string) (err error) {
+	if err = s.faults.inject(ctx, "Users.EnsureSystemUser"); err != nil {
+		return
+	}
+	return s.next.Users.EnsureSystemUser(ctx, a1)
+}
+
+func (s faultyUsers) GetByEmail(ctx context.Context, a1 string) (r0 *User, err error) {
+	if err = s.faults.inject(ctx, "Users.GetByEmail"); err != nil {
+		return
+	}
+	return s.next.Users.GetByEmail(ctx, a1)
+}
+
+func (s faultyUsers) GetByID(ctx context.Context, a1 int64) (r0 *User, err error) {
+	if err = s.faults.inject(ctx, "Users.GetByID"); err != nil {
+		return
+	}
+	return s.next.Users.GetByID(ctx, a1)
+}
+
+func (s faultyUsers) GetByUsernames(ctx context.Context, a1 []string, a2 []int64) (r0 []*User, err error) {
+	if err = s.faults.inject(ctx, "Users.GetByUsernames"); err != nil {
+		return
+	}
+	return s.next.Users.GetByUsernames(ctx, a1, a2)
+}
+
+func (s faultyUsers) GetByEmails(ctx context.Context, a1 []string) (r0 []*User, err error) {
+	if err = s.faults.inject(ctx, "Users.GetByEmails"); err != nil {
+		return
+	}
+	return s.next.Users.GetByEmails(ctx, a1)
+}
+
+func (s faultyUsers) GetByUsername(ctx context.Context, a1 string) (r0 *PublicUser, err error) {
+	if err = s.faults.inject(ctx, "Users.GetByUsername"); err != nil {
+		return
+	}
+	return s.next.Users.GetByUsername(ctx, a1)
+}
+
+func (s faultyUsers) Search(ctx context.Context, a1 string, a2 int) (r0 []*PublicUser, err error) {
+	if err = s.faults.inject(ctx, "Users.Search"); err != nil {
+		return
+	}
+	return s.next.Users.Search(ctx, a1, a2)
+}
+
+func (s faultyUsers) UpdateProfile(ctx context.Context, a1 int64, a2 *string, a3 *bool, a4 int64) (r0 *User, err error) {
+	if err = s.faults.inject(ctx, "Users.UpdateProfile"); err != nil {
+		return
+	}
+	return s.next.Users.UpdateProfile(ctx, a1, a2, a3, a4)
+}
+
+func (s faultyUsers) Delete(ctx context.Context, a1 int64) (err error) {
+	if err = s.faults.inject(ctx, "Users.Delete"); err != nil {
+		return
+	}
+	return s.next.Users.Delete(ctx, a1)
+}
+
+func (s faultyUsers) GetRole(ctx context.Context, a1 int64) (r0 string, err error) {
+	if err = s.faults.inject(ctx, "Users.GetRole"); err != nil {
+		return
+	}
+	return s.next.Users.GetRole(ctx, a1)
+}
+
+func (s faultyUsers) SetRole(ctx context.Context, a1 int64, a2 string) (err error) {
+	if err = s.faults.inject(ctx, "Users.SetRole"); err != nil {
+		return
+	}
+	return s.next.Users.SetRole(ctx, a1, a2)
+}
+
+func (s faultyUsers) IsBanned(ctx context.Context, a1 int64) (r0 bool, err error) {
+	if err = s.faults.inject(ctx, "Users.IsBanned"); err != nil {
+		return
+	}
+	return s.next.Users.IsBanned(ctx, a1)
+}
+
+func (s faultyUsers) Ban(ctx context.Context, a1 int64) (r0 []int64, err error) {
+	if err = s.faults.inject(ctx, "Users.Ban"); err != nil {
+		return
+	}
+	return s.next.Users.Ban(ctx, a1)
+}
+
+func (s faultyUsers) Unban(ctx context.Context, a1 int64) (err error) {
+	if err = s.faults.inject(ctx, "Users.Unban"); err != nil {
+		return
+	}
+	return s.next.Users.Unban(ctx, a1)
+}
+
+func (s faultyUsers) GetTokenVersion(ctx context.Context, a1 int64) (r0 int64, err error) {
+	if err = s.faults.inject(ctx, "Users.GetTokenVersion"); err != nil {
+		return
+	}
+	return s.next.Users.GetTokenVersion(ctx, a1)
+}
+
+func (s faultyUsers) RevokeTokens(ctx context.Context, a1 int64) (r0 int64, r1 []int64, err error) {
+	if err = s.faults.inject(ctx, "Users.RevokeTokens"); err != nil {
+		return
+	}
+	return s.next.Users.RevokeTokens(ctx, a1)
+}
+
+type faultyRooms struct{ *faultyStorage }
+
+func (s faultyRooms) Create(ctx context.Context, a1 *Room) (err error) {
+	if err = s.faults.inject(ctx, "Rooms.Create"); err != nil {
+		return
+	}
+	return s.next.Rooms.Create(ctx, a1)
+}
+
+func (s faultyRooms) CreateWithMembers(ctx context.Context, a1 *Room, a2 []int64) (r0 map[int64]string, err error) {
+	if err = s.faults.inject(ctx, "Rooms.CreateWithMembers"); err != nil {
+		return
+	}
+	return s.next.Rooms.CreateWithMembers(ctx, a1, a2)
+}
+
+func (s faultyRooms) GetByID(ctx context.Context, a1 int64) (r0 *Room, err error) {
+	if err = s.faults.inject(ctx, "Rooms.GetByID"); err != nil {
+		return
+	}
+	return s.next.Rooms.GetByID(ctx, a1)
+}
+
+func (s faultyRooms) GetByName(ctx context.Context, a1 string) (r0 *Room, err error) {
+	if err = s.faults.inject(ctx, "Rooms.GetByName"); err != nil {
+		return
+	}
+	return s.next.Rooms.GetByName(ctx, a1)
+}
+
+func (s faultyRooms) IsContentFilterEnabled(ctx context.Context, a1 int64) (r0 bool, err error) {
+	if err = s.faults.inject(ctx, "Rooms.IsContentFilterEnabled"); err != nil {
+		return
+	}
+	return s.next.Rooms.IsContentFilterEnabled(ctx, a1)
+}
+
+func (s faultyRooms) IsDuplicateLimitEnabled(ctx context.Context, a1 int64) (r0 bool, err error) {
+	if err = s.faults.inject(ctx, "Rooms.IsDuplicateLimitEnabled"); err != nil {
+		return
+	}
+	return s.next.Rooms.IsDuplicateLimitEnabled(ctx, a1)
+}
+
+func (s faultyRooms) GetQuietHours(ctx context.Context, a1 int64) (r0 *schedule.Window, r1 int64, err error) {
+	if err = s.faults.inject(ctx, "Rooms.GetQuietHours"); err != nil {
+		return
+	}
+	return s.next.Rooms.GetQuietHours(ctx, a1)
+}
+
+func (s faultyRooms) GetLanguage(ctx context.Context, a1 int64) (r0 string, err error) {
+	if err = s.faults.inject(ctx, "Rooms.GetLanguage"); err != nil {
+		return
+	}
+	return s.next.Rooms.GetLanguage(ctx, a1)
+}
+
+func (s faultyRooms) GetPostPolicy(ctx context.Context, a1 int64) (r0 string, err error) {
+	if err = s.faults.inject(ctx, "Rooms.GetPostPolicy"); err != nil {
+		return
+	}
+	return s.next.Rooms.GetPostPolicy(ctx, a1)
+}
+
+func (s faultyRooms) List(ctx context.Context, a1 RoomListOptions) (r0 []*Room, err error) {
+	if err = s.faults.inject(ctx, "Rooms.List"); err != nil {
+		return
+	}
+	return s.next.Rooms.List(ctx, a1)
+}
+
+func (s faultyRooms) GetUserRooms(ctx context.Context, a1 int64, a2 RoomListOptions) (r0 []*Room, err error) {
+	if err = s.faults.inject(ctx, "Rooms.GetUserRooms"); err != nil {
+		return
+	}
+	return s.next.Rooms.GetUserRooms(ctx, a1, a2)
+}
+
+func (s faultyRooms) GetUserRoomSummaries(ctx context.Context, a1 int64, a2 RoomListOptions) (r0 []*RoomSummary, err error) {
+	if err = s.faults.inject(ctx, "Rooms.GetUserRoomSummaries"); err != nil {
+		return
+	}
+	return s.next.Rooms.GetUserRoomSummaries(ctx, a1, a2)
+}
+
+func (s faultyRooms) SetDefault(ctx context.Context, a1 int64, a2 bool) (err error) {
+	if err = s.faults.inject(ctx, "Rooms.SetDefault"); err != nil {
+		return
+	}
+	return s.next.Rooms.SetDefault(ctx, a1, a2)
+}
+
+func (s faultyRooms) Recommend(ctx context.Context, a1 int64, a2 int) (r0 []*RoomRecommendation, err error) {
+	if err = s.faults.inject(ctx, "Rooms.Recommend"); err != nil {
+		return
+	}
+	return s.next.Rooms.Recommend(ctx, a1, a2)
+}
+
+func (s faultyRooms) ListTags(ctx context.Context) (r0 []*TagCount, err error) {
+	if err = s.faults.inject(ctx, "Rooms.ListTags"); err != nil {
+		return
+	}
+	return s.next.Rooms.ListTags(ctx)
+}
+
+func (s faultyRooms) Merge(ctx context.Context, a1 int64, a2 int64, a3 int64) (r0 *RoomMergeResult, err error) {
+	if err = s.faults.inject(ctx, "Rooms.Merge"); err != nil {
+		return
+	}
+	return s.next.Rooms.Merge(ctx, a1, a2, a3)
+}
+
+func (s faultyRooms) Update(ctx context.Context, a1 *Room, a2 int64) (err error) {
+	if err = s.faults.inject(ctx, "Rooms.Update"); err != nil {
+		return
+	}
+	return s.next.Rooms.Update(ctx, a1, a2)
+}
+
+func (s faultyRooms) CountCreatedSince(ctx context.Context, a1 int64, a2 time.Time) (r0 int, r1 time.Time, err error) {
+	if err = s.faults.inject(ctx, "Rooms.CountCreatedSince"); err != nil {
+		return
+	}
+	return s.next.Rooms.CountCreatedSince(ctx, a1, a2)
+}
+
+func (s faultyRooms) CountOwnedActive(ctx context.Context, a1 int64) (r0 int, err error) {
+	if err = s.faults.inject(ctx, "Rooms.CountOwnedActive"); err != nil {
+		return
+	}
+	return s.next.Rooms.CountOwnedActive(ctx, a1)
+}
+
+func (s faultyRooms) SoftDelete(ctx context.Context, a1 int64) (err error) {
+	if err = s.faults.inject(ctx, "Rooms.SoftDelete"); err != nil {
+		return
+	}
+	return s.next.Rooms.SoftDelete(ctx, a1)
+}
+
+func (s faultyRooms) GetDeletedByID(ctx context.Context, a1 int64, a2 time.Duration) (r0 *Room, err error) {
+	if err = s.faults.inject(ctx, "Rooms.GetDeletedByID"); err != nil {
+		return
+	}
+	return s.next.Rooms.GetDeletedByID(ctx, a1, a2)
+}
+
+func (s faultyRooms) Restore(ctx context.Context, a1 int64, a2 time.Duration) (err error) {
+	if err = s.faults.inject(ctx, "Rooms.Restore"); err != nil {
+		return
+	}
+	return s.next.Rooms.Restore(ctx, a1, a2)
+}
+
+func (s faultyRooms) PurgeExpired(ctx context.Context, a1 time.Duration, a2 int) (r0 int64, err error) {
+	if err = s.faults.inject(ctx, "Rooms.PurgeExpired"); err != nil {
+		return
+	}
+	return s.next.Rooms.PurgeExpired(ctx, a1, a2)
+}
+
+func (s faultyRooms) ListRetained(ctx context.Context) (r0 []RoomRetention, err error) {
+	if err = s.faults.inject(ctx, "Rooms.ListRetained"); err != nil {
+		return
+	}
+	return s.next.Rooms.ListRetained(ctx)
+}
+
+func (s faultyRooms) Delete(ctx context.Context, a1 int64) (err error) {
+	if err = s.faults.inject(ctx, "Rooms.Delete"); err != nil {
+		return
+	}
+	return s.next.Rooms.Delete(ctx, a1)
+}
+
+type faultyMessages struct{ *faultyStorage }
+
+func (s faultyMessages) Create(ctx context.Context, a1 *Message) (err error) {
+	if err = s.faults.inject(ctx, "Messages.Create"); err != nil {
+		return
+	}
+	return s.next.Messages.Create(ctx, a1)
+}
+
+func (s faultyMessages) GetRoomMessages(ctx context.Context, a1 int64, a2 int) (r0 []*Message, err error) {
+	if err = s.faults.inject(ctx, "Messages.GetRoomMessages"); err != nil {
+		return
+	}
+	return s.next.Messages.GetRoomMessages(ctx, a1, a2)
+}
+
+func (s faultyMessages) GetMessagesBefore(ctx context.Context, a1 int64, a2 int64, a3 int) (r0 []*Message, err error) {
+	if err = s.faults.inject(ctx, "Messages.GetMessagesBefore"); err != nil {
+		return
+	}
+	return s.next.Messages.GetMessagesBefore(ctx, a1, a2, a3)
+}
+
+func (s faultyMessages) GetMessagesAround(ctx context.Context, a1 int64, a2 int64, a3 int, a4 int) (r0 *MessageWindow, err error) {
+	if err = s.faults.inject(ctx, "Messages.GetMessagesAround"); err != nil {
+		return
+	}
+	return s.next.Messages.GetMessagesAround(ctx, a1, a2, a3, a4)
+}
+
+func (s faultyMessages) Histogram(ctx context.Context, a1 int64, a2 string, a3 time.Time, a4 time.Time) (r0 []*ActivityBucket, err error) {
+	if err = s.faults.inject(ctx, "Messages.Histogram"); err != nil {
+		return
+	}
+	return s.next.Messages.Histogram(ctx, a1, a2, a3, a4)
+}
+
+func (s faultyMessages) FirstMessageAt(ctx context.Context, a1 int64, a2 time.Time) (r0 int64, err error) {
+	if err = s.faults.inject(ctx, "Messages.FirstMessageAt"); err != nil {
+		return
+	}
+	return s.next.Messages.FirstMessageAt(ctx, a1, a2)
+}
+
+func (s faultyMessages) GetMessagesSince(ctx context.Context, a1 int64, a2 time.Time) (r0 []*Message, err error) {
+	if err = s.faults.inject(ctx, "Messages.GetMessagesSince"); err != nil {
+		return
+	}
+	return s.next.Messages.GetMessagesSince(ctx, a1, a2)
+}
+
+func (s faultyMessages) CountRecentIdentical(ctx context.Context, a1 int64, a2 int64, a3 string, a4 time.Duration) (r0 int, err error) {
+	if err = s.faults.inject(ctx, "Messages.CountRecentIdentical"); err != nil {
+		return
+	}
+	return s.next.Messages.CountRecentIdentical(ctx, a1, a2, a3, a4)
+}
+
+func (s faultyMessages) CountInRoom(ctx context.Context, a1 int64) (r0 int, err error) {
+	if err = s.faults.inject(ctx, "Messages.CountInRoom"); err != nil {
+		return
+	}
+	return s.next.Messages.CountInRoom(ctx, a1)
+}
+
+func (s faultyMessages) GetByID(ctx context.Context, a1 int64) (r0 *Message, err error) {
+	if err = s.faults.inject(ctx, "Messages.GetByID"); err != nil {
+		return
+	}
+	return s.next.Messages.GetByID(ctx, a1)
+}
+
+func (s faultyMessages) PurgeRoomExpired(ctx context.Context, a1 int64, a2 time.Duration, a3 int) (r0 int64, err error) {
+	if err = s.faults.inject(ctx, "Messages.PurgeRoomExpired"); err != nil {
+		return
+	}
+	return s.next.Messages.PurgeRoomExpired(ctx, a1, a2, a3)
+}
+
+func (s faultyMessages) Search(ctx context.Context, a1 int64, a2 string, a3 int) (r0 []*Message, err error) {
+	if err = s.faults.inject(ctx, "Messages.Search"); err != nil {
+		return
+	}
+	return s.next.Messages.Search(ctx, a1, a2, a3)
+}
+
+type faultyRoomMembers struct{ *faultyStorage }
+
+func (s faultyRoomMembers) Join(ctx context.Context, a1 int64, a2 int64, a3 int64) (err error) {
+	if err = s.faults.inject(ctx, "RoomMembers.Join"); err != nil {
+		return
+	}
+	return s.next.RoomMembers.Join(ctx, a1, a2, a3)
+}
+
+func (s faultyRoomMembers) JoinWithOptions(ctx context.Context, a1 int64, a2 int64, a3 JoinOptions) (err error) {
+	if err = s.faults.inject(ctx, "RoomMembers.JoinWithOptions"); err != nil {
+		return
+	}
+	return s.next.RoomMembers.JoinWithOptions(ctx, a1, a2, a3)
+}
+
+func (s faultyRoomMembers) JoinIfAbsent(ctx context.Context, a1 int64, a2 int64) (r0 bool, err error) {
+	if err = s.faults.inject(ctx, "RoomMembers.JoinIfAbsent"); err != nil {
+		return
+	}
+	return s.next.RoomMembers.JoinIfAbsent(ctx, a1, a2)
+}
+
+func (s faultyRoomMembers) Leave(ctx context.Context, a1 int64, a2 int64, a3 int64) (err error) {
+	if err = s.faults.inject(ctx, "RoomMembers.Leave"); err != nil {
+		return
+	}
+	return s.next.RoomMembers.Leave(ctx, a1, a2, a3)
+}
+
+func (s faultyRoomMembers) IsUserInRoom(ctx context.Context, a1 int64, a2 int64) (r0 bool, err error) {
+	if err = s.faults.inject(ctx, "RoomMembers.IsUserInRoom"); err != nil {
+		return
+	}
+	return s.next.RoomMembers.IsUserInRoom(ctx, a1, a2)
+}
+
+func (s faultyRoomMembers) IsRoomAdmin(ctx context.Context, a1 int64, a2 int64) (r0 bool, err error) {
+	if err = s.faults.inject(ctx, "RoomMembers.IsRoomAdmin"); err != nil {
+		return
+	}
+	return s.next.RoomMembers.IsRoomAdmin(ctx, a1, a2)
+}
+
+func (s faultyRoomMembers) SetRole(ctx context.Context, a1 int64, a2 int64, a3 string) (err error) {
+	if err = s.faults.inject(ctx, "RoomMembers.SetRole"); err != nil {
+		return
+	}
+	return s.next.RoomMembers.SetRole(ctx, a1, a2, a3)
+}
+
+func (s faultyRoomMembers) GetRoomAdmins(ctx context.Context, a1 int64) (r0 []int64, err error) {
+	if err = s.faults.inject(ctx, "RoomMembers.GetRoomAdmins"); err != nil {
+		return
+	}
+	return s.next.RoomMembers.GetRoomAdmins(ctx, a1)
+}
+
+func (s faultyRoomMembers) GetRoomMembers(ctx context.Context, a1 int64) (r0 []int64, err error) {
+	if err = s.faults.inject(ctx, "RoomMembers.GetRoomMembers"); err != nil {
+		return
+	}
+	return s.next.RoomMembers.GetRoomMembers(ctx, a1)
+}
+
+func (s faultyRoomMembers) GetRoomMemberCount(ctx context.Context, a1 int64) (r0 int, err error) {
+	if err = s.faults.inject(ctx, "RoomMembers.GetRoomMemberCount"); err != nil {
+		return
+	}
+	return s.next.RoomMembers.GetRoomMemberCount(ctx, a1)
+}
+
+func (s faultyRoomMembers) GetUserRoomCount(ctx context.Context, a1 int64) (r0 int, err error) {
+	if err = s.faults.inject(ctx, "RoomMembers.GetUserRoomCount"); err != nil {
+		return
+	}
+	return s.next.RoomMembers.GetUserRoomCount(ctx, a1)
+}
+
+func (s faultyRoomMembers) FindMembersByUsername(ctx context.Context, a1 int64, a2 []string) (r0 []int64, err error) {
+	if err = s.faults.inject(ctx, "RoomMembers.FindMembersByUsername"); err != nil {
+		return
+	}
+	return s.next.RoomMembers.FindMembersByUsername(ctx, a1, a2)
+}
+
+func (s faultyRoomMembers) SearchMembers(ctx context.Context, a1 int64, a2 string, a3 int) (r0 []*PublicUser, err error) {
+	if err = s.faults.inject(ctx, "RoomMembers.SearchMembers"); err != nil {
+		return
+	}
+	return s.next.RoomMembers.SearchMembers(ctx, a1, a2, a3)
+}
+
+func (s faultyRoomMembers) GetMutual(ctx context.Context, a1 int64, a2 int64) (r0 *MutualContext, err error) {
+	if err = s.faults.inject(ctx, "RoomMembers.GetMutual"); err != nil {
+		return
+	}
+	return s.next.RoomMembers.GetMutual(ctx, a1, a2)
+}
+
+func (s faultyRoomMembers) AddMembers(ctx context.Context, a1 int64, a2 []int64, a3 int64) (r0 map[int64]string, err error) {
+	if err = s.faults.inject(ctx, "RoomMembers.AddMembers"); err != nil {
+		return
+	}
+	return s.next.RoomMembers.AddMembers(ctx, a1, a2, a3)
+}
+
+func (s faultyRoomMembers) RemoveMembers(ctx context.Context, a1 int64, a2 []int64, a3 int64) (r0 map[int64]string, err error) {
+	if err = s.faults.inject(ctx, "RoomMembers.RemoveMembers"); err != nil {
+		return
+	}
+	return s.next.RoomMembers.RemoveMembers(ctx, a1, a2, a3)
+}
+
+type faultyMembershipEvents struct{ *faultyStorage }
+
+func (s faultyMembershipEvents) List(ctx context.Context, a1 int64, a2 MembershipEventQuery) (r0 []*MembershipEvent, err error) {
+	if err = s.faults.inject(ctx, "MembershipEvents.List"); err != nil {
+		return
+	}
+	return s.next.MembershipEvents.List(ctx, a1, a2)
+}
+
+type faultyPins struct{ *faultyStorage }
+
+func (s faultyPins) Pin(ctx context.Context, a1 int64, a2 int64, a3 int64) (r0 PinChange, err error) {
+	if err = s.faults.inject(ctx, "Pins.Pin"); err != nil {
+		return
+	}
+	return s.next.Pins.Pin(ctx, a1, a2, a3)
+}
+
+func (s faultyPins) Unpin(ctx context.Context, a1 int64, a2 int64) (r0 PinChange, err error) {
+	if err = s.faults.inject(ctx, "Pins.Unpin"); err != nil {
+		return
+	}
+	return s.next.Pins.Unpin(ctx, a1, a2)
+}
+
+func (s faultyPins) List(ctx context.Context, a1 int64) (r0 []*PinnedMessage, r1 int64, err error) {
+	if err = s.faults.inject(ctx, "Pins.List"); err != nil {
+		return
+	}
+	return s.next.Pins.List(ctx, a1)
+}
+
+func (s faultyPins) Reorder(ctx context.Context, a1 int64, a2 []int64, a3 int64) (r0 PinChange, err error) {
+	if err = s.faults.inject(ctx, "Pins.Reorder"); err != nil {
+		return
+	}
+	return s.next.Pins.Reorder(ctx, a1, a2, a3)
+}
+
+func (s faultyPins) Search(ctx context.Context, a1 int64, a2 string, a3 int) (r0 []*PinnedMessage, err error) {
+	if err = s.faults.inject(ctx, "Pins.Search"); err != nil {
+		return
+	}
+	return s.next.Pins.Search(ctx, a1, a2, a3)
+}
+
+func (s faultyPins) Count(ctx context.Context, a1 int64) (r0 int, err error) {
+	if err = s.faults.inject(ctx, "Pins.Count"); err != nil {
+		return
+	}
+	return s.next.Pins.Count(ctx, a1)
+}
+
+type faultyRoomEvents struct{ *faultyStorage }
+
+func (s faultyRoomEvents) ListAfter(ctx context.Context, a1 int64, a2 int64, a3 int) (r0 []*RoomEvent, err error) {
+	if err = s.faults.inject(ctx, "RoomEvents.ListAfter"); err != nil {
+		return
+	}
+	return s.next.RoomEvents.ListAfter(ctx, a1, a2, a3)
+}
+
+func (s faultyRoomEvents) PurgeOlderThan(ctx context.Context, a1 time.Time) (r0 int64, err error) {
+	if err = s.faults.inject(ctx, "RoomEvents.PurgeOlderThan"); err != nil {
+		return
+	}
+	return s.next.RoomEvents.PurgeOlderThan(ctx, a1)
+}
+
+type faultyUserEvents struct{ *faultyStorage }
+
+func (s faultyUserEvents) Append(ctx context.Context, a1 []*UserEvent) (err error) {
+	if err = s.faults.inject(ctx, "UserEvents.Append"); err != nil {
+		return
+	}
+	return s.next.UserEvents.Append(ctx, a1)
+}
+
+func (s faultyUserEvents) ListAfter(ctx context.Context, a1 int64, a2 int64, a3 int) (r0 []*UserEvent, err error) {
+	if err = s.faults.inject(ctx, "UserEvents.ListAfter"); err != nil {
+		return
+	}
+	return s.next.UserEvents.ListAfter(ctx, a1, a2, a3)
+}
+
+func (s faultyUserEvents) PurgeOlderThan(ctx context.Context, a1 time.Time) (r0 int64, err error) {
+	if err = s.faults.inject(ctx, "UserEvents.PurgeOlderThan"); err != nil {
+		return
+	}
+	return s.next.UserEvents.PurgeOlderThan(ctx, a1)
+}
+
+type faultyDigests struct{ *faultyStorage }
+
+func (s faultyDigests) GetSettings(ctx context.Context, a1 int64) (r0 *DigestSettings, err error) {
+	if err = s.faults.inject(ctx, "Digests.GetSettings"); err != nil {
+		return
+	}
+	return s.next.Digests.GetSettings(ctx, a1)
+}
+
+func (s faultyDigests) UpdateSettings(ctx context.Context, a1 int64, a2 *DigestSettings) (err error) {
+	if err = s.faults.inject(ctx, "Digests.UpdateSettings"); err != nil {
+		return
+	}
+	return s.next.Digests.UpdateSettings(ctx, a1, a2)
+}
+
+func (s faultyDigests) Timezones(ctx context.Context) (r0 []string, err error) {
+	if err = s.faults.inject(ctx, "Digests.Timezones"); err != nil {
+		return
+	}
+	return s.next.Digests.Timezones(ctx)
+}
+
+func (s faultyDigests) ClaimDue(ctx context.Context, a1 string, a2 time.Time, a3 int, a4 int) (r0 []int64, err error) {
+	if err = s.faults.inject(ctx, "Digests.ClaimDue"); err != nil {
+		return
+	}
+	return s.next.Digests.ClaimDue(ctx, a1, a2, a3, a4)
+}
+
+func (s faultyDigests) LoadRecipients(ctx context.Context, a1 []int64, a2 time.Time) (r0 []*DigestRecipient, err error) {
+	if err = s.faults.inject(ctx, "Digests.LoadRecipients"); err != nil {
+		return
+	}
+	return s.next.Digests.LoadRecipients(ctx, a1, a2)
+}
+
+func (s faultyDigests) Finish(ctx context.Context, a1 int64, a2 time.Time, a3 string, a4 string) (err error) {
+	if err = s.faults.inject(ctx, "Digests.Finish"); err != nil {
+		return
+	}
+	return s.next.Digests.Finish(ctx, a1, a2, a3, a4)
+}
+
+type faultyNotificationPreferences struct{ *faultyStorage }
+
+func (s faultyNotificationPreferences) Get(ctx context.Context, a1 int64) (r0 NotificationPreferences, err error) {
+	if err = s.faults.inject(ctx, "NotificationPreferences.Get"); err != nil {
+		return
+	}
+	return s.next.NotificationPreferences.Get(ctx, a1)
+}
+
+func (s faultyNotificationPreferences) GetForUsers(ctx context.Context, a1 []int64) (r0 map[int64]NotificationPreferences, err error) {
+	if err = s.faults.inject(ctx, "NotificationPreferences.GetForUsers"); err != nil {
+		return
+	}
+	return s.next.NotificationPreferences.GetForUsers(ctx, a1)
+}
+
+func (s faultyNotificationPreferences) Update(ctx context.Context, a1 int64, a2 NotificationPreferences) (r0 NotificationPreferences, err error) {
+	if err = s.faults.inject(ctx, "NotificationPreferences.Update"); err != nil {
+		return
+	}
+	return s.next.NotificationPreferences.Update(ctx, a1, a2)
+}
+
+type faultyDevices struct{ *faultyStorage }
+
+func (s faultyDevices) Create(ctx context.Context, a1 *Device) (err error) {
+	if err = s.faults.inject(ctx, "Devices.Create"); err != nil {
+		return
+	}
+	return s.next.Devices.Create(ctx, a1)
+}
+
+func (s faultyDevices) Touch(ctx context.Context, a1 int64, a2 int64) (err error) {
+	if err = s.faults.inject(ctx, "Devices.Touch"); err != nil {
+		return
+	}
+	return s.next.Devices.Touch(ctx, a1, a2)
+}
+
+func (s faultyDevices) PruneInactive(ctx context.Context, a1 time.Time) (r0 int64, err error) {
+	if err = s.faults.inject(ctx, "Devices.PruneInactive"); err != nil {
+		return
+	}
+	return s.next.Devices.PruneInactive(ctx, a1)
+}
+
+type faultyAPITokens struct{ *faultyStorage }
+
+func (s faultyAPITokens) Create(ctx context.Context, a1 *APIToken, a2 string) (err error) {
+	if err = s.faults.inject(ctx, "APITokens.Create"); err != nil {
+		return
+	}
+	return s.next.APITokens.Create(ctx, a1, a2)
+}
+
+func (s faultyAPITokens) List(ctx context.Context, a1 int64) (r0 []*APIToken, err error) {
+	if err = s.faults.inject(ctx, "APITokens.List"); err != nil {
+		return
+	}
+	return s.next.APITokens.List(ctx, a1)
+}
+
+func (s faultyAPITokens) GetByHash(ctx context.Context, a1 string) (r0 *APIToken, err error) {
+	if err = s.faults.inject(ctx, "APITokens.GetByHash"); err != nil {
+		return
+	}
+	return s.next.APITokens.GetByHash(ctx, a1)
+}
+
+func (s faultyAPITokens) Touch(ctx context.Context, a1 int64) (err error) {
+	if err = s.faults.inject(ctx, "APITokens.Touch"); err != nil {
+		return
+	}
+	return s.next.APITokens.Touch(ctx, a1)
+}
+
+func (s faultyAPITokens) Revoke(ctx context.Context, a1 int64, a2 int64) (err error) {
+	if err = s.faults.inject(ctx, "APITokens.Revoke"); err != nil {
+		return
+	}
+	return s.next.APITokens.Revoke(ctx, a1, a2)
+}
+
+type faultyAPIKeys struct{ *faultyStorage }
+
+func (s faultyAPIKeys) Create(ctx context.Context, a1 *APIKey, a2 string) (err error) {
+	if err = s.faults.inject(ctx, "APIKeys.Create"); err != nil {
+		return
+	}
+	return s.next.APIKeys.Create(ctx, a1, a2)
+}
+
+func (s faultyAPIKeys) List(ctx context.Context, a1 int64) (r0 []*APIKey, err error) {
+	if err = s.faults.inject(ctx, "APIKeys.List"); err != nil {
+		return
+	}
+	return s.next.APIKeys.List(ctx, a1)
+}
+
+func (s faultyAPIKeys) GetByHash(ctx context.Context, a1 string) (r0 *APIKey, err error) {
+	if err = s.faults.inject(ctx, "APIKeys.GetByHash"); err != nil {
+		return
+	}
+	return s.next.APIKeys.GetByHash(ctx, a1)
+}
+
+func (s faultyAPIKeys) Touch(ctx context.Context, a1 int64) (err error) {
+	if err = s.faults.inject(ctx, "APIKeys.Touch"); err != nil {
+		return
+	}
+	return s.next.APIKeys.Touch(ctx, a1)
+}
+
+func (s faultyAPIKeys) Revoke(ctx context.Context, a1 int64, a2 int64) (err error) {
+	if err = s.faults.inject(ctx, "APIKeys.Revoke"); err != nil {
+		return
+	}
+	return s.next.APIKeys.Revoke(ctx, a1, a2)
+}
+
+type faultySessions struct{ *faultyStorage }
+
+func (s faultySessions) Create(ctx context.Context, a1 *Session) (err error) {
+	if err = s.faults.inject(ctx, "Sessions.Create"); err != nil {
+		return
+	}
+	return s.next.Sessions.Create(ctx, a1)
+}
+
+func (s faultySessions) GetByID(ctx context.Context, a1 int64) (r0 *Session, err error) {
+	if err = s.faults.inject(ctx, "Sessions.GetByID"); err != nil {
+		return
+	}
+	return s.next.Sessions.GetByID(ctx, a1)
+}
+
+func (s faultySessions) ListActive(ctx context.Context, a1 int64, a2 time.Time) (r0 []*Session, err error) {
+	if err = s.faults.inject(ctx, "Sessions.ListActive"); err != nil {
+		return
+	}
+	return s.next.Sessions.ListActive(ctx, a1, a2)
+}
+
+func (s faultySessions) Touch(ctx context.Context, a1 int64, a2 string) (err error) {
+	if err = s.faults.inject(ctx, "Sessions.Touch"); err != nil {
+		return
+	}
+	return s.next.Sessions.Touch(ctx, a1, a2)
+}
+
+func (s faultySessions) Revoke(ctx context.Context, a1 int64, a2 int64) (err error) {
+	if err = s.faults.inject(ctx, "Sessions.Revoke"); err != nil {
+		return
+	}
+	return s.next.Sessions.Revoke(ctx, a1, a2)
+}
+
+func (s faultySessions) PurgeInactive(ctx context.Context, a1 time.Time) (r0 int64, err error) {
+	if err = s.faults.inject(ctx, "Sessions.PurgeInactive"); err != nil {
+		return
+	}
+	return s.next.Sessions.PurgeInactive(ctx, a1)
+}
+
+type faultyTwoFactor struct{ *faultyStorage }
+
+func (s faultyTwoFactor) Get(ctx context.Context, a1 int64) (r0 *TwoFactor, err error) {
+	if err = s.faults.inject(ctx, "TwoFactor.Get"); err != nil {
+		return
+	}
+	return s.next.TwoFactor.Get(ctx, a1)
+}
+
+func (s faultyTwoFactor) Setup(ctx context.Context, a1 int64, a2 []byte, a3 []string) (err error) {
+	if err = s.faults.inject(ctx, "TwoFactor.Setup"); err != nil {
+		return
+	}
+	return s.next.TwoFactor.Setup(ctx, a1, a2, a3)
+}
+
+func (s faultyTwoFactor) Enable(ctx context.Context, a1 int64, a2 int64) (err error) {
+	if err = s.faults.inject(ctx, "TwoFactor.Enable"); err != nil {
+		return
+	}
+	return s.next.TwoFactor.Enable(ctx, a1, a2)
+}
+
+func (s faultyTwoFactor) UseStep(ctx context.Context, a1 int64, a2 int64) (r0 bool, err error) {
+	if err = s.faults.inject(ctx, "TwoFactor.UseStep"); err != nil {
+		return
+	}
+	return s.next.TwoFactor.UseStep(ctx, a1, a2)
+}
+
+func (s faultyTwoFactor) UseRecoveryCode(ctx context.Context, a1 int64, a2 string) (r0 bool, err error) {
+	if err = s.faults.inject(ctx, "TwoFactor.UseRecoveryCode"); err != nil {
+		return
+	}
+	return s.next.TwoFactor.UseRecoveryCode(ctx, a1, a2)
+}
+
+func (s faultyTwoFactor) Disable(ctx context.Context, a1 int64) (err error) {
+	if err = s.faults.inject(ctx, "TwoFactor.Disable"); err != nil {
+		return
+	}
+	return s.next.TwoFactor.Disable(ctx, a1)
+}
+
+type faultyPushTokens struct{ *faultyStorage }
+
+func (s faultyPushTokens) Upsert(ctx context.Context, a1 *PushToken) (err error) {
+	if err = s.faults.inject(ctx, "PushTokens.Upsert"); err != nil {
+		return
+	}
+	return s.next.PushTokens.Upsert(ctx, a1)
+}
+
+func (s faultyPushTokens) Delete(ctx context.Context, a1 int64, a2 int64) (err error) {
+	if err = s.faults.inject(ctx, "PushTokens.Delete"); err != nil {
+		return
+	}
+	return s.next.PushTokens.Delete(ctx, a1, a2)
+}
+
+func (s faultyPushTokens) ListActiveForUsers(ctx context.Context, a1 []int64) (r0 []*PushToken, err error) {
+	if err = s.faults.inject(ctx, "PushTokens.ListActiveForUsers"); err != nil {
+		return
+	}
+	return s.next.PushTokens.ListActiveForUsers(ctx, a1)
+}
+
+func (s faultyPushTokens) RecordFailure(ctx context.Context, a1 int64, a2 int) (r0 bool, err error) {
+	if err = s.faults.inject(ctx, "PushTokens.RecordFailure"); err != nil {
+		return
+	}
+	return s.next.PushTokens.RecordFailure(ctx, a1, a2)
+}
+
+func (s faultyPushTokens) RecordSuccess(ctx context.Context, a1 int64) (err error) {
+	if err = s.faults.inject(ctx, "PushTokens.RecordSuccess"); err != nil {
+		return
+	}
+	return s.next.PushTokens.RecordSuccess(ctx, a1)
+}
+
+type faultyAttachments struct{ *faultyStorage }
+
+func (s faultyAttachments) Add(ctx context.Context, a1 *Attachment, a2 func() error) (err error) {
+	if err = s.faults.inject(ctx, "Attachments.Add"); err != nil {
+		return
+	}
+	return s.next.Attachments.Add(ctx, a1, a2)
+}
+
+func (s faultyAttachments) GetByID(ctx context.Context, a1 int64, a2 int64) (r0 *Attachment, err error) {
+	if err = s.faults.inject(ctx, "Attachments.GetByID"); err != nil {
+		return
+	}
+	return s.next.Attachments.GetByID(ctx, a1, a2)
+}
+
+func (s faultyAttachments) FinishThumbnail(ctx context.Context, a1 int64, a2 bool) (r0 []*Attachment, err error) {
+	if err = s.faults.inject(ctx, "Attachments.FinishThumbnail"); err != nil {
+		return
+	}
+	return s.next.Attachments.FinishThumbnail(ctx, a1, a2)
+}
+
+func (s faultyAttachments) PendingThumbnails(ctx context.Context) (r0 map[int64]string, err error) {
+	if err = s.faults.inject(ctx, "Attachments.PendingThumbnails"); err != nil {
+		return
+	}
+	return s.next.Attachments.PendingThumbnails(ctx)
+}
+
+func (s faultyAttachments) Remove(ctx context.Context, a1 int64, a2 int64, a3 func(string) error) (err error) {
+	if err = s.faults.inject(ctx, "Attachments.Remove"); err != nil {
+		return
+	}
+	return s.next.Attachments.Remove(ctx, a1, a2, a3)
+}
+
+func (s faultyAttachments) Stats(ctx context.Context) (r0 *StorageStats, err error) {
+	if err = s.faults.inject(ctx, "Attachments.Stats"); err != nil {
+		return
+	}
+	return s.next.Attachments.Stats(ctx)
+}
+
+func (s faultyAttachments) Search(ctx context.Context, a1 int64, a2 string, a3 int) (r0 []*Attachment, err error) {
+	if err = s.faults.inject(ctx, "Attachments.Search"); err != nil {
+		return
+	}
+	return s.next.Attachments.Search(ctx, a1, a2, a3)
+}
+
+type faultyTranslations struct{ *faultyStorage }
+
+func (s faultyTranslations) Get(ctx context.Context, a1 int64, a2 string, a3 string) (r0 *MessageTranslation, err error) {
+	if err = s.faults.inject(ctx, "Translations.Get"); err != nil {
+		return
+	}
+	return s.next.Translations.Get(ctx, a1, a2, a3)
+}
+
+func (s faultyTranslations) Save(ctx context.Context, a1 *MessageTranslation) (err error) {
+	if err = s.faults.inject(ctx, "Translations.Save"); err != nil {
+		return
+	}
+	return s.next.Translations.Save(ctx, a1)
+}
+
+func (s faultyTranslations) Invalidate(ctx context.Context, a1 int64) (err error) {
+	if err = s.faults.inject(ctx, "Translations.Invalidate"); err != nil {
+		return
+	}
+	return s.next.Translations.Invalidate(ctx, a1)
+}
+
+type faultyReadMarkers struct{ *faultyStorage }
+
+func (s faultyReadMarkers) MarkRead(ctx context.Context, a1 int64, a2 int64, a3 int64, a4 int64) (err error) {
+	if err = s.faults.inject(ctx, "ReadMarkers.MarkRead"); err != nil {
+		return
+	}
+	return s.next.ReadMarkers.MarkRead(ctx, a1, a2, a3, a4)
+}
+
+func (s faultyReadMarkers) GetUnreadCount(ctx context.Context, a1 int64, a2 int64) (r0 int, err error) {
+	if err = s.faults.inject(ctx, "ReadMarkers.GetUnreadCount"); err != nil {
+		return
+	}
+	return s.next.ReadMarkers.GetUnreadCount(ctx, a1, a2)
+}
+
+func (s faultyReadMarkers) GetSyncState(ctx context.Context, a1 int64) (r0 []*RoomSyncState, err error) {
+	if err = s.faults.inject(ctx, "ReadMarkers.GetSyncState"); err != nil {
+		return
+	}
+	return s.next.ReadMarkers.GetSyncState(ctx, a1)
+}
+
+type faultyJoinRequests struct{ *faultyStorage }
+
+func (s faultyJoinRequests) Knock(ctx context.Context, a1 int64, a2 int64, a3 time.Duration) (r0 *JoinRequest, r1 bool, err error) {
+	if err = s.faults.inject(ctx, "JoinRequests.Knock"); err != nil {
+		return
+	}
+	return s.next.JoinRequests.Knock(ctx, a1, a2, a3)
+}
+
+func (s faultyJoinRequests) ListPending(ctx context.Context, a1 int64) (r0 []*JoinRequest, err error) {
+	if err = s.faults.inject(ctx, "JoinRequests.ListPending"); err != nil {
+		return
+	}
+	return s.next.JoinRequests.ListPending(ctx, a1)
+}
+
+func (s faultyJoinRequests) Approve(ctx context.Context, a1 int64, a2 int64, a3 int64) (err error) {
+	if err = s.faults.inject(ctx, "JoinRequests.Approve"); err != nil {
+		return
+	}
+	return s.next.JoinRequests.Approve(ctx, a1, a2, a3)
+}
+
+func (s faultyJoinRequests) Reject(ctx context.Context, a1 int64, a2 int64, a3 int64) (err error) {
+	if err = s.faults.inject(ctx, "JoinRequests.Reject"); err != nil {
+		return
+	}
+	return s.next.JoinRequests.Reject(ctx, a1, a2, a3)
+}
+
+type faultyEmailInvites struct{ *faultyStorage }
+
+func (s faultyEmailInvites) Create(ctx context.Context, a1 *EmailInvite, a2 string, a3 time.Duration) (err error) {
+	if err = s.faults.inject(ctx, "EmailInvites.Create"); err != nil {
+		return
+	}
+	return s.next.EmailInvites.Create(ctx, a1, a2, a3)
+}
+
+type faultyOAuthIdentities struct{ *faultyStorage }
+
+func (s faultyOAuthIdentities) GetUserID(ctx context.Context, a1 string, a2 string) (r0 int64, err error) {
+	if err = s.faults.inject(ctx, "OAuthIdentities.GetUserID"); err != nil {
+		return
+	}
+	return s.next.OAuthIdentities.GetUserID(ctx, a1, a2)
+}
+
+func (s faultyOAuthIdentities) Link(ctx context.Context, a1 *OAuthIdentity) (err error) {
+	if err = s.faults.inject(ctx, "OAuthIdentities.Link"); err != nil {
+		return
+	}
+	return s.next.OAuthIdentities.Link(ctx, a1)
+}
+
+type faultyPasswordResets struct{ *faultyStorage }
+
+func (s faultyPasswordResets) Create(ctx context.Context, a1 int64, a2 string, a3 time.Duration) (r0 time.Time, err error) {
+	if err = s.faults.inject(ctx, "PasswordResets.Create"); err != nil {
+		return
+	}
+	return s.next.PasswordResets.Create(ctx, a1, a2, a3)
+}
+
+func (s faultyPasswordResets) GetUserID(ctx context.Context, a1 string) (r0 int64, err error) {
+	if err = s.faults.inject(ctx, "PasswordResets.GetUserID"); err != nil {
+		return
+	}
+	return s.next.PasswordResets.GetUserID(ctx, a1)
+}
+
+func (s faultyPasswordResets) Consume(ctx context.Context, a1 string, a2 string) (r0 []int64, err error) {
+	if err = s.faults.inject(ctx, "PasswordResets.Consume"); err != nil {
+		return
+	}
+	return s.next.PasswordResets.Consume(ctx, a1, a2)
+}
+
+func (s faultyPasswordResets) PurgeExpired(ctx context.Context, a1 time.Time) (r0 int64, err error) {
+	if err = s.faults.inject(ctx, "PasswordResets.PurgeExpired"); err != nil {
+		return
+	}
+	return s.next.PasswordResets.PurgeExpired(ctx, a1)
+}
+
+type faultyRoomInvites struct{ *faultyStorage }
+
+func (s faultyRoomInvites) Create(ctx context.Context, a1 *RoomInvite, a2 time.Duration) (err error) {
+	if err = s.faults.inject(ctx, "RoomInvites.Create"); err != nil {
+		return
+	}
+	return s.next.RoomInvites.Create(ctx, a1, a2)
+}
+
+func (s faultyRoomInvites) ListPending(ctx context.Context, a1 int64) (r0 []*RoomInvite, err error) {
+	if err = s.faults.inject(ctx, "RoomInvites.ListPending"); err != nil {
+		return
+	}
+	return s.next.RoomInvites.ListPending(ctx, a1)
+}
+
+func (s faultyRoomInvites) Respond(ctx context.Context, a1 int64, a2 int64, a3 bool) (r0 *RoomInvite, r1 bool, err error) {
+	if err = s.faults.inject(ctx, "RoomInvites.Respond"); err != nil {
+		return
+	}
+	return s.next.RoomInvites.Respond(ctx, a1, a2, a3)
+}
+
+type faultyReceipts struct{ *faultyStorage }
+
+func (s faultyReceipts) MarkDelivered(ctx context.Context, a1 []DeliveryMark) (err error) {
+	if err = s.faults.inject(ctx, "Receipts.MarkDelivered"); err != nil {
+		return
+	}
+	return s.next.Receipts.MarkDelivered(ctx, a1)
+}
+
+func (s faultyReceipts) MarkDeliveredLatest(ctx context.Context, a1 int64, a2 int64) (err error) {
+	if err = s.faults.inject(ctx, "Receipts.MarkDeliveredLatest"); err != nil {
+		return
+	}
+	return s.next.Receipts.MarkDeliveredLatest(ctx, a1, a2)
+}
+
+func (s faultyReceipts) GetReceipts(ctx context.Context, a1 int64, a2 int64) (r0 *MessageReceipts, err error) {
+	if err = s.faults.inject(ctx, "Receipts.GetReceipts"); err != nil {
+		return
+	}
+	return s.next.Receipts.GetReceipts(ctx, a1, a2)
+}
+
+type faultyExports struct{ *faultyStorage }
+
+func (s faultyExports) CreateJob(ctx context.Context, a1 int64, a2 time.Time) (r0 *ExportJob, err error) {
+	if err = s.faults.inject(ctx, "Exports.CreateJob"); err != nil {
+		return
+	}
+	return s.next.Exports.CreateJob(ctx, a1, a2)
+}
+
+func (s faultyExports) CompleteJob(ctx context.Context, a1 int64, a2 string) (err error) {
+	if err = s.faults.inject(ctx, "Exports.CompleteJob"); err != nil {
+		return
+	}
+	return s.next.Exports.CompleteJob(ctx, a1, a2)
+}
+
+func (s faultyExports) FailJob(ctx context.Context, a1 int64, a2 string) (err error) {
+	if err = s.faults.inject(ctx, "Exports.FailJob"); err != nil {
+		return
+	}
+	return s.next.Exports.FailJob(ctx, a1, a2)
+}
+
+func (s faultyExports) GetJob(ctx context.Context, a1 int64, a2 int64) (r0 *ExportJob, err error) {
+	if err = s.faults.inject(ctx, "Exports.GetJob"); err != nil {
+		return
+	}
+	return s.next.Exports.GetJob(ctx, a1, a2)
+}
+
+func (s faultyExports) CountUserMessages(ctx context.Context, a1 int64) (r0 int, err error) {
+	if err = s.faults.inject(ctx, "Exports.CountUserMessages"); err != nil {
+		return
+	}
+	return s.next.Exports.CountUserMessages(ctx, a1)
+}
+
+func (s faultyExports) GetUserMemberships(ctx context.Context, a1 int64) (r0 []*ExportedMembership, err error) {
+	if err = s.faults.inject(ctx, "Exports.GetUserMemberships"); err != nil {
+		return
+	}
+	return s.next.Exports.GetUserMemberships(ctx, a1)
+}
+
+func (s faultyExports) GetUserJoinRequests(ctx context.Context, a1 int64) (r0 []*JoinRequest, err error) {
+	if err = s.faults.inject(ctx, "Exports.GetUserJoinRequests"); err != nil {
+		return
+	}
+	return s.next.Exports.GetUserJoinRequests(ctx, a1)
+}
+
+func (s faultyExports) GetUserDevices(ctx context.Context, a1 int64) (r0 []*Device, err error) {
+	if err = s.faults.inject(ctx, "Exports.GetUserDevices"); err != nil {
+		return
+	}
+	return s.next.Exports.GetUserDevices(ctx, a1)
+}
+
+func (s faultyExports) GetUserPosts(ctx context.Context, a1 int64) (r0 []*Post, err error) {
+	if err = s.faults.inject(ctx, "Exports.GetUserPosts"); err != nil {
+		return
+	}
+	return s.next.Exports.GetUserPosts(ctx, a1)
+}
+
+func (s faultyExports) StreamUserMessages(ctx context.Context, a1 int64, a2 func(*ExportedMessage) error) (err error) {
+	if err = s.faults.inject(ctx, "Exports.StreamUserMessages"); err != nil {
+		return
+	}
+	return s.next.Exports.StreamUserMessages(ctx, a1, a2)
+}
+
+type faultyRoomTemplates struct{ *faultyStorage }
+
+func (s faultyRoomTemplates) Create(ctx context.Context, a1 *RoomTemplate) (err error) {
+	if err = s.faults.inject(ctx, "RoomTemplates.Create"); err != nil {
+		return
+	}
+	return s.next.RoomTemplates.Create(ctx, a1)
+}
+
+func (s faultyRoomTemplates) GetByID(ctx context.Context, a1 int64, a2 int64) (r0 *RoomTemplate, err error) {
+	if err = s.faults.inject(ctx, "RoomTemplates.GetByID"); err != nil {
+		return
+	}
+	return s.next.RoomTemplates.GetByID(ctx, a1, a2)
+}
+
+func (s faultyRoomTemplates) ListForOwner(ctx context.Context, a1 int64) (r0 []*RoomTemplate, err error) {
+	if err = s.faults.inject(ctx, "RoomTemplates.ListForOwner"); err != nil {
+		return
+	}
+	return s.next.RoomTemplates.ListForOwner(ctx, a1)
+}
+
+type faultyRoomPermissions struct{ *faultyStorage }
+
+func (s faultyRoomPermissions) Get(ctx context.Context, a1 int64) (r0 RoomPermissions, err error) {
+	if err = s.faults.inject(ctx, "RoomPermissions.Get"); err != nil {
+		return
+	}
+	return s.next.RoomPermissions.Get(ctx, a1)
+}
+
+func (s faultyRoomPermissions) GetAccess(ctx context.Context, a1 int64, a2 int64) (r0 *RoomAccess, err error) {
+	if err = s.faults.inject(ctx, "RoomPermissions.GetAccess"); err != nil {
+		return
+	}
+	return s.next.RoomPermissions.GetAccess(ctx, a1, a2)
+}
+
+func (s faultyRoomPermissions) Update(ctx context.Context, a1 int64, a2 RoomPermissions) (r0 RoomPermissions, err error) {
+	if err = s.faults.inject(ctx, "RoomPermissions.Update"); err != nil {
+		return
+	}
+	return s.next.RoomPermissions.Update(ctx, a1, a2)
+}
+
+type faultyModerationHooks struct{ *faultyStorage }
+
+func (s faultyModerationHooks) Get(ctx context.Context, a1 int64) (r0 *ModerationHook, err error) {
+	if err = s.faults.inject(ctx, "ModerationHooks.Get"); err != nil {
+		return
+	}
+	return s.next.ModerationHooks.Get(ctx, a1)
+}
+
+func (s faultyModerationHooks) Save(ctx context.Context, a1 *ModerationHook) (err error) {
+	if err = s.faults.inject(ctx, "ModerationHooks.Save"); err != nil {
+		return
+	}
+	return s.next.ModerationHooks.Save(ctx, a1)
+}
+
+func (s faultyModerationHooks) Disable(ctx context.Context, a1 int64, a2 string) (err error) {
+	if err = s.faults.inject(ctx, "ModerationHooks.Disable"); err != nil {
+		return
+	}
+	return s.next.ModerationHooks.Disable(ctx, a1, a2)
+}
+
+func (s faultyModerationHooks) Delete(ctx context.Context, a1 int64) (err error) {
+	if err = s.faults.inject(ctx, "ModerationHooks.Delete"); err != nil {
+		return
+	}
+	return s.next.ModerationHooks.Delete(ctx, a1)
+}
+
+type faultyOutgoingWebhooks struct{ *faultyStorage }
+
+func (s faultyOutgoingWebhooks) Create(ctx context.Context, a1 *OutgoingWebhook) (err error) {
+	if err = s.faults.inject(ctx, "OutgoingWebhooks.Create"); err != nil {
+		return
+	}
+	return s.next.OutgoingWebhooks.Create(ctx, a1)
+}
+
+func (s faultyOutgoingWebhooks) Get(ctx context.Context, a1 int64, a2 int64) (r0 *OutgoingWebhook, err error) {
+	if err = s.faults.inject(ctx, "OutgoingWebhooks.Get"); err != nil {
+		return
+	}
+	return s.next.OutgoingWebhooks.Get(ctx, a1, a2)
+}
+
+func (s faultyOutgoingWebhooks) ListForRoom(ctx context.Context, a1 int64) (r0 []*OutgoingWebhook, err error) {
+	if err = s.faults.inject(ctx, "OutgoingWebhooks.ListForRoom"); err != nil {
+		return
+	}
+	return s.next.OutgoingWebhooks.ListForRoom(ctx, a1)
+}
+
+func (s faultyOutgoingWebhooks) ListEnabled(ctx context.Context) (r0 []*OutgoingWebhook, err error) {
+	if err = s.faults.inject(ctx, "OutgoingWebhooks.ListEnabled"); err != nil {
+		return
+	}
+	return s.next.OutgoingWebhooks.ListEnabled(ctx)
+}
+
+func (s faultyOutgoingWebhooks) Delete(ctx context.Context, a1 int64, a2 int64) (err error) {
+	if err = s.faults.inject(ctx, "OutgoingWebhooks.Delete"); err != nil {
+		return
+	}
+	return s.next.OutgoingWebhooks.Delete(ctx, a1, a2)
+}
+
+func (s faultyOutgoingWebhooks) AdvanceSeq(ctx context.Context, a1 int64, a2 int64, a3 int64) (r0 bool, err error) {
+	if err = s.faults.inject(ctx, "OutgoingWebhooks.AdvanceSeq"); err != nil {
+		return
+	}
+	return s.next.OutgoingWebhooks.AdvanceSeq(ctx, a1, a2, a3)
+}
+
+func (s faultyOutgoingWebhooks) SkipEvents(ctx context.Context, a1 int64) (err error) {
+	if err = s.faults.inject(ctx, "OutgoingWebhooks.SkipEvents"); err != nil {
+		return
+	}
+	return s.next.OutgoingWebhooks.SkipEvents(ctx, a1)
+}
+
+func (s faultyOutgoingWebhooks) RecordDelivery(ctx context.Context, a1 *OutgoingWebhookDelivery) (r0 int, err error) {
+	if err = s.faults.inject(ctx, "OutgoingWebhooks.RecordDelivery"); err != nil {
+		return
+	}
+	return s.next.OutgoingWebhooks.RecordDelivery(ctx, a1)
+}
+
+func (s faultyOutgoingWebhooks) Disable(ctx context.Context, a1 int64, a2 string) (r0 bool, err error) {
+	if err = s.faults.inject(ctx, "OutgoingWebhooks.Disable"); err != nil {
+		return
+	}
+	return s.next.OutgoingWebhooks.Disable(ctx, a1, a2)
+}
+
+func (s faultyOutgoingWebhooks) ListDeliveries(ctx context.Context, a1 int64, a2 int) (r0 []*OutgoingWebhookDelivery, err error) {
+	if err = s.faults.inject(ctx, "OutgoingWebhooks.ListDeliveries"); err != nil {
+		return
+	}
+	return s.next.OutgoingWebhooks.ListDeliveries(ctx, a1, a2)
+}
+
+type faultyFeatureFlags struct{ *faultyStorage }
+
+func (s faultyFeatureFlags) List(ctx context.Context) (r0 []*FeatureFlag, err error) {
+	if err = s.faults.inject(ctx, "FeatureFlags.List"); err != nil {
+		return
+	}
+	return s.next.FeatureFlags.List(ctx)
+}
+
+func (s faultyFeatureFlags) Save(ctx context.Context, a1 *FeatureFlag) (err error) {
+	if err = s.faults.inject(ctx, "FeatureFlags.Save"); err != nil {
+		return
+	}
+	return s.next.FeatureFlags.Save(ctx, a1)
+}
+
+type faultyReports struct{ *faultyStorage }
+
+func (s faultyReports) Create(ctx context.Context, a1 *Report) (err error) {
+	if err = s.faults.inject(ctx, "Reports.Create"); err != nil {
+		return
+	}
+	return s.next.Reports.Create(ctx, a1)
+}
+
+func (s faultyReports) ListForRoom(ctx context.Context, a1 int64, a2 int, a3 int) (r0 []*Report, err error) {
+	if err = s.faults.inject(ctx, "Reports.ListForRoom"); err != nil {
+		return
+	}
+	return s.next.Reports.ListForRoom(ctx, a1, a2, a3)
+}
+
+func (s faultyReports) List(ctx context.Context, a1 string, a2 int, a3 int) (r0 []*Report, err error) {
+	if err = s.faults.inject(ctx, "Reports.List"); err != nil {
+		return
+	}
+	return s.next.Reports.List(ctx, a1, a2, a3)
+}
+
+func (s faultyReports) UpdateStatus(ctx context.Context, a1 int64, a2 string, a3 string, a4 string) (r0 *Report, err error) {
+	if err = s.faults.inject(ctx, "Reports.UpdateStatus"); err != nil {
+		return
+	}
+	return s.next.Reports.UpdateStatus(ctx, a1, a2, a3, a4)
+}
+
+type faultyRedactions struct{ *faultyStorage }
+
+func (s faultyRedactions) Redact(ctx context.Context, a1 int64, a2 string, a3 string) (r0 *Redaction, err error) {
+	if err = s.faults.inject(ctx, "Redactions.Redact"); err != nil {
+		return
+	}
+	return s.next.Redactions.Redact(ctx, a1, a2, a3)
+}
+
+func (s faultyRedactions) ListMessages(ctx context.Context, a1 AdminMessageQuery) (r0 []*AdminMessage, err error) {
+	if err = s.faults.inject(ctx, "Redactions.ListMessages"); err != nil {
+		return
+	}
+	return s.next.Redactions.ListMessages(ctx, a1)
+}
+
+// withFaults wraps every store of next so its methods go through faults first
+func withFaults(next Storage, faults *Faults) Storage {
+	s := &faultyStorage{faults: faults, next: next}
+	return Storage{
+		Posts:                   faultyPosts{s},
+		Users:                   faultyUsers{s},
+		Rooms:                   faultyRooms{s},
+		Messages:                faultyMessages{s},
+		RoomMembers:             faultyRoomMembers{s},
+		MembershipEvents:        faultyMembershipEvents{s},
+		Pins:                    faultyPins{s},
+		RoomEvents:              faultyRoomEvents{s},
+		UserEvents:              faultyUserEvents{s},
+		Digests:                 faultyDigests{s},
+		NotificationPreferences: faultyNotificationPreferences{s},
+		Devices:                 faultyDevices{s},
+		APITokens:               faultyAPITokens{s},
+		APIKeys:                 faultyAPIKeys{s},
+		Sessions:                faultySessions{s},
+		TwoFactor:               faultyTwoFactor{s},
+		PushTokens:              faultyPushTokens{s},
+		Attachments:             faultyAttachments{s},
+		Translations:            faultyTranslations{s},
+		ReadMarkers:             faultyReadMarkers{s},
+		JoinRequests:            faultyJoinRequests{s},
+		EmailInvites:            faultyEmailInvites{s},
+		OAuthIdentities:         faultyOAuthIdentities{s},
+		PasswordResets:          faultyPasswordResets{s},
+		RoomInvites:             faultyRoomInvites{s},
+		Receipts:                faultyReceipts{s},
+		Exports:                 faultyExports{s},
+		RoomTemplates:           faultyRoomTemplates{s},
+		RoomPermissions:         faultyRoomPermissions{s},
+		ModerationHooks:         faultyModerationHooks{s},
+		OutgoingWebhooks:        faultyOutgoingWebhooks{s},
+		FeatureFlags:            faultyFeatureFlags{s},
+		Reports:                 faultyReports{s},
+		Redactions:              faultyRedactions{s},
+	}
+}
+
+// faultMethods are the methods a fault can be set on, as Field.Method
+var faultMethods = map[string]bool{
+	"Posts.Create":                        true,
+	"Posts.GetByID":                       true,
+	"Posts.List":                          true,
+	"Posts.Update":                        true,
+	"Posts.Delete":                        true,
+	"Users.Create":                        true,
+	"Users.CreateWithDefaultRooms":        true,
+	"Users.EnsureSystemUser":              true,
+	"Users.GetByEmail":                    true,
+	"Users.GetByID":                       true,
+	"Users.GetByUsernames":                true,
+	"Users.GetByEmails":                   true,
+	"Users.GetByUsername":                 true,
+	"Users.Search":                        true,
+	"Users.UpdateProfile":                 true,
+	"Users.Delete":                        true,
+	"Users.GetRole":                       true,
+	"Users.SetRole":                       true,
+	"Users.IsBanned":                      true,
+	"Users.Ban":                           true,
+	"Users.Unban":                         true,
+	"Users.GetTokenVersion":               true,
+	"Users.RevokeTokens":                  true,
+	"Rooms.Create":                        true,
+	"Rooms.CreateWithMembers":             true,
+	"Rooms.GetByID":                       true,
+	"Rooms.GetByName":                     true,
+	"Rooms.IsContentFilterEnabled":        true,
+	"Rooms.IsDuplicateLimitEnabled":       true,
+	"Rooms.GetQuietHours":                 true,
+	"Rooms.GetLanguage":                   true,
+	"Rooms.GetPostPolicy":                 true,
+	"Rooms.List":                          true,
+	"Rooms.GetUserRooms":                  true,
+	"Rooms.GetUserRoomSummaries":          true,
+	"Rooms.SetDefault":                    true,
+	"Rooms.Recommend":                     true,
+	"Rooms.ListTags":                      true,
+	"Rooms.Merge":                         true,
+	"Rooms.Update":                        true,
+	"Rooms.CountCreatedSince":             true,
+	"Rooms.CountOwnedActive":              true,
+	"Rooms.SoftDelete":                    true,
+	"Rooms.GetDeletedByID":                true,
+	"Rooms.Restore":                       true,
+	"Rooms.PurgeExpired":                  true,
+	"Rooms.ListRetained":                  true,
+	"Rooms.Delete":                        true,
+	"Messages.Create":                     true,
+	"Messages.GetRoomMessages":            true,
+	"Messages.GetMessagesBefore":          true,
+	"Messages.GetMessagesAround":          true,
+	"Messages.Histogram":                  true,
+	"Messages.FirstMessageAt":             true,
+	"Messages.GetMessagesSince":           true,
+	"Messages.CountRecentIdentical":       true,
+	"Messages.CountInRoom":                true,
+	"Messages.GetByID":                    true,
+	"Messages.PurgeRoomExpired":           true,
+	"Messages.Search":                     true,
+	"RoomMembers.Join":                    true,
+	"RoomMembers.JoinWithOptions":         true,
+	"RoomMembers.JoinIfAbsent":            true,
+	"RoomMembers.Leave":                   true,
+	"RoomMembers.IsUserInRoom":            true,
+	"RoomMembers.IsRoomAdmin":             true,
+	"RoomMembers.SetRole":                 true,
+	"RoomMembers.GetRoomAdmins":           true,
+	"RoomMembers.GetRoomMembers":          true,
+	"RoomMembers.GetRoomMemberCount":      true,
+	"RoomMembers.GetUserRoomCount":        true,
+	"RoomMembers.FindMembersByUsername":   true,
+	"RoomMembers.SearchMembers":           true,
+	"RoomMembers.GetMutual":               true,
+	"RoomMembers.AddMembers":              true,
+	"RoomMembers.RemoveMembers":           true,
+	"MembershipEvents.List":               true,
+	"Pins.Pin":                            true,
+	"Pins.Unpin":                          true,
+	"Pins.List":                           true,
+	"Pins.Reorder":                        true,
+	"Pins.Search":                         true,
+	"Pins.Count":                          true,
+	"RoomEvents.ListAfter":                true,
+	"RoomEvents.PurgeOlderThan":           true,
+	"UserEvents.Append":                   true,
+	"UserEvents.ListAfter":                true,
+	"UserEvents.PurgeOlderThan":           true,
+	"Digests.GetSettings":                 true,
+	"Digests.UpdateSettings":              true,
+	"Digests.Timezones":                   true,
+	"Digests.ClaimDue":                    true,
+	"Digests.LoadRecipients":              true,
+	"Digests.Finish":                      true,
+	"NotificationPreferences.Get":         true,
+	"NotificationPreferences.GetForUsers": true,
+	"NotificationPreferences.Update":      true,
+	"Devices.Create":                      true,
+	"Devices.Touch":                       true,
+	"Devices.PruneInactive":               true,
+	"APITokens.Create":                    true,
+	"APITokens.List":                      true,
+	"APITokens.GetByHash":                 true,
+	"APITokens.Touch":                     true,
+	"APITokens.Revoke":                    true,
+	"APIKeys.Create":                      true,
+	"APIKeys.List":                        true,
+	"APIKeys.GetByHash":                   true,
+	"APIKeys.Touch":                       true,
+	"APIKeys.Revoke":                      true,
+	"Sessions.Create":                     true,
+	"Sessions.GetByID":                    true,
+	"Sessions.ListActive":                 true,
+	"Sessions.Touch":                      true,
+	"Sessions.Revoke":                     true,
+	"Sessions.PurgeInactive":              true,
+	"TwoFactor.Get":                       true,
+	"TwoFactor.Setup":                     true,
+	"TwoFactor.Enable":                    true,
+	"TwoFactor.UseStep":                   true,
+	"TwoFactor.UseRecoveryCode":           true,
+	"TwoFactor.Disable":                   true,
+	"PushTokens.Upsert":                   true,
+	"PushTokens.Delete":                   true,
+	"PushTokens.ListActiveForUsers":       true,
+	"PushTokens.RecordFailure":            true,
+	"PushTokens.RecordSuccess":            true,
+	"Attachments.Add":                     true,
+	"Attachments.GetByID":                 true,
+	"Attachments.FinishThumbnail":         true,
+	"Attachments.PendingThumbnails":       true,
+	"Attachments.Remove":                  true,
+	"Attachments.Stats":                   true,
+	"Attachments.Search":                  true,
+	"Translations.Get":                    true,
+	"Translations.Save":                   true,
+	"Translations.Invalidate":             true,
+	"ReadMarkers.MarkRead":                true,
+	"ReadMarkers.GetUnreadCount":          true,
+	"ReadMarkers.GetSyncState":            true,
+	"JoinRequests.Knock":                  true,
+	"JoinRequests.ListPending":            true,
+	"JoinRequests.Approve":                true,
+	"JoinRequests.Reject":                 true,
+	"EmailInvites.Create":                 true,
+	"OAuthIdentities.GetUserID":           true,
+	"OAuthIdentities.Link":                true,
+	"PasswordResets.Create":               true,
+	"PasswordResets.GetUserID":            true,
+	"PasswordResets.Consume":              true,
+	"PasswordResets.PurgeExpired":         true,
+	"RoomInvites.Create":                  true,
+	"RoomInvites.ListPending":             true,
+	"RoomInvites.Respond":                 true,
+	"Receipts.MarkDelivered":              true,
+	"Receipts.MarkDeliveredLatest":        true,
+	"Receipts.GetReceipts":                true,
+	"Exports.CreateJob":                   true,
+	"Exports.CompleteJob":                 true,
+	"Exports.FailJob":                     true,
+	"Exports.GetJob":                      true,
+	"Exports.CountUserMessages":           true,
+	"Exports.GetUserMemberships":          true,
+	"Exports.GetUserJoinRequests":         true,
+	"Exports.GetUserDevices":              true,
+	"Exports.GetUserPosts":                true,
+	"Exports.StreamUserMessages":          true,
+	"RoomTemplates.Create":                true,
+	"RoomTemplates.GetByID":               true,
+	"RoomTemplates.ListForOwner":          true,
+	"RoomPermissions.Get":                 true,
+	"RoomPermissions.GetAccess":           true,
+	"RoomPermissions.Update":              true,
+	"ModerationHooks.Get":                 true,
+	"ModerationHooks.Save":                true,
+	"ModerationHooks.Disable":             true,
+	"ModerationHooks.Delete":              true,
+	"OutgoingWebhooks.Create":             true,
+	"OutgoingWebhooks.Get":                true,
+	"OutgoingWebhooks.ListForRoom":        true,
+	"OutgoingWebhooks.ListEnabled":        true,
+	"OutgoingWebhooks.Delete":             true,
+	"OutgoingWebhooks.AdvanceSeq":         true,
+	"OutgoingWebhooks.SkipEvents":         true,
+	"OutgoingWebhooks.RecordDelivery":     true,
+	"OutgoingWebhooks.Disable":            true,
+	"OutgoingWebhooks.ListDeliveries":     true,
+	"FeatureFlags.List":                   true,
+	"FeatureFlags.Save":                   true,
+	"Reports.Create":                      true,
+	"Reports.ListForRoom":                 true,
+	"Reports.List":                        true,
+	"Reports.UpdateStatus":                true,
+	"Redactions.Redact":                   true,
+	"Redactions.ListMessages":             true,
+}
@@ -0,0 +1,72 @@
+//go:build faults
+
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestFaults fails every second post deletion, then slows one down past its
+// deadline: failed calls never reach the database, the others do, and a call
+// cut short holds no connection. Unknown methods are refused, and with the
+// faults cleared every call goes through
+func TestFaults(t *testing.T) {
+	db, mock := newMockDB(t)
+	faults := NewFaults()
+	st := WithFaults(Storage{Posts: &PostStore{db}}, faults)
+	ctx := context.Background()
+
+	if FaultsOf(st) != faults || FaultsOf(Storage{Posts: &PostStore{db}}) != nil {
+		t.Error("FaultsOf didn't find the injector, or found one that isn't there")
+	}
+	if err := faults.Set("Posts.Archive", Fault{Err: sql.ErrConnDone}); !errors.Is(err, ErrUnknownFaultMethod) {
+		t.Errorf("a fault on an unknown method got %v, want ErrUnknownFaultMethod", err)
+	}
+
+	if err := faults.Set("Posts.Delete", Fault{Err: sql.ErrConnDone, EveryNth: 2}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		if i%2 == 1 {
+			mock.ExpectExec(`DELETE FROM posts WHERE id = \$1`).WithArgs(int64(i)).WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		err := st.Posts.Delete(ctx, int64(i))
+		if i%2 == 0 && !errors.Is(err, sql.ErrConnDone) {
+			t.Errorf("call %d got %v, want the injected error", i, err)
+		}
+		if i%2 == 1 && err != nil {
+			t.Errorf("call %d got %v, want it to reach the database", i, err)
+		}
+	}
+	if status := faults.List()["Posts.Delete"]; status.Calls != 4 || status.Hits != 2 {
+		t.Errorf("the fault saw %d calls and hit %d, want 4 and 2", status.Calls, status.Hits)
+	}
+
+	if err := faults.Set("Posts.Delete", Fault{Latency: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	deadline, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := st.Posts.Delete(deadline, 5); !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 5*time.Second {
+		t.Errorf("a call slowed past its deadline got %v after %s, want DeadlineExceeded at the deadline", err, time.Since(start))
+	}
+	if inUse := db.Stats().InUse; inUse != 0 {
+		t.Errorf("%d connections are in use after the call was cut short", inUse)
+	}
+
+	faults.Reset()
+	if len(faults.List()) != 0 {
+		t.Errorf("faults are still set after a reset: %v", faults.List())
+	}
+	mock.ExpectExec(`DELETE FROM posts WHERE id = \$1`).WithArgs(int64(6)).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := st.Posts.Delete(ctx, 6); err != nil {
+		t.Errorf("a call with the faults cleared got %v", err)
+	}
+}
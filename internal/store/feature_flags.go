@@ -0,0 +1,138 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrOverrideTargetNotFound is returned when a flag override names a user or
+// room that doesn't exist
+var ErrOverrideTargetNotFound = errors.New("feature flag override names an unknown user or room")
+
+// FeatureFlag is a feature's rollout state: on or off by default, with
+// overrides for single users and rooms (see internal/flags for how they combine)
+type FeatureFlag struct {
+	Name string `json:"name"`
+
+	// Enabled is the default for users and rooms without an override
+	Enabled bool `json:"enabled"`
+
+	// Overrides keyed by user or room ID; true turns the feature on, false off
+	UserOverrides map[int64]bool `json:"user_overrides"`
+	RoomOverrides map[int64]bool `json:"room_overrides"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FeatureFlagStore handles database operations for feature flags
+type FeatureFlagStore struct {
+	db *sql.DB
+}
+
+// List returns every stored flag with its overrides, ordered by name
+// The table is small and read as a whole into the flags cache, so there's no
+// lookup by name
+func (s *FeatureFlagStore) List(ctx context.Context) ([]*FeatureFlag, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT name, enabled, updated_at FROM feature_flags ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	flags := make([]*FeatureFlag, 0)
+	byName := make(map[string]*FeatureFlag)
+	for rows.Next() {
+		flag := &FeatureFlag{UserOverrides: map[int64]bool{}, RoomOverrides: map[int64]bool{}}
+		if err := rows.Scan(&flag.Name, &flag.Enabled, &flag.UpdatedAt); err != nil {
+			return nil, err
+		}
+		flags = append(flags, flag)
+		byName[flag.Name] = flag
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	overrides, err := s.db.QueryContext(ctx, `SELECT flag_name, user_id, room_id, enabled FROM feature_flag_overrides`)
+	if err != nil {
+		return nil, err
+	}
+	defer overrides.Close()
+
+	for overrides.Next() {
+		var (
+			name           string
+			userID, roomID sql.NullInt64
+			enabled        bool
+		)
+		if err := overrides.Scan(&name, &userID, &roomID, &enabled); err != nil {
+			return nil, err
+		}
+		flag := byName[name]
+		if flag == nil {
+			continue
+		}
+		if userID.Valid {
+			flag.UserOverrides[userID.Int64] = enabled
+		} else {
+			flag.RoomOverrides[roomID.Int64] = enabled
+		}
+	}
+	return flags, overrides.Err()
+}
+
+// Save creates or replaces a flag: its default and all of its overrides
+// Returns ErrOverrideTargetNotFound if an override names an unknown user or room
+func (s *FeatureFlagStore) Save(ctx context.Context, flag *FeatureFlag) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO feature_flags (name, enabled) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET enabled = EXCLUDED.enabled, updated_at = NOW()
+		RETURNING updated_at
+	`, flag.Name, flag.Enabled).Scan(&flag.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM feature_flag_overrides WHERE flag_name = $1`, flag.Name); err != nil {
+		return err
+	}
+
+	insert := func(column string, overrides map[int64]bool) error {
+		if len(overrides) == 0 {
+			return nil
+		}
+		ids := make([]int64, 0, len(overrides))
+		states := make([]bool, 0, len(overrides))
+		for id, enabled := range overrides {
+			ids = append(ids, id)
+			states = append(states, enabled)
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO feature_flag_overrides (flag_name, `+column+`, enabled)
+			SELECT $1, unnest($2::bigint[]), unnest($3::boolean[])
+		`, flag.Name, pq.Array(ids), pq.Array(states))
+		if IsForeignKeyViolation(err) {
+			return ErrOverrideTargetNotFound
+		}
+		return err
+	}
+	if err := insert("user_id", flag.UserOverrides); err != nil {
+		return err
+	}
+	if err := insert("room_id", flag.RoomOverrides); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// TestListFeatureFlags hangs each override on its flag, by user or by room
+func TestListFeatureFlags(t *testing.T) {
+	db, mock := newMockDB(t)
+	flags := &FeatureFlagStore{db}
+	now := time.Now()
+
+	mock.ExpectQuery(`FROM feature_flags ORDER BY name`).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "enabled", "updated_at"}).
+			AddRow("dark_mode", true, now).
+			AddRow("persist_join_leave", false, now))
+	mock.ExpectQuery(`FROM feature_flag_overrides`).
+		WillReturnRows(sqlmock.NewRows([]string{"flag_name", "user_id", "room_id", "enabled"}).
+			AddRow("persist_join_leave", 12, nil, true).
+			AddRow("persist_join_leave", nil, 5, true).
+			AddRow("dark_mode", 3, nil, false))
+	list, err := flags.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("got %d flags, want 2", len(list))
+	}
+	darkMode, persist := list[0], list[1]
+	if !darkMode.Enabled || len(darkMode.UserOverrides) != 1 || darkMode.UserOverrides[3] || len(darkMode.RoomOverrides) != 0 {
+		t.Errorf("got %+v, want dark_mode on but off for user 3", darkMode)
+	}
+	if persist.Enabled || !persist.UserOverrides[12] || !persist.RoomOverrides[5] || len(persist.UserOverrides)+len(persist.RoomOverrides) != 2 {
+		t.Errorf("got %+v, want persist_join_leave on for user 12 and room 5", persist)
+	}
+}
+
+// TestSaveFeatureFlagUnknownTarget reports an override naming a user who
+// doesn't exist as ErrOverrideTargetNotFound, and saves nothing
+func TestSaveFeatureFlagUnknownTarget(t *testing.T) {
+	db, mock := newMockDB(t)
+	flags := &FeatureFlagStore{db}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO feature_flags`).WithArgs("persist_join_leave", false).
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(time.Now()))
+	mock.ExpectExec(`DELETE FROM feature_flag_overrides WHERE flag_name = \$1`).WithArgs("persist_join_leave").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO feature_flag_overrides \(flag_name, user_id, enabled\)`).
+		WillReturnError(&pq.Error{Code: "23503"})
+	mock.ExpectRollback()
+
+	flag := &FeatureFlag{Name: "persist_join_leave", UserOverrides: map[int64]bool{999: true}}
+	if err := flags.Save(context.Background(), flag); !errors.Is(err, ErrOverrideTargetNotFound) {
+		t.Errorf("got %v, want ErrOverrideTargetNotFound", err)
+	}
+}
@@ -0,0 +1,195 @@
+//go:build ignore
+
+// gen_timeouts writes timeouts_gen.go: a wrapper for each Storage field whose
+// methods run under the store's timeoutPolicy (see timeouts.go)
+// It also writes faults_gen.go, the same wrappers for the fault injector,
+// which only builds with -tags faults (see faults.go)
+// Run it with go generate ./internal/store after changing storage.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "storage.go", nil, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Named interfaces a field may use, such as MessageQueries
+	named := make(map[string]*ast.InterfaceType)
+	var storage *ast.StructType
+	ast.Inspect(file, func(n ast.Node) bool {
+		spec, ok := n.(*ast.TypeSpec)
+		if !ok {
+			return true
+		}
+		switch t := spec.Type.(type) {
+		case *ast.InterfaceType:
+			named[spec.Name.Name] = t
+		case *ast.StructType:
+			if spec.Name.Name == "Storage" {
+				storage = t
+			}
+		}
+		return false
+	})
+	if storage == nil {
+		log.Fatal("storage.go has no Storage struct")
+	}
+
+	expr := func(e ast.Expr) string {
+		var buf bytes.Buffer
+		if err := format.Node(&buf, fset, e); err != nil {
+			log.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	var imports bytes.Buffer
+	imports.WriteString("import (\n")
+	var std, others []string
+	for _, imp := range file.Imports {
+		if strings.Contains(imp.Path.Value, ".") {
+			others = append(others, imp.Path.Value)
+		} else {
+			std = append(std, imp.Path.Value)
+		}
+	}
+	for _, path := range std {
+		fmt.Fprintf(&imports, "\t%s\n", path)
+	}
+	if len(others) > 0 {
+		imports.WriteString("\n")
+	}
+	for _, path := range others {
+		fmt.Fprintf(&imports, "\t%s\n", path)
+	}
+	imports.WriteString(")\n\n")
+
+	var out, faults bytes.Buffer
+	out.WriteString("// Code generated by gen_timeouts.go; DO NOT EDIT.\n\npackage store\n\n")
+	out.Write(imports.Bytes())
+	faults.WriteString("// Code generated by gen_timeouts.go; DO NOT EDIT.\n\n//go:build faults\n\npackage store\n\n")
+	faults.Write(imports.Bytes())
+	faults.WriteString("// faultyStorage is the Storage a withFaults wrapper passes calls on to\n")
+	faults.WriteString("type faultyStorage struct {\n\tfaults *Faults\n\tnext   Storage\n}\n\n")
+
+	out.WriteString("// timedStorage is the Storage a withTimeouts wrapper passes calls on to\n")
+	out.WriteString("type timedStorage struct {\n\tpolicy *timeoutPolicy\n\tnext   Storage\n}\n\n")
+
+	var fields, methods []string
+	for _, field := range storage.Fields.List {
+		iface, ok := field.Type.(*ast.InterfaceType)
+		if ident, isIdent := field.Type.(*ast.Ident); isIdent {
+			iface, ok = named[ident.Name]
+		}
+		if !ok {
+			log.Fatalf("Storage.%s isn't an interface", field.Names[0].Name)
+		}
+		name := field.Names[0].Name
+		fields = append(fields, name)
+		wrapper := "timed" + name
+		fmt.Fprintf(&out, "type %s struct{ *timedStorage }\n\n", wrapper)
+		faulty := "faulty" + name
+		fmt.Fprintf(&faults, "type %s struct{ *faultyStorage }\n\n", faulty)
+
+		for _, m := range iface.Methods.List {
+			fn := m.Type.(*ast.FuncType)
+			method := m.Names[0].Name
+			key := name + "." + method
+			methods = append(methods, key)
+
+			var params, args []string
+			for i, p := range fn.Params.List {
+				if i == 0 {
+					if expr(p.Type) != "context.Context" {
+						log.Fatalf("%s doesn't take a context first", key)
+					}
+					params = append(params, "ctx context.Context")
+					continue
+				}
+				arg := fmt.Sprintf("a%d", i)
+				params = append(params, arg+" "+expr(p.Type))
+				args = append(args, arg)
+			}
+			var results, named []string
+			for _, r := range fn.Results.List {
+				results = append(results, expr(r.Type))
+			}
+			// The fault wrapper names its results so it can return just the error
+			for i, r := range results {
+				if i == len(results)-1 {
+					named = append(named, "err "+r)
+				} else {
+					named = append(named, fmt.Sprintf("r%d %s", i, r))
+				}
+			}
+			call := fmt.Sprintf("s.next.%s.%s(%s)", name, method, strings.Join(append([]string{"ctx"}, args...), ", "))
+
+			signature := strings.Join(results, ", ")
+			if len(results) > 1 {
+				signature = "(" + signature + ")"
+			}
+			fmt.Fprintf(&out, "func (s %s) %s(%s) %s {\n", wrapper, method, strings.Join(params, ", "), signature)
+			switch len(results) {
+			case 1:
+				fmt.Fprintf(&out, "\treturn s.policy.run(ctx, %q, func(ctx context.Context) error {\n\t\treturn %s\n\t})\n", key, call)
+			case 2:
+				fmt.Fprintf(&out, "\treturn timed(s.policy, ctx, %q, func(ctx context.Context) %s {\n\t\treturn %s\n\t})\n", key, signature, call)
+			case 3:
+				fmt.Fprintf(&out, "\treturn timed2(s.policy, ctx, %q, func(ctx context.Context) %s {\n\t\treturn %s\n\t})\n", key, signature, call)
+			default:
+				log.Fatalf("%s returns %d values", key, len(results))
+			}
+			out.WriteString("}\n\n")
+
+			fmt.Fprintf(&faults, "func (s %s) %s(%s) (%s) {\n", faulty, method, strings.Join(params, ", "), strings.Join(named, ", "))
+			fmt.Fprintf(&faults, "\tif err = s.faults.inject(ctx, %q); err != nil {\n\t\treturn\n\t}\n\treturn %s\n}\n\n", key, call)
+		}
+	}
+
+	out.WriteString("// withTimeouts wraps every store of next so its methods run under policy\n")
+	out.WriteString("func withTimeouts(next Storage, policy *timeoutPolicy) Storage {\n\ts := &timedStorage{policy: policy, next: next}\n\treturn Storage{\n")
+	for _, name := range fields {
+		fmt.Fprintf(&out, "\t\t%s: timed%s{s},\n", name, name)
+	}
+	out.WriteString("\t}\n}\n")
+
+	faults.WriteString("// withFaults wraps every store of next so its methods go through faults first\n")
+	faults.WriteString("func withFaults(next Storage, faults *Faults) Storage {\n\ts := &faultyStorage{faults: faults, next: next}\n\treturn Storage{\n")
+	for _, name := range fields {
+		fmt.Fprintf(&faults, "\t\t%s: faulty%s{s},\n", name, name)
+	}
+	faults.WriteString("\t}\n}\n\n")
+	faults.WriteString("// faultMethods are the methods a fault can be set on, as Field.Method\n")
+	faults.WriteString("var faultMethods = map[string]bool{\n")
+	for _, key := range methods {
+		fmt.Fprintf(&faults, "\t%q: true,\n", key)
+	}
+	faults.WriteString("}\n")
+
+	write("timeouts_gen.go", out.Bytes())
+	write("faults_gen.go", faults.Bytes())
+}
+
+// write formats src and writes it to name
+func write(name string, src []byte) {
+	src, err := format.Source(src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(name, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
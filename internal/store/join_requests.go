@@ -0,0 +1,222 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Join request statuses
+const (
+	JoinRequestPending  = "pending"
+	JoinRequestApproved = "approved"
+	JoinRequestRejected = "rejected"
+)
+
+// ErrJoinRequestCooldown is returned by Knock when the user's last request to
+// the room was rejected too recently to ask again
+var ErrJoinRequestCooldown = errors.New("join request rejected recently")
+
+// JoinRequest is a user's request to join a room with the "approval" join policy
+type JoinRequest struct {
+	RoomID    int64      `json:"room_id"`
+	UserID    int64      `json:"user_id"`
+	Username  string     `json:"username,omitempty"` // Populated by ListPending
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+	DecidedBy *int64     `json:"decided_by,omitempty"`
+}
+
+// JoinRequestStore handles database operations for join requests
+type JoinRequestStore struct {
+	db     *sql.DB
+	limits Limits
+}
+
+// Knock records a pending request for a user to join a room
+// Knocking is idempotent: if a request is already pending it is returned unchanged
+// and created is false, so admins aren't notified twice
+// A request rejected less than cooldown ago returns ErrJoinRequestCooldown
+func (s *JoinRequestStore) Knock(ctx context.Context, roomID, userID int64, cooldown time.Duration) (*JoinRequest, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	// Inserting first (instead of checking first) means two concurrent knocks
+	// can't both create a request; the loser falls through to the row lock below
+	insertQuery := `
+		INSERT INTO join_requests (room_id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT (room_id, user_id) DO NOTHING
+	`
+	result, err := tx.ExecContext(ctx, insertQuery, roomID, userID)
+	if err != nil {
+		return nil, false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The cooldown is compared against the database clock, the same clock
+	// that wrote decided_at
+	selectQuery := `
+		SELECT room_id, user_id, status, created_at, decided_at, decided_by,
+			COALESCE(decided_at > NOW() - ($3 * INTERVAL '1 second'), FALSE)
+		FROM join_requests
+		WHERE room_id = $1 AND user_id = $2
+		FOR UPDATE
+	`
+	request := &JoinRequest{}
+	var inCooldown bool
+	err = tx.QueryRowContext(ctx, selectQuery, roomID, userID, cooldown.Seconds()).Scan(
+		&request.RoomID,
+		&request.UserID,
+		&request.Status,
+		&request.CreatedAt,
+		&request.DecidedAt,
+		&request.DecidedBy,
+		&inCooldown,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if inserted == 0 {
+		if request.Status == JoinRequestPending {
+			return request, false, tx.Commit()
+		}
+		if request.Status == JoinRequestRejected && inCooldown {
+			return nil, false, ErrJoinRequestCooldown
+		}
+
+		// An old decision (e.g. approved, then the user left) is replaced by a fresh request
+		reopenQuery := `
+			UPDATE join_requests
+			SET status = 'pending', created_at = NOW(), decided_at = NULL, decided_by = NULL
+			WHERE room_id = $1 AND user_id = $2
+			RETURNING status, created_at
+		`
+		if err := tx.QueryRowContext(ctx, reopenQuery, roomID, userID).Scan(&request.Status, &request.CreatedAt); err != nil {
+			return nil, false, err
+		}
+		request.DecidedAt = nil
+		request.DecidedBy = nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	return request, true, nil
+}
+
+// ListPending returns a room's pending join requests, oldest first
+func (s *JoinRequestStore) ListPending(ctx context.Context, roomID int64) ([]*JoinRequest, error) {
+	query := `
+		SELECT jr.room_id, jr.user_id, u.username, jr.status, jr.created_at
+		FROM join_requests jr
+		INNER JOIN users u ON u.id = jr.user_id
+		WHERE jr.room_id = $1 AND jr.status = 'pending'
+		ORDER BY jr.created_at ASC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := make([]*JoinRequest, 0)
+	for rows.Next() {
+		request := &JoinRequest{}
+		err := rows.Scan(
+			&request.RoomID,
+			&request.UserID,
+			&request.Username,
+			&request.Status,
+			&request.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+// Approve accepts a pending request and makes the user a room member
+// Both happen in one transaction so a request is never approved without a membership
+// Returns sql.ErrNoRows if there is no pending request, or ErrRoomFull /
+// ErrTooManyRooms if the membership would exceed a limit (the request stays pending)
+func (s *JoinRequestStore) Approve(ctx context.Context, roomID, userID, adminID int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := decide(ctx, tx, roomID, userID, adminID, JoinRequestApproved); err != nil {
+		return err
+	}
+
+	// The user may have been added some other way while the request was pending
+	var isMember bool
+	memberQuery := `SELECT EXISTS(SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)`
+	if err := tx.QueryRowContext(ctx, memberQuery, roomID, userID).Scan(&isMember); err != nil {
+		return err
+	}
+	if !isMember {
+		if err := addMember(ctx, tx, s.limits, roomID, userID, JoinOptions{ActorID: adminID}); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Reject declines a pending request
+// The decision time is kept so Knock can enforce the cooldown
+// Returns sql.ErrNoRows if there is no pending request
+func (s *JoinRequestStore) Reject(ctx context.Context, roomID, userID, adminID int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := decide(ctx, tx, roomID, userID, adminID, JoinRequestRejected); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// decide moves a pending request to its final status
+func decide(ctx context.Context, tx *sql.Tx, roomID, userID, adminID int64, status string) error {
+	query := `
+		UPDATE join_requests
+		SET status = $4, decided_at = NOW(), decided_by = $3
+		WHERE room_id = $1 AND user_id = $2 AND status = 'pending'
+	`
+
+	result, err := tx.ExecContext(ctx, query, roomID, userID, adminID, status)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// joinRequestColumns are the columns Knock reads back
+var joinRequestColumns = []string{"room_id", "user_id", "status", "created_at", "decided_at", "decided_by", "in_cooldown"}
+
+// TestKnock knocks on a room in each state the last request can be in: a
+// first knock creates a request, knocking while it's pending changes nothing,
+// a recent rejection refuses, and an older decision is reopened
+func TestKnock(t *testing.T) {
+	db, mock := newMockDB(t)
+	requests := &JoinRequestStore{db, Limits{}}
+	now := time.Now()
+	decidedBy := int64(1)
+
+	for _, tc := range []struct {
+		name       string
+		inserted   int64
+		status     string
+		inCooldown bool
+		reopened   bool
+		created    bool
+		err        error
+	}{
+		{"first knock", 1, JoinRequestPending, false, false, true, nil},
+		{"pending", 0, JoinRequestPending, false, false, false, nil},
+		{"rejected recently", 0, JoinRequestRejected, true, false, false, ErrJoinRequestCooldown},
+		{"rejected long ago", 0, JoinRequestRejected, false, true, true, nil},
+		{"approved, then left", 0, JoinRequestApproved, false, true, true, nil},
+	} {
+		row := sqlmock.NewRows(joinRequestColumns)
+		if tc.inserted == 1 {
+			row.AddRow(1, 2, tc.status, now, nil, nil, false)
+		} else {
+			row.AddRow(1, 2, tc.status, now, now, decidedBy, tc.inCooldown)
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO join_requests .* ON CONFLICT \(room_id, user_id\) DO NOTHING`).
+			WithArgs(int64(1), int64(2)).WillReturnResult(sqlmock.NewResult(0, tc.inserted))
+		mock.ExpectQuery(`FROM join_requests\s+WHERE room_id = \$1 AND user_id = \$2\s+FOR UPDATE`).
+			WithArgs(int64(1), int64(2), float64(3600)).WillReturnRows(row)
+		if tc.reopened {
+			mock.ExpectQuery(`UPDATE join_requests\s+SET status = 'pending'`).WithArgs(int64(1), int64(2)).
+				WillReturnRows(sqlmock.NewRows([]string{"status", "created_at"}).AddRow(JoinRequestPending, now))
+		}
+		if tc.err == nil {
+			mock.ExpectCommit()
+		} else {
+			mock.ExpectRollback()
+		}
+
+		request, created, err := requests.Knock(context.Background(), 1, 2, time.Hour)
+		if !errors.Is(err, tc.err) || created != tc.created {
+			t.Errorf("%s: got created %t and %v, want %t and %v", tc.name, created, err, tc.created, tc.err)
+			continue
+		}
+		if err == nil && (request.Status != JoinRequestPending || (tc.reopened && request.DecidedAt != nil)) {
+			t.Errorf("%s: got %+v, want a pending request", tc.name, request)
+		}
+	}
+}
+
+// TestApproveJoinsInOneTransaction approves a request: the decision and the
+// membership commit together, a user who joined some other way meanwhile is
+// left as they are, and a request that isn't pending changes nothing
+func TestApproveJoinsInOneTransaction(t *testing.T) {
+	db, mock := newMockDB(t)
+	requests := &JoinRequestStore{db, Limits{}}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE join_requests\s+SET status = \$4`).WithArgs(int64(1), int64(2), int64(3), JoinRequestApproved).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM room_members`).WithArgs(int64(1), int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(`SELECT id FROM users WHERE id = \$1 FOR UPDATE`).WithArgs(int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT max_members FROM rooms WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"max_members"}).AddRow(nil))
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM room_members`).WithArgs(int64(1), int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(`INSERT INTO room_members`).WithArgs(int64(1), int64(2), RoomRoleMember).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// The admin who approved is recorded as adding them
+	mock.ExpectExec(`INSERT INTO room_membership_events`).WithArgs(int64(1), int64(2), MembershipJoined, int64(3)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectMembershipLogged(mock, 1, []int64{2}, MembershipJoined, 3, false)
+	mock.ExpectCommit()
+
+	if err := requests.Approve(context.Background(), 1, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE join_requests`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM room_members`).WithArgs(int64(1), int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectCommit()
+
+	if err := requests.Approve(context.Background(), 1, 2, 3); err != nil {
+		t.Fatalf("approving a user who is already a member: %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE join_requests`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if err := requests.Approve(context.Background(), 1, 2, 3); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("approving a decided request returned %v, want sql.ErrNoRows", err)
+	}
+}
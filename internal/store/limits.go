@@ -0,0 +1,160 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrRoomFull is returned when joining a room that has reached its member limit
+	ErrRoomFull = errors.New("room is full")
+
+	// ErrTooManyRooms is returned when a user already belongs to the maximum number of rooms
+	ErrTooManyRooms = errors.New("room membership limit reached")
+)
+
+// Limits caps how large rooms and memberships may grow, and how long store
+// operations may take
+// A zero value means "no limit", except for Timeouts
+type Limits struct {
+	// MaxRoomMembers is the global member cap; rooms may lower it but never raise it
+	MaxRoomMembers int
+
+	// MaxRoomsPerUser is how many rooms a single user may belong to
+	MaxRoomsPerUser int
+
+	// MessageRetention is how long messages are kept in rooms without their
+	// own retention; zero keeps them forever
+	MessageRetention time.Duration
+
+	// Timeouts bound each store operation by what it does; zero fields use
+	// DefaultTimeouts'
+	Timeouts Timeouts
+}
+
+// roomMemberLimit returns the member limit in effect for a room with the
+// given per-room override (nil if the room has none)
+func (l Limits) roomMemberLimit(override *int) int {
+	if override == nil {
+		return l.MaxRoomMembers
+	}
+	if l.MaxRoomMembers > 0 && *override > l.MaxRoomMembers {
+		// The global cap wins if it was lowered after the room set its own limit
+		return l.MaxRoomMembers
+	}
+	return *override
+}
+
+// JoinOptions controls how addMember adds a user to a room
+type JoinOptions struct {
+	// Role is one of the RoomRole constants; empty means RoomRoleMember
+	Role string
+
+	// ActorID is who added the user, recorded in the membership history: the
+	// user themselves for a plain join, 0 for a system action
+	ActorID int64
+
+	// Quiet flags the join in the room's event log so clients don't show a
+	// "joined" line for it, e.g. for the default rooms every new account joins
+	Quiet bool
+
+	// IfAbsent makes joining a room the user is already in a no-op instead of
+	// a primary key violation: addMember returns errAlreadyJoined and records
+	// no membership event
+	IfAbsent bool
+}
+
+// errAlreadyJoined is returned by addMember with JoinOptions.IfAbsent when the
+// user was already a member
+var errAlreadyJoined = errors.New("already a member")
+
+// addMember inserts a membership after checking both limits
+// Must be called inside a transaction: the user and room rows are locked with
+// SELECT ... FOR UPDATE, so concurrent joins queue up behind each other instead
+// of all counting the same number of members and overshooting the limit
+// Locks are always taken user first, then room, so two joins can't deadlock
+// The "joined" membership event is recorded in the same transaction, with
+// opts.ActorID as the one who added the user
+func addMember(ctx context.Context, tx *sql.Tx, limits Limits, roomID, userID int64, opts JoinOptions) error {
+	role := opts.Role
+	if role == "" {
+		role = RoomRoleMember
+	}
+
+	if _, err := tx.ExecContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return err
+	}
+
+	var override *int
+	// A deleted room can't be joined; it reports sql.ErrNoRows like a missing one
+	err := tx.QueryRowContext(ctx, `SELECT max_members FROM rooms WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, roomID).Scan(&override)
+	if err != nil {
+		return err
+	}
+
+	// An existing member fails on the primary key below, which callers report
+	// as "already a member"; that's more useful than a limit error
+	var isMember bool
+	memberQuery := `SELECT EXISTS(SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)`
+	if err := tx.QueryRowContext(ctx, memberQuery, roomID, userID).Scan(&isMember); err != nil {
+		return err
+	}
+
+	if limits.MaxRoomsPerUser > 0 && !isMember {
+		var rooms int
+		roomsQuery := `
+			SELECT COUNT(*) FROM room_members rm
+			INNER JOIN rooms r ON r.id = rm.room_id
+			WHERE rm.user_id = $1 AND r.deleted_at IS NULL
+		`
+		err := tx.QueryRowContext(ctx, roomsQuery, userID).Scan(&rooms)
+		if err != nil {
+			return err
+		}
+		if rooms >= limits.MaxRoomsPerUser {
+			return ErrTooManyRooms
+		}
+	}
+
+	if limit := limits.roomMemberLimit(override); limit > 0 && !isMember {
+		var members int
+		err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM room_members WHERE room_id = $1`, roomID).Scan(&members)
+		if err != nil {
+			return err
+		}
+		if members >= limit {
+			return ErrRoomFull
+		}
+	}
+
+	if opts.IfAbsent {
+		// The check and the insert are one statement: whichever of two
+		// concurrent joins inserts the row is the only one that gets it back
+		query := `
+			INSERT INTO room_members (room_id, user_id, role)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (room_id, user_id) DO NOTHING
+			RETURNING user_id
+		`
+		var inserted int64
+		err := tx.QueryRowContext(ctx, query, roomID, userID, role).Scan(&inserted)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errAlreadyJoined
+		}
+		if err != nil {
+			return err
+		}
+		return recordMembershipEvent(ctx, tx, roomID, userID, MembershipJoined, opts.ActorID, opts.Quiet)
+	}
+
+	query := `
+		INSERT INTO room_members (room_id, user_id, role)
+		VALUES ($1, $2, $3)
+	`
+	if _, err := tx.ExecContext(ctx, query, roomID, userID, role); err != nil {
+		return err
+	}
+	return recordMembershipEvent(ctx, tx, roomID, userID, MembershipJoined, opts.ActorID, opts.Quiet)
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestRoomMemberLimit works out the limit in effect for rooms with and
+// without their own, under a global cap and without one
+func TestRoomMemberLimit(t *testing.T) {
+	ten, hundred := 10, 100
+
+	for _, tc := range []struct {
+		name     string
+		global   int
+		override *int
+		want     int
+	}{
+		{"global cap only", 50, nil, 50},
+		{"no limits at all", 0, nil, 0},
+		{"room below the cap", 50, &ten, 10},
+		{"room above a lowered cap", 50, &hundred, 50},
+		{"room limit without a cap", 0, &hundred, 100},
+	} {
+		if got := (Limits{MaxRoomMembers: tc.global}).roomMemberLimit(tc.override); got != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestJoinChecksLimits joins a room under both limits: the user row is
+// locked before the room row, a full room or a user at their quota is
+// refused without inserting, and an existing member skips the counts and
+// fails on the primary key as before
+func TestJoinChecksLimits(t *testing.T) {
+	limits := Limits{MaxRoomMembers: 3, MaxRoomsPerUser: 2}
+	duplicate := errors.New(`pq: duplicate key value violates unique constraint "room_members_pkey"`)
+
+	for _, tc := range []struct {
+		name     string
+		isMember bool
+		rooms    int
+		members  int
+		err      error
+	}{
+		{"room with space", false, 1, 2, nil},
+		{"full room", false, 1, 3, ErrRoomFull},
+		{"user at their quota", false, 2, 0, ErrTooManyRooms},
+		{"existing member", true, 0, 0, duplicate},
+	} {
+		db, mock := newMockDB(t)
+		members := &RoomMemberStore{db, limits}
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`SELECT id FROM users WHERE id = \$1 FOR UPDATE`).WithArgs(int64(2)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT max_members FROM rooms WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"max_members"}).AddRow(nil))
+		mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM room_members`).WithArgs(int64(1), int64(2)).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tc.isMember))
+		if !tc.isMember {
+			mock.ExpectQuery(`SELECT COUNT\(\*\) FROM room_members rm\s+INNER JOIN rooms r ON r.id = rm.room_id\s+WHERE rm.user_id = \$1 AND r.deleted_at IS NULL`).WithArgs(int64(2)).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tc.rooms))
+		}
+		if !tc.isMember && !errors.Is(tc.err, ErrTooManyRooms) {
+			mock.ExpectQuery(`SELECT COUNT\(\*\) FROM room_members WHERE room_id = \$1`).WithArgs(int64(1)).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tc.members))
+		}
+		switch {
+		case tc.err == nil:
+			mock.ExpectExec(`INSERT INTO room_members`).WithArgs(int64(1), int64(2), RoomRoleMember).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(`INSERT INTO room_membership_events`).WithArgs(int64(1), int64(2), MembershipJoined, int64(2)).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			expectMembershipLogged(mock, 1, []int64{2}, MembershipJoined, 2, false)
+			mock.ExpectCommit()
+		case tc.isMember:
+			mock.ExpectExec(`INSERT INTO room_members`).WillReturnError(duplicate)
+			mock.ExpectRollback()
+		default:
+			// The limit errors return before the insert
+			mock.ExpectRollback()
+		}
+
+		err := members.Join(context.Background(), 1, 2, 2)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.err)
+		}
+	}
+}
+
+// TestRetention checks a room's own retention wins over the server default,
+// 0 included, and rooms without one get the default
+func TestRetention(t *testing.T) {
+	day, forever := int64(86400), int64(0)
+	for _, c := range []struct {
+		fallback time.Duration
+		setting  string
+		seconds  *int64
+		want     time.Duration
+	}{
+		{0, "none", nil, 0},
+		{48 * time.Hour, "none", nil, 48 * time.Hour},
+		{48 * time.Hour, "a day", &day, 24 * time.Hour},
+		{48 * time.Hour, "forever", &forever, 0},
+		{0, "a day", &day, 24 * time.Hour},
+	} {
+		if got := (Limits{MessageRetention: c.fallback}).retention(c.seconds); got != c.want {
+			t.Errorf("with a default of %s and a setting of %s: %s, want %s", c.fallback, c.setting, got, c.want)
+		}
+	}
+
+	room := &Room{RetentionSeconds: &day}
+	fillRoomComputed(room, Limits{MessageRetention: 48 * time.Hour})
+	if room.EffectiveRetentionSeconds != day {
+		t.Errorf("the room reports %d effective seconds, want %d", room.EffectiveRetentionSeconds, day)
+	}
+}
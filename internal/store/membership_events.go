@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Membership event types, one per kind of membership change
+// Kick, ban and invite don't exist yet; their types are reserved so the history
+// doesn't need a migration once they do
+const (
+	MembershipJoined  = "joined"
+	MembershipLeft    = "left"
+	MembershipKicked  = "kicked"
+	MembershipBanned  = "banned"
+	MembershipInvited = "invited"
+)
+
+// MembershipEvent is one entry in a room's membership history
+type MembershipEvent struct {
+	ID       int64  `json:"id"`
+	RoomID   int64  `json:"room_id"`
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+	Event    string `json:"event"`
+
+	// Who performed the change; nil for system actions or deleted accounts
+	ActorID       *int64  `json:"actor_id"`
+	ActorUsername *string `json:"actor_username"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MembershipEventQuery selects a page of a room's membership history
+type MembershipEventQuery struct {
+	UserID int64 // Only events for this user; 0 for everyone
+	Limit  int   // Page size
+	Before int64 // Only events with a smaller ID; 0 starts from the newest
+}
+
+// MembershipEventStore handles reading the membership history
+// Events are written by the stores that change memberships, via recordMembershipEvent
+type MembershipEventStore struct {
+	db *sql.DB
+}
+
+// recordMembershipEvent appends an event inside the caller's transaction
+// If the membership change is rolled back, so is its event
+// The change is also added to the room's event log (see room_events.go)
+// An actorID of 0 records a system action
+// A quiet event is flagged in the room's event log so clients don't announce it
+func recordMembershipEvent(ctx context.Context, tx *sql.Tx, roomID, userID int64, event string, actorID int64, quiet bool) error {
+	query := `
+		INSERT INTO room_membership_events (room_id, user_id, event, actor_id)
+		VALUES ($1, $2, $3, NULLIF($4, 0))
+	`
+
+	if _, err := tx.ExecContext(ctx, query, roomID, userID, event, actorID); err != nil {
+		return err
+	}
+	return appendMembershipEvents(ctx, tx, roomID, []int64{userID}, event, actorID, quiet)
+}
+
+// recordMembershipEvents appends the same event for several users in one statement
+func recordMembershipEvents(ctx context.Context, tx *sql.Tx, roomID int64, userIDs []int64, event string, actorID int64) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO room_membership_events (room_id, user_id, event, actor_id)
+		SELECT $1, unnest($2::bigint[]), $3, NULLIF($4, 0)
+	`
+
+	if _, err := tx.ExecContext(ctx, query, roomID, pq.Array(userIDs), event, actorID); err != nil {
+		return err
+	}
+	return appendMembershipEvents(ctx, tx, roomID, userIDs, event, actorID, false)
+}
+
+// List returns a page of a room's membership history, newest first
+// Pages are keyed on the event ID rather than the timestamp: IDs are unique,
+// so events that share a created_at are never skipped or repeated between pages
+func (s *MembershipEventStore) List(ctx context.Context, roomID int64, q MembershipEventQuery) ([]*MembershipEvent, error) {
+	query := `
+		SELECT e.id, e.room_id, e.user_id, u.username, e.event, e.actor_id, a.username, e.created_at
+		FROM room_membership_events e
+		INNER JOIN users u ON u.id = e.user_id
+		LEFT JOIN users a ON a.id = e.actor_id
+		WHERE e.room_id = $1
+		  AND ($2::BIGINT = 0 OR e.user_id = $2)
+		  AND ($3::BIGINT = 0 OR e.id < $3)
+		ORDER BY e.id DESC
+		LIMIT $4
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, roomID, q.UserID, q.Before, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*MembershipEvent, 0)
+	for rows.Next() {
+		event := &MembershipEvent{}
+		err := rows.Scan(
+			&event.ID,
+			&event.RoomID,
+			&event.UserID,
+			&event.Username,
+			&event.Event,
+			&event.ActorID,
+			&event.ActorUsername,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestLeaveRecordsEvent leaves a room: the event is written, and added to
+// the room's event log, in the same transaction as the delete, and nothing is written when the delete fails,
+// removes nothing, or the event itself can't be saved
+func TestLeaveRecordsEvent(t *testing.T) {
+	deleteFailed := errors.New("room_members is locked")
+	eventFailed := errors.New("room_membership_events is full")
+	for _, tc := range []struct {
+		name    string
+		removed int64
+		delErr  error
+		evErr   error
+	}{
+		{"member leaves", 1, nil, nil},
+		{"not a member", 0, nil, nil},
+		{"delete fails", 0, deleteFailed, nil},
+		{"event fails", 1, nil, eventFailed},
+	} {
+		db, mock := newMockDB(t)
+		members := &RoomMemberStore{db, Limits{}}
+
+		mock.ExpectBegin()
+		del := mock.ExpectExec(`DELETE FROM room_members\s+WHERE room_id = \$1 AND user_id = \$2`).WithArgs(int64(1), int64(2))
+		if tc.delErr != nil {
+			del.WillReturnError(tc.delErr)
+		} else {
+			del.WillReturnResult(sqlmock.NewResult(0, tc.removed))
+		}
+		if tc.removed > 0 {
+			event := mock.ExpectExec(`INSERT INTO room_membership_events`).WithArgs(int64(1), int64(2), MembershipLeft, int64(3))
+			if tc.evErr != nil {
+				event.WillReturnError(tc.evErr)
+			} else {
+				event.WillReturnResult(sqlmock.NewResult(1, 1))
+				expectMembershipLogged(mock, 1, []int64{2}, MembershipLeft, 3, false)
+			}
+		}
+		want := tc.delErr
+		if want == nil {
+			want = tc.evErr
+		}
+		if want == nil {
+			mock.ExpectCommit()
+		} else {
+			mock.ExpectRollback()
+		}
+
+		err := members.Leave(context.Background(), 1, 2, 3)
+		if !errors.Is(err, want) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, want)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
+
+// TestJoinWithoutEventRollsBack fails to record a join: the membership
+// is rolled back with it
+func TestJoinWithoutEventRollsBack(t *testing.T) {
+	db, mock := newMockDB(t)
+	members := &RoomMemberStore{db, Limits{}}
+	eventFailed := errors.New("room_membership_events is full")
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT id FROM users WHERE id = \$1 FOR UPDATE`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT max_members FROM rooms`).WillReturnRows(sqlmock.NewRows([]string{"max_members"}).AddRow(nil))
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM room_members`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(`INSERT INTO room_members`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO room_membership_events`).WithArgs(int64(1), int64(2), MembershipJoined, int64(0)).
+		WillReturnError(eventFailed)
+	mock.ExpectRollback()
+
+	if err := members.Join(context.Background(), 1, 2, 0); !errors.Is(err, eventFailed) {
+		t.Errorf("got %v, want %v", err, eventFailed)
+	}
+}
+
+// TestListMembershipEvents reads a page of history: the filters and the
+// cursor are passed through, and a missing actor reads as nil
+func TestListMembershipEvents(t *testing.T) {
+	db, mock := newMockDB(t)
+	events := &MembershipEventStore{db}
+	now := time.Now()
+
+	mock.ExpectQuery(`ORDER BY e.id DESC\s+LIMIT \$4`).WithArgs(int64(1), int64(2), int64(120), 50).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "room_id", "user_id", "username", "event", "actor_id", "actor_username", "created_at"}).
+			AddRow(119, 1, 2, "grace", MembershipLeft, 3, "ada", now).
+			AddRow(87, 1, 2, "grace", MembershipJoined, nil, nil, now))
+
+	page, err := events.List(context.Background(), 1, MembershipEventQuery{UserID: 2, Limit: 50, Before: 120})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 || *page[0].ActorUsername != "ada" || page[1].ActorID != nil {
+		t.Errorf("got %+v", page)
+	}
+}
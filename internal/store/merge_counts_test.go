@@ -0,0 +1,107 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestMergeCounts merges two rooms on the scratch database that share a
+// member, who is an admin only in the source: the target ends up with
+// every message and each member once, the shared one an admin, and the
+// source can't be restored
+func TestMergeCounts(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	limits := Limits{MaxRoomMembers: 100, MaxRoomsPerUser: 100}
+	rooms := &RoomStore{db, limits, NewPools(db, nil)}
+	members := &RoomMemberStore{db, limits}
+	messages := &MessageStore{db, NewPools(db, nil)}
+	suffix := time.Now().UnixNano()
+
+	var ada, grace, linus int64
+	for _, user := range []struct {
+		name string
+		id   *int64
+	}{{"ada", &ada}, {"grace", &grace}, {"linus", &linus}} {
+		query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+		if err := db.QueryRowContext(ctx, query, fmt.Sprintf("merge-%s-%d", user.name, suffix)).Scan(user.id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	source := &Room{Name: fmt.Sprintf("merge-source-%d", suffix), CreatedBy: ada}
+	target := &Room{Name: fmt.Sprintf("merge-target-%d", suffix), CreatedBy: ada}
+	for _, room := range []*Room{source, target} {
+		if err := rooms.Create(ctx, room); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM rooms WHERE id = ANY(ARRAY[$1, $2]::bigint[])`, source.ID, target.ID)
+		db.Exec(`DELETE FROM users WHERE id = ANY(ARRAY[$1, $2, $3]::bigint[])`, ada, grace, linus)
+	})
+
+	// ada is in both; grace only in the source; linus in both, admin of the source only
+	for _, m := range []struct {
+		room, user int64
+		role       string
+	}{
+		{source.ID, ada, RoomRoleAdmin}, {source.ID, grace, RoomRoleMember}, {source.ID, linus, RoomRoleAdmin},
+		{target.ID, ada, RoomRoleAdmin}, {target.ID, linus, RoomRoleMember},
+	} {
+		if err := members.JoinWithOptions(ctx, m.room, m.user, JoinOptions{Role: m.role, ActorID: ada}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, room := range []int64{source.ID, target.ID, source.ID} {
+		if err := messages.Create(ctx, &Message{RoomID: room, UserID: ada, Content: fmt.Sprintf("message %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := rooms.Merge(ctx, source.ID, target.ID, ada)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.MessagesMoved != 2 || result.MembersMoved != 3 || result.MembersAdded != 1 {
+		t.Errorf("got %+v, want 2 messages and 3 members moved, 1 added", result)
+	}
+
+	count := func(query string, args ...any) int {
+		t.Helper()
+		var n int
+		if err := db.QueryRowContext(ctx, query, args...).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := count(`SELECT COUNT(*) FROM messages WHERE room_id = $1`, target.ID); n != 3 {
+		t.Errorf("the target has %d messages, want 3", n)
+	}
+	if n := count(`SELECT COUNT(*) FROM room_members WHERE room_id = $1`, target.ID); n != 3 {
+		t.Errorf("the target has %d member rows, want 3", n)
+	}
+	if n := count(`SELECT COUNT(*) FROM room_members WHERE room_id = $1`, source.ID); n != 0 {
+		t.Errorf("the source still has %d members", n)
+	}
+	if admin, _ := members.IsRoomAdmin(ctx, target.ID, linus); !admin {
+		t.Error("linus lost the admin role they had in the source")
+	}
+	merged, err := rooms.GetByID(ctx, target.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.MemberCount != 3 {
+		t.Errorf("the target's member_count is %d, want 3", merged.MemberCount)
+	}
+	if err := rooms.Restore(ctx, source.ID, time.Hour); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("restoring the merged room got %v, want sql.ErrNoRows", err)
+	}
+}
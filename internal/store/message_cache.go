@@ -0,0 +1,283 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MessageCache keeps the newest messages of recently read rooms in memory, in
+// front of the store behind it, so opening a busy room doesn't query the
+// database every time
+//
+// A room's window is loaded by a GetRoomMessages miss and then kept current
+// by Create: every message saved through the cache (the hub's, the API's and
+// system messages) is appended to it. GetRoomMessages and GetMessagesBefore
+// are answered from the window when it holds the whole page; anything else
+// goes to the store. Windows are dropped least recently read first once the
+// cache holds more than its maximum number of messages
+//
+// Only writes made through this cache keep it current. Redactions and room
+// merges call Invalidate, and PurgeRoomExpired invalidates the room itself;
+// changes made elsewhere (another instance, or directly in the database) are
+// seen once a window is older than the TTL and reloaded. With several
+// instances serving the same rooms, leave the cache off or keep the TTL short
+type MessageCache struct {
+	MessageQueries // The store behind the cache; methods not cached go straight to it
+
+	maxMessages int
+	ttl         time.Duration // 0 keeps windows until they're evicted or invalidated
+
+	mu       sync.Mutex
+	rooms    map[int64]*list.Element // Of *roomWindow
+	recent   *list.List              // Most recently read first
+	messages int                     // Messages held across every window
+
+	hits, misses atomic.Int64
+}
+
+// roomWindow is one room's newest messages, oldest first
+// They're a contiguous tail of the room's history: no message newer than the
+// first is missing
+type roomWindow struct {
+	roomID   int64
+	messages []*Message
+	loaded   bool // False while the first GetRoomMessages miss is reading the store
+	complete bool // The room has no messages older than the window's
+	loadedAt time.Time
+
+	// gen changes with every message saved in the room; a load that started
+	// before one isn't kept, as it may have missed it
+	gen uint64
+}
+
+// MessageCacheStats is the cache's usage, for the readiness endpoint
+type MessageCacheStats struct {
+	Rooms       int   `json:"rooms"`
+	Messages    int   `json:"messages"`
+	MaxMessages int   `json:"max_messages"`
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+}
+
+// NewMessageCache puts a cache holding up to maxMessages messages in front of messages
+// Windows older than ttl are reloaded on their next read
+func NewMessageCache(messages MessageQueries, maxMessages int, ttl time.Duration) *MessageCache {
+	return &MessageCache{
+		MessageQueries: messages,
+		maxMessages:    maxMessages,
+		ttl:            ttl,
+		rooms:          make(map[int64]*list.Element),
+		recent:         list.New(),
+	}
+}
+
+// Create saves a message and appends it to its room's window
+// A message saved without its author's name (the hub's join and leave
+// announcements) drops the window instead: only the database knows the name
+func (c *MessageCache) Create(ctx context.Context, message *Message) error {
+	if err := c.MessageQueries.Create(ctx, message); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.rooms[message.RoomID]
+	if !ok {
+		return nil
+	}
+	w := elem.Value.(*roomWindow)
+	w.gen++
+	if !w.loaded {
+		return nil
+	}
+	if message.Username == "" {
+		c.remove(elem)
+		return nil
+	}
+
+	// Concurrent inserts can finish out of ID order, and a load that ran
+	// after the insert committed already has it
+	i := sort.Search(len(w.messages), func(i int) bool { return w.messages[i].ID >= message.ID })
+	if i < len(w.messages) && w.messages[i].ID == message.ID {
+		return nil
+	}
+	if i == 0 && !w.complete {
+		return nil // Older than the window: it isn't part of the tail
+	}
+	w.messages = append(w.messages, nil)
+	copy(w.messages[i+1:], w.messages[i:])
+	w.messages[i] = cachedCopy(message)
+	c.messages++
+
+	if limit := c.windowLimit(); len(w.messages) > limit {
+		c.messages -= len(w.messages) - limit
+		w.messages = append([]*Message(nil), w.messages[len(w.messages)-limit:]...)
+		w.complete = false
+	}
+	c.evict()
+	return nil
+}
+
+// GetRoomMessages returns a room's newest messages, from its window when it
+// holds at least limit of them (or all the room has), else from the store,
+// which (re)loads the window
+func (c *MessageCache) GetRoomMessages(ctx context.Context, roomID int64, limit int) ([]*Message, error) {
+	limit, _ = clampPage(limit, 0, defaultRoomMessagesLimit, maxHistoryPageLimit)
+
+	c.mu.Lock()
+	if w := c.window(roomID); w != nil && (w.complete || len(w.messages) >= limit) {
+		messages := copyMessages(w.messages[max(len(w.messages)-limit, 0):])
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return messages, nil
+	}
+	elem, ok := c.rooms[roomID]
+	if !ok {
+		elem = c.recent.PushFront(&roomWindow{roomID: roomID})
+		c.rooms[roomID] = elem
+	}
+	gen := elem.Value.(*roomWindow).gen
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	messages, err := c.MessageQueries.GetRoomMessages(ctx, roomID, limit)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Invalidated, evicted or written to while loading: the load may be stale
+	if c.rooms[roomID] != elem || elem.Value.(*roomWindow).gen != gen {
+		return messages, err
+	}
+	w := elem.Value.(*roomWindow)
+	if err != nil {
+		if !w.loaded {
+			c.remove(elem)
+		}
+		return nil, err
+	}
+	kept := messages[max(len(messages)-c.windowLimit(), 0):]
+	c.messages += len(kept) - len(w.messages)
+	w.messages = copyMessages(kept)
+	w.complete = len(messages) < limit
+	w.loaded = true
+	w.loadedAt = time.Now()
+	c.recent.MoveToFront(elem)
+	c.evict()
+	return messages, nil
+}
+
+// GetMessagesBefore returns a page of a room's history from its window when
+// the whole page is in it, else from the store
+// Pages further back than the window aren't cached
+func (c *MessageCache) GetMessagesBefore(ctx context.Context, roomID, beforeID int64, limit int) ([]*Message, error) {
+	limit, _ = clampPage(limit, 0, defaultMessagesBeforeLimit, maxHistoryPageLimit)
+
+	c.mu.Lock()
+	if w := c.window(roomID); w != nil {
+		end := sort.Search(len(w.messages), func(i int) bool { return w.messages[i].ID >= beforeID })
+		if w.complete || end >= limit {
+			messages := copyMessages(w.messages[max(end-limit, 0):end])
+			c.mu.Unlock()
+			c.hits.Add(1)
+			return messages, nil
+		}
+	}
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	return c.MessageQueries.GetMessagesBefore(ctx, roomID, beforeID, limit)
+}
+
+// PurgeRoomExpired deletes a room's expired messages, dropping its window if any were
+func (c *MessageCache) PurgeRoomExpired(ctx context.Context, roomID int64, retention time.Duration, batchSize int) (int64, error) {
+	purged, err := c.MessageQueries.PurgeRoomExpired(ctx, roomID, retention, batchSize)
+	if purged > 0 {
+		c.Invalidate(roomID)
+	}
+	return purged, err
+}
+
+// Invalidate drops rooms' windows, for changes to their messages made
+// without Create: redactions, merges, and edits and deletions
+// The next GetRoomMessages reloads them from the store
+func (c *MessageCache) Invalidate(roomIDs ...int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, roomID := range roomIDs {
+		if elem, ok := c.rooms[roomID]; ok {
+			c.remove(elem)
+		}
+	}
+}
+
+// Stats returns the cache's current usage
+func (c *MessageCache) Stats() *MessageCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &MessageCacheStats{
+		Rooms:       len(c.rooms),
+		Messages:    c.messages,
+		MaxMessages: c.maxMessages,
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+	}
+}
+
+// window returns a room's loaded window if it's fresh, marking it recently read
+// The caller holds mu
+func (c *MessageCache) window(roomID int64) *roomWindow {
+	elem, ok := c.rooms[roomID]
+	if !ok {
+		return nil
+	}
+	w := elem.Value.(*roomWindow)
+	if !w.loaded || (c.ttl > 0 && time.Since(w.loadedAt) >= c.ttl) {
+		return nil
+	}
+	c.recent.MoveToFront(elem)
+	return w
+}
+
+// windowLimit is the most messages one room's window holds: the largest
+// page, unless the whole cache is smaller
+func (c *MessageCache) windowLimit() int {
+	return min(maxHistoryPageLimit, c.maxMessages)
+}
+
+// evict drops the least recently read windows until the cache is within its maximum
+// The caller holds mu
+func (c *MessageCache) evict() {
+	for c.messages > c.maxMessages {
+		c.remove(c.recent.Back())
+	}
+}
+
+// remove drops a window; the caller holds mu
+func (c *MessageCache) remove(elem *list.Element) {
+	w := c.recent.Remove(elem).(*roomWindow)
+	delete(c.rooms, w.roomID)
+	c.messages -= len(w.messages)
+}
+
+// cachedCopy is a saved message as the store would read it back
+func cachedCopy(message *Message) *Message {
+	copied := *message
+	copied.ContentHash = ""
+	setDerivedFields(&copied)
+	return &copied
+}
+
+// copyMessages copies messages for a caller, which may change them
+// (system messages are rendered in place, for one)
+func copyMessages(messages []*Message) []*Message {
+	copied := make([]*Message, len(messages))
+	for i, m := range messages {
+		c := *m
+		copied[i] = &c
+	}
+	return copied
+}
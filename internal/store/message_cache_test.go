@@ -0,0 +1,237 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryMessages stands in for the database behind a MessageCache: it keeps
+// messages in memory, oldest first, and counts the history reads that reach it
+type memoryMessages struct {
+	MessageQueries
+	mu       sync.Mutex
+	messages []*Message
+	reads    int
+
+	// beforeRead, if set, runs as a history read starts, once
+	beforeRead func()
+}
+
+func (m *memoryMessages) Create(_ context.Context, message *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	message.ID = int64(len(m.messages) + 1)
+	message.CreatedAt = time.Now()
+	message.System = message.UserID == SystemUserID
+	copied := *message
+	copied.ContentHash = ""
+	if copied.Username == "" {
+		copied.Username = "system"
+	}
+	m.messages = append(m.messages, &copied)
+	return nil
+}
+
+func (m *memoryMessages) GetRoomMessages(ctx context.Context, roomID int64, limit int) ([]*Message, error) {
+	return m.GetMessagesBefore(ctx, roomID, 1<<62, limit)
+}
+
+func (m *memoryMessages) GetMessagesBefore(_ context.Context, roomID, beforeID int64, limit int) ([]*Message, error) {
+	if before := m.beforeRead; before != nil {
+		m.beforeRead = nil
+		before()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reads++
+	var messages []*Message
+	for _, message := range m.messages {
+		if message.RoomID == roomID && message.ID < beforeID {
+			copied := *message
+			messages = append(messages, &copied)
+		}
+	}
+	return messages[max(len(messages)-limit, 0):], nil
+}
+
+// edit changes a message's content without going through the cache, as an
+// edit or a redaction does
+func (m *memoryMessages) edit(id int64, content string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages[id-1].Content = content
+}
+
+// send saves n messages from ada in roomID
+func send(t testing.TB, messages MessageQueries, roomID int64, n int) {
+	t.Helper()
+	for i := range n {
+		message := &Message{RoomID: roomID, UserID: 1, Username: "ada", Content: fmt.Sprintf("message %d", i), ContentHash: "hash"}
+		if err := messages.Create(context.Background(), message); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestMessageCacheAppend loads a room's window, then sends messages through
+// the cache: history reads are answered from memory and match what the
+// database holds, older pages inside the window too, while a page further
+// back than the window and a system message without its author's name go
+// to the database
+func TestMessageCacheAppend(t *testing.T) {
+	ctx := context.Background()
+	db := &memoryMessages{}
+	cache := NewMessageCache(db, 100, time.Minute)
+	send(t, db, 1, 30)
+
+	if _, err := cache.GetRoomMessages(ctx, 1, 20); err != nil {
+		t.Fatal(err)
+	}
+	send(t, cache, 1, 5)
+	send(t, cache, 2, 5) // A room nobody read isn't cached
+
+	for _, tc := range []struct {
+		name     string
+		read     func(MessageQueries) ([]*Message, error)
+		wantRead bool
+	}{
+		{"newest", func(m MessageQueries) ([]*Message, error) { return m.GetRoomMessages(ctx, 1, 25) }, false},
+		{"page in the window", func(m MessageQueries) ([]*Message, error) { return m.GetMessagesBefore(ctx, 1, 30, 10) }, false},
+		{"page past the window", func(m MessageQueries) ([]*Message, error) { return m.GetMessagesBefore(ctx, 1, 20, 10) }, true},
+		{"more than the window", func(m MessageQueries) ([]*Message, error) { return m.GetRoomMessages(ctx, 1, 50) }, true},
+	} {
+		reads := db.reads
+		cached, err := tc.read(cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if read := db.reads > reads; read != tc.wantRead {
+			t.Errorf("%s: read the database %v, want %v", tc.name, read, tc.wantRead)
+		}
+		stored, _ := tc.read(db)
+		if !reflect.DeepEqual(cached, stored) {
+			t.Errorf("%s: the cache returned %d messages that differ from the database's %d", tc.name, len(cached), len(stored))
+		}
+	}
+
+	// Callers render system messages in place; that mustn't reach the cache
+	page, _ := cache.GetRoomMessages(ctx, 1, 1)
+	page[0].Content = "rendered"
+	if again, _ := cache.GetRoomMessages(ctx, 1, 1); again[0].Content != "message 4" {
+		t.Errorf("the cached message reads %q after a caller changed its copy", again[0].Content)
+	}
+
+	if err := cache.Create(ctx, &Message{RoomID: 1, UserID: SystemUserID, SystemEvent: SystemEvent{"type": "join"}}); err != nil {
+		t.Fatal(err)
+	}
+	reads := db.reads
+	if page, _ := cache.GetRoomMessages(ctx, 1, 1); db.reads == reads || page[0].Username != "system" {
+		t.Errorf("after a message without its author's name, the history was read from memory (%+v)", page[0])
+	}
+
+	if stats := cache.Stats(); stats.Rooms != 1 || stats.Hits == 0 || stats.Misses == 0 {
+		t.Errorf("the stats are %+v, want one room with hits and misses", stats)
+	}
+}
+
+// TestMessageCacheInvalidate edits a cached message in the database: the
+// window serves the old content until the room is invalidated, then the
+// edit. Purging expired messages drops the window too
+func TestMessageCacheInvalidate(t *testing.T) {
+	ctx := context.Background()
+	db := &memoryMessages{}
+	cache := NewMessageCache(&purgingMessages{db}, 100, 0)
+	send(t, db, 1, 3)
+	cache.GetRoomMessages(ctx, 1, 10)
+
+	db.edit(2, RedactedContent)
+	if page, _ := cache.GetRoomMessages(ctx, 1, 10); page[1].Content != "message 1" {
+		t.Fatalf("before invalidating, message 2 reads %q, want it from the window", page[1].Content)
+	}
+	cache.Invalidate(1)
+	if page, _ := cache.GetRoomMessages(ctx, 1, 10); page[1].Content != RedactedContent {
+		t.Errorf("after invalidating, message 2 reads %q, want the edit", page[1].Content)
+	}
+
+	reads := db.reads
+	cache.PurgeRoomExpired(ctx, 1, time.Hour, 100)
+	cache.GetRoomMessages(ctx, 1, 10)
+	if db.reads == reads {
+		t.Error("after purging expired messages, the history was read from memory")
+	}
+}
+
+// purgingMessages purges one message from every room
+type purgingMessages struct {
+	*memoryMessages
+}
+
+func (p *purgingMessages) PurgeRoomExpired(context.Context, int64, time.Duration, int) (int64, error) {
+	return 1, nil
+}
+
+// TestMessageCacheEviction reads more rooms than fit: the least recently
+// read window goes first, and a window written to while it loads isn't kept
+func TestMessageCacheEviction(t *testing.T) {
+	ctx := context.Background()
+	db := &memoryMessages{}
+	cache := NewMessageCache(db, 25, 0)
+	for room := int64(1); room <= 3; room++ {
+		send(t, db, room, 10)
+	}
+
+	cache.GetRoomMessages(ctx, 1, 10)
+	cache.GetRoomMessages(ctx, 2, 10)
+	cache.GetRoomMessages(ctx, 1, 10) // Room 1 is now the most recent
+	cache.GetRoomMessages(ctx, 3, 10) // Room 2 makes room
+	if stats := cache.Stats(); stats.Rooms != 2 || stats.Messages != 20 {
+		t.Errorf("the stats are %+v, want 2 rooms holding 20 messages", stats)
+	}
+	reads := db.reads
+	cache.GetRoomMessages(ctx, 1, 10)
+	if db.reads != reads {
+		t.Error("room 1 was evicted, want room 2 evicted")
+	}
+
+	// A message saved while room 4 loads: the load may have missed it
+	db.beforeRead = func() { send(t, cache, 4, 1) }
+	cache.GetRoomMessages(ctx, 4, 10)
+	reads = db.reads
+	cache.GetRoomMessages(ctx, 4, 10)
+	if db.reads == reads {
+		t.Error("a window written to while it loaded was kept")
+	}
+}
+
+// BenchmarkHotRoomHistory has a busy room's members open it between
+// messages, as they do all day: with the cache, history reads stop reaching
+// the database once the window is loaded
+func BenchmarkHotRoomHistory(b *testing.B) {
+	for _, tc := range []struct {
+		name   string
+		cached bool
+	}{{"database", false}, {"cached", true}} {
+		b.Run(tc.name, func(b *testing.B) {
+			db := &memoryMessages{}
+			var messages MessageQueries = db
+			if tc.cached {
+				messages = NewMessageCache(db, 10000, time.Minute)
+			}
+			send(b, db, 1, 100)
+
+			ctx := context.Background()
+			reads := db.reads
+			for b.Loop() {
+				send(b, messages, 1, 1)
+				if _, err := messages.GetRoomMessages(ctx, 1, 50); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(db.reads-reads)/float64(b.N), "queries/op")
+		})
+	}
+}
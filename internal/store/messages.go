@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"time"
+
+	"github.com/drazan344/go-chat/internal/content"
 )
 
 // Message represents a chat message in a room
@@ -11,31 +13,127 @@ import (
 type Message struct {
 	ID        int64     `json:"id"`
 	RoomID    int64     `json:"room_id"`
-	UserID    int64     `json:"user_id"`
+	UserID    int64     `json:"user_id"` // 0 once the author's account is deleted
 	Content   string    `json:"content"`
-	Username  string    `json:"username"`  // Joined from users table for display purposes
+	Username  string    `json:"username"` // Joined from users table for display purposes
 	CreatedAt time.Time `json:"created_at"`
+
+	// AuthorDeleted is true once the author's account is deleted; Username is
+	// then the name they had when they sent it (see DeletedUsername)
+	AuthorDeleted bool `json:"author_deleted,omitempty"`
+
+	// ContentType tells clients how to render Content: "text", "markdown", or "code"
+	ContentType string `json:"content_type"`
+	// Language is the highlighting language of code messages (empty otherwise)
+	Language string `json:"language,omitempty"`
+
+	// Filtered is true if the content filter masked part of the message
+	Filtered bool `json:"filtered,omitempty"`
+
+	// Truncated is true if the message was cut down to the length limit
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Override is true if a room admin posted it during quiet hours
+	Override bool `json:"override,omitempty"`
+
+	// Moderated is true if the room's moderation bot rewrote the content
+	Moderated bool `json:"moderated,omitempty"`
+
+	// System is true for messages the server posted as the system user (SystemUserID)
+	System bool `json:"system,omitempty"`
+
+	// SystemEvent is what a system message says, saved instead of text: Content
+	// is empty in the database and rendered from the event in the room's
+	// language when the message is read or broadcast (see internal/sysmsg)
+	SystemEvent SystemEvent `json:"system_event,omitempty"`
+
+	// LegacySystem marks system messages saved as rendered text, before system
+	// messages had events; their content is shown as it is
+	LegacySystem bool `json:"legacy_system,omitempty"`
+
+	// OverrideUsername and OverrideAvatarURL are who a bridge bot relayed the
+	// message for, shown in place of the bot's own name and avatar
+	// They're display only: UserID and Username stay the bot's, for
+	// permissions and auditing, and the name is never resolved to a user
+	OverrideUsername  string `json:"override_username,omitempty"`
+	OverrideAvatarURL string `json:"override_avatar_url,omitempty"`
+
+	// Redacted is true once a platform admin removed the message (see
+	// RedactionStore.Redact); Content is then RedactedContent
+	Redacted bool `json:"redacted,omitempty"`
+
+	// RoomMention is "here" or "room" when the message notified the room with
+	// @here or @room (see content.RoomMention); empty otherwise, including when
+	// the sender wrote one without mention_everyone and it stayed plain text
+	RoomMention string `json:"room_mention,omitempty"`
+
+	// ContentHash identifies identical messages (see content.Hash)
+	// Set it from the content as sent, before any masking; Create fills it in if empty
+	ContentHash string `json:"-"`
 }
 
+// Page sizes of GetRoomMessages and GetMessagesBefore
+const (
+	defaultRoomMessagesLimit   = 100
+	defaultMessagesBeforeLimit = 50
+	maxHistoryPageLimit        = 500
+)
+
+// DeletedUsername is shown as the author of a message whose author's account
+// is deleted, when the name they had isn't known
+const DeletedUsername = "deleted user"
+
 // MessageStore handles database operations for messages
 type MessageStore struct {
-	db *sql.DB
+	db    *sql.DB
+	reads *Pools // Older history pages may run on the read replica (see readAffinity)
 }
 
 // Create inserts a new message into the database
-// The message must belong to a room and be sent by a user
+// The message must belong to a room and be sent by a user, whose current
+// username is saved with it for when their account is deleted
+// The room's last_message_at is bumped in the same transaction, so activity
+// ordering can never disagree with the messages table
 func (s *MessageStore) Create(ctx context.Context, message *Message) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
 	query := `
-		INSERT INTO messages (room_id, user_id, content)
-		VALUES ($1, $2, $3) RETURNING id, created_at
+		INSERT INTO messages (room_id, user_id, content, content_type, language, filtered, truncated, quiet_override, moderated, content_hash,
+			override_username, override_avatar_url, system_event, room_mention, author_username)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10, NULLIF($11, ''), NULLIF($12, ''), $13, NULLIF($14, ''),
+			COALESCE((SELECT username FROM users WHERE id = $2), '')) RETURNING id, created_at
 	`
 
-	err := s.db.QueryRowContext(
+	// Messages created without a content type are plain text
+	if message.ContentType == "" {
+		message.ContentType = "text"
+	}
+	if message.ContentHash == "" {
+		message.ContentHash = content.Hash(message.Content)
+	}
+
+	err = tx.QueryRowContext(
 		ctx,
 		query,
 		message.RoomID,
 		message.UserID,
 		message.Content,
+		message.ContentType,
+		message.Language,
+		message.Filtered,
+		message.Truncated,
+		message.Override,
+		message.Moderated,
+		message.ContentHash,
+		message.OverrideUsername,
+		message.OverrideAvatarURL,
+		message.SystemEvent,
+		message.RoomMention,
 	).Scan(
 		&message.ID,
 		&message.CreatedAt,
@@ -43,25 +141,328 @@ func (s *MessageStore) Create(ctx context.Context, message *Message) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	message.System = message.UserID == SystemUserID
+
+	// GREATEST guards against a slower concurrent insert moving the timestamp backwards
+	activityQuery := `
+		UPDATE rooms
+		SET last_message_at = GREATEST(COALESCE(last_message_at, $1), $1)
+		WHERE id = $2
+	`
+	if _, err := tx.ExecContext(ctx, activityQuery, message.CreatedAt, message.RoomID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CountRecentIdentical counts a user's messages in a room with the given content
+// hash that were sent within the last window
+// Backed by idx_messages_duplicates, so it stays cheap on busy rooms
+func (s *MessageStore) CountRecentIdentical(ctx context.Context, roomID, userID int64, contentHash string, window time.Duration) (int, error) {
+	query := `
+		SELECT COUNT(*) FROM messages
+		WHERE room_id = $1 AND user_id = $2 AND content_hash = $3
+			AND created_at > NOW() - make_interval(secs => $4)
+	`
+
+	var count int
+	err := s.db.QueryRowContext(ctx, query, roomID, userID, contentHash, window.Seconds()).Scan(&count)
+	return count, err
+}
+
+// GetByID retrieves a single message with its author's username
+// Messages whose author's account was deleted are returned too (see scanMessage)
+func (s *MessageStore) GetByID(ctx context.Context, id int64) (*Message, error) {
+	query := `
+		SELECT m.id, m.room_id, COALESCE(m.user_id, 0), m.content, COALESCE(u.username, m.author_username), m.created_at,
+			m.content_type, COALESCE(m.language, ''), m.filtered, m.truncated, m.quiet_override, m.moderated,
+			COALESCE(m.override_username, ''), COALESCE(m.override_avatar_url, ''), m.system_event,
+			m.redacted_at IS NOT NULL, COALESCE(m.room_mention, '')
+		FROM messages m
+		LEFT JOIN users u ON m.user_id = u.id
+		WHERE m.id = $1
+	`
+
+	return scanMessage(s.db.QueryRowContext(ctx, query, id))
+}
+
+// scanMessage scans a row selected with the message columns the queries in
+// this file share: id, room_id, user_id, content, username, created_at,
+// content_type, language, filtered, truncated, quiet_override, moderated,
+// override_username, override_avatar_url, system_event, redacted, room_mention
+// A deleted author's user_id is NULL and selected as 0; the users join is a
+// LEFT JOIN falling back to messages.author_username, so their messages keep
+// their name, or DeletedUsername for messages saved before names were kept
+func scanMessage(row rowScanner) (*Message, error) {
+	message := &Message{}
+	err := row.Scan(
+		&message.ID,
+		&message.RoomID,
+		&message.UserID,
+		&message.Content,
+		&message.Username,
+		&message.CreatedAt,
+		&message.ContentType,
+		&message.Language,
+		&message.Filtered,
+		&message.Truncated,
+		&message.Override,
+		&message.Moderated,
+		&message.OverrideUsername,
+		&message.OverrideAvatarURL,
+		&message.SystemEvent,
+		&message.Redacted,
+		&message.RoomMention,
+	)
+	if err != nil {
+		return nil, err
+	}
+	setDerivedFields(message)
+	return message, nil
+}
+
+// setDerivedFields fills in the fields of a scanned message that aren't columns
+func setDerivedFields(message *Message) {
+	message.System = message.UserID == SystemUserID
+	message.LegacySystem = message.System && message.SystemEvent == nil
+	if message.UserID == 0 {
+		message.AuthorDeleted = true
+		if message.Username == "" {
+			message.Username = DeletedUsername
+		}
+	}
+}
+
+// roomMessagesQuery selects a room's latest messages, newest first
+// IDs are unique and increase with every insert, so ordering by ID gives the
+// same order on every call; created_at doesn't, as messages can share a
+// timestamp. The (room_id, id) index serves it (see TestQueryPlans)
+const roomMessagesQuery = `
+	SELECT m.id, m.room_id, COALESCE(m.user_id, 0), m.content, COALESCE(u.username, m.author_username), m.created_at,
+		m.content_type, COALESCE(m.language, ''), m.filtered, m.truncated, m.quiet_override, m.moderated,
+		COALESCE(m.override_username, ''), COALESCE(m.override_avatar_url, ''), m.system_event,
+		m.redacted_at IS NOT NULL, COALESCE(m.room_mention, '')
+	FROM messages m
+	LEFT JOIN users u ON m.user_id = u.id
+	WHERE m.room_id = $1
+	ORDER BY m.id DESC
+	LIMIT $2
+`
+
+// messagesBeforeQuery selects one page of a room's history: the messages
+// before an ID, newest first. Like roomMessagesQuery it's served by the
+// (room_id, id) index, however far back the page is
+const messagesBeforeQuery = `
+	SELECT m.id, m.room_id, COALESCE(m.user_id, 0), m.content, COALESCE(u.username, m.author_username), m.created_at,
+		m.content_type, COALESCE(m.language, ''), m.filtered, m.truncated, m.quiet_override, m.moderated,
+		COALESCE(m.override_username, ''), COALESCE(m.override_avatar_url, ''), m.system_event,
+		m.redacted_at IS NOT NULL, COALESCE(m.room_mention, '')
+	FROM messages m
+	LEFT JOIN users u ON m.user_id = u.id
+	WHERE m.room_id = $1 AND m.id < $2
+	ORDER BY m.id DESC
+	LIMIT $3
+`
+
+// messagesSinceQuery selects a room's messages after a point in time, oldest first
+// ID breaks ties between messages with the same timestamp
+const messagesSinceQuery = `
+	SELECT m.id, m.room_id, COALESCE(m.user_id, 0), m.content, COALESCE(u.username, m.author_username), m.created_at,
+		m.content_type, COALESCE(m.language, ''), m.filtered, m.truncated, m.quiet_override, m.moderated,
+		COALESCE(m.override_username, ''), COALESCE(m.override_avatar_url, ''), m.system_event,
+		m.redacted_at IS NOT NULL, COALESCE(m.room_mention, '')
+	FROM messages m
+	LEFT JOIN users u ON m.user_id = u.id
+	WHERE m.room_id = $1 AND m.created_at > $2
+	ORDER BY m.created_at ASC, m.id ASC
+`
+
+// messagesAroundQuery selects the messages on both sides of an anchor message
+// in one round trip: the anchor and up to $3 older messages, plus up to $4
+// newer ones, oldest first. Each half is a keyset scan of the (room_id, id)
+// index, wherever in the history the anchor is. Callers ask for one more
+// message per side than they want, to learn whether there are more
+const messagesAroundQuery = `
+	SELECT * FROM (
+		(SELECT m.id, m.room_id, COALESCE(m.user_id, 0), m.content, COALESCE(u.username, m.author_username), m.created_at,
+			m.content_type, COALESCE(m.language, ''), m.filtered, m.truncated, m.quiet_override, m.moderated,
+			COALESCE(m.override_username, ''), COALESCE(m.override_avatar_url, ''), m.system_event,
+			m.redacted_at IS NOT NULL, COALESCE(m.room_mention, '')
+		FROM messages m
+		LEFT JOIN users u ON m.user_id = u.id
+		WHERE m.room_id = $1 AND m.id <= $2
+		ORDER BY m.id DESC
+		LIMIT $3)
+		UNION ALL
+		(SELECT m.id, m.room_id, COALESCE(m.user_id, 0), m.content, COALESCE(u.username, m.author_username), m.created_at,
+			m.content_type, COALESCE(m.language, ''), m.filtered, m.truncated, m.quiet_override, m.moderated,
+			COALESCE(m.override_username, ''), COALESCE(m.override_avatar_url, ''), m.system_event,
+			m.redacted_at IS NOT NULL, COALESCE(m.room_mention, '')
+		FROM messages m
+		LEFT JOIN users u ON m.user_id = u.id
+		WHERE m.room_id = $1 AND m.id > $2
+		ORDER BY m.id ASC
+		LIMIT $4)
+	) around
+	ORDER BY id ASC
+`
+
+// MaxContextMessages caps how many messages GetMessagesAround returns on each side of the anchor
+const MaxContextMessages = 100
+
+// MessageWindow is a stretch of a room's history around one message
+type MessageWindow struct {
+	AnchorID      int64      `json:"anchor_id"`
+	Messages      []*Message `json:"messages"` // Oldest first, including the anchor
+	HasMoreBefore bool       `json:"has_more_before"`
+	HasMoreAfter  bool       `json:"has_more_after"`
+}
+
+// GetMessagesAround retrieves a message with up to before messages older and
+// after messages newer than it, for opening a room at a linked message
+// Both counts are clamped to 0..MaxContextMessages. Returns sql.ErrNoRows if
+// the anchor isn't a message of the room
+func (s *MessageStore) GetMessagesAround(ctx context.Context, roomID, aroundID int64, before, after int) (*MessageWindow, error) {
+	before = min(max(before, 0), MaxContextMessages)
+	after = min(max(after, 0), MaxContextMessages)
+
+	// The older half includes the anchor itself
+	rows, err := s.db.QueryContext(ctx, messagesAroundQuery, roomID, aroundID, before+2, after+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]*Message, 0, before+after+3)
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return messageWindow(messages, aroundID, before, after)
+}
+
+// messageWindow trims the rows of messagesAroundQuery to before and after
+// messages on each side of the anchor, noting which side had more
+func messageWindow(messages []*Message, aroundID int64, before, after int) (*MessageWindow, error) {
+	anchor := -1
+	for i, m := range messages {
+		if m.ID == aroundID {
+			anchor = i
+			break
+		}
+	}
+	if anchor < 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	window := &MessageWindow{AnchorID: aroundID}
+	start, end := 0, len(messages)
+	if anchor > before {
+		start = anchor - before
+		window.HasMoreBefore = true
+	}
+	if end-anchor-1 > after {
+		end = anchor + 1 + after
+		window.HasMoreAfter = true
+	}
+	window.Messages = messages[start:end]
+	return window, nil
+}
+
+// Histogram granularities: the date_trunc field each bucket is truncated to
+const (
+	HistogramDay  = "day"
+	HistogramWeek = "week" // ISO weeks, starting on Monday
+)
+
+// MaxHistogramBuckets caps how many buckets one Histogram call may span
+const MaxHistogramBuckets = 366
+
+// ActivityBucket is a room's activity in one day or week
+type ActivityBucket struct {
+	PeriodStart     time.Time `json:"period_start"` // UTC
+	MessageCount    int       `json:"message_count"`
+	ActiveUserCount int       `json:"active_user_count"` // Distinct senders, not counting the system user
+}
+
+// histogramQuery counts a room's messages and senders per bucket in one pass
+// over the (room_id, created_at) index; buckets without messages aren't returned
+const histogramQuery = `
+	SELECT date_trunc($2, m.created_at) AS period_start, COUNT(*),
+		COUNT(DISTINCT m.user_id) FILTER (WHERE m.user_id > 0)
+	FROM messages m
+	WHERE m.room_id = $1 AND m.created_at >= $3 AND m.created_at < $4
+	GROUP BY period_start
+	ORDER BY period_start
+`
+
+// Histogram returns a room's activity per day or week between from
+// (inclusive) and to (exclusive), oldest first, for a jump-to-date scrollbar
+// Times are UTC. Empty buckets are left out; the caller fills the gaps
+func (s *MessageStore) Histogram(ctx context.Context, roomID int64, granularity string, from, to time.Time) ([]*ActivityBucket, error) {
+	rows, err := s.reads.query(ctx, "MessageStore.Histogram", histogramQuery, roomID, granularity, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := make([]*ActivityBucket, 0)
+	for rows.Next() {
+		bucket := &ActivityBucket{}
+		if err := rows.Scan(&bucket.PeriodStart, &bucket.MessageCount, &bucket.ActiveUserCount); err != nil {
+			return nil, err
+		}
+		// TIMESTAMP columns come back with an unnamed zero offset; they're UTC
+		bucket.PeriodStart = bucket.PeriodStart.UTC()
+		buckets = append(buckets, bucket)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
+// firstMessageAtQuery finds a room's first message at or after a time
+const firstMessageAtQuery = `
+	SELECT m.id
+	FROM messages m
+	WHERE m.room_id = $1 AND m.created_at >= $2
+	ORDER BY m.created_at ASC, m.id ASC
+	LIMIT 1
+`
+
+// FirstMessageAt returns the ID of a room's first message at or after t
+// Returns sql.ErrNoRows if nothing was posted since
+func (s *MessageStore) FirstMessageAt(ctx context.Context, roomID int64, t time.Time) (int64, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx, firstMessageAtQuery, roomID, t.UTC()).Scan(&id)
+	return id, err
+}
+
+// CountInRoom returns how many messages a room has, for the impact summary
+// shown before it's deleted; it scans the room's index, so it's a bulk operation
+func (s *MessageStore) CountInRoom(ctx context.Context, roomID int64) (int, error) {
+	var count int
+	err := s.reads.queryRow(ctx, "MessageStore.CountInRoom", `SELECT COUNT(*) FROM messages WHERE room_id = $1`, []interface{}{roomID}, &count)
+	return count, err
 }
 
 // GetRoomMessages retrieves the most recent messages for a room
 // Messages are joined with the users table to include the username
 // The limit parameter controls how many messages to return (e.g., last 100 messages)
 func (s *MessageStore) GetRoomMessages(ctx context.Context, roomID int64, limit int) ([]*Message, error) {
-	// Join with users table to get username for display
-	// Order by created_at DESC and then reverse in code, or use a subquery
-	query := `
-		SELECT m.id, m.room_id, m.user_id, m.content, u.username, m.created_at
-		FROM messages m
-		INNER JOIN users u ON m.user_id = u.id
-		WHERE m.room_id = $1
-		ORDER BY m.created_at DESC
-		LIMIT $2
-	`
+	limit, _ = clampPage(limit, 0, defaultRoomMessagesLimit, maxHistoryPageLimit)
 
-	rows, err := s.db.QueryContext(ctx, query, roomID, limit)
+	rows, err := s.reads.query(ctx, "MessageStore.GetRoomMessages", roomMessagesQuery, roomID, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -70,15 +471,7 @@ func (s *MessageStore) GetRoomMessages(ctx context.Context, roomID int64, limit
 	// Store messages in reverse order since we queried DESC but want to display ASC
 	messages := make([]*Message, 0, limit)
 	for rows.Next() {
-		message := &Message{}
-		err := rows.Scan(
-			&message.ID,
-			&message.RoomID,
-			&message.UserID,
-			&message.Content,
-			&message.Username,
-			&message.CreatedAt,
-		)
+		message, err := scanMessage(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -98,18 +491,43 @@ func (s *MessageStore) GetRoomMessages(ctx context.Context, roomID int64, limit
 	return messages, nil
 }
 
+// GetMessagesBefore retrieves up to limit messages older than beforeID, for
+// paging back through a room's history
+// Messages are returned oldest first, like GetRoomMessages
+func (s *MessageStore) GetMessagesBefore(ctx context.Context, roomID, beforeID int64, limit int) ([]*Message, error) {
+	limit, _ = clampPage(limit, 0, defaultMessagesBeforeLimit, maxHistoryPageLimit)
+
+	rows, err := s.reads.query(ctx, "MessageStore.GetMessagesBefore", messagesBeforeQuery, roomID, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]*Message, 0, limit)
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Newest first from the query; callers want chronological order
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages, nil
+}
+
 // GetMessagesSince retrieves all messages in a room since a specific timestamp
 // This is useful for clients that reconnect and want to catch up on missed messages
 func (s *MessageStore) GetMessagesSince(ctx context.Context, roomID int64, since time.Time) ([]*Message, error) {
-	query := `
-		SELECT m.id, m.room_id, m.user_id, m.content, u.username, m.created_at
-		FROM messages m
-		INNER JOIN users u ON m.user_id = u.id
-		WHERE m.room_id = $1 AND m.created_at > $2
-		ORDER BY m.created_at ASC
-	`
-
-	rows, err := s.db.QueryContext(ctx, query, roomID, since)
+	rows, err := s.db.QueryContext(ctx, messagesSinceQuery, roomID, since)
 	if err != nil {
 		return nil, err
 	}
@@ -117,15 +535,55 @@ func (s *MessageStore) GetMessagesSince(ctx context.Context, roomID int64, since
 
 	messages := make([]*Message, 0)
 	for rows.Next() {
-		message := &Message{}
-		err := rows.Scan(
-			&message.ID,
-			&message.RoomID,
-			&message.UserID,
-			&message.Content,
-			&message.Username,
-			&message.CreatedAt,
-		)
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// messageSearchQuery selects a room's messages whose content contains $2,
+// newest first. $2 is escaped with escapeLike; system messages are saved
+// without content, so they never match, and redacted messages are skipped
+// so searching for the redaction marker doesn't list them
+const messageSearchQuery = `
+	SELECT m.id, m.room_id, COALESCE(m.user_id, 0), m.content, COALESCE(u.username, m.author_username), m.created_at,
+		m.content_type, COALESCE(m.language, ''), m.filtered, m.truncated, m.quiet_override, m.moderated,
+		COALESCE(m.override_username, ''), COALESCE(m.override_avatar_url, ''), m.system_event,
+		m.redacted_at IS NOT NULL, COALESCE(m.room_mention, '')
+	FROM messages m
+	LEFT JOIN users u ON m.user_id = u.id
+	WHERE m.room_id = $1 AND m.content ILIKE '%' || $2 || '%' AND m.redacted_at IS NULL
+	ORDER BY m.id DESC
+	LIMIT $3
+`
+
+// Search finds a room's messages whose content contains q, ignoring case
+// Messages are returned newest first, since that's what a search box shows
+func (s *MessageStore) Search(ctx context.Context, roomID int64, q string, limit int) ([]*Message, error) {
+	// q is matched literally, so LIKE's wildcards in it must be escaped
+	q = searchTerm(q)
+	if q == "" {
+		return make([]*Message, 0), nil
+	}
+	limit, _ = clampPage(limit, 0, 20, maxRoomSearchLimit)
+
+	rows, err := s.reads.query(ctx, "MessageStore.Search", messageSearchQuery, roomID, escapeLike(q), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]*Message, 0, limit)
+	for rows.Next() {
+		message, err := scanMessage(rows)
 		if err != nil {
 			return nil, err
 		}
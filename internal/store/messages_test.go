@@ -0,0 +1,269 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/drazan344/go-chat/internal/content"
+)
+
+// TestCreateBumpsLastActivity saves a truncated message: the insert and the
+// room's last_message_at bump commit together, and a failed bump saves nothing
+func TestCreateBumpsLastActivity(t *testing.T) {
+	db, mock := newMockDB(t)
+	messages := &MessageStore{db, NewPools(db, nil)}
+	createdAt := time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO messages`).WithArgs(int64(1), int64(2), "hello", "text", "", false, true, false, false, content.Hash("hello"), "", "", nil, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, createdAt))
+	mock.ExpectExec(`UPDATE rooms\s+SET last_message_at = GREATEST`).WithArgs(createdAt, int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	message := &Message{RoomID: 1, UserID: 2, Content: "hello", Truncated: true}
+	if err := messages.Create(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+	if message.ID != 7 || !message.CreatedAt.Equal(createdAt) {
+		t.Errorf("the message was saved as %d at %s, want 7 at %s", message.ID, message.CreatedAt, createdAt)
+	}
+
+	bumpFailed := errors.New("rooms is locked")
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO messages`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(8, createdAt))
+	mock.ExpectExec(`UPDATE rooms`).WillReturnError(bumpFailed)
+	mock.ExpectRollback()
+
+	if err := messages.Create(context.Background(), &Message{RoomID: 1, UserID: 2, Content: "again"}); !errors.Is(err, bumpFailed) {
+		t.Errorf("a failed bump returned %v, want %v", err, bumpFailed)
+	}
+}
+
+// TestCountRecentIdentical checks the window is passed in seconds, so a
+// fractional window isn't cut to whole minutes or lost
+func TestCountRecentIdentical(t *testing.T) {
+	db, mock := newMockDB(t)
+	messages := &MessageStore{db, NewPools(db, nil)}
+	hash := content.Hash("buy now")
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM messages\s+WHERE room_id = \$1 AND user_id = \$2 AND content_hash = \$3\s+AND created_at > NOW\(\) - make_interval\(secs => \$4\)`).
+		WithArgs(int64(1), int64(2), hash, 90.5).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+	count, err := messages.CountRecentIdentical(context.Background(), 1, 2, hash, 90*time.Second+500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("counted %d, want 3", count)
+	}
+}
+
+// messageRowColumns are the columns the message history queries select
+var messageRowColumns = []string{"id", "room_id", "user_id", "content", "username", "created_at", "content_type", "language", "filtered", "truncated", "quiet_override", "moderated", "override_username", "override_avatar_url", "system_event", "redacted", "room_mention"}
+
+// TestGetRoomMessagesOrder asks for the newest messages by ID and returns
+// them oldest first, so three messages sharing a timestamp keep their order
+// between calls, clamps a zero limit to the default, and reads whether the
+// room's moderation bot rewrote each and who a bridge relayed it for
+func TestGetRoomMessagesOrder(t *testing.T) {
+	db, mock := newMockDB(t)
+	messages := &MessageStore{db, NewPools(db, nil)}
+	at := time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`FROM messages m\s+LEFT JOIN users u ON m.user_id = u.id\s+WHERE m.room_id = \$1\s+ORDER BY m.id DESC\s+LIMIT \$2`).
+		WithArgs(int64(1), 100).
+		WillReturnRows(sqlmock.NewRows(messageRowColumns).
+			AddRow(9, 1, 2, "third", "grace", at, "text", "", false, false, false, false, "alice (IRC)", "https://irc.example.com/alice.png", nil, false, "").
+			AddRow(8, 1, 2, "second", "grace", at, "text", "", false, false, false, true, "", "", nil, false, "").
+			AddRow(7, 1, 1, "first", "ada", at, "text", "", false, false, false, false, "", "", nil, false, ""))
+
+	got, err := messages.GetRoomMessages(context.Background(), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0].ID != 7 || got[1].ID != 8 || got[2].ID != 9 {
+		t.Fatalf("got %d messages starting with %+v, want 7, 8, 9", len(got), got[0])
+	}
+	if got[0].Moderated || !got[1].Moderated {
+		t.Errorf("got moderated %v and %v, want only the second", got[0].Moderated, got[1].Moderated)
+	}
+	if got[2].OverrideUsername != "alice (IRC)" || got[2].OverrideAvatarURL != "https://irc.example.com/alice.png" || got[2].Username != "grace" || got[1].OverrideUsername != "" {
+		t.Errorf("got %q/%q relayed by %q, want alice (IRC) relayed by grace", got[2].OverrideUsername, got[2].OverrideAvatarURL, got[2].Username)
+	}
+}
+
+// TestGetMessagesSinceOrder breaks timestamp ties by ID
+func TestGetMessagesSinceOrder(t *testing.T) {
+	db, mock := newMockDB(t)
+	messages := &MessageStore{db, NewPools(db, nil)}
+	since := time.Date(2026, 3, 14, 9, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`WHERE m.room_id = \$1 AND m.created_at > \$2\s+ORDER BY m.created_at ASC, m.id ASC`).
+		WithArgs(int64(1), since).
+		WillReturnRows(sqlmock.NewRows(messageRowColumns))
+
+	if _, err := messages.GetMessagesSince(context.Background(), 1, since); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestGetMessagesBeforeOrder pages back from an ID newest first and returns
+// the page oldest first, with the limit capped at 500
+func TestGetMessagesBeforeOrder(t *testing.T) {
+	db, mock := newMockDB(t)
+	messages := &MessageStore{db, NewPools(db, nil)}
+	at := time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`WHERE m.room_id = \$1 AND m.id < \$2\s+ORDER BY m.id DESC\s+LIMIT \$3`).
+		WithArgs(int64(1), int64(10), 500).
+		WillReturnRows(sqlmock.NewRows(messageRowColumns).
+			AddRow(9, 1, 2, "second", "grace", at, "text", "", false, false, false, false, "", "", nil, false, "").
+			AddRow(4, 1, 1, "first", "ada", at, "text", "", false, false, false, false, "", "", nil, false, ""))
+
+	got, err := messages.GetMessagesBefore(context.Background(), 1, 10, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != 4 || got[1].ID != 9 {
+		t.Errorf("got %d messages, want 4 then 9", len(got))
+	}
+}
+
+// TestSystemMessagesFlagged marks the system user's messages, and only
+// theirs, as system messages when they're read back
+func TestSystemMessagesFlagged(t *testing.T) {
+	db, mock := newMockDB(t)
+	messages := &MessageStore{db, NewPools(db, nil)}
+	at := time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`ORDER BY m.id DESC`).
+		WillReturnRows(sqlmock.NewRows(messageRowColumns).
+			AddRow(8, 1, SystemUserID, "gophers was merged into this room", "system", at, "text", "", false, false, false, false, "", "", nil, false, "").
+			AddRow(7, 1, 1, "hello", "ada", at, "text", "", false, false, false, false, "", "", nil, false, ""))
+
+	got, err := messages.GetRoomMessages(context.Background(), 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].System || !got[1].System {
+		t.Errorf("got %+v, want only the second message flagged", got)
+	}
+}
+
+// messageIDs returns the IDs of messages, in order
+func messageIDs(messages []*Message) []int64 {
+	ids := make([]int64, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+// TestMessageWindow trims the rows around an anchor to the counts asked for,
+// with the extra row on each side only telling whether there are more
+func TestMessageWindow(t *testing.T) {
+	// rows returns messages with the IDs from to to
+	rows := func(from, to int64) []*Message {
+		var messages []*Message
+		for id := from; id <= to; id++ {
+			messages = append(messages, &Message{ID: id})
+		}
+		return messages
+	}
+
+	for _, tc := range []struct {
+		name          string
+		rows          []*Message
+		around        int64
+		before, after int
+		want          []int64
+		more          [2]bool
+	}{
+		{"middle", rows(1, 9), 5, 3, 3, []int64{2, 3, 4, 5, 6, 7, 8}, [2]bool{true, true}},
+		{"exactly full", rows(2, 8), 5, 3, 3, []int64{2, 3, 4, 5, 6, 7, 8}, [2]bool{false, false}},
+		{"start of history", rows(1, 6), 2, 3, 3, []int64{1, 2, 3, 4, 5}, [2]bool{false, true}},
+		{"end of history", rows(3, 9), 8, 3, 3, []int64{5, 6, 7, 8, 9}, [2]bool{true, false}},
+		{"nothing before", rows(4, 9), 5, 0, 3, []int64{5, 6, 7, 8}, [2]bool{true, true}},
+		{"nothing after", rows(1, 6), 5, 3, 0, []int64{2, 3, 4, 5}, [2]bool{true, true}},
+		{"one message", rows(5, 5), 5, 3, 3, []int64{5}, [2]bool{false, false}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			window, err := messageWindow(tc.rows, tc.around, tc.before, tc.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := messageIDs(window.Messages); !slices.Equal(got, tc.want) {
+				t.Errorf("got messages %v, want %v", got, tc.want)
+			}
+			if more := [2]bool{window.HasMoreBefore, window.HasMoreAfter}; more != tc.more || window.AnchorID != tc.around {
+				t.Errorf("got anchor %d and more %v, want %d and %v", window.AnchorID, more, tc.around, tc.more)
+			}
+		})
+	}
+
+	// The anchor isn't a message of the room when neither half found it
+	if _, err := messageWindow(rows(6, 8), 5, 3, 3); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("a missing anchor got %v, want sql.ErrNoRows", err)
+	}
+}
+
+// TestGetMessagesAround clamps the counts before asking each half of the
+// query for one extra row, plus the anchor on the older side
+func TestGetMessagesAround(t *testing.T) {
+	db, mock := newMockDB(t)
+	messages := &MessageStore{db, NewPools(db, nil)}
+	at := time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`WHERE m.room_id = \$1 AND m.id <= \$2\s+ORDER BY m.id DESC\s+LIMIT \$3\)\s+UNION ALL`).
+		WithArgs(int64(1), int64(8), MaxContextMessages+2, 1).
+		WillReturnRows(sqlmock.NewRows(messageRowColumns).
+			AddRow(7, 1, 1, "before", "ada", at, "text", "", false, false, false, false, "", "", nil, false, "").
+			AddRow(8, 1, 2, "linked", "grace", at, "text", "", false, false, false, false, "", "", nil, false, "").
+			AddRow(9, 1, 1, "after", "ada", at, "text", "", false, false, false, false, "", "", nil, false, ""))
+
+	window, err := messages.GetMessagesAround(context.Background(), 1, 8, 500, -3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messageIDs(window.Messages); !slices.Equal(got, []int64{7, 8}) || window.HasMoreBefore || !window.HasMoreAfter {
+		t.Errorf("got %v with %+v, want 7, 8 and more after", got, window)
+	}
+}
+
+// TestHistogram passes the range in UTC and hands buckets back in UTC, as
+// Postgres returns TIMESTAMP columns with an unnamed zero offset
+func TestHistogram(t *testing.T) {
+	db, mock := newMockDB(t)
+	messages := &MessageStore{db, NewPools(db, nil)}
+	berlin := time.FixedZone("CET", 3600)
+	from := time.Date(2024, 3, 1, 1, 0, 0, 0, berlin)
+	to := time.Date(2024, 4, 1, 1, 0, 0, 0, berlin)
+	unnamed := time.FixedZone("", 0)
+
+	mock.ExpectQuery(`SELECT date_trunc\(\$2, m.created_at\) AS period_start`).
+		WithArgs(int64(1), HistogramWeek, from.UTC(), to.UTC()).
+		WillReturnRows(sqlmock.NewRows([]string{"period_start", "count", "count"}).
+			AddRow(time.Date(2024, 2, 26, 0, 0, 0, 0, unnamed), 12, 3).
+			AddRow(time.Date(2024, 3, 4, 0, 0, 0, 0, unnamed), 4, 1))
+
+	buckets, err := messages.Histogram(context.Background(), 1, HistogramWeek, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 2 || buckets[0].MessageCount != 12 || buckets[0].ActiveUserCount != 3 {
+		t.Fatalf("got %+v", buckets)
+	}
+	for _, bucket := range buckets {
+		if bucket.PeriodStart.Location() != time.UTC {
+			t.Errorf("%s isn't in UTC", bucket.PeriodStart)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ModerationHook is a room's moderation bot: an endpoint asked about every chat
+// message before anyone else sees it (see internal/websocket/moderation_hooks.go)
+type ModerationHook struct {
+	RoomID int64  `json:"room_id"`
+	URL    string `json:"url"`
+	Secret string `json:"-"` // Signs the requests; never sent back to clients
+
+	// Timeout bounds one call; the message waits at most this long
+	Timeout time.Duration `json:"-"`
+
+	// FailOpen lets messages through when the bot times out or errors;
+	// otherwise they're rejected
+	FailOpen bool `json:"fail_open"`
+
+	// Enabled is cleared when the hook is turned off after failing repeatedly;
+	// DisabledReason and DisabledAt say why and when
+	Enabled        bool       `json:"enabled"`
+	DisabledReason string     `json:"disabled_reason,omitempty"`
+	DisabledAt     *time.Time `json:"disabled_at,omitempty"`
+
+	CreatedBy *int64    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ModerationHookStore handles database operations for rooms' moderation hooks
+type ModerationHookStore struct {
+	db *sql.DB
+}
+
+// moderationHookColumns are the columns scanModerationHook reads, in order
+const moderationHookColumns = `
+	room_id, url, secret, timeout_ms, fail_open, enabled, COALESCE(disabled_reason, ''),
+	disabled_at, created_by, created_at, updated_at
+`
+
+// scanModerationHook scans a row selected with moderationHookColumns
+func scanModerationHook(row rowScanner) (*ModerationHook, error) {
+	hook := &ModerationHook{}
+	var timeoutMs int
+	err := row.Scan(
+		&hook.RoomID,
+		&hook.URL,
+		&hook.Secret,
+		&timeoutMs,
+		&hook.FailOpen,
+		&hook.Enabled,
+		&hook.DisabledReason,
+		&hook.DisabledAt,
+		&hook.CreatedBy,
+		&hook.CreatedAt,
+		&hook.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	hook.Timeout = time.Duration(timeoutMs) * time.Millisecond
+	return hook, nil
+}
+
+// Get retrieves a room's moderation hook, enabled or not
+// Returns sql.ErrNoRows if the room has none
+func (s *ModerationHookStore) Get(ctx context.Context, roomID int64) (*ModerationHook, error) {
+	query := `SELECT ` + moderationHookColumns + ` FROM room_moderation_hooks WHERE room_id = $1`
+
+	return scanModerationHook(s.db.QueryRowContext(ctx, query, roomID))
+}
+
+// Save creates or replaces a room's moderation hook
+// Saving turns a disabled hook back on if hook.Enabled is set
+func (s *ModerationHookStore) Save(ctx context.Context, hook *ModerationHook) error {
+	query := `
+		INSERT INTO room_moderation_hooks (room_id, url, secret, timeout_ms, fail_open, enabled, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (room_id) DO UPDATE
+		SET url = EXCLUDED.url, secret = EXCLUDED.secret, timeout_ms = EXCLUDED.timeout_ms,
+			fail_open = EXCLUDED.fail_open, enabled = EXCLUDED.enabled,
+			disabled_reason = NULL, disabled_at = NULL, updated_at = NOW()
+		RETURNING ` + moderationHookColumns
+
+	saved, err := scanModerationHook(s.db.QueryRowContext(ctx, query,
+		hook.RoomID, hook.URL, hook.Secret, hook.Timeout.Milliseconds(), hook.FailOpen, hook.Enabled, hook.CreatedBy))
+	if err != nil {
+		return err
+	}
+	*hook = *saved
+	return nil
+}
+
+// Disable turns a room's hook off and records why
+// Used when it keeps failing; saving it again turns it back on
+func (s *ModerationHookStore) Disable(ctx context.Context, roomID int64, reason string) error {
+	query := `
+		UPDATE room_moderation_hooks
+		SET enabled = FALSE, disabled_reason = $2, disabled_at = NOW(), updated_at = NOW()
+		WHERE room_id = $1
+	`
+	_, err := s.db.ExecContext(ctx, query, roomID, reason)
+	return err
+}
+
+// Delete removes a room's moderation hook
+// Returns sql.ErrNoRows if the room has none
+func (s *ModerationHookStore) Delete(ctx context.Context, roomID int64) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM room_moderation_hooks WHERE room_id = $1`, roomID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// moderationHookRowColumns are the columns of moderationHookColumns
+var moderationHookRowColumns = []string{"room_id", "url", "secret", "timeout_ms", "fail_open", "enabled", "disabled_reason", "disabled_at", "created_by", "created_at", "updated_at"}
+
+// TestSaveModerationHook stores the timeout in milliseconds, clears why a
+// hook was turned off, and reads the saved row back into the hook
+func TestSaveModerationHook(t *testing.T) {
+	db, mock := newMockDB(t)
+	hooks := &ModerationHookStore{db}
+	creator := int64(1)
+	at := time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`INSERT INTO room_moderation_hooks .* ON CONFLICT \(room_id\) DO UPDATE .* disabled_reason = NULL, disabled_at = NULL`).
+		WithArgs(int64(4), "https://bot.example.com/", "sixteen-or-more-chars", int64(300), false, true, &creator).
+		WillReturnRows(sqlmock.NewRows(moderationHookRowColumns).
+			AddRow(4, "https://bot.example.com/", "sixteen-or-more-chars", 300, false, true, "", nil, 1, at, at))
+
+	hook := &ModerationHook{RoomID: 4, URL: "https://bot.example.com/", Secret: "sixteen-or-more-chars", Timeout: 300 * time.Millisecond, Enabled: true, CreatedBy: &creator}
+	if err := hooks.Save(context.Background(), hook); err != nil {
+		t.Fatal(err)
+	}
+	if hook.Timeout != 300*time.Millisecond || !hook.CreatedAt.Equal(at) || hook.FailOpen {
+		t.Errorf("saved %+v", hook)
+	}
+}
+
+// TestDeleteMissingModerationHook reports a room without a hook as not found
+func TestDeleteMissingModerationHook(t *testing.T) {
+	db, mock := newMockDB(t)
+	hooks := &ModerationHookStore{db}
+
+	mock.ExpectExec(`DELETE FROM room_moderation_hooks WHERE room_id = \$1`).WithArgs(int64(4)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := hooks.Delete(context.Background(), 4); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v, want sql.ErrNoRows", err)
+	}
+}
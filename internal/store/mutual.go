@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// mutualMessageWindow is how far back messages count towards MutualContext.RecentMessages
+const mutualMessageWindow = 30 * 24 * time.Hour
+
+// MutualRoom is a room two users are both members of
+type MutualRoom struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	MemberCount int       `json:"member_count"`
+	SharedSince time.Time `json:"shared_since"` // When the later of the two joined
+}
+
+// MutualContext describes how two users are connected
+type MutualContext struct {
+	UserID int64         `json:"user_id"`
+	Rooms  []*MutualRoom `json:"rooms"`
+
+	// Messages either of them posted in the mutual rooms over the last 30 days
+	RecentMessages int `json:"messages_last_30_days"`
+
+	// When they first shared a room they still share; nil if they share none
+	FirstSharedAt *time.Time `json:"first_shared_at"`
+}
+
+// GetMutual returns the rooms userID and otherID are both in, with how much
+// they've talked there recently
+// Computed with an intersection join on room_members and one aggregate over
+// messages, so the cost doesn't depend on how many rooms either user is in
+// Deleted rooms are left out
+func (s *RoomMemberStore) GetMutual(ctx context.Context, userID, otherID int64) (*MutualContext, error) {
+	// One snapshot, so the message count matches the room list
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	roomsQuery := `
+		SELECT r.id, r.name, r.member_count, GREATEST(a.joined_at, b.joined_at)
+		FROM room_members a
+		INNER JOIN room_members b ON b.room_id = a.room_id AND b.user_id = $2
+		INNER JOIN rooms r ON r.id = a.room_id
+		WHERE a.user_id = $1 AND r.deleted_at IS NULL
+		ORDER BY r.name
+	`
+	rows, err := tx.QueryContext(ctx, roomsQuery, userID, otherID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mutual := &MutualContext{UserID: otherID, Rooms: make([]*MutualRoom, 0)}
+	roomIDs := make([]int64, 0)
+	for rows.Next() {
+		room := &MutualRoom{}
+		if err := rows.Scan(&room.ID, &room.Name, &room.MemberCount, &room.SharedSince); err != nil {
+			return nil, err
+		}
+		mutual.Rooms = append(mutual.Rooms, room)
+		roomIDs = append(roomIDs, room.ID)
+
+		if mutual.FirstSharedAt == nil || room.SharedSince.Before(*mutual.FirstSharedAt) {
+			since := room.SharedSince
+			mutual.FirstSharedAt = &since
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(roomIDs) == 0 {
+		return mutual, nil
+	}
+
+	messagesQuery := `
+		SELECT COUNT(*)
+		FROM messages
+		WHERE room_id = ANY($1)
+		  AND user_id IN ($2, $3)
+		  AND created_at > NOW() - make_interval(secs => $4)
+	`
+	err = tx.QueryRowContext(ctx, messagesQuery, pq.Array(roomIDs), userID, otherID, mutualMessageWindow.Seconds()).Scan(&mutual.RecentMessages)
+	if err != nil {
+		return nil, err
+	}
+
+	return mutual, nil
+}
@@ -0,0 +1,116 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestMutualRooms seeds three users across three rooms on the scratch
+// database: one all three share, one only ada and linus are in, and one
+// ada and grace shared before it was deleted. Between ada and grace only
+// the first counts, with their recent messages in it and not linus's or
+// an old one
+func TestMutualRooms(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	limits := Limits{MaxRoomMembers: 100, MaxRoomsPerUser: 100}
+	rooms := &RoomStore{db, limits, NewPools(db, nil)}
+	members := &RoomMemberStore{db, limits}
+	messages := &MessageStore{db, NewPools(db, nil)}
+	suffix := time.Now().UnixNano()
+
+	var ada, grace, linus int64
+	for _, user := range []struct {
+		name string
+		id   *int64
+	}{{"ada", &ada}, {"grace", &grace}, {"linus", &linus}} {
+		query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+		if err := db.QueryRowContext(ctx, query, fmt.Sprintf("mutual-%s-%d", user.name, suffix)).Scan(user.id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shared := &Room{Name: fmt.Sprintf("mutual-shared-%d", suffix), CreatedBy: ada}
+	partial := &Room{Name: fmt.Sprintf("mutual-partial-%d", suffix), CreatedBy: ada}
+	deleted := &Room{Name: fmt.Sprintf("mutual-deleted-%d", suffix), CreatedBy: ada}
+	for _, room := range []*Room{shared, partial, deleted} {
+		if err := rooms.Create(ctx, room); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM rooms WHERE id = ANY(ARRAY[$1, $2, $3]::bigint[])`, shared.ID, partial.ID, deleted.ID)
+		db.Exec(`DELETE FROM users WHERE id = ANY(ARRAY[$1, $2, $3]::bigint[])`, ada, grace, linus)
+	})
+
+	for _, m := range []struct{ room, user int64 }{
+		{shared.ID, ada}, {shared.ID, grace}, {shared.ID, linus},
+		{partial.ID, ada}, {partial.ID, linus},
+		{deleted.ID, ada}, {deleted.ID, grace},
+	} {
+		if err := members.JoinWithOptions(ctx, m.room, m.user, JoinOptions{ActorID: ada}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// grace joined the shared room after ada, and the deleted room earlier still
+	joined := map[int64]time.Time{
+		ada:   time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC),
+		grace: time.Date(2026, 2, 1, 9, 0, 0, 0, time.UTC),
+	}
+	for user, at := range joined {
+		if _, err := db.ExecContext(ctx, `UPDATE room_members SET joined_at = $1 WHERE room_id = $2 AND user_id = $3`, at, shared.ID, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, `UPDATE room_members SET joined_at = $1 WHERE room_id = $2`, time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC), deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(room, user int64) *Message {
+		t.Helper()
+		message := &Message{RoomID: room, UserID: user, Content: "hello"}
+		if err := messages.Create(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+		return message
+	}
+	post(shared.ID, ada)
+	post(shared.ID, grace)
+	post(shared.ID, linus)
+	post(partial.ID, ada)
+	post(deleted.ID, grace)
+	old := post(shared.ID, grace)
+	if _, err := db.ExecContext(ctx, `UPDATE messages SET created_at = NOW() - INTERVAL '31 days' WHERE id = $1`, old.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := rooms.SoftDelete(ctx, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	mutual, err := members.GetMutual(ctx, ada, grace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mutual.Rooms) != 1 || mutual.Rooms[0].ID != shared.ID || mutual.Rooms[0].MemberCount != 3 {
+		t.Fatalf("got rooms %+v, want the shared room with 3 members", mutual.Rooms)
+	}
+	if mutual.RecentMessages != 2 {
+		t.Errorf("counted %d recent messages, want 2", mutual.RecentMessages)
+	}
+	if mutual.FirstSharedAt == nil || !mutual.FirstSharedAt.Equal(joined[grace]) {
+		t.Errorf("first shared at %v, want %v", mutual.FirstSharedAt, joined[grace])
+	}
+
+	both, err := members.GetMutual(ctx, ada, linus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(both.Rooms) != 2 || both.RecentMessages != 3 {
+		t.Errorf("ada and linus got rooms %+v and %d messages, want 2 rooms and 3 messages", both.Rooms, both.RecentMessages)
+	}
+}
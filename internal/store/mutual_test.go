@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// TestGetMutual reads the shared rooms with one intersection query and
+// counts messages across all of them with one more
+func TestGetMutual(t *testing.T) {
+	db, mock := newMockDB(t)
+	members := &RoomMemberStore{db, Limits{}}
+	older := time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC)
+	newer := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM room_members a\s+INNER JOIN room_members b ON b.room_id = a.room_id AND b.user_id = \$2\s+INNER JOIN rooms r ON r.id = a.room_id\s+WHERE a.user_id = \$1 AND r.deleted_at IS NULL`).
+		WithArgs(int64(1), int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "member_count", "greatest"}).
+			AddRow(4, "books", 12, newer).
+			AddRow(7, "lobby", 40, older))
+	mock.ExpectQuery(`SELECT COUNT\(\*\)\s+FROM messages\s+WHERE room_id = ANY\(\$1\)\s+AND user_id IN \(\$2, \$3\)`).
+		WithArgs(pq.Array([]int64{4, 7}), int64(1), int64(2), mutualMessageWindow.Seconds()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(9))
+	mock.ExpectRollback()
+
+	mutual, err := members.GetMutual(context.Background(), 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mutual.UserID != 2 || len(mutual.Rooms) != 2 || mutual.Rooms[1].MemberCount != 40 || mutual.RecentMessages != 9 {
+		t.Errorf("got %+v with rooms %+v", mutual, mutual.Rooms)
+	}
+	if mutual.FirstSharedAt == nil || !mutual.FirstSharedAt.Equal(older) {
+		t.Errorf("first shared at %v, want %v", mutual.FirstSharedAt, older)
+	}
+}
+
+// TestGetMutualNone skips the message count when there's no shared room
+func TestGetMutualNone(t *testing.T) {
+	db, mock := newMockDB(t)
+	members := &RoomMemberStore{db, Limits{}}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM room_members a`).
+		WithArgs(int64(1), int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "member_count", "greatest"}))
+	mock.ExpectRollback()
+
+	mutual, err := members.GetMutual(context.Background(), 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mutual.Rooms == nil || len(mutual.Rooms) != 0 || mutual.FirstSharedAt != nil || mutual.RecentMessages != 0 {
+		t.Errorf("got %+v, want an empty room list and no first shared time", mutual)
+	}
+}
@@ -0,0 +1,61 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestNotificationCells flips single cells on the scratch database: each
+// flip changes that cell and no other, and flipping back stores the value
+// rather than deleting the row
+func TestNotificationCells(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	prefs := &NotificationPreferenceStore{db}
+
+	var userID int64
+	query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+	if err := db.QueryRowContext(ctx, query, fmt.Sprintf("prefs-%d", time.Now().UnixNano())).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec(`DELETE FROM users WHERE id = $1`, userID) })
+
+	defaults := DefaultNotificationPreferences()
+	for _, channel := range NotificationChannels {
+		for _, event := range NotificationEvents {
+			flipped := !defaults[channel][event]
+			got, err := prefs.Update(ctx, userID, NotificationPreferences{channel: {event: flipped}})
+			if err != nil {
+				t.Fatalf("flipping %s.%s: %v", channel, event, err)
+			}
+			for _, c := range NotificationChannels {
+				for _, e := range NotificationEvents {
+					want := defaults[c][e]
+					if c == channel && e == event {
+						want = flipped
+					}
+					if got[c][e] != want {
+						t.Errorf("after flipping %s.%s, %s.%s is %v", channel, event, c, e, got[c][e])
+					}
+				}
+			}
+			if _, err := prefs.Update(ctx, userID, NotificationPreferences{channel: {event: !flipped}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var rows int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM notification_preferences WHERE user_id = $1`, userID).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if want := len(NotificationChannels) * len(NotificationEvents); rows != want {
+		t.Errorf("there are %d rows, want %d", rows, want)
+	}
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+
+	"github.com/lib/pq"
+)
+
+// Notification channels: where a notification is delivered
+const (
+	NotifyChannelWebSocket = "websocket_flag" // The "notify" flag on WebSocket frames, for in-app alerts
+	NotifyChannelEmail     = "email"
+	NotifyChannelPush      = "push"
+)
+
+// Notification event types: what a notification is about
+// DMs, invites and announcements don't exist yet; their cells are stored so
+// clients can show the full matrix and the features can honour it from day one
+const (
+	NotifyEventMention      = "mention"
+	NotifyEventDM           = "dm"
+	NotifyEventRoomInvite   = "room_invite"
+	NotifyEventAnnouncement = "announcement"
+	NotifyEventDigest       = "digest"
+)
+
+// NotificationChannels and NotificationEvents list every channel and event type
+var (
+	NotificationChannels = []string{NotifyChannelWebSocket, NotifyChannelEmail, NotifyChannelPush}
+	NotificationEvents   = []string{NotifyEventMention, NotifyEventDM, NotifyEventRoomInvite, NotifyEventAnnouncement, NotifyEventDigest}
+)
+
+// notificationDefaults are the cells that are on for a user who changed nothing
+// Every cell not listed is off
+var notificationDefaults = map[string]map[string]bool{
+	NotifyChannelWebSocket: {
+		NotifyEventMention: true, NotifyEventDM: true, NotifyEventRoomInvite: true,
+		NotifyEventAnnouncement: true, NotifyEventDigest: true,
+	},
+	NotifyChannelPush: {
+		NotifyEventMention: true, NotifyEventDM: true, NotifyEventRoomInvite: true,
+	},
+	// Digests still have to be turned on in the digest settings
+	NotifyChannelEmail: {
+		NotifyEventRoomInvite: true, NotifyEventDigest: true,
+	},
+}
+
+// NotificationPreferences is a user's preference matrix: channel -> event type -> enabled
+type NotificationPreferences map[string]map[string]bool
+
+// DefaultNotificationPreferences returns the full matrix with every cell at its default
+func DefaultNotificationPreferences() NotificationPreferences {
+	prefs := make(NotificationPreferences, len(NotificationChannels))
+	for _, channel := range NotificationChannels {
+		prefs[channel] = make(map[string]bool, len(NotificationEvents))
+		for _, event := range NotificationEvents {
+			prefs[channel][event] = notificationDefaults[channel][event]
+		}
+	}
+	return prefs
+}
+
+// Allows reports whether notifications of an event type may go out on a channel
+// Cells missing from the matrix use their default
+func (p NotificationPreferences) Allows(channel, event string) bool {
+	if enabled, ok := p[channel][event]; ok {
+		return enabled
+	}
+	return notificationDefaults[channel][event]
+}
+
+// ValidNotificationCell reports whether channel and event are both known
+func ValidNotificationCell(channel, event string) bool {
+	return slices.Contains(NotificationChannels, channel) && slices.Contains(NotificationEvents, event)
+}
+
+// NotificationPreferenceStore handles users' notification preferences
+// Only cells a user changed are stored; the rest come from the defaults
+type NotificationPreferenceStore struct {
+	db *sql.DB
+}
+
+// Get returns a user's full preference matrix
+func (s *NotificationPreferenceStore) Get(ctx context.Context, userID int64) (NotificationPreferences, error) {
+	prefs, err := s.GetForUsers(ctx, []int64{userID})
+	if err != nil {
+		return nil, err
+	}
+	return prefs[userID], nil
+}
+
+// GetForUsers returns the full preference matrix of each user in one query
+// Every requested user is in the result, with defaults if they changed nothing
+func (s *NotificationPreferenceStore) GetForUsers(ctx context.Context, userIDs []int64) (map[int64]NotificationPreferences, error) {
+	result := make(map[int64]NotificationPreferences, len(userIDs))
+	for _, userID := range userIDs {
+		result[userID] = DefaultNotificationPreferences()
+	}
+	if len(userIDs) == 0 {
+		return result, nil
+	}
+
+	query := `
+		SELECT user_id, channel, event_type, enabled
+		FROM notification_preferences
+		WHERE user_id = ANY($1)
+	`
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID int64
+		var channel, event string
+		var enabled bool
+		if err := rows.Scan(&userID, &channel, &event, &enabled); err != nil {
+			return nil, err
+		}
+		if prefs, ok := result[userID]; ok && ValidNotificationCell(channel, event) {
+			prefs[channel][event] = enabled
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Update changes the given cells of a user's matrix and leaves the others alone
+// Cells must be valid (see ValidNotificationCell)
+// Returns the full matrix after the change
+func (s *NotificationPreferenceStore) Update(ctx context.Context, userID int64, changes NotificationPreferences) (NotificationPreferences, error) {
+	channels := make([]string, 0)
+	events := make([]string, 0)
+	enabled := make([]bool, 0)
+	for channel, cells := range changes {
+		for event, on := range cells {
+			channels = append(channels, channel)
+			events = append(events, event)
+			enabled = append(enabled, on)
+		}
+	}
+
+	// One statement for all cells, so a partial update can't half apply
+	query := `
+		INSERT INTO notification_preferences (user_id, channel, event_type, enabled)
+		SELECT $1, c.channel, c.event_type, c.enabled
+		FROM unnest($2::text[], $3::text[], $4::boolean[]) AS c(channel, event_type, enabled)
+		ON CONFLICT (user_id, channel, event_type)
+		DO UPDATE SET enabled = EXCLUDED.enabled, updated_at = NOW()
+	`
+	if len(channels) > 0 {
+		_, err := s.db.ExecContext(ctx, query, userID, pq.Array(channels), pq.Array(events), pq.Array(enabled))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.Get(ctx, userID)
+}
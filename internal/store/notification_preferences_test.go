@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// TestGetForUsers fills in defaults around the stored cells, for every
+// requested user, in one query; unknown cells in the table are ignored
+func TestGetForUsers(t *testing.T) {
+	db, mock := newMockDB(t)
+	prefs := &NotificationPreferenceStore{db}
+
+	mock.ExpectQuery(`SELECT user_id, channel, event_type, enabled\s+FROM notification_preferences\s+WHERE user_id = ANY\(\$1\)`).
+		WithArgs(pq.Array([]int64{1, 2})).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "channel", "event_type", "enabled"}).
+			AddRow(1, NotifyChannelPush, NotifyEventMention, false).
+			AddRow(1, NotifyChannelEmail, NotifyEventMention, true).
+			AddRow(2, "pager", NotifyEventMention, true))
+
+	got, err := prefs.GetForUsers(context.Background(), []int64{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[1].Allows(NotifyChannelPush, NotifyEventMention) || !got[1].Allows(NotifyChannelEmail, NotifyEventMention) {
+		t.Errorf("user 1's stored cells weren't applied: %v", got[1])
+	}
+	if !got[2].Allows(NotifyChannelPush, NotifyEventMention) || got[2]["pager"] != nil {
+		t.Errorf("user 2 should have the defaults: %v", got[2])
+	}
+	for _, userID := range []int64{1, 2} {
+		if cells := len(got[userID]) * len(got[userID][NotifyChannelPush]); cells != len(NotificationChannels)*len(NotificationEvents) {
+			t.Errorf("user %d's matrix has %d cells", userID, cells)
+		}
+	}
+}
+
+// TestUpdateNotificationPreferences upserts every changed cell in one
+// statement and returns the full matrix
+func TestUpdateNotificationPreferences(t *testing.T) {
+	db, mock := newMockDB(t)
+	prefs := &NotificationPreferenceStore{db}
+
+	mock.ExpectExec(`INSERT INTO notification_preferences \(user_id, channel, event_type, enabled\).+unnest\(\$2::text\[\], \$3::text\[\], \$4::boolean\[\]\).+ON CONFLICT \(user_id, channel, event_type\)`).
+		WithArgs(int64(1), pq.Array([]string{NotifyChannelPush}), pq.Array([]string{NotifyEventMention}), pq.Array([]bool{false})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM notification_preferences`).
+		WithArgs(pq.Array([]int64{1})).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "channel", "event_type", "enabled"}).
+			AddRow(1, NotifyChannelPush, NotifyEventMention, false))
+
+	got, err := prefs.Update(context.Background(), 1, NotificationPreferences{NotifyChannelPush: {NotifyEventMention: false}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Allows(NotifyChannelPush, NotifyEventMention) || !got.Allows(NotifyChannelPush, NotifyEventDM) {
+		t.Errorf("got %v, want only push mentions off", got)
+	}
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// OAuthIdentity is a provider account (Google, GitHub) linked to a user
+type OAuthIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"` // The provider's stable ID for the account
+	UserID    int64     `json:"user_id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OAuthIdentityStore handles database operations for linked provider accounts
+type OAuthIdentityStore struct {
+	db *sql.DB
+}
+
+// GetUserID returns the user a provider account is linked to
+// Returns sql.ErrNoRows if it isn't linked
+func (s *OAuthIdentityStore) GetUserID(ctx context.Context, provider, subject string) (int64, error) {
+	query := `SELECT user_id FROM oauth_identities WHERE provider = $1 AND subject = $2`
+	var userID int64
+	err := s.db.QueryRowContext(ctx, query, provider, subject).Scan(&userID)
+	return userID, err
+}
+
+// Link links a provider account to a user and fills in CreatedAt
+// An account can only be linked once: linking it again is a unique violation
+func (s *OAuthIdentityStore) Link(ctx context.Context, identity *OAuthIdentity) error {
+	query := `
+		INSERT INTO oauth_identities (provider, subject, user_id, email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at
+	`
+	return s.db.QueryRowContext(ctx, query, identity.Provider, identity.Subject, identity.UserID, identity.Email).
+		Scan(&identity.CreatedAt)
+}
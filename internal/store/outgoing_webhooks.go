@@ -0,0 +1,331 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxWebhookDeliveries is how many delivery log entries are kept per webhook
+const maxWebhookDeliveries = 100
+
+// Outcomes of a webhook delivery
+const (
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// OutgoingWebhook is a URL a room's events are POSTed to, for external
+// automation (see internal/webhook/outgoing.go)
+type OutgoingWebhook struct {
+	ID     int64  `json:"id"`
+	RoomID int64  `json:"room_id"`
+	URL    string `json:"url"`
+	Secret string `json:"-"` // Signs the requests; never sent back to clients
+
+	// Events is the allowlist of event types sent to the webhook
+	Events []string `json:"events"`
+
+	// Enabled is cleared when the webhook is turned off after failing
+	// repeatedly; DisabledReason and DisabledAt say why and when
+	// ConsecutiveFailures counts the deliveries that failed since the last success
+	Enabled             bool       `json:"enabled"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	DisabledReason      string     `json:"disabled_reason,omitempty"`
+	DisabledAt          *time.Time `json:"disabled_at,omitempty"`
+
+	// EventSeq is the last event of the room's event log sent to the webhook
+	EventSeq int64 `json:"-"`
+
+	CreatedBy *int64    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Wants reports whether an event type is in the webhook's allowlist
+func (h *OutgoingWebhook) Wants(eventType string) bool {
+	for _, event := range h.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// OutgoingWebhookDelivery is the outcome of sending one event to a webhook,
+// after its retries
+type OutgoingWebhookDelivery struct {
+	ID        int64  `json:"id"`
+	WebhookID int64  `json:"webhook_id"`
+	Event     string `json:"event"`
+	Status    string `json:"status"` // DeliveryDelivered or DeliveryFailed
+	Attempts  int    `json:"attempts"`
+
+	// From the last attempt: the response's status code (nil if there was no
+	// response), how long it took, and the start of the response body
+	ResponseCode *int   `json:"response_code"`
+	LatencyMs    int64  `json:"latency_ms"`
+	ResponseBody string `json:"response_body"`
+
+	// Error says why the last attempt failed; empty once delivered
+	Error string `json:"error,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OutgoingWebhookStore handles database operations for rooms' outgoing webhooks
+type OutgoingWebhookStore struct {
+	db *sql.DB
+}
+
+// outgoingWebhookColumns are the columns scanOutgoingWebhook reads, in order
+const outgoingWebhookColumns = `
+	id, room_id, url, secret, events, enabled, consecutive_failures, COALESCE(disabled_reason, ''),
+	disabled_at, event_seq, created_by, created_at, updated_at
+`
+
+// scanOutgoingWebhook scans a row selected with outgoingWebhookColumns
+func scanOutgoingWebhook(row rowScanner) (*OutgoingWebhook, error) {
+	hook := &OutgoingWebhook{}
+	err := row.Scan(
+		&hook.ID,
+		&hook.RoomID,
+		&hook.URL,
+		&hook.Secret,
+		pq.Array(&hook.Events),
+		&hook.Enabled,
+		&hook.ConsecutiveFailures,
+		&hook.DisabledReason,
+		&hook.DisabledAt,
+		&hook.EventSeq,
+		&hook.CreatedBy,
+		&hook.CreatedAt,
+		&hook.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+// listOutgoingWebhooks runs a query selecting outgoingWebhookColumns
+func (s *OutgoingWebhookStore) listOutgoingWebhooks(ctx context.Context, query string, args ...interface{}) ([]*OutgoingWebhook, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := make([]*OutgoingWebhook, 0)
+	for rows.Next() {
+		hook, err := scanOutgoingWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+// Create adds a webhook to a room
+// It starts after the events already in the room's log, so it's only sent
+// what happens from now on
+func (s *OutgoingWebhookStore) Create(ctx context.Context, hook *OutgoingWebhook) error {
+	query := `
+		INSERT INTO outgoing_webhooks (room_id, url, secret, events, created_by, event_seq)
+		VALUES ($1, $2, $3, $4, $5, (SELECT COALESCE(MAX(seq), 0) FROM room_events WHERE room_id = $1))
+		RETURNING ` + outgoingWebhookColumns
+
+	created, err := scanOutgoingWebhook(s.db.QueryRowContext(ctx, query,
+		hook.RoomID, hook.URL, hook.Secret, pq.Array(hook.Events), hook.CreatedBy))
+	if err != nil {
+		return err
+	}
+	*hook = *created
+	return nil
+}
+
+// Get retrieves one of a room's webhooks
+// Returns sql.ErrNoRows if the room has no webhook with that ID
+func (s *OutgoingWebhookStore) Get(ctx context.Context, roomID, id int64) (*OutgoingWebhook, error) {
+	query := `SELECT ` + outgoingWebhookColumns + ` FROM outgoing_webhooks WHERE room_id = $1 AND id = $2`
+
+	return scanOutgoingWebhook(s.db.QueryRowContext(ctx, query, roomID, id))
+}
+
+// ListForRoom returns a room's webhooks, enabled or not, oldest first
+func (s *OutgoingWebhookStore) ListForRoom(ctx context.Context, roomID int64) ([]*OutgoingWebhook, error) {
+	query := `SELECT ` + outgoingWebhookColumns + ` FROM outgoing_webhooks WHERE room_id = $1 ORDER BY id`
+
+	return s.listOutgoingWebhooks(ctx, query, roomID)
+}
+
+// ListEnabled returns every enabled webhook of a room that isn't deleted
+// The dispatcher caches them all; there are few compared to rooms
+func (s *OutgoingWebhookStore) ListEnabled(ctx context.Context) ([]*OutgoingWebhook, error) {
+	query := `
+		SELECT ` + outgoingWebhookColumns + `
+		FROM outgoing_webhooks
+		WHERE enabled AND room_id IN (SELECT id FROM rooms WHERE deleted_at IS NULL)
+		ORDER BY id
+	`
+
+	return s.listOutgoingWebhooks(ctx, query)
+}
+
+// Delete removes one of a room's webhooks along with its delivery log
+// Returns sql.ErrNoRows if the room has no webhook with that ID
+func (s *OutgoingWebhookStore) Delete(ctx context.Context, roomID, id int64) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM outgoing_webhooks WHERE room_id = $1 AND id = $2`, roomID, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AdvanceSeq moves a webhook's place in the room's event log from one event to
+// a later one, claiming the events in between for sending
+// Returns false if the webhook isn't at from any more: another instance
+// claimed them first
+func (s *OutgoingWebhookStore) AdvanceSeq(ctx context.Context, id, from, to int64) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `UPDATE outgoing_webhooks SET event_seq = $3 WHERE id = $1 AND event_seq = $2`, id, from, to)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// SkipEvents moves a webhook past every event in its room's log
+// Used when the events it was due have been purged
+func (s *OutgoingWebhookStore) SkipEvents(ctx context.Context, id int64) error {
+	query := `
+		UPDATE outgoing_webhooks w
+		SET event_seq = GREATEST(w.event_seq, (SELECT COALESCE(MAX(seq), 0) FROM room_events WHERE room_id = w.room_id))
+		WHERE w.id = $1
+	`
+	_, err := s.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// RecordDelivery logs the outcome of a delivery and updates the webhook's
+// failure streak: a success resets it, a failure adds one
+// Only the most recent maxWebhookDeliveries entries are kept per webhook
+// Returns the failures in a row after this delivery
+func (s *OutgoingWebhookStore) RecordDelivery(ctx context.Context, delivery *OutgoingWebhookDelivery) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO outgoing_webhook_deliveries (webhook_id, event, status, attempts, response_code, latency_ms, response_body, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at
+	`
+	err = tx.QueryRowContext(ctx, query,
+		delivery.WebhookID, delivery.Event, delivery.Status, delivery.Attempts,
+		delivery.ResponseCode, delivery.LatencyMs, delivery.ResponseBody, delivery.Error,
+	).Scan(&delivery.ID, &delivery.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	var failures int
+	streakQuery := `
+		UPDATE outgoing_webhooks
+		SET consecutive_failures = CASE WHEN $2 THEN 0 ELSE consecutive_failures + 1 END
+		WHERE id = $1
+		RETURNING consecutive_failures
+	`
+	err = tx.QueryRowContext(ctx, streakQuery, delivery.WebhookID, delivery.Status == DeliveryDelivered).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+
+	pruneQuery := `
+		DELETE FROM outgoing_webhook_deliveries
+		WHERE webhook_id = $1 AND id <= (
+			SELECT id FROM outgoing_webhook_deliveries
+			WHERE webhook_id = $1
+			ORDER BY id DESC
+			OFFSET $2 LIMIT 1
+		)
+	`
+	if _, err := tx.ExecContext(ctx, pruneQuery, delivery.WebhookID, maxWebhookDeliveries); err != nil {
+		return 0, err
+	}
+
+	return failures, tx.Commit()
+}
+
+// Disable turns a webhook off and records why
+// Returns false if it was already off, so whoever turned it off is told only once
+func (s *OutgoingWebhookStore) Disable(ctx context.Context, id int64, reason string) (bool, error) {
+	query := `
+		UPDATE outgoing_webhooks
+		SET enabled = FALSE, disabled_reason = $2, disabled_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND enabled
+	`
+	result, err := s.db.ExecContext(ctx, query, id, reason)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// ListDeliveries returns a webhook's most recent deliveries, newest first
+func (s *OutgoingWebhookStore) ListDeliveries(ctx context.Context, webhookID int64, limit int) ([]*OutgoingWebhookDelivery, error) {
+	query := `
+		SELECT id, webhook_id, event, status, attempts, response_code, latency_ms, response_body, error, created_at
+		FROM outgoing_webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`
+	rows, err := s.db.QueryContext(ctx, query, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]*OutgoingWebhookDelivery, 0)
+	for rows.Next() {
+		delivery := &OutgoingWebhookDelivery{}
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.Event,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.ResponseCode,
+			&delivery.LatencyMs,
+			&delivery.ResponseBody,
+			&delivery.Error,
+			&delivery.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PasswordResetStore handles database operations for password reset tokens
+// Tokens are stored as SHA-256 hashes, like email invites
+type PasswordResetStore struct {
+	db *sql.DB
+}
+
+// Create saves a reset token for a user, valid for ttl, and returns when it expires
+// Asking again replaces the user's unused token, so only the newest email works
+func (s *PasswordResetStore) Create(ctx context.Context, userID int64, tokenHash string, ttl time.Duration) (time.Time, error) {
+	query := `
+		INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+		VALUES ($1, $2, NOW() + ($3 * INTERVAL '1 second'))
+		ON CONFLICT (user_id) WHERE used_at IS NULL DO UPDATE
+		SET token_hash = EXCLUDED.token_hash, created_at = NOW(), expires_at = EXCLUDED.expires_at
+		RETURNING expires_at
+	`
+	var expiresAt time.Time
+	err := s.db.QueryRowContext(ctx, query, userID, tokenHash, ttl.Seconds()).Scan(&expiresAt)
+	return expiresAt, err
+}
+
+// GetUserID returns the user a live token belongs to
+// Returns sql.ErrNoRows for unknown, used or expired tokens
+func (s *PasswordResetStore) GetUserID(ctx context.Context, tokenHash string) (int64, error) {
+	query := `
+		SELECT user_id FROM password_reset_tokens
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+	`
+	var userID int64
+	err := s.db.QueryRowContext(ctx, query, tokenHash).Scan(&userID)
+	return userID, err
+}
+
+// Consume uses up a live token and sets its user's password to passwordHash,
+// in one transaction that also signs the user out everywhere: their token
+// version is bumped and their sessions and personal access tokens deleted
+// Returns the IDs of the sessions deleted, for their connections to be closed.
+// Returns sql.ErrNoRows if the token is unknown, expired or already used, so
+// two requests racing with the same token can't both succeed
+func (s *PasswordResetStore) Consume(ctx context.Context, tokenHash, passwordHash string) ([]int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	useQuery := `
+		UPDATE password_reset_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id
+	`
+	var userID int64
+	if err := tx.QueryRowContext(ctx, useQuery, tokenHash).Scan(&userID); err != nil {
+		return nil, err
+	}
+
+	// Whoever asked for the reset may not be the only one who knew the old
+	// password, so nothing they could have signed in or minted survives it
+	passwordQuery := `
+		UPDATE users SET password = $2, token_version = token_version + 1, updated_at = NOW()
+		WHERE id = $1
+	`
+	if err := expectOneRow(tx.ExecContext(ctx, passwordQuery, userID, passwordHash)); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM api_tokens WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+
+	var sessionIDs []int64
+	sessionsQuery := `
+		WITH deleted AS (DELETE FROM sessions WHERE user_id = $1 RETURNING id)
+		SELECT COALESCE(array_agg(id ORDER BY id), '{}') FROM deleted
+	`
+	if err := tx.QueryRowContext(ctx, sessionsQuery, userID).Scan(pq.Array(&sessionIDs)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return sessionIDs, nil
+}
+
+// PurgeExpired deletes tokens that were used or expired before the cutoff
+// Returns the number of tokens removed
+func (s *PasswordResetStore) PurgeExpired(ctx context.Context, cutoff time.Time) (int64, error) {
+	query := `DELETE FROM password_reset_tokens WHERE expires_at < $1 OR used_at < $1`
+	result, err := s.db.ExecContext(ctx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestConsumePasswordReset uses up a live token: the password is set, the
+// token version bumped and the user's API tokens and sessions deleted in the
+// same transaction, and the sessions' IDs returned
+func TestConsumePasswordReset(t *testing.T) {
+	db, mock := newMockDB(t)
+	resets := &PasswordResetStore{db}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE password_reset_tokens SET used_at = NOW\(\)\s+WHERE token_hash = \$1 AND used_at IS NULL AND expires_at > NOW\(\)`).
+		WithArgs("token-hash").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(7))
+	mock.ExpectExec(`UPDATE users SET password = \$2, token_version = token_version \+ 1`).WithArgs(int64(7), "new-hash").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM api_tokens WHERE user_id = \$1`).WithArgs(int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(`DELETE FROM sessions WHERE user_id = \$1`).WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"ids"}).AddRow("{3,5}"))
+	mock.ExpectCommit()
+
+	sessions, err := resets.Consume(context.Background(), "token-hash", "new-hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0] != 3 || sessions[1] != 5 {
+		t.Errorf("got sessions %v, want 3 and 5", sessions)
+	}
+}
+
+// TestConsumeSpentPasswordReset refuses a token that's used or expired
+// without touching the password
+func TestConsumeSpentPasswordReset(t *testing.T) {
+	db, mock := newMockDB(t)
+	resets := &PasswordResetStore{db}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE password_reset_tokens SET used_at = NOW\(\)`).WithArgs("token-hash").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectRollback()
+
+	if _, err := resets.Consume(context.Background(), "token-hash", "new-hash"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v, want sql.ErrNoRows", err)
+	}
+}
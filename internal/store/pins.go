@@ -0,0 +1,341 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MaxPinsPerRoom caps how many messages one room can pin
+const MaxPinsPerRoom = 50
+
+var (
+	// ErrAlreadyPinned is returned when pinning a message that is already pinned
+	ErrAlreadyPinned = errors.New("message already pinned")
+
+	// ErrTooManyPins is returned when a room already has MaxPinsPerRoom pins
+	ErrTooManyPins = errors.New("too many pinned messages")
+
+	// ErrPinsVersionConflict is returned when the pins changed since the version the caller saw
+	ErrPinsVersionConflict = errors.New("pins changed since they were read")
+
+	// ErrPinSetMismatch is returned when a new order doesn't list exactly the pinned messages
+	ErrPinSetMismatch = errors.New("order doesn't match the pinned messages")
+)
+
+// PinnedMessage is a pinned message with enough of the message to show it
+type PinnedMessage struct {
+	MessageID int64     `json:"message_id"`
+	RoomID    int64     `json:"room_id"`
+	Position  int       `json:"position"`
+	PinnedBy  *int64    `json:"pinned_by"`
+	PinnedAt  time.Time `json:"pinned_at"`
+
+	// The message itself; a deleted author is shown as for messages
+	// (see Message.AuthorDeleted)
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+
+	AuthorDeleted bool `json:"author_deleted,omitempty"`
+}
+
+// PinChange is the outcome of a pin, unpin or reorder
+type PinChange struct {
+	Version  int64 // The room's pins version after the change
+	EventSeq int64 // The change's sequence number in the room's event log
+}
+
+// PinStore handles pinned messages and their order
+// Every change bumps the room's pins_version and is added to the room's event log
+type PinStore struct {
+	db *sql.DB
+}
+
+// Pin adds a message to the end of a room's pinned bar
+// Returns sql.ErrNoRows if the room or the message (in that room) doesn't exist,
+// ErrAlreadyPinned or ErrTooManyPins
+func (s *PinStore) Pin(ctx context.Context, roomID, messageID, userID int64) (PinChange, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return PinChange{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockPins(ctx, tx, roomID); err != nil {
+		return PinChange{}, err
+	}
+
+	var inRoom bool
+	messageQuery := `SELECT EXISTS(SELECT 1 FROM messages WHERE id = $1 AND room_id = $2)`
+	if err := tx.QueryRowContext(ctx, messageQuery, messageID, roomID).Scan(&inRoom); err != nil {
+		return PinChange{}, err
+	}
+	if !inRoom {
+		return PinChange{}, sql.ErrNoRows
+	}
+
+	var count int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM pinned_messages WHERE room_id = $1`, roomID).Scan(&count); err != nil {
+		return PinChange{}, err
+	}
+	if count >= MaxPinsPerRoom {
+		return PinChange{}, ErrTooManyPins
+	}
+
+	insertQuery := `
+		INSERT INTO pinned_messages (room_id, message_id, position, pinned_by)
+		SELECT $1, $2, COALESCE(MAX(position), 0) + 1, $3
+		FROM pinned_messages WHERE room_id = $1
+		ON CONFLICT DO NOTHING
+	`
+	result, err := tx.ExecContext(ctx, insertQuery, roomID, messageID, userID)
+	if err != nil {
+		return PinChange{}, err
+	}
+	if inserted, err := result.RowsAffected(); err != nil {
+		return PinChange{}, err
+	} else if inserted == 0 {
+		return PinChange{}, ErrAlreadyPinned
+	}
+
+	return bumpPinsVersion(ctx, tx, roomID, RoomEventPinAdded, map[string]interface{}{"message_id": messageID, "pinned_by": userID})
+}
+
+// Unpin removes a message from a room's pinned bar
+// The other pins keep their positions; the gap is harmless
+// Returns sql.ErrNoRows if the message isn't pinned
+func (s *PinStore) Unpin(ctx context.Context, roomID, messageID int64) (PinChange, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return PinChange{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockPins(ctx, tx, roomID); err != nil {
+		return PinChange{}, err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM pinned_messages WHERE room_id = $1 AND message_id = $2`, roomID, messageID)
+	if err := expectOneRow(result, err); err != nil {
+		return PinChange{}, err
+	}
+
+	return bumpPinsVersion(ctx, tx, roomID, RoomEventPinRemoved, map[string]interface{}{"message_id": messageID})
+}
+
+// List returns a room's pinned messages in position order, with the current pins version
+func (s *PinStore) List(ctx context.Context, roomID int64) ([]*PinnedMessage, int64, error) {
+	// Read both in one snapshot so the version matches the list
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	var version int64
+	versionQuery := `SELECT pins_version FROM rooms WHERE id = $1 AND deleted_at IS NULL`
+	if err := tx.QueryRowContext(ctx, versionQuery, roomID).Scan(&version); err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT p.message_id, p.room_id, p.position, p.pinned_by, p.pinned_at,
+		       COALESCE(m.user_id, 0), COALESCE(u.username, m.author_username), m.content, m.created_at
+		FROM pinned_messages p
+		INNER JOIN messages m ON m.id = p.message_id
+		LEFT JOIN users u ON u.id = m.user_id
+		WHERE p.room_id = $1
+		ORDER BY p.position ASC, p.pinned_at ASC
+	`
+
+	rows, err := tx.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	pins := make([]*PinnedMessage, 0)
+	for rows.Next() {
+		pin, err := scanPinnedMessage(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		pins = append(pins, pin)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return pins, version, nil
+}
+
+// scanPinnedMessage reads a row selected with the columns List and Search share
+func scanPinnedMessage(row rowScanner) (*PinnedMessage, error) {
+	pin := &PinnedMessage{}
+	err := row.Scan(
+		&pin.MessageID,
+		&pin.RoomID,
+		&pin.Position,
+		&pin.PinnedBy,
+		&pin.PinnedAt,
+		&pin.UserID,
+		&pin.Username,
+		&pin.Content,
+		&pin.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if pin.UserID == 0 {
+		pin.AuthorDeleted = true
+		if pin.Username == "" {
+			pin.Username = DeletedUsername
+		}
+	}
+	return pin, nil
+}
+
+// Count returns how many messages are pinned in a room
+func (s *PinStore) Count(ctx context.Context, roomID int64) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pinned_messages WHERE room_id = $1`, roomID).Scan(&count)
+	return count, err
+}
+
+// Search finds a room's pinned messages whose content contains q, ignoring
+// case, in position order. Redacted messages are skipped, as in MessageStore.Search
+func (s *PinStore) Search(ctx context.Context, roomID int64, q string, limit int) ([]*PinnedMessage, error) {
+	// q is matched literally, so LIKE's wildcards in it must be escaped
+	q = searchTerm(q)
+	if q == "" {
+		return make([]*PinnedMessage, 0), nil
+	}
+	limit, _ = clampPage(limit, 0, 20, maxRoomSearchLimit)
+
+	query := `
+		SELECT p.message_id, p.room_id, p.position, p.pinned_by, p.pinned_at,
+		       COALESCE(m.user_id, 0), COALESCE(u.username, m.author_username), m.content, m.created_at
+		FROM pinned_messages p
+		INNER JOIN messages m ON m.id = p.message_id
+		LEFT JOIN users u ON u.id = m.user_id
+		WHERE p.room_id = $1 AND m.content ILIKE '%' || $2 || '%' AND m.redacted_at IS NULL
+		ORDER BY p.position ASC, p.pinned_at ASC
+		LIMIT $3
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, roomID, escapeLike(q), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pins := make([]*PinnedMessage, 0)
+	for rows.Next() {
+		pin, err := scanPinnedMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, pin)
+	}
+	return pins, rows.Err()
+}
+
+// Reorder replaces the order of a room's pins
+// messageIDs must list every pinned message exactly once (else ErrPinSetMismatch),
+// and version must be the current pins version (else ErrPinsVersionConflict),
+// so two admins reordering at once can't interleave: the second one has to
+// reload and try again
+// Positions are renumbered 1..n, closing any gaps left by unpinning
+func (s *PinStore) Reorder(ctx context.Context, roomID int64, messageIDs []int64, version int64) (PinChange, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return PinChange{}, err
+	}
+	defer tx.Rollback()
+
+	current, err := lockPins(ctx, tx, roomID)
+	if err != nil {
+		return PinChange{}, err
+	}
+	if current != version {
+		return PinChange{}, ErrPinsVersionConflict
+	}
+
+	pinned, err := queryIDs(ctx, tx, `SELECT message_id FROM pinned_messages WHERE room_id = $1`, roomID)
+	if err != nil {
+		return PinChange{}, err
+	}
+	if !sameIDSet(pinned, messageIDs) {
+		return PinChange{}, ErrPinSetMismatch
+	}
+
+	// One statement: each message's position is its index in the list
+	updateQuery := `
+		UPDATE pinned_messages p
+		SET position = o.ord
+		FROM unnest($2::bigint[]) WITH ORDINALITY AS o(message_id, ord)
+		WHERE p.room_id = $1 AND p.message_id = o.message_id
+	`
+	if _, err := tx.ExecContext(ctx, updateQuery, roomID, pq.Array(messageIDs)); err != nil {
+		return PinChange{}, err
+	}
+
+	return bumpPinsVersion(ctx, tx, roomID, RoomEventPinOrderChanged, map[string]interface{}{"pinned_ids": messageIDs})
+}
+
+// lockPins locks a room's row for a pin change and returns its pins version
+// Every pin change takes this lock, so they're applied one at a time per room
+// Returns sql.ErrNoRows if the room doesn't exist or is deleted
+func lockPins(ctx context.Context, tx *sql.Tx, roomID int64) (int64, error) {
+	var version int64
+	query := `SELECT pins_version FROM rooms WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+	err := tx.QueryRowContext(ctx, query, roomID).Scan(&version)
+	return version, err
+}
+
+// bumpPinsVersion increments a room's pins version, logs the change as an
+// event and commits the transaction
+// The payload gets the new pins_version added to it
+func bumpPinsVersion(ctx context.Context, tx *sql.Tx, roomID int64, eventType string, payload map[string]interface{}) (PinChange, error) {
+	var change PinChange
+	query := `UPDATE rooms SET pins_version = pins_version + 1 WHERE id = $1 RETURNING pins_version`
+	if err := tx.QueryRowContext(ctx, query, roomID).Scan(&change.Version); err != nil {
+		return PinChange{}, err
+	}
+
+	payload["pins_version"] = change.Version
+	seq, err := appendRoomEvent(ctx, tx, roomID, eventType, payload)
+	if err != nil {
+		return PinChange{}, err
+	}
+	change.EventSeq = seq
+
+	if err := tx.Commit(); err != nil {
+		return PinChange{}, err
+	}
+	return change, nil
+}
+
+// sameIDSet reports whether b lists exactly the IDs in a, each once
+func sameIDSet(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	want := make(map[int64]bool, len(a))
+	for _, id := range a {
+		want[id] = true
+	}
+	for _, id := range b {
+		if !want[id] {
+			return false
+		}
+		// Deleting catches an ID listed twice
+		delete(want, id)
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// expectLockPins expects a pin change to lock room 1 at version
+func expectLockPins(mock sqlmock.Sqlmock, version int64) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT pins_version FROM rooms WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+		WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"pins_version"}).AddRow(version))
+}
+
+// TestReorderPins reorders three pins at the current version: positions
+// are set by one statement, the version is bumped and the change logged
+func TestReorderPins(t *testing.T) {
+	db, mock := newMockDB(t)
+	pins := &PinStore{db}
+	order := []int64{12, 10, 11}
+
+	expectLockPins(mock, 3)
+	mock.ExpectQuery(`SELECT message_id FROM pinned_messages WHERE room_id = \$1`).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id"}).AddRow(10).AddRow(11).AddRow(12))
+	mock.ExpectExec(`UPDATE pinned_messages p\s+SET position = o.ord\s+FROM unnest\(\$2::bigint\[\]\) WITH ORDINALITY`).
+		WithArgs(int64(1), pq.Array(order)).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectQuery(`UPDATE rooms SET pins_version = pins_version \+ 1 WHERE id = \$1 RETURNING pins_version`).
+		WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"pins_version"}).AddRow(4))
+	expectRoomEvent(mock, 1, RoomEventPinOrderChanged, 57)
+	mock.ExpectCommit()
+
+	change, err := pins.Reorder(context.Background(), 1, order, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Version != 4 || change.EventSeq != 57 {
+		t.Errorf("the change is %+v, want version 4 logged as event 57", change)
+	}
+}
+
+// TestReorderPinsRefused reorders pins that changed under the caller: pin
+// 12 was unpinned after they loaded the list
+// With the version they loaded it's a conflict; a list naming the old pins
+// at the new version doesn't match. Neither touches a position
+func TestReorderPinsRefused(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		version int64
+		order   []int64
+		want    error
+	}{
+		{"stale version", 3, []int64{12, 10, 11}, ErrPinsVersionConflict},
+		{"unpinned message listed", 4, []int64{12, 10, 11}, ErrPinSetMismatch},
+		{"pin left out", 4, []int64{10}, ErrPinSetMismatch},
+		{"pin listed twice", 4, []int64{10, 10}, ErrPinSetMismatch},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			pins := &PinStore{db}
+
+			expectLockPins(mock, 4) // Bumped by the unpin
+			if tc.want != ErrPinsVersionConflict {
+				mock.ExpectQuery(`SELECT message_id FROM pinned_messages`).
+					WillReturnRows(sqlmock.NewRows([]string{"message_id"}).AddRow(10).AddRow(11))
+			}
+			mock.ExpectRollback()
+
+			if _, err := pins.Reorder(context.Background(), 1, tc.order, tc.version); !errors.Is(err, tc.want) {
+				t.Errorf("Reorder returned %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+// TestPinRefused pins a message that's already pinned, one in another room
+// and one too many
+func TestPinRefused(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		inRoom   bool
+		count    int
+		inserted int64
+		want     error
+	}{
+		{"already pinned", true, 2, 0, ErrAlreadyPinned},
+		{"not in the room", false, 0, 0, sql.ErrNoRows},
+		{"bar full", true, MaxPinsPerRoom, 0, ErrTooManyPins},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			pins := &PinStore{db}
+
+			expectLockPins(mock, 1)
+			mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM messages WHERE id = \$1 AND room_id = \$2\)`).
+				WithArgs(int64(10), int64(1)).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tc.inRoom))
+			if tc.inRoom {
+				mock.ExpectQuery(`SELECT COUNT\(\*\) FROM pinned_messages WHERE room_id = \$1`).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tc.count))
+			}
+			if tc.inRoom && tc.count < MaxPinsPerRoom {
+				mock.ExpectExec(`INSERT INTO pinned_messages`).WithArgs(int64(1), int64(10), int64(2)).
+					WillReturnResult(sqlmock.NewResult(0, tc.inserted))
+			}
+			mock.ExpectRollback()
+
+			if _, err := pins.Pin(context.Background(), 1, 10, 2); !errors.Is(err, tc.want) {
+				t.Errorf("Pin returned %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
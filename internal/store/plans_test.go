@@ -0,0 +1,224 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// Query plans
+//
+// The hot store queries must stay index-driven: on a table with millions of
+// messages a sequential scan turns a 1ms query into seconds. TestQueryPlans
+// runs EXPLAIN on each of them against a database seeded with enough rows
+// for PostgreSQL to plan as it would in production, and fails on any
+// sequential scan of a large table, so a query change that loses its index
+// is caught before it ships
+
+// planCheckedTables are the tables that grow with usage; small tables like
+// rooms and users may be scanned when that's cheaper
+var planCheckedTables = map[string]bool{
+	"messages":     true,
+	"room_members": true,
+	"read_markers": true,
+}
+
+// plannedQuery is one hot query with arguments that are realistic for the dataset
+type plannedQuery struct {
+	name  string
+	query string
+	args  func(sample planSample) []interface{}
+}
+
+// planSample holds IDs picked from the dataset to run the queries with
+type planSample struct {
+	roomID   int64 // The room with the most messages
+	userID   int64 // A member of that room
+	oldestID int64 // The room's first message
+}
+
+// plannedQueries lists the queries TestQueryPlans checks
+// Add a query here when it runs on every request or message
+var plannedQueries = []plannedQuery{
+	{"MessageStore.GetRoomMessages", roomMessagesQuery, func(s planSample) []interface{} {
+		return []interface{}{s.roomID, 100}
+	}},
+	{"MessageStore.GetMessagesBefore", messagesBeforeQuery, func(s planSample) []interface{} {
+		// Deep in the history, where a bad plan would hurt most
+		return []interface{}{s.roomID, s.oldestID + 100, 50}
+	}},
+	{"MessageStore.GetMessagesAround", messagesAroundQuery, func(s planSample) []interface{} {
+		// A jump deep into the history, with the default 25 on each side
+		return []interface{}{s.roomID, s.oldestID + 100, 27, 26}
+	}},
+	{"MessageStore.Histogram", histogramQuery, func(s planSample) []interface{} {
+		// A year of days, the widest range the endpoint allows
+		return []interface{}{s.roomID, HistogramDay, time.Now().AddDate(-1, 0, 0), time.Now()}
+	}},
+	{"MessageStore.FirstMessageAt", firstMessageAtQuery, func(s planSample) []interface{} {
+		return []interface{}{s.roomID, time.Now().AddDate(0, -1, 0)}
+	}},
+	{"MessageStore.GetMessagesSince", messagesSinceQuery, func(s planSample) []interface{} {
+		return []interface{}{s.roomID, time.Now().Add(-time.Hour)}
+	}},
+	{"ReadMarkerStore.GetUnreadCount", unreadCountQuery, func(s planSample) []interface{} {
+		return []interface{}{s.userID, s.roomID}
+	}},
+	{"RoomMemberStore.IsUserInRoom", isMemberQuery, func(s planSample) []interface{} {
+		return []interface{}{s.roomID, s.userID}
+	}},
+	{"RoomStore.GetUserRoomSummaries", roomSummariesQuery(RoomListOptions{}), func(s planSample) []interface{} {
+		return []interface{}{s.userID, "", maxSummaryUnread}
+	}},
+}
+
+// Dataset TestQueryPlans seeds; a few seconds of inserts, once per database
+const (
+	planRooms    = 50
+	planUsers    = 200
+	planMessages = 200000
+)
+
+// TestQueryPlans checks every hot query plans with indexes on the large tables
+func TestQueryPlans(t *testing.T) {
+	db := testdb.Open(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	if err := seedPlanData(ctx, db, planRooms, planUsers, planMessages); err != nil {
+		t.Fatalf("seeding: %v", err)
+	}
+
+	// The room with the most messages, a member and its first message
+	var sample planSample
+	sampleQuery := `
+		SELECT m.room_id, (SELECT MIN(rm.user_id) FROM room_members rm WHERE rm.room_id = m.room_id), MIN(m.id)
+		FROM messages m
+		GROUP BY m.room_id
+		ORDER BY COUNT(*) DESC
+		LIMIT 1
+	`
+	var userID sql.NullInt64
+	if err := db.QueryRowContext(ctx, sampleQuery).Scan(&sample.roomID, &userID, &sample.oldestID); err != nil {
+		t.Fatalf("picking the sample room: %v", err)
+	}
+	sample.userID = userID.Int64
+
+	for _, planned := range plannedQueries {
+		t.Run(planned.name, func(t *testing.T) {
+			var plan []byte
+			if err := db.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+planned.query, planned.args(sample)...).Scan(&plan); err != nil {
+				t.Fatalf("EXPLAIN: %v", err)
+			}
+			seqScans, err := findSeqScans(plan)
+			if err != nil {
+				t.Fatalf("reading the plan: %v", err)
+			}
+			if len(seqScans) > 0 {
+				t.Errorf("sequential scan of %v in room %d as user %d; plan:\n%s", seqScans, sample.roomID, sample.userID, plan)
+			}
+		})
+	}
+}
+
+// planNode is the part of an EXPLAIN (FORMAT JSON) node the check looks at
+type planNode struct {
+	NodeType     string     `json:"Node Type"`
+	RelationName string     `json:"Relation Name"`
+	Plans        []planNode `json:"Plans"`
+}
+
+// findSeqScans returns the large tables an EXPLAIN (FORMAT JSON) plan scans sequentially
+func findSeqScans(raw []byte) ([]string, error) {
+	var explained []struct {
+		Plan planNode `json:"Plan"`
+	}
+	if err := json.Unmarshal(raw, &explained); err != nil {
+		return nil, err
+	}
+
+	var found []string
+	var walk func(node planNode)
+	walk = func(node planNode) {
+		if node.NodeType == "Seq Scan" && planCheckedTables[node.RelationName] {
+			found = append(found, node.RelationName)
+		}
+		for _, child := range node.Plans {
+			walk(child)
+		}
+	}
+	for _, e := range explained {
+		walk(e.Plan)
+	}
+	return found, nil
+}
+
+// seedPlanData fills the database with a synthetic dataset for TestQueryPlans:
+// users who are all members of every room, and messages spread evenly over
+// the rooms, one second apart going back from now
+// Rows are named queryplan-*, and seeding again only tops up the messages,
+// so it's safe to repeat. Only run it against a scratch database
+func seedPlanData(ctx context.Context, db *sql.DB, rooms, users, messages int) error {
+	usersQuery := `
+		INSERT INTO users (username, email, password)
+		SELECT 'queryplan-user-' || g, 'queryplan-user-' || g || '@example.invalid', '!'
+		FROM generate_series(1, $1) g
+		ON CONFLICT DO NOTHING
+	`
+	if _, err := db.ExecContext(ctx, usersQuery, users); err != nil {
+		return fmt.Errorf("seeding users: %w", err)
+	}
+
+	roomsQuery := `
+		INSERT INTO rooms (name, created_by)
+		SELECT 'queryplan-room-' || g, (SELECT MIN(id) FROM users WHERE username LIKE 'queryplan-user-%')
+		FROM generate_series(1, $1) g
+		ON CONFLICT DO NOTHING
+	`
+	if _, err := db.ExecContext(ctx, roomsQuery, rooms); err != nil {
+		return fmt.Errorf("seeding rooms: %w", err)
+	}
+
+	membersQuery := `
+		INSERT INTO room_members (room_id, user_id)
+		SELECT r.id, u.id
+		FROM rooms r, users u
+		WHERE r.name LIKE 'queryplan-room-%' AND u.username LIKE 'queryplan-user-%'
+		ON CONFLICT DO NOTHING
+	`
+	if _, err := db.ExecContext(ctx, membersQuery); err != nil {
+		return fmt.Errorf("seeding members: %w", err)
+	}
+
+	// Only the difference to the requested count is added
+	messagesQuery := `
+		WITH r AS (SELECT array_agg(id ORDER BY id) AS ids FROM rooms WHERE name LIKE 'queryplan-room-%'),
+		     u AS (SELECT array_agg(id ORDER BY id) AS ids FROM users WHERE username LIKE 'queryplan-user-%'),
+		     existing AS (
+		         SELECT COUNT(*) AS n FROM messages
+		         WHERE room_id IN (SELECT id FROM rooms WHERE name LIKE 'queryplan-room-%')
+		     )
+		INSERT INTO messages (room_id, user_id, content, created_at)
+		SELECT r.ids[1 + g % array_length(r.ids, 1)],
+		       u.ids[1 + g % array_length(u.ids, 1)],
+		       'queryplan message ' || g,
+		       NOW() - make_interval(secs => g)
+		FROM r, u, existing, generate_series(1, GREATEST($1 - existing.n, 0)) g
+	`
+	if _, err := db.ExecContext(ctx, messagesQuery, messages); err != nil {
+		return fmt.Errorf("seeding messages: %w", err)
+	}
+
+	// Fresh statistics, so the planner knows how big the tables are
+	if _, err := db.ExecContext(ctx, `ANALYZE users, rooms, room_members, messages, read_markers`); err != nil {
+		return fmt.Errorf("analyzing: %w", err)
+	}
+	return nil
+}
@@ -3,10 +3,13 @@ package store
 import (
 	"context"
 	"database/sql"
-	"github.com/lib/pq"
 	"time"
+
+	"github.com/lib/pq"
 )
 
+// Post represents a long-form post written by a user
+// Unlike chat messages, posts don't belong to a room and can be edited
 type Post struct {
 	ID        int64     `json:"id"`
 	Title     string    `json:"title"`
@@ -17,16 +20,25 @@ type Post struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// PostStore handles database operations for posts
 type PostStore struct {
 	db *sql.DB
 }
 
+// Create inserts a new post
+// It returns the generated ID and timestamps via the RETURNING clause
 func (s *PostStore) Create(ctx context.Context, post *Post) error {
 	query := `
-	INSERT INTO posts (title, content, user_id, tags)
-	VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at
+		INSERT INTO posts (title, content, user_id, tags)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at
 	`
 
+	// A nil slice would be stored as NULL; posts without tags get an empty array
+	if post.Tags == nil {
+		post.Tags = []string{}
+	}
+
+	// pq.Array converts the Go slice into a Postgres array
 	err := s.db.QueryRowContext(
 		ctx,
 		query,
@@ -44,3 +56,98 @@ func (s *PostStore) Create(ctx context.Context, post *Post) error {
 	}
 	return nil
 }
+
+// GetByID retrieves a post by its ID
+// Returns sql.ErrNoRows if the post doesn't exist
+func (s *PostStore) GetByID(ctx context.Context, id int64) (*Post, error) {
+	query := `
+		SELECT id, title, content, user_id, tags, created_at, updated_at
+		FROM posts
+		WHERE id = $1
+	`
+
+	return scanPost(s.db.QueryRowContext(ctx, query, id))
+}
+
+// List returns a page of posts, newest first
+// limit and offset implement simple page-based pagination
+func (s *PostStore) List(ctx context.Context, limit, offset int) ([]*Post, error) {
+	limit, offset = clampPage(limit, offset, 20, 100)
+
+	query := `
+		SELECT id, title, content, user_id, tags, created_at, updated_at
+		FROM posts
+		ORDER BY created_at DESC, id DESC
+		LIMIT $1 OFFSET $2
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := make([]*Post, 0, limit)
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return posts, nil
+}
+
+// Update saves a post's title, content, and tags
+// Returns sql.ErrNoRows if the post doesn't exist
+func (s *PostStore) Update(ctx context.Context, post *Post) error {
+	query := `
+		UPDATE posts
+		SET title = $1, content = $2, tags = $3, updated_at = NOW()
+		WHERE id = $4
+		RETURNING updated_at
+	`
+
+	if post.Tags == nil {
+		post.Tags = []string{}
+	}
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		post.Title,
+		post.Content,
+		pq.Array(post.Tags),
+		post.ID,
+	).Scan(&post.UpdatedAt)
+}
+
+// Delete removes a post
+// Deleting a post that doesn't exist is not an error (idempotent operation)
+func (s *PostStore) Delete(ctx context.Context, id int64) error {
+	query := `DELETE FROM posts WHERE id = $1`
+	_, err := s.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// scanPost scans a single post row
+func scanPost(row rowScanner) (*Post, error) {
+	post := &Post{}
+	err := row.Scan(
+		&post.ID,
+		&post.Title,
+		&post.Content,
+		&post.UserID,
+		pq.Array(&post.Tags),
+		&post.CreatedAt,
+		&post.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return post, nil
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestPostsAreAFeature keeps posts wired into Storage: they're a supported
+// feature with their own endpoints, not a remnant to be dropped quietly
+func TestPostsAreAFeature(t *testing.T) {
+	db, _ := newMockDB(t)
+	posts, _ := NewPostgresStorage(NewPools(db, nil), Limits{}).Posts.(timedPosts)
+	if posts.timedStorage == nil {
+		t.Fatal("NewPostgresStorage doesn't bound Posts' operations")
+	}
+	if _, ok := posts.next.Posts.(*PostStore); !ok {
+		t.Error("NewPostgresStorage doesn't back Posts with a PostStore")
+	}
+}
+
+// TestPostTags saves and reads posts' tags as PostgreSQL arrays: a post
+// without tags is saved with an empty array rather than NULL
+func TestPostTags(t *testing.T) {
+	db, mock := newMockDB(t)
+	posts := &PostStore{db}
+	now := time.Now()
+
+	mock.ExpectQuery(`INSERT INTO posts`).WithArgs("Hello", "First post", int64(1), "{}").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(1, now, now))
+
+	post := &Post{Title: "Hello", Content: "First post", UserID: 1}
+	if err := posts.Create(context.Background(), post); err != nil {
+		t.Fatal(err)
+	}
+	if post.ID != 1 || post.Tags == nil {
+		t.Errorf("created %+v, want post 1 with an empty tag list", post)
+	}
+
+	mock.ExpectQuery(`FROM posts\s+WHERE id = \$1`).WithArgs(int64(1)).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "title", "content", "user_id", "tags", "created_at", "updated_at"}).
+			AddRow(1, "Hello", "First post", 1, `{news,"go chat"}`, now, now))
+
+	read, err := posts.GetByID(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Tags) != 2 || read.Tags[1] != "go chat" {
+		t.Errorf("read tags %q, want news and go chat", read.Tags)
+	}
+}
+
+// TestListPostsPage lists a page of posts newest first, with the limit and
+// offset passed through
+func TestListPostsPage(t *testing.T) {
+	db, mock := newMockDB(t)
+	posts := &PostStore{db}
+	now := time.Now()
+
+	mock.ExpectQuery(`ORDER BY created_at DESC, id DESC\s+LIMIT \$1 OFFSET \$2`).WithArgs(2, 4).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "title", "content", "user_id", "tags", "created_at", "updated_at"}).
+			AddRow(6, "six", "x", 1, "{}", now, now).
+			AddRow(5, "five", "x", 1, "{}", now, now))
+
+	page, err := posts.List(context.Background(), 2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 || page[0].ID != 6 {
+		t.Errorf("listed %d posts, want 6 then 5", len(page))
+	}
+}
+
+// TestUpdateMissingPost updates a post that's gone: the missing row comes
+// back as sql.ErrNoRows
+func TestUpdateMissingPost(t *testing.T) {
+	db, mock := newMockDB(t)
+	posts := &PostStore{db}
+
+	mock.ExpectQuery(`UPDATE posts`).WithArgs("t", "c", "{}", int64(9)).
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at"}))
+
+	if err := posts.Update(context.Background(), &Post{ID: 9, Title: "t", Content: "c"}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("updating a missing post returned %v, want sql.ErrNoRows", err)
+	}
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Push platforms accepted by PushToken.Platform
+const (
+	PushPlatformAPNs    = "apns"    // Apple Push Notification service
+	PushPlatformFCM     = "fcm"     // Firebase Cloud Messaging
+	PushPlatformWebPush = "webpush" // Browser push subscriptions
+)
+
+// ValidPushPlatform reports whether platform is one of the PushPlatform constants
+func ValidPushPlatform(platform string) bool {
+	return platform == PushPlatformAPNs || platform == PushPlatformFCM || platform == PushPlatformWebPush
+}
+
+// PushToken is the address a push service uses to reach one device
+type PushToken struct {
+	ID                  int64      `json:"id"`
+	DeviceID            int64      `json:"device_id"`
+	UserID              int64      `json:"user_id"`
+	Platform            string     `json:"platform"`
+	Token               string     `json:"-"` // Never echoed back; it's a credential for reaching the device
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	DisabledAt          *time.Time `json:"disabled_at"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+// PushTokenStore handles database operations for push tokens
+type PushTokenStore struct {
+	db *sql.DB
+}
+
+// Upsert sets the push token of a device, replacing any previous one
+// A new token starts with a clean slate, so a disabled device is re-enabled
+func (s *PushTokenStore) Upsert(ctx context.Context, token *PushToken) error {
+	query := `
+		INSERT INTO device_push_tokens (device_id, user_id, platform, token)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (device_id) DO UPDATE
+		SET platform = EXCLUDED.platform, token = EXCLUDED.token,
+			consecutive_failures = 0, disabled_at = NULL, updated_at = NOW()
+		RETURNING id, consecutive_failures, disabled_at, created_at, updated_at
+	`
+
+	return s.db.QueryRowContext(ctx, query, token.DeviceID, token.UserID, token.Platform, token.Token).Scan(
+		&token.ID,
+		&token.ConsecutiveFailures,
+		&token.DisabledAt,
+		&token.CreatedAt,
+		&token.UpdatedAt,
+	)
+}
+
+// Delete removes a device's push token
+// The user ID is part of the WHERE clause so a client can't remove another user's token
+// Returns sql.ErrNoRows if the device has no token
+func (s *PushTokenStore) Delete(ctx context.Context, deviceID, userID int64) error {
+	query := `DELETE FROM device_push_tokens WHERE device_id = $1 AND user_id = $2`
+	return expectOneRow(s.db.ExecContext(ctx, query, deviceID, userID))
+}
+
+// ListActiveForUsers returns the tokens that are not disabled for the given users
+func (s *PushTokenStore) ListActiveForUsers(ctx context.Context, userIDs []int64) ([]*PushToken, error) {
+	query := `
+		SELECT id, device_id, user_id, platform, token, consecutive_failures, disabled_at, created_at, updated_at
+		FROM device_push_tokens
+		WHERE user_id = ANY($1) AND disabled_at IS NULL
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]*PushToken, 0)
+	for rows.Next() {
+		token := &PushToken{}
+		err := rows.Scan(
+			&token.ID,
+			&token.DeviceID,
+			&token.UserID,
+			&token.Platform,
+			&token.Token,
+			&token.ConsecutiveFailures,
+			&token.DisabledAt,
+			&token.CreatedAt,
+			&token.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// RecordFailure counts a permanent delivery failure for a token
+// Once maxFailures failures happen in a row the token is disabled
+// Returns true if this failure disabled the token
+func (s *PushTokenStore) RecordFailure(ctx context.Context, id int64, maxFailures int) (bool, error) {
+	query := `
+		UPDATE device_push_tokens
+		SET consecutive_failures = consecutive_failures + 1,
+			disabled_at = CASE
+				WHEN consecutive_failures + 1 >= $2 THEN NOW()
+				ELSE disabled_at
+			END,
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING disabled_at IS NOT NULL
+	`
+
+	var disabled bool
+	err := s.db.QueryRowContext(ctx, query, id, maxFailures).Scan(&disabled)
+	return disabled, err
+}
+
+// RecordSuccess resets a token's failure streak after a successful delivery
+func (s *PushTokenStore) RecordSuccess(ctx context.Context, id int64) error {
+	// Skips the write in the common case where there was no streak
+	query := `
+		UPDATE device_push_tokens
+		SET consecutive_failures = 0, updated_at = NOW()
+		WHERE id = $1 AND consecutive_failures > 0
+	`
+	_, err := s.db.ExecContext(ctx, query, id)
+	return err
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// TestPushTokenUpsert sets a device's token: an existing one is replaced
+// with its failure streak cleared, and the row's fields are read back
+func TestPushTokenUpsert(t *testing.T) {
+	db, mock := newMockDB(t)
+	tokens := &PushTokenStore{db}
+	now := time.Now()
+
+	mock.ExpectQuery(`ON CONFLICT \(device_id\) DO UPDATE\s+SET platform = EXCLUDED.platform, token = EXCLUDED.token,\s+consecutive_failures = 0, disabled_at = NULL`).
+		WithArgs(int64(7), int64(2), PushPlatformFCM, "abc").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "consecutive_failures", "disabled_at", "created_at", "updated_at"}).AddRow(3, 0, nil, now, now))
+
+	token := &PushToken{DeviceID: 7, UserID: 2, Platform: PushPlatformFCM, Token: "abc"}
+	if err := tokens.Upsert(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+	if token.ID != 3 || token.DisabledAt != nil {
+		t.Errorf("got %+v, want token 3, enabled", token)
+	}
+}
+
+// TestPushTokenDeleteChecksOwner deletes a device's token: another user's
+// device is reported as having none
+func TestPushTokenDeleteChecksOwner(t *testing.T) {
+	db, mock := newMockDB(t)
+	tokens := &PushTokenStore{db}
+
+	mock.ExpectExec(`DELETE FROM device_push_tokens WHERE device_id = \$1 AND user_id = \$2`).
+		WithArgs(int64(7), int64(3)).WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := tokens.Delete(context.Background(), 7, 3); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("another user's delete returned %v, want sql.ErrNoRows", err)
+	}
+}
+
+// TestPushTokenFailures counts failures against a token: the threshold is
+// passed to the query that disables it, and the answer says whether it did
+func TestPushTokenFailures(t *testing.T) {
+	db, mock := newMockDB(t)
+	tokens := &PushTokenStore{db}
+	ctx := context.Background()
+
+	mock.ExpectQuery(`SET consecutive_failures = consecutive_failures \+ 1,\s+disabled_at = CASE\s+WHEN consecutive_failures \+ 1 >= \$2 THEN NOW\(\)`).
+		WithArgs(int64(3), 5).WillReturnRows(sqlmock.NewRows([]string{"disabled"}).AddRow(true))
+	disabled, err := tokens.RecordFailure(ctx, 3, 5)
+	if err != nil || !disabled {
+		t.Errorf("RecordFailure = %v, %v; want true", disabled, err)
+	}
+
+	// Success only writes when there's a streak to reset
+	mock.ExpectExec(`SET consecutive_failures = 0, updated_at = NOW\(\)\s+WHERE id = \$1 AND consecutive_failures > 0`).
+		WithArgs(int64(3)).WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := tokens.RecordSuccess(ctx, 3); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestListActiveForUsers checks disabled tokens are left out and the users
+// are sent as one array
+func TestListActiveForUsers(t *testing.T) {
+	db, mock := newMockDB(t)
+	tokens := &PushTokenStore{db}
+	now := time.Now()
+
+	mock.ExpectQuery(`WHERE user_id = ANY\(\$1\) AND disabled_at IS NULL`).WithArgs(pq.Array([]int64{2, 3})).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "device_id", "user_id", "platform", "token", "consecutive_failures", "disabled_at", "created_at", "updated_at"}).
+			AddRow(1, 7, 2, "fcm", "abc", 0, nil, now, now).
+			AddRow(2, 8, 3, "apns", "def", 1, nil, now, now))
+
+	active, err := tokens.ListActiveForUsers(context.Background(), []int64{2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 2 || active[1].Token != "def" || active[1].ConsecutiveFailures != 1 {
+		t.Errorf("got %+v", active)
+	}
+}
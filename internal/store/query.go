@@ -0,0 +1,111 @@
+package store
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Query building rules for this package
+//
+// User input only ever reaches PostgreSQL as a bind parameter ($1, $2, ...)
+// The few parts of a query that parameters can't express are built by the
+// helpers below, which only ever return fixed strings or numbers:
+//   - sort orders come from a sortOrders table, never from the request
+//   - LIMIT/OFFSET values are clamped with clampPage and still passed as parameters
+//   - search terms are cleaned with searchTerm and escaped with escapeLike
+//     before they're passed as an ILIKE parameter
+//   - mentions of a username column are matched with mentionMatch
+
+// sortOrders maps the sort keys an API accepts to fixed ORDER BY clauses
+// Unknown keys get the fallback, so a request can never choose the SQL
+type sortOrders struct {
+	clauses  map[string]string
+	fallback string
+}
+
+// clause returns the ORDER BY clause for key
+func (o sortOrders) clause(key string) string {
+	if clause, ok := o.clauses[key]; ok {
+		return clause
+	}
+	return o.fallback
+}
+
+// valid reports whether key is one of the accepted sort keys
+func (o sortOrders) valid(key string) bool {
+	_, ok := o.clauses[key]
+	return ok
+}
+
+// clampPage keeps a limit and offset within bounds
+// A limit below 1 becomes defaultLimit and one above maxLimit becomes maxLimit;
+// a negative offset becomes 0
+// Handlers still reject bad values with a 400; this keeps a caller that
+// forgot from asking the database for a million rows
+func clampPage(limit, offset, defaultLimit, maxLimit int) (int, int) {
+	if limit < 1 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+// maxSearchTermLength caps search terms in characters; longer input is cut
+const maxSearchTermLength = 100
+
+// maxRoomSearchLimit caps how many results one section of a room search returns
+const maxRoomSearchLimit = 50
+
+// searchTerm cleans user input before it's used as a search term
+// PostgreSQL rejects text containing NUL bytes or invalid UTF-8 with an error,
+// so both are removed, and the term is capped at maxSearchTermLength characters
+func searchTerm(q string) string {
+	q = strings.ToValidUTF8(q, "")
+	q = strings.ReplaceAll(q, "\x00", "")
+	if runes := []rune(q); len(runes) > maxSearchTermLength {
+		q = string(runes[:maxSearchTermLength])
+	}
+	return q
+}
+
+// likeEscaper escapes the LIKE wildcards and the escape character itself
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike makes s match literally in a LIKE/ILIKE pattern
+// The query adds its own wildcards around the parameter, e.g.
+// ILIKE '%' || $1 || '%' (backslash is PostgreSQL's default escape character)
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// mentionMatch returns a condition that holds when the text column mentions
+// the user in the username column as "@username"
+// It follows content.Mentions, which the hub's mention alerts use: the @ must
+// not follow a letter, digit, underscore or another @, and the name must end
+// there, so "@bob" isn't found in "@bobby", "@bob_" or "@bob.smith" but is
+// in "thanks @bob." The username is escaped in SQL (every character that
+// isn't a letter, digit or underscore gets a backslash), since it comes from
+// the row; both arguments are column names, never user input
+func mentionMatch(text, username string) string {
+	return text + ` ~ ('(?<![[:alnum:]_@])@' || regexp_replace(` + username + `, '([^[:alnum:]_])', '\\\1', 'g') || '(?![[:alnum:]_]|[.-][[:alnum:]_])')`
+}
+
+// IsUniqueViolation reports whether err is PostgreSQL's unique_violation (23505)
+// Use it instead of looking for "unique" or "duplicate" in the error text
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// IsForeignKeyViolation reports whether err is PostgreSQL's foreign_key_violation (23503)
+func IsForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
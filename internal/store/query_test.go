@@ -0,0 +1,104 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// TestEscapeLike escapes the wildcards and the escape character, and
+// nothing else
+func TestEscapeLike(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"ada", "ada"},
+		{"100%", `100\%`},
+		{"a_b", `a\_b`},
+		{`back\slash`, `back\\slash`},
+		{`\%_`, `\\\%\_`},
+		{`it's "quoted"`, `it's "quoted"`},
+		{"", ""},
+	} {
+		if got := escapeLike(tc.in); got != tc.want {
+			t.Errorf("escapeLike(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// TestSearchTerm drops what PostgreSQL would reject and caps the length in
+// characters, not bytes
+func TestSearchTerm(t *testing.T) {
+	long := strings.Repeat("ü", maxSearchTermLength+5)
+	for _, tc := range []struct {
+		name, in, want string
+	}{
+		{"plain", "ada", "ada"},
+		{"NUL bytes", "a\x00d\x00a", "ada"},
+		{"invalid UTF-8", "ad\xffa", "ada"},
+		{"too long", long, long[:2*maxSearchTermLength]},
+	} {
+		if got := searchTerm(tc.in); got != tc.want {
+			t.Errorf("%s: searchTerm(%q) = %q, want %q", tc.name, tc.in, got, tc.want)
+		}
+	}
+}
+
+// TestClampPage fills in and caps the limit and floors the offset
+func TestClampPage(t *testing.T) {
+	for _, tc := range []struct {
+		limit, offset         int
+		wantLimit, wantOffset int
+	}{
+		{10, 5, 10, 5},
+		{0, 0, 20, 0},
+		{-3, -1, 20, 0},
+		{1000000, 40, 100, 40},
+	} {
+		limit, offset := clampPage(tc.limit, tc.offset, 20, 100)
+		if limit != tc.wantLimit || offset != tc.wantOffset {
+			t.Errorf("clampPage(%d, %d) = %d, %d; want %d, %d", tc.limit, tc.offset, limit, offset, tc.wantLimit, tc.wantOffset)
+		}
+	}
+}
+
+// TestRoomSortOrders maps the known keys and falls back for anything else
+func TestRoomSortOrders(t *testing.T) {
+	for _, tc := range []struct {
+		sort  string
+		valid bool
+		want  string
+	}{
+		{RoomSortCreated, true, "ORDER BY r.created_at DESC"},
+		{RoomSortActivity, true, "ORDER BY r.last_message_at DESC NULLS LAST, r.created_at DESC"},
+		{"", false, "ORDER BY r.created_at DESC"},
+		{"name; DROP TABLE rooms", false, "ORDER BY r.created_at DESC"},
+	} {
+		if ValidRoomSort(tc.sort) != tc.valid || (RoomListOptions{Sort: tc.sort}).orderBy() != tc.want {
+			t.Errorf("%q: valid %v, ordered by %q", tc.sort, ValidRoomSort(tc.sort), (RoomListOptions{Sort: tc.sort}).orderBy())
+		}
+	}
+}
+
+// TestIsUniqueViolation looks at the SQLSTATE, wrapped or not, and not at
+// the message
+func TestIsUniqueViolation(t *testing.T) {
+	violation := &pq.Error{Code: "23505"}
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{violation, true},
+		{fmt.Errorf("creating user: %w", violation), true},
+		{&pq.Error{Code: "23503", Message: "duplicate-looking foreign key"}, false},
+		{errors.New("duplicate key value violates unique constraint"), false},
+		{nil, false},
+	} {
+		if got := IsUniqueViolation(tc.err); got != tc.want {
+			t.Errorf("IsUniqueViolation(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// DeviceReadPosition is the last message a single device has read in a room
+type DeviceReadPosition struct {
+	DeviceID          int64 `json:"device_id"`
+	LastReadMessageID int64 `json:"last_read_message_id"`
+}
+
+// RoomSyncState is the read state of one room for one user
+// LastReadMessageID is the furthest position across all of the user's devices,
+// which is what the user-level unread count is computed from
+type RoomSyncState struct {
+	RoomID            int64                `json:"room_id"`
+	LatestMessageID   int64                `json:"latest_message_id"`
+	LastReadMessageID int64                `json:"last_read_message_id"`
+	UnreadCount       int                  `json:"unread_count"`
+	Devices           []DeviceReadPosition `json:"devices"`
+}
+
+// ReadMarkerStore handles database operations for per-device read positions
+type ReadMarkerStore struct {
+	db *sql.DB
+}
+
+// MarkRead moves a device's read position in a room forward
+// GREATEST makes this monotonic: a stale client reporting an older message
+// can't move the marker backwards
+func (s *ReadMarkerStore) MarkRead(ctx context.Context, userID, roomID, deviceID, messageID int64) error {
+	query := `
+		INSERT INTO read_markers (user_id, room_id, device_id, last_read_message_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, room_id, device_id) DO UPDATE
+		SET last_read_message_id = GREATEST(read_markers.last_read_message_id, EXCLUDED.last_read_message_id),
+			updated_at = NOW()
+	`
+
+	_, err := s.db.ExecContext(ctx, query, userID, roomID, deviceID, messageID)
+	return err
+}
+
+// unreadCountQuery counts a user's unread messages in a room
+const unreadCountQuery = `
+	SELECT COUNT(*)
+	FROM messages m
+	WHERE m.room_id = $2
+	AND m.id > COALESCE((
+		SELECT MAX(last_read_message_id)
+		FROM read_markers
+		WHERE user_id = $1 AND room_id = $2
+	), 0)
+`
+
+// GetUnreadCount returns how many messages in a room the user hasn't read on any device
+// The count and the max-across-devices are computed in a single statement
+func (s *ReadMarkerStore) GetUnreadCount(ctx context.Context, userID, roomID int64) (int, error) {
+	var count int
+	if err := s.db.QueryRowContext(ctx, unreadCountQuery, userID, roomID).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// GetSyncState returns the read state of every room the user belongs to
+// Room-level numbers come from one query and per-device positions from a second,
+// so the cost doesn't grow with the number of rooms
+func (s *ReadMarkerStore) GetSyncState(ctx context.Context, userID int64) ([]*RoomSyncState, error) {
+	roomsQuery := `
+		SELECT rm.room_id,
+			COALESCE(latest.id, 0),
+			COALESCE(reads.read_max, 0),
+			(
+				SELECT COUNT(*) FROM messages m
+				WHERE m.room_id = rm.room_id AND m.id > COALESCE(reads.read_max, 0)
+			)
+		FROM room_members rm
+		LEFT JOIN LATERAL (
+			SELECT MAX(id) AS id FROM messages WHERE room_id = rm.room_id
+		) latest ON TRUE
+		LEFT JOIN LATERAL (
+			SELECT MAX(last_read_message_id) AS read_max
+			FROM read_markers
+			WHERE user_id = rm.user_id AND room_id = rm.room_id
+		) reads ON TRUE
+		WHERE rm.user_id = $1
+			AND NOT EXISTS (SELECT 1 FROM rooms r WHERE r.id = rm.room_id AND r.deleted_at IS NOT NULL)
+		ORDER BY rm.room_id
+	`
+
+	rows, err := s.db.QueryContext(ctx, roomsQuery, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := make([]*RoomSyncState, 0)
+	byRoom := make(map[int64]*RoomSyncState)
+	for rows.Next() {
+		state := &RoomSyncState{Devices: []DeviceReadPosition{}}
+		err := rows.Scan(
+			&state.RoomID,
+			&state.LatestMessageID,
+			&state.LastReadMessageID,
+			&state.UnreadCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+		byRoom[state.RoomID] = state
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	devicesQuery := `
+		SELECT room_id, device_id, last_read_message_id
+		FROM read_markers
+		WHERE user_id = $1
+		ORDER BY room_id, device_id
+	`
+
+	deviceRows, err := s.db.QueryContext(ctx, devicesQuery, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer deviceRows.Close()
+
+	for deviceRows.Next() {
+		var roomID int64
+		var position DeviceReadPosition
+		if err := deviceRows.Scan(&roomID, &position.DeviceID, &position.LastReadMessageID); err != nil {
+			return nil, err
+		}
+		// Markers for rooms the user has since left are ignored
+		if state, ok := byRoom[roomID]; ok {
+			state.Devices = append(state.Devices, position)
+		}
+	}
+	if err := deviceRows.Err(); err != nil {
+		return nil, err
+	}
+
+	return states, nil
+}
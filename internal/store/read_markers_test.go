@@ -0,0 +1,58 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestMarkReadIsMonotonic moves a read marker: the upsert keeps the greater
+// of the old and new positions
+func TestMarkReadIsMonotonic(t *testing.T) {
+	db, mock := newMockDB(t)
+	markers := &ReadMarkerStore{db}
+
+	mock.ExpectExec(`ON CONFLICT \(user_id, room_id, device_id\) DO UPDATE\s+SET last_read_message_id = GREATEST\(read_markers.last_read_message_id, EXCLUDED.last_read_message_id\)`).
+		WithArgs(int64(1), int64(2), int64(3), int64(40)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := markers.MarkRead(context.Background(), 1, 2, 3, 40); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestGetSyncState reads a user's sync state in two queries, however many
+// rooms they're in, with each room's device positions attached and markers
+// for rooms they've left ignored
+func TestGetSyncState(t *testing.T) {
+	db, mock := newMockDB(t)
+	markers := &ReadMarkerStore{db}
+
+	mock.ExpectQuery(`FROM room_members rm`).WithArgs(int64(1)).WillReturnRows(
+		sqlmock.NewRows([]string{"room_id", "latest", "read_max", "unread"}).
+			AddRow(10, 90, 80, 10).
+			AddRow(11, 0, 0, 0))
+	mock.ExpectQuery(`SELECT room_id, device_id, last_read_message_id\s+FROM read_markers`).WithArgs(int64(1)).WillReturnRows(
+		sqlmock.NewRows([]string{"room_id", "device_id", "last_read_message_id"}).
+			AddRow(10, 0, 60).
+			AddRow(10, 7, 80).
+			AddRow(12, 7, 5))
+
+	states, err := markers.GetSyncState(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 {
+		t.Fatalf("got %d rooms, want 2", len(states))
+	}
+	first := states[0]
+	if first.RoomID != 10 || first.LatestMessageID != 90 || first.LastReadMessageID != 80 || first.UnreadCount != 10 {
+		t.Errorf("room 10 read %+v", first)
+	}
+	if len(first.Devices) != 2 || first.Devices[1] != (DeviceReadPosition{DeviceID: 7, LastReadMessageID: 80}) {
+		t.Errorf("room 10 has devices %+v, want 0 and 7", first.Devices)
+	}
+	if states[1].Devices == nil || len(states[1].Devices) != 0 {
+		t.Errorf("room 11 has devices %#v, want an empty list", states[1].Devices)
+	}
+}
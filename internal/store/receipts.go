@@ -0,0 +1,133 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// DeliveryMark says a message (and everything before it) reached a user in a room
+type DeliveryMark struct {
+	RoomID    int64
+	UserID    int64
+	MessageID int64
+}
+
+// MessageReceipts is the delivery and read state of one message
+// The sender is left out: their own message is trivially delivered and read
+type MessageReceipts struct {
+	MessageID      int64   `json:"message_id"`
+	DeliveredCount int     `json:"delivered_count"`
+	ReadCount      int     `json:"read_count"`
+	DeliveredTo    []int64 `json:"delivered_to"`
+	ReadBy         []int64 `json:"read_by"`
+}
+
+// ReceiptStore handles database operations for delivery receipts
+type ReceiptStore struct {
+	db *sql.DB
+}
+
+// MarkDelivered advances delivery pointers for a batch of (room, user) pairs
+// The whole batch is one UPDATE: unnest turns the three arrays into rows that
+// are joined against room_members
+// GREATEST keeps pointers monotonic if batches are applied out of order
+func (s *ReceiptStore) MarkDelivered(ctx context.Context, marks []DeliveryMark) error {
+	if len(marks) == 0 {
+		return nil
+	}
+
+	roomIDs := make([]int64, len(marks))
+	userIDs := make([]int64, len(marks))
+	messageIDs := make([]int64, len(marks))
+	for i, mark := range marks {
+		roomIDs[i] = mark.RoomID
+		userIDs[i] = mark.UserID
+		messageIDs[i] = mark.MessageID
+	}
+
+	query := `
+		UPDATE room_members rm
+		SET delivered_up_to = GREATEST(rm.delivered_up_to, d.message_id)
+		FROM unnest($1::bigint[], $2::bigint[], $3::bigint[]) AS d(room_id, user_id, message_id)
+		WHERE rm.room_id = d.room_id AND rm.user_id = d.user_id
+	`
+
+	_, err := s.db.ExecContext(ctx, query, pq.Array(roomIDs), pq.Array(userIDs), pq.Array(messageIDs))
+	return err
+}
+
+// MarkDeliveredLatest advances a user's delivery pointer to the newest message in a room
+// Used when a user who was offline connects or fetches history
+func (s *ReceiptStore) MarkDeliveredLatest(ctx context.Context, roomID, userID int64) error {
+	query := `
+		UPDATE room_members
+		SET delivered_up_to = GREATEST(delivered_up_to, (
+			SELECT COALESCE(MAX(id), 0) FROM messages WHERE room_id = $1
+		))
+		WHERE room_id = $1 AND user_id = $2
+	`
+
+	_, err := s.db.ExecContext(ctx, query, roomID, userID)
+	return err
+}
+
+// GetReceipts returns who has received and read a message
+// A member counts as having received a message they've read, even if the
+// delivery pointer never caught up (e.g. they read it via the REST API)
+// Returns sql.ErrNoRows if the message doesn't exist in the room
+func (s *ReceiptStore) GetReceipts(ctx context.Context, roomID, messageID int64) (*MessageReceipts, error) {
+	var senderID int64
+	// A deleted sender is 0, which no member is
+	senderQuery := `SELECT COALESCE(user_id, 0) FROM messages WHERE id = $1 AND room_id = $2`
+	if err := s.db.QueryRowContext(ctx, senderQuery, messageID, roomID).Scan(&senderID); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT rm.user_id,
+			COALESCE(reads.read_max, 0) >= $2 AS has_read,
+			rm.delivered_up_to >= $2 OR COALESCE(reads.read_max, 0) >= $2 AS delivered
+		FROM room_members rm
+		LEFT JOIN LATERAL (
+			SELECT MAX(last_read_message_id) AS read_max
+			FROM read_markers
+			WHERE user_id = rm.user_id AND room_id = rm.room_id
+		) reads ON TRUE
+		WHERE rm.room_id = $1 AND rm.user_id <> $3
+		ORDER BY rm.user_id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, roomID, messageID, senderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	receipts := &MessageReceipts{
+		MessageID:   messageID,
+		DeliveredTo: []int64{},
+		ReadBy:      []int64{},
+	}
+	for rows.Next() {
+		var userID int64
+		var hasRead, delivered bool
+		if err := rows.Scan(&userID, &hasRead, &delivered); err != nil {
+			return nil, err
+		}
+		if delivered {
+			receipts.DeliveredTo = append(receipts.DeliveredTo, userID)
+		}
+		if hasRead {
+			receipts.ReadBy = append(receipts.ReadBy, userID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	receipts.DeliveredCount = len(receipts.DeliveredTo)
+	receipts.ReadCount = len(receipts.ReadBy)
+	return receipts, nil
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestMarkDeliveredIsOneUpdate writes a batch of delivery pointers: however
+// many there are, it's a single UPDATE over three parallel arrays, and an
+// empty batch doesn't touch the database
+func TestMarkDeliveredIsOneUpdate(t *testing.T) {
+	db, mock := newMockDB(t)
+	receipts := &ReceiptStore{db}
+
+	if err := receipts.MarkDelivered(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec(`UPDATE room_members rm\s+SET delivered_up_to = GREATEST\(rm.delivered_up_to, d.message_id\)\s+FROM unnest`).
+		WithArgs("{1,1,2}", "{2,3,2}", "{40,41,7}").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	err := receipts.MarkDelivered(context.Background(), []DeliveryMark{
+		{RoomID: 1, UserID: 2, MessageID: 40},
+		{RoomID: 1, UserID: 3, MessageID: 41},
+		{RoomID: 2, UserID: 2, MessageID: 7},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestGetReceipts reads a message's receipts: the sender is left out, each
+// member is listed under what they've had, and a message from another room
+// isn't found
+func TestGetReceipts(t *testing.T) {
+	db, mock := newMockDB(t)
+	receipts := &ReceiptStore{db}
+
+	mock.ExpectQuery(`SELECT COALESCE\(user_id, 0\) FROM messages WHERE id = \$1 AND room_id = \$2`).WithArgs(int64(9), int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectQuery(`FROM room_members rm`).WithArgs(int64(1), int64(9), int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "has_read", "delivered"}).
+			AddRow(2, true, true).
+			AddRow(3, false, true).
+			AddRow(4, false, false))
+
+	got, err := receipts.GetReceipts(context.Background(), 1, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got.DeliveredTo, []int64{2, 3}) || !slices.Equal(got.ReadBy, []int64{2}) ||
+		got.DeliveredCount != 2 || got.ReadCount != 1 {
+		t.Errorf("got %+v, want delivered to 2 and 3, read by 2", got)
+	}
+
+	mock.ExpectQuery(`SELECT COALESCE\(user_id, 0\) FROM messages`).WithArgs(int64(9), int64(5)).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	if _, err := receipts.GetReceipts(context.Background(), 5, 9); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("a message from another room returned %v, want sql.ErrNoRows", err)
+	}
+}
@@ -0,0 +1,94 @@
+package store
+
+import "context"
+
+// RoomRecommendation is a room suggested to a user, with the parts of its score
+// The components let clients explain a suggestion ("active community · 3 people you know")
+type RoomRecommendation struct {
+	*Room
+
+	// RecentMessages is the number of messages posted in the last 7 days
+	RecentMessages int `json:"recent_messages"`
+
+	// KnownMembers is how many of the room's members share another room with the user
+	KnownMembers int `json:"known_members"`
+
+	// Score is the combined ranking value; only its order is meaningful
+	Score float64 `json:"score"`
+}
+
+// Recommend returns rooms the user hasn't joined, best match first
+//
+// The score combines three signals:
+//   - activity: messages in the last 7 days
+//   - size: the current member count
+//   - overlap: members of the room who are co-members of the user elsewhere
+//
+// Activity and size are log-scaled so one huge room doesn't drown out the rest,
+// while every known member counts fully. A user without memberships has no
+// co-members, so their ranking falls back to activity and size alone
+// Invite-only rooms are never recommended since the user couldn't join them
+func (s *RoomStore) Recommend(ctx context.Context, userID int64, limit int) ([]*RoomRecommendation, error) {
+	query := `
+		WITH my_rooms AS (
+			SELECT room_id FROM room_members WHERE user_id = $1
+		),
+		co_members AS (
+			-- Everyone who shares at least one room with the user
+			SELECT DISTINCT rm.user_id
+			FROM room_members rm
+			INNER JOIN my_rooms mr ON mr.room_id = rm.room_id
+			WHERE rm.user_id <> $1
+		),
+		activity AS (
+			SELECT room_id, COUNT(*) AS recent_messages
+			FROM messages
+			WHERE created_at > NOW() - INTERVAL '7 days'
+			GROUP BY room_id
+		),
+		overlap AS (
+			SELECT rm.room_id, COUNT(*) AS known_members
+			FROM room_members rm
+			INNER JOIN co_members cm ON cm.user_id = rm.user_id
+			GROUP BY rm.room_id
+		),
+		candidates AS (
+			SELECT r.id,
+				COALESCE(a.recent_messages, 0) AS recent_messages,
+				COALESCE(o.known_members, 0) AS known_members,
+				r.member_count
+			FROM rooms r
+			LEFT JOIN activity a ON a.room_id = r.id
+			LEFT JOIN overlap o ON o.room_id = r.id
+			WHERE r.join_policy <> 'invite' AND r.deleted_at IS NULL
+				AND NOT EXISTS (SELECT 1 FROM my_rooms mr WHERE mr.room_id = r.id)
+		)
+		SELECT ` + roomColumns + `,
+			c.recent_messages, c.known_members,
+			LN(1 + c.recent_messages) + 0.5 * LN(1 + c.member_count) + 2 * c.known_members AS score
+		FROM candidates c
+		INNER JOIN rooms r ON r.id = c.id
+		ORDER BY score DESC, r.id DESC
+		LIMIT $2
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recommendations := make([]*RoomRecommendation, 0)
+	for rows.Next() {
+		rec := &RoomRecommendation{Room: &Room{}}
+		targets := append(rec.Room.scanTargets(), &rec.RecentMessages, &rec.KnownMembers, &rec.Score)
+		err := rows.Scan(targets...)
+		if err != nil {
+			return nil, err
+		}
+		s.fillComputed(rec.Room)
+		recommendations = append(recommendations, rec)
+	}
+
+	return recommendations, rows.Err()
+}
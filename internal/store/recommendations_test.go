@@ -0,0 +1,132 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestRecommendRanking seeds a small graph of users, rooms and messages on
+// the scratch database and checks the order rooms are suggested in, for a
+// user with co-members and for a brand-new one
+func TestRecommendRanking(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	limits := Limits{MaxRoomMembers: 100, MaxRoomsPerUser: 100}
+	rooms := &RoomStore{db, limits, NewPools(db, nil)}
+	members := &RoomMemberStore{db, limits}
+	suffix := time.Now().UnixNano()
+
+	user := func(name string) int64 {
+		t.Helper()
+		var id int64
+		query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+		if err := db.QueryRowContext(ctx, query, fmt.Sprintf("rec-%s-%d", name, suffix)).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Exec(`DELETE FROM users WHERE id = $1`, id) })
+		return id
+	}
+	room := func(name, policy string, memberIDs ...int64) int64 {
+		t.Helper()
+		r := &Room{Name: fmt.Sprintf("rec-%s-%d", name, suffix), CreatedBy: memberIDs[0], JoinPolicy: policy}
+		if err := rooms.Create(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Exec(`DELETE FROM rooms WHERE id = $1`, r.ID) })
+		for _, id := range memberIDs {
+			if err := members.Join(ctx, r.ID, id, id); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return r.ID
+	}
+	post := func(roomID, userID int64, count int, age time.Duration) {
+		t.Helper()
+		query := `
+			INSERT INTO messages (room_id, user_id, content, created_at)
+			SELECT $1, $2, 'hi', NOW() - $3::INTERVAL FROM generate_series(1, $4)
+		`
+		if _, err := db.ExecContext(ctx, query, roomID, userID, fmt.Sprintf("%d seconds", int(age.Seconds())), count); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ada, grace, linus, ken, newbie := user("ada"), user("grace"), user("linus"), user("ken"), user("newbie")
+
+	// ada shares home with grace and linus, who are both in friends
+	home := room("home", JoinPolicyOpen, ada, grace, linus)
+	friends := room("friends", JoinPolicyOpen, grace, linus)
+	busy := room("busy", JoinPolicyOpen, ken)
+	post(busy, ken, 30, time.Hour)
+	stale := room("stale", JoinPolicyOpen, ken)
+	post(stale, ken, 50, 10*24*time.Hour) // Too old to count as activity
+	secret := room("secret", JoinPolicyInvite, grace, linus, ken)
+	post(secret, ken, 40, time.Hour)
+
+	// ranked returns the seeded rooms recommended to userID, in order
+	seeded := map[int64]bool{home: true, friends: true, busy: true, stale: true, secret: true}
+	ranked := func(userID int64) []*RoomRecommendation {
+		t.Helper()
+		recommendations, err := rooms.Recommend(ctx, userID, 10000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []*RoomRecommendation
+		for _, rec := range recommendations {
+			if seeded[rec.ID] {
+				got = append(got, rec)
+			}
+		}
+		return got
+	}
+	ids := func(recs []*RoomRecommendation) []int64 {
+		var got []int64
+		for _, rec := range recs {
+			got = append(got, rec.ID)
+		}
+		return got
+	}
+
+	for _, tc := range []struct {
+		name   string
+		userID int64
+		want   []int64
+	}{
+		// Two known members outweigh 30 recent messages, and old messages
+		// don't count; ada's own room and the invite-only one never show
+		{"with co-members", ada, []int64{friends, busy, stale}},
+		// No memberships: pure activity and size, so busy leads and the
+		// three-member home beats two-member friends
+		{"brand-new", newbie, []int64{busy, home, friends, stale}},
+	} {
+		got := ranked(tc.userID)
+		if fmt.Sprint(ids(got)) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: got rooms %v, want %v", tc.name, ids(got), tc.want)
+			continue
+		}
+		for _, rec := range got {
+			if tc.userID == newbie && rec.KnownMembers != 0 {
+				t.Errorf("%s: room %d has %d known members", tc.name, rec.ID, rec.KnownMembers)
+			}
+		}
+	}
+
+	if t.Failed() {
+		return
+	}
+
+	// The components are what the ranking used
+	got := ranked(ada)
+	if got[0].KnownMembers != 2 || got[0].RecentMessages != 0 {
+		t.Errorf("friends has %d known members and %d recent messages, want 2 and 0", got[0].KnownMembers, got[0].RecentMessages)
+	}
+	if got[1].RecentMessages != 30 || got[2].RecentMessages != 0 {
+		t.Errorf("busy and stale have %d and %d recent messages, want 30 and 0", got[1].RecentMessages, got[2].RecentMessages)
+	}
+}
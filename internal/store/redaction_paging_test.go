@@ -0,0 +1,110 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestRedactWhilePaging pages through a room's messages in the admin view,
+// two at a time, redacting one already listed and one not yet listed between
+// pages, on the scratch database: every message is listed once and in
+// order, the unlisted one shows up redacted with its original, the history
+// shows the marker, and the room's search finds neither the original nor
+// the marker
+func TestRedactWhilePaging(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	limits := Limits{MaxRoomMembers: 100, MaxRoomsPerUser: 100}
+	rooms := &RoomStore{db, limits, NewPools(db, nil)}
+	messages := &MessageStore{db, NewPools(db, nil)}
+	redactions := &RedactionStore{db}
+	suffix := time.Now().UnixNano()
+
+	var ada int64
+	query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+	if err := db.QueryRowContext(ctx, query, fmt.Sprintf("redacted-ada-%d", suffix)).Scan(&ada); err != nil {
+		t.Fatal(err)
+	}
+	room := &Room{Name: fmt.Sprintf("redacted-%d", suffix), CreatedBy: ada}
+	if err := rooms.Create(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM rooms WHERE id = $1`, room.ID)
+		db.Exec(`DELETE FROM users WHERE id = $1`, ada)
+	})
+
+	var sent []int64
+	for i := range 5 {
+		message := &Message{RoomID: room.ID, UserID: ada, Content: fmt.Sprintf("offensive %d", i)}
+		if err := messages.Create(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, message.ID)
+	}
+
+	var listed []int64
+	var before int64
+	for page := 0; ; page++ {
+		found, err := redactions.ListMessages(ctx, AdminMessageQuery{RoomID: room.ID, Limit: 2, Before: before})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range found {
+			listed = append(listed, m.ID)
+			if m.ID == sent[0] && (!m.Redacted || m.Redaction == nil || m.Redaction.OriginalContent != "offensive 0") {
+				t.Errorf("message %d is listed as %+v with %+v, want it redacted with the original", m.ID, m.Message, m.Redaction)
+			}
+		}
+		if len(found) < 2 {
+			break
+		}
+		before = found[len(found)-1].ID
+
+		if page == 0 {
+			// One already listed, one on a later page
+			for _, id := range []int64{sent[4], sent[0]} {
+				if _, err := redactions.Redact(ctx, id, "ops", "abuse"); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	want := slices.Clone(sent)
+	slices.Reverse(want)
+	if !slices.Equal(listed, want) {
+		t.Errorf("listed %v, want every message once, newest first: %v", listed, want)
+	}
+
+	if _, err := redactions.Redact(ctx, sent[0], "ops", "again"); err != ErrAlreadyRedacted {
+		t.Errorf("redacting twice got %v, want ErrAlreadyRedacted", err)
+	}
+
+	history, err := messages.GetRoomMessages(ctx, room.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range history {
+		redacted := m.ID == sent[0] || m.ID == sent[4]
+		if redacted != m.Redacted || redacted != (m.Content == RedactedContent) {
+			t.Errorf("message %d reads %q (redacted %v) in the history", m.ID, m.Content, m.Redacted)
+		}
+	}
+
+	for _, q := range []string{"offensive 0", RedactedContent} {
+		found, err := messages.Search(ctx, room.ID, q, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 0 {
+			t.Errorf("searching the room for %q found %d messages, want none", q, len(found))
+		}
+	}
+}
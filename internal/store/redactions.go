@@ -0,0 +1,226 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// RedactedContent replaces the content of a message a platform admin removed
+// History, search results, pins, room previews and exports all show it
+const RedactedContent = "[removed by moderator]"
+
+// ErrAlreadyRedacted is returned when redacting a message that already was
+var ErrAlreadyRedacted = errors.New("message already redacted")
+
+// Redaction is a message a platform admin removed: the original, kept for
+// appeals, and who removed it and why. Only the admin API shows it
+type Redaction struct {
+	ID        int64 `json:"id"`
+	MessageID int64 `json:"message_id"`
+	RoomID    int64 `json:"room_id"`
+
+	// The message as it was before it was replaced by RedactedContent
+	OriginalContent     string `json:"original_content"`
+	OriginalContentType string `json:"original_content_type"`
+	OriginalLanguage    string `json:"original_language,omitempty"`
+
+	Actor     string    `json:"actor"` // Who redacted it, as given by the admin tooling
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// EventSeq is the redaction's sequence number in the room's event log
+	// Only Redact sets it
+	EventSeq int64 `json:"-"`
+}
+
+// AdminMessage is a message as the admin message view lists it: with its
+// room's name and, once redacted, the redaction holding the original
+type AdminMessage struct {
+	*Message
+	RoomName  string     `json:"room_name"`
+	Redaction *Redaction `json:"redaction,omitempty"`
+}
+
+// AdminMessageQuery selects a page of messages across every room
+// Zero values don't filter
+type AdminMessageQuery struct {
+	UserID int64     // Only messages by this user
+	RoomID int64     // Only messages in this room
+	Q      string    // Only messages whose content contains this, ignoring case
+	Since  time.Time // Only messages sent at or after this
+	Until  time.Time // Only messages sent before this
+	Limit  int       // Page size
+	Before int64     // Only messages with a smaller ID; 0 starts from the newest
+}
+
+// RedactionStore handles redacting messages and the admin view of messages
+type RedactionStore struct {
+	db *sql.DB
+}
+
+// Redact replaces a message's content with RedactedContent, keeps the
+// original in message_redactions with the actor and reason, and adds the
+// redaction to the room's event log, in one transaction
+// Cached translations of the original are dropped with it
+// Returns sql.ErrNoRows for an unknown message and ErrAlreadyRedacted
+func (s *RedactionStore) Redact(ctx context.Context, messageID int64, actor, reason string) (*Redaction, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	// The row lock keeps a concurrent redaction from copying the marker as the original
+	redaction := &Redaction{MessageID: messageID, Actor: actor, Reason: reason}
+	var redacted bool
+	messageQuery := `
+		SELECT room_id, content, content_type, COALESCE(language, ''), redacted_at IS NOT NULL
+		FROM messages
+		WHERE id = $1
+		FOR UPDATE
+	`
+	err = tx.QueryRowContext(ctx, messageQuery, messageID).Scan(&redaction.RoomID,
+		&redaction.OriginalContent, &redaction.OriginalContentType, &redaction.OriginalLanguage, &redacted)
+	if err != nil {
+		return nil, err
+	}
+	if redacted {
+		return nil, ErrAlreadyRedacted
+	}
+
+	insertQuery := `
+		INSERT INTO message_redactions (message_id, original_content, original_content_type, original_language, actor, reason)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6)
+		RETURNING id, created_at
+	`
+	err = tx.QueryRowContext(ctx, insertQuery, messageID, redaction.OriginalContent, redaction.OriginalContentType,
+		redaction.OriginalLanguage, actor, reason).Scan(&redaction.ID, &redaction.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	updateQuery := `
+		UPDATE messages
+		SET content = $2, content_type = 'text', language = NULL, redacted_at = $3
+		WHERE id = $1
+	`
+	if _, err := tx.ExecContext(ctx, updateQuery, messageID, RedactedContent, redaction.CreatedAt); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM message_translations WHERE message_id = $1`, messageID); err != nil {
+		return nil, err
+	}
+
+	// The payload leaves the original out: the event log is read by room members
+	redaction.EventSeq, err = appendRoomEvent(ctx, tx, redaction.RoomID, RoomEventMessageRedacted,
+		map[string]interface{}{"message_id": messageID})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return redaction, nil
+}
+
+// adminMessagesQuery selects a page of messages across every room, newest
+// first, with their room's name and any redaction. $3 is escaped with
+// escapeLike and matched against the current content, which the trigram
+// index serves; a redacted message matches on RedactedContent, not its original
+// Keyset pagination on the ID keeps pages stable while messages are added
+// or redacted: a redaction changes a row's content, never its ID
+const adminMessagesQuery = `
+	SELECT m.id, m.room_id, COALESCE(m.user_id, 0), m.content, COALESCE(u.username, m.author_username), m.created_at,
+		m.content_type, COALESCE(m.language, ''), m.filtered, m.truncated, m.quiet_override, m.moderated,
+		COALESCE(m.override_username, ''), COALESCE(m.override_avatar_url, ''), m.system_event,
+		m.redacted_at IS NOT NULL, COALESCE(m.room_mention, ''),
+		r.name, red.id, COALESCE(red.original_content, ''), COALESCE(red.original_content_type, ''),
+		COALESCE(red.original_language, ''), COALESCE(red.actor, ''), COALESCE(red.reason, ''), red.created_at
+	FROM messages m
+	INNER JOIN rooms r ON r.id = m.room_id
+	LEFT JOIN users u ON m.user_id = u.id
+	LEFT JOIN message_redactions red ON red.message_id = m.id
+	WHERE ($1::BIGINT = 0 OR m.user_id = $1)
+	  AND ($2::BIGINT = 0 OR m.room_id = $2)
+	  AND ($3 = '' OR m.content ILIKE '%' || $3 || '%')
+	  AND ($4::TIMESTAMP IS NULL OR m.created_at >= $4)
+	  AND ($5::TIMESTAMP IS NULL OR m.created_at < $5)
+	  AND ($6::BIGINT = 0 OR m.id < $6)
+	ORDER BY m.id DESC
+	LIMIT $7
+`
+
+// ListMessages returns a page of messages across every room for platform
+// admins, newest first, redacted ones with their original
+// Rooms' membership and permissions don't apply: only the admin API may call it
+func (s *RedactionStore) ListMessages(ctx context.Context, q AdminMessageQuery) ([]*AdminMessage, error) {
+	limit, _ := clampPage(q.Limit, 0, 50, 200)
+	term := searchTerm(q.Q)
+	since := sql.NullTime{Time: q.Since, Valid: !q.Since.IsZero()}
+	until := sql.NullTime{Time: q.Until, Valid: !q.Until.IsZero()}
+
+	rows, err := s.db.QueryContext(ctx, adminMessagesQuery, q.UserID, q.RoomID, escapeLike(term), since, until, q.Before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]*AdminMessage, 0, limit)
+	for rows.Next() {
+		var (
+			m           AdminMessage
+			redactionID sql.NullInt64
+			redactedAt  sql.NullTime
+			redaction   Redaction
+		)
+		m.Message = &Message{}
+		err := rows.Scan(
+			&m.ID,
+			&m.RoomID,
+			&m.UserID,
+			&m.Content,
+			&m.Username,
+			&m.CreatedAt,
+			&m.ContentType,
+			&m.Language,
+			&m.Filtered,
+			&m.Truncated,
+			&m.Override,
+			&m.Moderated,
+			&m.OverrideUsername,
+			&m.OverrideAvatarURL,
+			&m.SystemEvent,
+			&m.Redacted,
+			&m.RoomMention,
+			&m.RoomName,
+			&redactionID,
+			&redaction.OriginalContent,
+			&redaction.OriginalContentType,
+			&redaction.OriginalLanguage,
+			&redaction.Actor,
+			&redaction.Reason,
+			&redactedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		setDerivedFields(m.Message)
+		if redactionID.Valid {
+			redaction.ID = redactionID.Int64
+			redaction.MessageID = m.ID
+			redaction.RoomID = m.RoomID
+			redaction.CreatedAt = redactedAt.Time
+			m.Redaction = &redaction
+		}
+		messages = append(messages, &m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestRedact copies a code message into message_redactions, replaces it with
+// the marker as plain text and logs the redaction, in one transaction
+func TestRedact(t *testing.T) {
+	db, mock := newMockDB(t)
+	redactions := &RedactionStore{db}
+	at := time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT room_id, content, content_type, COALESCE\(language, ''\), redacted_at IS NOT NULL\s+FROM messages\s+WHERE id = \$1\s+FOR UPDATE`).
+		WithArgs(int64(9)).
+		WillReturnRows(sqlmock.NewRows([]string{"room_id", "content", "content_type", "language", "redacted"}).AddRow(1, "rm -rf /", "code", "sh", false))
+	mock.ExpectQuery(`INSERT INTO message_redactions`).
+		WithArgs(int64(9), "rm -rf /", "code", "sh", "ops@example.com", "harmful advice").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(3, at))
+	mock.ExpectExec(`UPDATE messages\s+SET content = \$2, content_type = 'text', language = NULL, redacted_at = \$3`).
+		WithArgs(int64(9), RedactedContent, at).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM message_translations WHERE message_id = \$1`).WithArgs(int64(9)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	expectRoomEvent(mock, 1, RoomEventMessageRedacted, 40)
+	mock.ExpectCommit()
+
+	redaction, err := redactions.Redact(context.Background(), 9, "ops@example.com", "harmful advice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redaction.ID != 3 || redaction.RoomID != 1 || redaction.OriginalContent != "rm -rf /" || redaction.OriginalLanguage != "sh" || redaction.EventSeq != 40 {
+		t.Errorf("got %+v, want redaction 3 of room 1 keeping the original, logged as event 40", redaction)
+	}
+}
+
+// TestRedactRefused redacts an unknown message and one already redacted;
+// neither writes anything
+func TestRedactRefused(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		rows *sqlmock.Rows
+		want error
+	}{
+		{"unknown message", sqlmock.NewRows([]string{"room_id"}), sql.ErrNoRows},
+		{"already redacted", sqlmock.NewRows([]string{"room_id", "content", "content_type", "language", "redacted"}).
+			AddRow(1, RedactedContent, "text", "", true), ErrAlreadyRedacted},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			redactions := &RedactionStore{db}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`FROM messages\s+WHERE id = \$1\s+FOR UPDATE`).WithArgs(int64(9)).WillReturnRows(tc.rows)
+			mock.ExpectRollback()
+
+			if _, err := redactions.Redact(context.Background(), 9, "ops", ""); !errors.Is(err, tc.want) {
+				t.Errorf("got %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+// TestListAdminMessages passes the filters through, escaping the search term
+// and leaving unset times NULL, and attaches the redaction to redacted messages only
+func TestListAdminMessages(t *testing.T) {
+	db, mock := newMockDB(t)
+	redactions := &RedactionStore{db}
+	at := time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+	since := at.Add(-time.Hour)
+
+	columns := append(append([]string{}, messageRowColumns...), "room_name", "redaction_id", "original_content",
+		"original_content_type", "original_language", "actor", "reason", "redacted_at")
+	mock.ExpectQuery(`LEFT JOIN message_redactions red ON red.message_id = m.id`).
+		WithArgs(int64(2), int64(0), `100\%`, sql.NullTime{Time: since, Valid: true}, sql.NullTime{}, int64(50), 200).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(12, 1, 2, RedactedContent, "grace", at, "text", "", false, false, false, false, "", "", nil, true, "",
+				"general", 3, "100% scam", "text", "", "ops", "spam", at).
+			AddRow(11, 4, 2, "100% sure", "grace", at, "text", "", false, false, false, false, "", "", nil, false, "",
+				"random", nil, "", "", "", "", "", nil))
+
+	messages, err := redactions.ListMessages(context.Background(), AdminMessageQuery{UserID: 2, Q: "100%", Since: since, Before: 50, Limit: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages, want 2", len(messages))
+	}
+	if r := messages[0].Redaction; !messages[0].Redacted || r == nil || r.OriginalContent != "100% scam" || r.MessageID != 12 || r.Actor != "ops" {
+		t.Errorf("the redacted message is %+v with redaction %+v, want the original kept", messages[0].Message, r)
+	}
+	if messages[1].Redaction != nil || messages[1].RoomName != "random" {
+		t.Errorf("the plain message is %+v, want room random and no redaction", messages[1])
+	}
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Read replica routing
+//
+// Heavy reads (older history, search, tag listings, stats, exports) can run on a
+// read replica so they don't compete with message inserts for the primary's
+// connections. Which methods may do so is decided in one place, readAffinity
+// below; everything not listed there runs on the primary. A replica lags the
+// primary by up to a few seconds, so a method belongs on it only if it never
+// needs to see a write made just before it
+
+// Pool names a connection pool a store method runs on
+type Pool string
+
+const (
+	PoolPrimary Pool = "primary"
+	PoolReplica Pool = "replica"
+)
+
+// readAffinity is where each read method runs when a replica is configured
+// Methods not listed run on the primary. The primary entries are reads that
+// would be tempting to move but must see the latest writes; they're listed so
+// the reason is on record
+var readAffinity = map[string]Pool{
+	// History and search: pages of old messages, fine a few seconds behind
+	"MessageStore.GetMessagesBefore": PoolReplica,
+	"MessageStore.Histogram":         PoolReplica,
+	"UserStore.Search":               PoolReplica,
+	"MessageStore.Search":            PoolReplica,
+	"MessageStore.CountInRoom":       PoolReplica, // The impact summary before a room is deleted
+
+	// Listings and stats
+	"RoomStore.ListTags":    PoolReplica,
+	"AttachmentStore.Stats": PoolReplica,
+
+	// Data export: long scans of one user's history
+	"ExportStore.CountUserMessages":   PoolReplica,
+	"ExportStore.GetUserMemberships":  PoolReplica,
+	"ExportStore.GetUserJoinRequests": PoolReplica,
+	"ExportStore.GetUserDevices":      PoolReplica,
+	"ExportStore.GetUserPosts":        PoolReplica,
+	"ExportStore.StreamUserMessages":  PoolReplica,
+
+	// Must see the latest writes
+	"RoomMemberStore.IsUserInRoom":   PoolPrimary, // Checked right before a WebSocket connect, after a join
+	"ReadMarkerStore.GetUnreadCount": PoolPrimary, // Read right after the marker moves
+	"MessageStore.GetMessagesSince":  PoolPrimary, // Reconnect catch-up; must not miss the newest messages
+	"MessageStore.GetMessagesAround": PoolPrimary, // Links to messages that were just sent
+	"MessageStore.GetRoomMessages":   PoolPrimary, // The newest page, loaded right after sending or joining
+	"RoomStore.List":                 PoolPrimary, // Must show a room to its creator right after it's created
+	"ExportStore.GetJob":             PoolPrimary, // Polled right after the job is created
+}
+
+// replicaRetryInterval is how long reads stay on the primary after the replica fails
+const replicaRetryInterval = 10 * time.Second
+
+// Pools holds the primary connection pool and the optional read replica
+// Safe for concurrent use
+type Pools struct {
+	primary *sql.DB
+	replica *sql.DB // nil when no replica is configured
+
+	// downUntil is when to try the replica again after it failed (Unix nanoseconds)
+	downUntil atomic.Int64
+	fallbacks atomic.Int64 // Replica reads retried on the primary
+
+	deadlines deadlineCounts // Store operations cut off by their timeout (see Timeouts)
+}
+
+// NewPools creates the pools the stores run on; replica may be nil
+func NewPools(primary, replica *sql.DB) *Pools {
+	return &Pools{primary: primary, replica: replica}
+}
+
+// Primary returns the primary pool, which every write uses
+func (p *Pools) Primary() *sql.DB {
+	return p.primary
+}
+
+// reader returns the pool a method reads from right now
+// The primary is used when no replica is configured, when the method isn't
+// marked for the replica, or while the replica is considered down
+func (p *Pools) reader(method string) (*sql.DB, Pool) {
+	if p.replica == nil || readAffinity[method] != PoolReplica {
+		return p.primary, PoolPrimary
+	}
+	if time.Now().UnixNano() < p.downUntil.Load() {
+		return p.primary, PoolPrimary
+	}
+	return p.replica, PoolReplica
+}
+
+// replicaFailed takes the replica out of rotation for replicaRetryInterval
+// Returns false if the error wasn't the replica's fault (the caller gave up)
+func (p *Pools) replicaFailed(ctx context.Context, method string, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	p.fallbacks.Add(1)
+	p.downUntil.Store(time.Now().Add(replicaRetryInterval).UnixNano())
+	log.Printf("Read replica failed for %s, using the primary for %s: %v", method, replicaRetryInterval, err)
+	return true
+}
+
+// query runs a read for method on its pool, retrying on the primary if the
+// replica fails to answer
+// Errors while iterating the rows aren't retried; by then results were read
+func (p *Pools) query(ctx context.Context, method, query string, args ...interface{}) (*sql.Rows, error) {
+	db, pool := p.reader(method)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil && pool == PoolReplica && p.replicaFailed(ctx, method, err) {
+		return p.primary.QueryContext(ctx, query, args...)
+	}
+	return rows, err
+}
+
+// queryRow runs a single-row read for method and scans it into dest, with the
+// same fallback as query
+// Returns sql.ErrNoRows as Scan does; that's an answer, not a failure
+func (p *Pools) queryRow(ctx context.Context, method, query string, args []interface{}, dest ...interface{}) error {
+	db, pool := p.reader(method)
+	err := db.QueryRowContext(ctx, query, args...).Scan(dest...)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) && pool == PoolReplica && p.replicaFailed(ctx, method, err) {
+		return p.primary.QueryRowContext(ctx, query, args...).Scan(dest...)
+	}
+	return err
+}
+
+// PoolStat is one connection pool's usage
+type PoolStat struct {
+	Open      int   `json:"open"`
+	InUse     int   `json:"in_use"`
+	Idle      int   `json:"idle"`
+	WaitCount int64 `json:"wait_count"` // Times a query waited for a free connection
+	WaitMs    int64 `json:"wait_ms"`    // Total time spent waiting
+}
+
+// PoolStats reports both pools for the readiness endpoint
+type PoolStats struct {
+	Primary PoolStat  `json:"primary"`
+	Replica *PoolStat `json:"replica,omitempty"` // Only with a replica configured
+
+	// ReplicaDown is set while reads stay on the primary after a replica failure
+	ReplicaDown      bool  `json:"replica_down,omitempty"`
+	ReplicaFallbacks int64 `json:"replica_fallbacks,omitempty"`
+
+	// DeadlineExceeded counts the store operations cut off by their timeout, per method
+	DeadlineExceeded map[string]int64 `json:"deadline_exceeded,omitempty"`
+}
+
+func poolStat(db *sql.DB) PoolStat {
+	s := db.Stats()
+	return PoolStat{
+		Open:      s.OpenConnections,
+		InUse:     s.InUse,
+		Idle:      s.Idle,
+		WaitCount: s.WaitCount,
+		WaitMs:    s.WaitDuration.Milliseconds(),
+	}
+}
+
+// Stats returns the pools' current usage
+func (p *Pools) Stats() *PoolStats {
+	stats := &PoolStats{Primary: poolStat(p.primary), DeadlineExceeded: p.deadlines.snapshot()}
+	if p.replica != nil {
+		replica := poolStat(p.replica)
+		stats.Replica = &replica
+		stats.ReplicaDown = time.Now().UnixNano() < p.downUntil.Load()
+		stats.ReplicaFallbacks = p.fallbacks.Load()
+	}
+	return stats
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestReadAffinityMethods keeps readAffinity honest: every entry names a
+// method that exists, so a rename can't silently move a read back to the
+// primary
+func TestReadAffinityMethods(t *testing.T) {
+	stores := map[string]reflect.Type{}
+	for _, s := range []interface{}{
+		&MessageStore{}, &UserStore{}, &RoomStore{}, &AttachmentStore{},
+		&ExportStore{}, &RoomMemberStore{}, &ReadMarkerStore{},
+	} {
+		stores[reflect.TypeOf(s).Elem().Name()] = reflect.TypeOf(s)
+	}
+	for method := range readAffinity {
+		typeName, methodName, _ := strings.Cut(method, ".")
+		typ, ok := stores[typeName]
+		if !ok {
+			t.Errorf("%s: unknown store %s", method, typeName)
+			continue
+		}
+		if _, ok := typ.MethodByName(methodName); !ok {
+			t.Errorf("%s: %s has no method %s", method, typeName, methodName)
+		}
+	}
+}
+
+// TestReadRouting runs reads against two mock pools and checks each lands
+// where readAffinity says: the newest history page and the room list on the
+// primary, older pages, the tag listing, search and stats on the replica
+func TestReadRouting(t *testing.T) {
+	for _, tc := range []struct {
+		method string
+		pool   Pool
+		rows   *sqlmock.Rows
+		read   func(ctx context.Context, db *sql.DB, pools *Pools) error
+	}{
+		{"MessageStore.GetRoomMessages", PoolPrimary, sqlmock.NewRows([]string{"id"}), func(ctx context.Context, db *sql.DB, pools *Pools) error {
+			_, err := (&MessageStore{db, pools}).GetRoomMessages(ctx, 1, 50)
+			return err
+		}},
+		{"RoomStore.List", PoolPrimary, sqlmock.NewRows([]string{"id"}), func(ctx context.Context, db *sql.DB, pools *Pools) error {
+			_, err := (&RoomStore{db, Limits{}, pools}).List(ctx, RoomListOptions{})
+			return err
+		}},
+		{"MessageStore.GetMessagesBefore", PoolReplica, sqlmock.NewRows([]string{"id"}), func(ctx context.Context, db *sql.DB, pools *Pools) error {
+			_, err := (&MessageStore{db, pools}).GetMessagesBefore(ctx, 1, 100, 50)
+			return err
+		}},
+		{"RoomStore.ListTags", PoolReplica, sqlmock.NewRows([]string{"id"}), func(ctx context.Context, db *sql.DB, pools *Pools) error {
+			_, err := (&RoomStore{db, Limits{}, pools}).ListTags(ctx)
+			return err
+		}},
+		{"UserStore.Search", PoolReplica, sqlmock.NewRows([]string{"id"}), func(ctx context.Context, db *sql.DB, pools *Pools) error {
+			_, err := (&UserStore{db, Limits{}, pools}).Search(ctx, "ada", 10)
+			return err
+		}},
+		{"AttachmentStore.Stats", PoolReplica, sqlmock.NewRows([]string{"count", "count", "sum", "sum"}).AddRow(0, 0, 0, 0), func(ctx context.Context, db *sql.DB, pools *Pools) error {
+			_, err := (&AttachmentStore{db, pools}).Stats(ctx)
+			return err
+		}},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			primary, primaryMock := newMockDB(t)
+			replica, replicaMock := newMockDB(t)
+			want := primaryMock
+			if tc.pool == PoolReplica {
+				want = replicaMock
+			}
+			// Neither pool expects anything else, so a read on the wrong one fails
+			want.ExpectQuery(`SELECT`).WillReturnRows(tc.rows)
+
+			// A read sent to the replica by mistake would be retried on the primary
+			pools := NewPools(primary, replica)
+			if err := tc.read(context.Background(), primary, pools); err != nil || pools.Stats().ReplicaFallbacks != 0 {
+				t.Errorf("got %v after %d fallbacks, want the read answered by the %s", err, pools.Stats().ReplicaFallbacks, tc.pool)
+			}
+		})
+	}
+}
+
+// TestReplicaFallback fails a replica read: it's retried on the primary,
+// the replica is skipped for the reads after it, and the readiness stats
+// say so. A read the caller gave up on, or one that found no rows, isn't
+// the replica's fault and isn't retried
+func TestReplicaFallback(t *testing.T) {
+	primary, primaryMock := newMockDB(t)
+	replica, replicaMock := newMockDB(t)
+	pools := NewPools(primary, replica)
+	attachments := &AttachmentStore{primary, pools}
+	statsRow := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"count", "count", "sum", "sum"}).AddRow(3, 5, 500, 300)
+	}
+	ctx := context.Background()
+
+	replicaMock.ExpectQuery(`FROM attachments`).WillReturnRows(sqlmock.NewRows([]string{"count", "count", "sum", "sum"}))
+	if _, err := attachments.Stats(ctx); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("no rows on the replica got %v, want sql.ErrNoRows", err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := attachments.Stats(cancelled); !errors.Is(err, context.Canceled) {
+		t.Errorf("a cancelled read got %v, want context.Canceled", err)
+	}
+	if stats := pools.Stats(); stats.ReplicaDown || stats.ReplicaFallbacks != 0 {
+		t.Fatalf("after no rows and a cancelled read got %+v, want the replica up", stats)
+	}
+
+	replicaMock.ExpectQuery(`FROM attachments`).WillReturnError(errors.New("replica is restarting"))
+	primaryMock.ExpectQuery(`FROM attachments`).WillReturnRows(statsRow())
+	stats, err := attachments.Stats(ctx)
+	if err != nil || stats.Uploads != 5 {
+		t.Fatalf("the retried read got %+v, %v", stats, err)
+	}
+
+	// The replica isn't asked again while it's down
+	primaryMock.ExpectQuery(`FROM attachments`).WillReturnRows(statsRow())
+	if _, err := attachments.Stats(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if stats := pools.Stats(); !stats.ReplicaDown || stats.ReplicaFallbacks != 1 || stats.Replica == nil {
+		t.Errorf("after the failure got %+v, want the replica down with one fallback", stats)
+	}
+}
+
+// TestNoReplica reads everything from the primary when no replica is
+// configured, and leaves the replica out of the stats
+func TestNoReplica(t *testing.T) {
+	primary, mock := newMockDB(t)
+	pools := NewPools(primary, nil)
+	mock.ExpectQuery(`FROM attachments`).WillReturnRows(sqlmock.NewRows([]string{"count", "count", "sum", "sum"}).AddRow(0, 0, 0, 0))
+
+	if _, err := (&AttachmentStore{primary, pools}).Stats(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if stats := pools.Stats(); stats.Replica != nil || stats.ReplicaDown {
+		t.Errorf("got %+v, want the primary alone", stats)
+	}
+}
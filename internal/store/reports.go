@@ -0,0 +1,272 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Report target types
+const (
+	ReportTargetMessage = "message"
+	ReportTargetUser    = "user"
+)
+
+// Report reason categories
+const (
+	ReportReasonSpam       = "spam"
+	ReportReasonHarassment = "harassment"
+	ReportReasonOther      = "other"
+)
+
+// Report statuses
+// Open and reviewing reports are still open: the reporter can't file another
+// one about the same target until it's resolved or dismissed
+const (
+	ReportStatusOpen      = "open"
+	ReportStatusReviewing = "reviewing"
+	ReportStatusResolved  = "resolved"
+	ReportStatusDismissed = "dismissed"
+)
+
+// reportTransitions lists the statuses each status may change to
+// Closed reports can be reopened
+var reportTransitions = map[string][]string{
+	ReportStatusOpen:      {ReportStatusReviewing, ReportStatusResolved, ReportStatusDismissed},
+	ReportStatusReviewing: {ReportStatusOpen, ReportStatusResolved, ReportStatusDismissed},
+	ReportStatusResolved:  {ReportStatusOpen},
+	ReportStatusDismissed: {ReportStatusOpen},
+}
+
+// ErrDuplicateReport is returned when the reporter already has an open report
+// about the same target
+var ErrDuplicateReport = errors.New("report already open for this target")
+
+// ErrInvalidReportTransition is returned by UpdateStatus for a status change
+// reportTransitions doesn't allow
+var ErrInvalidReportTransition = errors.New("invalid report status change")
+
+// ValidReportReason reports whether reason is one of the ReportReason constants
+func ValidReportReason(reason string) bool {
+	return reason == ReportReasonSpam || reason == ReportReasonHarassment || reason == ReportReasonOther
+}
+
+// ValidReportStatus reports whether status is one of the ReportStatus constants
+func ValidReportStatus(status string) bool {
+	_, ok := reportTransitions[status]
+	return ok
+}
+
+// Report is an abuse report about a message or a user
+type Report struct {
+	ID         int64  `json:"id"`
+	ReporterID int64  `json:"reporter_id"`
+	TargetType string `json:"target_type"` // One of the ReportTarget constants
+
+	// TargetUserID is the reported user, or the author of the reported message
+	// nil once that user's account is deleted
+	TargetUserID *int64 `json:"target_user_id"`
+
+	// MessageID is the reported message; nil for user reports and once the message is deleted
+	MessageID *int64 `json:"message_id,omitempty"`
+
+	// RoomID is the room the report concerns: the message's room, or the room
+	// a user report was filed from (nil if none was given)
+	RoomID *int64 `json:"room_id"`
+
+	// MessageContent is the reported message as it was when reported
+	MessageContent *string `json:"message_content,omitempty"`
+
+	Reason    string    `json:"reason"` // One of the ReportReason constants
+	Details   string    `json:"details"`
+	Status    string    `json:"status"` // One of the ReportStatus constants
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// History is the report's status changes, oldest first
+	// Only UpdateStatus fills it in
+	History []*ReportEvent `json:"history,omitempty"`
+}
+
+// ReportEvent is one status change in a report's audit trail
+type ReportEvent struct {
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Actor      string    `json:"actor"` // Who made the change, as given by the admin tooling
+	Note       string    `json:"note"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ReportStore handles database operations for abuse reports
+type ReportStore struct {
+	db *sql.DB
+}
+
+// reportColumns lists the columns selected for every Report query
+const reportColumns = `id, reporter_id, target_type, target_user_id, message_id, room_id, message_content,
+	reason, details, status, created_at, updated_at`
+
+// scanReport scans a row selected with reportColumns
+func scanReport(row rowScanner) (*Report, error) {
+	report := &Report{}
+	err := row.Scan(&report.ID, &report.ReporterID, &report.TargetType, &report.TargetUserID,
+		&report.MessageID, &report.RoomID, &report.MessageContent,
+		&report.Reason, &report.Details, &report.Status, &report.CreatedAt, &report.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// scanReports collects every row of a report query
+func scanReports(rows *sql.Rows) ([]*Report, error) {
+	defer rows.Close()
+
+	reports := make([]*Report, 0)
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+// Create files a report and fills in its ID, status and timestamps
+// The caller snapshots the message content into MessageContent
+// Returns ErrDuplicateReport if the reporter already has an open report about the target
+func (s *ReportStore) Create(ctx context.Context, report *Report) error {
+	query := `
+		INSERT INTO reports (reporter_id, target_type, target_user_id, message_id, room_id, message_content, reason, details)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, status, created_at, updated_at
+	`
+	err := s.db.QueryRowContext(ctx, query, report.ReporterID, report.TargetType, report.TargetUserID,
+		report.MessageID, report.RoomID, report.MessageContent, report.Reason, report.Details,
+	).Scan(&report.ID, &report.Status, &report.CreatedAt, &report.UpdatedAt)
+	if IsUniqueViolation(err) {
+		return ErrDuplicateReport
+	}
+	return err
+}
+
+// ListForRoom returns a page of the reports concerning a room, newest first
+func (s *ReportStore) ListForRoom(ctx context.Context, roomID int64, limit, offset int) ([]*Report, error) {
+	limit, offset = clampPage(limit, offset, 20, 100)
+	query := `
+		SELECT ` + reportColumns + `
+		FROM reports
+		WHERE room_id = $1
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := s.db.QueryContext(ctx, query, roomID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanReports(rows)
+}
+
+// List returns a page of all reports, newest first
+// An empty status lists reports in every status
+func (s *ReportStore) List(ctx context.Context, status string, limit, offset int) ([]*Report, error) {
+	limit, offset = clampPage(limit, offset, 20, 100)
+	query := `
+		SELECT ` + reportColumns + `
+		FROM reports
+		WHERE ($1 = '' OR status = $1)
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := s.db.QueryContext(ctx, query, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanReports(rows)
+}
+
+// UpdateStatus moves a report to a new status and records the change in its
+// audit trail, in one transaction
+// Returns the updated report with its full history, sql.ErrNoRows for an
+// unknown report, ErrInvalidReportTransition for a change reportTransitions
+// doesn't allow, and ErrDuplicateReport when reopening would give the
+// reporter two open reports about the same target
+func (s *ReportStore) UpdateStatus(ctx context.Context, id int64, status, actor, note string) (*Report, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	// The row lock keeps concurrent changes from recording the wrong from_status
+	var current string
+	if err := tx.QueryRowContext(ctx, `SELECT status FROM reports WHERE id = $1 FOR UPDATE`, id).Scan(&current); err != nil {
+		return nil, err
+	}
+	allowed := false
+	for _, next := range reportTransitions[current] {
+		if next == status {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, ErrInvalidReportTransition
+	}
+
+	updateQuery := `
+		UPDATE reports SET status = $2, updated_at = NOW()
+		WHERE id = $1
+		RETURNING ` + reportColumns
+	report, err := scanReport(tx.QueryRowContext(ctx, updateQuery, id, status))
+	if err != nil {
+		if IsUniqueViolation(err) {
+			return nil, ErrDuplicateReport
+		}
+		return nil, err
+	}
+
+	eventQuery := `
+		INSERT INTO report_events (report_id, from_status, to_status, actor, note)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	if _, err := tx.ExecContext(ctx, eventQuery, id, current, status, actor, note); err != nil {
+		return nil, err
+	}
+
+	historyQuery := `
+		SELECT from_status, to_status, actor, note, created_at
+		FROM report_events
+		WHERE report_id = $1
+		ORDER BY id
+	`
+	rows, err := tx.QueryContext(ctx, historyQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report.History = make([]*ReportEvent, 0)
+	for rows.Next() {
+		event := &ReportEvent{}
+		if err := rows.Scan(&event.FromStatus, &event.ToStatus, &event.Actor, &event.Note, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		report.History = append(report.History, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,102 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestOpenReportIndexes files reports on the scratch database: a reporter
+// can't have two open reports about the same message or user, but can
+// report it again once the first is closed, and a message report keeps
+// the content after the message is edited and deleted
+func TestOpenReportIndexes(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	reports := &ReportStore{db}
+	messages := &MessageStore{db, NewPools(db, nil)}
+	suffix := time.Now().UnixNano()
+
+	var reporter, author int64
+	for _, user := range []struct {
+		name string
+		id   *int64
+	}{{fmt.Sprintf("reporter-%d", suffix), &reporter}, {fmt.Sprintf("author-%d", suffix), &author}} {
+		query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+		if err := db.QueryRowContext(ctx, query, user.name).Scan(user.id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	room := &Room{Name: fmt.Sprintf("reports-%d", suffix), CreatedBy: author}
+	if err := (&RoomStore{db, Limits{}, NewPools(db, nil)}).Create(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM rooms WHERE id = $1`, room.ID)
+		db.Exec(`DELETE FROM users WHERE id = ANY(ARRAY[$1, $2]::bigint[])`, reporter, author)
+	})
+	message := &Message{RoomID: room.ID, UserID: author, Content: "cheap watches"}
+	if err := messages.Create(ctx, message); err != nil {
+		t.Fatal(err)
+	}
+
+	messageReport := func() *Report {
+		return &Report{ReporterID: reporter, TargetType: ReportTargetMessage, TargetUserID: &author,
+			MessageID: &message.ID, RoomID: &room.ID, MessageContent: &message.Content, Reason: ReportReasonSpam}
+	}
+	userReport := func() *Report {
+		return &Report{ReporterID: reporter, TargetType: ReportTargetUser, TargetUserID: &author, Reason: ReportReasonHarassment}
+	}
+
+	first := messageReport()
+	if err := reports.Create(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	// A user report about the message's author is a different target
+	if err := reports.Create(ctx, userReport()); err != nil {
+		t.Fatalf("reporting the author beside their message: %v", err)
+	}
+	for name, report := range map[string]*Report{"message": messageReport(), "user": userReport()} {
+		if err := reports.Create(ctx, report); !errors.Is(err, ErrDuplicateReport) {
+			t.Errorf("a second open %s report got %v, want ErrDuplicateReport", name, err)
+		}
+	}
+	if _, err := reports.UpdateStatus(ctx, first.ID, ReportStatusReviewing, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := reports.Create(ctx, messageReport()); !errors.Is(err, ErrDuplicateReport) {
+		t.Errorf("reporting beside a report in review got %v, want ErrDuplicateReport", err)
+	}
+	if _, err := reports.UpdateStatus(ctx, first.ID, ReportStatusDismissed, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := reports.Create(ctx, messageReport()); err != nil {
+		t.Errorf("reporting again after dismissal: %v", err)
+	}
+
+	if _, err := db.ExecContext(ctx, `UPDATE messages SET content = 'sorry' WHERE id = $1`, message.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, `DELETE FROM messages WHERE id = $1`, message.ID); err != nil {
+		t.Fatal(err)
+	}
+	listed, err := reports.ListForRoom(ctx, room.ID, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 2 {
+		t.Fatalf("the room has %d reports, want 2", len(listed))
+	}
+	for _, report := range listed {
+		if report.MessageID != nil || report.MessageContent == nil || *report.MessageContent != "cheap watches" {
+			t.Errorf("after deleting the message report %d holds message %v and %v, want no message and the content as reported",
+				report.ID, report.MessageID, report.MessageContent)
+		}
+	}
+}
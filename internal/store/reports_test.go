@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// reportRow is a row of reportColumns for report 7, about message 3 by user 2
+func reportRow(status string) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows([]string{"id", "reporter_id", "target_type", "target_user_id", "message_id", "room_id", "message_content",
+		"reason", "details", "status", "created_at", "updated_at"}).
+		AddRow(7, 1, ReportTargetMessage, 2, 3, 4, "spam spam spam", ReportReasonSpam, "", status, now, now)
+}
+
+// TestCreateReportDuplicate maps a hit on the open-report indexes to
+// ErrDuplicateReport
+func TestCreateReportDuplicate(t *testing.T) {
+	db, mock := newMockDB(t)
+	reports := &ReportStore{db}
+
+	mock.ExpectQuery(`INSERT INTO reports`).WillReturnError(&pq.Error{Code: "23505"})
+	user := int64(2)
+	err := reports.Create(context.Background(), &Report{ReporterID: 1, TargetType: ReportTargetUser, TargetUserID: &user, Reason: ReportReasonOther})
+	if !errors.Is(err, ErrDuplicateReport) {
+		t.Errorf("got %v, want ErrDuplicateReport", err)
+	}
+}
+
+// TestUpdateReportStatus resolves an open report: the change and its audit
+// row commit together and the report comes back with its whole history
+func TestUpdateReportStatus(t *testing.T) {
+	db, mock := newMockDB(t)
+	reports := &ReportStore{db}
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT status FROM reports WHERE id = \$1 FOR UPDATE`).WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(ReportStatusReviewing))
+	mock.ExpectQuery(`UPDATE reports SET status = \$2`).WithArgs(int64(7), ReportStatusResolved).
+		WillReturnRows(reportRow(ReportStatusResolved))
+	mock.ExpectExec(`INSERT INTO report_events`).
+		WithArgs(int64(7), ReportStatusReviewing, ReportStatusResolved, "ops-ada", "warned").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectQuery(`FROM report_events`).WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"from_status", "to_status", "actor", "note", "created_at"}).
+			AddRow(ReportStatusOpen, ReportStatusReviewing, "ops-ada", "", now).
+			AddRow(ReportStatusReviewing, ReportStatusResolved, "ops-ada", "warned", now))
+	mock.ExpectCommit()
+
+	report, err := reports.UpdateStatus(context.Background(), 7, ReportStatusResolved, "ops-ada", "warned")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != ReportStatusResolved || len(report.History) != 2 || report.History[1].Note != "warned" {
+		t.Errorf("got %+v with history %+v, want resolved after two changes", report, report.History)
+	}
+}
+
+// TestUpdateReportStatusRefused rolls back a change reportTransitions
+// doesn't allow, and one that would open a second report about the target
+func TestUpdateReportStatusRefused(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		current string
+		status  string
+		reopen  bool
+		want    error
+	}{
+		{"closed to closed", ReportStatusResolved, ReportStatusDismissed, false, ErrInvalidReportTransition},
+		{"unchanged", ReportStatusOpen, ReportStatusOpen, false, ErrInvalidReportTransition},
+		{"reopened beside a newer report", ReportStatusDismissed, ReportStatusOpen, true, ErrDuplicateReport},
+	} {
+		db, mock := newMockDB(t)
+		reports := &ReportStore{db}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FOR UPDATE`).WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(tc.current))
+		if tc.reopen {
+			mock.ExpectQuery(`UPDATE reports`).WillReturnError(&pq.Error{Code: "23505"})
+		}
+		mock.ExpectRollback()
+
+		if _, err := reports.UpdateStatus(context.Background(), 7, tc.status, "", ""); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
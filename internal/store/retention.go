@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// RoomRetention is how long one room keeps its messages
+type RoomRetention struct {
+	RoomID    int64
+	Retention time.Duration
+}
+
+// retention returns the retention in effect for a room with the given
+// setting (nil if the room has none); zero keeps messages forever
+// A room's own setting wins, whatever the server default
+func (l Limits) retention(seconds *int64) time.Duration {
+	if seconds == nil {
+		return l.MessageRetention
+	}
+	return time.Duration(*seconds) * time.Second
+}
+
+// ListRetained returns every room whose messages expire, with its retention
+// Rooms without a setting of their own use the server default, so with no
+// default only the rooms that set one are listed
+func (s *RoomStore) ListRetained(ctx context.Context) ([]RoomRetention, error) {
+	query := `
+		SELECT id, COALESCE(retention_seconds, $1)
+		FROM rooms
+		WHERE deleted_at IS NULL AND COALESCE(retention_seconds, $1) > 0
+		ORDER BY id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, int64(s.limits.MessageRetention.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := make([]RoomRetention, 0)
+	for rows.Next() {
+		var room RoomRetention
+		var seconds int64
+		if err := rows.Scan(&room.RoomID, &seconds); err != nil {
+			return nil, err
+		}
+		room.Retention = time.Duration(seconds) * time.Second
+		rooms = append(rooms, room)
+	}
+	return rooms, rows.Err()
+}
+
+// PurgeRoomExpired deletes up to batchSize of a room's messages posted longer
+// ago than the retention, by the database's clock, oldest first, returning how
+// many were removed
+// Pinned messages are kept until they're unpinned, so a room's pins never
+// change behind its clients' backs
+// Call it repeatedly until it returns fewer than batchSize
+func (s *MessageStore) PurgeRoomExpired(ctx context.Context, roomID int64, retention time.Duration, batchSize int) (int64, error) {
+	query := `
+		DELETE FROM messages
+		WHERE id IN (
+			SELECT m.id FROM messages m
+			WHERE m.room_id = $1 AND m.created_at < NOW() - make_interval(secs => $2)
+			  AND NOT EXISTS (SELECT 1 FROM pinned_messages p WHERE p.message_id = m.id)
+			ORDER BY m.created_at
+			LIMIT $3
+		)
+	`
+
+	result, err := s.db.ExecContext(ctx, query, roomID, retention.Seconds(), batchSize)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
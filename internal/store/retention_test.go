@@ -0,0 +1,119 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestMessageRetention purges a room on the scratch database: only messages
+// older than the retention go, oldest first and a batch at a time, and pinned
+// ones stay. Rooms are listed for purging with their own retention or the
+// default, and not at all when they keep messages forever
+func TestMessageRetention(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	suffix := time.Now().UnixNano()
+	limits := Limits{MessageRetention: 48 * time.Hour}
+	rooms := &RoomStore{db: db, limits: limits}
+	messages := &MessageStore{db: db}
+
+	var userID int64
+	userQuery := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+	if err := db.QueryRowContext(ctx, userQuery, fmt.Sprintf("retention-%d", suffix)).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM rooms WHERE created_by = $1`, userID)
+		db.Exec(`DELETE FROM users WHERE id = $1`, userID)
+	})
+
+	// A room on the default, one keeping a day, and one keeping messages forever
+	day, forever := int64(24*3600), int64(0)
+	var ids []int64
+	for i, seconds := range []*int64{nil, &day, &forever} {
+		room := &Room{Name: fmt.Sprintf("retention-%d-%d", suffix, i), CreatedBy: userID}
+		if err := rooms.Create(ctx, room); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.ExecContext(ctx, `UPDATE rooms SET retention_seconds = $2 WHERE id = $1`, room.ID, seconds); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, room.ID)
+	}
+
+	retained, err := rooms.ListRetained(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64]time.Duration{ids[0]: 48 * time.Hour, ids[1]: 24 * time.Hour}
+	for _, room := range retained {
+		if !slices.Contains(ids, room.RoomID) {
+			continue // Another test's room
+		}
+		if room.Retention != want[room.RoomID] {
+			t.Errorf("room %d is listed with %s, want %s", room.RoomID, room.Retention, want[room.RoomID])
+		}
+		delete(want, room.RoomID)
+	}
+	if len(want) > 0 {
+		t.Errorf("rooms %v weren't listed for purging", want)
+	}
+
+	// The day room gets messages 30, 26 and 2 hours old, and a pinned one 40 hours old
+	roomID := ids[1]
+	post := func(age time.Duration) int64 {
+		t.Helper()
+		message := &Message{RoomID: roomID, UserID: userID, Content: fmt.Sprintf("%s old", age)}
+		if err := messages.Create(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.ExecContext(ctx, `UPDATE messages SET created_at = NOW() - make_interval(secs => $2) WHERE id = $1`, message.ID, age.Seconds()); err != nil {
+			t.Fatal(err)
+		}
+		return message.ID
+	}
+	oldest, old, recent, pinned := post(30*time.Hour), post(26*time.Hour), post(2*time.Hour), post(40*time.Hour)
+	if _, err := db.ExecContext(ctx, `INSERT INTO pinned_messages (room_id, message_id, position, pinned_by) VALUES ($1, $2, 0, $3)`, roomID, pinned, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	// remaining lists which of the room's messages are left
+	remaining := func() []int64 {
+		t.Helper()
+		var left []int64
+		for _, id := range []int64{oldest, old, recent, pinned} {
+			var exists bool
+			if err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM messages WHERE id = $1)`, id).Scan(&exists); err != nil {
+				t.Fatal(err)
+			}
+			if exists {
+				left = append(left, id)
+			}
+		}
+		return left
+	}
+
+	for i, c := range []struct {
+		purged int64
+		left   []int64
+	}{
+		{1, []int64{old, recent, pinned}},
+		{1, []int64{recent, pinned}},
+		{0, []int64{recent, pinned}},
+	} {
+		purged, err := messages.PurgeRoomExpired(ctx, roomID, 24*time.Hour, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if left := remaining(); purged != c.purged || !slices.Equal(left, c.left) {
+			t.Errorf("batch %d purged %d, leaving %v; want %d, leaving %v", i+1, purged, left, c.purged, c.left)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestSoftDeleteRestorePurge deletes a room with members and messages on
+// the scratch database: every lookup loses it while its rows stay, a
+// restore brings it back with the same members, and once the deletion is
+// older than the window the purge removes the room and its messages
+func TestSoftDeleteRestorePurge(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	limits := Limits{MaxRoomMembers: 100, MaxRoomsPerUser: 100}
+	rooms := &RoomStore{db, limits, NewPools(db, nil)}
+	members := &RoomMemberStore{db, limits}
+	messages := &MessageStore{db, NewPools(db, nil)}
+	suffix := time.Now().UnixNano()
+	window := time.Hour
+
+	var ada, grace int64
+	for _, user := range []struct {
+		name string
+		id   *int64
+	}{{"ada", &ada}, {"grace", &grace}} {
+		query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+		if err := db.QueryRowContext(ctx, query, fmt.Sprintf("deletion-%s-%d", user.name, suffix)).Scan(user.id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	room := &Room{Name: fmt.Sprintf("deletion-%d", suffix), CreatedBy: ada}
+	if err := rooms.Create(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM rooms WHERE id = $1`, room.ID)
+		for _, id := range []int64{ada, grace} {
+			db.Exec(`DELETE FROM users WHERE id = $1`, id)
+		}
+	})
+	for _, id := range []int64{ada, grace} {
+		if err := members.Join(ctx, room.ID, id, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := messages.Create(ctx, &Message{RoomID: room.ID, UserID: grace, Content: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rooms.SoftDelete(ctx, room.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := rooms.SoftDelete(ctx, room.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleting twice got %v, want sql.ErrNoRows", err)
+	}
+
+	// Gone from every lookup
+	if _, err := rooms.GetByID(ctx, room.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetByID got %v, want sql.ErrNoRows", err)
+	}
+	if _, err := rooms.GetByName(ctx, room.Name); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetByName got %v, want sql.ErrNoRows", err)
+	}
+	listed, err := rooms.List(ctx, RoomListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userRooms, err := rooms.GetUserRooms(ctx, grace, RoomListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range append(listed, userRooms...) {
+		if r.ID == room.ID {
+			t.Error("the deleted room is still listed")
+		}
+	}
+	if in, err := members.IsUserInRoom(ctx, room.ID, grace); err != nil || in {
+		t.Errorf("IsUserInRoom = %v, %v; want false", in, err)
+	}
+	if count, err := members.GetUserRoomCount(ctx, grace); err != nil || count != 0 {
+		t.Errorf("grace counts %d rooms (%v), want 0", count, err)
+	}
+	if err := members.Join(ctx, room.ID, grace, grace); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("joining the deleted room got %v, want sql.ErrNoRows", err)
+	}
+
+	// Not purged inside the window; restored with everything it had
+	if _, err := rooms.PurgeExpired(ctx, window, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := rooms.Restore(ctx, room.ID, window); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := rooms.GetByID(ctx, room.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.MemberCount != 2 || restored.LastMessageAt == nil {
+		t.Errorf("the restored room has %d members and last message %v, want 2 and a time", restored.MemberCount, restored.LastMessageAt)
+	}
+	if in, _ := members.IsUserInRoom(ctx, room.ID, grace); !in {
+		t.Error("grace isn't a member of the restored room")
+	}
+
+	// Deleted longer ago than the window: too late to restore, and purged
+	if err := rooms.SoftDelete(ctx, room.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, `UPDATE rooms SET deleted_at = NOW() - INTERVAL '2 hours' WHERE id = $1`, room.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := rooms.Restore(ctx, room.ID, window); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("restoring after the window got %v, want sql.ErrNoRows", err)
+	}
+	if _, err := rooms.PurgeExpired(ctx, window, 100); err != nil {
+		t.Fatal(err)
+	}
+	var left int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM messages WHERE room_id = $1`, room.ID).Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rooms.GetDeletedByID(ctx, room.ID, 24*time.Hour); !errors.Is(err, sql.ErrNoRows) || left != 0 {
+		t.Errorf("after the purge the room is %v with %d messages left, want gone", err, left)
+	}
+}
@@ -0,0 +1,95 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestRoomEventLog makes several kinds of change to a room on the scratch
+// database and replays them after the first: they come back in order with
+// their own seqs. Purging the log then turns the same replay into
+// ErrRoomEventsPurged, while a client that saw everything gets nothing new
+func TestRoomEventLog(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	limits := Limits{MaxRoomMembers: 100, MaxRoomsPerUser: 100}
+	rooms := &RoomStore{db, limits, NewPools(db, nil)}
+	members := &RoomMemberStore{db, limits}
+	messages := &MessageStore{db, NewPools(db, nil)}
+	pins := &PinStore{db}
+	events := &RoomEventStore{db}
+	suffix := time.Now().UnixNano()
+
+	var ada, grace int64
+	for _, user := range []struct {
+		name string
+		id   *int64
+	}{{"ada", &ada}, {"grace", &grace}} {
+		query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+		if err := db.QueryRowContext(ctx, query, fmt.Sprintf("events-%s-%d", user.name, suffix)).Scan(user.id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	room := &Room{Name: fmt.Sprintf("events-%d", suffix), CreatedBy: ada}
+	if err := rooms.Create(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM rooms WHERE id = $1`, room.ID)
+		db.Exec(`DELETE FROM users WHERE id = ANY(ARRAY[$1, $2]::bigint[])`, ada, grace)
+	})
+	message := &Message{RoomID: room.ID, UserID: ada, Content: "pin me"}
+	if err := messages.Create(ctx, message); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := pins.Pin(ctx, room.ID, message.ID, ada)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := members.Join(ctx, room.ID, grace, grace); err != nil {
+		t.Fatal(err)
+	}
+	room.Description = "now with a description"
+	if err := rooms.Update(ctx, room, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pins.Unpin(ctx, room.ID, message.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	missed, err := events.ListAfter(ctx, room.ID, first.EventSeq, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for i, event := range missed {
+		types = append(types, event.Type)
+		if i > 0 && event.Seq <= missed[i-1].Seq {
+			t.Errorf("event %d has seq %d after %d", i, event.Seq, missed[i-1].Seq)
+		}
+	}
+	want := []string{"member_" + MembershipJoined, RoomEventRoomUpdated, RoomEventPinRemoved}
+	if !slices.Equal(types, want) {
+		t.Errorf("replayed %v, want %v", types, want)
+	}
+
+	if _, err := events.PurgeOlderThan(ctx, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := events.ListAfter(ctx, room.ID, first.EventSeq, 500); !errors.Is(err, ErrRoomEventsPurged) {
+		t.Errorf("replaying after the purge got %v, want ErrRoomEventsPurged", err)
+	}
+	latest := missed[len(missed)-1].Seq
+	if rest, err := events.ListAfter(ctx, room.ID, latest, 500); err != nil || len(rest) != 0 {
+		t.Errorf("an up to date client got %d events (%v), want none", len(rest), err)
+	}
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Room event types
+// Membership changes are logged as "member_" plus the membership event
+// (member_joined, member_left, member_kicked)
+const (
+	RoomEventPinAdded        = "pin_added"
+	RoomEventPinRemoved      = "pin_removed"
+	RoomEventPinOrderChanged = "pin_order_changed"
+	RoomEventRoomUpdated     = "room_updated"
+	RoomEventRoomMerged      = "room_merged" // Logged in the target room; history and pins should be reloaded
+	RoomEventMessageRedacted = "message_redacted"
+)
+
+// roomEventLockClass namespaces the advisory locks taken by appendRoomEvent
+const roomEventLockClass = 0x524f4f4d // "ROOM"
+
+// ErrRoomEventsPurged is returned when events after the requested sequence
+// number have been purged, so replaying the log can't bring a client up to date
+var ErrRoomEventsPurged = errors.New("room events purged; full resync required")
+
+// RoomEvent is one entry in a room's event log: a change clients keep a local
+// copy of (membership, pins, room settings) that they need to replay after
+// being offline. Chat messages aren't logged here; they have their own history
+type RoomEvent struct {
+	Seq       int64           `json:"seq"`
+	RoomID    int64           `json:"room_id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// RoomEventStore handles reading and pruning the room event log
+// Events are written by the stores that make the changes, via appendRoomEvent
+type RoomEventStore struct {
+	db *sql.DB
+}
+
+// lockRoomEvents serializes event writes to a room until the transaction ends
+// Sequence numbers come from a shared sequence and are taken before commit;
+// without the lock a transaction could commit seq 11 before another commits
+// seq 10, and a client that already read 11 would never see 10
+// An advisory lock is used rather than the room row, which every chat message updates
+func lockRoomEvents(ctx context.Context, tx *sql.Tx, roomID int64) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, hashint8($2))`, roomEventLockClass, roomID)
+	return err
+}
+
+// appendRoomEvent adds an event to a room's log inside the caller's transaction
+// If the change is rolled back, so is its event
+// Returns the event's sequence number
+func appendRoomEvent(ctx context.Context, tx *sql.Tx, roomID int64, eventType string, payload interface{}) (int64, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	if err := lockRoomEvents(ctx, tx, roomID); err != nil {
+		return 0, err
+	}
+
+	var seq int64
+	query := `INSERT INTO room_events (room_id, event_type, payload) VALUES ($1, $2, $3) RETURNING seq`
+	err = tx.QueryRowContext(ctx, query, roomID, eventType, string(data)).Scan(&seq)
+	return seq, err
+}
+
+// appendMembershipEvents logs the same membership change for several users in one statement
+// Quiet changes get "quiet": true in their payload: clients still apply them
+// to their cached member list but don't show a "joined" line for them
+func appendMembershipEvents(ctx context.Context, tx *sql.Tx, roomID int64, userIDs []int64, event string, actorID int64, quiet bool) error {
+	if err := lockRoomEvents(ctx, tx, roomID); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO room_events (room_id, event_type, payload)
+		SELECT $1, $2, jsonb_build_object('user_id', u.id, 'actor_id', NULLIF($4::bigint, 0))
+			|| CASE WHEN $5 THEN '{"quiet": true}'::jsonb ELSE '{}'::jsonb END
+		FROM unnest($3::bigint[]) WITH ORDINALITY AS u(id, ord)
+		ORDER BY u.ord
+	`
+	_, err := tx.ExecContext(ctx, query, roomID, "member_"+event, pq.Array(userIDs), actorID, quiet)
+	return err
+}
+
+// ListAfter returns up to limit of a room's events with a sequence number above afterSeq, oldest first
+// Returns ErrRoomEventsPurged if some events after afterSeq were already purged
+func (s *RoomEventStore) ListAfter(ctx context.Context, roomID, afterSeq int64, limit int) ([]*RoomEvent, error) {
+	// Read the watermark and the events in one snapshot so a purge in between can't slip through
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var purgedSeq int64
+	watermarkQuery := `SELECT events_purged_seq FROM rooms WHERE id = $1 AND deleted_at IS NULL`
+	if err := tx.QueryRowContext(ctx, watermarkQuery, roomID).Scan(&purgedSeq); err != nil {
+		return nil, err
+	}
+	if afterSeq < purgedSeq {
+		return nil, ErrRoomEventsPurged
+	}
+
+	query := `
+		SELECT seq, room_id, event_type, payload, created_at
+		FROM room_events
+		WHERE room_id = $1 AND seq > $2
+		ORDER BY seq
+		LIMIT $3
+	`
+	rows, err := tx.QueryContext(ctx, query, roomID, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*RoomEvent, 0)
+	for rows.Next() {
+		event := &RoomEvent{}
+		if err := rows.Scan(&event.Seq, &event.RoomID, &event.Type, &event.Payload, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// PurgeOlderThan deletes events older than the cutoff
+// Each room remembers the highest sequence number it lost (events_purged_seq),
+// so ListAfter can tell a client it fell too far behind
+// Returns the number of events removed
+func (s *RoomEventStore) PurgeOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	query := `
+		WITH purged AS (
+			DELETE FROM room_events WHERE created_at < $1
+			RETURNING room_id, seq
+		), watermarks AS (
+			SELECT room_id, MAX(seq) AS seq, COUNT(*) AS removed FROM purged GROUP BY room_id
+		), updated AS (
+			UPDATE rooms r
+			SET events_purged_seq = GREATEST(r.events_purged_seq, w.seq)
+			FROM watermarks w
+			WHERE r.id = w.room_id
+		)
+		SELECT COALESCE(SUM(removed), 0) FROM watermarks
+	`
+
+	var removed int64
+	err := s.db.QueryRowContext(ctx, query, cutoff).Scan(&removed)
+	return removed, err
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// expectRoomEvent expects a change to be logged in a room's event log
+// under the room's advisory lock, and gives it seq
+func expectRoomEvent(mock sqlmock.Sqlmock, roomID int64, eventType string, seq int64) {
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock\(\$1, hashint8\(\$2\)\)`).WithArgs(roomEventLockClass, roomID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`INSERT INTO room_events \(room_id, event_type, payload\) VALUES \(\$1, \$2, \$3\) RETURNING seq`).
+		WithArgs(roomID, eventType, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"seq"}).AddRow(seq))
+}
+
+// expectMembershipEvents expects the same membership change for userIDs in
+// the membership history and in the room's event log
+func expectMembershipEvents(mock sqlmock.Sqlmock, roomID int64, userIDs []int64, event string, actorID int64) {
+	mock.ExpectExec(`INSERT INTO room_membership_events \(room_id, user_id, event, actor_id\)\s+SELECT \$1, unnest`).
+		WithArgs(roomID, pq.Array(userIDs), event, actorID).WillReturnResult(sqlmock.NewResult(0, int64(len(userIDs))))
+	expectMembershipLogged(mock, roomID, userIDs, event, actorID, false)
+}
+
+// expectMembershipLogged expects a membership change to be added to the
+// room's event log, one event per user, flagged quiet or not
+func expectMembershipLogged(mock sqlmock.Sqlmock, roomID int64, userIDs []int64, event string, actorID int64, quiet bool) {
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock`).WithArgs(roomEventLockClass, roomID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO room_events \(room_id, event_type, payload\)\s+SELECT \$1, \$2, jsonb_build_object`).
+		WithArgs(roomID, "member_"+event, pq.Array(userIDs), actorID, quiet).WillReturnResult(sqlmock.NewResult(0, int64(len(userIDs))))
+}
+
+// TestListRoomEventsAfter reads the purge watermark and the events in one
+// read-only snapshot
+func TestListRoomEventsAfter(t *testing.T) {
+	db, mock := newMockDB(t)
+	events := &RoomEventStore{db}
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT events_purged_seq FROM rooms WHERE id = \$1 AND deleted_at IS NULL`).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"events_purged_seq"}).AddRow(10))
+	mock.ExpectQuery(`FROM room_events\s+WHERE room_id = \$1 AND seq > \$2\s+ORDER BY seq\s+LIMIT \$3`).WithArgs(int64(1), int64(10), 2).
+		WillReturnRows(sqlmock.NewRows([]string{"seq", "room_id", "event_type", "payload", "created_at"}).
+			AddRow(11, 1, RoomEventPinAdded, []byte(`{"message_id": 5}`), now).
+			AddRow(14, 1, "member_joined", []byte(`{"user_id": 2}`), now))
+	mock.ExpectRollback()
+
+	got, err := events.ListAfter(context.Background(), 1, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Seq != 11 || got[1].Type != "member_joined" || string(got[0].Payload) != `{"message_id": 5}` {
+		t.Errorf("got %+v, want the pin at 11 and the join at 14", got)
+	}
+}
+
+// TestListRoomEventsPurged asks for events from before the watermark: the
+// client missed some it can't get back. A room that's gone is sql.ErrNoRows
+func TestListRoomEventsPurged(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		rows *sqlmock.Rows
+		want error
+	}{
+		{"behind the purge", sqlmock.NewRows([]string{"events_purged_seq"}).AddRow(10), ErrRoomEventsPurged},
+		{"room gone", sqlmock.NewRows([]string{"events_purged_seq"}), sql.ErrNoRows},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT events_purged_seq FROM rooms`).WillReturnRows(tc.rows)
+			mock.ExpectRollback()
+
+			if _, err := (&RoomEventStore{db}).ListAfter(context.Background(), 1, 9, 500); !errors.Is(err, tc.want) {
+				t.Errorf("got %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+// TestPurgeRoomEvents removes old events and raises each room's watermark
+// in the same statement
+func TestPurgeRoomEvents(t *testing.T) {
+	db, mock := newMockDB(t)
+	cutoff := time.Now().Add(-720 * time.Hour)
+
+	mock.ExpectQuery(`DELETE FROM room_events WHERE created_at < \$1(?s:.*)SET events_purged_seq = GREATEST\(r.events_purged_seq, w.seq\)`).
+		WithArgs(cutoff).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(42))
+
+	removed, err := (&RoomEventStore{db}).PurgeOlderThan(context.Background(), cutoff)
+	if err != nil || removed != 42 {
+		t.Errorf("removed %d (%v), want 42", removed, err)
+	}
+}
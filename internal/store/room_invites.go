@@ -0,0 +1,181 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Room invite statuses
+const (
+	RoomInvitePending  = "pending"
+	RoomInviteAccepted = "accepted"
+	RoomInviteDeclined = "declined"
+)
+
+var (
+	// ErrInviteNotFound is returned for an invite that doesn't exist, isn't the
+	// user's, or whose room was deleted
+	ErrInviteNotFound = errors.New("invite not found")
+
+	// ErrInviteExpired is returned when answering an invite past its expiry
+	ErrInviteExpired = errors.New("invite expired")
+
+	// ErrInviteAnswered is returned when answering an invite the other way
+	// than it was already answered
+	ErrInviteAnswered = errors.New("invite already answered")
+)
+
+// RoomInvite is an invite of a registered user to a room
+type RoomInvite struct {
+	ID          int64      `json:"id"`
+	RoomID      int64      `json:"room_id"`
+	RoomName    string     `json:"room_name"`
+	InviteeID   int64      `json:"invitee_id"`
+	InvitedBy   *int64     `json:"invited_by"`
+	InviterName string     `json:"inviter_name,omitempty"` // Empty once the inviter's account is gone
+	Status      string     `json:"status"`                 // One of the RoomInvite constants
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RespondedAt *time.Time `json:"responded_at,omitempty"`
+}
+
+// roomInviteColumns are the columns scanned into a RoomInvite, in
+// scanTargets' order; queries alias room_invites as i, rooms as r and the
+// inviter's users row as u
+const roomInviteColumns = `i.id, i.room_id, r.name, i.invitee_id, i.invited_by, COALESCE(u.username, ''),
+	i.status, i.created_at, i.expires_at, i.responded_at`
+
+func (invite *RoomInvite) scanTargets() []any {
+	return []any{
+		&invite.ID, &invite.RoomID, &invite.RoomName, &invite.InviteeID, &invite.InvitedBy, &invite.InviterName,
+		&invite.Status, &invite.CreatedAt, &invite.ExpiresAt, &invite.RespondedAt,
+	}
+}
+
+// RoomInviteStore handles database operations for room invites
+// Accepting one joins the room in the same transaction
+type RoomInviteStore struct {
+	db     *sql.DB
+	limits Limits
+}
+
+// Create saves an invite of a user to a room, valid for ttl
+// Inviting the same user to the same room again refreshes the open invite's
+// inviter and expiry and keeps its ID, so the invitee sees it once
+// Set RoomID, InviteeID and InvitedBy; the rest is filled in
+func (s *RoomInviteStore) Create(ctx context.Context, invite *RoomInvite, ttl time.Duration) error {
+	query := `
+		INSERT INTO room_invites (room_id, invitee_id, invited_by, expires_at)
+		VALUES ($1, $2, NULLIF($3, 0), NOW() + ($4 * INTERVAL '1 second'))
+		ON CONFLICT (room_id, invitee_id) WHERE status = 'pending' DO UPDATE
+		SET invited_by = EXCLUDED.invited_by, created_at = NOW(), expires_at = EXCLUDED.expires_at
+		RETURNING id, invited_by, status, created_at, expires_at
+	`
+	var invitedBy int64
+	if invite.InvitedBy != nil {
+		invitedBy = *invite.InvitedBy
+	}
+	return s.db.QueryRowContext(ctx, query, invite.RoomID, invite.InviteeID, invitedBy, ttl.Seconds()).
+		Scan(&invite.ID, &invite.InvitedBy, &invite.Status, &invite.CreatedAt, &invite.ExpiresAt)
+}
+
+// ListPending returns a user's open invites, newest first
+// Expired invites and those to deleted rooms are left out
+func (s *RoomInviteStore) ListPending(ctx context.Context, userID int64) ([]*RoomInvite, error) {
+	query := `
+		SELECT ` + roomInviteColumns + `
+		FROM room_invites i
+		INNER JOIN rooms r ON r.id = i.room_id
+		LEFT JOIN users u ON u.id = i.invited_by
+		WHERE i.invitee_id = $1 AND i.status = 'pending' AND i.expires_at > NOW() AND r.deleted_at IS NULL
+		ORDER BY i.id DESC
+	`
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := make([]*RoomInvite, 0)
+	for rows.Next() {
+		invite := &RoomInvite{}
+		if err := rows.Scan(invite.scanTargets()...); err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+// Respond accepts or declines a user's invite; accepting joins them to the
+// room in the same transaction (subject to the membership limits, like a join)
+// Returns the invite and whether this call answered it. Answering an invite
+// the same way again returns it unchanged, so a retried response is harmless;
+// answering it the other way returns ErrInviteAnswered
+// An invite that isn't the user's is ErrInviteNotFound, as if it didn't exist
+func (s *RoomInviteStore) Respond(ctx context.Context, inviteID, userID int64, accept bool) (*RoomInvite, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	// Locked, so two responses to one invite take turns
+	query := `
+		SELECT ` + roomInviteColumns + `, i.expires_at <= NOW()
+		FROM room_invites i
+		INNER JOIN rooms r ON r.id = i.room_id
+		LEFT JOIN users u ON u.id = i.invited_by
+		WHERE i.id = $1 AND r.deleted_at IS NULL
+		FOR UPDATE OF i
+	`
+	invite := &RoomInvite{}
+	var expired bool
+	err = tx.QueryRowContext(ctx, query, inviteID).Scan(append(invite.scanTargets(), &expired)...)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && invite.InviteeID != userID {
+		return nil, false, ErrInviteNotFound
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	status := RoomInviteDeclined
+	if accept {
+		status = RoomInviteAccepted
+	}
+	if invite.Status != RoomInvitePending {
+		if invite.Status == status {
+			return invite, false, nil
+		}
+		return nil, false, ErrInviteAnswered
+	}
+	if expired {
+		return nil, false, ErrInviteExpired
+	}
+
+	if accept {
+		// Someone who joined meanwhile (another invite, an admin's bulk add)
+		// still accepts; they just don't join twice
+		err := addMember(ctx, tx, s.limits, invite.RoomID, userID, JoinOptions{ActorID: userID, IfAbsent: true})
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, ErrInviteNotFound
+		}
+		if err != nil && !errors.Is(err, errAlreadyJoined) {
+			return nil, false, err
+		}
+	}
+
+	update := `UPDATE room_invites SET status = $2, responded_at = NOW() WHERE id = $1 RETURNING status, responded_at`
+	if err := tx.QueryRowContext(ctx, update, invite.ID, status).Scan(&invite.Status, &invite.RespondedAt); err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	return invite, true, nil
+}
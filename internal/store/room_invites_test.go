@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectInviteLocked expects Respond's locking read of invite 7, to room 1
+// for user 2 from user 3, in status and expired or not
+func expectInviteLocked(mock sqlmock.Sqlmock, status string, expired bool) {
+	now := time.Now()
+	columns := []string{"id", "room_id", "name", "invitee_id", "invited_by", "username", "status", "created_at", "expires_at", "responded_at", "expired"}
+	mock.ExpectQuery(`FROM room_invites i\s+INNER JOIN rooms r .*WHERE i.id = \$1 AND r.deleted_at IS NULL\s+FOR UPDATE OF i`).
+		WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(7, 1, "general", 2, 3, "ada", status, now, now.Add(time.Hour), nil, expired))
+}
+
+// TestRespondToInvite accepts an invite, which joins the room in the same
+// transaction, then answers it again: the same answer changes nothing and
+// the other is refused. Someone else's invite and an expired one are refused
+// without a change
+func TestRespondToInvite(t *testing.T) {
+	db, mock := newMockDB(t)
+	invites := &RoomInviteStore{db, Limits{}}
+	ctx := context.Background()
+
+	mock.ExpectBegin()
+	expectInviteLocked(mock, RoomInvitePending, false)
+	mock.ExpectExec(`SELECT id FROM users WHERE id = \$1 FOR UPDATE`).WithArgs(int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT max_members FROM rooms WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"max_members"}).AddRow(nil))
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM room_members`).WithArgs(int64(1), int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`INSERT INTO room_members .* ON CONFLICT \(room_id, user_id\) DO NOTHING`).WithArgs(int64(1), int64(2), RoomRoleMember).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(2))
+	// The invitee is recorded as joining themselves
+	mock.ExpectExec(`INSERT INTO room_membership_events`).WithArgs(int64(1), int64(2), MembershipJoined, int64(2)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectMembershipLogged(mock, 1, []int64{2}, MembershipJoined, 2, false)
+	mock.ExpectQuery(`UPDATE room_invites SET status = \$2, responded_at = NOW\(\) WHERE id = \$1`).WithArgs(int64(7), RoomInviteAccepted).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "responded_at"}).AddRow(RoomInviteAccepted, time.Now()))
+	mock.ExpectCommit()
+
+	invite, changed, err := invites.Respond(ctx, 7, 2, true)
+	if err != nil || !changed || invite.Status != RoomInviteAccepted || invite.RoomName != "general" || invite.InviterName != "ada" {
+		t.Fatalf("accepting got %+v, changed %t, %v; want the accepted invite to general from ada", invite, changed, err)
+	}
+
+	mock.ExpectBegin()
+	expectInviteLocked(mock, RoomInviteAccepted, false)
+	mock.ExpectRollback()
+	if invite, changed, err := invites.Respond(ctx, 7, 2, true); err != nil || changed || invite.Status != RoomInviteAccepted {
+		t.Errorf("accepting again got %+v, changed %t, %v; want the invite unchanged", invite, changed, err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		userID  int64
+		accept  bool
+		status  string
+		expired bool
+		want    error
+	}{
+		{"declining an accepted invite", 2, false, RoomInviteAccepted, false, ErrInviteAnswered},
+		{"someone else's invite", 4, true, RoomInvitePending, false, ErrInviteNotFound},
+		{"an expired invite", 2, true, RoomInvitePending, true, ErrInviteExpired},
+	} {
+		mock.ExpectBegin()
+		expectInviteLocked(mock, tc.status, tc.expired)
+		mock.ExpectRollback()
+		if _, changed, err := invites.Respond(ctx, 7, tc.userID, tc.accept); !errors.Is(err, tc.want) || changed {
+			t.Errorf("%s got changed %t, %v; want %v", tc.name, changed, err, tc.want)
+		}
+	}
+}
@@ -3,7 +3,10 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 // RoomMember represents the many-to-many relationship between users and rooms
@@ -11,57 +14,188 @@ import (
 type RoomMember struct {
 	RoomID   int64     `json:"room_id"`
 	UserID   int64     `json:"user_id"`
+	Role     string    `json:"role"`
 	JoinedAt time.Time `json:"joined_at"`
 }
 
+// Member roles within a room
+const (
+	RoomRoleMember = "member" // Regular participant
+	RoomRoleAdmin  = "admin"  // Can manage the room, e.g. approve join requests
+)
+
 // RoomMemberStore handles database operations for room memberships
 type RoomMemberStore struct {
-	db *sql.DB
+	db     *sql.DB
+	limits Limits
 }
 
 // Join adds a user to a room
+// actorID is who added them, recorded in the membership history; pass userID
+// when users join on their own, or 0 for a system action
 // If the user is already a member, this will return an error due to the primary key constraint
-func (s *RoomMemberStore) Join(ctx context.Context, roomID, userID int64) error {
+// Returns ErrRoomFull or ErrTooManyRooms if joining would exceed a limit
+func (s *RoomMemberStore) Join(ctx context.Context, roomID, userID, actorID int64) error {
+	return s.JoinWithOptions(ctx, roomID, userID, JoinOptions{ActorID: actorID})
+}
+
+// JoinWithOptions adds a user to a room with a specific role or as a quiet join
+// Used when creating a room, where the creator becomes its first admin
+func (s *RoomMemberStore) JoinWithOptions(ctx context.Context, roomID, userID int64, opts JoinOptions) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	if err := addMember(ctx, tx, s.limits, roomID, userID, opts); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// JoinIfAbsent adds a user to a room they join themselves, unless they're
+// already a member
+// Reports whether the user was added; only then is a membership event recorded,
+// so callers fire their join side effects only when it returns true. Safe to
+// call concurrently for the same user and room: exactly one call returns true
+func (s *RoomMemberStore) JoinIfAbsent(ctx context.Context, roomID, userID int64) (bool, error) {
+	err := s.JoinWithOptions(ctx, roomID, userID, JoinOptions{ActorID: userID, IfAbsent: true})
+	if errors.Is(err, errAlreadyJoined) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetUserRoomCount returns how many rooms a user belongs to
+// Used to refuse creating a room up front when the user is at their room limit
+// Deleted rooms don't count, even while they can still be restored
+func (s *RoomMemberStore) GetUserRoomCount(ctx context.Context, userID int64) (int, error) {
 	query := `
-		INSERT INTO room_members (room_id, user_id)
-		VALUES ($1, $2)
+		SELECT COUNT(*)
+		FROM room_members rm
+		INNER JOIN rooms r ON r.id = rm.room_id
+		WHERE rm.user_id = $1 AND r.deleted_at IS NULL
 	`
 
-	// ExecContext is used when we don't need to retrieve any data back
-	// It's more efficient than QueryRowContext for INSERT/UPDATE/DELETE without RETURNING
-	_, err := s.db.ExecContext(ctx, query, roomID, userID)
-	return err
+	var count int
+	if err := s.db.QueryRowContext(ctx, query, userID).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
 }
 
 // Leave removes a user from a room
+// actorID is who removed them, as for Join
 // If the user is not a member, this will not return an error (idempotent operation)
-func (s *RoomMemberStore) Leave(ctx context.Context, roomID, userID int64) error {
+// and nothing is added to the membership history
+func (s *RoomMemberStore) Leave(ctx context.Context, roomID, userID, actorID int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 		DELETE FROM room_members
 		WHERE room_id = $1 AND user_id = $2
 	`
 
-	_, err := s.db.ExecContext(ctx, query, roomID, userID)
-	return err
+	result, err := tx.ExecContext(ctx, query, roomID, userID)
+	if err != nil {
+		return err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if removed > 0 {
+		if err := recordMembershipEvent(ctx, tx, roomID, userID, MembershipLeft, actorID, false); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
+// isMemberQuery checks one user's membership of a room
+// Members of a deleted room keep their row, but the room is gone for them
+const isMemberQuery = `
+	SELECT EXISTS(
+		SELECT 1 FROM room_members rm
+		INNER JOIN rooms r ON r.id = rm.room_id
+		WHERE rm.room_id = $1 AND rm.user_id = $2 AND r.deleted_at IS NULL
+	)
+`
+
 // IsUserInRoom checks if a user is a member of a specific room
 // This is important for authorization (user can only see messages in rooms they've joined)
 func (s *RoomMemberStore) IsUserInRoom(ctx context.Context, roomID, userID int64) (bool, error) {
+	var exists bool
+	// EXISTS returns a boolean indicating whether any rows match the condition
+	err := s.db.QueryRowContext(ctx, isMemberQuery, roomID, userID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// IsRoomAdmin checks if a user is an admin of a specific room
+func (s *RoomMemberStore) IsRoomAdmin(ctx context.Context, roomID, userID int64) (bool, error) {
 	query := `
 		SELECT EXISTS(
 			SELECT 1 FROM room_members
-			WHERE room_id = $1 AND user_id = $2
+			WHERE room_id = $1 AND user_id = $2 AND role = 'admin'
 		)
 	`
 
-	var exists bool
-	// EXISTS returns a boolean indicating whether any rows match the condition
-	err := s.db.QueryRowContext(ctx, query, roomID, userID).Scan(&exists)
-	if err != nil {
+	var isAdmin bool
+	if err := s.db.QueryRowContext(ctx, query, roomID, userID).Scan(&isAdmin); err != nil {
 		return false, err
 	}
-	return exists, nil
+	return isAdmin, nil
+}
+
+// SetRole changes a member's role in a room, RoomRoleMember or RoomRoleAdmin
+// The creator's ownership doesn't depend on it (see RoomRoleOwner)
+// Returns sql.ErrNoRows if the user isn't a member
+func (s *RoomMemberStore) SetRole(ctx context.Context, roomID, userID int64, role string) error {
+	query := `UPDATE room_members SET role = $3 WHERE room_id = $1 AND user_id = $2`
+	return expectOneRow(s.db.ExecContext(ctx, query, roomID, userID, role))
+}
+
+// GetRoomAdmins retrieves the user IDs of a room's admins
+func (s *RoomMemberStore) GetRoomAdmins(ctx context.Context, roomID int64) ([]int64, error) {
+	query := `
+		SELECT user_id
+		FROM room_members
+		WHERE room_id = $1 AND role = 'admin'
+		ORDER BY joined_at ASC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := make([]int64, 0)
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return userIDs, nil
 }
 
 // GetRoomMembers retrieves all user IDs for members of a specific room
@@ -112,3 +246,77 @@ func (s *RoomMemberStore) GetRoomMemberCount(ctx context.Context, roomID int64)
 	}
 	return count, nil
 }
+
+// SearchMembers finds a room's members whose username or display name
+// contains q, ignoring case
+// Unlike UserStore.Search it ignores "discoverable": people who share a room
+// see each other anyway. Ranked like UserStore.Search
+func (s *RoomMemberStore) SearchMembers(ctx context.Context, roomID int64, q string, limit int) ([]*PublicUser, error) {
+	// q is matched literally, so LIKE's wildcards in it must be escaped
+	q = searchTerm(q)
+	if q == "" {
+		return make([]*PublicUser, 0), nil
+	}
+	limit, _ = clampPage(limit, 0, 20, maxRoomSearchLimit)
+
+	query := `
+		SELECT u.id, u.username, u.display_name
+		FROM room_members rm
+		INNER JOIN users u ON u.id = rm.user_id
+		WHERE rm.room_id = $1
+		  AND (u.username ILIKE '%' || $2 || '%' OR u.display_name ILIKE '%' || $2 || '%')
+		ORDER BY
+			CASE
+				WHEN u.username ILIKE $2 || '%' THEN 0
+				WHEN u.display_name ILIKE $2 || '%' THEN 1
+				ELSE 2
+			END,
+			LENGTH(u.username),
+			u.username
+		LIMIT $3
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, roomID, escapeLike(q), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]*PublicUser, 0)
+	for rows.Next() {
+		user := &PublicUser{}
+		if err := rows.Scan(&user.ID, &user.Username, &user.DisplayName); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// FindMembersByUsername returns the IDs of room members with one of the given usernames
+// Used to resolve @mentions; names that aren't members of the room are ignored
+func (s *RoomMemberStore) FindMembersByUsername(ctx context.Context, roomID int64, usernames []string) ([]int64, error) {
+	query := `
+		SELECT u.id
+		FROM users u
+		INNER JOIN room_members rm ON rm.user_id = u.id
+		WHERE rm.room_id = $1 AND u.username = ANY($2)
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, roomID, pq.Array(usernames))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	return userIDs, rows.Err()
+}
@@ -0,0 +1,258 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestParallelJoinsRespectLimits has many users join one small room at once,
+// and one user join many rooms at once, on the scratch database: the row
+// locks in addMember let exactly as many joins through as the limits allow,
+// and the trigger-kept member_count agrees with room_members
+func TestParallelJoinsRespectLimits(t *testing.T) {
+	const (
+		users    = 12
+		roomSize = 5
+		quota    = 3
+	)
+	db := testdb.Open(t)
+	ctx := context.Background()
+	limits := Limits{MaxRoomMembers: 100, MaxRoomsPerUser: quota}
+	members := &RoomMemberStore{db, limits}
+	rooms := &RoomStore{db, limits, NewPools(db, nil)}
+	suffix := time.Now().UnixNano()
+
+	// The extra user at the end joins rooms in the second half
+	userIDs := make([]int64, users+1)
+	for i := range userIDs {
+		query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+		if err := db.QueryRowContext(ctx, query, fmt.Sprintf("limits-%d-%d", suffix, i)).Scan(&userIDs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	roomIDs := make([]int64, users)
+	for i := range roomIDs {
+		room := &Room{Name: fmt.Sprintf("limits-%d-%d", suffix, i), CreatedBy: userIDs[0]}
+		if err := rooms.Create(ctx, room); err != nil {
+			t.Fatal(err)
+		}
+		roomIDs[i] = room.ID
+	}
+	t.Cleanup(func() {
+		for _, id := range roomIDs {
+			db.Exec(`DELETE FROM rooms WHERE id = $1`, id)
+		}
+		for _, id := range userIDs {
+			db.Exec(`DELETE FROM users WHERE id = $1`, id)
+		}
+	})
+
+	size := roomSize
+	small, err := rooms.GetByID(ctx, roomIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	small.MaxMembersOverride = &size
+	if err := rooms.Update(ctx, small, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// joinAll runs join for every index at once and counts how it went
+	joinAll := func(join func(i int) error) (joined, refused int) {
+		var wg sync.WaitGroup
+		errs := make([]error, users)
+		for i := range users {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = join(i)
+			}()
+		}
+		wg.Wait()
+
+		for i, err := range errs {
+			switch {
+			case err == nil:
+				joined++
+			case errors.Is(err, ErrRoomFull), errors.Is(err, ErrTooManyRooms):
+				refused++
+			default:
+				t.Fatalf("join %d: %v", i, err)
+			}
+		}
+		return joined, refused
+	}
+
+	// Every user joins the small room
+	joined, refused := joinAll(func(i int) error { return members.Join(ctx, roomIDs[0], userIDs[i], userIDs[i]) })
+	if joined != roomSize || refused != users-roomSize {
+		t.Errorf("%d joined and %d were refused, want %d and %d", joined, refused, roomSize, users-roomSize)
+	}
+	room, err := rooms.GetByID(ctx, roomIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.MemberCount != roomSize {
+		t.Errorf("member_count is %d, want %d", room.MemberCount, roomSize)
+	}
+
+	// The extra user joins every room; the small room is full, and their
+	// quota lets them into only some of the others
+	last := userIDs[users]
+	joined, refused = joinAll(func(i int) error { return members.Join(ctx, roomIDs[i], last, last) })
+	if count, err := members.GetUserRoomCount(ctx, last); err != nil || count != quota {
+		t.Errorf("the user is in %d rooms (%v), want %d", count, err, quota)
+	}
+	if joined != quota || refused != users-quota {
+		t.Errorf("%d joined and %d were refused, want %d and %d", joined, refused, quota, users-quota)
+	}
+}
+
+// TestMembershipEventPages pages through a history where every event has
+// the same timestamp, on the scratch database: each event is seen exactly
+// once, newest first, and the user filter holds across pages
+func TestMembershipEventPages(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	events := &MembershipEventStore{db}
+	suffix := time.Now().UnixNano()
+
+	var userIDs [2]int64
+	for i := range userIDs {
+		query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+		if err := db.QueryRowContext(ctx, query, fmt.Sprintf("history-%d-%d", suffix, i)).Scan(&userIDs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	room := &Room{Name: fmt.Sprintf("history-%d", suffix), CreatedBy: userIDs[0]}
+	if err := (&RoomStore{db, Limits{}, NewPools(db, nil)}).Create(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM rooms WHERE id = $1`, room.ID)
+		for _, id := range userIDs {
+			db.Exec(`DELETE FROM users WHERE id = $1`, id)
+		}
+	})
+
+	// Seven events for the first user and three for the second, all at once
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		userID, event := userIDs[0], MembershipJoined
+		if i%2 == 1 {
+			event = MembershipLeft
+		}
+		if i >= 7 {
+			userID = userIDs[1]
+		}
+		query := `INSERT INTO room_membership_events (room_id, user_id, event, actor_id, created_at) VALUES ($1, $2, $3, $2, $4)`
+		if _, err := db.ExecContext(ctx, query, room.ID, userID, event, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		userID int64
+		want   int
+	}{{0, 10}, {userIDs[0], 7}, {userIDs[1], 3}} {
+		seen := make(map[int64]bool)
+		var before int64
+		for page := 0; ; page++ {
+			if page > 10 {
+				t.Fatalf("user %d: paging didn't stop", tc.userID)
+			}
+			got, err := events.List(ctx, room.ID, MembershipEventQuery{UserID: tc.userID, Limit: 3, Before: before})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) == 0 {
+				break
+			}
+			for _, e := range got {
+				if seen[e.ID] || (before != 0 && e.ID >= before) {
+					t.Errorf("user %d: event %d came again or out of order", tc.userID, e.ID)
+				}
+				if tc.userID != 0 && e.UserID != tc.userID {
+					t.Errorf("user %d: got user %d's event", tc.userID, e.UserID)
+				}
+				seen[e.ID] = true
+				before = e.ID
+			}
+		}
+		if len(seen) != tc.want {
+			t.Errorf("user %d: paged through %d events, want %d", tc.userID, len(seen), tc.want)
+		}
+	}
+}
+
+// TestJoinIfAbsent joins a user to a room from several goroutines at once on
+// the scratch database: exactly one call adds the member and records a
+// membership event, and a later call finds them already there
+func TestJoinIfAbsent(t *testing.T) {
+	const joins = 8
+	db := testdb.Open(t)
+	ctx := context.Background()
+	members := &RoomMemberStore{db: db}
+	suffix := time.Now().UnixNano()
+
+	var userID int64
+	userQuery := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+	if err := db.QueryRowContext(ctx, userQuery, fmt.Sprintf("autojoin-%d", suffix)).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	room := &Room{Name: fmt.Sprintf("autojoin-%d", suffix), CreatedBy: userID}
+	if err := (&RoomStore{db: db}).Create(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+	roomID := room.ID
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM rooms WHERE id = $1`, roomID)
+		db.Exec(`DELETE FROM users WHERE id = $1`, userID)
+	})
+
+	var wg sync.WaitGroup
+	added := make([]bool, joins)
+	errs := make([]error, joins)
+	for i := range joins {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			added[i], errs[i] = members.JoinIfAbsent(ctx, roomID, userID)
+		}()
+	}
+	wg.Wait()
+
+	var adds int
+	for i := range joins {
+		if errs[i] != nil {
+			t.Fatalf("join %d: %v", i, errs[i])
+		}
+		if added[i] {
+			adds++
+		}
+	}
+	if adds != 1 {
+		t.Errorf("%d of %d concurrent joins added the member, want 1", adds, joins)
+	}
+
+	var events int
+	eventQuery := `SELECT COUNT(*) FROM room_membership_events WHERE room_id = $1 AND user_id = $2 AND event = $3`
+	if err := db.QueryRowContext(ctx, eventQuery, roomID, userID, MembershipJoined).Scan(&events); err != nil {
+		t.Fatal(err)
+	}
+	if events != 1 {
+		t.Errorf("%d join events were recorded, want 1", events)
+	}
+
+	if again, err := members.JoinIfAbsent(ctx, roomID, userID); err != nil || again {
+		t.Errorf("joining again returned %t, %v; want false, nil", again, err)
+	}
+}
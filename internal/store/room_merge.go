@@ -0,0 +1,227 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrMergeTargetDeleted is returned when merging into a room that has been deleted
+	ErrMergeTargetDeleted = errors.New("merge target room is deleted")
+
+	// ErrMergeSameRoom is returned when a room is merged into itself
+	ErrMergeSameRoom = errors.New("cannot merge a room into itself")
+)
+
+// RoomMergeResult describes what a merge moved
+type RoomMergeResult struct {
+	SourceRoomID  int64 `json:"source_room_id"`
+	TargetRoomID  int64 `json:"target_room_id"`
+	MessagesMoved int64 `json:"messages_moved"`
+	PinsMoved     int64 `json:"pins_moved"`
+
+	// Source members, and those of them who weren't already in the target
+	Members      []int64 `json:"-"`
+	NewMembers   []int64 `json:"-"`
+	MembersMoved int     `json:"members_moved"`
+	MembersAdded int     `json:"members_added"`
+}
+
+// Merge moves everything in the source room into the target room, in one transaction
+//   - messages are moved with a single UPDATE, so they interleave with the
+//     target's history by timestamp and ID, however many there are
+//   - pins are appended after the target's pins, keeping their order
+//   - members join the target; someone in both rooms keeps the higher role
+//   - read markers move for users who have none in the target yet
+//
+// The source room is then soft-deleted with merged_into pointing at the target
+// Joins are recorded for new target members and leaves for every source member,
+// all by actorID
+// Member limits are checked against the combined membership; the per-user
+// room limit isn't, since each user trades one room for another
+// Returns sql.ErrNoRows if either room doesn't exist or the source is deleted,
+// ErrMergeTargetDeleted if the target is deleted and ErrRoomFull if the
+// target can't take the extra members
+func (s *RoomStore) Merge(ctx context.Context, sourceID, targetID, actorID int64) (*RoomMergeResult, error) {
+	if sourceID == targetID {
+		return nil, ErrMergeSameRoom
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock both rooms, lowest ID first, so two merges of the same pair can't deadlock
+	lockQuery := `
+		SELECT id, deleted_at IS NOT NULL, max_members
+		FROM rooms
+		WHERE id = ANY($1)
+		ORDER BY id
+		FOR UPDATE
+	`
+	rows, err := tx.QueryContext(ctx, lockQuery, pq.Array([]int64{sourceID, targetID}))
+	if err != nil {
+		return nil, err
+	}
+	deleted := make(map[int64]bool, 2)
+	var targetOverride *int
+	for rows.Next() {
+		var id int64
+		var isDeleted bool
+		var override *int
+		if err := rows.Scan(&id, &isDeleted, &override); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		deleted[id] = isDeleted
+		if id == targetID {
+			targetOverride = override
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sourceDeleted, sourceFound := deleted[sourceID]
+	targetDeleted, targetFound := deleted[targetID]
+	if !sourceFound || !targetFound || sourceDeleted {
+		return nil, sql.ErrNoRows
+	}
+	if targetDeleted {
+		return nil, ErrMergeTargetDeleted
+	}
+
+	result := &RoomMergeResult{SourceRoomID: sourceID, TargetRoomID: targetID}
+
+	// Members
+	result.Members, err = queryIDs(ctx, tx, `SELECT user_id FROM room_members WHERE room_id = $1`, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	newQuery := `
+		SELECT user_id FROM room_members
+		WHERE room_id = $1
+		  AND user_id NOT IN (SELECT user_id FROM room_members WHERE room_id = $2)
+	`
+	result.NewMembers, err = queryIDs(ctx, tx, newQuery, sourceID, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit := s.limits.roomMemberLimit(targetOverride); limit > 0 && len(result.NewMembers) > 0 {
+		var members int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM room_members WHERE room_id = $1`, targetID).Scan(&members); err != nil {
+			return nil, err
+		}
+		if members+len(result.NewMembers) > limit {
+			return nil, ErrRoomFull
+		}
+	}
+
+	// Admins of the source stay admins in the target
+	promoteQuery := `
+		UPDATE room_members t
+		SET role = 'admin'
+		FROM room_members s
+		WHERE t.room_id = $2 AND s.room_id = $1 AND s.user_id = t.user_id
+		  AND s.role = 'admin' AND t.role <> 'admin'
+	`
+	if _, err := tx.ExecContext(ctx, promoteQuery, sourceID, targetID); err != nil {
+		return nil, err
+	}
+
+	moveMembersQuery := `
+		INSERT INTO room_members (room_id, user_id, role)
+		SELECT $2, user_id, role FROM room_members WHERE room_id = $1
+		ON CONFLICT DO NOTHING
+	`
+	if _, err := tx.ExecContext(ctx, moveMembersQuery, sourceID, targetID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM room_members WHERE room_id = $1`, sourceID); err != nil {
+		return nil, err
+	}
+	if err := recordMembershipEvents(ctx, tx, sourceID, result.Members, MembershipLeft, actorID); err != nil {
+		return nil, err
+	}
+	if err := recordMembershipEvents(ctx, tx, targetID, result.NewMembers, MembershipJoined, actorID); err != nil {
+		return nil, err
+	}
+	result.MembersMoved = len(result.Members)
+	result.MembersAdded = len(result.NewMembers)
+
+	// Messages: one statement however large the room is
+	moved, err := tx.ExecContext(ctx, `UPDATE messages SET room_id = $2 WHERE room_id = $1`, sourceID, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if result.MessagesMoved, err = moved.RowsAffected(); err != nil {
+		return nil, err
+	}
+
+	// Pins go after the target's own; pinned_messages is keyed by message, so
+	// nothing can collide. The total may exceed MaxPinsPerRoom, in which case
+	// new pins are refused until some are removed
+	movePinsQuery := `
+		UPDATE pinned_messages
+		SET room_id = $2,
+		    position = position + (SELECT COALESCE(MAX(position), 0) FROM pinned_messages WHERE room_id = $2)
+		WHERE room_id = $1
+	`
+	pins, err := tx.ExecContext(ctx, movePinsQuery, sourceID, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if result.PinsMoved, err = pins.RowsAffected(); err != nil {
+		return nil, err
+	}
+	if result.PinsMoved > 0 {
+		if _, err := tx.ExecContext(ctx, `UPDATE rooms SET pins_version = pins_version + 1 WHERE id = $1`, targetID); err != nil {
+			return nil, err
+		}
+	}
+
+	// A read position in the target is kept as is; users new to the target
+	// bring their source position along
+	markersQuery := `
+		INSERT INTO read_markers (user_id, room_id, device_id, last_read_message_id, updated_at)
+		SELECT user_id, $2, device_id, last_read_message_id, updated_at
+		FROM read_markers WHERE room_id = $1
+		ON CONFLICT DO NOTHING
+	`
+	if _, err := tx.ExecContext(ctx, markersQuery, sourceID, targetID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM read_markers WHERE room_id = $1`, sourceID); err != nil {
+		return nil, err
+	}
+
+	targetQuery := `
+		UPDATE rooms
+		SET last_message_at = GREATEST(last_message_at, (SELECT last_message_at FROM rooms WHERE id = $1))
+		WHERE id = $2
+	`
+	if _, err := tx.ExecContext(ctx, targetQuery, sourceID, targetID); err != nil {
+		return nil, err
+	}
+	sourceQuery := `UPDATE rooms SET deleted_at = NOW(), merged_into = $2 WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, sourceQuery, sourceID, targetID); err != nil {
+		return nil, err
+	}
+
+	// Clients of the target missed a lot of history and maybe pins at once
+	if _, err := appendRoomEvent(ctx, tx, targetID, RoomEventRoomMerged, result); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
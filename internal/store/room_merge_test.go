@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// TestMerge merges room 1 into room 2: every statement runs once, whatever
+// the size of the rooms, so moving messages row by row would be caught by
+// the mock failing on the statements it doesn't expect
+func TestMerge(t *testing.T) {
+	db, mock := newMockDB(t)
+	rooms := &RoomStore{db, Limits{MaxRoomMembers: 10}, NewPools(db, nil)}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM rooms\s+WHERE id = ANY\(\$1\)\s+ORDER BY id\s+FOR UPDATE`).WithArgs(pq.Array([]int64{1, 2})).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "deleted", "max_members"}).AddRow(1, false, nil).AddRow(2, false, nil))
+	mock.ExpectQuery(`SELECT user_id FROM room_members WHERE room_id = \$1`).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1).AddRow(2).AddRow(3))
+	mock.ExpectQuery(`AND user_id NOT IN`).WithArgs(int64(1), int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(2))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM room_members WHERE room_id = \$1`).WithArgs(int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectExec(`UPDATE room_members t\s+SET role = 'admin'`).WithArgs(int64(1), int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO room_members \(room_id, user_id, role\)\s+SELECT \$2, user_id, role FROM room_members WHERE room_id = \$1\s+ON CONFLICT DO NOTHING`).
+		WithArgs(int64(1), int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM room_members WHERE room_id = \$1`).WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, 3))
+	expectMembershipEvents(mock, 1, []int64{1, 2, 3}, MembershipLeft, 1)
+	expectMembershipEvents(mock, 2, []int64{2}, MembershipJoined, 1)
+	mock.ExpectExec(`UPDATE messages SET room_id = \$2 WHERE room_id = \$1`).WithArgs(int64(1), int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 5120))
+	mock.ExpectExec(`UPDATE pinned_messages`).WithArgs(int64(1), int64(2)).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`UPDATE rooms SET pins_version = pins_version \+ 1 WHERE id = \$1`).WithArgs(int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO read_markers`).WithArgs(int64(1), int64(2)).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM read_markers WHERE room_id = \$1`).WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`SET last_message_at = GREATEST`).WithArgs(int64(1), int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE rooms SET deleted_at = NOW\(\), merged_into = \$2 WHERE id = \$1`).WithArgs(int64(1), int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectRoomEvent(mock, 2, RoomEventRoomMerged, 90)
+	mock.ExpectCommit()
+
+	result, err := rooms.Merge(context.Background(), 1, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.MessagesMoved != 5120 || result.PinsMoved != 3 || result.MembersMoved != 3 || result.MembersAdded != 1 {
+		t.Errorf("got %+v, want 5120 messages, 3 pins, 3 members moved and 1 added", result)
+	}
+}
+
+// TestMergeRefused checks the merges that stop before moving anything
+func TestMergeRefused(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		rows    *sqlmock.Rows
+		members int
+		want    error
+	}{
+		{"target deleted", sqlmock.NewRows([]string{"id", "deleted", "max_members"}).AddRow(1, false, nil).AddRow(2, true, nil), 0, ErrMergeTargetDeleted},
+		{"source missing", sqlmock.NewRows([]string{"id", "deleted", "max_members"}).AddRow(2, false, nil), 0, sql.ErrNoRows},
+		{"target full", sqlmock.NewRows([]string{"id", "deleted", "max_members"}).AddRow(1, false, nil).AddRow(2, false, 3), 3, ErrRoomFull},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			rooms := &RoomStore{db, Limits{MaxRoomMembers: 10}, NewPools(db, nil)}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`FOR UPDATE`).WillReturnRows(tc.rows)
+			if tc.members > 0 {
+				mock.ExpectQuery(`SELECT user_id FROM room_members WHERE room_id = \$1`).
+					WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(4))
+				mock.ExpectQuery(`AND user_id NOT IN`).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(4))
+				mock.ExpectQuery(`SELECT COUNT\(\*\)`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tc.members))
+			}
+			mock.ExpectRollback()
+
+			if _, err := rooms.Merge(context.Background(), 1, 2, 1); !errors.Is(err, tc.want) {
+				t.Errorf("got %v, want %v", err, tc.want)
+			}
+		})
+	}
+
+	if _, err := (&RoomStore{}).Merge(context.Background(), 1, 1, 1); !errors.Is(err, ErrMergeSameRoom) {
+		t.Errorf("merging a room into itself got %v, want ErrMergeSameRoom", err)
+	}
+}
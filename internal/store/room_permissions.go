@@ -0,0 +1,203 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"slices"
+
+	"github.com/lib/pq"
+)
+
+// RoomRoleOwner is the room's creator (rooms.created_by)
+// It isn't stored in room_members; the creator is an owner whether or not
+// they're still a member, like the creator checks this replaced
+const RoomRoleOwner = "owner"
+
+// Room capabilities: what a role may do in a room
+const (
+	CapPostMessage     = "post_message"     // Send chat messages
+	CapPinMessage      = "pin_message"      // Pin, unpin and reorder pins
+	CapManageMembers   = "manage_members"   // Add and remove members, handle join requests, read the membership history
+	CapManageSettings  = "manage_settings"  // Change room settings and this permission matrix
+	CapDeleteRoom      = "delete_room"      // Delete and restore the room
+	CapViewReports     = "view_reports"     // Read abuse reports filed in the room
+	CapMergeRoom       = "merge_room"       // Merge the room into another, or another into it
+	CapMentionEveryone = "mention_everyone" // Notify the whole room with @here and @room
+)
+
+// RoomPermissionRoles and RoomCapabilities list every role and capability of the matrix
+var (
+	RoomPermissionRoles = []string{RoomRoleOwner, RoomRoleAdmin, RoomRoleMember}
+	RoomCapabilities    = []string{
+		CapPostMessage, CapPinMessage, CapManageMembers, CapManageSettings,
+		CapDeleteRoom, CapViewReports, CapMergeRoom, CapMentionEveryone,
+	}
+)
+
+// roomPermissionDefaults are the cells allowed in a room that changed nothing
+// They match what the creator and admin checks allowed before the matrix
+// Every cell not listed is denied
+var roomPermissionDefaults = map[string]map[string]bool{
+	RoomRoleOwner: {
+		CapPostMessage: true, CapPinMessage: true, CapManageMembers: true, CapManageSettings: true,
+		CapDeleteRoom: true, CapViewReports: true, CapMergeRoom: true, CapMentionEveryone: true,
+	},
+	RoomRoleAdmin: {
+		CapPostMessage: true, CapPinMessage: true, CapManageMembers: true, CapDeleteRoom: true,
+		CapMentionEveryone: true,
+	},
+	RoomRoleMember: {
+		CapPostMessage: true,
+	},
+}
+
+// RoomPermissions is a room's permission matrix: role -> capability -> allowed
+type RoomPermissions map[string]map[string]bool
+
+// DefaultRoomPermissions returns the full matrix with every cell at its default
+func DefaultRoomPermissions() RoomPermissions {
+	perms := make(RoomPermissions, len(RoomPermissionRoles))
+	for _, role := range RoomPermissionRoles {
+		perms[role] = make(map[string]bool, len(RoomCapabilities))
+		for _, capability := range RoomCapabilities {
+			perms[role][capability] = roomPermissionDefaults[role][capability]
+		}
+	}
+	return perms
+}
+
+// Allows reports whether a role may use a capability
+// Cells missing from the matrix use their default; unknown roles may do nothing
+func (p RoomPermissions) Allows(role, capability string) bool {
+	if allowed, ok := p[role][capability]; ok {
+		return allowed
+	}
+	return roomPermissionDefaults[role][capability]
+}
+
+// ValidRoomPermission reports whether role and capability are both known
+func ValidRoomPermission(role, capability string) bool {
+	return slices.Contains(RoomPermissionRoles, role) && slices.Contains(RoomCapabilities, capability)
+}
+
+// RoomAccess is what one user may do in one room
+type RoomAccess struct {
+	Role        string          // owner, admin or member; empty if the user has no role in the room
+	Member      bool            // Whether the user is in room_members; owners may have left
+	Deleted     bool            // Whether the room is soft-deleted (see RoomStore.SoftDelete)
+	Permissions RoomPermissions // The room's full matrix
+}
+
+// Can reports whether the user may use a capability
+func (a *RoomAccess) Can(capability string) bool {
+	return a.Role != "" && a.Permissions.Allows(a.Role, capability)
+}
+
+// RoomPermissionStore handles rooms' permission matrices
+// Only cells a room changed are stored; the rest come from the defaults
+type RoomPermissionStore struct {
+	db *sql.DB
+}
+
+// Get returns a room's full permission matrix
+func (s *RoomPermissionStore) Get(ctx context.Context, roomID int64) (RoomPermissions, error) {
+	query := `SELECT role, capability, allowed FROM room_role_permissions WHERE room_id = $1`
+	rows, err := s.db.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	perms := DefaultRoomPermissions()
+	for rows.Next() {
+		var role, capability string
+		var allowed bool
+		if err := rows.Scan(&role, &capability, &allowed); err != nil {
+			return nil, err
+		}
+		if ValidRoomPermission(role, capability) {
+			perms[role][capability] = allowed
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return perms, nil
+}
+
+// roomAccessQuery loads a user's role in a room and the room's overrides in one round trip
+// Deleted rooms are included, so a room can still be restored
+const roomAccessQuery = `
+	SELECT CASE WHEN r.created_by = $2 THEN 'owner' ELSE COALESCE(rm.role, '') END,
+	       rm.user_id IS NOT NULL,
+	       r.deleted_at IS NOT NULL,
+	       COALESCE((
+	           SELECT json_agg(json_build_array(p.role, p.capability, p.allowed))
+	           FROM room_role_permissions p
+	           WHERE p.room_id = r.id
+	       ), '[]')
+	FROM rooms r
+	LEFT JOIN room_members rm ON rm.room_id = r.id AND rm.user_id = $2
+	WHERE r.id = $1
+`
+
+// GetAccess returns what a user may do in a room
+// Returns sql.ErrNoRows if the room doesn't exist
+func (s *RoomPermissionStore) GetAccess(ctx context.Context, roomID, userID int64) (*RoomAccess, error) {
+	access := &RoomAccess{}
+	var overrides []byte
+	if err := s.db.QueryRowContext(ctx, roomAccessQuery, roomID, userID).Scan(&access.Role, &access.Member, &access.Deleted, &overrides); err != nil {
+		return nil, err
+	}
+
+	// Each override is [role, capability, allowed]
+	var cells [][3]interface{}
+	if err := json.Unmarshal(overrides, &cells); err != nil {
+		return nil, err
+	}
+	access.Permissions = DefaultRoomPermissions()
+	for _, cell := range cells {
+		role, _ := cell[0].(string)
+		capability, _ := cell[1].(string)
+		allowed, _ := cell[2].(bool)
+		if ValidRoomPermission(role, capability) {
+			access.Permissions[role][capability] = allowed
+		}
+	}
+	return access, nil
+}
+
+// Update changes the given cells of a room's matrix and leaves the others alone
+// Cells must be valid (see ValidRoomPermission)
+// Returns the full matrix after the change
+func (s *RoomPermissionStore) Update(ctx context.Context, roomID int64, changes RoomPermissions) (RoomPermissions, error) {
+	roles := make([]string, 0)
+	capabilities := make([]string, 0)
+	allowed := make([]bool, 0)
+	for role, cells := range changes {
+		for capability, on := range cells {
+			roles = append(roles, role)
+			capabilities = append(capabilities, capability)
+			allowed = append(allowed, on)
+		}
+	}
+
+	// One statement for all cells, so a partial update can't half apply
+	query := `
+		INSERT INTO room_role_permissions (room_id, role, capability, allowed)
+		SELECT $1, c.role, c.capability, c.allowed
+		FROM unnest($2::text[], $3::text[], $4::boolean[]) AS c(role, capability, allowed)
+		ON CONFLICT (room_id, role, capability)
+		DO UPDATE SET allowed = EXCLUDED.allowed, updated_at = NOW()
+	`
+	if len(roles) > 0 {
+		_, err := s.db.ExecContext(ctx, query, roomID, pq.Array(roles), pq.Array(capabilities), pq.Array(allowed))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.Get(ctx, roomID)
+}
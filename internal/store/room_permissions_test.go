@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestDefaultRoomPermissions checks the defaults match what the creator and
+// admin checks allowed before the matrix: the owner may do everything, an
+// admin everything but change settings, read reports and merge, and a member
+// only post. Admins may also use @here and @room
+func TestDefaultRoomPermissions(t *testing.T) {
+	admin := map[string]bool{
+		CapPostMessage: true, CapPinMessage: true, CapManageMembers: true, CapDeleteRoom: true,
+		CapMentionEveryone: true,
+	}
+	perms := DefaultRoomPermissions()
+	if len(perms) != len(RoomPermissionRoles) {
+		t.Errorf("the defaults have %d roles, want %d", len(perms), len(RoomPermissionRoles))
+	}
+	for _, capability := range RoomCapabilities {
+		for role, want := range map[string]bool{
+			RoomRoleOwner:  true,
+			RoomRoleAdmin:  admin[capability],
+			RoomRoleMember: capability == CapPostMessage,
+		} {
+			got, ok := perms[role][capability]
+			if !ok || got != want {
+				t.Errorf("%s.%s defaults to %t (present %t), want %t", role, capability, got, ok, want)
+			}
+		}
+	}
+
+	// Each call returns its own matrix
+	perms[RoomRoleMember][CapPinMessage] = true
+	if DefaultRoomPermissions()[RoomRoleMember][CapPinMessage] {
+		t.Error("changing one default matrix changed the next")
+	}
+}
+
+// TestRoomPermissionsAllows checks cells the matrix has win over the
+// defaults, missing ones fall back to them, and unknown roles get nothing
+func TestRoomPermissionsAllows(t *testing.T) {
+	perms := RoomPermissions{
+		RoomRoleMember: {CapPinMessage: true, CapPostMessage: false},
+		RoomRoleAdmin:  {},
+	}
+	for _, tc := range []struct {
+		role, capability string
+		want             bool
+	}{
+		{RoomRoleMember, CapPinMessage, true},
+		{RoomRoleMember, CapPostMessage, false},
+		{RoomRoleMember, CapDeleteRoom, false},
+		{RoomRoleAdmin, CapManageMembers, true},
+		{RoomRoleAdmin, CapManageSettings, false},
+		{RoomRoleOwner, CapMergeRoom, true},
+		{"moderator", CapPostMessage, false},
+		{"", CapPostMessage, false},
+	} {
+		if got := perms.Allows(tc.role, tc.capability); got != tc.want {
+			t.Errorf("Allows(%q, %q) = %t, want %t", tc.role, tc.capability, got, tc.want)
+		}
+	}
+}
+
+func TestValidRoomPermission(t *testing.T) {
+	for _, tc := range []struct {
+		role, capability string
+		want             bool
+	}{
+		{RoomRoleOwner, CapManageSettings, true},
+		{RoomRoleMember, CapViewReports, true},
+		{"moderator", CapPostMessage, false},
+		{RoomRoleAdmin, "ban_users", false},
+		{"", "", false},
+	} {
+		if got := ValidRoomPermission(tc.role, tc.capability); got != tc.want {
+			t.Errorf("ValidRoomPermission(%q, %q) = %t, want %t", tc.role, tc.capability, got, tc.want)
+		}
+	}
+}
+
+// TestRoomAccessCan checks a user with no role in the room may do nothing,
+// even what every role may
+func TestRoomAccessCan(t *testing.T) {
+	perms := DefaultRoomPermissions()
+	outsider := &RoomAccess{Permissions: perms}
+	if outsider.Can(CapPostMessage) {
+		t.Error("a user without a role may post")
+	}
+	member := &RoomAccess{Role: RoomRoleMember, Member: true, Permissions: perms}
+	if !member.Can(CapPostMessage) || member.Can(CapPinMessage) {
+		t.Error("a member's access doesn't follow the matrix")
+	}
+}
+
+// TestGetAccess loads a member's role and the room's overrides in one query:
+// each override replaces its default, and a cell left over from a capability
+// this build doesn't know is ignored
+func TestGetAccess(t *testing.T) {
+	db, mock := newMockDB(t)
+	perms := &RoomPermissionStore{db}
+
+	mock.ExpectQuery(`LEFT JOIN room_members rm ON rm.room_id = r.id AND rm.user_id = \$2\s+WHERE r.id = \$1`).
+		WithArgs(int64(1), int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"role", "member", "deleted", "overrides"}).
+			AddRow("member", true, false, []byte(`[["member", "pin_message", true], ["member", "post_message", false], ["member", "slow_mode", true]]`)))
+
+	access, err := perms.GetAccess(context.Background(), 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access.Role != RoomRoleMember || !access.Member {
+		t.Errorf("got role %q and member %t, want a member", access.Role, access.Member)
+	}
+	if !access.Can(CapPinMessage) || access.Can(CapPostMessage) || !access.Permissions.Allows(RoomRoleAdmin, CapPinMessage) {
+		t.Errorf("the matrix is %v, want the member overrides on the defaults", access.Permissions)
+	}
+	if _, ok := access.Permissions[RoomRoleMember]["slow_mode"]; ok {
+		t.Error("an unknown capability made it into the matrix")
+	}
+}
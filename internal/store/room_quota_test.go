@@ -0,0 +1,78 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestRoomCreationCounts checks the counts behind the room creation quotas
+// on the scratch database: rooms created after since count, deleted or not,
+// a room created exactly at since doesn't, and the total leaves deleted rooms
+// out
+func TestRoomCreationCounts(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	rooms := &RoomStore{db: db, reads: NewPools(db, nil)}
+	suffix := time.Now().UnixNano()
+
+	var userID int64
+	userQuery := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+	if err := db.QueryRowContext(ctx, userQuery, fmt.Sprintf("quota-%d", suffix)).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM rooms WHERE created_by = $1`, userID)
+		db.Exec(`DELETE FROM users WHERE id = $1`, userID)
+	})
+
+	// Created a second before since, exactly at it, a second after it, and
+	// an hour after it (then deleted)
+	since := time.Now().Add(-24 * time.Hour).Truncate(time.Microsecond)
+	var ids []int64
+	for i, offset := range []time.Duration{-time.Second, 0, time.Second, time.Hour} {
+		room := &Room{Name: fmt.Sprintf("quota-%d-%d", suffix, i), CreatedBy: userID}
+		if err := rooms.Create(ctx, room); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.ExecContext(ctx, `UPDATE rooms SET created_at = $2 WHERE id = $1`, room.ID, since.Add(offset)); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, room.ID)
+	}
+	if err := rooms.SoftDelete(ctx, ids[3]); err != nil {
+		t.Fatal(err)
+	}
+
+	created, oldest, err := rooms.CountCreatedSince(ctx, userID, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 2 || !oldest.Equal(since.Add(time.Second)) {
+		t.Errorf("got %d rooms, the oldest created at %s; want 2, the oldest a second after %s", created, oldest, since)
+	}
+
+	owned, err := rooms.CountOwnedActive(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owned != 3 {
+		t.Errorf("the user owns %d rooms that aren't deleted, want 3", owned)
+	}
+
+	// Only the deleted room is in the last 23 hours
+	created, _, err = rooms.CountCreatedSince(ctx, userID, since.Add(time.Minute))
+	if err != nil || created != 1 {
+		t.Errorf("after since plus a minute got %d rooms, %v; want the deleted one", created, err)
+	}
+
+	created, oldest, err = rooms.CountCreatedSince(ctx, userID, time.Now())
+	if err != nil || created != 0 || !oldest.IsZero() {
+		t.Errorf("from now got %d rooms, the oldest at %s, %v; want none", created, oldest, err)
+	}
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestRoomSearchQueries checks the queries behind a room search match LIKE
+// wildcards in the term literally, cap the limit at maxRoomSearchLimit, skip
+// redacted messages, and don't query at all for a term that cleans down to nothing
+func TestRoomSearchQueries(t *testing.T) {
+	ctx := context.Background()
+	db, mock := newMockDB(t)
+	members := &RoomMemberStore{db: db}
+	pins := &PinStore{db}
+	attachments := &AttachmentStore{db, NewPools(db, nil)}
+	messages := &MessageStore{db, NewPools(db, nil)}
+
+	mock.ExpectQuery(`WHERE rm.room_id = \$1\s+AND \(u.username ILIKE '%' \|\| \$2 \|\| '%' OR u.display_name ILIKE '%' \|\| \$2 \|\| '%'\)`).
+		WithArgs(int64(7), `50\%`, maxRoomSearchLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "display_name"}).AddRow(3, "ada50%", "Ada"))
+	found, err := members.SearchMembers(ctx, 7, "50%", 500)
+	if err != nil || len(found) != 1 || found[0].Username != "ada50%" {
+		t.Errorf("searching members got %+v, %v", found, err)
+	}
+
+	mock.ExpectQuery(`WHERE p.room_id = \$1 AND m.content ILIKE '%' \|\| \$2 \|\| '%' AND m.redacted_at IS NULL`).
+		WithArgs(int64(7), `a\_b`, 6).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "room_id", "position", "pinned_by", "pinned_at", "user_id", "username", "content", "created_at"}))
+	if pinned, err := pins.Search(ctx, 7, "a_b", 6); err != nil || len(pinned) != 0 {
+		t.Errorf("searching pins got %+v, %v", pinned, err)
+	}
+
+	mock.ExpectQuery(`WHERE r.user_id = \$1 AND r.filename ILIKE '%' \|\| \$2 \|\| '%'`).
+		WithArgs(int64(3), `c:\\`, 6).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	if files, err := attachments.Search(ctx, 3, `c:\`, 6); err != nil || len(files) != 0 {
+		t.Errorf("searching files got %+v, %v", files, err)
+	}
+
+	mock.ExpectQuery(`WHERE m.room_id = \$1 AND m.content ILIKE '%' \|\| \$2 \|\| '%' AND m.redacted_at IS NULL\s+ORDER BY m.id DESC`).
+		WithArgs(int64(7), "deploy", 6).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	if found, err := messages.Search(ctx, 7, "deploy", 6); err != nil || len(found) != 0 {
+		t.Errorf("searching messages got %+v, %v", found, err)
+	}
+
+	// NUL bytes are dropped, leaving nothing to search for
+	if found, err := messages.Search(ctx, 7, "\x00", 6); err != nil || len(found) != 0 {
+		t.Errorf("searching messages for a NUL byte got %+v, %v", found, err)
+	}
+}
@@ -0,0 +1,158 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// RoomSummary is a room as the sidebar shows it: the room itself plus the
+// caller's view of it
+type RoomSummary struct {
+	*Room
+
+	// LastMessage is the room's most recent message, nil for a room without messages
+	LastMessage *LastMessage `json:"last_message"`
+
+	// UnreadCount counts messages from others past the user's read position
+	// on any device, up to maxSummaryUnread
+	UnreadCount int `json:"unread_count"`
+
+	// MentionCount counts the unread messages mentioning "@username", and
+	// allowed @room mentions
+	MentionCount int `json:"mention_count"`
+
+	// OnlineCount is the number of members connected right now
+	// The store leaves it at 0; the handler fills it in from the hub
+	OnlineCount int `json:"online_count"`
+}
+
+// LastMessage is the preview of a room's most recent message
+type LastMessage struct {
+	ID        int64     `json:"id"`
+	Preview   string    `json:"preview"` // The first 80 characters
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// AuthorDeleted is set as for a Message (see Message.AuthorDeleted)
+	AuthorDeleted bool `json:"author_deleted,omitempty"`
+
+	// SystemEvent is set when the message is a structured system message; its
+	// preview is empty here and rendered by the caller (see Message.SystemEvent)
+	SystemEvent SystemEvent `json:"system_event,omitempty"`
+}
+
+// maxSummaryUnread caps the unread and mention counts of a room summary
+// Counting stops there, so a room with years of unread history costs the
+// same as one with a thousand messages; clients show "999+"
+const maxSummaryUnread = 1000
+
+// roomSummariesQuery loads a user's joined rooms with their last message and
+// counts; $1 is the user, $2 the tag filter and $3 maxSummaryUnread
+// The last message and the counts come from LATERAL joins, which are LEFT
+// joins so rooms without messages (or without read markers) are still listed
+// Unread messages are those from others past the user's furthest read marker
+// or, with no marker at all, those sent since the user joined; only the
+// newest maxSummaryUnread of them are counted, from the (room_id, id) index
+func roomSummariesQuery(opts RoomListOptions) string {
+	return `
+		SELECT ` + roomColumns + `,
+			lm.id, COALESCE(lm.preview, ''), lm.user_id, COALESCE(lm.username, ''), lm.created_at, lm.system_event,
+			counts.unread, counts.mentions
+		FROM rooms r
+		INNER JOIN room_members rm ON r.id = rm.room_id
+		INNER JOIN users me ON me.id = rm.user_id
+		LEFT JOIN LATERAL (
+			SELECT m.id, LEFT(m.content, 80) AS preview, COALESCE(m.user_id, 0) AS user_id,
+				COALESCE(u.username, m.author_username) AS username, m.created_at, m.system_event
+			FROM messages m
+			LEFT JOIN users u ON u.id = m.user_id
+			WHERE m.room_id = r.id
+			ORDER BY m.id DESC
+			LIMIT 1
+		) lm ON TRUE
+		LEFT JOIN LATERAL (
+			SELECT MAX(rk.last_read_message_id) AS read_max
+			FROM read_markers rk
+			WHERE rk.user_id = rm.user_id AND rk.room_id = r.id
+		) reads ON TRUE
+		LEFT JOIN LATERAL (
+			SELECT m.id AS before_join
+			FROM messages m
+			WHERE reads.read_max IS NULL AND m.room_id = r.id AND m.created_at < rm.joined_at
+			ORDER BY m.created_at DESC
+			LIMIT 1
+		) joined ON TRUE
+		CROSS JOIN LATERAL (
+			SELECT COUNT(*) AS unread,
+				COUNT(*) FILTER (WHERE ` + mentionMatch("unread.content", "me.username") + `
+					OR unread.room_mention = 'room') AS mentions
+			FROM (
+				SELECT m.content, m.room_mention
+				FROM messages m
+				WHERE m.room_id = r.id AND m.user_id IS DISTINCT FROM rm.user_id
+				AND m.id > COALESCE(reads.read_max, joined.before_join, 0)
+				ORDER BY m.id DESC
+				LIMIT $3
+			) unread
+		) counts
+		WHERE rm.user_id = $1 AND r.deleted_at IS NULL AND ` + opts.tagFilter(2) + `
+		` + opts.orderBy()
+}
+
+// GetUserRoomSummaries returns every room the user has joined with its last
+// message, unread count and mention count
+// It's a single query however many rooms the user is in (see roomSummariesQuery)
+func (s *RoomStore) GetUserRoomSummaries(ctx context.Context, userID int64, opts RoomListOptions) ([]*RoomSummary, error) {
+	query := roomSummariesQuery(opts)
+
+	rows, err := s.db.QueryContext(ctx, query, userID, opts.Tag, maxSummaryUnread)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := make([]*RoomSummary, 0)
+	for rows.Next() {
+		summary := &RoomSummary{Room: &Room{}}
+		var (
+			lastID        *int64
+			lastPreview   string
+			lastUserID    *int64
+			lastUsername  string
+			lastCreatedAt *time.Time
+			lastEvent     SystemEvent
+		)
+		targets := append(summary.Room.scanTargets(),
+			&lastID, &lastPreview, &lastUserID, &lastUsername, &lastCreatedAt, &lastEvent,
+			&summary.UnreadCount, &summary.MentionCount)
+		if err := rows.Scan(targets...); err != nil {
+			return nil, err
+		}
+
+		s.fillComputed(summary.Room)
+		if lastID != nil {
+			summary.LastMessage = &LastMessage{
+				ID:        *lastID,
+				Preview:   lastPreview,
+				UserID:    *lastUserID,
+				Username:  lastUsername,
+				CreatedAt: *lastCreatedAt,
+
+				SystemEvent: lastEvent,
+			}
+			if *lastUserID == 0 {
+				summary.LastMessage.AuthorDeleted = true
+				if lastUsername == "" {
+					summary.LastMessage.Username = DeletedUsername
+				}
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return summaries, nil
+}
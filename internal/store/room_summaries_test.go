@@ -0,0 +1,43 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestGetUserRoomSummaries lists forty rooms with a single query (the mock
+// fails on any other statement); a room without messages has no last
+// message, and counts stop at maxSummaryUnread
+func TestGetUserRoomSummaries(t *testing.T) {
+	db, mock := newMockDB(t)
+	rooms := &RoomStore{db, Limits{}, NewPools(db, nil)}
+	now := time.Now()
+
+	rows := sqlmock.NewRows(append(roomRowColumns, "lm_id", "preview", "lm_user_id", "username", "lm_created_at", "lm_system_event", "unread", "mentions"))
+	for id := int64(1); id <= 40; id++ {
+		if id == 40 {
+			rows.AddRow(id, "quiet", "", 1, now, now, 1, false, nil, "open", nil, 1, true, false, nil, "{}", false, "", nil, "everyone", nil, "", nil, "", nil, nil, 0, 0)
+			continue
+		}
+		rows.AddRow(id, "busy", "", 1, now, now, 1, false, now, "open", nil, 2, true, false, nil, "{}", false, "", nil, "everyone", id*10, "hi @ada", 2, "grace", now, nil, 3, 1)
+	}
+	mock.ExpectQuery(`LIMIT \$3\s+\) unread\s+\) counts\s+WHERE rm.user_id = \$1 AND r.deleted_at IS NULL`).
+		WithArgs(int64(1), "", maxSummaryUnread).WillReturnRows(rows)
+
+	summaries, err := rooms.GetUserRoomSummaries(context.Background(), 1, RoomListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 40 {
+		t.Fatalf("got %d rooms, want 40", len(summaries))
+	}
+	if first := summaries[0]; first.LastMessage == nil || first.LastMessage.ID != 10 || first.LastMessage.Username != "grace" || first.UnreadCount != 3 || first.MentionCount != 1 {
+		t.Errorf("got %+v with last message %+v", first, first.LastMessage)
+	}
+	if quiet := summaries[39]; quiet.LastMessage != nil || quiet.UnreadCount != 0 {
+		t.Errorf("the room without messages got last message %+v and %d unread", quiet.LastMessage, quiet.UnreadCount)
+	}
+}
@@ -0,0 +1,122 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestRoomSummaryCounts lists bob's rooms on the scratch database, where
+// bobby's name starts with bob's and bob's has a dot in it
+// In the room bob has read, only messages from others past the marker are
+// unread, and only "@bob" followed by the end of the name is a mention; a
+// room without messages is still listed; in the room bob never read, only
+// what was sent after bob joined is unread
+func TestRoomSummaryCounts(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	limits := Limits{MaxRoomMembers: 100, MaxRoomsPerUser: 100}
+	rooms := &RoomStore{db, limits, NewPools(db, nil)}
+	members := &RoomMemberStore{db, limits}
+	messages := &MessageStore{db, NewPools(db, nil)}
+	markers := &ReadMarkerStore{db}
+	suffix := time.Now().UnixNano()
+
+	bobName := fmt.Sprintf("s%d.bob", suffix)
+	var bob, bobby int64
+	for _, user := range []struct {
+		name string
+		id   *int64
+	}{{bobName, &bob}, {bobName + "by", &bobby}} {
+		query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+		if err := db.QueryRowContext(ctx, query, user.name).Scan(user.id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := &Room{Name: fmt.Sprintf("summary-read-%d", suffix), CreatedBy: bobby}
+	empty := &Room{Name: fmt.Sprintf("summary-empty-%d", suffix), CreatedBy: bobby}
+	unread := &Room{Name: fmt.Sprintf("summary-unread-%d", suffix), CreatedBy: bobby}
+	for _, room := range []*Room{read, empty, unread} {
+		if err := rooms.Create(ctx, room); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM rooms WHERE id = ANY(ARRAY[$1, $2, $3]::bigint[])`, read.ID, empty.ID, unread.ID)
+		db.Exec(`DELETE FROM users WHERE id = ANY(ARRAY[$1, $2]::bigint[])`, bob, bobby)
+	})
+
+	post := func(room, user int64, content string) *Message {
+		t.Helper()
+		message := &Message{RoomID: room, UserID: user, Content: content}
+		if err := messages.Create(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+		return message
+	}
+
+	for _, room := range []*Room{read, empty} {
+		if err := members.Join(ctx, room.ID, bob, bob); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := post(read.ID, bobby, "hi @"+bobName)
+	if err := markers.MarkRead(ctx, bob, read.ID, 0, seen.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{
+		"again @" + bobName,             // Mention
+		"thanks @" + bobName + ".",      // Mention, the dot ends the sentence
+		"cc @" + bobName + "by",         // bobby's
+		"write to x@" + bobName,         // An address, not a mention
+		fmt.Sprintf("@s%dxbob", suffix), // Only matches if the dot in the name is a wildcard
+	} {
+		post(read.ID, bobby, content)
+	}
+	post(read.ID, bob, "me, @"+bobName)
+
+	// Sent a day before bob joined, then after
+	post(unread.ID, bobby, "before")
+	if _, err := db.ExecContext(ctx, `UPDATE messages SET created_at = NOW() - INTERVAL '1 day' WHERE room_id = $1`, unread.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := members.Join(ctx, unread.ID, bob, bob); err != nil {
+		t.Fatal(err)
+	}
+	post(unread.ID, bobby, "after @"+bobName)
+
+	summaries, err := rooms.GetUserRoomSummaries(ctx, bob, RoomListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[int64]*RoomSummary)
+	for _, summary := range summaries {
+		got[summary.ID] = summary
+	}
+	for _, tc := range []struct {
+		room             *Room
+		unread, mentions int
+		last             string
+	}{
+		{read, 5, 2, "me, @" + bobName},
+		{empty, 0, 0, ""},
+		{unread, 1, 1, "after @" + bobName},
+	} {
+		summary, ok := got[tc.room.ID]
+		if !ok {
+			t.Errorf("room %s isn't listed", tc.room.Name)
+			continue
+		}
+		if summary.UnreadCount != tc.unread || summary.MentionCount != tc.mentions {
+			t.Errorf("room %s has %d unread and %d mentions, want %d and %d", tc.room.Name, summary.UnreadCount, summary.MentionCount, tc.unread, tc.mentions)
+		}
+		if tc.last == "" && summary.LastMessage != nil || tc.last != "" && (summary.LastMessage == nil || summary.LastMessage.Preview != tc.last) {
+			t.Errorf("room %s has last message %+v, want %q", tc.room.Name, summary.LastMessage, tc.last)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// MaxRoomTags is how many tags one room may have
+const MaxRoomTags = 5
+
+// tagPattern is what a normalized tag must look like: 2-30 lowercase letters, digits or dashes
+var tagPattern = regexp.MustCompile(`^[a-z0-9-]{2,30}$`)
+
+// NormalizeTag trims and lowercases a tag and reports whether the result is valid
+func NormalizeTag(tag string) (string, bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	return tag, tagPattern.MatchString(tag)
+}
+
+// TagCount is a tag with the number of rooms that have it
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Rooms int    `json:"rooms"`
+}
+
+// ListTags returns every tag in use with its room count, most used first
+// Only rooms anyone can find are counted: deleted and invite-only rooms are left out
+func (s *RoomStore) ListTags(ctx context.Context) ([]*TagCount, error) {
+	query := `
+		SELECT rt.tag, COUNT(*)
+		FROM room_tags rt
+		INNER JOIN rooms r ON r.id = rt.room_id
+		WHERE r.deleted_at IS NULL AND r.join_policy <> $1
+		GROUP BY rt.tag
+		ORDER BY COUNT(*) DESC, rt.tag
+	`
+
+	rows, err := s.reads.query(ctx, "RoomStore.ListTags", query, JoinPolicyInvite)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make([]*TagCount, 0)
+	for rows.Next() {
+		tag := &TagCount{}
+		if err := rows.Scan(&tag.Tag, &tag.Rooms); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// replaceTags sets a room's tags to exactly tags
+// Tags must already be normalized; runs in the caller's transaction
+// room_tags is what tag filters and counts query; rooms.tags is the sorted
+// copy room reads return, written here so the two never disagree
+func replaceTags(ctx context.Context, tx *sql.Tx, roomID int64, tags []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM room_tags WHERE room_id = $1`, roomID); err != nil {
+		return err
+	}
+	if len(tags) > 0 {
+		query := `
+			INSERT INTO room_tags (room_id, tag)
+			SELECT $1, UNNEST($2::text[])
+			ON CONFLICT DO NOTHING
+		`
+		if _, err := tx.ExecContext(ctx, query, roomID, pq.Array(tags)); err != nil {
+			return err
+		}
+	}
+
+	query := `
+		UPDATE rooms SET tags = COALESCE((SELECT array_agg(tag ORDER BY tag) FROM room_tags WHERE room_id = $1), '{}')
+		WHERE id = $1
+	`
+	_, err := tx.ExecContext(ctx, query, roomID)
+	return err
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// TestNormalizeTag lowercases and trims tags and checks what's left
+func TestNormalizeTag(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{" Go-Lang ", "go-lang", true},
+		{"lfg2", "lfg2", true},
+		{"x", "x", false},
+		{"c++", "c++", false},
+		{"dev ops", "dev ops", false},
+		{"a123456789b123456789c123456789", "a123456789b123456789c123456789", true},
+		{"a123456789b123456789c123456789d", "a123456789b123456789c123456789d", false},
+	} {
+		if got, ok := NormalizeTag(tc.in); got != tc.want || ok != tc.ok {
+			t.Errorf("NormalizeTag(%q) = %q, %v; want %q, %v", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+// expectReplaceTags expects replaceTags to set room 1's tags, then copy
+// them onto the room row
+func expectReplaceTags(mock sqlmock.Sqlmock, tags []string) {
+	mock.ExpectExec(`DELETE FROM room_tags WHERE room_id = \$1`).WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, 2))
+	if len(tags) > 0 {
+		mock.ExpectExec(`INSERT INTO room_tags \(room_id, tag\)\s+SELECT \$1, UNNEST\(\$2::text\[\]\)`).
+			WithArgs(int64(1), pq.Array(tags)).WillReturnResult(sqlmock.NewResult(0, int64(len(tags))))
+	}
+	mock.ExpectExec(`UPDATE rooms SET tags = COALESCE\(\(SELECT array_agg\(tag ORDER BY tag\) FROM room_tags WHERE room_id = \$1\), '\{\}'\)`).
+		WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// TestCreateSavesTags creates a room with tags: the room, its tags and the
+// copy on the room row commit together
+func TestCreateSavesTags(t *testing.T) {
+	db, mock := newMockDB(t)
+	rooms := &RoomStore{db, Limits{}, NewPools(db, nil)}
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO rooms`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "version", "content_filter_enabled", "duplicate_limit_enabled", "post_policy"}).
+			AddRow(1, now, now, 1, true, false, "everyone"))
+	expectReplaceTags(mock, []string{"chat", "go"})
+	mock.ExpectCommit()
+
+	if err := rooms.Create(context.Background(), &Room{Name: "golang", CreatedBy: 1, Tags: []string{"chat", "go"}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestUpdateClearsTags saves a room with no tags: its tags are deleted and
+// the copy emptied, with nothing to insert
+func TestUpdateClearsTags(t *testing.T) {
+	db, mock := newMockDB(t)
+	rooms := &RoomStore{db, Limits{}, NewPools(db, nil)}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE rooms`).WillReturnRows(sqlmock.NewRows([]string{"updated_at", "version", "description", "post_policy"}).AddRow(time.Now(), 2, "", ""))
+	expectReplaceTags(mock, nil)
+	expectRoomEvent(mock, 1, RoomEventRoomUpdated, 7)
+	mock.ExpectCommit()
+
+	if err := rooms.Update(context.Background(), &Room{ID: 1, JoinPolicy: JoinPolicyOpen}, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestListLoadsTagsInOneQuery lists rooms by tag: the tags of every room
+// come back with it, from one query whatever the number of rooms
+// The mock fails the test on any query it doesn't expect, so loading tags
+// room by room would be caught
+func TestListLoadsTagsInOneQuery(t *testing.T) {
+	db, mock := newMockDB(t)
+	rooms := &RoomStore{db, Limits{}, NewPools(db, nil)}
+	now := time.Now()
+
+	rows := sqlmock.NewRows(append(roomRowColumns, "preview"))
+	for id := 1; id <= 20; id++ {
+		rows.AddRow(id, "room", "", 1, now, now, 1, false, nil, "open", nil, 1, true, false, nil, "{go,lfg}", false, "", nil, "everyone", "")
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`($1 = '' OR EXISTS (SELECT 1 FROM room_tags ft WHERE ft.room_id = r.id AND ft.tag = $1))`)).
+		WithArgs("go").WillReturnRows(rows)
+
+	listed, err := rooms.List(context.Background(), RoomListOptions{Tag: "go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 20 || !slices.Equal(listed[19].Tags, []string{"go", "lfg"}) {
+		t.Errorf("listed %d rooms, the last tagged %v; want 20 tagged [go lfg]", len(listed), listed[len(listed)-1].Tags)
+	}
+}
+
+// TestListTags counts tags over rooms that aren't deleted or invite-only
+func TestListTags(t *testing.T) {
+	db, mock := newMockDB(t)
+	rooms := &RoomStore{db, Limits{}, NewPools(db, nil)}
+
+	mock.ExpectQuery(`FROM room_tags rt\s+INNER JOIN rooms r ON r.id = rt.room_id\s+WHERE r.deleted_at IS NULL AND r.join_policy <> \$1\s+GROUP BY rt.tag`).
+		WithArgs(JoinPolicyInvite).
+		WillReturnRows(sqlmock.NewRows([]string{"tag", "count"}).AddRow("go", 12).AddRow("chat", 3))
+
+	tags, err := rooms.ListTags(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 || *tags[0] != (TagCount{Tag: "go", Rooms: 12}) || *tags[1] != (TagCount{Tag: "chat", Rooms: 3}) {
+		t.Errorf("got %v, want go 12 and chat 3", tags)
+	}
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// RoomTemplate is a named set of room settings its owner can create rooms from
+// Settings is kept as the JSON object it was saved with; the API validates it
+// on save and decodes it again on use
+type RoomTemplate struct {
+	ID        int64           `json:"id"`
+	OwnerID   int64           `json:"owner_id"`
+	Name      string          `json:"name"`
+	Settings  json.RawMessage `json:"settings"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// RoomTemplateStore handles database operations for room templates
+type RoomTemplateStore struct {
+	db *sql.DB
+}
+
+// roomTemplateColumns lists the columns selected for every RoomTemplate query
+const roomTemplateColumns = `id, owner_id, name, settings, created_at`
+
+// scanRoomTemplate scans a row selected with roomTemplateColumns
+func scanRoomTemplate(row rowScanner) (*RoomTemplate, error) {
+	template := &RoomTemplate{}
+	var settings []byte
+	if err := row.Scan(&template.ID, &template.OwnerID, &template.Name, &settings, &template.CreatedAt); err != nil {
+		return nil, err
+	}
+	template.Settings = settings
+	return template, nil
+}
+
+// Create saves a new template and fills in its ID and creation time
+// Names are unique per owner; a duplicate fails with a unique violation
+func (s *RoomTemplateStore) Create(ctx context.Context, template *RoomTemplate) error {
+	query := `
+		INSERT INTO room_templates (owner_id, name, settings)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`
+	return s.db.QueryRowContext(ctx, query, template.OwnerID, template.Name, []byte(template.Settings)).
+		Scan(&template.ID, &template.CreatedAt)
+}
+
+// GetByID returns one of a user's templates
+// Returns sql.ErrNoRows if the template doesn't exist or belongs to someone else
+func (s *RoomTemplateStore) GetByID(ctx context.Context, id, ownerID int64) (*RoomTemplate, error) {
+	query := `SELECT ` + roomTemplateColumns + ` FROM room_templates WHERE id = $1 AND owner_id = $2`
+	return scanRoomTemplate(s.db.QueryRowContext(ctx, query, id, ownerID))
+}
+
+// ListForOwner returns a user's templates sorted by name
+func (s *RoomTemplateStore) ListForOwner(ctx context.Context, ownerID int64) ([]*RoomTemplate, error) {
+	query := `SELECT ` + roomTemplateColumns + ` FROM room_templates WHERE owner_id = $1 ORDER BY name`
+
+	rows, err := s.db.QueryContext(ctx, query, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := make([]*RoomTemplate, 0)
+	for rows.Next() {
+		template, err := scanRoomTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
@@ -0,0 +1,75 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestCreateWithMembers creates a room from template settings on the scratch
+// database: the settings, tags, creator and members land together, and a
+// creator already at their room quota leaves no room behind
+func TestCreateWithMembers(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	suffix := time.Now().UnixNano()
+
+	var ids []int64
+	for _, name := range []string{"owner", "member"} {
+		var id int64
+		query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+		if err := db.QueryRowContext(ctx, query, fmt.Sprintf("%s-%d", name, suffix)).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	owner, member := ids[0], ids[1]
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM rooms WHERE created_by = $1`, owner)
+		db.Exec(`DELETE FROM users WHERE id = ANY(ARRAY[$1, $2]::bigint[])`, owner, member)
+	})
+
+	rooms := &RoomStore{db, Limits{MaxRoomMembers: 100, MaxRoomsPerUser: 1}, NewPools(db, nil)}
+	room := NewRoom(fmt.Sprintf("standup-%d", suffix), owner)
+	room.JoinPolicy = JoinPolicyInvite
+	room.Tags = []string{"team"}
+	results, err := rooms.CreateWithMembers(ctx, room, []int64{member})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int64]string{member: BulkAdded}; !maps.Equal(results, want) {
+		t.Errorf("the outcomes are %v, want %v", results, want)
+	}
+	if room.MemberCount != 2 {
+		t.Errorf("the room has %d members, want 2", room.MemberCount)
+	}
+	saved, err := rooms.GetByID(ctx, room.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.JoinPolicy != JoinPolicyInvite || !slices.Equal(saved.Tags, []string{"team"}) {
+		t.Errorf("the saved room is %+v, want the template's settings", saved)
+	}
+	var role string
+	if err := db.QueryRowContext(ctx, `SELECT role FROM room_members WHERE room_id = $1 AND user_id = $2`, room.ID, owner).Scan(&role); err != nil || role != RoomRoleAdmin {
+		t.Errorf("the creator's role is %q (%v), want admin", role, err)
+	}
+
+	// The owner is now in their one allowed room
+	second := NewRoom(fmt.Sprintf("retro-%d", suffix), owner)
+	if _, err := rooms.CreateWithMembers(ctx, second, []int64{member}); !errors.Is(err, ErrTooManyRooms) {
+		t.Fatalf("creating past the quota got %v, want ErrTooManyRooms", err)
+	}
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM rooms WHERE name = $1`, second.Name).Scan(&count); err != nil || count != 0 {
+		t.Errorf("found %d rooms named %q (%v), want the creation rolled back", count, second.Name, err)
+	}
+}
@@ -3,128 +3,596 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
 	"time"
+
+	"github.com/drazan344/go-chat/internal/schedule"
+	"github.com/lib/pq"
 )
 
+// ErrVersionConflict is returned by conditional updates when the row changed
+// since the version the caller read (optimistic locking)
+var ErrVersionConflict = errors.New("resource changed since it was read")
+
 // Room represents a chat room where users can send messages
 // Rooms are created by users and can be joined by other users
 type Room struct {
 	ID          int64     `json:"id"`
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
-	CreatedBy   int64     `json:"created_by"`
+	CreatedBy   int64     `json:"created_by"` // 0 once the creator's account is deleted; the room stays
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Version is bumped on every Update; clients send it back to update safely
+	Version int64 `json:"version"`
+
+	// IsPublicReadonly lets anonymous guests watch the room's live feed
+	// and read recent history without registering
+	IsPublicReadonly bool `json:"is_public_readonly"`
+
+	// LastMessageAt is when the most recent message was posted (nil if none yet)
+	// It's maintained by MessageStore.Create so sidebars can sort by activity
+	LastMessageAt *time.Time `json:"last_message_at"`
+
+	// LastMessagePreview is the first 80 characters of the most recent message
+	LastMessagePreview string `json:"last_message_preview,omitempty"`
+
+	// JoinPolicy controls how users become members (one of the JoinPolicy constants)
+	JoinPolicy string `json:"join_policy"`
+
+	// MemberCount is the current number of members
+	MemberCount int `json:"member_count"`
+
+	// MaxMembers is the member limit in effect for this room, so clients can
+	// show "room full" before trying to join
+	MaxMembers int `json:"max_members"`
+
+	// MaxMembersOverride is the creator's per-room limit, nil to use the global limit
+	MaxMembersOverride *int `json:"-"`
+
+	// ContentFilterEnabled runs messages through the server's content filter
+	ContentFilterEnabled bool `json:"content_filter_enabled"`
+
+	// DuplicateLimitEnabled rejects a user's repeated identical messages
+	DuplicateLimitEnabled bool `json:"duplicate_limit_enabled"`
+
+	// QuietHours is the weekly window in which only the creator and admins can
+	// post; nil when the room has none
+	QuietHours *schedule.Window `json:"quiet_hours"`
+
+	// QuietNow is whether quiet hours are in effect, worked out when the room is read
+	QuietNow bool `json:"quiet_now"`
+
+	// Tags are the room's topics, lowercase and sorted (at most MaxRoomTags)
+	// Create and Update save them along with the room
+	Tags []string `json:"tags"`
+
+	// IsDefault rooms are joined by every new account on registration
+	// Set by platform admins with SetDefault
+	IsDefault bool `json:"is_default"`
+
+	// Language is the BCP 47 tag system messages are shown in; empty uses the
+	// server's default (DEFAULT_ROOM_LANGUAGE)
+	Language string `json:"language,omitempty"`
+
+	// RetentionSeconds is how long the room keeps its messages, nil to use the
+	// server's MESSAGE_RETENTION; 0 keeps them forever
+	RetentionSeconds *int64 `json:"retention_seconds"`
+
+	// EffectiveRetentionSeconds is the retention in effect, so clients can show
+	// "messages disappear after 24 hours"; 0 when messages are kept forever
+	EffectiveRetentionSeconds int64 `json:"effective_retention_seconds"`
+
+	// PostPolicy is who may send messages (one of the PostPolicy constants);
+	// clients disable their input box for members who can't
+	PostPolicy string `json:"post_policy"`
+}
+
+// Join policies accepted by Room.JoinPolicy
+const (
+	JoinPolicyOpen     = "open"     // Anyone can join directly
+	JoinPolicyApproval = "approval" // Joining creates a request that an admin must approve
+	JoinPolicyInvite   = "invite"   // Users can't join on their own
+)
+
+// ValidJoinPolicy reports whether policy is one of the JoinPolicy constants
+func ValidJoinPolicy(policy string) bool {
+	return policy == JoinPolicyOpen || policy == JoinPolicyApproval || policy == JoinPolicyInvite
+}
+
+// Post policies accepted by Room.PostPolicy
+const (
+	PostPolicyEveryone   = "everyone"    // Whoever has post_message may post (the default)
+	PostPolicyAdminsOnly = "admins_only" // Only the owner and admins may post: an announcement channel
+)
+
+// ValidPostPolicy reports whether policy is one of the PostPolicy constants
+func ValidPostPolicy(policy string) bool {
+	return policy == PostPolicyEveryone || policy == PostPolicyAdminsOnly
+}
+
+// PostPolicyAllows reports whether a user with the given room role may post
+// under a room's post policy; post_message is checked separately
+func PostPolicyAllows(policy, role string) bool {
+	return policy != PostPolicyAdminsOnly || role == RoomRoleOwner || role == RoomRoleAdmin
+}
+
+// Sort orders accepted by RoomListOptions
+const (
+	RoomSortCreated  = "created"  // Newest rooms first (default)
+	RoomSortActivity = "activity" // Most recently active rooms first
+)
+
+// RoomListOptions controls how room listings are filtered and ordered
+type RoomListOptions struct {
+	Sort string // One of the RoomSort constants; empty means RoomSortCreated
+	Tag  string // Only list rooms with this (normalized) tag; empty lists all
+}
+
+// tagFilter returns a WHERE condition for the Tag option, using placeholder $n
+// The condition is always there, so queries keep the same number of arguments
+func (o RoomListOptions) tagFilter(n int) string {
+	placeholder := "$" + strconv.Itoa(n)
+	return "(" + placeholder + " = '' OR EXISTS (SELECT 1 FROM room_tags ft WHERE ft.room_id = r.id AND ft.tag = " + placeholder + "))"
+}
+
+// roomSortOrders maps the RoomSort constants to their ORDER BY clauses
+var roomSortOrders = sortOrders{
+	clauses: map[string]string{
+		RoomSortCreated: "ORDER BY r.created_at DESC",
+		// Rooms that never had a message go last, newest first among themselves
+		RoomSortActivity: "ORDER BY r.last_message_at DESC NULLS LAST, r.created_at DESC",
+	},
+	fallback: "ORDER BY r.created_at DESC",
+}
+
+// ValidRoomSort reports whether sort is one of the RoomSort constants
+func ValidRoomSort(sort string) bool {
+	return roomSortOrders.valid(sort)
+}
+
+// orderBy returns the ORDER BY clause for the requested sort
+// Only fixed strings are ever returned, so the result is safe to put in SQL
+func (o RoomListOptions) orderBy() string {
+	return roomSortOrders.clause(o.Sort)
+}
+
+// roomColumns lists the columns selected for every Room query
+// Keeping them in one place means adding a column only requires updating
+// this list and Room.scanTargets, instead of every query in this file
+// The "r." prefix lets the same list be used in queries that join other tables
+const roomColumns = `r.id, r.name, r.description, COALESCE(r.created_by, 0), r.created_at, r.updated_at, r.version,
+		r.is_public_readonly, r.last_message_at, r.join_policy, r.max_members, r.member_count,
+		r.content_filter_enabled, r.duplicate_limit_enabled, r.quiet_hours, r.tags, r.is_default, COALESCE(r.language, ''), r.retention_seconds,
+		r.post_policy`
+
+// roomPreviewColumn follows roomColumns in the room listings, which show the
+// start of each room's newest message; roomPreviewJoin goes with it
+// Reading a single room leaves it out, so GetByID stays a primary key lookup
+// The newest message is the one with the highest ID, since created_at can tie
+const (
+	roomPreviewColumn = `COALESCE(lm.preview, '')`
+	roomPreviewJoin   = `
+		LEFT JOIN LATERAL (
+			SELECT LEFT(m.content, 80) AS preview FROM messages m
+			WHERE m.room_id = r.id
+			ORDER BY m.id DESC
+			LIMIT 1
+		) lm ON true`
+)
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+// It allows a single scan function to serve QueryRow and Query results
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanRoom scans a row selected with roomColumns into a Room
+func scanRoom(row rowScanner) (*Room, error) {
+	room := &Room{}
+	if err := row.Scan(room.scanTargets()...); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+// scanTargets returns pointers to the fields matching roomColumns, in order
+// Queries selecting extra columns after roomColumns append their own targets
+func (room *Room) scanTargets() []interface{} {
+	return []interface{}{
+		&room.ID,
+		&room.Name,
+		&room.Description,
+		&room.CreatedBy,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+		&room.Version,
+		&room.IsPublicReadonly,
+		&room.LastMessageAt,
+		&room.JoinPolicy,
+		&room.MaxMembersOverride,
+		&room.MemberCount,
+		&room.ContentFilterEnabled,
+		&room.DuplicateLimitEnabled,
+		quietHoursColumn{&room.QuietHours},
+		pq.Array(&room.Tags),
+		&room.IsDefault,
+		&room.Language,
+		&room.RetentionSeconds,
+		&room.PostPolicy,
+	}
+}
+
+// scanRoomWithPreview scans a row selected with roomColumns and roomPreviewColumn
+func scanRoomWithPreview(row rowScanner) (*Room, error) {
+	room := &Room{}
+	if err := row.Scan(append(room.scanTargets(), &room.LastMessagePreview)...); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+// quietHoursColumn scans the quiet_hours JSON column into a validated window
+type quietHoursColumn struct {
+	dest **schedule.Window
+}
+
+func (c quietHoursColumn) Scan(src interface{}) error {
+	*c.dest = nil
+	if src == nil {
+		return nil
+	}
+	raw, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("quiet_hours: unexpected type %T", src)
+	}
+
+	window := &schedule.Window{}
+	if err := json.Unmarshal(raw, window); err != nil {
+		return fmt.Errorf("quiet_hours: %w", err)
+	}
+	if err := window.Validate(); err != nil {
+		return fmt.Errorf("quiet_hours: %w", err)
+	}
+	*c.dest = window
+	return nil
+}
+
+// quietHoursValue converts a window into the value stored in quiet_hours
+func quietHoursValue(window *schedule.Window) (interface{}, error) {
+	if window == nil {
+		return nil, nil
+	}
+	return json.Marshal(window)
 }
 
 // RoomStore handles database operations for rooms
 // It follows the repository pattern for clean separation of data access logic
 type RoomStore struct {
-	db *sql.DB
+	db     *sql.DB
+	limits Limits
+	reads  *Pools // The tag listing may run on the read replica
 }
 
 // Create creates a new chat room in the database
 // It returns the generated ID and timestamps via the RETURNING clause
+// The room's tags are saved in the same transaction
 func (s *RoomStore) Create(ctx context.Context, room *Room) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
 	query := `
-		INSERT INTO rooms (name, description, created_by)
-		VALUES ($1, $2, $3) RETURNING id, created_at, updated_at
+		INSERT INTO rooms (name, description, created_by, is_public_readonly, join_policy)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, updated_at, version, content_filter_enabled, duplicate_limit_enabled, post_policy
 	`
 
+	// Rooms are open unless the creator says otherwise
+	if room.JoinPolicy == "" {
+		room.JoinPolicy = JoinPolicyOpen
+	}
+
 	// QueryRowContext executes the query and scans the result in one operation
 	// Context allows for timeout and cancellation
-	err := s.db.QueryRowContext(
+	err = tx.QueryRowContext(
 		ctx,
 		query,
 		room.Name,
 		room.Description,
 		room.CreatedBy,
+		room.IsPublicReadonly,
+		room.JoinPolicy,
 	).Scan(
 		&room.ID,
 		&room.CreatedAt,
 		&room.UpdatedAt,
+		&room.Version,
+		&room.ContentFilterEnabled,
+		&room.DuplicateLimitEnabled,
+		&room.PostPolicy,
 	)
 	if err != nil {
 		return err
 	}
+
+	if room.Tags == nil {
+		room.Tags = []string{}
+	}
+	if err := replaceTags(ctx, tx, room.ID, room.Tags); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.fillComputed(room)
 	return nil
 }
 
+// NewRoom returns a room with the settings the rooms table gives a new room,
+// for callers that change some of them before CreateWithMembers
+func NewRoom(name string, createdBy int64) *Room {
+	return &Room{
+		Name:                 name,
+		CreatedBy:            createdBy,
+		JoinPolicy:           JoinPolicyOpen,
+		ContentFilterEnabled: true,
+		Tags:                 []string{},
+		PostPolicy:           PostPolicyEveryone,
+	}
+}
+
+// CreateWithMembers creates a room with all of its settings, makes the creator
+// its first admin and adds memberIDs as members, in one transaction
+// The members are added as by RoomMemberStore.AddMembers, with the creator as
+// the actor, so one that can't join is skipped rather than failing the room
+// Returns each member's outcome (see the Bulk* constants); the creator failing
+// to join (ErrTooManyRooms) fails the whole creation
+func (s *RoomStore) CreateWithMembers(ctx context.Context, room *Room, memberIDs []int64) (map[int64]string, error) {
+	quietHours, err := quietHoursValue(room.QuietHours)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO rooms (name, description, created_by, is_public_readonly, join_policy, max_members,
+			content_filter_enabled, duplicate_limit_enabled, quiet_hours, language, retention_seconds, post_policy)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''), $11, $12)
+		RETURNING id, created_at, updated_at, version
+	`
+	err = tx.QueryRowContext(
+		ctx,
+		query,
+		room.Name,
+		room.Description,
+		room.CreatedBy,
+		room.IsPublicReadonly,
+		room.JoinPolicy,
+		room.MaxMembersOverride,
+		room.ContentFilterEnabled,
+		room.DuplicateLimitEnabled,
+		quietHours,
+		room.Language,
+		room.RetentionSeconds,
+		room.PostPolicy,
+	).Scan(&room.ID, &room.CreatedAt, &room.UpdatedAt, &room.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if room.Tags == nil {
+		room.Tags = []string{}
+	}
+	if err := replaceTags(ctx, tx, room.ID, room.Tags); err != nil {
+		return nil, err
+	}
+
+	creator := JoinOptions{Role: RoomRoleAdmin, ActorID: room.CreatedBy}
+	if err := addMember(ctx, tx, s.limits, room.ID, room.CreatedBy, creator); err != nil {
+		return nil, err
+	}
+
+	results := make(map[int64]string, len(memberIDs))
+	if len(memberIDs) > 0 {
+		results, err = addMembers(ctx, tx, s.limits, room.ID, memberIDs, room.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM room_members WHERE room_id = $1`, room.ID).Scan(&room.MemberCount); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.fillComputed(room)
+	return results, nil
+}
+
 // GetByID retrieves a room by its ID
 func (s *RoomStore) GetByID(ctx context.Context, id int64) (*Room, error) {
 	query := `
-		SELECT id, name, description, created_by, created_at, updated_at
-		FROM rooms
-		WHERE id = $1
+		SELECT ` + roomColumns + `
+		FROM rooms r
+		WHERE r.id = $1 AND r.deleted_at IS NULL
 	`
 
-	room := &Room{}
-	err := s.db.QueryRowContext(ctx, query, id).Scan(
-		&room.ID,
-		&room.Name,
-		&room.Description,
-		&room.CreatedBy,
-		&room.CreatedAt,
-		&room.UpdatedAt,
-	)
-	if err != nil {
-		return nil, err
+	return s.withLimits(scanRoom(s.db.QueryRowContext(ctx, query, id)))
+}
+
+// IsContentFilterEnabled reports whether messages in a room go through the content filter
+// It reads a single column, so it's cheap enough to call for every message
+func (s *RoomStore) IsContentFilterEnabled(ctx context.Context, id int64) (bool, error) {
+	query := `SELECT content_filter_enabled FROM rooms WHERE id = $1 AND deleted_at IS NULL`
+
+	var enabled bool
+	if err := s.db.QueryRowContext(ctx, query, id).Scan(&enabled); err != nil {
+		return false, err
 	}
-	return room, nil
+	return enabled, nil
+}
+
+// GetQuietHours returns a room's quiet hours (nil if it has none) and its creator
+// (0 if the creator's account is deleted)
+// The hub calls it to decide who may post, caching the result per room
+func (s *RoomStore) GetQuietHours(ctx context.Context, id int64) (*schedule.Window, int64, error) {
+	query := `SELECT quiet_hours, COALESCE(created_by, 0) FROM rooms WHERE id = $1 AND deleted_at IS NULL`
+
+	var window *schedule.Window
+	var createdBy int64
+	if err := s.db.QueryRowContext(ctx, query, id).Scan(quietHoursColumn{&window}, &createdBy); err != nil {
+		return nil, 0, err
+	}
+	return window, createdBy, nil
+}
+
+// GetLanguage returns a room's language setting ("" for the server default)
+// The hub calls it to render system messages, caching the result per room
+func (s *RoomStore) GetLanguage(ctx context.Context, id int64) (string, error) {
+	var language string
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(language, '') FROM rooms WHERE id = $1`, id).Scan(&language)
+	return language, err
+}
+
+// GetPostPolicy returns who may send messages in a room (a PostPolicy constant)
+// The hub calls it to refuse members' messages in announcement rooms,
+// caching the result per room
+func (s *RoomStore) GetPostPolicy(ctx context.Context, id int64) (string, error) {
+	var policy string
+	err := s.db.QueryRowContext(ctx, `SELECT post_policy FROM rooms WHERE id = $1 AND deleted_at IS NULL`, id).Scan(&policy)
+	return policy, err
+}
+
+// SetDefault flags or unflags a room as a default room for new accounts
+// Unflagging doesn't touch existing memberships
+// Returns sql.ErrNoRows if the room doesn't exist or is deleted
+func (s *RoomStore) SetDefault(ctx context.Context, id int64, isDefault bool) error {
+	query := `UPDATE rooms SET is_default = $2 WHERE id = $1 AND deleted_at IS NULL`
+	return expectOneRow(s.db.ExecContext(ctx, query, id, isDefault))
+}
+
+// IsDuplicateLimitEnabled reports whether a room rejects repeated identical messages
+func (s *RoomStore) IsDuplicateLimitEnabled(ctx context.Context, id int64) (bool, error) {
+	query := `SELECT duplicate_limit_enabled FROM rooms WHERE id = $1 AND deleted_at IS NULL`
+
+	var enabled bool
+	if err := s.db.QueryRowContext(ctx, query, id).Scan(&enabled); err != nil {
+		return false, err
+	}
+	return enabled, nil
 }
 
 // GetByName retrieves a room by its name
 // Room names are unique, so this will return at most one room
 func (s *RoomStore) GetByName(ctx context.Context, name string) (*Room, error) {
 	query := `
-		SELECT id, name, description, created_by, created_at, updated_at
-		FROM rooms
-		WHERE name = $1
+		SELECT ` + roomColumns + `
+		FROM rooms r
+		WHERE r.name = $1 AND r.deleted_at IS NULL
 	`
 
-	room := &Room{}
-	err := s.db.QueryRowContext(ctx, query, name).Scan(
-		&room.ID,
-		&room.Name,
-		&room.Description,
-		&room.CreatedBy,
-		&room.CreatedAt,
-		&room.UpdatedAt,
-	)
-	if err != nil {
-		return nil, err
-	}
-	return room, nil
+	return s.withLimits(scanRoom(s.db.QueryRowContext(ctx, query, name)))
 }
 
 // List retrieves all rooms from the database
-// Returns rooms ordered by creation time (newest first)
-func (s *RoomStore) List(ctx context.Context) ([]*Room, error) {
+// Returns rooms ordered by creation time (newest first) or by last activity
+func (s *RoomStore) List(ctx context.Context, opts RoomListOptions) ([]*Room, error) {
 	query := `
-		SELECT id, name, description, created_by, created_at, updated_at
-		FROM rooms
-		ORDER BY created_at DESC
-	`
+		SELECT ` + roomColumns + `, ` + roomPreviewColumn + `
+		FROM rooms r` + roomPreviewJoin + `
+		WHERE r.deleted_at IS NULL AND ` + opts.tagFilter(1) + `
+		` + opts.orderBy()
 
 	// Query returns multiple rows, unlike QueryRow
-	rows, err := s.db.QueryContext(ctx, query)
+	rows, err := s.reads.query(ctx, "RoomStore.List", query, opts.Tag)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close() // Important: always close rows to free resources
 
+	return s.withLimitsAll(scanRoomsWith(rows, scanRoomWithPreview))
+}
+
+// GetUserRooms retrieves all rooms that a user has joined
+// This joins the rooms and room_members tables
+func (s *RoomStore) GetUserRooms(ctx context.Context, userID int64, opts RoomListOptions) ([]*Room, error) {
+	query := `
+		SELECT ` + roomColumns + `, ` + roomPreviewColumn + `
+		FROM rooms r
+		INNER JOIN room_members rm ON r.id = rm.room_id` + roomPreviewJoin + `
+		WHERE rm.user_id = $1 AND r.deleted_at IS NULL AND ` + opts.tagFilter(2) + `
+		` + opts.orderBy()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, opts.Tag)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return s.withLimitsAll(scanRoomsWith(rows, scanRoomWithPreview))
+}
+
+// fillComputed fills in the fields worked out at read time rather than stored:
+// the effective member limit and whether quiet hours are in effect
+func (s *RoomStore) fillComputed(room *Room) {
+	fillRoomComputed(room, s.limits)
+}
+
+// fillRoomComputed is fillComputed for stores that load rooms themselves
+func fillRoomComputed(room *Room, limits Limits) {
+	room.MaxMembers = limits.roomMemberLimit(room.MaxMembersOverride)
+	room.QuietNow = room.QuietHours.Active(time.Now())
+	room.EffectiveRetentionSeconds = int64(limits.retention(room.RetentionSeconds).Seconds())
+}
+
+// withLimits fills in the room's computed fields (see fillComputed)
+// It takes scanRoom's results directly so call sites stay one line
+func (s *RoomStore) withLimits(room *Room, err error) (*Room, error) {
+	if err != nil {
+		return nil, err
+	}
+	s.fillComputed(room)
+	return room, nil
+}
+
+// withLimitsAll is withLimits for a list of rooms
+func (s *RoomStore) withLimitsAll(rooms []*Room, err error) ([]*Room, error) {
+	if err != nil {
+		return nil, err
+	}
+	for _, room := range rooms {
+		s.fillComputed(room)
+	}
+	return rooms, nil
+}
+
+// scanRooms collects every row of a room query into a slice
+// The caller is responsible for closing rows
+func scanRooms(rows *sql.Rows) ([]*Room, error) {
+	return scanRoomsWith(rows, scanRoom)
+}
+
+// scanRoomsWith is scanRooms for queries selecting more than roomColumns
+func scanRoomsWith(rows *sql.Rows, scan func(rowScanner) (*Room, error)) ([]*Room, error) {
 	rooms := make([]*Room, 0)
 	// Iterate through each row in the result set
 	for rows.Next() {
-		room := &Room{}
-		err := rows.Scan(
-			&room.ID,
-			&room.Name,
-			&room.Description,
-			&room.CreatedBy,
-			&room.CreatedAt,
-			&room.UpdatedAt,
-		)
+		room, err := scan(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -132,56 +600,217 @@ func (s *RoomStore) List(ctx context.Context) ([]*Room, error) {
 	}
 
 	// Check for errors that occurred during iteration
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
 	return rooms, nil
 }
 
-// GetUserRooms retrieves all rooms that a user has joined
-// This joins the rooms and room_members tables
-func (s *RoomStore) GetUserRooms(ctx context.Context, userID int64) ([]*Room, error) {
+// Update saves the editable settings of a room, tags included
+// updated_at and version are bumped so clients can tell when the room last changed,
+// and a room_updated event is logged
+// With a non-zero expectedVersion the update only happens if the room is still
+// at that version, else it returns ErrVersionConflict; zero means last write wins
+func (s *RoomStore) Update(ctx context.Context, room *Room, expectedVersion int64) error {
+	quietHours, err := quietHoursValue(room.QuietHours)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// previous reads the row as it was before the update, for the event below
 	query := `
-		SELECT r.id, r.name, r.description, r.created_by, r.created_at, r.updated_at
-		FROM rooms r
-		INNER JOIN room_members rm ON r.id = rm.room_id
-		WHERE rm.user_id = $1
-		ORDER BY r.created_at DESC
+		WITH previous AS (SELECT description, post_policy FROM rooms WHERE id = $8)
+		UPDATE rooms
+		SET description = $1, is_public_readonly = $2, join_policy = $3, max_members = $4,
+			content_filter_enabled = $5, duplicate_limit_enabled = $6, quiet_hours = $7, language = NULLIF($10, ''),
+			retention_seconds = $11, post_policy = $12, updated_at = NOW(), version = version + 1
+		WHERE id = $8 AND deleted_at IS NULL AND ($9::bigint = 0 OR version = $9)
+		RETURNING updated_at, version, (SELECT COALESCE(description, '') FROM previous), (SELECT post_policy FROM previous)
 	`
 
-	rows, err := s.db.QueryContext(ctx, query, userID)
+	var previousDescription, previousPostPolicy string
+
+	err = tx.QueryRowContext(
+		ctx,
+		query,
+		room.Description,
+		room.IsPublicReadonly,
+		room.JoinPolicy,
+		room.MaxMembersOverride,
+		room.ContentFilterEnabled,
+		room.DuplicateLimitEnabled,
+		quietHours,
+		room.ID,
+		expectedVersion,
+		room.Language,
+		room.RetentionSeconds,
+		room.PostPolicy,
+	).Scan(&room.UpdatedAt, &room.Version, &previousDescription, &previousPostPolicy)
+	if errors.Is(err, sql.ErrNoRows) && expectedVersion != 0 {
+		return versionConflict(ctx, tx, "rooms", room.ID)
+	}
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	rooms := make([]*Room, 0)
-	for rows.Next() {
-		room := &Room{}
-		err := rows.Scan(
-			&room.ID,
-			&room.Name,
-			&room.Description,
-			&room.CreatedBy,
-			&room.CreatedAt,
-			&room.UpdatedAt,
+	if err := replaceTags(ctx, tx, room.ID, room.Tags); err != nil {
+		return err
+	}
+
+	// Compact on purpose: clients reload the room when they see a newer version
+	// A new description is included, as outgoing webhooks send it on (topic_changed),
+	// and a new post policy, so replaying clients enable or disable their input box
+	payload := map[string]interface{}{"version": room.Version}
+	if room.Description != previousDescription {
+		payload["description"] = room.Description
+	}
+	if room.PostPolicy != previousPostPolicy {
+		payload["post_policy"] = room.PostPolicy
+	}
+	if _, err := appendRoomEvent(ctx, tx, room.ID, RoomEventRoomUpdated, payload); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.fillComputed(room)
+	return nil
+}
+
+// CountCreatedSince counts the rooms a user created after since; a room
+// created exactly at since no longer counts. since is taken from the
+// caller's clock, so the window is the API's and not the database's
+// Deleted rooms still count until they're purged: deleting a room doesn't
+// give back a creation. Also returns when the oldest of them was created
+// (zero when there are none)
+func (s *RoomStore) CountCreatedSince(ctx context.Context, userID int64, since time.Time) (int, time.Time, error) {
+	query := `
+		SELECT COUNT(*), MIN(created_at)
+		FROM rooms
+		WHERE created_by = $1 AND created_at > $2
+	`
+
+	var (
+		count  int
+		oldest sql.NullTime
+	)
+	if err := s.db.QueryRowContext(ctx, query, userID, since).Scan(&count, &oldest); err != nil {
+		return 0, time.Time{}, err
+	}
+	return count, oldest.Time, nil
+}
+
+// CountOwnedActive counts the rooms a user created that aren't deleted
+// A deleted room stops counting at once, though it can still be restored
+func (s *RoomStore) CountOwnedActive(ctx context.Context, userID int64) (int, error) {
+	query := `SELECT COUNT(*) FROM rooms WHERE created_by = $1 AND deleted_at IS NULL`
+
+	var count int
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// SoftDelete marks a room deleted
+// The room disappears from every lookup, but its messages and memberships stay
+// so Restore can bring it back until PurgeExpired removes it
+// Returns sql.ErrNoRows if the room doesn't exist or is already deleted
+func (s *RoomStore) SoftDelete(ctx context.Context, id int64) error {
+	query := `UPDATE rooms SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`
+	return expectOneRow(s.db.ExecContext(ctx, query, id))
+}
+
+// GetDeletedByID retrieves a soft-deleted room that is still within the restore window
+// Returns sql.ErrNoRows for rooms that aren't deleted or can no longer be restored
+// (including rooms merged into another room)
+func (s *RoomStore) GetDeletedByID(ctx context.Context, id int64, window time.Duration) (*Room, error) {
+	query := `
+		SELECT ` + roomColumns + `
+		FROM rooms r
+		WHERE r.id = $1 AND r.deleted_at > NOW() - make_interval(secs => $2)
+		  AND r.merged_into IS NULL
+	`
+
+	return s.withLimits(scanRoom(s.db.QueryRowContext(ctx, query, id, window.Seconds())))
+}
+
+// Restore undoes SoftDelete if the room was deleted within the window
+// Memberships were never removed, so every member is back as before
+// The version is bumped, so a deletion confirmed for the room before it was
+// deleted can't be replayed on it
+// Merged rooms gave their messages and members away, so they can't be restored
+// Returns sql.ErrNoRows if there's nothing to restore
+func (s *RoomStore) Restore(ctx context.Context, id int64, window time.Duration) error {
+	query := `
+		UPDATE rooms SET deleted_at = NULL, version = version + 1
+		WHERE id = $1 AND deleted_at > NOW() - make_interval(secs => $2)
+		  AND merged_into IS NULL
+	`
+	return expectOneRow(s.db.ExecContext(ctx, query, id, window.Seconds()))
+}
+
+// PurgeExpired permanently deletes up to batchSize rooms that were soft-deleted
+// longer ago than the window, returning how many were removed
+// Rooms are purged in small batches since CASCADE also deletes all their messages;
+// call it repeatedly until it returns fewer than batchSize
+func (s *RoomStore) PurgeExpired(ctx context.Context, window time.Duration, batchSize int) (int64, error) {
+	query := `
+		DELETE FROM rooms
+		WHERE id IN (
+			SELECT id FROM rooms
+			WHERE deleted_at <= NOW() - make_interval(secs => $1)
+			ORDER BY deleted_at
+			LIMIT $2
 		)
-		if err != nil {
-			return nil, err
-		}
-		rooms = append(rooms, room)
+	`
+
+	result, err := s.db.ExecContext(ctx, query, window.Seconds(), batchSize)
+	if err != nil {
+		return 0, err
 	}
+	return result.RowsAffected()
+}
 
-	if err = rows.Err(); err != nil {
-		return nil, err
+// versionConflict explains why a conditional update matched no row
+// If the row exists it was at another version (ErrVersionConflict); otherwise it's gone
+// table must be a constant, never user input
+func versionConflict(ctx context.Context, tx *sql.Tx, table string, id int64) error {
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM `+table+` WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return err
 	}
+	if exists {
+		return ErrVersionConflict
+	}
+	return sql.ErrNoRows
+}
 
-	return rooms, nil
+// expectOneRow turns an UPDATE that matched nothing into sql.ErrNoRows
+func expectOneRow(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
 
-// Delete deletes a room by its ID
+// Delete permanently deletes a room by its ID
 // CASCADE will automatically delete related messages and room_members
+// Users delete rooms with SoftDelete; this is for cleaning up a room that was never used
 func (s *RoomStore) Delete(ctx context.Context, id int64) error {
 	query := `DELETE FROM rooms WHERE id = $1`
 	_, err := s.db.ExecContext(ctx, query, id)
@@ -0,0 +1,277 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// roomRowColumns are the columns of a roomColumns row
+var roomRowColumns = []string{"id", "name", "description", "created_by", "created_at", "updated_at", "version", "is_public_readonly", "last_message_at", "join_policy", "max_members", "member_count", "content_filter_enabled", "duplicate_limit_enabled", "quiet_hours", "tags", "is_default", "language", "retention_seconds", "post_policy"}
+
+// TestListByActivity lists rooms by last activity: the order comes from
+// last_message_at with quiet rooms last, and each room carries the start of
+// its newest message
+func TestListByActivity(t *testing.T) {
+	db, mock := newMockDB(t)
+	rooms := &RoomStore{db, Limits{}, NewPools(db, nil)}
+	now := time.Now()
+
+	mock.ExpectQuery(regexp.QuoteMeta("ORDER BY r.last_message_at DESC NULLS LAST, r.created_at DESC")).
+		WillReturnRows(sqlmock.NewRows(append(roomRowColumns, "preview")).
+			AddRow(2, "busy", "", 1, now, now, 1, false, now, "open", nil, 4, true, false, nil, "{}", false, "", nil, "everyone", "see you at noon").
+			AddRow(1, "quiet", "", 1, now, now, 1, false, nil, "open", nil, 1, true, false, nil, "{}", false, "", nil, "everyone", ""))
+
+	listed, err := rooms.List(context.Background(), RoomListOptions{Sort: RoomSortActivity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 2 || listed[0].LastMessagePreview != "see you at noon" || listed[1].LastMessageAt != nil {
+		t.Errorf("listed %+v, want busy with its preview, then quiet", listed)
+	}
+}
+
+// TestGetByIDReadsTheRoomRow reads a room whose own member limit is above a
+// lowered global cap: the cap wins, and the member count comes from the
+// room row rather than from counting room_members (the query has no
+// subquery)
+func TestGetByIDReadsTheRoomRow(t *testing.T) {
+	db, mock := newMockDB(t)
+	rooms := &RoomStore{db, Limits{MaxRoomMembers: 50}, NewPools(db, nil)}
+	now := time.Now()
+
+	mock.ExpectQuery(`SELECT r.id, r.name, r.description, COALESCE\(r.created_by, 0\), [^()]*r.member_count[^()]*COALESCE\(r.language, ''\), r.retention_seconds, r.post_policy\s+FROM rooms r\s+WHERE r.id = \$1`).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows(roomRowColumns).AddRow(1, "general", "", 1, now, now, 1, false, nil, "open", 80, 12, true, false, nil, "{}", false, "", nil, "everyone"))
+
+	room, err := rooms.GetByID(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.MaxMembers != 50 || room.MemberCount != 12 {
+		t.Errorf("got a limit of %d and %d members, want 50 and 12", room.MaxMembers, room.MemberCount)
+	}
+}
+
+// TestRecommendScansRooms reads a recommendation row: the room columns come
+// first, then the score components, and the room's member limit is resolved
+// like anywhere else
+func TestRecommendScansRooms(t *testing.T) {
+	db, mock := newMockDB(t)
+	rooms := &RoomStore{db, Limits{MaxRoomMembers: 50}, NewPools(db, nil)}
+	now := time.Now()
+
+	mock.ExpectQuery(regexp.QuoteMeta("ORDER BY score DESC, r.id DESC")).WithArgs(int64(1), 10).
+		WillReturnRows(sqlmock.NewRows(append(roomRowColumns, "recent_messages", "known_members", "score")).
+			AddRow(3, "golang", "", 2, now, now, 1, false, now, "open", nil, 8, true, false, nil, "{}", false, "", nil, "everyone", 120, 3, 8.1))
+
+	recommended, err := rooms.Recommend(context.Background(), 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recommended) != 1 {
+		t.Fatalf("got %d recommendations, want 1", len(recommended))
+	}
+	rec := recommended[0]
+	if rec.ID != 3 || rec.MemberCount != 8 || rec.MaxMembers != 50 || rec.RecentMessages != 120 || rec.KnownMembers != 3 || rec.Score != 8.1 {
+		t.Errorf("got %+v with room %+v", rec, rec.Room)
+	}
+}
+
+// TestLookupsSkipDeletedRooms checks every query that finds a room for a
+// user, a listing or a join leaves soft-deleted rooms out
+// A lookup that loses the predicate matches nothing here and fails
+func TestLookupsSkipDeletedRooms(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name  string
+		query string
+		call  func(db *sql.DB) error
+	}{
+		{"GetByID", `FROM rooms r\s+WHERE r.id = \$1 AND r.deleted_at IS NULL`, func(db *sql.DB) error {
+			_, err := (&RoomStore{db, Limits{}, NewPools(db, nil)}).GetByID(ctx, 1)
+			return err
+		}},
+		{"GetByName", `FROM rooms r\s+WHERE r.name = \$1 AND r.deleted_at IS NULL`, func(db *sql.DB) error {
+			_, err := (&RoomStore{db, Limits{}, NewPools(db, nil)}).GetByName(ctx, "general")
+			return err
+		}},
+		{"List", `FROM rooms r(?s:.*)WHERE r.deleted_at IS NULL`, func(db *sql.DB) error {
+			_, err := (&RoomStore{db, Limits{}, NewPools(db, nil)}).List(ctx, RoomListOptions{})
+			return err
+		}},
+		{"GetUserRooms", `WHERE rm.user_id = \$1 AND r.deleted_at IS NULL`, func(db *sql.DB) error {
+			_, err := (&RoomStore{db, Limits{}, NewPools(db, nil)}).GetUserRooms(ctx, 1, RoomListOptions{})
+			return err
+		}},
+		{"Recommend", `WHERE r.join_policy <> 'invite' AND r.deleted_at IS NULL`, func(db *sql.DB) error {
+			_, err := (&RoomStore{db, Limits{}, NewPools(db, nil)}).Recommend(ctx, 1, 10)
+			return err
+		}},
+		{"IsContentFilterEnabled", `FROM rooms WHERE id = \$1 AND deleted_at IS NULL`, func(db *sql.DB) error {
+			_, err := (&RoomStore{db, Limits{}, NewPools(db, nil)}).IsContentFilterEnabled(ctx, 1)
+			return err
+		}},
+		{"GetQuietHours", `FROM rooms WHERE id = \$1 AND deleted_at IS NULL`, func(db *sql.DB) error {
+			_, _, err := (&RoomStore{db, Limits{}, NewPools(db, nil)}).GetQuietHours(ctx, 1)
+			return err
+		}},
+		{"GetPostPolicy", `FROM rooms WHERE id = \$1 AND deleted_at IS NULL`, func(db *sql.DB) error {
+			_, err := (&RoomStore{db, Limits{}, NewPools(db, nil)}).GetPostPolicy(ctx, 1)
+			return err
+		}},
+		{"IsDuplicateLimitEnabled", `FROM rooms WHERE id = \$1 AND deleted_at IS NULL`, func(db *sql.DB) error {
+			_, err := (&RoomStore{db, Limits{}, NewPools(db, nil)}).IsDuplicateLimitEnabled(ctx, 1)
+			return err
+		}},
+		{"Update", `UPDATE rooms(?s:.*)WHERE id = \$8 AND deleted_at IS NULL`, func(db *sql.DB) error {
+			return (&RoomStore{db, Limits{}, NewPools(db, nil)}).Update(ctx, &Room{ID: 1}, 0)
+		}},
+		{"IsUserInRoom", `WHERE rm.room_id = \$1 AND rm.user_id = \$2 AND r.deleted_at IS NULL`, func(db *sql.DB) error {
+			_, err := (&RoomMemberStore{db, Limits{}}).IsUserInRoom(ctx, 1, 2)
+			return err
+		}},
+		{"GetUserRoomCount", `WHERE rm.user_id = \$1 AND r.deleted_at IS NULL`, func(db *sql.DB) error {
+			_, err := (&RoomMemberStore{db, Limits{}}).GetUserRoomCount(ctx, 1)
+			return err
+		}},
+		{"GetSyncState", `AND NOT EXISTS \(SELECT 1 FROM rooms r WHERE r.id = rm.room_id AND r.deleted_at IS NOT NULL\)`, func(db *sql.DB) error {
+			_, err := (&ReadMarkerStore{db}).GetSyncState(ctx, 1)
+			return err
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			if tc.name == "Update" {
+				mock.ExpectBegin() // Saved with the room's tags
+			}
+			mock.ExpectQuery(tc.query).WillReturnError(sql.ErrNoRows)
+			if err := tc.call(db); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("got %v, want the query's sql.ErrNoRows", err)
+			}
+		})
+	}
+}
+
+// TestJoinDeletedRoom joins a soft-deleted room: the room's row lock
+// finds nothing, so the join fails like one for a missing room
+func TestJoinDeletedRoom(t *testing.T) {
+	db, mock := newMockDB(t)
+	members := &RoomMemberStore{db, Limits{}}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT id FROM users WHERE id = \$1 FOR UPDATE`).WithArgs(int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT max_members FROM rooms WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"max_members"}))
+	mock.ExpectRollback()
+
+	if err := members.Join(context.Background(), 1, 2, 2); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("joining a deleted room got %v, want sql.ErrNoRows", err)
+	}
+}
+
+// TestRestoreAndPurgeWindow checks restoring and purging use the window in
+// seconds, purging goes oldest first in batches, and a restore that finds
+// nothing reports sql.ErrNoRows
+func TestRestoreAndPurgeWindow(t *testing.T) {
+	db, mock := newMockDB(t)
+	rooms := &RoomStore{db, Limits{}, NewPools(db, nil)}
+	ctx := context.Background()
+	window := 7 * 24 * time.Hour
+
+	mock.ExpectExec(`UPDATE rooms SET deleted_at = NULL, version = version \+ 1\s+WHERE id = \$1 AND deleted_at > NOW\(\) - make_interval\(secs => \$2\)`).
+		WithArgs(int64(1), window.Seconds()).WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := rooms.Restore(ctx, 1, window); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("restoring nothing got %v, want sql.ErrNoRows", err)
+	}
+
+	mock.ExpectExec(`DELETE FROM rooms\s+WHERE id IN \(\s+SELECT id FROM rooms\s+WHERE deleted_at <= NOW\(\) - make_interval\(secs => \$1\)\s+ORDER BY deleted_at\s+LIMIT \$2`).
+		WithArgs(window.Seconds(), 100).WillReturnResult(sqlmock.NewResult(0, 42))
+	purged, err := rooms.PurgeExpired(ctx, window, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 42 {
+		t.Errorf("purged %d rooms, want 42", purged)
+	}
+}
+
+// TestFindMembersByUsername resolves mentioned names to members of the room
+// with one query, whatever the number of names
+func TestFindMembersByUsername(t *testing.T) {
+	db, mock := newMockDB(t)
+	members := &RoomMemberStore{db, Limits{}}
+
+	mock.ExpectQuery(`INNER JOIN room_members rm ON rm.user_id = u.id\s+WHERE rm.room_id = \$1 AND u.username = ANY\(\$2\)`).
+		WithArgs(int64(1), pq.Array([]string{"ada", "grace", "nobody"})).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+
+	ids, err := members.FindMembersByUsername(context.Background(), 1, []string{"ada", "grace", "nobody"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("got %v, want [1 2]", ids)
+	}
+}
+
+// TestQuietHoursColumn reads a room's quiet hours from their JSON column:
+// a whole-day window comes back validated and in force, and a malformed
+// one is an error rather than a room without quiet hours
+func TestQuietHoursColumn(t *testing.T) {
+	db, mock := newMockDB(t)
+	rooms := &RoomStore{db, Limits{}, NewPools(db, nil)}
+	now := time.Now()
+	allDay := []byte(`{"start": "00:00", "end": "00:00", "timezone": "Europe/Berlin"}`)
+
+	mock.ExpectQuery(`FROM rooms r\s+WHERE r.id = \$1`).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows(roomRowColumns).AddRow(1, "general", "", 1, now, now, 1, false, nil, "open", nil, 1, true, false, allDay, "{}", false, "", nil, "everyone"))
+	room, err := rooms.GetByID(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.QuietHours == nil || room.QuietHours.Timezone != "Europe/Berlin" || !room.QuietNow {
+		t.Errorf("got quiet hours %+v in force %v, want the whole day in force", room.QuietHours, room.QuietNow)
+	}
+
+	mock.ExpectQuery(`SELECT quiet_hours, COALESCE\(created_by, 0\) FROM rooms WHERE id = \$1`).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"quiet_hours", "created_by"}).AddRow([]byte(`{"start": "25:00", "end": "08:00"}`), 1))
+	if _, _, err := rooms.GetQuietHours(context.Background(), 1); err == nil {
+		t.Error("a malformed window was read without an error")
+	}
+}
+
+// TestUpdateVersionConflict saves a room at a version it's no longer at:
+// the conditional UPDATE matches nothing and the room is still there, so
+// the error is ErrVersionConflict; for a room that's gone it's sql.ErrNoRows
+func TestUpdateVersionConflict(t *testing.T) {
+	for _, tc := range []struct {
+		exists bool
+		want   error
+	}{
+		{true, ErrVersionConflict},
+		{false, sql.ErrNoRows},
+	} {
+		db, mock := newMockDB(t)
+		rooms := &RoomStore{db, Limits{}, NewPools(db, nil)}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`version = version \+ 1\s+WHERE id = \$8 AND deleted_at IS NULL AND \(\$9::bigint = 0 OR version = \$9\)`).
+			WithArgs("", false, JoinPolicyOpen, nil, false, false, nil, int64(1), int64(3), "", nil, "").
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM rooms WHERE id = \$1\)`).WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tc.exists))
+		mock.ExpectRollback()
+
+		err := rooms.Update(context.Background(), &Room{ID: 1, JoinPolicy: JoinPolicyOpen}, 3)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("with the room there %v: got %v, want %v", tc.exists, err, tc.want)
+		}
+	}
+}
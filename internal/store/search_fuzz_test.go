@@ -0,0 +1,79 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// isASCII reports whether s has no characters whose case PostgreSQL and Go
+// might fold differently
+func isASCII(s string) bool {
+	for _, r := range s {
+		if r > unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
+
+// FuzzUserSearch feeds hostile search terms through UserStore.Search on the
+// scratch database: quotes, LIKE wildcards, backslashes, NUL bytes, invalid
+// UTF-8 and very long input
+// No term may make the query fail, and every user found must contain the
+// term literally, so a wildcard never matches more than itself
+// Run beyond the seed corpus with
+// go test -tags integration -run '^$' -fuzz FuzzUserSearch ./internal/store
+func FuzzUserSearch(f *testing.F) {
+	db := testdb.Open(f)
+	ctx := context.Background()
+	users := &UserStore{db, Limits{}, NewPools(db, nil)}
+	// Letters only, so the seeded users are the only ones the tag finds
+	tag := fmt.Sprintf("f%x", time.Now().UnixNano())
+
+	for _, suffix := range []string{"-100%", "-a_b", `-back\slash`, "-o'brien", `-say"hi"`, "-plain"} {
+		var id int64
+		query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+		if err := db.QueryRowContext(ctx, query, tag+suffix).Scan(&id); err != nil {
+			f.Fatal(err)
+		}
+		f.Cleanup(func() { db.Exec(`DELETE FROM users WHERE id = $1`, id) })
+	}
+
+	// The wildcards and escapes match only themselves
+	for q, want := range map[string]int{"-100%": 1, "%": 0, "-a_b": 1, "-a_": 1, "_b": 0, `\`: 0, `-back\`: 1, "-o'": 1, `-say"`: 1, "-": 6} {
+		if found, err := users.Search(ctx, tag+q, 50); err != nil || len(found) != want {
+			f.Errorf("searching for %q found %d users (%v), want %d", q, len(found), err, want)
+		}
+	}
+
+	for _, seed := range []string{
+		"", "%", "_", `\`, `\\`, "%%", "-1%", "-a%b", "-a_", `-back\`, "'", "-o'", `"`, "'; DROP TABLE users; --",
+		"\x00", "-pl\x00ain", "\xff\xfe", strings.Repeat("%", 10000), strings.Repeat("a", 1<<16),
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, q string) {
+		found, err := users.Search(ctx, tag+q, 50)
+		if err != nil {
+			t.Fatalf("searching for %q: %v", q, err)
+		}
+		term := strings.ToLower(searchTerm(tag + q))
+		if !isASCII(term) {
+			return
+		}
+		for _, user := range found {
+			if !strings.Contains(strings.ToLower(user.Username), term) && !strings.Contains(strings.ToLower(user.DisplayName), term) {
+				t.Errorf("searching for %q found %q", q, user.Username)
+			}
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Session is one login: a JWT carries its ID, and deleting the session
+// revokes the token
+type Session struct {
+	ID           int64     `json:"id"`
+	UserID       int64     `json:"-"`
+	IP           string    `json:"ip"`
+	UserAgent    string    `json:"user_agent"`
+	DeviceLabel  string    `json:"device_label"` // e.g. "Firefox on Windows"
+	CreatedAt    time.Time `json:"created_at"`
+	LastActiveAt time.Time `json:"last_active_at"`
+	ExpiresAt    time.Time `json:"expires_at"` // When the session's token expires
+
+	// Current is set when listing: the session the request was made with
+	Current bool `json:"current"`
+}
+
+// Active reports whether the session can still be used
+// Sessions end when their token expires or after idleTimeout without use
+func (s *Session) Active(now time.Time, idleTimeout time.Duration) bool {
+	return now.Before(s.ExpiresAt) && now.Sub(s.LastActiveAt) < idleTimeout
+}
+
+// SessionStore handles database operations for login sessions
+type SessionStore struct {
+	db *sql.DB
+}
+
+// sessionColumns lists the columns selected for every Session query
+const sessionColumns = `id, user_id, ip, user_agent, device_label, created_at, last_active_at, expires_at`
+
+// scanSession scans a row selected with sessionColumns
+func scanSession(row rowScanner) (*Session, error) {
+	session := &Session{}
+	err := row.Scan(&session.ID, &session.UserID, &session.IP, &session.UserAgent, &session.DeviceLabel,
+		&session.CreatedAt, &session.LastActiveAt, &session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Create saves a new session and fills in its ID and timestamps
+func (s *SessionStore) Create(ctx context.Context, session *Session) error {
+	query := `
+		INSERT INTO sessions (user_id, ip, user_agent, device_label, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, last_active_at
+	`
+	return s.db.QueryRowContext(ctx, query, session.UserID, session.IP, session.UserAgent,
+		session.DeviceLabel, session.ExpiresAt).Scan(&session.ID, &session.CreatedAt, &session.LastActiveAt)
+}
+
+// GetByID returns a session
+// Returns sql.ErrNoRows for unknown (or revoked) sessions
+func (s *SessionStore) GetByID(ctx context.Context, id int64) (*Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE id = $1`
+	return scanSession(s.db.QueryRowContext(ctx, query, id))
+}
+
+// ListActive returns a user's sessions that are still usable, most recently active first
+// Sessions idle since before idleCutoff or past their expiry are left out
+func (s *SessionStore) ListActive(ctx context.Context, userID int64, idleCutoff time.Time) ([]*Session, error) {
+	query := `
+		SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE user_id = $1 AND last_active_at > $2 AND expires_at > NOW()
+		ORDER BY last_active_at DESC, id DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID, idleCutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]*Session, 0)
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Touch records that a session was used, and from which address
+func (s *SessionStore) Touch(ctx context.Context, id int64, ip string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE sessions SET last_active_at = NOW(), ip = $2 WHERE id = $1`, id, ip)
+	return err
+}
+
+// Revoke deletes one of a user's sessions; its token stops working on the next request
+// The user ID is part of the WHERE clause so users can't revoke each other's sessions
+// Returns sql.ErrNoRows if the session doesn't exist or belongs to someone else
+func (s *SessionStore) Revoke(ctx context.Context, id, userID int64) error {
+	return expectOneRow(s.db.ExecContext(ctx, `DELETE FROM sessions WHERE id = $1 AND user_id = $2`, id, userID))
+}
+
+// PurgeInactive deletes sessions idle since before the cutoff or past their expiry
+// Returns the number of sessions removed
+func (s *SessionStore) PurgeInactive(ctx context.Context, cutoff time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE last_active_at < $1 OR expires_at < NOW()`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
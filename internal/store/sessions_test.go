@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// sessionRowColumns are the columns of sessionColumns, for mocked rows
+var sessionRowColumns = []string{"id", "user_id", "ip", "user_agent", "device_label", "created_at", "last_active_at", "expires_at"}
+
+// TestSessionActive ends a session at its expiry or after the idle timeout,
+// whichever comes first
+func TestSessionActive(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		name       string
+		lastActive time.Duration
+		expiresIn  time.Duration
+		want       bool
+	}{
+		{"in use", -time.Minute, time.Hour, true},
+		{"idle", -31 * time.Minute, time.Hour, false},
+		{"idle exactly the timeout", -30 * time.Minute, time.Hour, false},
+		{"expired", -time.Minute, -time.Second, false},
+		{"expiring now", -time.Minute, 0, false},
+	} {
+		session := &Session{LastActiveAt: now.Add(tc.lastActive), ExpiresAt: now.Add(tc.expiresIn)}
+		if got := session.Active(now, 30*time.Minute); got != tc.want {
+			t.Errorf("%s: active is %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestListActiveSessions lists only a user's sessions used since the cutoff
+func TestListActiveSessions(t *testing.T) {
+	db, mock := newMockDB(t)
+	sessions := &SessionStore{db}
+	now := time.Now()
+	cutoff := now.Add(-time.Hour)
+
+	mock.ExpectQuery(`FROM sessions\s+WHERE user_id = \$1 AND last_active_at > \$2 AND expires_at > NOW\(\)\s+ORDER BY last_active_at DESC, id DESC`).
+		WithArgs(int64(1), cutoff).
+		WillReturnRows(sqlmock.NewRows(sessionRowColumns).
+			AddRow(4, 1, "203.0.113.9", "curl/8.5.0", "curl", now, now, now.Add(time.Hour)).
+			AddRow(2, 1, "198.51.100.7", "Firefox", "Firefox", now, now.Add(-time.Minute), now.Add(time.Hour)))
+
+	got, err := sessions.ListActive(context.Background(), 1, cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != 4 || got[1].DeviceLabel != "Firefox" || got[0].Current {
+		t.Errorf("got %+v, want sessions 4 and 2", got)
+	}
+}
+
+// TestRevokeSession deletes a session only for its owner
+func TestRevokeSession(t *testing.T) {
+	db, mock := newMockDB(t)
+	sessions := &SessionStore{db}
+
+	mock.ExpectExec(`DELETE FROM sessions WHERE id = \$1 AND user_id = \$2`).WithArgs(int64(3), int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := sessions.Revoke(context.Background(), 3, 2); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("revoking someone else's session got %v, want sql.ErrNoRows", err)
+	}
+}
+
+// TestPurgeInactiveSessions deletes idle and expired sessions in one statement
+func TestPurgeInactiveSessions(t *testing.T) {
+	db, mock := newMockDB(t)
+	sessions := &SessionStore{db}
+	cutoff := time.Now().Add(-720 * time.Hour)
+
+	mock.ExpectExec(`DELETE FROM sessions WHERE last_active_at < \$1 OR expires_at < NOW\(\)`).WithArgs(cutoff).
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	if removed, err := sessions.PurgeInactive(context.Background(), cutoff); err != nil || removed != 5 {
+		t.Errorf("purging removed %d sessions (%v), want 5", removed, err)
+	}
+}
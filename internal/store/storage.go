@@ -2,60 +2,396 @@ package store
 
 import (
 	"context"
-	"database/sql"
 	"time"
+
+	"github.com/drazan344/go-chat/internal/schedule"
 )
 
 // Storage aggregates all store interfaces
 // This follows the repository pattern, providing a clean abstraction over data access
 type Storage struct {
-	// Posts store (kept for compatibility, though not used in chat app)
+	// Posts store handles long-form posts outside of chat rooms
 	Posts interface {
 		Create(context.Context, *Post) error
+		GetByID(context.Context, int64) (*Post, error)
+		List(context.Context, int, int) ([]*Post, error)
+		Update(context.Context, *Post) error
+		Delete(context.Context, int64) error
 	}
 
 	// Users store handles user account management
 	Users interface {
 		Create(context.Context, *User) error
+		CreateWithDefaultRooms(context.Context, *User, string) ([]*Room, []*EmailInviteOutcome, error)
+		EnsureSystemUser(context.Context, string) error
 		GetByEmail(context.Context, string) (*User, error)
 		GetByID(context.Context, int64) (*User, error)
+		GetByUsernames(context.Context, []string, []int64) ([]*User, error)
+		GetByEmails(context.Context, []string) ([]*User, error)
+		GetByUsername(context.Context, string) (*PublicUser, error)
+		Search(context.Context, string, int) ([]*PublicUser, error)
+		UpdateProfile(context.Context, int64, *string, *bool, int64) (*User, error)
+		Delete(context.Context, int64) error
+		GetRole(context.Context, int64) (string, error)
+		SetRole(context.Context, int64, string) error
+		IsBanned(context.Context, int64) (bool, error)
+		Ban(context.Context, int64) ([]int64, error)
+		Unban(context.Context, int64) error
+		GetTokenVersion(context.Context, int64) (int64, error)
+		RevokeTokens(context.Context, int64) (int64, []int64, error)
 	}
 
 	// Rooms store handles chat room management
 	Rooms interface {
 		Create(context.Context, *Room) error
+		CreateWithMembers(context.Context, *Room, []int64) (map[int64]string, error)
 		GetByID(context.Context, int64) (*Room, error)
 		GetByName(context.Context, string) (*Room, error)
-		List(context.Context) ([]*Room, error)
-		GetUserRooms(context.Context, int64) ([]*Room, error)
+		IsContentFilterEnabled(context.Context, int64) (bool, error)
+		IsDuplicateLimitEnabled(context.Context, int64) (bool, error)
+		GetQuietHours(context.Context, int64) (*schedule.Window, int64, error)
+		GetLanguage(context.Context, int64) (string, error)
+		GetPostPolicy(context.Context, int64) (string, error)
+		List(context.Context, RoomListOptions) ([]*Room, error)
+		GetUserRooms(context.Context, int64, RoomListOptions) ([]*Room, error)
+		GetUserRoomSummaries(context.Context, int64, RoomListOptions) ([]*RoomSummary, error)
+		SetDefault(context.Context, int64, bool) error
+		Recommend(context.Context, int64, int) ([]*RoomRecommendation, error)
+		ListTags(context.Context) ([]*TagCount, error)
+		Merge(context.Context, int64, int64, int64) (*RoomMergeResult, error)
+		Update(context.Context, *Room, int64) error
+		CountCreatedSince(context.Context, int64, time.Time) (int, time.Time, error)
+		CountOwnedActive(context.Context, int64) (int, error)
+		SoftDelete(context.Context, int64) error
+		GetDeletedByID(context.Context, int64, time.Duration) (*Room, error)
+		Restore(context.Context, int64, time.Duration) error
+		PurgeExpired(context.Context, time.Duration, int) (int64, error)
+		ListRetained(context.Context) ([]RoomRetention, error)
 		Delete(context.Context, int64) error
 	}
 
 	// Messages store handles chat message persistence
-	Messages interface {
-		Create(context.Context, *Message) error
-		GetRoomMessages(context.Context, int64, int) ([]*Message, error)
-		GetMessagesSince(context.Context, int64, time.Time) ([]*Message, error)
-	}
+	// A MessageCache may sit in front of it (see NewMessageCache)
+	Messages MessageQueries
 
 	// RoomMembers store handles room membership (many-to-many user-room relationship)
 	RoomMembers interface {
-		Join(context.Context, int64, int64) error
-		Leave(context.Context, int64, int64) error
+		Join(context.Context, int64, int64, int64) error
+		JoinWithOptions(context.Context, int64, int64, JoinOptions) error
+		JoinIfAbsent(context.Context, int64, int64) (bool, error)
+		Leave(context.Context, int64, int64, int64) error
 		IsUserInRoom(context.Context, int64, int64) (bool, error)
+		IsRoomAdmin(context.Context, int64, int64) (bool, error)
+		SetRole(context.Context, int64, int64, string) error
+		GetRoomAdmins(context.Context, int64) ([]int64, error)
 		GetRoomMembers(context.Context, int64) ([]int64, error)
 		GetRoomMemberCount(context.Context, int64) (int, error)
+		GetUserRoomCount(context.Context, int64) (int, error)
+		FindMembersByUsername(context.Context, int64, []string) ([]int64, error)
+		SearchMembers(context.Context, int64, string, int) ([]*PublicUser, error)
+		GetMutual(context.Context, int64, int64) (*MutualContext, error)
+		AddMembers(context.Context, int64, []int64, int64) (map[int64]string, error)
+		RemoveMembers(context.Context, int64, []int64, int64) (map[int64]string, error)
+	}
+
+	// MembershipEvents store handles the history of joins and leaves per room
+	MembershipEvents interface {
+		List(context.Context, int64, MembershipEventQuery) ([]*MembershipEvent, error)
+	}
+
+	// Pins store handles pinned messages and their order
+	Pins interface {
+		Pin(context.Context, int64, int64, int64) (PinChange, error)
+		Unpin(context.Context, int64, int64) (PinChange, error)
+		List(context.Context, int64) ([]*PinnedMessage, int64, error)
+		Reorder(context.Context, int64, []int64, int64) (PinChange, error)
+		Search(context.Context, int64, string, int) ([]*PinnedMessage, error)
+		Count(context.Context, int64) (int, error)
+	}
+
+	// RoomEvents store handles the per-room log of changes clients replay after reconnecting
+	RoomEvents interface {
+		ListAfter(context.Context, int64, int64, int) ([]*RoomEvent, error)
+		PurgeOlderThan(context.Context, time.Time) (int64, error)
+	}
+
+	// UserEvents store keeps the frames that overflowed the hub's per-user event buffers
+	UserEvents interface {
+		Append(context.Context, []*UserEvent) error
+		ListAfter(context.Context, int64, int64, int) ([]*UserEvent, error)
+		PurgeOlderThan(context.Context, time.Time) (int64, error)
+	}
+
+	// Digests store handles daily digest email settings and send claims
+	Digests interface {
+		GetSettings(context.Context, int64) (*DigestSettings, error)
+		UpdateSettings(context.Context, int64, *DigestSettings) error
+		Timezones(context.Context) ([]string, error)
+		ClaimDue(context.Context, string, time.Time, int, int) ([]int64, error)
+		LoadRecipients(context.Context, []int64, time.Time) ([]*DigestRecipient, error)
+		Finish(context.Context, int64, time.Time, string, string) error
+	}
+
+	// NotificationPreferences store handles the per channel and event type notification matrix
+	// Read it through notify.Policy, which caches it, rather than directly
+	NotificationPreferences interface {
+		Get(context.Context, int64) (NotificationPreferences, error)
+		GetForUsers(context.Context, []int64) (map[int64]NotificationPreferences, error)
+		Update(context.Context, int64, NotificationPreferences) (NotificationPreferences, error)
+	}
+
+	// Devices store handles per-client device registration
+	Devices interface {
+		Create(context.Context, *Device) error
+		Touch(context.Context, int64, int64) error
+		PruneInactive(context.Context, time.Time) (int64, error)
+	}
+
+	// APITokens store handles personal access tokens for programmatic access
+	APITokens interface {
+		Create(context.Context, *APIToken, string) error
+		List(context.Context, int64) ([]*APIToken, error)
+		GetByHash(context.Context, string) (*APIToken, error)
+		Touch(context.Context, int64) error
+		Revoke(context.Context, int64, int64) error
+	}
+
+	// APIKeys store handles bot API keys, apart from personal access tokens
+	APIKeys interface {
+		Create(context.Context, *APIKey, string) error
+		List(context.Context, int64) ([]*APIKey, error)
+		GetByHash(context.Context, string) (*APIKey, error)
+		Touch(context.Context, int64) error
+		Revoke(context.Context, int64, int64) error
+	}
+
+	// Sessions store handles login sessions behind JWTs
+	Sessions interface {
+		Create(context.Context, *Session) error
+		GetByID(context.Context, int64) (*Session, error)
+		ListActive(context.Context, int64, time.Time) ([]*Session, error)
+		Touch(context.Context, int64, string) error
+		Revoke(context.Context, int64, int64) error
+		PurgeInactive(context.Context, time.Time) (int64, error)
+	}
+
+	// TwoFactor store handles TOTP secrets and recovery codes
+	TwoFactor interface {
+		Get(context.Context, int64) (*TwoFactor, error)
+		Setup(context.Context, int64, []byte, []string) error
+		Enable(context.Context, int64, int64) error
+		UseStep(context.Context, int64, int64) (bool, error)
+		UseRecoveryCode(context.Context, int64, string) (bool, error)
+		Disable(context.Context, int64) error
+	}
+
+	// PushTokens store handles per-device push notification tokens
+	PushTokens interface {
+		Upsert(context.Context, *PushToken) error
+		Delete(context.Context, int64, int64) error
+		ListActiveForUsers(context.Context, []int64) ([]*PushToken, error)
+		RecordFailure(context.Context, int64, int) (bool, error)
+		RecordSuccess(context.Context, int64) error
+	}
+
+	// Attachments store handles uploaded files, deduplicated by content hash
+	Attachments interface {
+		Add(context.Context, *Attachment, func() error) error
+		GetByID(context.Context, int64, int64) (*Attachment, error)
+		FinishThumbnail(context.Context, int64, bool) ([]*Attachment, error)
+		PendingThumbnails(context.Context) (map[int64]string, error)
+		Remove(context.Context, int64, int64, func(string) error) error
+		Stats(context.Context) (*StorageStats, error)
+		Search(context.Context, int64, string, int) ([]*Attachment, error)
+	}
+
+	// Translations store caches machine translations of messages
+	Translations interface {
+		Get(context.Context, int64, string, string) (*MessageTranslation, error)
+		Save(context.Context, *MessageTranslation) error
+		Invalidate(context.Context, int64) error
+	}
+
+	// ReadMarkers store handles per-device read positions and unread counts
+	ReadMarkers interface {
+		MarkRead(context.Context, int64, int64, int64, int64) error
+		GetUnreadCount(context.Context, int64, int64) (int, error)
+		GetSyncState(context.Context, int64) ([]*RoomSyncState, error)
+	}
+
+	// JoinRequests store handles knock-to-join requests for approval rooms
+	JoinRequests interface {
+		Knock(context.Context, int64, int64, time.Duration) (*JoinRequest, bool, error)
+		ListPending(context.Context, int64) ([]*JoinRequest, error)
+		Approve(context.Context, int64, int64, int64) error
+		Reject(context.Context, int64, int64, int64) error
+	}
+
+	// EmailInvites store handles room invites sent to addresses with no account yet
+	EmailInvites interface {
+		Create(context.Context, *EmailInvite, string, time.Duration) error
+	}
+
+	// OAuthIdentities store handles the Google and GitHub accounts users sign in with
+	OAuthIdentities interface {
+		GetUserID(context.Context, string, string) (int64, error)
+		Link(context.Context, *OAuthIdentity) error
+	}
+
+	// PasswordResets store handles the single-use tokens of forgotten password emails
+	PasswordResets interface {
+		Create(context.Context, int64, string, time.Duration) (time.Time, error)
+		GetUserID(context.Context, string) (int64, error)
+		Consume(context.Context, string, string) ([]int64, error)
+		PurgeExpired(context.Context, time.Time) (int64, error)
+	}
+
+	// RoomInvites store handles invites of registered users to rooms
+	RoomInvites interface {
+		Create(context.Context, *RoomInvite, time.Duration) error
+		ListPending(context.Context, int64) ([]*RoomInvite, error)
+		Respond(context.Context, int64, int64, bool) (*RoomInvite, bool, error)
+	}
+
+	// Receipts store handles delivery pointers and per-message receipts
+	Receipts interface {
+		MarkDelivered(context.Context, []DeliveryMark) error
+		MarkDeliveredLatest(context.Context, int64, int64) error
+		GetReceipts(context.Context, int64, int64) (*MessageReceipts, error)
+	}
+
+	// Exports store handles personal data export jobs and the queries behind them
+	Exports interface {
+		CreateJob(context.Context, int64, time.Time) (*ExportJob, error)
+		CompleteJob(context.Context, int64, string) error
+		FailJob(context.Context, int64, string) error
+		GetJob(context.Context, int64, int64) (*ExportJob, error)
+		CountUserMessages(context.Context, int64) (int, error)
+		GetUserMemberships(context.Context, int64) ([]*ExportedMembership, error)
+		GetUserJoinRequests(context.Context, int64) ([]*JoinRequest, error)
+		GetUserDevices(context.Context, int64) ([]*Device, error)
+		GetUserPosts(context.Context, int64) ([]*Post, error)
+		StreamUserMessages(context.Context, int64, func(*ExportedMessage) error) error
+	}
+
+	// RoomTemplates store handles the saved settings rooms can be created from
+	RoomTemplates interface {
+		Create(context.Context, *RoomTemplate) error
+		GetByID(context.Context, int64, int64) (*RoomTemplate, error)
+		ListForOwner(context.Context, int64) ([]*RoomTemplate, error)
 	}
+
+	// RoomPermissions store handles what each role may do in a room
+	RoomPermissions interface {
+		Get(context.Context, int64) (RoomPermissions, error)
+		GetAccess(context.Context, int64, int64) (*RoomAccess, error)
+		Update(context.Context, int64, RoomPermissions) (RoomPermissions, error)
+	}
+
+	// ModerationHooks store handles rooms' moderation bots
+	ModerationHooks interface {
+		Get(context.Context, int64) (*ModerationHook, error)
+		Save(context.Context, *ModerationHook) error
+		Disable(context.Context, int64, string) error
+		Delete(context.Context, int64) error
+	}
+
+	// OutgoingWebhooks store handles rooms' outgoing webhooks and their delivery logs
+	OutgoingWebhooks interface {
+		Create(context.Context, *OutgoingWebhook) error
+		Get(context.Context, int64, int64) (*OutgoingWebhook, error)
+		ListForRoom(context.Context, int64) ([]*OutgoingWebhook, error)
+		ListEnabled(context.Context) ([]*OutgoingWebhook, error)
+		Delete(context.Context, int64, int64) error
+		AdvanceSeq(context.Context, int64, int64, int64) (bool, error)
+		SkipEvents(context.Context, int64) error
+		RecordDelivery(context.Context, *OutgoingWebhookDelivery) (int, error)
+		Disable(context.Context, int64, string) (bool, error)
+		ListDeliveries(context.Context, int64, int) ([]*OutgoingWebhookDelivery, error)
+	}
+
+	// FeatureFlags store handles feature flags and their per-user and per-room overrides
+	FeatureFlags interface {
+		List(context.Context) ([]*FeatureFlag, error)
+		Save(context.Context, *FeatureFlag) error
+	}
+
+	// Reports store handles abuse reports and their audit trail
+	Reports interface {
+		Create(context.Context, *Report) error
+		ListForRoom(context.Context, int64, int, int) ([]*Report, error)
+		List(context.Context, string, int, int) ([]*Report, error)
+		UpdateStatus(context.Context, int64, string, string, string) (*Report, error)
+	}
+
+	// Redactions store handles messages platform admins removed and the admin view of messages
+	Redactions interface {
+		Redact(context.Context, int64, string, string) (*Redaction, error)
+		ListMessages(context.Context, AdminMessageQuery) ([]*AdminMessage, error)
+	}
+}
+
+// MessageQueries is what Storage.Messages offers: MessageStore, or a
+// MessageCache in front of one
+type MessageQueries interface {
+	Create(context.Context, *Message) error
+	GetRoomMessages(context.Context, int64, int) ([]*Message, error)
+	GetMessagesBefore(context.Context, int64, int64, int) ([]*Message, error)
+	GetMessagesAround(context.Context, int64, int64, int, int) (*MessageWindow, error)
+	Histogram(context.Context, int64, string, time.Time, time.Time) ([]*ActivityBucket, error)
+	FirstMessageAt(context.Context, int64, time.Time) (int64, error)
+	GetMessagesSince(context.Context, int64, time.Time) ([]*Message, error)
+	CountRecentIdentical(context.Context, int64, int64, string, time.Duration) (int, error)
+	CountInRoom(context.Context, int64) (int, error)
+	GetByID(context.Context, int64) (*Message, error)
+	PurgeRoomExpired(context.Context, int64, time.Duration, int) (int64, error)
+	Search(context.Context, int64, string, int) ([]*Message, error)
 }
 
 // NewPostgresStorage creates a new Storage instance with PostgreSQL implementations
-// All stores share the same database connection pool for efficiency
-func NewPostgresStorage(db *sql.DB) Storage {
-	return Storage{
-		Posts:       &PostStore{db},
-		Users:       &UserStore{db},
-		Rooms:       &RoomStore{db},
-		Messages:    &MessageStore{db},
-		RoomMembers: &RoomMemberStore{db},
+// All stores write to and read from the primary pool; the methods listed in
+// readAffinity read from the replica instead when one is configured
+// limits are enforced by every store that adds room members, and give rooms
+// without a retention of their own the server's; every method runs within
+// limits.Timeouts (see timeouts.go)
+func NewPostgresStorage(pools *Pools, limits Limits) Storage {
+	db := pools.Primary()
+	storage := Storage{
+		Posts:            &PostStore{db},
+		Users:            &UserStore{db, limits, pools},
+		Rooms:            &RoomStore{db, limits, pools},
+		Messages:         &MessageStore{db, pools},
+		RoomMembers:      &RoomMemberStore{db, limits},
+		MembershipEvents: &MembershipEventStore{db},
+		Devices:          &DeviceStore{db},
+		PushTokens:       &PushTokenStore{db},
+		APITokens:        &APITokenStore{db},
+		APIKeys:          &APIKeyStore{db},
+		Sessions:         &SessionStore{db},
+		TwoFactor:        &TwoFactorStore{db},
+		Attachments:      &AttachmentStore{db, pools},
+		Pins:             &PinStore{db},
+		RoomEvents:       &RoomEventStore{db},
+		UserEvents:       &UserEventStore{db},
+		Digests:          &DigestStore{db},
+		Translations:     &MessageTranslationStore{db},
+		ReadMarkers:      &ReadMarkerStore{db},
+		JoinRequests:     &JoinRequestStore{db, limits},
+		EmailInvites:     &EmailInviteStore{db},
+		PasswordResets:   &PasswordResetStore{db},
+		OAuthIdentities:  &OAuthIdentityStore{db},
+		RoomInvites:      &RoomInviteStore{db, limits},
+		Receipts:         &ReceiptStore{db},
+		Exports:          &ExportStore{db, limits, pools},
+		Reports:          &ReportStore{db},
+		Redactions:       &RedactionStore{db},
+		RoomTemplates:    &RoomTemplateStore{db},
+		RoomPermissions:  &RoomPermissionStore{db},
+		ModerationHooks:  &ModerationHookStore{db},
+		OutgoingWebhooks: &OutgoingWebhookStore{db},
+		FeatureFlags:     &FeatureFlagStore{db},
+
+		NotificationPreferences: &NotificationPreferenceStore{db},
 	}
+	return withTimeouts(storage, newTimeoutPolicy(limits.Timeouts, pools))
 }
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// SystemEvent is a structured system message: the event under "event" and its
+// parameters under their own names, e.g. {"event": "join", "username": "alice"}
+// Saved in messages.system_event as JSON; a nil event is NULL
+type SystemEvent map[string]string
+
+// Name returns the event, e.g. "join"
+func (e SystemEvent) Name() string {
+	return e["event"]
+}
+
+// Value implements driver.Valuer
+func (e SystemEvent) Value() (driver.Value, error) {
+	if e == nil {
+		return nil, nil
+	}
+	return json.Marshal(e)
+}
+
+// Scan implements sql.Scanner
+func (e *SystemEvent) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*e = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into SystemEvent", src)
+	}
+	event := SystemEvent{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return err
+	}
+	*e = event
+	return nil
+}
@@ -0,0 +1,85 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestSystemUser provisions the system user on the scratch database under
+// a fresh name: it can't be found by login, search or username, can't be
+// resolved as a room member, and its messages read back flagged as system
+// messages without it joining the room
+func TestSystemUser(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	users := &UserStore{db, Limits{}, NewPools(db, nil)}
+	suffix := time.Now().UnixNano()
+	name := fmt.Sprintf("bot%d", suffix)
+
+	if err := users.EnsureSystemUser(ctx, name); err != nil {
+		t.Fatal(err)
+	}
+	// Provisioning again only renames it
+	if err := users.EnsureSystemUser(ctx, name); err != nil {
+		t.Fatalf("provisioning twice: %v", err)
+	}
+	t.Cleanup(func() { users.EnsureSystemUser(context.Background(), "system") })
+
+	var owner int64
+	query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+	if err := db.QueryRowContext(ctx, query, fmt.Sprintf("owner-%d", suffix)).Scan(&owner); err != nil {
+		t.Fatal(err)
+	}
+	room := &Room{Name: fmt.Sprintf("merged-%d", suffix), CreatedBy: owner}
+	if err := (&RoomStore{db, Limits{MaxRoomMembers: 100, MaxRoomsPerUser: 100}, NewPools(db, nil)}).Create(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM rooms WHERE id = $1`, room.ID)
+		db.Exec(`DELETE FROM users WHERE id = $1`, owner)
+	})
+
+	if _, err := users.GetByEmail(ctx, systemUserEmail); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("logging in as the system user found %v, want sql.ErrNoRows", err)
+	}
+	if _, err := users.GetByUsername(ctx, name); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("looking the system user up found %v, want sql.ErrNoRows", err)
+	}
+	if found, err := users.Search(ctx, name, 10); err != nil || len(found) != 0 {
+		t.Errorf("searching for the system user found %d users (%v), want none", len(found), err)
+	}
+	if found, err := users.GetByUsernames(ctx, []string{name}, []int64{SystemUserID}); err != nil || len(found) != 0 {
+		t.Errorf("resolving the system user as a member found %d users (%v), want none", len(found), err)
+	}
+
+	// A real account can't take the system user's name
+	query = `INSERT INTO users (username, email, password) VALUES ($1, 'taken@example.invalid', '!')`
+	if _, err := db.ExecContext(ctx, query, name); !IsUniqueViolation(err) {
+		t.Errorf("registering the system user's name got %v, want a unique violation", err)
+	}
+
+	messages := &MessageStore{db, NewPools(db, nil)}
+	message := &Message{RoomID: room.ID, UserID: SystemUserID, Content: "gophers was merged into this room", ContentType: "text"}
+	if err := messages.Create(ctx, message); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := messages.GetByID(ctx, message.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !message.System || !saved.System || saved.Username != name {
+		t.Errorf("the notice reads back as %+v, want a system message from %s", saved, name)
+	}
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT member_count FROM rooms WHERE id = $1`, room.ID).Scan(&count); err != nil || count != 0 {
+		t.Errorf("the room has %d members (%v), want the notice not to count one", count, err)
+	}
+}
@@ -0,0 +1,76 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestTagCounts tags four rooms on the scratch database, one invite-only
+// and one deleted: the counts leave both out, the room rows carry their
+// tags sorted, and listing by tag finds only the tagged rooms
+func TestTagCounts(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	rooms := &RoomStore{db, Limits{}, NewPools(db, nil)}
+	suffix := time.Now().UnixNano()
+	tag := fmt.Sprintf("t%x", suffix)
+	other := "o" + tag[1:]
+
+	var ada int64
+	query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+	if err := db.QueryRowContext(ctx, query, fmt.Sprintf("tags-ada-%d", suffix)).Scan(&ada); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec(`DELETE FROM users WHERE id = $1`, ada) })
+
+	var created []*Room
+	for i, policy := range []string{JoinPolicyOpen, JoinPolicyApproval, JoinPolicyInvite, JoinPolicyOpen} {
+		room := &Room{Name: fmt.Sprintf("tags-%d-%d", i, suffix), CreatedBy: ada, JoinPolicy: policy, Tags: []string{tag, other}}
+		if i > 0 {
+			room.Tags = []string{tag}
+		}
+		if err := rooms.Create(ctx, room); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Exec(`DELETE FROM rooms WHERE id = $1`, room.ID) })
+		created = append(created, room)
+	}
+	if err := rooms.SoftDelete(ctx, created[3].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := rooms.ListTags(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]int)
+	for _, c := range counts {
+		got[c.Tag] = c.Rooms
+	}
+	if got[tag] != 2 || got[other] != 1 {
+		t.Errorf("counted %d and %d rooms, want 2 and 1", got[tag], got[other])
+	}
+
+	room, err := rooms.GetByID(ctx, created[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{other, tag}; !slices.Equal(room.Tags, want) {
+		t.Errorf("the room row has tags %v, want %v", room.Tags, want)
+	}
+
+	listed, err := rooms.List(ctx, RoomListOptions{Tag: other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].ID != created[0].ID {
+		t.Errorf("listing by %s found %d rooms, want only the first", other, len(listed))
+	}
+}
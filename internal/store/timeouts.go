@@ -0,0 +1,203 @@
+package store
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:generate go run gen_timeouts.go
+
+// Store operation timeouts
+//
+// Every method of a Storage made by NewPostgresStorage runs with a deadline
+// of its own, so a stuck connection can't pin a goroutine for as long as the
+// caller's context allows (a request's 60 seconds, or forever for a
+// background job). The deadline depends on what the method does: a read, a
+// write, or a bulk operation (scans, purges, batches and streams), decided in
+// one place, operationCategory below. A caller's earlier deadline still wins
+//
+// The wrappers applying it are generated from the Storage interfaces into
+// timeouts_gen.go; after adding a method to one, run go generate ./internal/store
+
+// Operation categories, each with its own timeout
+const (
+	OpRead  = "read"
+	OpWrite = "write"
+	OpBulk  = "bulk"
+)
+
+// Timeouts is how long each category of store operation may take
+// Zero fields use DefaultTimeouts'
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+	Bulk  time.Duration
+}
+
+// DefaultTimeouts returns the timeouts used when none are configured
+func DefaultTimeouts() Timeouts {
+	return Timeouts{Read: 3 * time.Second, Write: 5 * time.Second, Bulk: 30 * time.Second}
+}
+
+// of returns the timeout of a category
+func (t Timeouts) of(category string) time.Duration {
+	defaults := DefaultTimeouts()
+	timeout, fallback := t.Write, defaults.Write
+	switch category {
+	case OpRead:
+		timeout, fallback = t.Read, defaults.Read
+	case OpBulk:
+		timeout, fallback = t.Bulk, defaults.Bulk
+	}
+	if timeout <= 0 {
+		return fallback
+	}
+	return timeout
+}
+
+// bulkOperations are the methods that may take longer than any single read or
+// write: scans across rooms or a user's whole history, purges, batches, and
+// uploads (Attachments.Add runs the caller's file write inside its transaction)
+// Keyed by Storage field and method
+var bulkOperations = map[string]bool{
+	"Users.CreateWithDefaultRooms":  true,
+	"Rooms.Merge":                   true,
+	"Rooms.PurgeExpired":            true,
+	"Rooms.ListRetained":            true,
+	"Messages.PurgeRoomExpired":     true,
+	"Messages.Histogram":            true,
+	"Messages.CountInRoom":          true,
+	"RoomMembers.AddMembers":        true,
+	"RoomMembers.RemoveMembers":     true,
+	"RoomEvents.PurgeOlderThan":     true,
+	"UserEvents.Append":             true,
+	"UserEvents.PurgeOlderThan":     true,
+	"Digests.ClaimDue":              true,
+	"Digests.LoadRecipients":        true,
+	"Devices.PruneInactive":         true,
+	"Sessions.PurgeInactive":        true,
+	"PasswordResets.PurgeExpired":   true,
+	"Attachments.Add":               true,
+	"Attachments.PendingThumbnails": true,
+	"Attachments.Stats":             true,
+	"Receipts.MarkDelivered":        true,
+
+	"Exports.CountUserMessages":   true,
+	"Exports.GetUserMemberships":  true,
+	"Exports.GetUserJoinRequests": true,
+	"Exports.GetUserDevices":      true,
+	"Exports.GetUserPosts":        true,
+	"Exports.StreamUserMessages":  true,
+}
+
+// readPrefixes start the names of methods that only read
+var readPrefixes = []string{"Get", "List", "Search", "Count", "Is", "Find", "First", "Recommend", "Timezones"}
+
+// operationCategory returns the category of a Storage method ("Users.GetByID"):
+// bulk if it's listed in bulkOperations, a read if its name says so, else a write
+func operationCategory(method string) string {
+	if bulkOperations[method] {
+		return OpBulk
+	}
+	_, name, _ := strings.Cut(method, ".")
+	for _, prefix := range readPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return OpRead
+		}
+	}
+	return OpWrite
+}
+
+// timeoutPolicy bounds the operations of one Storage
+type timeoutPolicy struct {
+	timeouts Timeouts
+	counts   *deadlineCounts // The Pools', so PoolStats reports them
+}
+
+func newTimeoutPolicy(timeouts Timeouts, pools *Pools) *timeoutPolicy {
+	return &timeoutPolicy{timeouts: timeouts, counts: &pools.deadlines}
+}
+
+// bound derives the context an operation runs with
+func (p *timeoutPolicy) bound(ctx context.Context, method string) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, p.timeouts.of(operationCategory(method)))
+}
+
+// observe logs and counts an operation that failed because its own deadline
+// passed; a caller's deadline or cancellation is the caller's to report
+func (p *timeoutPolicy) observe(ctx, bounded context.Context, method string, err error) {
+	if err == nil || bounded.Err() != context.DeadlineExceeded || ctx.Err() != nil {
+		return
+	}
+	p.counts.add(method)
+	log.Printf("Store operation %s timed out after %s: %v", method, p.timeouts.of(operationCategory(method)), err)
+}
+
+// run runs an operation returning only an error within its deadline
+func (p *timeoutPolicy) run(ctx context.Context, method string, op func(context.Context) error) error {
+	bounded, cancel := p.bound(ctx, method)
+	defer cancel()
+	err := op(bounded)
+	p.observe(ctx, bounded, method, err)
+	return err
+}
+
+// timed runs an operation returning a value and an error within its deadline
+func timed[T any](p *timeoutPolicy, ctx context.Context, method string, op func(context.Context) (T, error)) (T, error) {
+	bounded, cancel := p.bound(ctx, method)
+	defer cancel()
+	v, err := op(bounded)
+	p.observe(ctx, bounded, method, err)
+	return v, err
+}
+
+// timed2 is timed for operations returning two values and an error
+func timed2[T, U any](p *timeoutPolicy, ctx context.Context, method string, op func(context.Context) (T, U, error)) (T, U, error) {
+	bounded, cancel := p.bound(ctx, method)
+	defer cancel()
+	v, w, err := op(bounded)
+	p.observe(ctx, bounded, method, err)
+	return v, w, err
+}
+
+// WithoutTimeouts returns the stores a Storage made by NewPostgresStorage runs
+// its operations on, without the timeouts; for tests that need the concrete
+// stores. Any other Storage is returned as is
+func WithoutTimeouts(st Storage) Storage {
+	if timed, ok := st.Posts.(timedPosts); ok {
+		return timed.next
+	}
+	return st
+}
+
+// deadlineCounts counts the operations cut off by their timeout, per method
+type deadlineCounts struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (c *deadlineCounts) add(method string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int64)
+	}
+	c.counts[method]++
+}
+
+// snapshot returns a copy of the counts; nil if nothing timed out
+func (c *deadlineCounts) snapshot() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.counts) == 0 {
+		return nil
+	}
+	counts := make(map[string]int64, len(c.counts))
+	for method, n := range c.counts {
+		counts[method] = n
+	}
+	return counts
+}
@@ -0,0 +1,1295 @@
+// Code generated by gen_timeouts.go; DO NOT EDIT.
+
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/schedule"
+)
+
+// timedStorage is the Storage a withTimeouts wrapper passes calls on to
+type timedStorage struct {
+	policy *timeoutPolicy
+	next   Storage
+}
+
+type timedPosts struct{ *timedStorage }
+
+func (s timedPosts) Create(ctx context.Context, a1 *Post) error {
+	return s.policy.run(ctx, "Posts.Create", func(ctx context.Context) error {
+		return s.next.Posts.Create(ctx, a1)
+	})
+}
+
+func (s timedPosts) GetByID(ctx context.Context, a1 int64) (*Post, error) {
+	return timed(s.policy, ctx, "Posts.GetByID", func(ctx context.Context) (*Post, error) {
+		return s.next.Posts.GetByID(ctx, a1)
+	})
+}
+
+func (s timedPosts) List(ctx context.Context, a1 int, a2 int) ([]*Post, error) {
+	return timed(s.policy, ctx, "Posts.List", func(ctx context.Context) ([]*Post, error) {
+		return s.next.Posts.List(ctx, a1, a2)
+	})
+}
+
+func (s timedPosts) Update(ctx context.Context, a1 *Post) error {
+	return s.policy.run(ctx, "Posts.Update", func(ctx context.Context) error {
+		return s.next.Posts.Update(ctx, a1)
+	})
+}
+
+func (s timedPosts) Delete(ctx context.Context, a1 int64) error {
+	return s.policy.run(ctx, "Posts.Delete", func(ctx context.Context) error {
+		return s.next.Posts.Delete(ctx, a1)
+	})
+}
+
+type timedUsers struct{ *timedStorage }
+
+func (s timedUsers) Create(ctx context.Context, a1 *User) error {
+	return s.policy.run(ctx, "Users.Create", func(ctx context.Context) error {
+		return s.next.Users.Create(ctx, a1)
+	})
+}
+
+func (s timedUsers) CreateWithDefaultRooms(ctx context.Context, a1 *User, a2 string) ([]*Room, []*EmailInviteOutcome, error) {
+	return timed2(s.policy, ctx, "Users.CreateWithDefaultRooms", func(ctx context.Context) ([]*Room, []*EmailInviteOutcome, error) {
+		return s.next.Users.CreateWithDefaultRooms(ctx, a1, a2)
+	})
+}
+
+func (s timedUsers) EnsureSystemUser(ctx context.Context, a1 string) error {
+	return s.policy.run(ctx, "Users.EnsureSystemUser", func(ctx context.Context) error {
+		return s.next.Users.EnsureSystemUser(ctx, a1)
+	})
+}
+
+func (s timedUsers) GetByEmail(ctx context.Context, a1 string) (*User, error) {
+	return timed(s.policy, ctx, "Users.GetByEmail", func(ctx context.Context) (*User, error) {
+		return s.next.Users.GetByEmail(ctx, a1)
+	})
+}
+
+func (s timedUsers) GetByID(ctx context.Context, a1 int64) (*User, error) {
+	return timed(s.policy, ctx, "Users.GetByID", func(ctx context.Context) (*User, error) {
+		return s.next.Users.GetByID(ctx, a1)
+	})
+}
+
+func (s timedUsers) GetByUsernames(ctx context.Context, a1 []string, a2 []int64) ([]*User, error) {
+	return timed(s.policy, ctx, "Users.GetByUsernames", func(ctx context.Context) ([]*User, error) {
+		return s.next.Users.GetByUsernames(ctx, a1, a2)
+	})
+}
+
+func (s timedUsers) GetByEmails(ctx context.Context, a1 []string) ([]*User, error) {
+	return timed(s.policy, ctx, "Users.GetByEmails", func(ctx context.Context) ([]*User, error) {
+		return s.next.Users.GetByEmails(ctx, a1)
+	})
+}
+
+func (s timedUsers) GetByUsername(ctx context.Context, a1 string) (*PublicUser, error) {
+	return timed(s.policy, ctx, "Users.GetByUsername", func(ctx context.Context) (*PublicUser, error) {
+		return s.next.Users.GetByUsername(ctx, a1)
+	})
+}
+
+func (s timedUsers) Search(ctx context.Context, a1 string, a2 int) ([]*PublicUser, error) {
+	return timed(s.policy, ctx, "Users.Search", func(ctx context.Context) ([]*PublicUser, error) {
+		return s.next.Users.Search(ctx, a1, a2)
+	})
+}
+
+func (s timedUsers) UpdateProfile(ctx context.Context, a1 int64, a2 *string, a3 *bool, a4 int64) (*User, error) {
+	return timed(s.policy, ctx, "Users.UpdateProfile", func(ctx context.Context) (*User, error) {
+		return s.next.Users.UpdateProfile(ctx, a1, a2, a3, a4)
+	})
+}
+
+func (s timedUsers) Delete(ctx context.Context, a1 int64) error {
+	return s.policy.run(ctx, "Users.Delete", func(ctx context.Context) error {
+		return s.next.Users.Delete(ctx, a1)
+	})
+}
+
+func (s timedUsers) GetRole(ctx context.Context, a1 int64) (string, error) {
+	return timed(s.policy, ctx, "Users.GetRole", func(ctx context.Context) (string, error) {
+		return s.next.Users.GetRole(ctx, a1)
+	})
+}
+
+func (s timedUsers) SetRole(ctx context.Context, a1 int64, a2 string) error {
+	return s.policy.run(ctx, "Users.SetRole", func(ctx context.Context) error {
+		return s.next.Users.SetRole(ctx, a1, a2)
+	})
+}
+
+func (s timedUsers) IsBanned(ctx context.Context, a1 int64) (bool, error) {
+	return timed(s.policy, ctx, "Users.IsBanned", func(ctx context.Context) (bool, error) {
+		return s.next.Users.IsBanned(ctx, a1)
+	})
+}
+
+func (s timedUsers) Ban(ctx context.Context, a1 int64) ([]int64, error) {
+	return timed(s.policy, ctx, "Users.Ban", func(ctx context.Context) ([]int64, error) {
+		return s.next.Users.Ban(ctx, a1)
+	})
+}
+
+func (s timedUsers) Unban(ctx context.Context, a1 int64) error {
+	return s.policy.run(ctx, "Users.Unban", func(ctx context.Context) error {
+		return s.next.Users.Unban(ctx, a1)
+	})
+}
+
+func (s timedUsers) GetTokenVersion(ctx context.Context, a1 int64) (int64, error) {
+	return timed(s.policy, ctx, "Users.GetTokenVersion", func(ctx context.Context) (int64, error) {
+		return s.next.Users.GetTokenVersion(ctx, a1)
+	})
+}
+
+func (s timedUsers) RevokeTokens(ctx context.Context, a1 int64) (int64, []int64, error) {
+	return timed2(s.policy, ctx, "Users.RevokeTokens", func(ctx context.Context) (int64, []int64, error) {
+		return s.next.Users.RevokeTokens(ctx, a1)
+	})
+}
+
+type timedRooms struct{ *timedStorage }
+
+func (s timedRooms) Create(ctx context.Context, a1 *Room) error {
+	return s.policy.run(ctx, "Rooms.Create", func(ctx context.Context) error {
+		return s.next.Rooms.Create(ctx, a1)
+	})
+}
+
+func (s timedRooms) CreateWithMembers(ctx context.Context, a1 *Room, a2 []int64) (map[int64]string, error) {
+	return timed(s.policy, ctx, "Rooms.CreateWithMembers", func(ctx context.Context) (map[int64]string, error) {
+		return s.next.Rooms.CreateWithMembers(ctx, a1, a2)
+	})
+}
+
+func (s timedRooms) GetByID(ctx context.Context, a1 int64) (*Room, error) {
+	return timed(s.policy, ctx, "Rooms.GetByID", func(ctx context.Context) (*Room, error) {
+		return s.next.Rooms.GetByID(ctx, a1)
+	})
+}
+
+func (s timedRooms) GetByName(ctx context.Context, a1 string) (*Room, error) {
+	return timed(s.policy, ctx, "Rooms.GetByName", func(ctx context.Context) (*Room, error) {
+		return s.next.Rooms.GetByName(ctx, a1)
+	})
+}
+
+func (s timedRooms) IsContentFilterEnabled(ctx context.Context, a1 int64) (bool, error) {
+	return timed(s.policy, ctx, "Rooms.IsContentFilterEnabled", func(ctx context.Context) (bool, error) {
+		return s.next.Rooms.IsContentFilterEnabled(ctx, a1)
+	})
+}
+
+func (s timedRooms) IsDuplicateLimitEnabled(ctx context.Context, a1 int64) (bool, error) {
+	return timed(s.policy, ctx, "Rooms.IsDuplicateLimitEnabled", func(ctx context.Context) (bool, error) {
+		return s.next.Rooms.IsDuplicateLimitEnabled(ctx, a1)
+	})
+}
+
+func (s timedRooms) GetQuietHours(ctx context.Context, a1 int64) (*schedule.Window, int64, error) {
+	return timed2(s.policy, ctx, "Rooms.GetQuietHours", func(ctx context.Context) (*schedule.Window, int64, error) {
+		return s.next.Rooms.GetQuietHours(ctx, a1)
+	})
+}
+
+func (s timedRooms) GetLanguage(ctx context.Context, a1 int64) (string, error) {
+	return timed(s.policy, ctx, "Rooms.GetLanguage", func(ctx context.Context) (string, error) {
+		return s.next.Rooms.GetLanguage(ctx, a1)
+	})
+}
+
+func (s timedRooms) GetPostPolicy(ctx context.Context, a1 int64) (string, error) {
+	return timed(s.policy, ctx, "Rooms.GetPostPolicy", func(ctx context.Context) (string, error) {
+		return s.next.Rooms.GetPostPolicy(ctx, a1)
+	})
+}
+
+func (s timedRooms) List(ctx context.Context, a1 RoomListOptions) ([]*Room, error) {
+	return timed(s.policy, ctx, "Rooms.List", func(ctx context.Context) ([]*Room, error) {
+		return s.next.Rooms.List(ctx, a1)
+	})
+}
+
+func (s timedRooms) GetUserRooms(ctx context.Context, a1 int64, a2 RoomListOptions) ([]*Room, error) {
+	return timed(s.policy, ctx, "Rooms.GetUserRooms", func(ctx context.Context) ([]*Room, error) {
+		return s.next.Rooms.GetUserRooms(ctx, a1, a2)
+	})
+}
+
+func (s timedRooms) GetUserRoomSummaries(ctx context.Context, a1 int64, a2 RoomListOptions) ([]*RoomSummary, error) {
+	return timed(s.policy, ctx, "Rooms.GetUserRoomSummaries", func(ctx context.Context) ([]*RoomSummary, error) {
+		return s.next.Rooms.GetUserRoomSummaries(ctx, a1, a2)
+	})
+}
+
+func (s timedRooms) SetDefault(ctx context.Context, a1 int64, a2 bool) error {
+	return s.policy.run(ctx, "Rooms.SetDefault", func(ctx context.Context) error {
+		return s.next.Rooms.SetDefault(ctx, a1, a2)
+	})
+}
+
+func (s timedRooms) Recommend(ctx context.Context, a1 int64, a2 int) ([]*RoomRecommendation, error) {
+	return timed(s.policy, ctx, "Rooms.Recommend", func(ctx context.Context) ([]*RoomRecommendation, error) {
+		return s.next.Rooms.Recommend(ctx, a1, a2)
+	})
+}
+
+func (s timedRooms) ListTags(ctx context.Context) ([]*TagCount, error) {
+	return timed(s.policy, ctx, "Rooms.ListTags", func(ctx context.Context) ([]*TagCount, error) {
+		return s.next.Rooms.ListTags(ctx)
+	})
+}
+
+func (s timedRooms) Merge(ctx context.Context, a1 int64, a2 int64, a3 int64) (*RoomMergeResult, error) {
+	return timed(s.policy, ctx, "Rooms.Merge", func(ctx context.Context) (*RoomMergeResult, error) {
+		return s.next.Rooms.Merge(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedRooms) Update(ctx context.Context, a1 *Room, a2 int64) error {
+	return s.policy.run(ctx, "Rooms.Update", func(ctx context.Context) error {
+		return s.next.Rooms.Update(ctx, a1, a2)
+	})
+}
+
+func (s timedRooms) CountCreatedSince(ctx context.Context, a1 int64, a2 time.Time) (int, time.Time, error) {
+	return timed2(s.policy, ctx, "Rooms.CountCreatedSince", func(ctx context.Context) (int, time.Time, error) {
+		return s.next.Rooms.CountCreatedSince(ctx, a1, a2)
+	})
+}
+
+func (s timedRooms) CountOwnedActive(ctx context.Context, a1 int64) (int, error) {
+	return timed(s.policy, ctx, "Rooms.CountOwnedActive", func(ctx context.Context) (int, error) {
+		return s.next.Rooms.CountOwnedActive(ctx, a1)
+	})
+}
+
+func (s timedRooms) SoftDelete(ctx context.Context, a1 int64) error {
+	return s.policy.run(ctx, "Rooms.SoftDelete", func(ctx context.Context) error {
+		return s.next.Rooms.SoftDelete(ctx, a1)
+	})
+}
+
+func (s timedRooms) GetDeletedByID(ctx context.Context, a1 int64, a2 time.Duration) (*Room, error) {
+	return timed(s.policy, ctx, "Rooms.GetDeletedByID", func(ctx context.Context) (*Room, error) {
+		return s.next.Rooms.GetDeletedByID(ctx, a1, a2)
+	})
+}
+
+func (s timedRooms) Restore(ctx context.Context, a1 int64, a2 time.Duration) error {
+	return s.policy.run(ctx, "Rooms.Restore", func(ctx context.Context) error {
+		return s.next.Rooms.Restore(ctx, a1, a2)
+	})
+}
+
+func (s timedRooms) PurgeExpired(ctx context.Context, a1 time.Duration, a2 int) (int64, error) {
+	return timed(s.policy, ctx, "Rooms.PurgeExpired", func(ctx context.Context) (int64, error) {
+		return s.next.Rooms.PurgeExpired(ctx, a1, a2)
+	})
+}
+
+func (s timedRooms) ListRetained(ctx context.Context) ([]RoomRetention, error) {
+	return timed(s.policy, ctx, "Rooms.ListRetained", func(ctx context.Context) ([]RoomRetention, error) {
+		return s.next.Rooms.ListRetained(ctx)
+	})
+}
+
+func (s timedRooms) Delete(ctx context.Context, a1 int64) error {
+	return s.policy.run(ctx, "Rooms.Delete", func(ctx context.Context) error {
+		return s.next.Rooms.Delete(ctx, a1)
+	})
+}
+
+type timedMessages struct{ *timedStorage }
+
+func (s timedMessages) Create(ctx context.Context, a1 *Message) error {
+	return s.policy.run(ctx, "Messages.Create", func(ctx context.Context) error {
+		return s.next.Messages.Create(ctx, a1)
+	})
+}
+
+func (s timedMessages) GetRoomMessages(ctx context.Context, a1 int64, a2 int) ([]*Message, error) {
+	return timed(s.policy, ctx, "Messages.GetRoomMessages", func(ctx context.Context) ([]*Message, error) {
+		return s.next.Messages.GetRoomMessages(ctx, a1, a2)
+	})
+}
+
+func (s timedMessages) GetMessagesBefore(ctx context.Context, a1 int64, a2 int64, a3 int) ([]*Message, error) {
+	return timed(s.policy, ctx, "Messages.GetMessagesBefore", func(ctx context.Context) ([]*Message, error) {
+		return s.next.Messages.GetMessagesBefore(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedMessages) GetMessagesAround(ctx context.Context, a1 int64, a2 int64, a3 int, a4 int) (*MessageWindow, error) {
+	return timed(s.policy, ctx, "Messages.GetMessagesAround", func(ctx context.Context) (*MessageWindow, error) {
+		return s.next.Messages.GetMessagesAround(ctx, a1, a2, a3, a4)
+	})
+}
+
+func (s timedMessages) Histogram(ctx context.Context, a1 int64, a2 string, a3 time.Time, a4 time.Time) ([]*ActivityBucket, error) {
+	return timed(s.policy, ctx, "Messages.Histogram", func(ctx context.Context) ([]*ActivityBucket, error) {
+		return s.next.Messages.Histogram(ctx, a1, a2, a3, a4)
+	})
+}
+
+func (s timedMessages) FirstMessageAt(ctx context.Context, a1 int64, a2 time.Time) (int64, error) {
+	return timed(s.policy, ctx, "Messages.FirstMessageAt", func(ctx context.Context) (int64, error) {
+		return s.next.Messages.FirstMessageAt(ctx, a1, a2)
+	})
+}
+
+func (s timedMessages) GetMessagesSince(ctx context.Context, a1 int64, a2 time.Time) ([]*Message, error) {
+	return timed(s.policy, ctx, "Messages.GetMessagesSince", func(ctx context.Context) ([]*Message, error) {
+		return s.next.Messages.GetMessagesSince(ctx, a1, a2)
+	})
+}
+
+func (s timedMessages) CountRecentIdentical(ctx context.Context, a1 int64, a2 int64, a3 string, a4 time.Duration) (int, error) {
+	return timed(s.policy, ctx, "Messages.CountRecentIdentical", func(ctx context.Context) (int, error) {
+		return s.next.Messages.CountRecentIdentical(ctx, a1, a2, a3, a4)
+	})
+}
+
+func (s timedMessages) CountInRoom(ctx context.Context, a1 int64) (int, error) {
+	return timed(s.policy, ctx, "Messages.CountInRoom", func(ctx context.Context) (int, error) {
+		return s.next.Messages.CountInRoom(ctx, a1)
+	})
+}
+
+func (s timedMessages) GetByID(ctx context.Context, a1 int64) (*Message, error) {
+	return timed(s.policy, ctx, "Messages.GetByID", func(ctx context.Context) (*Message, error) {
+		return s.next.Messages.GetByID(ctx, a1)
+	})
+}
+
+func (s timedMessages) PurgeRoomExpired(ctx context.Context, a1 int64, a2 time.Duration, a3 int) (int64, error) {
+	return timed(s.policy, ctx, "Messages.PurgeRoomExpired", func(ctx context.Context) (int64, error) {
+		return s.next.Messages.PurgeRoomExpired(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedMessages) Search(ctx context.Context, a1 int64, a2 string, a3 int) ([]*Message, error) {
+	return timed(s.policy, ctx, "Messages.Search", func(ctx context.Context) ([]*Message, error) {
+		return s.next.Messages.Search(ctx, a1, a2, a3)
+	})
+}
+
+type timedRoomMembers struct{ *timedStorage }
+
+func (s timedRoomMembers) Join(ctx context.Context, a1 int64, a2 int64, a3 int64) error {
+	return s.policy.run(ctx, "RoomMembers.Join", func(ctx context.Context) error {
+		return s.next.RoomMembers.Join(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedRoomMembers) JoinWithOptions(ctx context.Context, a1 int64, a2 int64, a3 JoinOptions) error {
+	return s.policy.run(ctx, "RoomMembers.JoinWithOptions", func(ctx context.Context) error {
+		return s.next.RoomMembers.JoinWithOptions(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedRoomMembers) JoinIfAbsent(ctx context.Context, a1 int64, a2 int64) (bool, error) {
+	return timed(s.policy, ctx, "RoomMembers.JoinIfAbsent", func(ctx context.Context) (bool, error) {
+		return s.next.RoomMembers.JoinIfAbsent(ctx, a1, a2)
+	})
+}
+
+func (s timedRoomMembers) Leave(ctx context.Context, a1 int64, a2 int64, a3 int64) error {
+	return s.policy.run(ctx, "RoomMembers.Leave", func(ctx context.Context) error {
+		return s.next.RoomMembers.Leave(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedRoomMembers) IsUserInRoom(ctx context.Context, a1 int64, a2 int64) (bool, error) {
+	return timed(s.policy, ctx, "RoomMembers.IsUserInRoom", func(ctx context.Context) (bool, error) {
+		return s.next.RoomMembers.IsUserInRoom(ctx, a1, a2)
+	})
+}
+
+func (s timedRoomMembers) IsRoomAdmin(ctx context.Context, a1 int64, a2 int64) (bool, error) {
+	return timed(s.policy, ctx, "RoomMembers.IsRoomAdmin", func(ctx context.Context) (bool, error) {
+		return s.next.RoomMembers.IsRoomAdmin(ctx, a1, a2)
+	})
+}
+
+func (s timedRoomMembers) SetRole(ctx context.Context, a1 int64, a2 int64, a3 string) error {
+	return s.policy.run(ctx, "RoomMembers.SetRole", func(ctx context.Context) error {
+		return s.next.RoomMembers.SetRole(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedRoomMembers) GetRoomAdmins(ctx context.Context, a1 int64) ([]int64, error) {
+	return timed(s.policy, ctx, "RoomMembers.GetRoomAdmins", func(ctx context.Context) ([]int64, error) {
+		return s.next.RoomMembers.GetRoomAdmins(ctx, a1)
+	})
+}
+
+func (s timedRoomMembers) GetRoomMembers(ctx context.Context, a1 int64) ([]int64, error) {
+	return timed(s.policy, ctx, "RoomMembers.GetRoomMembers", func(ctx context.Context) ([]int64, error) {
+		return s.next.RoomMembers.GetRoomMembers(ctx, a1)
+	})
+}
+
+func (s timedRoomMembers) GetRoomMemberCount(ctx context.Context, a1 int64) (int, error) {
+	return timed(s.policy, ctx, "RoomMembers.GetRoomMemberCount", func(ctx context.Context) (int, error) {
+		return s.next.RoomMembers.GetRoomMemberCount(ctx, a1)
+	})
+}
+
+func (s timedRoomMembers) GetUserRoomCount(ctx context.Context, a1 int64) (int, error) {
+	return timed(s.policy, ctx, "RoomMembers.GetUserRoomCount", func(ctx context.Context) (int, error) {
+		return s.next.RoomMembers.GetUserRoomCount(ctx, a1)
+	})
+}
+
+func (s timedRoomMembers) FindMembersByUsername(ctx context.Context, a1 int64, a2 []string) ([]int64, error) {
+	return timed(s.policy, ctx, "RoomMembers.FindMembersByUsername", func(ctx context.Context) ([]int64, error) {
+		return s.next.RoomMembers.FindMembersByUsername(ctx, a1, a2)
+	})
+}
+
+func (s timedRoomMembers) SearchMembers(ctx context.Context, a1 int64, a2 string, a3 int) ([]*PublicUser, error) {
+	return timed(s.policy, ctx, "RoomMembers.SearchMembers", func(ctx context.Context) ([]*PublicUser, error) {
+		return s.next.RoomMembers.SearchMembers(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedRoomMembers) GetMutual(ctx context.Context, a1 int64, a2 int64) (*MutualContext, error) {
+	return timed(s.policy, ctx, "RoomMembers.GetMutual", func(ctx context.Context) (*MutualContext, error) {
+		return s.next.RoomMembers.GetMutual(ctx, a1, a2)
+	})
+}
+
+func (s timedRoomMembers) AddMembers(ctx context.Context, a1 int64, a2 []int64, a3 int64) (map[int64]string, error) {
+	return timed(s.policy, ctx, "RoomMembers.AddMembers", func(ctx context.Context) (map[int64]string, error) {
+		return s.next.RoomMembers.AddMembers(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedRoomMembers) RemoveMembers(ctx context.Context, a1 int64, a2 []int64, a3 int64) (map[int64]string, error) {
+	return timed(s.policy, ctx, "RoomMembers.RemoveMembers", func(ctx context.Context) (map[int64]string, error) {
+		return s.next.RoomMembers.RemoveMembers(ctx, a1, a2, a3)
+	})
+}
+
+type timedMembershipEvents struct{ *timedStorage }
+
+func (s timedMembershipEvents) List(ctx context.Context, a1 int64, a2 MembershipEventQuery) ([]*MembershipEvent, error) {
+	return timed(s.policy, ctx, "MembershipEvents.List", func(ctx context.Context) ([]*MembershipEvent, error) {
+		return s.next.MembershipEvents.List(ctx, a1, a2)
+	})
+}
+
+type timedPins struct{ *timedStorage }
+
+func (s timedPins) Pin(ctx context.Context, a1 int64, a2 int64, a3 int64) (PinChange, error) {
+	return timed(s.policy, ctx, "Pins.Pin", func(ctx context.Context) (PinChange, error) {
+		return s.next.Pins.Pin(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedPins) Unpin(ctx context.Context, a1 int64, a2 int64) (PinChange, error) {
+	return timed(s.policy, ctx, "Pins.Unpin", func(ctx context.Context) (PinChange, error) {
+		return s.next.Pins.Unpin(ctx, a1, a2)
+	})
+}
+
+func (s timedPins) List(ctx context.Context, a1 int64) ([]*PinnedMessage, int64, error) {
+	return timed2(s.policy, ctx, "Pins.List", func(ctx context.Context) ([]*PinnedMessage, int64, error) {
+		return s.next.Pins.List(ctx, a1)
+	})
+}
+
+func (s timedPins) Reorder(ctx context.Context, a1 int64, a2 []int64, a3 int64) (PinChange, error) {
+	return timed(s.policy, ctx, "Pins.Reorder", func(ctx context.Context) (PinChange, error) {
+		return s.next.Pins.Reorder(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedPins) Search(ctx context.Context, a1 int64, a2 string, a3 int) ([]*PinnedMessage, error) {
+	return timed(s.policy, ctx, "Pins.Search", func(ctx context.Context) ([]*PinnedMessage, error) {
+		return s.next.Pins.Search(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedPins) Count(ctx context.Context, a1 int64) (int, error) {
+	return timed(s.policy, ctx, "Pins.Count", func(ctx context.Context) (int, error) {
+		return s.next.Pins.Count(ctx, a1)
+	})
+}
+
+type timedRoomEvents struct{ *timedStorage }
+
+func (s timedRoomEvents) ListAfter(ctx context.Context, a1 int64, a2 int64, a3 int) ([]*RoomEvent, error) {
+	return timed(s.policy, ctx, "RoomEvents.ListAfter", func(ctx context.Context) ([]*RoomEvent, error) {
+		return s.next.RoomEvents.ListAfter(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedRoomEvents) PurgeOlderThan(ctx context.Context, a1 time.Time) (int64, error) {
+	return timed(s.policy, ctx, "RoomEvents.PurgeOlderThan", func(ctx context.Context) (int64, error) {
+		return s.next.RoomEvents.PurgeOlderThan(ctx, a1)
+	})
+}
+
+type timedUserEvents struct{ *timedStorage }
+
+func (s timedUserEvents) Append(ctx context.Context, a1 []*UserEvent) error {
+	return s.policy.run(ctx, "UserEvents.Append", func(ctx context.Context) error {
+		return s.next.UserEvents.Append(ctx, a1)
+	})
+}
+
+func (s timedUserEvents) ListAfter(ctx context.Context, a1 int64, a2 int64, a3 int) ([]*UserEvent, error) {
+	return timed(s.policy, ctx, "UserEvents.ListAfter", func(ctx context.Context) ([]*UserEvent, error) {
+		return s.next.UserEvents.ListAfter(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedUserEvents) PurgeOlderThan(ctx context.Context, a1 time.Time) (int64, error) {
+	return timed(s.policy, ctx, "UserEvents.PurgeOlderThan", func(ctx context.Context) (int64, error) {
+		return s.next.UserEvents.PurgeOlderThan(ctx, a1)
+	})
+}
+
+type timedDigests struct{ *timedStorage }
+
+func (s timedDigests) GetSettings(ctx context.Context, a1 int64) (*DigestSettings, error) {
+	return timed(s.policy, ctx, "Digests.GetSettings", func(ctx context.Context) (*DigestSettings, error) {
+		return s.next.Digests.GetSettings(ctx, a1)
+	})
+}
+
+func (s timedDigests) UpdateSettings(ctx context.Context, a1 int64, a2 *DigestSettings) error {
+	return s.policy.run(ctx, "Digests.UpdateSettings", func(ctx context.Context) error {
+		return s.next.Digests.UpdateSettings(ctx, a1, a2)
+	})
+}
+
+func (s timedDigests) Timezones(ctx context.Context) ([]string, error) {
+	return timed(s.policy, ctx, "Digests.Timezones", func(ctx context.Context) ([]string, error) {
+		return s.next.Digests.Timezones(ctx)
+	})
+}
+
+func (s timedDigests) ClaimDue(ctx context.Context, a1 string, a2 time.Time, a3 int, a4 int) ([]int64, error) {
+	return timed(s.policy, ctx, "Digests.ClaimDue", func(ctx context.Context) ([]int64, error) {
+		return s.next.Digests.ClaimDue(ctx, a1, a2, a3, a4)
+	})
+}
+
+func (s timedDigests) LoadRecipients(ctx context.Context, a1 []int64, a2 time.Time) ([]*DigestRecipient, error) {
+	return timed(s.policy, ctx, "Digests.LoadRecipients", func(ctx context.Context) ([]*DigestRecipient, error) {
+		return s.next.Digests.LoadRecipients(ctx, a1, a2)
+	})
+}
+
+func (s timedDigests) Finish(ctx context.Context, a1 int64, a2 time.Time, a3 string, a4 string) error {
+	return s.policy.run(ctx, "Digests.Finish", func(ctx context.Context) error {
+		return s.next.Digests.Finish(ctx, a1, a2, a3, a4)
+	})
+}
+
+type timedNotificationPreferences struct{ *timedStorage }
+
+func (s timedNotificationPreferences) Get(ctx context.Context, a1 int64) (NotificationPreferences, error) {
+	return timed(s.policy, ctx, "NotificationPreferences.Get", func(ctx context.Context) (NotificationPreferences, error) {
+		return s.next.NotificationPreferences.Get(ctx, a1)
+	})
+}
+
+func (s timedNotificationPreferences) GetForUsers(ctx context.Context, a1 []int64) (map[int64]NotificationPreferences, error) {
+	return timed(s.policy, ctx, "NotificationPreferences.GetForUsers", func(ctx context.Context) (map[int64]NotificationPreferences, error) {
+		return s.next.NotificationPreferences.GetForUsers(ctx, a1)
+	})
+}
+
+func (s timedNotificationPreferences) Update(ctx context.Context, a1 int64, a2 NotificationPreferences) (NotificationPreferences, error) {
+	return timed(s.policy, ctx, "NotificationPreferences.Update", func(ctx context.Context) (NotificationPreferences, error) {
+		return s.next.NotificationPreferences.Update(ctx, a1, a2)
+	})
+}
+
+type timedDevices struct{ *timedStorage }
+
+func (s timedDevices) Create(ctx context.Context, a1 *Device) error {
+	return s.policy.run(ctx, "Devices.Create", func(ctx context.Context) error {
+		return s.next.Devices.Create(ctx, a1)
+	})
+}
+
+func (s timedDevices) Touch(ctx context.Context, a1 int64, a2 int64) error {
+	return s.policy.run(ctx, "Devices.Touch", func(ctx context.Context) error {
+		return s.next.Devices.Touch(ctx, a1, a2)
+	})
+}
+
+func (s timedDevices) PruneInactive(ctx context.Context, a1 time.Time) (int64, error) {
+	return timed(s.policy, ctx, "Devices.PruneInactive", func(ctx context.Context) (int64, error) {
+		return s.next.Devices.PruneInactive(ctx, a1)
+	})
+}
+
+type timedAPITokens struct{ *timedStorage }
+
+func (s timedAPITokens) Create(ctx context.Context, a1 *APIToken, a2 string) error {
+	return s.policy.run(ctx, "APITokens.Create", func(ctx context.Context) error {
+		return s.next.APITokens.Create(ctx, a1, a2)
+	})
+}
+
+func (s timedAPITokens) List(ctx context.Context, a1 int64) ([]*APIToken, error) {
+	return timed(s.policy, ctx, "APITokens.List", func(ctx context.Context) ([]*APIToken, error) {
+		return s.next.APITokens.List(ctx, a1)
+	})
+}
+
+func (s timedAPITokens) GetByHash(ctx context.Context, a1 string) (*APIToken, error) {
+	return timed(s.policy, ctx, "APITokens.GetByHash", func(ctx context.Context) (*APIToken, error) {
+		return s.next.APITokens.GetByHash(ctx, a1)
+	})
+}
+
+func (s timedAPITokens) Touch(ctx context.Context, a1 int64) error {
+	return s.policy.run(ctx, "APITokens.Touch", func(ctx context.Context) error {
+		return s.next.APITokens.Touch(ctx, a1)
+	})
+}
+
+func (s timedAPITokens) Revoke(ctx context.Context, a1 int64, a2 int64) error {
+	return s.policy.run(ctx, "APITokens.Revoke", func(ctx context.Context) error {
+		return s.next.APITokens.Revoke(ctx, a1, a2)
+	})
+}
+
+type timedAPIKeys struct{ *timedStorage }
+
+func (s timedAPIKeys) Create(ctx context.Context, a1 *APIKey, a2 string) error {
+	return s.policy.run(ctx, "APIKeys.Create", func(ctx context.Context) error {
+		return s.next.APIKeys.Create(ctx, a1, a2)
+	})
+}
+
+func (s timedAPIKeys) List(ctx context.Context, a1 int64) ([]*APIKey, error) {
+	return timed(s.policy, ctx, "APIKeys.List", func(ctx context.Context) ([]*APIKey, error) {
+		return s.next.APIKeys.List(ctx, a1)
+	})
+}
+
+func (s timedAPIKeys) GetByHash(ctx context.Context, a1 string) (*APIKey, error) {
+	return timed(s.policy, ctx, "APIKeys.GetByHash", func(ctx context.Context) (*APIKey, error) {
+		return s.next.APIKeys.GetByHash(ctx, a1)
+	})
+}
+
+func (s timedAPIKeys) Touch(ctx context.Context, a1 int64) error {
+	return s.policy.run(ctx, "APIKeys.Touch", func(ctx context.Context) error {
+		return s.next.APIKeys.Touch(ctx, a1)
+	})
+}
+
+func (s timedAPIKeys) Revoke(ctx context.Context, a1 int64, a2 int64) error {
+	return s.policy.run(ctx, "APIKeys.Revoke", func(ctx context.Context) error {
+		return s.next.APIKeys.Revoke(ctx, a1, a2)
+	})
+}
+
+type timedSessions struct{ *timedStorage }
+
+func (s timedSessions) Create(ctx context.Context, a1 *Session) error {
+	return s.policy.run(ctx, "Sessions.Create", func(ctx context.Context) error {
+		return s.next.Sessions.Create(ctx, a1)
+	})
+}
+
+func (s timedSessions) GetByID(ctx context.Context, a1 int64) (*Session, error) {
+	return timed(s.policy, ctx, "Sessions.GetByID", func(ctx context.Context) (*Session, error) {
+		return s.next.Sessions.GetByID(ctx, a1)
+	})
+}
+
+func (s timedSessions) ListActive(ctx context.Context, a1 int64, a2 time.Time) ([]*Session, error) {
+	return timed(s.policy, ctx, "Sessions.ListActive", func(ctx context.Context) ([]*Session, error) {
+		return s.next.Sessions.ListActive(ctx, a1, a2)
+	})
+}
+
+func (s timedSessions) Touch(ctx context.Context, a1 int64, a2 string) error {
+	return s.policy.run(ctx, "Sessions.Touch", func(ctx context.Context) error {
+		return s.next.Sessions.Touch(ctx, a1, a2)
+	})
+}
+
+func (s timedSessions) Revoke(ctx context.Context, a1 int64, a2 int64) error {
+	return s.policy.run(ctx, "Sessions.Revoke", func(ctx context.Context) error {
+		return s.next.Sessions.Revoke(ctx, a1, a2)
+	})
+}
+
+func (s timedSessions) PurgeInactive(ctx context.Context, a1 time.Time) (int64, error) {
+	return timed(s.policy, ctx, "Sessions.PurgeInactive", func(ctx context.Context) (int64, error) {
+		return s.next.Sessions.PurgeInactive(ctx, a1)
+	})
+}
+
+type timedTwoFactor struct{ *timedStorage }
+
+func (s timedTwoFactor) Get(ctx context.Context, a1 int64) (*TwoFactor, error) {
+	return timed(s.policy, ctx, "TwoFactor.Get", func(ctx context.Context) (*TwoFactor, error) {
+		return s.next.TwoFactor.Get(ctx, a1)
+	})
+}
+
+func (s timedTwoFactor) Setup(ctx context.Context, a1 int64, a2 []byte, a3 []string) error {
+	return s.policy.run(ctx, "TwoFactor.Setup", func(ctx context.Context) error {
+		return s.next.TwoFactor.Setup(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedTwoFactor) Enable(ctx context.Context, a1 int64, a2 int64) error {
+	return s.policy.run(ctx, "TwoFactor.Enable", func(ctx context.Context) error {
+		return s.next.TwoFactor.Enable(ctx, a1, a2)
+	})
+}
+
+func (s timedTwoFactor) UseStep(ctx context.Context, a1 int64, a2 int64) (bool, error) {
+	return timed(s.policy, ctx, "TwoFactor.UseStep", func(ctx context.Context) (bool, error) {
+		return s.next.TwoFactor.UseStep(ctx, a1, a2)
+	})
+}
+
+func (s timedTwoFactor) UseRecoveryCode(ctx context.Context, a1 int64, a2 string) (bool, error) {
+	return timed(s.policy, ctx, "TwoFactor.UseRecoveryCode", func(ctx context.Context) (bool, error) {
+		return s.next.TwoFactor.UseRecoveryCode(ctx, a1, a2)
+	})
+}
+
+func (s timedTwoFactor) Disable(ctx context.Context, a1 int64) error {
+	return s.policy.run(ctx, "TwoFactor.Disable", func(ctx context.Context) error {
+		return s.next.TwoFactor.Disable(ctx, a1)
+	})
+}
+
+type timedPushTokens struct{ *timedStorage }
+
+func (s timedPushTokens) Upsert(ctx context.Context, a1 *PushToken) error {
+	return s.policy.run(ctx, "PushTokens.Upsert", func(ctx context.Context) error {
+		return s.next.PushTokens.Upsert(ctx, a1)
+	})
+}
+
+func (s timedPushTokens) Delete(ctx context.Context, a1 int64, a2 int64) error {
+	return s.policy.run(ctx, "PushTokens.Delete", func(ctx context.Context) error {
+		return s.next.PushTokens.Delete(ctx, a1, a2)
+	})
+}
+
+func (s timedPushTokens) ListActiveForUsers(ctx context.Context, a1 []int64) ([]*PushToken, error) {
+	return timed(s.policy, ctx, "PushTokens.ListActiveForUsers", func(ctx context.Context) ([]*PushToken, error) {
+		return s.next.PushTokens.ListActiveForUsers(ctx, a1)
+	})
+}
+
+func (s timedPushTokens) RecordFailure(ctx context.Context, a1 int64, a2 int) (bool, error) {
+	return timed(s.policy, ctx, "PushTokens.RecordFailure", func(ctx context.Context) (bool, error) {
+		return s.next.PushTokens.RecordFailure(ctx, a1, a2)
+	})
+}
+
+func (s timedPushTokens) RecordSuccess(ctx context.Context, a1 int64) error {
+	return s.policy.run(ctx, "PushTokens.RecordSuccess", func(ctx context.Context) error {
+		return s.next.PushTokens.RecordSuccess(ctx, a1)
+	})
+}
+
+type timedAttachments struct{ *timedStorage }
+
+func (s timedAttachments) Add(ctx context.Context, a1 *Attachment, a2 func() error) error {
+	return s.policy.run(ctx, "Attachments.Add", func(ctx context.Context) error {
+		return s.next.Attachments.Add(ctx, a1, a2)
+	})
+}
+
+func (s timedAttachments) GetByID(ctx context.Context, a1 int64, a2 int64) (*Attachment, error) {
+	return timed(s.policy, ctx, "Attachments.GetByID", func(ctx context.Context) (*Attachment, error) {
+		return s.next.Attachments.GetByID(ctx, a1, a2)
+	})
+}
+
+func (s timedAttachments) FinishThumbnail(ctx context.Context, a1 int64, a2 bool) ([]*Attachment, error) {
+	return timed(s.policy, ctx, "Attachments.FinishThumbnail", func(ctx context.Context) ([]*Attachment, error) {
+		return s.next.Attachments.FinishThumbnail(ctx, a1, a2)
+	})
+}
+
+func (s timedAttachments) PendingThumbnails(ctx context.Context) (map[int64]string, error) {
+	return timed(s.policy, ctx, "Attachments.PendingThumbnails", func(ctx context.Context) (map[int64]string, error) {
+		return s.next.Attachments.PendingThumbnails(ctx)
+	})
+}
+
+func (s timedAttachments) Remove(ctx context.Context, a1 int64, a2 int64, a3 func(string) error) error {
+	return s.policy.run(ctx, "Attachments.Remove", func(ctx context.Context) error {
+		return s.next.Attachments.Remove(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedAttachments) Stats(ctx context.Context) (*StorageStats, error) {
+	return timed(s.policy, ctx, "Attachments.Stats", func(ctx context.Context) (*StorageStats, error) {
+		return s.next.Attachments.Stats(ctx)
+	})
+}
+
+func (s timedAttachments) Search(ctx context.Context, a1 int64, a2 string, a3 int) ([]*Attachment, error) {
+	return timed(s.policy, ctx, "Attachments.Search", func(ctx context.Context) ([]*Attachment, error) {
+		return s.next.Attachments.Search(ctx, a1, a2, a3)
+	})
+}
+
+type timedTranslations struct{ *timedStorage }
+
+func (s timedTranslations) Get(ctx context.Context, a1 int64, a2 string, a3 string) (*MessageTranslation, error) {
+	return timed(s.policy, ctx, "Translations.Get", func(ctx context.Context) (*MessageTranslation, error) {
+		return s.next.Translations.Get(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedTranslations) Save(ctx context.Context, a1 *MessageTranslation) error {
+	return s.policy.run(ctx, "Translations.Save", func(ctx context.Context) error {
+		return s.next.Translations.Save(ctx, a1)
+	})
+}
+
+func (s timedTranslations) Invalidate(ctx context.Context, a1 int64) error {
+	return s.policy.run(ctx, "Translations.Invalidate", func(ctx context.Context) error {
+		return s.next.Translations.Invalidate(ctx, a1)
+	})
+}
+
+type timedReadMarkers struct{ *timedStorage }
+
+func (s timedReadMarkers) MarkRead(ctx context.Context, a1 int64, a2 int64, a3 int64, a4 int64) error {
+	return s.policy.run(ctx, "ReadMarkers.MarkRead", func(ctx context.Context) error {
+		return s.next.ReadMarkers.MarkRead(ctx, a1, a2, a3, a4)
+	})
+}
+
+func (s timedReadMarkers) GetUnreadCount(ctx context.Context, a1 int64, a2 int64) (int, error) {
+	return timed(s.policy, ctx, "ReadMarkers.GetUnreadCount", func(ctx context.Context) (int, error) {
+		return s.next.ReadMarkers.GetUnreadCount(ctx, a1, a2)
+	})
+}
+
+func (s timedReadMarkers) GetSyncState(ctx context.Context, a1 int64) ([]*RoomSyncState, error) {
+	return timed(s.policy, ctx, "ReadMarkers.GetSyncState", func(ctx context.Context) ([]*RoomSyncState, error) {
+		return s.next.ReadMarkers.GetSyncState(ctx, a1)
+	})
+}
+
+type timedJoinRequests struct{ *timedStorage }
+
+func (s timedJoinRequests) Knock(ctx context.Context, a1 int64, a2 int64, a3 time.Duration) (*JoinRequest, bool, error) {
+	return timed2(s.policy, ctx, "JoinRequests.Knock", func(ctx context.Context) (*JoinRequest, bool, error) {
+		return s.next.JoinRequests.Knock(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedJoinRequests) ListPending(ctx context.Context, a1 int64) ([]*JoinRequest, error) {
+	return timed(s.policy, ctx, "JoinRequests.ListPending", func(ctx context.Context) ([]*JoinRequest, error) {
+		return s.next.JoinRequests.ListPending(ctx, a1)
+	})
+}
+
+func (s timedJoinRequests) Approve(ctx context.Context, a1 int64, a2 int64, a3 int64) error {
+	return s.policy.run(ctx, "JoinRequests.Approve", func(ctx context.Context) error {
+		return s.next.JoinRequests.Approve(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedJoinRequests) Reject(ctx context.Context, a1 int64, a2 int64, a3 int64) error {
+	return s.policy.run(ctx, "JoinRequests.Reject", func(ctx context.Context) error {
+		return s.next.JoinRequests.Reject(ctx, a1, a2, a3)
+	})
+}
+
+type timedEmailInvites struct{ *timedStorage }
+
+func (s timedEmailInvites) Create(ctx context.Context, a1 *EmailInvite, a2 string, a3 time.Duration) error {
+	return s.policy.run(ctx, "EmailInvites.Create", func(ctx context.Context) error {
+		return s.next.EmailInvites.Create(ctx, a1, a2, a3)
+	})
+}
+
+type timedOAuthIdentities struct{ *timedStorage }
+
+func (s timedOAuthIdentities) GetUserID(ctx context.Context, a1 string, a2 string) (int64, error) {
+	return timed(s.policy, ctx, "OAuthIdentities.GetUserID", func(ctx context.Context) (int64, error) {
+		return s.next.OAuthIdentities.GetUserID(ctx, a1, a2)
+	})
+}
+
+func (s timedOAuthIdentities) Link(ctx context.Context, a1 *OAuthIdentity) error {
+	return s.policy.run(ctx, "OAuthIdentities.Link", func(ctx context.Context) error {
+		return s.next.OAuthIdentities.Link(ctx, a1)
+	})
+}
+
+type timedPasswordResets struct{ *timedStorage }
+
+func (s timedPasswordResets) Create(ctx context.Context, a1 int64, a2 string, a3 time.Duration) (time.Time, error) {
+	return timed(s.policy, ctx, "PasswordResets.Create", func(ctx context.Context) (time.Time, error) {
+		return s.next.PasswordResets.Create(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedPasswordResets) GetUserID(ctx context.Context, a1 string) (int64, error) {
+	return timed(s.policy, ctx, "PasswordResets.GetUserID", func(ctx context.Context) (int64, error) {
+		return s.next.PasswordResets.GetUserID(ctx, a1)
+	})
+}
+
+func (s timedPasswordResets) Consume(ctx context.Context, a1 string, a2 string) ([]int64, error) {
+	return timed(s.policy, ctx, "PasswordResets.Consume", func(ctx context.Context) ([]int64, error) {
+		return s.next.PasswordResets.Consume(ctx, a1, a2)
+	})
+}
+
+func (s timedPasswordResets) PurgeExpired(ctx context.Context, a1 time.Time) (int64, error) {
+	return timed(s.policy, ctx, "PasswordResets.PurgeExpired", func(ctx context.Context) (int64, error) {
+		return s.next.PasswordResets.PurgeExpired(ctx, a1)
+	})
+}
+
+type timedRoomInvites struct{ *timedStorage }
+
+func (s timedRoomInvites) Create(ctx context.Context, a1 *RoomInvite, a2 time.Duration) error {
+	return s.policy.run(ctx, "RoomInvites.Create", func(ctx context.Context) error {
+		return s.next.RoomInvites.Create(ctx, a1, a2)
+	})
+}
+
+func (s timedRoomInvites) ListPending(ctx context.Context, a1 int64) ([]*RoomInvite, error) {
+	return timed(s.policy, ctx, "RoomInvites.ListPending", func(ctx context.Context) ([]*RoomInvite, error) {
+		return s.next.RoomInvites.ListPending(ctx, a1)
+	})
+}
+
+func (s timedRoomInvites) Respond(ctx context.Context, a1 int64, a2 int64, a3 bool) (*RoomInvite, bool, error) {
+	return timed2(s.policy, ctx, "RoomInvites.Respond", func(ctx context.Context) (*RoomInvite, bool, error) {
+		return s.next.RoomInvites.Respond(ctx, a1, a2, a3)
+	})
+}
+
+type timedReceipts struct{ *timedStorage }
+
+func (s timedReceipts) MarkDelivered(ctx context.Context, a1 []DeliveryMark) error {
+	return s.policy.run(ctx, "Receipts.MarkDelivered", func(ctx context.Context) error {
+		return s.next.Receipts.MarkDelivered(ctx, a1)
+	})
+}
+
+func (s timedReceipts) MarkDeliveredLatest(ctx context.Context, a1 int64, a2 int64) error {
+	return s.policy.run(ctx, "Receipts.MarkDeliveredLatest", func(ctx context.Context) error {
+		return s.next.Receipts.MarkDeliveredLatest(ctx, a1, a2)
+	})
+}
+
+func (s timedReceipts) GetReceipts(ctx context.Context, a1 int64, a2 int64) (*MessageReceipts, error) {
+	return timed(s.policy, ctx, "Receipts.GetReceipts", func(ctx context.Context) (*MessageReceipts, error) {
+		return s.next.Receipts.GetReceipts(ctx, a1, a2)
+	})
+}
+
+type timedExports struct{ *timedStorage }
+
+func (s timedExports) CreateJob(ctx context.Context, a1 int64, a2 time.Time) (*ExportJob, error) {
+	return timed(s.policy, ctx, "Exports.CreateJob", func(ctx context.Context) (*ExportJob, error) {
+		return s.next.Exports.CreateJob(ctx, a1, a2)
+	})
+}
+
+func (s timedExports) CompleteJob(ctx context.Context, a1 int64, a2 string) error {
+	return s.policy.run(ctx, "Exports.CompleteJob", func(ctx context.Context) error {
+		return s.next.Exports.CompleteJob(ctx, a1, a2)
+	})
+}
+
+func (s timedExports) FailJob(ctx context.Context, a1 int64, a2 string) error {
+	return s.policy.run(ctx, "Exports.FailJob", func(ctx context.Context) error {
+		return s.next.Exports.FailJob(ctx, a1, a2)
+	})
+}
+
+func (s timedExports) GetJob(ctx context.Context, a1 int64, a2 int64) (*ExportJob, error) {
+	return timed(s.policy, ctx, "Exports.GetJob", func(ctx context.Context) (*ExportJob, error) {
+		return s.next.Exports.GetJob(ctx, a1, a2)
+	})
+}
+
+func (s timedExports) CountUserMessages(ctx context.Context, a1 int64) (int, error) {
+	return timed(s.policy, ctx, "Exports.CountUserMessages", func(ctx context.Context) (int, error) {
+		return s.next.Exports.CountUserMessages(ctx, a1)
+	})
+}
+
+func (s timedExports) GetUserMemberships(ctx context.Context, a1 int64) ([]*ExportedMembership, error) {
+	return timed(s.policy, ctx, "Exports.GetUserMemberships", func(ctx context.Context) ([]*ExportedMembership, error) {
+		return s.next.Exports.GetUserMemberships(ctx, a1)
+	})
+}
+
+func (s timedExports) GetUserJoinRequests(ctx context.Context, a1 int64) ([]*JoinRequest, error) {
+	return timed(s.policy, ctx, "Exports.GetUserJoinRequests", func(ctx context.Context) ([]*JoinRequest, error) {
+		return s.next.Exports.GetUserJoinRequests(ctx, a1)
+	})
+}
+
+func (s timedExports) GetUserDevices(ctx context.Context, a1 int64) ([]*Device, error) {
+	return timed(s.policy, ctx, "Exports.GetUserDevices", func(ctx context.Context) ([]*Device, error) {
+		return s.next.Exports.GetUserDevices(ctx, a1)
+	})
+}
+
+func (s timedExports) GetUserPosts(ctx context.Context, a1 int64) ([]*Post, error) {
+	return timed(s.policy, ctx, "Exports.GetUserPosts", func(ctx context.Context) ([]*Post, error) {
+		return s.next.Exports.GetUserPosts(ctx, a1)
+	})
+}
+
+func (s timedExports) StreamUserMessages(ctx context.Context, a1 int64, a2 func(*ExportedMessage) error) error {
+	return s.policy.run(ctx, "Exports.StreamUserMessages", func(ctx context.Context) error {
+		return s.next.Exports.StreamUserMessages(ctx, a1, a2)
+	})
+}
+
+type timedRoomTemplates struct{ *timedStorage }
+
+func (s timedRoomTemplates) Create(ctx context.Context, a1 *RoomTemplate) error {
+	return s.policy.run(ctx, "RoomTemplates.Create", func(ctx context.Context) error {
+		return s.next.RoomTemplates.Create(ctx, a1)
+	})
+}
+
+func (s timedRoomTemplates) GetByID(ctx context.Context, a1 int64, a2 int64) (*RoomTemplate, error) {
+	return timed(s.policy, ctx, "RoomTemplates.GetByID", func(ctx context.Context) (*RoomTemplate, error) {
+		return s.next.RoomTemplates.GetByID(ctx, a1, a2)
+	})
+}
+
+func (s timedRoomTemplates) ListForOwner(ctx context.Context, a1 int64) ([]*RoomTemplate, error) {
+	return timed(s.policy, ctx, "RoomTemplates.ListForOwner", func(ctx context.Context) ([]*RoomTemplate, error) {
+		return s.next.RoomTemplates.ListForOwner(ctx, a1)
+	})
+}
+
+type timedRoomPermissions struct{ *timedStorage }
+
+func (s timedRoomPermissions) Get(ctx context.Context, a1 int64) (RoomPermissions, error) {
+	return timed(s.policy, ctx, "RoomPermissions.Get", func(ctx context.Context) (RoomPermissions, error) {
+		return s.next.RoomPermissions.Get(ctx, a1)
+	})
+}
+
+func (s timedRoomPermissions) GetAccess(ctx context.Context, a1 int64, a2 int64) (*RoomAccess, error) {
+	return timed(s.policy, ctx, "RoomPermissions.GetAccess", func(ctx context.Context) (*RoomAccess, error) {
+		return s.next.RoomPermissions.GetAccess(ctx, a1, a2)
+	})
+}
+
+func (s timedRoomPermissions) Update(ctx context.Context, a1 int64, a2 RoomPermissions) (RoomPermissions, error) {
+	return timed(s.policy, ctx, "RoomPermissions.Update", func(ctx context.Context) (RoomPermissions, error) {
+		return s.next.RoomPermissions.Update(ctx, a1, a2)
+	})
+}
+
+type timedModerationHooks struct{ *timedStorage }
+
+func (s timedModerationHooks) Get(ctx context.Context, a1 int64) (*ModerationHook, error) {
+	return timed(s.policy, ctx, "ModerationHooks.Get", func(ctx context.Context) (*ModerationHook, error) {
+		return s.next.ModerationHooks.Get(ctx, a1)
+	})
+}
+
+func (s timedModerationHooks) Save(ctx context.Context, a1 *ModerationHook) error {
+	return s.policy.run(ctx, "ModerationHooks.Save", func(ctx context.Context) error {
+		return s.next.ModerationHooks.Save(ctx, a1)
+	})
+}
+
+func (s timedModerationHooks) Disable(ctx context.Context, a1 int64, a2 string) error {
+	return s.policy.run(ctx, "ModerationHooks.Disable", func(ctx context.Context) error {
+		return s.next.ModerationHooks.Disable(ctx, a1, a2)
+	})
+}
+
+func (s timedModerationHooks) Delete(ctx context.Context, a1 int64) error {
+	return s.policy.run(ctx, "ModerationHooks.Delete", func(ctx context.Context) error {
+		return s.next.ModerationHooks.Delete(ctx, a1)
+	})
+}
+
+type timedOutgoingWebhooks struct{ *timedStorage }
+
+func (s timedOutgoingWebhooks) Create(ctx context.Context, a1 *OutgoingWebhook) error {
+	return s.policy.run(ctx, "OutgoingWebhooks.Create", func(ctx context.Context) error {
+		return s.next.OutgoingWebhooks.Create(ctx, a1)
+	})
+}
+
+func (s timedOutgoingWebhooks) Get(ctx context.Context, a1 int64, a2 int64) (*OutgoingWebhook, error) {
+	return timed(s.policy, ctx, "OutgoingWebhooks.Get", func(ctx context.Context) (*OutgoingWebhook, error) {
+		return s.next.OutgoingWebhooks.Get(ctx, a1, a2)
+	})
+}
+
+func (s timedOutgoingWebhooks) ListForRoom(ctx context.Context, a1 int64) ([]*OutgoingWebhook, error) {
+	return timed(s.policy, ctx, "OutgoingWebhooks.ListForRoom", func(ctx context.Context) ([]*OutgoingWebhook, error) {
+		return s.next.OutgoingWebhooks.ListForRoom(ctx, a1)
+	})
+}
+
+func (s timedOutgoingWebhooks) ListEnabled(ctx context.Context) ([]*OutgoingWebhook, error) {
+	return timed(s.policy, ctx, "OutgoingWebhooks.ListEnabled", func(ctx context.Context) ([]*OutgoingWebhook, error) {
+		return s.next.OutgoingWebhooks.ListEnabled(ctx)
+	})
+}
+
+func (s timedOutgoingWebhooks) Delete(ctx context.Context, a1 int64, a2 int64) error {
+	return s.policy.run(ctx, "OutgoingWebhooks.Delete", func(ctx context.Context) error {
+		return s.next.OutgoingWebhooks.Delete(ctx, a1, a2)
+	})
+}
+
+func (s timedOutgoingWebhooks) AdvanceSeq(ctx context.Context, a1 int64, a2 int64, a3 int64) (bool, error) {
+	return timed(s.policy, ctx, "OutgoingWebhooks.AdvanceSeq", func(ctx context.Context) (bool, error) {
+		return s.next.OutgoingWebhooks.AdvanceSeq(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedOutgoingWebhooks) SkipEvents(ctx context.Context, a1 int64) error {
+	return s.policy.run(ctx, "OutgoingWebhooks.SkipEvents", func(ctx context.Context) error {
+		return s.next.OutgoingWebhooks.SkipEvents(ctx, a1)
+	})
+}
+
+func (s timedOutgoingWebhooks) RecordDelivery(ctx context.Context, a1 *OutgoingWebhookDelivery) (int, error) {
+	return timed(s.policy, ctx, "OutgoingWebhooks.RecordDelivery", func(ctx context.Context) (int, error) {
+		return s.next.OutgoingWebhooks.RecordDelivery(ctx, a1)
+	})
+}
+
+func (s timedOutgoingWebhooks) Disable(ctx context.Context, a1 int64, a2 string) (bool, error) {
+	return timed(s.policy, ctx, "OutgoingWebhooks.Disable", func(ctx context.Context) (bool, error) {
+		return s.next.OutgoingWebhooks.Disable(ctx, a1, a2)
+	})
+}
+
+func (s timedOutgoingWebhooks) ListDeliveries(ctx context.Context, a1 int64, a2 int) ([]*OutgoingWebhookDelivery, error) {
+	return timed(s.policy, ctx, "OutgoingWebhooks.ListDeliveries", func(ctx context.Context) ([]*OutgoingWebhookDelivery, error) {
+		return s.next.OutgoingWebhooks.ListDeliveries(ctx, a1, a2)
+	})
+}
+
+type timedFeatureFlags struct{ *timedStorage }
+
+func (s timedFeatureFlags) List(ctx context.Context) ([]*FeatureFlag, error) {
+	return timed(s.policy, ctx, "FeatureFlags.List", func(ctx context.Context) ([]*FeatureFlag, error) {
+		return s.next.FeatureFlags.List(ctx)
+	})
+}
+
+func (s timedFeatureFlags) Save(ctx context.Context, a1 *FeatureFlag) error {
+	return s.policy.run(ctx, "FeatureFlags.Save", func(ctx context.Context) error {
+		return s.next.FeatureFlags.Save(ctx, a1)
+	})
+}
+
+type timedReports struct{ *timedStorage }
+
+func (s timedReports) Create(ctx context.Context, a1 *Report) error {
+	return s.policy.run(ctx, "Reports.Create", func(ctx context.Context) error {
+		return s.next.Reports.Create(ctx, a1)
+	})
+}
+
+func (s timedReports) ListForRoom(ctx context.Context, a1 int64, a2 int, a3 int) ([]*Report, error) {
+	return timed(s.policy, ctx, "Reports.ListForRoom", func(ctx context.Context) ([]*Report, error) {
+		return s.next.Reports.ListForRoom(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedReports) List(ctx context.Context, a1 string, a2 int, a3 int) ([]*Report, error) {
+	return timed(s.policy, ctx, "Reports.List", func(ctx context.Context) ([]*Report, error) {
+		return s.next.Reports.List(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedReports) UpdateStatus(ctx context.Context, a1 int64, a2 string, a3 string, a4 string) (*Report, error) {
+	return timed(s.policy, ctx, "Reports.UpdateStatus", func(ctx context.Context) (*Report, error) {
+		return s.next.Reports.UpdateStatus(ctx, a1, a2, a3, a4)
+	})
+}
+
+type timedRedactions struct{ *timedStorage }
+
+func (s timedRedactions) Redact(ctx context.Context, a1 int64, a2 string, a3 string) (*Redaction, error) {
+	return timed(s.policy, ctx, "Redactions.Redact", func(ctx context.Context) (*Redaction, error) {
+		return s.next.Redactions.Redact(ctx, a1, a2, a3)
+	})
+}
+
+func (s timedRedactions) ListMessages(ctx context.Context, a1 AdminMessageQuery) ([]*AdminMessage, error) {
+	return timed(s.policy, ctx, "Redactions.ListMessages", func(ctx context.Context) ([]*AdminMessage, error) {
+		return s.next.Redactions.ListMessages(ctx, a1)
+	})
+}
+
+// withTimeouts wraps every store of next so its methods run under policy
+func withTimeouts(next Storage, policy *timeoutPolicy) Storage {
+	s := &timedStorage{policy: policy, next: next}
+	return Storage{
+		Posts:                   timedPosts{s},
+		Users:                   timedUsers{s},
+		Rooms:                   timedRooms{s},
+		Messages:                timedMessages{s},
+		RoomMembers:             timedRoomMembers{s},
+		MembershipEvents:        timedMembershipEvents{s},
+		Pins:                    timedPins{s},
+		RoomEvents:              timedRoomEvents{s},
+		UserEvents:              timedUserEvents{s},
+		Digests:                 timedDigests{s},
+		NotificationPreferences: timedNotificationPreferences{s},
+		Devices:                 timedDevices{s},
+		APITokens:               timedAPITokens{s},
+		APIKeys:                 timedAPIKeys{s},
+		Sessions:                timedSessions{s},
+		TwoFactor:               timedTwoFactor{s},
+		PushTokens:              timedPushTokens{s},
+		Attachments:             timedAttachments{s},
+		Translations:            timedTranslations{s},
+		ReadMarkers:             timedReadMarkers{s},
+		JoinRequests:            timedJoinRequests{s},
+		EmailInvites:            timedEmailInvites{s},
+		OAuthIdentities:         timedOAuthIdentities{s},
+		PasswordResets:          timedPasswordResets{s},
+		RoomInvites:             timedRoomInvites{s},
+		Receipts:                timedReceipts{s},
+		Exports:                 timedExports{s},
+		RoomTemplates:           timedRoomTemplates{s},
+		RoomPermissions:         timedRoomPermissions{s},
+		ModerationHooks:         timedModerationHooks{s},
+		OutgoingWebhooks:        timedOutgoingWebhooks{s},
+		FeatureFlags:            timedFeatureFlags{s},
+		Reports:                 timedReports{s},
+		Redactions:              timedRedactions{s},
+	}
+}
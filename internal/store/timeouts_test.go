@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// testTimeouts are short enough to wait out, and far enough apart to tell apart
+var testTimeouts = Timeouts{Read: 20 * time.Millisecond, Write: 60 * time.Millisecond, Bulk: 120 * time.Millisecond}
+
+// TestOperationTimeouts runs one operation of each category against a
+// database that takes a second to answer: each gives up at its category's
+// timeout and is counted, while a caller's earlier deadline cuts a bulk
+// operation short without being counted as the store's
+func TestOperationTimeouts(t *testing.T) {
+	for _, tc := range []struct {
+		method string
+		query  string
+		want   time.Duration
+		call   func(context.Context, Storage) error
+	}{
+		{"Users.GetByID", `FROM users`, testTimeouts.Read, func(ctx context.Context, st Storage) error {
+			_, err := st.Users.GetByID(ctx, 1)
+			return err
+		}},
+		{"Posts.Create", `INSERT INTO posts`, testTimeouts.Write, func(ctx context.Context, st Storage) error {
+			return st.Posts.Create(ctx, &Post{Title: "t", Content: "c", UserID: 1})
+		}},
+		{"Exports.StreamUserMessages", `FROM messages m`, testTimeouts.Bulk, func(ctx context.Context, st Storage) error {
+			return st.Exports.StreamUserMessages(ctx, 1, func(*ExportedMessage) error { return nil })
+		}},
+	} {
+		db, mock := newMockDB(t)
+		pools := NewPools(db, nil)
+		st := NewPostgresStorage(pools, Limits{Timeouts: testTimeouts})
+		mock.ExpectQuery(tc.query).WillDelayFor(time.Second).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		start := time.Now()
+		err := tc.call(context.Background(), st)
+		if took := time.Since(start); err == nil || took < tc.want || took > tc.want+200*time.Millisecond {
+			t.Errorf("%s returned %v after %s, want an error after %s", tc.method, err, took, tc.want)
+		}
+		if counts := pools.Stats().DeadlineExceeded; counts[tc.method] != 1 || len(counts) != 1 {
+			t.Errorf("%s: the timeouts counted are %v, want one for it", tc.method, counts)
+		}
+	}
+
+	db, mock := newMockDB(t)
+	pools := NewPools(db, nil)
+	st := NewPostgresStorage(pools, Limits{Timeouts: testTimeouts})
+	mock.ExpectQuery(`FROM messages m`).WillDelayFor(time.Second).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := st.Exports.StreamUserMessages(ctx, 1, func(*ExportedMessage) error { return nil })
+	if took := time.Since(start); err == nil || took >= testTimeouts.Bulk {
+		t.Errorf("with a 5ms deadline, the export returned %v after %s, want an error before %s", err, took, testTimeouts.Bulk)
+	}
+	if counts := pools.Stats().DeadlineExceeded; counts != nil {
+		t.Errorf("the caller's deadline was counted as the store's: %v", counts)
+	}
+}
+
+// TestOperationCategories checks how methods are told apart, and that every
+// method listed as bulk exists, so a rename doesn't quietly give it a
+// shorter timeout
+func TestOperationCategories(t *testing.T) {
+	for method, want := range map[string]string{
+		"Users.GetByID":                 OpRead,
+		"RoomMembers.IsUserInRoom":      OpRead,
+		"Messages.FirstMessageAt":       OpRead,
+		"Posts.Create":                  OpWrite,
+		"Sessions.Touch":                OpWrite,
+		"Rooms.PurgeExpired":            OpBulk,
+		"Exports.StreamUserMessages":    OpBulk,
+		"Attachments.PendingThumbnails": OpBulk,
+	} {
+		if got := operationCategory(method); got != want {
+			t.Errorf("%s is a %s operation, want %s", method, got, want)
+		}
+	}
+
+	storage := reflect.TypeOf(Storage{})
+	for method := range bulkOperations {
+		field, name, _ := strings.Cut(method, ".")
+		f, ok := storage.FieldByName(field)
+		if !ok {
+			t.Errorf("%s: Storage has no %s", method, field)
+			continue
+		}
+		if _, ok := f.Type.MethodByName(name); !ok {
+			t.Errorf("%s: Storage.%s has no %s method", method, field, name)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"context"
+
+	"github.com/lib/pq"
+)
+
+// GetTokenVersion returns the version a user's new access tokens carry;
+// tokens with an older one are revoked
+// Returns sql.ErrNoRows if the user doesn't exist
+func (s *UserStore) GetTokenVersion(ctx context.Context, userID int64) (int64, error) {
+	query := `SELECT token_version FROM users WHERE id = $1 AND id > 0`
+	var version int64
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&version)
+	return version, err
+}
+
+// RevokeTokens signs a user out everywhere: it bumps their token version and
+// deletes their sessions in one transaction. Personal access tokens are kept
+// Returns the new version and the deleted sessions' IDs
+// Returns sql.ErrNoRows if the user doesn't exist
+func (s *UserStore) RevokeTokens(ctx context.Context, userID int64) (int64, []int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	versionQuery := `
+		UPDATE users SET token_version = token_version + 1
+		WHERE id = $1 AND id > 0
+		RETURNING token_version
+	`
+	var version int64
+	if err := tx.QueryRowContext(ctx, versionQuery, userID).Scan(&version); err != nil {
+		return 0, nil, err
+	}
+
+	var sessionIDs []int64
+	sessionsQuery := `
+		WITH deleted AS (DELETE FROM sessions WHERE user_id = $1 RETURNING id)
+		SELECT COALESCE(array_agg(id ORDER BY id), '{}') FROM deleted
+	`
+	if err := tx.QueryRowContext(ctx, sessionsQuery, userID).Scan(pq.Array(&sessionIDs)); err != nil {
+		return 0, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, nil, err
+	}
+	return version, sessionIDs, nil
+}
@@ -0,0 +1,33 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestRevokeTokens bumps the user's token version and deletes their sessions
+// in the same transaction, returning both
+func TestRevokeTokens(t *testing.T) {
+	db, mock := newMockDB(t)
+	users := &UserStore{db: db}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE users SET token_version = token_version \+ 1.+RETURNING token_version`).WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"token_version"}).AddRow(3))
+	mock.ExpectQuery(`DELETE FROM sessions WHERE user_id = \$1`).WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"ids"}).AddRow("{4,9}"))
+	mock.ExpectCommit()
+
+	version, sessions, err := users.RevokeTokens(context.Background(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 3 {
+		t.Errorf("got version %d, want 3", version)
+	}
+	if len(sessions) != 2 || sessions[0] != 4 || sessions[1] != 9 {
+		t.Errorf("got sessions %v, want 4 and 9", sessions)
+	}
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+)
+
+// MessageTranslation is a cached translation of one message into one language
+type MessageTranslation struct {
+	MessageID  int64     `json:"message_id"`
+	TargetLang string    `json:"target_lang"`
+	SourceLang string    `json:"source_lang"`
+	Text       string    `json:"text"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	// SourceHash identifies the content that was translated (see TranslationSourceHash)
+	SourceHash string `json:"-"`
+}
+
+// TranslationSourceHash returns the hash stored with a translation of content
+// Unlike content.Hash it's taken over the exact text, so any edit, even one
+// that only changes case or spacing, makes the cached translation stale
+func TranslationSourceHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// MessageTranslationStore handles the translation cache
+type MessageTranslationStore struct {
+	db *sql.DB
+}
+
+// Get returns the cached translation of a message, if it is still current
+// A translation of different content than sourceHash (the message was edited
+// since) is deleted and reported as sql.ErrNoRows, like a miss
+func (s *MessageTranslationStore) Get(ctx context.Context, messageID int64, targetLang, sourceHash string) (*MessageTranslation, error) {
+	query := `
+		SELECT message_id, target_lang, source_lang, text, created_at, source_hash
+		FROM message_translations
+		WHERE message_id = $1 AND target_lang = $2
+	`
+
+	t := &MessageTranslation{}
+	err := s.db.QueryRowContext(ctx, query, messageID, targetLang).Scan(
+		&t.MessageID,
+		&t.TargetLang,
+		&t.SourceLang,
+		&t.Text,
+		&t.CreatedAt,
+		&t.SourceHash,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.SourceHash != sourceHash {
+		if err := s.Invalidate(ctx, messageID); err != nil {
+			return nil, err
+		}
+		return nil, sql.ErrNoRows
+	}
+	return t, nil
+}
+
+// Save stores a translation, replacing any earlier one for the same language
+func (s *MessageTranslationStore) Save(ctx context.Context, t *MessageTranslation) error {
+	query := `
+		INSERT INTO message_translations (message_id, target_lang, source_lang, source_hash, text)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (message_id, target_lang) DO UPDATE
+		SET source_lang = EXCLUDED.source_lang, source_hash = EXCLUDED.source_hash,
+			text = EXCLUDED.text, created_at = NOW()
+		RETURNING created_at
+	`
+
+	return s.db.QueryRowContext(ctx, query, t.MessageID, t.TargetLang, t.SourceLang, t.SourceHash, t.Text).Scan(&t.CreatedAt)
+}
+
+// Invalidate drops every cached translation of a message
+// Paths that change or remove a message's content should call it; deleting
+// the message row does it automatically (ON DELETE CASCADE)
+func (s *MessageTranslationStore) Invalidate(ctx context.Context, messageID int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM message_translations WHERE message_id = $1`, messageID)
+	return err
+}
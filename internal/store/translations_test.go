@@ -0,0 +1,55 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestTranslationCache reads a cached translation: one of the current text
+// is returned, one of text that has since changed is deleted and reported
+// as a miss
+func TestTranslationCache(t *testing.T) {
+	columns := []string{"message_id", "target_lang", "source_lang", "text", "created_at", "source_hash"}
+	current := TranslationSourceHash("good morning")
+
+	for _, tc := range []struct {
+		name   string
+		cached string
+		want   error
+	}{
+		{"current", "good morning", nil},
+		{"edited since", "good night", sql.ErrNoRows},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			translations := &MessageTranslationStore{db}
+
+			mock.ExpectQuery(`FROM message_translations\s+WHERE message_id = \$1 AND target_lang = \$2`).WithArgs(int64(1), "de").
+				WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "de", "en", "gut Morgen", time.Now(), TranslationSourceHash(tc.cached)))
+			if tc.want != nil {
+				mock.ExpectExec(`DELETE FROM message_translations WHERE message_id = \$1`).WithArgs(int64(1)).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+			}
+
+			got, err := translations.Get(context.Background(), 1, "de", current)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("got %v, want %v", err, tc.want)
+			}
+			if tc.want == nil && got.Text != "gut Morgen" {
+				t.Errorf("got %+v, want the cached translation", got)
+			}
+		})
+	}
+}
+
+// TestTranslationSourceHash tells apart edits content.Hash would not
+func TestTranslationSourceHash(t *testing.T) {
+	if TranslationSourceHash("Hello") == TranslationSourceHash("hello") || TranslationSourceHash("hi") == TranslationSourceHash("hi ") {
+		t.Error("edits to case or spacing don't change the hash")
+	}
+}
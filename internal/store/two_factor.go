@@ -0,0 +1,183 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrTwoFactorEnabled is returned when setting up 2FA for an account that already has it on
+// It has to be turned off first, which takes a code
+var ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+
+// TwoFactor is a user's two-factor authentication state
+type TwoFactor struct {
+	UserID int64
+
+	// Secret is the encrypted TOTP secret; nil until 2FA is set up
+	Secret []byte
+
+	// Enabled is set once a first code was confirmed; until then the secret
+	// exists but logins don't ask for a code
+	Enabled   bool
+	EnabledAt *time.Time
+
+	// LastStep is the time step of the last code accepted; codes from it or
+	// earlier steps are refused
+	LastStep int64
+
+	// RecoveryCodesLeft is how many unused recovery codes remain
+	RecoveryCodesLeft int
+}
+
+// TwoFactorStore handles 2FA settings on users and their recovery codes
+type TwoFactorStore struct {
+	db *sql.DB
+}
+
+// Get returns a user's 2FA state
+func (s *TwoFactorStore) Get(ctx context.Context, userID int64) (*TwoFactor, error) {
+	query := `
+		SELECT u.id, u.totp_secret, u.two_factor_enabled, u.two_factor_enabled_at, u.totp_last_step,
+			(SELECT COUNT(*) FROM two_factor_recovery_codes c WHERE c.user_id = u.id AND c.used_at IS NULL)
+		FROM users u
+		WHERE u.id = $1
+	`
+
+	tf := &TwoFactor{}
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(
+		&tf.UserID,
+		&tf.Secret,
+		&tf.Enabled,
+		&tf.EnabledAt,
+		&tf.LastStep,
+		&tf.RecoveryCodesLeft,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return tf, nil
+}
+
+// Setup stores a new secret and recovery codes for a user who doesn't have 2FA on
+// An earlier setup that was never confirmed is replaced, codes and all
+// Returns ErrTwoFactorEnabled if 2FA is already on
+func (s *TwoFactorStore) Setup(ctx context.Context, userID int64, secret []byte, codeHashes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE users SET totp_secret = $2, totp_last_step = 0
+		WHERE id = $1 AND NOT two_factor_enabled
+	`, userID, secret)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrTwoFactorEnabled
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM two_factor_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO two_factor_recovery_codes (user_id, code_hash)
+		SELECT $1, unnest($2::text[])
+	`, userID, pq.Array(codeHashes))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Enable turns 2FA on after the user confirmed a code from the given step
+// Returns sql.ErrNoRows if there's no secret to enable or 2FA is already on
+func (s *TwoFactorStore) Enable(ctx context.Context, userID, step int64) error {
+	query := `
+		UPDATE users
+		SET two_factor_enabled = TRUE, two_factor_enabled_at = NOW(), totp_last_step = $2
+		WHERE id = $1 AND totp_secret IS NOT NULL AND NOT two_factor_enabled AND totp_last_step < $2
+	`
+	result, err := s.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// UseStep records that a code from the given step was accepted
+// Returns false if that step (or a later one) was already used: the code is
+// a replay. The check and the update are one statement, so two requests
+// racing with the same code can't both win
+func (s *TwoFactorStore) UseStep(ctx context.Context, userID, step int64) (bool, error) {
+	query := `UPDATE users SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2`
+	result, err := s.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// UseRecoveryCode spends one of a user's recovery codes
+// Returns false if no unused code has that hash
+func (s *TwoFactorStore) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) (bool, error) {
+	query := `
+		UPDATE two_factor_recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+	result, err := s.db.ExecContext(ctx, query, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// Disable turns 2FA off and forgets the secret and recovery codes
+func (s *TwoFactorStore) Disable(ctx context.Context, userID int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users
+		SET totp_secret = NULL, two_factor_enabled = FALSE, two_factor_enabled_at = NULL
+		WHERE id = $1
+	`, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM two_factor_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestUseStep accepts a step once: the update only matches while the stored
+// step is older, so a replayed code changes nothing and is refused
+func TestUseStep(t *testing.T) {
+	db, mock := newMockDB(t)
+	twoFactor := &TwoFactorStore{db}
+
+	for _, rows := range []int64{1, 0} {
+		mock.ExpectExec(`UPDATE users SET totp_last_step = \$2 WHERE id = \$1 AND totp_last_step < \$2`).
+			WithArgs(int64(1), int64(37037037)).
+			WillReturnResult(sqlmock.NewResult(0, rows))
+	}
+
+	if fresh, err := twoFactor.UseStep(context.Background(), 1, 37037037); err != nil || !fresh {
+		t.Errorf("the first use got %v, %v; want fresh", fresh, err)
+	}
+	if fresh, err := twoFactor.UseStep(context.Background(), 1, 37037037); err != nil || fresh {
+		t.Errorf("the replay got %v, %v; want refused", fresh, err)
+	}
+}
+
+// TestSetupWhileEnabled refuses a new secret while 2FA is on, without
+// touching the recovery codes
+func TestSetupWhileEnabled(t *testing.T) {
+	db, mock := newMockDB(t)
+	twoFactor := &TwoFactorStore{db}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users SET totp_secret = \$2, totp_last_step = 0\s+WHERE id = \$1 AND NOT two_factor_enabled`).
+		WithArgs(int64(1), []byte("sealed")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if err := twoFactor.Setup(context.Background(), 1, []byte("sealed"), []string{"hash"}); !errors.Is(err, ErrTwoFactorEnabled) {
+		t.Errorf("got %v, want ErrTwoFactorEnabled", err)
+	}
+}
@@ -0,0 +1,149 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestDeleteUser deletes a user who owns a room, is a member of two and has
+// written messages, on the scratch database: the messages stay under the
+// username they were sent with and read as a deleted author, the owned room
+// survives without a creator, the memberships are gone, and every history,
+// pin, summary and receipt lookup still works
+func TestDeleteUser(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	limits := Limits{MaxRoomMembers: 100, MaxRoomsPerUser: 100}
+	rooms := &RoomStore{db, limits, NewPools(db, nil)}
+	members := &RoomMemberStore{db, limits}
+	messages := &MessageStore{db, NewPools(db, nil)}
+	pins := &PinStore{db}
+	receipts := &ReceiptStore{db}
+	suffix := time.Now().UnixNano()
+
+	var ada, grace int64
+	adaName := fmt.Sprintf("leaving-ada-%d", suffix)
+	for _, user := range []struct {
+		name string
+		id   *int64
+	}{{adaName, &ada}, {fmt.Sprintf("leaving-grace-%d", suffix), &grace}} {
+		query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.invalid', '!') RETURNING id`
+		if err := db.QueryRowContext(ctx, query, user.name).Scan(user.id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	owned := &Room{Name: fmt.Sprintf("leaving-owned-%d", suffix), CreatedBy: ada}
+	joined := &Room{Name: fmt.Sprintf("leaving-joined-%d", suffix), CreatedBy: grace}
+	for _, room := range []*Room{owned, joined} {
+		if err := rooms.Create(ctx, room); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		for _, room := range []*Room{owned, joined} {
+			db.Exec(`DELETE FROM rooms WHERE id = $1`, room.ID)
+		}
+		for _, id := range []int64{ada, grace} {
+			db.Exec(`DELETE FROM users WHERE id = $1`, id)
+		}
+	})
+	for _, room := range []*Room{owned, joined} {
+		for _, id := range []int64{ada, grace} {
+			if err := members.Join(ctx, room.ID, id, id); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	written := &Message{RoomID: joined.ID, UserID: ada, Content: "goodbye"}
+	for _, message := range []*Message{
+		{RoomID: owned.ID, UserID: ada, Content: "hello"},
+		written,
+	} {
+		if err := messages.Create(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := pins.Pin(ctx, joined.ID, written.ID, grace); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, ada); err != nil {
+		t.Fatalf("deleting the user: %v", err)
+	}
+
+	// The tables, as documented in the migration
+	var authored, nulled, memberships int
+	err := db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM messages WHERE room_id IN ($1, $2) AND author_username = $3),
+			(SELECT COUNT(*) FROM messages WHERE room_id IN ($1, $2) AND user_id IS NULL),
+			(SELECT COUNT(*) FROM room_members WHERE user_id = $4)
+	`, owned.ID, joined.ID, adaName, ada).Scan(&authored, &nulled, &memberships)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authored != 2 || nulled != 2 || memberships != 0 {
+		t.Errorf("after the delete: %d messages under the old username, %d without an author and %d memberships; want 2, 2 and 0",
+			authored, nulled, memberships)
+	}
+
+	// History reads the old username and flags the author as deleted
+	history, err := messages.GetRoomMessages(ctx, joined.ID, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID, err := messages.GetByID(ctx, written.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range append(history, byID) {
+		if message.System {
+			continue
+		}
+		if message.UserID != 0 || message.Username != adaName || !message.AuthorDeleted {
+			t.Errorf("message %d reads as user %d %q (author deleted %v), want 0 %q and deleted",
+				message.ID, message.UserID, message.Username, message.AuthorDeleted, adaName)
+		}
+	}
+
+	pinned, _, err := pins.List(ctx, joined.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pinned) != 1 || pinned[0].Username != adaName || !pinned[0].AuthorDeleted {
+		t.Errorf("got pins %+v, want the message under %q with a deleted author", pinned, adaName)
+	}
+
+	if _, err := receipts.GetReceipts(ctx, joined.ID, written.ID); err != nil {
+		t.Errorf("getting the receipts: %v", err)
+	}
+
+	// The owned room survives its creator, with one member left
+	room, err := rooms.GetByID(ctx, owned.ID)
+	if err != nil {
+		t.Fatalf("the owned room is gone: %v", err)
+	}
+	if room.CreatedBy != 0 || room.MemberCount != 1 {
+		t.Errorf("the owned room has creator %d and %d members, want 0 and 1", room.CreatedBy, room.MemberCount)
+	}
+
+	summaries, err := rooms.GetUserRoomSummaries(ctx, grace, RoomListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, summary := range summaries {
+		if summary.ID != owned.ID && summary.ID != joined.ID || summary.LastMessage == nil {
+			continue
+		}
+		if last := summary.LastMessage; last.Username != adaName || !last.AuthorDeleted || last.UserID != 0 {
+			t.Errorf("room %d's last message reads as user %d %q (author deleted %v), want 0 %q and deleted",
+				summary.ID, last.UserID, last.Username, last.AuthorDeleted, adaName)
+		}
+	}
+}
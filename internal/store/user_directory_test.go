@@ -0,0 +1,65 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testdb"
+)
+
+// TestUserDirectory searches a few seeded users on the scratch database:
+// prefix matches on the username come first, then on the display name,
+// then anywhere; hiding a user drops them from search but not from lookup
+func TestUserDirectory(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	users := &UserStore{db, Limits{}, NewPools(db, nil)}
+	// Letters only, so the suffix doesn't make every seeded name match
+	tag := fmt.Sprintf("q%x", time.Now().UnixNano())
+
+	seed := func(username, displayName string) int64 {
+		t.Helper()
+		var id int64
+		query := `INSERT INTO users (username, email, password, display_name) VALUES ($1, $1 || '@example.invalid', '!', $2) RETURNING id`
+		if err := db.QueryRowContext(ctx, query, username, displayName).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Exec(`DELETE FROM users WHERE id = $1`, id) })
+		return id
+	}
+	middle := seed("x"+tag+"-ada", "")
+	display := seed("grace-"+tag[:6]+"z", tag+" Hopper")
+	longer := seed(tag+"-lovelace", "")
+	prefix := seed(tag+"-ada", "")
+	hidden := seed(tag+"-ken", "")
+
+	discoverable := false
+	if _, err := users.UpdateProfile(ctx, hidden, nil, &discoverable, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := users.Search(ctx, tag, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int64
+	for _, u := range found {
+		got = append(got, u.ID)
+	}
+	// Shorter usernames first within a rank
+	want := []int64{prefix, longer, display, middle}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("search ranked %v, want %v", got, want)
+	}
+
+	if user, err := users.GetByUsername(ctx, tag+"-ken"); err != nil || user.ID != hidden {
+		t.Errorf("looking up the hidden user got %+v, %v", user, err)
+	}
+	if found, _ := users.Search(ctx, tag+"%", 50); len(found) != 0 {
+		t.Errorf("a literal %% matched %d users", len(found))
+	}
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// UserEvent is one frame delivered to a user, as the hub numbered it
+// The hub keeps a user's recent frames in memory; only the ones pushed out
+// of a full buffer are written here, when USER_EVENT_SPILL is on
+type UserEvent struct {
+	UserID    int64
+	Seq       int64           // The frame's user_seq
+	Frame     json.RawMessage // The frame as sent, user_seq included
+	CreatedAt time.Time       // When it was sent
+}
+
+// UserEventStore handles the spilled frames of users' event streams
+type UserEventStore struct {
+	db *sql.DB
+}
+
+// Append writes a batch of frames in one statement
+// A frame already written (same user and user_seq) is skipped, so a batch
+// retried after a timeout doesn't fail on the rows that made it
+func (s *UserEventStore) Append(ctx context.Context, events []*UserEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	userIDs := make([]int64, len(events))
+	seqs := make([]int64, len(events))
+	frames := make([]string, len(events))
+	sentAt := make([]int64, len(events))
+	for i, event := range events {
+		userIDs[i] = event.UserID
+		seqs[i] = event.Seq
+		frames[i] = string(event.Frame)
+		sentAt[i] = event.CreatedAt.UnixMicro()
+	}
+
+	query := `
+		INSERT INTO user_events (user_id, user_seq, frame, created_at)
+		SELECT e.user_id, e.user_seq, e.frame::json, to_timestamp(e.sent_at / 1000000.0)
+		FROM unnest($1::bigint[], $2::bigint[], $3::text[], $4::bigint[]) AS e(user_id, user_seq, frame, sent_at)
+		ON CONFLICT (user_id, user_seq) DO NOTHING
+	`
+	_, err := s.db.ExecContext(ctx, query, pq.Array(userIDs), pq.Array(seqs), pq.Array(frames), pq.Array(sentAt))
+	return err
+}
+
+// ListAfter returns up to limit of a user's frames with a user_seq above afterSeq, oldest first
+// Frames past the retention may still be here until the next purge; the hub
+// skips them
+func (s *UserEventStore) ListAfter(ctx context.Context, userID, afterSeq int64, limit int) ([]*UserEvent, error) {
+	query := `
+		SELECT user_id, user_seq, frame, created_at
+		FROM user_events
+		WHERE user_id = $1 AND user_seq > $2
+		ORDER BY user_seq
+		LIMIT $3
+	`
+	rows, err := s.db.QueryContext(ctx, query, userID, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*UserEvent, 0)
+	for rows.Next() {
+		event := &UserEvent{}
+		if err := rows.Scan(&event.UserID, &event.Seq, &event.Frame, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// PurgeOlderThan deletes frames sent before the cutoff
+// Returns the number of frames removed
+func (s *UserEventStore) PurgeOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM user_events WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
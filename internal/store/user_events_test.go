@@ -0,0 +1,53 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// TestAppendUserEvents writes a batch in one statement, skipping frames
+// already written; an empty batch doesn't reach the database
+func TestAppendUserEvents(t *testing.T) {
+	db, mock := newMockDB(t)
+	events := &UserEventStore{db}
+	sent := time.UnixMicro(1_700_000_000_123_456)
+
+	if err := events.Append(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec(`INSERT INTO user_events \(user_id, user_seq, frame, created_at\)[\s\S]+FROM unnest\([\s\S]+ON CONFLICT \(user_id, user_seq\) DO NOTHING`).
+		WithArgs(pq.Array([]int64{1, 1}), pq.Array([]int64{7, 8}), pq.Array([]string{`{"user_seq":7}`, `{"user_seq":8}`}), pq.Array([]int64{sent.UnixMicro(), sent.UnixMicro()})).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	err := events.Append(context.Background(), []*UserEvent{
+		{UserID: 1, Seq: 7, Frame: []byte(`{"user_seq":7}`), CreatedAt: sent},
+		{UserID: 1, Seq: 8, Frame: []byte(`{"user_seq":8}`), CreatedAt: sent},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestListUserEventsAfter reads one user's frames after a user_seq, oldest first
+func TestListUserEventsAfter(t *testing.T) {
+	db, mock := newMockDB(t)
+	events := &UserEventStore{db}
+	now := time.Now()
+
+	mock.ExpectQuery(`FROM user_events\s+WHERE user_id = \$1 AND user_seq > \$2\s+ORDER BY user_seq\s+LIMIT \$3`).WithArgs(int64(1), int64(6), 2).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "user_seq", "frame", "created_at"}).
+			AddRow(1, 7, []byte(`{"user_seq":7,"type":"message"}`), now).
+			AddRow(1, 8, []byte(`{"user_seq":8,"type":"join"}`), now))
+
+	got, err := events.ListAfter(context.Background(), 1, 6, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Seq != 7 || got[1].Seq != 8 || string(got[1].Frame) != `{"user_seq":8,"type":"join"}` {
+		t.Errorf("got %+v, want frames 7 and 8", got)
+	}
+}
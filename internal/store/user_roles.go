@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"slices"
+
+	"github.com/lib/pq"
+)
+
+// Server-wide user roles, unlike RoomRole*, which only apply in one room
+const (
+	UserRoleMember    = "member"    // Everyone, by default
+	UserRoleModerator = "moderator" // Trusted with moderation; ranks above members
+	UserRoleAdmin     = "admin"     // May delete any room and ban users
+)
+
+// UserRoles lists every server role, from least to most trusted
+var UserRoles = []string{UserRoleMember, UserRoleModerator, UserRoleAdmin}
+
+// UserRoleAtLeast reports whether role is least or a more trusted one
+// Unknown roles never qualify, and an unknown least is never met
+func UserRoleAtLeast(role, least string) bool {
+	have, want := slices.Index(UserRoles, role), slices.Index(UserRoles, least)
+	return have >= 0 && want >= 0 && have >= want
+}
+
+// GetRole returns a user's server role
+// Returns sql.ErrNoRows if the user doesn't exist
+func (s *UserStore) GetRole(ctx context.Context, userID int64) (string, error) {
+	query := `SELECT role FROM users WHERE id = $1 AND id > 0`
+	var role string
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&role)
+	return role, err
+}
+
+// SetRole changes a user's server role, one of UserRoles
+// Returns sql.ErrNoRows if the user doesn't exist
+func (s *UserStore) SetRole(ctx context.Context, userID int64, role string) error {
+	query := `UPDATE users SET role = $2, updated_at = NOW() WHERE id = $1 AND id > 0`
+	return expectOneRow(s.db.ExecContext(ctx, query, userID, role))
+}
+
+// IsBanned reports whether a user is banned
+// Returns sql.ErrNoRows if the user doesn't exist
+func (s *UserStore) IsBanned(ctx context.Context, userID int64) (bool, error) {
+	query := `SELECT banned_at IS NOT NULL FROM users WHERE id = $1 AND id > 0`
+	var banned bool
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&banned)
+	return banned, err
+}
+
+// Ban bans a user and signs them out: their token version is bumped and their
+// sessions and personal access tokens are deleted in the same transaction
+// Returns the deleted sessions' IDs
+// Banning a banned user keeps the original time. Admins can't be banned:
+// like a missing user, that returns sql.ErrNoRows
+func (s *UserStore) Ban(ctx context.Context, userID int64) ([]int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	banQuery := `
+		UPDATE users
+		SET banned_at = COALESCE(banned_at, NOW()), token_version = token_version + 1, updated_at = NOW()
+		WHERE id = $1 AND id > 0 AND role <> 'admin'
+	`
+	if err := expectOneRow(tx.ExecContext(ctx, banQuery, userID)); err != nil {
+		return nil, err
+	}
+
+	var sessionIDs []int64
+	sessionsQuery := `
+		WITH deleted AS (DELETE FROM sessions WHERE user_id = $1 RETURNING id)
+		SELECT COALESCE(array_agg(id ORDER BY id), '{}') FROM deleted
+	`
+	if err := tx.QueryRowContext(ctx, sessionsQuery, userID).Scan(pq.Array(&sessionIDs)); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM api_tokens WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return sessionIDs, nil
+}
+
+// Unban lifts a user's ban; they can sign in again
+// Returns sql.ErrNoRows if the user doesn't exist
+func (s *UserStore) Unban(ctx context.Context, userID int64) error {
+	query := `UPDATE users SET banned_at = NULL, updated_at = NOW() WHERE id = $1 AND id > 0`
+	return expectOneRow(s.db.ExecContext(ctx, query, userID))
+}
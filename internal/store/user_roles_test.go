@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestUserRoleAtLeast ranks admins over moderators over members
+func TestUserRoleAtLeast(t *testing.T) {
+	tests := []struct {
+		role, least string
+		want        bool
+	}{
+		{UserRoleAdmin, UserRoleModerator, true},
+		{UserRoleModerator, UserRoleModerator, true},
+		{UserRoleMember, UserRoleModerator, false},
+		{UserRoleModerator, UserRoleAdmin, false},
+		{"root", UserRoleMember, false},
+		{UserRoleAdmin, "root", false},
+	}
+	for _, tc := range tests {
+		if got := UserRoleAtLeast(tc.role, tc.least); got != tc.want {
+			t.Errorf("UserRoleAtLeast(%q, %q) = %v, want %v", tc.role, tc.least, got, tc.want)
+		}
+	}
+}
+
+// TestBanUser bans a member: their token version is bumped and their
+// sessions and tokens deleted in the same transaction, and the sessions' IDs
+// returned
+func TestBanUser(t *testing.T) {
+	db, mock := newMockDB(t)
+	users := &UserStore{db: db}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users\s+SET banned_at = COALESCE\(banned_at, NOW\(\)\), token_version = token_version \+ 1.+AND role <> 'admin'`).WithArgs(int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`DELETE FROM sessions WHERE user_id = \$1`).WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"ids"}).AddRow("{4,9}"))
+	mock.ExpectExec(`DELETE FROM api_tokens WHERE user_id = \$1`).WithArgs(int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	sessions, err := users.Ban(context.Background(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0] != 4 || sessions[1] != 9 {
+		t.Errorf("got sessions %v, want 4 and 9", sessions)
+	}
+}
+
+// TestBanAdmin refuses to ban an admin without signing them out
+func TestBanAdmin(t *testing.T) {
+	db, mock := newMockDB(t)
+	users := &UserStore{db: db}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users\s+SET banned_at`).WithArgs(int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if _, err := users.Ban(context.Background(), 1); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v, want sql.ErrNoRows", err)
+	}
+}
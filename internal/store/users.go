@@ -3,36 +3,80 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"slices"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type User struct {
-	ID        int64     `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	Password  string    `json:"-"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID           int64     `json:"id"`
+	Username     string    `json:"username"`
+	DisplayName  string    `json:"display_name"`
+	Email        string    `json:"email"`
+	Password     string    `json:"-"`
+	Discoverable bool      `json:"discoverable"` // Whether the user appears in directory search
+	Version      int64     `json:"version"`      // Bumped on every profile update (optimistic locking)
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// PublicUser is the part of a profile anyone signed in may see
+type PublicUser struct {
+	ID          int64  `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
 }
 
 type UserStore struct {
-	db *sql.DB
+	db     *sql.DB
+	limits Limits // Applied when new accounts join the default rooms
+	reads  *Pools // Search may run on the read replica
 }
 
-func (s *UserStore) Create(ctx context.Context, user *User) error {
+// SystemUserID is the reserved user that server-originated messages (merge
+// notices and the like) are posted as, so history attributes them consistently
+// It's negative so it can never clash with a real account, and queries leave
+// it out with "id > 0": it can't log in, isn't found by search or username
+// lookups, and so can't be added to rooms either. It never joins any room
+const SystemUserID int64 = -1
+
+// systemUserEmail is never used to send mail; it only fills the unique column
+const systemUserEmail = "system@system.invalid"
+
+// EnsureSystemUser creates the system user if it's missing, or renames it to username
+// Its password is "!", which no bcrypt hash matches, so it can't log in even
+// if someone found its email
+// Fails with a unique violation if a real account already has the username
+func (s *UserStore) EnsureSystemUser(ctx context.Context, username string) error {
 	query := `
-		INSERT INTO users (username, email, password)
-		VALUES ($1, $2, $3) RETURNING id, created_at, updated_at
+		INSERT INTO users (id, username, email, password, discoverable)
+		VALUES ($1, $2, $3, '!', FALSE)
+		ON CONFLICT (id) DO UPDATE SET username = EXCLUDED.username
 	`
+	_, err := s.db.ExecContext(ctx, query, SystemUserID, username, systemUserEmail)
+	return err
+}
+
+// createUserQuery inserts a user and returns the columns the database fills in
+const createUserQuery = `
+	INSERT INTO users (username, email, password)
+	VALUES ($1, $2, $3) RETURNING id, display_name, discoverable, version, created_at, updated_at
+`
 
+func (s *UserStore) Create(ctx context.Context, user *User) error {
 	err := s.db.QueryRowContext(
 		ctx,
-		query,
+		createUserQuery,
 		user.Username,
 		user.Email,
 		user.Password,
 	).Scan(
 		&user.ID,
+		&user.DisplayName,
+		&user.Discoverable,
+		&user.Version,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -42,21 +86,118 @@ func (s *UserStore) Create(ctx context.Context, user *User) error {
 	return nil
 }
 
+// CreateWithDefaultRooms creates a user and joins them to every default room
+// in one transaction, so an account never exists without its default rooms
+// The joins are quiet (see JoinOptions) and recorded as system actions
+// A default room that is full is skipped rather than failing the registration,
+// and joining stops once the user reaches the rooms-per-user limit
+// With inviteTokenHash set, the user's open email invites are accepted in the
+// same transaction (see acceptEmailInvites) and their outcomes returned
+// Returns the rooms joined, default or invited, as they are after the join
+func (s *UserStore) CreateWithDefaultRooms(ctx context.Context, user *User, inviteTokenHash string) ([]*Room, []*EmailInviteOutcome, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, createUserQuery, user.Username, user.Email, user.Password).Scan(
+		&user.ID, &user.DisplayName, &user.Discoverable, &user.Version, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM rooms WHERE is_default AND deleted_at IS NULL ORDER BY id`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defaultIDs := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		defaultIDs = append(defaultIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	joined := make([]int64, 0, len(defaultIDs))
+	for _, roomID := range defaultIDs {
+		err := addMember(ctx, tx, s.limits, roomID, user.ID, JoinOptions{Quiet: true})
+		if errors.Is(err, ErrRoomFull) {
+			continue
+		}
+		if errors.Is(err, ErrTooManyRooms) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		joined = append(joined, roomID)
+	}
+
+	var invites []*EmailInviteOutcome
+	if inviteTokenHash != "" {
+		invites, err = acceptEmailInvites(ctx, tx, s.limits, user, inviteTokenHash)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, invite := range invites {
+			if invite.Status == EmailInviteJoined && !slices.Contains(joined, invite.RoomID) {
+				joined = append(joined, invite.RoomID)
+			}
+		}
+	}
+
+	roomsQuery := `
+		SELECT ` + roomColumns + `
+		FROM rooms r
+		WHERE r.id = ANY($1)
+		ORDER BY r.id
+	`
+	rows, err = tx.QueryContext(ctx, roomsQuery, pq.Array(joined))
+	if err != nil {
+		return nil, nil, err
+	}
+	rooms, err := scanRooms(rows)
+	rows.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, room := range rooms {
+		fillRoomComputed(room, s.limits)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return rooms, invites, nil
+}
+
 // GetByEmail retrieves a user by their email address
 // This is used during login to find the user and verify their password
+// The system user is never returned, so it can't log in
 func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		SELECT id, username, email, password, created_at, updated_at
+		SELECT id, username, display_name, email, password, discoverable, version, created_at, updated_at
 		FROM users
-		WHERE email = $1
+		WHERE email = $1 AND id > 0
 	`
 
 	user := &User{}
 	err := s.db.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
 		&user.Username,
+		&user.DisplayName,
 		&user.Email,
 		&user.Password, // Password is included here for authentication
+		&user.Discoverable,
+		&user.Version,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -70,7 +211,7 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error)
 // This is used to get user information when we have a user ID from JWT or context
 func (s *UserStore) GetByID(ctx context.Context, id int64) (*User, error) {
 	query := `
-		SELECT id, username, email, password, created_at, updated_at
+		SELECT id, username, display_name, email, password, discoverable, version, created_at, updated_at
 		FROM users
 		WHERE id = $1
 	`
@@ -79,8 +220,11 @@ func (s *UserStore) GetByID(ctx context.Context, id int64) (*User, error) {
 	err := s.db.QueryRowContext(ctx, query, id).Scan(
 		&user.ID,
 		&user.Username,
+		&user.DisplayName,
 		&user.Email,
 		&user.Password,
+		&user.Discoverable,
+		&user.Version,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -89,3 +233,214 @@ func (s *UserStore) GetByID(ctx context.Context, id int64) (*User, error) {
 	}
 	return user, nil
 }
+
+// GetByUsernames retrieves the users matching any of the given usernames or IDs
+// Both lists are resolved in one query; names and IDs with no user (or the
+// system user) are simply missing from the result, in no particular order
+// Passwords are not loaded
+func (s *UserStore) GetByUsernames(ctx context.Context, usernames []string, ids []int64) ([]*User, error) {
+	query := `
+		SELECT id, username, display_name, email, discoverable, version, created_at, updated_at
+		FROM users
+		WHERE (username = ANY($1) OR id = ANY($2)) AND id > 0
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(usernames), pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]*User, 0)
+	for rows.Next() {
+		user := &User{}
+		err := rows.Scan(
+			&user.ID,
+			&user.Username,
+			&user.DisplayName,
+			&user.Email,
+			&user.Discoverable,
+			&user.Version,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// GetByEmails retrieves the users registered with any of the given addresses
+// Addresses are matched case-insensitively; ones with no user are simply
+// missing from the result. Passwords are not loaded
+func (s *UserStore) GetByEmails(ctx context.Context, emails []string) ([]*User, error) {
+	query := `
+		SELECT id, username, display_name, email, discoverable, version, created_at, updated_at
+		FROM users
+		WHERE LOWER(email) = ANY($1) AND id > 0
+	`
+
+	normalized := make([]string, len(emails))
+	for i, email := range emails {
+		normalized[i] = NormalizeEmail(email)
+	}
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(normalized))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]*User, 0)
+	for rows.Next() {
+		user := &User{}
+		err := rows.Scan(
+			&user.ID,
+			&user.Username,
+			&user.DisplayName,
+			&user.Email,
+			&user.Discoverable,
+			&user.Version,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// GetByUsername retrieves a user's public profile by exact username
+// Works for every user, discoverable or not: knowing the exact name is enough
+// The system user isn't a user anyone can look up
+func (s *UserStore) GetByUsername(ctx context.Context, username string) (*PublicUser, error) {
+	query := `
+		SELECT id, username, display_name
+		FROM users
+		WHERE username = $1 AND id > 0
+	`
+
+	user := &PublicUser{}
+	err := s.db.QueryRowContext(ctx, query, username).Scan(&user.ID, &user.Username, &user.DisplayName)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Search finds discoverable users whose username or display name contains q, ignoring case
+// Results are ranked: username starting with q, then display name starting
+// with q, then any other match; shorter usernames first within each rank
+func (s *UserStore) Search(ctx context.Context, q string, limit int) ([]*PublicUser, error) {
+	// q is matched literally, so LIKE's wildcards in it must be escaped
+	q = searchTerm(q)
+	if q == "" {
+		// An empty pattern would match everyone
+		return make([]*PublicUser, 0), nil
+	}
+	pattern := escapeLike(q)
+	limit, _ = clampPage(limit, 0, 20, 50)
+
+	query := `
+		SELECT id, username, display_name
+		FROM users
+		WHERE discoverable AND id > 0
+		  AND (username ILIKE '%' || $1 || '%' OR display_name ILIKE '%' || $1 || '%')
+		ORDER BY
+			CASE
+				WHEN username ILIKE $1 || '%' THEN 0
+				WHEN display_name ILIKE $1 || '%' THEN 1
+				ELSE 2
+			END,
+			LENGTH(username),
+			username
+		LIMIT $2
+	`
+
+	rows, err := s.reads.query(ctx, "UserStore.Search", query, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]*PublicUser, 0)
+	for rows.Next() {
+		user := &PublicUser{}
+		if err := rows.Scan(&user.ID, &user.Username, &user.DisplayName); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// UpdateProfile changes a user's own profile settings
+// nil fields are left as they are
+// With a non-zero expectedVersion the update only happens if the profile is
+// still at that version, else it returns ErrVersionConflict (see RoomStore.Update)
+func (s *UserStore) UpdateProfile(ctx context.Context, userID int64, displayName *string, discoverable *bool, expectedVersion int64) (*User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+		SET display_name = COALESCE($2, display_name),
+		    discoverable = COALESCE($3, discoverable),
+		    updated_at = NOW(),
+		    version = version + 1
+		WHERE id = $1 AND ($4::bigint = 0 OR version = $4)
+		RETURNING id, username, display_name, email, discoverable, version, created_at, updated_at
+	`
+
+	user := &User{}
+	err = tx.QueryRowContext(ctx, query, userID, displayName, discoverable, expectedVersion).Scan(
+		&user.ID,
+		&user.Username,
+		&user.DisplayName,
+		&user.Email,
+		&user.Discoverable,
+		&user.Version,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) && expectedVersion != 0 {
+		return nil, versionConflict(ctx, tx, "users", userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Delete permanently deletes a user by their ID
+// Everything referencing them is deleted or unlinked by the foreign keys
+// (sessions and memberships go, rooms they created lose their owner)
+// There's no account deletion for users; this is for cleaning up an account
+// that was never used, such as the self-test's
+func (s *UserStore) Delete(ctx context.Context, id int64) error {
+	query := `DELETE FROM users WHERE id = $1`
+	_, err := s.db.ExecContext(ctx, query, id)
+	return err
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestSearchUsers checks search skips non-discoverable users and the system
+// user, ranks prefix matches first, and matches LIKE wildcards in the query
+// literally
+func TestSearchUsers(t *testing.T) {
+	for _, tc := range []struct {
+		q, pattern string
+	}{
+		{"ada", "ada"},
+		{"50%", `50\%`},
+		{"a_b", `a\_b`},
+		{`c:\`, `c:\\`},
+	} {
+		db, mock := newMockDB(t)
+		users := &UserStore{db, Limits{}, NewPools(db, nil)}
+
+		mock.ExpectQuery(`WHERE discoverable AND id > 0\s+AND \(username ILIKE '%' \|\| \$1 \|\| '%' OR display_name ILIKE '%' \|\| \$1 \|\| '%'\)\s+ORDER BY\s+CASE\s+WHEN username ILIKE \$1 \|\| '%' THEN 0\s+WHEN display_name ILIKE \$1 \|\| '%' THEN 1`).
+			WithArgs(tc.pattern, 20).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "username", "display_name"}).AddRow(1, "ada", "Ada"))
+
+		got, err := users.Search(context.Background(), tc.q, 20)
+		if err != nil {
+			t.Fatalf("searching %q: %v", tc.q, err)
+		}
+		if len(got) != 1 || got[0].Username != "ada" {
+			t.Errorf("searching %q got %+v", tc.q, got)
+		}
+	}
+}
+
+// TestGetByUsername looks a user up by exact name, whether or not they're
+// discoverable, as long as it isn't the system user
+func TestGetByUsername(t *testing.T) {
+	db, mock := newMockDB(t)
+	users := &UserStore{db, Limits{}, NewPools(db, nil)}
+
+	mock.ExpectQuery(`SELECT id, username, display_name\s+FROM users\s+WHERE username = \$1 AND id > 0\s*$`).WithArgs("grace").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "display_name"}).AddRow(2, "grace", "Grace"))
+	got, err := users.GetByUsername(context.Background(), "grace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != 2 || got.DisplayName != "Grace" {
+		t.Errorf("got %+v, want grace", got)
+	}
+}
+
+// TestUpdateProfile leaves fields that weren't given as they are
+func TestUpdateProfile(t *testing.T) {
+	db, mock := newMockDB(t)
+	users := &UserStore{db, Limits{}, NewPools(db, nil)}
+	hidden := false
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SET display_name = COALESCE\(\$2, display_name\),\s+discoverable = COALESCE\(\$3, discoverable\)`).
+		WithArgs(int64(2), nil, false, int64(0)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "display_name", "email", "discoverable", "version", "created_at", "updated_at"}).
+			AddRow(2, "grace", "Grace", "grace@example.com", false, 4, now, now))
+	mock.ExpectCommit()
+	got, err := users.UpdateProfile(context.Background(), 2, nil, &hidden, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Discoverable || got.DisplayName != "Grace" || got.Version != 4 {
+		t.Errorf("got %+v, want grace hidden with their name kept", got)
+	}
+}
+
+// TestUpdateProfileVersionConflict saves a profile at a stale version
+func TestUpdateProfileVersionConflict(t *testing.T) {
+	db, mock := newMockDB(t)
+	users := &UserStore{db, Limits{}, NewPools(db, nil)}
+	name := "Grace"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`WHERE id = \$1 AND \(\$4::bigint = 0 OR version = \$4\)`).
+		WithArgs(int64(2), name, nil, int64(3)).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM users WHERE id = \$1\)`).WithArgs(int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	if _, err := users.UpdateProfile(context.Background(), 2, &name, nil, 3); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("got %v, want ErrVersionConflict", err)
+	}
+}
@@ -0,0 +1,168 @@
+// Package sysmsg renders system messages in a room's language
+//
+// System messages (joins, leaves, merge notices) are saved as a
+// store.SystemEvent, an event name with parameters, rather than as text.
+// Render turns one into a sentence from the catalog of the room's language
+// each time it's read or broadcast, so changing a room's language changes
+// how its existing system messages read too
+package sysmsg
+
+import (
+	"embed"
+	"encoding/json"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// DefaultLanguage is used for languages without a catalog and for events a
+// catalog lacks
+const DefaultLanguage = "en"
+
+// Events and the parameters they carry
+const (
+	EventJoin    = "join"    // username
+	EventLeave   = "leave"   // username
+	EventMerged  = "merged"  // source: the name of the room merged in
+	EventRemoved = "removed" // username
+)
+
+// Join returns the event announcing that a user joined the room
+func Join(username string) store.SystemEvent {
+	return store.SystemEvent{"event": EventJoin, "username": username}
+}
+
+// Leave returns the event announcing that a user left the room
+func Leave(username string) store.SystemEvent {
+	return store.SystemEvent{"event": EventLeave, "username": username}
+}
+
+// Removed returns the event announcing that a user was removed from the room
+func Removed(username string) store.SystemEvent {
+	return store.SystemEvent{"event": EventRemoved, "username": username}
+}
+
+// Merged returns the event announcing that another room was merged into this one
+func Merged(source string) store.SystemEvent {
+	return store.SystemEvent{"event": EventMerged, "source": source}
+}
+
+// localeFiles embeds the catalogs, one per language (en.json, de.json, ...)
+// Each maps an event to its template; "{name}" is replaced by the parameter
+//
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalog holds every language's templates, keyed by language then event
+// It's loaded once and only read afterwards
+var catalog = mustLoadCatalog()
+
+// mustLoadCatalog parses the embedded catalogs
+// A broken catalog is a programming error, so it fails at startup
+func mustLoadCatalog() map[string]map[string]string {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		log.Fatalf("failed to read system message catalog: %v", err)
+	}
+
+	c := make(map[string]map[string]string)
+	for _, entry := range entries {
+		data, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			log.Fatalf("failed to read system messages %s: %v", entry.Name(), err)
+		}
+		templates := make(map[string]string)
+		if err := json.Unmarshal(data, &templates); err != nil {
+			log.Fatalf("failed to parse system messages %s: %v", entry.Name(), err)
+		}
+		c[strings.TrimSuffix(entry.Name(), ".json")] = templates
+	}
+
+	if _, ok := c[DefaultLanguage]; !ok {
+		log.Fatalf("system message catalog is missing the default language %q", DefaultLanguage)
+	}
+	return c
+}
+
+// unknownEvents remembers the events already logged as missing from the catalog
+var unknownEvents sync.Map
+
+// Render returns the text of a system message in a language
+// The language is tried as given ("de-AT") and by its base language ("de"),
+// then English. An event no catalog knows renders as its name, and is logged
+// once, so a message is never shown empty
+func Render(event store.SystemEvent, language string) string {
+	name := event.Name()
+	template, ok := lookup(name, strings.ToLower(language))
+	if !ok {
+		if _, logged := unknownEvents.LoadOrStore(name, true); !logged {
+			log.Printf("System message event %q has no text in the catalog", name)
+		}
+		return name
+	}
+
+	replacements := make([]string, 0, 2*len(event))
+	for key, value := range event {
+		if key != "event" {
+			replacements = append(replacements, "{"+key+"}", value)
+		}
+	}
+	return strings.NewReplacer(replacements...).Replace(template)
+}
+
+// lookup finds an event's template for a lowercase language tag
+func lookup(event, language string) (string, bool) {
+	for {
+		if template, ok := catalog[language][event]; ok {
+			return template, true
+		}
+		i := strings.LastIndex(language, "-")
+		if i < 0 {
+			break
+		}
+		language = language[:i]
+	}
+	template, ok := catalog[DefaultLanguage][event]
+	return template, ok
+}
+
+// languageTag matches well-formed BCP 47 tags: a language, then optional
+// script, region and variants (private use and extensions aren't accepted)
+var languageTag = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z]{4})?(-[a-zA-Z]{2}|-[0-9]{3})?(-[a-zA-Z0-9]{5,8}|-[0-9][a-zA-Z0-9]{3})*$`)
+
+// CanonicalTag checks a BCP 47 language tag and returns it in its usual
+// case: "pt-br" becomes "pt-BR", "zh-hant-tw" becomes "zh-Hant-TW"
+// Any well-formed tag is accepted, with or without a catalog; Render falls
+// back to English for the ones without
+func CanonicalTag(tag string) (string, bool) {
+	if !languageTag.MatchString(tag) {
+		return "", false
+	}
+	subtags := strings.Split(tag, "-")
+	subtags[0] = strings.ToLower(subtags[0])
+	for i := 1; i < len(subtags); i++ {
+		switch s := subtags[i]; {
+		case len(s) == 4 && i == 1 && isLetters(s):
+			subtags[i] = strings.ToUpper(s[:1]) + strings.ToLower(s[1:])
+		case len(s) == 2:
+			subtags[i] = strings.ToUpper(s)
+		default:
+			subtags[i] = strings.ToLower(s)
+		}
+	}
+	return strings.Join(subtags, "-"), true
+}
+
+// isLetters reports whether s is all ASCII letters
+func isLetters(s string) bool {
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package sysmsg
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestRender renders each kind of event: the room's language is used as
+// given, then by its base language, then in English; an event no catalog
+// knows comes out as its name
+func TestRender(t *testing.T) {
+	for _, c := range []struct {
+		event    store.SystemEvent
+		language string
+		want     string
+	}{
+		{Join("ada"), "en", "ada joined the room"},
+		{Leave("ada"), "de", "ada hat den Raum verlassen"},
+		{Leave("grace"), "de-AT", "grace hat den Raum verlassen"},
+		{Leave("grace"), "DE", "grace hat den Raum verlassen"},
+		{Merged("random"), "fr", "random was merged into this room"},
+		{Join("ada"), "", "ada joined the room"},
+		{store.SystemEvent{"event": "renamed", "name": "lobby"}, "de", "renamed"},
+		// A parameter's own braces aren't expanded again
+		{Join("{username}"), "en", "{username} joined the room"},
+	} {
+		if got := Render(c.event, c.language); got != c.want {
+			t.Errorf("Render(%v, %q) = %q, want %q", c.event, c.language, got, c.want)
+		}
+	}
+}
+
+// TestCatalogs checks every catalog has text for every event and mentions
+// each of its parameters
+func TestCatalogs(t *testing.T) {
+	events := map[string]store.SystemEvent{
+		EventJoin:    Join("x"),
+		EventLeave:   Leave("x"),
+		EventMerged:  Merged("x"),
+		EventRemoved: Removed("x"),
+	}
+	for _, language := range slices.Sorted(maps.Keys(catalog)) {
+		templates := catalog[language]
+		for name, event := range events {
+			template, ok := templates[name]
+			if !ok {
+				t.Errorf("%s has no text for %q", language, name)
+				continue
+			}
+			for key := range event {
+				if key != "event" && !strings.Contains(template, "{"+key+"}") {
+					t.Errorf("%s's text for %q doesn't use {%s}", language, name, key)
+				}
+			}
+		}
+		for name := range templates {
+			if _, ok := events[name]; !ok {
+				t.Errorf("%s has text for %q, which isn't an event", language, name)
+			}
+		}
+	}
+}
+
+// TestCanonicalTag accepts well-formed tags in any case and returns them in
+// their usual one, and refuses anything else
+func TestCanonicalTag(t *testing.T) {
+	for _, c := range []struct {
+		tag  string
+		want string // Empty if the tag is refused
+	}{
+		{"en", "en"},
+		{"DE", "de"},
+		{"pt-br", "pt-BR"},
+		{"zh-hant-tw", "zh-Hant-TW"},
+		{"es-419", "es-419"},
+		{"sl-ROZAJ", "sl-rozaj"},
+		{"de-CH-1996", "de-CH-1996"},
+		{"", ""},
+		{"english", ""},
+		{"e", ""},
+		{"en_US", ""},
+		{"en-", ""},
+		{"x-private", ""},
+		{"en-US-u-ca-gregory", ""},
+	} {
+		got, ok := CanonicalTag(c.tag)
+		if ok != (c.want != "") || got != c.want {
+			t.Errorf("CanonicalTag(%q) = %q, %t; want %q", c.tag, got, ok, c.want)
+		}
+	}
+}
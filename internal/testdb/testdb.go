@@ -0,0 +1,34 @@
+// Package testdb connects integration tests (built with -tags integration)
+// to the scratch database in TEST_DATABASE_URL
+package testdb
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	_ "github.com/lib/pq"
+)
+
+// Open connects to the scratch database, skipping the test when
+// TEST_DATABASE_URL isn't set
+// The database must already be migrated (DB_ADDR=$TEST_DATABASE_URL make migrate-up)
+// The tests add rows and never remove them; don't point it at real data
+func Open(t testing.TB) *sql.DB {
+	t.Helper()
+	addr := os.Getenv("TEST_DATABASE_URL")
+	if addr == "" {
+		t.Skip("TEST_DATABASE_URL isn't set")
+	}
+
+	db, err := sql.Open("postgres", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Ping(); err != nil {
+		t.Fatalf("connecting to the test database: %v", err)
+	}
+	return db
+}
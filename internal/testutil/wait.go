@@ -0,0 +1,17 @@
+// Package testutil holds helpers shared by the packages' tests
+package testutil
+
+import "time"
+
+// WaitFor polls cond every 10ms until it holds or the timeout passes
+// Returns whether it held
+func WaitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
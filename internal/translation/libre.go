@@ -0,0 +1,133 @@
+package translation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// languagesTTL is how long the backend's language list is reused before asking again
+// Servers rarely add languages, and this is checked on every translate request
+const languagesTTL = time.Hour
+
+// LibreTranslate calls a LibreTranslate-compatible HTTP API
+// See https://libretranslate.com/docs for the endpoints used (/translate and /languages)
+type LibreTranslate struct {
+	baseURL string
+	apiKey  string // Optional; sent as api_key when set
+	client  *http.Client
+
+	// Cached result of /languages
+	mu          sync.Mutex
+	languages   []string
+	languagesAt time.Time
+}
+
+// NewLibreTranslate creates a client for the API at baseURL
+// Every request gives up after timeout, which is reported as ErrUnavailable
+func NewLibreTranslate(baseURL, apiKey string, timeout time.Duration) *LibreTranslate {
+	return &LibreTranslate{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Translate asks the server to translate text, detecting the source language
+func (l *LibreTranslate) Translate(ctx context.Context, text, targetLang string) (string, string, error) {
+	body, err := json.Marshal(map[string]string{
+		"q":       text,
+		"source":  "auto",
+		"target":  targetLang,
+		"format":  "text",
+		"api_key": l.apiKey,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.baseURL+"/translate", bytes.NewReader(body))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var result struct {
+		TranslatedText   string `json:"translatedText"`
+		DetectedLanguage struct {
+			Language string `json:"language"`
+		} `json:"detectedLanguage"`
+	}
+	if err := l.do(req, &result); err != nil {
+		return "", "", err
+	}
+	return result.TranslatedText, result.DetectedLanguage.Language, nil
+}
+
+// Languages returns the server's target languages, cached for languagesTTL
+// A failed refresh keeps serving the previous list if there is one
+func (l *LibreTranslate) Languages(ctx context.Context) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.languages != nil && time.Since(l.languagesAt) < languagesTTL {
+		return l.languages, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.baseURL+"/languages", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []struct {
+		Code string `json:"code"`
+	}
+	if err := l.do(req, &result); err != nil {
+		if l.languages != nil {
+			return l.languages, nil
+		}
+		return nil, err
+	}
+
+	languages := make([]string, len(result))
+	for i, lang := range result {
+		languages[i] = lang.Code
+	}
+	l.languages = languages
+	l.languagesAt = time.Now()
+	return languages, nil
+}
+
+// do sends a request and decodes the JSON response into out
+// Network errors and 5xx/429 responses wrap ErrUnavailable; a 400 naming the
+// target language is ErrUnsupportedLanguage
+func (l *LibreTranslate) do(req *http.Request, out any) error {
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %v", req.Method, req.URL.Path, ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+
+		switch {
+		case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+			return fmt.Errorf("%s %s: %w: status %d", req.Method, req.URL.Path, ErrUnavailable, resp.StatusCode)
+		case resp.StatusCode == http.StatusBadRequest && strings.Contains(strings.ToLower(apiErr.Error), "not supported"):
+			return ErrUnsupportedLanguage
+		}
+		return errors.New("translation backend: " + resp.Status + ": " + apiErr.Error)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
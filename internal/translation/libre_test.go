@@ -0,0 +1,111 @@
+package translation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestLibreTranslate translates through a fake server, checking the request
+// it's sent
+func TestLibreTranslate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/translate" || req["q"] != "hello" || req["target"] != "de" || req["source"] != "auto" || req["api_key"] != "secret" {
+			t.Errorf("got %s %v", r.URL.Path, req)
+		}
+		w.Write([]byte(`{"translatedText": "hallo", "detectedLanguage": {"language": "en", "confidence": 90}}`))
+	}))
+	defer server.Close()
+
+	client := NewLibreTranslate(server.URL+"/", "secret", time.Second)
+	text, source, err := client.Translate(context.Background(), "hello", "de")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "hallo" || source != "en" {
+		t.Errorf("got %q from %q, want hallo from en", text, source)
+	}
+}
+
+// TestLibreTranslateErrors maps the server's failures: outages and slow
+// answers are ErrUnavailable, a refused language ErrUnsupportedLanguage
+func TestLibreTranslateErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		status  int
+		body    string
+		delay   time.Duration
+		want    error
+		generic bool
+	}{
+		{"server error", http.StatusInternalServerError, `{"error": "boom"}`, 0, ErrUnavailable, false},
+		{"rate limited", http.StatusTooManyRequests, `{"error": "slow down"}`, 0, ErrUnavailable, false},
+		{"timeout", http.StatusOK, `{}`, 200 * time.Millisecond, ErrUnavailable, false},
+		{"unsupported", http.StatusBadRequest, `{"error": "xx is not supported"}`, 0, ErrUnsupportedLanguage, false},
+		{"other refusal", http.StatusForbidden, `{"error": "invalid API key"}`, 0, nil, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tc.delay)
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			_, _, err := NewLibreTranslate(server.URL, "", 50*time.Millisecond).Translate(context.Background(), "hello", "xx")
+			switch {
+			case tc.generic && (err == nil || errors.Is(err, ErrUnavailable) || errors.Is(err, ErrUnsupportedLanguage)):
+				t.Errorf("got %v, want a plain error", err)
+			case !tc.generic && !errors.Is(err, tc.want):
+				t.Errorf("got %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+// TestLibreTranslateLanguages caches the language list, and keeps serving
+// it when a refresh fails
+func TestLibreTranslateLanguages(t *testing.T) {
+	var calls atomic.Int32
+	var down atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`[{"code": "de", "name": "German"}, {"code": "es", "name": "Spanish"}]`))
+	}))
+	defer server.Close()
+	client := NewLibreTranslate(server.URL, "", time.Second)
+	ctx := context.Background()
+
+	for range 3 {
+		languages, err := client.Languages(ctx)
+		if err != nil || !slices.Equal(languages, []string{"de", "es"}) {
+			t.Fatalf("got %v, %v; want [de es]", languages, err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("the server was asked %d times, want once", calls.Load())
+	}
+
+	// Expire the cache while the server is down
+	down.Store(true)
+	client.languagesAt = time.Now().Add(-2 * languagesTTL)
+	if languages, err := client.Languages(ctx); err != nil || len(languages) != 2 {
+		t.Errorf("a failed refresh got %v, %v; want the old list", languages, err)
+	}
+
+	if _, err := NewLibreTranslate(server.URL, "", time.Second).Languages(ctx); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("a first fetch from a down server got %v, want ErrUnavailable", err)
+	}
+}
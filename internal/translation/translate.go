@@ -0,0 +1,78 @@
+// Package translation turns chat messages into other languages on request
+//
+// Backends implement Translator; the API caches their results per message
+// and target language, so each translation is only paid for once
+package translation
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// ErrUnavailable marks failures of the backend itself (timeouts, 5xx, connection
+// refused) that are worth retrying later
+// Backends wrap it (fmt.Errorf("...: %w", translation.ErrUnavailable))
+var ErrUnavailable = errors.New("translation backend unavailable")
+
+// ErrUnsupportedLanguage is returned for a target language the backend can't produce
+var ErrUnsupportedLanguage = errors.New("unsupported target language")
+
+// Translator translates text into a target language
+// Languages are ISO 639-1 codes ("de", "fr", ...)
+type Translator interface {
+	// Translate returns the translated text and the language it was translated from
+	Translate(ctx context.Context, text, targetLang string) (translated, sourceLang string, err error)
+
+	// Languages lists the target languages the backend supports
+	Languages(ctx context.Context) ([]string, error)
+}
+
+// Dictionary is a Translator backed by fixed word lists, for development and demos
+// Words are looked up case-insensitively one at a time; anything not in the
+// target's list is kept as is. It assumes the source language is SourceLang
+type Dictionary struct {
+	SourceLang string
+
+	// Words maps a target language to source word -> translated word
+	Words map[string]map[string]string
+}
+
+// Translate replaces every known word with its translation
+func (d *Dictionary) Translate(_ context.Context, text, targetLang string) (string, string, error) {
+	words, ok := d.Words[targetLang]
+	if !ok {
+		return "", "", ErrUnsupportedLanguage
+	}
+
+	fields := strings.Fields(text)
+	for i, field := range fields {
+		if translated, ok := words[strings.ToLower(field)]; ok {
+			fields[i] = translated
+		}
+	}
+	return strings.Join(fields, " "), d.SourceLang, nil
+}
+
+// Languages returns the target languages that have a word list
+func (d *Dictionary) Languages(context.Context) ([]string, error) {
+	languages := make([]string, 0, len(d.Words))
+	for lang := range d.Words {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+	return languages, nil
+}
+
+// NewDemoDictionary returns a Dictionary with a few common chat words in German and Spanish
+// Enough to try the feature end to end without running a translation server
+func NewDemoDictionary() *Dictionary {
+	return &Dictionary{
+		SourceLang: "en",
+		Words: map[string]map[string]string{
+			"de": {"hello": "hallo", "thanks": "danke", "yes": "ja", "no": "nein", "good": "gut", "morning": "Morgen", "night": "Nacht"},
+			"es": {"hello": "hola", "thanks": "gracias", "yes": "sí", "no": "no", "good": "bueno", "morning": "mañana", "night": "noche"},
+		},
+	}
+}
@@ -0,0 +1,31 @@
+package translation
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// TestDictionary translates known words case-insensitively and keeps the rest
+func TestDictionary(t *testing.T) {
+	d := NewDemoDictionary()
+	ctx := context.Background()
+
+	text, source, err := d.Translate(ctx, "Hello  Ada, good morning", "es")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "hola Ada, bueno mañana" || source != "en" {
+		t.Errorf("got %q from %q, want \"hola Ada, bueno mañana\" from en", text, source)
+	}
+
+	if _, _, err := d.Translate(ctx, "hello", "fr"); !errors.Is(err, ErrUnsupportedLanguage) {
+		t.Errorf("translating to fr got %v, want ErrUnsupportedLanguage", err)
+	}
+
+	languages, _ := d.Languages(ctx)
+	if !slices.Equal(languages, []string{"de", "es"}) {
+		t.Errorf("the languages are %v, want [de es]", languages)
+	}
+}
@@ -0,0 +1,76 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/websocket"
+)
+
+// maxModerationResponse caps how much of a moderation bot's answer is read
+const maxModerationResponse = 64 << 10
+
+// Moderator calls rooms' moderation bots; it implements websocket.ModerationCaller
+//
+// Each message is POSTed as a websocket.ModerationRequest, signed with the
+// hook's secret like webhook events are, and the bot answers with a
+// websocket.ModerationDecision:
+//
+//	{"action": "allow"}
+//	{"action": "reject", "reason": "no links to that site"}
+//	{"action": "rewrite", "content": "the cleaned up message"}
+//
+// Anything but a 2xx answer with one of those is an error
+type Moderator struct {
+	client *http.Client
+}
+
+// NewModerator creates a moderator
+// Calls are bounded by the context the hub passes (the hook's timeout), and
+// redirects aren't followed, so a hook can only reach the URL it was saved with
+func NewModerator() *Moderator {
+	return &Moderator{
+		client: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Moderate asks a room's bot about one message
+func (m *Moderator) Moderate(ctx context.Context, hook *store.ModerationHook, request *websocket.ModerationRequest) (*websocket.ModerationDecision, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, websocket.EventModerate)
+	req.Header.Set(SignatureHeader, Sign([]byte(hook.Secret), body))
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("moderation hook responded with status %d", resp.StatusCode)
+	}
+
+	decision := &websocket.ModerationDecision{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxModerationResponse)).Decode(decision); err != nil {
+		return nil, fmt.Errorf("invalid moderation hook response: %w", err)
+	}
+	return decision, nil
+}
@@ -0,0 +1,191 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/websocket"
+)
+
+// hookStore keeps room 1's moderation hook, and why it was turned off
+type hookStore struct {
+	mu       sync.Mutex
+	hook     store.ModerationHook
+	disabled string
+}
+
+func (s *hookStore) Get(_ context.Context, roomID int64) (*store.ModerationHook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if roomID != s.hook.RoomID {
+		return nil, sql.ErrNoRows
+	}
+	hook := s.hook
+	return &hook, nil
+}
+
+func (s *hookStore) Save(context.Context, *store.ModerationHook) error { return nil }
+
+func (s *hookStore) Delete(context.Context, int64) error { return nil }
+
+func (s *hookStore) Disable(_ context.Context, _ int64, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hook.Enabled = false
+	s.disabled = reason
+	return nil
+}
+
+// reason returns why the hook was turned off, if it was
+func (s *hookStore) reason() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.disabled
+}
+
+// ownedRooms says user 1 created every room
+type ownedRooms struct {
+	*store.RoomStore
+}
+
+func (ownedRooms) GetByID(_ context.Context, roomID int64) (*store.Room, error) {
+	return &store.Room{ID: roomID, Name: "general", CreatedBy: 1}, nil
+}
+
+// newModeratedHub starts a hub that calls room 1's bot at url through a
+// Moderator, with the hook's secret, timeout and fail_open as given
+func newModeratedHub(t *testing.T, url, secret string, timeout time.Duration, failOpen bool) (*websocket.Hub, *hookStore) {
+	t.Helper()
+	hooks := &hookStore{hook: store.ModerationHook{RoomID: 1, URL: url, Secret: secret, Timeout: timeout, FailOpen: failOpen, Enabled: true}}
+	hub := websocket.NewHub(store.Storage{Rooms: ownedRooms{}, ModerationHooks: hooks}, 1)
+	hub.SetModerationCaller(NewModerator())
+	go hub.Run()
+	return hub, hooks
+}
+
+// TestModerator runs messages past a bot served over HTTP that checks each
+// request's signature and answers by content: allow, reject with a reason,
+// rewrite, an invalid rewrite, a redirect, an error status and no answer in
+// time. With the room failing closed, everything but a proper answer is
+// rejected as a failure
+func TestModerator(t *testing.T) {
+	const secret = "a-moderation-secret"
+	bot := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(EventHeader) != websocket.EventModerate || !Verify([]byte(secret), body, r.Header.Get(SignatureHeader)) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		var req websocket.ModerationRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var decision websocket.ModerationDecision
+		switch req.Content {
+		case "hello":
+			decision = websocket.ModerationDecision{Action: websocket.ModerationAllow}
+		case "buy now":
+			decision = websocket.ModerationDecision{Action: websocket.ModerationReject, Reason: "no ads"}
+		case "darn":
+			decision = websocket.ModerationDecision{Action: websocket.ModerationRewrite, Content: "d**n"}
+		case "blank":
+			decision = websocket.ModerationDecision{Action: websocket.ModerationRewrite, Content: "   "}
+		case "moved":
+			http.Redirect(w, r, "/elsewhere", http.StatusFound)
+			return
+		case "slow":
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+			return
+		default:
+			http.Error(w, "confused", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(decision)
+	}))
+	defer bot.Close()
+	hub, _ := newModeratedHub(t, bot.URL, secret, 100*time.Millisecond, false)
+
+	for _, tc := range []struct {
+		content string
+		want    websocket.ModerationOutcome
+	}{
+		{"hello", websocket.ModerationOutcome{Action: websocket.ModerationAllow}},
+		{"buy now", websocket.ModerationOutcome{Action: websocket.ModerationReject, Reason: "no ads"}},
+		{"darn", websocket.ModerationOutcome{Action: websocket.ModerationRewrite, Content: "d**n"}},
+		{"blank", websocket.ModerationOutcome{Action: websocket.ModerationReject, Failed: true}},
+		{"moved", websocket.ModerationOutcome{Action: websocket.ModerationReject, Failed: true}},
+		{"oops", websocket.ModerationOutcome{Action: websocket.ModerationReject, Failed: true}},
+		{"slow", websocket.ModerationOutcome{Action: websocket.ModerationReject, Failed: true}},
+	} {
+		message := &store.Message{RoomID: 1, UserID: 2, Username: "grace", Content: tc.content}
+		outcome, err := hub.ModerateWithHook(context.Background(), message)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.content, err)
+		}
+		if *outcome != tc.want {
+			t.Errorf("%q got %+v, want %+v", tc.content, *outcome, tc.want)
+		}
+	}
+
+	// Rooms without a hook aren't held up
+	outcome, err := hub.ModerateWithHook(context.Background(), &store.Message{RoomID: 2, UserID: 2, Content: "oops"})
+	if err != nil || outcome.Action != websocket.ModerationAllow || outcome.Failed {
+		t.Errorf("a room without a hook got %+v, %v", outcome, err)
+	}
+}
+
+// TestModeratorCircuitBreaker fails every call with the room failing open:
+// messages are let through while the bot keeps failing, and the fifth
+// failure in a row turns the hook off, so the bot isn't called again
+func TestModeratorCircuitBreaker(t *testing.T) {
+	var calls atomic.Int32
+	bot := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer bot.Close()
+	hub, hooks := newModeratedHub(t, bot.URL, "a-moderation-secret", 100*time.Millisecond, true)
+
+	moderate := func() *websocket.ModerationOutcome {
+		t.Helper()
+		outcome, err := hub.ModerateWithHook(context.Background(), &store.Message{RoomID: 1, UserID: 2, Content: "hello"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return outcome
+	}
+	for i := 0; i < 5; i++ {
+		if outcome := moderate(); outcome.Action != websocket.ModerationAllow || !outcome.Failed {
+			t.Fatalf("call %d got %+v, want allowed as a failure", i+1, outcome)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for hooks.reason() == "" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if reason := hooks.reason(); !strings.Contains(reason, "5 failures in a row") || !strings.Contains(reason, "503") {
+		t.Errorf("the hook was turned off with %q, want the failure count and last error", reason)
+	}
+	if outcome := moderate(); outcome.Action != websocket.ModerationAllow || outcome.Failed {
+		t.Errorf("after the breaker tripped got %+v, want a plain allow", outcome)
+	}
+	if n := calls.Load(); n != 5 {
+		t.Errorf("the bot was called %d times, want 5", n)
+	}
+}
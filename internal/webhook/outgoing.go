@@ -0,0 +1,567 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/websocket"
+)
+
+// Rooms' outgoing webhooks
+//
+// A room's owner can have its events POSTed to URLs of their choosing, e.g. to
+// post to an incident tool when someone pins a message. Each webhook has an
+// allowlist of the event types below and is signed like the server-wide
+// webhook, with its own secret
+//
+// Chat messages come from the hub's hooks. Everything else comes from the
+// room's event log (see store.RoomEvent): each webhook remembers the last
+// event it was sent, and an instance claims the events after it by moving that
+// forward in the database, so with several instances each event is still sent
+// once. The log is read when a handler reports a change and every
+// outgoingSweepInterval, which catches changes made on other instances
+//
+// Deliveries run on their own workers and are dropped when the queue is full,
+// so neither the hub nor a request ever waits on a webhook. Each event is
+// tried maxAttempts times with exponential backoff; the outcome is logged per
+// webhook, and after MaxConsecutiveFailures failed deliveries in a row the
+// webhook is turned off and its creator told
+
+// Event types a room's webhook can be sent
+const (
+	OutgoingMessageCreated = "message_created" // A chat message was posted
+	OutgoingMessagePinned  = "message_pinned"  // A message was pinned
+	OutgoingMemberJoined   = "member_joined"   // Someone joined or was added
+	OutgoingMemberLeft     = "member_left"     // Someone left, was removed or was banned
+	OutgoingTopicChanged   = "topic_changed"   // The room's description changed
+)
+
+// OutgoingEvents lists every event type a room's webhook can be sent
+var OutgoingEvents = []string{
+	OutgoingMessageCreated,
+	OutgoingMessagePinned,
+	OutgoingMemberJoined,
+	OutgoingMemberLeft,
+	OutgoingTopicChanged,
+}
+
+// WebhookIDHeader carries the ID of the room's webhook a request is for
+const WebhookIDHeader = "X-GoChat-Webhook-ID"
+
+const (
+	// MaxConsecutiveFailures is how many deliveries in a row may fail before a
+	// webhook is turned off
+	MaxConsecutiveFailures = 50
+
+	// outgoingWorkers is how many deliveries run at once
+	outgoingWorkers = 8
+
+	// outgoingQueueSize bounds the deliveries waiting for a worker
+	outgoingQueueSize = 1024
+
+	// outgoingCacheTTL is how long the enabled webhooks are cached
+	outgoingCacheTTL = 30 * time.Second
+
+	// outgoingSweepInterval is how often every room with webhooks has its event log read
+	outgoingSweepInterval = 10 * time.Second
+
+	// outgoingEventBatch is how many log events are claimed at once
+	outgoingEventBatch = 100
+
+	// maxDeliveryResponse caps how much of a response body is kept in the delivery log
+	maxDeliveryResponse = 1024
+)
+
+// ValidOutgoingEvent reports whether name is one of OutgoingEvents
+func ValidOutgoingEvent(name string) bool {
+	for _, event := range OutgoingEvents {
+		if event == name {
+			return true
+		}
+	}
+	return false
+}
+
+// OutgoingPayload is the body POSTed to a room's webhook
+// People appear by ID and username only; payloads never carry email addresses
+type OutgoingPayload struct {
+	Event      string    `json:"event"`
+	WebhookID  int64     `json:"webhook_id"`
+	RoomID     int64     `json:"room_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+
+	// Message is the chat message posted or pinned
+	Message *OutgoingMessage `json:"message,omitempty"`
+
+	// User joined or left; Actor added or removed them, or pinned the message
+	// Actor is absent when people join or leave by themselves
+	User  *OutgoingUser `json:"user,omitempty"`
+	Actor *OutgoingUser `json:"actor,omitempty"`
+
+	// Topic is the room's new description, for topic_changed
+	Topic *string `json:"topic,omitempty"`
+}
+
+// OutgoingUser is a person in a webhook payload
+type OutgoingUser struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+// OutgoingMessage is a chat message in a webhook payload
+type OutgoingMessage struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"user_id"`
+	Username    string    `json:"username"`
+	Content     string    `json:"content"`
+	ContentType string    `json:"content_type,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// outgoingJob is one payload waiting to be sent to one webhook
+type outgoingJob struct {
+	hook    *store.OutgoingWebhook
+	payload OutgoingPayload
+}
+
+// Outgoing sends rooms' events to their webhooks
+type Outgoing struct {
+	store  store.Storage
+	client *http.Client
+
+	queue chan outgoingJob
+	rooms chan int64 // Rooms whose event log may have new events
+
+	// backoff is the wait before the first retry; it doubles after each attempt
+	backoff time.Duration
+
+	// disabled is called after a webhook was turned off for failing
+	disabled func(*store.OutgoingWebhook)
+
+	dropped atomic.Int64
+
+	mu         sync.Mutex
+	hooks      map[int64][]*store.OutgoingWebhook // Enabled webhooks by room
+	expires    time.Time
+	generation uint64 // Counts invalidations, so a load that raced one isn't cached
+}
+
+// NewOutgoing creates the dispatcher; nothing is sent until Start
+// Redirects aren't followed, so a webhook can only reach the URL it was saved with
+func NewOutgoing(st store.Storage) *Outgoing {
+	return &Outgoing{
+		store: st,
+		client: &http.Client{
+			Timeout: requestTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		queue:   make(chan outgoingJob, outgoingQueueSize),
+		rooms:   make(chan int64, outgoingQueueSize),
+		backoff: initialBackoff,
+	}
+}
+
+// OnDisabled sets the callback run after a webhook was turned off for failing,
+// to tell its creator
+// Must be called before Start
+func (o *Outgoing) OnDisabled(fn func(*store.OutgoingWebhook)) {
+	o.disabled = fn
+}
+
+// Register subscribes the dispatcher to the hub's chat messages
+func (o *Outgoing) Register(hooks *websocket.HookRegistry) {
+	hooks.OnMessagePersisted(o.messagePersisted)
+}
+
+// Start runs the delivery workers, and the event log reader until ctx is done
+func (o *Outgoing) Start(ctx context.Context) {
+	for i := 0; i < outgoingWorkers; i++ {
+		go o.worker()
+	}
+	go o.readLogs(ctx)
+}
+
+// RoomChanged reports that a room's event log may have new events
+// Never blocks: if the reader is behind, the next sweep picks the room up
+func (o *Outgoing) RoomChanged(roomID int64) {
+	select {
+	case o.rooms <- roomID:
+	default:
+	}
+}
+
+// Invalidate drops the cached webhooks after one is added, removed or turned off
+func (o *Outgoing) Invalidate() {
+	o.mu.Lock()
+	o.expires = time.Time{}
+	o.generation++
+	o.mu.Unlock()
+}
+
+// Dropped returns how many deliveries were skipped because the queue was full
+func (o *Outgoing) Dropped() int64 {
+	return o.dropped.Load()
+}
+
+// load returns the enabled webhooks by room, from the cache while it's fresh
+// If they can't be loaded the last ones loaded stay in use
+func (o *Outgoing) load(ctx context.Context) map[int64][]*store.OutgoingWebhook {
+	now := time.Now()
+
+	o.mu.Lock()
+	if now.Before(o.expires) {
+		hooks := o.hooks
+		o.mu.Unlock()
+		return hooks
+	}
+	generation := o.generation
+	stale := o.hooks
+	o.mu.Unlock()
+
+	list, err := o.store.OutgoingWebhooks.ListEnabled(ctx)
+	if err != nil {
+		log.Printf("Failed to load outgoing webhooks, using the last ones loaded: %v", err)
+		return stale
+	}
+	hooks := make(map[int64][]*store.OutgoingWebhook)
+	for _, hook := range list {
+		hooks[hook.RoomID] = append(hooks[hook.RoomID], hook)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.generation == generation {
+		o.hooks = hooks
+		o.expires = now.Add(outgoingCacheTTL)
+	}
+	return hooks
+}
+
+// enqueue queues a payload for a webhook if the webhook wants its event type
+// Never blocks: when the queue is full the delivery is dropped and counted
+func (o *Outgoing) enqueue(hook *store.OutgoingWebhook, payload OutgoingPayload) {
+	if !hook.Wants(payload.Event) {
+		return
+	}
+	payload.WebhookID = hook.ID
+	payload.RoomID = hook.RoomID
+
+	select {
+	case o.queue <- outgoingJob{hook: hook, payload: payload}:
+	default:
+		// Log the first drop and then every 1000th, to avoid flooding the log
+		if n := o.dropped.Add(1); n == 1 || n%1000 == 0 {
+			log.Printf("Outgoing webhook queue full, dropped %d deliveries so far", n)
+		}
+	}
+}
+
+// messagePersisted sends a saved chat message to its room's webhooks
+// Runs on a hook worker, never on the hub's loop; system messages aren't sent
+func (o *Outgoing) messagePersisted(event websocket.HookEvent) {
+	message := event.Message
+	if message == nil || message.System {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	hooks := o.load(ctx)[event.RoomID]
+	if len(hooks) == 0 {
+		return
+	}
+
+	payload := OutgoingPayload{
+		Event:      OutgoingMessageCreated,
+		OccurredAt: event.OccurredAt,
+		Message: &OutgoingMessage{
+			ID:          message.ID,
+			UserID:      message.UserID,
+			Username:    message.Username,
+			Content:     message.Content,
+			ContentType: message.ContentType,
+		},
+	}
+	if message.CreatedAt != nil {
+		payload.Message.CreatedAt = *message.CreatedAt
+	}
+	for _, hook := range hooks {
+		o.enqueue(hook, payload)
+	}
+}
+
+// readLogs reads rooms' event logs as they're reported changed, and every
+// room with webhooks on each sweep, until ctx is done
+func (o *Outgoing) readLogs(ctx context.Context) {
+	ticker := time.NewTicker(outgoingSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case roomID := <-o.rooms:
+			o.readLog(roomID)
+		case <-ticker.C:
+			loadCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+			rooms := o.load(loadCtx)
+			cancel()
+			for roomID := range rooms {
+				o.readLog(roomID)
+			}
+		}
+	}
+}
+
+// readLog sends a room's new log events to each of its webhooks that wants some
+func (o *Outgoing) readLog(roomID int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, hook := range o.load(ctx)[roomID] {
+		if hook.Wants(OutgoingMessagePinned) || hook.Wants(OutgoingMemberJoined) ||
+			hook.Wants(OutgoingMemberLeft) || hook.Wants(OutgoingTopicChanged) {
+			o.readHookLog(ctx, hook)
+		}
+	}
+}
+
+// readHookLog claims the events after a webhook's place in its room's log and
+// queues the ones it wants
+// Events are claimed before they're sent: one lost to a crash in between
+// isn't sent, rather than being sent twice
+// Only called from readLogs, so the cached webhook's EventSeq has one writer
+func (o *Outgoing) readHookLog(ctx context.Context, hook *store.OutgoingWebhook) {
+	for {
+		events, err := o.store.RoomEvents.ListAfter(ctx, hook.RoomID, hook.EventSeq, outgoingEventBatch)
+		if errors.Is(err, store.ErrRoomEventsPurged) {
+			log.Printf("Events due to outgoing webhook %d of room %d were purged; skipping them", hook.ID, hook.RoomID)
+			if err := o.store.OutgoingWebhooks.SkipEvents(ctx, hook.ID); err != nil {
+				log.Printf("Failed to skip purged events of outgoing webhook %d: %v", hook.ID, err)
+			}
+			o.Invalidate()
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to read the event log of room %d for its webhooks: %v", hook.RoomID, err)
+			return
+		}
+		if len(events) == 0 {
+			return
+		}
+
+		last := events[len(events)-1].Seq
+		claimed, err := o.store.OutgoingWebhooks.AdvanceSeq(ctx, hook.ID, hook.EventSeq, last)
+		if err != nil {
+			log.Printf("Failed to claim events for outgoing webhook %d: %v", hook.ID, err)
+			return
+		}
+		if !claimed {
+			// Another instance sent them; reload to find out where it got to
+			o.Invalidate()
+			return
+		}
+		hook.EventSeq = last
+
+		for _, event := range events {
+			if payload, ok := o.logPayload(ctx, event); ok {
+				o.enqueue(hook, payload)
+			}
+		}
+		if len(events) < outgoingEventBatch {
+			return
+		}
+	}
+}
+
+// logPayload turns a room log event into a webhook payload
+// Returns false for events webhooks aren't sent
+func (o *Outgoing) logPayload(ctx context.Context, event *store.RoomEvent) (OutgoingPayload, bool) {
+	var fields struct {
+		UserID      int64   `json:"user_id"`
+		ActorID     *int64  `json:"actor_id"`
+		MessageID   int64   `json:"message_id"`
+		PinnedBy    int64   `json:"pinned_by"`
+		Description *string `json:"description"`
+	}
+	if err := json.Unmarshal(event.Payload, &fields); err != nil {
+		log.Printf("Failed to read room event %d for outgoing webhooks: %v", event.Seq, err)
+		return OutgoingPayload{}, false
+	}
+	payload := OutgoingPayload{OccurredAt: event.CreatedAt}
+
+	switch event.Type {
+	case "member_" + store.MembershipJoined:
+		payload.Event = OutgoingMemberJoined
+	case "member_" + store.MembershipLeft, "member_" + store.MembershipKicked, "member_" + store.MembershipBanned:
+		payload.Event = OutgoingMemberLeft
+	case store.RoomEventPinAdded:
+		payload.Event = OutgoingMessagePinned
+		message, err := o.store.Messages.GetByID(ctx, fields.MessageID)
+		if err != nil {
+			log.Printf("Failed to load pinned message %d for outgoing webhooks: %v", fields.MessageID, err)
+			return OutgoingPayload{}, false
+		}
+		payload.Message = &OutgoingMessage{
+			ID:          message.ID,
+			UserID:      message.UserID,
+			Username:    message.Username,
+			Content:     message.Content,
+			ContentType: message.ContentType,
+			CreatedAt:   message.CreatedAt,
+		}
+		payload.Actor = o.user(ctx, fields.PinnedBy)
+		return payload, true
+	case store.RoomEventRoomUpdated:
+		if fields.Description == nil {
+			return OutgoingPayload{}, false
+		}
+		payload.Event = OutgoingTopicChanged
+		payload.Topic = fields.Description
+		return payload, true
+	default:
+		return OutgoingPayload{}, false
+	}
+
+	payload.User = o.user(ctx, fields.UserID)
+	if fields.ActorID != nil && *fields.ActorID != fields.UserID {
+		payload.Actor = o.user(ctx, *fields.ActorID)
+	}
+	return payload, true
+}
+
+// user looks up a person for a payload: only their ID and username are
+// copied, so the rest of their account (email address included) never is
+func (o *Outgoing) user(ctx context.Context, userID int64) *OutgoingUser {
+	if userID == 0 {
+		return nil
+	}
+	user, err := o.store.Users.GetByID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to look up user %d for outgoing webhooks: %v", userID, err)
+		return &OutgoingUser{ID: userID}
+	}
+	return &OutgoingUser{ID: user.ID, Username: user.Username}
+}
+
+// worker sends queued deliveries until the process exits
+func (o *Outgoing) worker() {
+	for job := range o.queue {
+		o.deliver(job)
+	}
+}
+
+// deliver sends one payload, retrying with exponential backoff, then logs
+// the outcome and turns the webhook off if it has failed too often in a row
+func (o *Outgoing) deliver(job outgoingJob) {
+	body, err := json.Marshal(job.payload)
+	if err != nil {
+		log.Printf("Failed to marshal outgoing webhook event %s: %v", job.payload.Event, err)
+		return
+	}
+
+	delivery := &store.OutgoingWebhookDelivery{WebhookID: job.hook.ID, Event: job.payload.Event}
+	backoff := o.backoff
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		delivery.Attempts = attempt
+		var retry bool
+		retry, err = o.attempt(job.hook, job.payload.Event, body, delivery)
+		if err == nil || !retry {
+			break
+		}
+		if attempt < maxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	delivery.Status = store.DeliveryDelivered
+	if err != nil {
+		delivery.Status = store.DeliveryFailed
+		delivery.Error = err.Error()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	failures, err := o.store.OutgoingWebhooks.RecordDelivery(ctx, delivery)
+	if err != nil {
+		log.Printf("Failed to log delivery to outgoing webhook %d: %v", job.hook.ID, err)
+		return
+	}
+	if failures >= MaxConsecutiveFailures {
+		o.disable(ctx, job.hook, failures)
+	}
+}
+
+// attempt makes a single delivery attempt, filling in the delivery's response
+// fields; any 2xx response counts as delivered
+// Reports whether a failure is worth retrying: errors reaching the webhook,
+// 5xx and 429 responses are; other responses mean the request itself was refused
+func (o *Outgoing) attempt(hook *store.OutgoingWebhook, eventType string, body []byte, delivery *store.OutgoingWebhookDelivery) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, eventType)
+	req.Header.Set(WebhookIDHeader, fmt.Sprint(hook.ID))
+	req.Header.Set(SignatureHeader, Sign([]byte(hook.Secret), body))
+
+	start := time.Now()
+	resp, err := o.client.Do(req)
+	delivery.LatencyMs = time.Since(start).Milliseconds()
+	delivery.ResponseCode = nil
+	delivery.ResponseBody = ""
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	code := resp.StatusCode
+	delivery.ResponseCode = &code
+	// Only the start of the body is kept; the rest isn't read
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxDeliveryResponse))
+	delivery.ResponseBody = strings.ToValidUTF8(string(data), "")
+
+	if code >= 200 && code <= 299 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook responded with status %d", code)
+	return code >= 500 || code == http.StatusTooManyRequests, err
+}
+
+// disable turns a failing webhook off and tells its creator
+func (o *Outgoing) disable(ctx context.Context, hook *store.OutgoingWebhook, failures int) {
+	reason := fmt.Sprintf("turned off after %d failed deliveries in a row", failures)
+	changed, err := o.store.OutgoingWebhooks.Disable(ctx, hook.ID, reason)
+	if err != nil {
+		log.Printf("Failed to turn off outgoing webhook %d: %v", hook.ID, err)
+		return
+	}
+	if !changed {
+		return
+	}
+	log.Printf("Outgoing webhook %d of room %d %s", hook.ID, hook.RoomID, reason)
+	o.Invalidate()
+
+	// A copy, since the cached webhook may still be in use
+	disabled := *hook
+	disabled.Enabled = false
+	disabled.DisabledReason = reason
+	if o.disabled != nil {
+		o.disabled(&disabled)
+	}
+}
@@ -0,0 +1,513 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+	"github.com/drazan344/go-chat/internal/websocket"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// TestMain silences the dispatcher's log output
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// memoryWebhooks keeps rooms' webhooks and their delivery log in memory
+// The methods the dispatcher doesn't use are the real store's on no database
+type memoryWebhooks struct {
+	*store.OutgoingWebhookStore
+	mu         sync.Mutex
+	hooks      map[int64]*store.OutgoingWebhook
+	deliveries []*store.OutgoingWebhookDelivery
+	skipped    []int64 // Webhooks SkipEvents was called for
+}
+
+func (m *memoryWebhooks) add(hook *store.OutgoingWebhook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hook.Enabled = true
+	m.hooks[hook.ID] = hook
+}
+
+func (m *memoryWebhooks) ListEnabled(context.Context) ([]*store.OutgoingWebhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var hooks []*store.OutgoingWebhook
+	for _, hook := range m.hooks {
+		if hook.Enabled {
+			copied := *hook
+			hooks = append(hooks, &copied)
+		}
+	}
+	return hooks, nil
+}
+
+func (m *memoryWebhooks) AdvanceSeq(_ context.Context, id, from, to int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hook := m.hooks[id]
+	if hook.EventSeq != from {
+		return false, nil
+	}
+	hook.EventSeq = to
+	return true, nil
+}
+
+func (m *memoryWebhooks) SkipEvents(_ context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.skipped = append(m.skipped, id)
+	return nil
+}
+
+func (m *memoryWebhooks) RecordDelivery(_ context.Context, delivery *store.OutgoingWebhookDelivery) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hook := m.hooks[delivery.WebhookID]
+	if delivery.Status == store.DeliveryDelivered {
+		hook.ConsecutiveFailures = 0
+	} else {
+		hook.ConsecutiveFailures++
+	}
+	copied := *delivery
+	m.deliveries = append(m.deliveries, &copied)
+	return hook.ConsecutiveFailures, nil
+}
+
+func (m *memoryWebhooks) Disable(_ context.Context, id int64, reason string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hook := m.hooks[id]
+	if !hook.Enabled {
+		return false, nil
+	}
+	hook.Enabled = false
+	hook.DisabledReason = reason
+	return true, nil
+}
+
+// logged returns a copy of the delivery log, oldest first
+func (m *memoryWebhooks) logged() []*store.OutgoingWebhookDelivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*store.OutgoingWebhookDelivery(nil), m.deliveries...)
+}
+
+// seq returns a webhook's place in its room's event log
+func (m *memoryWebhooks) seq(id int64) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.hooks[id].EventSeq
+}
+
+// memoryRoomEvents is a room event log, with events before purgedBefore gone
+type memoryRoomEvents struct {
+	*store.RoomEventStore
+	mu           sync.Mutex
+	events       []*store.RoomEvent
+	purgedBefore int64
+}
+
+// append logs an event with a JSON payload in room 1
+func (m *memoryRoomEvents) append(eventType string, payload map[string]any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	encoded, _ := json.Marshal(payload)
+	m.events = append(m.events, &store.RoomEvent{
+		Seq:       int64(len(m.events) + 1),
+		RoomID:    1,
+		Type:      eventType,
+		Payload:   encoded,
+		CreatedAt: time.Now(),
+	})
+}
+
+func (m *memoryRoomEvents) ListAfter(_ context.Context, roomID, after int64, limit int) ([]*store.RoomEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if after+1 < m.purgedBefore {
+		return nil, store.ErrRoomEventsPurged
+	}
+	var events []*store.RoomEvent
+	for _, event := range m.events {
+		if event.RoomID == roomID && event.Seq > after && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// memoryUsers knows users by ID, with email addresses payloads must leave out
+type memoryUsers struct {
+	*store.UserStore
+	users map[int64]*store.User
+}
+
+func (m *memoryUsers) GetByID(_ context.Context, id int64) (*store.User, error) {
+	user, ok := m.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+// memoryMessages knows messages by ID, for pins
+type memoryMessages struct {
+	*store.MessageStore
+	messages map[int64]*store.Message
+}
+
+func (m *memoryMessages) GetByID(_ context.Context, id int64) (*store.Message, error) {
+	message, ok := m.messages[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return message, nil
+}
+
+// receiver is a webhook endpoint that records what it's sent and answers
+// with the statuses it's told to, then 200
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*receivedRequest
+	statuses []int
+}
+
+type receivedRequest struct {
+	header  http.Header
+	body    []byte
+	payload OutgoingPayload
+}
+
+func newReceiver(t *testing.T) *receiver {
+	r := &receiver{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		received := &receivedRequest{header: req.Header.Clone(), body: body}
+		json.Unmarshal(body, &received.payload)
+
+		r.mu.Lock()
+		r.requests = append(r.requests, received)
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		r.mu.Unlock()
+
+		if status == http.StatusFound {
+			http.Redirect(w, req, "/elsewhere", status)
+			return
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, "status "+strconv.Itoa(status))
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// answer queues the statuses of the next responses
+func (r *receiver) answer(statuses ...int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses = append(r.statuses, statuses...)
+}
+
+func (r *receiver) received() []*receivedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*receivedRequest(nil), r.requests...)
+}
+
+// outgoingTest is a dispatcher on in-memory stores with one delivery worker,
+// so deliveries go out in the order they're queued. The log reader isn't
+// started; tests call readLog themselves
+type outgoingTest struct {
+	*Outgoing
+	hooks  *memoryWebhooks
+	events *memoryRoomEvents
+}
+
+func newOutgoingTest() *outgoingTest {
+	hooks := &memoryWebhooks{hooks: make(map[int64]*store.OutgoingWebhook)}
+	events := &memoryRoomEvents{}
+	users := &memoryUsers{users: map[int64]*store.User{
+		2: {ID: 2, Username: "ada", Email: "ada@example.com"},
+		3: {ID: 3, Username: "grace", Email: "grace@example.com"},
+	}}
+	messages := &memoryMessages{messages: map[int64]*store.Message{
+		7: {ID: 7, RoomID: 1, UserID: 3, Username: "grace", Content: "the deploy is at noon", ContentType: "text"},
+	}}
+	o := NewOutgoing(store.Storage{OutgoingWebhooks: hooks, RoomEvents: events, Users: users, Messages: messages})
+	o.backoff = time.Millisecond
+	go o.worker()
+	return &outgoingTest{Outgoing: o, hooks: hooks, events: events}
+}
+
+// waitDeliveries waits until n deliveries are in the log and returns them
+func (o *outgoingTest) waitDeliveries(t *testing.T, n int) []*store.OutgoingWebhookDelivery {
+	t.Helper()
+	if !testutil.WaitFor(5*time.Second, func() bool { return len(o.hooks.logged()) >= n }) {
+		t.Fatalf("%d deliveries were logged, want %d", len(o.hooks.logged()), n)
+	}
+	return o.hooks.logged()
+}
+
+// chatMessage is the hub's event for a message saved in a room
+func chatMessage(roomID int64, content string, system bool) websocket.HookEvent {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	return websocket.HookEvent{
+		Type:   websocket.EventMessagePersisted,
+		RoomID: roomID,
+		Message: &websocket.Message{Message: wire.Message{
+			ID: 11, RoomID: roomID, UserID: 2, Username: "ada",
+			Content: content, ContentType: "text", System: system, CreatedAt: &created,
+		}},
+		OccurredAt: created,
+	}
+}
+
+// TestOutgoingMessages sends chat messages to the room's webhooks: only the
+// ones that want message_created get them, signed with their own secret and
+// labeled with the event and webhook; system messages aren't sent
+func TestOutgoingMessages(t *testing.T) {
+	o := newOutgoingTest()
+	r := newReceiver(t)
+	o.hooks.add(&store.OutgoingWebhook{ID: 1, RoomID: 1, URL: r.URL, Secret: "secret-of-hook-1", Events: []string{OutgoingMessageCreated}})
+	o.hooks.add(&store.OutgoingWebhook{ID: 2, RoomID: 1, URL: r.URL, Secret: "secret-of-hook-2", Events: []string{OutgoingMessagePinned}})
+	o.hooks.add(&store.OutgoingWebhook{ID: 3, RoomID: 2, URL: r.URL, Secret: "secret-of-hook-3", Events: OutgoingEvents})
+
+	o.messagePersisted(chatMessage(1, "ada joined", true))
+	o.messagePersisted(chatMessage(1, "hello", false))
+	deliveries := o.waitDeliveries(t, 1)
+
+	requests := r.received()
+	if len(requests) != 1 {
+		t.Fatalf("the webhooks got %d requests, want 1", len(requests))
+	}
+	req := requests[0]
+	if got := req.header.Get(SignatureHeader); got != Sign([]byte("secret-of-hook-1"), req.body) {
+		t.Errorf("the signature is %q, want the body signed with webhook 1's secret", got)
+	}
+	if req.header.Get(EventHeader) != OutgoingMessageCreated || req.header.Get(WebhookIDHeader) != "1" {
+		t.Errorf("the request is labeled %s for webhook %s, want message_created for 1",
+			req.header.Get(EventHeader), req.header.Get(WebhookIDHeader))
+	}
+	p := req.payload
+	if p.Event != OutgoingMessageCreated || p.WebhookID != 1 || p.RoomID != 1 || p.Message == nil ||
+		p.Message.ID != 11 || p.Message.Content != "hello" || p.Message.Username != "ada" {
+		t.Errorf("the payload is %s", req.body)
+	}
+
+	d := deliveries[0]
+	if d.WebhookID != 1 || d.Status != store.DeliveryDelivered || d.Attempts != 1 ||
+		d.ResponseCode == nil || *d.ResponseCode != http.StatusOK || d.ResponseBody != "status 200" {
+		t.Errorf("the delivery was logged as %+v", d)
+	}
+}
+
+// TestOutgoingLog sends a room's event log to a webhook: joins, removals,
+// pins and description changes become payloads naming people by ID and
+// username only, other events are skipped, and each event is sent once
+func TestOutgoingLog(t *testing.T) {
+	o := newOutgoingTest()
+	r := newReceiver(t)
+	o.hooks.add(&store.OutgoingWebhook{ID: 1, RoomID: 1, URL: r.URL, Secret: "secret-of-hook-1", Events: OutgoingEvents})
+
+	topic := "deploys only"
+	o.events.append("member_"+store.MembershipJoined, map[string]any{"user_id": 2, "actor_id": 2})
+	o.events.append("member_"+store.MembershipKicked, map[string]any{"user_id": 3, "actor_id": 2})
+	o.events.append(store.RoomEventPinAdded, map[string]any{"message_id": 7, "pinned_by": 2})
+	o.events.append(store.RoomEventRoomUpdated, map[string]any{"version": 2})
+	o.events.append(store.RoomEventRoomUpdated, map[string]any{"version": 3, "description": topic})
+	o.events.append("message_deleted", map[string]any{"message_id": 7})
+
+	o.readLog(1)
+	o.waitDeliveries(t, 4)
+	o.readLog(1) // Nothing new
+	time.Sleep(50 * time.Millisecond)
+
+	requests := r.received()
+	if len(requests) != 4 {
+		t.Fatalf("the webhook got %d requests, want 4", len(requests))
+	}
+	user := func(u *OutgoingUser) string {
+		if u == nil {
+			return "none"
+		}
+		return strconv.FormatInt(u.ID, 10) + " " + u.Username
+	}
+	for i, c := range []struct {
+		event, user, actor string
+	}{
+		{OutgoingMemberJoined, "2 ada", "none"},
+		{OutgoingMemberLeft, "3 grace", "2 ada"},
+		{OutgoingMessagePinned, "none", "2 ada"},
+		{OutgoingTopicChanged, "none", "none"},
+	} {
+		p := requests[i].payload
+		if p.Event != c.event || user(p.User) != c.user || user(p.Actor) != c.actor {
+			t.Errorf("request %d is %s for %s by %s, want %s for %s by %s",
+				i, p.Event, user(p.User), user(p.Actor), c.event, c.user, c.actor)
+		}
+		if strings.Contains(string(requests[i].body), "@example.com") {
+			t.Errorf("request %d carries an email address: %s", i, requests[i].body)
+		}
+	}
+	if m := requests[2].payload.Message; m == nil || m.ID != 7 || m.Content != "the deploy is at noon" {
+		t.Errorf("the pin carries %+v, want message 7", m)
+	}
+	if topic := requests[3].payload.Topic; topic == nil || *topic != "deploys only" {
+		t.Errorf("the topic change carries %v, want %q", topic, "deploys only")
+	}
+	if seq := o.hooks.seq(1); seq != 6 {
+		t.Errorf("the webhook's place in the log is %d, want 6", seq)
+	}
+}
+
+// TestOutgoingLogClaims reads the log after another instance claimed its
+// events, and after the events due were purged: neither sends anything
+func TestOutgoingLogClaims(t *testing.T) {
+	o := newOutgoingTest()
+	r := newReceiver(t)
+	o.hooks.add(&store.OutgoingWebhook{ID: 1, RoomID: 1, URL: r.URL, Secret: "secret-of-hook-1", Events: OutgoingEvents})
+	o.load(context.Background())
+
+	// Another instance sends the first event behind this one's cache
+	o.events.append("member_"+store.MembershipJoined, map[string]any{"user_id": 2})
+	o.hooks.AdvanceSeq(context.Background(), 1, 0, 1)
+	o.readLog(1)
+	if seq := o.hooks.seq(1); seq != 1 {
+		t.Errorf("losing the claim moved the webhook to %d, want 1", seq)
+	}
+
+	// Reloaded, it sends the next one from where the other instance got to
+	o.events.append("member_"+store.MembershipLeft, map[string]any{"user_id": 2})
+	o.readLog(1)
+	o.waitDeliveries(t, 1)
+	if requests := r.received(); len(requests) != 1 || requests[0].payload.Event != OutgoingMemberLeft {
+		t.Fatalf("the webhook got %d requests, want only member_left", len(requests))
+	}
+
+	// Events it never sent were purged
+	o.events.append("member_"+store.MembershipJoined, map[string]any{"user_id": 3})
+	o.events.purgedBefore = 4
+	o.readLog(1)
+	if len(o.hooks.skipped) != 1 || len(r.received()) != 1 {
+		t.Errorf("after a purge SkipEvents ran %d times and %d requests were sent, want once and 1", len(o.hooks.skipped), len(r.received()))
+	}
+}
+
+// TestOutgoingRetries checks which failures are retried: errors from the
+// server and 429 are, up to maxAttempts times; other statuses and redirects
+// (which aren't followed) fail at once. The last response is logged
+func TestOutgoingRetries(t *testing.T) {
+	o := newOutgoingTest()
+	r := newReceiver(t)
+	o.hooks.add(&store.OutgoingWebhook{ID: 1, RoomID: 1, URL: r.URL, Secret: "secret-of-hook-1", Events: OutgoingEvents})
+
+	for i, c := range []struct {
+		statuses []int
+		status   string
+		attempts int
+		code     int
+	}{
+		{[]int{503, 429}, store.DeliveryDelivered, 3, 200},
+		{[]int{503, 502, 500, 503}, store.DeliveryFailed, maxAttempts, 503},
+		{[]int{400}, store.DeliveryFailed, 1, 400},
+		{[]int{http.StatusFound}, store.DeliveryFailed, 1, http.StatusFound},
+	} {
+		r.answer(c.statuses...)
+		o.messagePersisted(chatMessage(1, "hello", false))
+		d := o.waitDeliveries(t, i+1)[i]
+		if d.Status != c.status || d.Attempts != c.attempts || d.ResponseCode == nil || *d.ResponseCode != c.code {
+			code := 0
+			if d.ResponseCode != nil {
+				code = *d.ResponseCode
+			}
+			t.Errorf("answering %v: logged %s after %d attempts with %d, want %s after %d with %d",
+				c.statuses, d.Status, d.Attempts, code, c.status, c.attempts, c.code)
+		}
+		if c.status == store.DeliveryFailed && d.Error == "" {
+			t.Errorf("answering %v: the failure has no error", c.statuses)
+		}
+	}
+	if n, want := len(r.received()), 3+maxAttempts+1+1; n != want {
+		t.Errorf("the webhook got %d requests, want %d", n, want)
+	}
+}
+
+// TestOutgoingDisable fails a webhook's MaxConsecutiveFailures-th delivery in
+// a row: it's turned off, its creator is told once, and it's sent nothing more
+func TestOutgoingDisable(t *testing.T) {
+	o := newOutgoingTest()
+	r := newReceiver(t)
+	o.hooks.add(&store.OutgoingWebhook{ID: 1, RoomID: 1, URL: r.URL, Secret: "secret-of-hook-1", Events: OutgoingEvents,
+		ConsecutiveFailures: MaxConsecutiveFailures - 2})
+	var mu sync.Mutex
+	var disabled []*store.OutgoingWebhook
+	o.OnDisabled(func(hook *store.OutgoingWebhook) {
+		mu.Lock()
+		defer mu.Unlock()
+		disabled = append(disabled, hook)
+	})
+
+	r.answer(400, 400)
+	o.messagePersisted(chatMessage(1, "one", false))
+	o.waitDeliveries(t, 1)
+	mu.Lock()
+	if len(disabled) != 0 {
+		t.Errorf("the webhook was turned off after %d failures", MaxConsecutiveFailures-1)
+	}
+	mu.Unlock()
+
+	o.messagePersisted(chatMessage(1, "two", false))
+	told := testutil.WaitFor(5*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(disabled) > 0
+	})
+	if !told {
+		t.Fatal("the creator was never told the webhook was turned off")
+	}
+	mu.Lock()
+	if len(disabled) != 1 || disabled[0].Enabled || !strings.Contains(disabled[0].DisabledReason, strconv.Itoa(MaxConsecutiveFailures)) {
+		t.Errorf("the creator was told about %d webhooks, want 1 turned off after %d failures", len(disabled), MaxConsecutiveFailures)
+	}
+	mu.Unlock()
+
+	o.messagePersisted(chatMessage(1, "three", false))
+	time.Sleep(50 * time.Millisecond)
+	if n := len(r.received()); n != 2 {
+		t.Errorf("the webhook got %d requests, want none after it was turned off", n-2)
+	}
+}
+
+// TestOutgoingQueueFull queues more deliveries than fit while nothing sends
+// them: the extra ones are dropped and counted, without blocking
+func TestOutgoingQueueFull(t *testing.T) {
+	o := NewOutgoing(store.Storage{}) // No workers
+	hook := &store.OutgoingWebhook{ID: 1, RoomID: 1, Events: []string{OutgoingMessageCreated}}
+	for i := 0; i < outgoingQueueSize+3; i++ {
+		o.enqueue(hook, OutgoingPayload{Event: OutgoingMessageCreated})
+	}
+	o.enqueue(hook, OutgoingPayload{Event: OutgoingTopicChanged}) // Not wanted, so not counted
+	if n := o.Dropped(); n != 3 {
+		t.Errorf("%d deliveries were dropped, want 3", n)
+	}
+}
@@ -0,0 +1,160 @@
+// Package webhook forwards hub events to an external HTTP endpoint
+//
+// Every event is POSTed as JSON and signed with HMAC-SHA256 using a shared secret,
+// so the receiver can check the request really came from this server:
+//
+//	X-GoChat-Signature: sha256=<hex of HMAC-SHA256(secret, body)>
+//
+// Rooms' moderation bots are called the same way, with each room's own secret
+// (see Moderator)
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/websocket"
+)
+
+const (
+	// SignatureHeader carries the HMAC of the request body
+	SignatureHeader = "X-GoChat-Signature"
+
+	// EventHeader carries the event type, so receivers can route without parsing the body
+	EventHeader = "X-GoChat-Event"
+
+	// maxAttempts is how many times one event is sent before giving up
+	maxAttempts = 4
+
+	// initialBackoff is the wait before the first retry; it doubles after each attempt
+	initialBackoff = 500 * time.Millisecond
+
+	// requestTimeout bounds a single delivery attempt
+	requestTimeout = 5 * time.Second
+)
+
+// Config describes where events are sent and which ones
+type Config struct {
+	URL    string
+	Secret string
+
+	// Events limits which event types are sent; empty sends all of them
+	Events []string
+}
+
+// Dispatcher POSTs hub events to a webhook URL
+type Dispatcher struct {
+	url    string
+	secret []byte
+	events map[string]bool
+	client *http.Client
+}
+
+// New creates a dispatcher for the given configuration
+func New(cfg Config) *Dispatcher {
+	events := make(map[string]bool, len(cfg.Events))
+	for _, event := range cfg.Events {
+		events[event] = true
+	}
+
+	return &Dispatcher{
+		url:    cfg.URL,
+		secret: []byte(cfg.Secret),
+		events: events,
+		client: &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// Register subscribes the dispatcher to the allowed events on a hook registry
+// Retries block a hook worker, which is fine: the hub never waits on hooks
+func (d *Dispatcher) Register(hooks *websocket.HookRegistry) {
+	if d.wants(websocket.EventMessagePersisted) {
+		hooks.OnMessagePersisted(d.Send)
+	}
+	if d.wants(websocket.EventClientJoined) {
+		hooks.OnClientJoined(d.Send)
+	}
+	if d.wants(websocket.EventClientLeft) {
+		hooks.OnClientLeft(d.Send)
+	}
+	if d.wants(websocket.EventRoomEmptied) {
+		hooks.OnRoomEmptied(d.Send)
+	}
+}
+
+// wants reports whether an event type passes the allowlist
+func (d *Dispatcher) wants(eventType string) bool {
+	return len(d.events) == 0 || d.events[eventType]
+}
+
+// Send delivers one event, retrying with exponential backoff
+// Failures are logged and the event is dropped after the last attempt
+func (d *Dispatcher) Send(event websocket.HookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal webhook event %s: %v", event.Type, err)
+		return
+	}
+
+	backoff := initialBackoff
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = d.post(event.Type, body)
+		if err == nil {
+			return
+		}
+
+		if attempt < maxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	log.Printf("Webhook delivery of %s failed after %d attempts: %v", event.Type, maxAttempts, err)
+}
+
+// post makes a single delivery attempt
+// Any 2xx response counts as delivered
+func (d *Dispatcher) post(eventType string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, eventType)
+	req.Header.Set(SignatureHeader, Sign(d.secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the signature header value for a request body
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature header value against a request body
+// Receivers written in Go can use this directly; it compares in constant time
+func Verify(secret, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/websocket"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// TestSignedDelivery receives one event the way an integration would: the
+// signature verifies against the raw body with the shared secret, and the
+// body is the event
+func TestSignedDelivery(t *testing.T) {
+	secret := []byte("s3cret")
+	type request struct {
+		event, signature string
+		body             []byte
+	}
+	received := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- request{r.Header.Get(EventHeader), r.Header.Get(SignatureHeader), body}
+	}))
+	defer server.Close()
+
+	New(Config{URL: server.URL, Secret: string(secret)}).Send(websocket.HookEvent{
+		Type:     websocket.EventMessagePersisted,
+		RoomID:   1,
+		UserID:   2,
+		Username: "grace",
+		Message:  &websocket.Message{Message: wire.Message{ID: 9, Content: "hi"}},
+	})
+
+	got := <-received
+	if got.event != websocket.EventMessagePersisted {
+		t.Errorf("%s = %q, want %q", EventHeader, got.event, websocket.EventMessagePersisted)
+	}
+	if !Verify(secret, got.body, got.signature) {
+		t.Errorf("signature %q doesn't verify", got.signature)
+	}
+	var event websocket.HookEvent
+	if err := json.Unmarshal(got.body, &event); err != nil {
+		t.Fatalf("decoding the body: %v", err)
+	}
+	if event.Type != websocket.EventMessagePersisted || event.UserID != 2 || event.Message == nil || event.Message.Content != "hi" {
+		t.Errorf("received %+v", event)
+	}
+}
+
+// TestVerify checks a signature is only accepted for the body and secret it
+// was made with
+func TestVerify(t *testing.T) {
+	secret, body := []byte("s3cret"), []byte(`{"event":"client.joined"}`)
+	signature := Sign(secret, body)
+
+	for _, tc := range []struct {
+		name      string
+		secret    []byte
+		body      []byte
+		signature string
+		want      bool
+	}{
+		{"matching", secret, body, signature, true},
+		{"tampered body", secret, []byte(`{"event":"client.left"}`), signature, false},
+		{"other secret", []byte("guess"), body, signature, false},
+		{"missing prefix", secret, body, signature[len("sha256="):], false},
+		{"empty", secret, body, "", false},
+	} {
+		if got := Verify(tc.secret, tc.body, tc.signature); got != tc.want {
+			t.Errorf("%s: Verify = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestRetry fails the first attempt: the event is sent again, unchanged
+func TestRetry(t *testing.T) {
+	var attempts atomic.Int32
+	var bodies [2]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := attempts.Add(1)
+		if n <= 2 {
+			body, _ := io.ReadAll(r.Body)
+			bodies[n-1] = string(body)
+		}
+		if n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	New(Config{URL: server.URL}).Send(websocket.HookEvent{Type: websocket.EventClientJoined, RoomID: 1})
+	if n := attempts.Load(); n != 2 {
+		t.Errorf("made %d attempts, want 2", n)
+	}
+	if bodies[0] != bodies[1] {
+		t.Errorf("the retry sent %s, first attempt %s", bodies[1], bodies[0])
+	}
+}
+
+// TestAllowlist checks which events a dispatcher sends
+func TestAllowlist(t *testing.T) {
+	all := New(Config{})
+	some := New(Config{Events: []string{websocket.EventClientJoined, websocket.EventRoomEmptied}})
+
+	for _, tc := range []struct {
+		event    string
+		wantSome bool
+	}{
+		{websocket.EventMessagePersisted, false},
+		{websocket.EventClientJoined, true},
+		{websocket.EventClientLeft, false},
+		{websocket.EventRoomEmptied, true},
+	} {
+		if !all.wants(tc.event) {
+			t.Errorf("with no allowlist %s isn't sent", tc.event)
+		}
+		if got := some.wants(tc.event); got != tc.wantSome {
+			t.Errorf("with an allowlist wants(%s) = %v, want %v", tc.event, got, tc.wantSome)
+		}
+	}
+}
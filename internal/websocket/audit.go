@@ -0,0 +1,81 @@
+package websocket
+
+import "log"
+
+// sequenceAudit checks that room broadcasts reach every client in order
+// Each broadcast is stamped with the next number in its room's sequence
+// (Message.AuditSeq), and every client's last seen number is tracked: a client
+// about to get a number that isn't exactly one more has been skipped (a gap) or
+// would see frames out of order
+// Owned by the shard loop, like the rooms it audits
+type sequenceAudit struct {
+	// Last sequence number handed out per room
+	last map[int64]int64
+
+	frames     int64 // Room broadcasts stamped
+	outOfOrder int64 // Frames a client would have seen at or below its last number
+	gaps       int64 // Frames a client would have seen with numbers skipped before them
+}
+
+// AuditStats are the sequence audit counters, summed across shards
+// Outside of a bug, OutOfOrder and Gaps stay at zero
+type AuditStats struct {
+	Frames     int64 `json:"frames"`
+	OutOfOrder int64 `json:"out_of_order"`
+	Gaps       int64 `json:"gaps"`
+}
+
+func newSequenceAudit() *sequenceAudit {
+	return &sequenceAudit{last: make(map[int64]int64)}
+}
+
+// SetSequenceAudit turns on sequence auditing (see sequenceAudit)
+// It costs a map lookup per broadcast and adds "audit_seq" to room frames,
+// so it's meant for chasing ordering bugs rather than running all the time
+// Must be called before Run
+func (h *Hub) SetSequenceAudit(enabled bool) {
+	for _, s := range h.shards {
+		if enabled {
+			s.audit = newSequenceAudit()
+		} else {
+			s.audit = nil
+		}
+	}
+}
+
+// start lines a newly registered client up with its room's current sequence
+// The client only expects the broadcasts made after it joined
+func (a *sequenceAudit) start(client *Client) {
+	client.auditSeq = a.last[client.roomID]
+}
+
+// stamp hands out the next sequence number for a room
+func (a *sequenceAudit) stamp(roomID int64) int64 {
+	a.last[roomID]++
+	a.frames++
+	return a.last[roomID]
+}
+
+// observe checks a stamped frame against what the client saw last
+// Clients that filter out the frame's type are checked too: skipping a frame
+// on purpose still moves them along the sequence
+func (a *sequenceAudit) observe(client *Client, seq int64) {
+	switch {
+	case seq <= client.auditSeq:
+		a.outOfOrder++
+		log.Printf("Sequence audit: out of order frame for user=%d room=%d: seq %d after %d",
+			client.userID, client.roomID, seq, client.auditSeq)
+		return
+	case seq > client.auditSeq+1:
+		a.gaps++
+		log.Printf("Sequence audit: gap for user=%d room=%d: seq %d after %d",
+			client.userID, client.roomID, seq, client.auditSeq)
+	}
+	client.auditSeq = seq
+}
+
+// forget drops a room's sequence once its last client has left
+// The next client starts a fresh sequence, so memory doesn't grow with every room ever used
+func (a *sequenceAudit) forget(roomID int64) {
+	delete(a.last, roomID)
+}
@@ -1,9 +1,16 @@
 package websocket
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"sync/atomic"
 	"time"
 
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/drazan344/go-chat/pkg/wire"
 	"github.com/gorilla/websocket"
 )
 
@@ -18,11 +25,11 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait
 	// This helps detect broken connections
 	pingPeriod = (pongWait * 9) / 10
-
-	// Maximum message size allowed from peer (1MB)
-	maxMessageSize = 1024 * 1024
 )
 
+// errFrameTooBig is returned by readFrame for frames over the frame size limit
+var errFrameTooBig = errors.New("websocket frame exceeds maximum message size")
+
 // Client represents a single WebSocket connection
 // Each user connected to a room has their own Client instance
 type Client struct {
@@ -40,8 +47,227 @@ type Client struct {
 	userID   int64
 	username string
 
+	// sessionID is the login session the connection was authenticated with
+	// (see SetSession); 0 for guests, API tokens and older tokens
+	sessionID int64
+
 	// Room ID this client is connected to
 	roomID int64
+
+	// readOnly marks anonymous guest connections
+	// Frames sent by read-only clients are discarded instead of broadcast
+	readOnly bool
+
+	// hasRoomStats is set once the client has been sent its room's stats
+	// Owned by the shard loop (see room_stats.go)
+	hasRoomStats bool
+
+	// suppressEcho keeps the client's own chat messages from being sent back
+	// to it; owned by the shard loop once the client has started (see options.go)
+	suppressEcho bool
+
+	// silentAllowed lets the client send silent messages (see AllowSilent)
+	silentAllowed bool
+
+	// postingDenied marks members whose role may not post (see DenyPosting)
+	postingDenied bool
+
+	// mentionEveryone lets the client's @here and @room notify the room (see AllowMentionEveryone)
+	mentionEveryone bool
+
+	// role is the user's role in the room when they connected (see SetRoomRole)
+	role string
+
+	// removed is set by the shard when it evicts the client from its room
+	// (see membership.go); readPump refuses the client's frames from then on
+	removed atomic.Bool
+
+	// done is closed when the connection has been torn down
+	done chan struct{}
+
+	// filter selects which room events are delivered to this client
+	// Owned by the shard loop once the client has started
+	filter eventFilter
+
+	// initialEvents is the filter requested at connection time (see SetEventFilter)
+	initialEvents []string
+
+	// proto is the negotiated frame format version (see SetProtocol)
+	proto int
+
+	// sendHello is set when the client negotiated a version and expects a hello frame
+	sendHello bool
+
+	// seq numbers the frames sent on this connection (v2 and later)
+	// Owned by the shard loop
+	seq int64
+
+	// throttle tracks the bandwidth sent to the client (see throttle.go)
+	// Owned by the shard loop
+	throttle throttleState
+
+	// auditSeq is the last room sequence number this client was handed
+	// Only used with sequence auditing on; owned by the shard loop
+	auditSeq int64
+
+	// closeCode and closeReason are sent in the close frame when the shard disconnects the client
+	// Zero sends an empty close frame; set by the shard before closing send
+	closeCode   int
+	closeReason string
+
+	// rtt measures round-trip time from ping/pong (see rtt.go)
+	rtt rttTracker
+
+	// pingStats is set when the client asked for "ping_stats" frames (see SetPingStats)
+	pingStats bool
+
+	// When writePump last finished writing a frame, and when the write under
+	// way started (0 if none), in Unix nanoseconds (see liveness.go)
+	lastWrite    atomic.Int64
+	writeStarted atomic.Int64
+
+	// pendingSince is when frames last started waiting in an empty send channel
+	// Owned by the shard loop
+	pendingSince time.Time
+
+	// historyInFlight counts this connection's running history requests (see history.go)
+	historyInFlight atomic.Int32
+
+	// inviteResponsesInFlight counts this connection's running invite responses (see invites.go)
+	inviteResponsesInFlight atomic.Int32
+
+	// queuedBytes is the bytes waiting in send (see memory.go)
+	queuedBytes atomic.Int64
+}
+
+// NewClient creates a client for an authenticated, upgraded WebSocket connection
+// Call Start to register it with the hub and begin pumping messages
+func NewClient(hub *Hub, conn *websocket.Conn, userID int64, username string, roomID int64) *Client {
+	return &Client{
+		hub:      hub,
+		conn:     conn,
+		send:     make(chan []byte, 256), // Buffered channel to prevent blocking
+		userID:   userID,
+		username: username,
+		roomID:   roomID,
+		done:     make(chan struct{}),
+		proto:    ProtoV1,
+	}
+}
+
+// NewGuestClient creates a read-only client for an anonymous viewer
+// Guests have no user account, so they get a zero user ID and a generated name
+func NewGuestClient(hub *Hub, conn *websocket.Conn, username string, roomID int64) *Client {
+	client := NewClient(hub, conn, 0, username, roomID)
+	client.readOnly = true
+	return client
+}
+
+// Start registers the client with the hub and starts its goroutines
+// readPump: reads messages from WebSocket and sends to hub
+// writePump: reads from send channel and writes to WebSocket
+// pingPump: pings the client, independently of writePump (see liveness.go)
+func (c *Client) Start() {
+	c.join()
+	go c.writePump()
+	go c.readPump()
+	go c.pingPump()
+}
+
+// SetSession records the login session the connection belongs to, so
+// revoking the session disconnects it (see Hub.CloseSession)
+// Must be called before Start
+func (c *Client) SetSession(sessionID int64) {
+	c.sessionID = sessionID
+}
+
+// DenyPosting refuses the client's chat messages with an error frame
+// Used when the user's role in the room lacks post_message; the check is made
+// when the connection opens, so a permission change applies on reconnect
+// Must be called before Start
+func (c *Client) DenyPosting() {
+	c.postingDenied = true
+}
+
+// Done returns a channel that is closed once the client has disconnected
+// Callers use it to release resources tied to the connection's lifetime
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// inboundFrame is the JSON form of a message sent by a client
+// Clients may also send a bare string, which is treated as plain text
+type inboundFrame struct {
+	Content     *string `json:"content"`
+	ContentType string  `json:"content_type"`
+	Language    string  `json:"language"`
+
+	// Type is set on control frames, e.g. "set_filter"
+	Type   string   `json:"type"`
+	Events []string `json:"events"`
+
+	// Set on "history_request" frames (see history.go)
+	RoomID   int64  `json:"room_id"`
+	BeforeID int64  `json:"before_id"`
+	Limit    int    `json:"limit"`
+	ReqID    string `json:"req_id"`
+
+	// Set on "set_options" frames (see options.go)
+	SuppressEcho *bool `json:"suppress_echo"`
+
+	// Set on "invite_response" frames (see invites.go)
+	InviteID int64 `json:"invite_id"`
+	Accept   *bool `json:"accept"`
+
+	// Silent asks for a chat message without notifications; bots only
+	Silent bool `json:"silent"`
+}
+
+// parseInbound decodes a raw WebSocket frame
+// A frame is only treated as JSON if it decodes into an object with a "content"
+// field or a control "type", so plain-text messages that happen to look like
+// JSON still work
+func parseInbound(frame []byte) inboundFrame {
+	var in inboundFrame
+	if err := json.Unmarshal(frame, &in); err == nil && (in.Content != nil || in.Type != "") {
+		return in
+	}
+	body := string(frame)
+	return inboundFrame{Content: &body, ContentType: content.TypeText}
+}
+
+// formatted returns the chat content carried by a frame
+func (in inboundFrame) formatted() content.Formatted {
+	f := content.Formatted{Type: in.ContentType, Language: in.Language}
+	if in.Content != nil {
+		f.Body = *in.Content
+	}
+	return f
+}
+
+// sendError replies to this client alone with an error frame
+func (c *Client) sendError(code, message string) {
+	c.hub.sendToClient(c, &Message{
+		Message: wire.Message{
+			RoomID:  c.roomID,
+			Content: message,
+			Type:    "error",
+			Code:    code,
+		},
+	})
+}
+
+// sendNotice sends the client a "notice" frame: information about something
+// it sent that went through, unlike an error
+func (c *Client) sendNotice(code, message string) {
+	c.hub.sendToClient(c, &Message{
+		Message: wire.Message{
+			RoomID:  c.roomID,
+			Content: message,
+			Type:    "notice",
+			Code:    code,
+		},
+	})
 }
 
 // readPump pumps messages from the WebSocket connection to the hub
@@ -51,27 +277,41 @@ func (c *Client) readPump() {
 	// Cleanup when this function exits
 	defer func() {
 		// Unregister the client from the hub
-		c.hub.unregister <- c
+		c.hub.unregister(c)
 		// Close the WebSocket connection
 		c.conn.Close()
+		// Signal anyone waiting on Done that the connection is gone
+		close(c.done)
 	}()
 
 	// Configure connection settings
-	c.conn.SetReadLimit(maxMessageSize)
+	// No SetReadLimit: readFrame enforces the frame size limit itself, so it can
+	// explain the close instead of gorilla aborting the connection opaquely
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 
 	// SetPongHandler sets up a handler for pong messages
 	// When a pong is received, extend the read deadline
 	// This is part of the ping/pong mechanism to detect broken connections
-	c.conn.SetPongHandler(func(string) error {
+	// The pong also echoes the ping's send time, which gives us the round-trip time
+	c.conn.SetPongHandler(func(payload string) error {
 		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		c.handlePong(payload)
 		return nil
 	})
 
 	// Continuously read messages from the WebSocket
 	for {
-		// ReadMessage blocks until a message is received
-		_, message, err := c.conn.ReadMessage()
+		// readFrame blocks until a message is received
+		limit := c.hub.tuning.Load().frameLimit()
+		message, err := c.readFrame(limit)
+		if errors.Is(err, errFrameTooBig) {
+			// Close with 1009 (message too big) and say what the limit is
+			reason := fmt.Sprintf("message exceeds %d bytes", limit)
+			c.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseMessageTooBig, reason),
+				time.Now().Add(writeWait))
+			break
+		}
 		if err != nil {
 			// WebSocket connection errors are normal when clients disconnect
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
@@ -80,75 +320,165 @@ func (c *Client) readPump() {
 			break
 		}
 
+		in := parseInbound(message)
+
+		// Control frames change connection settings and are never broadcast
+		// Guests may use them too, since they only affect what the guest receives
+		switch in.Type {
+		case "":
+			// A chat message, handled below
+		case "set_filter":
+			c.changeFilter(in.Events)
+			continue
+		case "history_request":
+			c.requestHistory(in)
+			continue
+		case "set_options":
+			c.changeOptions(in)
+			continue
+		case "invite_response":
+			c.respondToInvite(in)
+			continue
+		default:
+			c.sendError("unknown_frame_type", "unknown frame type "+in.Type)
+			continue
+		}
+
+		// Guests can watch but never post
+		// Tell them why their frame went nowhere instead of silently dropping it
+		if c.readOnly {
+			c.sendError("guest_read_only", "guests cannot send messages")
+			continue
+		}
+		if c.removed.Load() {
+			c.sendError("not_room_member", "you are no longer a member of this room")
+			continue
+		}
+		if c.postingDenied {
+			c.sendError("room_permission_denied", "your role in this room doesn't allow post_message")
+			continue
+		}
+		if in.Silent && !c.silentAllowed {
+			c.sendError("silent_not_allowed", "only API token connections can send silent messages")
+			continue
+		}
+
+		// Validate the content before it reaches the hub
+		// Invalid messages are reported back to the sender only
+		formatted, err := content.Validate(in.formatted(), c.hub.LengthPolicy())
+		if err != nil {
+			var validationErr *content.Error
+			if errors.As(err, &validationErr) {
+				c.sendError(validationErr.Code, validationErr.Message)
+			}
+			continue
+		}
+
 		// Create a message struct to send to the hub
 		msg := &Message{
-			RoomID:   c.roomID,
-			UserID:   c.userID,
-			Username: c.username,
-			Content:  string(message),
-			Type:     "message",
+			Message: wire.Message{
+				RoomID:      c.roomID,
+				UserID:      c.userID,
+				Username:    c.username,
+				Content:     formatted.Body,
+				Type:        "message",
+				ContentType: formatted.Type,
+				Language:    formatted.Language,
+				Truncated:   formatted.Truncated,
+				Silent:      in.Silent,
+			},
+			sender: c,
+		}
+
+		// @here and @room only notify the room when the sender may use them;
+		// otherwise they're sent as plain text and the sender is told why
+		if scope := content.RoomMention(formatted.Body); scope != "" {
+			if c.mentionEveryone {
+				msg.RoomMention = scope
+			} else {
+				c.sendNotice("mention_everyone_denied", "your role in this room doesn't allow mention_everyone; @"+scope+" was sent as plain text")
+			}
 		}
 
 		// Send message to the hub for broadcasting
 		// The hub will persist it to the database and broadcast to all clients in the room
-		c.hub.broadcast <- msg
+		c.hub.broadcast(msg)
+	}
+}
+
+// readFrame reads the next data frame, up to limit bytes
+// Reading stops one byte past the limit, so an oversized frame never has to
+// fit in memory before it's refused with errFrameTooBig
+func (c *Client) readFrame(limit int) ([]byte, error) {
+	_, r, err := c.conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	frame, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
 	}
+	if len(frame) > limit {
+		return nil, errFrameTooBig
+	}
+	return frame, nil
 }
 
 // writePump pumps messages from the hub to the WebSocket connection
 // A goroutine running writePump is started for each connection
 // The application ensures that there is at most one writer to a connection
 func (c *Client) writePump() {
-	// Create a ticker to send ping messages periodically
-	// Pings help detect broken connections
-	ticker := time.NewTicker(pingPeriod)
 	defer func() {
-		ticker.Stop()
 		c.conn.Close()
+
+		// Frames left when the connection failed are never written; take them
+		// off the hub's queued bytes as the shard adds them, until readPump's
+		// unregister makes the shard close the channel
+		for message := range c.send {
+			c.hub.memory.dequeued(c, len(message))
+		}
 	}()
 
-	for {
-		select {
-		case message, ok := <-c.send:
-			// Set write deadline
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-
-			// Check if channel was closed
-			if !ok {
-				// The hub closed the channel, close the connection
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
+	// Pings are sent by pingPump, so a slow write never holds them up
+	for message := range c.send {
+		// Set write deadline
+		c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 
-			// Get a writer for the next message
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
+		// Off the channel, so no longer counted as queued (see memory.go)
+		c.hub.memory.dequeued(c, len(message))
+		c.beginWrite()
 
-			// Write the message
-			w.Write(message)
+		// Get a writer for the next message
+		w, err := c.conn.NextWriter(websocket.TextMessage)
+		if err != nil {
+			return
+		}
 
-			// Add queued messages to the current WebSocket message
-			// This is an optimization to batch multiple messages into one WebSocket frame
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.send)
-			}
+		// Write the message
+		w.Write(message)
 
-			// Close the writer, sending the message
-			if err := w.Close(); err != nil {
-				return
-			}
+		// Add queued messages to the current WebSocket message
+		// This is an optimization to batch multiple messages into one WebSocket frame
+		n := len(c.send)
+		for i := 0; i < n; i++ {
+			queued := <-c.send
+			c.hub.memory.dequeued(c, len(queued))
+			w.Write([]byte{'\n'})
+			w.Write(queued)
+		}
 
-		case <-ticker.C:
-			// Send a ping message to the client
-			// If the client doesn't respond with a pong, the connection will timeout
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				return
-			}
+		// Close the writer, sending the message
+		if err := w.Close(); err != nil {
+			return
 		}
+		c.endWrite()
+	}
+
+	// The hub closed the channel, close the connection
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	closeMessage := []byte{}
+	if c.closeCode != 0 {
+		closeMessage = websocket.FormatCloseMessage(c.closeCode, c.closeReason)
 	}
+	c.conn.WriteMessage(websocket.CloseMessage, closeMessage)
 }
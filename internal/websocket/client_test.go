@@ -0,0 +1,136 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/pkg/wire"
+	"github.com/gorilla/websocket"
+)
+
+// TestInboundContentTypes sends typed frames: valid ones reach the room
+// normalized, with their type and language, and invalid ones are refused to
+// the sender alone with an error code
+func TestInboundContentTypes(t *testing.T) {
+	messages := newMemoryMessages()
+	hub := NewHub(store.Storage{Messages: messages, Rooms: roomSettings{}, Receipts: &memoryReceipts{}}, 0)
+	hub.SetRoomStatsInterval(0)
+	go hub.Run()
+
+	sender := dialTestHub(t, hub, 1, 1)
+	reader := dialTestHub(t, hub, 2, 1)
+	framesUntil(t, reader, "join") // Its own
+
+	if err := sender.WriteJSON(map[string]string{"content_type": "html", "content": "<b>hi</b>"}); err != nil {
+		t.Fatal(err)
+	}
+	frames := framesUntil(t, sender, "error")
+	if got := frames[len(frames)-1]; got.Code != "unknown_content_type" {
+		t.Errorf("an unknown type was refused with %q, want unknown_content_type", got.Code)
+	}
+
+	// One at a time: frames queued for a client are batched into one
+	// WebSocket message, of which ReadJSON only decodes the first
+	for _, tc := range []struct {
+		frame any
+		want  Message
+	}{
+		{map[string]string{"content_type": "markdown", "content": "**hi** <script>x</script>"}, Message{Message: wire.Message{ContentType: "markdown", Content: "**hi** "}}},
+		{map[string]string{"content_type": "code", "language": "Go", "content": "<b>"}, Message{Message: wire.Message{ContentType: "code", Language: "go", Content: "<b>"}}},
+		{`{"not": "a frame"}`, Message{Message: wire.Message{ContentType: "text", Content: `{"not": "a frame"}`}}},
+	} {
+		var err error
+		if text, ok := tc.frame.(string); ok {
+			err = sender.WriteMessage(websocket.TextMessage, []byte(text))
+		} else {
+			err = sender.WriteJSON(tc.frame)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames := framesUntil(t, reader, "message")
+		got, want := frames[len(frames)-1], tc.want
+		if got.ContentType != want.ContentType || got.Language != want.Language || got.Content != want.Content {
+			t.Errorf("the room got %s/%s %q, want %s/%s %q", got.ContentType, got.Language, got.Content, want.ContentType, want.Language, want.Content)
+		}
+	}
+
+	if saved := messages.saved(1); len(saved) != 3 {
+		t.Errorf("saved %d messages, want the 3 valid ones", len(saved))
+	}
+}
+
+// TestLengthPolicy sends messages over a 10 rune limit: rejected ones get
+// an error frame, truncated ones are saved and broadcast cut down and marked
+func TestLengthPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		oversize string
+		want     Message
+	}{
+		{content.OversizeReject, Message{Message: wire.Message{Type: "error", Code: "message_too_long", Content: "text messages are limited to 10 characters"}}},
+		{content.OversizeTruncate, Message{Message: wire.Message{Type: "message", Content: "0123456789", Truncated: true}}},
+	} {
+		t.Run(tc.oversize, func(t *testing.T) {
+			messages := newMemoryMessages()
+			hub := NewHub(store.Storage{Messages: messages, Rooms: roomSettings{}, Receipts: &memoryReceipts{}}, 0)
+			tunables := DefaultTunables()
+			tunables.Lengths = content.LengthPolicy{MaxLength: 10, Oversize: tc.oversize}
+			hub.SetTunables(tunables)
+			hub.SetRoomStatsInterval(0)
+			go hub.Run()
+
+			sender := dialTestHub(t, hub, 1, 1)
+			framesUntil(t, sender, "join")
+			if err := sender.WriteJSON(map[string]string{"content": "0123456789abc"}); err != nil {
+				t.Fatal(err)
+			}
+			frames := framesUntil(t, sender, tc.want.Type)
+			got := frames[len(frames)-1]
+			if got.Code != tc.want.Code || got.Content != tc.want.Content || got.Truncated != tc.want.Truncated {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+
+			saved := messages.saved(1)
+			if tc.want.Type == "error" {
+				if len(saved) != 0 {
+					t.Errorf("saved %d rejected messages", len(saved))
+				}
+				return
+			}
+			if len(saved) != 1 || saved[0].Content != tc.want.Content || !saved[0].Truncated {
+				t.Errorf("saved %+v, want the truncated message", saved)
+			}
+		})
+	}
+}
+
+// TestOversizedFrame sends a frame over the transport limit: the connection
+// is closed with 1009 and a reason naming the limit
+func TestOversizedFrame(t *testing.T) {
+	hub := newTestHub(0)
+	go hub.Run()
+
+	conn := dialTestHub(t, hub, 1, 1)
+	framesUntil(t, conn, "join")
+	if err := conn.WriteMessage(websocket.TextMessage, make([]byte, defaultMaxFrameBytes+1)); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue // Frames sent before the close
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseMessageTooBig || !strings.Contains(closeErr.Text, fmt.Sprint(defaultMaxFrameBytes)) {
+			t.Errorf("the connection ended with %v, want 1009 naming the limit", err)
+		}
+		return
+	}
+}
@@ -0,0 +1,79 @@
+package websocket
+
+import (
+	"log"
+	"time"
+
+	"github.com/drazan344/go-chat/pkg/wire"
+	"github.com/gorilla/websocket"
+)
+
+// CloseServiceRestart is the close code sent to clients still connected when a
+// drain deadline passes (1012, "service restart"), telling them to reconnect
+const CloseServiceRestart = websocket.CloseServiceRestart
+
+// Drain puts the hub into draining mode before a deploy
+// Every connected client gets a "server_draining" frame asking it to reconnect
+// after reconnectAfter (ideally to another instance), and Draining starts
+// returning true so handlers can refuse new connections
+// With a deadline greater than zero, clients still connected when it elapses
+// are closed with CloseServiceRestart
+// Returns false (and does nothing) if the hub is already draining
+func (h *Hub) Drain(reconnectAfter, deadline time.Duration) bool {
+	if !h.draining.CompareAndSwap(false, true) {
+		return false
+	}
+
+	log.Printf("Hub draining: reconnect_after=%s deadline=%s", reconnectAfter, deadline)
+
+	notice := &Message{
+		Message: wire.Message{
+			Content:        "server is restarting, please reconnect",
+			Type:           "server_draining",
+			ReconnectAfter: int(reconnectAfter.Seconds()),
+		},
+	}
+
+	for _, s := range h.shards {
+		s.post(func() {
+			for _, clients := range s.rooms {
+				for client := range clients {
+					// Addressed to every client individually, so the event filter doesn't apply
+					s.deliverToClient(client, notice)
+				}
+			}
+		})
+	}
+
+	if deadline > 0 {
+		time.AfterFunc(deadline, h.closeAll)
+	}
+	return true
+}
+
+// Draining reports whether Drain has been called
+func (h *Hub) Draining() bool {
+	return h.draining.Load()
+}
+
+// closeAll disconnects every remaining client with CloseServiceRestart
+func (h *Hub) closeAll() {
+	for _, s := range h.shards {
+		s.post(func() {
+			closed := 0
+			for _, clients := range s.rooms {
+				for client := range clients {
+					// Set before removeClient closes the send channel; writePump reads
+					// it after seeing the channel closed, so there's no race
+					client.closeCode = CloseServiceRestart
+					client.closeReason = "server draining"
+					s.removeClient(client)
+					closed++
+				}
+			}
+			if closed > 0 {
+				log.Printf("Drain deadline passed: closed %d clients on shard %d", closed, s.id)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/drazan344/go-chat/internal/testutil"
+)
+
+// drainNotice reads a client's frames until the server_draining one and
+// returns it
+// The test fails if none comes within a few seconds
+func drainNotice(t *testing.T, client *Client) Message {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case frame := <-client.send:
+			var message Message
+			if err := json.Unmarshal(frame, &message); err != nil {
+				t.Fatal(err)
+			}
+			if message.Type == "server_draining" {
+				return message
+			}
+		case <-timeout:
+			t.Fatalf("user %d was never told the server is draining", client.userID)
+		}
+	}
+}
+
+// TestDrainNotifiesClients drains a hub with clients in two rooms: each is
+// told when to reconnect, a client filtering out everything but chat
+// included, and draining again does nothing
+func TestDrainNotifiesClients(t *testing.T) {
+	hub := newTestHub(2)
+	go hub.Run()
+
+	chatOnly := newTestClient(hub, 1, 1, 64)
+	chatOnly.SetEventFilter([]string{"message"})
+	chatOnly.join()
+	other := newTestClient(hub, 2, 2, 64)
+	other.join()
+
+	if !hub.Drain(7*time.Second, 0) {
+		t.Fatal("Drain refused to start")
+	}
+	if !hub.Draining() || !hub.Stats().Draining {
+		t.Error("the hub doesn't report draining")
+	}
+	for _, client := range []*Client{chatOnly, other} {
+		if notice := drainNotice(t, client); notice.ReconnectAfter != 7 {
+			t.Errorf("user %d was told to reconnect after %ds, want 7", client.userID, notice.ReconnectAfter)
+		}
+	}
+
+	// A second drain can't bring in a deadline of its own
+	if hub.Drain(time.Second, time.Millisecond) {
+		t.Error("draining twice started a second drain")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if clients := hub.Stats().Clients; clients != 2 {
+		t.Errorf("%d clients are left, want 2", clients)
+	}
+}
+
+// TestDrainDeadline drains with a deadline: a connection still open when
+// it passes gets the notice and is then closed with 1012 (service restart)
+func TestDrainDeadline(t *testing.T) {
+	hub := newTestHub(1)
+	go hub.Run()
+	conn := dialTestHub(t, hub, 1, 1)
+	framesUntil(t, conn, "join")
+
+	hub.Drain(time.Second, 100*time.Millisecond)
+	framesUntil(t, conn, "server_draining")
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.ReadMessage()
+	var closed *websocket.CloseError
+	if !errors.As(err, &closed) || closed.Code != CloseServiceRestart {
+		t.Fatalf("after the deadline the connection got %v, want close code %d", err, CloseServiceRestart)
+	}
+	if !testutil.WaitFor(time.Second, func() bool { return hub.Stats().Clients == 0 }) {
+		t.Errorf("%d clients are left after the deadline", hub.Stats().Clients)
+	}
+}
@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+const (
+	// defaultDuplicateLimit is how many identical messages a user may send per window
+	defaultDuplicateLimit = 3
+
+	// defaultDuplicateWindow is the rolling window identical messages are counted in
+	defaultDuplicateWindow = time.Minute
+)
+
+// duplicateLimit caps repeated identical messages in rooms that enable it
+// Messages count as identical if their normalized content matches (see content.Hash)
+type duplicateLimit struct {
+	max    int           // Identical messages allowed per window; zero or less disables the check
+	window time.Duration // How far back identical messages are counted
+}
+
+// IsDuplicate reports whether a message with this content hash would exceed
+// the room's duplicate limit, using the same rules as the WebSocket path
+func (h *Hub) IsDuplicate(ctx context.Context, roomID, userID int64, contentHash string) bool {
+	return h.tuning.Load().duplicates().exceeded(ctx, h.store, roomID, userID, contentHash)
+}
+
+// exceeded reports whether the user already sent max identical messages within the window
+// Lookup failures let the message through; losing spam protection briefly beats losing chat
+func (d duplicateLimit) exceeded(ctx context.Context, st store.Storage, roomID, userID int64, contentHash string) bool {
+	if d.max <= 0 {
+		return false
+	}
+
+	enabled, err := st.Rooms.IsDuplicateLimitEnabled(ctx, roomID)
+	if err != nil {
+		log.Printf("Failed to check duplicate limit setting for room %d: %v", roomID, err)
+		return false
+	}
+	if !enabled {
+		return false
+	}
+
+	count, err := st.Messages.CountRecentIdentical(ctx, roomID, userID, contentHash, d.window)
+	if err != nil {
+		log.Printf("Failed to count duplicate messages in room %d: %v", roomID, err)
+		return false
+	}
+	return count >= d.max
+}
@@ -0,0 +1,90 @@
+package websocket
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// TestDuplicateLimit repeats a message in a room with the limit on: the
+// first three are saved, the fourth is refused to its sender alone, and
+// once the earlier ones leave the window it goes through again
+// Case and surrounding whitespace don't make a message different, and a
+// room without the setting takes every copy
+func TestDuplicateLimit(t *testing.T) {
+	messages := newMemoryMessages()
+	hub := NewHub(store.Storage{
+		Messages: messages,
+		Rooms:    roomSettings{limited: map[int64]bool{1: true}},
+		Receipts: &memoryReceipts{},
+	}, 1)
+	hub.SetRoomStatsInterval(0)
+	go hub.Run()
+
+	sender := newTestClient(hub, 1, 1, 64)
+	reader := newTestClient(hub, 2, 1, 64)
+	elsewhere := newTestClient(hub, 1, 2, 64)
+	for _, client := range []*Client{sender, reader, elsewhere} {
+		hub.register(client)
+	}
+	send := func(roomID int64, content string) {
+		client := sender
+		if roomID == 2 {
+			client = elsewhere
+		}
+		hub.broadcast(&Message{Message: wire.Message{RoomID: roomID, UserID: 1, Type: "message", Content: content}, sender: client})
+		hub.shards[0].do(func() {})
+	}
+	codes := func(client *Client) (got []string) {
+		for len(client.send) > 0 {
+			var frame Message
+			if err := json.Unmarshal(<-client.send, &frame); err != nil {
+				t.Fatal(err)
+			}
+			switch frame.Type {
+			case "message":
+				got = append(got, frame.Content)
+			case "error":
+				got = append(got, frame.Code)
+			}
+		}
+		return got
+	}
+
+	for _, content := range []string{"buy now", "Buy Now", " BUY NOW ", "buy now"} {
+		send(1, content)
+	}
+	send(1, "something else")
+	if saved := len(messages.saved(1)); saved != 4 {
+		t.Errorf("room 1 saved %d messages, want 3 copies and the other one", saved)
+	}
+	if got, want := codes(sender), []string{"buy now", "Buy Now", " BUY NOW ", "duplicate_message", "something else"}; !slices.Equal(got, want) {
+		t.Errorf("the sender got %q, want %q", got, want)
+	}
+	if got := codes(reader); len(got) != 4 {
+		t.Errorf("the room got %q, want only the saved messages", got)
+	}
+
+	for i := 0; i < 5; i++ {
+		send(2, "buy now")
+	}
+	if saved := len(messages.saved(2)); saved != 5 {
+		t.Errorf("the room without the limit saved %d copies, want all 5", saved)
+	}
+
+	// The window slides: once the copies are older than it they stop counting
+	messages.age(defaultDuplicateWindow - time.Second)
+	send(1, "buy now")
+	if got := codes(sender); len(got) != 1 || got[0] != "duplicate_message" {
+		t.Errorf("inside the window the sender got %q, want a refusal", got)
+	}
+	messages.age(2 * time.Second)
+	send(1, "buy now")
+	if got := codes(sender); len(got) != 1 || got[0] != "buy now" {
+		t.Errorf("after the window the sender got %q, want the message", got)
+	}
+}
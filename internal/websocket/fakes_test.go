@@ -0,0 +1,309 @@
+package websocket
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/schedule"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// memoryMessages is an in-memory message store for the hub tests
+// It implements what the hub uses; the other methods return
+// errMemoryUnsupported
+type memoryMessages struct {
+	mu     sync.Mutex
+	nextID int64
+	rooms  map[int64][]*store.Message
+}
+
+func newMemoryMessages() *memoryMessages {
+	return &memoryMessages{rooms: make(map[int64][]*store.Message)}
+}
+
+func (s *memoryMessages) Create(_ context.Context, m *store.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	m.ID = s.nextID
+	m.CreatedAt = time.Now()
+	saved := *m
+	s.rooms[m.RoomID] = append(s.rooms[m.RoomID], &saved)
+	return nil
+}
+
+// saved returns copies of a room's saved messages, oldest first
+func (s *memoryMessages) saved(roomID int64) []*store.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []*store.Message
+	for _, m := range s.rooms[roomID] {
+		copied := *m
+		messages = append(messages, &copied)
+	}
+	return messages
+}
+
+// age moves every saved message back by d, as if it had been sent earlier
+func (s *memoryMessages) age(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, messages := range s.rooms {
+		for _, m := range messages {
+			m.CreatedAt = m.CreatedAt.Add(-d)
+		}
+	}
+}
+
+// CountRecentIdentical counts the user's saved messages in the room with
+// the content hash, sent within the window
+func (s *memoryMessages) CountRecentIdentical(_ context.Context, roomID, userID int64, contentHash string, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, m := range s.rooms[roomID] {
+		if m.UserID == userID && m.ContentHash == contentHash && time.Since(m.CreatedAt) < window {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// errMemoryUnsupported is returned by the fake stores' methods the tests don't use
+var errMemoryUnsupported = errors.New("not supported by the in-memory message store")
+
+func (s *memoryMessages) GetRoomMessages(context.Context, int64, int) ([]*store.Message, error) {
+	return nil, errMemoryUnsupported
+}
+
+// GetMessagesBefore returns copies of the room's last limit messages before
+// beforeID, oldest first
+func (s *memoryMessages) GetMessagesBefore(_ context.Context, roomID, beforeID int64, limit int) ([]*store.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []*store.Message
+	for _, m := range s.rooms[roomID] {
+		if m.ID < beforeID {
+			copied := *m
+			messages = append(messages, &copied)
+		}
+	}
+	return messages[max(len(messages)-limit, 0):], nil
+}
+
+func (s *memoryMessages) GetMessagesSince(context.Context, int64, time.Time) ([]*store.Message, error) {
+	return nil, errMemoryUnsupported
+}
+
+func (s *memoryMessages) GetByID(context.Context, int64) (*store.Message, error) {
+	return nil, errMemoryUnsupported
+}
+
+func (s *memoryMessages) GetMessagesAround(context.Context, int64, int64, int, int) (*store.MessageWindow, error) {
+	return nil, errMemoryUnsupported
+}
+
+func (s *memoryMessages) FirstMessageAt(context.Context, int64, time.Time) (int64, error) {
+	return 0, errMemoryUnsupported
+}
+
+func (s *memoryMessages) Histogram(context.Context, int64, string, time.Time, time.Time) ([]*store.ActivityBucket, error) {
+	return nil, errMemoryUnsupported
+}
+
+func (s *memoryMessages) PurgeRoomExpired(context.Context, int64, time.Duration, int) (int64, error) {
+	return 0, errMemoryUnsupported
+}
+
+func (s *memoryMessages) Search(context.Context, int64, string, int) ([]*store.Message, error) {
+	return nil, errMemoryUnsupported
+}
+
+func (s *memoryMessages) CountInRoom(context.Context, int64) (int, error) {
+	return 0, errMemoryUnsupported
+}
+
+// discardMessages is a message store that accepts every message and keeps
+// none
+type discardMessages struct{}
+
+func (discardMessages) Create(context.Context, *store.Message) error {
+	return nil
+}
+
+func (discardMessages) CountRecentIdentical(context.Context, int64, int64, string, time.Duration) (int, error) {
+	return 0, nil
+}
+
+func (discardMessages) GetRoomMessages(context.Context, int64, int) ([]*store.Message, error) {
+	return nil, errMemoryUnsupported
+}
+
+func (discardMessages) GetMessagesBefore(context.Context, int64, int64, int) ([]*store.Message, error) {
+	return nil, errMemoryUnsupported
+}
+
+func (discardMessages) GetMessagesSince(context.Context, int64, time.Time) ([]*store.Message, error) {
+	return nil, errMemoryUnsupported
+}
+
+func (discardMessages) GetByID(context.Context, int64) (*store.Message, error) {
+	return nil, errMemoryUnsupported
+}
+
+func (discardMessages) GetMessagesAround(context.Context, int64, int64, int, int) (*store.MessageWindow, error) {
+	return nil, errMemoryUnsupported
+}
+
+func (discardMessages) FirstMessageAt(context.Context, int64, time.Time) (int64, error) {
+	return 0, errMemoryUnsupported
+}
+
+func (discardMessages) Histogram(context.Context, int64, string, time.Time, time.Time) ([]*store.ActivityBucket, error) {
+	return nil, errMemoryUnsupported
+}
+
+func (discardMessages) PurgeRoomExpired(context.Context, int64, time.Duration, int) (int64, error) {
+	return 0, errMemoryUnsupported
+}
+
+func (discardMessages) Search(context.Context, int64, string, int) ([]*store.Message, error) {
+	return nil, errMemoryUnsupported
+}
+
+func (discardMessages) CountInRoom(context.Context, int64) (int, error) {
+	return 0, errMemoryUnsupported
+}
+
+// memoryReceipts records the delivery batches the hub writes
+type memoryReceipts struct {
+	mu      sync.Mutex
+	batches [][]store.DeliveryMark
+}
+
+func (s *memoryReceipts) MarkDelivered(_ context.Context, marks []store.DeliveryMark) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, marks)
+	return nil
+}
+
+// written returns the batches written so far
+func (s *memoryReceipts) written() [][]store.DeliveryMark {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]store.DeliveryMark(nil), s.batches...)
+}
+
+func (s *memoryReceipts) MarkDeliveredLatest(context.Context, int64, int64) error {
+	return errMemoryUnsupported
+}
+
+func (s *memoryReceipts) GetReceipts(context.Context, int64, int64) (*store.MessageReceipts, error) {
+	return nil, errMemoryUnsupported
+}
+
+// roomSettings answers the per-room settings the hub asks about: the
+// content filter is on unless a room is listed in unfiltered, the
+// duplicate limit is off unless a room is listed in limited, quiet
+// hours are those in quiet, in rooms created by user 1, and the rooms in
+// announcements only let admins post
+// The hub uses no other room method, so the embedded store is left nil
+type roomSettings struct {
+	*store.RoomStore
+	unfiltered    map[int64]bool
+	limited       map[int64]bool
+	quiet         map[int64]*schedule.Window
+	announcements map[int64]bool
+}
+
+func (s roomSettings) IsContentFilterEnabled(_ context.Context, roomID int64) (bool, error) {
+	return !s.unfiltered[roomID], nil
+}
+
+func (s roomSettings) IsDuplicateLimitEnabled(_ context.Context, roomID int64) (bool, error) {
+	return s.limited[roomID], nil
+}
+
+func (s roomSettings) GetQuietHours(_ context.Context, roomID int64) (*schedule.Window, int64, error) {
+	return s.quiet[roomID], 1, nil
+}
+
+func (s roomSettings) GetPostPolicy(_ context.Context, roomID int64) (string, error) {
+	if s.announcements[roomID] {
+		return store.PostPolicyAdminsOnly, nil
+	}
+	return store.PostPolicyEveryone, nil
+}
+
+func (s roomSettings) GetByID(_ context.Context, roomID int64) (*store.Room, error) {
+	return &store.Room{ID: roomID, Name: fmt.Sprintf("room%d", roomID), CreatedBy: 1}, nil
+}
+
+// roomAdmins answers whether a user is a room admin: those listed in admins
+// are, in every room
+type roomAdmins struct {
+	*store.RoomMemberStore
+	admins map[int64]bool
+}
+
+func (s roomAdmins) IsRoomAdmin(_ context.Context, _, userID int64) (bool, error) {
+	return s.admins[userID], nil
+}
+
+// roomMembers answers membership checks: the users listed in members are
+// in every room
+type roomMembers struct {
+	*store.RoomMemberStore
+	members map[int64]bool
+}
+
+func (s roomMembers) IsUserInRoom(_ context.Context, _, userID int64) (bool, error) {
+	return s.members[userID], nil
+}
+
+// memoryModerationHooks keeps rooms' moderation hooks in memory
+type memoryModerationHooks struct {
+	mu    sync.Mutex
+	hooks map[int64]*store.ModerationHook
+}
+
+func (s *memoryModerationHooks) Get(_ context.Context, roomID int64) (*store.ModerationHook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hook, ok := s.hooks[roomID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *hook
+	return &copied, nil
+}
+
+func (s *memoryModerationHooks) Save(_ context.Context, hook *store.ModerationHook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := *hook
+	s.hooks[hook.RoomID] = &copied
+	return nil
+}
+
+func (s *memoryModerationHooks) Disable(_ context.Context, roomID int64, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hook, ok := s.hooks[roomID]; ok {
+		hook.Enabled = false
+		hook.DisabledReason = reason
+	}
+	return nil
+}
+
+func (s *memoryModerationHooks) Delete(_ context.Context, roomID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.hooks, roomID)
+	return nil
+}
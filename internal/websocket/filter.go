@@ -0,0 +1,99 @@
+package websocket
+
+import (
+	"fmt"
+	"strings"
+)
+
+// filterableEvents lists the frame types a client may subscribe to
+// Error frames and acks are not listed: they are addressed to a single client
+// and always delivered, whatever its filter says
+var filterableEvents = map[string]bool{
+	"message":              true,
+	"join":                 true,
+	"leave":                true,
+	"join_request":         true,
+	"join_approved":        true,
+	"join_rejected":        true,
+	"member_added":         true,
+	"member_removed":       true,
+	"pin_added":            true,
+	"pin_removed":          true,
+	"pin_order_changed":    true,
+	"delivered":            true,
+	"room_stats":           true,
+	"attachment_thumbnail": true,
+	"room_updated":         true,
+	"invite":               true,
+	"invite_accepted":      true,
+	"invite_declined":      true,
+}
+
+// eventFilter is the set of frame types a client wants to receive
+// An empty filter means "everything", which is also the default
+type eventFilter map[string]bool
+
+// allows reports whether a frame of the given type passes the filter
+func (f eventFilter) allows(eventType string) bool {
+	return len(f) == 0 || f[eventType]
+}
+
+// newEventFilter builds a filter from event names
+// Unknown names make the whole filter invalid, so a typo can't silently
+// hide events the client meant to receive
+func newEventFilter(events []string) (eventFilter, error) {
+	filter := make(eventFilter, len(events))
+	var unknown []string
+	for _, event := range events {
+		event = strings.TrimSpace(event)
+		if event == "" {
+			continue
+		}
+		if !filterableEvents[event] {
+			unknown = append(unknown, event)
+			continue
+		}
+		filter[event] = true
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown events: %s", strings.Join(unknown, ", "))
+	}
+	return filter, nil
+}
+
+// SetEventFilter sets the events the client receives from the moment it connects
+// It must be called before Start; events usually come from the ?events= query parameter
+// An invalid filter is reported to the client in an error frame once it has
+// started, and the client then receives everything
+func (c *Client) SetEventFilter(events []string) {
+	c.initialEvents = events
+}
+
+// join registers the client with the filter given to SetEventFilter
+// A valid filter is in place before registration, so even the client's own
+// join announcement goes through it; an invalid one is reported once the
+// client is registered, so the error frame has somewhere to go
+func (c *Client) join() {
+	filter, err := newEventFilter(c.initialEvents)
+	if err == nil {
+		c.filter = filter
+	}
+	if c.sendHello {
+		c.queueHello()
+	}
+	c.hub.register(c)
+	if err != nil {
+		c.sendError("unknown_event", err.Error())
+	}
+}
+
+// changeFilter handles a {"type":"set_filter","events":[...]} control frame
+// An invalid filter is rejected and the previous one stays in place
+func (c *Client) changeFilter(events []string) {
+	filter, err := newEventFilter(events)
+	if err != nil {
+		c.sendError("unknown_event", err.Error())
+		return
+	}
+	c.hub.setFilter(c, filter)
+}
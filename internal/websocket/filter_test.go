@@ -0,0 +1,144 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testutil"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// receivedFrame is what a test client saw: a frame's type and content
+type receivedFrame struct {
+	Type, Content string
+}
+
+// framesUntilContent reads a client's frames until one with the given
+// content arrives and returns them all, that one last
+// The test fails if none comes within a few seconds
+func framesUntilContent(t *testing.T, client *Client, content string) []receivedFrame {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	var frames []receivedFrame
+	for {
+		select {
+		case frame := <-client.send:
+			var message Message
+			if err := json.Unmarshal(frame, &message); err != nil {
+				t.Fatal(err)
+			}
+			frames = append(frames, receivedFrame{message.Type, message.Content})
+			if message.Content == content {
+				return frames
+			}
+		case <-timeout:
+			t.Fatalf("user %d got %v but never %q", client.userID, frames, content)
+		}
+	}
+}
+
+// sameFrames reports whether two frame lists hold the same frames, in any
+// order
+func sameFrames(got, want []receivedFrame) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	counts := make(map[receivedFrame]int)
+	for _, frame := range got {
+		counts[frame]++
+	}
+	for _, frame := range want {
+		counts[frame]--
+		if counts[frame] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// TestEventFilters puts two clients with different filters in one room and
+// checks exactly which frames each gets: a filter applies from the client's
+// own join on, frames addressed to the client always get through, and live
+// changes are acked, with an invalid one keeping the old filter
+func TestEventFilters(t *testing.T) {
+	hub := newTestHub(1)
+	hub.SetPresenceGrace(0)
+	go hub.Run()
+	s := hub.shards[0]
+
+	// left reports whether a user's leave has been announced
+	left := func(userID int64) bool {
+		var present bool
+		s.do(func() { _, present = s.presence[1][userID] })
+		return !present
+	}
+
+	// user1 wants chat only, user2 chat and arrivals
+	chatOnly := newTestClient(hub, 1, 1, 64)
+	chatOnly.SetEventFilter([]string{"message"})
+	chatOnly.join()
+	arrivals := newTestClient(hub, 2, 1, 64)
+	arrivals.SetEventFilter([]string{" message", "join "})
+	arrivals.join()
+
+	// user3 comes in with no filter, says hi and leaves; the closing message
+	// goes through the same queue as hi, after the leave has been announced,
+	// so every earlier frame has been delivered once it arrives
+	other := newTestClient(hub, 3, 1, 64)
+	other.join()
+	hub.broadcast(&Message{Message: wire.Message{RoomID: 1, UserID: 3, Username: "user3", Content: "hi", Type: "message"}})
+	hub.unregister(other)
+	if !testutil.WaitFor(time.Second, func() bool { return left(3) }) {
+		t.Fatal("user 3's leave was never announced")
+	}
+	hub.sendToClient(chatOnly, &Message{Message: wire.Message{RoomID: 1, Content: "nope", Type: "error", Code: "test"}})
+	hub.broadcast(&Message{Message: wire.Message{RoomID: 1, UserID: 4, Username: "user4", Content: "end", Type: "message"}})
+
+	for _, tc := range []struct {
+		client *Client
+		want   []receivedFrame
+	}{
+		{chatOnly, []receivedFrame{{"message", "hi"}, {"error", "nope"}, {"message", "end"}}},
+		{arrivals, []receivedFrame{{"join", "user2 joined the room"}, {"join", "user3 joined the room"}, {"message", "hi"}, {"message", "end"}}},
+	} {
+		if got := framesUntilContent(t, tc.client, "end"); !sameFrames(got, tc.want) {
+			t.Errorf("user %d got %v, want %v", tc.client.userID, got, tc.want)
+		}
+	}
+
+	// A live change is acked; a bad one is refused and changes nothing
+	chatOnly.changeFilter([]string{"leave"})
+	framesUntilContent(t, chatOnly, "event filter updated")
+	chatOnly.changeFilter([]string{"leave", "typing"})
+	if frames := framesUntilContent(t, chatOnly, "unknown events: typing"); frames[len(frames)-1].Type != "error" {
+		t.Errorf("an unknown event got %v, want an error frame", frames)
+	}
+	hub.broadcast(&Message{Message: wire.Message{RoomID: 1, UserID: 4, Username: "user4", Content: "filtered", Type: "message"}})
+	hub.unregister(arrivals)
+	if !testutil.WaitFor(time.Second, func() bool { return left(2) }) {
+		t.Fatal("user 2's leave was never announced")
+	}
+	hub.broadcast(&Message{Message: wire.Message{RoomID: 1, UserID: 4, Username: "user4", Content: "done", Type: "leave"}})
+	want := []receivedFrame{{"leave", "user2 left the room"}, {"leave", "done"}}
+	if got := framesUntilContent(t, chatOnly, "done"); !sameFrames(got, want) {
+		t.Errorf("after changing its filter user 1 got %v, want %v", got, want)
+	}
+}
+
+// TestInvalidInitialFilter connects with an unknown event: the client is
+// told and gets every event
+func TestInvalidInitialFilter(t *testing.T) {
+	hub := newTestHub(1)
+	go hub.Run()
+
+	client := newTestClient(hub, 1, 1, 64)
+	client.SetEventFilter([]string{"message", "typing"})
+	client.join()
+	hub.broadcast(&Message{Message: wire.Message{RoomID: 1, UserID: 2, Username: "user2", Content: "end", Type: "message"}})
+
+	want := []receivedFrame{{"join", "user1 joined the room"}, {"error", "unknown events: typing"}, {"message", "end"}}
+	if got := framesUntilContent(t, client, "end"); !sameFrames(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/gorilla/websocket"
+)
+
+// TestMain silences the hub's log output; it logs every broadcast, which
+// would drown out the test results
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestHub creates a hub with no database behind it
+// Chat messages are accepted by a store that keeps nothing, so tests and
+// benchmarks can send as many as they like. Room stats and the membership
+// sweep, which need room members, are off
+// The caller starts it with go hub.Run()
+func newTestHub(shards int) *Hub {
+	hub := NewHub(store.Storage{Messages: discardMessages{}, Rooms: roomSettings{}}, shards)
+	hub.SetRoomStatsInterval(0)
+	hub.SetMembershipSweepInterval(0)
+	return hub
+}
+
+// dialTestHub connects userID to roomID on hub over a real WebSocket
+// setup, if given, configures the client before it starts, as the API's
+// handler does with the connection's options
+// The connection is closed when the test ends
+func dialTestHub(t *testing.T, hub *Hub, userID, roomID int64, setup ...func(*Client)) *websocket.Conn {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := NewClient(hub, conn, userID, fmt.Sprintf("user%d", userID), roomID)
+		for _, f := range setup {
+			f(client)
+		}
+		client.Start()
+	}))
+	t.Cleanup(server.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dialing as user %d: %v", userID, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// framesUntil reads a connection's frames until one of the given type
+// arrives and returns them all, that one last
+// The test fails if none comes within a few seconds
+func framesUntil(t *testing.T, conn *websocket.Conn, frameType string) []*Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	var frames []*Message
+	for {
+		var message Message
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("waiting for a %s frame: %v", frameType, err)
+		}
+		frames = append(frames, &message)
+		if message.Type == frameType {
+			return frames
+		}
+	}
+}
+
+// newTestClient creates a client with no connection behind it
+// Whoever reads its send channel stands in for writePump and must call
+// hub.memory.dequeued for every frame, as drainFrames and nextFrame do
+func newTestClient(hub *Hub, userID, roomID int64, buffer int) *Client {
+	return &Client{
+		hub:      hub,
+		send:     make(chan []byte, buffer),
+		userID:   userID,
+		username: fmt.Sprintf("user%d", userID),
+		roomID:   roomID,
+		done:     make(chan struct{}),
+	}
+}
+
+// drainFrames takes every frame off a client's send channel until it's
+// closed, as writePump would, and returns them
+func drainFrames(client *Client) [][]byte {
+	var frames [][]byte
+	for frame := range client.send {
+		client.hub.memory.dequeued(client, len(frame))
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// nextFrame reads a client's frames until one of the given type arrives and
+// returns it decoded, or nil after the timeout
+// Frames of other types (joins, presence) are skipped
+func nextFrame(client *Client, frameType string, timeout time.Duration) *Message {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case frame, ok := <-client.send:
+			if !ok {
+				return nil
+			}
+			client.hub.memory.dequeued(client, len(frame))
+			var message Message
+			if err := json.Unmarshal(frame, &message); err == nil && message.Type == frameType {
+				return &message
+			}
+		case <-timer.C:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"time"
+	"unicode/utf8"
+
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// History over the socket
+//
+// Clients on flaky networks can page back through a room's history on the
+// connection they already have instead of making REST calls:
+//
+//	{"type": "history_request", "room_id": 5, "before_id": 1234, "limit": 50, "req_id": "abc"}
+//
+// The page (oldest first, the messages before before_id; the newest ones
+// without it) comes back in one or more "history_response" frames carrying the
+// same req_id. Large pages are split so no frame exceeds historyFrameBudget;
+// the last frame has "final": true and "has_more" if older messages exist.
+// Failures come back as one "history_error" frame with a code
+//
+// Requests run on their own goroutine, never on a shard loop, and each
+// connection may have maxHistoryInFlight of them running at once
+
+const (
+	// maxHistoryInFlight is how many history requests a connection may have running
+	maxHistoryInFlight = 3
+
+	// defaultHistoryLimit and maxHistoryLimit bound the page size
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+
+	// maxReqIDLength caps the request ID echoed back to the client, in bytes
+	maxReqIDLength = 64
+
+	// historyFrameBudget is the largest a response frame's message list may get
+	// Pages past it are split over several frames
+	historyFrameBudget = 64 * 1024
+
+	// historyTimeout bounds the store query behind one request
+	historyTimeout = 10 * time.Second
+)
+
+// requestHistory validates a history request and starts its worker
+// Called from readPump; it never blocks on the store
+func (c *Client) requestHistory(in inboundFrame) {
+	reqID := in.ReqID
+	if len(reqID) > maxReqIDLength {
+		c.sendHistoryError(capReqID(reqID), "invalid_req_id", "req_id is too long")
+		return
+	}
+	if c.readOnly {
+		c.sendHistoryError(reqID, "guest_read_only", "guests can't request history")
+		return
+	}
+	// A connection belongs to one room, so that's the only history it can page through
+	if in.RoomID != 0 && in.RoomID != c.roomID {
+		c.sendHistoryError(reqID, "not_room_member", "history is only available for this connection's room")
+		return
+	}
+	if in.BeforeID < 0 || in.Limit < 0 || in.Limit > maxHistoryLimit {
+		c.sendHistoryError(reqID, "invalid_history_request", "before_id can't be negative and limit is at most 200")
+		return
+	}
+
+	if c.historyInFlight.Add(1) > maxHistoryInFlight {
+		c.historyInFlight.Add(-1)
+		c.sendHistoryError(reqID, "too_many_history_requests", "wait for a history request to finish")
+		return
+	}
+
+	limit := in.Limit
+	if limit == 0 {
+		limit = defaultHistoryLimit
+	}
+	go func() {
+		defer c.historyInFlight.Add(-1)
+		c.serveHistory(reqID, in.BeforeID, limit)
+	}()
+}
+
+// serveHistory loads one page of history and sends it to the client
+// It runs on its own goroutine; the query is cancelled if the client leaves
+func (c *Client) serveHistory(reqID string, beforeID int64, limit int) {
+	ctx, cancel := context.WithTimeout(c.hub.ctx, historyTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// Membership was checked when the connection opened, but the user may
+	// have left the room on another device since
+	isMember, err := c.hub.store.RoomMembers.IsUserInRoom(ctx, c.roomID, c.userID)
+	if err != nil {
+		log.Printf("History membership check failed for user %d in room %d: %v", c.userID, c.roomID, err)
+		c.sendHistoryError(reqID, "history_failed", "failed to load history")
+		return
+	}
+	if !isMember {
+		c.sendHistoryError(reqID, "not_room_member", "you are no longer a member of this room")
+		return
+	}
+
+	// One extra row tells whether there's an older page
+	before := beforeID
+	if before == 0 {
+		before = math.MaxInt64
+	}
+	stored, err := c.hub.store.Messages.GetMessagesBefore(ctx, c.roomID, before, limit+1)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("History query failed for room %d: %v", c.roomID, err)
+			c.sendHistoryError(reqID, "history_failed", "failed to load history")
+		}
+		return
+	}
+	hasMore := len(stored) > limit
+	if hasMore {
+		// Oldest first, so the extra row is the first one
+		stored = stored[1:]
+	}
+
+	c.hub.RenderSystemMessages(ctx, stored)
+	messages := make([]*wire.Message, len(stored))
+	for i, m := range stored {
+		messages[i] = &NewChatMessage(m).Message
+	}
+	for _, frame := range splitHistory(c.roomID, reqID, messages, hasMore, historyFrameBudget) {
+		c.hub.sendToClient(c, frame)
+	}
+}
+
+// splitHistory packs a page into "history_response" frames whose message
+// lists stay under budget bytes of JSON
+// A single message larger than the budget still gets a frame of its own
+// There's always at least one frame, and only the last is final
+func splitHistory(roomID int64, reqID string, messages []*wire.Message, hasMore bool, budget int) []*Message {
+	frames := make([]*Message, 0, 1)
+	current := make([]*wire.Message, 0)
+	size := 0
+	for _, message := range messages {
+		encoded, err := json.Marshal(message)
+		if err != nil {
+			log.Printf("Failed to marshal history message %d: %v", message.ID, err)
+			continue
+		}
+		if len(current) > 0 && size+len(encoded) > budget {
+			frames = append(frames, &Message{Message: wire.Message{RoomID: roomID, Type: "history_response", ReqID: reqID, Messages: current}})
+			current = make([]*wire.Message, 0)
+			size = 0
+		}
+		current = append(current, message)
+		size += len(encoded) + 1 // The comma between array elements
+	}
+	frames = append(frames, &Message{
+		Message: wire.Message{
+			RoomID:   roomID,
+			Type:     "history_response",
+			ReqID:    reqID,
+			Messages: current,
+			HasMore:  hasMore,
+			Final:    true,
+		},
+	})
+	return frames
+}
+
+// sendHistoryError replies to a history request with a "history_error" frame
+func (c *Client) sendHistoryError(reqID, code, message string) {
+	c.hub.sendToClient(c, &Message{
+		Message: wire.Message{
+			RoomID:  c.roomID,
+			Type:    "history_error",
+			Code:    code,
+			Content: message,
+			ReqID:   reqID,
+		},
+	})
+}
+
+// capReqID cuts a request ID to maxReqIDLength bytes without splitting a character
+func capReqID(reqID string) string {
+	if len(reqID) <= maxReqIDLength {
+		return reqID
+	}
+	cut := maxReqIDLength
+	for cut > 0 && !utf8.RuneStart(reqID[cut]) {
+		cut--
+	}
+	return reqID[:cut]
+}
@@ -0,0 +1,246 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+	"github.com/drazan344/go-chat/pkg/wire"
+	"github.com/gorilla/websocket"
+)
+
+// historyRequest is a "history_request" frame as a client sends it
+type historyRequest struct {
+	Type     string `json:"type"`
+	RoomID   int64  `json:"room_id,omitempty"`
+	BeforeID int64  `json:"before_id,omitempty"`
+	Limit    int    `json:"limit,omitempty"`
+	ReqID    string `json:"req_id"`
+}
+
+// requestHistory sends a history request and returns the frames answering
+// it: the response's frames up to the final one, or the error
+func requestHistory(t *testing.T, conn *websocket.Conn, req historyRequest) []*Message {
+	t.Helper()
+	req.Type = "history_request"
+	if err := conn.WriteJSON(req); err != nil {
+		t.Fatal(err)
+	}
+	// The only reply, under whatever request ID was echoed
+	for _, frames := range historyReplies(t, conn, 1) {
+		return frames
+	}
+	return nil
+}
+
+// historyReplies reads frames until n requests have been answered and
+// returns each request's frames by request ID
+// writePump may batch queued frames into one WebSocket message, one per
+// line, so every frame in each message is decoded
+func historyReplies(t *testing.T, conn *websocket.Conn, n int) map[string][]*Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	replies := make(map[string][]*Message)
+	for answered := 0; answered < n; {
+		_, r, err := conn.NextReader()
+		if err != nil {
+			t.Fatalf("waiting for history: %v", err)
+		}
+		decoder := json.NewDecoder(r)
+		for decoder.More() {
+			var frame Message
+			if err := decoder.Decode(&frame); err != nil {
+				t.Fatal(err)
+			}
+			if frame.Type != "history_response" && frame.Type != "history_error" {
+				continue
+			}
+			replies[frame.ReqID] = append(replies[frame.ReqID], &frame)
+			if frame.Type == "history_error" || frame.Final {
+				answered++
+			}
+		}
+	}
+	return replies
+}
+
+// newHistoryHub creates a running hub whose room 1 has messages 1 to 120,
+// with ada (1) a member and grace (2) not
+func newHistoryHub(t *testing.T, messages store.Storage) *Hub {
+	t.Helper()
+	if messages.Messages == nil {
+		memory := newMemoryMessages()
+		for i := 1; i <= 120; i++ {
+			memory.Create(context.Background(), &store.Message{RoomID: 1, UserID: 1, Username: "ada", Content: fmt.Sprintf("message %d", i)})
+		}
+		messages.Messages = memory
+	}
+	messages.Rooms = roomSettings{}
+	messages.RoomMembers = roomMembers{members: map[int64]bool{1: true}}
+	hub := NewHub(messages, 1)
+	hub.SetRoomStatsInterval(0)
+	go hub.Run()
+	return hub
+}
+
+// TestHistoryOverSocket pages back through room 1 on the socket: the newest
+// page first, then the rest, each oldest first with has_more on the final frame
+// Requests for another room, over the limit, from a user who left, or with
+// a request ID too long to echo get a history_error
+func TestHistoryOverSocket(t *testing.T) {
+	hub := newHistoryHub(t, store.Storage{})
+	conn := dialTestHub(t, hub, 1, 1)
+	framesUntil(t, conn, "join")
+
+	for _, tc := range []struct {
+		req     historyRequest
+		first   int64
+		last    int64
+		hasMore bool
+	}{
+		{historyRequest{Limit: 50, ReqID: "newest"}, 71, 120, true},
+		{historyRequest{RoomID: 1, BeforeID: 71, Limit: 100, ReqID: "rest"}, 1, 70, false},
+	} {
+		frames := requestHistory(t, conn, tc.req)
+		if len(frames) != 1 || frames[0].Type != "history_response" {
+			t.Fatalf("%s: got %d frames, want a single history_response", tc.req.ReqID, len(frames))
+		}
+		page := frames[0]
+		if n := len(page.Messages); n != int(tc.last-tc.first+1) || page.Messages[0].ID != tc.first || page.Messages[n-1].ID != tc.last {
+			t.Errorf("%s: got %d messages, want %d to %d", tc.req.ReqID, n, tc.first, tc.last)
+		}
+		if page.HasMore != tc.hasMore || page.Messages[0].Type != "message" || page.Messages[0].CreatedAt == nil {
+			t.Errorf("%s: got has_more %v and first message %+v, want has_more %v and a full chat message", tc.req.ReqID, page.HasMore, page.Messages[0], tc.hasMore)
+		}
+	}
+
+	longID := strings.Repeat("é", maxReqIDLength)
+	for _, tc := range []struct {
+		req  historyRequest
+		echo string
+		code string
+	}{
+		{historyRequest{RoomID: 2, ReqID: "other-room"}, "other-room", "not_room_member"},
+		{historyRequest{Limit: maxHistoryLimit + 1, ReqID: "too-many"}, "too-many", "invalid_history_request"},
+		{historyRequest{ReqID: longID}, longID[:maxReqIDLength], "invalid_req_id"},
+	} {
+		frames := requestHistory(t, conn, tc.req)
+		if len(frames) == 0 {
+			t.Fatalf("%q: no reply was echoed back to %q", tc.code, tc.echo)
+		}
+		if frames[0].Type != "history_error" || frames[0].Code != tc.code {
+			t.Errorf("got %s %q, want history_error %q", frames[0].Type, frames[0].Code, tc.code)
+		}
+	}
+
+	// grace's connection outlived their membership
+	left := dialTestHub(t, hub, 2, 1)
+	framesUntil(t, left, "join")
+	if frames := requestHistory(t, left, historyRequest{ReqID: "left"}); len(frames) != 1 || frames[0].Code != "not_room_member" {
+		t.Errorf("a user no longer in the room got %+v, want a not_room_member error", frames)
+	}
+}
+
+// blockedHistory is a message store whose history queries wait until release is closed
+type blockedHistory struct {
+	*memoryMessages
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s blockedHistory) GetMessagesBefore(ctx context.Context, roomID, beforeID int64, limit int) ([]*store.Message, error) {
+	s.started <- struct{}{}
+	<-s.release
+	return s.memoryMessages.GetMessagesBefore(ctx, roomID, beforeID, limit)
+}
+
+// TestHistoryInFlightCap sends a fourth request while three are still
+// running: it's refused, the three are answered, and afterwards the
+// connection may ask again
+func TestHistoryInFlightCap(t *testing.T) {
+	messages := blockedHistory{memoryMessages: newMemoryMessages(), started: make(chan struct{}, maxHistoryInFlight), release: make(chan struct{})}
+	hub := newHistoryHub(t, store.Storage{Messages: messages})
+	conn := dialTestHub(t, hub, 1, 1)
+	framesUntil(t, conn, "join")
+
+	for i := 1; i <= maxHistoryInFlight; i++ {
+		conn.WriteJSON(historyRequest{Type: "history_request", ReqID: fmt.Sprint(i)})
+	}
+	for i := 0; i < maxHistoryInFlight; i++ {
+		select {
+		case <-messages.started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d history queries started", i)
+		}
+	}
+	frames := requestHistory(t, conn, historyRequest{ReqID: "4"})
+	if len(frames) != 1 || frames[0].Code != "too_many_history_requests" {
+		t.Fatalf("the fourth request got %+v, want too_many_history_requests", frames)
+	}
+
+	close(messages.release)
+	replies := historyReplies(t, conn, maxHistoryInFlight)
+	for i := 1; i <= maxHistoryInFlight; i++ {
+		if frames := replies[fmt.Sprint(i)]; len(frames) != 1 || frames[0].Type != "history_response" {
+			t.Errorf("request %d got %+v, want its response", i, frames)
+		}
+	}
+	if !testutil.WaitFor(time.Second, func() bool {
+		var in int32
+		hub.shardFor(1).do(func() {
+			for client := range hub.shardFor(1).rooms[1] {
+				in += client.historyInFlight.Load()
+			}
+		})
+		return in == 0
+	}) {
+		t.Fatal("finished requests still count as in flight")
+	}
+	if frames := requestHistory(t, conn, historyRequest{ReqID: "again"}); len(frames) != 1 || frames[0].Type != "history_response" {
+		t.Errorf("asking again afterwards got %+v, want a response", frames)
+	}
+}
+
+// TestSplitHistory packs 1KB messages into frames of at most 4KB of
+// messages: every message arrives once and in order, only the last frame
+// is final and says whether there's more, and a message over the budget
+// gets a frame of its own
+func TestSplitHistory(t *testing.T) {
+	messages := make([]*wire.Message, 0)
+	for i := int64(1); i <= 10; i++ {
+		messages = append(messages, &wire.Message{ID: i, Type: "message", Content: strings.Repeat("x", 1000)})
+	}
+	messages[6].Content = strings.Repeat("y", 5000)
+
+	frames := splitHistory(1, "abc", messages, true, 4096)
+	var next int64 = 1
+	for i, frame := range frames {
+		final := i == len(frames)-1
+		if frame.Type != "history_response" || frame.ReqID != "abc" || frame.Final != final || frame.HasMore != final {
+			t.Errorf("frame %d is %s %q final %v has_more %v", i, frame.Type, frame.ReqID, frame.Final, frame.HasMore)
+		}
+		encoded, _ := json.Marshal(frame.Messages)
+		if len(frame.Messages) > 1 && len(encoded) > 4096 {
+			t.Errorf("frame %d carries %d bytes of messages", i, len(encoded))
+		}
+		for _, m := range frame.Messages {
+			if m.ID != next {
+				t.Fatalf("frame %d has message %d, want %d", i, m.ID, next)
+			}
+			next++
+		}
+	}
+	if next != 11 {
+		t.Errorf("the frames carry %d messages, want 10", next-1)
+	}
+
+	if frames := splitHistory(1, "empty", nil, false, 4096); len(frames) != 1 || !frames[0].Final || len(frames[0].Messages) != 0 {
+		t.Errorf("an empty page got %+v, want one final frame", frames)
+	}
+}
@@ -0,0 +1,135 @@
+package websocket
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Hook event types
+const (
+	EventMessagePersisted = "message.persisted" // A chat message was saved to the database
+	EventClientJoined     = "client.joined"     // A member connection registered in a room
+	EventClientLeft       = "client.left"       // A member connection left a room
+	EventRoomEmptied      = "room.emptied"      // The last connection in a room went away
+)
+
+const (
+	// defaultHookWorkers is how many goroutines run hook callbacks
+	defaultHookWorkers = 4
+
+	// defaultHookQueueSize bounds the callbacks waiting for a worker
+	defaultHookQueueSize = 1024
+)
+
+// HookEvent describes something that happened in the hub
+// It's passed to hook callbacks and is also the payload sent by webhooks
+type HookEvent struct {
+	Type       string    `json:"event"`
+	RoomID     int64     `json:"room_id"`
+	UserID     int64     `json:"user_id,omitempty"`
+	Username   string    `json:"username,omitempty"`
+	Message    *Message  `json:"message,omitempty"` // Set for EventMessagePersisted
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// HookFunc is a callback registered for hub events
+type HookFunc func(HookEvent)
+
+// hookCall is one callback waiting to run
+type hookCall struct {
+	fn    HookFunc
+	event HookEvent
+}
+
+// HookRegistry lets integrations react to hub events without touching the hub
+// Callbacks never run on a shard loop: events are queued and handled by a
+// small worker pool, so a slow or panicking hook can't delay message delivery
+// When the queue is full, events are dropped and counted rather than blocking
+type HookRegistry struct {
+	mu    sync.RWMutex
+	hooks map[string][]HookFunc
+
+	queue   chan hookCall
+	dropped atomic.Int64
+}
+
+// newHookRegistry creates a registry and starts its worker pool
+func newHookRegistry(workers, queueSize int) *HookRegistry {
+	r := &HookRegistry{
+		hooks: make(map[string][]HookFunc),
+		queue: make(chan hookCall, queueSize),
+	}
+	for i := 0; i < workers; i++ {
+		go r.worker()
+	}
+	return r
+}
+
+// OnMessagePersisted registers fn for chat messages saved to the database
+func (r *HookRegistry) OnMessagePersisted(fn HookFunc) { r.on(EventMessagePersisted, fn) }
+
+// OnClientJoined registers fn for member connections joining a room
+func (r *HookRegistry) OnClientJoined(fn HookFunc) { r.on(EventClientJoined, fn) }
+
+// OnClientLeft registers fn for member connections leaving a room
+func (r *HookRegistry) OnClientLeft(fn HookFunc) { r.on(EventClientLeft, fn) }
+
+// OnRoomEmptied registers fn for rooms losing their last connection
+func (r *HookRegistry) OnRoomEmptied(fn HookFunc) { r.on(EventRoomEmptied, fn) }
+
+// Dropped returns how many callbacks were skipped because the queue was full
+func (r *HookRegistry) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+// on registers fn for an event type
+func (r *HookRegistry) on(eventType string, fn HookFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks[eventType] = append(r.hooks[eventType], fn)
+}
+
+// emit queues every callback registered for the event
+// Called from shard loops, so it must never block
+func (r *HookRegistry) emit(event HookEvent) {
+	r.mu.RLock()
+	hooks := r.hooks[event.Type]
+	r.mu.RUnlock()
+
+	if len(hooks) == 0 {
+		return
+	}
+
+	event.OccurredAt = time.Now().UTC()
+	for _, fn := range hooks {
+		select {
+		case r.queue <- hookCall{fn: fn, event: event}:
+		default:
+			// Log the first drop and then every 1000th, to avoid flooding the log
+			if n := r.dropped.Add(1); n == 1 || n%1000 == 0 {
+				log.Printf("Hook queue full, dropped %d hook calls so far", n)
+			}
+		}
+	}
+}
+
+// worker runs queued callbacks until the process exits
+func (r *HookRegistry) worker() {
+	for call := range r.queue {
+		r.run(call)
+	}
+}
+
+// run executes one callback, recovering from panics so a broken hook
+// doesn't take the worker (or the server) down
+func (r *HookRegistry) run(call hookCall) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("Hook for %s panicked: %v\n%s", call.event.Type, err, debug.Stack())
+		}
+	}()
+	call.fn(call.event)
+}
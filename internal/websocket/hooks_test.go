@@ -0,0 +1,146 @@
+package websocket
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// TestSlowHookDoesNotDelayBroadcasts blocks every hook worker inside a
+// message hook: the room must still get each message straight away, and
+// the hook must see all of them once it's unblocked
+func TestSlowHookDoesNotDelayBroadcasts(t *testing.T) {
+	const messages = 20
+
+	hub := NewHub(store.Storage{Messages: newMemoryMessages(), Rooms: roomSettings{}, Receipts: &memoryReceipts{}}, 1)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var persisted []string
+	hub.Hooks().OnMessagePersisted(func(event HookEvent) {
+		<-release
+		mu.Lock()
+		persisted = append(persisted, event.Message.Content)
+		mu.Unlock()
+	})
+	hub.SetRoomStatsInterval(0)
+	go hub.Run()
+
+	reader := newTestClient(hub, 2, 1, 64)
+	reader.join()
+	start := time.Now()
+	for i := 0; i < messages; i++ {
+		hub.broadcast(&Message{Message: wire.Message{RoomID: 1, UserID: 1, Username: "user1", Content: fmt.Sprintf("m%d", i), Type: "message"}})
+	}
+	frames := framesUntilContent(t, reader, fmt.Sprintf("m%d", messages-1))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("broadcasting took %v with the hooks blocked", elapsed)
+	}
+	if len(frames) != messages+1 { // And the reader's own join
+		t.Errorf("the room got %d frames, want %d", len(frames), messages+1)
+	}
+
+	close(release)
+	done := testutil.WaitFor(time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(persisted) == messages
+	})
+	if !done {
+		mu.Lock()
+		t.Errorf("the hook saw %d messages, want %d", len(persisted), messages)
+		mu.Unlock()
+	}
+}
+
+// TestHookEvents checks which events a join, a chat message and a leave
+// produce, and what they carry
+func TestHookEvents(t *testing.T) {
+	hub := NewHub(store.Storage{Messages: newMemoryMessages(), Rooms: roomSettings{}, Receipts: &memoryReceipts{}}, 1)
+	events := make(chan HookEvent, 16)
+	record := func(event HookEvent) { events <- event }
+	hub.Hooks().OnClientJoined(record)
+	hub.Hooks().OnMessagePersisted(record)
+	hub.Hooks().OnClientLeft(record)
+	hub.Hooks().OnRoomEmptied(record)
+	hub.SetRoomStatsInterval(0)
+	go hub.Run()
+
+	client := newTestClient(hub, 1, 7, 64)
+	go drainFrames(client)
+	client.join()
+	next := func() HookEvent {
+		t.Helper()
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("no hook event arrived")
+			return HookEvent{}
+		}
+	}
+
+	// Each step waits for its own event, since workers may run them in any order
+	if event := next(); event.Type != EventClientJoined || event.RoomID != 7 || event.UserID != 1 || event.Username != "user1" {
+		t.Errorf("joining produced %+v", event)
+	}
+	hub.broadcast(&Message{Message: wire.Message{RoomID: 7, UserID: 1, Username: "user1", Content: "hi", Type: "message"}})
+	event := next()
+	if event.Type != EventMessagePersisted || event.Message == nil || event.Message.Content != "hi" || event.Message.ID == 0 {
+		t.Errorf("a chat message produced %+v", event)
+	}
+	if event.OccurredAt.IsZero() {
+		t.Error("the event has no time")
+	}
+
+	hub.unregister(client)
+	got := map[string]bool{next().Type: true, next().Type: true}
+	if !got[EventClientLeft] || !got[EventRoomEmptied] {
+		t.Errorf("leaving produced %v, want %s and %s", got, EventClientLeft, EventRoomEmptied)
+	}
+}
+
+// TestHookPanicIsRecovered has a hook panic: the worker must survive and
+// run the next callback
+func TestHookPanicIsRecovered(t *testing.T) {
+	registry := newHookRegistry(1, 8)
+	ran := make(chan string, 1)
+	registry.OnClientJoined(func(event HookEvent) {
+		if event.UserID == 1 {
+			panic("broken integration")
+		}
+		ran <- event.Username
+	})
+
+	registry.emit(HookEvent{Type: EventClientJoined, UserID: 1})
+	registry.emit(HookEvent{Type: EventClientJoined, UserID: 2, Username: "user2"})
+	select {
+	case name := <-ran:
+		if name != "user2" {
+			t.Errorf("the hook ran for %q, want user2", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the worker died with the panicking hook")
+	}
+}
+
+// TestHookQueueOverflow fills the queue: emitting must not block, and
+// every call that doesn't fit is counted
+func TestHookQueueOverflow(t *testing.T) {
+	registry := newHookRegistry(0, 2) // No workers, so nothing leaves the queue
+	registry.OnClientJoined(func(HookEvent) {})
+	registry.OnClientLeft(func(HookEvent) {})
+
+	registry.emit(HookEvent{Type: EventRoomEmptied}) // Nobody listens: not queued, not dropped
+	for i := 0; i < 5; i++ {
+		registry.emit(HookEvent{Type: EventClientJoined})
+	}
+	registry.emit(HookEvent{Type: EventClientLeft})
+	if dropped := registry.Dropped(); dropped != 4 {
+		t.Errorf("Dropped() = %d, want 4", dropped)
+	}
+}
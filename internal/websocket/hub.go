@@ -2,213 +2,576 @@ package websocket
 
 import (
 	"context"
-	"encoding/json"
 	"log"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/drazan344/go-chat/internal/flags"
+	"github.com/drazan344/go-chat/internal/notify"
 	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/pkg/wire"
 )
 
 // Message represents a chat message being sent through WebSocket
 // This is used for both incoming and outgoing messages
+// Its fields on the wire are wire.Message's, which clients decode frames into;
+// the rest is the hub's bookkeeping while the message is on its way
+// Chat messages ("message" frames) carry the same fields as store.Message
+// does over REST; convert between the two with NewChatMessage and
+// StoreMessage rather than copying fields by hand (see wire.go)
 type Message struct {
-	RoomID   int64  `json:"room_id"`
-	UserID   int64  `json:"user_id"`
-	Username string `json:"username"`
-	Content  string `json:"content"`
-	Type     string `json:"type"` // "message", "join", "leave"
+	wire.Message
+
+	// notifyUsers are the users whose copy of a chat message gets Notify
+	notifyUsers map[int64]bool
+
+	// sender is the connection a chat message came from, if any
+	// Used to report a rejected message back to its author
+	sender *Client
+
+	// persisted is set once the message has been saved to the database
+	persisted bool
+
+	// contentHash is taken as the message arrives, before moderation changes
+	// the content (see content.Hash)
+	contentHash string
+
+	// moderation is set once the room's moderation bot has seen the message
+	// (see moderation_hooks.go)
+	moderation *ModerationOutcome
+
+	// event identifies the frame to users' event streams, so a user's
+	// connections give it one user_seq (see user_events.go)
+	event uint64
+}
+
+// directMessage is a frame addressed to a single client rather than a whole room
+// Used for error replies that only the sender should see
+type directMessage struct {
+	client  *Client
+	message *Message
 }
 
 // Hub maintains the set of active clients and broadcasts messages to clients
 // It's the central coordinator for all WebSocket connections
+//
+// Internally the hub is split into shards, each running its own event loop
+// A room always maps to the same shard (roomID modulo shard count), so messages
+// within a room stay ordered while busy rooms don't delay rooms on other shards
 type Hub struct {
-	// Registered clients organized by room ID
-	// map[roomID]map[*Client]bool
-	// The inner map acts as a set (we only care about keys, values are always true)
-	rooms map[int64]map[*Client]bool
+	shards []*shard
+
+	// Callbacks for integrations, run outside the shard loops
+	hooks *HookRegistry
+
+	// Set by Drain; new connections should be refused while true
+	draining atomic.Bool
+
+	// Content filter, also set on every shard; kept here for Moderate
+	filter content.Filter
+
+	// Settings that can change while the hub runs, shared with every shard (see tunables.go)
+	tuning *tunablesPointer
 
-	// Inbound messages from the clients
-	// Messages are sent to this channel from client.readPump()
-	broadcast chan *Message
+	// Rooms' moderation bots, shared with every shard; nil unless SetModerationCaller was called
+	moderation *moderationHooks
 
-	// Register requests from the clients
-	// Sent when a new WebSocket connection is established
-	register chan *Client
+	// Which users have open connections, shared by all shards
+	online *onlineIndex
 
-	// Unregister requests from clients
-	// Sent when a WebSocket connection is closed
-	unregister chan *Client
+	// Users' event streams, shared by all shards (see user_events.go)
+	userEvents *userEvents
+
+	// Rooms' quiet hours, shared by all shards and the REST send path
+	quiet *roomCache[quietEntry]
+
+	// Rooms' languages, for rendering system messages (see languages.go)
+	languages *languageCache
+
+	// Rooms' member counts for room_stats frames, shared by all shards
+	memberCounts *roomCache[int]
+
+	// Users' @room allowance, shared by all shards and the REST send path (see mentions.go)
+	roomMentions *roomMentionLimiter
+
+	// Rooms' post policies, shared by all shards and the REST send path (see post_policy.go)
+	postPolicies *roomCache[string]
+
+	// Bytes queued in send channels, shared by all shards and clients (see memory.go)
+	memory *memoryAccount
+
+	// Answers "invite_response" frames; nil unless SetInviteResponder was called
+	invites InviteResponder
 
 	// Storage layer for persisting messages
 	store store.Storage
+
+	// Parent of the hub's own store calls (persisting, sweeps, hooks), which
+	// are cancelled once it's done (see SetContext)
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// HubStats is a point-in-time summary of the hub's state
+type HubStats struct {
+	Shards  int `json:"shards"`
+	Rooms   int `json:"rooms"`
+	Clients int `json:"clients"`
+
+	// Draining is true after Drain; Clients is then the number of connections left
+	Draining bool `json:"draining"`
+
+	// Online is the sum over rooms of the distinct members connected, as sent
+	// in room_stats frames; RoomStatsPending is how many rooms have a frame due
+	Online           int `json:"online"`
+	RoomStatsPending int `json:"room_stats_pending"`
+
+	// Audit holds the sequence audit counters; nil unless auditing is on
+	Audit *AuditStats `json:"audit,omitempty"`
+
+	// RTT summarizes round-trip times across all connections; nil until one has answered a ping
+	// Per-room figures are in the hub snapshot
+	RTT *RTTStats `json:"rtt,omitempty"`
+
+	// Throttle counts connections held to their bandwidth budget (see throttle.go)
+	// Per-connection counters are in the hub snapshot
+	Throttle ThrottleStats `json:"throttle"`
+
+	// Memory is the bytes queued for clients against the memory limits (see memory.go)
+	Memory MemoryStats `json:"memory"`
+
+	// StaleDisconnects counts the clients disconnected because frames stopped
+	// reaching them (see liveness.go)
+	StaleDisconnects int64 `json:"stale_disconnects"`
 }
 
-// NewHub creates a new Hub instance
+// NewHub creates a new Hub instance with the given number of shards
+// A shard count of zero or less uses GOMAXPROCS, one shard per usable CPU
 // The hub must be started with hub.Run() in a goroutine
-func NewHub(store store.Storage) *Hub {
-	return &Hub{
-		broadcast:  make(chan *Message, 256), // Buffered to prevent blocking
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		rooms:      make(map[int64]map[*Client]bool),
-		store:      store,
+func NewHub(store store.Storage, shardCount int) *Hub {
+	if shardCount <= 0 {
+		shardCount = runtime.GOMAXPROCS(0)
 	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &Hub{
+		ctx:    ctx,
+		cancel: cancel,
+		shards: make([]*shard, shardCount),
+		hooks:  newHookRegistry(defaultHookWorkers, defaultHookQueueSize),
+		filter: content.NoopFilter{},
+		online: newOnlineIndex(),
+		quiet:  newRoomCache(quietCacheTTL, loadQuietHours),
+		store:  store,
+
+		languages: newLanguageCache(),
+		tuning:    &tunablesPointer{},
+
+		memberCounts: newRoomCache(memberCountTTL, loadMemberCount),
+		roomMentions: newRoomMentionLimiter(),
+		postPolicies: newRoomCache(postPolicyCacheTTL, loadPostPolicy),
+		memory:       &memoryAccount{},
+	}
+	h.userEvents = newUserEvents(store, h.online)
+	for i := range h.shards {
+		h.shards[i] = newShard(i, store)
+		h.shards[i].hooks = h.hooks
+		h.shards[i].online = h.online
+		h.shards[i].quiet = h.quiet
+		h.shards[i].languages = h.languages
+		h.shards[i].memberCounts = h.memberCounts
+		h.shards[i].roomMentions = h.roomMentions
+		h.shards[i].postPolicies = h.postPolicies
+		h.shards[i].tuning = h.tuning
+		h.shards[i].memory = h.memory
+		h.shards[i].userEvents = h.userEvents
+		h.shards[i].ctx = h.ctx
+	}
+	h.SetTunables(DefaultTunables())
+	return h
 }
 
-// Run starts the hub's main event loop
-// This should be called in a goroutine: go hub.Run()
-// The hub continuously listens on its channels and processes events
-func (h *Hub) Run() {
-	log.Println("WebSocket hub started")
+// Hooks returns the registry integrations use to subscribe to hub events
+func (h *Hub) Hooks() *HookRegistry {
+	return h.hooks
+}
 
-	for {
-		select {
-		case client := <-h.register:
-			// A new client wants to connect to a room
-			h.registerClient(client)
+// SetContentFilter sets the filter chat messages pass through before being
+// saved and broadcast; the default allows everything
+// Must be called before Run
+func (h *Hub) SetContentFilter(filter content.Filter) {
+	h.filter = filter
+	for _, s := range h.shards {
+		s.filter = filter
+	}
+}
 
-		case client := <-h.unregister:
-			// A client disconnected from a room
-			h.unregisterClient(client)
+// SetContext ties the hub's own store calls to ctx, typically the
+// application's: once it's done they're cancelled rather than left to run
+// out their timeouts. Without it they run on context.Background()
+func (h *Hub) SetContext(ctx context.Context) {
+	context.AfterFunc(ctx, h.cancel)
+}
 
-		case message := <-h.broadcast:
-			// A message needs to be broadcasted to all clients in a room
-			h.handleBroadcast(message)
-		}
+// SetNotificationPolicy sets the policy deciding which mentioned users get
+// "notify": true on chat messages; without one nobody does
+// Must be called before Run
+func (h *Hub) SetNotificationPolicy(policy notify.Policy) {
+	for _, s := range h.shards {
+		s.notifications = policy
+	}
+}
+
+// SetFeatureFlags sets the checker for features behind a flag (see
+// internal/flags); without one they're all off
+// Must be called before Run
+func (h *Hub) SetFeatureFlags(checker flags.Checker) {
+	for _, s := range h.shards {
+		s.featureFlags = checker
+	}
+}
+
+// Run starts every shard's event loop and blocks until they all exit
+// This should be called in a goroutine: go hub.Run()
+func (h *Hub) Run() {
+	log.Printf("WebSocket hub started with %d shards", len(h.shards))
+	go h.userEvents.run(h.ctx)
+
+	var wg sync.WaitGroup
+	for _, s := range h.shards {
+		wg.Add(1)
+		go func(s *shard) {
+			defer wg.Done()
+			s.run()
+		}(s)
 	}
+	wg.Wait()
 }
 
-// registerClient adds a client to a room
-func (h *Hub) registerClient(client *Client) {
-	// Check if room exists in the map
-	if h.rooms[client.roomID] == nil {
-		// Create a new set for this room
-		h.rooms[client.roomID] = make(map[*Client]bool)
+// shardFor returns the shard responsible for a room
+// Room IDs are sequential, so modulo spreads them evenly across shards
+func (h *Hub) shardFor(roomID int64) *shard {
+	index := roomID % int64(len(h.shards))
+	if index < 0 {
+		index = -index
 	}
+	return h.shards[index]
+}
+
+// register adds a client to its room's shard
+func (h *Hub) register(client *Client) {
+	h.shardFor(client.roomID).register <- client
+}
+
+// unregister removes a client from its room's shard
+func (h *Hub) unregister(client *Client) {
+	h.shardFor(client.roomID).unregister <- client
+}
 
-	// Add client to the room
-	h.rooms[client.roomID][client] = true
+// broadcast queues a message for persistence and delivery to its room
+func (h *Hub) broadcast(message *Message) {
+	h.shardFor(message.RoomID).broadcast <- message
+}
 
-	log.Printf("Client registered: user=%d room=%d (total in room: %d)",
-		client.userID, client.roomID, len(h.rooms[client.roomID]))
+// InjectMessage delivers a chat message that was saved outside the hub, e.g. one
+// sent over REST, to the room's connected clients exactly as if it had arrived
+// over a WebSocket
+// The message must already be persisted (ID set) and moderated with Moderate;
+// the hub doesn't save or filter it again
+// Safe to call from any goroutine
+func (h *Hub) InjectMessage(message *Message) {
+	// Copy so the caller can keep using its value
+	injected := *message
+	injected.Type = "message"
+	injected.sender = nil
+	injected.persisted = true
+	h.shardFor(injected.RoomID).broadcast <- &injected
+}
 
-	// Optionally send a "user joined" notification to the room
-	joinMessage := &Message{
-		RoomID:   client.roomID,
-		UserID:   client.userID,
-		Username: client.username,
-		Content:  client.username + " joined the room",
-		Type:     "join",
+// Announce broadcasts a notification frame (anything but a chat message) to a room
+// Used for changes made over REST that connected clients should see live
+// Safe to call from any goroutine
+func (h *Hub) Announce(message *Message) {
+	if message.Type == "message" {
+		// Chat messages must go through InjectMessage so they're treated as saved
+		log.Printf("Announce called with a chat message for room %d; ignored", message.RoomID)
+		return
 	}
+	announced := *message
+	announced.sender = nil
+	h.shardFor(announced.RoomID).broadcast <- &announced
+}
 
-	// Broadcast join message to all clients in the room
-	h.broadcastToRoom(client.roomID, joinMessage)
+// Moderate runs text through the hub's content filter with the same rules the
+// WebSocket path uses, including the room's content_filter_enabled setting
+// Returns the verdict and, for VerdictMask, the rewritten text
+func (h *Hub) Moderate(ctx context.Context, roomID int64, text string) (content.Verdict, string) {
+	return moderateContent(ctx, h.filter, h.store, roomID, text)
 }
 
-// unregisterClient removes a client from a room
-func (h *Hub) unregisterClient(client *Client) {
-	if clients, ok := h.rooms[client.roomID]; ok {
-		if _, ok := clients[client]; ok {
-			// Remove client from room
-			delete(clients, client)
+// sendToClient queues a frame for a single client
+// Safe to call from any goroutine; the shard loop performs the actual delivery
+func (h *Hub) sendToClient(client *Client, message *Message) {
+	h.shardFor(client.roomID).direct <- &directMessage{client: client, message: message}
+}
 
-			// Close the client's send channel
-			close(client.send)
+// setFilter replaces a client's event filter on its shard's loop
+// The client gets a "filter_updated" frame once the change is live
+func (h *Hub) setFilter(client *Client, filter eventFilter) {
+	s := h.shardFor(client.roomID)
+	s.post(func() {
+		client.filter = filter
+		s.deliverToClient(client, &Message{
+			Message: wire.Message{
+				RoomID:  client.roomID,
+				Content: "event filter updated",
+				Type:    "filter_updated",
+			},
+		})
+	})
+}
 
-			log.Printf("Client unregistered: user=%d room=%d (remaining in room: %d)",
-				client.userID, client.roomID, len(clients))
+// SendToUsers delivers a frame to the given users' connections in one room
+// Used for notifications that only some members should see, e.g. join requests for admins
+func (h *Hub) SendToUsers(roomID int64, userIDs []int64, message *Message) {
+	wanted := make(map[int64]bool, len(userIDs))
+	for _, id := range userIDs {
+		wanted[id] = true
+	}
 
-			// If room is empty, delete it from the map
-			if len(clients) == 0 {
-				delete(h.rooms, client.roomID)
-				log.Printf("Room %d is now empty and removed from hub", client.roomID)
+	message.event = h.userEvents.newEvent()
+	s := h.shardFor(roomID)
+	s.post(func() {
+		for client := range s.rooms[roomID] {
+			if wanted[client.userID] && !client.readOnly && client.filter.allows(message.Type) {
+				s.deliverToClient(client, message)
 			}
+		}
+	})
+}
 
-			// Send a "user left" notification
-			leaveMessage := &Message{
-				RoomID:   client.roomID,
-				UserID:   client.userID,
-				Username: client.username,
-				Content:  client.username + " left the room",
-				Type:     "leave",
+// SendToUser delivers a frame to every open connection of a user, whatever room it's in
+// A user's connections can live on any shard, so every shard is asked
+func (h *Hub) SendToUser(userID int64, message *Message) {
+	message.event = h.userEvents.newEvent()
+	for _, s := range h.shards {
+		s.post(func() {
+			for _, clients := range s.rooms {
+				for client := range clients {
+					if client.userID == userID && !client.readOnly && client.filter.allows(message.Type) {
+						s.deliverToClient(client, message)
+					}
+				}
 			}
-
-			// Broadcast leave message to remaining clients
-			h.broadcastToRoom(client.roomID, leaveMessage)
-		}
+		})
 	}
 }
 
-// handleBroadcast processes incoming messages
-// It persists the message to the database and broadcasts it to all clients in the room
-func (h *Hub) handleBroadcast(message *Message) {
-	// Only persist actual chat messages, not join/leave notifications
-	if message.Type == "message" {
-		// Save message to database
-		// Using context.Background() since this is not tied to a specific HTTP request
-		// In production, you might want a context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		dbMessage := &store.Message{
-			RoomID:  message.RoomID,
-			UserID:  message.UserID,
-			Content: message.Content,
-		}
+// NotifyUsers delivers a frame to every open connection of several users
+// Like SendToUser, but each shard is asked once for the whole batch instead of once per user
+func (h *Hub) NotifyUsers(userIDs []int64, message *Message) {
+	wanted := make(map[int64]bool, len(userIDs))
+	for _, id := range userIDs {
+		wanted[id] = true
+	}
 
-		if err := h.store.Messages.Create(ctx, dbMessage); err != nil {
-			log.Printf("Failed to save message to database: %v", err)
-			// Continue with broadcast even if database save fails
-			// In production, you might want to handle this differently
-		}
+	message.event = h.userEvents.newEvent()
+	for _, s := range h.shards {
+		s.post(func() {
+			for _, clients := range s.rooms {
+				for client := range clients {
+					if wanted[client.userID] && !client.readOnly && client.filter.allows(message.Type) {
+						s.deliverToClient(client, message)
+					}
+				}
+			}
+		})
 	}
+}
 
-	// Broadcast message to all clients in the room
-	h.broadcastToRoom(message.RoomID, message)
+// CloseRemovedFromRoom is the close code sent to clients whose user was removed from the room
+// 4003 echoes HTTP 403: they may no longer be here
+const CloseRemovedFromRoom = 4003
+
+// RemoveUsers disconnects the given users' connections to a room with CloseRemovedFromRoom
+// Each gets a "removed_from_room" frame first so the client can tell the user why
+// txn, if set, ties the frames to the others the removal produced (wire.Message.Txn)
+func (h *Hub) RemoveUsers(roomID int64, userIDs []int64, txn string) {
+	h.evictUsers(roomID, userIDs, func(userID int64) *Message {
+		frame := removedFrame(roomID, userID)
+		frame.Txn = txn
+		return frame
+	}, CloseRemovedFromRoom, "removed from room")
 }
 
-// broadcastToRoom sends a message to all clients in a specific room
-// This is a fan-out pattern: one message goes to many recipients
-func (h *Hub) broadcastToRoom(roomID int64, message *Message) {
-	// Get all clients in the room
-	clients, ok := h.rooms[roomID]
-	if !ok {
-		// No clients in this room
-		return
+// removedFrame is the "removed_from_room" frame sent before CloseRemovedFromRoom
+func removedFrame(roomID, userID int64) *Message {
+	return &Message{
+		Message: wire.Message{
+			RoomID:  roomID,
+			UserID:  userID,
+			Content: "you were removed from this room",
+			Type:    "removed_from_room",
+		},
 	}
+}
 
-	// Marshal message to JSON
-	// We do this once instead of for each client (more efficient)
-	jsonMessage, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Failed to marshal message: %v", err)
-		return
-	}
+// CloseRoomDeleted is the close code sent to clients of a room that was deleted
+// Application codes live in the 4000-4999 range; 4004 echoes HTTP 404
+const CloseRoomDeleted = 4004
+
+// CloseRoomMerged is the close code sent to clients of a room merged into another
+// 4301 echoes HTTP 301 Moved Permanently; the close reason names the new room
+const CloseRoomMerged = 4301
+
+// CloseRoom disconnects every client in a room with CloseRoomDeleted
+// Clients get a "room_deleted" frame first so they can tell the user why
+func (h *Hub) CloseRoom(roomID int64) {
+	h.closeRoom(roomID, &Message{
+		Message: wire.Message{
+			RoomID:  roomID,
+			Content: "this room has been deleted",
+			Type:    "room_deleted",
+		},
+	}, CloseRoomDeleted, "room deleted")
+}
 
-	// Send message to each client in the room
-	// This is the fan-out: iterate through all clients and send to each
-	for client := range clients {
-		select {
-		case client.send <- jsonMessage:
-			// Message sent successfully
-			// The non-blocking select prevents one slow client from blocking others
-		default:
-			// Client's send buffer is full, likely disconnected
-			// Close and unregister the client
-			close(client.send)
-			delete(clients, client)
-			log.Printf("Client removed due to full buffer: user=%d room=%d", client.userID, roomID)
+// CloseMergedRoom disconnects every client in a room that was merged into
+// targetID, with CloseRoomMerged
+// Clients get a "room_merged" frame carrying target_room_id first, so they can
+// reconnect to the target room
+func (h *Hub) CloseMergedRoom(roomID, targetID int64) {
+	h.closeRoom(roomID, &Message{
+		Message: wire.Message{
+			RoomID:       roomID,
+			Content:      "this room has been merged into another room",
+			Type:         "room_merged",
+			TargetRoomID: targetID,
+		},
+	}, CloseRoomMerged, "merged into room "+strconv.FormatInt(targetID, 10))
+}
+
+// closeRoom sends every client in a room a final frame and disconnects it
+func (h *Hub) closeRoom(roomID int64, final *Message, code int, reason string) {
+	final.event = h.userEvents.newEvent()
+	s := h.shardFor(roomID)
+	s.post(func() {
+		for client := range s.rooms[roomID] {
+			s.deliverToClient(client, final)
+
+			// deliverToClient drops clients with a full buffer, so check it's still here
+			if _, ok := s.rooms[roomID][client]; ok {
+				client.closeCode = code
+				client.closeReason = reason
+				s.removeClient(client)
+			}
 		}
+	})
+}
+
+// CloseSessionRevoked is the close code sent to connections of a revoked login session
+// 4401 echoes HTTP 401: the client has to log in again
+const CloseSessionRevoked = 4401
+
+// CloseSession disconnects every connection authenticated with a login
+// session, in any room, after a "session_revoked" frame
+// Sessions aren't tied to a room, so every shard is searched
+func (h *Hub) CloseSession(sessionID int64) {
+	if sessionID == 0 {
+		return
 	}
+	h.closeSignedOut(func(client *Client) bool { return client.sessionID == sessionID })
+}
+
+// CloseUser disconnects every connection of a user, in any room and with any
+// token, as CloseSession does; for when all their tokens were revoked at once
+func (h *Hub) CloseUser(userID int64) {
+	h.closeSignedOut(func(client *Client) bool { return client.userID == userID && !client.readOnly })
+}
 
-	log.Printf("Broadcasted message to %d clients in room %d", len(clients), roomID)
+// closeSignedOut sends the clients matched a "session_revoked" frame and
+// disconnects them with CloseSessionRevoked
+func (h *Hub) closeSignedOut(match func(*Client) bool) {
+	for _, s := range h.shards {
+		s := s
+		s.post(func() {
+			for roomID, clients := range s.rooms {
+				for client := range clients {
+					if !match(client) {
+						continue
+					}
+					s.deliverToClient(client, &Message{
+						Message: wire.Message{
+							RoomID:  roomID,
+							UserID:  client.userID,
+							Content: "you were signed out",
+							Type:    "session_revoked",
+						},
+					})
+
+					// deliverToClient drops clients with a full buffer, so check it's still here
+					if _, ok := s.rooms[roomID][client]; ok {
+						client.closeCode = CloseSessionRevoked
+						client.closeReason = "session revoked"
+						s.removeClient(client)
+					}
+				}
+			}
+		})
+	}
 }
 
 // GetRoomClientCount returns the number of active clients in a room
 // This can be used for monitoring or displaying "X users online" in UI
+// Read-only guests are not counted since they aren't room members
 func (h *Hub) GetRoomClientCount(roomID int64) int {
-	if clients, ok := h.rooms[roomID]; ok {
-		return len(clients)
+	s := h.shardFor(roomID)
+
+	var count int
+	s.do(func() {
+		count = s.memberCount(roomID)
+	})
+	return count
+}
+
+// Stats returns totals across all shards
+// Each shard is queried on its own loop, so this never races with broadcasts
+func (h *Hub) Stats() HubStats {
+	stats := HubStats{Shards: len(h.shards), Draining: h.Draining()}
+	var rtts []time.Duration
+	roomQueued := make(map[int64]int64)
+	var shed int64
+	for _, s := range h.shards {
+		s.do(func() {
+			stats.Rooms += len(s.rooms)
+			for roomID, clients := range s.rooms {
+				stats.Clients += len(clients)
+				stats.Online += s.onlineMemberCount(roomID)
+				rtts = rttSamples(clients, rtts)
+				roomQueued[roomID] = roomQueuedBytes(clients)
+			}
+			shed += s.shedClients
+			stats.StaleDisconnects += s.staleClients
+			stats.RoomStatsPending += len(s.statsDirty)
+			stats.Throttle.Throttled += len(s.throttled)
+			stats.Throttle.Episodes += s.throttleCounts.Episodes
+			stats.Throttle.Dropped += s.throttleCounts.Dropped
+			stats.Throttle.Coalesced += s.throttleCounts.Coalesced
+			if s.audit != nil {
+				if stats.Audit == nil {
+					stats.Audit = &AuditStats{}
+				}
+				stats.Audit.Frames += s.audit.frames
+				stats.Audit.OutOfOrder += s.audit.outOfOrder
+				stats.Audit.Gaps += s.audit.gaps
+			}
+		})
 	}
-	return 0
+	stats.RTT = summarizeRTT(rtts)
+	stats.Memory = h.memoryStats(roomQueued, shed)
+	return stats
 }
@@ -0,0 +1,401 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+	"github.com/drazan344/go-chat/pkg/wire"
+	"github.com/gorilla/websocket"
+)
+
+// TestChatMessagesAreSaved sends a chat message over the WebSocket: it's
+// saved through MessageStore.Create, which keeps the room's last activity,
+// before the room gets it
+func TestChatMessagesAreSaved(t *testing.T) {
+	messages := newMemoryMessages()
+	hub := NewHub(store.Storage{Messages: messages, Rooms: roomSettings{}, Receipts: &memoryReceipts{}}, 0)
+	hub.SetRoomStatsInterval(0)
+	go hub.Run()
+
+	sender := dialTestHub(t, hub, 1, 1)
+	reader := dialTestHub(t, hub, 2, 1)
+	framesUntil(t, reader, "join") // Its own
+	if err := sender.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	frames := framesUntil(t, reader, "message")
+	if got := frames[len(frames)-1]; got.Content != "hello" || got.UserID != 1 {
+		t.Errorf("the room got %+v, want user 1's hello", got)
+	}
+
+	saved := messages.saved(1)
+	if len(saved) != 1 || saved[0].Content != "hello" || saved[0].UserID != 1 {
+		t.Errorf("saved %+v, want user 1's hello once", saved)
+	}
+}
+
+// TestInjectMessage hands the hub a message saved elsewhere: the room gets
+// it as a chat message with its ID, and it isn't saved a second time
+func TestInjectMessage(t *testing.T) {
+	messages := newMemoryMessages()
+	hub := NewHub(store.Storage{Messages: messages, Rooms: roomSettings{}, Receipts: &memoryReceipts{}}, 1)
+	hub.SetRoomStatsInterval(0)
+	go hub.Run()
+
+	reader := dialTestHub(t, hub, 2, 1)
+	framesUntil(t, reader, "join")
+	injected := &Message{Message: wire.Message{ID: 42, RoomID: 1, UserID: 1, Username: "user1", Content: "from REST"}}
+	hub.InjectMessage(injected)
+	frames := framesUntil(t, reader, "message")
+	if got := frames[len(frames)-1]; got.ID != 42 || got.Content != "from REST" {
+		t.Errorf("the room got %+v, want message 42", got)
+	}
+	if injected.Type != "" {
+		t.Error("InjectMessage changed the caller's message")
+	}
+	if saved := messages.saved(1); len(saved) != 0 {
+		t.Errorf("the hub saved %d messages, want none", len(saved))
+	}
+}
+
+// TestHubClientsMovingBetweenRooms has users hop from room to room, so every
+// hop leaves one shard and joins another, while producers keep every room
+// busy. Run with -race: clients are registered and removed on several shard
+// loops at once
+// Every hop must be visible as soon as the shard has handled it, and once
+// everyone has gone the hub's bookkeeping must be back to zero
+func TestHubClientsMovingBetweenRooms(t *testing.T) {
+	const (
+		shards = 4
+		rooms  = 12
+		users  = 16
+		hops   = 40
+	)
+
+	hub := newTestHub(shards)
+	go hub.Run()
+
+	stop := make(chan struct{})
+	var producing sync.WaitGroup
+	for roomID := int64(1); roomID <= rooms; roomID++ {
+		producing.Add(1)
+		go func() {
+			defer producing.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				hub.broadcast(&Message{Message: wire.Message{RoomID: roomID, UserID: 1000, Username: "producer", Content: fmt.Sprintf("%d", i), Type: "message"}})
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+
+	var moving sync.WaitGroup
+	for userID := int64(1); userID <= users; userID++ {
+		moving.Add(1)
+		go func() {
+			defer moving.Done()
+			for hop := int64(0); hop < hops; hop++ {
+				roomID := (userID+hop)%rooms + 1
+				s := hub.shardFor(roomID)
+				client := newTestClient(hub, userID, roomID, 1024)
+				drained := make(chan struct{})
+				go func() {
+					defer close(drained)
+					drainFrames(client)
+				}()
+
+				hub.register(client)
+				var registered bool
+				s.do(func() { _, registered = s.rooms[roomID][client] })
+				if !registered {
+					t.Errorf("user %d isn't in room %d's shard after registering", userID, roomID)
+				}
+
+				hub.unregister(client)
+				<-drained
+				s.do(func() { _, registered = s.rooms[roomID][client] })
+				if registered {
+					t.Errorf("user %d is still in room %d's shard after leaving", userID, roomID)
+				}
+			}
+		}()
+	}
+	moving.Wait()
+	close(stop)
+	producing.Wait()
+
+	if stats := hub.Stats(); stats.Clients != 0 || stats.Rooms != 0 {
+		t.Errorf("after everyone left the hub has %d clients in %d rooms", stats.Clients, stats.Rooms)
+	}
+}
+
+// TestHubShardBoundaries registers clients in rooms either side of shard
+// boundaries, including rooms that share a shard, and checks each room lives
+// on the one shard shardFor picks and counts and broadcasts stay per room
+func TestHubShardBoundaries(t *testing.T) {
+	const shards = 4
+
+	hub := newTestHub(shards)
+	go hub.Run()
+
+	// 3 and 4 are adjacent rooms on different shards; 4 and 8 share shard 0,
+	// as do 0 and -4, which shardFor must still place
+	roomIDs := []int64{-4, 0, 3, 4, 8}
+	for _, roomID := range roomIDs {
+		index := roomID % shards
+		if index < 0 {
+			index = -index
+		}
+		if got := hub.shardFor(roomID); got != hub.shards[index] {
+			t.Fatalf("room %d is on shard %d, want %d", roomID, got.id, index)
+		}
+	}
+
+	// User 1 is connected to rooms 3 and 4; every room also has user 2
+	// Each client counts the chat messages it gets
+	var clients []*Client
+	received := make(map[*Client]*atomic.Int64)
+	var reading sync.WaitGroup
+	connect := func(userID, roomID int64) *Client {
+		client := newTestClient(hub, userID, roomID, 256)
+		count := &atomic.Int64{}
+		received[client] = count
+		clients = append(clients, client)
+		reading.Add(1)
+		go func() {
+			defer reading.Done()
+			for frame := range client.send {
+				if isChatFrame(frame) {
+					count.Add(1)
+				}
+			}
+		}()
+		hub.register(client)
+		return client
+	}
+	connect(1, 3)
+	second := connect(1, 4)
+	for _, roomID := range roomIDs {
+		connect(2, roomID)
+	}
+
+	for _, roomID := range roomIDs {
+		want := 1
+		if roomID == 3 || roomID == 4 {
+			want = 2
+		}
+		if got := hub.GetRoomClientCount(roomID); got != want {
+			t.Errorf("room %d has %d clients, want %d", roomID, got, want)
+		}
+	}
+	for i, s := range hub.shards {
+		var foreign []int64
+		s.do(func() {
+			for roomID := range s.rooms {
+				if hub.shardFor(roomID) != s {
+					foreign = append(foreign, roomID)
+				}
+			}
+		})
+		if len(foreign) != 0 {
+			t.Errorf("shard %d holds rooms %v, which belong to other shards", i, foreign)
+		}
+	}
+	if stats := hub.Stats(); stats.Rooms != len(roomIDs) || stats.Clients != len(clients) {
+		t.Errorf("the hub has %d clients in %d rooms, want %d in %d", stats.Clients, stats.Rooms, len(clients), len(roomIDs))
+	}
+
+	// A message to room 4 reaches room 4 only, not room 8 on the same shard
+	// or room 3 next to it
+	hub.broadcast(&Message{Message: wire.Message{RoomID: 4, UserID: 1000, Username: "producer", Content: "room 4 only", Type: "message"}})
+	for _, s := range hub.shards {
+		s.do(func() {})
+	}
+	testutil.WaitFor(time.Second, func() bool { return received[second].Load() == 1 })
+	for client, count := range received {
+		want := int64(0)
+		if client.roomID == 4 {
+			want = 1
+		}
+		if got := count.Load(); got != want {
+			t.Errorf("user %d in room %d got %d chat messages, want %d", client.userID, client.roomID, got, want)
+		}
+	}
+
+	for _, client := range clients {
+		hub.unregister(client)
+	}
+	reading.Wait()
+	if stats := hub.Stats(); stats.Rooms != 0 || stats.Clients != 0 {
+		t.Errorf("after everyone left the hub has %d clients in %d rooms", stats.Clients, stats.Rooms)
+	}
+}
+
+// isChatFrame reports whether a frame is a chat message
+func isChatFrame(frame []byte) bool {
+	var message struct {
+		Type string `json:"type"`
+	}
+	return json.Unmarshal(frame, &message) == nil && message.Type == "message"
+}
+
+// BenchmarkHubSingleLoop and BenchmarkHubSharded fan messages out to 8 busy
+// rooms, each with its own producer, through one shard and through one shard
+// per room; the difference is what sharding buys
+func BenchmarkHubSingleLoop(b *testing.B) {
+	benchmarkHub(b, 1)
+}
+
+func BenchmarkHubSharded(b *testing.B) {
+	benchmarkHub(b, 8)
+}
+
+// benchmarkHub sends b.N messages spread over 8 rooms with 10 readers each
+// and waits until every reader has received its room's share
+func benchmarkHub(b *testing.B, shards int) {
+	const (
+		rooms   = 8
+		readers = 10
+	)
+
+	hub := newTestHub(shards)
+	go hub.Run()
+
+	var received atomic.Int64
+	var clients []*Client
+	var reading sync.WaitGroup
+	for roomID := int64(1); roomID <= rooms; roomID++ {
+		for userID := int64(1); userID <= readers; userID++ {
+			client := newTestClient(hub, userID, roomID, 4096)
+			clients = append(clients, client)
+			reading.Add(1)
+			go func() {
+				defer reading.Done()
+				for range client.send {
+					received.Add(1)
+				}
+			}()
+			hub.register(client)
+		}
+	}
+
+	// Start counting once the join announcements have been read
+	for _, s := range hub.shards {
+		s.do(func() {})
+	}
+	testutil.WaitFor(10*time.Second, func() bool {
+		for _, client := range clients {
+			if len(client.send) > 0 {
+				return false
+			}
+		}
+		return true
+	})
+	baseline := received.Load()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	var producing sync.WaitGroup
+	for roomID := int64(1); roomID <= rooms; roomID++ {
+		producing.Add(1)
+		go func() {
+			defer producing.Done()
+			for i := roomID - 1; i < int64(b.N); i += rooms {
+				hub.broadcast(&Message{Message: wire.Message{RoomID: roomID, UserID: 1000, Username: "producer", Content: "benchmark", Type: "message"}})
+			}
+		}()
+	}
+	producing.Wait()
+	want := baseline + int64(b.N)*readers
+	if !testutil.WaitFor(time.Minute, func() bool { return received.Load() >= want }) {
+		b.Fatalf("readers got %d of %d frames", received.Load()-baseline, int64(b.N)*readers)
+	}
+	b.StopTimer()
+
+	for _, client := range clients {
+		hub.unregister(client)
+	}
+	reading.Wait()
+}
+
+// TestCloseSession revokes a session with connections in rooms on two
+// shards: both get a session_revoked frame and are closed with 4401, while
+// the same user's connection from another session stays
+func TestCloseSession(t *testing.T) {
+	hub := newTestHub(4)
+	go hub.Run()
+
+	revoked := []*Client{newTestClient(hub, 1, 3, 64), newTestClient(hub, 1, 4, 64)}
+	other := newTestClient(hub, 1, 3, 64)
+	for _, client := range revoked {
+		client.SetSession(7)
+		hub.register(client)
+	}
+	other.SetSession(8)
+	hub.register(other)
+
+	hub.CloseSession(7)
+	for _, client := range revoked {
+		done := make(chan [][]byte)
+		go func() { done <- drainFrames(client) }()
+		select {
+		case frames := <-done:
+			var last Message
+			json.Unmarshal(frames[len(frames)-1], &last)
+			if last.Type != "session_revoked" || client.closeCode != CloseSessionRevoked {
+				t.Errorf("the client in room %d ended with a %q frame and close code %d", client.roomID, last.Type, client.closeCode)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the client in room %d wasn't disconnected", client.roomID)
+		}
+	}
+
+	if got := hub.GetRoomClientCount(3); got != 1 {
+		t.Errorf("room 3 has %d clients, want the other session's", got)
+	}
+	if got := hub.GetRoomClientCount(4); got != 0 {
+		t.Errorf("room 4 has %d clients, want none", got)
+	}
+}
+
+// TestCloseUser signs a user out of every connection, whatever its session,
+// and leaves other users connected
+func TestCloseUser(t *testing.T) {
+	hub := newTestHub(4)
+	go hub.Run()
+
+	closed := []*Client{newTestClient(hub, 1, 3, 64), newTestClient(hub, 1, 4, 64)}
+	closed[0].SetSession(7)
+	other := newTestClient(hub, 2, 3, 64)
+	for _, client := range append(closed, other) {
+		hub.register(client)
+	}
+
+	hub.CloseUser(1)
+	for _, client := range closed {
+		done := make(chan [][]byte)
+		go func() { done <- drainFrames(client) }()
+		select {
+		case <-done:
+			if client.closeCode != CloseSessionRevoked {
+				t.Errorf("the client in room %d was closed with %d, want %d", client.roomID, client.closeCode, CloseSessionRevoked)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the client in room %d wasn't disconnected", client.roomID)
+		}
+	}
+
+	if got := hub.GetRoomClientCount(3); got != 1 {
+		t.Errorf("room 3 has %d clients, want the other user's", got)
+	}
+}
@@ -0,0 +1,115 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// Invites over the socket
+//
+// A user invited to a room gets an "invite" frame on every open connection,
+// whatever room it's in (the server sends it with SendToUser), and can answer
+// on any of them without a REST call:
+//
+//	{"type": "invite_response", "invite_id": 7, "accept": true}
+//
+// The hub hands the answer to the InviteResponder the server set, off the
+// shard loop. The server's joins the room and confirms the answer to the
+// invitee and the inviter with "invite_accepted" or "invite_declined"
+// frames, as its REST endpoints do. Refusals come back as an "error" frame
+// with the invite_id and a code: invite_not_found, invite_expired,
+// invite_already_answered, room_full or room_quota_exceeded
+
+const (
+	// maxInviteResponsesInFlight is how many invite responses a connection may have running
+	maxInviteResponsesInFlight = 3
+
+	// inviteResponseTimeout bounds the responder's work for one response
+	inviteResponseTimeout = 10 * time.Second
+)
+
+// InviteResponder answers invites for "invite_response" frames
+// RespondToInvite returns the store's invite errors (store.ErrInviteNotFound,
+// ...) for answers it refuses; the hub turns them into error frames
+type InviteResponder interface {
+	RespondToInvite(ctx context.Context, userID, inviteID int64, accept bool) error
+}
+
+// SetInviteResponder lets clients answer invites over the socket
+// Without one "invite_response" frames are refused as unknown
+// Must be called before Run
+func (h *Hub) SetInviteResponder(responder InviteResponder) {
+	h.invites = responder
+}
+
+// respondToInvite validates an invite response and starts its worker
+// Called from readPump; it never blocks on the store
+func (c *Client) respondToInvite(in inboundFrame) {
+	responder := c.hub.invites
+	if responder == nil {
+		c.sendError("unknown_frame_type", "unknown frame type "+in.Type)
+		return
+	}
+	if c.readOnly {
+		c.sendInviteError(in.InviteID, "guest_read_only", "guests can't answer invites")
+		return
+	}
+	if in.InviteID <= 0 || in.Accept == nil {
+		c.sendInviteError(in.InviteID, "invalid_invite_response", "invite_response needs an invite_id and accept")
+		return
+	}
+	if c.inviteResponsesInFlight.Add(1) > maxInviteResponsesInFlight {
+		c.inviteResponsesInFlight.Add(-1)
+		c.sendInviteError(in.InviteID, "too_many_invite_responses", "wait for an invite response to finish")
+		return
+	}
+
+	go func() {
+		defer c.inviteResponsesInFlight.Add(-1)
+		ctx, cancel := context.WithTimeout(c.hub.ctx, inviteResponseTimeout)
+		defer cancel()
+		if err := responder.RespondToInvite(ctx, c.userID, in.InviteID, *in.Accept); err != nil {
+			code, message := inviteErrorCode(err)
+			if code == "invite_response_failed" {
+				log.Printf("Failed to answer invite %d for user %d: %v", in.InviteID, c.userID, err)
+			}
+			c.sendInviteError(in.InviteID, code, message)
+		}
+	}()
+}
+
+// sendInviteError sends the client an "error" frame about one of its invite responses
+func (c *Client) sendInviteError(inviteID int64, code, message string) {
+	c.hub.sendToClient(c, &Message{
+		Message: wire.Message{
+			RoomID:   c.roomID,
+			Content:  message,
+			Type:     "error",
+			Code:     code,
+			InviteID: inviteID,
+		},
+	})
+}
+
+// inviteErrorCode returns the error frame code and text for a refused invite response
+func inviteErrorCode(err error) (code, message string) {
+	switch {
+	case errors.Is(err, store.ErrInviteNotFound):
+		return "invite_not_found", "no such invite"
+	case errors.Is(err, store.ErrInviteExpired):
+		return "invite_expired", "the invite has expired"
+	case errors.Is(err, store.ErrInviteAnswered):
+		return "invite_already_answered", "the invite was already answered the other way"
+	case errors.Is(err, store.ErrRoomFull):
+		return "room_full", "the room is full"
+	case errors.Is(err, store.ErrTooManyRooms):
+		return "room_quota_exceeded", "you're in as many rooms as you can be"
+	default:
+		return "invite_response_failed", "the invite couldn't be answered, try again"
+	}
+}
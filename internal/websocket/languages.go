@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/sysmsg"
+)
+
+// languageCacheTTL is how long a room's language is trusted before being reloaded
+// Changes made through this instance take effect at once (see SetRoomLanguage)
+const languageCacheTTL = 5 * time.Minute
+
+// languageCache remembers each room's language, for rendering system messages
+// The shard loop only peeks at it; RoomLanguage loads a room's language when
+// a client connects, so it's there by the time the client's join is announced
+// Languages are cached resolved: never empty
+type languageCache struct {
+	rooms *roomCache[string]
+
+	// fallback is used for rooms without a language of their own
+	// Set before Run, only read afterwards
+	fallback string
+}
+
+func newLanguageCache() *languageCache {
+	c := &languageCache{fallback: sysmsg.DefaultLanguage}
+	c.rooms = newRoomCache(languageCacheTTL, func(ctx context.Context, st store.Storage, roomID int64) (string, error) {
+		language, err := st.Rooms.GetLanguage(ctx, roomID)
+		return c.resolve(language), err
+	})
+	return c
+}
+
+// get returns a room's language, loading it if it isn't cached or is stale
+// A room that can't be loaded uses the fallback until the next try
+func (c *languageCache) get(ctx context.Context, st store.Storage, roomID int64) string {
+	language, err := c.rooms.get(ctx, st, roomID)
+	if err != nil {
+		log.Printf("Failed to load the language of room %d: %v", roomID, err)
+		return c.fallback
+	}
+	return language
+}
+
+// peek returns a room's cached language, or the fallback, without loading it
+func (c *languageCache) peek(roomID int64) string {
+	if language, ok := c.rooms.peek(roomID); ok {
+		return language
+	}
+	return c.fallback
+}
+
+// set caches a room's language setting ("" for the fallback)
+func (c *languageCache) set(roomID int64, language string) {
+	c.rooms.set(roomID, c.resolve(language))
+}
+
+// resolve replaces a room's missing language with the fallback
+func (c *languageCache) resolve(language string) string {
+	if language == "" {
+		return c.fallback
+	}
+	return language
+}
+
+// SetDefaultLanguage sets the language of rooms without one of their own
+// Must be called before Run
+func (h *Hub) SetDefaultLanguage(language string) {
+	h.languages.fallback = language
+}
+
+// RoomLanguage returns the language a room's system messages are shown in,
+// loading it if the hub doesn't know it yet
+// Safe to call from any goroutine, but not from a shard's loop
+func (h *Hub) RoomLanguage(ctx context.Context, roomID int64) string {
+	return h.languages.get(ctx, h.store, roomID)
+}
+
+// SetRoomLanguage tells the hub a room's language setting changed ("" for the default)
+// Call it after saving the room, so frames use the new language at once
+// Safe to call from any goroutine
+func (h *Hub) SetRoomLanguage(roomID int64, language string) {
+	h.languages.set(roomID, language)
+}
+
+// RenderSystemMessages fills in the content of structured system messages
+// in their room's language; other messages are left alone
+// Safe to call from any goroutine, but not from a shard's loop
+func (h *Hub) RenderSystemMessages(ctx context.Context, messages []*store.Message) {
+	for _, m := range messages {
+		if m.SystemEvent != nil {
+			m.Content = sysmsg.Render(m.SystemEvent, h.RoomLanguage(ctx, m.RoomID))
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package websocket
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/sysmsg"
+)
+
+// memoryRoomLanguages answers GetLanguage from a map and counts the calls
+// Rooms missing from the map fail to load; the other methods are the real
+// store's on no database and would panic
+type memoryRoomLanguages struct {
+	*store.RoomStore
+	mu        sync.Mutex
+	languages map[int64]string
+	queries   int
+}
+
+func (m *memoryRoomLanguages) GetLanguage(_ context.Context, roomID int64) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries++
+	language, ok := m.languages[roomID]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return language, nil
+}
+
+func (m *memoryRoomLanguages) queried() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.queries
+}
+
+// TestRoomLanguages loads rooms' languages through the hub: a room's own
+// language is cached, a room without one or that can't be loaded uses the
+// default (and the failure isn't cached), and SetRoomLanguage replaces the
+// cached one without a query. Join and leave frames and history are rendered
+// in whatever the room's language is at the time
+func TestRoomLanguages(t *testing.T) {
+	const timeout = 2 * time.Second
+	ctx := context.Background()
+	rooms := &memoryRoomLanguages{languages: map[int64]string{1: "de", 2: ""}}
+	hub := NewHub(store.Storage{Rooms: rooms}, 1)
+	hub.SetDefaultLanguage("fr")
+	hub.SetRoomStatsInterval(0)
+	hub.SetPresenceGrace(0)
+	tunables := DefaultTunables()
+	tunables.BandwidthBudget = 0
+	hub.SetTunables(tunables)
+	go hub.Run()
+
+	for _, c := range []struct {
+		roomID  int64
+		want    string
+		queries int // In total, after the call
+	}{
+		{1, "de", 1},
+		{1, "de", 1},
+		{2, "fr", 2},
+		{3, "fr", 3},
+		{3, "fr", 4},
+	} {
+		if got := hub.RoomLanguage(ctx, c.roomID); got != c.want {
+			t.Errorf("room %d's language is %q, want %q", c.roomID, got, c.want)
+		}
+		if n := rooms.queried(); n != c.queries {
+			t.Errorf("after loading room %d the store was asked %d times, want %d", c.roomID, n, c.queries)
+		}
+	}
+
+	observer := newTestClient(hub, 1, 1, 64)
+	hub.register(observer)
+	defer hub.unregister(observer)
+
+	// expect checks the observer's next frame of frameType is about client
+	// and reads want
+	expect := func(client *Client, frameType, want string) {
+		t.Helper()
+		frame := nextFrame(observer, frameType, timeout)
+		if frame == nil {
+			t.Fatalf("the observer got no %s frame", frameType)
+		}
+		if frame.Content != want || frame.SystemEvent["username"] != client.username || frame.SystemEvent.Name() != frameType {
+			t.Errorf("the %s frame has %q with event %v, want %q", frameType, frame.Content, frame.SystemEvent, want)
+		}
+	}
+	expect(observer, "join", "user1 ist dem Raum beigetreten") // Its own
+	ada := newTestClient(hub, 2, 1, 64)
+	hub.register(ada)
+	expect(ada, "join", "user2 ist dem Raum beigetreten")
+
+	// A change made through this instance applies at once
+	hub.SetRoomLanguage(1, "")
+	hub.unregister(ada)
+	expect(ada, "leave", "user2 left the room") // fr has no catalog
+	if got := hub.RoomLanguage(ctx, 1); got != "fr" {
+		t.Errorf("after the reset room 1's language is %q, want fr", got)
+	}
+	hub.SetRoomLanguage(1, "de-CH")
+	grace := newTestClient(hub, 3, 1, 64)
+	hub.register(grace)
+	expect(grace, "join", "user3 ist dem Raum beigetreten")
+	hub.unregister(grace)
+	if n := rooms.queried(); n != 4 {
+		t.Errorf("setting languages queried the store; %d queries, want 4", n)
+	}
+
+	messages := []*store.Message{
+		{RoomID: 1, SystemEvent: sysmsg.Leave("ada"), Content: "ada left the room"},
+		{RoomID: 2, SystemEvent: sysmsg.Merged("random")},
+		{RoomID: 1, Content: "hallo"},
+		// Saved as text before system messages had events
+		{RoomID: 1, UserID: store.SystemUserID, System: true, LegacySystem: true, Content: "ada joined the room"},
+	}
+	hub.RenderSystemMessages(ctx, messages)
+	for i, want := range []string{"ada hat den Raum verlassen", "random was merged into this room", "hallo", "ada joined the room"} {
+		if messages[i].Content != want {
+			t.Errorf("message %d renders as %q, want %q", i, messages[i].Content, want)
+		}
+	}
+}
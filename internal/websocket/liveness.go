@@ -0,0 +1,134 @@
+package websocket
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Connection liveness
+//
+// A pong only shows the client's reads got through at some point; it says
+// nothing about whether our writes still do. When a client's TCP buffer backs
+// up, writePump can sit in a large write while the client keeps answering
+// pings, and the connection stays registered receiving nothing
+//
+// So pings don't go through writePump: pingPump sends them as control frames
+// with their own deadline, which the connection allows alongside writePump's
+// writes. And each client records when its last frame was written: every
+// staleCheckInterval the shard disconnects clients whose writes haven't
+// completed for Tunables.StaleWriteThreshold while they had frames to write,
+// with CloseStaleConnection, whatever their pongs say
+
+// CloseStaleConnection is the close code sent to clients disconnected because
+// frames stopped reaching them
+const CloseStaleConnection = 4408
+
+const (
+	// staleCheckInterval is how often each shard looks for stale clients
+	staleCheckInterval = 5 * time.Second
+
+	// defaultStaleWriteThreshold is the threshold unless the tunables change it
+	// Longer than writeWait, so a write that times out is reported by writePump first
+	defaultStaleWriteThreshold = 45 * time.Second
+
+	// staleCloseWait is how long a stale client is given to take its close frame
+	staleCloseWait = time.Second
+)
+
+// beginWrite records that writePump started writing a frame
+func (c *Client) beginWrite() {
+	c.writeStarted.Store(time.Now().UnixNano())
+}
+
+// endWrite records that writePump finished writing a frame
+func (c *Client) endWrite() {
+	c.lastWrite.Store(time.Now().UnixNano())
+	c.writeStarted.Store(0)
+}
+
+// stalledFor returns how long the client's writes haven't kept up: how long
+// the current write has taken, or, with frames waiting, how long since they
+// started waiting or a frame was last written, whichever is later
+// Zero when the client is keeping up or has nothing to write
+// Must only be called from the shard's loop
+func (c *Client) stalledFor(now time.Time) time.Duration {
+	if started := c.writeStarted.Load(); started != 0 {
+		return now.Sub(time.Unix(0, started))
+	}
+	if len(c.send) == 0 {
+		return 0
+	}
+	since := c.pendingSince
+	if last := time.Unix(0, c.lastWrite.Load()); last.After(since) {
+		since = last
+	}
+	return now.Sub(since)
+}
+
+// checkStale disconnects the clients whose writes stalled for longer than the threshold
+// Must only be called from the shard's loop
+func (s *shard) checkStale() {
+	threshold := s.tuning.Load().StaleWriteThreshold
+	if threshold <= 0 {
+		return
+	}
+
+	now := time.Now()
+	var stale []*Client
+	for _, clients := range s.rooms {
+		for client := range clients {
+			if client.stalledFor(now) > threshold {
+				stale = append(stale, client)
+			}
+		}
+	}
+	for _, client := range stale {
+		s.disconnectStale(client, client.stalledFor(now))
+	}
+}
+
+// disconnectStale unregisters a client whose frames stopped reaching it
+// The connection is closed as well, since writePump may be stuck in a write
+// that only closing it ends
+// Must only be called from the shard's loop
+func (s *shard) disconnectStale(client *Client, stalled time.Duration) {
+	log.Printf("Stale client disconnected: user=%d room=%d stalled=%s queued_frames=%d queued_bytes=%d",
+		client.userID, client.roomID, stalled.Round(time.Second), len(client.send), client.queuedBytes.Load())
+
+	client.closeCode = CloseStaleConnection
+	client.closeReason = "connection stale"
+	s.removeClient(client)
+	s.staleClients++
+
+	// Clients without a connection are the load harness's
+	if conn := client.conn; conn != nil {
+		go func() {
+			message := websocket.FormatCloseMessage(CloseStaleConnection, "connection stale")
+			conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(staleCloseWait))
+			conn.Close()
+		}()
+	}
+}
+
+// pingPump pings the client until the connection is gone
+// If a ping can't be written within writeWait the connection is closed,
+// which ends readPump and so unregisters the client
+func (c *Client) pingPump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			// The payload is the send time, echoed back in the pong to measure RTT
+			if err := c.conn.WriteControl(websocket.PingMessage, pingPayload(), time.Now().Add(writeWait)); err != nil {
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package websocket
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testutil"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// TestStaleConnectionsReclaimed checks that connections whose writes stall
+// are reclaimed
+// Stalled clients take no frame off their channel, like a connection whose
+// TCP buffer is full. With the stale threshold at 0 they must still be
+// registered after waiting well past it; once the threshold is set, every
+// stalled client must be disconnected with CloseStaleConnection within the
+// threshold and one sweep, and clients that keep reading must never be touched
+func TestStaleConnectionsReclaimed(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for two stale sweeps")
+	}
+	const (
+		rooms     = 4
+		stalledN  = 2 // Clients per room whose writes never complete
+		readersN  = 3 // Clients per room that read everything
+		threshold = time.Second
+	)
+
+	hub := newTestHub(2)
+	tunables := DefaultTunables()
+	tunables.BandwidthBudget = 0
+	tunables.MemorySoftLimit = 0
+	tunables.MemoryHardLimit = 0
+	tunables.StaleWriteThreshold = 0
+	hub.SetTunables(tunables)
+	go hub.Run()
+
+	var reading sync.WaitGroup
+	var stalled, readers []*Client
+	for room := int64(1); room <= rooms; room++ {
+		for k := int64(1); k <= stalledN+readersN; k++ {
+			client := newTestClient(hub, k, room, 256)
+			if k <= stalledN {
+				stalled = append(stalled, client)
+				hub.register(client)
+				continue
+			}
+
+			// Readers write every frame as writePump would
+			readers = append(readers, client)
+			reading.Add(1)
+			go func() {
+				defer reading.Done()
+				for frame := range client.send {
+					client.beginWrite()
+					hub.memory.dequeued(client, len(frame))
+					client.endWrite()
+				}
+			}()
+			hub.register(client)
+		}
+	}
+
+	// A trickle of messages, so the readers keep writing and the stalled
+	// clients' frames pile up without filling their buffers
+	stop := make(chan struct{})
+	var producing sync.WaitGroup
+	var messages atomic.Int64
+	producing.Add(1)
+	go func() {
+		defer producing.Done()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				n := messages.Add(1)
+				hub.InjectMessage(&Message{
+					Message: wire.Message{
+						RoomID:   n%rooms + 1,
+						UserID:   1000,
+						Username: "producer",
+						Content:  "tick",
+					},
+				})
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		producing.Wait()
+	}()
+
+	// With the sweep off, stalled clients stay registered however long they wait
+	time.Sleep(threshold + staleCheckInterval + time.Second)
+	if clients := hub.Stats().Clients; clients != len(stalled)+len(readers) {
+		t.Errorf("%d of %d clients registered with the sweep off", clients, len(stalled)+len(readers))
+	}
+
+	// With it on, every one of them goes within the threshold and one sweep
+	tunables.StaleWriteThreshold = threshold
+	hub.SetTunables(tunables)
+	within := threshold + staleCheckInterval + time.Second
+	if !testutil.WaitFor(within, func() bool { return hub.Stats().StaleDisconnects >= int64(len(stalled)) }) {
+		t.Fatalf("only %d of %d stalled clients were disconnected within %s", hub.Stats().StaleDisconnects, len(stalled), within)
+	}
+
+	for _, client := range readers {
+		hub.unregister(client)
+	}
+	reading.Wait()
+	for _, client := range stalled {
+		drainFrames(client)
+		if client.closeCode != CloseStaleConnection {
+			t.Errorf("stalled user=%d room=%d was closed with code %d, not %d", client.userID, client.roomID, client.closeCode, CloseStaleConnection)
+		}
+	}
+	for _, client := range readers {
+		if client.closeCode != 0 {
+			t.Errorf("reader user=%d room=%d was disconnected with code %d", client.userID, client.roomID, client.closeCode)
+		}
+	}
+}
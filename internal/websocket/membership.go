@@ -0,0 +1,183 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// Room membership
+//
+// A connection's membership is checked once, when it opens. Afterwards the
+// hub has to be told: handlers call RemoveUsers (kicks, bulk removals) or
+// LeftRoom (leaves) once the change is committed, and the user's connections
+// to the room get a final frame and are closed. Evicted clients are marked
+// removed, so readPump stops forwarding their frames and the shard refuses
+// the ones already queued (see handleBroadcast)
+//
+// Changes the hub isn't told about, made by another instance or straight in
+// the database, are caught by the membership sweep: every sweep interval
+// (see SetMembershipSweepInterval) each shard loads the members of its
+// rooms, one query per room, and evicts the connections of users who are
+// no longer among them
+
+// CloseLeftRoom is the close code sent to a user's connections to a room they left
+// 4410 echoes HTTP 410 Gone: the membership is over, by the user's own choice
+const CloseLeftRoom = 4410
+
+const (
+	// defaultMembershipSweepInterval is how often each shard checks its
+	// connections' memberships unless SetMembershipSweepInterval changes it
+	defaultMembershipSweepInterval = 5 * time.Minute
+
+	// membershipSweepTimeout bounds the store queries of one sweep
+	membershipSweepTimeout = 30 * time.Second
+)
+
+// LeftRoom disconnects a user's connections to a room they left, with CloseLeftRoom
+// Each gets a "left_room" frame first, so the user's other tabs can tell why
+// Safe to call from any goroutine
+func (h *Hub) LeftRoom(roomID, userID int64) {
+	h.evictUsers(roomID, []int64{userID}, func(userID int64) *Message {
+		return &Message{
+			Message: wire.Message{
+				RoomID:  roomID,
+				UserID:  userID,
+				Content: "you left this room",
+				Type:    "left_room",
+			},
+		}
+	}, CloseLeftRoom, "left room")
+}
+
+// evictUsers disconnects the given users' connections to a room, each after
+// the frame final returns for its user; a user's connections share one frame
+func (h *Hub) evictUsers(roomID int64, userIDs []int64, final func(userID int64) *Message, code int, reason string) {
+	finals := make(map[int64]*Message, len(userIDs))
+	for _, id := range userIDs {
+		frame := final(id)
+		frame.event = h.userEvents.newEvent()
+		finals[id] = frame
+	}
+
+	s := h.shardFor(roomID)
+	s.post(func() {
+		for client := range s.rooms[roomID] {
+			if frame := finals[client.userID]; frame != nil && !client.readOnly {
+				s.evict(client, frame, code, reason)
+			}
+		}
+	})
+}
+
+// evict marks a client removed, sends it a final frame and disconnects it
+// Must only be called from the shard's loop
+func (s *shard) evict(client *Client, final *Message, code int, reason string) {
+	client.removed.Store(true)
+	s.deliverToClient(client, final)
+
+	// deliverToClient drops clients with a full buffer, so check it's still here
+	if _, ok := s.rooms[client.roomID][client]; ok {
+		client.closeCode = code
+		client.closeReason = reason
+		s.removeClient(client)
+	}
+}
+
+// refuseRemoved drops a chat message whose sender was evicted from the room
+// after sending it, replying with an error frame in case the sender is still
+// connected. Returns whether the message was refused
+// Must only be called from the shard's loop
+func (s *shard) refuseRemoved(message *Message) bool {
+	if message.sender == nil || !message.sender.removed.Load() {
+		return false
+	}
+	log.Printf("Dropped message from user %d, no longer a member of room %d", message.UserID, message.RoomID)
+	s.deliverToClient(message.sender, notMemberError(message.RoomID))
+	return true
+}
+
+// notMemberError is the error frame for frames sent after leaving or being removed
+func notMemberError(roomID int64) *Message {
+	return &Message{
+		Message: wire.Message{
+			RoomID:  roomID,
+			Content: "you are no longer a member of this room",
+			Type:    "error",
+			Code:    "not_room_member",
+		},
+	}
+}
+
+// SetMembershipSweepInterval sets how often each shard checks that its
+// connected users are still members of their rooms
+// Zero or less turns the sweep off, which tools driving a hub without a database need
+// Must be called before Run
+func (h *Hub) SetMembershipSweepInterval(interval time.Duration) {
+	for _, s := range h.shards {
+		s.sweepInterval = interval
+	}
+}
+
+// sweepMembership starts checking the memberships of the shard's connected users
+// Members are loaded on a goroutine, one query per room, and the users no
+// longer among them are evicted back on the loop. Only the clients connected
+// when the sweep started are checked: a later one passed its own membership
+// check after the load began. Only one sweep runs at a time
+// Must only be called from the shard's loop
+func (s *shard) sweepMembership() {
+	if s.sweeping {
+		return
+	}
+
+	checked := make(map[int64][]*Client)
+	for roomID, clients := range s.rooms {
+		for client := range clients {
+			if !client.readOnly {
+				checked[roomID] = append(checked[roomID], client)
+			}
+		}
+	}
+	if len(checked) == 0 {
+		return
+	}
+	s.sweeping = true
+
+	go func() {
+		ctx, cancel := context.WithTimeout(s.ctx, membershipSweepTimeout)
+		defer cancel()
+
+		var gone []*Client
+		for roomID, clients := range checked {
+			userIDs, err := s.store.RoomMembers.GetRoomMembers(ctx, roomID)
+			if err != nil {
+				// Evicting on a failed query would empty the room; try again next sweep
+				log.Printf("Failed to load members of room %d for the membership sweep: %v", roomID, err)
+				continue
+			}
+			members := make(map[int64]bool, len(userIDs))
+			for _, id := range userIDs {
+				members[id] = true
+			}
+			for _, client := range clients {
+				if !members[client.userID] {
+					gone = append(gone, client)
+				}
+			}
+		}
+
+		s.post(func() {
+			s.sweeping = false
+			for _, client := range gone {
+				// The client may have disconnected while the members were loaded
+				if _, ok := s.rooms[client.roomID][client]; !ok {
+					continue
+				}
+				log.Printf("Membership sweep evicted user %d from room %d", client.userID, client.roomID)
+				s.evict(client, removedFrame(client.roomID, client.userID), CloseRemovedFromRoom, "removed from room")
+			}
+		})
+	}()
+}
@@ -0,0 +1,165 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// memoryRoomMembers answers GetRoomMembers from a map and counts the calls
+// The sweep needs nothing else from RoomMembers; the other methods are the
+// real store's on no database and would panic
+type memoryRoomMembers struct {
+	*store.RoomMemberStore
+	mu      sync.Mutex
+	members map[int64][]int64
+	queries int
+}
+
+func (m *memoryRoomMembers) GetRoomMembers(_ context.Context, roomID int64) ([]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries++
+	return m.members[roomID], nil
+}
+
+func (m *memoryRoomMembers) set(roomID int64, userIDs ...int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.members[roomID] = userIDs
+}
+
+func (m *memoryRoomMembers) queried() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.queries
+}
+
+// TestLeftRoomRefusesQueuedMessages leaves a room while a message the user
+// sent is still on its way to the shard: the user's connections get a
+// left_room frame and close code, and the message is never saved
+func TestLeftRoomRefusesQueuedMessages(t *testing.T) {
+	messages := newMemoryMessages()
+	hub := NewHub(store.Storage{Messages: messages, Rooms: roomSettings{unfiltered: map[int64]bool{1: true}}, Receipts: &memoryReceipts{}}, 1)
+	hub.SetMembershipSweepInterval(0)
+	go hub.Run()
+	s := hub.shards[0]
+
+	leaving := newTestClient(hub, 1, 1, 256)
+	otherTab := newTestClient(hub, 1, 1, 256)
+	staying := newTestClient(hub, 2, 1, 256)
+	for _, client := range []*Client{leaving, otherTab, staying} {
+		hub.register(client)
+	}
+	defer hub.unregister(staying)
+
+	send := func(client *Client, text string) {
+		hub.broadcast(&Message{
+			Message: wire.Message{RoomID: 1, UserID: client.userID, Username: client.username, Content: text, Type: "message"},
+			sender:  client,
+		})
+	}
+
+	// The leave reaches the shard first; readPump had already forwarded the message
+	hub.LeftRoom(1, 1)
+	send(leaving, "one for the road")
+	send(staying, "bye")
+
+	// The shard takes its broadcasts in order, so once user 2's is saved user 1's was handled
+	if !testutil.WaitFor(2*time.Second, func() bool {
+		saved := messages.saved(1)
+		return len(saved) > 0 && saved[len(saved)-1].Content == "bye"
+	}) {
+		t.Fatal("user 2's message was never saved")
+	}
+	s.do(func() {})
+
+	for name, client := range map[string]*Client{"the leaving connection": leaving, "the other tab": otherTab} {
+		if frame := nextFrame(client, "left_room", time.Second); frame == nil || frame.UserID != 1 {
+			t.Errorf("%s got left_room %+v, want one for user 1", name, frame)
+		}
+		if !client.removed.Load() || client.closeCode != CloseLeftRoom {
+			t.Errorf("%s was closed with %d (removed %v), want %d", name, client.closeCode, client.removed.Load(), CloseLeftRoom)
+		}
+	}
+
+	saved := messages.saved(1)
+	if len(saved) != 1 || saved[0].Content != "bye" {
+		t.Errorf("saved %d messages, want only user 2's", len(saved))
+	}
+	if staying.removed.Load() {
+		t.Error("user 2 was evicted too")
+	}
+}
+
+// TestMembershipSweep deletes a membership behind the hub's back: the next
+// sweep evicts that user's connection with a removed_from_room frame, with one
+// query per room, and leaves members and guests alone
+func TestMembershipSweep(t *testing.T) {
+	members := &memoryRoomMembers{members: map[int64][]int64{1: {1, 2}, 2: {1}}}
+	hub := NewHub(store.Storage{RoomMembers: members}, 1)
+	hub.SetRoomStatsInterval(0)
+	hub.SetMembershipSweepInterval(time.Hour) // The test sweeps itself
+	go hub.Run()
+	s := hub.shards[0]
+
+	// sweep checks the shard's memberships and waits for the evictions
+	sweep := func() {
+		t.Helper()
+		s.do(s.sweepMembership)
+		done := testutil.WaitFor(2*time.Second, func() bool {
+			var sweeping bool
+			s.do(func() { sweeping = s.sweeping })
+			return !sweeping
+		})
+		if !done {
+			t.Fatal("the sweep never finished")
+		}
+	}
+
+	member := newTestClient(hub, 1, 1, 256)
+	removed := newTestClient(hub, 2, 1, 256)
+	elsewhere := newTestClient(hub, 1, 2, 256)
+	guest := newTestClient(hub, 0, 1, 256)
+	guest.readOnly = true
+	for _, client := range []*Client{member, removed, elsewhere, guest} {
+		hub.register(client)
+	}
+	defer func() {
+		for _, client := range []*Client{member, elsewhere, guest} {
+			hub.unregister(client)
+		}
+	}()
+
+	sweep()
+	if removed.removed.Load() {
+		t.Fatal("a member was evicted")
+	}
+
+	members.set(1, 1)
+	before := members.queried()
+	sweep()
+	if got := members.queried() - before; got != 2 {
+		t.Errorf("the sweep made %d queries, want one per room (2)", got)
+	}
+
+	if frame := nextFrame(removed, "removed_from_room", time.Second); frame == nil || frame.RoomID != 1 {
+		t.Errorf("the removed user got %+v, want removed_from_room for room 1", frame)
+	}
+	if removed.closeCode != CloseRemovedFromRoom {
+		t.Errorf("the removed user was closed with %d, want %d", removed.closeCode, CloseRemovedFromRoom)
+	}
+	for name, client := range map[string]*Client{"the member": member, "the member's other room": elsewhere, "the guest": guest} {
+		if client.removed.Load() {
+			t.Errorf("%s was evicted", name)
+		}
+	}
+	if got := hub.GetRoomClientCount(1); got != 1 {
+		t.Errorf("room 1 has %d members connected, want 1", got)
+	}
+}
@@ -0,0 +1,213 @@
+package websocket
+
+import (
+	"log"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Memory accounting
+//
+// Frames waiting in client send channels are most of what the hub holds in
+// memory, and a room full of stalled readers can hold a lot of them. Every
+// frame's length is added to its client's and the hub's queued bytes when
+// the shard queues it, and taken off again when writePump takes it off the
+// channel (or the frame is discarded with its connection)
+//
+// Two tunables put a ceiling on it:
+//   - over MemorySoftLimit, every connection's bandwidth budget is cut to a
+//     quarter, so low-priority frames are held back much sooner (this does
+//     nothing while throttling is off), and a warning is logged
+//   - over MemoryHardLimit, a frame for a client holding at least its share of
+//     the queued bytes isn't queued: the client is disconnected with
+//     CloseServerOverloaded and its queued frames are discarded. Clients that
+//     keep up are untouched, and queuing resumes once the total drops
+
+// CloseServerOverloaded is the close code sent to clients disconnected at the
+// hard memory limit (1013, "try again later")
+const CloseServerOverloaded = websocket.CloseTryAgainLater
+
+// overloadBudgetDivisor divides bandwidth budgets over the soft memory limit
+const overloadBudgetDivisor = 4
+
+// maxMemoryRooms caps the rooms listed in MemoryStats; the most backed up come first
+const maxMemoryRooms = 10
+
+// memoryAccount counts the bytes queued across a hub's send channels
+// Shared by the hub, its shards and every client's writePump, so it's all atomics
+type memoryAccount struct {
+	// queued is the bytes in all send channels; clients the registered clients,
+	// which gives each client's share
+	queued  atomic.Int64
+	clients atomic.Int64
+
+	// overSoft is whether the total was over the soft limit at the last enqueue,
+	// so the warning is logged once per crossing
+	overSoft atomic.Bool
+}
+
+// MemoryStats are the hub's memory accounting figures
+type MemoryStats struct {
+	QueuedBytes int64 `json:"queued_bytes"` // Bytes waiting in send channels
+
+	// The configured limits; zero when off
+	SoftLimit int `json:"soft_limit"`
+	HardLimit int `json:"hard_limit"`
+
+	// OverSoftLimit is whether budgets are cut right now; Shed counts the
+	// clients disconnected at the hard limit
+	OverSoftLimit bool  `json:"over_soft_limit"`
+	Shed          int64 `json:"shed"`
+
+	// Rooms lists the rooms with the most bytes queued, up to maxMemoryRooms
+	Rooms []RoomMemory `json:"rooms"`
+}
+
+// RoomMemory is the bytes queued for one room's clients
+type RoomMemory struct {
+	RoomID      int64 `json:"room_id"`
+	QueuedBytes int64 `json:"queued_bytes"`
+}
+
+// enqueued counts a frame about to go on a client's send channel
+func (m *memoryAccount) enqueued(client *Client, n int) {
+	client.queuedBytes.Add(int64(n))
+	m.queued.Add(int64(n))
+}
+
+// dequeued takes a frame off the count once it has left the send channel
+func (m *memoryAccount) dequeued(client *Client, n int) {
+	client.queuedBytes.Add(-int64(n))
+	m.queued.Add(-int64(n))
+}
+
+// enqueue puts a frame on a client's send channel
+// Returns false if the frame wasn't queued, because the client's buffer was
+// full or the hub is over its hard memory limit; either way the client has
+// been disconnected
+// Must only be called from the shard's loop
+func (s *shard) enqueue(client *Client, frame []byte) bool {
+	tuning := s.tuning.Load()
+	if s.overHardLimit(client, tuning.MemoryHardLimit) {
+		s.shedClient(client)
+		return false
+	}
+
+	// Counted before the send, or writePump could take it off first
+	s.memory.enqueued(client, len(frame))
+	if len(client.send) == 0 {
+		client.pendingSince = time.Now()
+	}
+	select {
+	case client.send <- frame:
+	default:
+		// A full buffer means the client is gone
+		s.memory.dequeued(client, len(frame))
+		s.removeClient(client)
+		s.droppedClients++
+		log.Printf("Client removed due to full buffer: user=%d room=%d", client.userID, client.roomID)
+		return false
+	}
+
+	if soft := tuning.MemorySoftLimit; soft > 0 {
+		queued := s.memory.queued.Load()
+		over := queued > int64(soft)
+		if s.memory.overSoft.CompareAndSwap(!over, over) {
+			if over {
+				log.Printf("WARNING: hub has %d bytes queued, over the soft limit of %d; throttling connections harder", queued, soft)
+			} else {
+				log.Printf("Hub queued bytes back under the soft limit of %d", soft)
+			}
+		}
+	}
+
+	s.countSent(client, len(frame))
+	return true
+}
+
+// overHardLimit reports whether a frame for client must be refused: the hub
+// is over the hard limit and the client holds at least the average share of
+// the queued bytes, so it's among the ones holding the memory
+func (s *shard) overHardLimit(client *Client, limit int) bool {
+	if limit <= 0 {
+		return false
+	}
+	queued := s.memory.queued.Load()
+	if queued <= int64(limit) {
+		return false
+	}
+	share := queued / max(s.memory.clients.Load(), 1)
+	return client.queuedBytes.Load() >= share
+}
+
+// shedClient disconnects a client at the hard memory limit
+// Its queued frames are discarded right away: it isn't reading them, and
+// waiting for writePump's write deadline would keep the memory held
+// Must only be called from the shard's loop
+func (s *shard) shedClient(client *Client) {
+	client.closeCode = CloseServerOverloaded
+	client.closeReason = "server overloaded"
+	queued := client.queuedBytes.Load()
+	s.removeClient(client)
+	s.shedClients++
+
+	// The channel is closed, so this stops once it's empty; writePump may
+	// take some of the frames too, and counts those itself
+	for frame := range client.send {
+		s.memory.dequeued(client, len(frame))
+	}
+	log.Printf("Client disconnected at the hub memory limit: user=%d room=%d queued=%d", client.userID, client.roomID, queued)
+}
+
+// bandwidthBudget returns the bandwidth budget connections are held to right
+// now: the tunable's, cut while the hub is over the soft memory limit
+func (s *shard) bandwidthBudget() int {
+	tuning := s.tuning.Load()
+	budget := tuning.BandwidthBudget
+	if tuning.MemorySoftLimit > 0 && s.memory.queued.Load() > int64(tuning.MemorySoftLimit) {
+		budget /= overloadBudgetDivisor
+	}
+	return budget
+}
+
+// memoryStats collects the memory figures; rooms holds each room's queued
+// bytes, gathered on the shard loops by the caller
+func (h *Hub) memoryStats(rooms map[int64]int64, shed int64) MemoryStats {
+	tuning := h.tuning.Load()
+	stats := MemoryStats{
+		QueuedBytes:   h.memory.queued.Load(),
+		SoftLimit:     tuning.MemorySoftLimit,
+		HardLimit:     tuning.MemoryHardLimit,
+		OverSoftLimit: tuning.MemorySoftLimit > 0 && h.memory.queued.Load() > int64(tuning.MemorySoftLimit),
+		Shed:          shed,
+		Rooms:         make([]RoomMemory, 0),
+	}
+	for roomID, queued := range rooms {
+		if queued > 0 {
+			stats.Rooms = append(stats.Rooms, RoomMemory{RoomID: roomID, QueuedBytes: queued})
+		}
+	}
+	sort.Slice(stats.Rooms, func(i, j int) bool {
+		if stats.Rooms[i].QueuedBytes != stats.Rooms[j].QueuedBytes {
+			return stats.Rooms[i].QueuedBytes > stats.Rooms[j].QueuedBytes
+		}
+		return stats.Rooms[i].RoomID < stats.Rooms[j].RoomID
+	})
+	if len(stats.Rooms) > maxMemoryRooms {
+		stats.Rooms = stats.Rooms[:maxMemoryRooms]
+	}
+	return stats
+}
+
+// roomQueuedBytes sums the bytes queued for a room's clients
+// Must only be called from the shard's loop
+func roomQueuedBytes(clients map[*Client]bool) int64 {
+	var queued int64
+	for client := range clients {
+		queued += client.queuedBytes.Load()
+	}
+	return queued
+}
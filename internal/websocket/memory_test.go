@@ -0,0 +1,137 @@
+package websocket
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testutil"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// TestMemoryHardLimitRecovers drives a hub into its hard memory limit and
+// checks that it recovers: clients that never read are disconnected with
+// CloseServerOverloaded, clients that keep reading are never touched and go
+// on receiving messages, the queued bytes fall back under the limit, and
+// they return to zero once every client has disconnected
+func TestMemoryHardLimitRecovers(t *testing.T) {
+	const (
+		rooms     = 4
+		wedgedN   = 2 // Clients per room that never read
+		readersN  = 3 // Clients per room that read everything
+		frameSize = 1024
+		hardLimit = 1 << 20
+		timeout   = 30 * time.Second
+	)
+
+	// Throttling is off so every frame is queued, and only the limit holds them back
+	hub := newTestHub(2)
+	tunables := DefaultTunables()
+	tunables.BandwidthBudget = 0
+	tunables.MemorySoftLimit = hardLimit / 2
+	tunables.MemoryHardLimit = hardLimit
+	hub.SetTunables(tunables)
+	go hub.Run()
+
+	// Wedged clients get buffers big enough that the memory limit, not a
+	// full buffer, is what disconnects them
+	buffer := hardLimit/frameSize + 64
+
+	var reading sync.WaitGroup
+	var wedged, readers []*Client
+	received := make(map[*Client]*atomic.Int64)
+	for room := int64(1); room <= rooms; room++ {
+		for k := int64(1); k <= wedgedN+readersN; k++ {
+			client := newTestClient(hub, k, room, buffer)
+			if k <= wedgedN {
+				wedged = append(wedged, client)
+				hub.register(client)
+				continue
+			}
+
+			count := &atomic.Int64{}
+			received[client] = count
+			readers = append(readers, client)
+			reading.Add(1)
+			go func() {
+				defer reading.Done()
+				for frame := range client.send {
+					hub.memory.dequeued(client, len(frame))
+					count.Add(1)
+				}
+			}()
+			hub.register(client)
+		}
+	}
+
+	payload := strings.Repeat("x", frameSize)
+	var sent int
+	broadcast := func(n int) {
+		for i := 0; i < n; i++ {
+			hub.InjectMessage(&Message{
+				Message: wire.Message{
+					RoomID:   int64(sent%rooms + 1),
+					UserID:   1000,
+					Username: "producer",
+					Content:  payload,
+				},
+			})
+			sent++
+		}
+	}
+
+	// Drive broadcasts until every wedged client has been disconnected
+	deadline := time.Now().Add(timeout)
+	var peak int64
+	for {
+		broadcast(rooms)
+		stats := hub.Stats()
+		peak = max(peak, stats.Memory.QueuedBytes)
+		if stats.Memory.Shed >= int64(len(wedged)) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d wedged clients were disconnected after %d messages (peak %d bytes queued)",
+				stats.Memory.Shed, len(wedged), sent, peak)
+		}
+	}
+
+	// The hub must be back under the limit, and readers must still get messages
+	before := make(map[*Client]int64, len(received))
+	for client, count := range received {
+		before[client] = count.Load()
+	}
+	broadcast(rooms * 10)
+	if !testutil.WaitFor(timeout, func() bool { return hub.Stats().Memory.QueuedBytes <= hardLimit }) {
+		t.Errorf("%d bytes still queued after the limit tripped, over the hard limit of %d", hub.Stats().Memory.QueuedBytes, hardLimit)
+	}
+	for client, count := range received {
+		if !testutil.WaitFor(timeout, func() bool { return count.Load() >= before[client]+10 }) {
+			t.Errorf("reader user=%d room=%d got %d of 10 messages after the limit tripped", client.userID, client.roomID, count.Load()-before[client])
+		}
+	}
+
+	// Disconnect everyone; the wedged clients' leftovers are discarded as
+	// their writePump would when the connection fails
+	for _, client := range append(wedged, readers...) {
+		hub.unregister(client)
+	}
+	reading.Wait()
+	for _, client := range wedged {
+		drainFrames(client)
+		if client.closeCode != CloseServerOverloaded {
+			t.Errorf("wedged user=%d room=%d was closed with code %d, not %d", client.userID, client.roomID, client.closeCode, CloseServerOverloaded)
+		}
+	}
+	for _, client := range readers {
+		if client.closeCode != 0 {
+			t.Errorf("reader user=%d room=%d was disconnected with code %d", client.userID, client.roomID, client.closeCode)
+		}
+	}
+
+	if queued := hub.Stats().Memory.QueuedBytes; queued != 0 {
+		t.Errorf("%d bytes still counted as queued after every client disconnected", queued)
+	}
+}
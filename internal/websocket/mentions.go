@@ -0,0 +1,178 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+const (
+	// roomMentionInterval is how often a user may notify a room with @room
+	roomMentionInterval = 10 * time.Minute
+
+	// maxRoomMentionEntries bounds the limiter; expired entries are swept when it's reached
+	maxRoomMentionEntries = 10000
+)
+
+// RoomMentionInterval returns how often a user may notify a room with @room
+func RoomMentionInterval() time.Duration {
+	return roomMentionInterval
+}
+
+// roomMentionKey is one user in one room
+type roomMentionKey struct {
+	roomID, userID int64
+}
+
+// roomMentionLimiter allows each user one @room per room per roomMentionInterval
+// The shards and AllowRoomMention draw on the same allowance, under one mutex
+// It's kept in memory: with several instances, each allows its own
+type roomMentionLimiter struct {
+	mu   sync.Mutex
+	last map[roomMentionKey]time.Time
+	now  func() time.Time // time.Now unless a test sets it
+}
+
+func newRoomMentionLimiter() *roomMentionLimiter {
+	return &roomMentionLimiter{last: make(map[roomMentionKey]time.Time), now: time.Now}
+}
+
+// allow uses the user's @room in a room if they have one left, else returns
+// how long until they do
+func (l *roomMentionLimiter) allow(roomID, userID int64) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	key := roomMentionKey{roomID, userID}
+	if last, ok := l.last[key]; ok && now.Sub(last) < roomMentionInterval {
+		return false, roomMentionInterval - now.Sub(last)
+	}
+
+	if len(l.last) >= maxRoomMentionEntries {
+		for k, last := range l.last {
+			if now.Sub(last) >= roomMentionInterval {
+				delete(l.last, k)
+			}
+		}
+	}
+	l.last[key] = now
+	return true, 0
+}
+
+// refund gives a user back the @room allow used up, for a message that was
+// then never saved
+func (l *roomMentionLimiter) refund(roomID, userID int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.last, roomMentionKey{roomID, userID})
+}
+
+// AllowRoomMention uses a user's @room in a room, the same allowance the
+// WebSocket path uses; when it's used up it returns false and how long until
+// the next one
+// Safe to call from any goroutine
+func (h *Hub) AllowRoomMention(roomID, userID int64) (bool, time.Duration) {
+	return h.roomMentions.allow(roomID, userID)
+}
+
+// RefundRoomMention gives back the @room AllowRoomMention used, for a message
+// that failed to save
+// Safe to call from any goroutine
+func (h *Hub) RefundRoomMention(roomID, userID int64) {
+	h.roomMentions.refund(roomID, userID)
+}
+
+// limitRoomMention applies the @room allowance to a chat message on the
+// shard loop; a message over it is refused with an error frame and
+// limitRoomMention returns false
+// @here isn't limited: it only reaches those already watching the room
+func (s *shard) limitRoomMention(message *Message) bool {
+	if message.RoomMention != content.MentionRoom {
+		return true
+	}
+	ok, wait := s.roomMentions.allow(message.RoomID, message.UserID)
+	if !ok && message.sender != nil {
+		s.deliverToClient(message.sender, &Message{
+			Message: wire.Message{
+				RoomID:  message.RoomID,
+				Content: fmt.Sprintf("you can use @room once every %s in a room; try again in %s", roomMentionInterval, wait.Round(time.Second)),
+				Type:    "error",
+				Code:    "room_mention_rate_limited",
+			},
+		})
+	}
+	return ok
+}
+
+// markMentionAlerts works out which room members mentioned in a chat message
+// should get it with "notify": true, per their websocket_flag/mention preference
+// A room-wide mention (@here or @room, see Message.RoomMention) mentions every
+// member connected to the room; members who aren't get @room through unread
+// mention counts, digests and push instead
+// Clients alert (sound, badge) on flagged frames and just display the rest
+// Runs on the shard loop; with no policy set, nobody is flagged, and silent
+// messages never flag anyone
+func (s *shard) markMentionAlerts(message *Message) {
+	if s.notifications == nil || message.Type != "message" || message.Silent {
+		return
+	}
+	names := content.Mentions(message.Content)
+	if len(names) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, 2*time.Second)
+	defer cancel()
+
+	mentioned := make(map[int64]bool)
+	if message.RoomMention != "" {
+		for client := range s.rooms[message.RoomID] {
+			if !client.readOnly {
+				mentioned[client.userID] = true
+			}
+		}
+	}
+
+	// Only room members can be mentioned; other names are just text
+	members, err := s.store.RoomMembers.FindMembersByUsername(ctx, message.RoomID, names)
+	if err != nil {
+		log.Printf("Failed to resolve mentions in room %d: %v", message.RoomID, err)
+		if len(mentioned) == 0 {
+			return
+		}
+	}
+	for _, userID := range members {
+		mentioned[userID] = true
+	}
+
+	recipients := make([]int64, 0, len(mentioned))
+	for userID := range mentioned {
+		if userID != message.UserID {
+			recipients = append(recipients, userID)
+		}
+	}
+	allowed := s.notifications.Filter(ctx, recipients, store.NotifyChannelWebSocket, store.NotifyEventMention)
+	if len(allowed) == 0 {
+		return
+	}
+
+	message.notifyUsers = make(map[int64]bool, len(allowed))
+	for _, userID := range allowed {
+		message.notifyUsers[userID] = true
+	}
+}
+
+// alertPayload marshals a copy of a message with Notify set
+// Only mentioned users get one, so it's made per client rather than once per room
+func alertPayload(message *Message) ([]byte, error) {
+	alert := *message
+	alert.Notify = true
+	return json.Marshal(&alert)
+}
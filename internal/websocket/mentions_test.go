@@ -0,0 +1,43 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRoomMentionLimiter checks each user gets one @room per room per
+// roomMentionInterval: a second one inside the window is refused with the
+// time left, other rooms and users have their own allowance, the window
+// reopens once it has passed, and a refund gives the allowance back
+func TestRoomMentionLimiter(t *testing.T) {
+	now := time.Date(2026, 3, 14, 9, 0, 0, 0, time.UTC)
+	limiter := newRoomMentionLimiter()
+	limiter.now = func() time.Time { return now }
+
+	for _, step := range []struct {
+		name           string
+		advance        time.Duration
+		roomID, userID int64
+		want           bool
+		wantWait       time.Duration
+	}{
+		{"first", 0, 1, 1, true, 0},
+		{"again at once", 0, 1, 1, false, roomMentionInterval},
+		{"another room", 0, 2, 1, true, 0},
+		{"another user", 0, 1, 2, true, 0},
+		{"just inside the window", roomMentionInterval - time.Second, 1, 1, false, time.Second},
+		{"once it has passed", time.Second, 1, 1, true, 0},
+	} {
+		now = now.Add(step.advance)
+		ok, wait := limiter.allow(step.roomID, step.userID)
+		if ok != step.want || wait != step.wantWait {
+			t.Errorf("%s: allowed %v with %s to wait, want %v and %s", step.name, ok, wait, step.want, step.wantWait)
+		}
+	}
+
+	// A message that failed to save gives its @room back
+	limiter.refund(1, 1)
+	if ok, _ := limiter.allow(1, 1); !ok {
+		t.Error("an @room refunded at once was refused")
+	}
+}
@@ -0,0 +1,449 @@
+package websocket
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// Moderation hooks
+//
+// A room can have a moderation bot (store.ModerationHook) that sees every chat
+// message before anyone else does. The bot answers allow, reject or rewrite;
+// only then is the message filtered, saved and broadcast.
+//
+// Calls never run on a shard loop. A room's messages are queued to one worker
+// of a small pool (room ID modulo the worker count), which asks the bot about
+// them one at a time and hands each back to the shard with the outcome, so
+// they're still delivered in the order they were sent. A bot that doesn't
+// answer within the hook's timeout, or answers nonsense, counts as a failure:
+// the message is let through or rejected as the room chose (fail_open), and
+// after moderationFailureLimit failures in a row the hook is turned off and the
+// room's creator is told.
+
+// Actions a moderation bot can answer with
+const (
+	ModerationAllow   = "allow"   // Deliver the message as it is
+	ModerationReject  = "reject"  // Drop it; only the sender hears about it
+	ModerationRewrite = "rewrite" // Deliver the bot's content instead, flagged "moderated"
+)
+
+// EventModerate names moderation requests, in the body and the X-GoChat-Event header
+const EventModerate = "message.moderate"
+
+const (
+	// moderationWorkers is how many goroutines call moderation bots
+	moderationWorkers = 8
+
+	// moderationQueueSize bounds the messages waiting for each worker; a
+	// message that finds its worker's queue full is refused
+	moderationQueueSize = 256
+
+	// moderationFailureLimit is how many failures in a row turn a hook off
+	moderationFailureLimit = 5
+
+	// moderationCacheTTL is how long a room's hook is trusted before being
+	// reloaded; changes through this instance take effect at once
+	moderationCacheTTL = time.Minute
+)
+
+// ErrModerationBusy means a room's moderation queue is full
+var ErrModerationBusy = errors.New("moderation queue full")
+
+// ModerationRequest is what a moderation bot is asked about a chat message
+type ModerationRequest struct {
+	Event       string    `json:"event"` // Always EventModerate
+	RoomID      int64     `json:"room_id"`
+	UserID      int64     `json:"user_id"`
+	Username    string    `json:"username"`
+	Content     string    `json:"content"`
+	ContentType string    `json:"content_type"`
+	Language    string    `json:"language,omitempty"`
+	SentAt      time.Time `json:"sent_at"`
+}
+
+// ModerationDecision is a moderation bot's answer
+type ModerationDecision struct {
+	Action  string `json:"action"`            // ModerationAllow, ModerationReject or ModerationRewrite
+	Content string `json:"content,omitempty"` // The replacement, for ModerationRewrite
+	Reason  string `json:"reason,omitempty"`  // Passed on to the sender of a rejected message
+}
+
+// ModerationCaller asks a room's moderation bot about a message
+// internal/webhook implements it over HTTP with signed requests; the context
+// carries the hook's timeout
+type ModerationCaller interface {
+	Moderate(ctx context.Context, hook *store.ModerationHook, req *ModerationRequest) (*ModerationDecision, error)
+}
+
+// ModerationOutcome is what became of a chat message at its room's moderation hook
+type ModerationOutcome struct {
+	Action  string // ModerationAllow, ModerationReject or ModerationRewrite
+	Content string // The rewritten content, for ModerationRewrite
+	Reason  string // The bot's reason, for ModerationReject
+
+	// Failed is set when the bot didn't answer properly in time; Action is
+	// then allow or reject, as the room's fail_open setting says
+	Failed bool
+}
+
+// moderationJob is one message waiting for a moderation worker
+type moderationJob struct {
+	request *ModerationRequest
+	policy  content.LengthPolicy // Limits a rewritten message is held to
+	done    func(*ModerationOutcome)
+}
+
+// moderationHooks calls rooms' moderation bots on a worker pool
+type moderationHooks struct {
+	caller ModerationCaller
+	store  store.Storage
+	ctx    context.Context // The hub's
+	queues []chan moderationJob
+
+	// disabled is called after a hook was turned off for failing, off the worker
+	disabled func(roomID int64, reason string)
+
+	// Rooms' hooks, nil for rooms without one
+	hooks *roomCache[*store.ModerationHook]
+
+	mu       sync.Mutex
+	failures map[int64]int // Failures in a row per room
+}
+
+// newModerationHooks creates the pool and starts its workers
+func newModerationHooks(ctx context.Context, caller ModerationCaller, st store.Storage, disabled func(int64, string)) *moderationHooks {
+	m := &moderationHooks{
+		caller:   caller,
+		store:    st,
+		ctx:      ctx,
+		queues:   make([]chan moderationJob, moderationWorkers),
+		disabled: disabled,
+		hooks:    newRoomCache(moderationCacheTTL, loadModerationHook),
+		failures: make(map[int64]int),
+	}
+	for i := range m.queues {
+		m.queues[i] = make(chan moderationJob, moderationQueueSize)
+		go m.worker(m.queues[i])
+	}
+	return m
+}
+
+// hook returns a room's hook, loading it if it isn't cached or is stale
+// Returns nil for rooms without one. Lookup failures count as no hook: like
+// quiet hours, a database hiccup shouldn't hold up a room's messages
+func (m *moderationHooks) hook(ctx context.Context, roomID int64) *store.ModerationHook {
+	hook, err := m.hooks.get(ctx, m.store, roomID)
+	if err != nil {
+		log.Printf("Failed to load moderation hook for room %d: %v", roomID, err)
+		return nil
+	}
+	return hook
+}
+
+// loadModerationHook loads a room's hook into the cache, nil if it has none
+func loadModerationHook(ctx context.Context, st store.Storage, roomID int64) (*store.ModerationHook, error) {
+	hook, err := st.ModerationHooks.Get(ctx, roomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return hook, err
+}
+
+// active reports whether a room's messages go to its moderation bot
+func (m *moderationHooks) active(ctx context.Context, roomID int64) bool {
+	hook := m.hook(ctx, roomID)
+	return hook != nil && hook.Enabled
+}
+
+// forget drops a room's cached hook and its failure count
+func (m *moderationHooks) forget(roomID int64) {
+	m.hooks.forget(roomID)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.failures, roomID)
+}
+
+// submit queues a message for the room's worker without blocking
+// Returns false if that worker's queue is full
+func (m *moderationHooks) submit(roomID int64, job moderationJob) bool {
+	index := roomID % int64(len(m.queues))
+	if index < 0 {
+		index = -index
+	}
+
+	select {
+	case m.queues[index] <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// worker handles one queue's messages in order until the process exits
+func (m *moderationHooks) worker(queue chan moderationJob) {
+	for job := range queue {
+		job.done(m.check(job))
+	}
+}
+
+// check asks the room's bot about one message
+// Rooms whose hook was removed or turned off while the message waited let it through
+func (m *moderationHooks) check(job moderationJob) *ModerationOutcome {
+	roomID := job.request.RoomID
+	ctx, cancel := context.WithTimeout(m.ctx, 5*time.Second)
+	defer cancel()
+
+	hook := m.hook(ctx, roomID)
+	if hook == nil || !hook.Enabled {
+		return &ModerationOutcome{Action: ModerationAllow}
+	}
+
+	callCtx, cancelCall := context.WithTimeout(ctx, hook.Timeout)
+	decision, err := m.caller.Moderate(callCtx, hook, job.request)
+	cancelCall()
+	if err == nil {
+		err = validateDecision(decision, job.request, job.policy)
+	}
+	if err != nil {
+		m.failed(hook, err)
+		if hook.FailOpen {
+			return &ModerationOutcome{Action: ModerationAllow, Failed: true}
+		}
+		return &ModerationOutcome{Action: ModerationReject, Failed: true}
+	}
+
+	m.mu.Lock()
+	delete(m.failures, roomID)
+	m.mu.Unlock()
+
+	outcome := &ModerationOutcome{Action: decision.Action}
+	switch decision.Action {
+	case ModerationReject:
+		outcome.Reason = decision.Reason
+	case ModerationRewrite:
+		outcome.Content = decision.Content
+	}
+	return outcome
+}
+
+// validateDecision checks a bot's answer makes sense
+// A rewrite is held to the same rules as the original message, so a bot
+// can't post what its users couldn't
+func validateDecision(decision *ModerationDecision, req *ModerationRequest, policy content.LengthPolicy) error {
+	switch decision.Action {
+	case ModerationAllow, ModerationReject:
+		return nil
+	case ModerationRewrite:
+		policy.Oversize = content.OversizeReject
+		_, err := content.Validate(content.Formatted{
+			Type:     req.ContentType,
+			Language: req.Language,
+			Body:     decision.Content,
+		}, policy)
+		if err != nil {
+			return fmt.Errorf("invalid rewrite: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown action %q", decision.Action)
+}
+
+// failed counts a failure of a room's bot and turns the hook off at the limit
+// The cached hook is switched off right away, so messages already queued
+// don't wait on the bot again
+func (m *moderationHooks) failed(hook *store.ModerationHook, err error) {
+	log.Printf("Moderation hook of room %d failed: %v", hook.RoomID, err)
+
+	m.mu.Lock()
+	m.failures[hook.RoomID]++
+	tripped := m.failures[hook.RoomID] >= moderationFailureLimit
+	if tripped {
+		delete(m.failures, hook.RoomID)
+		off := *hook
+		off.Enabled = false
+		m.hooks.set(hook.RoomID, &off)
+	}
+	m.mu.Unlock()
+
+	if !tripped {
+		return
+	}
+
+	reason := fmt.Sprintf("turned off after %d failures in a row; last error: %v", moderationFailureLimit, err)
+	log.Printf("Moderation hook of room %d %s", hook.RoomID, reason)
+	go func() {
+		ctx, cancel := context.WithTimeout(m.ctx, 5*time.Second)
+		defer cancel()
+		if err := m.store.ModerationHooks.Disable(ctx, hook.RoomID, reason); err != nil {
+			log.Printf("Failed to save disabled moderation hook of room %d: %v", hook.RoomID, err)
+		}
+		m.disabled(hook.RoomID, reason)
+	}()
+}
+
+// newModerationRequest describes a chat message for its room's bot
+func newModerationRequest(roomID, userID int64, username, text, contentType, language string) *ModerationRequest {
+	if contentType == "" {
+		contentType = content.TypeText
+	}
+	return &ModerationRequest{
+		Event:       EventModerate,
+		RoomID:      roomID,
+		UserID:      userID,
+		Username:    username,
+		Content:     text,
+		ContentType: contentType,
+		Language:    language,
+		SentAt:      time.Now().UTC(),
+	}
+}
+
+// SetModerationCaller turns moderation hooks on, calling bots through caller
+// Without one rooms' hooks are ignored
+// Must be called before Run
+func (h *Hub) SetModerationCaller(caller ModerationCaller) {
+	h.moderation = newModerationHooks(h.ctx, caller, h.store, h.moderationHookDisabled)
+	for _, s := range h.shards {
+		s.moderation = h.moderation
+	}
+}
+
+// InvalidateModerationHook makes the hub reload a room's hook on the next
+// message and forgets its failures
+// Call it after changing or removing the hook
+// Safe to call from any goroutine
+func (h *Hub) InvalidateModerationHook(roomID int64) {
+	if h.moderation != nil {
+		h.moderation.forget(roomID)
+	}
+}
+
+// ModerateWithHook runs a chat message past its room's moderation bot, for
+// the REST send path; rooms without an enabled hook get ModerationAllow at once
+// The message waits behind the room's earlier ones like a WebSocket message
+// would. Returns ErrModerationBusy if the room's queue is full, or the
+// context's error if it ends first
+// Safe to call from any goroutine
+func (h *Hub) ModerateWithHook(ctx context.Context, message *store.Message) (*ModerationOutcome, error) {
+	if h.moderation == nil || !h.moderation.active(ctx, message.RoomID) {
+		return &ModerationOutcome{Action: ModerationAllow}, nil
+	}
+
+	result := make(chan *ModerationOutcome, 1)
+	job := moderationJob{
+		request: newModerationRequest(message.RoomID, message.UserID, message.Username, message.Content, message.ContentType, message.Language),
+		policy:  h.LengthPolicy(),
+		done:    func(outcome *ModerationOutcome) { result <- outcome },
+	}
+	if !h.moderation.submit(message.RoomID, job) {
+		return nil, ErrModerationBusy
+	}
+
+	select {
+	case outcome := <-result:
+		return outcome, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// moderationHookDisabled tells a room's creator that its hook was turned off
+func (h *Hub) moderationHookDisabled(roomID int64, reason string) {
+	ctx, cancel := context.WithTimeout(h.ctx, 5*time.Second)
+	defer cancel()
+
+	room, err := h.store.Rooms.GetByID(ctx, roomID)
+	if err != nil {
+		log.Printf("Failed to look up room %d to report its disabled moderation hook: %v", roomID, err)
+		return
+	}
+	h.SendToUser(room.CreatedBy, &Message{
+		Message: wire.Message{
+			RoomID:  roomID,
+			Content: "the moderation hook of " + room.Name + " was " + reason,
+			Type:    "moderation_hook_disabled",
+		},
+	})
+}
+
+// sendToModerationHook hands a chat message to its room's bot, on the shard loop
+// Returns false if the room has no hook and the message should carry on; true
+// if the message left the loop, to come back through broadcast with its
+// outcome, or was refused because the room's queue is full
+// Rooms with messages still at the bot keep queueing, even if the hook was
+// just removed, so a later message can't overtake them
+func (s *shard) sendToModerationHook(ctx context.Context, message *Message) bool {
+	if s.moderation == nil {
+		return false
+	}
+	if s.moderationPending[message.RoomID] == 0 && !s.moderation.active(ctx, message.RoomID) {
+		return false
+	}
+
+	job := moderationJob{
+		request: newModerationRequest(message.RoomID, message.UserID, message.Username, message.Content, message.ContentType, message.Language),
+		policy:  s.tuning.Load().Lengths,
+		done: func(outcome *ModerationOutcome) {
+			message.moderation = outcome
+			s.broadcast <- message
+		},
+	}
+	if !s.moderation.submit(message.RoomID, job) {
+		if message.sender != nil {
+			s.deliverToClient(message.sender, &Message{
+				Message: wire.Message{
+					RoomID:  message.RoomID,
+					Content: "the room's moderation bot is busy; try again shortly",
+					Type:    "error",
+					Code:    "moderation_busy",
+				},
+			})
+		}
+		return true
+	}
+	s.moderationPending[message.RoomID]++
+	return true
+}
+
+// applyModerationOutcome acts on a message back from its room's bot, on the shard loop
+// A rewrite replaces the content; a rejected message is reported to its
+// sender and applyModerationOutcome returns false
+func (s *shard) applyModerationOutcome(message *Message) bool {
+	if s.moderationPending[message.RoomID]--; s.moderationPending[message.RoomID] <= 0 {
+		delete(s.moderationPending, message.RoomID)
+	}
+
+	outcome := message.moderation
+	switch outcome.Action {
+	case ModerationReject:
+		if message.sender != nil {
+			reply := &Message{Message: wire.Message{RoomID: message.RoomID, Type: "error"}}
+			switch {
+			case outcome.Failed:
+				reply.Content = "the room's moderation bot is unavailable; try again later"
+				reply.Code = "moderation_unavailable"
+			case outcome.Reason != "":
+				reply.Content = "message rejected by the room's moderation bot: " + outcome.Reason
+				reply.Code = "moderation_rejected"
+			default:
+				reply.Content = "message rejected by the room's moderation bot"
+				reply.Code = "moderation_rejected"
+			}
+			s.deliverToClient(message.sender, reply)
+		}
+		return false
+	case ModerationRewrite:
+		message.Content = outcome.Content
+		message.Moderated = true
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// moderationBot answers moderation requests with a function of the request
+type moderationBot func(*ModerationRequest) (*ModerationDecision, error)
+
+func (bot moderationBot) Moderate(_ context.Context, _ *store.ModerationHook, req *ModerationRequest) (*ModerationDecision, error) {
+	return bot(req)
+}
+
+// newModeratedHub starts a one-shard hub whose room 1 has an enabled
+// moderation hook answered by bot, with user 1 as the room's creator
+func newModeratedHub(t *testing.T, bot moderationBot, failOpen bool) (*Hub, *memoryMessages, *memoryModerationHooks) {
+	t.Helper()
+	messages := newMemoryMessages()
+	hooks := &memoryModerationHooks{hooks: map[int64]*store.ModerationHook{
+		1: {RoomID: 1, URL: "https://bot.example.com/", Timeout: time.Second, FailOpen: failOpen, Enabled: true},
+	}}
+	hub := NewHub(store.Storage{
+		Messages:        messages,
+		Rooms:           roomSettings{unfiltered: map[int64]bool{1: true}},
+		Receipts:        &memoryReceipts{},
+		ModerationHooks: hooks,
+	}, 1)
+	hub.SetModerationCaller(bot)
+	hub.SetRoomStatsInterval(0)
+	go hub.Run()
+	return hub, messages, hooks
+}
+
+// TestModerationHookOutcomes sends messages through a room's bot: a slow
+// answer holds back the next message rather than letting it overtake, a
+// rejection reaches its sender alone with the bot's reason, and a rewrite is
+// delivered and saved flagged as moderated
+func TestModerationHookOutcomes(t *testing.T) {
+	hub, messages, _ := newModeratedHub(t, func(req *ModerationRequest) (*ModerationDecision, error) {
+		switch req.Content {
+		case "first":
+			time.Sleep(100 * time.Millisecond)
+		case "spam":
+			return &ModerationDecision{Action: ModerationReject, Reason: "no spam"}, nil
+		case "darn":
+			return &ModerationDecision{Action: ModerationRewrite, Content: "d**n"}, nil
+		}
+		return &ModerationDecision{Action: ModerationAllow}, nil
+	}, true)
+
+	sender := newTestClient(hub, 2, 1, 64)
+	reader := newTestClient(hub, 3, 1, 64)
+	hub.register(sender)
+	hub.register(reader)
+	for _, text := range []string{"first", "second", "spam", "darn"} {
+		hub.broadcast(&Message{Message: wire.Message{RoomID: 1, UserID: 2, Username: "grace", Type: "message", Content: text}, sender: sender})
+	}
+
+	for _, want := range []string{"first", "second", "d**n"} {
+		frame := nextFrame(reader, "message", 2*time.Second)
+		if frame == nil || frame.Content != want {
+			t.Fatalf("the reader got %+v, want %q", frame, want)
+		}
+		if frame.Moderated != (want == "d**n") {
+			t.Errorf("%q arrived with moderated %v", want, frame.Moderated)
+		}
+	}
+	if frame := nextFrame(sender, "error", time.Second); frame == nil || frame.Code != "moderation_rejected" || !strings.Contains(frame.Content, "no spam") {
+		t.Errorf("the sender got %+v, want moderation_rejected with the bot's reason", frame)
+	}
+
+	saved := messages.saved(1)
+	if len(saved) != 3 || saved[2].Content != "d**n" || !saved[2].Moderated || saved[0].Moderated {
+		t.Errorf("saved %d messages, want first, second and the moderated rewrite", len(saved))
+	}
+}
+
+// TestModerationHookFailures breaks a room's bot with the room failing
+// closed: each message is refused as unavailable until the fifth failure in
+// a row turns the hook off and tells the room's creator, after which
+// messages go through without asking the bot
+func TestModerationHookFailures(t *testing.T) {
+	calls := make(chan struct{}, 16)
+	hub, messages, hooks := newModeratedHub(t, func(*ModerationRequest) (*ModerationDecision, error) {
+		calls <- struct{}{}
+		return nil, errors.New("bot is down")
+	}, false)
+
+	creator := newTestClient(hub, 1, 1, 64)
+	sender := newTestClient(hub, 2, 1, 64)
+	hub.register(creator)
+	hub.register(sender)
+	send := func(text string) {
+		hub.broadcast(&Message{Message: wire.Message{RoomID: 1, UserID: 2, Username: "grace", Type: "message", Content: text}, sender: sender})
+	}
+
+	for i := 0; i < moderationFailureLimit; i++ {
+		send("hello")
+		if frame := nextFrame(sender, "error", time.Second); frame == nil || frame.Code != "moderation_unavailable" {
+			t.Fatalf("message %d got %+v, want moderation_unavailable", i+1, frame)
+		}
+	}
+	if frame := nextFrame(creator, "moderation_hook_disabled", time.Second); frame == nil || !strings.Contains(frame.Content, "5 failures in a row") {
+		t.Errorf("the creator got %+v, want moderation_hook_disabled", frame)
+	}
+	if hook, _ := hooks.Get(context.Background(), 1); hook.Enabled || hook.DisabledReason == "" {
+		t.Errorf("the saved hook is %+v, want it disabled with a reason", hook)
+	}
+
+	send("back again")
+	if frame := nextFrame(creator, "message", time.Second); frame == nil || frame.Content != "back again" {
+		t.Errorf("after the hook was turned off the creator got %+v", frame)
+	}
+	if len(calls) != moderationFailureLimit || len(messages.saved(1)) != 1 {
+		t.Errorf("the bot was called %d times and %d messages saved, want %d and 1", len(calls), len(messages.saved(1)), moderationFailureLimit)
+	}
+}
@@ -0,0 +1,94 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// blocklist is a content filter that rejects messages containing "spam"
+// and masks "darn"
+type blocklist struct{}
+
+func (blocklist) Check(_ context.Context, message string) (content.Verdict, string, error) {
+	switch {
+	case strings.Contains(message, "spam"):
+		return content.VerdictReject, "", nil
+	case strings.Contains(message, "darn"):
+		return content.VerdictMask, strings.ReplaceAll(message, "darn", "****"), nil
+	}
+	return content.VerdictAllow, message, nil
+}
+
+// TestContentFilter sends messages through a hub with a content filter: a
+// rejected one is neither saved nor broadcast and its sender alone is told,
+// a masked one is saved and broadcast masked and flagged, and rooms that
+// turned filtering off get messages as sent
+func TestContentFilter(t *testing.T) {
+	messages := newMemoryMessages()
+	hub := NewHub(store.Storage{
+		Messages: messages,
+		Rooms:    roomSettings{unfiltered: map[int64]bool{2: true}},
+		Receipts: &memoryReceipts{},
+	}, 1)
+	hub.SetContentFilter(blocklist{})
+	hub.SetRoomStatsInterval(0)
+	go hub.Run()
+
+	sender := newTestClient(hub, 1, 1, 64)
+	reader := newTestClient(hub, 2, 1, 64)
+	unfiltered := newTestClient(hub, 1, 2, 64)
+	for _, client := range []*Client{sender, reader, unfiltered} {
+		hub.register(client)
+	}
+
+	for _, m := range []*Message{
+		{Message: wire.Message{RoomID: 1, UserID: 1, Type: "message", Content: "buy spam"}, sender: sender},
+		{Message: wire.Message{RoomID: 1, UserID: 1, Type: "message", Content: "well darn"}, sender: sender},
+		{Message: wire.Message{RoomID: 2, UserID: 1, Type: "message", Content: "darn spam"}, sender: unfiltered},
+		// Sent last so that once it arrives, everything before it has been handled
+		{Message: wire.Message{RoomID: 1, UserID: 1, Type: "message", Content: "done"}, sender: sender},
+	} {
+		hub.broadcast(m)
+	}
+	if !testutil.WaitFor(5*time.Second, func() bool { return len(messages.saved(1)) == 2 }) {
+		t.Fatalf("room 1 saved %d messages, want 2", len(messages.saved(1)))
+	}
+	// The last one is saved before it's broadcast; wait for the loop to finish it
+	hub.shards[0].do(func() {})
+
+	if saved := messages.saved(1); saved[0].Content != "well ****" || !saved[0].Filtered {
+		t.Errorf("saved %+v, want the masked message, flagged", saved[0])
+	}
+	if saved := messages.saved(2); len(saved) != 1 || saved[0].Content != "darn spam" || saved[0].Filtered {
+		t.Errorf("the unfiltered room saved %+v, want the message as sent", saved)
+	}
+
+	chat := func(client *Client) (frames []Message) {
+		for len(client.send) > 0 {
+			var frame Message
+			if err := json.Unmarshal(<-client.send, &frame); err != nil {
+				t.Fatal(err)
+			}
+			if frame.Type == "message" || frame.Type == "error" {
+				frames = append(frames, frame)
+			}
+		}
+		return frames
+	}
+	got := chat(reader)
+	if len(got) != 2 || got[0].Content != "well ****" || !got[0].Filtered || got[1].Content != "done" {
+		t.Errorf("the room got %+v, want the masked message, flagged, then done", got)
+	}
+	got = chat(sender)
+	if len(got) != 3 || got[0].Code != "content_rejected" || got[1].Content != "well ****" {
+		t.Errorf("the sender got %+v, want the rejection, then the room's messages", got)
+	}
+}
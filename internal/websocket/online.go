@@ -0,0 +1,48 @@
+package websocket
+
+import "sync"
+
+// onlineIndex counts each user's open member connections across all shards
+// Shards update it as clients come and go; anyone can read it without a
+// round trip through the shard loops, which matters for per-message checks
+// like "should this user get a push notification?"
+type onlineIndex struct {
+	mu     sync.RWMutex
+	counts map[int64]int // userID -> open connections
+}
+
+func newOnlineIndex() *onlineIndex {
+	return &onlineIndex{counts: make(map[int64]int)}
+}
+
+// add records a new connection for a user
+func (o *onlineIndex) add(userID int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.counts[userID]++
+}
+
+// remove records a closed connection for a user
+func (o *onlineIndex) remove(userID int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.counts[userID] <= 1 {
+		delete(o.counts, userID)
+		return
+	}
+	o.counts[userID]--
+}
+
+// online reports whether a user has at least one open connection
+func (o *onlineIndex) online(userID int64) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.counts[userID] > 0
+}
+
+// IsUserOnline reports whether a user has an open connection to any room
+// Guests aren't tracked, since they have no user ID
+// Safe to call from any goroutine
+func (h *Hub) IsUserOnline(userID int64) bool {
+	return h.online.online(userID)
+}
@@ -0,0 +1,41 @@
+package websocket
+
+import "testing"
+
+// TestIsUserOnline connects a user to two rooms on different shards: they
+// stay online until their last connection closes, and a guest connection
+// never makes anyone online
+func TestIsUserOnline(t *testing.T) {
+	hub := newTestHub(2)
+	go hub.Run()
+
+	// Rooms 1 and 2 land on different shards
+	first := newTestClient(hub, 7, 1, 64)
+	second := newTestClient(hub, 7, 2, 64)
+	guest := newTestClient(hub, 8, 1, 64)
+	guest.readOnly = true
+	for _, client := range []*Client{first, second, guest} {
+		go drainFrames(client)
+		hub.register(client)
+	}
+	settle := func() {
+		for _, s := range hub.shards {
+			s.do(func() {})
+		}
+	}
+	settle()
+
+	if !hub.IsUserOnline(7) || hub.IsUserOnline(8) {
+		t.Errorf("online: user 7 %v, guest %v; want true, false", hub.IsUserOnline(7), hub.IsUserOnline(8))
+	}
+	hub.unregister(first)
+	settle()
+	if !hub.IsUserOnline(7) {
+		t.Error("user 7 went offline with a connection left")
+	}
+	hub.unregister(second)
+	settle()
+	if hub.IsUserOnline(7) {
+		t.Error("user 7 is online with no connections")
+	}
+}
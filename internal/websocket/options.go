@@ -0,0 +1,85 @@
+package websocket
+
+import "github.com/drazan344/go-chat/pkg/wire"
+
+// Connection options
+//
+// Bots and bridges (an IRC bridge, say) post messages and would otherwise get
+// each one straight back, which every one of them has to guard against to
+// avoid echo loops. A connection can opt out of its own echoes with
+// ?suppress_echo=true on the ws URL or, live, with
+//
+//	{"type": "set_options", "suppress_echo": true}
+//
+// (acked with an "options_updated" frame). The hub then doesn't deliver the
+// connection's own chat messages back to it; it gets a "message_ack" frame
+// with the saved message's id and created_at instead. Other connections of
+// the same user still get the message, so multi-device sync keeps working
+//
+// Connections authenticated with an API token may also send
+// {"content": "...", "silent": true}: the message is saved and delivered as
+// usual but marked "silent", never flagged "notify" and never pushed, so
+// automated posts don't ping anyone
+
+// SetSuppressEcho sets whether the client gets its own chat messages back
+// Must be called before Start; afterwards the client changes it with set_options
+func (c *Client) SetSuppressEcho(enabled bool) {
+	c.suppressEcho = enabled
+}
+
+// AllowSilent lets the client send silent messages
+// Meant for bots and integrations, i.e. connections made with an API token
+// Must be called before Start
+func (c *Client) AllowSilent() {
+	c.silentAllowed = true
+}
+
+// AllowMentionEveryone lets the client's @here and @room notify the room
+// Used when the user's role in the room has mention_everyone; like
+// DenyPosting, a permission change applies on reconnect
+// Must be called before Start
+func (c *Client) AllowMentionEveryone() {
+	c.mentionEveryone = true
+}
+
+// changeOptions handles a {"type":"set_options",...} control frame
+// Options left out of the frame keep their current value
+func (c *Client) changeOptions(in inboundFrame) {
+	if in.SuppressEcho == nil {
+		c.sendError("invalid_options", "set_options needs at least one option: suppress_echo")
+		return
+	}
+	suppressEcho := *in.SuppressEcho
+
+	s := c.hub.shardFor(c.roomID)
+	s.post(func() {
+		c.suppressEcho = suppressEcho
+		s.deliverToClient(c, &Message{
+			Message: wire.Message{
+				RoomID:       c.roomID,
+				Content:      "connection options updated",
+				Type:         "options_updated",
+				SuppressEcho: &suppressEcho,
+			},
+		})
+	})
+}
+
+// echoAck is what a client that suppresses echoes gets instead of its own
+// chat message: enough to match the message up with the one it sent
+// Returns nil for messages that weren't saved, since there's nothing to ack
+func echoAck(message *Message) *Message {
+	if !message.persisted {
+		return nil
+	}
+	return &Message{
+		Message: wire.Message{
+			ID:        message.ID,
+			RoomID:    message.RoomID,
+			UserID:    message.UserID,
+			Username:  message.Username,
+			Type:      "message_ack",
+			CreatedAt: message.CreatedAt,
+		},
+	}
+}
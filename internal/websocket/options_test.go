@@ -0,0 +1,109 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+	"github.com/gorilla/websocket"
+)
+
+// send writes a frame to a test connection
+func send(t *testing.T, conn *websocket.Conn, frame map[string]any) {
+	t.Helper()
+	if err := conn.WriteJSON(frame); err != nil {
+		t.Fatalf("sending %v: %v", frame, err)
+	}
+}
+
+// chatFrames returns the chat messages among frames
+func chatFrames(frames []*Message) []*Message {
+	var chat []*Message
+	for _, frame := range frames {
+		if frame.Type == "message" {
+			chat = append(chat, frame)
+		}
+	}
+	return chat
+}
+
+// TestSuppressEchoAndSilent connects a bridge that suppresses its echoes and
+// may send silent messages, another connection of the same user and a second
+// user over real WebSockets: the bridge gets a message_ack for its own
+// message instead of the message, the others get it marked silent, turning
+// suppression off with set_options brings the echoes back, and a user
+// without a token can't send silent messages
+func TestSuppressEchoAndSilent(t *testing.T) {
+	messages := newMemoryMessages()
+	hub := NewHub(store.Storage{Messages: messages, Rooms: roomSettings{}, Receipts: &memoryReceipts{}}, 1)
+	hub.SetRoomStatsInterval(0)
+	go hub.Run()
+
+	bridge := dialTestHub(t, hub, 1, 1, func(c *Client) {
+		c.SetSuppressEcho(true)
+		c.AllowSilent()
+	})
+	phone := dialTestHub(t, hub, 1, 1)
+	reader := dialTestHub(t, hub, 2, 1)
+	if !testutil.WaitFor(2*time.Second, func() bool { return hub.GetRoomClientCount(1) == 3 }) {
+		t.Fatal("the connections never registered")
+	}
+
+	send(t, bridge, map[string]any{"content": "relayed", "silent": true})
+	frames := framesUntil(t, bridge, "message_ack")
+	if chat := chatFrames(frames); len(chat) != 0 {
+		t.Errorf("the bridge got its own message back: %q", chat[0].Content)
+	}
+	ack := frames[len(frames)-1]
+	saved := messages.saved(1)
+	if len(saved) != 1 || ack.ID != saved[0].ID || ack.CreatedAt == nil {
+		t.Errorf("the ack is for message %d at %v, want the one saved message", ack.ID, ack.CreatedAt)
+	}
+	for name, conn := range map[string]*websocket.Conn{"the same user's other connection": phone, "the other user": reader} {
+		frames := framesUntil(t, conn, "message")
+		if got := frames[len(frames)-1]; got.Content != "relayed" || !got.Silent || got.ID != ack.ID {
+			t.Errorf("%s got message %d %q silent=%t, want %d \"relayed\" silent", name, got.ID, got.Content, got.Silent, ack.ID)
+		}
+	}
+
+	// Other people's messages still reach the bridge
+	send(t, reader, map[string]any{"content": "to the bridge"})
+	if frames := framesUntil(t, bridge, "message"); frames[len(frames)-1].Content != "to the bridge" {
+		t.Errorf("the bridge got %q, want \"to the bridge\"", frames[len(frames)-1].Content)
+	}
+	framesUntil(t, reader, "message")
+	framesUntil(t, phone, "message")
+
+	// Turning suppression off brings echoes back
+	send(t, bridge, map[string]any{"type": "set_options", "suppress_echo": false})
+	frames = framesUntil(t, bridge, "options_updated")
+	if updated := frames[len(frames)-1]; updated.SuppressEcho == nil || *updated.SuppressEcho {
+		t.Errorf("options_updated has suppress_echo %v, want false", updated.SuppressEcho)
+	}
+	send(t, bridge, map[string]any{"content": "echoed"})
+	frames = framesUntil(t, bridge, "message")
+	if got := frames[len(frames)-1]; got.Content != "echoed" || got.Silent {
+		t.Errorf("the bridge got %q silent=%t back, want \"echoed\" not silent", got.Content, got.Silent)
+	}
+	framesUntil(t, reader, "message")
+	framesUntil(t, phone, "message")
+
+	send(t, bridge, map[string]any{"type": "set_options"})
+	frames = framesUntil(t, bridge, "error")
+	if code := frames[len(frames)-1].Code; code != "invalid_options" {
+		t.Errorf("set_options without options got %s, want invalid_options", code)
+	}
+
+	// Only token connections may send silent messages; the refused one goes nowhere
+	send(t, reader, map[string]any{"content": "hush", "silent": true})
+	frames = framesUntil(t, reader, "error")
+	if code := frames[len(frames)-1].Code; code != "silent_not_allowed" {
+		t.Errorf("a silent message without a token got %s, want silent_not_allowed", code)
+	}
+	send(t, reader, map[string]any{"content": "after"})
+	frames = framesUntil(t, phone, "message")
+	if chat := chatFrames(frames); len(chat) != 1 || chat[0].Content != "after" {
+		t.Errorf("after the refused message the room got %d messages, want only \"after\"", len(chat))
+	}
+}
@@ -0,0 +1,155 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testutil"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// TestRoomOrdering drives a sharded hub with concurrent producers and checks
+// that every client sees its room's frames in strictly increasing audit
+// sequence, with no gaps or duplicates, and receives every message
+func TestRoomOrdering(t *testing.T) {
+	const (
+		shards    = 4
+		rooms     = 8
+		clientsN  = 5 // Fake clients per room
+		producers = 8
+		messages  = 500 // Messages per producer, spread round-robin over the rooms
+		timeout   = 30 * time.Second
+	)
+
+	// Room stats need member counts, which the test hub has no store for
+	// Throttling is off too: it drops joins on purpose, which would show as gaps
+	hub := newTestHub(shards)
+	hub.SetSequenceAudit(true)
+	hub.SetRoomStatsInterval(0)
+	tunables := DefaultTunables()
+	tunables.BandwidthBudget = 0
+	hub.SetTunables(tunables)
+	go hub.Run()
+
+	// Each room gets messages*producers/rooms messages, rounded up; joins come on top
+	perRoom := make(map[int64]int)
+	for p := 0; p < producers; p++ {
+		for i := 0; i < messages; i++ {
+			perRoom[int64((p+i)%rooms+1)]++
+		}
+	}
+
+	var recorders sync.WaitGroup
+	clients := make([]*Client, 0, rooms*clientsN)
+	for roomID := int64(1); roomID <= rooms; roomID++ {
+		for k := int64(1); k <= clientsN; k++ {
+			client := newTestClient(hub, k, roomID, perRoom[roomID]+clientsN+16)
+			clients = append(clients, client)
+
+			recorders.Add(1)
+			go func() {
+				defer recorders.Done()
+				if got := recordOrdering(t, client); got != perRoom[roomID] {
+					t.Errorf("user=%d room=%d received %d of %d messages", client.userID, roomID, got, perRoom[roomID])
+				}
+			}()
+
+			hub.register(client)
+		}
+	}
+
+	var producing sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		producing.Add(1)
+		go func() {
+			defer producing.Done()
+			for i := 0; i < messages; i++ {
+				hub.broadcast(&Message{
+					Message: wire.Message{
+						RoomID:   int64((p+i)%rooms + 1),
+						UserID:   int64(1000 + p),
+						Username: fmt.Sprintf("producer%d", p),
+						Content:  fmt.Sprintf("%d/%d", p, i),
+						Type:     "message",
+					},
+				})
+			}
+		}()
+	}
+	producing.Wait()
+
+	// Wait until every message has been fanned out, then disconnect everyone,
+	// which closes the send channels and lets the recorders finish
+	testutil.WaitFor(timeout, func() bool {
+		return hub.Stats().Audit.Frames >= int64(len(clients)+producers*messages)
+	})
+	for _, client := range clients {
+		hub.unregister(client)
+	}
+	recorders.Wait()
+
+	if audit := hub.Stats().Audit; audit.OutOfOrder != 0 || audit.Gaps != 0 {
+		t.Errorf("the hub's audit counted %d frames out of order and %d gaps", audit.OutOfOrder, audit.Gaps)
+	}
+}
+
+// recordOrdering drains a fake client's send channel until it's closed
+// Every stamped frame must be exactly one more than the one before it
+// Returns how many chat messages arrived
+func recordOrdering(t *testing.T, client *Client) (messages int) {
+	var last int64
+	for frame := range client.send {
+		var message Message
+		if err := json.Unmarshal(frame, &message); err != nil {
+			t.Errorf("user=%d room=%d got an undecodable frame: %v", client.userID, client.roomID, err)
+			continue
+		}
+		if message.Type == "message" {
+			messages++
+		}
+
+		switch {
+		case message.AuditSeq == 0:
+			t.Errorf("user=%d room=%d got a %q frame without a sequence", client.userID, client.roomID, message.Type)
+		case last != 0 && message.AuditSeq <= last:
+			t.Errorf("user=%d room=%d got seq %d after %d (duplicate or out of order)", client.userID, client.roomID, message.AuditSeq, last)
+		case last != 0 && message.AuditSeq != last+1:
+			t.Errorf("user=%d room=%d got seq %d after %d (gap)", client.userID, client.roomID, message.AuditSeq, last)
+		}
+		if message.AuditSeq > last {
+			last = message.AuditSeq
+		}
+	}
+	return messages
+}
+
+// TestSequenceAuditCounts feeds the audit a skipped and a repeated frame:
+// each is counted once, and a client joining later starts from the room's
+// current sequence
+func TestSequenceAuditCounts(t *testing.T) {
+	audit := newSequenceAudit()
+	early := &Client{userID: 1, roomID: 7}
+	audit.start(early)
+
+	audit.observe(early, audit.stamp(7)) // 1
+	audit.stamp(7)                       // 2 never reaches early
+	three := audit.stamp(7)
+	audit.observe(early, three)
+	audit.observe(early, three)
+
+	late := &Client{userID: 2, roomID: 7}
+	audit.start(late)
+	audit.observe(late, audit.stamp(7))
+
+	if audit.frames != 4 || audit.gaps != 1 || audit.outOfOrder != 1 {
+		t.Errorf("the audit counted %d frames, %d gaps and %d out of order, want 4, 1 and 1", audit.frames, audit.gaps, audit.outOfOrder)
+	}
+
+	audit.forget(7)
+	if seq := audit.stamp(7); seq != 1 {
+		t.Errorf("a forgotten room restarted at %d, want 1", seq)
+	}
+}
@@ -0,0 +1,96 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// Announcement rooms
+//
+// A room whose post_policy is "admins_only" is an announcement channel: its
+// owner and admins post and everyone else reads. The hub refuses other
+// members' chat messages with a "room_announcement_only" error frame, judging
+// by the role the client connected with (see SetRoomRole) and the room's
+// policy, cached here. Frames that aren't chat messages (history requests,
+// filters, options) are unaffected
+
+// postPolicyCacheTTL is how long a room's post policy is trusted before being reloaded
+// Changes made through this instance take effect at once (see SetRoomPostPolicy);
+// the TTL only bounds how stale another instance's change can be
+const postPolicyCacheTTL = time.Minute
+
+// loadPostPolicy loads a room's post policy into the hub's cache
+func loadPostPolicy(ctx context.Context, st store.Storage, roomID int64) (string, error) {
+	return st.Rooms.GetPostPolicy(ctx, roomID)
+}
+
+// postPolicy returns a room's post policy from the hub's cache
+// A room that can't be loaded lets everyone post until the next try; a
+// database hiccup shouldn't silence a room, as with quiet hours
+func postPolicy(ctx context.Context, cache *roomCache[string], st store.Storage, roomID int64) string {
+	policy, err := cache.get(ctx, st, roomID)
+	if err != nil {
+		log.Printf("Failed to load the post policy of room %d: %v", roomID, err)
+		return store.PostPolicyEveryone
+	}
+	return policy
+}
+
+// SetRoomRole records the user's role in the room (owner, admin or member),
+// which decides whether they may post in an announcement room; like
+// DenyPosting, a role change applies on reconnect
+// Must be called before Start
+func (c *Client) SetRoomRole(role string) {
+	c.role = role
+}
+
+// RoomPostPolicy returns who may post in a room (a store.PostPolicy constant),
+// loading it if the hub doesn't know it yet
+// Safe to call from any goroutine, but not from a shard's loop
+func (h *Hub) RoomPostPolicy(ctx context.Context, roomID int64) string {
+	return postPolicy(ctx, h.postPolicies, h.store, roomID)
+}
+
+// SetRoomPostPolicy tells the hub a room's post policy changed and tells the
+// room's clients with a "room_updated" frame, so they can enable or disable
+// their input box
+// Call it after saving the room; members' messages are judged by the new
+// policy from then on
+// Safe to call from any goroutine
+func (h *Hub) SetRoomPostPolicy(roomID int64, policy string) {
+	h.postPolicies.set(roomID, policy)
+	h.Announce(&Message{
+		Message: wire.Message{
+			RoomID:     roomID,
+			Content:    "the room's post policy changed",
+			Type:       "room_updated",
+			PostPolicy: policy,
+		},
+	})
+}
+
+// enforcePostPolicy applies the room's post policy to a chat message on the
+// shard loop; a refused message is reported to its sender and
+// enforcePostPolicy returns false
+// Messages without a sender were let through by whoever injected them
+func (s *shard) enforcePostPolicy(ctx context.Context, message *Message) bool {
+	if message.sender == nil {
+		return true
+	}
+	if store.PostPolicyAllows(postPolicy(ctx, s.postPolicies, s.store, message.RoomID), message.sender.role) {
+		return true
+	}
+	s.deliverToClient(message.sender, &Message{
+		Message: wire.Message{
+			RoomID:  message.RoomID,
+			Content: "only room admins can post in this room",
+			Type:    "error",
+			Code:    "room_announcement_only",
+		},
+	})
+	return false
+}
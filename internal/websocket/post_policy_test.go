@@ -0,0 +1,51 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestAnnouncementRoom posts in an admins_only room: the admin's message is
+// delivered and the member's refused, until the hub is told the room is
+// open again, which its clients hear about in a room_updated frame
+func TestAnnouncementRoom(t *testing.T) {
+	rooms := roomSettings{announcements: map[int64]bool{1: true}}
+	messages := newMemoryMessages()
+	hub := NewHub(store.Storage{Messages: messages, Rooms: rooms, Receipts: &memoryReceipts{}}, 0)
+	hub.SetRoomStatsInterval(0)
+	go hub.Run()
+
+	admin := dialTestHub(t, hub, 1, 1, func(c *Client) { c.SetRoomRole(store.RoomRoleAdmin) })
+	framesUntil(t, admin, "join")
+	member := dialTestHub(t, hub, 2, 1, func(c *Client) { c.SetRoomRole(store.RoomRoleMember) })
+	framesUntil(t, member, "join")
+
+	if err := admin.WriteJSON(map[string]string{"content": "release at 5"}); err != nil {
+		t.Fatal(err)
+	}
+	if frames := framesUntil(t, member, "message"); frames[len(frames)-1].UserID != 1 {
+		t.Errorf("the member got %+v, want the admin's message", frames[len(frames)-1])
+	}
+	framesUntil(t, admin, "message") // The admin's echo
+	if err := member.WriteJSON(map[string]string{"content": "thanks!"}); err != nil {
+		t.Fatal(err)
+	}
+	if frames := framesUntil(t, member, "error"); frames[len(frames)-1].Code != "room_announcement_only" {
+		t.Errorf("the member was refused with %q, want room_announcement_only", frames[len(frames)-1].Code)
+	}
+
+	hub.SetRoomPostPolicy(1, store.PostPolicyEveryone)
+	if frames := framesUntil(t, member, "room_updated"); frames[len(frames)-1].PostPolicy != store.PostPolicyEveryone {
+		t.Errorf("the room_updated frame says %q, want everyone", frames[len(frames)-1].PostPolicy)
+	}
+	if err := member.WriteJSON(map[string]string{"content": "thanks!"}); err != nil {
+		t.Fatal(err)
+	}
+	if frames := framesUntil(t, admin, "message"); frames[len(frames)-1].UserID != 2 {
+		t.Errorf("the admin got %+v, want the member's message", frames[len(frames)-1])
+	}
+	if saved := messages.saved(1); len(saved) != 2 {
+		t.Errorf("saved %d messages, want the admin's and the member's second", len(saved))
+	}
+}
@@ -0,0 +1,173 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/drazan344/go-chat/internal/flags"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/sysmsg"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// defaultPresenceGrace is how long a user may be disconnected before the room
+// is told they left; reconnecting within it produces no join/leave at all
+const defaultPresenceGrace = 20 * time.Second
+
+// presence tracks one user in one room across all of their connections
+// Only the first connection announces a join and only the last disconnect
+// (after the grace period) announces a leave
+type presence struct {
+	username string
+
+	// conns counts the user's open, non-guest connections in the room
+	conns int
+
+	// leaveTimer is set while the user has no connections and their leave is pending
+	leaveTimer *time.Timer
+
+	// generation is bumped every time a leave timer is started or cancelled,
+	// so a timer that fires after being superseded can tell it's stale
+	generation int
+}
+
+// userJoined records a new connection and announces the user if they weren't present
+// Must only be called from the shard's loop
+func (s *shard) userJoined(client *Client) {
+	users := s.presence[client.roomID]
+	if users == nil {
+		users = make(map[int64]*presence)
+		s.presence[client.roomID] = users
+	}
+
+	p := users[client.userID]
+	if p != nil {
+		p.conns++
+		if p.leaveTimer != nil {
+			// Back within the grace period: the leave never happened
+			p.leaveTimer.Stop()
+			p.leaveTimer = nil
+			p.generation++
+			log.Printf("Presence: user=%d reconnected to room=%d within grace period", client.userID, client.roomID)
+		}
+		return
+	}
+
+	users[client.userID] = &presence{username: client.username, conns: 1}
+
+	// Optionally send a "user joined" notification to the room
+	joinEvent := sysmsg.Join(client.username)
+	joinMessage := &Message{
+		Message: wire.Message{
+			RoomID:      client.roomID,
+			UserID:      client.userID,
+			Username:    client.username,
+			Content:     sysmsg.Render(joinEvent, s.languages.peek(client.roomID)),
+			Type:        "join",
+			SystemEvent: wire.SystemEvent(joinEvent),
+		},
+	}
+
+	// Broadcast join message to all clients in the room
+	s.broadcastToRoom(client.roomID, joinMessage)
+	s.persistPresence(joinMessage)
+}
+
+// userLeft records a closed connection and, if it was the user's last one,
+// schedules their leave announcement after the grace period
+// The leave always goes through the shard loop via a timer, even with a zero
+// grace period, so it's never broadcast while a broadcast is iterating the room
+// Must only be called from the shard's loop
+func (s *shard) userLeft(client *Client) {
+	p := s.presence[client.roomID][client.userID]
+	if p == nil {
+		return
+	}
+
+	p.conns--
+	if p.conns > 0 {
+		return
+	}
+
+	p.generation++
+	generation := p.generation
+	roomID, userID := client.roomID, client.userID
+	p.leaveTimer = time.AfterFunc(s.presenceGrace, func() {
+		s.post(func() {
+			s.expirePresence(roomID, userID, generation)
+		})
+	})
+}
+
+// expirePresence announces a leave once its grace period has passed
+// Stale timers (the user reconnected, or left again later) are ignored
+func (s *shard) expirePresence(roomID, userID int64, generation int) {
+	users := s.presence[roomID]
+	p := users[userID]
+	if p == nil || p.conns > 0 || p.generation != generation {
+		return
+	}
+
+	delete(users, userID)
+	if len(users) == 0 {
+		delete(s.presence, roomID)
+	}
+
+	// Send a "user left" notification
+	leaveEvent := sysmsg.Leave(p.username)
+	leaveMessage := &Message{
+		Message: wire.Message{
+			RoomID:      roomID,
+			UserID:      userID,
+			Username:    p.username,
+			Content:     sysmsg.Render(leaveEvent, s.languages.peek(roomID)),
+			Type:        "leave",
+			SystemEvent: wire.SystemEvent(leaveEvent),
+		},
+	}
+
+	// Broadcast leave message to remaining clients
+	s.broadcastToRoom(roomID, leaveMessage)
+	s.persistPresence(leaveMessage)
+}
+
+// persistPresence saves a join or leave announcement as a system message if
+// the PersistJoinLeave flag is on for the user and room
+// Connected clients already got the announcement live, so the saved copy is
+// only for history and isn't broadcast again. It's saved as its event, to be
+// rendered in the room's language when read. The flag check (which may have
+// to load the flags) and the insert run off the shard loop
+func (s *shard) persistPresence(announcement *Message) {
+	if s.featureFlags == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+		defer cancel()
+
+		if !s.featureFlags.Enabled(ctx, flags.PersistJoinLeave, announcement.UserID, announcement.RoomID) {
+			return
+		}
+		message := &store.Message{
+			RoomID:      announcement.RoomID,
+			UserID:      store.SystemUserID,
+			ContentType: content.TypeText,
+			SystemEvent: store.SystemEvent(announcement.SystemEvent),
+		}
+		if err := s.store.Messages.Create(ctx, message); err != nil {
+			log.Printf("Failed to save %s announcement for user %d in room %d: %v",
+				announcement.Type, announcement.UserID, announcement.RoomID, err)
+		}
+	}()
+}
+
+// SetPresenceGrace sets how long a disconnected user stays "present" before
+// their leave is announced
+// Must be called before Run
+func (h *Hub) SetPresenceGrace(grace time.Duration) {
+	for _, s := range h.shards {
+		s.presenceGrace = grace
+	}
+}
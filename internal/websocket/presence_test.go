@@ -0,0 +1,225 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/flags"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+)
+
+// presenceRecorder counts the join and leave frames an observer gets for each user
+type presenceRecorder struct {
+	mu     sync.Mutex
+	joins  map[int64]int
+	leaves map[int64]int
+	done   chan struct{}
+}
+
+// recordPresence drains an observer's send channel until it's closed
+func recordPresence(client *Client) *presenceRecorder {
+	r := &presenceRecorder{joins: make(map[int64]int), leaves: make(map[int64]int), done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		for frame := range client.send {
+			var message Message
+			if json.Unmarshal(frame, &message) != nil {
+				continue
+			}
+			r.mu.Lock()
+			switch message.Type {
+			case "join":
+				r.joins[message.UserID]++
+			case "leave":
+				r.leaves[message.UserID]++
+			}
+			r.mu.Unlock()
+		}
+	}()
+	return r
+}
+
+func (r *presenceRecorder) counts(userID int64) (joins, leaves int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.joins[userID], r.leaves[userID]
+}
+
+// TestPresenceRapidReconnects simulates a flaky connection: a user drops and
+// reconnects many times within the grace period, sometimes with a second
+// connection overlapping, then goes for good. The room must see exactly one
+// join and, once the grace period has passed, exactly one leave
+func TestPresenceRapidReconnects(t *testing.T) {
+	const (
+		grace      = 200 * time.Millisecond
+		reconnects = 25
+	)
+
+	hub := newTestHub(2)
+	hub.SetPresenceGrace(grace)
+	go hub.Run()
+
+	observer := newTestClient(hub, 2, 1, 1024)
+	hub.register(observer)
+	recorder := recordPresence(observer)
+	s := hub.shardFor(1)
+
+	connect := func() *Client {
+		client := newTestClient(hub, 1, 1, 64)
+		go drainFrames(client)
+		hub.register(client)
+		return client
+	}
+	client := connect()
+	for i := 0; i < reconnects; i++ {
+		if i%5 == 0 {
+			// The new connection opens before the old one has closed
+			next := connect()
+			hub.unregister(client)
+			client = next
+			continue
+		}
+		hub.unregister(client)
+		time.Sleep(grace / 20)
+		client = connect()
+	}
+	s.do(func() {})
+	if joins, leaves := recorder.counts(1); joins != 1 || leaves != 0 {
+		t.Fatalf("while reconnecting the room saw %d joins and %d leaves, want 1 and 0", joins, leaves)
+	}
+
+	hub.unregister(client)
+	time.Sleep(grace / 2)
+	s.do(func() {})
+	if _, leaves := recorder.counts(1); leaves != 0 {
+		t.Errorf("the leave was announced %d times before the grace period ended", leaves)
+	}
+	left := testutil.WaitFor(2*grace+time.Second, func() bool {
+		_, leaves := recorder.counts(1)
+		return leaves > 0
+	})
+	if !left {
+		t.Fatal("the leave was never announced")
+	}
+
+	// Give any stale timer time to fire a second leave
+	time.Sleep(2 * grace)
+	s.do(func() {})
+	if joins, leaves := recorder.counts(1); joins != 1 || leaves != 1 {
+		t.Errorf("the room saw %d joins and %d leaves, want exactly 1 of each", joins, leaves)
+	}
+	var present bool
+	s.do(func() { _, present = s.presence[1][1] })
+	if present {
+		t.Error("the user is still tracked as present after their leave")
+	}
+
+	hub.unregister(observer)
+	<-recorder.done
+}
+
+// TestPresenceStaleExpiry checks expirePresence ignores a timer superseded by
+// a reconnect, and a timer from an earlier disconnect once the user has left again
+func TestPresenceStaleExpiry(t *testing.T) {
+	hub := newTestHub(1)
+	hub.SetPresenceGrace(time.Hour) // The test fires the timers itself
+	go hub.Run()
+	s := hub.shards[0]
+
+	observer := newTestClient(hub, 2, 1, 256)
+	hub.register(observer)
+	recorder := recordPresence(observer)
+
+	generation := func() (gen int) {
+		s.do(func() { gen = s.presence[1][1].generation })
+		return gen
+	}
+
+	first := newTestClient(hub, 1, 1, 64)
+	go drainFrames(first)
+	hub.register(first)
+	hub.unregister(first)
+	stale := generation()
+
+	// Reconnecting supersedes the pending leave
+	second := newTestClient(hub, 1, 1, 64)
+	go drainFrames(second)
+	hub.register(second)
+	s.do(func() { s.expirePresence(1, 1, stale) })
+	if _, leaves := recorder.counts(1); leaves != 0 {
+		t.Errorf("a superseded timer announced %d leaves", leaves)
+	}
+
+	// Leaving again starts a new timer; the old one still does nothing
+	hub.unregister(second)
+	current := generation()
+	if current == stale {
+		t.Fatalf("the new leave timer has the old generation %d", stale)
+	}
+	s.do(func() { s.expirePresence(1, 1, stale) })
+	if _, leaves := recorder.counts(1); leaves != 0 {
+		t.Errorf("a timer from an earlier disconnect announced %d leaves", leaves)
+	}
+
+	// The current timer announces the leave, once
+	s.do(func() {
+		s.expirePresence(1, 1, current)
+		s.expirePresence(1, 1, current)
+	})
+	hub.unregister(observer)
+	<-recorder.done
+	if joins, leaves := recorder.counts(1); joins != 1 || leaves != 1 {
+		t.Errorf("the room saw %d joins and %d leaves, want exactly 1 of each", joins, leaves)
+	}
+}
+
+// flagChecker turns a flag on for the users in it
+type flagChecker map[string]map[int64]bool
+
+func (c flagChecker) Enabled(_ context.Context, flag string, userID, _ int64) bool {
+	return c[flag][userID]
+}
+
+// TestPersistJoinLeave has persist_join_leave on for user 1 only: their
+// join and leave are saved to the room's history as system events, and
+// user 2's aren't
+func TestPersistJoinLeave(t *testing.T) {
+	messages := newMemoryMessages()
+	hub := NewHub(store.Storage{Messages: messages, Rooms: roomSettings{}}, 1)
+	hub.SetPresenceGrace(0)
+	hub.SetFeatureFlags(flagChecker{flags.PersistJoinLeave: {1: true}})
+	hub.SetRoomStatsInterval(0)
+	go hub.Run()
+
+	for _, userID := range []int64{1, 2} {
+		client := newTestClient(hub, userID, 1, 64)
+		go drainFrames(client)
+		hub.register(client)
+		hub.unregister(client)
+	}
+
+	var saved []*store.Message
+	testutil.WaitFor(time.Second, func() bool {
+		saved = messages.saved(1)
+		return len(saved) >= 2
+	})
+	time.Sleep(50 * time.Millisecond)
+	saved = messages.saved(1)
+	if len(saved) != 2 {
+		t.Fatalf("saved %d messages, want user 1's join and leave", len(saved))
+	}
+	// Each is saved on its own goroutine, so they may land in either order
+	got := make(map[string]bool)
+	for _, m := range saved {
+		got[m.SystemEvent.Name()+" "+m.SystemEvent["username"]] = m.UserID == store.SystemUserID && m.Content == ""
+	}
+	for _, want := range []string{"join user1", "leave user1"} {
+		if !got[want] {
+			t.Errorf("%q wasn't saved from the system user; saved %v", want, got)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Frame format versions
+// v1 is the bare Message JSON every existing client understands
+// v2 wraps the same payload in an envelope with a per-connection sequence number
+const (
+	ProtoV1 = 1
+	ProtoV2 = 2
+)
+
+// subprotocolPrefix names versions in the Sec-WebSocket-Protocol header, e.g. "gochat.v2"
+const subprotocolPrefix = "gochat.v"
+
+// capabilities lists the server features announced in the hello frame
+var capabilities = []string{"content_types", "filters", "receipts", "history", "room_stats", "suppress_echo"}
+
+// encoders build the wire format of each protocol version
+// Adding a version means adding an encoder here; existing ones never change
+var encoders = map[int]frameEncoder{
+	ProtoV1: encodeV1,
+	ProtoV2: encodeV2,
+}
+
+// latestProto is the newest version the server speaks
+var latestProto = ProtoV2
+
+// ProtocolVersions returns the frame format versions the server speaks, oldest first
+func ProtocolVersions() []int {
+	versions := make([]int, 0, len(encoders))
+	for v := range encoders {
+		versions = append(versions, v)
+	}
+	slices.Sort(versions)
+	return versions
+}
+
+// Capabilities returns the features announced in the hello frame
+func Capabilities() []string {
+	return slices.Clone(capabilities)
+}
+
+// Subprotocol returns the Sec-WebSocket-Protocol entry that asks for a version
+func Subprotocol(version int) string {
+	return fmt.Sprintf("%s%d", subprotocolPrefix, version)
+}
+
+// frameEncoder turns a message into the bytes sent to one client
+// payload is the message already marshaled to JSON, shared by all clients
+// of a broadcast so it's only built once
+type frameEncoder func(client *Client, message *Message, payload []byte) ([]byte, error)
+
+// envelopeV2 is the v2 frame format
+type envelopeV2 struct {
+	V    int             `json:"v"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+	Seq  int64           `json:"seq"`
+}
+
+// encodeV1 sends the message as is
+func encodeV1(_ *Client, _ *Message, payload []byte) ([]byte, error) {
+	return payload, nil
+}
+
+// encodeV2 wraps the message in an envelope
+// seq counts frames on this connection, so clients can spot gaps
+func encodeV2(client *Client, message *Message, payload []byte) ([]byte, error) {
+	client.seq++
+	return json.Marshal(envelopeV2{V: ProtoV2, Type: message.Type, Data: payload, Seq: client.seq})
+}
+
+// helloFrame announces the negotiated version and server features
+// It has the same shape in every version, so clients can read it before
+// they know which format the rest of the connection uses
+type helloFrame struct {
+	Type         string   `json:"type"`
+	Proto        int      `json:"proto"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// Negotiation is the outcome of protocol negotiation for one connection
+type Negotiation struct {
+	Version int
+
+	// Subprotocol is echoed in the upgrade response when the client asked via
+	// the Sec-WebSocket-Protocol header; empty otherwise
+	Subprotocol string
+
+	// Requested is false for legacy clients that didn't ask for any version
+	// They get v1 and no hello frame, exactly as before negotiation existed
+	Requested bool
+}
+
+// ResponseHeader returns the header to pass to Upgrader.Upgrade
+func (n Negotiation) ResponseHeader() http.Header {
+	if n.Subprotocol == "" {
+		return nil
+	}
+	return http.Header{"Sec-Websocket-Protocol": {n.Subprotocol}}
+}
+
+// Negotiate picks the protocol version for a WebSocket upgrade request
+// Clients ask with ?proto=2 or a "gochat.v2" Sec-WebSocket-Protocol entry
+// A version the server doesn't know falls back to the highest supported one
+// below it, so a client asking for v3 today gets v2
+func Negotiate(r *http.Request) Negotiation {
+	if raw := r.URL.Query().Get("proto"); raw != "" {
+		requested, err := strconv.Atoi(raw)
+		if err != nil {
+			return Negotiation{Version: ProtoV1, Requested: true}
+		}
+		return Negotiation{Version: bestVersion(requested), Requested: true}
+	}
+
+	// Subprotocols lists what the client offered; pick the highest we can serve
+	best := 0
+	for _, offered := range websocket.Subprotocols(r) {
+		if !strings.HasPrefix(offered, subprotocolPrefix) {
+			continue
+		}
+		requested, err := strconv.Atoi(strings.TrimPrefix(offered, subprotocolPrefix))
+		if err != nil {
+			continue
+		}
+		if v := bestVersion(requested); v > best {
+			best = v
+		}
+	}
+	if best == 0 {
+		return Negotiation{Version: ProtoV1}
+	}
+
+	// The echoed subprotocol must be one the client offered; if it only offered
+	// versions we don't have, the fallback is announced in the hello frame instead
+	n := Negotiation{Version: best, Requested: true}
+	for _, offered := range websocket.Subprotocols(r) {
+		if offered == Subprotocol(best) {
+			n.Subprotocol = offered
+		}
+	}
+	return n
+}
+
+// bestVersion returns the highest supported version not above requested
+func bestVersion(requested int) int {
+	if requested > latestProto {
+		return latestProto
+	}
+	if _, ok := encoders[requested]; ok {
+		return requested
+	}
+	return ProtoV1
+}
+
+// SetProtocol applies a negotiated protocol to the client
+// It must be called before Start
+func (c *Client) SetProtocol(n Negotiation) {
+	c.proto = n.Version
+	c.sendHello = n.Requested
+}
+
+// queueHello puts the client's hello frame first in its send channel
+// It must be called before the client is registered: until then nothing else
+// writes to the channel, so the hello comes before even the client's own join
+func (c *Client) queueHello() {
+	frame, err := json.Marshal(helloFrame{Type: "hello", Proto: c.proto, Capabilities: capabilities})
+	if err != nil {
+		log.Printf("Failed to marshal hello frame: %v", err)
+		return
+	}
+	c.send <- frame
+}
+
+// encodeFor builds the frame for one client from a shared payload
+// Must only be called from the shard's loop, which owns client.seq
+func encodeFor(client *Client, message *Message, payload []byte) ([]byte, error) {
+	encode, ok := encoders[client.proto]
+	if !ok {
+		encode = encodeV1
+	}
+	return encode(client, message, payload)
+}
@@ -0,0 +1,139 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testutil"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// TestNegotiate picks versions for upgrade requests: legacy clients get v1
+// without asking, unknown versions fall back to the newest one below them,
+// and only a subprotocol the client offered is echoed
+func TestNegotiate(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		query   string
+		offered string
+		want    Negotiation
+	}{
+		{"legacy", "", "", Negotiation{Version: ProtoV1}},
+		{"query v1", "?proto=1", "", Negotiation{Version: ProtoV1, Requested: true}},
+		{"query v2", "?proto=2", "", Negotiation{Version: ProtoV2, Requested: true}},
+		{"query from the future", "?proto=3", "", Negotiation{Version: ProtoV2, Requested: true}},
+		{"query garbage", "?proto=two", "", Negotiation{Version: ProtoV1, Requested: true}},
+		{"query wins over the header", "?proto=1", "gochat.v2", Negotiation{Version: ProtoV1, Requested: true}},
+		{"subprotocol v2", "", "gochat.v2", Negotiation{Version: ProtoV2, Subprotocol: "gochat.v2", Requested: true}},
+		{"best subprotocol", "", "gochat.v1, gochat.v2", Negotiation{Version: ProtoV2, Subprotocol: "gochat.v2", Requested: true}},
+		{"subprotocol from the future", "", "gochat.v9", Negotiation{Version: ProtoV2, Requested: true}},
+		{"someone else's subprotocol", "", "mqtt", Negotiation{Version: ProtoV1}},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/ws"+tc.query, nil)
+		if tc.offered != "" {
+			r.Header.Set("Sec-WebSocket-Protocol", tc.offered)
+		}
+		if got := Negotiate(r); got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// sentFrame is a frame as a client of either version receives it: v2
+// fields are empty on v1 frames, and v1 fields on v2 envelopes
+type sentFrame struct {
+	Message
+	V            int             `json:"v"`
+	Data         json.RawMessage `json:"data"`
+	Seq          int64           `json:"seq"`
+	Proto        int             `json:"proto"`
+	Capabilities []string        `json:"capabilities"`
+}
+
+// takeFrames takes every frame queued for a client
+func takeFrames(t *testing.T, client *Client) []sentFrame {
+	t.Helper()
+	var frames []sentFrame
+	for len(client.send) > 0 {
+		var frame sentFrame
+		if err := json.Unmarshal(<-client.send, &frame); err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// TestProtocolVersions runs one scenario with a client of each kind in the
+// room: a legacy client, one that asked for v1 and one on v2. Each sees the
+// same events in its own format; clients that asked get a hello before
+// anything else, and v2 frames are numbered per connection
+func TestProtocolVersions(t *testing.T) {
+	hub := newTestHub(1)
+	go hub.Run()
+
+	legacy := newTestClient(hub, 1, 1, 64)
+	legacy.SetProtocol(Negotiation{Version: ProtoV1})
+	v1 := newTestClient(hub, 2, 1, 64)
+	v1.SetProtocol(Negotiation{Version: ProtoV1, Requested: true})
+	v2 := newTestClient(hub, 3, 1, 64)
+	v2.SetProtocol(Negotiation{Version: ProtoV2, Requested: true})
+	for _, client := range []*Client{legacy, v1, v2} {
+		client.join()
+	}
+	hub.broadcast(&Message{Message: wire.Message{RoomID: 1, UserID: 1, Username: "user1", Type: "message", Content: "hello"}})
+	// Every client has all its frames once the message has reached them all
+	if !testutil.WaitFor(5*time.Second, func() bool { return len(legacy.send) == 4 && len(v1.send) == 4 && len(v2.send) == 3 }) {
+		t.Fatal("the message never reached every client")
+	}
+
+	for _, tc := range []struct {
+		client *Client
+		hello  bool
+		types  []string // After the hello, if any
+	}{
+		{legacy, false, []string{"join", "join", "join", "message"}},
+		{v1, true, []string{"join", "join", "message"}},
+		{v2, true, []string{"join", "message"}},
+	} {
+		frames := takeFrames(t, tc.client)
+		if tc.hello {
+			if len(frames) == 0 || frames[0].Type != "hello" || frames[0].Proto != tc.client.proto ||
+				len(frames[0].Capabilities) == 0 {
+				t.Errorf("user %d: got %+v first, want a hello for v%d", tc.client.userID, frames, tc.client.proto)
+				continue
+			}
+			frames = frames[1:]
+		}
+		if len(frames) != len(tc.types) {
+			t.Errorf("user %d: got %d frames, want %v", tc.client.userID, len(frames), tc.types)
+			continue
+		}
+
+		for i, frame := range frames {
+			content := frame.Content
+			if tc.client.proto == ProtoV2 {
+				var data Message
+				if frame.V != ProtoV2 || frame.Seq != int64(i+1) || json.Unmarshal(frame.Data, &data) != nil {
+					t.Errorf("user %d: frame %d is %+v, want a v2 envelope numbered %d", tc.client.userID, i, frame, i+1)
+					continue
+				}
+				content = data.Content
+				if data.Type != frame.Type {
+					t.Errorf("user %d: envelope type %q has a %q inside", tc.client.userID, frame.Type, data.Type)
+				}
+			} else if frame.V != 0 || frame.Data != nil {
+				t.Errorf("user %d: frame %d is %+v, want bare v1 JSON", tc.client.userID, i, frame)
+			}
+			if frame.Type != tc.types[i] {
+				t.Errorf("user %d: frame %d is %q, want %q", tc.client.userID, i, frame.Type, tc.types[i])
+			}
+			if frame.Type == "message" && content != "hello" {
+				t.Errorf("user %d: the message reads %q, want hello", tc.client.userID, content)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/schedule"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// quietCacheTTL is how long a room's quiet hours are trusted before being reloaded
+// Changes made through this instance take effect at once (see InvalidateQuietHours);
+// the TTL only bounds how stale another instance's change can be
+const quietCacheTTL = time.Minute
+
+// Outcomes of a quiet hours check
+const (
+	QuietAllowed  = iota // Not quiet hours, post normally
+	QuietOverride        // Quiet hours, but the sender is the creator or an admin
+	QuietRejected        // Quiet hours; the message must be refused
+)
+
+// quietEntry is one room's quiet hours, as cached by the hub
+type quietEntry struct {
+	window    *schedule.Window // nil if the room has no quiet hours
+	createdBy int64
+}
+
+// loadQuietHours loads a room's quiet hours into the hub's cache
+func loadQuietHours(ctx context.Context, st store.Storage, roomID int64) (quietEntry, error) {
+	window, createdBy, err := st.Rooms.GetQuietHours(ctx, roomID)
+	return quietEntry{window: window, createdBy: createdBy}, err
+}
+
+// InvalidateQuietHours makes the hub reload a room's quiet hours on the next message
+// Call it after changing the room's settings
+// Safe to call from any goroutine
+func (h *Hub) InvalidateQuietHours(roomID int64) {
+	h.quiet.forget(roomID)
+}
+
+// CheckQuietHours decides whether a user may post in a room right now
+// The room creator and admins may always post, but their messages are
+// marked as overriding quiet hours
+// Lookup failures allow the message; a database hiccup shouldn't silence a room
+// Safe to call from any goroutine
+func (h *Hub) CheckQuietHours(ctx context.Context, roomID, userID int64) int {
+	return checkQuietHours(ctx, h.quiet, h.store, roomID, userID)
+}
+
+// checkQuietHours is shared by the shards (WebSocket) and CheckQuietHours (REST)
+func checkQuietHours(ctx context.Context, cache *roomCache[quietEntry], st store.Storage, roomID, userID int64) int {
+	entry, err := cache.get(ctx, st, roomID)
+	if err != nil {
+		log.Printf("Failed to load quiet hours for room %d: %v", roomID, err)
+		return QuietAllowed
+	}
+	if !entry.window.Active(time.Now()) {
+		return QuietAllowed
+	}
+
+	if userID == entry.createdBy {
+		return QuietOverride
+	}
+	isAdmin, err := st.RoomMembers.IsRoomAdmin(ctx, roomID, userID)
+	if err != nil {
+		log.Printf("Failed to check admin role in room %d: %v", roomID, err)
+		return QuietAllowed
+	}
+	if isAdmin {
+		return QuietOverride
+	}
+	return QuietRejected
+}
+
+// enforceQuietHours applies quiet hours to a chat message on the shard loop
+// Rejected messages are reported to their sender and enforceQuietHours returns false
+func (s *shard) enforceQuietHours(ctx context.Context, message *Message) bool {
+	switch checkQuietHours(ctx, s.quiet, s.store, message.RoomID, message.UserID) {
+	case QuietOverride:
+		message.Override = true
+	case QuietRejected:
+		if message.sender != nil {
+			s.deliverToClient(message.sender, &Message{
+				Message: wire.Message{
+					RoomID:  message.RoomID,
+					Content: "only room admins can post during quiet hours",
+					Type:    "error",
+					Code:    "room_quiet_hours",
+				},
+			})
+		}
+		return false
+	}
+	return true
+}
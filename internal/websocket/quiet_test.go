@@ -0,0 +1,82 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+
+	"github.com/drazan344/go-chat/internal/schedule"
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestQuietHours posts in a room during its quiet hours: the creator and
+// an admin get through, marked as overriding them, and a member is refused
+// Once the room's quiet hours are removed and the hub told, the member can
+// post again
+func TestQuietHours(t *testing.T) {
+	allDay := &schedule.Window{Start: "00:00", End: "00:00"}
+	if err := allDay.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	rooms := roomSettings{quiet: map[int64]*schedule.Window{1: allDay}}
+	messages := newMemoryMessages()
+	hub := NewHub(store.Storage{
+		Messages:    messages,
+		Rooms:       rooms,
+		RoomMembers: roomAdmins{admins: map[int64]bool{3: true}},
+		Receipts:    &memoryReceipts{},
+	}, 0)
+	hub.SetRoomStatsInterval(0)
+	go hub.Run()
+
+	reader := dialTestHub(t, hub, 4, 1)
+	framesUntil(t, reader, "join")
+
+	for _, tc := range []struct {
+		userID   int64
+		override bool
+	}{
+		{1, true}, // The creator
+		{3, true}, // An admin
+	} {
+		sender := dialTestHub(t, hub, tc.userID, 1)
+		framesUntil(t, reader, "join")
+		if err := sender.WriteJSON(map[string]string{"content": "still here"}); err != nil {
+			t.Fatal(err)
+		}
+		frames := framesUntil(t, reader, "message")
+		if got := frames[len(frames)-1]; got.UserID != tc.userID || got.Override != tc.override {
+			t.Errorf("user %d's message arrived as %+v, want override %v", tc.userID, got, tc.override)
+		}
+	}
+
+	member := dialTestHub(t, hub, 2, 1)
+	framesUntil(t, member, "join")
+	if err := member.WriteJSON(map[string]string{"content": "hello?"}); err != nil {
+		t.Fatal(err)
+	}
+	frames := framesUntil(t, member, "error")
+	if got := frames[len(frames)-1]; got.Code != "room_quiet_hours" {
+		t.Errorf("the member was refused with %q, want room_quiet_hours", got.Code)
+	}
+	if got := hub.CheckQuietHours(context.Background(), 1, 2); got != QuietRejected {
+		t.Errorf("CheckQuietHours for the member is %d, want QuietRejected", got)
+	}
+
+	delete(rooms.quiet, 1)
+	if got := hub.CheckQuietHours(context.Background(), 1, 2); got != QuietRejected {
+		t.Errorf("before the hub is told, CheckQuietHours is %d, want the cached QuietRejected", got)
+	}
+	hub.InvalidateQuietHours(1)
+	if err := member.WriteJSON(map[string]string{"content": "hello!"}); err != nil {
+		t.Fatal(err)
+	}
+	frames = framesUntil(t, reader, "message")
+	if got := frames[len(frames)-1]; got.UserID != 2 || got.Override {
+		t.Errorf("after quiet hours the member's message arrived as %+v", got)
+	}
+
+	saved := messages.saved(1)
+	if len(saved) != 3 || !saved[0].Override || !saved[1].Override || saved[2].Override {
+		t.Errorf("saved %d messages, want the two overriding and one normal", len(saved))
+	}
+}
@@ -0,0 +1,65 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// deliveryFlushInterval is how long deliveries are coalesced before being
+// written to the database and announced to the room
+// Within one window, a user receiving 50 messages costs one pointer update
+// and one "delivered" frame instead of 50 of each
+const deliveryFlushInterval = 2 * time.Second
+
+// recordDelivery notes that a message frame reached a user's send channel
+// Only the highest message ID per (room, user) is kept until the next flush
+// Must only be called from the shard's loop
+func (s *shard) recordDelivery(roomID, userID, messageID int64) {
+	users := s.deliveries[roomID]
+	if users == nil {
+		users = make(map[int64]int64)
+		s.deliveries[roomID] = users
+	}
+	if messageID > users[userID] {
+		users[userID] = messageID
+	}
+}
+
+// flushDeliveries announces and persists the deliveries recorded since the last flush
+// Frames go out from the loop right away; the database write runs in its own
+// goroutine so a slow query doesn't stall message fan-out
+func (s *shard) flushDeliveries() {
+	if len(s.deliveries) == 0 {
+		return
+	}
+
+	marks := make([]store.DeliveryMark, 0)
+	for roomID, users := range s.deliveries {
+		for userID, messageID := range users {
+			marks = append(marks, store.DeliveryMark{RoomID: roomID, UserID: userID, MessageID: messageID})
+
+			s.broadcastToRoom(roomID, &Message{
+				Message: wire.Message{
+					RoomID:        roomID,
+					UserID:        userID,
+					Type:          "delivered",
+					UpToMessageID: messageID,
+				},
+			})
+		}
+	}
+	s.deliveries = make(map[int64]map[int64]int64)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+		defer cancel()
+
+		if err := s.store.Receipts.MarkDelivered(ctx, marks); err != nil {
+			log.Printf("Failed to save %d delivery markers: %v", len(marks), err)
+		}
+	}()
+}
@@ -0,0 +1,130 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// deliveredFrames takes the frames queued for a client and returns the
+// "delivered" ones, keyed by user
+func deliveredFrames(t *testing.T, client *Client) map[int64]int64 {
+	t.Helper()
+	delivered := make(map[int64]int64)
+	for {
+		select {
+		case frame := <-client.send:
+			var message Message
+			if err := json.Unmarshal(frame, &message); err != nil {
+				t.Fatal(err)
+			}
+			if message.Type == "delivered" {
+				if _, ok := delivered[message.UserID]; ok {
+					t.Errorf("user %d got two delivered frames in one window", message.UserID)
+				}
+				delivered[message.UserID] = message.UpToMessageID
+			}
+		default:
+			return delivered
+		}
+	}
+}
+
+// TestDeliveriesAreCoalesced sends a burst of messages within one delivery
+// window: the flush writes one pointer per recipient at the newest message
+// and announces each once, the sender and guests aren't counted, and a
+// window with nothing new writes nothing
+func TestDeliveriesAreCoalesced(t *testing.T) {
+	const burst = 5
+	receipts := &memoryReceipts{}
+	hub := NewHub(store.Storage{Messages: newMemoryMessages(), Rooms: roomSettings{}, Receipts: receipts}, 1)
+	shard := hub.shards[0]
+	// Only the test flushes
+	shard.deliveryInterval = time.Hour
+	hub.SetRoomStatsInterval(0)
+	go hub.Run()
+
+	sender := newTestClient(hub, 1, 1, 64)
+	grace := newTestClient(hub, 2, 1, 64)
+	linus := newTestClient(hub, 3, 1, 64)
+	guest := newTestClient(hub, 4, 1, 64)
+	guest.readOnly = true
+	for _, client := range []*Client{sender, grace, linus, guest} {
+		hub.register(client)
+	}
+	for range burst {
+		hub.broadcast(&Message{Message: wire.Message{RoomID: 1, UserID: 1, Type: "message", Content: "hello"}})
+	}
+	// The broadcasts have been handled once grace has every message, after
+	// their own join and linus's
+	if !testutil.WaitFor(5*time.Second, func() bool { return len(grace.send) >= 2+burst }) {
+		t.Fatal("the burst never reached grace")
+	}
+
+	flush := func() { shard.do(shard.flushDeliveries) }
+	flush()
+	want := map[int64]int64{2: burst, 3: burst}
+	for _, client := range []*Client{grace, linus} {
+		if got := deliveredFrames(t, client); !sameDeliveries(got, want) {
+			t.Errorf("user %d was told %v, want %v", client.userID, got, want)
+		}
+	}
+	if !testutil.WaitFor(5*time.Second, func() bool { return len(receipts.written()) == 1 }) {
+		t.Fatal("the window's deliveries were never written")
+	}
+	marks := make(map[int64]int64)
+	for _, mark := range receipts.written()[0] {
+		marks[mark.UserID] = mark.MessageID
+	}
+	if !sameDeliveries(marks, want) {
+		t.Errorf("wrote %v, want %v", marks, want)
+	}
+
+	flush()
+	if got := deliveredFrames(t, grace); len(got) != 0 {
+		t.Errorf("an empty window announced %v", got)
+	}
+	// Writes go out from their own goroutine; give a stray one time to show
+	time.Sleep(50 * time.Millisecond)
+	if batches := len(receipts.written()); batches != 1 {
+		t.Errorf("an empty window wrote a batch; %d in all, want 1", batches)
+	}
+}
+
+// TestDeliveriesFlushOnTheTicker leaves flushing to the shard: a message's
+// delivery is written within a window without anyone asking
+func TestDeliveriesFlushOnTheTicker(t *testing.T) {
+	receipts := &memoryReceipts{}
+	hub := NewHub(store.Storage{Messages: newMemoryMessages(), Rooms: roomSettings{}, Receipts: receipts}, 1)
+	hub.shards[0].deliveryInterval = 20 * time.Millisecond
+	hub.SetRoomStatsInterval(0)
+	go hub.Run()
+
+	hub.register(newTestClient(hub, 1, 1, 64))
+	hub.register(newTestClient(hub, 2, 1, 64))
+	hub.broadcast(&Message{Message: wire.Message{RoomID: 1, UserID: 1, Type: "message", Content: "hello"}})
+
+	if !testutil.WaitFor(time.Second, func() bool { return len(receipts.written()) > 0 }) {
+		t.Fatal("the delivery was never flushed")
+	}
+	if marks := receipts.written()[0]; len(marks) != 1 || marks[0] != (store.DeliveryMark{RoomID: 1, UserID: 2, MessageID: 1}) {
+		t.Errorf("wrote %+v, want user 2 up to message 1", marks)
+	}
+}
+
+// sameDeliveries reports whether two user-to-message maps are equal
+func sameDeliveries(a, b map[int64]int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for userID, messageID := range a {
+		if b[userID] != messageID {
+			return false
+		}
+	}
+	return true
+}
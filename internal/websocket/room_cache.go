@@ -0,0 +1,77 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// roomCacheEntry is one room's cached value
+type roomCacheEntry[V any] struct {
+	value    V
+	loadedAt time.Time
+}
+
+// roomCache remembers a setting per room (quiet hours, post policy, language,
+// member count) so the checks made for every message or flush don't cost a
+// query each
+// Shared by all shards, and by the REST send path for the settings it checks,
+// so it has its own lock
+type roomCache[V any] struct {
+	ttl  time.Duration
+	load func(ctx context.Context, st store.Storage, roomID int64) (V, error)
+
+	mu    sync.Mutex
+	rooms map[int64]roomCacheEntry[V]
+}
+
+// newRoomCache creates a cache that reloads a room's value with load once it's
+// older than ttl
+// Changes made through this instance take effect at once (see set and
+// forget); the TTL only bounds how stale another instance's change can be
+func newRoomCache[V any](ttl time.Duration, load func(context.Context, store.Storage, int64) (V, error)) *roomCache[V] {
+	return &roomCache[V]{ttl: ttl, load: load, rooms: make(map[int64]roomCacheEntry[V])}
+}
+
+// get returns a room's value, loading it if it isn't cached or is stale
+// A failed load isn't cached, so the next call tries again
+func (c *roomCache[V]) get(ctx context.Context, st store.Storage, roomID int64) (V, error) {
+	c.mu.Lock()
+	entry, ok := c.rooms[roomID]
+	c.mu.Unlock()
+	if ok && time.Since(entry.loadedAt) < c.ttl {
+		return entry.value, nil
+	}
+
+	value, err := c.load(ctx, st, roomID)
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	c.set(roomID, value)
+	return value, nil
+}
+
+// peek returns a room's cached value, stale or not, without loading it
+func (c *roomCache[V]) peek(roomID int64) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.rooms[roomID]
+	return entry.value, ok
+}
+
+// set caches a room's value, such as one just saved
+func (c *roomCache[V]) set(roomID int64, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rooms[roomID] = roomCacheEntry[V]{value: value, loadedAt: time.Now()}
+}
+
+// forget drops a room from the cache
+func (c *roomCache[V]) forget(roomID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.rooms, roomID)
+}
@@ -0,0 +1,50 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// TestRoomCache loads a room's value once per TTL, reloads it after forget,
+// keeps a value set directly and doesn't cache a failed load
+func TestRoomCache(t *testing.T) {
+	loads := 0
+	var failure error
+	cache := newRoomCache(time.Hour, func(ctx context.Context, st store.Storage, roomID int64) (int, error) {
+		loads++
+		return int(roomID) * 10, failure
+	})
+	ctx, st := context.Background(), store.Storage{}
+
+	for i := 0; i < 2; i++ {
+		if value, err := cache.get(ctx, st, 1); err != nil || value != 10 {
+			t.Fatalf("got %d, %v, want 10", value, err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("a fresh value was loaded %d times, want once", loads)
+	}
+
+	cache.forget(1)
+	cache.get(ctx, st, 1)
+	if loads != 2 {
+		t.Errorf("a forgotten room wasn't reloaded (%d loads)", loads)
+	}
+
+	cache.set(1, 42)
+	if value, _ := cache.get(ctx, st, 1); value != 42 || loads != 2 {
+		t.Errorf("got %d after setting 42 (%d loads)", value, loads)
+	}
+
+	failure = errors.New("database is down")
+	if _, err := cache.get(ctx, st, 2); err == nil {
+		t.Error("a failed load returned no error")
+	}
+	if _, ok := cache.peek(2); ok {
+		t.Error("a failed load was cached")
+	}
+}
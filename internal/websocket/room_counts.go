@@ -0,0 +1,38 @@
+package websocket
+
+// GetRoomCounts returns how many distinct members are connected to each room
+// Rooms nobody is connected to are missing from the result
+// Each shard is asked once for all of its rooms, so the cost doesn't grow with
+// the number of rooms the way calling GetRoomClientCount per room would
+// Safe to call from handlers
+func (h *Hub) GetRoomCounts(roomIDs []int64) map[int64]int {
+	byShard := make(map[*shard][]int64)
+	for _, roomID := range roomIDs {
+		s := h.shardFor(roomID)
+		byShard[s] = append(byShard[s], roomID)
+	}
+
+	counts := make(map[int64]int, len(roomIDs))
+	for s, ids := range byShard {
+		s.do(func() {
+			for _, roomID := range ids {
+				if n := s.onlineMemberCount(roomID); n > 0 {
+					counts[roomID] = n
+				}
+			}
+		})
+	}
+	return counts
+}
+
+// onlineMemberCount counts the distinct members connected to a room
+// A user with several tabs open counts once; read-only guests don't count
+func (s *shard) onlineMemberCount(roomID int64) int {
+	users := make(map[int64]struct{})
+	for client := range s.rooms[roomID] {
+		if !client.readOnly {
+			users[client.userID] = struct{}{}
+		}
+	}
+	return len(users)
+}
@@ -0,0 +1,39 @@
+package websocket
+
+import (
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/testutil"
+)
+
+// TestGetRoomCounts counts distinct members per room across shards: two
+// tabs count once, a read-only guest not at all, and rooms nobody is in are
+// left out
+func TestGetRoomCounts(t *testing.T) {
+	hub := newTestHub(4)
+	go hub.Run()
+
+	guest := newTestClient(hub, 0, 5, 64)
+	guest.readOnly = true
+	for _, client := range []*Client{
+		newTestClient(hub, 1, 3, 64),
+		newTestClient(hub, 1, 3, 64),
+		newTestClient(hub, 2, 3, 64),
+		newTestClient(hub, 2, 4, 64),
+		guest,
+	} {
+		go drainFrames(client)
+		hub.register(client)
+	}
+
+	want := map[int64]int{3: 2, 4: 1}
+	var got map[int64]int
+	if !testutil.WaitFor(5*time.Second, func() bool {
+		got = hub.GetRoomCounts([]int64{3, 4, 5, 6})
+		return maps.Equal(got, want)
+	}) {
+		t.Errorf("got counts %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,151 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// Room stats
+//
+// Clients show "42 members, 7 online" in the room header. Instead of polling,
+// they get {"type": "room_stats", "room_id": 5, "online": 7, "members": 42}
+// whenever either number changes. Changes are coalesced: a room that changed
+// is marked dirty and each shard sends its dirty rooms' stats once per
+// interval (see SetRoomStatsInterval), so a burst of joins produces one frame.
+// A room whose numbers end up where they were sends nothing; a connection
+// that hasn't had the numbers yet gets them on the next flush either way
+//
+// online is the number of distinct members connected (guests don't count);
+// members comes from the database through memberCountCache, which handlers
+// invalidate with Hub.MembersChanged after adding or removing members
+
+const (
+	// defaultRoomStatsInterval is how often a shard sends the stats of rooms that changed
+	// It's also the most often one room gets a room_stats frame
+	defaultRoomStatsInterval = 5 * time.Second
+
+	// memberCountTTL bounds how stale a cached member count can get when the
+	// change was made by another instance
+	memberCountTTL = time.Minute
+
+	// memberCountTimeout bounds the store queries of one flush
+	memberCountTimeout = 5 * time.Second
+)
+
+// roomStats are the numbers sent in a room_stats frame
+type roomStats struct {
+	online  int
+	members int
+}
+
+// loadMemberCount loads a room's member count into the hub's cache
+func loadMemberCount(ctx context.Context, st store.Storage, roomID int64) (int, error) {
+	return st.RoomMembers.GetRoomMemberCount(ctx, roomID)
+}
+
+// MembersChanged tells the hub a room's members changed
+// The cached member count is dropped and the room's clients get fresh stats
+// within the stats interval. Call it after joins, leaves, kicks and bulk changes
+// Safe to call from any goroutine
+func (h *Hub) MembersChanged(roomID int64) {
+	h.memberCounts.forget(roomID)
+	s := h.shardFor(roomID)
+	s.post(func() {
+		s.markStatsDirty(roomID)
+	})
+}
+
+// SetRoomStatsInterval sets how often room_stats frames may go out per room
+// Zero or less turns them off, which tools driving a hub without a database need
+// Must be called before Run
+func (h *Hub) SetRoomStatsInterval(interval time.Duration) {
+	for _, s := range h.shards {
+		s.statsInterval = interval
+	}
+}
+
+// markStatsDirty schedules a room's stats for the next flush
+// Rooms nobody is connected to are skipped; whoever connects next marks it again
+// Must only be called from the shard's loop
+func (s *shard) markStatsDirty(roomID int64) {
+	if s.statsInterval <= 0 {
+		return
+	}
+	if _, ok := s.rooms[roomID]; ok {
+		s.statsDirty[roomID] = true
+	}
+}
+
+// flushRoomStats starts sending the stats of the rooms that changed
+// Member counts may need the database, so they're loaded on a goroutine and
+// the frames are sent back on the loop. Only one load runs at a time; rooms
+// that change meanwhile wait for the next tick
+// Must only be called from the shard's loop
+func (s *shard) flushRoomStats() {
+	if len(s.statsDirty) == 0 || s.statsLoading {
+		return
+	}
+
+	roomIDs := make([]int64, 0, len(s.statsDirty))
+	for roomID := range s.statsDirty {
+		roomIDs = append(roomIDs, roomID)
+	}
+	clear(s.statsDirty)
+	s.statsLoading = true
+
+	go func() {
+		ctx, cancel := context.WithTimeout(s.ctx, memberCountTimeout)
+		defer cancel()
+
+		members := make(map[int64]int, len(roomIDs))
+		for _, roomID := range roomIDs {
+			count, err := s.memberCounts.get(ctx, s.store, roomID)
+			if err != nil {
+				log.Printf("Failed to load member count of room %d: %v", roomID, err)
+				continue
+			}
+			members[roomID] = count
+		}
+
+		s.post(func() {
+			s.statsLoading = false
+			for roomID, count := range members {
+				s.sendRoomStats(roomID, count)
+			}
+		})
+	}()
+}
+
+// sendRoomStats sends a room's current stats to the clients that need them
+// Everyone gets them if they changed since the last frame; otherwise only
+// connections that haven't had them yet
+// Must only be called from the shard's loop
+func (s *shard) sendRoomStats(roomID int64, members int) {
+	clients, ok := s.rooms[roomID]
+	if !ok {
+		return
+	}
+
+	stats := roomStats{online: s.onlineMemberCount(roomID), members: members}
+	changed := s.statsSent[roomID] != stats
+	s.statsSent[roomID] = stats
+
+	message := &Message{Message: wire.Message{RoomID: roomID, Type: "room_stats", Online: &stats.online, Members: &stats.members}}
+	if changed {
+		for client := range clients {
+			client.hasRoomStats = true
+		}
+		s.broadcastToRoom(roomID, message)
+		return
+	}
+	for client := range clients {
+		if !client.hasRoomStats && client.filter.allows(message.Type) {
+			client.hasRoomStats = true
+			s.deliverToClient(client, message)
+		}
+	}
+}
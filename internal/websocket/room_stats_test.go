@@ -0,0 +1,141 @@
+package websocket
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/internal/testutil"
+)
+
+// memoryMemberCounts answers GetRoomMemberCount from a map and counts the calls
+// Room stats need nothing else from RoomMembers; the other methods are the
+// real store's on no database and would panic
+type memoryMemberCounts struct {
+	*store.RoomMemberStore
+	mu      sync.Mutex
+	counts  map[int64]int
+	queries int
+}
+
+func (m *memoryMemberCounts) GetRoomMemberCount(_ context.Context, roomID int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries++
+	return m.counts[roomID], nil
+}
+
+func (m *memoryMemberCounts) set(roomID int64, count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[roomID] = count
+}
+
+func (m *memoryMemberCounts) queried() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.queries
+}
+
+// TestRoomStats drives a shard's room stats flushes by hand: changes between
+// two flushes produce one frame with the final numbers, guests get it without
+// counting as online, a flush with nothing new sends nothing, a new connection
+// gets the numbers even when they didn't change, and member counts are cached
+// until MembersChanged
+func TestRoomStats(t *testing.T) {
+	const timeout = 2 * time.Second
+	members := &memoryMemberCounts{counts: map[int64]int{1: 42}}
+	hub := NewHub(store.Storage{RoomMembers: members}, 1)
+	hub.SetRoomStatsInterval(time.Hour) // The test flushes itself
+	go hub.Run()
+	s := hub.shards[0]
+
+	// flush sends the stats of the rooms that changed and waits for the frames to go out
+	flush := func() {
+		t.Helper()
+		s.do(s.flushRoomStats)
+		loaded := testutil.WaitFor(timeout, func() bool {
+			var loading bool
+			s.do(func() { loading = s.statsLoading })
+			return !loading
+		})
+		if !loaded {
+			t.Fatal("the member counts never loaded")
+		}
+	}
+	var clients []*Client
+	connect := func(userID int64, readOnly bool) *Client {
+		client := newTestClient(hub, userID, 1, 256)
+		client.readOnly = readOnly
+		hub.register(client)
+		clients = append(clients, client)
+		return client
+	}
+	defer func() {
+		for _, client := range clients {
+			hub.unregister(client)
+		}
+	}()
+	// expect checks a client's next room_stats frame, or that none comes if want is nil
+	expect := func(name string, client *Client, want *roomStats) {
+		t.Helper()
+		wait := timeout
+		if want == nil {
+			wait = 100 * time.Millisecond // It would already be queued
+		}
+		frame := nextFrame(client, "room_stats", wait)
+		switch {
+		case want == nil && frame != nil:
+			t.Errorf("%s got room_stats online=%d members=%d, want none", name, *frame.Online, *frame.Members)
+		case want != nil && frame == nil:
+			t.Errorf("%s got no room_stats, want online=%d members=%d", name, want.online, want.members)
+		case want != nil && (frame.RoomID != 1 || *frame.Online != want.online || *frame.Members != want.members):
+			t.Errorf("%s got room %d online=%d members=%d, want room 1 online=%d members=%d",
+				name, frame.RoomID, *frame.Online, *frame.Members, want.online, want.members)
+		}
+	}
+
+	// Twenty users, one of them twice, and a guest join within one interval
+	observer := connect(1, false)
+	for userID := int64(2); userID <= 20; userID++ {
+		connect(userID, false)
+	}
+	connect(2, false)
+	guest := connect(99, true)
+	flush()
+	expect("the observer", observer, &roomStats{online: 20, members: 42})
+	expect("the observer's second frame", observer, nil)
+	expect("the guest", guest, &roomStats{online: 20, members: 42})
+
+	// Nothing changed
+	flush()
+	expect("the observer after an idle flush", observer, nil)
+
+	// Another connection of a user already online changes no number, but
+	// still needs them
+	late := connect(3, false)
+	flush()
+	expect("the new connection", late, &roomStats{online: 20, members: 42})
+	expect("the observer after an unchanged flush", observer, nil)
+	if n := members.queried(); n != 1 {
+		t.Errorf("the member count was queried %d times, want once", n)
+	}
+
+	// Members changed elsewhere: the count is reloaded
+	members.set(1, 43)
+	hub.MembersChanged(1)
+	flush()
+	expect("the observer after MembersChanged", observer, &roomStats{online: 20, members: 43})
+	if n := members.queried(); n != 2 {
+		t.Errorf("the member count was queried %d times, want twice", n)
+	}
+
+	// Someone leaves
+	hub.unregister(clients[19]) // User 20
+	clients = slices.Delete(clients, 19, 20)
+	flush()
+	expect("the observer after a leave", observer, &roomStats{online: 19, members: 43})
+}
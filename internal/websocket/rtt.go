@@ -0,0 +1,148 @@
+package websocket
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+const (
+	// rttAlpha is the weight of the newest sample in the RTT average
+	// 0.2 smooths out a single slow pong but follows a real change within a few pings
+	rttAlpha = 0.2
+
+	// slowRTTPings is how many pings in a row must be over the slow threshold
+	// before the connection is logged, so one hiccup doesn't fill the log
+	slowRTTPings = 3
+
+	// defaultSlowRTT is the slow threshold unless the tunables change it
+	defaultSlowRTT = 500 * time.Millisecond
+)
+
+// rttTracker measures a connection's round-trip time from ping/pong pairs
+// writePump puts the send time in each ping's payload and the peer echoes it
+// back in the pong, so the tracker needs no record of pings in flight
+// Only the read goroutine (the pong handler) writes; the shard loop reads
+// the average for stats, so the fields are atomics
+type rttTracker struct {
+	ewma    atomic.Int64 // Average RTT in microseconds
+	samples atomic.Int64 // Pongs measured
+	slow    int          // Consecutive samples over the slow threshold; read goroutine only
+}
+
+// RTTStats summarizes the round-trip times of a set of connections
+// Each connection contributes its average; connections that haven't
+// answered a ping yet are left out
+type RTTStats struct {
+	Connections int     `json:"connections"`
+	P50         float64 `json:"p50_ms"`
+	P95         float64 `json:"p95_ms"`
+	P99         float64 `json:"p99_ms"`
+}
+
+// SetPingStats makes the client receive a "ping_stats" frame with its
+// round-trip time after every measured ping
+// It must be called before Start; usually from the ?ping_stats= query parameter
+func (c *Client) SetPingStats(enabled bool) {
+	c.pingStats = enabled
+}
+
+// pingPayload is the body of the next ping: the current time in nanoseconds
+func pingPayload() []byte {
+	return strconv.AppendInt(nil, time.Now().UnixNano(), 10)
+}
+
+// handlePong records the round trip of an answered ping
+// Runs on the read goroutine as the connection's pong handler
+func (c *Client) handlePong(payload string) {
+	sent, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		// Not one of our pings (or a peer that doesn't echo payloads)
+		return
+	}
+	rtt := time.Since(time.Unix(0, sent))
+	if rtt < 0 {
+		return
+	}
+
+	average := c.rtt.record(rtt)
+
+	if threshold := c.hub.tuning.Load().SlowRTT; threshold > 0 {
+		if rtt > threshold {
+			c.rtt.slow++
+			if c.rtt.slow == slowRTTPings {
+				log.Printf("Slow connection: user=%d room=%d rtt=%s average=%s (over %s for %d pings)",
+					c.userID, c.roomID, rtt.Round(time.Millisecond), average.Round(time.Millisecond), threshold, slowRTTPings)
+			}
+		} else {
+			c.rtt.slow = 0
+		}
+	}
+
+	if c.pingStats {
+		c.hub.sendToClient(c, &Message{Message: wire.Message{RoomID: c.roomID, Type: "ping_stats", RTTMs: durationMs(average)}})
+	}
+}
+
+// record adds a sample and returns the new average
+// The first sample is taken as is, so the average doesn't have to climb up from zero
+func (t *rttTracker) record(rtt time.Duration) time.Duration {
+	sample := rtt.Microseconds()
+	average := sample
+	if t.samples.Load() > 0 {
+		previous := t.ewma.Load()
+		average = previous + int64(math.Round(rttAlpha*float64(sample-previous)))
+	}
+	t.ewma.Store(average)
+	t.samples.Add(1)
+	return time.Duration(average) * time.Microsecond
+}
+
+// average returns the current average and whether there is one yet
+func (t *rttTracker) average() (time.Duration, bool) {
+	if t.samples.Load() == 0 {
+		return 0, false
+	}
+	return time.Duration(t.ewma.Load()) * time.Microsecond, true
+}
+
+// rttSamples collects the averages of clients that have one
+func rttSamples(clients map[*Client]bool, into []time.Duration) []time.Duration {
+	for client := range clients {
+		if average, ok := client.rtt.average(); ok {
+			into = append(into, average)
+		}
+	}
+	return into
+}
+
+// summarizeRTT computes percentiles over connection averages
+// Returns nil when there are none, so the stats field is left out
+func summarizeRTT(samples []time.Duration) *RTTStats {
+	if len(samples) == 0 {
+		return nil
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return &RTTStats{
+		Connections: len(samples),
+		P50:         durationMs(percentile(samples, 50)),
+		P95:         durationMs(percentile(samples, 95)),
+		P99:         durationMs(percentile(samples, 99)),
+	}
+}
+
+// percentile returns the nearest-rank percentile of sorted samples
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// durationMs converts a duration to milliseconds, rounded to 0.1ms
+func durationMs(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Millisecond)*10) / 10
+}
@@ -0,0 +1,159 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestRTTAverage feeds the tracker samples by hand: the first is taken as
+// is, then the average moves a fifth of the way to each new one
+func TestRTTAverage(t *testing.T) {
+	var tracker rttTracker
+	if _, ok := tracker.average(); ok {
+		t.Error("a tracker with no samples has an average")
+	}
+	if got := tracker.record(100 * time.Millisecond); got != 100*time.Millisecond {
+		t.Errorf("the first sample gave %s, want 100ms", got)
+	}
+	if got := tracker.record(200 * time.Millisecond); got != 120*time.Millisecond {
+		t.Errorf("the second sample gave %s, want 120ms", got)
+	}
+	for range 30 {
+		tracker.record(200 * time.Millisecond)
+	}
+	if got, _ := tracker.average(); got < 199*time.Millisecond || got > 200*time.Millisecond {
+		t.Errorf("after 30 more 200ms samples the average is %s", got)
+	}
+}
+
+// TestSummarizeRTT checks the nearest-rank percentiles
+func TestSummarizeRTT(t *testing.T) {
+	if summarizeRTT(nil) != nil {
+		t.Error("no samples gave a summary")
+	}
+	var samples []time.Duration
+	for i := 100; i >= 1; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	got := summarizeRTT(samples)
+	want := RTTStats{Connections: 100, P50: 50, P95: 95, P99: 99}
+	if *got != want {
+		t.Errorf("got %+v, want %+v", *got, want)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe to use as the log's output while the
+// hub's goroutines write to it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestRTTLoopback pings a real connection whose peer waits before each
+// pong: first not at all, then 40ms. The client is sent its average after
+// every ping, which climbs towards 40ms, and shows up in hub stats and the
+// snapshot; three slow pings in a row are logged
+func TestRTTLoopback(t *testing.T) {
+	logged := &lockedBuffer{}
+	log.SetOutput(logged)
+	t.Cleanup(func() { log.SetOutput(io.Discard) })
+
+	hub := newTestHub(1)
+	tunables := DefaultTunables()
+	tunables.SlowRTT = 20 * time.Millisecond
+	hub.SetTunables(tunables)
+	go hub.Run()
+
+	// The server side's conn is kept so the test can ping without waiting
+	// for writePump's ticker; WriteControl is safe alongside its writes
+	serverConns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := NewClient(hub, conn, 1, "user1", 1)
+		client.SetPingStats(true)
+		client.Start()
+		serverConns <- conn
+	}))
+	t.Cleanup(server.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	serverConn := <-serverConns
+	framesUntil(t, conn, "join")
+
+	var delay time.Duration
+	conn.SetPingHandler(func(payload string) error {
+		time.Sleep(delay)
+		return conn.WriteControl(websocket.PongMessage, []byte(payload), time.Now().Add(time.Second))
+	})
+	ping := func() float64 {
+		t.Helper()
+		if err := serverConn.WriteControl(websocket.PingMessage, pingPayload(), time.Now().Add(time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		frames := framesUntil(t, conn, "ping_stats")
+		return frames[len(frames)-1].RTTMs
+	}
+
+	for range 3 {
+		if rtt := ping(); rtt > 20 {
+			t.Fatalf("an immediate pong measured %.1fms", rtt)
+		}
+	}
+	if strings.Contains(logged.String(), "Slow connection") {
+		t.Error("a fast connection was logged as slow")
+	}
+
+	delay = 40 * time.Millisecond
+	var rtts []float64
+	for range 15 {
+		rtts = append(rtts, ping())
+	}
+	// 1 - 0.8^15 of the way from about 0 to 40ms is about 38.6ms
+	if last := rtts[len(rtts)-1]; last < 35 || last > 80 {
+		t.Errorf("after 15 slow pongs the average is %.1fms, want about 40ms (%v)", last, rtts)
+	}
+	if rtts[0] >= rtts[len(rtts)-1] {
+		t.Errorf("the average didn't climb: %v", rtts)
+	}
+	if !strings.Contains(logged.String(), "Slow connection: user=1 room=1") {
+		t.Error("the slow connection wasn't logged")
+	}
+
+	stats := hub.Stats()
+	if stats.RTT == nil || stats.RTT.Connections != 1 || stats.RTT.P50 != rtts[len(rtts)-1] {
+		t.Errorf("hub stats have RTT %+v, want one connection at %.1fms", stats.RTT, rtts[len(rtts)-1])
+	}
+	snapshot := hub.Snapshot()
+	room := snapshot.RoomList[0]
+	if room.RTT == nil || room.Connections[0].RTTMs != rtts[len(rtts)-1] {
+		t.Errorf("the snapshot has room RTT %+v and connection %+v", room.RTT, room.Connections[0])
+	}
+}
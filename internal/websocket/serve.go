@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// upgrader configures the WebSocket upgrade
+var upgrader = websocket.Upgrader{
+	// ReadBufferSize and WriteBufferSize specify I/O buffer sizes
+	// 1024 bytes is sufficient for our chat messages
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+
+	// CheckOrigin allows every origin here: callers of ServeWS check origins
+	// themselves (the API checks ALLOWED_ORIGINS, which can be reloaded)
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// ClientOptions describes the connection ServeWS sets up
+// Everything except the identity is optional; the zero value of each option
+// is what a plain browser connection gets
+type ClientOptions struct {
+	UserID   int64 // Zero for guests
+	Username string
+	RoomID   int64
+
+	// Guest makes a read-only connection for an anonymous viewer (see NewGuestClient)
+	Guest bool
+
+	// SessionID is the login session the connection belongs to (see SetSession)
+	SessionID int64
+
+	// Events limits the room events the client receives; nil means all of them (see SetEventFilter)
+	Events []string
+
+	PingStats    bool // Send "ping_stats" frames (see SetPingStats)
+	SuppressEcho bool // Don't send the client its own chat messages (see SetSuppressEcho)
+	AllowSilent  bool // Let the client send silent messages (see AllowSilent)
+	DenyPosting  bool // Refuse the client's chat messages (see DenyPosting)
+
+	// MentionEveryone lets the client notify the room with @here and @room (see AllowMentionEveryone)
+	MentionEveryone bool
+
+	// Role is the user's role in the room, which decides whether they may post
+	// in an announcement room (see SetRoomRole)
+	Role string
+}
+
+// ServeWS upgrades a request to a WebSocket connection, negotiates its frame
+// format and starts a client for it on the hub
+// The caller authenticates the request and decides whether it may connect
+// (membership, origin, draining) before calling it. If the upgrade fails, the
+// upgrader has already answered the request and the error is returned
+func ServeWS(hub *Hub, w http.ResponseWriter, r *http.Request, opts ClientOptions) (*Client, error) {
+	proto := Negotiate(r)
+	conn, err := upgrader.Upgrade(w, r, proto.ResponseHeader())
+	if err != nil {
+		return nil, err
+	}
+
+	// The room's language is loaded here, off the shard loop, so the
+	// client's join is announced in it
+	hub.RoomLanguage(r.Context(), opts.RoomID)
+	// Likewise the post policy, which the client's messages are checked against
+	if !opts.Guest {
+		hub.RoomPostPolicy(r.Context(), opts.RoomID)
+	}
+
+	var client *Client
+	if opts.Guest {
+		client = NewGuestClient(hub, conn, opts.Username, opts.RoomID)
+	} else {
+		client = NewClient(hub, conn, opts.UserID, opts.Username, opts.RoomID)
+	}
+	client.SetEventFilter(opts.Events)
+	client.SetProtocol(proto)
+	if opts.SessionID != 0 {
+		client.SetSession(opts.SessionID)
+	}
+	client.SetPingStats(opts.PingStats)
+	client.SetSuppressEcho(opts.SuppressEcho)
+	if opts.AllowSilent {
+		client.AllowSilent()
+	}
+	if opts.DenyPosting {
+		client.DenyPosting()
+	}
+	if opts.MentionEveryone {
+		client.AllowMentionEveryone()
+	}
+	client.SetRoomRole(opts.Role)
+
+	// Register the client with the hub and start goroutines for reading and writing
+	client.Start()
+	return client, nil
+}
@@ -0,0 +1,645 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/content"
+	"github.com/drazan344/go-chat/internal/flags"
+	"github.com/drazan344/go-chat/internal/notify"
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// shard owns a subset of the hub's rooms and runs its own event loop
+// Every room is handled by exactly one shard, so events within a room are
+// still processed in order, while rooms on different shards proceed in parallel
+type shard struct {
+	// Index of this shard, used in log lines
+	id int
+
+	// Registered clients organized by room ID
+	// map[roomID]map[*Client]bool
+	// The inner map acts as a set (we only care about keys, values are always true)
+	rooms map[int64]map[*Client]bool
+
+	// Inbound messages from the clients
+	// Messages are sent to this channel from client.readPump()
+	broadcast chan *Message
+
+	// Register requests from the clients
+	// Sent when a new WebSocket connection is established
+	register chan *Client
+
+	// Unregister requests from clients
+	// Sent when a WebSocket connection is closed
+	unregister chan *Client
+
+	// Frames addressed to one specific client
+	// Routed through the shard so only the shard ever writes to (and closes) client.send
+	direct chan *directMessage
+
+	// Functions to run on the shard's loop
+	// This is how other goroutines read shard state without racing the loop
+	requests chan func()
+
+	// Highest message ID delivered per room and user since the last flush
+	// map[roomID]map[userID]messageID
+	deliveries map[int64]map[int64]int64
+
+	// How long deliveries are coalesced; deliveryFlushInterval unless a test
+	// changes it before the shard starts
+	deliveryInterval time.Duration
+
+	// Storage layer for persisting messages
+	store store.Storage
+
+	// The hub's context, the parent of the shard's store calls (see Hub.SetContext)
+	ctx context.Context
+
+	// Content filter applied to chat messages before they're saved
+	filter content.Filter
+
+	// Decides which mentioned users get chat messages flagged with "notify"; may be nil
+	notifications notify.Policy
+
+	// Feature flags for behaviour being rolled out gradually; may be nil, which
+	// leaves every flagged feature off
+	featureFlags flags.Checker
+
+	// Users present in each room, across all their connections
+	// map[roomID]map[userID]*presence
+	presence map[int64]map[int64]*presence
+
+	// How long a disconnected user stays present before their leave is announced
+	presenceGrace time.Duration
+
+	// Integration callbacks, shared by all shards of a hub
+	hooks *HookRegistry
+
+	// Clients disconnected because their send buffer was full, at the hub's
+	// hard memory limit, and because frames stopped reaching them (see liveness.go)
+	droppedClients int64
+	shedClients    int64
+	staleClients   int64
+
+	// Bytes queued in send channels, shared by all shards of a hub (see memory.go)
+	memory *memoryAccount
+
+	// Clients in a throttling episode and the shard's throttling counters
+	// (see throttle.go); the number throttled is len(throttled)
+	throttled      map[*Client]bool
+	throttleCounts ThrottleStats
+
+	// The hub's tunables (duplicate limit, ...), shared by all shards of a hub
+	tuning *tunablesPointer
+
+	// Which users have open connections, shared by all shards of a hub
+	online *onlineIndex
+
+	// Users' event streams, shared by all shards of a hub (see user_events.go)
+	userEvents *userEvents
+
+	// Rooms' quiet hours, shared by all shards of a hub
+	quiet *roomCache[quietEntry]
+
+	// Rooms' languages, shared by all shards of a hub; only peeked at here
+	languages *languageCache
+
+	// Checks room broadcasts are delivered in order; nil unless auditing is on
+	audit *sequenceAudit
+
+	// Rooms' member counts, shared by all shards of a hub
+	memberCounts *roomCache[int]
+
+	// Users' @room allowance, shared by all shards of a hub (see mentions.go)
+	roomMentions *roomMentionLimiter
+
+	// Rooms' post policies, shared by all shards of a hub (see post_policy.go)
+	postPolicies *roomCache[string]
+
+	// Rooms' moderation bots, shared by all shards of a hub; nil when they're off
+	// moderationPending counts each room's messages still with its bot
+	moderation        *moderationHooks
+	moderationPending map[int64]int
+
+	// Rooms whose stats changed since the last flush, the stats last sent to
+	// each room, and whether a flush is loading member counts (see room_stats.go)
+	statsDirty    map[int64]bool
+	statsSent     map[int64]roomStats
+	statsLoading  bool
+	statsInterval time.Duration
+
+	// How often connected users' memberships are checked, and whether a
+	// check is loading members (see membership.go)
+	sweepInterval time.Duration
+	sweeping      bool
+}
+
+// newShard creates a shard; it must be started with shard.run() in a goroutine
+func newShard(id int, store store.Storage) *shard {
+	return &shard{
+		id:            id,
+		broadcast:     make(chan *Message, 256), // Buffered to prevent blocking
+		register:      make(chan *Client),
+		unregister:    make(chan *Client),
+		direct:        make(chan *directMessage, 256),
+		requests:      make(chan func()),
+		rooms:         make(map[int64]map[*Client]bool),
+		deliveries:    make(map[int64]map[int64]int64),
+		store:         store,
+		filter:        content.NoopFilter{},
+		presence:      make(map[int64]map[int64]*presence),
+		presenceGrace: defaultPresenceGrace,
+		statsDirty:    make(map[int64]bool),
+		statsSent:     make(map[int64]roomStats),
+		statsInterval: defaultRoomStatsInterval,
+		sweepInterval: defaultMembershipSweepInterval,
+		throttled:     make(map[*Client]bool),
+
+		deliveryInterval:  deliveryFlushInterval,
+		moderationPending: make(map[int64]int),
+	}
+}
+
+// run is the shard's main event loop
+// The shard continuously listens on its channels and processes events
+func (s *shard) run() {
+	// Delivery receipts are batched and flushed on this ticker
+	deliveryTicker := time.NewTicker(s.deliveryInterval)
+	defer deliveryTicker.Stop()
+
+	// Room stats are coalesced and sent on this ticker, unless they're off
+	var statsTick <-chan time.Time
+	if s.statsInterval > 0 {
+		statsTicker := time.NewTicker(s.statsInterval)
+		defer statsTicker.Stop()
+		statsTick = statsTicker.C
+	}
+
+	// Throttled clients that stopped receiving frames are checked on this ticker
+	// Twice per window, so a window that just ended isn't missed by a hair
+	throttleTicker := time.NewTicker(throttleWindow / 2)
+	defer throttleTicker.Stop()
+
+	// Clients whose writes stopped getting through are looked for on this ticker
+	staleTicker := time.NewTicker(staleCheckInterval)
+	defer staleTicker.Stop()
+
+	// Connected users' memberships are checked on this ticker, unless it's off
+	var sweepTick <-chan time.Time
+	if s.sweepInterval > 0 {
+		sweepTicker := time.NewTicker(s.sweepInterval)
+		defer sweepTicker.Stop()
+		sweepTick = sweepTicker.C
+	}
+
+	for {
+		select {
+		case client := <-s.register:
+			// A new client wants to connect to a room
+			s.registerClient(client)
+
+		case client := <-s.unregister:
+			// A client disconnected from a room
+			s.unregisterClient(client)
+
+		case message := <-s.broadcast:
+			// A message needs to be broadcasted to all clients in a room
+			s.handleBroadcast(message)
+
+		case d := <-s.direct:
+			// A frame for a single client (e.g. an error reply)
+			s.deliverToClient(d.client, d.message)
+
+		case fn := <-s.requests:
+			// Another goroutine needs to read or change shard state
+			fn()
+
+		case <-deliveryTicker.C:
+			// Announce and persist the deliveries of the last window
+			s.flushDeliveries()
+
+		case <-statsTick:
+			// Send the stats of rooms whose members or connections changed
+			s.flushRoomStats()
+
+		case <-throttleTicker.C:
+			// End the throttling of clients that went quiet
+			s.checkThrottles()
+
+		case <-staleTicker.C:
+			// Disconnect clients that stopped receiving frames
+			s.checkStale()
+
+		case <-sweepTick:
+			// Disconnect users removed from their rooms without the hub being told
+			s.sweepMembership()
+		}
+	}
+}
+
+// do runs fn on the shard's loop and waits for it to finish
+// Use it for reads from outside the loop; fn must not block
+func (s *shard) do(fn func()) {
+	done := make(chan struct{})
+	s.requests <- func() {
+		fn()
+		close(done)
+	}
+	<-done
+}
+
+// post queues fn to run on the shard's loop without waiting for it
+// Use it for fire-and-forget work like targeted notifications; fn must not block
+func (s *shard) post(fn func()) {
+	s.requests <- fn
+}
+
+// registerClient adds a client to a room
+func (s *shard) registerClient(client *Client) {
+	// Check if room exists in the map
+	if s.rooms[client.roomID] == nil {
+		// Create a new set for this room
+		s.rooms[client.roomID] = make(map[*Client]bool)
+	}
+
+	// Add client to the room
+	s.rooms[client.roomID][client] = true
+	s.memory.clients.Add(1)
+	if s.audit != nil {
+		s.audit.start(client)
+	}
+
+	log.Printf("Client registered: user=%d room=%d shard=%d (total in room: %d)",
+		client.userID, client.roomID, s.id, len(s.rooms[client.roomID]))
+
+	// Guests get the room's stats too, they just don't count as online
+	s.markStatsDirty(client.roomID)
+
+	// Guests watch silently; announcing them would leak viewer counts to members
+	if client.readOnly {
+		return
+	}
+
+	s.online.add(client.userID)
+	s.hooks.emit(HookEvent{
+		Type:     EventClientJoined,
+		RoomID:   client.roomID,
+		UserID:   client.userID,
+		Username: client.username,
+	})
+
+	// Announce the user unless they're already here on another connection
+	// or are reconnecting within the grace period
+	s.userJoined(client)
+}
+
+// unregisterClient removes a client from a room
+func (s *shard) unregisterClient(client *Client) {
+	if clients, ok := s.rooms[client.roomID]; ok {
+		if _, ok := clients[client]; ok {
+			s.removeClient(client)
+
+			log.Printf("Client unregistered: user=%d room=%d (remaining in room: %d)",
+				client.userID, client.roomID, len(clients))
+		}
+	}
+}
+
+// removeClient takes a registered client out of its room and closes its send channel
+// Used both for normal disconnects and for clients dropped because their buffer is full
+func (s *shard) removeClient(client *Client) {
+	clients := s.rooms[client.roomID]
+
+	// Remove client from room
+	delete(clients, client)
+	delete(s.throttled, client)
+	s.memory.clients.Add(-1)
+
+	// Close the client's send channel
+	close(client.send)
+
+	// The leave is announced later, and only if this was the user's last connection
+	if !client.readOnly {
+		s.markStatsDirty(client.roomID)
+		s.userLeft(client)
+		s.online.remove(client.userID)
+		s.hooks.emit(HookEvent{
+			Type:     EventClientLeft,
+			RoomID:   client.roomID,
+			UserID:   client.userID,
+			Username: client.username,
+		})
+	}
+
+	// If room is empty, delete it from the map
+	if len(clients) == 0 {
+		delete(s.rooms, client.roomID)
+		delete(s.statsDirty, client.roomID)
+		delete(s.statsSent, client.roomID)
+		if s.audit != nil {
+			s.audit.forget(client.roomID)
+		}
+		log.Printf("Room %d is now empty and removed from hub", client.roomID)
+		s.hooks.emit(HookEvent{Type: EventRoomEmptied, RoomID: client.roomID})
+	}
+}
+
+// handleBroadcast processes incoming messages
+// It persists the message to the database and broadcasts it to all clients in the room
+// Messages injected after being saved elsewhere (see Hub.InjectMessage) skip straight to delivery
+func (s *shard) handleBroadcast(message *Message) {
+	// Only persist actual chat messages, not join/leave notifications
+	if message.Type == "message" && !message.persisted {
+		// The sender may have been evicted since sending it
+		if s.refuseRemoved(message) {
+			return
+		}
+
+		// Save message to database
+		// Using the hub's context since this is not tied to a specific HTTP request
+		// In production, you might want a context with timeout
+		ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+		defer cancel()
+
+		if message.moderation == nil {
+			// Repeated spam is dropped before anything else happens
+			// The hash is taken before moderation so masked copies still count as identical
+			message.contentHash = content.Hash(message.Content)
+			if s.tuning.Load().duplicates().exceeded(ctx, s.store, message.RoomID, message.UserID, message.contentHash) {
+				if message.sender != nil {
+					s.deliverToClient(message.sender, &Message{
+						Message: wire.Message{
+							RoomID:  message.RoomID,
+							Content: "you already sent this message several times",
+							Type:    "error",
+							Code:    "duplicate_message",
+						},
+					})
+				}
+				return
+			}
+
+			// Outside admins, nobody posts during the room's quiet hours
+			if !s.enforceQuietHours(ctx, message) {
+				return
+			}
+
+			// Nor at all in an announcement room
+			if !s.enforcePostPolicy(ctx, message) {
+				return
+			}
+
+			// Rooms with a moderation bot hand the message to it; it comes
+			// back through broadcast with the bot's outcome set
+			if s.sendToModerationHook(ctx, message) {
+				return
+			}
+		} else if !s.applyModerationOutcome(message) {
+			return
+		}
+
+		// Moderation happens before anything is stored or sent
+		if !s.moderate(ctx, message) {
+			return
+		}
+
+		// A refused message doesn't use up the sender's @room
+		if !s.limitRoomMention(message) {
+			return
+		}
+
+		dbMessage := message.StoreMessage()
+		dbMessage.ContentHash = message.contentHash
+
+		if err := s.store.Messages.Create(ctx, dbMessage); err != nil {
+			// A message that isn't saved isn't sent either: it would vanish
+			// from history, and receipts have no ID to refer to
+			log.Printf("Failed to save message to database: %v", err)
+			if message.RoomMention == content.MentionRoom {
+				s.roomMentions.refund(message.RoomID, message.UserID)
+			}
+			if message.sender != nil {
+				s.deliverToClient(message.sender, &Message{
+					Message: wire.Message{
+						RoomID:  message.RoomID,
+						Content: "failed to save message",
+						Type:    "error",
+						Code:    "message_save_failed",
+					},
+				})
+			}
+			return
+		}
+		// Clients need the ID for receipts, and deliveries are tracked by it
+		message.ID = dbMessage.ID
+		message.CreatedAt = &dbMessage.CreatedAt
+		message.persisted = true
+	}
+
+	if message.persisted {
+		// Hooks get their own copy; the original is still being broadcast
+		persisted := *message
+		persisted.sender = nil
+		s.hooks.emit(HookEvent{
+			Type:     EventMessagePersisted,
+			RoomID:   message.RoomID,
+			UserID:   message.UserID,
+			Username: message.Username,
+			Message:  &persisted,
+		})
+	}
+
+	// Mentioned users who want alerts get their copy flagged
+	s.markMentionAlerts(message)
+
+	// Broadcast message to all clients in the room
+	s.broadcastToRoom(message.RoomID, message)
+}
+
+// moderate runs a chat message through the content filter
+// Masked content replaces the message's content in place; a rejected message
+// is reported to its sender and moderate returns false
+func (s *shard) moderate(ctx context.Context, message *Message) bool {
+	verdict, rewritten := moderateContent(ctx, s.filter, s.store, message.RoomID, message.Content)
+
+	switch verdict {
+	case content.VerdictReject:
+		if message.sender != nil {
+			s.deliverToClient(message.sender, &Message{
+				Message: wire.Message{
+					RoomID:  message.RoomID,
+					Content: "message rejected by the content filter",
+					Type:    "error",
+					Code:    "content_rejected",
+				},
+			})
+		}
+		return false
+	case content.VerdictMask:
+		message.Content = rewritten
+		message.Filtered = true
+	}
+	return true
+}
+
+// moderateContent checks text against the filter, honouring the room's setting
+// Shared by the WebSocket path (on the shard loop) and Hub.Moderate (REST)
+// If the filter itself fails the message is let through, since a broken
+// wordlist shouldn't take chat down
+func moderateContent(ctx context.Context, filter content.Filter, st store.Storage, roomID int64, text string) (content.Verdict, string) {
+	if _, ok := filter.(content.NoopFilter); ok {
+		// Skip the room lookup when there is nothing to filter
+		return content.VerdictAllow, text
+	}
+
+	enabled, err := st.Rooms.IsContentFilterEnabled(ctx, roomID)
+	if err != nil {
+		log.Printf("Failed to check content filter setting for room %d: %v", roomID, err)
+		enabled = true
+	}
+	if !enabled {
+		return content.VerdictAllow, text
+	}
+
+	verdict, rewritten, err := filter.Check(ctx, text)
+	if err != nil {
+		log.Printf("Content filter failed: %v", err)
+		return content.VerdictAllow, text
+	}
+	return verdict, rewritten
+}
+
+// broadcastToRoom sends a message to all clients in a specific room
+// This is a fan-out pattern: one message goes to many recipients
+func (s *shard) broadcastToRoom(roomID int64, message *Message) {
+	// Get all clients in the room
+	clients, ok := s.rooms[roomID]
+	if !ok {
+		// No clients in this room
+		return
+	}
+
+	// With auditing on, every room broadcast carries the room's next sequence number
+	if s.audit != nil {
+		message.AuditSeq = s.audit.stamp(roomID)
+	}
+	if message.event == 0 {
+		message.event = s.userEvents.newEvent()
+	}
+
+	// Marshal message to JSON
+	// We do this once instead of for each client (more efficient)
+	// Each client's protocol encoder then wraps this payload as needed
+	jsonMessage, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Failed to marshal message: %v", err)
+		return
+	}
+
+	// Saved chat messages get delivery receipts; notifications don't
+	trackDelivery := message.Type == "message" && message.ID > 0
+
+	// Send message to each client in the room
+	// This is the fan-out: iterate through all clients and send to each
+	for client := range clients {
+		if s.audit != nil {
+			s.audit.observe(client, message.AuditSeq)
+		}
+
+		// Connections that suppress echoes get an ack for their own chat messages
+		// instead of the message; it's addressed to them, so it skips the filter
+		if client == message.sender && client.suppressEcho && message.Type == "message" {
+			if ack := echoAck(message); ack != nil {
+				s.deliverToClient(client, ack)
+			}
+			continue
+		}
+
+		// Skip clients that opted out of this kind of event
+		if !client.filter.allows(message.Type) {
+			continue
+		}
+
+		// Hold back low-priority frames from clients over their bandwidth budget
+		if !s.admit(client, message) {
+			continue
+		}
+
+		payload := jsonMessage
+		if message.notifyUsers[client.userID] {
+			if payload, err = alertPayload(message); err != nil {
+				log.Printf("Failed to marshal message: %v", err)
+				continue
+			}
+		}
+		frame, err := encodeFor(client, message, s.sequence(client, message, message.event, payload))
+		if err != nil {
+			log.Printf("Failed to encode message: %v", err)
+			continue
+		}
+
+		// The send never blocks, so one slow client can't hold up the others
+		// A client that can't take the frame is disconnected; deleting from
+		// the map while ranging over it is safe in Go
+		if s.enqueue(client, frame) && trackDelivery && client.userID != message.UserID && !client.readOnly {
+			s.recordDelivery(roomID, client.userID, message.ID)
+		}
+	}
+
+	log.Printf("Broadcasted message to %d clients in room %d", len(clients), roomID)
+}
+
+// deliverToClient writes a frame to one client's send channel
+// The client may have disconnected while the frame was queued, so check it's still registered
+func (s *shard) deliverToClient(client *Client, message *Message) {
+	if _, ok := s.rooms[client.roomID][client]; !ok {
+		return
+	}
+	if !s.admit(client, message) {
+		return
+	}
+
+	jsonMessage, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Failed to marshal message: %v", err)
+		return
+	}
+
+	// A frame for this client alone gets an event of its own
+	event := message.event
+	if event == 0 {
+		event = s.userEvents.newEvent()
+	}
+	frame, err := encodeFor(client, message, s.sequence(client, message, event, jsonMessage))
+	if err != nil {
+		log.Printf("Failed to encode message: %v", err)
+		return
+	}
+	s.enqueue(client, frame)
+}
+
+// sequence gives a frame its user_seq for the client's user (see user_events.go)
+// Guests and frames meant only for this connection are left as they are
+// Must be called after admit, so a frame held back isn't numbered
+func (s *shard) sequence(client *Client, message *Message, event uint64, payload []byte) []byte {
+	if client.readOnly || client.userID == 0 || unsequencedTypes[message.Type] {
+		return payload
+	}
+	return s.userEvents.stamp(client.userID, event, payload)
+}
+
+// memberCount returns the number of non-guest clients in a room
+// Must only be called from the shard's loop
+func (s *shard) memberCount(roomID int64) int {
+	count := 0
+	for client := range s.rooms[roomID] {
+		if !client.readOnly {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,214 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// maxSnapshotRooms caps how many rooms a snapshot lists; the busiest come first
+	maxSnapshotRooms = 1000
+
+	// maxSnapshotClientsPerRoom caps the connections listed for one room
+	maxSnapshotClientsPerRoom = 100
+)
+
+// Snapshot is a compact view of the hub's state at one moment
+// It's written to disk periodically so that after a crash we can see who was
+// connected where, and it's served on demand for live diagnostics
+type Snapshot struct {
+	TakenAt  time.Time `json:"taken_at"`
+	Shards   int       `json:"shards"`
+	Rooms    int       `json:"rooms"`
+	Clients  int       `json:"clients"`
+	Draining bool      `json:"draining"`
+
+	// DroppedClients counts clients disconnected because their send buffer was full
+	DroppedClients int64 `json:"dropped_clients"`
+
+	// DroppedHooks counts hook calls skipped because the hook queue was full
+	DroppedHooks int64 `json:"dropped_hooks"`
+
+	// RoomList has one entry per room, busiest first
+	// MoreRooms is how many rooms were left out to keep the snapshot bounded
+	RoomList  []RoomSnapshot `json:"room_list"`
+	MoreRooms int            `json:"more_rooms,omitempty"`
+}
+
+// RoomSnapshot describes the connections in one room
+type RoomSnapshot struct {
+	RoomID  int64 `json:"room_id"`
+	Shard   int   `json:"shard"`
+	Clients int   `json:"clients"`
+	Guests  int   `json:"guests"`
+
+	// Online is the distinct members connected and Members the member count
+	// last sent in a room_stats frame; Members is absent until one was sent
+	Online  int  `json:"online"`
+	Members *int `json:"members,omitempty"`
+
+	// RTT summarizes the round-trip times of the room's connections
+	RTT *RTTStats `json:"rtt,omitempty"`
+
+	// QueuedBytes is the bytes waiting in the room's send buffers (see memory.go)
+	QueuedBytes int64 `json:"queued_bytes"`
+
+	// Connections lists up to maxSnapshotClientsPerRoom clients
+	// MoreConnections is how many more there were ("+N more")
+	Connections     []ClientSnapshot `json:"connections"`
+	MoreConnections int              `json:"more_connections,omitempty"`
+}
+
+// ClientSnapshot describes one connection
+type ClientSnapshot struct {
+	UserID      int64 `json:"user_id"`      // Zero for guests
+	Queued      int   `json:"queued"`       // Frames waiting in the send buffer
+	QueuedBytes int64 `json:"queued_bytes"` // Their size
+
+	// RTTMs is the connection's average round-trip time; absent until it answers a ping
+	RTTMs float64 `json:"rtt_ms,omitempty"`
+
+	// Throttling (see throttle.go): whether the connection is over its
+	// bandwidth budget now, how often it went over, and the frames it lost
+	Throttled         bool  `json:"throttled,omitempty"`
+	ThrottleEpisodes  int64 `json:"throttle_episodes,omitempty"`
+	ThrottleDropped   int64 `json:"throttle_dropped,omitempty"`
+	ThrottleCoalesced int64 `json:"throttle_coalesced,omitempty"`
+}
+
+// Snapshot collects the state of every shard
+// Each shard is read on its own loop, so this never races with broadcasts
+func (h *Hub) Snapshot() *Snapshot {
+	snapshot := &Snapshot{
+		TakenAt:      time.Now().UTC(),
+		Shards:       len(h.shards),
+		Draining:     h.Draining(),
+		DroppedHooks: h.hooks.Dropped(),
+		RoomList:     make([]RoomSnapshot, 0),
+	}
+
+	for _, s := range h.shards {
+		s.do(func() {
+			snapshot.DroppedClients += s.droppedClients
+			for roomID, clients := range s.rooms {
+				snapshot.RoomList = append(snapshot.RoomList, s.snapshotRoom(roomID, clients))
+				snapshot.Clients += len(clients)
+			}
+		})
+	}
+	snapshot.Rooms = len(snapshot.RoomList)
+
+	// Keep the busiest rooms if there are too many to list
+	sort.Slice(snapshot.RoomList, func(i, j int) bool {
+		if snapshot.RoomList[i].Clients != snapshot.RoomList[j].Clients {
+			return snapshot.RoomList[i].Clients > snapshot.RoomList[j].Clients
+		}
+		return snapshot.RoomList[i].RoomID < snapshot.RoomList[j].RoomID
+	})
+	if len(snapshot.RoomList) > maxSnapshotRooms {
+		snapshot.MoreRooms = len(snapshot.RoomList) - maxSnapshotRooms
+		snapshot.RoomList = snapshot.RoomList[:maxSnapshotRooms]
+	}
+
+	return snapshot
+}
+
+// snapshotRoom describes one room
+// Must only be called from the shard's loop
+func (s *shard) snapshotRoom(roomID int64, clients map[*Client]bool) RoomSnapshot {
+	room := RoomSnapshot{
+		RoomID:      roomID,
+		Shard:       s.id,
+		Clients:     len(clients),
+		Online:      s.onlineMemberCount(roomID),
+		Connections: make([]ClientSnapshot, 0, min(len(clients), maxSnapshotClientsPerRoom)),
+	}
+	if sent, ok := s.statsSent[roomID]; ok {
+		room.Members = &sent.members
+	}
+
+	for client := range clients {
+		if client.readOnly {
+			room.Guests++
+		}
+		if len(room.Connections) == maxSnapshotClientsPerRoom {
+			room.MoreConnections++
+			continue
+		}
+		connection := ClientSnapshot{
+			UserID:      client.userID,
+			Queued:      len(client.send),
+			QueuedBytes: client.queuedBytes.Load(),
+
+			Throttled:         client.throttle.active,
+			ThrottleEpisodes:  client.throttle.episodes,
+			ThrottleDropped:   client.throttle.dropped,
+			ThrottleCoalesced: client.throttle.coalesced,
+		}
+		if average, ok := client.rtt.average(); ok {
+			connection.RTTMs = durationMs(average)
+		}
+		room.Connections = append(room.Connections, connection)
+	}
+	room.RTT = summarizeRTT(rttSamples(clients, nil))
+	room.QueuedBytes = roomQueuedBytes(clients)
+
+	return room
+}
+
+// WriteSnapshots writes a snapshot to path every interval, forever
+// This should be called in a goroutine: go hub.WriteSnapshots(path, interval)
+func (h *Hub) WriteSnapshots(path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := writeSnapshotFile(path, h.Snapshot()); err != nil {
+			log.Printf("Failed to write hub snapshot: %v", err)
+		}
+	}
+}
+
+// writeSnapshotFile writes a snapshot atomically: to a temporary file first,
+// then renamed over the old one, so a crash mid-write never leaves half a file
+func writeSnapshotFile(path string, snapshot *Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".hub-snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReadSnapshot loads the snapshot written by a previous run
+// Returns an error wrapping os.ErrNotExist if there isn't one
+func ReadSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,158 @@
+package websocket
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// TestSnapshotDuringBroadcasts takes snapshots while producers keep four
+// rooms busy and clients come and go. Run with -race: snapshots read every
+// shard while its loop is broadcasting
+// Every snapshot must add up: its room entries account for all its clients
+func TestSnapshotDuringBroadcasts(t *testing.T) {
+	const (
+		rooms     = 4
+		snapshots = 50
+	)
+
+	hub := newTestHub(2)
+	go hub.Run()
+
+	stop := make(chan struct{})
+	var running sync.WaitGroup
+	for roomID := int64(1); roomID <= rooms; roomID++ {
+		reader := newTestClient(hub, 100+roomID, roomID, 1024)
+		go drainFrames(reader)
+		reader.join()
+
+		running.Add(2)
+		go func() {
+			defer running.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					hub.broadcast(&Message{Message: wire.Message{RoomID: roomID, UserID: 1, Username: "producer", Content: "busy", Type: "message"}})
+					// Slow enough that the readers keep up and are never dropped
+					time.Sleep(50 * time.Microsecond)
+				}
+			}
+		}()
+		go func() {
+			defer running.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					visitor := newTestClient(hub, 200+roomID, roomID, 1024)
+					go drainFrames(visitor)
+					visitor.join()
+					hub.unregister(visitor)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < snapshots; i++ {
+		snapshot := hub.Snapshot()
+		clients := 0
+		for _, room := range snapshot.RoomList {
+			clients += room.Clients
+			if len(room.Connections)+room.MoreConnections != room.Clients {
+				t.Errorf("room %d lists %d connections and %d more, but has %d clients",
+					room.RoomID, len(room.Connections), room.MoreConnections, room.Clients)
+			}
+		}
+		if clients != snapshot.Clients || len(snapshot.RoomList) != snapshot.Rooms {
+			t.Errorf("the rooms hold %d clients in %d entries, the snapshot says %d in %d",
+				clients, len(snapshot.RoomList), snapshot.Clients, snapshot.Rooms)
+		}
+		if snapshot.Clients < rooms {
+			t.Errorf("the snapshot has %d clients, want at least the %d readers", snapshot.Clients, rooms)
+		}
+	}
+	close(stop)
+	running.Wait()
+}
+
+// TestSnapshotBounds checks a huge hub still gives a bounded snapshot:
+// rooms past the cap are left out busiest last, connections past the
+// per-room cap are counted, and guests are counted separately
+func TestSnapshotBounds(t *testing.T) {
+	hub := newTestHub(4)
+	go hub.Run()
+
+	// Room 1 is the busiest, with a guest among its connections; the
+	// buffers hold everyone's join, so nobody is dropped
+	busiest := maxSnapshotClientsPerRoom + 5
+	for i := 0; i < busiest; i++ {
+		client := newTestClient(hub, int64(i+1), 1, 2*busiest)
+		client.readOnly = i == 0
+		hub.register(client)
+	}
+	// Every other room has one client; two of them don't fit
+	for roomID := int64(2); roomID <= maxSnapshotRooms+2; roomID++ {
+		hub.register(newTestClient(hub, 1, roomID, 64))
+	}
+
+	snapshot := hub.Snapshot()
+	if snapshot.Rooms != maxSnapshotRooms+2 || snapshot.Clients != busiest+maxSnapshotRooms+1 {
+		t.Errorf("the snapshot counts %d clients in %d rooms, want %d in %d",
+			snapshot.Clients, snapshot.Rooms, busiest+maxSnapshotRooms+1, maxSnapshotRooms+2)
+	}
+	if len(snapshot.RoomList) != maxSnapshotRooms || snapshot.MoreRooms != 2 {
+		t.Fatalf("the snapshot lists %d rooms and %d more, want %d and 2", len(snapshot.RoomList), snapshot.MoreRooms, maxSnapshotRooms)
+	}
+	room := snapshot.RoomList[0]
+	if room.RoomID != 1 || room.Clients != busiest || room.Guests != 1 {
+		t.Errorf("the first room is %d with %d clients and %d guests, want room 1 with %d and 1", room.RoomID, room.Clients, room.Guests, busiest)
+	}
+	if len(room.Connections) != maxSnapshotClientsPerRoom || room.MoreConnections != 5 {
+		t.Errorf("room 1 lists %d connections and %d more, want %d and 5", len(room.Connections), room.MoreConnections, maxSnapshotClientsPerRoom)
+	}
+	// Rooms with the same number of clients come in ID order
+	if last := snapshot.RoomList[maxSnapshotRooms-1].RoomID; last != maxSnapshotRooms {
+		t.Errorf("the last room listed is %d, want %d", last, maxSnapshotRooms)
+	}
+}
+
+// TestSnapshotFile writes a snapshot and reads it back, as a restart would,
+// leaving no temporary files behind
+func TestSnapshotFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hub-snapshot.json")
+	if _, err := ReadSnapshot(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("reading a missing snapshot got %v, want os.ErrNotExist", err)
+	}
+
+	written := &Snapshot{
+		TakenAt:  time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		Rooms:    1,
+		Clients:  2,
+		RoomList: []RoomSnapshot{{RoomID: 7, Clients: 2, Connections: []ClientSnapshot{{UserID: 1, Queued: 3}, {UserID: 2}}}},
+	}
+	for i := 0; i < 2; i++ { // The second write replaces the first
+		if err := writeSnapshotFile(path, written); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read, err := ReadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !read.TakenAt.Equal(written.TakenAt) || read.Clients != 2 || len(read.RoomList) != 1 || read.RoomList[0].Connections[0].Queued != 3 {
+		t.Errorf("read back %+v, want %+v", read, written)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("the directory holds %d files, want only the snapshot", len(entries))
+	}
+}
@@ -0,0 +1,248 @@
+package websocket
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// Adaptive throttling
+//
+// In a busy room, presence, receipts and stats frames can far outweigh the
+// chat itself, which hurts clients on slow or metered links. Each connection
+// gets a bandwidth budget (Tunables.BandwidthBudget, bytes per second): once
+// a second's worth of frames goes over it, the connection is throttled and
+// its low-priority frames are held back. Chat messages, acks and anything
+// addressed to the connection alone are always delivered
+//
+// While throttled, "latest" frames (room stats, delivery receipts) only keep
+// the newest of each type, which is sent when throttling ends; "low" frames
+// (joins and leaves) are dropped. The client hears about it once per episode:
+// {"type": "throttled", "dropped_types": [...]} when it starts, and
+// {"type": "unthrottled", "dropped": N} when a whole window stays under half
+// the budget, N being how many frames it missed (so it can refresh what it shows)
+
+// throttleWindow is the period bandwidth is measured over
+const throttleWindow = time.Second
+
+// defaultBandwidthBudget is the budget unless the tunables change it (64KB/s)
+const defaultBandwidthBudget = 64 << 10
+
+// framePriority is how a frame type fares on a throttled connection
+type framePriority int
+
+const (
+	// priorityHigh frames are always delivered
+	priorityHigh framePriority = iota
+
+	// priorityLatest frames are coalesced: the newest of each type is held and
+	// sent when throttling ends
+	priorityLatest
+
+	// priorityLow frames are dropped
+	priorityLow
+)
+
+// framePriorities lists every frame type the server sends with its priority
+// A new frame type must be added here; types that aren't are delivered as
+// high priority and logged once, so a missing entry is noticed without ever
+// costing a client a frame
+var framePriorities = map[string]framePriority{
+	// Chat and what the client asked for
+	"message":          priorityHigh,
+	"message_ack":      priorityHigh,
+	"error":            priorityHigh,
+	"notice":           priorityHigh,
+	"filter_updated":   priorityHigh,
+	"options_updated":  priorityHigh,
+	"history_response": priorityHigh,
+	"history_error":    priorityHigh,
+	"ping_stats":       priorityHigh,
+	"throttled":        priorityHigh,
+	"unthrottled":      priorityHigh,
+
+	// Changes to the room or the connection a client must not miss
+	"pin_added":                 priorityHigh,
+	"pin_removed":               priorityHigh,
+	"pin_order_changed":         priorityHigh,
+	"message_redacted":          priorityHigh,
+	"member_added":              priorityHigh,
+	"member_removed":            priorityHigh,
+	"join_request":              priorityHigh,
+	"join_approved":             priorityHigh,
+	"join_rejected":             priorityHigh,
+	"invite":                    priorityHigh,
+	"invite_accepted":           priorityHigh,
+	"invite_declined":           priorityHigh,
+	"attachment_thumbnail":      priorityHigh,
+	"moderation_hook_disabled":  priorityHigh,
+	"outgoing_webhook_disabled": priorityHigh,
+	"removed_from_room":         priorityHigh,
+	"left_room":                 priorityHigh,
+	"room_deleted":              priorityHigh,
+	"room_merged":               priorityHigh,
+	"room_updated":              priorityHigh,
+	"session_revoked":           priorityHigh,
+	"server_draining":           priorityHigh,
+
+	// Only the newest one matters
+	"room_stats": priorityLatest,
+	"delivered":  priorityLatest,
+
+	// Presence
+	"join":  priorityLow,
+	"leave": priorityLow,
+}
+
+// unlistedFrameTypes remembers the frame types already logged as missing from framePriorities
+var unlistedFrameTypes sync.Map
+
+// priorityOf returns a frame type's priority
+func priorityOf(frameType string) framePriority {
+	priority, ok := framePriorities[frameType]
+	if !ok {
+		if _, logged := unlistedFrameTypes.LoadOrStore(frameType, true); !logged {
+			log.Printf("Frame type %q has no throttling priority; delivering it as high priority", frameType)
+		}
+		return priorityHigh
+	}
+	return priority
+}
+
+// throttledTypes is the "dropped_types" list of throttled frames: every type
+// a throttled connection may miss, sorted
+var throttledTypes = func() []string {
+	types := make([]string, 0)
+	for frameType, priority := range framePriorities {
+		if priority != priorityHigh {
+			types = append(types, frameType)
+		}
+	}
+	sort.Strings(types)
+	return types
+}()
+
+// throttleState is one connection's bandwidth accounting
+// Owned by the shard loop
+type throttleState struct {
+	windowStart time.Time
+	windowBytes int
+
+	// active is set during an episode; held keeps the newest "latest" frame
+	// of each type, and missed counts the frames held back this episode
+	active bool
+	held   map[string]*Message
+	missed int
+
+	// Over the connection's lifetime, for the hub snapshot
+	episodes  int64
+	dropped   int64
+	coalesced int64
+}
+
+// ThrottleStats counts throttling across the hub
+type ThrottleStats struct {
+	Throttled int   `json:"throttled"` // Connections throttled right now
+	Episodes  int64 `json:"episodes"`  // Times a connection went over its budget
+	Dropped   int64 `json:"dropped"`   // Low-priority frames dropped
+	Coalesced int64 `json:"coalesced"` // Frames replaced by a newer one of the same type
+}
+
+// admit decides whether a frame goes out to a client now
+// Must be called before the frame is encoded, so a held frame doesn't use up
+// a sequence number; must only be called from the shard's loop
+func (s *shard) admit(client *Client, message *Message) bool {
+	t := &client.throttle
+	budget := s.bandwidthBudget()
+
+	now := time.Now()
+	if now.Sub(t.windowStart) >= throttleWindow {
+		quiet := t.windowBytes <= budget/2
+		t.windowStart, t.windowBytes = now, 0
+		if t.active && (quiet || budget <= 0) {
+			s.endThrottle(client)
+		}
+	}
+	if !t.active {
+		return true
+	}
+
+	switch priorityOf(message.Type) {
+	case priorityLatest:
+		if t.held[message.Type] != nil {
+			t.coalesced++
+			s.throttleCounts.Coalesced++
+		}
+		t.held[message.Type] = message
+		t.missed++
+		return false
+	case priorityLow:
+		t.dropped++
+		s.throttleCounts.Dropped++
+		t.missed++
+		return false
+	}
+	return true
+}
+
+// countSent adds a delivered frame to the client's window, and starts
+// throttling the client if that takes it over the budget
+// Must only be called from the shard's loop
+func (s *shard) countSent(client *Client, n int) {
+	t := &client.throttle
+	t.windowBytes += n
+
+	budget := s.bandwidthBudget()
+	if t.active || budget <= 0 || t.windowBytes <= budget {
+		return
+	}
+	t.active = true
+	t.held = make(map[string]*Message)
+	t.missed = 0
+	t.episodes++
+	s.throttleCounts.Episodes++
+	s.throttled[client] = true
+	s.deliverToClient(client, &Message{Message: wire.Message{RoomID: client.roomID, Type: "throttled", DroppedTypes: throttledTypes}})
+}
+
+// endThrottle ends a client's throttling episode: the client is told how much
+// it missed, then gets the newest of each held frame
+// Must only be called from the shard's loop
+func (s *shard) endThrottle(client *Client) {
+	t := &client.throttle
+	held, missed := t.held, t.missed
+	t.active = false
+	t.held = nil
+	delete(s.throttled, client)
+
+	dropped := missed
+	s.deliverToClient(client, &Message{Message: wire.Message{RoomID: client.roomID, Type: "unthrottled", Dropped: &dropped}})
+	for _, frameType := range throttledTypes {
+		if message := held[frameType]; message != nil {
+			s.deliverToClient(client, message)
+		}
+	}
+}
+
+// checkThrottles ends the episodes of throttled clients that went quiet
+// Clients that receive frames are checked as they do; this catches the ones
+// that stopped receiving anything, so their held frames aren't stuck
+// Must only be called from the shard's loop
+func (s *shard) checkThrottles() {
+	budget := s.bandwidthBudget()
+	now := time.Now()
+	for client := range s.throttled {
+		t := &client.throttle
+		if now.Sub(t.windowStart) < throttleWindow {
+			continue
+		}
+		quiet := t.windowBytes <= budget/2
+		t.windowStart, t.windowBytes = now, 0
+		if quiet || budget <= 0 {
+			s.endThrottle(client)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package websocket
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// queuedFrames takes the frames waiting on a client's send channel, decoded
+// Deliveries happen on the shard loop, so after a s.do they're all queued
+func queuedFrames(t *testing.T, client *Client) []*Message {
+	t.Helper()
+	var frames []*Message
+	for {
+		select {
+		case frame := <-client.send:
+			var message Message
+			if err := json.Unmarshal(frame, &message); err != nil {
+				t.Fatalf("decoding %s: %v", frame, err)
+			}
+			frames = append(frames, &message)
+		default:
+			return frames
+		}
+	}
+}
+
+// frameTypes lists the types of frames, in order
+func frameTypes(frames []*Message) []string {
+	types := make([]string, len(frames))
+	for i, frame := range frames {
+		types[i] = frame.Type
+	}
+	return types
+}
+
+// TestThrottle sends a connection more than its budget: it's told it's
+// throttled, then gets chat messages and frames addressed to it but no
+// presence, and only the newest room stats and receipt; a busy window keeps
+// it throttled, and a quiet one ends it with a count of what was missed and
+// the held frames
+func TestThrottle(t *testing.T) {
+	const budget = 1000
+	hub := newTestHub(1)
+	tunables := DefaultTunables()
+	tunables.BandwidthBudget = budget
+	hub.SetTunables(tunables)
+	go hub.Run()
+	s := hub.shards[0]
+
+	client := newTestClient(hub, 1, 1, 256)
+	hub.register(client)
+	defer hub.unregister(client)
+	s.do(func() {})
+	queuedFrames(t, client) // Its own join
+
+	// broadcast sends frames to the room from the shard loop
+	broadcast := func(messages ...*Message) {
+		s.do(func() {
+			for _, message := range messages {
+				s.broadcastToRoom(1, message)
+			}
+		})
+	}
+	chat := func(content string) *Message {
+		return &Message{Message: wire.Message{RoomID: 1, UserID: 2, Username: "user2", Type: "message", Content: content}}
+	}
+	stats := func(online int) *Message {
+		members := 10
+		return &Message{Message: wire.Message{RoomID: 1, Type: "room_stats", Online: &online, Members: &members}}
+	}
+
+	// The third 300-byte message takes it over the budget; chat still gets through
+	long := strings.Repeat("x", 300)
+	broadcast(chat(long), chat(long), chat(long), chat(long))
+	frames := queuedFrames(t, client)
+	if got, want := frameTypes(frames), []string{"message", "message", "message", "throttled", "message"}; !slices.Equal(got, want) {
+		t.Fatalf("going over the budget got %v, want %v", got, want)
+	}
+	if got := frames[3].DroppedTypes; !slices.Equal(got, []string{"delivered", "join", "leave", "room_stats"}) {
+		t.Errorf("throttled lists %v as dropped", got)
+	}
+
+	broadcast(
+		&Message{Message: wire.Message{RoomID: 1, UserID: 3, Type: "join"}},
+		stats(3),
+		&Message{Message: wire.Message{RoomID: 1, Type: "delivered", MessageID: 7}},
+		&Message{Message: wire.Message{RoomID: 1, UserID: 3, Type: "leave"}},
+		stats(2),
+		chat("still here"),
+	)
+	s.do(func() {
+		s.deliverToClient(client, &Message{Message: wire.Message{RoomID: 1, Type: "error", Code: "example"}})
+	})
+	frames = queuedFrames(t, client)
+	if got, want := frameTypes(frames), []string{"message", "error"}; !slices.Equal(got, want) {
+		t.Fatalf("while throttled the client got %v, want %v", got, want)
+	}
+
+	// endWindow makes the client's window a second old, as if it had passed,
+	// with bytes sent in it, and lets the shard check it
+	endWindow := func(bytes int) {
+		s.do(func() {
+			client.throttle.windowStart = time.Now().Add(-throttleWindow)
+			client.throttle.windowBytes = bytes
+			s.checkThrottles()
+		})
+	}
+	endWindow(budget/2 + 1)
+	if frames := queuedFrames(t, client); len(frames) != 0 || hub.Stats().Throttle.Throttled != 1 {
+		t.Fatalf("a busy window ended throttling: got %v", frameTypes(frames))
+	}
+
+	endWindow(budget / 2)
+	frames = queuedFrames(t, client)
+	if got, want := frameTypes(frames), []string{"unthrottled", "delivered", "room_stats"}; !slices.Equal(got, want) {
+		t.Fatalf("a quiet window got %v, want %v", got, want)
+	}
+	if dropped := frames[0].Dropped; dropped == nil || *dropped != 5 {
+		t.Errorf("unthrottled says %v frames were missed, want 5", dropped)
+	}
+	if online := frames[2].Online; *online != 2 {
+		t.Errorf("the held room_stats has online=%d, want the newest, 2", *online)
+	}
+
+	counts := hub.Stats().Throttle
+	if counts.Throttled != 0 || counts.Episodes != 1 || counts.Dropped != 2 || counts.Coalesced != 1 {
+		t.Errorf("the hub counts %+v, want 0 throttled, 1 episode, 2 dropped, 1 coalesced", counts)
+	}
+
+	// Presence reaches it again
+	broadcast(&Message{Message: wire.Message{RoomID: 1, UserID: 4, Type: "join"}})
+	if got := frameTypes(queuedFrames(t, client)); !slices.Equal(got, []string{"join"}) {
+		t.Errorf("after throttling the client got %v, want the join", got)
+	}
+}
+
+// TestThrottleTurnedOff checks turning the budget off ends an episode at the
+// next check, however busy the connection is
+func TestThrottleTurnedOff(t *testing.T) {
+	hub := newTestHub(1)
+	tunables := DefaultTunables()
+	tunables.BandwidthBudget = 100
+	hub.SetTunables(tunables)
+	go hub.Run()
+	s := hub.shards[0]
+
+	client := newTestClient(hub, 1, 1, 256)
+	hub.register(client)
+	defer hub.unregister(client)
+	s.do(func() {
+		s.broadcastToRoom(1, &Message{Message: wire.Message{RoomID: 1, UserID: 2, Type: "message", Content: strings.Repeat("x", 200)}})
+	})
+	if got := frameTypes(queuedFrames(t, client)); !slices.Contains(got, "throttled") {
+		t.Fatalf("going over the budget got %v, want throttled", got)
+	}
+
+	tunables.BandwidthBudget = 0
+	hub.SetTunables(tunables)
+	s.do(func() {
+		client.throttle.windowStart = time.Now().Add(-throttleWindow)
+		client.throttle.windowBytes = 1 << 20
+		s.checkThrottles()
+	})
+	if got := frameTypes(queuedFrames(t, client)); !slices.Equal(got, []string{"unthrottled"}) {
+		t.Errorf("turning throttling off got %v, want unthrottled", got)
+	}
+}
+
+func TestPriorityOf(t *testing.T) {
+	for frameType, want := range map[string]framePriority{
+		"message":            priorityHigh,
+		"room_stats":         priorityLatest,
+		"join":               priorityLow,
+		"not_a_frame_type_x": priorityHigh, // Unlisted types are never held back
+	} {
+		if got := priorityOf(frameType); got != want {
+			t.Errorf("priorityOf(%q) = %d, want %d", frameType, got, want)
+		}
+	}
+}
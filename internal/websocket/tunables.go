@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/content"
+)
+
+// Tunables are the hub settings that can change while it runs
+// They're kept as one snapshot behind an atomic pointer shared by the hub and
+// its shards. Readers load it once per operation (a message, a pong), so a
+// change applies from the next operation on and never halfway through one;
+// open connections aren't touched
+type Tunables struct {
+	// How long chat messages may be, checked by clients as frames arrive and
+	// by the REST send path
+	Lengths content.LengthPolicy
+
+	// Largest frame a client may send, in bytes; zero keeps the default (1MB)
+	// This is the transport limit; chat content is held to Lengths, much lower
+	MaxFrameBytes int
+
+	// Identical messages a user may send per DuplicateWindow in rooms with
+	// duplicate_limit_enabled; zero or less turns the check off
+	DuplicateLimit  int
+	DuplicateWindow time.Duration
+
+	// Round-trip time above which a connection counts as slow (see rtt.go)
+	// Zero turns the slow connection logging off
+	SlowRTT time.Duration
+
+	// Bytes per second a connection may be sent before its low-priority
+	// frames are held back (see throttle.go); zero turns throttling off
+	BandwidthBudget int
+
+	// Bytes queued across all send channels over which budgets are cut and
+	// backed up clients disconnected (see memory.go); zero turns either off
+	MemorySoftLimit int
+	MemoryHardLimit int
+
+	// How long a client's writes may stall, with frames waiting, before it's
+	// disconnected (see liveness.go); zero turns the check off
+	StaleWriteThreshold time.Duration
+}
+
+// DefaultTunables returns the settings a new hub starts with
+func DefaultTunables() Tunables {
+	return Tunables{
+		DuplicateLimit:  defaultDuplicateLimit,
+		DuplicateWindow: defaultDuplicateWindow,
+		SlowRTT:         defaultSlowRTT,
+		BandwidthBudget: defaultBandwidthBudget,
+
+		StaleWriteThreshold: defaultStaleWriteThreshold,
+	}
+}
+
+// defaultMaxFrameBytes is the frame size limit unless the tunables change it
+const defaultMaxFrameBytes = 1 << 20
+
+// frameLimit returns the largest frame a client may send under the snapshot
+func (t *Tunables) frameLimit() int {
+	if t.MaxFrameBytes <= 0 {
+		return defaultMaxFrameBytes
+	}
+	return t.MaxFrameBytes
+}
+
+// MaxFrameBytes returns the largest frame a client may send, in bytes
+func (h *Hub) MaxFrameBytes() int {
+	return h.tuning.Load().frameLimit()
+}
+
+// duplicates returns the duplicate message limit of the snapshot
+func (t *Tunables) duplicates() duplicateLimit {
+	return duplicateLimit{max: t.DuplicateLimit, window: t.DuplicateWindow}
+}
+
+// tunablesPointer is the snapshot shared by a hub and its shards
+type tunablesPointer = atomic.Pointer[Tunables]
+
+// SetTunables replaces the hub's tunables as a whole
+// Safe to call at any time, including while the hub runs
+func (h *Hub) SetTunables(t Tunables) {
+	h.tuning.Store(&t)
+}
+
+// Tunables returns the hub's current tunables
+func (h *Hub) Tunables() Tunables {
+	return *h.tuning.Load()
+}
+
+// LengthPolicy returns the message length policy, so messages sent over
+// REST are held to the same limit as WebSocket ones
+func (h *Hub) LengthPolicy() content.LengthPolicy {
+	return h.tuning.Load().Lengths
+}
@@ -0,0 +1,454 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// Per-user event streams
+//
+// Every frame a signed-in user's connections receive carries "user_seq",
+// the next number in that user's own sequence. It's handed out as the frame
+// is queued, once per frame and user: a user with two connections in a room
+// sees the same number on both, and frames from rooms on different shards
+// still interleave into one ordered sequence. Each user has their own lock,
+// so users never wait on each other
+//
+// A user's recent frames are kept in a ring (UserEventConfig.Buffer frames,
+// none older than Retention), which GET /v1/users/me/events reads so a client
+// that spots a gap, or reconnects, can fetch what it missed. With Spill on,
+// frames pushed out of a full ring are written to the store instead of
+// dropped, so a burst doesn't cost a slow client its catch-up
+//
+// A stream starts at the current time in microseconds, so numbers keep
+// growing when a stream is dropped (its user gone quiet and offline past the
+// retention) or the server restarts. A client asking to resume from a number
+// the stream can't vouch for gets ErrUserEventsGone and reloads
+//
+// Frames meant only for the connection they're sent on (errors, acks,
+// history pages, ping stats and the like) aren't numbered, nor are guests'
+
+// Defaults for UserEventConfig
+const (
+	DefaultUserEventBuffer    = 256
+	DefaultUserEventRetention = 5 * time.Minute
+)
+
+const (
+	// userEventSweepInterval is how often expired frames and idle streams are dropped
+	userEventSweepInterval = 30 * time.Second
+
+	// userEventPurgeInterval is how often spilled frames past the retention are deleted
+	userEventPurgeInterval = time.Minute
+
+	// userEventRecent is how many of a stream's newest frames are checked for
+	// one already numbered for another of the user's connections; a frame is
+	// numbered by all of them within one shard pass, so few frames come between
+	userEventRecent = 8
+
+	// Spilled frames are written in batches of up to userEventSpillBatch,
+	// at least every userEventSpillFlush; up to userEventSpillQueue wait
+	// their turn, beyond which they're dropped
+	userEventSpillBatch = 200
+	userEventSpillFlush = time.Second
+	userEventSpillQueue = 4096
+
+	// userEventStoreTimeout bounds one spill write or purge
+	userEventStoreTimeout = 10 * time.Second
+)
+
+// unsequencedTypes are the frame types meant only for the connection they're
+// sent on; they get no user_seq and aren't kept
+var unsequencedTypes = map[string]bool{
+	"error":            true,
+	"message_ack":      true,
+	"filter_updated":   true,
+	"options_updated":  true,
+	"history_response": true,
+	"history_error":    true,
+	"ping_stats":       true,
+	"throttled":        true,
+	"unthrottled":      true,
+	"server_draining":  true,
+}
+
+// ErrUserEventsGone is returned by UserEvents for a position the user's
+// stream can no longer replay from: frames after it have expired or been
+// pushed out, or it's from a stream that was dropped
+var ErrUserEventsGone = errors.New("user events gone; full resync required")
+
+// UserEventConfig is how much of each user's stream is kept for catching up
+type UserEventConfig struct {
+	Buffer    int           // Frames kept in memory per user
+	Retention time.Duration // How long a frame is kept, in memory or spilled
+	Spill     bool          // Write frames pushed out of a full buffer to the store
+}
+
+// UserEventPage is a page of a user's stream, as returned by UserEvents
+type UserEventPage struct {
+	Events []json.RawMessage
+
+	// Latest is the user_seq to ask for next: the last frame returned, or
+	// the position asked for if there was nothing new
+	Latest int64
+
+	// HasMore is true if the page was full and more frames follow
+	HasMore bool
+}
+
+// userEvent is one numbered frame in a stream
+type userEvent struct {
+	seq   int64
+	event uint64 // Message.event, which tells a frame already numbered
+	frame []byte
+	at    time.Time
+}
+
+// userStream is one user's sequence and recent frames
+type userStream struct {
+	mu sync.Mutex
+
+	first int64 // The first number the stream handed out
+	last  int64 // The last one
+
+	// ring holds the newest frames, oldest at head
+	ring []userEvent
+	head int
+	n    int
+
+	// dropped is set once the sweep removed the stream; a shard that looked
+	// it up just before then must look again
+	dropped bool
+}
+
+// userEvents holds every user's stream; shared by the hub and all shards
+type userEvents struct {
+	streams sync.Map // userID -> *userStream
+
+	// nextEvent hands out Message.event values
+	nextEvent atomic.Uint64
+
+	size      int
+	retention time.Duration
+
+	// spill is where pushed out frames wait to be written; nil unless spilling is on
+	spill        chan *store.UserEvent
+	spillDropped atomic.Int64
+
+	store  store.Storage
+	online *onlineIndex
+}
+
+func newUserEvents(st store.Storage, online *onlineIndex) *userEvents {
+	return &userEvents{
+		size:      DefaultUserEventBuffer,
+		retention: DefaultUserEventRetention,
+		store:     st,
+		online:    online,
+	}
+}
+
+// SetUserEvents sets how much of each user's stream is kept (see UserEventConfig)
+// A Buffer or Retention of zero or less keeps the default
+// Must be called before Run
+func (h *Hub) SetUserEvents(cfg UserEventConfig) {
+	if cfg.Buffer > 0 {
+		h.userEvents.size = cfg.Buffer
+	}
+	if cfg.Retention > 0 {
+		h.userEvents.retention = cfg.Retention
+	}
+	if cfg.Spill {
+		h.userEvents.spill = make(chan *store.UserEvent, userEventSpillQueue)
+	} else {
+		h.userEvents.spill = nil
+	}
+}
+
+// newEvent returns a value for Message.event
+// A frame delivered to several connections must have it set before it's
+// handed to them, so the connections of one user give it one number
+func (u *userEvents) newEvent() uint64 {
+	return u.nextEvent.Add(1)
+}
+
+// stream returns a user's stream, locked, starting one if they have none
+func (u *userEvents) stream(userID int64) *userStream {
+	for {
+		value, ok := u.streams.Load(userID)
+		if !ok {
+			first := time.Now().UnixMicro()
+			value, _ = u.streams.LoadOrStore(userID, &userStream{
+				first: first,
+				last:  first - 1,
+				ring:  make([]userEvent, 0, u.size),
+			})
+		}
+		st := value.(*userStream)
+		st.mu.Lock()
+		if !st.dropped {
+			return st
+		}
+		st.mu.Unlock()
+	}
+}
+
+// stamp numbers a frame for a user and keeps it, returning the frame with
+// its user_seq; a frame already numbered for another of the user's
+// connections gets the number it was given then
+func (u *userEvents) stamp(userID int64, event uint64, payload []byte) []byte {
+	st := u.stream(userID)
+	defer st.mu.Unlock()
+
+	for i := st.n - 1; i >= 0 && i >= st.n-userEventRecent; i-- {
+		if e := st.at(i); e.event == event {
+			return e.frame
+		}
+	}
+
+	st.last++
+	entry := userEvent{seq: st.last, event: event, frame: withUserSeq(payload, st.last), at: time.Now()}
+	if pushed, ok := st.push(entry, u.size); ok && u.spill != nil {
+		select {
+		case u.spill <- &store.UserEvent{UserID: userID, Seq: pushed.seq, Frame: pushed.frame, CreatedAt: pushed.at}:
+		default:
+			u.spillDropped.Add(1)
+		}
+	}
+	return entry.frame
+}
+
+// withUserSeq puts "user_seq" first in a frame's JSON object
+// The payload is the marshalled Message, which always starts with `{"`
+func withUserSeq(payload []byte, seq int64) []byte {
+	frame := make([]byte, 0, len(payload)+24)
+	frame = append(frame, `{"user_seq":`...)
+	frame = strconv.AppendInt(frame, seq, 10)
+	frame = append(frame, ',')
+	return append(frame, payload[1:]...)
+}
+
+// at returns the ith oldest frame in the ring
+func (st *userStream) at(i int) *userEvent {
+	return &st.ring[(st.head+i)%len(st.ring)]
+}
+
+// push adds a frame to the ring, returning the frame it pushed out if it was full
+func (st *userStream) push(entry userEvent, size int) (userEvent, bool) {
+	if len(st.ring) < size {
+		st.ring = append(st.ring, entry)
+		st.n++
+		return userEvent{}, false
+	}
+	if st.n < len(st.ring) {
+		// Expired frames were dropped from the front; there's room at the back
+		*st.at(st.n) = entry
+		st.n++
+		return userEvent{}, false
+	}
+	pushed := st.ring[st.head]
+	st.ring[st.head] = entry
+	st.head = (st.head + 1) % len(st.ring)
+	return pushed, true
+}
+
+// expire drops the frames sent before cutoff from the front of the ring
+func (st *userStream) expire(cutoff time.Time) {
+	for st.n > 0 && st.at(0).at.Before(cutoff) {
+		*st.at(0) = userEvent{}
+		st.head = (st.head + 1) % len(st.ring)
+		st.n--
+	}
+}
+
+// UserEvents returns up to limit of the frames a user was sent after the
+// given user_seq, oldest first; after 0 returns the oldest frames still kept
+// Frames older than the ring's are read from the store when spilling is on
+// Returns ErrUserEventsGone if frames after that position can't all be returned
+// Safe to call from any goroutine
+func (h *Hub) UserEvents(ctx context.Context, userID, after int64, limit int) (*UserEventPage, error) {
+	u := h.userEvents
+	value, ok := u.streams.Load(userID)
+	if !ok {
+		if after != 0 {
+			return nil, ErrUserEventsGone
+		}
+		return &UserEventPage{Events: []json.RawMessage{}}, nil
+	}
+
+	// Copy what's needed out of the ring; frames are never changed once
+	// made, so they can be shared
+	st := value.(*userStream)
+	cutoff := time.Now().Add(-u.retention)
+	st.mu.Lock()
+	first, last := st.first, st.last
+	oldest := last + 1 // The oldest frame the ring still has
+	var recent []userEvent
+	for i := 0; i < st.n; i++ {
+		e := st.at(i)
+		if e.at.Before(cutoff) {
+			continue
+		}
+		oldest = min(oldest, e.seq)
+		if e.seq > after && len(recent) <= limit {
+			recent = append(recent, *e)
+		}
+	}
+	st.mu.Unlock()
+
+	// A client resuming needs every frame after its position; one starting
+	// over (after 0) takes whatever is still kept
+	resume := after != 0
+	if resume && (after < first-1 || after > last) {
+		return nil, ErrUserEventsGone
+	}
+	from := after
+	if !resume {
+		from = first - 1
+	}
+
+	var older []*store.UserEvent
+	if from+1 < oldest && u.spill != nil {
+		var err error
+		if older, err = u.store.UserEvents.ListAfter(ctx, userID, from, limit+1); err != nil {
+			return nil, err
+		}
+	}
+
+	page := &UserEventPage{Events: make([]json.RawMessage, 0, min(limit, len(older)+len(recent))), Latest: after}
+	next := from + 1
+	add := func(seq int64, frame []byte) bool {
+		if resume && seq != next {
+			return false
+		}
+		if len(page.Events) == limit {
+			page.HasMore = true
+			return false
+		}
+		page.Events = append(page.Events, frame)
+		page.Latest, next = seq, seq+1
+		return true
+	}
+	added := true
+	for _, e := range older {
+		if e.Seq >= oldest || e.CreatedAt.Before(cutoff) {
+			continue
+		}
+		if added = add(e.Seq, e.Frame); !added {
+			break
+		}
+	}
+	for _, e := range recent {
+		if !added {
+			break
+		}
+		added = add(e.seq, e.frame)
+	}
+
+	switch {
+	case page.HasMore:
+	case resume && page.Latest < last:
+		// Some frame between the position and the newest is gone
+		return nil, ErrUserEventsGone
+	case !resume:
+		page.Latest = last
+	}
+	return page, nil
+}
+
+// run sweeps the streams, and writes and purges spilled frames when
+// spilling is on, until ctx is done
+func (u *userEvents) run(ctx context.Context) {
+	if u.spill != nil {
+		go u.writeSpilled(ctx)
+	}
+	sweep := time.NewTicker(userEventSweepInterval)
+	defer sweep.Stop()
+	purge := time.NewTicker(userEventPurgeInterval)
+	defer purge.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sweep.C:
+			u.sweep()
+		case <-purge.C:
+			if u.spill != nil {
+				u.purgeSpilled(ctx)
+			}
+		}
+	}
+}
+
+// sweep drops expired frames, and the streams of users who are offline and
+// have none left
+func (u *userEvents) sweep() {
+	cutoff := time.Now().Add(-u.retention)
+	u.streams.Range(func(key, value any) bool {
+		userID, st := key.(int64), value.(*userStream)
+		st.mu.Lock()
+		st.expire(cutoff)
+		if st.n == 0 && !u.online.online(userID) {
+			st.dropped = true
+			u.streams.CompareAndDelete(userID, st)
+		}
+		st.mu.Unlock()
+		return true
+	})
+}
+
+// writeSpilled writes spilled frames to the store in batches
+func (u *userEvents) writeSpilled(ctx context.Context) {
+	flush := time.NewTicker(userEventSpillFlush)
+	defer flush.Stop()
+	batch := make([]*store.UserEvent, 0, userEventSpillBatch)
+	write := func() {
+		if len(batch) == 0 {
+			return
+		}
+		writeCtx, cancel := context.WithTimeout(ctx, userEventStoreTimeout)
+		defer cancel()
+		if err := u.store.UserEvents.Append(writeCtx, batch); err != nil {
+			log.Printf("Failed to spill %d user events: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-u.spill:
+			batch = append(batch, event)
+			if len(batch) == userEventSpillBatch {
+				write()
+			}
+		case <-flush.C:
+			write()
+			if dropped := u.spillDropped.Swap(0); dropped > 0 {
+				log.Printf("Dropped %d user events: the spill queue was full", dropped)
+			}
+		}
+	}
+}
+
+// purgeSpilled deletes spilled frames past the retention
+func (u *userEvents) purgeSpilled(ctx context.Context) {
+	purgeCtx, cancel := context.WithTimeout(ctx, userEventStoreTimeout)
+	defer cancel()
+	removed, err := u.store.UserEvents.PurgeOlderThan(purgeCtx, time.Now().Add(-u.retention))
+	if err != nil {
+		log.Printf("Failed to purge spilled user events: %v", err)
+		return
+	}
+	if removed > 0 {
+		log.Printf("Purged %d spilled user events", removed)
+	}
+}
@@ -0,0 +1,135 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// memoryUserEvents keeps spilled frames in a slice
+type memoryUserEvents struct {
+	events []*store.UserEvent
+}
+
+func (m *memoryUserEvents) Append(_ context.Context, events []*store.UserEvent) error {
+	m.events = append(m.events, events...)
+	return nil
+}
+
+func (m *memoryUserEvents) ListAfter(_ context.Context, userID, after int64, limit int) ([]*store.UserEvent, error) {
+	var found []*store.UserEvent
+	for _, e := range m.events {
+		if e.UserID == userID && e.Seq > after && len(found) < limit {
+			found = append(found, e)
+		}
+	}
+	return found, nil
+}
+
+func (m *memoryUserEvents) PurgeOlderThan(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+// decodeFrame decodes a stamped frame
+func decodeFrame(frame []byte) *Message {
+	var message Message
+	json.Unmarshal(frame, &message)
+	return &message
+}
+
+// stampFrames numbers count new frames for a user, returning their user_seqs
+func stampFrames(hub *Hub, userID int64, count int) []int64 {
+	seqs := make([]int64, count)
+	for i := range seqs {
+		frame := hub.userEvents.stamp(userID, hub.userEvents.newEvent(), []byte(fmt.Sprintf(`{"room_id":1,"content":"%d"}`, i)))
+		seqs[i] = decodeFrame(frame).UserSeq
+	}
+	return seqs
+}
+
+// TestUserEventStream numbers frames per user: a frame stamped again (for
+// another connection) keeps its number, users don't share a sequence, and
+// pages are read back in order until frames pushed out of the buffer make
+// a position unreachable
+func TestUserEventStream(t *testing.T) {
+	hub := newTestHub(1)
+	hub.SetUserEvents(UserEventConfig{Buffer: 4})
+	u := hub.userEvents
+
+	event := u.newEvent()
+	first := u.stamp(1, event, []byte(`{"room_id":1,"type":"message"}`))
+	again := u.stamp(1, event, []byte(`{"room_id":1,"type":"message"}`))
+	if string(first) != string(again) || decodeFrame(first).UserSeq == 0 {
+		t.Fatalf("the same frame was stamped %s, then %s", first, again)
+	}
+	start := decodeFrame(first).UserSeq
+	if other := u.stamp(2, event, []byte(`{"room_id":1}`)); decodeFrame(other).UserSeq == start+1 {
+		t.Error("another user's frame continued user 1's sequence")
+	}
+
+	seqs := stampFrames(hub, 1, 3)
+	for i, seq := range seqs {
+		if seq != start+int64(i)+1 {
+			t.Errorf("frame %d got user_seq %d, want %d", i, seq, start+int64(i)+1)
+		}
+	}
+
+	page, err := hub.UserEvents(context.Background(), 1, start, 2)
+	if err != nil || len(page.Events) != 2 || page.Latest != seqs[1] || !page.HasMore {
+		t.Fatalf("the first page is %+v (%v), want frames %v with more to come", page, err, seqs[:2])
+	}
+	page, err = hub.UserEvents(context.Background(), 1, page.Latest, 2)
+	if err != nil || len(page.Events) != 1 || page.Latest != seqs[2] || page.HasMore {
+		t.Fatalf("the second page is %+v (%v), want the last frame alone", page, err)
+	}
+	if page, err := hub.UserEvents(context.Background(), 1, seqs[2], 10); err != nil || len(page.Events) != 0 || page.Latest != seqs[2] {
+		t.Errorf("asking after the newest frame got %+v (%v), want nothing new", page, err)
+	}
+
+	// Two more push the first two frames out of the buffer of four
+	stampFrames(hub, 1, 2)
+	if _, err := hub.UserEvents(context.Background(), 1, start, 10); !errors.Is(err, ErrUserEventsGone) {
+		t.Errorf("asking after a pushed out frame got %v, want ErrUserEventsGone", err)
+	}
+	if page, err := hub.UserEvents(context.Background(), 1, 0, 10); err != nil || len(page.Events) != 4 || page.Latest != seqs[2]+2 {
+		t.Errorf("starting over got %+v (%v), want the four frames kept", page, err)
+	}
+	if _, err := hub.UserEvents(context.Background(), 3, start, 10); !errors.Is(err, ErrUserEventsGone) {
+		t.Errorf("a user with no stream got %v, want ErrUserEventsGone", err)
+	}
+}
+
+// TestUserEventSpill pushes frames out of a buffer of two with spilling on:
+// they're queued for the store, and a page reaching back past the buffer
+// reads them from there, then carries on from memory
+func TestUserEventSpill(t *testing.T) {
+	spilled := &memoryUserEvents{}
+	hub := NewHub(store.Storage{UserEvents: spilled}, 1)
+	hub.SetUserEvents(UserEventConfig{Buffer: 2, Spill: true})
+
+	seqs := stampFrames(hub, 1, 5)
+	for len(hub.userEvents.spill) > 0 {
+		spilled.Append(context.Background(), []*store.UserEvent{<-hub.userEvents.spill})
+	}
+	if len(spilled.events) != 3 {
+		t.Fatalf("%d frames were spilled, want the 3 pushed out", len(spilled.events))
+	}
+
+	page, err := hub.UserEvents(context.Background(), 1, seqs[0], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 4 || page.Latest != seqs[4] {
+		t.Fatalf("the page has %d frames up to %d, want 4 up to %d", len(page.Events), page.Latest, seqs[4])
+	}
+	for i, raw := range page.Events {
+		if seq := decodeFrame(raw).UserSeq; seq != seqs[i+1] {
+			t.Errorf("frame %d has user_seq %d, want %d", i, seq, seqs[i+1])
+		}
+	}
+}
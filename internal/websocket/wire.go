@@ -0,0 +1,80 @@
+package websocket
+
+import (
+	"github.com/drazan344/go-chat/internal/store"
+	"github.com/drazan344/go-chat/pkg/wire"
+)
+
+// Chat message wire format
+//
+// A chat message looks the same whether a client gets it as a "message"
+// frame or from the REST API (store.Message): id, room_id, user_id,
+// username, content, content_type, language, filtered, truncated, override,
+// moderated, system, author_deleted, redacted and created_at, under the same names.
+// The only differences:
+//   - Frames have "type": "message"; REST responses don't
+//   - Frames of messages that couldn't be saved have no id or created_at
+//   - REST always includes content_type; frames leave it out only for
+//     messages that never went through formatting, which the hub doesn't send
+//
+// New chat message fields go on both types and into both functions below,
+// so the two transports can't drift apart
+
+// NewChatMessage builds the "message" frame for a saved message
+func NewChatMessage(m *store.Message) *Message {
+	message := &Message{
+		Message: wire.Message{
+			ID:           m.ID,
+			RoomID:       m.RoomID,
+			UserID:       m.UserID,
+			Username:     m.Username,
+			Content:      m.Content,
+			Type:         "message",
+			ContentType:  m.ContentType,
+			Language:     m.Language,
+			Filtered:     m.Filtered,
+			Truncated:    m.Truncated,
+			Override:     m.Override,
+			Moderated:    m.Moderated,
+			System:       m.System,
+			SystemEvent:  wire.SystemEvent(m.SystemEvent),
+			LegacySystem: m.LegacySystem,
+
+			AuthorDeleted: m.AuthorDeleted,
+			Redacted:      m.Redacted,
+			RoomMention:   m.RoomMention,
+
+			OverrideUsername:  m.OverrideUsername,
+			OverrideAvatarURL: m.OverrideAvatarURL,
+		},
+	}
+	if !m.CreatedAt.IsZero() {
+		createdAt := m.CreatedAt
+		message.CreatedAt = &createdAt
+	}
+	return message
+}
+
+// StoreMessage converts a chat message frame into the message to save
+// The ID, creation time, LegacySystem, AuthorDeleted and Redacted are left for the
+// store to fill in; ContentHash is the caller's to set
+func (m *Message) StoreMessage() *store.Message {
+	return &store.Message{
+		RoomID:      m.RoomID,
+		UserID:      m.UserID,
+		Username:    m.Username,
+		Content:     m.Content,
+		ContentType: m.ContentType,
+		Language:    m.Language,
+		Filtered:    m.Filtered,
+		Truncated:   m.Truncated,
+		Override:    m.Override,
+		Moderated:   m.Moderated,
+		System:      m.System,
+		SystemEvent: store.SystemEvent(m.SystemEvent),
+		RoomMention: m.RoomMention,
+
+		OverrideUsername:  m.OverrideUsername,
+		OverrideAvatarURL: m.OverrideAvatarURL,
+	}
+}
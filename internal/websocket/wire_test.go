@@ -0,0 +1,129 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/drazan344/go-chat/internal/store"
+)
+
+// update rewrites the golden files instead of comparing against them:
+// go test ./internal/websocket -run Golden -update
+var update = flag.Bool("update", false, "rewrite the testdata/*.golden files")
+
+// wireMessage is a saved chat message with every field set, whether or not
+// they'd go together
+func wireMessage() *store.Message {
+	return &store.Message{
+		ID:          42,
+		RoomID:      7,
+		UserID:      2,
+		Username:    "grace",
+		Content:     "see `main.go`",
+		CreatedAt:   time.Date(2026, 3, 14, 15, 9, 26, 0, time.UTC),
+		ContentType: "markdown",
+		Language:    "go",
+		Filtered:    true,
+		Truncated:   true,
+		Override:    true,
+		System:      true,
+		SystemEvent: store.SystemEvent{"event": "join", "username": "grace"},
+		ContentHash: "not on the wire",
+
+		LegacySystem:  true,
+		AuthorDeleted: true,
+		Redacted:      true,
+		RoomMention:   "room",
+	}
+}
+
+// checkGolden compares v, encoded as indented JSON, with testdata/name
+func checkGolden(t *testing.T, name string, v any) {
+	t.Helper()
+	got, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s changed; if that's intended, run with -update\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+// TestWireGolden pins the JSON of a chat message over REST and as a frame,
+// saved and unsaved
+func TestWireGolden(t *testing.T) {
+	checkGolden(t, "rest_message.golden", wireMessage())
+	checkGolden(t, "frame_message.golden", NewChatMessage(wireMessage()))
+
+	unsaved := NewChatMessage(wireMessage())
+	unsaved.ID, unsaved.CreatedAt = 0, nil
+	checkGolden(t, "frame_message_unsaved.golden", unsaved)
+}
+
+// jsonKeys returns the top-level keys v encodes to
+func jsonKeys(t *testing.T, v any) []string {
+	t.Helper()
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		t.Fatal(err)
+	}
+	return slices.Sorted(maps.Keys(fields))
+}
+
+// TestWireConformance checks a frame has every field REST has, with the
+// same value, plus "type" and nothing else
+// A field added to store.Message and not to the frame fails it
+func TestWireConformance(t *testing.T) {
+	rest := jsonKeys(t, wireMessage())
+	frame := jsonKeys(t, NewChatMessage(wireMessage()))
+	want := slices.Sorted(slices.Values(append(rest, "type")))
+	if !slices.Equal(frame, want) {
+		t.Errorf("the frame has %v, want the REST fields %v and type", frame, rest)
+	}
+
+	var decoded store.Message
+	encoded, _ := json.Marshal(NewChatMessage(wireMessage()))
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	original := wireMessage()
+	original.ContentHash = ""
+	if !reflect.DeepEqual(decoded, *original) {
+		t.Errorf("a frame decodes to %+v, want %+v", decoded, *original)
+	}
+}
+
+// TestStoreMessage converts a frame back to the message to save: every
+// field comes back except those the store fills in
+func TestStoreMessage(t *testing.T) {
+	got := NewChatMessage(wireMessage()).StoreMessage()
+	want := wireMessage()
+	want.ID, want.CreatedAt, want.ContentHash, want.LegacySystem, want.AuthorDeleted, want.Redacted = 0, time.Time{}, "", false, false, false
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", *got, *want)
+	}
+}
@@ -0,0 +1,405 @@
+// Package chatclient is a Go client for go-chat, for tools and bots that
+// talk to a chat server
+//
+// Dial checks the token and returns a Client. JoinRoom (or OpenRoom, for a
+// room the user is already in) connects to the room's WebSocket and keeps it
+// connected: a dropped connection is dialed again with exponential backoff
+// and jitter, and the chat messages sent while it was down are fetched with
+// history requests on the new connection, then delivered in order before
+// anything newer. Each chat message reaches the handlers once
+//
+// Frames are decoded into wire.Message, the type the server encodes them
+// from, so the two can't drift apart
+package chatclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drazan344/go-chat/pkg/wire"
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// ErrClosed is returned by a Client after Close or Shutdown
+	ErrClosed = errors.New("client closed")
+
+	// ErrNotConnected is returned when sending to a room that isn't open or is reconnecting
+	ErrNotConnected = errors.New("room not connected")
+
+	// ErrJoinPending is returned by JoinRoom for rooms that need approval:
+	// the join request is made, and the room can be opened once it's approved
+	ErrJoinPending = errors.New("join request awaiting approval")
+)
+
+// Frame types the server sends, for Subscribe
+// The wire.Message fields each one sets are documented there
+const (
+	AllFrames            = "*" // Every frame, whatever its type
+	FrameMessage         = "message"
+	FrameMessageRedacted = "message_redacted"
+	FrameJoin            = "join"
+	FrameLeave           = "leave"
+	FrameError           = "error"
+	FrameNotice          = "notice"
+	FrameDelivered       = "delivered"
+	FrameRoomStats       = "room_stats"
+	FramePinAdded        = "pin_added"
+	FramePinRemoved      = "pin_removed"
+	FrameDraining        = "server_draining"
+	FrameRemoved         = "removed_from_room"
+	FrameLeftRoom        = "left_room"
+	FrameRoomDeleted     = "room_deleted"
+	FrameRoomMerged      = "room_merged"
+	FrameRoomUpdated     = "room_updated"
+	FrameHistoryError    = "history_error"
+	FrameInvite          = "invite"
+	FrameInviteAccepted  = "invite_accepted"
+	FrameInviteDeclined  = "invite_declined"
+)
+
+// User is the account a Client is signed in as
+type User struct {
+	ID          int64  `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+}
+
+// APIError is an error response from the server
+type APIError struct {
+	Status  int
+	Code    string // Machine-readable, e.g. "room_invite_only"; branch on this
+	Message string // Localized text for people
+
+	// RetryAfter is the server's Retry-After, on 429 and 503 responses
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// Handler receives frames; see Subscribe
+type Handler func(*wire.Message)
+
+// Client is a connection to a go-chat server as one user
+// It's safe for concurrent use
+type Client struct {
+	baseURL    *url.URL
+	token      string
+	user       User
+	httpClient *http.Client
+	dialer     *websocket.Dialer
+	backoff    backoff
+	onState    func(StateChange)
+
+	mu     sync.Mutex
+	rooms  map[int64]*roomConn
+	closed bool
+
+	subMu    sync.RWMutex
+	handlers map[string][]*subscription
+}
+
+// subscription is one registered handler; its address tells it apart
+type subscription struct {
+	handler Handler
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient makes REST calls with client instead of http.DefaultClient
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) { c.httpClient = client }
+}
+
+// WithDialer opens WebSockets with dialer instead of websocket.DefaultDialer
+func WithDialer(dialer *websocket.Dialer) Option {
+	return func(c *Client) { c.dialer = dialer }
+}
+
+// WithBackoff sets the wait before reconnecting: min after the first failed
+// attempt, doubling up to max (defaults 500ms and 30s)
+// Each wait is jittered between half and all of it
+func WithBackoff(min, max time.Duration) Option {
+	return func(c *Client) { c.backoff = backoff{min: min, max: max} }
+}
+
+// WithStateHandler calls fn whenever a room's connection changes state
+// It's called on the room's own goroutine, so it mustn't block
+func WithStateHandler(fn func(StateChange)) Option {
+	return func(c *Client) { c.onState = fn }
+}
+
+// Dial returns a Client for the server at baseURL (e.g.
+// "https://chat.example.com", or with the path go-chat is mounted under),
+// authenticated with token: a JWT from /v1/auth/login or an API token
+// The token is checked before Dial returns; no room is connected yet
+func Dial(ctx context.Context, baseURL, token string, opts ...Option) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("base URL %q must be http or https", baseURL)
+	}
+
+	c := &Client{
+		baseURL:    base,
+		token:      token,
+		httpClient: http.DefaultClient,
+		dialer:     websocket.DefaultDialer,
+		backoff:    defaultBackoff,
+		onState:    func(StateChange) {},
+		rooms:      make(map[int64]*roomConn),
+		handlers:   make(map[string][]*subscription),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if _, err := c.do(ctx, http.MethodGet, "/v1/auth/me", nil, &c.user); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// User returns the account the client is signed in as
+func (c *Client) User() User {
+	return c.user
+}
+
+// Subscribe calls h with every frame of frameType (a Frame constant, or
+// AllFrames), from any open room, until unsubscribe is called
+// Each room delivers its frames in order on its own goroutine; a slow
+// handler holds up that room's frames
+// Chat messages missed while reconnecting arrive as FrameMessage frames too
+func (c *Client) Subscribe(frameType string, h Handler) (unsubscribe func()) {
+	sub := &subscription{handler: h}
+	c.subMu.Lock()
+	c.handlers[frameType] = append(c.handlers[frameType], sub)
+	c.subMu.Unlock()
+
+	return func() {
+		c.subMu.Lock()
+		defer c.subMu.Unlock()
+		subs := c.handlers[frameType]
+		for i, s := range subs {
+			if s == sub {
+				c.handlers[frameType] = append(subs[:i:i], subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// dispatch hands a frame to the handlers for its type, then to those for all frames
+func (c *Client) dispatch(frame *wire.Message) {
+	c.subMu.RLock()
+	subs := append(append([]*subscription(nil), c.handlers[frame.Type]...), c.handlers[AllFrames]...)
+	c.subMu.RUnlock()
+	for _, sub := range subs {
+		sub.handler(frame)
+	}
+}
+
+// JoinRoom makes the user a member of a room and opens it (see OpenRoom)
+// Being a member already isn't an error. Rooms that need approval return
+// ErrJoinPending; invite-only rooms an *APIError with "room_invite_only"
+func (c *Client) JoinRoom(ctx context.Context, roomID int64) error {
+	status, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/v1/rooms/%d/join", roomID), nil, nil)
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Code == "already_member":
+	case err != nil:
+		return err
+	case status == http.StatusAccepted:
+		return ErrJoinPending
+	}
+	return c.OpenRoom(ctx, roomID)
+}
+
+// OpenRoom connects to the WebSocket of a room the user is a member of
+// It returns once the first connection is up, or with its error; from then
+// on the connection is kept up until LeaveRoom, Close or a close the server
+// means for good (removed from the room, room deleted or merged, session
+// revoked), which is reported as StateClosed
+// Opening a room that's already open does nothing
+func (c *Client) OpenRoom(ctx context.Context, roomID int64) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	if _, ok := c.rooms[roomID]; ok {
+		c.mu.Unlock()
+		return nil
+	}
+	room := newRoomConn(c, roomID)
+	c.rooms[roomID] = room
+	c.mu.Unlock()
+
+	if err := room.start(ctx); err != nil {
+		c.forget(room)
+		return err
+	}
+	return nil
+}
+
+// LeaveRoom closes the room's connection and ends the user's membership
+func (c *Client) LeaveRoom(ctx context.Context, roomID int64) error {
+	if room := c.room(roomID); room != nil {
+		c.forget(room)
+		room.close()
+	}
+	_, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/v1/rooms/%d/leave", roomID), nil, nil)
+	return err
+}
+
+// Outgoing is a chat message to send
+type Outgoing struct {
+	Content     string `json:"content"`
+	ContentType string `json:"content_type,omitempty"` // "text" (the default), "markdown" or "code"
+	Language    string `json:"language,omitempty"`     // The highlighting language of code
+	Silent      bool   `json:"silent,omitempty"`       // No notifications; API tokens only
+}
+
+// SendMessage sends a plain text chat message to an open room
+func (c *Client) SendMessage(ctx context.Context, roomID int64, content string) error {
+	return c.Send(ctx, roomID, Outgoing{Content: content})
+}
+
+// Send sends a chat message to an open room
+// It returns once the frame is written; the message itself comes back as a
+// FrameMessage frame once it's saved, and a refusal (e.g.
+// "message_too_long") as a FrameError frame
+// While the room is reconnecting it returns ErrNotConnected without sending
+func (c *Client) Send(ctx context.Context, roomID int64, message Outgoing) error {
+	room := c.room(roomID)
+	if room == nil {
+		return ErrNotConnected
+	}
+	return room.send(ctx, message)
+}
+
+// Shutdown closes every room's connection with a normal close and waits for
+// their goroutines to finish, or for ctx to be done
+// The client can't be used afterwards
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	c.closed = true
+	rooms := make([]*roomConn, 0, len(c.rooms))
+	for _, room := range c.rooms {
+		rooms = append(rooms, room)
+	}
+	c.rooms = make(map[int64]*roomConn)
+	c.mu.Unlock()
+
+	for _, room := range rooms {
+		room.close()
+	}
+	for _, room := range rooms {
+		select {
+		case <-room.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Close is Shutdown without a deadline
+func (c *Client) Close() error {
+	return c.Shutdown(context.Background())
+}
+
+// room returns an open room's connection, or nil
+func (c *Client) room(roomID int64) *roomConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rooms[roomID]
+}
+
+// forget drops a room's connection from the open rooms, if it's still the one there
+func (c *Client) forget(room *roomConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rooms[room.roomID] == room {
+		delete(c.rooms, room.roomID)
+	}
+}
+
+// endpoint returns the URL of an API path, under the base URL's path
+func (c *Client) endpoint(path string) *url.URL {
+	u := *c.baseURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	return &u
+}
+
+// do makes a REST call with body as JSON (none if nil) and decodes a
+// successful response into out, if it isn't nil
+// Error responses come back as *APIError
+func (c *Client) do(ctx context.Context, method, path string, body, out any) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint(path).String(), reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return resp.StatusCode, readAPIError(resp)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, err
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// maxErrorBody caps how much of an error response is read
+const maxErrorBody = 64 * 1024
+
+// readAPIError reads an error response: {"error": "...", "code": "..."}
+// A body in another shape still gives an *APIError, with the status text
+func readAPIError(resp *http.Response) *APIError {
+	apiErr := &APIError{Status: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	var body struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxErrorBody)).Decode(&body); err == nil {
+		apiErr.Code, apiErr.Message = body.Code, body.Error
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
@@ -0,0 +1,43 @@
+package chatclient
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBackoff checks each wait is between half and all of min doubled per
+// failed attempt, capped at max, however many attempts failed
+func TestBackoff(t *testing.T) {
+	b := backoff{min: 100 * time.Millisecond, max: 2 * time.Second}
+	for attempt, full := range map[int]time.Duration{
+		0:   100 * time.Millisecond,
+		1:   200 * time.Millisecond,
+		4:   1600 * time.Millisecond,
+		5:   2 * time.Second,
+		40:  2 * time.Second,
+		100: 2 * time.Second,
+	} {
+		for range 100 {
+			if d := b.delay(attempt); d < full/2 || d > full {
+				t.Fatalf("attempt %d waited %v, want between %v and %v", attempt, d, full/2, full)
+			}
+		}
+	}
+}
+
+// TestIDWindow checks duplicates are caught among the last n IDs only
+func TestIDWindow(t *testing.T) {
+	w := newIDWindow(3)
+	for _, id := range []int64{1, 2, 3} {
+		if !w.add(id) {
+			t.Fatalf("%d was taken for a duplicate", id)
+		}
+	}
+	if w.add(2) {
+		t.Error("2 wasn't taken for a duplicate")
+	}
+	w.add(4)
+	if !w.add(1) {
+		t.Error("1 is still remembered after 3 newer IDs")
+	}
+}
@@ -0,0 +1,455 @@
+package chatclient
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/drazan344/go-chat/pkg/wire"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// readTimeout is how long a connection may go without a frame or a ping
+	// The server pings every 54 seconds
+	readTimeout = 75 * time.Second
+
+	// writeTimeout bounds a write when the caller's context has no deadline
+	writeTimeout = 10 * time.Second
+
+	// backfillPageSize is how many messages each history request asks for,
+	// the server's maximum
+	backfillPageSize = 200
+
+	// maxBackfillPages caps the pages fetched after one reconnect; older
+	// missed messages than that are skipped
+	maxBackfillPages = 50
+
+	// seenWindow is how many recent message IDs are kept to drop duplicates
+	seenWindow = 1024
+)
+
+// Close codes the server ends a room's connection with for good (see
+// internal/websocket/hub.go and membership.go); every other close is reconnected
+// A connection ended with one of them reports StateClosed with a
+// *websocket.CloseError carrying the code
+const (
+	CloseRemovedFromRoom = 4003
+	CloseRoomDeleted     = 4004
+	CloseRoomMerged      = 4301 // The reason names the room it was merged into
+	CloseSessionRevoked  = 4401
+	CloseLeftRoom        = 4410 // The user left the room, e.g. in another tab
+)
+
+// historyRequest asks for one page of a room's messages (see
+// internal/websocket/history.go)
+type historyRequest struct {
+	Type     string `json:"type"`
+	RoomID   int64  `json:"room_id"`
+	BeforeID int64  `json:"before_id,omitempty"`
+	Limit    int    `json:"limit"`
+	ReqID    string `json:"req_id"`
+}
+
+// roomConn keeps one room's WebSocket connected
+// After every connect it syncs: the first time it only learns the room's
+// newest message, and after a reconnect it pages back with history requests
+// to the last message delivered. Chat messages arriving live meanwhile are
+// held, then delivered after the missed ones
+type roomConn struct {
+	client *Client
+	roomID int64
+
+	ctx    context.Context // Done once the room is closed
+	cancel context.CancelFunc
+	done   chan struct{} // Closed when the connection's goroutine returns
+
+	mu      sync.Mutex
+	conn    *websocket.Conn // nil while reconnecting
+	writeMu sync.Mutex      // One writer at a time
+
+	// Only used by the goroutine reading the connection
+	lastID     int64 // The newest chat message delivered or, before any, the room's newest
+	synced     bool  // Whether a sync has finished, so lastID is known
+	seen       *idWindow
+	sync       *backfill     // Set while syncing
+	reqSeq     int           // Numbers the syncs' request IDs
+	retryAfter time.Duration // The server's wait before the next reconnect, if it asked for one
+}
+
+// backfill is a sync in progress
+type backfill struct {
+	reqID        string
+	initial      bool            // The first sync: only lastID is wanted
+	pages        int             // Requested so far
+	oldest       int64           // The oldest message ID seen so far
+	reachedKnown bool            // A page reached lastID
+	found        []*wire.Message // Missed messages
+	buffered     []*wire.Message // Live messages held until the sync is done
+}
+
+func newRoomConn(c *Client, roomID int64) *roomConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &roomConn{
+		client: c,
+		roomID: roomID,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		seen:   newIDWindow(seenWindow),
+	}
+}
+
+// start dials the room once and, if that works, keeps it connected from a
+// goroutine of its own
+func (r *roomConn) start(ctx context.Context) error {
+	r.client.onState(StateChange{RoomID: r.roomID, State: StateConnecting})
+
+	// Closing the room while this dial is under way cancels it too
+	dialCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(r.ctx, cancel)
+	defer stop()
+
+	conn, err := r.dial(dialCtx)
+	if err != nil {
+		r.cancel()
+		close(r.done)
+		r.client.onState(StateChange{RoomID: r.roomID, State: StateClosed, Err: err})
+		return err
+	}
+	r.setConn(conn)
+	r.client.onState(StateChange{RoomID: r.roomID, State: StateConnected})
+	go r.run(conn)
+	return nil
+}
+
+// close ends the room's connection with a normal close and stops reconnecting
+func (r *roomConn) close() {
+	r.cancel()
+	r.mu.Lock()
+	conn := r.conn
+	r.mu.Unlock()
+	if conn != nil {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		conn.Close()
+	}
+}
+
+// setConn swaps the current connection; one that's set after the room was
+// closed is closed at once
+func (r *roomConn) setConn(conn *websocket.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conn = conn
+	if conn != nil && r.ctx.Err() != nil {
+		conn.Close()
+	}
+}
+
+// run serves connections until the room is closed or the server ends it for good
+func (r *roomConn) run(conn *websocket.Conn) {
+	defer close(r.done)
+	for {
+		err := r.serve(conn)
+		r.setConn(nil)
+		if r.ctx.Err() == nil && !isPermanent(err) {
+			r.client.onState(StateChange{RoomID: r.roomID, State: StateReconnecting, Err: err})
+			conn, err = r.reconnect()
+		}
+		if r.ctx.Err() != nil {
+			r.client.onState(StateChange{RoomID: r.roomID, State: StateClosed})
+			return
+		}
+		if err != nil {
+			r.client.forget(r)
+			r.client.onState(StateChange{RoomID: r.roomID, State: StateClosed, Err: err})
+			return
+		}
+		r.setConn(conn)
+		r.client.onState(StateChange{RoomID: r.roomID, State: StateConnected})
+	}
+}
+
+// reconnect dials until a connection is up, waiting the backoff (or what
+// the server asked for) before each attempt
+// It fails once the room is closed or the server refuses it for good
+func (r *roomConn) reconnect() (*websocket.Conn, error) {
+	for attempt := 0; ; attempt++ {
+		wait := r.client.backoff.delay(attempt)
+		if r.retryAfter > 0 {
+			wait = r.retryAfter + jitter(r.client.backoff.min)
+			r.retryAfter = 0
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-r.ctx.Done():
+			timer.Stop()
+			return nil, r.ctx.Err()
+		}
+
+		conn, err := r.dial(r.ctx)
+		if err == nil {
+			return conn, nil
+		}
+		if r.ctx.Err() != nil || isPermanent(err) {
+			return nil, err
+		}
+	}
+}
+
+// dial opens a WebSocket to the room
+// A refused handshake comes back as *APIError, and its Retry-After is
+// waited before the next attempt
+func (r *roomConn) dial(ctx context.Context) (*websocket.Conn, error) {
+	u := r.client.endpoint(fmt.Sprintf("/v1/rooms/%d/ws", r.roomID))
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+	header := http.Header{"Authorization": {"Bearer " + r.client.token}}
+
+	conn, resp, err := r.client.dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil {
+			apiErr := readAPIError(resp)
+			r.retryAfter = apiErr.RetryAfter
+			return nil, apiErr
+		}
+		return nil, err
+	}
+
+	// The server's pings carry their send time, which the pong echoes back
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeTimeout))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	})
+	return conn, nil
+}
+
+// isPermanent reports whether err means the room can't be connected again:
+// a handshake refused for the token or the membership, or one of the close
+// codes the server ends a connection with for good
+func isPermanent(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.Status {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+			return true
+		}
+		return false
+	}
+	return websocket.IsCloseError(err, CloseRemovedFromRoom, CloseRoomDeleted, CloseRoomMerged, CloseSessionRevoked, CloseLeftRoom)
+}
+
+// serve syncs a new connection and hands out its frames until it fails
+// A sync cut short is dropped: the next connection's sync fetches the same
+// messages again, since lastID hasn't moved
+func (r *roomConn) serve(conn *websocket.Conn) error {
+	defer func() { r.sync = nil }()
+	if err := r.startSync(conn); err != nil {
+		return err
+	}
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		// The server batches frames that queued up into one message, one
+		// per line
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var frame wire.Message
+			if err := decoder.Decode(&frame); err != nil {
+				break // The end, or something that isn't a frame
+			}
+			if err := r.handle(conn, &frame); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handle routes one frame: sync responses to the sync, chat messages to be
+// delivered once (or held while syncing), and the rest to the handlers
+func (r *roomConn) handle(conn *websocket.Conn, frame *wire.Message) error {
+	if s := r.sync; s != nil {
+		switch {
+		case (frame.Type == "history_response" || frame.Type == FrameHistoryError) && frame.ReqID == s.reqID:
+			return r.syncFrame(conn, frame)
+		case frame.Type == FrameMessage:
+			s.buffered = append(s.buffered, frame)
+			return nil
+		case frame.Type == FrameError && frame.Code == "unknown_frame_type":
+			// A server without history over the socket: there's nothing to fetch
+			r.finishSync()
+			return nil
+		}
+	}
+
+	if frame.Type == FrameDraining && frame.ReconnectAfter > 0 {
+		r.retryAfter = time.Duration(frame.ReconnectAfter) * time.Second
+	}
+	if frame.Type == FrameMessage {
+		r.deliver(frame)
+		return nil
+	}
+	r.client.dispatch(frame)
+	return nil
+}
+
+// deliver hands a chat message to the handlers unless it was delivered before
+// Messages the server couldn't save have no ID and are always delivered
+func (r *roomConn) deliver(message *wire.Message) {
+	if message.ID != 0 {
+		if !r.seen.add(message.ID) {
+			return
+		}
+		r.lastID = max(r.lastID, message.ID)
+	}
+	r.client.dispatch(message)
+}
+
+// startSync starts syncing a new connection
+func (r *roomConn) startSync(conn *websocket.Conn) error {
+	r.reqSeq++
+	r.sync = &backfill{
+		reqID:   "chatclient-sync-" + strconv.Itoa(r.reqSeq),
+		initial: !r.synced,
+	}
+	limit := backfillPageSize
+	if r.sync.initial {
+		limit = 1
+	}
+	return r.requestPage(conn, 0, limit)
+}
+
+// requestPage asks for the messages before beforeID (the newest without it)
+func (r *roomConn) requestPage(conn *websocket.Conn, beforeID int64, limit int) error {
+	r.sync.pages++
+	return r.write(context.Background(), conn, historyRequest{
+		Type:     "history_request",
+		RoomID:   r.roomID,
+		BeforeID: beforeID,
+		Limit:    limit,
+		ReqID:    r.sync.reqID,
+	})
+}
+
+// syncFrame takes in a response to the sync's history request
+// Pages come newest first; paging stops at the first one reaching lastID
+func (r *roomConn) syncFrame(conn *websocket.Conn, frame *wire.Message) error {
+	s := r.sync
+	if frame.Type == FrameHistoryError {
+		// Whatever was fetched is still delivered; the error is the caller's to see
+		r.finishSync()
+		r.client.dispatch(frame)
+		return nil
+	}
+
+	for _, message := range frame.Messages {
+		if s.initial {
+			r.lastID = max(r.lastID, message.ID)
+			continue
+		}
+		if message.ID > r.lastID {
+			s.found = append(s.found, message)
+		} else {
+			s.reachedKnown = true
+		}
+		if s.oldest == 0 || message.ID < s.oldest {
+			s.oldest = message.ID
+		}
+	}
+	if !frame.Final {
+		return nil
+	}
+
+	if s.initial || s.reachedKnown || !frame.HasMore || s.oldest == 0 || s.pages >= maxBackfillPages {
+		r.finishSync()
+		return nil
+	}
+	return r.requestPage(conn, s.oldest, backfillPageSize)
+}
+
+// finishSync delivers the missed messages in order, then the live ones held
+// meanwhile, skipping any delivered already
+func (r *roomConn) finishSync() {
+	s := r.sync
+	r.sync = nil
+	r.synced = true
+	slices.SortFunc(s.found, func(a, b *wire.Message) int { return cmp.Compare(a.ID, b.ID) })
+	for _, message := range s.found {
+		r.deliver(message)
+	}
+	for _, message := range s.buffered {
+		r.deliver(message)
+	}
+}
+
+// send writes a chat message to the current connection
+func (r *roomConn) send(ctx context.Context, message Outgoing) error {
+	r.mu.Lock()
+	conn := r.conn
+	r.mu.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	return r.write(ctx, conn, message)
+}
+
+// write sends v as a JSON frame, by ctx's deadline or within writeTimeout
+func (r *roomConn) write(ctx context.Context, conn *websocket.Conn, v any) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(writeTimeout)
+	}
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	conn.SetWriteDeadline(deadline)
+	return conn.WriteJSON(v)
+}
+
+// idWindow remembers the last n IDs added
+type idWindow struct {
+	ids  map[int64]struct{}
+	ring []int64
+	next int
+}
+
+func newIDWindow(n int) *idWindow {
+	return &idWindow{ids: make(map[int64]struct{}, n), ring: make([]int64, n)}
+}
+
+// add remembers id, forgetting the oldest one, and reports whether it was new
+func (w *idWindow) add(id int64) bool {
+	if _, ok := w.ids[id]; ok {
+		return false
+	}
+	if old := w.ring[w.next]; old != 0 {
+		delete(w.ids, old)
+	}
+	w.ring[w.next] = id
+	w.next = (w.next + 1) % len(w.ring)
+	w.ids[id] = struct{}{}
+	return true
+}
@@ -0,0 +1,71 @@
+package chatclient
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// State is where a room's connection is
+type State int
+
+const (
+	// StateConnecting is the first dial, before OpenRoom returns
+	StateConnecting State = iota
+	// StateConnected means frames are flowing; after a reconnect, missed
+	// chat messages are still being fetched and are delivered first
+	StateConnected
+	// StateReconnecting means the connection dropped and is being dialed again
+	StateReconnecting
+	// StateClosed is final: closed by the client, or by the server for good
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// StateChange is a room's connection moving to State
+type StateChange struct {
+	RoomID int64
+	State  State
+
+	// Err is why the connection dropped (StateReconnecting) or ended
+	// (StateClosed); nil when the client closed it
+	Err error
+}
+
+// backoff is the wait before each reconnect attempt
+type backoff struct {
+	min, max time.Duration
+}
+
+var defaultBackoff = backoff{min: 500 * time.Millisecond, max: 30 * time.Second}
+
+// delay returns the wait before the attempt after attempt failures: min
+// doubled per failure up to max, of which a random half is waited, so
+// clients dropped together don't all come back at once
+func (b backoff) delay(attempt int) time.Duration {
+	d := b.max
+	if attempt < 32 && b.min<<attempt < b.max && b.min<<attempt > 0 {
+		d = b.min << attempt
+	}
+	return d/2 + jitter(d/2)
+}
+
+// jitter returns a random duration in [0, d]
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d + 1)
+}
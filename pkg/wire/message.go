@@ -0,0 +1,171 @@
+// Package wire holds the types go-chat's WebSocket frames are made of, for
+// the server to encode and clients to decode
+// It imports nothing but the standard library, so clients can use it
+// without pulling in the server
+package wire
+
+import "time"
+
+// Message is one WebSocket frame: a chat message, a join or leave, an error
+// and so on, told apart by Type
+// The server's hub embeds it in its own message type (internal/websocket),
+// so the frames it sends and the ones clients decode are the same type
+// The files and functions the field comments refer to are in that package
+type Message struct {
+	ID       int64  `json:"id,omitempty"` // Database ID, set once a chat message is saved
+	RoomID   int64  `json:"room_id"`
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+	Content  string `json:"content"`
+	Type     string `json:"type"` // "message", "join", "leave", "error"
+
+	// CreatedAt is when a chat message was saved; absent until it has been,
+	// and on other frame types
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+
+	// Formatting metadata for chat messages (see internal/content)
+	ContentType string `json:"content_type,omitempty"`
+	Language    string `json:"language,omitempty"`
+
+	// Code is a machine-readable reason on "error" and "notice" frames
+	Code string `json:"code,omitempty"`
+
+	// UpToMessageID is set on "delivered" frames: every message up to and
+	// including this ID has reached the user
+	UpToMessageID int64 `json:"up_to_message_id,omitempty"`
+
+	// Filtered is true if the content filter masked part of the message
+	Filtered bool `json:"filtered,omitempty"`
+
+	// Truncated is true if the message was cut down to the length limit
+	Truncated bool `json:"truncated,omitempty"`
+
+	// ReconnectAfter is set on "server_draining" frames: seconds to wait before reconnecting
+	ReconnectAfter int `json:"reconnect_after,omitempty"`
+
+	// Set on pin frames: the message concerned ("pin_added", "pin_removed"),
+	// the full pin order ("pin_order_changed") and the room's pins version after the change
+	MessageID   int64   `json:"message_id,omitempty"`
+	PinnedIDs   []int64 `json:"pinned_ids,omitempty"`
+	PinsVersion int64   `json:"pins_version,omitempty"`
+
+	// Override is true on chat messages an admin posted during quiet hours
+	Override bool `json:"override,omitempty"`
+
+	// Moderated is true on chat messages the room's moderation bot rewrote
+	Moderated bool `json:"moderated,omitempty"`
+
+	// System is true on chat messages the server posted as the system user
+	System bool `json:"system,omitempty"`
+
+	// Set on chat messages a bridge bot relayed for someone else: the name and
+	// avatar to show instead of the bot's. Only the REST API sets them, for
+	// API token callers; UserID and Username stay the bot's
+	OverrideUsername  string `json:"override_username,omitempty"`
+	OverrideAvatarURL string `json:"override_avatar_url,omitempty"`
+
+	// Silent is true on chat messages a bot sent without notifications: nobody
+	// is flagged "notify" or pushed for them (see internal/websocket/options.go)
+	Silent bool `json:"silent,omitempty"`
+
+	// SuppressEcho is set on "options_updated" frames: the connection's setting now
+	SuppressEcho *bool `json:"suppress_echo,omitempty"`
+
+	// RTTMs is set on "ping_stats" frames: the connection's average round-trip time
+	RTTMs float64 `json:"rtt_ms,omitempty"`
+
+	// EventSeq is set on frames for changes recorded in the room's event log
+	// Clients keep the highest one seen and pass it to GET /v1/rooms/{id}/events
+	// after reconnecting to replay what they missed
+	EventSeq int64 `json:"event_seq,omitempty"`
+
+	// UserSeq numbers the frames a signed-in user receives, across all their
+	// connections and rooms (see user_events.go); the same frame has the same
+	// number on every connection. A gap means frames were missed, which
+	// GET /v1/users/me/events?after_user_seq= returns. Frames meant only for
+	// one connection (errors, acks, history pages) have none
+	UserSeq int64 `json:"user_seq,omitempty"`
+
+	// Txn is shared by the frames one action produced, e.g. a kick's
+	// "member_removed" frame and system message, so clients can apply them together
+	Txn string `json:"txn,omitempty"`
+
+	// Set on "room_stats" frames: distinct members connected and the room's
+	// member count (see internal/websocket/room_stats.go); pointers so zero
+	// is still sent
+	Online  *int `json:"online,omitempty"`
+	Members *int `json:"members,omitempty"`
+
+	// SystemEvent is set on system messages and on "join" and "leave" frames:
+	// what Content says, as an event with parameters (see internal/sysmsg)
+	SystemEvent SystemEvent `json:"system_event,omitempty"`
+
+	// LegacySystem marks system messages saved as text before they had
+	// events; their Content is shown as it is
+	LegacySystem bool `json:"legacy_system,omitempty"`
+
+	// AuthorDeleted is true on chat messages whose author's account is
+	// deleted; UserID is 0 and Username the name they had
+	AuthorDeleted bool `json:"author_deleted,omitempty"`
+
+	// Redacted is true on chat messages a platform admin removed; Content is
+	// the redaction marker. "message_redacted" frames tell clients to replace
+	// a message they already show (MessageID) with the marker
+	Redacted bool `json:"redacted,omitempty"`
+
+	// RoomMention is "here" or "room" on chat messages that notified the whole
+	// room (@here: members connected to it, @room: every member); empty when
+	// the text has neither or the sender wasn't allowed to use them
+	RoomMention string `json:"room_mention,omitempty"`
+
+	// Attachment is set on "attachment_thumbnail" frames: the uploader's
+	// attachment once its thumbnail is made (or turned out impossible)
+	Attachment *Attachment `json:"attachment,omitempty"`
+
+	// Set on "throttled" frames: the frame types held back while the
+	// connection is over its bandwidth budget; and on "unthrottled" frames:
+	// how many frames it missed (see throttle.go)
+	DroppedTypes []string `json:"dropped_types,omitempty"`
+	Dropped      *int     `json:"dropped,omitempty"`
+
+	// PostPolicy is set on "room_updated" frames sent when a room's post policy
+	// changes: "everyone" or "admins_only", where only the owner and admins may
+	// send messages. Clients disable their input box for everyone else
+	PostPolicy string `json:"post_policy,omitempty"`
+
+	// Set on "invite" frames, sent to every connection of a user invited to a
+	// room: the invite to answer with an "invite_response" frame (see
+	// invites.go), the room and who invited them. The "invite_accepted" and
+	// "invite_declined" frames confirming the answer carry InviteID and Room too
+	InviteID int64  `json:"invite_id,omitempty"`
+	Room     *Room  `json:"room,omitempty"`
+	From     string `json:"from,omitempty"`
+
+	// TargetRoomID is set on "room_merged" frames: the room that took over this one
+	TargetRoomID int64 `json:"target_room_id,omitempty"`
+
+	// AuditSeq numbers room broadcasts when sequence auditing is on (see audit.go)
+	AuditSeq int64 `json:"audit_seq,omitempty"`
+
+	// Set on "history_response" and "history_error" frames: the request ID the
+	// client sent, one slice of the requested page (oldest first) and, on the
+	// response's final frame, whether older messages exist
+	ReqID    string     `json:"req_id,omitempty"`
+	Messages []*Message `json:"messages,omitempty"`
+	HasMore  bool       `json:"has_more,omitempty"`
+	Final    bool       `json:"final,omitempty"`
+
+	// Notify is set on chat messages sent to a user they mention, or that
+	// mention the whole room (RoomMention), if the user wants mention alerts
+	// (see mentions.go); clients should alert on it
+	Notify bool `json:"notify,omitempty"`
+}
+
+// SystemEvent is a structured system message: the event under "event" and its
+// parameters under their own names, e.g. {"event": "join", "username": "alice"}
+type SystemEvent map[string]string
+
+// Name returns the event, e.g. "join"
+func (e SystemEvent) Name() string {
+	return e["event"]
+}
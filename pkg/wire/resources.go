@@ -0,0 +1,34 @@
+package wire
+
+import "time"
+
+// Attachment is an upload as its owner sees it, e.g. in
+// "attachment_thumbnail" frames
+type Attachment struct {
+	ID           int64     `json:"id"`            // The upload
+	AttachmentID int64     `json:"attachment_id"` // The stored file, shared by identical uploads
+	UserID       int64     `json:"user_id"`
+	Filename     string    `json:"filename"`
+	Hash         string    `json:"hash"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	// Deduplicated is true if the bytes were already stored by an earlier upload
+	Deduplicated bool `json:"deduplicated"`
+
+	// Images only: dimensions, whether the thumbnail is still being made and,
+	// once it's ready, where to fetch it
+	Width            int    `json:"width,omitempty"`
+	Height           int    `json:"height,omitempty"`
+	ThumbnailPending bool   `json:"thumbnail_pending,omitempty"`
+	ThumbnailURL     string `json:"thumbnail_url,omitempty"`
+}
+
+// Room is a room as "invite" frames describe it to someone who isn't in it yet
+type Room struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MemberCount int    `json:"member_count"`
+}
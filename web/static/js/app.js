@@ -27,6 +27,17 @@ function showAuthModal() {
         document.getElementById('register-form').style.display = 'none';
         document.getElementById('login-form').style.display = 'block';
     });
+
+    // Links in invite emails open the sign-up form straight away
+    if (inviteToken()) {
+        document.getElementById('login-form').style.display = 'none';
+        document.getElementById('register-form').style.display = 'block';
+    }
+}
+
+// inviteToken returns the token of the email invite the page was opened from, if any
+function inviteToken() {
+    return new URLSearchParams(window.location.search).get('invite');
 }
 
 async function handleLogin() {
@@ -49,7 +60,9 @@ async function handleRegister() {
     const errorDiv = document.getElementById('register-error');
 
     try {
-        await auth.register(username, email, password);
+        await auth.register(username, email, password, inviteToken());
+        // Drop the token from the address bar; it's been used
+        history.replaceState(null, '', window.location.pathname);
         initChatApp();
     } catch (error) {
         errorDiv.textContent = error.message;
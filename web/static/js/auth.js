@@ -5,15 +5,24 @@ class Auth {
         this.user = JSON.parse(localStorage.getItem('user') || 'null');
     }
 
-    async register(username, email, password) {
+    // inviteToken comes from an email invite link; the rooms it invites to are joined on sign-up
+    async register(username, email, password, inviteToken) {
+        const body = { username, email, password };
+        if (inviteToken) {
+            body.invite_token = inviteToken;
+        }
         const response = await fetch('/v1/auth/register', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ username, email, password })
+            body: JSON.stringify(body)
         });
 
         if (!response.ok) {
             const error = await response.json();
+            // Password policy errors list every rule the password broke
+            if (error.fields && error.fields.password) {
+                throw new Error(error.fields.password.map(f => f.error).join('; '));
+            }
             throw new Error(error.error || 'Registration failed');
         }
 
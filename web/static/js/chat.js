@@ -3,6 +3,7 @@ class ChatApp {
     constructor(auth) {
         this.auth = auth;
         this.currentRoom = null;
+        this.currentRole = null;
         this.wsClient = null;
         this.rooms = [];
     }
@@ -64,7 +65,9 @@ class ChatApp {
 
         this.currentRoom = room;
         document.getElementById('current-room-name').textContent = `# ${room.name}`;
-        document.getElementById('message-field').disabled = false;
+        document.getElementById('room-stats').textContent = '';
+        this.currentRole = await this.loadRole(roomID);
+        this.applyPostPolicy(room.post_policy);
 
         document.querySelectorAll('.room-item').forEach(item => {
             item.classList.toggle('active', item.dataset.roomId === roomID.toString());
@@ -74,6 +77,25 @@ class ChatApp {
         this.connectWebSocket(roomID);
     }
 
+    // loadRole returns the user's role in the room (owner, admin or member)
+    async loadRole(roomID) {
+        const response = await fetch(`/v1/rooms/${roomID}/permissions`, {
+            headers: { 'Authorization': `Bearer ${this.auth.getToken()}` }
+        });
+        if (!response.ok) return null;
+        const permissions = await response.json();
+        return permissions.role;
+    }
+
+    // applyPostPolicy disables the input box in an announcement room for
+    // anyone but its owner and admins; the server refuses their messages anyway
+    applyPostPolicy(policy) {
+        const readOnly = policy === 'admins_only' && this.currentRole !== 'owner' && this.currentRole !== 'admin';
+        const field = document.getElementById('message-field');
+        field.disabled = readOnly;
+        field.placeholder = readOnly ? 'Only room admins can post here' : 'Type your message...';
+    }
+
     async loadMessages(roomID) {
         const response = await fetch(`/v1/rooms/${roomID}/messages`, {
             headers: { 'Authorization': `Bearer ${this.auth.getToken()}` }
@@ -91,6 +113,13 @@ class ChatApp {
         this.wsClient.connect();
     }
 
+    // answerInvite asks whether to join the room an invite is for and answers
+    // it over the current connection; the confirmation comes back as a frame
+    answerInvite(invite) {
+        const accept = confirm(`${invite.from} invited you to #${invite.room.name}. Join?`);
+        this.wsClient.send(JSON.stringify({ type: 'invite_response', invite_id: invite.invite_id, accept }));
+    }
+
     sendMessage(content) {
         if (this.wsClient && content.trim()) {
             this.wsClient.send(content);
@@ -98,12 +127,58 @@ class ChatApp {
     }
 
     displayMessage(msg) {
+        // Receipts and acks update state rather than adding chat lines
+        // Pin frames are for a pinned bar, which this client doesn't have yet
+        if (msg.type === 'delivered' || msg.type === 'filter_updated' || msg.type.startsWith('pin_')) {
+            return;
+        }
+        if (msg.type === 'message_redacted') {
+            // A platform admin removed a message: show the marker in its place
+            const redacted = document.querySelector(`#messages [data-message-id="${msg.message_id}"] .content`);
+            if (redacted) {
+                redacted.textContent = msg.content;
+            }
+            return;
+        }
+        if (msg.type === 'room_updated') {
+            if (msg.post_policy) {
+                this.currentRoom.post_policy = msg.post_policy;
+                this.applyPostPolicy(msg.post_policy);
+            }
+            return;
+        }
+        if (msg.type === 'invite') {
+            this.answerInvite(msg);
+            return;
+        }
+        if (msg.type === 'room_stats') {
+            document.getElementById('room-stats').textContent = `${msg.members} members, ${msg.online} online`;
+            return;
+        }
+
         const messagesDiv = document.getElementById('messages');
         const messageEl = document.createElement('div');
 
-        if (msg.type === 'join' || msg.type === 'leave') {
+        if (msg.type === 'join' || msg.type === 'leave' || msg.type.startsWith('join_') || msg.type === 'room_deleted' || msg.type === 'removed_from_room' || msg.type === 'left_room' || msg.type === 'member_added' || msg.type === 'invite_accepted' || msg.type === 'invite_declined') {
+            messageEl.className = 'message system';
+            messageEl.textContent = `* ${msg.content}`;
+        } else if (msg.type === 'error' || msg.type === 'notice') {
+            messageEl.className = 'message system';
+            messageEl.textContent = `! ${msg.content}`;
+        } else if (msg.system) {
+            // Saved messages the server posted itself, e.g. merge notices
             messageEl.className = 'message system';
             messageEl.textContent = `* ${msg.content}`;
+        } else if (msg.content_type === 'code') {
+            messageEl.className = 'message';
+            const time = msg.created_at ? new Date(msg.created_at).toLocaleTimeString() : new Date().toLocaleTimeString();
+            messageEl.innerHTML = `
+                <span class="timestamp">[${time}]</span>
+                <span class="username">${msg.username}:</span>
+                <pre class="content code"></pre>
+            `;
+            // textContent keeps code literal instead of parsing it as HTML
+            messageEl.querySelector('pre').textContent = msg.content;
         } else {
             messageEl.className = 'message';
             const time = msg.created_at ? new Date(msg.created_at).toLocaleTimeString() : new Date().toLocaleTimeString();
@@ -114,6 +189,13 @@ class ChatApp {
             `;
         }
 
+        if (msg.room_mention) {
+            // @here or @room that notified the room
+            messageEl.classList.add('room-mention');
+        }
+        if (msg.id) {
+            messageEl.dataset.messageId = msg.id;
+        }
         messagesDiv.appendChild(messageEl);
         messagesDiv.scrollTop = messagesDiv.scrollHeight;
     }
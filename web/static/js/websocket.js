@@ -7,6 +7,7 @@ class WebSocketClient {
         this.onMessage = null;
         this.reconnectAttempts = 0;
         this.maxReconnectAttempts = 5;
+        this.reconnectDelay = 3000;
     }
 
     connect() {
@@ -18,11 +19,19 @@ class WebSocketClient {
         this.ws.onopen = () => {
             console.log('WebSocket connected');
             this.reconnectAttempts = 0;
+            this.reconnectDelay = 3000;
         };
 
         this.ws.onmessage = (event) => {
             try {
                 const message = JSON.parse(event.data);
+                // The server is shutting down: reconnect (to another instance) when told to
+                // A little jitter keeps every client from reconnecting at the same moment
+                if (message.type === 'server_draining') {
+                    this.reconnectDelay = (message.reconnect_after || 3) * 1000 + Math.random() * 2000;
+                    this.ws.close();
+                    return;
+                }
                 if (this.onMessage) {
                     this.onMessage(message);
                 }
@@ -35,13 +44,19 @@ class WebSocketClient {
             console.error('WebSocket error:', error);
         };
 
-        this.ws.onclose = () => {
+        this.ws.onclose = (event) => {
             console.log('WebSocket disconnected');
+            // 4004: the room was deleted, 4003: we were removed from it,
+            // 4410: we left it (maybe in another tab)
+            // Either way there is nothing to reconnect to
+            if (event.code === 4004 || event.code === 4003 || event.code === 4410) {
+                return;
+            }
             if (this.reconnectAttempts < this.maxReconnectAttempts) {
                 setTimeout(() => {
                     this.reconnectAttempts++;
                     this.connect();
-                }, 3000);
+                }, this.reconnectDelay);
             }
         };
     }